@@ -255,6 +255,12 @@ export interface Env extends WebhookTriggerEnv, TaskRecoveryEnv {
   // Boot log configuration
   BOOT_LOG_TTL_SECONDS?: string;
   BOOT_LOG_MAX_ENTRIES?: string;
+  // Viewer access audit configuration
+  ACCESS_AUDIT_TTL_SECONDS?: string;
+  ACCESS_AUDIT_MAX_ENTRIES?: string;
+  // Privileged exec audit configuration
+  EXEC_AUDIT_TTL_SECONDS?: string;
+  EXEC_AUDIT_MAX_ENTRIES?: string;
   // Voice-to-text transcription (Workers AI)
   WHISPER_MODEL_ID?: string;
   MAX_AUDIO_SIZE_BYTES?: string;
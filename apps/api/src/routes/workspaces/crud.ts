@@ -80,6 +80,36 @@ crudRoutes.get('/:id', requireAuth(), requireApproved(), async (c) => {
   return c.json(response);
 });
 
+crudRoutes.get('/:id/access-audit', requireAuth(), requireApproved(), async (c) => {
+  const userId = getUserId(c);
+  const workspaceId = c.req.param('id');
+  const sessionId = c.req.query('sessionId');
+  const db = drizzle(c.env.DATABASE, { schema });
+
+  const workspace = await getOwnedWorkspace(db, workspaceId, userId);
+  const { getAccessAudit } = await import('../../services/access-audit');
+  const entries = (await getAccessAudit(c.env.KV, workspace.id))
+    .filter((e) => !sessionId || e.sessionId === sessionId)
+    .reverse();
+
+  return c.json({ entries });
+});
+
+crudRoutes.get('/:id/exec-audit', requireAuth(), requireApproved(), async (c) => {
+  const userId = getUserId(c);
+  const workspaceId = c.req.param('id');
+  const operation = c.req.query('operation');
+  const db = drizzle(c.env.DATABASE, { schema });
+
+  const workspace = await getOwnedWorkspace(db, workspaceId, userId);
+  const { getExecAudit } = await import('../../services/exec-audit');
+  const entries = (await getExecAudit(c.env.KV, workspace.id))
+    .filter((e) => !operation || e.operation === operation)
+    .reverse();
+
+  return c.json({ entries });
+});
+
 crudRoutes.get('/:id/port-access', requireAuth(), requireApproved(), async (c) => {
   const userId = getUserId(c);
   const workspaceId = c.req.param('id');
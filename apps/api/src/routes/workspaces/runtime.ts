@@ -25,16 +25,20 @@ import { ulid } from '../../lib/ulid';
 import { requireApproved, requireAuth } from '../../middleware/auth';
 import { errors } from '../../middleware/error';
 import {
+  AccessAuditBatchSchema,
   AgentCredentialSyncSchema,
   AgentTypeBodySchema,
   BootLogEntrySchema,
+  ExecAuditBatchSchema,
   formatIssues,
   jsonValidator,
   MessageBatchSchema,
 } from '../../schemas';
+import { appendAccessAudit } from '../../services/access-audit';
 import { appendBootLog } from '../../services/boot-log';
 import { syncActiveAgentCredentialSecret } from '../../services/composable-credentials/agent-sync';
 import { decrypt, encrypt } from '../../services/encryption';
+import { appendExecAudit } from '../../services/exec-audit';
 import { getInstallationToken, getUserInstallationRepositories } from '../../services/github-app';
 import {
   GitHubCliPolicyError,
@@ -168,7 +172,17 @@ async function readRequestBodyWithLimit(request: Request, maxBytes: number): Pro
     return '';
   }
 
-  const reader = request.body.getReader();
+  // The VM agent gzips large message batches. The limit applies to the
+  // decompressed bytes so compression cannot be used to bypass it.
+  const encoding = request.headers.get('content-encoding')?.trim().toLowerCase();
+  let stream: ReadableStream<Uint8Array> = request.body;
+  if (encoding === 'gzip') {
+    stream = request.body.pipeThrough(new DecompressionStream('gzip'));
+  } else if (encoding && encoding !== 'identity') {
+    throw errors.badRequest(`Unsupported Content-Encoding: ${encoding}`);
+  }
+
+  const reader = stream.getReader();
   const chunks: Uint8Array[] = [];
   let totalBytes = 0;
   let done = false;
@@ -1494,6 +1508,51 @@ runtimeRoutes.post('/:id/boot-log', jsonValidator(BootLogEntrySchema), async (c)
   return c.json({ success: true });
 });
 
+const MAX_ACCESS_AUDIT_BATCH_SIZE = 500;
+
+/**
+ * POST /:id/access-audit — VM agent viewer access records (who attached to an
+ * agent session, from where, and whether they drove it). Idempotent by record id.
+ */
+runtimeRoutes.post('/:id/access-audit', jsonValidator(AccessAuditBatchSchema), async (c) => {
+  const workspaceId = c.req.param('id');
+  await verifyWorkspaceCallbackAuth(c, workspaceId);
+
+  const { entries } = c.req.valid('json');
+  if (entries.length === 0 || entries.length > MAX_ACCESS_AUDIT_BATCH_SIZE) {
+    throw errors.badRequest(`entries must contain 1-${MAX_ACCESS_AUDIT_BATCH_SIZE} records`);
+  }
+  if (entries.some((e) => e.workspaceId !== workspaceId)) {
+    throw errors.badRequest('entries must belong to the workspace in the path');
+  }
+
+  const accepted = await appendAccessAudit(c.env.KV, workspaceId, entries, c.env);
+  return c.json({ success: true, accepted });
+});
+
+const MAX_EXEC_AUDIT_BATCH_SIZE = 500;
+
+/**
+ * POST /:id/exec-audit — VM agent records of privileged `docker exec -u root`
+ * operations (what was changed in the container, by which agent component, and
+ * the result). Idempotent by record id.
+ */
+runtimeRoutes.post('/:id/exec-audit', jsonValidator(ExecAuditBatchSchema), async (c) => {
+  const workspaceId = c.req.param('id');
+  await verifyWorkspaceCallbackAuth(c, workspaceId);
+
+  const { entries } = c.req.valid('json');
+  if (entries.length === 0 || entries.length > MAX_EXEC_AUDIT_BATCH_SIZE) {
+    throw errors.badRequest(`entries must contain 1-${MAX_EXEC_AUDIT_BATCH_SIZE} records`);
+  }
+  if (entries.some((e) => e.workspaceId !== workspaceId)) {
+    throw errors.badRequest('entries must belong to the workspace in the path');
+  }
+
+  const accepted = await appendExecAudit(c.env.KV, workspaceId, entries, c.env);
+  return c.json({ success: true, accepted });
+});
+
 /**
  * POST /:id/messages — VM agent batch message persistence.
  * Uses workspace callback auth. Accepts 1-100 messages per batch.
@@ -41,10 +41,14 @@ export { CreateNodeSchema, PatchNodeSchema, UpdateNodeLabelSchema } from './node
 export {
   AgentCredentialSyncSchema,
   AgentTypeBodySchema,
+  AccessAuditBatchSchema,
+  AccessAuditEntrySchema,
   BootLogEntrySchema,
   CreateAgentSessionSchema,
   CreateWorkspaceSchema,
   CredentialInjectionSchema,
+  ExecAuditBatchSchema,
+  ExecAuditEntrySchema,
   MessageBatchSchema,
   UpdateAgentSessionSchema,
   UpdateWorkspacePortsPublicSchema,
@@ -53,6 +53,45 @@ export const BootLogEntrySchema = v.object({
   timestamp: v.string(),
 });
 
+export const AccessAuditEntrySchema = v.object({
+  id: v.string(),
+  workspaceId: v.string(),
+  sessionId: v.string(),
+  viewerId: v.string(),
+  subject: v.optional(v.string()),
+  ip: v.optional(v.string()),
+  remoteAddr: v.optional(v.string()),
+  userAgent: v.optional(v.string()),
+  role: v.picklist(['watcher', 'driver']),
+  prompts: v.number(),
+  attachedAt: v.string(),
+  detachedAt: v.optional(v.string()),
+  durationMs: v.optional(v.number()),
+  endReason: v.optional(v.string()),
+});
+
+export const AccessAuditBatchSchema = v.object({
+  entries: v.array(AccessAuditEntrySchema),
+});
+
+export const ExecAuditEntrySchema = v.object({
+  id: v.string(),
+  workspaceId: v.string(),
+  containerId: v.string(),
+  operation: v.string(),
+  target: v.optional(v.string()),
+  initiator: v.string(),
+  result: v.picklist(['succeeded', 'failed']),
+  exitCode: v.number(),
+  error: v.optional(v.string()),
+  startedAt: v.string(),
+  durationMs: v.number(),
+});
+
+export const ExecAuditBatchSchema = v.object({
+  entries: v.array(ExecAuditEntrySchema),
+});
+
 export const AgentCredentialSyncSchema = v.object({
   credential: v.string(),
   credentialKind: v.optional(CredentialKindSchema),
@@ -0,0 +1,71 @@
+const ACCESS_AUDIT_PREFIX = 'accessaudit:';
+const DEFAULT_ACCESS_AUDIT_TTL = 90 * 24 * 60 * 60; // 90 days
+const DEFAULT_ACCESS_AUDIT_MAX_ENTRIES = 1000;
+
+/** A viewer attachment to an agent session, as shipped by the VM agent. */
+export interface AccessAuditEntry {
+  id: string;
+  workspaceId: string;
+  sessionId: string;
+  viewerId: string;
+  subject?: string;
+  ip?: string;
+  remoteAddr?: string;
+  userAgent?: string;
+  role: 'watcher' | 'driver';
+  prompts: number;
+  attachedAt: string;
+  detachedAt?: string;
+  durationMs?: number;
+  endReason?: string;
+}
+
+type AccessAuditEnv = { ACCESS_AUDIT_TTL_SECONDS?: string; ACCESS_AUDIT_MAX_ENTRIES?: string };
+
+function getAccessAuditTTL(env?: AccessAuditEnv): number {
+  if (env?.ACCESS_AUDIT_TTL_SECONDS) {
+    const ttl = parseInt(env.ACCESS_AUDIT_TTL_SECONDS, 10);
+    if (!isNaN(ttl) && ttl > 0) return ttl;
+  }
+  return DEFAULT_ACCESS_AUDIT_TTL;
+}
+
+function getAccessAuditMaxEntries(env?: AccessAuditEnv): number {
+  if (env?.ACCESS_AUDIT_MAX_ENTRIES) {
+    const max = parseInt(env.ACCESS_AUDIT_MAX_ENTRIES, 10);
+    if (!isNaN(max) && max > 0) return max;
+  }
+  return DEFAULT_ACCESS_AUDIT_MAX_ENTRIES;
+}
+
+export async function getAccessAudit(kv: KVNamespace, workspaceId: string): Promise<AccessAuditEntry[]> {
+  const data = await kv.get<AccessAuditEntry[]>(`${ACCESS_AUDIT_PREFIX}${workspaceId}`, { type: 'json' });
+  return data || [];
+}
+
+/**
+ * Appends shipped access records for a workspace. Records already stored
+ * (same id) are ignored so agent retries are idempotent; the oldest records
+ * are dropped beyond ACCESS_AUDIT_MAX_ENTRIES.
+ */
+export async function appendAccessAudit(
+  kv: KVNamespace,
+  workspaceId: string,
+  entries: AccessAuditEntry[],
+  env?: AccessAuditEnv
+): Promise<number> {
+  const existing = await getAccessAudit(kv, workspaceId);
+  const seen = new Set(existing.map((e) => e.id));
+  const added = entries.filter((e) => !seen.has(e.id));
+  if (added.length === 0) return 0;
+
+  const merged = [...existing, ...added].sort((a, b) => a.attachedAt.localeCompare(b.attachedAt));
+  const maxEntries = getAccessAuditMaxEntries(env);
+  const trimmed = merged.length > maxEntries ? merged.slice(-maxEntries) : merged;
+  await kv.put(
+    `${ACCESS_AUDIT_PREFIX}${workspaceId}`,
+    JSON.stringify(trimmed),
+    { expirationTtl: getAccessAuditTTL(env) }
+  );
+  return added.length;
+}
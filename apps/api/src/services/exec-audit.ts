@@ -0,0 +1,68 @@
+const EXEC_AUDIT_PREFIX = 'execaudit:';
+const DEFAULT_EXEC_AUDIT_TTL = 90 * 24 * 60 * 60; // 90 days
+const DEFAULT_EXEC_AUDIT_MAX_ENTRIES = 1000;
+
+/** A privileged `docker exec -u root` operation, as shipped by the VM agent. */
+export interface ExecAuditEntry {
+  id: string;
+  workspaceId: string;
+  containerId: string;
+  operation: string;
+  target?: string;
+  initiator: string;
+  result: 'succeeded' | 'failed';
+  exitCode: number;
+  error?: string;
+  startedAt: string;
+  durationMs: number;
+}
+
+type ExecAuditEnv = { EXEC_AUDIT_TTL_SECONDS?: string; EXEC_AUDIT_MAX_ENTRIES?: string };
+
+function getExecAuditTTL(env?: ExecAuditEnv): number {
+  if (env?.EXEC_AUDIT_TTL_SECONDS) {
+    const ttl = parseInt(env.EXEC_AUDIT_TTL_SECONDS, 10);
+    if (!isNaN(ttl) && ttl > 0) return ttl;
+  }
+  return DEFAULT_EXEC_AUDIT_TTL;
+}
+
+function getExecAuditMaxEntries(env?: ExecAuditEnv): number {
+  if (env?.EXEC_AUDIT_MAX_ENTRIES) {
+    const max = parseInt(env.EXEC_AUDIT_MAX_ENTRIES, 10);
+    if (!isNaN(max) && max > 0) return max;
+  }
+  return DEFAULT_EXEC_AUDIT_MAX_ENTRIES;
+}
+
+export async function getExecAudit(kv: KVNamespace, workspaceId: string): Promise<ExecAuditEntry[]> {
+  const data = await kv.get<ExecAuditEntry[]>(`${EXEC_AUDIT_PREFIX}${workspaceId}`, { type: 'json' });
+  return data || [];
+}
+
+/**
+ * Appends shipped exec records for a workspace. Records already stored
+ * (same id) are ignored so agent retries are idempotent; the oldest records
+ * are dropped beyond EXEC_AUDIT_MAX_ENTRIES.
+ */
+export async function appendExecAudit(
+  kv: KVNamespace,
+  workspaceId: string,
+  entries: ExecAuditEntry[],
+  env?: ExecAuditEnv
+): Promise<number> {
+  const existing = await getExecAudit(kv, workspaceId);
+  const seen = new Set(existing.map((e) => e.id));
+  const added = entries.filter((e) => !seen.has(e.id));
+  if (added.length === 0) return 0;
+
+  const merged = [...existing, ...added].sort((a, b) => a.startedAt.localeCompare(b.startedAt));
+  const maxEntries = getExecAuditMaxEntries(env);
+  const trimmed = merged.length > maxEntries ? merged.slice(-maxEntries) : merged;
+  await kv.put(
+    `${EXEC_AUDIT_PREFIX}${workspaceId}`,
+    JSON.stringify(trimmed),
+    { expirationTtl: getExecAuditTTL(env) }
+  );
+  return added.length;
+}
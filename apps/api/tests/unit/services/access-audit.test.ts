@@ -0,0 +1,60 @@
+import { describe, expect, it, vi } from 'vitest';
+
+import { type AccessAuditEntry, appendAccessAudit, getAccessAudit } from '../../../src/services/access-audit';
+
+function createMockKV(): KVNamespace {
+  const store = new Map<string, string>();
+  return {
+    get: vi.fn(async (key: string) => {
+      const val = store.get(key);
+      return val ? JSON.parse(val) : null;
+    }),
+    put: vi.fn(async (key: string, value: string) => {
+      store.set(key, value);
+    }),
+    delete: vi.fn(),
+    list: vi.fn(),
+    getWithMetadata: vi.fn(),
+  } as unknown as KVNamespace;
+}
+
+function makeEntry(id: string, attachedAt: string): AccessAuditEntry {
+  return {
+    id,
+    workspaceId: 'ws-1',
+    sessionId: 'sess-1',
+    viewerId: `viewer-${id}`,
+    subject: 'user-1',
+    ip: '203.0.113.7',
+    role: 'watcher',
+    prompts: 0,
+    attachedAt,
+    detachedAt: attachedAt,
+    endReason: 'disconnected',
+  };
+}
+
+describe('access-audit service', () => {
+  it('appendAccessAudit ignores records that were already shipped', async () => {
+    const kv = createMockKV();
+    const first = makeEntry('a', '2026-03-01T10:00:00Z');
+    expect(await appendAccessAudit(kv, 'ws-1', [first])).toBe(1);
+    expect(await appendAccessAudit(kv, 'ws-1', [first, makeEntry('b', '2026-03-01T11:00:00Z')])).toBe(1);
+
+    const entries = await getAccessAudit(kv, 'ws-1');
+    expect(entries.map((e) => e.id)).toEqual(['a', 'b']);
+  });
+
+  it('appendAccessAudit keeps the newest ACCESS_AUDIT_MAX_ENTRIES records', async () => {
+    const kv = createMockKV();
+    await appendAccessAudit(
+      kv,
+      'ws-1',
+      [makeEntry('c', '2026-03-01T12:00:00Z'), makeEntry('a', '2026-03-01T10:00:00Z'), makeEntry('b', '2026-03-01T11:00:00Z')],
+      { ACCESS_AUDIT_MAX_ENTRIES: '2' }
+    );
+
+    const entries = await getAccessAudit(kv, 'ws-1');
+    expect(entries.map((e) => e.id)).toEqual(['b', 'c']);
+  });
+});
@@ -0,0 +1,59 @@
+import { describe, expect, it, vi } from 'vitest';
+
+import { appendExecAudit, type ExecAuditEntry, getExecAudit } from '../../../src/services/exec-audit';
+
+function createMockKV(): KVNamespace {
+  const store = new Map<string, string>();
+  return {
+    get: vi.fn(async (key: string) => {
+      const val = store.get(key);
+      return val ? JSON.parse(val) : null;
+    }),
+    put: vi.fn(async (key: string, value: string) => {
+      store.set(key, value);
+    }),
+    delete: vi.fn(),
+    list: vi.fn(),
+    getWithMetadata: vi.fn(),
+  } as unknown as KVNamespace;
+}
+
+function makeEntry(id: string, startedAt: string): ExecAuditEntry {
+  return {
+    id,
+    workspaceId: 'ws-1',
+    containerId: 'ctr-1',
+    operation: 'workspace.chown',
+    target: '/workspaces 1000:1000',
+    initiator: 'bootstrap',
+    result: 'succeeded',
+    exitCode: 0,
+    startedAt,
+    durationMs: 12,
+  };
+}
+
+describe('exec-audit service', () => {
+  it('appendExecAudit ignores records that were already shipped', async () => {
+    const kv = createMockKV();
+    const first = makeEntry('a', '2026-03-01T10:00:00Z');
+    expect(await appendExecAudit(kv, 'ws-1', [first])).toBe(1);
+    expect(await appendExecAudit(kv, 'ws-1', [first, makeEntry('b', '2026-03-01T11:00:00Z')])).toBe(1);
+
+    const entries = await getExecAudit(kv, 'ws-1');
+    expect(entries.map((e) => e.id)).toEqual(['a', 'b']);
+  });
+
+  it('appendExecAudit keeps the newest EXEC_AUDIT_MAX_ENTRIES records', async () => {
+    const kv = createMockKV();
+    await appendExecAudit(
+      kv,
+      'ws-1',
+      [makeEntry('c', '2026-03-01T12:00:00Z'), makeEntry('a', '2026-03-01T10:00:00Z'), makeEntry('b', '2026-03-01T11:00:00Z')],
+      { EXEC_AUDIT_MAX_ENTRIES: '2' }
+    );
+
+    const entries = await getExecAudit(kv, 'ws-1');
+    expect(entries.map((e) => e.id)).toEqual(['b', 'c']);
+  });
+});
@@ -125,6 +125,26 @@ describe('POST /workspaces/:id/messages — behavioral tests', () => {
       expect(body.persisted).toBeGreaterThanOrEqual(0);
     });
 
+    it('accepts gzip-compressed message batches', async () => {
+      const json = new Blob([JSON.stringify({ messages: [makeMessage()] })]);
+      const compressed = await new Response(
+        json.stream().pipeThrough(new CompressionStream('gzip'))
+      ).arrayBuffer();
+      const response = await SELF.fetch(
+        `https://api.test.example.com/api/workspaces/${WORKSPACE_ID}/messages`,
+        {
+          method: 'POST',
+          headers: {
+            Authorization: `Bearer ${validToken}`,
+            'Content-Type': 'application/json',
+            'Content-Encoding': 'gzip',
+          },
+          body: compressed,
+        }
+      );
+      expect(response.status).toBe(200);
+    });
+
     it('returns 409 when workspace has no linked chatSessionId (transient window)', async () => {
       const response = await postMessages(WORKSPACE_NO_SESSION, [makeMessage()], noSessionToken);
       // 409 Conflict — VM agent will retry (not 400 which would discard the batch)
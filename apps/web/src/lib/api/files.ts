@@ -1,6 +1,8 @@
 import type {
   CreateWorktreeRequest,
   GitBranchListResponse,
+  GitCheckoutRequest,
+  GitCheckoutResponse,
   RemoveWorktreeResponse,
   WorktreeInfo,
   WorktreeListResponse,
@@ -32,6 +34,11 @@ export interface GitStatusData {
   staged: GitFileStatus[];
   unstaged: GitFileStatus[];
   untracked: GitFileStatus[];
+  /** Checked-out branch; absent when HEAD is detached. */
+  branch?: string;
+  upstream?: string;
+  ahead?: number;
+  behind?: number;
 }
 
 function parseGitFileStatus(value: unknown, context: string): GitFileStatus {
@@ -48,6 +55,10 @@ function parseGitStatusData(record: Record<string, unknown>): GitStatusData {
     staged: requireArray(record, 'staged', 'git.status').map((value, index) => parseGitFileStatus(value, `git.status.staged[${index}]`)),
     unstaged: requireArray(record, 'unstaged', 'git.status').map((value, index) => parseGitFileStatus(value, `git.status.unstaged[${index}]`)),
     untracked: requireArray(record, 'untracked', 'git.status').map((value, index) => parseGitFileStatus(value, `git.status.untracked[${index}]`)),
+    ...(optionalString(record, 'branch') ? { branch: optionalString(record, 'branch') } : {}),
+    ...(optionalString(record, 'upstream') ? { upstream: optionalString(record, 'upstream') } : {}),
+    ...(typeof record.ahead === 'number' ? { ahead: record.ahead } : {}),
+    ...(typeof record.behind === 'number' ? { behind: record.behind } : {}),
   };
 }
 
@@ -90,8 +101,21 @@ function parseGitBranchListResponse(record: Record<string, unknown>): GitBranchL
   return {
     branches: requireArray(record, 'branches', 'git.branches').map((value, index) => {
       const branch = expectJsonRecord(value, `git.branches.branches[${index}]`);
-      return { name: requireString(branch, 'name', `git.branches.branches[${index}]`) };
+      return {
+        name: requireString(branch, 'name', `git.branches.branches[${index}]`),
+        ...(branch.local === true ? { local: true } : {}),
+      };
     }),
+    ...(optionalString(record, 'current') ? { current: optionalString(record, 'current') } : {}),
+  };
+}
+
+function parseGitCheckoutResponse(record: Record<string, unknown>): GitCheckoutResponse {
+  return {
+    branch: requireString(record, 'branch', 'git.checkout'),
+    headCommit: requireString(record, 'headCommit', 'git.checkout'),
+    created: requireBoolean(record, 'created', 'git.checkout'),
+    ...(optionalString(record, 'previousBranch') ? { previousBranch: optionalString(record, 'previousBranch') } : {}),
   };
 }
 
@@ -291,6 +315,34 @@ export async function getGitBranches(
   return readResponseJson(res, 'git.branches', parseGitBranchListResponse);
 }
 
+/**
+ * Switch the workspace repository (or the given worktree) to another branch,
+ * optionally creating it. Fails with 409 when the working tree has
+ * uncommitted changes unless `allowDirty` is set.
+ */
+export async function checkoutGitBranch(
+  workspaceUrl: string,
+  workspaceId: string,
+  token: string,
+  checkoutRequest: GitCheckoutRequest,
+  worktree?: string
+): Promise<GitCheckoutResponse> {
+  const params = new URLSearchParams({ token });
+  if (worktree) params.set('worktree', worktree);
+  const url = `${workspaceUrl}/workspaces/${encodeURIComponent(workspaceId)}/git/checkout?${params.toString()}`;
+  const res = await fetch(url, {
+    method: 'POST',
+    credentials: 'include',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify(checkoutRequest),
+  });
+  if (!res.ok) {
+    const text = await res.text();
+    throw new Error(`Git checkout failed: ${text}`);
+  }
+  return readResponseJson(res, 'git.checkout', parseGitCheckoutResponse);
+}
+
 export async function createWorktree(
   workspaceUrl: string,
   workspaceId: string,
@@ -240,6 +240,7 @@ export type {
   GitStatusData,
 } from './files';
 export {
+  checkoutGitBranch,
   createWorktree,
   downloadSessionFile,
   getFileIndex,
@@ -3,7 +3,8 @@ import { useCallback, useEffect, useRef, useState } from 'react';
 import type { AcpErrorCode } from '../errors';
 import { errorCodeFromCloseCode, errorCodeFromMessage, getErrorMeta } from '../errors';
 import { maybeJsonRecord } from '../runtime-validation';
-import type { AgentStatusMessage, LifecycleEventCallback, SessionStateMessage } from '../transport/types';
+import { createAcpLongPollTransport } from '../transport/longpoll';
+import type { AgentStatusMessage, ControlPlaneStatusMessage, LifecycleEventCallback, SessionStateMessage } from '../transport/types';
 import type { AcpTransport } from '../transport/websocket';
 import { createAcpWebSocketTransport } from '../transport/websocket';
 import {
@@ -84,6 +85,12 @@ export interface UseAcpSessionOptions {
   reconnectTimeoutMs?: number;
   /** Maximum delay cap for exponential backoff in ms (default: 16000) */
   reconnectMaxDelayMs?: number;
+  /**
+   * Fall back to HTTP long-polling when the WebSocket upgrade fails, e.g. on
+   * networks whose proxies block WebSockets (default: true). Once a fallback
+   * happens, reconnects keep using long-poll for the life of the hook.
+   */
+  longPollFallback?: boolean;
 }
 
 /** Return type of the useAcpSession hook */
@@ -98,6 +105,11 @@ export interface AcpSessionHandle {
   errorCode: AcpErrorCode | null;
   /** Whether the session is replaying buffered messages from a late join */
   replaying: boolean;
+  /**
+   * Whether the VM Agent reports the control plane as unreachable. The agent
+   * keeps running on cached credentials; show an "operating offline" notice.
+   */
+  operatingOffline: boolean;
   /** Switch to a different agent */
   switchAgent: (agentType: string) => void;
   /** Send an ACP JSON-RPC message to the agent */
@@ -112,7 +124,8 @@ export interface AcpSessionHandle {
  * React hook for managing an ACP session with the VM Agent gateway.
  *
  * Handles:
- * - WebSocket connection to /agent/ws
+ * - WebSocket connection to /agent/ws, falling back to HTTP long-poll
+ *   (/agent/poll) when the upgrade is blocked
  * - Agent selection via select_agent control messages
  * - Agent status tracking (starting -> ready -> prompting -> etc.)
  * - Reconnection with exponential backoff on unexpected disconnect
@@ -128,6 +141,7 @@ export function useAcpSession(options: UseAcpSessionOptions): AcpSessionHandle {
     reconnectDelayMs = DEFAULT_RECONNECT_DELAY_MS,
     reconnectTimeoutMs = DEFAULT_RECONNECT_TIMEOUT_MS,
     reconnectMaxDelayMs = DEFAULT_RECONNECT_MAX_DELAY_MS,
+    longPollFallback = true,
   } = options;
 
   const [state, setState] = useState<AcpSessionState>('disconnected');
@@ -135,6 +149,7 @@ export function useAcpSession(options: UseAcpSessionOptions): AcpSessionHandle {
   const [error, setError] = useState<string | null>(null);
   const [errorCode, setErrorCode] = useState<AcpErrorCode | null>(null);
   const [replaying, setReplaying] = useState(false);
+  const [operatingOffline, setOperatingOffline] = useState(false);
 
   /** Set both error message and structured code together. Uses error metadata for the message when not provided. */
   const setStructuredError = useCallback((code: AcpErrorCode, message?: string) => {
@@ -198,6 +213,8 @@ export function useAcpSession(options: UseAcpSessionOptions): AcpSessionHandle {
   const attemptReconnectRef = useRef<() => void>(() => {});
   // Last close-code-derived error code — used to enrich RECONNECT_TIMEOUT
   const lastCloseErrorCodeRef = useRef<AcpErrorCode | null>(null);
+  // Set once a WebSocket upgrade fails; later connects use long-poll directly.
+  const longPollRef = useRef(false);
 
   // Lifecycle logging helper
   const logLifecycle = useCallback((
@@ -373,7 +390,17 @@ export function useAcpSession(options: UseAcpSessionOptions): AcpSessionHandle {
     }
   }, [logLifecycle]);
 
-  // Connect to the ACP WebSocket
+  // Handle control plane reachability changes from the VM Agent
+  const handleControlPlaneStatus = useCallback((msg: ControlPlaneStatusMessage) => {
+    logLifecycle(msg.offline ? 'warn' : 'info', `Control plane ${msg.offline ? 'unreachable — operating offline' : 'reachable'}`, {
+      since: msg.since,
+      reason: msg.reason,
+    });
+    setOperatingOffline(msg.offline);
+  }, [logLifecycle]);
+
+  // Connect to the ACP gateway over WebSocket, or over HTTP long-poll once a
+  // WebSocket upgrade has failed on this network.
   const connect = useCallback((url: string) => {
     // Close any stale transport before opening a new connection.
     // This prevents duplicate connections when a reconnect fires while a
@@ -386,9 +413,8 @@ export function useAcpSession(options: UseAcpSessionOptions): AcpSessionHandle {
     }
 
     const host = safeHost(url);
-    const ws = new WebSocket(url);
 
-    ws.addEventListener('open', () => {
+    const handleOpen = (transportKind: 'websocket' | 'long-poll') => {
       // Reset reconnection state on successful connect
       const wasReconnect = wasConnectedRef.current;
       reconnectAttemptRef.current = 0;
@@ -399,85 +425,139 @@ export function useAcpSession(options: UseAcpSessionOptions): AcpSessionHandle {
       replayCompletedRef.current = false;
       // Reset the first-connect flag so onFirstConnect can fire again
       hasCalledFirstConnectRef.current = false;
+      // The agent re-sends control_plane_status after attach while offline.
+      setOperatingOffline(false);
       // Stay in 'connecting' until we receive session_state from the server.
       // The server will send session_state immediately after the viewer
       // attaches, telling us whether an agent is already running.
       setState('connecting');
       clearError();
 
-      logLifecycle('info', 'WebSocket connected, awaiting session_state', { host, wasReconnect });
-    });
+      logLifecycle('info', 'Connected, awaiting session_state', { host, wasReconnect, transport: transportKind });
+    };
 
-    const transport = createAcpWebSocketTransport({
-      ws,
+    const handleClose = (code?: number, reason?: string) => {
+      // Connection closed — attempt reconnection if not intentional
+      transportRef.current = null;
+
+      const strategy = classifyCloseCode(code);
+
+      logLifecycle('info', 'WebSocket closed', {
+        host,
+        code,
+        reason,
+        strategy,
+        intentional: intentionalCloseRef.current,
+        wasConnected: wasConnectedRef.current,
+        longPoll: longPollRef.current,
+      });
+
+      if (intentionalCloseRef.current) {
+        setState('disconnected');
+        return;
+      }
+
+      // Server explicitly rejected us (auth failure, policy) — don't reconnect
+      if (strategy === 'no-reconnect') {
+        const errCode = errorCodeFromCloseCode(code);
+        logLifecycle('warn', 'Server closed connection cleanly — not reconnecting', { code, reason, errorCode: errCode });
+        setState('error');
+        setStructuredError(errCode);
+        return;
+      }
+
+      // Only reconnect if we were previously connected
+      if (wasConnectedRef.current) {
+        // Stash the close-code-derived error code so the UI can show it
+        // during reconnection if it eventually times out.
+        lastCloseErrorCodeRef.current = errorCodeFromCloseCode(code);
+        attemptReconnectRef.current();
+      } else {
+        logLifecycle('error', 'WebSocket connection failed (never connected)', { host, code, reason });
+        setState('error');
+        setStructuredError('CONNECTION_FAILED');
+      }
+    };
+
+    const handleError = () => {
+      logLifecycle('warn', 'WebSocket error event', {
+        host,
+        wasConnected: wasConnectedRef.current,
+        intentionalClose: intentionalCloseRef.current,
+      });
+
+      if (!intentionalCloseRef.current && wasConnectedRef.current) {
+        // Will be followed by close event which handles reconnection
+        return;
+      }
+      setState('error');
+      setStructuredError('CONNECTION_FAILED');
+    };
+
+    const callbacks = {
       onAgentStatus: handleAgentStatus,
       onAcpMessage: handleAcpMessage,
       onAgentCrashReport: handleAcpMessage,
       onSessionState: handleSessionState,
       onSessionReplayComplete: handleSessionReplayComplete,
       onSessionPrompting: handleSessionPrompting,
-      onClose(code?: number, reason?: string) {
-        // WebSocket closed — attempt reconnection if not intentional
-        transportRef.current = null;
-
-        const strategy = classifyCloseCode(code);
-
-        logLifecycle('info', 'WebSocket closed', {
-          host,
-          code,
-          reason,
-          strategy,
-          intentional: intentionalCloseRef.current,
-          wasConnected: wasConnectedRef.current,
-        });
+      onControlPlaneStatus: handleControlPlaneStatus,
+      onLifecycleEvent: onLifecycleEventRef.current,
+    };
 
-        if (intentionalCloseRef.current) {
-          setState('disconnected');
-          return;
-        }
+    const startLongPoll = () => {
+      const transport = createAcpLongPollTransport({
+        ...callbacks,
+        url,
+        onOpen: () => handleOpen('long-poll'),
+        onClose: handleClose,
+        onError: handleError,
+      });
+      transportRef.current = transport;
+      return transport;
+    };
 
-        // Server explicitly rejected us (auth failure, policy) — don't reconnect
-        if (strategy === 'no-reconnect') {
-          const errCode = errorCodeFromCloseCode(code);
-          logLifecycle('warn', 'Server closed connection cleanly — not reconnecting', { code, reason, errorCode: errCode });
-          setState('error');
-          setStructuredError(errCode);
-          return;
-        }
+    if (longPollRef.current) {
+      return startLongPoll();
+    }
 
-        // Only reconnect if we were previously connected
-        if (wasConnectedRef.current) {
-          // Stash the close-code-derived error code so the UI can show it
-          // during reconnection if it eventually times out.
-          lastCloseErrorCodeRef.current = errorCodeFromCloseCode(code);
-          attemptReconnectRef.current();
-        } else {
-          logLifecycle('error', 'WebSocket connection failed (never connected)', { host, code, reason });
-          setState('error');
-          setStructuredError('CONNECTION_FAILED');
+    const ws = new WebSocket(url);
+    let opened = false;
+
+    // Fall back to long-poll only when this socket never opened: some
+    // corporate proxies block the upgrade outright. Drops after a successful
+    // open are ordinary disconnects and reconnect over WebSocket.
+    const shouldFallBack = () => longPollFallback && !opened && !intentionalCloseRef.current;
+
+    ws.addEventListener('open', () => {
+      opened = true;
+      handleOpen('websocket');
+    });
+
+    const transport = createAcpWebSocketTransport({
+      ...callbacks,
+      ws,
+      onClose(code?: number, reason?: string) {
+        if (shouldFallBack() && transportRef.current === transport) {
+          logLifecycle('warn', 'WebSocket upgrade failed — falling back to HTTP long-poll', { host, code, reason });
+          longPollRef.current = true;
+          startLongPoll();
+          return;
         }
+        handleClose(code, reason);
       },
       onError() {
-        // WebSocket error
-        logLifecycle('warn', 'WebSocket error event', {
-          host,
-          wasConnected: wasConnectedRef.current,
-          intentionalClose: intentionalCloseRef.current,
-        });
-
-        if (!intentionalCloseRef.current && wasConnectedRef.current) {
-          // Will be followed by close event which handles reconnection
+        if (shouldFallBack()) {
+          // The close event that follows switches to long-poll.
           return;
         }
-        setState('error');
-        setStructuredError('CONNECTION_FAILED');
+        handleError();
       },
-      onLifecycleEvent: onLifecycleEventRef.current,
     });
 
     transportRef.current = transport;
     return transport;
-  }, [handleAgentStatus, handleAcpMessage, handleSessionState, handleSessionReplayComplete, handleSessionPrompting, logLifecycle, clearError, setStructuredError]); // eslint-disable-line react-hooks/exhaustive-deps
+  }, [handleAgentStatus, handleAcpMessage, handleSessionState, handleSessionReplayComplete, handleSessionPrompting, handleControlPlaneStatus, logLifecycle, clearError, setStructuredError, longPollFallback]); // eslint-disable-line react-hooks/exhaustive-deps
 
   const resolveConnectUrl = useCallback((fallbackUrl?: string | null) => {
     if (resolveWsUrlRef.current) {
@@ -751,6 +831,7 @@ export function useAcpSession(options: UseAcpSessionOptions): AcpSessionHandle {
     error,
     errorCode,
     replaying,
+    operatingOffline,
     switchAgent,
     sendMessage,
     connected: transportRef.current?.connected ?? false,
@@ -5,6 +5,7 @@ export * from './types';
 export * from './errors';
 
 // Transport
+export * from './transport/longpoll';
 export * from './transport/types';
 export * from './transport/websocket';
 
@@ -0,0 +1,251 @@
+import type { AcpTransport, AcpTransportCallbacks } from './websocket';
+import { dispatchTransportMessage } from './websocket';
+
+/** Default time the VM Agent may hold a poll open (ms). The server caps it via ACP_POLL_WAIT. */
+const DEFAULT_POLL_WAIT_MS = 25_000;
+
+/** Options for creating the ACP long-poll transport. */
+export interface AcpLongPollTransportOptions extends AcpTransportCallbacks {
+  /**
+   * The /agent/ws URL whose WebSocket upgrade failed. Poll URLs are derived
+   * from it, keeping its query (token, sessionId, worktree).
+   */
+  url: string;
+  /** Callback once the viewer is attached — the long-poll equivalent of the WebSocket open event. */
+  onOpen?: () => void;
+  /** Max time in ms the server may hold each poll open (default: 25000). */
+  pollWaitMs?: number;
+  /** fetch implementation (default: globalThis.fetch). */
+  fetchImpl?: typeof fetch;
+}
+
+interface PollBatch {
+  messages: Array<{ seq: number; data: unknown }>;
+  cursor: number;
+  closed: boolean;
+  reason?: string;
+}
+
+/**
+ * Derive the long-poll attach URL (http(s)://host/agent/poll?query) from an
+ * /agent/ws WebSocket URL.
+ */
+export function longPollUrlFromWebSocketUrl(wsUrl: string): URL {
+  const url = new URL(wsUrl);
+  if (url.protocol === 'wss:') {
+    url.protocol = 'https:';
+  } else if (url.protocol === 'ws:') {
+    url.protocol = 'http:';
+  }
+  url.pathname = url.pathname.replace(/\/agent\/ws\/?$/, '/agent/poll');
+  return url;
+}
+
+/** Map an HTTP failure to the WebSocket close code the session hook reconnects on. */
+function closeCodeForStatus(status: number): number {
+  if (status === 401 || status === 403) return 4001;
+  if (status === 404) return 1006;
+  return 1011;
+}
+
+/**
+ * Create an ACP transport over HTTP long-polling, for networks that block
+ * WebSockets. The VM Agent attaches a viewer to the same SessionHost as a
+ * WebSocket would; messages are fetched in batches with a sequence cursor
+ * and outgoing messages are POSTed one at a time, in order.
+ *
+ * Unlike the WebSocket transport, close() does not invoke onClose.
+ */
+export function createAcpLongPollTransport(opts: AcpLongPollTransportOptions): AcpTransport {
+  const fetchImpl: typeof fetch = opts.fetchImpl ?? ((input, init) => globalThis.fetch(input, init));
+  const pollWaitMs = opts.pollWaitMs ?? DEFAULT_POLL_WAIT_MS;
+  const attachUrl = longPollUrlFromWebSocketUrl(opts.url);
+  const token = attachUrl.searchParams.get('token');
+
+  let state: 'connecting' | 'open' | 'closed' = 'connecting';
+  let viewerId: string | null = null;
+  let cursor = 0;
+  let sendChain: Promise<void> = Promise.resolve();
+  const abort = new AbortController();
+
+  function viewerUrl(suffix: string, params: Record<string, string> = {}): string {
+    const url = new URL(attachUrl.toString());
+    url.pathname = `${attachUrl.pathname}/${encodeURIComponent(viewerId ?? '')}${suffix}`;
+    url.search = '';
+    if (token) url.searchParams.set('token', token);
+    for (const [key, value] of Object.entries(params)) {
+      url.searchParams.set(key, value);
+    }
+    return url.toString();
+  }
+
+  function fail(code: number, reason: string) {
+    if (state === 'closed') return;
+    state = 'closed';
+    abort.abort();
+    opts.onClose?.(code, reason);
+  }
+
+  async function attach() {
+    let res: Response;
+    try {
+      res = await fetchImpl(attachUrl.toString(), {
+        method: 'POST',
+        credentials: 'include',
+        signal: abort.signal,
+      });
+    } catch {
+      if (state === 'closed') return;
+      opts.onError?.(new Event('error'));
+      fail(1006, 'attach_failed');
+      return;
+    }
+    if (state === 'closed') return;
+    if (!res.ok) {
+      opts.onLifecycleEvent?.({
+        source: 'acp-transport',
+        level: 'warn',
+        message: 'Long-poll attach rejected',
+        context: { status: res.status },
+      });
+      fail(closeCodeForStatus(res.status), `http_${res.status}`);
+      return;
+    }
+    let body: { viewerId: string; cursor?: number };
+    try {
+      body = (await res.json()) as { viewerId: string; cursor?: number };
+    } catch {
+      fail(1011, 'attach_failed');
+      return;
+    }
+    if (state === 'closed') return;
+    viewerId = body.viewerId;
+    cursor = body.cursor ?? 0;
+    state = 'open';
+    opts.onLifecycleEvent?.({
+      source: 'acp-transport',
+      level: 'info',
+      message: 'Long-poll viewer attached',
+      context: { viewerId },
+    });
+    opts.onOpen?.();
+    void pollLoop();
+  }
+
+  async function pollLoop() {
+    while (state === 'open') {
+      let res: Response;
+      try {
+        res = await fetchImpl(
+          viewerUrl('', { after: String(cursor), waitMs: String(pollWaitMs) }),
+          { credentials: 'include', signal: abort.signal, cache: 'no-store' }
+        );
+      } catch {
+        if (state === 'closed') return;
+        fail(1006, 'poll_failed');
+        return;
+      }
+      if (state !== 'open') return;
+      if (!res.ok) {
+        fail(closeCodeForStatus(res.status), res.status === 404 ? 'viewer_not_found' : `http_${res.status}`);
+        return;
+      }
+
+      let batch: PollBatch;
+      try {
+        batch = (await res.json()) as PollBatch;
+      } catch {
+        if (state === 'closed') return;
+        fail(1006, 'poll_failed');
+        return;
+      }
+      for (const msg of batch.messages) {
+        if (state !== 'open') return;
+        try {
+          dispatchTransportMessage(msg.data, opts);
+        } catch (err) {
+          opts.onLifecycleEvent?.({
+            source: 'acp-transport',
+            level: 'warn',
+            message: 'Failed to handle long-poll message',
+            context: { seq: msg.seq, error: err instanceof Error ? err.message : String(err) },
+          });
+        }
+      }
+      cursor = batch.cursor;
+      if (batch.closed) {
+        // The server closed the viewer (session stopped/suspended, detached,
+        // or queue overflow) — same as a WebSocket going-away close.
+        fail(1001, batch.reason ?? 'closed');
+        return;
+      }
+    }
+  }
+
+  function send(message: unknown, messageType: string) {
+    if (state !== 'open') {
+      opts.onLifecycleEvent?.({
+        source: 'acp-transport',
+        level: 'warn',
+        message: 'Send failed: long-poll viewer not attached',
+        context: { state, messageType },
+      });
+      return;
+    }
+    const body = JSON.stringify(message);
+    const url = viewerUrl('/messages');
+    sendChain = sendChain.then(async () => {
+      if (state !== 'open') return;
+      try {
+        const res = await fetchImpl(url, {
+          method: 'POST',
+          credentials: 'include',
+          headers: { 'Content-Type': 'application/json' },
+          body,
+        });
+        if (!res.ok) {
+          opts.onLifecycleEvent?.({
+            source: 'acp-transport',
+            level: 'warn',
+            message: 'Long-poll send rejected',
+            context: { status: res.status, messageType },
+          });
+        }
+      } catch (err) {
+        opts.onLifecycleEvent?.({
+          source: 'acp-transport',
+          level: 'warn',
+          message: 'Long-poll send failed',
+          context: { messageType, error: err instanceof Error ? err.message : String(err) },
+        });
+      }
+    });
+  }
+
+  void attach();
+
+  return {
+    sendAcpMessage(message: unknown) {
+      send(message, 'acp');
+    },
+
+    sendSelectAgent(agentType: string) {
+      send({ type: 'select_agent', agentType }, 'select_agent');
+    },
+
+    close() {
+      const wasAttached = state === 'open' && viewerId !== null;
+      const detachUrl = wasAttached ? viewerUrl('') : null;
+      state = 'closed';
+      abort.abort();
+      if (detachUrl) {
+        // Best effort — the server also detaches viewers that stop polling.
+        fetchImpl(detachUrl, { method: 'DELETE', credentials: 'include', keepalive: true }).catch(() => {});
+      }
+    },
+
+    get connected() {
+      return state === 'open';
+    },
+  };
+}
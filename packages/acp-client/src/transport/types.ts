@@ -54,9 +54,80 @@ export interface SessionPromptingMessage {
   type: 'session_prompting';
 }
 
+/** Who or what stopped a prompt before the agent finished */
+export type PromptCancelInitiator = 'user' | 'timeout' | 'admin' | 'budget';
+
+/** Why a prompt was stopped, so UIs can render "Stopped by you" vs a failure */
+export interface PromptCancellation {
+  initiator: PromptCancelInitiator;
+  reason?: string;
+  /** True when the agent streamed output before it stopped */
+  partialOutput: boolean;
+  /** True when the agent ignored the cancel and its process was killed */
+  forceStopped?: boolean;
+  cancelledAt: string;
+}
+
 /** Sent by VM Agent when a prompt finishes */
 export interface SessionPromptDoneMessage {
   type: 'session_prompt_done';
+  stopReason?: string;
+  /** Present only when the prompt was cancelled, timed out, or force-stopped */
+  cancellation?: PromptCancellation;
+}
+
+/**
+ * Sent by VM Agent when the control plane becomes unreachable or reachable
+ * again, and to viewers that attach while it is unreachable. While offline the
+ * agent runs on cached credentials/settings and callbacks are queued.
+ */
+export interface ControlPlaneStatusMessage {
+  type: 'control_plane_status';
+  offline: boolean;
+  since: string;
+  reason?: string;
+}
+
+/** One prompt waiting behind the running prompt */
+export interface QueuedPromptInfo {
+  /** 1-based position in the queue */
+  position: number;
+  messageId?: string;
+  viewerId?: string;
+}
+
+/**
+ * Broadcast by VM Agent whenever the prompt queue changes. An empty queue
+ * means no prompts are waiting.
+ */
+export interface SessionPromptQueueMessage {
+  type: 'session_prompt_queue';
+  queued: QueuedPromptInfo[];
+  maxQueued: number;
+}
+
+/**
+ * Sent by the browser to answer a `permission/request` notification. Either
+ * choose one of the offered options or cancel the request.
+ */
+export interface PermissionResponseMessage {
+  type: 'permission_response';
+  /** The `requestId` from the permission/request params */
+  requestId: string;
+  optionId?: string;
+  cancelled?: boolean;
+}
+
+/**
+ * Broadcast by VM Agent when a permission request stops being pending, so
+ * every viewer can close its prompt. `optionId` is absent when cancelled.
+ */
+export interface PermissionResolvedMessage {
+  type: 'permission_resolved';
+  requestId: string;
+  optionId?: string;
+  resolvedBy: 'viewer' | 'timeout' | 'cancelled';
+  viewerId?: string;
 }
 
 /** Application-level ping sent by browser to VM Agent */
@@ -78,6 +149,10 @@ export type ControlMessage =
   | SessionReplayCompleteMessage
   | SessionPromptingMessage
   | SessionPromptDoneMessage
+  | ControlPlaneStatusMessage
+  | SessionPromptQueueMessage
+  | PermissionResponseMessage
+  | PermissionResolvedMessage
   | PingMessage
   | PongMessage;
 
@@ -90,6 +165,10 @@ const CONTROL_MESSAGE_TYPES = new Set([
   'session_replay_complete',
   'session_prompting',
   'session_prompt_done',
+  'control_plane_status',
+  'session_prompt_queue',
+  'permission_response',
+  'permission_resolved',
   'ping',
   'pong',
 ]);
@@ -1,7 +1,11 @@
 import type {
   AgentCrashReportMessage,
   AgentStatusMessage,
+  ControlPlaneStatusMessage,
   LifecycleEventCallback,
+  PermissionResolvedMessage,
+  SessionPromptDoneMessage,
+  SessionPromptQueueMessage,
   SessionStateMessage,
 } from './types';
 import { isControlMessage } from './types';
@@ -34,7 +38,25 @@ export type SessionReplayCompleteCallback = () => void;
 /**
  * Callback for session prompting state changes.
  */
-export type SessionPromptingCallback = (prompting: boolean) => void;
+export type SessionPromptingCallback = (
+  prompting: boolean,
+  done?: SessionPromptDoneMessage
+) => void;
+
+/**
+ * Callback for control plane reachability changes (offline mode).
+ */
+export type ControlPlaneStatusCallback = (msg: ControlPlaneStatusMessage) => void;
+
+/**
+ * Callback for prompt queue changes (prompts waiting behind the running one).
+ */
+export type SessionPromptQueueCallback = (msg: SessionPromptQueueMessage) => void;
+
+/**
+ * Callback for permission requests that were answered, timed out, or cancelled.
+ */
+export type PermissionResolvedCallback = (msg: PermissionResolvedMessage) => void;
 
 /**
  * Callback for receiving ACP JSON-RPC messages from the agent.
@@ -60,19 +82,20 @@ export interface AcpTransport {
   readonly connected: boolean;
 }
 
-/** Options for creating the ACP WebSocket transport. */
-export interface AcpTransportOptions {
-  /** An open WebSocket connection to /agent/ws */
-  ws: WebSocket;
+/**
+ * Callbacks shared by every ACP transport (WebSocket and the HTTP long-poll
+ * fallback).
+ */
+export interface AcpTransportCallbacks {
   /** Callback for agent_status control messages */
   onAgentStatus: AgentStatusCallback;
   /** Callback for ACP JSON-RPC messages from the agent */
   onAcpMessage: AcpMessageCallback;
   /** Callback for agent_crash_report control messages */
   onAgentCrashReport?: AgentCrashReportCallback;
-  /** Callback when the WebSocket closes. Receives the close code and reason for smarter reconnection. */
+  /** Callback when the connection closes. Receives the close code and reason for smarter reconnection. */
   onClose?: (code?: number, reason?: string) => void;
-  /** Callback when a WebSocket error occurs */
+  /** Callback when a connection error occurs */
   onError?: (error: Event) => void;
   /** Optional callback for lifecycle observability logging */
   onLifecycleEvent?: LifecycleEventCallback;
@@ -82,12 +105,78 @@ export interface AcpTransportOptions {
   onSessionReplayComplete?: SessionReplayCompleteCallback;
   /** Callback for session_prompting / session_prompt_done */
   onSessionPrompting?: SessionPromptingCallback;
+  /** Callback for control_plane_status (session operating offline) */
+  onControlPlaneStatus?: ControlPlaneStatusCallback;
+  /** Callback for session_prompt_queue (queued follow-up prompts) */
+  onSessionPromptQueue?: SessionPromptQueueCallback;
+  /** Callback for permission_resolved (close any open permission prompt) */
+  onPermissionResolved?: PermissionResolvedCallback;
+}
+
+/** Options for creating the ACP WebSocket transport. */
+export interface AcpTransportOptions extends AcpTransportCallbacks {
+  /** An open WebSocket connection to /agent/ws */
+  ws: WebSocket;
   /** Application-level heartbeat ping interval in ms (default: 30000). Set to 0 to disable. */
   heartbeatIntervalMs?: number;
   /** Pong response deadline in ms (default: 10000). Connection closed if exceeded. */
   heartbeatTimeoutMs?: number;
 }
 
+/**
+ * Route one parsed message from the VM Agent to the matching callback.
+ * Returns the control message type when the transport itself must act on it
+ * (ping/pong keepalives), otherwise null.
+ */
+export function dispatchTransportMessage(
+  data: unknown,
+  opts: AcpTransportCallbacks
+): 'ping' | 'pong' | null {
+  if (!isControlMessage(data)) {
+    opts.onAcpMessage(data);
+    return null;
+  }
+  switch (data.type) {
+    case 'agent_status':
+      opts.onAgentStatus(data);
+      break;
+    case 'agent_crash_report':
+      if (opts.onAgentCrashReport) {
+        opts.onAgentCrashReport(data);
+      } else {
+        opts.onAcpMessage(data);
+      }
+      break;
+    case 'session_state':
+      opts.onSessionState?.(data);
+      break;
+    case 'session_replay_complete':
+      opts.onSessionReplayComplete?.();
+      break;
+    case 'session_prompting':
+      opts.onSessionPrompting?.(true);
+      break;
+    case 'session_prompt_done':
+      opts.onSessionPrompting?.(false, data);
+      break;
+    case 'control_plane_status':
+      opts.onControlPlaneStatus?.(data);
+      break;
+    case 'session_prompt_queue':
+      opts.onSessionPromptQueue?.(data);
+      break;
+    case 'permission_resolved':
+      opts.onPermissionResolved?.(data);
+      break;
+    case 'pong':
+    case 'ping':
+      return data.type;
+    default:
+      break;
+  }
+  return null;
+}
+
 /**
  * Create an ACP WebSocket transport connected to the VM Agent.
  *
@@ -167,44 +256,14 @@ export function createAcpWebSocketTransport(
   ws.addEventListener('message', (event) => {
     try {
       const data = JSON.parse(event.data as string);
-      if (isControlMessage(data)) {
-        switch (data.type) {
-          case 'agent_status':
-            opts.onAgentStatus(data);
-            break;
-          case 'agent_crash_report':
-            if (opts.onAgentCrashReport) {
-              opts.onAgentCrashReport(data);
-            } else {
-              opts.onAcpMessage(data);
-            }
-            break;
-          case 'session_state':
-            opts.onSessionState?.(data);
-            break;
-          case 'session_replay_complete':
-            opts.onSessionReplayComplete?.();
-            break;
-          case 'session_prompting':
-            opts.onSessionPrompting?.(true);
-            break;
-          case 'session_prompt_done':
-            opts.onSessionPrompting?.(false);
-            break;
-          case 'pong':
-            handlePong();
-            break;
-          case 'ping':
-            // Server shouldn't send pings to client, but respond if it does
-            if (ws.readyState === WebSocket.OPEN) {
-              ws.send(JSON.stringify({ type: 'pong' }));
-            }
-            break;
-          default:
-            break;
+      const keepalive = dispatchTransportMessage(data, opts);
+      if (keepalive === 'pong') {
+        handlePong();
+      } else if (keepalive === 'ping') {
+        // Server shouldn't send pings to client, but respond if it does
+        if (ws.readyState === WebSocket.OPEN) {
+          ws.send(JSON.stringify({ type: 'pong' }));
         }
-      } else {
-        opts.onAcpMessage(data);
       }
     } catch {
       opts.onLifecycleEvent?.({
@@ -79,6 +79,8 @@ class MockWebSocket {
 beforeEach(() => {
   MockWebSocket.instances = [];
   vi.stubGlobal('WebSocket', MockWebSocket as unknown as typeof WebSocket);
+  // The long-poll fallback is unreachable unless a test provides a server.
+  vi.stubGlobal('fetch', vi.fn(() => Promise.reject(new TypeError('network unreachable'))));
 });
 
 afterEach(() => {
@@ -981,6 +983,54 @@ describe('useAcpSession structured error codes', () => {
     });
   });
 
+  it('falls back to HTTP long-poll when the WebSocket upgrade fails', async () => {
+    const fetchMock = vi.fn((input: RequestInfo | URL, init?: RequestInit) => {
+      const url = String(input);
+      if (init?.method === 'POST') {
+        return Promise.resolve(new Response(JSON.stringify({ viewerId: 'viewer-1', cursor: 0 }), { status: 201 }));
+      }
+      if (url.includes('after=0')) {
+        return Promise.resolve(new Response(JSON.stringify({
+          messages: [{ seq: 1, data: { type: 'session_state', status: 'idle', agentType: '', replayCount: 0 } }],
+          cursor: 1,
+          closed: false,
+        }), { status: 200 }));
+      }
+      return new Promise<Response>((_, reject) => {
+        init?.signal?.addEventListener('abort', () => reject(new DOMException('aborted', 'AbortError')));
+      });
+    });
+    vi.stubGlobal('fetch', fetchMock);
+
+    const { result, unmount } = renderHook(() => useAcpSession({
+      wsUrl: 'ws://localhost/agent/ws?token=t',
+    }));
+
+    const ws = MockWebSocket.instances[0]!;
+    act(() => ws.close(1006));
+
+    await waitFor(() => {
+      expect(result.current.state).toBe('no_session');
+    });
+    expect(fetchMock.mock.calls[0]![0]).toBe('http://localhost/agent/poll?token=t');
+    expect(MockWebSocket.instances).toHaveLength(1);
+    unmount();
+  });
+
+  it('does not fall back to long-poll when disabled', async () => {
+    const { result } = renderHook(() => useAcpSession({
+      wsUrl: 'ws://localhost/agent/ws',
+      longPollFallback: false,
+    }));
+
+    act(() => MockWebSocket.instances[0]!.close(1006));
+
+    await waitFor(() => {
+      expect(result.current.errorCode).toBe('CONNECTION_FAILED' satisfies AcpErrorCode);
+    });
+    expect(fetch).not.toHaveBeenCalled();
+  });
+
   it('sets URL_UNAVAILABLE when resolver returns null', async () => {
     const resolveWsUrl = vi.fn().mockResolvedValue(null);
 
@@ -0,0 +1,148 @@
+import { describe, it, expect, vi } from 'vitest';
+import { createAcpLongPollTransport, longPollUrlFromWebSocketUrl } from '../../../src/transport/longpoll';
+
+function jsonResponse(status: number, body: unknown): Response {
+  return new Response(JSON.stringify(body), {
+    status,
+    headers: { 'Content-Type': 'application/json' },
+  });
+}
+
+/** A fetch that never resolves until aborted, like a long-poll with no traffic. */
+function pendingUntilAborted(init?: RequestInit): Promise<Response> {
+  return new Promise((_, reject) => {
+    init?.signal?.addEventListener('abort', () => reject(new DOMException('aborted', 'AbortError')));
+  });
+}
+
+describe('longPollUrlFromWebSocketUrl', () => {
+  it('maps ws(s) /agent/ws URLs to http(s) /agent/poll and keeps the query', () => {
+    expect(longPollUrlFromWebSocketUrl('wss://ws-abc.example.com/agent/ws?token=t&sessionId=s').toString())
+      .toBe('https://ws-abc.example.com/agent/poll?token=t&sessionId=s');
+    expect(longPollUrlFromWebSocketUrl('ws://localhost:8080/agent/ws').toString())
+      .toBe('http://localhost:8080/agent/poll');
+  });
+});
+
+describe('createAcpLongPollTransport', () => {
+  it('attaches, dispatches batches in order, and advances the cursor', async () => {
+    const onSessionState = vi.fn();
+    const onAcpMessage = vi.fn();
+    const onOpen = vi.fn();
+    const urls: string[] = [];
+    let polls = 0;
+
+    const fetchImpl = vi.fn((input: RequestInfo | URL, init?: RequestInit) => {
+      const url = String(input);
+      urls.push(`${init?.method ?? 'GET'} ${url}`);
+      if (init?.method === 'POST' && url.includes('/agent/poll?')) {
+        return Promise.resolve(jsonResponse(201, { viewerId: 'viewer-1', cursor: 0 }));
+      }
+      polls++;
+      if (polls === 1) {
+        return Promise.resolve(jsonResponse(200, {
+          messages: [
+            { seq: 1, data: { type: 'session_state', status: 'idle', agentType: '', replayCount: 0 } },
+            { seq: 2, data: { jsonrpc: '2.0', method: 'session/update' } },
+          ],
+          cursor: 2,
+          closed: false,
+        }));
+      }
+      return pendingUntilAborted(init);
+    }) as unknown as typeof fetch;
+
+    const transport = createAcpLongPollTransport({
+      url: 'wss://host.test/agent/ws?token=jwt&sessionId=s1',
+      onAgentStatus: vi.fn(),
+      onAcpMessage,
+      onSessionState,
+      onOpen,
+      fetchImpl,
+    });
+
+    await vi.waitFor(() => expect(onAcpMessage).toHaveBeenCalledTimes(1));
+    expect(onOpen).toHaveBeenCalledTimes(1);
+    expect(transport.connected).toBe(true);
+    expect(onSessionState).toHaveBeenCalledWith(expect.objectContaining({ status: 'idle' }));
+    expect(onAcpMessage).toHaveBeenCalledWith({ jsonrpc: '2.0', method: 'session/update' });
+
+    await vi.waitFor(() => expect(urls).toHaveLength(3));
+    expect(urls[0]).toBe('POST https://host.test/agent/poll?token=jwt&sessionId=s1');
+    expect(urls[1]).toContain('https://host.test/agent/poll/viewer-1?token=jwt&after=0');
+    expect(urls[2]).toContain('after=2');
+
+    transport.close();
+    expect(transport.connected).toBe(false);
+    await vi.waitFor(() => expect(urls).toContain('DELETE https://host.test/agent/poll/viewer-1?token=jwt'));
+  });
+
+  it('POSTs outgoing messages in order', async () => {
+    const bodies: string[] = [];
+    const fetchImpl = vi.fn((input: RequestInfo | URL, init?: RequestInit) => {
+      const url = String(input);
+      if (init?.method === 'POST' && url.includes('/messages')) {
+        bodies.push(String(init.body));
+        return Promise.resolve(new Response(null, { status: 202 }));
+      }
+      if (init?.method === 'POST') {
+        return Promise.resolve(jsonResponse(201, { viewerId: 'viewer-1', cursor: 0 }));
+      }
+      return pendingUntilAborted(init);
+    }) as unknown as typeof fetch;
+
+    const onOpen = vi.fn();
+    const transport = createAcpLongPollTransport({
+      url: 'ws://host.test/agent/ws',
+      onAgentStatus: vi.fn(),
+      onAcpMessage: vi.fn(),
+      onOpen,
+      fetchImpl,
+    });
+    await vi.waitFor(() => expect(onOpen).toHaveBeenCalled());
+
+    transport.sendSelectAgent('claude-code');
+    transport.sendAcpMessage({ jsonrpc: '2.0', id: 1, method: 'session/prompt' });
+
+    await vi.waitFor(() => expect(bodies).toHaveLength(2));
+    expect(JSON.parse(bodies[0]!)).toEqual({ type: 'select_agent', agentType: 'claude-code' });
+    expect(JSON.parse(bodies[1]!)).toMatchObject({ method: 'session/prompt' });
+    transport.close();
+  });
+
+  it('reports a server-closed viewer as a going-away close', async () => {
+    const onClose = vi.fn();
+    const fetchImpl = vi.fn((_input: RequestInfo | URL, init?: RequestInit) => {
+      if (init?.method === 'POST') {
+        return Promise.resolve(jsonResponse(201, { viewerId: 'viewer-1', cursor: 0 }));
+      }
+      return Promise.resolve(jsonResponse(200, { messages: [], cursor: 0, closed: true, reason: 'session stopped' }));
+    }) as unknown as typeof fetch;
+
+    createAcpLongPollTransport({
+      url: 'ws://host.test/agent/ws',
+      onAgentStatus: vi.fn(),
+      onAcpMessage: vi.fn(),
+      onClose,
+      fetchImpl,
+    });
+
+    await vi.waitFor(() => expect(onClose).toHaveBeenCalledWith(1001, 'session stopped'));
+  });
+
+  it('maps a rejected attach to the auth-expired close code', async () => {
+    const onClose = vi.fn();
+    const fetchImpl = vi.fn(() => Promise.resolve(new Response('Unauthorized', { status: 401 }))) as unknown as typeof fetch;
+
+    const transport = createAcpLongPollTransport({
+      url: 'ws://host.test/agent/ws',
+      onAgentStatus: vi.fn(),
+      onAcpMessage: vi.fn(),
+      onClose,
+      fetchImpl,
+    });
+
+    await vi.waitFor(() => expect(onClose).toHaveBeenCalledWith(4001, 'http_401'));
+    expect(transport.connected).toBe(false);
+  });
+});
@@ -209,8 +209,24 @@ export interface SaveAgentCredentialRequest {
   autoActivate?: boolean; // Default true
 }
 
+/**
+ * Launch descriptor for an agent outside the built-in catalog (OpenRouter,
+ * Ollama, or a self-hosted ACP adapter). Ignored for built-in agent types.
+ */
+export interface CustomAgentDescriptor {
+  command: string; // Executable name or absolute path of the ACP stdio adapter
+  args?: string[];
+  env?: Record<string, string>;
+  credentialEnvVar?: string; // Env var that receives apiKey; omit when the agent takes no credential
+  installCommand?: string; // Run via sh -c when command is not on PATH
+  npmBased?: boolean; // Ensure Node.js/npm before installCommand
+  baseUrl?: string;
+  baseUrlEnvVar?: string; // Default: OPENAI_BASE_URL
+}
+
 /** Response from /api/workspaces/:id/agent-key endpoint */
 export interface AgentKeyResponse {
   apiKey: string; // Decrypted credential (API key or OAuth token)
   credentialKind: CredentialKind; // Type for proper env var injection
+  customAgent?: CustomAgentDescriptor; // Only for agent types outside the built-in catalog
 }
@@ -254,6 +254,8 @@ export type {
   CreateAgentSessionRequest,
   CreateWorktreeRequest,
   GitBranchListResponse,
+  GitCheckoutRequest,
+  GitCheckoutResponse,
   PersistMessageBatchRequest,
   PersistMessageBatchResponse,
   PersistMessageItem,
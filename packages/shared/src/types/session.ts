@@ -174,7 +174,26 @@ export interface RemoveWorktreeResponse {
 }
 
 export interface GitBranchListResponse {
-  branches: Array<{ name: string }>;
+  /** Remote branches, followed by branches that exist only in the workspace (`local: true`). */
+  branches: Array<{ name: string; local?: boolean }>;
+  /** Checked-out branch; absent when HEAD is detached. */
+  current?: string;
+}
+
+export interface GitCheckoutRequest {
+  branch: string;
+  /** Create `branch` from `baseBranch` (default HEAD) instead of switching to an existing branch. */
+  create?: boolean;
+  baseBranch?: string;
+  /** Carry uncommitted changes to tracked files across the switch instead of failing with 409. */
+  allowDirty?: boolean;
+}
+
+export interface GitCheckoutResponse {
+  branch: string;
+  previousBranch?: string;
+  headCommit: string;
+  created: boolean;
 }
 
 export interface AgentSession {
@@ -197,6 +216,8 @@ export interface AgentSession {
   hostStatus?: AgentHostStatus | null;
   /** Number of connected browser viewers (only present in live/enriched responses). */
   viewerCount?: number | null;
+  /** Checkout the agent is running in, as reported by the VM Agent session list. */
+  workDir?: string | null;
 }
 
 export interface CreateAgentSessionRequest {
@@ -332,6 +332,18 @@ export interface BootstrapResponse {
   gitUserEmail?: string | null;
   githubId?: string | null;
   controlPlaneUrl: string;
+  /** Repository git host: github (default), gitlab, bitbucket, or artifacts. */
+  provider?: string | null;
+  /** Token for `provider`. Takes precedence over githubToken when set. */
+  gitToken?: string | null;
+  /** Bound repository host, e.g. a self-managed GitLab. Defaults to the provider's hosted service. */
+  repositoryHost?: string | null;
+  /** Bound repository path; required for path-bound providers (gitlab, bitbucket). */
+  repositoryPath?: string | null;
+  /** Unencrypted SSH private key for ssh:// and git@host:path repositories. */
+  deployKey?: string | null;
+  /** known_hosts lines for the SSH git host. Without them the first host key seen is trusted. */
+  knownHosts?: string | null;
 }
 
 export interface WorkspaceRuntimeEnvVar {
@@ -0,0 +1,379 @@
+// Package accessaudit provides a SQLite-backed audit trail of viewers that
+// attached to agent sessions: who attached, when, from where, whether they
+// only watched or also drove the agent, and for how long. Closed records are
+// shipped upstream to the control plane and retained locally for a bounded
+// period so organizations can answer "who was watching/driving this agent
+// session" after an incident.
+package accessaudit
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/retention"
+	_ "modernc.org/sqlite"
+)
+
+// Viewer roles. A viewer is a watcher until it sends a prompt or cancels one.
+const (
+	RoleWatcher = "watcher"
+	RoleDriver  = "driver"
+)
+
+// End reasons recorded when an access record is closed.
+const (
+	EndReasonDisconnected = "disconnected"
+	// EndReasonAgentRestart marks records left open by a previous agent
+	// process; their detach time is unknown.
+	EndReasonAgentRestart = "agent_restart"
+)
+
+// ErrNotFound is returned when an access record does not exist.
+var ErrNotFound = errors.New("access record not found")
+
+// Entry is a single viewer attachment to an agent session.
+type Entry struct {
+	ID          string `json:"id"`
+	WorkspaceID string `json:"workspaceId"`
+	SessionID   string `json:"sessionId"`
+	ViewerID    string `json:"viewerId"`
+	// Subject is the authenticated user (JWT subject) behind the viewer.
+	Subject string `json:"subject,omitempty"`
+	// IP is the client address taken from forwarded headers when present;
+	// RemoteAddr is the address of the peer that connected to the agent.
+	IP         string `json:"ip,omitempty"`
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+	UserAgent  string `json:"userAgent,omitempty"`
+	Role       string `json:"role"`
+	Prompts    int    `json:"prompts"`
+	AttachedAt string `json:"attachedAt"`
+	DetachedAt string `json:"detachedAt,omitempty"`
+	DurationMs int64  `json:"durationMs,omitempty"`
+	EndReason  string `json:"endReason,omitempty"`
+}
+
+// Query filters access records. WorkspaceID is required.
+type Query struct {
+	WorkspaceID string
+	SessionID   string
+	Subject     string
+	// Since limits results to records attached at or after this time.
+	Since time.Time
+	Limit int
+}
+
+// Store is a SQLite-backed access audit store.
+type Store struct {
+	db *sql.DB
+	mu sync.Mutex // serializes writes
+}
+
+// New opens (or creates) a SQLite access audit store at the given path.
+// Records attached longer ago than retention are trimmed on open (zero keeps
+// everything), and records left open by a previous agent process are closed
+// with EndReasonAgentRestart.
+func New(dbPath string, retention time.Duration) (*Store, error) {
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?cache=shared&mode=rwc&_journal_mode=WAL", dbPath))
+	if err != nil {
+		return nil, fmt.Errorf("accessaudit: open: %w", err)
+	}
+	for _, pragma := range []string{
+		"PRAGMA journal_mode=WAL",
+		"PRAGMA busy_timeout=5000",
+		"PRAGMA synchronous=NORMAL",
+	} {
+		if _, err := db.Exec(pragma); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("accessaudit: %s: %w", pragma, err)
+		}
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("accessaudit: migrate: %w", err)
+	}
+
+	s := &Store{db: db}
+	if retention > 0 {
+		cutoff := time.Now().UTC().Add(-retention).Format(time.RFC3339Nano)
+		if result, err := db.Exec(`DELETE FROM viewer_access WHERE attached_at < ?`, cutoff); err != nil {
+			slog.Warn("accessaudit: trim on startup failed", "error", err)
+		} else if n, _ := result.RowsAffected(); n > 0 {
+			slog.Info("accessaudit: trimmed old records on startup", "deleted", n)
+		}
+	}
+	if _, err := db.Exec(
+		`UPDATE viewer_access SET end_reason = ? WHERE end_reason = ''`, EndReasonAgentRestart,
+	); err != nil {
+		slog.Warn("accessaudit: closing dangling records failed", "error", err)
+	}
+	return s, nil
+}
+
+func migrate(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS viewer_access (
+			id           TEXT PRIMARY KEY,
+			workspace_id TEXT NOT NULL,
+			session_id   TEXT NOT NULL,
+			viewer_id    TEXT NOT NULL,
+			subject      TEXT NOT NULL DEFAULT '',
+			ip           TEXT NOT NULL DEFAULT '',
+			remote_addr  TEXT NOT NULL DEFAULT '',
+			user_agent   TEXT NOT NULL DEFAULT '',
+			role         TEXT NOT NULL DEFAULT 'watcher',
+			prompts      INTEGER NOT NULL DEFAULT 0,
+			attached_at  TEXT NOT NULL,
+			detached_at  TEXT NOT NULL DEFAULT '',
+			duration_ms  INTEGER NOT NULL DEFAULT 0,
+			end_reason   TEXT NOT NULL DEFAULT '',
+			shipped      INTEGER NOT NULL DEFAULT 0
+		);
+		CREATE INDEX IF NOT EXISTS idx_viewer_access_workspace ON viewer_access(workspace_id, attached_at);
+		CREATE INDEX IF NOT EXISTS idx_viewer_access_unshipped ON viewer_access(shipped, end_reason);
+	`)
+	return err
+}
+
+// Attach records a viewer attaching to a session and returns the stored entry.
+// ID and AttachedAt are filled in when empty; the role starts as watcher.
+func (s *Store) Attach(e Entry) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e.ID == "" {
+		e.ID = newAccessID()
+	}
+	if e.AttachedAt == "" {
+		e.AttachedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	e.Role = RoleWatcher
+	e.Prompts = 0
+	if _, err := s.db.Exec(
+		`INSERT INTO viewer_access (id, workspace_id, session_id, viewer_id, subject, ip, remote_addr, user_agent, role, attached_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.ID, e.WorkspaceID, e.SessionID, e.ViewerID, e.Subject, e.IP, e.RemoteAddr, e.UserAgent, e.Role, e.AttachedAt,
+	); err != nil {
+		return Entry{}, fmt.Errorf("accessaudit: insert: %w", err)
+	}
+	return e, nil
+}
+
+// RecordDrive marks the viewer as a driver and counts one prompt or cancel.
+func (s *Store) RecordDrive(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result, err := s.db.Exec(
+		`UPDATE viewer_access SET role = ?, prompts = prompts + 1 WHERE id = ?`, RoleDriver, id,
+	)
+	if err != nil {
+		return fmt.Errorf("accessaudit: record drive: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Detach closes an access record at the given time and returns it.
+func (s *Store) Detach(id string, at time.Time) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, err := s.get(id)
+	if err != nil {
+		return Entry{}, err
+	}
+	e.DetachedAt = at.UTC().Format(time.RFC3339Nano)
+	e.EndReason = EndReasonDisconnected
+	if attached, err := time.Parse(time.RFC3339Nano, e.AttachedAt); err == nil && at.After(attached) {
+		e.DurationMs = at.Sub(attached).Milliseconds()
+	}
+	if _, err := s.db.Exec(
+		`UPDATE viewer_access SET detached_at = ?, duration_ms = ?, end_reason = ? WHERE id = ?`,
+		e.DetachedAt, e.DurationMs, e.EndReason, id,
+	); err != nil {
+		return Entry{}, fmt.Errorf("accessaudit: detach: %w", err)
+	}
+	return e, nil
+}
+
+// List returns access records matching the query, newest first.
+func (s *Store) List(q Query) ([]Entry, error) {
+	var where []string
+	var args []interface{}
+	where = append(where, "workspace_id = ?")
+	args = append(args, q.WorkspaceID)
+	if q.SessionID != "" {
+		where = append(where, "session_id = ?")
+		args = append(args, q.SessionID)
+	}
+	if q.Subject != "" {
+		where = append(where, "subject = ?")
+		args = append(args, q.Subject)
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "attached_at >= ?")
+		args = append(args, q.Since.UTC().Format(time.RFC3339Nano))
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	args = append(args, limit)
+
+	rows, err := s.db.Query(
+		`SELECT `+entryColumns+` FROM viewer_access WHERE `+strings.Join(where, " AND ")+
+			` ORDER BY attached_at DESC, id DESC LIMIT ?`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanEntries(rows)
+}
+
+// Unshipped returns up to limit closed records that have not yet been shipped
+// upstream, oldest first.
+func (s *Store) Unshipped(limit int) ([]Entry, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	rows, err := s.db.Query(
+		`SELECT `+entryColumns+` FROM viewer_access WHERE shipped = 0 AND end_reason != ''
+		 ORDER BY attached_at ASC, id ASC LIMIT ?`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanEntries(rows)
+}
+
+// MarkShipped flags records as delivered upstream.
+func (s *Store) MarkShipped(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	if _, err := s.db.Exec(`UPDATE viewer_access SET shipped = 1 WHERE id IN (`+placeholders+`)`, args...); err != nil {
+		return fmt.Errorf("accessaudit: mark shipped: %w", err)
+	}
+	return nil
+}
+
+// RetentionItems lists a workspace's closed access records with their
+// approximate stored size, for retention policy enforcement. Open records
+// belong to viewers that are still attached and are never purged.
+func (s *Store) RetentionItems(workspaceID string) ([]retention.Item, error) {
+	rows, err := s.db.Query(
+		`SELECT id, length(id) + length(session_id) + length(viewer_id) + length(subject) + length(ip) +
+			length(remote_addr) + length(user_agent) + length(role) + length(attached_at) + length(detached_at) + length(end_reason),
+			attached_at
+		 FROM viewer_access WHERE workspace_id = ? AND end_reason != ''`,
+		workspaceID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []retention.Item
+	for rows.Next() {
+		var item retention.Item
+		var attachedAt string
+		if err := rows.Scan(&item.ID, &item.Bytes, &attachedAt); err != nil {
+			return nil, err
+		}
+		item.CreatedAt = retention.ParseTimestamp(attachedAt)
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// DeleteRetentionItems deletes a workspace's closed access records by ID.
+func (s *Store) DeleteRetentionItems(workspaceID string, ids []string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for start := 0; start < len(ids); start += deleteBatchSize {
+		batch := ids[start:min(start+deleteBatchSize, len(ids))]
+		args := make([]interface{}, 0, len(batch)+1)
+		args = append(args, workspaceID)
+		for _, id := range batch {
+			args = append(args, id)
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(batch)), ",")
+		result, err := s.db.Exec(
+			`DELETE FROM viewer_access WHERE workspace_id = ? AND end_reason != '' AND id IN (`+placeholders+`)`, args...,
+		)
+		if err != nil {
+			return deleted, fmt.Errorf("accessaudit: delete: %w", err)
+		}
+		n, _ := result.RowsAffected()
+		deleted += n
+	}
+	return deleted, nil
+}
+
+// deleteBatchSize bounds the number of bound parameters per DELETE.
+const deleteBatchSize = 500
+
+// Close closes the underlying database connection.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+const entryColumns = `id, workspace_id, session_id, viewer_id, subject, ip, remote_addr, user_agent,
+	role, prompts, attached_at, detached_at, duration_ms, end_reason`
+
+func (s *Store) get(id string) (Entry, error) {
+	rows, err := s.db.Query(`SELECT `+entryColumns+` FROM viewer_access WHERE id = ?`, id)
+	if err != nil {
+		return Entry{}, err
+	}
+	entries, err := scanEntries(rows)
+	if err != nil {
+		return Entry{}, err
+	}
+	if len(entries) == 0 {
+		return Entry{}, ErrNotFound
+	}
+	return entries[0], nil
+}
+
+func scanEntries(rows *sql.Rows) ([]Entry, error) {
+	defer rows.Close()
+	entries := []Entry{}
+	for rows.Next() {
+		var e Entry
+		if err := rows.Scan(
+			&e.ID, &e.WorkspaceID, &e.SessionID, &e.ViewerID, &e.Subject, &e.IP, &e.RemoteAddr, &e.UserAgent,
+			&e.Role, &e.Prompts, &e.AttachedAt, &e.DetachedAt, &e.DurationMs, &e.EndReason,
+		); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func newAccessID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return "access-" + hex.EncodeToString(b)
+}
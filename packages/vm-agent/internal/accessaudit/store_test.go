@@ -0,0 +1,110 @@
+package accessaudit
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T, dbPath string) *Store {
+	t.Helper()
+	if dbPath == "" {
+		dbPath = filepath.Join(t.TempDir(), "access-audit.db")
+	}
+	s, err := New(dbPath, 0)
+	if err != nil {
+		t.Fatalf("accessaudit.New: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func TestAttachDriveDetach(t *testing.T) {
+	s := newTestStore(t, "")
+
+	attachedAt := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	e, err := s.Attach(Entry{
+		WorkspaceID: "ws-1", SessionID: "sess-1", ViewerID: "viewer-1",
+		Subject: "user-1", IP: "203.0.113.7", AttachedAt: attachedAt.Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+	if e.ID == "" || e.Role != RoleWatcher {
+		t.Fatalf("unexpected attached entry: %+v", e)
+	}
+	if _, err := s.Attach(Entry{WorkspaceID: "ws-1", SessionID: "sess-2", ViewerID: "viewer-2", Subject: "user-2"}); err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := s.RecordDrive(e.ID); err != nil {
+			t.Fatalf("RecordDrive: %v", err)
+		}
+	}
+	if err := s.RecordDrive("access-missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("RecordDrive(missing) error = %v, want ErrNotFound", err)
+	}
+
+	closed, err := s.Detach(e.ID, attachedAt.Add(90*time.Second))
+	if err != nil {
+		t.Fatalf("Detach: %v", err)
+	}
+	if closed.Role != RoleDriver || closed.Prompts != 2 || closed.DurationMs != 90000 || closed.EndReason != EndReasonDisconnected {
+		t.Fatalf("unexpected detached entry: %+v", closed)
+	}
+
+	list, err := s.List(Query{WorkspaceID: "ws-1", Subject: "user-1"})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(list) != 1 || list[0].ID != e.ID || list[0].IP != "203.0.113.7" {
+		t.Fatalf("List(subject) = %+v", list)
+	}
+	if list, _ := s.List(Query{WorkspaceID: "ws-1"}); len(list) != 2 {
+		t.Fatalf("List(workspace) returned %d records, want 2", len(list))
+	}
+	if list, _ := s.List(Query{WorkspaceID: "ws-1", Since: attachedAt.Add(time.Hour)}); len(list) != 1 || list[0].SessionID != "sess-2" {
+		t.Fatalf("List(since) = %+v", list)
+	}
+}
+
+func TestUnshippedOnlyReturnsClosedRecords(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "access-audit.db")
+	s, err := New(dbPath, 0)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	closed, _ := s.Attach(Entry{WorkspaceID: "ws-1", SessionID: "sess-1", ViewerID: "viewer-1"})
+	if _, err := s.Detach(closed.ID, time.Now()); err != nil {
+		t.Fatalf("Detach: %v", err)
+	}
+	open, _ := s.Attach(Entry{WorkspaceID: "ws-1", SessionID: "sess-1", ViewerID: "viewer-2"})
+
+	pending, err := s.Unshipped(10)
+	if err != nil {
+		t.Fatalf("Unshipped: %v", err)
+	}
+	if len(pending) != 1 || pending[0].ID != closed.ID {
+		t.Fatalf("Unshipped = %+v, want only %s", pending, closed.ID)
+	}
+	if err := s.MarkShipped([]string{closed.ID}); err != nil {
+		t.Fatalf("MarkShipped: %v", err)
+	}
+	if pending, _ := s.Unshipped(10); len(pending) != 0 {
+		t.Fatalf("Unshipped after MarkShipped = %+v", pending)
+	}
+	_ = s.Close()
+
+	// Reopening closes records left open by the previous process.
+	reopened := newTestStore(t, dbPath)
+	pending, err = reopened.Unshipped(10)
+	if err != nil {
+		t.Fatalf("Unshipped: %v", err)
+	}
+	if len(pending) != 1 || pending[0].ID != open.ID || pending[0].EndReason != EndReasonAgentRestart || pending[0].DetachedAt != "" {
+		t.Fatalf("dangling record after restart = %+v", pending)
+	}
+}
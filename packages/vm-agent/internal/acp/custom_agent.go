@@ -0,0 +1,146 @@
+package acp
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// customAgentCommandPattern allows a bare executable name or an absolute
+// path. The command is also interpolated into the npm cleanup script, so
+// shell metacharacters and whitespace are rejected.
+var customAgentCommandPattern = regexp.MustCompile(`^([A-Za-z0-9._+-]+|(/[A-Za-z0-9._+-]+)+)$`)
+
+// defaultCustomAgentBaseURLEnvVar receives BaseURL when the descriptor does
+// not name its own variable. Most self-hosted and OpenRouter-style adapters
+// speak the OpenAI API.
+const defaultCustomAgentBaseURLEnvVar = "OPENAI_BASE_URL"
+
+// customAgentDescriptor describes a user-configured ACP agent (an OpenRouter,
+// Ollama, or self-hosted ACP adapter) that is not in the built-in catalog.
+// The control plane returns it alongside the credential from the agent-key
+// endpoint, and the SessionHost launches it like any built-in agent.
+type customAgentDescriptor struct {
+	Command string            `json:"command"`
+	Args    []string          `json:"args,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+	// CredentialEnvVar receives the agent credential. Empty means the agent
+	// takes no credential (e.g. a local Ollama model).
+	CredentialEnvVar string `json:"credentialEnvVar,omitempty"`
+	// InstallCommand runs via sh -c when Command is not on PATH. It comes
+	// from the workspace owner's agent configuration and runs in their own
+	// container, the same trust level as their devcontainer setup.
+	InstallCommand string `json:"installCommand,omitempty"`
+	NpmBased       bool   `json:"npmBased,omitempty"`
+	BaseURL        string `json:"baseUrl,omitempty"`
+	BaseURLEnvVar  string `json:"baseUrlEnvVar,omitempty"`
+}
+
+// isBuiltinAgentType reports whether agentType is in the built-in catalog.
+// Built-in agents always use getAgentCommandInfo, even if the control plane
+// sends a descriptor.
+func isBuiltinAgentType(agentType string) bool {
+	switch agentType {
+	case "claude-code", "openai-codex", "google-gemini", "mistral-vibe", "opencode", "amp":
+		return true
+	default:
+		return false
+	}
+}
+
+// customAgentFor returns the custom descriptor that applies to agentType, or
+// nil for built-in agents and credentials without one.
+func customAgentFor(agentType string, cred *agentCredential) *customAgentDescriptor {
+	if cred == nil || cred.customAgent == nil || isBuiltinAgentType(agentType) {
+		return nil
+	}
+	return cred.customAgent
+}
+
+// resolveAgentCommandInfo returns the launch info for agentType, using the
+// custom descriptor from the credential when one applies.
+func resolveAgentCommandInfo(agentType string, cred *agentCredential) (agentCommandInfo, error) {
+	custom := customAgentFor(agentType, cred)
+	if custom == nil {
+		return getAgentCommandInfo(agentType, cred.credentialKind), nil
+	}
+	if err := custom.validate(); err != nil {
+		return agentCommandInfo{}, fmt.Errorf("invalid custom agent %q: %w", agentType, err)
+	}
+	return agentCommandInfo{
+		command:    custom.Command,
+		args:       custom.Args,
+		envVarName: custom.CredentialEnvVar,
+		installCmd: custom.InstallCommand,
+		isNpmBased: custom.NpmBased,
+	}, nil
+}
+
+func (d *customAgentDescriptor) validate() error {
+	if !customAgentCommandPattern.MatchString(d.Command) {
+		return fmt.Errorf("command %q must be an executable name or absolute path", d.Command)
+	}
+	for _, segment := range strings.Split(d.Command, "/") {
+		if segment == "." || segment == ".." {
+			return fmt.Errorf("command %q must not contain . or .. segments", d.Command)
+		}
+	}
+	for _, arg := range d.Args {
+		if strings.ContainsRune(arg, 0) {
+			return fmt.Errorf("args must not contain null bytes")
+		}
+	}
+	if strings.ContainsRune(d.InstallCommand, 0) {
+		return fmt.Errorf("installCommand must not contain null bytes")
+	}
+	for key, value := range d.Env {
+		if !runtimeEnvKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid env var key %q", key)
+		}
+		if strings.ContainsRune(value, 0) {
+			return fmt.Errorf("env var %s must not contain null bytes", key)
+		}
+	}
+	for _, key := range []string{d.CredentialEnvVar, d.BaseURLEnvVar} {
+		if key != "" && !runtimeEnvKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid env var key %q", key)
+		}
+	}
+	if d.BaseURL != "" {
+		u, err := url.Parse(d.BaseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("baseUrl %q must be an absolute http(s) URL", d.BaseURL)
+		}
+	}
+	return nil
+}
+
+// appendEnv adds the descriptor's env map, base URL, and credential to
+// envVars. Descriptor values replace inherited ones; the credential is set
+// last so the env map cannot override it.
+func (d *customAgentDescriptor) appendEnv(envVars []string, credential string) []string {
+	keys := make([]string, 0, len(d.Env))
+	for key := range d.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		envVars = removeEnvVar(envVars, key)
+		envVars = append(envVars, key+"="+d.Env[key])
+	}
+	if d.BaseURL != "" {
+		key := d.BaseURLEnvVar
+		if key == "" {
+			key = defaultCustomAgentBaseURLEnvVar
+		}
+		envVars = removeEnvVar(envVars, key)
+		envVars = append(envVars, key+"="+d.BaseURL)
+	}
+	if d.CredentialEnvVar != "" && credential != "" {
+		envVars = removeEnvVar(envVars, d.CredentialEnvVar)
+		envVars = append(envVars, d.CredentialEnvVar+"="+credential)
+	}
+	return envVars
+}
@@ -0,0 +1,145 @@
+package acp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveAgentCommandInfoCustomAgent(t *testing.T) {
+	t.Parallel()
+
+	cred := &agentCredential{
+		credential:     "sk-or-123",
+		credentialKind: "api-key",
+		customAgent: &customAgentDescriptor{
+			Command:          "my-acp-adapter",
+			Args:             []string{"--acp", "--model", "qwen"},
+			CredentialEnvVar: "OPENROUTER_API_KEY",
+			InstallCommand:   "npm install -g my-acp-adapter@1.0.0",
+			NpmBased:         true,
+		},
+	}
+
+	info, err := resolveAgentCommandInfo("openrouter-qwen", cred)
+	if err != nil {
+		t.Fatalf("resolveAgentCommandInfo: %v", err)
+	}
+	want := agentCommandInfo{
+		command:    "my-acp-adapter",
+		args:       []string{"--acp", "--model", "qwen"},
+		envVarName: "OPENROUTER_API_KEY",
+		installCmd: "npm install -g my-acp-adapter@1.0.0",
+		isNpmBased: true,
+	}
+	if !reflect.DeepEqual(info, want) {
+		t.Fatalf("info = %+v, want %+v", info, want)
+	}
+
+	// Built-in agents ignore descriptors.
+	info, err = resolveAgentCommandInfo("claude-code", cred)
+	if err != nil {
+		t.Fatalf("resolveAgentCommandInfo(claude-code): %v", err)
+	}
+	if info.command != "claude-agent-acp" {
+		t.Fatalf("claude-code command = %q, want claude-agent-acp", info.command)
+	}
+}
+
+func TestResolveAgentCommandInfoRejectsInvalidDescriptor(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]customAgentDescriptor{
+		"empty command":       {},
+		"shell in command":    {Command: "adapter; rm -rf /"},
+		"relative path":       {Command: "bin/adapter"},
+		"dot-dot path":        {Command: "/usr/../adapter"},
+		"bad env key":         {Command: "adapter", Env: map[string]string{"BAD-KEY": "x"}},
+		"bad credential var":  {Command: "adapter", CredentialEnvVar: "1KEY"},
+		"non-http base url":   {Command: "adapter", BaseURL: "file:///etc/passwd"},
+		"relative base url":   {Command: "adapter", BaseURL: "/v1"},
+		"null byte in arg":    {Command: "adapter", Args: []string{"a\x00b"}},
+		"null byte installer": {Command: "adapter", InstallCommand: "echo\x00"},
+	}
+	for name, desc := range tests {
+		t.Run(name, func(t *testing.T) {
+			desc := desc
+			if _, err := resolveAgentCommandInfo("custom", &agentCredential{customAgent: &desc}); err == nil {
+				t.Fatalf("expected %+v to be rejected", desc)
+			}
+		})
+	}
+}
+
+func TestCustomAgentAppendEnv(t *testing.T) {
+	t.Parallel()
+
+	desc := &customAgentDescriptor{
+		Command:          "adapter",
+		Env:              map[string]string{"OPENAI_MODEL": "llama3", "HOME_HINT": "1", "OPENROUTER_API_KEY": "from-env-map"},
+		CredentialEnvVar: "OPENROUTER_API_KEY",
+		BaseURL:          "http://localhost:11434/v1",
+	}
+	env := desc.appendEnv([]string{"PATH=/usr/bin", "OPENAI_MODEL=gpt"}, "sk-or-123")
+
+	got := map[string]string{}
+	for _, entry := range env {
+		key, value, _ := strings.Cut(entry, "=")
+		if _, dup := got[key]; dup {
+			t.Fatalf("duplicate env var %s in %v", key, env)
+		}
+		got[key] = value
+	}
+	want := map[string]string{
+		"PATH":               "/usr/bin",
+		"OPENAI_MODEL":       "llama3",
+		"HOME_HINT":          "1",
+		"OPENAI_BASE_URL":    "http://localhost:11434/v1",
+		"OPENROUTER_API_KEY": "sk-or-123",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("env = %v, want %v", got, want)
+	}
+
+	// No credential is injected when the agent takes none.
+	desc.CredentialEnvVar = ""
+	desc.BaseURLEnvVar = "OLLAMA_HOST"
+	env = desc.appendEnv(nil, "")
+	if strings.Contains(strings.Join(env, "\n"), "sk-or") || !strings.Contains(strings.Join(env, "\n"), "OLLAMA_HOST=http://localhost:11434/v1") {
+		t.Fatalf("env = %v", env)
+	}
+}
+
+func TestRequestAgentKeyAcceptsCustomAgentWithoutCredential(t *testing.T) {
+	t.Parallel()
+
+	cp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"apiKey":"","customAgent":{"command":"ollama-acp","baseUrl":"http://localhost:11434/v1"}}`))
+	}))
+	defer cp.Close()
+
+	host := NewSessionHost(SessionHostConfig{
+		GatewayConfig: GatewayConfig{
+			SessionID:       "test-session",
+			WorkspaceID:     "test-workspace",
+			ControlPlaneURL: cp.URL,
+		},
+	})
+	defer host.Stop()
+
+	cred, err := host.requestAgentKey(context.Background(), "ollama-llama3")
+	if err != nil {
+		t.Fatalf("requestAgentKey: %v", err)
+	}
+	if cred.customAgent == nil || cred.customAgent.Command != "ollama-acp" || cred.credentialKind != "api-key" {
+		t.Fatalf("cred = %+v", cred)
+	}
+
+	// A descriptor does not excuse a missing credential for built-in agents.
+	if _, err := host.requestAgentKey(context.Background(), "claude-code"); err == nil {
+		t.Fatal("expected empty claude-code credential to be rejected")
+	}
+}
@@ -0,0 +1,76 @@
+package acp
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/faultinject"
+)
+
+type recordingLauncher struct {
+	cfg ProcessConfig
+}
+
+func (l *recordingLauncher) Start(cfg ProcessConfig) (*AgentProcess, error) {
+	l.cfg = cfg
+	return nil, errors.New("launcher not available in tests")
+}
+
+func TestStartAgentProcessInjectedRapidExit(t *testing.T) {
+	t.Cleanup(faultinject.Reset)
+	if err := faultinject.Configure("agent.start=1"); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+
+	launcher := &recordingLauncher{}
+	host := NewSessionHost(SessionHostConfig{GatewayConfig: GatewayConfig{ProcessLauncher: launcher}})
+	startup := &agentStartup{info: agentCommandInfo{command: "claude-agent-acp", args: []string{"--acp"}}}
+
+	_, _ = host.startAgentProcess(startup)
+	if launcher.cfg.AcpCommand != "sh" {
+		t.Fatalf("injected start command = %q, want sh", launcher.cfg.AcpCommand)
+	}
+
+	_, _ = host.startAgentProcess(startup)
+	if launcher.cfg.AcpCommand != "claude-agent-acp" {
+		t.Fatalf("command after fault exhausted = %q, want claude-agent-acp", launcher.cfg.AcpCommand)
+	}
+}
+
+func TestViewerWritePumpInjectedWriteFailure(t *testing.T) {
+	t.Cleanup(faultinject.Reset)
+	if err := faultinject.Configure("ws.write=1"); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+
+	host := newTestSessionHost(t)
+	defer host.Stop()
+
+	serverConn, _ := testWSPair(t)
+	viewer := host.AttachViewer("v1", serverConn)
+	if viewer == nil {
+		t.Fatal("AttachViewer returned nil")
+	}
+
+	select {
+	case <-viewer.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected injected write failure to close the viewer")
+	}
+	if got := faultinject.Injected(faultinject.WebSocketWrite); got != 1 {
+		t.Fatalf("Injected(WebSocketWrite) = %d, want 1", got)
+	}
+}
+
+func TestExecInContainerInjectedTimeout(t *testing.T) {
+	t.Cleanup(faultinject.Reset)
+	if err := faultinject.Configure("docker.exec=1"); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+
+	_, _, err := execInContainer(t.Context(), "container", "", "", "true")
+	if !errors.Is(err, faultinject.ErrInjected) {
+		t.Fatalf("expected injected exec failure, got %v", err)
+	}
+}
@@ -12,7 +12,7 @@ func intPtr(n int) *int {
 	return &n
 }
 
-func TestApplyLineLimit(t *testing.T) {
+func TestSelectLines(t *testing.T) {
 	content := "line1\nline2\nline3\nline4\nline5"
 
 	tests := []struct {
@@ -96,7 +96,10 @@ func TestApplyLineLimit(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := applyLineLimit(tt.content, tt.line, tt.limit)
+			result, _, err := selectLines(strings.NewReader(tt.content), tt.line, tt.limit, len(tt.content))
+			if err != nil {
+				t.Fatalf("selectLines() error = %v", err)
+			}
 			if result != tt.expected {
 				t.Errorf("expected %q, got %q", tt.expected, result)
 			}
@@ -230,7 +233,7 @@ func TestWriteTextFileValidation(t *testing.T) {
 		}
 	})
 
-	t.Run("default max size is 1MB", func(t *testing.T) {
+	t.Run("default max size is 4MB", func(t *testing.T) {
 		clientNoLimit := &sessionHostClient{
 			host: &SessionHost{
 				config: SessionHostConfig{
@@ -238,12 +241,12 @@ func TestWriteTextFileValidation(t *testing.T) {
 						ContainerResolver: func() (string, error) {
 							return "test-container", nil
 						},
-						// FileMaxSize not set — should default to 1048576 (1MB)
+						// FileMaxSize not set — should default to 4MB
 					},
 				},
 			},
 		}
-		// Content under 1MB should pass size validation (will fail at docker exec)
+		// Content under 4MB should pass size validation (will fail at docker exec)
 		_, err := clientNoLimit.WriteTextFile(t.Context(), acpsdk.WriteTextFileRequest{
 			Path:    "/tmp/test.txt",
 			Content: "small content",
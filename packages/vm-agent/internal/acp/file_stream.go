@@ -0,0 +1,273 @@
+package acp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/workspace/vm-agent/internal/containerruntime"
+	"github.com/workspace/vm-agent/internal/faultinject"
+)
+
+// _meta keys that extend fs/read_text_file and fs/write_text_file. Offsets
+// and lengths are in bytes.
+const (
+	// MetaEncodingKey selects the content encoding, "text" or "base64", of a
+	// read response or write request. Binary files need "base64".
+	MetaEncodingKey = "sam.encoding"
+	// MetaMIMETypeKey carries the sniffed media type of a base64 read.
+	MetaMIMETypeKey = "sam.mimeType"
+	// MetaOffsetKey makes fs/read_text_file read from this byte offset.
+	MetaOffsetKey = "sam.offset"
+	// MetaLengthKey bounds a byte-range read to this many bytes.
+	MetaLengthKey = "sam.length"
+	// MetaAppendKey makes fs/write_text_file append its content to the file
+	// instead of replacing it, so a large file can be written in chunks.
+	MetaAppendKey = "sam.append"
+)
+
+const (
+	// defaultFileMaxSize is the default for FileMaxSize.
+	defaultFileMaxSize = 4 << 20
+	// maxFileChunkSize caps FileMaxSize: file content travels in a single
+	// JSON-RPC line, which the ACP SDK limits to 10 MB.
+	maxFileChunkSize = 8 << 20
+)
+
+// fileMaxSize is the most file content one fs/read_text_file returns or one
+// fs/write_text_file accepts.
+func (c *sessionHostClient) fileMaxSize() int {
+	maxSize := c.host.config.FileMaxSize
+	if maxSize <= 0 {
+		maxSize = defaultFileMaxSize
+	}
+	return min(maxSize, maxFileChunkSize)
+}
+
+// fileByteRange is a byte range requested through _meta. length is -1 when
+// the range runs to the end of the file.
+type fileByteRange struct {
+	offset int64
+	length int64
+}
+
+// metaFileByteRange reads a byte range from a request's _meta. ok is false
+// when the request has no offset.
+func metaFileByteRange(meta map[string]any) (rng fileByteRange, ok bool, err error) {
+	offset, hasOffset, err := metaInt(meta, MetaOffsetKey)
+	if err != nil {
+		return fileByteRange{}, false, err
+	}
+	length, hasLength, err := metaInt(meta, MetaLengthKey)
+	if err != nil {
+		return fileByteRange{}, false, err
+	}
+	if !hasOffset {
+		if hasLength {
+			return fileByteRange{}, false, fmt.Errorf("%s requires %s", MetaLengthKey, MetaOffsetKey)
+		}
+		return fileByteRange{}, false, nil
+	}
+	if !hasLength {
+		length = -1
+	}
+	return fileByteRange{offset: offset, length: length}, true, nil
+}
+
+// metaInt reads a non-negative integer from _meta. JSON numbers decode as
+// float64.
+func metaInt(meta map[string]any, key string) (int64, bool, error) {
+	raw, ok := meta[key]
+	if !ok || raw == nil {
+		return 0, false, nil
+	}
+	number, ok := raw.(float64)
+	if !ok || number < 0 || number != math.Trunc(number) || number > math.MaxInt64/2 {
+		return 0, false, fmt.Errorf("_meta %s must be a non-negative integer", key)
+	}
+	return int64(number), true, nil
+}
+
+// metaString reads a string from _meta; anything else is empty.
+func metaString(meta map[string]any, key string) string {
+	value, _ := meta[key].(string)
+	return value
+}
+
+// metaBool reads a boolean from _meta; anything but true is false.
+func metaBool(meta map[string]any, key string) bool {
+	value, _ := meta[key].(bool)
+	return value
+}
+
+// containerStream is the stdout of a command in the container, read as it
+// is produced.
+type containerStream struct {
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	stderr bytes.Buffer
+	cancel context.CancelFunc
+}
+
+func startContainerStream(ctx context.Context, containerID, user string, args ...string) (*containerStream, error) {
+	if err := faultinject.Check(faultinject.DockerExec); err != nil {
+		return nil, fmt.Errorf("command failed: %w", errors.Join(err, context.DeadlineExceeded))
+	}
+	rt := containerruntime.Current()
+	ctx, cancel := context.WithCancel(ctx)
+	s := &containerStream{cmd: rt.Command(ctx, rt.ExecArgs(containerID, containerruntime.ExecOptions{User: user}, args...)...), cancel: cancel}
+	s.cmd.Stderr = &s.stderr
+	stdout, err := s.cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("command failed: %w", err)
+	}
+	s.stdout = stdout
+	if err := s.cmd.Start(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("command failed: %w", err)
+	}
+	return s, nil
+}
+
+func (s *containerStream) Read(p []byte) (int, error) {
+	return s.stdout.Read(p)
+}
+
+// finish waits for the command. With stop set, output is no longer needed:
+// the command is killed and its exit status ignored.
+func (s *containerStream) finish(stop bool) (stderr string, err error) {
+	if stop {
+		s.cancel()
+	}
+	_, _ = io.Copy(io.Discard, s.stdout)
+	err = s.cmd.Wait()
+	s.cancel()
+	if stop {
+		err = nil
+	}
+	if err != nil {
+		err = fmt.Errorf("command failed: %w", err)
+	}
+	return strings.TrimSpace(s.stderr.String()), err
+}
+
+// readContainerFile streams path from the container and returns the part the
+// request asks for: a byte range given in _meta, the lines given by line and
+// limit, or the whole file. The file itself may be of any size; what is
+// returned is capped at maxSize.
+func readContainerFile(ctx context.Context, containerID, user string, params readFileParams, maxSize int) (string, error) {
+	args := []string{"cat", params.path}
+	if params.byteRange {
+		args = []string{"tail", "-c", "+" + strconv.FormatInt(params.rng.offset+1, 10), params.path}
+	}
+	stream, err := startContainerStream(ctx, containerID, user, args...)
+	if err != nil {
+		return "", err
+	}
+
+	var content string
+	var stop bool
+	var selectErr error
+	switch {
+	case params.byteRange:
+		content, stop, selectErr = selectBytes(stream, params.rng.length, maxSize)
+	case params.line != nil || params.limit != nil:
+		content, stop, selectErr = selectLines(stream, params.line, params.limit, maxSize)
+	default:
+		content, stop, selectErr = selectBytes(stream, -1, maxSize)
+	}
+	stderr, err := stream.finish(stop || selectErr != nil)
+	if err != nil {
+		if stderr != "" {
+			err = fmt.Errorf("%w: %s", err, stderr)
+		}
+		return "", err
+	}
+	return content, selectErr
+}
+
+// readFileParams is what ReadTextFile needs of a request.
+type readFileParams struct {
+	path      string
+	line      *int
+	limit     *int
+	byteRange bool
+	rng       fileByteRange
+}
+
+// errFileTooLarge is returned when the requested part of a file is larger
+// than one request may return.
+var errFileTooLarge = errors.New("exceeds maximum size")
+
+// selectBytes reads length bytes from r, or all of it when length is -1.
+// stop reports that r was not read to the end.
+func selectBytes(r io.Reader, length int64, maxSize int) (content string, stop bool, err error) {
+	if length > int64(maxSize) {
+		return "", true, errFileTooLarge
+	}
+	want := length
+	if want < 0 {
+		want = int64(maxSize) + 1
+	}
+	data, err := io.ReadAll(io.LimitReader(r, want))
+	if err != nil {
+		return "", true, err
+	}
+	if length < 0 && len(data) > maxSize {
+		return "", true, errFileTooLarge
+	}
+	return string(data), int64(len(data)) == want, nil
+}
+
+// selectLines returns limit lines of r from the 1-based line, reading no
+// further than it needs. A nil or non-positive limit reads to the end. When
+// the limit ends the read, the last line's newline is dropped.
+func selectLines(r io.Reader, line, limit *int, maxSize int) (content string, stop bool, err error) {
+	reader := bufio.NewReader(r)
+	if line != nil {
+		for skip := *line - 1; skip > 0; skip-- {
+			if _, err := reader.ReadSlice('\n'); err != nil {
+				if errors.Is(err, bufio.ErrBufferFull) {
+					skip++ // the rest of a long line
+					continue
+				}
+				if err == io.EOF {
+					return "", false, nil
+				}
+				return "", true, err
+			}
+		}
+	}
+
+	var out strings.Builder
+	for taken := 0; limit == nil || *limit <= 0 || taken < *limit; taken++ {
+		for {
+			// ReadSlice hands back at most one buffer of a long line, so a
+			// line bigger than maxSize fails before it is held in memory.
+			chunk, err := reader.ReadSlice('\n')
+			if out.Len()+len(chunk) > maxSize {
+				return "", true, errFileTooLarge
+			}
+			out.Write(chunk)
+			if errors.Is(err, bufio.ErrBufferFull) {
+				continue
+			}
+			if err == io.EOF {
+				return out.String(), false, nil
+			}
+			if err != nil {
+				return "", true, err
+			}
+			break
+		}
+	}
+	return strings.TrimSuffix(out.String(), "\n"), true, nil
+}
@@ -0,0 +1,224 @@
+package acp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	acpsdk "github.com/coder/acp-go-sdk"
+)
+
+func TestMetaFileByteRange(t *testing.T) {
+	rng, ok, err := metaFileByteRange(map[string]any{MetaOffsetKey: float64(10), MetaLengthKey: float64(5)})
+	if err != nil || !ok || rng != (fileByteRange{offset: 10, length: 5}) {
+		t.Fatalf("offset and length: rng=%+v ok=%v err=%v", rng, ok, err)
+	}
+	rng, ok, err = metaFileByteRange(map[string]any{MetaOffsetKey: float64(0)})
+	if err != nil || !ok || rng.length != -1 {
+		t.Fatalf("offset only: rng=%+v ok=%v err=%v", rng, ok, err)
+	}
+	if _, ok, err := metaFileByteRange(nil); err != nil || ok {
+		t.Fatalf("no meta: ok=%v err=%v", ok, err)
+	}
+
+	for name, meta := range map[string]map[string]any{
+		"negative offset":       {MetaOffsetKey: float64(-1)},
+		"fractional length":     {MetaOffsetKey: float64(0), MetaLengthKey: 1.5},
+		"string offset":         {MetaOffsetKey: "10"},
+		"length without offset": {MetaLengthKey: float64(5)},
+	} {
+		if _, _, err := metaFileByteRange(meta); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestSelectLinesReadsOnlyWhatItNeeds(t *testing.T) {
+	content := strings.Repeat("x", 100) + "\nkeep\nrest\n"
+
+	got, stop, err := selectLines(strings.NewReader(content), intPtr(2), intPtr(1), 10)
+	if err != nil || got != "keep" || !stop {
+		t.Fatalf("selectLines() = %q, stop=%v, err=%v", got, stop, err)
+	}
+
+	if _, _, err := selectLines(strings.NewReader(content), nil, intPtr(1), 10); !errors.Is(err, errFileTooLarge) {
+		t.Fatalf("selectLines() over max size error = %v, want errFileTooLarge", err)
+	}
+}
+
+// endlessLine is a reader with one line that never ends.
+type endlessLine struct{}
+
+func (endlessLine) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'x'
+	}
+	return len(p), nil
+}
+
+func TestSelectLinesStopsInsideLineLargerThanMaxSize(t *testing.T) {
+	if _, _, err := selectLines(endlessLine{}, nil, intPtr(1), 64*1024); !errors.Is(err, errFileTooLarge) {
+		t.Fatalf("selectLines() error = %v, want errFileTooLarge", err)
+	}
+
+	content := strings.Repeat("x", 10_000) + "\nkeep\n"
+	got, _, err := selectLines(strings.NewReader(content), nil, intPtr(2), 20_000)
+	if err != nil || got != strings.Repeat("x", 10_000)+"\nkeep" {
+		t.Fatalf("selectLines() across buffer-sized chunks = %d bytes, err=%v", len(got), err)
+	}
+}
+
+// useMockDocker puts a docker CLI on PATH that runs "exec [FLAGS] CONTAINER
+// CMD ARGS..." on the host.
+func useMockDocker(t *testing.T, dir string) {
+	t.Helper()
+	mockDocker := filepath.Join(dir, "docker")
+	script := `#!/bin/sh
+shift
+while :; do
+  case "$1" in
+    -i|-t) shift ;;
+    -u|-w|-e) shift 2 ;;
+    *) break ;;
+  esac
+done
+shift
+exec "$@"
+`
+	if err := os.WriteFile(mockDocker, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestReadTextFileInParts(t *testing.T) {
+	dir := t.TempDir()
+	useMockDocker(t, dir)
+	target := filepath.Join(dir, "data.txt")
+	var content strings.Builder
+	for i := range 100 {
+		content.WriteString(strings.Repeat(string(rune('a'+i%26)), 9) + "\n")
+	}
+	if err := os.WriteFile(target, []byte(content.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	host := newTestSessionHost(t)
+	host.config.ContainerResolver = func() (string, error) { return "container", nil }
+	host.config.FileMaxSize = 100
+	client := &sessionHostClient{host: host}
+
+	_, err := client.ReadTextFile(t.Context(), acpsdk.ReadTextFileRequest{Path: target})
+	if err == nil || !strings.Contains(err.Error(), "exceeds maximum size") {
+		t.Fatalf("whole-file read error = %v, want exceeds maximum size", err)
+	}
+
+	resp, err := client.ReadTextFile(t.Context(), acpsdk.ReadTextFileRequest{
+		Path: target,
+		Meta: map[string]any{MetaOffsetKey: float64(990), MetaLengthKey: float64(20)},
+	})
+	if err != nil || resp.Content != "vvvvvvvvv\n" {
+		t.Fatalf("byte-range read = %q, err=%v", resp.Content, err)
+	}
+
+	resp, err = client.ReadTextFile(t.Context(), acpsdk.ReadTextFileRequest{Path: target, Line: intPtr(27), Limit: intPtr(2)})
+	if err != nil || resp.Content != "aaaaaaaaa\nbbbbbbbbb" {
+		t.Fatalf("line read = %q, err=%v", resp.Content, err)
+	}
+
+	_, err = client.ReadTextFile(t.Context(), acpsdk.ReadTextFileRequest{Path: filepath.Join(dir, "missing.txt"), Limit: intPtr(1)})
+	if err == nil || !strings.Contains(err.Error(), "No such file") {
+		t.Fatalf("missing file error = %v", err)
+	}
+}
+
+func TestWriteTextFileAppendsChunks(t *testing.T) {
+	dir := t.TempDir()
+	useMockDocker(t, dir)
+	target := filepath.Join(dir, "big.lock")
+
+	host := newTestSessionHost(t)
+	host.config.ContainerResolver = func() (string, error) { return "container", nil }
+	host.config.FileMaxSize = 8
+	client := &sessionHostClient{host: host}
+
+	if _, err := client.WriteTextFile(t.Context(), acpsdk.WriteTextFileRequest{Path: target, Content: "chunk1\n"}); err != nil {
+		t.Fatalf("first chunk: %v", err)
+	}
+	for _, chunk := range []string{"chunk2\n", "chunk3\n"} {
+		if _, err := client.WriteTextFile(t.Context(), acpsdk.WriteTextFileRequest{
+			Path:    target,
+			Content: chunk,
+			Meta:    map[string]any{MetaAppendKey: true},
+		}); err != nil {
+			t.Fatalf("append %q: %v", chunk, err)
+		}
+	}
+
+	got, err := os.ReadFile(target)
+	if err != nil || string(got) != "chunk1\nchunk2\nchunk3\n" {
+		t.Fatalf("file = %q, err=%v", got, err)
+	}
+
+	edits := 0
+	messages, _, _ := host.replaySince(0)
+	for _, buffered := range messages {
+		var msg FileEditMessage
+		if json.Unmarshal(buffered.Data, &msg) == nil && msg.Type == MsgFileEdit {
+			edits++
+		}
+	}
+	if edits != 1 {
+		t.Fatalf("file_edit messages = %d, want 1 for the first write only", edits)
+	}
+}
+
+func TestBinaryFileRoundTripsAsBase64(t *testing.T) {
+	dir := t.TempDir()
+	useMockDocker(t, dir)
+	target := filepath.Join(dir, "icon.png")
+	png := "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x10"
+
+	host := newTestSessionHost(t)
+	host.config.ContainerResolver = func() (string, error) { return "container", nil }
+	client := &sessionHostClient{host: host}
+
+	if _, err := client.WriteTextFile(t.Context(), acpsdk.WriteTextFileRequest{
+		Path:    target,
+		Content: base64.StdEncoding.EncodeToString([]byte(png)),
+		Meta:    map[string]any{MetaEncodingKey: "base64"},
+	}); err != nil {
+		t.Fatalf("base64 write: %v", err)
+	}
+	if got, err := os.ReadFile(target); err != nil || string(got) != png {
+		t.Fatalf("file = %q, err=%v", got, err)
+	}
+
+	_, err := client.ReadTextFile(t.Context(), acpsdk.ReadTextFileRequest{Path: target})
+	if err == nil || !strings.Contains(err.Error(), "is binary (image/png)") {
+		t.Fatalf("text read of binary file error = %v", err)
+	}
+
+	resp, err := client.ReadTextFile(t.Context(), acpsdk.ReadTextFileRequest{Path: target, Meta: map[string]any{MetaEncodingKey: "base64"}})
+	if err != nil {
+		t.Fatalf("base64 read: %v", err)
+	}
+	if decoded, _ := base64.StdEncoding.DecodeString(resp.Content); string(decoded) != png {
+		t.Fatalf("base64 read = %q", resp.Content)
+	}
+	if resp.Meta[MetaEncodingKey] != "base64" || resp.Meta[MetaMIMETypeKey] != "image/png" {
+		t.Fatalf("response _meta = %v", resp.Meta)
+	}
+
+	if _, err := client.WriteTextFile(t.Context(), acpsdk.WriteTextFileRequest{
+		Path:    target,
+		Content: "not base64!",
+		Meta:    map[string]any{MetaEncodingKey: "base64"},
+	}); err == nil || !strings.Contains(err.Error(), "invalid base64") {
+		t.Fatalf("invalid base64 write error = %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -16,6 +17,11 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/workspace/vm-agent/internal/containerruntime"
+	"github.com/workspace/vm-agent/internal/execaudit"
+	"github.com/workspace/vm-agent/internal/faultinject"
+	"github.com/workspace/vm-agent/internal/persistence"
+	"github.com/workspace/vm-agent/internal/retry"
 )
 
 const localShellPath = "/bin/sh"
@@ -59,6 +65,15 @@ type TabLastPromptUpdater interface {
 	UpdateTabLastPrompt(tabID, lastPrompt string) error
 }
 
+// TabMessageStore persists a chat tab's replay buffer to the SQLite
+// persistence store so late-join replay survives VM agent restarts.
+type TabMessageStore interface {
+	// AppendTabMessages stores messages and evicts all but the newest keep.
+	AppendTabMessages(workspaceID, tabID string, messages []persistence.TabMessage, keep int) error
+	// ListTabMessages returns the persisted buffer, oldest first.
+	ListTabMessages(tabID string) ([]persistence.TabMessage, error)
+}
+
 // SessionLastPromptUpdater persists the last user prompt in the in-memory session manager.
 type SessionLastPromptUpdater interface {
 	// UpdateLastPrompt stores the last user message for a session.
@@ -76,6 +91,29 @@ type CredentialSyncer interface {
 	SyncCredential(ctx context.Context, workspaceID, agentType, credentialKind, credential string) error
 }
 
+// OfflineCache stores last-known control-plane responses (agent credentials
+// and settings) so sessions can start while the control plane is unreachable.
+type OfflineCache interface {
+	// SaveOffline replaces the cached payload for the workspace, agent type and kind.
+	SaveOffline(workspaceID, agentType, kind string, payload []byte) error
+	// LoadOffline returns the cached payload and when it was stored.
+	// Returns nil payload and no error when nothing is cached.
+	LoadOffline(workspaceID, agentType, kind string) ([]byte, time.Time, error)
+}
+
+// ControlPlaneStatusReporter is notified when a session falls back to cached
+// data because the control plane could not be reached.
+type ControlPlaneStatusReporter interface {
+	ReportControlPlaneUnreachable(reason string)
+}
+
+// CallbackOutbox durably queues non-critical control-plane callbacks that
+// could not be delivered, so they are retried once the control plane is
+// reachable again. path is relative to the control-plane URL.
+type CallbackOutbox interface {
+	EnqueueCallback(workspaceID, path string, payload []byte) error
+}
+
 // MessageReporter enqueues chat messages for batched delivery to the control plane.
 // All methods must be nil-safe (a nil reporter is a no-op).
 type MessageReporter interface {
@@ -133,6 +171,13 @@ type GatewayConfig struct {
 	// ProcessLauncher starts ACP subprocesses. Nil uses Docker exec, preserving
 	// the traditional VM/devcontainer path.
 	ProcessLauncher ProcessLauncher
+	// StdioReattach runs docker exec agents behind a stdio supervisor that
+	// re-attaches to the still-running process when the exec pipe breaks,
+	// instead of restarting the agent. Ignored when ProcessLauncher is set.
+	StdioReattach bool
+	// StdioReattachTimeout bounds re-attach attempts before falling back to a
+	// full restart. Zero uses DefaultStdioReattachTimeout.
+	StdioReattachTimeout time.Duration
 	// GitTokenFetcher returns a fresh GitHub installation token for the
 	// workspace. It is called at ACP session start to inject GH_TOKEN into
 	// the agent process. If nil or returns error, GH_TOKEN is omitted.
@@ -154,7 +199,9 @@ type GatewayConfig struct {
 	TabStore TabSessionUpdater
 	// FileExecTimeout is the timeout for file read/write operations via docker exec.
 	FileExecTimeout time.Duration
-	// FileMaxSize is the maximum file size in bytes for read operations.
+	// FileMaxSize is the most file content in bytes one read returns or one
+	// write accepts; larger files are read in ranges and written in appended
+	// chunks. Capped below the ACP message size limit.
 	FileMaxSize int
 	// ErrorReporter sends structured error entries to CF Workers observability.
 	// Agent errors (crashes, install failures, prompt failures) are reported here.
@@ -167,6 +214,12 @@ type GatewayConfig struct {
 	PongTimeout time.Duration
 	// PromptTimeout bounds how long a prompt can run before force-stop fallback.
 	PromptTimeout time.Duration
+	// AdaptivePromptTimeout replaces PromptTimeout with a timeout derived from
+	// historical prompt durations per agent type. Zero value disables it.
+	AdaptivePromptTimeout AdaptivePromptTimeout
+	// PromptTimeoutOverrideMax caps a client-supplied per-prompt timeout
+	// ("timeoutSeconds" in session/prompt params). Zero ignores overrides.
+	PromptTimeoutOverrideMax time.Duration
 	// PromptCancelGracePeriod waits after cancel before force-stopping unresponsive prompt.
 	PromptCancelGracePeriod time.Duration
 	// PromptRetryMaxRetries bounds transient provider prompt retries after the initial attempt.
@@ -175,6 +228,10 @@ type GatewayConfig struct {
 	PromptRetryInitialDelay time.Duration
 	// PromptRetryMaxDelay caps exponential backoff for transient provider prompt retries.
 	PromptRetryMaxDelay time.Duration
+	// AgentKeyRetry retries agent credential fetches that fail because the
+	// control plane is unreachable, before falling back to the offline cache.
+	// Zero makes a single attempt.
+	AgentKeyRetry retry.Policy
 	// PromptRetrySleeper is injectable for tests. Nil uses time.Sleep with context cancellation.
 	PromptRetrySleeper func(context.Context, time.Duration) error
 	// ActivityRereportInterval refreshes prompt activity while a prompt is active.
@@ -193,8 +250,14 @@ type GatewayConfig struct {
 	RestartDecayWindow time.Duration
 	// TabLastPromptStore persists the last user prompt to SQLite for session discoverability.
 	TabLastPromptStore TabLastPromptUpdater
+	// TabMessageStore persists the replay buffer to SQLite. When set, a new
+	// SessionHost rebuilds its buffer from it. Nil keeps the buffer in memory.
+	TabMessageStore TabMessageStore
 	// SessionLastPromptManager persists the last user prompt in the in-memory session manager.
 	SessionLastPromptManager SessionLastPromptUpdater
+	// AgentAdmission enforces workspace agent quotas before an agent selection
+	// starts a process. When nil, agents start without a quota check.
+	AgentAdmission AgentAdmitter
 	// IdleSuspendTimeout is how long a session can be idle with no viewers before
 	// being automatically suspended. Zero disables auto-suspend.
 	IdleSuspendTimeout time.Duration
@@ -208,6 +271,14 @@ type GatewayConfig struct {
 	// Used by task-driven workspaces to report completion back to the control plane.
 	// When nil, no callback fires. The string arg is the stop reason (e.g. "end_turn", "error").
 	OnPromptComplete func(stopReason string, promptErr error)
+	// OnPromptStart is called synchronously before a prompt is sent to the
+	// agent. The server uses it to checkpoint the working tree so the prompt's
+	// edits can be rolled back. When nil, nothing is called.
+	OnPromptStart func(workspaceID, sessionID, messageID string)
+	// OnPromptDuration is called with the wall-clock duration of every prompt
+	// that ran to completion. The server feeds it into the /metrics histogram.
+	// stopReason is "error" when the prompt failed. When nil, nothing is recorded.
+	OnPromptDuration func(agentType, stopReason string, duration time.Duration)
 	// SAMEnvFallback provides fallback SAM environment variables (KEY=value pairs)
 	// injected into ACP sessions when the bootstrap-written /etc/sam/env file is
 	// missing or incomplete. Built from the vm-agent's own config at startup.
@@ -215,6 +286,18 @@ type GatewayConfig struct {
 	// CredentialSyncer syncs updated file-based credentials (e.g. auth.json)
 	// back to the control plane after a session ends. When nil, no sync occurs.
 	CredentialSyncer CredentialSyncer
+	// OfflineCache holds last-known agent credentials and settings used when
+	// the control plane is unreachable. When nil, no fallback occurs.
+	OfflineCache OfflineCache
+	// OfflineCacheMaxAge bounds how old a cached entry may be and still be
+	// used. Zero means no limit.
+	OfflineCacheMaxAge time.Duration
+	// ControlPlaneStatus is told when a session falls back to cached data so
+	// viewers can be marked as operating offline. When nil, nothing is reported.
+	ControlPlaneStatus ControlPlaneStatusReporter
+	// CallbackOutbox receives usage reports that failed every delivery
+	// attempt. When nil, undeliverable reports are dropped.
+	CallbackOutbox CallbackOutbox
 	// McpServers are MCP server configs to inject into ACP sessions.
 	// When non-empty, these are converted to acpsdk.McpServer entries
 	// and passed in NewSession/LoadSession requests.
@@ -261,12 +344,20 @@ type Gateway struct {
 	// The read loop selects on this to exit immediately instead of waiting for
 	// the read deadline (40s) to expire.
 	viewerDone <-chan struct{}
+	// onDismissAnnouncement is invoked when the viewer dismisses a
+	// workspace announcement. Nil ignores dismissals.
+	onDismissAnnouncement func(announcementID string)
+	// onDrive is invoked when the viewer sends a prompt or cancels one.
+	// Nil ignores drive actions.
+	onDrive func()
 
 	mu     sync.Mutex
 	closed bool
 }
 
 // NewGateway creates a new Gateway that relays WebSocket messages to a SessionHost.
+// conn may be nil for transports that deliver viewer messages via Dispatch
+// instead of Run, such as the HTTP long-poll fallback.
 func NewGateway(host *SessionHost, conn *websocket.Conn, viewerID string, viewerDone <-chan struct{}) *Gateway {
 	return &Gateway{
 		host:       host,
@@ -276,6 +367,18 @@ func NewGateway(host *SessionHost, conn *websocket.Conn, viewerID string, viewer
 	}
 }
 
+// SetAnnouncementDismissHandler registers the callback invoked when this
+// viewer dismisses a workspace announcement. Must be called before Run.
+func (g *Gateway) SetAnnouncementDismissHandler(fn func(announcementID string)) {
+	g.onDismissAnnouncement = fn
+}
+
+// SetDriveHandler registers the callback invoked when this viewer drives the
+// agent by sending a prompt or cancelling one. Must be called before Run.
+func (g *Gateway) SetDriveHandler(fn func()) {
+	g.onDrive = fn
+}
+
 // Close terminates the gateway by closing the underlying WebSocket connection.
 // This causes Run() to return. The agent process is NOT stopped.
 func (g *Gateway) Close() {
@@ -283,6 +386,9 @@ func (g *Gateway) Close() {
 	g.closed = true
 	g.mu.Unlock()
 
+	if g.conn == nil {
+		return
+	}
 	g.conn.WriteControl(
 		websocket.CloseMessage,
 		websocket.FormatCloseMessage(websocket.CloseGoingAway, "connection closed"),
@@ -396,13 +502,25 @@ func (g *Gateway) Run(ctx context.Context) error {
 	}
 }
 
+// Dispatch routes one viewer message to the SessionHost, exactly as if it had
+// arrived on the WebSocket. Used by transports without a read loop.
+func (g *Gateway) Dispatch(ctx context.Context, data []byte) {
+	g.handleMessage(ctx, data)
+}
+
 // handleMessage parses a WebSocket message and routes it to the SessionHost.
 func (g *Gateway) handleMessage(ctx context.Context, data []byte) {
-	// Check for control messages (select_agent, ping)
+	// Check for control messages (select_agent, permission_response, ping)
 	var control struct {
 		Type string `json:"type"`
 	}
 	if err := json.Unmarshal(data, &control); err == nil {
+		switch ControlMessageType(control.Type) {
+		case MsgSelectAgent, MsgRefreshCredential:
+			if g.host.rejectReadOnlyViewer(g.viewerID, control.Type, nil) {
+				return
+			}
+		}
 		switch ControlMessageType(control.Type) {
 		case MsgSelectAgent:
 			var selectMsg SelectAgentMessage
@@ -410,6 +528,25 @@ func (g *Gateway) handleMessage(ctx context.Context, data []byte) {
 				go g.host.SelectAgent(ctx, selectMsg.AgentType)
 			}
 			return
+		case MsgDismissAnnouncement:
+			var dismissMsg DismissAnnouncementMessage
+			if err := json.Unmarshal(data, &dismissMsg); err == nil && dismissMsg.AnnouncementID != "" && g.onDismissAnnouncement != nil {
+				g.onDismissAnnouncement(dismissMsg.AnnouncementID)
+			}
+			return
+		case MsgRefreshCredential:
+			go func() {
+				if err := g.host.RefreshCredential(ctx); err != nil {
+					slog.Warn("Gateway: agent credential refresh failed", "viewerID", g.viewerID, "error", err)
+				}
+			}()
+			return
+		case MsgPermissionResponse:
+			var permMsg PermissionResponseMessage
+			if err := json.Unmarshal(data, &permMsg); err == nil && permMsg.RequestID != "" {
+				g.host.HandlePermissionResponse(g.viewerID, permMsg)
+			}
+			return
 		case MsgPing:
 			// Application-level keepalive: respond with pong via the viewer's
 			// send channel so the message flows through the same write path as
@@ -432,14 +569,25 @@ func (g *Gateway) handleMessage(ctx context.Context, data []byte) {
 		return
 	}
 
+	// Read-only viewers only watch: every JSON-RPC message would drive the
+	// agent, so none are relayed.
+	if g.host.rejectReadOnlyViewer(g.viewerID, rpcMsg.Method, rpcMsg.ID) {
+		return
+	}
+
+	if g.onDrive != nil && (rpcMsg.Method == "session/prompt" || rpcMsg.Method == "session/cancel") {
+		g.onDrive()
+	}
+
 	switch rpcMsg.Method {
 	case "session/prompt":
 		go g.host.HandlePrompt(ctx, rpcMsg.ID, rpcMsg.Params, g.viewerID, false)
 	case "session/cancel":
 		// Cancel the in-flight prompt context. Also forward to agent stdin
 		// so the agent process itself can react to the cancellation signal.
+		g.host.cancelCompanionPrompts(PromptCancellation{Initiator: CancelByUser})
 		if g.host.AgentType() == "opencode" {
-			g.host.cancelPrompt(false)
+			g.host.cancelPrompt(PromptCancellation{Initiator: CancelByUser}, false)
 			g.host.StopProcessForPromptCancel()
 		} else {
 			g.host.CancelPrompt()
@@ -467,6 +615,9 @@ type agentCredential struct {
 	// enabled and the user has no dedicated agent key). OpenCode is always
 	// bring-your-own-key and never uses these.
 	inferenceConfig *inferenceConfig
+	// customAgent describes how to launch an agent type outside the built-in
+	// catalog. Ignored for built-in agent types.
+	customAgent *customAgentDescriptor
 }
 
 // inferenceConfig holds platform AI proxy configuration returned by the control plane
@@ -489,6 +640,8 @@ type agentSettingsPayload struct {
 	Effort           string `json:"effort"`
 	OpencodeProvider string `json:"opencodeProvider"`
 	OpencodeBaseURL  string `json:"opencodeBaseUrl"`
+	// McpServers are extra MCP servers for the agent, keyed by name.
+	McpServers McpServerSpecs `json:"mcpServers,omitempty"`
 }
 
 // truncate limits a string to maxLen characters, appending "..." if truncated.
@@ -499,51 +652,46 @@ func truncate(s string, maxLen int) string {
 	return s[:maxLen] + "..."
 }
 
-// applyLineLimit applies Line and Limit parameters to file content for partial reads.
-// Line is 1-based. Returns the selected portion of content.
-func applyLineLimit(content string, line *int, limit *int) string {
-	if line == nil && limit == nil {
-		return content
+// execInContainer runs a command inside a devcontainer and returns stdout.
+// A non-zero exit is reported by status only; callers add stderr themselves.
+func execInContainer(ctx context.Context, containerID, user, workDir string, args ...string) (stdout string, stderr string, err error) {
+	if err := faultinject.Check(faultinject.DockerExec); err != nil {
+		return "", "", fmt.Errorf("command failed: %w", errors.Join(err, context.DeadlineExceeded))
 	}
-	lines := strings.Split(content, "\n")
-	startLine := 0
-	if line != nil && *line > 1 {
-		startLine = *line - 1
-		if startLine >= len(lines) {
-			return ""
-		}
-		lines = lines[startLine:]
+
+	result, err := containerruntime.Current().Exec(ctx, containerID, containerruntime.ExecOptions{User: user, WorkDir: workDir}, args...)
+	if result == nil {
+		return "", "", fmt.Errorf("command failed: %w", err)
 	}
-	if limit != nil && *limit > 0 && *limit < len(lines) {
-		lines = lines[:*limit]
+	stderr = strings.TrimSpace(string(result.Stderr))
+	if err != nil {
+		return "", stderr, fmt.Errorf("command failed: exit status %d", result.ExitCode)
 	}
-	return strings.Join(lines, "\n")
+	return string(result.Stdout), stderr, nil
 }
 
-// execInContainer runs a command inside a devcontainer and returns stdout.
-// Uses docker exec with optional user flag.
-func execInContainer(ctx context.Context, containerID, user, workDir string, args ...string) (stdout string, stderr string, err error) {
-	dockerArgs := []string{"exec", "-i"}
-	if user != "" {
-		dockerArgs = append(dockerArgs, "-u", user)
+// readContainerOutput runs a command inside a container and returns at most
+// limit bytes of its stdout. The output is streamed rather than buffered by
+// Exec so an unexpectedly large file cannot exhaust memory.
+func readContainerOutput(ctx context.Context, containerID, user string, limit int64, command ...string) (string, error) {
+	rt := containerruntime.Current()
+	cmd := rt.Command(ctx, rt.ExecArgs(containerID, containerruntime.ExecOptions{User: user}, command...)...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", fmt.Errorf("%s exec stdout pipe: %w", rt.Name(), err)
 	}
-	if workDir != "" {
-		dockerArgs = append(dockerArgs, "-w", workDir)
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("%s exec start failed: %w", rt.Name(), err)
 	}
-	dockerArgs = append(dockerArgs, containerID)
-	dockerArgs = append(dockerArgs, args...)
-
-	cmd := exec.CommandContext(ctx, "docker", dockerArgs...)
-
-	var stdoutBuf, stderrBuf bytes.Buffer
-	cmd.Stdout = &stdoutBuf
-	cmd.Stderr = &stderrBuf
-
-	if err := cmd.Run(); err != nil {
-		return "", strings.TrimSpace(stderrBuf.String()), fmt.Errorf("command failed: %w", err)
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, io.LimitReader(stdout, limit)); err != nil {
+		_ = cmd.Wait()
+		return "", fmt.Errorf("%s exec read failed: %w", rt.Name(), err)
 	}
-
-	return stdoutBuf.String(), strings.TrimSpace(stderrBuf.String()), nil
+	if err := cmd.Wait(); err != nil {
+		return "", fmt.Errorf("%s exec failed: %w", rt.Name(), err)
+	}
+	return buf.String(), nil
 }
 
 // writeAuthFileToContainer writes credential content to a file inside a container.
@@ -671,20 +819,11 @@ func writeAuthFileToContainer(ctx context.Context, containerID, user, authFilePa
 		return fmt.Errorf("chmod auth file parent dir: %w", err)
 	}
 
-	dockerArgs := []string{"exec", "-i"}
-	if user != "" {
-		dockerArgs = append(dockerArgs, "-u", user)
-	}
-	dockerArgs = append(dockerArgs, containerID, "tee", targetPath)
-
-	cmd := exec.CommandContext(ctx, "docker", dockerArgs...)
-	cmd.Stdin = strings.NewReader(content)
-	cmd.Stdout = io.Discard
-
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("write auth file content: %w: %s", err, strings.TrimSpace(stderr.String()))
+	if _, err := containerruntime.Current().Exec(ctx, containerID, containerruntime.ExecOptions{
+		User:  user,
+		Stdin: strings.NewReader(content),
+	}, "sh", "-c", `cat > "$1"`, "sh", targetPath); err != nil {
+		return fmt.Errorf("write auth file content: %w", err)
 	}
 
 	if _, stderrText, err := execInContainer(ctx, containerID, user, "", "chmod", "600", targetPath); err != nil {
@@ -710,32 +849,9 @@ func readAuthFileFromContainer(ctx context.Context, containerID, user, authFileP
 		return "", fmt.Errorf("resolve auth file target path: %w", err)
 	}
 
-	dockerArgs := []string{"exec"}
-	if user != "" {
-		dockerArgs = append(dockerArgs, "-u", user)
-	}
-	dockerArgs = append(dockerArgs, containerID, "cat", targetPath)
-
-	cmd := exec.CommandContext(ctx, "docker", dockerArgs...)
-
 	// Cap output at 1 MB to guard against unexpectedly large files.
 	const maxCredentialSize = 1 << 20 // 1 MB
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return "", fmt.Errorf("docker exec stdout pipe: %w", err)
-	}
-	if err := cmd.Start(); err != nil {
-		return "", fmt.Errorf("docker exec start failed: %w", err)
-	}
-	var buf bytes.Buffer
-	if _, err := io.Copy(&buf, io.LimitReader(stdout, maxCredentialSize)); err != nil {
-		_ = cmd.Wait()
-		return "", fmt.Errorf("docker exec read failed: %w", err)
-	}
-	if err := cmd.Wait(); err != nil {
-		return "", fmt.Errorf("docker exec failed: %w", err)
-	}
-	return buf.String(), nil
+	return readContainerOutput(ctx, containerID, user, maxCredentialSize, "cat", targetPath)
 }
 
 // agentInstallMu serializes concurrent agent binary installs to prevent
@@ -753,9 +869,8 @@ var agentInstallMu sync.Mutex
 // path acquires it, with a double-check after acquisition.
 func installAgentBinary(ctx context.Context, containerID string, info agentCommandInfo) error {
 	// Fast path: check without mutex — avoids contention when already installed.
-	checkArgs := []string{"exec", containerID, "which", info.command}
-	checkCmd := exec.CommandContext(ctx, "docker", checkArgs...)
-	if err := checkCmd.Run(); err == nil {
+	rt := containerruntime.Current()
+	if _, err := rt.Exec(ctx, containerID, containerruntime.ExecOptions{}, "which", info.command); err == nil {
 		slog.Info("Agent binary is already installed", "command", info.command)
 		return nil
 	}
@@ -770,8 +885,7 @@ func installAgentBinary(ctx context.Context, containerID string, info agentComma
 	}
 
 	// Double-check after acquiring mutex — another goroutine may have installed it.
-	recheckCmd := exec.CommandContext(ctx, "docker", checkArgs...)
-	if err := recheckCmd.Run(); err == nil {
+	if _, err := rt.Exec(ctx, containerID, containerruntime.ExecOptions{}, "which", info.command); err == nil {
 		slog.Info("Agent binary was installed by another goroutine", "command", info.command)
 		return nil
 	}
@@ -787,20 +901,20 @@ func installAgentBinary(ctx context.Context, containerID string, info agentComma
 			`rm -rf /usr/local/lib/node_modules/.%s-* /usr/local/lib/node_modules/*/.%s-* /usr/local/share/nvm/versions/node/*/lib/node_modules/.%s-* /usr/local/share/nvm/versions/node/*/lib/node_modules/*/.%s-* 2>/dev/null; true`,
 			info.command, info.command, info.command, info.command,
 		)
-		cleanupArgs := []string{"exec", "-u", "root", containerID, "sh", "-c", cleanupScript}
-		cleanupCmd := exec.CommandContext(ctx, "docker", cleanupArgs...)
-		_ = cleanupCmd.Run() // best-effort cleanup
+		op := execaudit.Begin(ctx, containerID, "agent.install_cleanup", info.command)
+		_, err := rt.Exec(ctx, containerID, containerruntime.ExecOptions{User: "root"}, "sh", "-c", cleanupScript)
+		_ = op.End(err) // best-effort cleanup
 	}
 
 	// For npm-based agents, ensure npm is available before running the install.
 	// Non-npm agents (e.g., pip-based) handle their own prerequisites in installCmd.
 	installScript := agentInstallScript(info)
 
-	installArgs := []string{"exec", "-u", "root", containerID, "sh", "-c", installScript}
-	installCmd := exec.CommandContext(ctx, "docker", installArgs...)
-	output, err := installCmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("install command failed: %w: %s", err, strings.TrimSpace(string(output)))
+	var output bytes.Buffer
+	op := execaudit.Begin(ctx, containerID, "agent.install", info.command)
+	_, err := rt.Exec(ctx, containerID, containerruntime.ExecOptions{User: "root", Output: &output}, "sh", "-c", installScript)
+	if op.End(err) != nil {
+		return fmt.Errorf("install command failed: %w: %s", err, strings.TrimSpace(output.String()))
 	}
 
 	slog.Info("Agent binary installed successfully", "command", info.command)
@@ -872,8 +986,10 @@ func agentInstallScript(info agentCommandInfo) string {
 }
 
 // agentCommandInfo holds the command, args, env var, and install command for an agent.
-// SECURITY: installCmd is passed to sh -c inside the container. It must always be a
-// hardcoded literal from getAgentCommandInfo — never derived from external input.
+// SECURITY: installCmd is passed to sh -c inside the container. For built-in agents
+// it must always be a hardcoded literal from getAgentCommandInfo — never derived
+// from external input. The only other source is a custom agent descriptor from the
+// control plane (see customAgentDescriptor), validated by resolveAgentCommandInfo.
 type agentCommandInfo struct {
 	command       string
 	args          []string
@@ -1552,40 +1668,13 @@ func readOptionalFileFromContainer(ctx context.Context, containerID, user, fileP
 	}
 
 	// Check if file exists first
-	dockerArgs := []string{"exec"}
-	if user != "" {
-		dockerArgs = append(dockerArgs, "-u", user)
-	}
-	dockerArgs = append(dockerArgs, containerID, "test", "-f", targetPath)
-
-	cmd := exec.CommandContext(ctx, "docker", dockerArgs...)
-	if err := cmd.Run(); err != nil {
+	if _, err := containerruntime.Current().Exec(ctx, containerID, containerruntime.ExecOptions{User: user}, "test", "-f", targetPath); err != nil {
 		// File doesn't exist, return empty string
 		return "", nil
 	}
 
-	// File exists, read its content
-	dockerArgs = dockerArgs[:len(dockerArgs)-3] // Remove "test", "-f", targetPath
-	dockerArgs = append(dockerArgs, "cat", targetPath)
-
-	cmd = exec.CommandContext(ctx, "docker", dockerArgs...)
 	const maxFileSize = 1 << 20 // 1 MB
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return "", fmt.Errorf("docker exec stdout pipe: %w", err)
-	}
-	if err := cmd.Start(); err != nil {
-		return "", fmt.Errorf("docker exec start failed: %w", err)
-	}
-	var buf bytes.Buffer
-	if _, err := io.Copy(&buf, io.LimitReader(stdout, maxFileSize)); err != nil {
-		_ = cmd.Wait()
-		return "", fmt.Errorf("docker exec read failed: %w", err)
-	}
-	if err := cmd.Wait(); err != nil {
-		return "", fmt.Errorf("docker exec failed: %w", err)
-	}
-	return buf.String(), nil
+	return readContainerOutput(ctx, containerID, user, maxFileSize, "cat", targetPath)
 }
 
 // writeCodexConfigToContainer updates ~/.codex/config.toml with a SAM-managed
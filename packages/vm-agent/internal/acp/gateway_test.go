@@ -210,6 +210,38 @@ func TestResolveAgentEnvVarsPrefersFreshGitTokenOverStaleEnv(t *testing.T) {
 	}
 }
 
+func TestResolveAgentEnvVarsIncludesCommitTraceVars(t *testing.T) {
+	t.Parallel()
+
+	host := &SessionHost{
+		config: SessionHostConfig{
+			GatewayConfig: GatewayConfig{WorkspaceID: "ws-123", SessionID: "sess-abc"},
+		},
+	}
+
+	envVars := host.resolveAgentEnvVars(context.Background(), "")
+
+	if !hasEnvEntry(envVars, "SAM_AGENT_SESSION_ID=sess-abc") {
+		t.Fatalf("SAM_AGENT_SESSION_ID missing: %v", envVars)
+	}
+	if !hasEnvEntry(envVars, "SAM_PROMPT_MARKER="+promptMarkerPath("sess-abc")) {
+		t.Fatalf("SAM_PROMPT_MARKER missing: %v", envVars)
+	}
+}
+
+func TestCommitAuthorEnvVarsNameTheAgent(t *testing.T) {
+	t.Parallel()
+
+	host := &SessionHost{config: SessionHostConfig{GatewayConfig: GatewayConfig{SessionID: "sess-abc"}}}
+	if envVars := host.commitAuthorEnvVars("openai-codex"); !hasEnvEntry(envVars, "SAM_AGENT_NAME=Codex") {
+		t.Fatalf("SAM_AGENT_NAME missing: %v", envVars)
+	}
+	host.config.SessionID = ""
+	if envVars := host.commitAuthorEnvVars("openai-codex"); len(envVars) != 0 {
+		t.Fatalf("commit author env without a session = %v, want none", envVars)
+	}
+}
+
 func hasEnvEntry(envVars []string, want string) bool {
 	for _, entry := range envVars {
 		if entry == want {
@@ -0,0 +1,319 @@
+package acp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	acpsdk "github.com/coder/acp-go-sdk"
+
+	"github.com/workspace/vm-agent/internal/containerruntime"
+)
+
+// McpRepoFile is the repository-relative path of the repository's MCP server
+// definitions.
+const McpRepoFile = ".sam/mcp.json"
+
+const (
+	// maxMcpServerSpecs bounds the MCP servers one source may define.
+	maxMcpServerSpecs = 20
+	// maxMcpConfigBytes bounds .sam/mcp.json.
+	maxMcpConfigBytes = 256 * 1024
+)
+
+var (
+	mcpServerNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+	mcpEnvNamePattern    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// McpServerSpec is a user-defined MCP server from agent settings or
+// .sam/mcp.json. A stdio server is a command the agent launches inside the
+// devcontainer; an http or sse server is reached by URL.
+type McpServerSpec struct {
+	Name string `json:"-"`
+	// Type is "stdio", "http", or "sse". Empty means stdio when Command is
+	// set and http otherwise.
+	Type    string            `json:"type,omitempty"`
+	Command string            `json:"command,omitempty"`
+	Args    []string          `json:"args,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// McpServerSpecs maps server names to their definitions. It is the format of
+// the "mcpServers" object in .sam/mcp.json and agent settings.
+type McpServerSpecs map[string]McpServerSpec
+
+// mcpConfigFile is the format of .sam/mcp.json.
+type mcpConfigFile struct {
+	McpServers McpServerSpecs `json:"mcpServers"`
+}
+
+// ParseMcpConfig parses and validates the contents of .sam/mcp.json.
+func ParseMcpConfig(data []byte) ([]McpServerSpec, error) {
+	var file mcpConfigFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", McpRepoFile, err)
+	}
+	return file.McpServers.Normalize()
+}
+
+// Normalize validates the definitions and returns them sorted by name with
+// Type filled in.
+func (specs McpServerSpecs) Normalize() ([]McpServerSpec, error) {
+	if len(specs) > maxMcpServerSpecs {
+		return nil, fmt.Errorf("at most %d MCP servers may be defined", maxMcpServerSpecs)
+	}
+	out := make([]McpServerSpec, 0, len(specs))
+	for name, spec := range specs {
+		spec.Name = name
+		if err := spec.normalize(); err != nil {
+			return nil, err
+		}
+		out = append(out, spec)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+func (s *McpServerSpec) normalize() error {
+	if !mcpServerNamePattern.MatchString(s.Name) {
+		return fmt.Errorf("MCP server name %q must be 1-64 letters, digits, '_' or '-'", s.Name)
+	}
+	if strings.HasPrefix(s.Name, "sam-mcp") {
+		return fmt.Errorf("MCP server name %q is reserved", s.Name)
+	}
+	s.Command = strings.TrimSpace(s.Command)
+	s.URL = strings.TrimSpace(s.URL)
+	if s.Type == "" {
+		s.Type = "http"
+		if s.Command != "" {
+			s.Type = "stdio"
+		}
+	}
+	switch s.Type {
+	case "stdio":
+		if s.Command == "" || s.URL != "" || len(s.Headers) > 0 {
+			return fmt.Errorf("MCP server %q: stdio servers need a command and no url or headers", s.Name)
+		}
+		if strings.ContainsRune(s.Command, '\x00') {
+			return fmt.Errorf("MCP server %q: command contains NUL byte", s.Name)
+		}
+	case "http", "sse":
+		if s.URL == "" || s.Command != "" || len(s.Args) > 0 || len(s.Env) > 0 {
+			return fmt.Errorf("MCP server %q: %s servers need a url and no command, args, or env", s.Name, s.Type)
+		}
+		isLocalhost := strings.HasPrefix(s.URL, "http://localhost:") || strings.HasPrefix(s.URL, "http://127.0.0.1:")
+		if !strings.HasPrefix(s.URL, "https://") && !isLocalhost {
+			return fmt.Errorf("MCP server %q: url must use HTTPS (got %q)", s.Name, s.URL)
+		}
+	default:
+		return fmt.Errorf("MCP server %q: unknown type %q", s.Name, s.Type)
+	}
+	for key := range s.Env {
+		if !mcpEnvNamePattern.MatchString(key) {
+			return fmt.Errorf("MCP server %q: invalid env var name %q", s.Name, key)
+		}
+	}
+	for key, value := range s.Headers {
+		if key == "" || strings.ContainsAny(key, ": \r\n") || strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("MCP server %q: invalid header %q", s.Name, key)
+		}
+	}
+	return nil
+}
+
+// mergeMcpServerSpecs combines definitions from agent settings and the
+// repository. On a name clash the agent settings win.
+func mergeMcpServerSpecs(settings, repo []McpServerSpec) []McpServerSpec {
+	seen := make(map[string]bool, len(settings))
+	merged := append([]McpServerSpec(nil), settings...)
+	for _, s := range settings {
+		seen[s.Name] = true
+	}
+	for _, s := range repo {
+		if !seen[s.Name] {
+			merged = append(merged, s)
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Name < merged[j].Name })
+	return merged
+}
+
+// buildSpecMcpServers converts user-defined servers into ACP descriptors.
+// Remote servers the agent cannot reach itself (amp, or an agent without the
+// matching MCP capability) are bridged through a local mcp-remote stdio
+// server in the devcontainer.
+func buildSpecMcpServers(specs []McpServerSpec, agentType string, caps acpsdk.McpCapabilities) []acpsdk.McpServer {
+	servers := make([]acpsdk.McpServer, 0, len(specs))
+	for _, spec := range specs {
+		switch spec.Type {
+		case "stdio":
+			servers = append(servers, acpsdk.McpServer{Stdio: &acpsdk.McpServerStdio{
+				Name:    spec.Name,
+				Command: spec.Command,
+				Args:    append([]string{}, spec.Args...),
+				Env:     mcpEnvVariables(spec.Env),
+			}})
+		case "http":
+			if agentType == "amp" || !caps.Http {
+				servers = append(servers, buildMcpRemoteBridge(spec))
+				continue
+			}
+			servers = append(servers, acpsdk.McpServer{Http: &acpsdk.McpServerHttpInline{
+				Name:    spec.Name,
+				Url:     spec.URL,
+				Headers: mcpHeaders(spec.Headers),
+			}})
+		case "sse":
+			if agentType == "amp" || !caps.Sse {
+				servers = append(servers, buildMcpRemoteBridge(spec))
+				continue
+			}
+			servers = append(servers, acpsdk.McpServer{Sse: &acpsdk.McpServerSseInline{
+				Name:    spec.Name,
+				Url:     spec.URL,
+				Headers: mcpHeaders(spec.Headers),
+			}})
+		}
+	}
+	return servers
+}
+
+// buildMcpRemoteBridge runs a remote server through mcp-remote. Header values
+// are passed as env vars, not CLI args, to keep them out of /proc.
+func buildMcpRemoteBridge(spec McpServerSpec) acpsdk.McpServer {
+	args := []string{"-y", ampMcpRemotePackage, spec.URL}
+	if spec.Type == "sse" {
+		args = append(args, "--transport", "sse-only")
+	}
+	var env []acpsdk.EnvVariable
+	for i, header := range sortedKeys(spec.Headers) {
+		envName := fmt.Sprintf("SAM_MCP_HEADER_%d", i)
+		env = append(env, acpsdk.EnvVariable{Name: envName, Value: spec.Headers[header]})
+		args = append(args, "--header", header+":${"+envName+"}")
+	}
+	args = append(args, "--silent")
+	return acpsdk.McpServer{Stdio: &acpsdk.McpServerStdio{
+		Name:    spec.Name,
+		Command: "npx",
+		Args:    args,
+		Env:     env,
+	}}
+}
+
+func mcpEnvVariables(env map[string]string) []acpsdk.EnvVariable {
+	vars := []acpsdk.EnvVariable{}
+	for _, name := range sortedKeys(env) {
+		vars = append(vars, acpsdk.EnvVariable{Name: name, Value: env[name]})
+	}
+	return vars
+}
+
+func mcpHeaders(headers map[string]string) []acpsdk.HttpHeader {
+	out := []acpsdk.HttpHeader{}
+	for _, name := range sortedKeys(headers) {
+		out = append(out, acpsdk.HttpHeader{Name: name, Value: headers[name]})
+	}
+	return out
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// resolveMcpServerSpecs collects the user-defined MCP servers for an agent
+// start from agent settings and the repository's .sam/mcp.json. Invalid
+// definitions are reported and skipped so they never block the agent.
+func (h *SessionHost) resolveMcpServerSpecs(ctx context.Context, containerID string, settings *agentSettingsPayload) []McpServerSpec {
+	var fromSettings []McpServerSpec
+	if settings != nil && len(settings.McpServers) > 0 {
+		specs, err := settings.McpServers.Normalize()
+		if err != nil {
+			h.reportMcpConfigInvalid("agent settings", err)
+		} else {
+			fromSettings = specs
+		}
+	}
+
+	var fromRepo []McpServerSpec
+	data, err := h.readRepoMcpConfig(ctx, containerID)
+	switch {
+	case err != nil:
+		h.reportMcpConfigInvalid(McpRepoFile, err)
+	case data != nil:
+		specs, err := ParseMcpConfig(data)
+		if err != nil {
+			h.reportMcpConfigInvalid(McpRepoFile, err)
+		} else {
+			fromRepo = specs
+		}
+	}
+
+	merged := mergeMcpServerSpecs(fromSettings, fromRepo)
+	if len(merged) > 0 {
+		names := make([]string, len(merged))
+		for i, s := range merged {
+			names[i] = s.Name
+		}
+		slog.Info("MCP servers configured", "sessionID", h.config.SessionID, "servers", names)
+	}
+	return merged
+}
+
+func (h *SessionHost) reportMcpConfigInvalid(source string, err error) {
+	slog.Warn("Ignoring invalid MCP server config", "source", source, "sessionID", h.config.SessionID, "error", err)
+	h.reportEvent("warn", "agent.mcp_config_invalid", "MCP server config is invalid and was ignored", map[string]interface{}{
+		"source": source,
+		"error":  err.Error(),
+	})
+}
+
+// readRepoMcpConfig returns the contents of .sam/mcp.json in the working
+// directory, or nil when there is none.
+func (h *SessionHost) readRepoMcpConfig(ctx context.Context, containerID string) ([]byte, error) {
+	workDir := h.config.ContainerWorkDir
+	if workDir == "" {
+		return nil, nil
+	}
+	path := filepath.Join(workDir, McpRepoFile)
+	var data []byte
+	if containerID == "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// A missing file prints nothing, so it reads as no config.
+		result, err := containerruntime.Current().Exec(ctx, containerID, containerruntime.ExecOptions{User: h.config.ContainerUser},
+			"sh", "-c", `[ -f "$1" ] && cat "$1" || true`, "sh", path)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", McpRepoFile, err)
+		}
+		if len(result.Stdout) == 0 {
+			return nil, nil
+		}
+		data = result.Stdout
+	}
+	if len(data) > maxMcpConfigBytes {
+		return nil, fmt.Errorf("%s exceeds %d bytes", McpRepoFile, maxMcpConfigBytes)
+	}
+	return data, nil
+}
@@ -0,0 +1,125 @@
+package acp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	acpsdk "github.com/coder/acp-go-sdk"
+)
+
+func TestParseMcpConfig(t *testing.T) {
+	specs, err := ParseMcpConfig([]byte(`{
+		"mcpServers": {
+			"postgres": {"command": "npx", "args": ["-y", "@modelcontextprotocol/server-postgres"], "env": {"DATABASE_URL": "postgres://localhost/app"}},
+			"docs": {"url": "https://docs.example.com/mcp", "headers": {"Authorization": "Bearer abc"}},
+			"events": {"type": "sse", "url": "http://localhost:8080/sse"}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("ParseMcpConfig: %v", err)
+	}
+	if len(specs) != 3 {
+		t.Fatalf("expected 3 servers, got %d", len(specs))
+	}
+	// Sorted by name, with Type inferred.
+	if specs[0].Name != "docs" || specs[0].Type != "http" {
+		t.Errorf("specs[0] = %+v", specs[0])
+	}
+	if specs[1].Name != "events" || specs[1].Type != "sse" {
+		t.Errorf("specs[1] = %+v", specs[1])
+	}
+	if specs[2].Name != "postgres" || specs[2].Type != "stdio" {
+		t.Errorf("specs[2] = %+v", specs[2])
+	}
+}
+
+func TestParseMcpConfig_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"bad json":         `{"mcpServers":`,
+		"reserved name":    `{"mcpServers":{"sam-mcp":{"url":"https://a.example.com"}}}`,
+		"bad name":         `{"mcpServers":{"has space":{"command":"x"}}}`,
+		"command and url":  `{"mcpServers":{"a":{"command":"x","url":"https://a.example.com"}}}`,
+		"insecure url":     `{"mcpServers":{"a":{"url":"http://a.example.com/mcp"}}}`,
+		"unknown type":     `{"mcpServers":{"a":{"type":"ws","url":"https://a.example.com"}}}`,
+		"bad env name":     `{"mcpServers":{"a":{"command":"x","env":{"1BAD":"v"}}}}`,
+		"header injection": `{"mcpServers":{"a":{"url":"https://a.example.com","headers":{"X-A":"v\r\nX-B: w"}}}}`,
+		"empty stdio":      `{"mcpServers":{"a":{"type":"stdio"}}}`,
+	}
+	for name, input := range tests {
+		if _, err := ParseMcpConfig([]byte(input)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestMergeMcpServerSpecs_SettingsWin(t *testing.T) {
+	settings := []McpServerSpec{{Name: "docs", Type: "http", URL: "https://settings.example.com"}}
+	repo := []McpServerSpec{
+		{Name: "docs", Type: "http", URL: "https://repo.example.com"},
+		{Name: "db", Type: "stdio", Command: "db-mcp"},
+	}
+	merged := mergeMcpServerSpecs(settings, repo)
+	if len(merged) != 2 || merged[0].Name != "db" || merged[1].URL != "https://settings.example.com" {
+		t.Fatalf("merged = %+v", merged)
+	}
+}
+
+func TestBuildSpecMcpServers(t *testing.T) {
+	specs := []McpServerSpec{
+		{Name: "db", Type: "stdio", Command: "db-mcp", Args: []string{"--ro"}, Env: map[string]string{"DB": "app"}},
+		{Name: "docs", Type: "http", URL: "https://docs.example.com/mcp", Headers: map[string]string{"Authorization": "Bearer abc"}},
+	}
+
+	servers := buildSpecMcpServers(specs, "claude-code", acpsdk.McpCapabilities{Http: true})
+	if len(servers) != 2 {
+		t.Fatalf("expected 2 servers, got %d", len(servers))
+	}
+	if s := servers[0].Stdio; s == nil || s.Command != "db-mcp" || len(s.Env) != 1 || s.Env[0].Name != "DB" {
+		t.Fatalf("stdio server = %+v", servers[0])
+	}
+	if s := servers[1].Http; s == nil || s.Url != "https://docs.example.com/mcp" || len(s.Headers) != 1 {
+		t.Fatalf("http server = %+v", servers[1])
+	}
+
+	// Without HTTP MCP support the remote server is bridged over stdio, with
+	// the header value kept out of the command line.
+	servers = buildSpecMcpServers(specs[1:], "claude-code", acpsdk.McpCapabilities{})
+	bridge := servers[0].Stdio
+	if bridge == nil || bridge.Command != "npx" || bridge.Name != "docs" {
+		t.Fatalf("bridge = %+v", servers[0])
+	}
+	args := strings.Join(bridge.Args, " ")
+	if strings.Contains(args, "Bearer abc") || !strings.Contains(args, "Authorization:${SAM_MCP_HEADER_0}") {
+		t.Fatalf("bridge args = %q", args)
+	}
+	if len(bridge.Env) != 1 || bridge.Env[0].Value != "Bearer abc" {
+		t.Fatalf("bridge env = %+v", bridge.Env)
+	}
+}
+
+func TestResolveMcpServerSpecs_StandaloneRepoFile(t *testing.T) {
+	workDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(workDir, ".sam"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	config := `{"mcpServers":{"db":{"command":"db-mcp"},"docs":{"url":"https://repo.example.com"}}}`
+	if err := os.WriteFile(filepath.Join(workDir, McpRepoFile), []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	host := NewSessionHost(SessionHostConfig{GatewayConfig: GatewayConfig{ContainerWorkDir: workDir}})
+	settings := &agentSettingsPayload{McpServers: McpServerSpecs{"docs": {URL: "https://settings.example.com"}}}
+	specs := host.resolveMcpServerSpecs(t.Context(), "", settings)
+	if len(specs) != 2 || specs[0].Name != "db" || specs[1].URL != "https://settings.example.com" {
+		t.Fatalf("specs = %+v", specs)
+	}
+
+	// Invalid settings are ignored rather than failing the agent start.
+	settings = &agentSettingsPayload{McpServers: McpServerSpecs{"sam-mcp": {URL: "https://x.example.com"}}}
+	specs = host.resolveMcpServerSpecs(t.Context(), "", settings)
+	if len(specs) != 2 || specs[1].URL != "https://repo.example.com" {
+		t.Fatalf("specs = %+v", specs)
+	}
+}
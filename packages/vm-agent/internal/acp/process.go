@@ -6,6 +6,7 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -15,6 +16,8 @@ import (
 	"sync"
 	"syscall"
 	"time"
+
+	"github.com/workspace/vm-agent/internal/containerruntime"
 )
 
 const (
@@ -44,12 +47,11 @@ var samEnvFiles = []string{
 func ReadContainerEnvFiles(ctx context.Context, containerID string) []string {
 	var result []string
 	for _, path := range samEnvFiles {
-		cmd := exec.CommandContext(ctx, "docker", "exec", containerID, "cat", path)
-		output, err := cmd.Output()
+		output, err := containerruntime.Current().Exec(ctx, containerID, containerruntime.ExecOptions{}, "cat", path)
 		if err != nil {
 			continue
 		}
-		result = append(result, parseEnvExportLines(string(output))...)
+		result = append(result, parseEnvExportLines(string(output.Stdout))...)
 	}
 	return result
 }
@@ -274,39 +276,29 @@ func splitProcessEnvVars(envVars []string, explicitSecretKeys map[string]bool) (
 }
 
 func buildDockerExecArgs(cfg ProcessConfig) ([]string, string, error) {
-	args := []string{"exec", "-i"}
-
-	if cfg.ContainerUser != "" {
-		args = append(args, "-u", cfg.ContainerUser)
-	}
-	if cfg.WorkDir != "" {
-		args = append(args, "-w", cfg.WorkDir)
-	}
-
 	// Separate secret env vars from non-secret ones. Secrets are written to
 	// a tmpfs-backed file and passed via --env-file to avoid exposing them
 	// in /proc/<pid>/cmdline on the host.
 	secrets, nonSecrets := splitProcessEnvVars(cfg.EnvVars, cfg.SecretEnvKeys)
-
-	for _, env := range nonSecrets {
-		args = append(args, "-e", env)
+	opts := containerruntime.ExecOptions{
+		User:        cfg.ContainerUser,
+		WorkDir:     cfg.WorkDir,
+		Env:         nonSecrets,
+		Interactive: true,
 	}
 
 	// Write secrets to env file. Failing here is fatal — falling back to -e flags
 	// would expose secrets in the process table (visible via `ps`).
-	var envFilePath string
 	if len(secrets) > 0 {
 		path, err := writeSecretEnvFile(secrets)
 		if err != nil {
 			return nil, "", fmt.Errorf("failed to write secret env file: %w", err)
 		}
-		envFilePath = path
-		args = append(args, "--env-file", envFilePath)
+		opts.EnvFile = path
 	}
 
-	args = append(args, cfg.ContainerID, cfg.AcpCommand)
-	args = append(args, cfg.AcpArgs...)
-	return args, envFilePath, nil
+	command := append([]string{cfg.AcpCommand}, cfg.AcpArgs...)
+	return containerruntime.Current().ExecArgs(cfg.ContainerID, opts, command...), opts.EnvFile, nil
 }
 
 func openProcessPipes(cmd *exec.Cmd, envFilePath string) (processPipes, error) {
@@ -357,7 +349,7 @@ func startDockerExecProcess(cfg ProcessConfig) (*AgentProcess, error) {
 		return nil, err
 	}
 
-	cmd := exec.Command("docker", args...)
+	cmd := containerruntime.Command(context.Background(), args...)
 
 	// Place the process in its own process group so we can signal the entire
 	// tree (docker exec CLI + its children) via negative PGID in Stop().
@@ -581,7 +573,13 @@ func (p *AgentProcess) Stop() error {
 // Targets both the ACP adapter (claude-agent-acp) and the underlying agent
 // (claude) to ensure nothing leaks.
 func (p *AgentProcess) killContainerProcesses(sig syscall.Signal) {
-	if p.containerID == "" {
+	killContainerProcesses(p.containerID, p.agentType, sig)
+}
+
+// killContainerProcesses signals every process in the container whose full
+// command line matches pattern.
+func killContainerProcesses(containerID, pattern string, sig syscall.Signal) {
+	if containerID == "" {
 		return
 	}
 
@@ -595,17 +593,18 @@ func (p *AgentProcess) killContainerProcesses(sig syscall.Signal) {
 
 	// Kill the ACP adapter process and all its children inside the container.
 	// Using pkill with -f matches the full command line.
-	cmd := exec.CommandContext(ctx, "docker", "exec", p.containerID,
-		"pkill", fmt.Sprintf("-%s", sigName), "-f", p.agentType)
-	if err := cmd.Run(); err != nil {
+	_, err := containerruntime.Current().Exec(ctx, containerID, containerruntime.ExecOptions{},
+		"pkill", fmt.Sprintf("-%s", sigName), "-f", pattern)
+	if err != nil {
 		// Exit code 1 means no processes matched — that's fine, they already exited.
-		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
-			slog.Debug("No container processes matched for kill", "signal", sigName, "pattern", p.agentType)
+		var execErr *containerruntime.ExecError
+		if errors.As(err, &execErr) && execErr.ExitCode == 1 {
+			slog.Debug("No container processes matched for kill", "signal", sigName, "pattern", pattern)
 		} else {
-			slog.Warn("Failed to kill container processes", "signal", sigName, "pattern", p.agentType, "error", err)
+			slog.Warn("Failed to kill container processes", "signal", sigName, "pattern", pattern, "error", err)
 		}
 	} else {
-		slog.Info("Sent signal to container processes", "signal", sigName, "pattern", p.agentType, "container", p.containerID)
+		slog.Info("Sent signal to container processes", "signal", sigName, "pattern", pattern, "container", containerID)
 	}
 }
 
@@ -0,0 +1,161 @@
+package acp
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Prompt timeout sources, reported in logs and lifecycle events so operators
+// can tell why a prompt was given the deadline it had.
+const (
+	promptTimeoutSourceStatic   = "static"
+	promptTimeoutSourceAdaptive = "adaptive"
+	promptTimeoutSourceOverride = "override"
+)
+
+// PromptDurationTracker records how long successful prompts take per agent
+// type over a sliding window of recent samples. A single tracker is shared by
+// every SessionHost on the node so that new sessions benefit from the history
+// of earlier ones. Samples are kept in memory only.
+type PromptDurationTracker struct {
+	mu      sync.Mutex
+	window  int
+	samples map[string][]time.Duration
+	next    map[string]int
+}
+
+// NewPromptDurationTracker creates a tracker retaining up to window samples
+// per agent type. A non-positive window defaults to 100.
+func NewPromptDurationTracker(window int) *PromptDurationTracker {
+	if window <= 0 {
+		window = 100
+	}
+	return &PromptDurationTracker{
+		window:  window,
+		samples: make(map[string][]time.Duration),
+		next:    make(map[string]int),
+	}
+}
+
+// Record adds a completed prompt duration for the agent type, evicting the
+// oldest sample once the window is full.
+func (t *PromptDurationTracker) Record(agentType string, d time.Duration) {
+	if t == nil || agentType == "" || d <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.samples[agentType]
+	if len(s) < t.window {
+		t.samples[agentType] = append(s, d)
+		return
+	}
+	i := t.next[agentType]
+	s[i] = d
+	t.next[agentType] = (i + 1) % t.window
+}
+
+// Percentile returns the p-th percentile (0-100, nearest-rank) of recorded
+// durations for the agent type, along with the number of samples it was
+// computed from. It returns zero when there are no samples.
+func (t *PromptDurationTracker) Percentile(agentType string, p float64) (time.Duration, int) {
+	if t == nil {
+		return 0, 0
+	}
+	t.mu.Lock()
+	sorted := append([]time.Duration(nil), t.samples[agentType]...)
+	t.mu.Unlock()
+	if len(sorted) == 0 {
+		return 0, 0
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1], len(sorted)
+}
+
+// AdaptivePromptTimeout derives a prompt timeout from historical prompt
+// durations: Multiplier times the Percentile-th percentile for the agent type,
+// clamped to [Min, Max]. Until MinSamples prompts have completed for an agent
+// type, the static PromptTimeout applies. A nil Tracker disables it.
+type AdaptivePromptTimeout struct {
+	Tracker    *PromptDurationTracker
+	Percentile float64
+	Multiplier float64
+	MinSamples int
+	Min        time.Duration
+	Max        time.Duration
+}
+
+// timeoutFor returns the adaptive timeout for the agent type, or false when
+// not enough history exists.
+func (a AdaptivePromptTimeout) timeoutFor(agentType string) (time.Duration, bool) {
+	if a.Tracker == nil {
+		return 0, false
+	}
+	p, n := a.Tracker.Percentile(agentType, a.Percentile)
+	if n == 0 || n < a.MinSamples {
+		return 0, false
+	}
+	multiplier := a.Multiplier
+	if multiplier <= 0 {
+		multiplier = 1
+	}
+	timeout := time.Duration(float64(p) * multiplier)
+	if a.Min > 0 && timeout < a.Min {
+		timeout = a.Min
+	}
+	if a.Max > 0 && timeout > a.Max {
+		timeout = a.Max
+	}
+	return timeout, true
+}
+
+// parsePromptTimeoutOverride extracts the optional client-supplied
+// "timeoutSeconds" from session/prompt params. Zero means no override.
+func parsePromptTimeoutOverride(params json.RawMessage) time.Duration {
+	var p struct {
+		TimeoutSeconds float64 `json:"timeoutSeconds"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil || p.TimeoutSeconds <= 0 || math.IsInf(p.TimeoutSeconds, 0) {
+		return 0
+	}
+	if p.TimeoutSeconds > float64(math.MaxInt64/int64(time.Second)) {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(p.TimeoutSeconds * float64(time.Second))
+}
+
+// resolvePromptTimeout picks the timeout for a prompt. A client override wins
+// but is capped at PromptTimeoutOverrideMax (overrides are ignored when the
+// cap is zero); otherwise the adaptive timeout applies once there is enough
+// history for the agent type, falling back to the static PromptTimeout.
+// A zero result means no timeout.
+func (h *SessionHost) resolvePromptTimeout(agentType string, override time.Duration) (time.Duration, string) {
+	if override > 0 && h.config.PromptTimeoutOverrideMax > 0 {
+		if override > h.config.PromptTimeoutOverrideMax {
+			override = h.config.PromptTimeoutOverrideMax
+		}
+		return override, promptTimeoutSourceOverride
+	}
+	if timeout, ok := h.config.AdaptivePromptTimeout.timeoutFor(agentType); ok {
+		return timeout, promptTimeoutSourceAdaptive
+	}
+	return h.promptTimeout(), promptTimeoutSourceStatic
+}
+
+// recordPromptDuration feeds a successfully completed prompt into the
+// node-wide duration history used by the adaptive timeout.
+func (h *SessionHost) recordPromptDuration(d time.Duration) {
+	if tracker := h.config.AdaptivePromptTimeout.Tracker; tracker != nil {
+		tracker.Record(h.AgentType(), d)
+	}
+}
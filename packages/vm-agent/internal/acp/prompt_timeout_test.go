@@ -0,0 +1,93 @@
+package acp
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestPromptDurationTrackerPercentileAndWindow(t *testing.T) {
+	tracker := NewPromptDurationTracker(4)
+	for _, d := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute, 4 * time.Minute} {
+		tracker.Record("claude-code", d)
+	}
+	if p, n := tracker.Percentile("claude-code", 50); p != 2*time.Minute || n != 4 {
+		t.Fatalf("p50 = %s (n=%d), want 2m (n=4)", p, n)
+	}
+	if p, _ := tracker.Percentile("claude-code", 100); p != 4*time.Minute {
+		t.Fatalf("p100 = %s, want 4m", p)
+	}
+
+	// The window evicts the oldest sample.
+	tracker.Record("claude-code", 10*time.Minute)
+	if p, n := tracker.Percentile("claude-code", 0); p != 2*time.Minute || n != 4 {
+		t.Fatalf("min after eviction = %s (n=%d), want 2m (n=4)", p, n)
+	}
+
+	// Agent types are tracked independently.
+	if _, n := tracker.Percentile("openai-codex", 95); n != 0 {
+		t.Fatalf("unexpected samples for other agent type: %d", n)
+	}
+}
+
+func TestResolvePromptTimeout(t *testing.T) {
+	tracker := NewPromptDurationTracker(0)
+	host := &SessionHost{config: SessionHostConfig{GatewayConfig: GatewayConfig{
+		PromptTimeout: time.Hour,
+		AdaptivePromptTimeout: AdaptivePromptTimeout{
+			Tracker:    tracker,
+			Percentile: 95,
+			Multiplier: 3,
+			MinSamples: 3,
+			Min:        10 * time.Minute,
+			Max:        2 * time.Hour,
+		},
+		PromptTimeoutOverrideMax: 4 * time.Hour,
+	}}}
+
+	check := func(name string, override, wantTimeout time.Duration, wantSource string) {
+		t.Helper()
+		timeout, source := host.resolvePromptTimeout("claude-code", override)
+		if timeout != wantTimeout || source != wantSource {
+			t.Errorf("%s: got %s (%s), want %s (%s)", name, timeout, source, wantTimeout, wantSource)
+		}
+	}
+
+	tracker.Record("claude-code", time.Minute)
+	tracker.Record("claude-code", time.Minute)
+	check("not enough history", 0, time.Hour, promptTimeoutSourceStatic)
+
+	tracker.Record("claude-code", time.Minute)
+	check("clamped to min", 0, 10*time.Minute, promptTimeoutSourceAdaptive)
+
+	for i := 0; i < 3; i++ {
+		tracker.Record("claude-code", 5*time.Minute)
+	}
+	check("percentile times multiplier", 0, 15*time.Minute, promptTimeoutSourceAdaptive)
+
+	for i := 0; i < 6; i++ {
+		tracker.Record("claude-code", time.Hour)
+	}
+	check("clamped to max", 0, 2*time.Hour, promptTimeoutSourceAdaptive)
+
+	check("client override", 30*time.Second, 30*time.Second, promptTimeoutSourceOverride)
+	check("override capped", 24*time.Hour, 4*time.Hour, promptTimeoutSourceOverride)
+
+	host.config.PromptTimeoutOverrideMax = 0
+	check("overrides disabled", 30*time.Second, 2*time.Hour, promptTimeoutSourceAdaptive)
+}
+
+func TestParsePromptTimeoutOverride(t *testing.T) {
+	cases := map[string]time.Duration{
+		`{"prompt":[],"timeoutSeconds":90}`:  90 * time.Second,
+		`{"prompt":[],"timeoutSeconds":1.5}`: 1500 * time.Millisecond,
+		`{"prompt":[],"timeoutSeconds":-5}`:  0,
+		`{"prompt":[]}`:                      0,
+		`{"timeoutSeconds":"soon"}`:          0,
+	}
+	for input, want := range cases {
+		if got := parsePromptTimeoutOverride(json.RawMessage(input)); got != want {
+			t.Errorf("parsePromptTimeoutOverride(%s) = %s, want %s", input, got, want)
+		}
+	}
+}
@@ -16,6 +16,8 @@ import (
 	acpsdk "github.com/coder/acp-go-sdk"
 	"github.com/gorilla/websocket"
 	"github.com/workspace/vm-agent/internal/config"
+	"github.com/workspace/vm-agent/internal/execaudit"
+	"github.com/workspace/vm-agent/internal/redact"
 )
 
 // SessionHostStatus represents the lifecycle state of a SessionHost.
@@ -55,6 +57,10 @@ const (
 	// reset before counting a new unexpected agent exit.
 	DefaultRestartDecayWindow = 5 * time.Minute
 
+	// DefaultPermissionTimeout is how long an agent permission request waits
+	// for a viewer to answer before the fallback outcome is used.
+	DefaultPermissionTimeout = 5 * time.Minute
+
 	// defaultControlPlaneHTTPTimeout is the safety-net HTTP client timeout
 	// used when no HTTPClient is injected via GatewayConfig. Production code
 	// injects a client via config.NewControlPlaneClient(cfg.HTTPCallbackTimeout);
@@ -173,6 +179,30 @@ type SessionHostConfig struct {
 	// RuntimeAssetsProvider fetches resolved project/profile/skill runtime assets
 	// for standalone sessions. It must not log or persist secret values.
 	RuntimeAssetsProvider RuntimeAssetsProvider
+
+	// MaxQueuedPrompts bounds the FIFO queue of prompts submitted while another
+	// prompt is running. 0 disables queueing, so such prompts are rejected
+	// with "Prompt already in progress".
+	// Override via ACP_MAX_QUEUED_PROMPTS. Default: 0.
+	MaxQueuedPrompts int
+
+	// PermissionTimeout bounds how long an agent permission request waits for
+	// a viewer's permission_response. On timeout the request is rejected with
+	// the agent's reject option, or cancelled if it offered none.
+	// Override via ACP_PERMISSION_TIMEOUT. Default: 5m.
+	PermissionTimeout time.Duration
+
+	// MaxCompanionAgents bounds the agents a session runs alongside its
+	// primary agent for prompts that target another agent type. 0 disables
+	// multi-agent routing.
+	// Override via ACP_MAX_COMPANION_AGENTS. Default: 2.
+	MaxCompanionAgents int
+
+	// ViewerAckInterval is how often viewers are sent a session_ack with the
+	// sequence number of the last buffered message they received. 0 disables
+	// acks, so reconnecting clients always get a full replay.
+	// Override via ACP_VIEWER_ACK_INTERVAL. Default: 5s.
+	ViewerAckInterval time.Duration
 }
 
 // BufferedMessage holds a single message in the replay buffer.
@@ -182,13 +212,68 @@ type BufferedMessage struct {
 	Timestamp time.Time
 }
 
-// Viewer represents a single WebSocket connection to a SessionHost.
+// viewerMessage is one message queued on a viewer's send channel. seq is the
+// buffered sequence number, or 0 for a transient message.
+type viewerMessage struct {
+	data []byte
+	seq  uint64
+}
+
+// Viewer represents a single connection (WebSocket or HTTP long-poll) to a
+// SessionHost.
 type Viewer struct {
 	ID     string
-	conn   *websocket.Conn
-	sendCh chan []byte
+	sink   ViewerSink
+	sendCh chan viewerMessage
 	done   chan struct{}
 	once   sync.Once
+	role   ViewerRole
+
+	// queuedSeq is the highest buffered sequence number queued for this
+	// viewer. Acks start once its replay is queued (ackReady). ackedSeq is
+	// only touched by the ack loop.
+	queuedSeq atomic.Uint64
+	ackReady  atomic.Bool
+	ackedSeq  uint64
+
+	// A viewer is lagging when its send channel overflowed. It is skipped by
+	// broadcasts until its write pump replays everything after resumeSeq.
+	// Guarded by lagMu, as is resyncedSeq, the newest message written by the
+	// last resync. resyncCh wakes an idle write pump once the viewer starts
+	// lagging.
+	lagMu       sync.Mutex
+	lagging     bool
+	resumeSeq   uint64
+	resyncedSeq uint64
+	resyncCh    chan struct{}
+}
+
+func (v *Viewer) isLagging() bool {
+	v.lagMu.Lock()
+	defer v.lagMu.Unlock()
+	return v.lagging
+}
+
+// skipsSeq reports whether the queued copy of buffered message seq must not
+// be written because a resync replays it, either the pending one or the one
+// already written.
+func (v *Viewer) skipsSeq(seq uint64) bool {
+	v.lagMu.Lock()
+	defer v.lagMu.Unlock()
+	if v.lagging {
+		return seq > v.resumeSeq
+	}
+	return seq <= v.resyncedSeq
+}
+
+// noteQueuedSeq raises queuedSeq to seq.
+func (v *Viewer) noteQueuedSeq(seq uint64) {
+	for {
+		cur := v.queuedSeq.Load()
+		if seq <= cur || v.queuedSeq.CompareAndSwap(cur, seq) {
+			return
+		}
+	}
 }
 
 // Done returns a channel that is closed when the viewer's write pump exits.
@@ -219,8 +304,14 @@ type SessionHost struct {
 	// agentSupportsLoadSession is captured from ACP Initialize so prompt error
 	// handling can decide whether a process crash is recoverable.
 	agentSupportsLoadSession bool
-	status                   SessionHostStatus
-	statusErr                string
+	// agentMcpCapabilities is captured from ACP Initialize so remote MCP
+	// servers the agent cannot reach itself are bridged over stdio.
+	agentMcpCapabilities acpsdk.McpCapabilities
+	// mcpServerSpecs are the user-defined MCP servers resolved at agent start
+	// from agent settings and .sam/mcp.json.
+	mcpServerSpecs []McpServerSpec
+	status         SessionHostStatus
+	statusErr      string
 	// intentionalPromptCancelProcessStop suppresses rapid-exit crash handling
 	// when a user cancel intentionally terminates an agent that lacks native
 	// session/cancel support.
@@ -240,21 +331,61 @@ type SessionHost struct {
 	credInjectionMode string // "env" or "auth-file"
 	credAuthFilePath  string // relative to home dir, e.g. ".codex/auth.json"
 	credKind          string // "api-key" or "oauth-token"
+	// agentCred is the credential the running agent was started with, so
+	// RefreshCredential can skip restarts when the key has not changed.
+	agentCred *agentCredential
+
+	// usageMu guards sessionCost, the cumulative cost from the agent's last
+	// usage_update notification. Prompts report the increase as their cost.
+	usageMu     sync.Mutex
+	sessionCost *acpsdk.Cost
+
+	// claimMu guards the session-scoped hooks a warm-standby host adopts in
+	// ClaimStandby (MessageReporter, OnPromptComplete). Kept separate from mu
+	// so ACP notification handlers never block on agent-state locking.
+	claimMu sync.RWMutex
 
 	// Viewers (guarded by viewerMu)
 	viewerMu sync.RWMutex
 	viewers  map[string]*Viewer
 
+	// broadcastMu serializes appending a message to the buffer with fanning
+	// it out, so every viewer receives buffered messages in sequence order.
+	broadcastMu sync.Mutex
+
 	// Message buffer for late-join replay (guarded by bufMu)
 	bufMu      sync.RWMutex
 	messageBuf []BufferedMessage
 	seqCounter uint64
 
+	// Buffered messages not yet written to TabMessageStore (guarded by
+	// persistMu). persistWake nudges the writer goroutine, which closes
+	// persistDone once it has flushed after the host stops.
+	persistMu      sync.Mutex
+	persistPending []BufferedMessage
+	persistWake    chan struct{}
+	persistDone    chan struct{}
+
 	// Prompt lifecycle state.
-	// promptMu guards promptInFlight (serialization gate only).
+	// promptMu guards promptInFlight and promptQueue.
 	promptMu       sync.Mutex
 	promptInFlight bool
 	promptSeq      uint64
+	// promptQueue holds prompts waiting behind the in-flight prompt, oldest
+	// first. Bounded by SessionHostConfig.MaxQueuedPrompts.
+	promptQueue []queuedPrompt
+
+	// Permission requests waiting for a viewer's answer, keyed by request ID
+	// (guarded by permissionMu).
+	permissionMu       sync.Mutex
+	pendingPermissions map[string]*pendingPermission
+	permissionSeq      uint64
+
+	// Terminals started by the agent via ACP terminal/create, keyed by
+	// terminal ID (guarded by terminalMu).
+	terminalMu  sync.Mutex
+	terminals   map[string]*agentTerminal
+	terminalSeq uint64
 	// promptCancelMu guards promptCancel independently from promptMu so that
 	// CancelPrompt() can read it without waiting for Prompt() to finish.
 	promptCancelMu sync.Mutex
@@ -270,6 +401,12 @@ type SessionHost struct {
 	// cancelled by a viewer or control-plane request.
 	// Protected by promptCancelMu.
 	promptCancelRequested bool
+	// promptCancellation describes the first cancel request for the current
+	// prompt (initiator and reason). Protected by promptCancelMu.
+	promptCancellation *PromptCancellation
+	// promptOutputSeen records whether the agent streamed any output during
+	// the current prompt, so cancellations can flag partial output.
+	promptOutputSeen atomic.Bool
 
 	// Crash recovery state (guarded by mu). When a prompt fails because the
 	// agent process disconnected, finishPromptWithError records this context
@@ -288,6 +425,15 @@ type SessionHost struct {
 	// Auto-suspend timer (guarded by viewerMu)
 	suspendTimer *time.Timer
 
+	// Companion agents keyed by agent type (guarded by companionMu). On a
+	// companion, companionParent is the primary host its messages are
+	// relayed through and companionAgent its agent type; both are set once
+	// at creation.
+	companionMu     sync.Mutex
+	companions      map[string]*SessionHost
+	companionParent *SessionHost
+	companionAgent  string
+
 	// Lifecycle
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -305,10 +451,13 @@ func NewSessionHost(config SessionHostConfig) *SessionHost {
 	if config.StderrBufferBytes <= 0 {
 		config.StderrBufferBytes = DefaultStderrBufferBytes
 	}
+	if config.PermissionTimeout <= 0 {
+		config.PermissionTimeout = DefaultPermissionTimeout
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 
-	return &SessionHost{
+	h := &SessionHost{
 		config:     config,
 		status:     HostIdle,
 		viewers:    make(map[string]*Viewer),
@@ -316,6 +465,14 @@ func NewSessionHost(config SessionHostConfig) *SessionHost {
 		ctx:        ctx,
 		cancel:     cancel,
 	}
+	if config.TabMessageStore != nil {
+		h.restoreMessageBuffer()
+		h.startMessagePersister()
+	}
+	if config.ViewerAckInterval > 0 {
+		go h.runViewerAcks()
+	}
+	return h
 }
 
 // httpClient returns the configured HTTP client for control-plane calls,
@@ -353,10 +510,63 @@ func (h *SessionHost) ViewerCount() int {
 	return len(h.viewers)
 }
 
+// SetViewerRole changes the role of an attached viewer. Unknown viewer IDs
+// are ignored.
+func (h *SessionHost) SetViewerRole(viewerID string, role ViewerRole) {
+	h.viewerMu.Lock()
+	defer h.viewerMu.Unlock()
+	if viewer, ok := h.viewers[viewerID]; ok {
+		viewer.role = role
+	}
+}
+
+// ViewerRole returns the role of an attached viewer. Viewers that are not
+// attached, such as the control plane's prompt relay, are read-write.
+func (h *SessionHost) ViewerRole(viewerID string) ViewerRole {
+	h.viewerMu.RLock()
+	defer h.viewerMu.RUnlock()
+	if viewer, ok := h.viewers[viewerID]; ok {
+		return viewer.role
+	}
+	return ViewerRoleReadWrite
+}
+
+// ReplayBufferSize returns the number of buffered replay messages and their
+// total size in bytes.
+func (h *SessionHost) ReplayBufferSize() (messages, bytes int) {
+	h.bufMu.RLock()
+	defer h.bufMu.RUnlock()
+	for _, msg := range h.messageBuf {
+		bytes += len(msg.Data)
+	}
+	return len(h.messageBuf), bytes
+}
+
 // AttachViewer registers a new WebSocket connection as a viewer of this session.
 // It sends the current session state, replays all buffered messages, then signals
 // replay completion. Returns nil if the session is stopped.
 func (h *SessionHost) AttachViewer(id string, conn *websocket.Conn) *Viewer {
+	return h.AttachViewerAfter(id, conn, 0)
+}
+
+// AttachViewerAfter is AttachViewer for a reconnecting client that already
+// holds every message up to lastSeq. See AttachViewerSinkAfter.
+func (h *SessionHost) AttachViewerAfter(id string, conn *websocket.Conn, lastSeq uint64) *Viewer {
+	return h.AttachViewerSinkAfter(id, websocketSink{conn: conn}, lastSeq)
+}
+
+// AttachViewerSink is AttachViewer for an arbitrary transport, such as the
+// PollQueue behind the HTTP long-poll fallback.
+func (h *SessionHost) AttachViewerSink(id string, sink ViewerSink) *Viewer {
+	return h.AttachViewerSinkAfter(id, sink, 0)
+}
+
+// AttachViewerSinkAfter attaches a viewer that already holds every message
+// up to lastSeq, the last session_ack it received. When the buffer still
+// reaches back to lastSeq, only newer messages are replayed and session_state
+// carries replayAfterSeq; otherwise, or when lastSeq is 0, the whole buffer
+// is replayed.
+func (h *SessionHost) AttachViewerSinkAfter(id string, sink ViewerSink, lastSeq uint64) *Viewer {
 	h.mu.RLock()
 	if h.status == HostStopped {
 		h.mu.RUnlock()
@@ -368,10 +578,12 @@ func (h *SessionHost) AttachViewer(id string, conn *websocket.Conn) *Viewer {
 	h.mu.RUnlock()
 
 	viewer := &Viewer{
-		ID:     id,
-		conn:   conn,
-		sendCh: make(chan []byte, h.config.ViewerSendBuffer),
-		done:   make(chan struct{}),
+		ID:       id,
+		sink:     sink,
+		sendCh:   make(chan viewerMessage, h.config.ViewerSendBuffer),
+		done:     make(chan struct{}),
+		role:     ViewerRoleReadWrite,
+		resyncCh: make(chan struct{}, 1),
 	}
 
 	// Register the viewer BEFORE starting the write pump goroutine to
@@ -393,14 +605,15 @@ func (h *SessionHost) AttachViewer(id string, conn *websocket.Conn) *Viewer {
 	slog.Info("SessionHost: viewer attached", "sessionID", h.config.SessionID, "viewerID", id, "totalViewers", h.ViewerCount())
 
 	// Send current session state
-	h.sendToViewerPriority(viewer, h.marshalSessionState(currentStatus, currentAgentType, currentErr))
+	replay, afterSeq, endSeq := h.replaySince(lastSeq)
+	h.sendToViewerPriority(viewer, h.marshalSessionStateForReplay(currentStatus, currentAgentType, currentErr, len(replay), afterSeq))
 
 	// Replay buffered messages
-	h.replayToViewer(viewer)
+	h.replayToViewer(viewer, replay, afterSeq)
 
 	// Signal replay complete — use blocking send so we don't evict buffered
 	// replay messages (sendToViewerPriority evicts on full channel).
-	h.sendToViewerWithTimeout(viewer, h.marshalControl(MsgSessionReplayDone, nil), 5*time.Second)
+	h.sendToViewerWithTimeout(viewer, viewerMessage{data: h.marshalControl(MsgSessionReplayDone, nil)}, 5*time.Second)
 
 	// Send a post-replay authoritative state snapshot with replayCount=0.
 	// This closes the race where prompt status changes during replay and the
@@ -409,7 +622,12 @@ func (h *SessionHost) AttachViewer(id string, conn *websocket.Conn) *Viewer {
 	// browser to re-enter replay mode, calling prepareForReplay() which wipes
 	// all just-replayed messages.
 	finalStatus, finalAgentType, finalErr := h.currentSessionState()
-	h.sendToViewerWithTimeout(viewer, h.marshalSessionStateWithReplayCount(finalStatus, finalAgentType, finalErr, 0), 5*time.Second)
+	h.sendToViewerWithTimeout(viewer, viewerMessage{data: h.marshalSessionStateWithReplayCount(finalStatus, finalAgentType, finalErr, 0)}, 5*time.Second)
+
+	// Messages broadcast since registration were queued live, so once the
+	// replay is queued the viewer has everything up to endSeq.
+	viewer.noteQueuedSeq(endSeq)
+	viewer.ackReady.Store(true)
 
 	return viewer
 }
@@ -488,24 +706,29 @@ func (h *SessionHost) autoSuspend() {
 	})
 }
 
-// CancelPrompt cancels the currently running Prompt() call, if any.
-// This is safe to call from any goroutine. If no prompt is in flight,
-// it's a no-op. The cancel function is guarded by promptCancelMu
-// (separate from promptMu) so we never deadlock with HandlePrompt.
+// CancelPrompt cancels the currently running Prompt() call, if any, on
+// behalf of the user. This is safe to call from any goroutine. If no prompt
+// is in flight, it's a no-op. The cancel function is guarded by
+// promptCancelMu (separate from promptMu) so we never deadlock with
+// HandlePrompt.
 func (h *SessionHost) CancelPrompt() {
-	h.cancelPrompt(true)
+	h.cancelPrompt(PromptCancellation{Initiator: CancelByUser}, true)
 }
 
 // CancelPromptFromControlPlane mirrors the viewer WebSocket session/cancel path
-// for HTTP control-plane cancellation requests.
-func (h *SessionHost) CancelPromptFromControlPlane() {
+// for HTTP control-plane cancellation requests. cancellation records who
+// asked for the cancel and why; it is surfaced to viewers and persisted in
+// the session transcript.
+func (h *SessionHost) CancelPromptFromControlPlane(cancellation PromptCancellation) {
 	if h.AgentType() == "opencode" {
-		h.cancelPrompt(false)
+		h.cancelCompanionPrompts(cancellation)
+		h.cancelPrompt(cancellation, false)
 		h.StopProcessForPromptCancel()
 		return
 	}
 
-	h.CancelPrompt()
+	h.cancelCompanionPrompts(cancellation)
+	h.cancelPrompt(cancellation, true)
 	cancelMessage, err := h.cancelNotification()
 	if err != nil {
 		slog.Warn("CancelPromptFromControlPlane: could not build session/cancel notification", "error", err)
@@ -546,12 +769,20 @@ func (h *SessionHost) currentSessionIDForCancel() string {
 	return h.config.SessionID
 }
 
-func (h *SessionHost) cancelPrompt(startGraceTimer bool) {
+func (h *SessionHost) cancelPrompt(cancellation PromptCancellation, startGraceTimer bool) {
 	h.promptCancelMu.Lock()
 	cancelFn := h.promptCancel
 	promptID := h.activePromptID
 	if cancelFn != nil {
 		h.promptCancelRequested = true
+		// The first request wins: a user pressing stop after a budget cancel
+		// must not relabel the cancellation.
+		if h.promptCancellation == nil {
+			if cancellation.CancelledAt.IsZero() {
+				cancellation.CancelledAt = time.Now().UTC()
+			}
+			h.promptCancellation = &cancellation
+		}
 	}
 	h.promptCancelMu.Unlock()
 
@@ -560,9 +791,15 @@ func (h *SessionHost) cancelPrompt(startGraceTimer bool) {
 		return
 	}
 
-	slog.Info("CancelPrompt: cancelling in-flight prompt")
-	h.reportLifecycle("info", "Prompt cancel requested", nil)
+	slog.Info("CancelPrompt: cancelling in-flight prompt", "initiator", string(cancellation.Initiator))
+	h.reportLifecycle("info", "Prompt cancel requested", map[string]interface{}{
+		"initiator": string(cancellation.Initiator),
+		"reason":    cancellation.Reason,
+	})
 	cancelFn()
+	// ACP requires clients to answer outstanding permission requests with
+	// the cancelled outcome once the prompt turn is cancelled.
+	h.cancelPendingPermissions()
 
 	if !startGraceTimer {
 		return
@@ -577,7 +814,7 @@ func (h *SessionHost) cancelPrompt(startGraceTimer bool) {
 		timer := time.NewTimer(wait)
 		defer timer.Stop()
 		<-timer.C
-		h.triggerPromptForceStopIfStuck(id, fmt.Sprintf("Prompt cancel grace elapsed after %s", wait))
+		h.triggerPromptForceStopIfStuck(id, fmt.Sprintf("Prompt cancel grace elapsed after %s", wait), cancellation.Initiator)
 	}(promptID, grace)
 }
 
@@ -656,11 +893,16 @@ func (h *SessionHost) Stop() {
 		agentType:     h.agentType,
 	}
 	h.mu.Unlock()
+	h.stopCompanions()
 
 	// Sync refreshed credentials back to the control plane before cleanup.
 	// The agent process is dead but the container is still alive.
 	h.syncCredentialOnStop(snap)
 
+	h.dropQueuedPrompts("the session stopped")
+	h.cancelPendingPermissions()
+	h.releaseAllTerminals()
+
 	// Report idle to the control plane so the browser status bar clears.
 	h.stopPromptActivityRereport()
 	h.reportActivity("idle")
@@ -674,6 +916,7 @@ func (h *SessionHost) Stop() {
 	h.viewerMu.Unlock()
 
 	h.cancel()
+	h.waitMessagePersister()
 
 	h.reportLifecycle("info", "SessionHost stopped", map[string]interface{}{
 		"sessionId": h.config.SessionID,
@@ -683,12 +926,7 @@ func (h *SessionHost) Stop() {
 	h.viewerMu.Lock()
 	for id, viewer := range h.viewers {
 		viewer.once.Do(func() { close(viewer.done) })
-		_ = viewer.conn.WriteControl(
-			websocket.CloseMessage,
-			websocket.FormatCloseMessage(websocket.CloseGoingAway, "session stopped"),
-			time.Now().Add(5*time.Second),
-		)
-		_ = viewer.conn.Close()
+		viewer.sink.CloseWithReason("session stopped")
 		delete(h.viewers, id)
 	}
 	h.viewerMu.Unlock()
@@ -811,6 +1049,7 @@ func (h *SessionHost) ensureAgentInstalled(ctx context.Context, info agentComman
 	// (with and without mutex), so we skip the redundant `which` check here
 	// and just broadcast the installing status before delegating.
 	h.broadcastAgentStatus(StatusInstalling, info.command, "")
+	ctx = execaudit.WithActor(ctx, h.config.WorkspaceID, execaudit.InitiatorAgentSession)
 	return installAgentBinary(ctx, containerID, info)
 }
 
@@ -818,7 +1057,7 @@ func (h *SessionHost) ensureAgentInstalled(ctx context.Context, info agentComman
 func (h *SessionHost) monitorStderr(process agentProcess) {
 	scanner := bufio.NewScanner(process.Stderr())
 	for scanner.Scan() {
-		line := scanner.Text()
+		line := redact.String(scanner.Text())
 		slog.Warn("Agent stderr", "line", line)
 		h.stderrMu.Lock()
 		if h.stderrBuf.Len() < h.config.StderrBufferBytes {
@@ -910,6 +1149,7 @@ func (h *SessionHost) stopCurrentAgentLocked() {
 	h.credInjectionMode = ""
 	h.credAuthFilePath = ""
 	h.credKind = ""
+	h.agentCred = nil
 }
 
 // persistAcpSessionID saves the ACP session ID for reconnection support.
@@ -961,23 +1201,43 @@ func (h *SessionHost) persistLastPrompt(text string) {
 	}
 }
 
-// IsPrompting returns true if a prompt is currently in flight.
+// IsPrompting returns true if a prompt is currently in flight on the primary
+// agent or a companion.
 // Used by the auto-suspend timer to avoid interrupting active work.
 func (h *SessionHost) IsPrompting() bool {
 	h.mu.RLock()
-	defer h.mu.RUnlock()
-	return h.status == HostPrompting
+	prompting := h.status == HostPrompting
+	h.mu.RUnlock()
+	if prompting {
+		return true
+	}
+	for _, c := range h.companionHosts() {
+		if c.IsPrompting() {
+			return true
+		}
+	}
+	return false
 }
 
 func (h *SessionHost) isPromptingOrRecovering() bool {
 	h.mu.RLock()
-	defer h.mu.RUnlock()
-	return h.status == HostPrompting || h.status == HostStarting || h.crashRecoveryInProgress
+	active := h.status == HostPrompting || h.status == HostStarting || h.crashRecoveryInProgress
+	h.mu.RUnlock()
+	return active || h.companionPromptActive()
 }
 
 // OnPromptCompleteCallback returns the OnPromptComplete callback, if configured.
 // Used by server-initiated prompt flows to report agent start failures back to
 // the control plane without going through HandlePrompt.
 func (h *SessionHost) OnPromptCompleteCallback() func(string, error) {
+	h.claimMu.RLock()
+	defer h.claimMu.RUnlock()
 	return h.config.OnPromptComplete
 }
+
+// messageReporter returns the configured MessageReporter, if any.
+func (h *SessionHost) messageReporter() MessageReporter {
+	h.claimMu.RLock()
+	defer h.claimMu.RUnlock()
+	return h.config.MessageReporter
+}
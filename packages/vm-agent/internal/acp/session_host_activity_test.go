@@ -48,7 +48,7 @@ func TestPromptActivityRereportStopsBeforeIdle(t *testing.T) {
 		return countActivity(&mu, &activities, "prompting") >= 2
 	})
 
-	host.markPromptDone()
+	host.markPromptDone("end_turn", nil)
 	waitFor(t, 250*time.Millisecond, func() bool {
 		return countActivity(&mu, &activities, "idle") >= 1
 	})
@@ -3,52 +3,130 @@ package acp
 import (
 	"encoding/json"
 	"log/slog"
+	"sort"
 	"sync/atomic"
 	"time"
 
-	"github.com/gorilla/websocket"
+	"github.com/workspace/vm-agent/internal/faultinject"
 )
 
 // --- Internal: message broadcasting ---
 
-// appendMessage appends a message to the replay buffer.
-func (h *SessionHost) appendMessage(data []byte) {
+// appendMessage appends a message to the replay buffer and returns its
+// sequence number.
+func (h *SessionHost) appendMessage(data []byte) uint64 {
 	// Append to buffer — sequence number assigned under lock to ensure
 	// buffer ordering matches sequence ordering under concurrent writes.
 	h.bufMu.Lock()
 	seq := atomic.AddUint64(&h.seqCounter, 1)
-	h.messageBuf = append(h.messageBuf, BufferedMessage{
+	msg := BufferedMessage{
 		Data:      data,
 		SeqNum:    seq,
 		Timestamp: time.Now(),
-	})
+	}
+	h.messageBuf = append(h.messageBuf, msg)
+	h.queuePersistedMessage(msg)
 	// Evict oldest if over limit
 	if len(h.messageBuf) > h.config.MessageBufferSize {
 		excess := len(h.messageBuf) - h.config.MessageBufferSize
 		h.messageBuf = h.messageBuf[excess:]
 	}
 	h.bufMu.Unlock()
+	return seq
 }
 
-// broadcastMessage appends a message to the buffer and sends it to all viewers.
+// broadcastMessage appends a message to the buffer and sends it to all
+// viewers. A viewer whose send channel is full is marked lagging instead of
+// losing the message; see queueBufferedMessage.
 func (h *SessionHost) broadcastMessage(data []byte) {
-	h.broadcastMessageWithPriority(data, false)
-}
-
-func (h *SessionHost) broadcastMessageWithPriority(data []byte, priority bool) {
-	h.appendMessage(data)
+	if h.companionParent != nil {
+		h.companionParent.broadcastMessage(h.tagCompanionMessage(data))
+		return
+	}
+	h.broadcastMu.Lock()
+	defer h.broadcastMu.Unlock()
+	seq := h.appendMessage(data)
 	// Fan out to all viewers
 	h.viewerMu.RLock()
 	for _, viewer := range h.viewers {
-		if priority {
-			h.sendToViewerPriority(viewer, data)
-		} else {
-			h.sendToViewer(viewer, data)
-		}
+		h.queueBufferedMessage(viewer, data, seq)
 	}
 	h.viewerMu.RUnlock()
 }
 
+// queueBufferedMessage queues buffered message seq for a viewer. Lagging
+// viewers are skipped: their resync replays the message. If the channel is
+// full the viewer starts lagging from the last message it was sent, rather
+// than getting a gap in the middle of an agent response.
+func (h *SessionHost) queueBufferedMessage(viewer *Viewer, data []byte, seq uint64) {
+	if viewer.isLagging() {
+		return
+	}
+	select {
+	case viewer.sendCh <- viewerMessage{data: data, seq: seq}:
+		viewer.noteQueuedSeq(seq)
+	case <-viewer.done:
+	default:
+		h.markViewerLagging(viewer, viewer.queuedSeq.Load())
+	}
+}
+
+// markViewerLagging records that viewer missed buffered messages after
+// resumeSeq. Once its write pump drains the send channel, resyncViewer
+// replays them; queued copies of those messages are skipped by the pump so
+// they are not written twice. A second call can only move resumeSeq back.
+// The pump is woken under the same lock, so a channel that drained before
+// the viewer was marked still gets its resync.
+func (h *SessionHost) markViewerLagging(viewer *Viewer, resumeSeq uint64) {
+	viewer.lagMu.Lock()
+	defer viewer.lagMu.Unlock()
+	if viewer.lagging {
+		viewer.resumeSeq = min(viewer.resumeSeq, resumeSeq)
+		return
+	}
+	viewer.lagging = true
+	viewer.resumeSeq = resumeSeq
+	select {
+	case viewer.resyncCh <- struct{}{}:
+	default:
+	}
+	slog.Warn("SessionHost: viewer send buffer full, viewer is lagging", "sessionID", h.config.SessionID, "viewerID", viewer.ID, "resumeSeq", resumeSeq)
+}
+
+// resyncViewer brings a lagging viewer up to date. It is called by the
+// viewer's write pump once the send channel is empty and writes the
+// session_resync and the missed messages straight to the sink, so the replay
+// cannot overflow the channel again. Holding broadcastMu while clearing the
+// lagging flag ensures every later broadcast is queued behind the replay.
+func (h *SessionHost) resyncViewer(viewer *Viewer) error {
+	h.broadcastMu.Lock()
+	viewer.lagMu.Lock()
+	resumeSeq := viewer.resumeSeq
+	viewer.lagging = false
+	viewer.lagMu.Unlock()
+	messages, afterSeq, endSeq := h.replaySince(resumeSeq)
+	viewer.lagMu.Lock()
+	viewer.resyncedSeq = endSeq
+	viewer.lagMu.Unlock()
+	viewer.queuedSeq.Store(endSeq)
+	h.broadcastMu.Unlock()
+
+	slog.Info("SessionHost: resyncing lagging viewer", "sessionID", h.config.SessionID, "viewerID", viewer.ID, "replayAfterSeq", afterSeq, "replayCount", len(messages))
+	data, err := json.Marshal(SessionResyncMessage{Type: MsgSessionResync, ReplayCount: len(messages), ReplayAfterSeq: afterSeq})
+	if err != nil {
+		return err
+	}
+	if err := h.writeToViewerSink(viewer, data); err != nil {
+		return err
+	}
+	for _, msg := range messages {
+		if err := h.writeToViewerSink(viewer, msg.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // broadcastAgentStatus broadcasts an agent_status control message to all viewers
 // and buffers it for late-join replay.
 func (h *SessionHost) broadcastAgentStatus(status AgentStatus, agentType, errMsg string) {
@@ -59,48 +137,91 @@ func (h *SessionHost) broadcastAgentStatus(status AgentStatus, agentType, errMsg
 		Error:     errMsg,
 	}
 	data, _ := json.Marshal(msg)
-	h.broadcastMessageWithPriority(data, true)
+	h.broadcastMessage(data)
 }
 
 func (h *SessionHost) broadcastAgentCrashReport(report AgentCrashReportMessage) {
 	data, _ := json.Marshal(report)
-	h.broadcastMessageWithPriority(data, true)
+	h.broadcastMessage(data)
 }
 
 // broadcastControl broadcasts a control message to all viewers and buffers it.
 func (h *SessionHost) broadcastControl(msgType ControlMessageType, extra map[string]interface{}) {
 	data := h.marshalControl(msgType, extra)
-	h.broadcastMessageWithPriority(data, true)
+	h.broadcastMessage(data)
 }
 
-// replayToViewer sends all buffered messages to a newly attached viewer.
-// Uses a blocking send with timeout to avoid silently dropping messages when
-// the viewer's send channel fills faster than the write pump can drain it.
-func (h *SessionHost) replayToViewer(viewer *Viewer) {
-	h.bufMu.RLock()
-	messages := make([]BufferedMessage, len(h.messageBuf))
-	copy(messages, h.messageBuf)
-	h.bufMu.RUnlock()
+// BroadcastTransient sends a control message to all currently attached
+// viewers without appending it to the replay buffer. Used for workspace-level
+// messages whose late-join replay is owned by the caller rather than by a
+// single session's buffer.
+func (h *SessionHost) BroadcastTransient(data []byte) {
+	h.viewerMu.RLock()
+	for _, viewer := range h.viewers {
+		h.sendToViewerPriority(viewer, data)
+	}
+	h.viewerMu.RUnlock()
+}
 
-	dropped := 0
-	for _, msg := range messages {
-		if !h.sendToViewerWithTimeout(viewer, msg.Data, 5*time.Second) {
-			dropped++
-			break // viewer gone or persistently blocked — stop replay
+// SendToViewer sends a control message to a single attached viewer without
+// buffering it. No-op if the viewer has detached.
+func (h *SessionHost) SendToViewer(viewerID string, data []byte) {
+	h.viewerMu.RLock()
+	viewer, ok := h.viewers[viewerID]
+	h.viewerMu.RUnlock()
+	if ok {
+		h.sendToViewerPriority(viewer, data)
+	}
+}
+
+// replaySince returns the buffered messages a viewer holding every message
+// up to lastSeq still needs, and the lastSeq the replay resumes from. If the
+// buffer no longer reaches back to lastSeq, or lastSeq is from a sequence
+// this host never issued, the whole buffer is returned with afterSeq 0.
+// endSeq is the sequence number of the newest buffered message.
+func (h *SessionHost) replaySince(lastSeq uint64) (messages []BufferedMessage, afterSeq, endSeq uint64) {
+	h.bufMu.RLock()
+	defer h.bufMu.RUnlock()
+	endSeq = atomic.LoadUint64(&h.seqCounter)
+	start := 0
+	if lastSeq > 0 && lastSeq <= endSeq {
+		switch {
+		case len(h.messageBuf) == 0 && lastSeq == endSeq:
+			afterSeq = lastSeq
+		case len(h.messageBuf) > 0 && h.messageBuf[0].SeqNum <= lastSeq+1:
+			afterSeq = lastSeq
+			start = sort.Search(len(h.messageBuf), func(i int) bool { return h.messageBuf[i].SeqNum > lastSeq })
 		}
 	}
-	if dropped > 0 {
-		slog.Warn("SessionHost: viewer replay aborted", "sessionID", h.config.SessionID, "viewerID", viewer.ID, "delivered", len(messages)-dropped, "total", len(messages))
+	messages = make([]BufferedMessage, len(h.messageBuf)-start)
+	copy(messages, h.messageBuf[start:])
+	return messages, afterSeq, endSeq
+}
+
+// replayToViewer sends buffered messages to a newly attached viewer.
+// Uses a blocking send with timeout to avoid silently dropping messages when
+// the viewer's send channel fills faster than the write pump can drain it.
+// A persistently blocked viewer is marked lagging from the last message
+// queued, so the rest of the replay follows as a resync.
+func (h *SessionHost) replayToViewer(viewer *Viewer, messages []BufferedMessage, afterSeq uint64) {
+	resumeSeq := afterSeq
+	for i, msg := range messages {
+		if !h.sendToViewerWithTimeout(viewer, viewerMessage{data: msg.Data, seq: msg.SeqNum}, 5*time.Second) {
+			slog.Warn("SessionHost: viewer replay stalled", "sessionID", h.config.SessionID, "viewerID", viewer.ID, "delivered", i, "total", len(messages))
+			h.markViewerLagging(viewer, resumeSeq)
+			return
+		}
+		resumeSeq = msg.SeqNum
 	}
 }
 
 // sendToViewerWithTimeout sends a message with a blocking timeout.
 // Returns true if sent, false if the viewer is gone or the timeout expired.
-func (h *SessionHost) sendToViewerWithTimeout(viewer *Viewer, data []byte, timeout time.Duration) bool {
+func (h *SessionHost) sendToViewerWithTimeout(viewer *Viewer, msg viewerMessage, timeout time.Duration) bool {
 	timer := time.NewTimer(timeout)
 	defer timer.Stop()
 	select {
-	case viewer.sendCh <- data:
+	case viewer.sendCh <- msg:
 		return true
 	case <-viewer.done:
 		return false
@@ -110,45 +231,55 @@ func (h *SessionHost) sendToViewerWithTimeout(viewer *Viewer, data []byte, timeo
 	}
 }
 
-// sendToViewer sends a message to a single viewer via its buffered channel.
-// If the channel is full, the message is dropped (viewer can reconnect).
-func (h *SessionHost) sendToViewer(viewer *Viewer, data []byte) {
-	select {
-	case viewer.sendCh <- data:
-	case <-viewer.done:
-	default:
-		// Channel full — drop message for this viewer
-		slog.Warn("SessionHost: viewer send buffer full, dropping message", "sessionID", h.config.SessionID, "viewerID", viewer.ID)
-	}
-}
-
-// sendToViewerPriority sends a high-priority message.
-// If the channel is full, we evict one queued message and retry once so
-// control/status updates are not silently dropped under replay backpressure.
+// sendToViewerPriority sends a high-priority message that is not in the
+// replay buffer. If the channel is full, we evict one queued message and
+// retry once so control/status updates are not silently dropped under
+// replay backpressure. If the evicted message was a buffered one, the viewer
+// is resynced from the message before it. A viewer that is already lagging
+// drops the message instead of losing more of its queue.
 func (h *SessionHost) sendToViewerPriority(viewer *Viewer, data []byte) {
+	msg := viewerMessage{data: data}
 	select {
-	case viewer.sendCh <- data:
+	case viewer.sendCh <- msg:
 		return
 	case <-viewer.done:
 		return
 	default:
 	}
+	if viewer.isLagging() {
+		slog.Warn("SessionHost: priority message dropped for lagging viewer", "sessionID", h.config.SessionID, "viewerID", viewer.ID)
+		return
+	}
 
 	// Make room by dropping one queued item for this viewer.
 	select {
-	case <-viewer.sendCh:
+	case evicted := <-viewer.sendCh:
+		if evicted.seq > 0 {
+			h.markViewerLagging(viewer, evicted.seq-1)
+		} else {
+			slog.Warn("SessionHost: transient message evicted for priority message", "sessionID", h.config.SessionID, "viewerID", viewer.ID)
+		}
 	default:
 	}
 
 	select {
-	case viewer.sendCh <- data:
+	case viewer.sendCh <- msg:
 	case <-viewer.done:
 	default:
 		slog.Warn("SessionHost: viewer priority message dropped (buffer saturated)", "sessionID", h.config.SessionID, "viewerID", viewer.ID)
 	}
 }
 
-// viewerWritePump drains the viewer's send channel and writes to its WebSocket.
+// writeToViewerSink writes one message to the viewer's transport. Only the
+// viewer's write pump calls it.
+func (h *SessionHost) writeToViewerSink(viewer *Viewer, data []byte) error {
+	if err := faultinject.Check(faultinject.WebSocketWrite); err != nil {
+		return err
+	}
+	return viewer.sink.WriteMessage(data)
+}
+
+// viewerWritePump drains the viewer's send channel and writes to its sink.
 // On write failure, it signals done so the Gateway read loop exits immediately
 // instead of waiting for a read deadline timeout.
 func (h *SessionHost) viewerWritePump(viewer *Viewer) {
@@ -157,20 +288,33 @@ func (h *SessionHost) viewerWritePump(viewer *Viewer) {
 		// can detect the failure immediately via the done channel select case,
 		// rather than waiting for the read deadline (40s) to expire.
 		viewer.once.Do(func() { close(viewer.done) })
-		viewer.conn.Close()
+		viewer.sink.Close()
 	}()
 
 	for {
 		select {
-		case data, ok := <-viewer.sendCh:
+		case msg, ok := <-viewer.sendCh:
 			if !ok {
 				return
 			}
-			viewer.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if err := viewer.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			var err error
+			if msg.seq == 0 || !viewer.skipsSeq(msg.seq) {
+				err = h.writeToViewerSink(viewer, msg.data)
+			}
+			if err == nil && len(viewer.sendCh) == 0 && viewer.isLagging() {
+				err = h.resyncViewer(viewer)
+			}
+			if err != nil {
 				slog.Warn("SessionHost: viewer write failed", "sessionID", h.config.SessionID, "viewerID", viewer.ID, "error", err)
 				return
 			}
+		case <-viewer.resyncCh:
+			if len(viewer.sendCh) == 0 && viewer.isLagging() {
+				if err := h.resyncViewer(viewer); err != nil {
+					slog.Warn("SessionHost: viewer write failed", "sessionID", h.config.SessionID, "viewerID", viewer.ID, "error", err)
+					return
+				}
+			}
 		case <-viewer.done:
 			return
 		case <-h.ctx.Done():
@@ -198,6 +342,10 @@ func (h *SessionHost) SendPongToViewer(viewerID string) {
 // sendJSONRPCErrorToViewer sends a JSON-RPC error to a specific viewer.
 func (h *SessionHost) sendJSONRPCErrorToViewer(viewerID string, reqID json.RawMessage, code int, message string) {
 	data := h.marshalJSONRPCError(reqID, code, message)
+	if h.companionParent != nil {
+		h.companionParent.SendToViewer(viewerID, h.tagCompanionMessage(data))
+		return
+	}
 
 	h.viewerMu.RLock()
 	viewer, ok := h.viewers[viewerID]
@@ -208,6 +356,72 @@ func (h *SessionHost) sendJSONRPCErrorToViewer(viewerID string, reqID json.RawMe
 	}
 }
 
+// rejectReadOnlyViewer reports whether viewerID is read-only and, if so,
+// sends it a viewer_error naming the refused message. reqID, when set, also
+// gets a JSON-RPC error so the client's pending request settles.
+func (h *SessionHost) rejectReadOnlyViewer(viewerID, rejected string, reqID json.RawMessage) bool {
+	if h.ViewerRole(viewerID) != ViewerRoleReadOnly {
+		return false
+	}
+	slog.Info("SessionHost: rejected message from read-only viewer", "sessionID", h.config.SessionID, "viewerID", viewerID, "rejected", rejected)
+	data, err := json.Marshal(ViewerErrorMessage{
+		Type:     MsgViewerError,
+		Code:     ViewerErrorReadOnly,
+		Message:  "This viewer is read-only",
+		Rejected: rejected,
+	})
+	if err == nil {
+		h.SendToViewer(viewerID, data)
+	}
+	if len(reqID) > 0 {
+		h.sendJSONRPCErrorToViewer(viewerID, reqID, -32600, "Viewer is read-only")
+	}
+	return true
+}
+
+// runViewerAcks sends session_ack messages every ViewerAckInterval until
+// the host stops.
+func (h *SessionHost) runViewerAcks() {
+	ticker := time.NewTicker(h.config.ViewerAckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-ticker.C:
+			h.sendViewerAcks()
+		}
+	}
+}
+
+// sendViewerAcks queues a session_ack for every viewer that received new
+// buffered messages since its last ack. The ack goes through the viewer's
+// send channel behind the messages it covers. Lagging viewers are not acked
+// until their resync is written.
+func (h *SessionHost) sendViewerAcks() {
+	h.viewerMu.RLock()
+	defer h.viewerMu.RUnlock()
+	for _, viewer := range h.viewers {
+		if !viewer.ackReady.Load() || viewer.isLagging() {
+			continue
+		}
+		seq := viewer.queuedSeq.Load()
+		if seq == 0 || seq == viewer.ackedSeq {
+			continue
+		}
+		data, err := json.Marshal(SessionAckMessage{Type: MsgSessionAck, Seq: seq})
+		if err != nil {
+			continue
+		}
+		// A full channel just skips this ack; the next tick retries.
+		select {
+		case viewer.sendCh <- viewerMessage{data: data}:
+			viewer.ackedSeq = seq
+		default:
+		}
+	}
+}
+
 // --- Internal: message marshaling ---
 
 func (h *SessionHost) marshalSessionState(status SessionHostStatus, agentType, errMsg string) []byte {
@@ -223,13 +437,19 @@ func (h *SessionHost) marshalSessionStateWithReplayCount(status SessionHostStatu
 		replayCount = len(h.messageBuf)
 		h.bufMu.RUnlock()
 	}
+	return h.marshalSessionStateForReplay(status, agentType, errMsg, replayCount, 0)
+}
 
+// marshalSessionStateForReplay marshals the session_state that precedes a
+// replay of replayCount messages after afterSeq (0 for a full replay).
+func (h *SessionHost) marshalSessionStateForReplay(status SessionHostStatus, agentType, errMsg string, replayCount int, afterSeq uint64) []byte {
 	msg := SessionStateMessage{
-		Type:        MsgSessionState,
-		Status:      string(status),
-		AgentType:   agentType,
-		Error:       errMsg,
-		ReplayCount: replayCount,
+		Type:           MsgSessionState,
+		Status:         string(status),
+		AgentType:      agentType,
+		Error:          errMsg,
+		ReplayCount:    replayCount,
+		ReplayAfterSeq: afterSeq,
 	}
 	data, _ := json.Marshal(msg)
 	return data
@@ -4,14 +4,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log/slog"
-	"os/exec"
 	"strings"
 	"time"
 
 	acpsdk "github.com/coder/acp-go-sdk"
+
+	"github.com/workspace/vm-agent/internal/containerruntime"
+	"github.com/workspace/vm-agent/internal/fileenc"
 )
 
 // --- sessionHostClient: ACP SDK client interface ---
@@ -49,6 +51,12 @@ func (c *sessionHostClient) SessionUpdate(_ context.Context, params acpsdk.Sessi
 	if c.host.replaySuppressed.Load() {
 		return nil
 	}
+	if params.Update.UserMessageChunk == nil {
+		c.host.promptOutputSeen.Store(true)
+	}
+	if update := params.Update.UsageUpdate; update != nil {
+		c.host.recordSessionCost(update.Cost)
+	}
 
 	data, err := json.Marshal(map[string]interface{}{
 		"jsonrpc": "2.0",
@@ -63,10 +71,10 @@ func (c *sessionHostClient) SessionUpdate(_ context.Context, params acpsdk.Sessi
 	}
 
 	// Persist chat messages to the control plane via the message reporter.
-	if c.host.config.MessageReporter != nil {
+	if reporter := c.host.messageReporter(); reporter != nil {
 		msgs := ExtractMessages(params)
 		for _, m := range msgs {
-			if err := c.host.config.MessageReporter.Enqueue(MessageReportEntry{
+			if err := reporter.Enqueue(MessageReportEntry{
 				MessageID:    m.MessageID,
 				Role:         m.Role,
 				Content:      m.Content,
@@ -81,31 +89,8 @@ func (c *sessionHostClient) SessionUpdate(_ context.Context, params acpsdk.Sessi
 	return nil
 }
 
-func (c *sessionHostClient) RequestPermission(_ context.Context, params acpsdk.RequestPermissionRequest) (acpsdk.RequestPermissionResponse, error) {
-	data, err := json.Marshal(map[string]interface{}{
-		"jsonrpc": "2.0",
-		"method":  "permission/request",
-		"params":  params,
-	})
-	if err != nil {
-		return acpsdk.RequestPermissionResponse{}, fmt.Errorf("failed to marshal permission request: %w", err)
-	}
-	c.host.broadcastMessage(data)
-
-	mode := c.host.permissionMode
-	if mode == "" {
-		mode = "default"
-	}
-	slog.Info("Permission request", "mode", mode, "optionsCount", len(params.Options))
-
-	if len(params.Options) > 0 {
-		return acpsdk.RequestPermissionResponse{
-			Outcome: acpsdk.NewRequestPermissionOutcomeSelected(params.Options[0].OptionId),
-		}, nil
-	}
-	return acpsdk.RequestPermissionResponse{
-		Outcome: acpsdk.NewRequestPermissionOutcomeCancelled(),
-	}, nil
+func (c *sessionHostClient) RequestPermission(ctx context.Context, params acpsdk.RequestPermissionRequest) (acpsdk.RequestPermissionResponse, error) {
+	return c.host.requestPermission(ctx, params)
 }
 
 func (c *sessionHostClient) ReadTextFile(ctx context.Context, params acpsdk.ReadTextFileRequest) (acpsdk.ReadTextFileResponse, error) {
@@ -115,6 +100,15 @@ func (c *sessionHostClient) ReadTextFile(ctx context.Context, params acpsdk.Read
 	if strings.ContainsRune(params.Path, 0) {
 		return acpsdk.ReadTextFileResponse{}, fmt.Errorf("file path contains null byte")
 	}
+	rng, byteRange, err := metaFileByteRange(params.Meta)
+	if err != nil {
+		return acpsdk.ReadTextFileResponse{}, err
+	}
+	read := readFileParams{path: params.Path, line: params.Line, limit: params.Limit, byteRange: byteRange, rng: rng}
+	encoding, err := fileenc.ParseEncoding(metaString(params.Meta, MetaEncodingKey))
+	if err != nil {
+		return acpsdk.ReadTextFileResponse{}, err
+	}
 
 	containerID, err := c.host.config.ContainerResolver()
 	if err != nil {
@@ -128,23 +122,30 @@ func (c *sessionHostClient) ReadTextFile(ctx context.Context, params acpsdk.Read
 	execCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	content, stderr, err := execInContainer(execCtx, containerID, c.host.config.ContainerUser, "", "cat", params.Path)
+	// The cap applies to the encoded response.
+	maxSize := fileenc.DecodedLen(c.fileMaxSize(), encoding)
+	content, err := readContainerFile(execCtx, containerID, c.host.config.ContainerUser, read, maxSize)
+	if errors.Is(err, errFileTooLarge) {
+		return acpsdk.ReadTextFileResponse{}, fmt.Errorf("file %q exceeds maximum size of %d bytes per read; read it in parts with line and limit, or _meta %s and %s",
+			params.Path, maxSize, MetaOffsetKey, MetaLengthKey)
+	}
 	if err != nil {
-		slog.Error("ReadTextFile error", "path", params.Path, "error", err, "stderr", stderr)
+		slog.Error("ReadTextFile error", "path", params.Path, "error", err)
 		return acpsdk.ReadTextFileResponse{}, fmt.Errorf("failed to read file %q: %v", params.Path, err)
 	}
 
-	maxSize := c.host.config.FileMaxSize
-	if maxSize == 0 {
-		maxSize = 1048576
-	}
-	if len(content) > maxSize {
-		return acpsdk.ReadTextFileResponse{}, fmt.Errorf("file %q exceeds maximum size of %d bytes", params.Path, maxSize)
+	data := []byte(content)
+	if encoding == fileenc.Text {
+		if fileenc.IsBinary(data) {
+			return acpsdk.ReadTextFileResponse{}, fmt.Errorf("file %q is binary (%s); read it with _meta %s %q",
+				params.Path, fileenc.SniffMIME(data), MetaEncodingKey, fileenc.Base64)
+		}
+		return acpsdk.ReadTextFileResponse{Content: content}, nil
 	}
-
-	content = applyLineLimit(content, params.Line, params.Limit)
-
-	return acpsdk.ReadTextFileResponse{Content: content}, nil
+	return acpsdk.ReadTextFileResponse{
+		Content: fileenc.Encode(data, encoding),
+		Meta:    map[string]any{MetaEncodingKey: encoding, MetaMIMETypeKey: fileenc.SniffMIME(data)},
+	}, nil
 }
 
 func (c *sessionHostClient) WriteTextFile(ctx context.Context, params acpsdk.WriteTextFileRequest) (acpsdk.WriteTextFileResponse, error) {
@@ -155,12 +156,18 @@ func (c *sessionHostClient) WriteTextFile(ctx context.Context, params acpsdk.Wri
 		return acpsdk.WriteTextFileResponse{}, fmt.Errorf("file path contains null byte")
 	}
 
-	maxSize := c.host.config.FileMaxSize
-	if maxSize == 0 {
-		maxSize = 1048576
-	}
+	maxSize := c.fileMaxSize()
 	if len(params.Content) > maxSize {
-		return acpsdk.WriteTextFileResponse{}, fmt.Errorf("content exceeds maximum size of %d bytes", maxSize)
+		return acpsdk.WriteTextFileResponse{}, fmt.Errorf("content exceeds maximum size of %d bytes per write; write it in parts with _meta %s", maxSize, MetaAppendKey)
+	}
+	appendContent := metaBool(params.Meta, MetaAppendKey)
+	encoding, err := fileenc.ParseEncoding(metaString(params.Meta, MetaEncodingKey))
+	if err != nil {
+		return acpsdk.WriteTextFileResponse{}, err
+	}
+	data, err := fileenc.Decode(params.Content, encoding)
+	if err != nil {
+		return acpsdk.WriteTextFileResponse{}, err
 	}
 
 	containerID, err := c.host.config.ContainerResolver()
@@ -175,44 +182,48 @@ func (c *sessionHostClient) WriteTextFile(ctx context.Context, params acpsdk.Wri
 	execCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	dockerArgs := []string{"exec", "-i"}
-	if c.host.config.ContainerUser != "" {
-		dockerArgs = append(dockerArgs, "-u", c.host.config.ContainerUser)
+	// Appended chunks are part of a larger write; they are not diffed.
+	var previous string
+	var created, diffable bool
+	if !appendContent {
+		previous, created, diffable = c.readPreviousContent(execCtx, containerID, params.Path, maxSize)
 	}
-	dockerArgs = append(dockerArgs, containerID, "tee", params.Path)
 
-	cmd := exec.CommandContext(execCtx, "docker", dockerArgs...)
-	cmd.Stdin = strings.NewReader(params.Content)
-
-	var stderrBuf bytes.Buffer
-	cmd.Stdout = io.Discard
-	cmd.Stderr = &stderrBuf
-
-	if err := cmd.Run(); err != nil {
-		stderrStr := strings.TrimSpace(stderrBuf.String())
-		slog.Error("WriteTextFile error", "path", params.Path, "error", err, "stderr", stderrStr)
+	// The redirect, unlike tee, does not echo the content back to Exec.
+	script := `cat > "$1"`
+	if appendContent {
+		script = `cat >> "$1"`
+	}
+	if _, err := containerruntime.Current().Exec(execCtx, containerID, containerruntime.ExecOptions{
+		User:  c.host.config.ContainerUser,
+		Stdin: bytes.NewReader(data),
+	}, "sh", "-c", script, "sh", params.Path); err != nil {
+		slog.Error("WriteTextFile error", "path", params.Path, "error", err)
 		return acpsdk.WriteTextFileResponse{}, fmt.Errorf("failed to write file %q: %v", params.Path, err)
 	}
 
+	if diffable {
+		c.host.broadcastFileEdit(params.Path, previous, string(data), created)
+	}
 	return acpsdk.WriteTextFileResponse{}, nil
 }
 
-func (c *sessionHostClient) CreateTerminal(_ context.Context, _ acpsdk.CreateTerminalRequest) (acpsdk.CreateTerminalResponse, error) {
-	return acpsdk.CreateTerminalResponse{}, fmt.Errorf("CreateTerminal not supported")
+func (c *sessionHostClient) CreateTerminal(_ context.Context, params acpsdk.CreateTerminalRequest) (acpsdk.CreateTerminalResponse, error) {
+	return c.host.createTerminal(params)
 }
 
-func (c *sessionHostClient) KillTerminal(_ context.Context, _ acpsdk.KillTerminalRequest) (acpsdk.KillTerminalResponse, error) {
-	return acpsdk.KillTerminalResponse{}, fmt.Errorf("KillTerminal not supported")
+func (c *sessionHostClient) KillTerminal(_ context.Context, params acpsdk.KillTerminalRequest) (acpsdk.KillTerminalResponse, error) {
+	return acpsdk.KillTerminalResponse{}, c.host.killTerminal(params.TerminalId)
 }
 
-func (c *sessionHostClient) TerminalOutput(_ context.Context, _ acpsdk.TerminalOutputRequest) (acpsdk.TerminalOutputResponse, error) {
-	return acpsdk.TerminalOutputResponse{}, fmt.Errorf("TerminalOutput not supported")
+func (c *sessionHostClient) TerminalOutput(_ context.Context, params acpsdk.TerminalOutputRequest) (acpsdk.TerminalOutputResponse, error) {
+	return c.host.terminalOutput(params.TerminalId)
 }
 
-func (c *sessionHostClient) ReleaseTerminal(_ context.Context, _ acpsdk.ReleaseTerminalRequest) (acpsdk.ReleaseTerminalResponse, error) {
-	return acpsdk.ReleaseTerminalResponse{}, fmt.Errorf("ReleaseTerminal not supported")
+func (c *sessionHostClient) ReleaseTerminal(_ context.Context, params acpsdk.ReleaseTerminalRequest) (acpsdk.ReleaseTerminalResponse, error) {
+	return acpsdk.ReleaseTerminalResponse{}, c.host.releaseTerminal(params.TerminalId)
 }
 
-func (c *sessionHostClient) WaitForTerminalExit(_ context.Context, _ acpsdk.WaitForTerminalExitRequest) (acpsdk.WaitForTerminalExitResponse, error) {
-	return acpsdk.WaitForTerminalExitResponse{}, fmt.Errorf("WaitForTerminalExit not supported")
+func (c *sessionHostClient) WaitForTerminalExit(ctx context.Context, params acpsdk.WaitForTerminalExitRequest) (acpsdk.WaitForTerminalExitResponse, error) {
+	return c.host.waitForTerminalExit(ctx, params.TerminalId)
 }
@@ -0,0 +1,88 @@
+package acp
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/execaudit"
+)
+
+// promptMarkerDir holds one file per agent session containing the ID of the
+// prompt currently being handled. The managed prepare-commit-msg hook
+// installed by bootstrap reads it (via SAM_PROMPT_MARKER) to add a SAM-Prompt
+// trailer to commits made by the agent.
+const promptMarkerDir = "/tmp/sam-prompts"
+
+// promptMarkerWriteTimeout bounds the docker exec that records the marker so
+// a slow container never delays prompt dispatch noticeably.
+const promptMarkerWriteTimeout = 5 * time.Second
+
+// promptMarkerPath returns the marker file for an agent session.
+func promptMarkerPath(sessionID string) string {
+	return filepath.Join(promptMarkerDir, sessionID)
+}
+
+// commitTraceEnvVars returns the env vars the managed git hooks use to
+// attribute commits to this agent session and its current prompt.
+func (h *SessionHost) commitTraceEnvVars() []string {
+	if h.config.SessionID == "" {
+		return nil
+	}
+	return []string{
+		"SAM_AGENT_SESSION_ID=" + h.config.SessionID,
+		"SAM_PROMPT_MARKER=" + promptMarkerPath(h.config.SessionID),
+	}
+}
+
+// commitAuthorEnvVars names the agent for the Co-authored-by trailer the
+// managed prepare-commit-msg hook adds when sam.coAuthoredBy is enabled.
+func (h *SessionHost) commitAuthorEnvVars(agentType string) []string {
+	if h.config.SessionID == "" {
+		return nil
+	}
+	return []string{"SAM_AGENT_NAME=" + agentDisplayName(agentType)}
+}
+
+// recordPromptMarker writes the prompt's user message ID to the session's
+// marker file before the prompt is dispatched. An empty message ID clears the
+// marker so a stale ID is never attributed to the wrong prompt. Failures are
+// logged only: commit trailers are best-effort and must not block prompts.
+func (h *SessionHost) recordPromptMarker(messageID string) {
+	if h.config.SessionID == "" {
+		return
+	}
+	path := promptMarkerPath(h.config.SessionID)
+
+	// Standalone mode runs the agent locally, so the marker lives on this host.
+	if h.config.ProcessLauncher != nil {
+		err := os.MkdirAll(promptMarkerDir, 0o755)
+		if err == nil {
+			err = os.WriteFile(path, []byte(messageID), 0o644)
+		}
+		if err != nil {
+			slog.Warn("Failed to record prompt marker for commit trailers", "sessionId", h.config.SessionID, "error", err)
+		}
+		return
+	}
+	if h.config.ContainerResolver == nil {
+		return
+	}
+
+	containerID, err := h.config.ContainerResolver()
+	if err != nil {
+		slog.Debug("Skipping prompt marker: devcontainer not found", "sessionId", h.config.SessionID, "error", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), promptMarkerWriteTimeout)
+	defer cancel()
+	script := `mkdir -p "$1" && chmod 1777 "$1" && printf '%s' "$3" > "$2" && chmod 644 "$2"`
+	ctx = execaudit.WithActor(ctx, h.config.WorkspaceID, execaudit.InitiatorAgentSession)
+	op := execaudit.Begin(ctx, containerID, "prompt_marker.write", path)
+	if _, stderr, err := execInContainer(ctx, containerID, "root", "", "sh", "-c", script, "sh", promptMarkerDir, path, messageID); op.End(err) != nil {
+		slog.Warn("Failed to record prompt marker for commit trailers",
+			"sessionId", h.config.SessionID, "error", err, "stderr", stderr)
+	}
+}
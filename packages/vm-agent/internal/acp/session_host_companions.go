@@ -0,0 +1,217 @@
+package acp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// --- Companion agents ---
+//
+// A session can keep agents other than its primary one running alongside it,
+// so one conversation can put the same question to e.g. claude-code and
+// google-gemini. A prompt with a "target" param naming another agent type is
+// routed to a companion SessionHost for that agent, started on first use.
+// Companions have no viewers of their own: everything they would broadcast is
+// relayed through the primary host tagged with "agent", so viewers and the
+// replay buffer see one shared transcript.
+
+// companionPromptParams is the part of session/prompt params that selects
+// the agent.
+type companionPromptParams struct {
+	Target string `json:"target"`
+}
+
+// promptTarget returns the agent type a prompt is addressed to, or "" for
+// the primary agent.
+func promptTarget(params json.RawMessage) string {
+	var p companionPromptParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(p.Target)
+}
+
+// routePromptToCompanion runs the prompt on a companion agent when it targets
+// one, starting the agent if needed. It reports whether the prompt was
+// handled.
+func (h *SessionHost) routePromptToCompanion(ctx context.Context, reqID json.RawMessage, params json.RawMessage, viewerID string, trustedSource bool) bool {
+	target := promptTarget(params)
+	if target == "" || target == h.AgentType() {
+		return false
+	}
+	companion, err := h.companion(target)
+	if err != nil {
+		h.sendJSONRPCErrorToViewer(viewerID, reqID, -32602, err.Error())
+		return true
+	}
+	// A no-op when the companion is already running.
+	companion.SelectAgent(ctx, target)
+	if status := companion.Status(); status == HostError || status == HostStopped {
+		h.removeCompanion(target, companion)
+		h.sendJSONRPCErrorToViewer(viewerID, reqID, -32603, fmt.Sprintf("Agent %s failed to start", target))
+		return true
+	}
+	companion.HandlePrompt(ctx, reqID, params, viewerID, trustedSource)
+	return true
+}
+
+// companion returns the companion host for agentType, creating it when the
+// session has room for another.
+func (h *SessionHost) companion(agentType string) (*SessionHost, error) {
+	if h.companionParent != nil {
+		return nil, fmt.Errorf("companion agents cannot route prompts")
+	}
+	if h.isStopped() {
+		return nil, fmt.Errorf("session is stopped")
+	}
+
+	h.companionMu.Lock()
+	defer h.companionMu.Unlock()
+	if c, ok := h.companions[agentType]; ok {
+		return c, nil
+	}
+	limit := h.config.MaxCompanionAgents
+	if limit <= 0 {
+		return nil, fmt.Errorf("multi-agent routing is disabled")
+	}
+	if len(h.companions) >= limit {
+		return nil, fmt.Errorf("session already has %d companion agents", limit)
+	}
+
+	h.mu.RLock()
+	cfg := h.config
+	h.mu.RUnlock()
+	// Session-level state belongs to the primary agent: a companion must not
+	// overwrite the session's ACP session ID, last prompt, or replay buffer,
+	// or drive task completion and auto-suspend.
+	cfg.PreviousAcpSessionID = ""
+	cfg.PreviousAgentType = ""
+	cfg.SessionManager = nil
+	cfg.TabStore = nil
+	cfg.TabLastPromptStore = nil
+	cfg.TabMessageStore = nil
+	cfg.SessionLastPromptManager = nil
+	cfg.IdleSuspendTimeout = 0
+	cfg.OnSuspend = nil
+	cfg.OnPromptComplete = nil
+	cfg.MaxCompanionAgents = 0
+	cfg.MessageReporter = h.messageReporter()
+
+	c := NewSessionHost(cfg)
+	c.companionParent = h
+	c.companionAgent = agentType
+	if h.companions == nil {
+		h.companions = make(map[string]*SessionHost)
+	}
+	h.companions[agentType] = c
+	slog.Info("SessionHost: companion agent added", "sessionID", h.config.SessionID, "agentType", agentType)
+	return c, nil
+}
+
+// removeCompanion stops and forgets a companion that failed to start.
+func (h *SessionHost) removeCompanion(agentType string, c *SessionHost) {
+	h.companionMu.Lock()
+	if h.companions[agentType] == c {
+		delete(h.companions, agentType)
+	}
+	h.companionMu.Unlock()
+	c.Stop()
+}
+
+// companionHosts returns a snapshot of the running companions.
+func (h *SessionHost) companionHosts() []*SessionHost {
+	h.companionMu.Lock()
+	defer h.companionMu.Unlock()
+	hosts := make([]*SessionHost, 0, len(h.companions))
+	for _, c := range h.companions {
+		hosts = append(hosts, c)
+	}
+	return hosts
+}
+
+// CompanionAgents returns the agent types of the session's companion agents.
+func (h *SessionHost) CompanionAgents() []string {
+	hosts := h.companionHosts()
+	agents := make([]string, 0, len(hosts))
+	for _, c := range hosts {
+		agents = append(agents, c.companionAgent)
+	}
+	return agents
+}
+
+// stopCompanions stops every companion agent. Called when the primary host
+// stops or suspends.
+func (h *SessionHost) stopCompanions() {
+	h.companionMu.Lock()
+	hosts := h.companions
+	h.companions = nil
+	h.companionMu.Unlock()
+	for _, c := range hosts {
+		c.Stop()
+	}
+}
+
+// cancelCompanionPrompts cancels in-flight companion prompts the same way a
+// viewer's session/cancel cancels the primary one.
+func (h *SessionHost) cancelCompanionPrompts(cancellation PromptCancellation) {
+	for _, c := range h.companionHosts() {
+		if c.IsPrompting() {
+			c.CancelPromptFromControlPlane(cancellation)
+		}
+	}
+}
+
+// companionPromptActive reports whether any companion is prompting or
+// recovering.
+func (h *SessionHost) companionPromptActive() bool {
+	for _, c := range h.companionHosts() {
+		if c.isPromptingOrRecovering() {
+			return true
+		}
+	}
+	return false
+}
+
+// companionForPermission returns the companion that issued a permission
+// request ID, if any.
+func (h *SessionHost) companionForPermission(requestID string) *SessionHost {
+	for _, c := range h.companionHosts() {
+		if strings.HasPrefix(requestID, c.permissionIDPrefix()) {
+			return c
+		}
+	}
+	return nil
+}
+
+// permissionIDPrefix keeps companion permission request IDs distinct from
+// the primary's, so responses can be routed back.
+func (h *SessionHost) permissionIDPrefix() string {
+	if h.companionAgent == "" {
+		return "perm-"
+	}
+	return "perm-" + h.companionAgent + ":"
+}
+
+// tagCompanionMessage adds the companion's agent type to a message relayed
+// through the primary host. A companion's agent_status becomes
+// companion_agent_status so it does not replace the primary agent's status
+// in viewers.
+func (h *SessionHost) tagCompanionMessage(data []byte) []byte {
+	var msg map[string]json.RawMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return data
+	}
+	msg["agent"], _ = json.Marshal(h.companionAgent)
+	var msgType ControlMessageType
+	if raw, ok := msg["type"]; ok && json.Unmarshal(raw, &msgType) == nil && msgType == MsgAgentStatus {
+		msg["type"], _ = json.Marshal(MsgCompanionAgentStatus)
+	}
+	tagged, err := json.Marshal(msg)
+	if err != nil {
+		return data
+	}
+	return tagged
+}
@@ -0,0 +1,137 @@
+package acp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	acpsdk "github.com/coder/acp-go-sdk"
+)
+
+// attachGatedCompanion starts a companion for agentType on host, wired to a
+// gated agent so SelectAgent treats it as already running.
+func attachGatedCompanion(t *testing.T, host *SessionHost, agentType string) (*SessionHost, *gatedPromptAgent) {
+	t.Helper()
+	companion, err := host.companion(agentType)
+	if err != nil {
+		t.Fatalf("companion(%s): %v", agentType, err)
+	}
+	process, clientToAgentReader, agentToClientWriter := newFakeAgentProcess(time.Now(), true)
+	t.Cleanup(func() {
+		clientToAgentReader.Close()
+		agentToClientWriter.Close()
+	})
+	agent := &gatedPromptAgent{
+		t:        t,
+		reader:   bufio.NewReader(clientToAgentReader),
+		writer:   agentToClientWriter,
+		release:  make(chan struct{}),
+		received: make(chan string, 8),
+	}
+	go agent.Serve()
+
+	companion.mu.Lock()
+	companion.process = process
+	companion.acpConn = acpsdk.NewClientSideConnection(&sessionHostClient{host: companion}, process.stdin, process.stdout)
+	companion.agentType = agentType
+	companion.sessionID = acpsdk.SessionId("acp-" + agentType)
+	companion.status = HostReady
+	companion.mu.Unlock()
+	return companion, agent
+}
+
+func targetedPromptParams(messageID, text, target string) json.RawMessage {
+	params, _ := json.Marshal(map[string]interface{}{
+		"messageId": messageID,
+		"prompt":    []map[string]string{{"type": "text", "text": text}},
+		"target":    target,
+	})
+	return params
+}
+
+func TestHandlePromptRoutesTargetToCompanion(t *testing.T) {
+	t.Parallel()
+
+	host, primary := newPromptQueueTestHost(t, 0)
+	host.config.MaxCompanionAgents = 1
+	host.mu.Lock()
+	host.agentType = "claude-code"
+	host.mu.Unlock()
+	sink := &recordingViewerSink{}
+	host.AttachViewerSink("viewer-1", sink)
+	_, gemini := attachGatedCompanion(t, host, "google-gemini")
+
+	done := make(chan struct{})
+	go func() {
+		host.HandlePrompt(context.Background(), json.RawMessage(`1`), targetedPromptParams("msg-1", "compare approaches", "google-gemini"), "viewer-1", false)
+		close(done)
+	}()
+	if got := waitForPromptText(t, gemini); got != "compare approaches" {
+		t.Fatalf("companion prompt = %q", got)
+	}
+	if !host.IsPrompting() {
+		t.Fatal("IsPrompting = false while a companion prompt is running")
+	}
+	gemini.release <- struct{}{}
+	<-done
+
+	select {
+	case text := <-primary.received:
+		t.Fatalf("primary agent received %q", text)
+	default:
+	}
+	// The companion's messages reach the primary host's viewers and replay
+	// buffer, tagged with the agent that answered.
+	if !sink.contains(`"agent":"google-gemini"`) || !sink.contains(`"stopReason":"end_turn"`) {
+		t.Fatalf("viewer did not receive tagged companion messages: %v", sink.messages)
+	}
+	if reason, ok := LastStopReason(host.BufferedMessages()); !ok || reason != "end_turn" {
+		t.Fatalf("LastStopReason = %q, %v", reason, ok)
+	}
+
+	// A prompt naming the primary agent runs there as usual.
+	go host.HandlePrompt(context.Background(), json.RawMessage(`2`), targetedPromptParams("msg-2", "follow up", "claude-code"), "viewer-1", false)
+	if got := waitForPromptText(t, primary); got != "follow up" {
+		t.Fatalf("primary prompt = %q", got)
+	}
+	primary.release <- struct{}{}
+
+	// The session has room for one companion only.
+	host.HandlePrompt(context.Background(), json.RawMessage(`3`), targetedPromptParams("msg-3", "and you?", "openai-codex"), "viewer-1", false)
+	if !sink.contains("session already has 1 companion agents") {
+		t.Fatal("expected the companion limit error")
+	}
+
+	host.Stop()
+	if agents := host.CompanionAgents(); len(agents) != 0 {
+		t.Fatalf("companions after Stop = %v", agents)
+	}
+}
+
+func TestCompanionDisabledAndMessageTagging(t *testing.T) {
+	t.Parallel()
+
+	host, _ := newPromptQueueTestHost(t, 0)
+	sink := &recordingViewerSink{}
+	host.AttachViewerSink("viewer-1", sink)
+	host.HandlePrompt(context.Background(), json.RawMessage(`1`), targetedPromptParams("msg-1", "hi", "google-gemini"), "viewer-1", false)
+	if !sink.contains("multi-agent routing is disabled") {
+		t.Fatal("expected multi-agent routing to be disabled by default")
+	}
+
+	companion := &SessionHost{companionAgent: "google-gemini"}
+	status, _ := json.Marshal(AgentStatusMessage{Type: MsgAgentStatus, Status: StatusReady, AgentType: "google-gemini"})
+	var tagged map[string]string
+	if err := json.Unmarshal(companion.tagCompanionMessage(status), &tagged); err != nil {
+		t.Fatalf("decode tagged status: %v", err)
+	}
+	if tagged["type"] != string(MsgCompanionAgentStatus) || tagged["agent"] != "google-gemini" || tagged["status"] != string(StatusReady) {
+		t.Fatalf("tagged status = %v", tagged)
+	}
+	if !strings.HasPrefix(companion.permissionIDPrefix(), "perm-google-gemini:") {
+		t.Fatalf("permission prefix = %q", companion.permissionIDPrefix())
+	}
+}
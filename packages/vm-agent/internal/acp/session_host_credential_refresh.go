@@ -0,0 +1,132 @@
+package acp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+)
+
+// ErrCredentialRefreshBusy is returned by RefreshCredential while a prompt is
+// running. Restarting mid-prompt would abandon the turn, so callers retry
+// once the prompt has finished.
+var ErrCredentialRefreshBusy = errors.New("cannot refresh agent credential while a prompt is running")
+
+// RefreshCredential fetches the agent's credential from the control plane
+// and, when it has been rotated, restarts the agent process with the new
+// credential and resumes the ACP session via LoadSession. Prompts that
+// arrive during the restart are queued behind it. An unchanged credential
+// leaves the running agent alone.
+func (h *SessionHost) RefreshCredential(ctx context.Context) error {
+	h.mu.RLock()
+	agentType, status, current := h.agentType, h.status, h.agentCred
+	running := h.process != nil && !h.crashRecoveryInProgress
+	h.mu.RUnlock()
+	if agentType == "" || !running || status != HostReady {
+		if status == HostPrompting {
+			return ErrCredentialRefreshBusy
+		}
+		return fmt.Errorf("no ready agent to refresh (status %s)", status)
+	}
+
+	// Hold the prompt slot for the whole refresh so a prompt cannot start
+	// against a process that is about to be replaced.
+	if !h.claimPromptSlot() {
+		return ErrCredentialRefreshBusy
+	}
+	defer func() {
+		if next, ok := h.releasePromptSlot(); ok {
+			go h.runQueuedPrompt(next)
+		}
+	}()
+
+	cred, err := h.fetchAgentKey(ctx, agentType)
+	if err != nil {
+		h.reportAgentError(agentType, "agent_credential_refresh", "Failed to fetch rotated credential", err.Error())
+		return fmt.Errorf("fetch agent credential: %w", err)
+	}
+	if reflect.DeepEqual(current, cred) {
+		slog.Info("Agent credential unchanged, skipping restart", "sessionID", h.config.SessionID, "agentType", agentType)
+		return nil
+	}
+	h.reportCredentialFetched(agentType, cred)
+	settings := h.loadAgentSettings(ctx, agentType)
+
+	resumed, err := h.restartReadyAgent(ctx, agentType, cred, settings, "rotated credential", "agent_credential_refresh")
+	if err != nil {
+		return err
+	}
+	h.reportEvent("info", "agent.credential_refreshed", fmt.Sprintf("Agent %s restarted with a rotated credential", agentType), map[string]interface{}{
+		"agentType":      agentType,
+		"sessionResumed": resumed,
+	})
+	return nil
+}
+
+// restartReadyAgent replaces the ready agent process with one started from
+// cred and settings, resuming the ACP session via LoadSession when the agent
+// supports it. The caller must hold the prompt slot. reason describes the
+// restart in logs and errors; errorCode is the code of reported failures.
+func (h *SessionHost) restartReadyAgent(ctx context.Context, agentType string, cred *agentCredential, settings *agentSettingsPayload, reason, errorCode string) (bool, error) {
+	h.mu.Lock()
+	if h.status != HostReady || h.agentType != agentType || h.process == nil {
+		status := h.status
+		h.mu.Unlock()
+		return false, fmt.Errorf("agent changed before restart with %s (status %s)", reason, status)
+	}
+	previousAcpSessionID := string(h.sessionID)
+	requireLoadSession := previousAcpSessionID != "" && h.agentSupportsLoadSession
+	// Clearing h.process first makes the old process's exit monitor treat the
+	// exit as a replacement rather than a crash.
+	h.stopCurrentAgentLocked()
+	h.status = HostStarting
+	h.statusErr = ""
+	h.mu.Unlock()
+
+	slog.Info("Restarting agent",
+		"sessionID", h.config.SessionID, "agentType", agentType, "reason", reason, "previousAcpSessionID", previousAcpSessionID)
+	h.resetStderrBuffer()
+	h.broadcastAgentStatus(StatusRestarting, agentType, "")
+
+	h.mu.Lock()
+	if h.status == HostStopped {
+		h.mu.Unlock()
+		return false, nil
+	}
+	var err error
+	if requireLoadSession {
+		err = h.startAgentWithSessionMode(ctx, agentType, cred, settings, previousAcpSessionID, true)
+	} else {
+		err = h.startAgent(ctx, agentType, cred, settings, previousAcpSessionID)
+	}
+	if err != nil {
+		message := fmt.Sprintf("Failed to restart %s with %s: %v", agentType, reason, err)
+		h.status = HostError
+		h.statusErr = message
+		h.mu.Unlock()
+		slog.Error("Agent restart failed", "sessionID", h.config.SessionID, "reason", reason, "error", err)
+		h.broadcastAgentStatus(StatusError, agentType, message)
+		h.reportAgentError(agentType, errorCode, message, "")
+		return false, err
+	}
+	h.status = HostReady
+	h.statusErr = ""
+	resumed := string(h.sessionID) == previousAcpSessionID
+	h.mu.Unlock()
+
+	h.broadcastAgentStatus(StatusReady, agentType, "")
+	return resumed, nil
+}
+
+// claimPromptSlot takes the prompt slot without starting a prompt. It
+// returns false when a prompt is already running.
+func (h *SessionHost) claimPromptSlot() bool {
+	h.promptMu.Lock()
+	defer h.promptMu.Unlock()
+	if h.promptInFlight {
+		return false
+	}
+	h.promptInFlight = true
+	return true
+}
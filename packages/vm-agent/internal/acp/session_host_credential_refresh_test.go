@@ -0,0 +1,116 @@
+package acp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newCredentialRefreshHost returns a ready claude-code host whose control
+// plane serves apiKey from the agent-key endpoint, with a running fake agent
+// started with the "original-key" credential.
+func newCredentialRefreshHost(t *testing.T, apiKey string) (*SessionHost, *fakeAgentProcess) {
+	t.Helper()
+	controlPlane := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/agent-key") {
+			_, _ = w.Write([]byte(`{"apiKey":"` + apiKey + `","credentialKind":"api-key"}`))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(controlPlane.Close)
+
+	host := newRecoveryTestHost(t, 30*time.Second)
+	host.config.ControlPlaneURL = controlPlane.URL
+	host.config.ContainerResolver = func() (string, error) { return "container", nil }
+	t.Cleanup(host.Stop)
+
+	oldProc, _, _ := armRecoverablePrompt(t, host, "claude-code", 10*time.Second, true)
+	host.mu.Lock()
+	host.agentCred = &agentCredential{credential: "original-key", credentialKind: "api-key"}
+	host.mu.Unlock()
+	return host, oldProc
+}
+
+func TestSessionHost_RefreshCredentialRestartsAndResumesSession(t *testing.T) {
+	host, oldProc := newCredentialRefreshHost(t, "rotated-key")
+
+	var startCount atomic.Int32
+	var mu sync.Mutex
+	var startedEnv []string
+	spawn := countingSpawn(t, &startCount)
+	host.config.StartProcess = func(startup *agentStartup) (agentProcess, error) {
+		mu.Lock()
+		startedEnv = append([]string(nil), startup.envVars...)
+		mu.Unlock()
+		return spawn(startup)
+	}
+
+	if err := host.RefreshCredential(context.Background()); err != nil {
+		t.Fatalf("RefreshCredential() error = %v", err)
+	}
+
+	if oldProc.stopCount.Load() != 1 {
+		t.Fatalf("old process Stop count = %d, want 1", oldProc.stopCount.Load())
+	}
+	if startCount.Load() != 1 {
+		t.Fatalf("agent starts = %d, want 1", startCount.Load())
+	}
+	mu.Lock()
+	gotKey := strings.Contains(strings.Join(startedEnv, "\n"), "rotated-key")
+	mu.Unlock()
+	if !gotKey {
+		t.Fatalf("restarted agent env does not carry the rotated key: %v", startedEnv)
+	}
+
+	host.mu.RLock()
+	status, sessionID, cred := host.status, string(host.sessionID), host.agentCred
+	host.mu.RUnlock()
+	if status != HostReady {
+		t.Fatalf("status = %s, want %s", status, HostReady)
+	}
+	if sessionID != "acp-session-1" {
+		t.Fatalf("sessionID = %q, want LoadSession to resume acp-session-1", sessionID)
+	}
+	if cred == nil || cred.credential != "rotated-key" {
+		t.Fatalf("agentCred = %+v, want rotated-key", cred)
+	}
+	if host.IsPrompting() || !host.claimPromptSlot() {
+		t.Fatal("prompt slot was not released after the refresh")
+	}
+}
+
+func TestSessionHost_RefreshCredentialUnchangedSkipsRestart(t *testing.T) {
+	host, oldProc := newCredentialRefreshHost(t, "original-key")
+
+	var startCount atomic.Int32
+	host.config.StartProcess = countingSpawn(t, &startCount)
+
+	if err := host.RefreshCredential(context.Background()); err != nil {
+		t.Fatalf("RefreshCredential() error = %v", err)
+	}
+	if oldProc.stopCount.Load() != 0 || startCount.Load() != 0 {
+		t.Fatalf("unchanged credential restarted the agent: stops=%d starts=%d", oldProc.stopCount.Load(), startCount.Load())
+	}
+}
+
+func TestSessionHost_RefreshCredentialRejectedDuringPrompt(t *testing.T) {
+	host, oldProc := newCredentialRefreshHost(t, "rotated-key")
+	if !host.claimPromptSlot() {
+		t.Fatal("could not claim prompt slot")
+	}
+
+	err := host.RefreshCredential(context.Background())
+	if !errors.Is(err, ErrCredentialRefreshBusy) {
+		t.Fatalf("RefreshCredential() error = %v, want ErrCredentialRefreshBusy", err)
+	}
+	if oldProc.stopCount.Load() != 0 {
+		t.Fatal("agent was stopped while a prompt was running")
+	}
+}
@@ -0,0 +1,48 @@
+package acp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrEnvReloadBusy is returned by ReloadEnvironment while a prompt is
+// running. Callers retry once the prompt has finished.
+var ErrEnvReloadBusy = errors.New("cannot reload agent environment while a prompt is running")
+
+// ReloadEnvironment restarts the agent process so it picks up environment
+// variables changed in the container's env files since it started, and
+// resumes the ACP session via LoadSession. The credential and settings are
+// reused; prompts that arrive during the restart are queued behind it.
+func (h *SessionHost) ReloadEnvironment(ctx context.Context) error {
+	h.mu.RLock()
+	agentType, status, cred := h.agentType, h.status, h.agentCred
+	running := h.process != nil && !h.crashRecoveryInProgress
+	h.mu.RUnlock()
+	if agentType == "" || !running || status != HostReady {
+		if status == HostPrompting {
+			return ErrEnvReloadBusy
+		}
+		return fmt.Errorf("no ready agent to reload (status %s)", status)
+	}
+
+	if !h.claimPromptSlot() {
+		return ErrEnvReloadBusy
+	}
+	defer func() {
+		if next, ok := h.releasePromptSlot(); ok {
+			go h.runQueuedPrompt(next)
+		}
+	}()
+
+	settings := h.loadAgentSettings(ctx, agentType)
+	resumed, err := h.restartReadyAgent(ctx, agentType, cred, settings, "updated environment", "agent_env_reload")
+	if err != nil {
+		return err
+	}
+	h.reportEvent("info", "agent.env_reloaded", fmt.Sprintf("Agent %s restarted with updated environment variables", agentType), map[string]interface{}{
+		"agentType":      agentType,
+		"sessionResumed": resumed,
+	})
+	return nil
+}
@@ -0,0 +1,53 @@
+package acp
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSessionHost_ReloadEnvironmentRestartsWithSameCredential(t *testing.T) {
+	host, oldProc := newCredentialRefreshHost(t, "rotated-key")
+
+	var startCount atomic.Int32
+	host.config.StartProcess = countingSpawn(t, &startCount)
+
+	if err := host.ReloadEnvironment(context.Background()); err != nil {
+		t.Fatalf("ReloadEnvironment() error = %v", err)
+	}
+	if oldProc.stopCount.Load() != 1 || startCount.Load() != 1 {
+		t.Fatalf("stops=%d starts=%d, want one restart", oldProc.stopCount.Load(), startCount.Load())
+	}
+
+	host.mu.RLock()
+	status, sessionID, cred := host.status, string(host.sessionID), host.agentCred
+	host.mu.RUnlock()
+	if status != HostReady {
+		t.Fatalf("status = %s, want %s", status, HostReady)
+	}
+	if sessionID != "acp-session-1" {
+		t.Fatalf("sessionID = %q, want LoadSession to resume acp-session-1", sessionID)
+	}
+	if cred == nil || cred.credential != "original-key" {
+		t.Fatalf("agentCred = %+v, want the credential the agent was running with", cred)
+	}
+	if host.IsPrompting() || !host.claimPromptSlot() {
+		t.Fatal("prompt slot was not released after the reload")
+	}
+}
+
+func TestSessionHost_ReloadEnvironmentRejectedDuringPrompt(t *testing.T) {
+	host, oldProc := newCredentialRefreshHost(t, "original-key")
+	if !host.claimPromptSlot() {
+		t.Fatal("could not claim prompt slot")
+	}
+
+	err := host.ReloadEnvironment(context.Background())
+	if !errors.Is(err, ErrEnvReloadBusy) {
+		t.Fatalf("ReloadEnvironment() error = %v, want ErrEnvReloadBusy", err)
+	}
+	if oldProc.stopCount.Load() != 0 {
+		t.Fatal("agent was stopped while a prompt was running")
+	}
+}
@@ -0,0 +1,236 @@
+package acp
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/workspace/vm-agent/internal/fileenc"
+)
+
+const (
+	// fileEditContextLines is the number of unchanged lines kept around each
+	// change in a file_edit hunk.
+	fileEditContextLines = 3
+	// maxFileEditDiffLines caps the hunk lines in one file_edit message;
+	// larger diffs are sent with counts only.
+	maxFileEditDiffLines = 2000
+	// maxFileEditLCSCells bounds the table used to diff the changed middle
+	// of a file. Beyond it the middle is shown as removed and re-added.
+	maxFileEditLCSCells = 1 << 20
+)
+
+// diffOp is one line of a line diff: ' ' kept, '-' removed, '+' added.
+type diffOp struct {
+	kind byte
+	text string
+}
+
+// readPreviousContent returns the content of path before the agent writes
+// it. ok is false when the previous content cannot be diffed: it could not be
+// read, or it is larger than maxSize.
+func (c *sessionHostClient) readPreviousContent(ctx context.Context, containerID, path string, maxSize int) (content string, created, ok bool) {
+	content, stderr, err := execInContainer(ctx, containerID, c.host.config.ContainerUser, "", "head", "-c", strconv.Itoa(maxSize+1), path)
+	if err != nil {
+		if strings.Contains(stderr, "No such file") {
+			return "", true, true
+		}
+		slog.Debug("WriteTextFile: previous content unavailable, skipping diff", "path", path, "error", err)
+		return "", false, false
+	}
+	if len(content) > maxSize {
+		return "", false, false
+	}
+	return content, false, true
+}
+
+// broadcastFileEdit sends viewers a file_edit message for a write of
+// newContent over oldContent, and buffers it for late joiners.
+func (h *SessionHost) broadcastFileEdit(path, oldContent, newContent string, created bool) {
+	data, err := json.Marshal(buildFileEditMessage(path, oldContent, newContent, created))
+	if err != nil {
+		slog.Warn("file_edit: marshal failed, skipping broadcast", "path", path, "error", err)
+		return
+	}
+	h.broadcastMessage(data)
+}
+
+func buildFileEditMessage(path, oldContent, newContent string, created bool) FileEditMessage {
+	msg := FileEditMessage{
+		Type:     MsgFileEdit,
+		Path:     path,
+		Created:  created,
+		OldBytes: len(oldContent),
+		NewBytes: len(newContent),
+		Hunks:    []DiffHunk{},
+	}
+	if fileenc.IsBinary([]byte(oldContent)) || fileenc.IsBinary([]byte(newContent)) {
+		msg.Binary = true
+		return msg
+	}
+	ops := diffLines(splitDiffLines(oldContent), splitDiffLines(newContent))
+	for _, op := range ops {
+		switch op.kind {
+		case '+':
+			msg.Additions++
+		case '-':
+			msg.Deletions++
+		}
+	}
+	hunks := diffHunks(ops, fileEditContextLines)
+	lines := 0
+	for _, hunk := range hunks {
+		lines += len(hunk.Lines)
+	}
+	if lines > maxFileEditDiffLines {
+		msg.Truncated = true
+		return msg
+	}
+	msg.Hunks = append(msg.Hunks, hunks...)
+	return msg
+}
+
+// splitDiffLines splits content into lines without their newlines.
+func splitDiffLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
+
+// diffLines returns a line diff of a and b. Agent edits are usually local,
+// so the common prefix and suffix are matched directly and only the middle
+// is diffed by longest common subsequence.
+func diffLines(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b)-prefix-suffix)
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	oldMid, newMid := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(oldMid)*len(newMid) <= maxFileEditLCSCells {
+		ops = append(ops, lcsDiff(oldMid, newMid)...)
+	} else {
+		for _, line := range oldMid {
+			ops = append(ops, diffOp{'-', line})
+		}
+		for _, line := range newMid {
+			ops = append(ops, diffOp{'+', line})
+		}
+	}
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+// lcsDiff diffs a and b along a longest common subsequence, listing
+// removals before additions within each change.
+func lcsDiff(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	width := m + 1
+	// lcs[i*width+j] is the LCS length of a[i:] and b[j:].
+	lcs := make([]int32, (n+1)*width)
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i*width+j] = lcs[(i+1)*width+j+1] + 1
+			} else {
+				lcs[i*width+j] = max(lcs[(i+1)*width+j], lcs[i*width+j+1])
+			}
+		}
+	}
+
+	ops := make([]diffOp, 0, n+m)
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[(i+1)*width+j] >= lcs[i*width+j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < m; j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
+
+// diffHunks groups ops into unified diff hunks with context unchanged lines
+// around each change. Changes separated by at most 2*context unchanged lines
+// share a hunk.
+func diffHunks(ops []diffOp, context int) []DiffHunk {
+	// oldLine[k] and newLine[k] count the old and new lines before ops[k].
+	oldLine := make([]int, len(ops)+1)
+	newLine := make([]int, len(ops)+1)
+	for k, op := range ops {
+		oldLine[k+1], newLine[k+1] = oldLine[k], newLine[k]
+		if op.kind != '+' {
+			oldLine[k+1]++
+		}
+		if op.kind != '-' {
+			newLine[k+1]++
+		}
+	}
+
+	var hunks []DiffHunk
+	for k := 0; k < len(ops); {
+		if ops[k].kind == ' ' {
+			k++
+			continue
+		}
+		start := max(k-context, 0)
+		end := k // last changed op in the hunk
+		for next := k + 1; next < len(ops); next++ {
+			if ops[next].kind == ' ' {
+				continue
+			}
+			if next-end-1 > 2*context {
+				break
+			}
+			end = next
+		}
+		stop := min(end+context+1, len(ops))
+
+		hunk := DiffHunk{
+			OldStart: oldLine[start] + 1,
+			OldLines: oldLine[stop] - oldLine[start],
+			NewStart: newLine[start] + 1,
+			NewLines: newLine[stop] - newLine[start],
+			Lines:    make([]string, 0, stop-start),
+		}
+		// As in diff -u, an empty side starts at the line before the hunk.
+		if hunk.OldLines == 0 {
+			hunk.OldStart--
+		}
+		if hunk.NewLines == 0 {
+			hunk.NewStart--
+		}
+		for _, op := range ops[start:stop] {
+			hunk.Lines = append(hunk.Lines, string(op.kind)+op.text)
+		}
+		hunks = append(hunks, hunk)
+		k = stop
+	}
+	return hunks
+}
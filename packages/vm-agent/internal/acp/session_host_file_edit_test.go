@@ -0,0 +1,117 @@
+package acp
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	acpsdk "github.com/coder/acp-go-sdk"
+)
+
+func TestBuildFileEditMessageHunks(t *testing.T) {
+	old := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\nn\n"
+	updated := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\nn\nadded\n"
+
+	msg := buildFileEditMessage("/workspaces/repo/x.txt", old, updated, false)
+	if msg.Type != MsgFileEdit || msg.Created || msg.OldBytes != len(old) || msg.NewBytes != len(updated) {
+		t.Fatalf("message header = %+v", msg)
+	}
+	if msg.Additions != 2 || msg.Deletions != 1 {
+		t.Fatalf("additions=%d deletions=%d, want 2 and 1", msg.Additions, msg.Deletions)
+	}
+	want := []DiffHunk{
+		{OldStart: 1, OldLines: 5, NewStart: 1, NewLines: 5, Lines: []string{" a", "-b", "+B", " c", " d", " e"}},
+		{OldStart: 12, OldLines: 3, NewStart: 12, NewLines: 4, Lines: []string{" l", " m", " n", "+added"}},
+	}
+	if !reflect.DeepEqual(msg.Hunks, want) {
+		t.Fatalf("hunks = %+v\nwant %+v", msg.Hunks, want)
+	}
+}
+
+func TestBuildFileEditMessageMergesNearbyChanges(t *testing.T) {
+	msg := buildFileEditMessage("f", "1\n2\n3\n4\n5\n6\n7\n8\n", "1\nX\n3\n4\n5\n6\nY\n8\n", false)
+	if len(msg.Hunks) != 1 {
+		t.Fatalf("hunks = %+v, want changes six lines apart in one hunk", msg.Hunks)
+	}
+	if got := msg.Hunks[0]; got.OldStart != 1 || got.OldLines != 8 || got.NewLines != 8 {
+		t.Fatalf("hunk = %+v", got)
+	}
+}
+
+func TestBuildFileEditMessageNewAndEmptiedFiles(t *testing.T) {
+	created := buildFileEditMessage("new.go", "", "package x\n\nfunc F() {}\n", true)
+	want := []DiffHunk{{OldStart: 0, OldLines: 0, NewStart: 1, NewLines: 3, Lines: []string{"+package x", "+", "+func F() {}"}}}
+	if !created.Created || created.Additions != 3 || !reflect.DeepEqual(created.Hunks, want) {
+		t.Fatalf("new file message = %+v", created)
+	}
+
+	emptied := buildFileEditMessage("old.go", "x\ny\n", "", false)
+	if emptied.Deletions != 2 || len(emptied.Hunks) != 1 || emptied.Hunks[0].NewStart != 0 || emptied.Hunks[0].NewLines != 0 {
+		t.Fatalf("emptied file message = %+v", emptied)
+	}
+
+	unchanged := buildFileEditMessage("same.go", "x\n", "x\n", false)
+	if unchanged.Hunks == nil || len(unchanged.Hunks) != 0 {
+		t.Fatalf("unchanged file hunks = %#v, want empty", unchanged.Hunks)
+	}
+}
+
+func TestBuildFileEditMessageBinaryAndTruncated(t *testing.T) {
+	binary := buildFileEditMessage("img.png", "", "\x89PNG\x00\x00", true)
+	if !binary.Binary || len(binary.Hunks) != 0 {
+		t.Fatalf("binary message = %+v", binary)
+	}
+
+	huge := strings.Repeat("line\n", maxFileEditDiffLines+1)
+	truncated := buildFileEditMessage("big.txt", "", huge, true)
+	if !truncated.Truncated || len(truncated.Hunks) != 0 || truncated.Additions != maxFileEditDiffLines+1 {
+		t.Fatalf("truncated message: truncated=%v hunks=%d additions=%d", truncated.Truncated, len(truncated.Hunks), truncated.Additions)
+	}
+}
+
+func TestWriteTextFileBroadcastsFileEdit(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(target, []byte("package main\n\nfunc main() {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	useMockDocker(t, dir)
+
+	host := newTestSessionHost(t)
+	host.config.ContainerResolver = func() (string, error) { return "container", nil }
+	client := &sessionHostClient{host: host}
+
+	if _, err := client.WriteTextFile(t.Context(), acpsdk.WriteTextFileRequest{
+		Path:    target,
+		Content: "package main\n\nfunc main() {\n\trun()\n}\n",
+	}); err != nil {
+		t.Fatalf("WriteTextFile() error = %v", err)
+	}
+	if _, err := client.WriteTextFile(t.Context(), acpsdk.WriteTextFileRequest{
+		Path:    filepath.Join(dir, "new.txt"),
+		Content: "hello\n",
+	}); err != nil {
+		t.Fatalf("WriteTextFile() new file error = %v", err)
+	}
+
+	messages, _, _ := host.replaySince(0)
+	var edits []FileEditMessage
+	for _, buffered := range messages {
+		var msg FileEditMessage
+		if json.Unmarshal(buffered.Data, &msg) == nil && msg.Type == MsgFileEdit {
+			edits = append(edits, msg)
+		}
+	}
+	if len(edits) != 2 {
+		t.Fatalf("file_edit messages = %d, want 2", len(edits))
+	}
+	if edits[0].Path != target || edits[0].Created || edits[0].Additions != 3 || edits[0].Deletions != 1 {
+		t.Fatalf("edit of existing file = %+v", edits[0])
+	}
+	if !edits[1].Created || edits[1].OldBytes != 0 || edits[1].Additions != 1 {
+		t.Fatalf("edit creating a file = %+v", edits[1])
+	}
+}
@@ -60,7 +60,8 @@ func (h *SessionHost) initializeACP(ctx context.Context, agentType string, timeo
 			Version: sysinfo.Version,
 		},
 		ClientCapabilities: acpsdk.ClientCapabilities{
-			Fs: acpsdk.FileSystemCapabilities{ReadTextFile: true, WriteTextFile: true},
+			Fs:       acpsdk.FileSystemCapabilities{ReadTextFile: true, WriteTextFile: true},
+			Terminal: true,
 		},
 	})
 	if err != nil {
@@ -72,6 +73,7 @@ func (h *SessionHost) initializeACP(ctx context.Context, agentType string, timeo
 	}
 	cancel()
 	h.agentSupportsLoadSession = resp.AgentCapabilities.LoadSession
+	h.agentMcpCapabilities = resp.AgentCapabilities.McpCapabilities
 	slog.Info("ACP: Initialize succeeded", "loadSession", resp.AgentCapabilities.LoadSession)
 	h.reportLifecycle("info", "ACP Initialize succeeded", map[string]interface{}{
 		"agentType":           agentType,
@@ -125,7 +127,7 @@ func (h *SessionHost) tryLoadPreviousACPSession(
 	loadResp, loadErr := h.acpConn.LoadSession(loadCtx, acpsdk.LoadSessionRequest{
 		SessionId:  acpsdk.SessionId(previousAcpSessionID),
 		Cwd:        h.config.ContainerWorkDir,
-		McpServers: buildAcpMcpServers(h.config.McpServers, agentType),
+		McpServers: h.sessionMcpServers(agentType),
 	})
 	cancel()
 	if loadErr != nil {
@@ -165,7 +167,7 @@ func (h *SessionHost) startNewACPSession(ctx context.Context, agentType string,
 	h.reportLifecycle("info", "ACP NewSession started", map[string]interface{}{"agentType": agentType})
 	sessResp, err := h.acpConn.NewSession(newCtx, acpsdk.NewSessionRequest{
 		Cwd:        h.config.ContainerWorkDir,
-		McpServers: buildAcpMcpServers(h.config.McpServers, agentType),
+		McpServers: h.sessionMcpServers(agentType),
 	})
 	if err != nil {
 		h.reportLifecycle("warn", "ACP NewSession failed", map[string]interface{}{
@@ -177,6 +179,7 @@ func (h *SessionHost) startNewACPSession(ctx context.Context, agentType string,
 	cancel()
 	h.sessionID = sessResp.SessionId
 	h.configOptions = sessResp.ConfigOptions
+	h.resetSessionCost()
 	slog.Info("ACP: NewSession succeeded", "sessionID", string(h.sessionID))
 	h.reportLifecycle("info", "ACP NewSession succeeded", map[string]interface{}{
 		"agentType":    agentType,
@@ -187,3 +190,10 @@ func (h *SessionHost) startNewACPSession(ctx context.Context, agentType string,
 
 	return nil
 }
+
+// sessionMcpServers returns the MCP servers for NewSession/LoadSession: the
+// control plane's SAM servers followed by the user-defined ones.
+func (h *SessionHost) sessionMcpServers(agentType string) []acpsdk.McpServer {
+	servers := buildAcpMcpServers(h.config.McpServers, agentType)
+	return append(servers, buildSpecMcpServers(h.mcpServerSpecs, agentType, h.agentMcpCapabilities)...)
+}
@@ -5,8 +5,6 @@ import (
 	"log/slog"
 	"strings"
 	"time"
-
-	"github.com/gorilla/websocket"
 )
 
 // credSyncSnapshot holds credential metadata captured under the lock for
@@ -113,6 +111,7 @@ func (h *SessionHost) Suspend() (acpSessionID string, agentType string) {
 		agentType:     h.agentType,
 	}
 	h.mu.Unlock()
+	h.stopCompanions()
 
 	// Sync refreshed credentials back to the control plane before cleanup.
 	h.syncCredentialOnStop(snap)
@@ -132,12 +131,7 @@ func (h *SessionHost) Suspend() (acpSessionID string, agentType string) {
 	h.viewerMu.Lock()
 	for id, viewer := range h.viewers {
 		viewer.once.Do(func() { close(viewer.done) })
-		_ = viewer.conn.WriteControl(
-			websocket.CloseMessage,
-			websocket.FormatCloseMessage(websocket.CloseGoingAway, "session suspended"),
-			time.Now().Add(5*time.Second),
-		)
-		_ = viewer.conn.Close()
+		viewer.sink.CloseWithReason("session suspended")
 		delete(h.viewers, id)
 	}
 	h.viewerMu.Unlock()
@@ -0,0 +1,306 @@
+package acp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/containerruntime"
+)
+
+// AgentQuota bounds the agent processes of one workspace.
+type AgentQuota struct {
+	// MaxAgents is the number of agent processes that may run at once in a
+	// workspace. 0 means unlimited.
+	MaxAgents int
+	// MemoryPercent refuses new agents while the devcontainer uses at least
+	// this share of its memory limit (or of host memory when the container
+	// is unlimited). 0 disables the memory check.
+	MemoryPercent int
+	// QueueTimeout is how long an over-quota agent selection waits for
+	// capacity before it is rejected. 0 rejects immediately.
+	QueueTimeout time.Duration
+}
+
+// AgentAdmitter decides whether a session host may start an agent process.
+// AdmitAgent blocks while the selection is queued and returns an error when
+// it is rejected.
+type AgentAdmitter interface {
+	AdmitAgent(ctx context.Context, host *SessionHost, agentType string) error
+}
+
+// ErrAgentQuotaExceeded is returned when a workspace has no capacity for
+// another agent process.
+var ErrAgentQuotaExceeded = errors.New("workspace agent quota exceeded")
+
+// Agent quota reasons reported in AgentQuotaMessage.
+const (
+	AgentQuotaReasonMaxAgents = "max_agents"
+	AgentQuotaReasonMemory    = "memory"
+)
+
+// defaultQuotaPollInterval is how often a queued selection re-checks capacity.
+const defaultQuotaPollInterval = 2 * time.Second
+
+// containerMemory is a devcontainer's cgroup memory usage and limit in bytes.
+type containerMemory struct {
+	used  uint64
+	limit uint64
+}
+
+// SessionHostManager enforces AgentQuota across the session hosts of each
+// workspace. Hosts that pass admission are tracked per workspace and count
+// against the quota while their agent is starting, ready or prompting; a host
+// whose agent stopped, failed or was suspended frees its slot.
+type SessionHostManager struct {
+	quota        AgentQuota
+	pollInterval time.Duration
+	// memoryUsage reads a container's memory usage. Replaced in tests.
+	memoryUsage func(ctx context.Context, containerID string) (containerMemory, error)
+
+	mu       sync.Mutex
+	admitted map[string]map[*SessionHost]struct{} // workspace ID -> hosts
+}
+
+// NewSessionHostManager creates a manager enforcing quota.
+func NewSessionHostManager(quota AgentQuota) *SessionHostManager {
+	return &SessionHostManager{
+		quota:        quota,
+		pollInterval: defaultQuotaPollInterval,
+		memoryUsage:  readContainerMemory,
+		admitted:     make(map[string]map[*SessionHost]struct{}),
+	}
+}
+
+// AdmitAgent implements AgentAdmitter. While over quota the selection is
+// queued for up to QueueTimeout, and viewers are told with an agent_quota
+// message. When no capacity frees up, viewers get a rejected agent_quota
+// message and ErrAgentQuotaExceeded is returned.
+func (m *SessionHostManager) AdmitAgent(ctx context.Context, host *SessionHost, agentType string) error {
+	var deadline time.Time
+	if m.quota.QueueTimeout > 0 {
+		deadline = time.Now().Add(m.quota.QueueTimeout)
+	}
+	queued := false
+	for {
+		msg, ok := m.tryAdmit(ctx, host)
+		msg.AgentType = agentType
+		if ok {
+			if queued {
+				slog.Info("Agent selection admitted after waiting for quota",
+					"workspaceId", host.config.WorkspaceID, "sessionId", host.config.SessionID, "agentType", agentType)
+			}
+			return nil
+		}
+
+		if deadline.IsZero() || !time.Now().Before(deadline) {
+			msg.State = "rejected"
+			msg.Message = quotaMessage(msg, false)
+			host.broadcastAgentQuota(msg)
+			return fmt.Errorf("%w: %s", ErrAgentQuotaExceeded, msg.Message)
+		}
+		if !queued {
+			queued = true
+			msg.State = "queued"
+			msg.Message = quotaMessage(msg, true)
+			slog.Info("Agent selection queued by workspace quota",
+				"workspaceId", host.config.WorkspaceID, "sessionId", host.config.SessionID,
+				"agentType", agentType, "reason", msg.Reason, "runningAgents", msg.RunningAgents)
+			host.broadcastAgentQuota(msg)
+		}
+
+		timer := time.NewTimer(m.pollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		if host.isStopped() {
+			return errors.New("session stopped while waiting for agent quota")
+		}
+	}
+}
+
+// tryAdmit reserves a slot for host when the workspace has capacity.
+func (m *SessionHostManager) tryAdmit(ctx context.Context, host *SessionHost) (AgentQuotaMessage, bool) {
+	msg := AgentQuotaMessage{
+		Type:          MsgAgentQuota,
+		MaxAgents:     m.quota.MaxAgents,
+		MemoryPercent: m.quota.MemoryPercent,
+	}
+
+	if m.quota.MemoryPercent > 0 {
+		if mem, ok := m.workspaceMemory(ctx, host); ok {
+			msg.MemoryUsedBytes, msg.MemoryLimitBytes = mem.used, mem.limit
+			if mem.used*100 >= mem.limit*uint64(m.quota.MemoryPercent) {
+				msg.Reason = AgentQuotaReasonMemory
+				return msg, false
+			}
+		}
+	}
+
+	workspaceID := host.config.WorkspaceID
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hosts := m.admitted[workspaceID]
+	if hosts == nil {
+		hosts = make(map[*SessionHost]struct{})
+		m.admitted[workspaceID] = hosts
+	}
+	for other := range hosts {
+		if other == host {
+			continue
+		}
+		if !other.holdsAgentSlot() {
+			delete(hosts, other)
+			continue
+		}
+		msg.RunningAgents++
+	}
+	if m.quota.MaxAgents > 0 && msg.RunningAgents >= m.quota.MaxAgents {
+		msg.Reason = AgentQuotaReasonMaxAgents
+		return msg, false
+	}
+	hosts[host] = struct{}{}
+	return msg, true
+}
+
+// workspaceMemory reads the memory usage of the host's devcontainer. Failures
+// are logged and do not block admission.
+func (m *SessionHostManager) workspaceMemory(ctx context.Context, host *SessionHost) (containerMemory, bool) {
+	if host.config.ContainerResolver == nil {
+		return containerMemory{}, false
+	}
+	containerID, err := host.config.ContainerResolver()
+	if err != nil || containerID == "" {
+		return containerMemory{}, false
+	}
+	mem, err := m.memoryUsage(ctx, containerID)
+	if err != nil {
+		slog.Warn("Agent quota: failed to read container memory", "containerId", containerID, "error", err)
+		return containerMemory{}, false
+	}
+	if mem.limit == 0 {
+		return containerMemory{}, false
+	}
+	return mem, true
+}
+
+// containerMemoryScript prints "<used> <limit> <host total>" in bytes for the
+// container's cgroup (v2, falling back to v1). The limit is "max" when the
+// container is unlimited.
+const containerMemoryScript = `if [ -r /sys/fs/cgroup/memory.current ]; then
+  used=$(cat /sys/fs/cgroup/memory.current); limit=$(cat /sys/fs/cgroup/memory.max)
+else
+  used=$(cat /sys/fs/cgroup/memory/memory.usage_in_bytes); limit=$(cat /sys/fs/cgroup/memory/memory.limit_in_bytes)
+fi
+total=$(awk '/^MemTotal:/ { print $2 * 1024 }' /proc/meminfo)
+echo "$used $limit $total"`
+
+// readContainerMemory reads the devcontainer's cgroup memory stats with
+// docker exec.
+func readContainerMemory(ctx context.Context, containerID string) (containerMemory, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	result, err := containerruntime.Current().Exec(ctx, containerID, containerruntime.ExecOptions{}, "sh", "-c", containerMemoryScript)
+	if err != nil {
+		return containerMemory{}, fmt.Errorf("docker exec cgroup stats: %w", err)
+	}
+	return parseContainerMemory(string(result.Stdout))
+}
+
+// parseContainerMemory parses containerMemoryScript output. An unlimited
+// container, or one whose limit exceeds host memory, is measured against
+// host memory.
+func parseContainerMemory(output string) (containerMemory, error) {
+	fields := strings.Fields(output)
+	if len(fields) != 3 {
+		return containerMemory{}, fmt.Errorf("unexpected cgroup stats %q", strings.TrimSpace(output))
+	}
+	used, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return containerMemory{}, fmt.Errorf("parse memory usage %q: %w", fields[0], err)
+	}
+	total, err := strconv.ParseUint(fields[2], 10, 64)
+	if err != nil {
+		return containerMemory{}, fmt.Errorf("parse host memory %q: %w", fields[2], err)
+	}
+	limit := total
+	if fields[1] != "max" {
+		cgroupLimit, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return containerMemory{}, fmt.Errorf("parse memory limit %q: %w", fields[1], err)
+		}
+		if cgroupLimit < total {
+			limit = cgroupLimit
+		}
+	}
+	return containerMemory{used: used, limit: limit}, nil
+}
+
+// quotaMessage explains an over-quota selection to the user.
+func quotaMessage(msg AgentQuotaMessage, queued bool) string {
+	var reason string
+	switch msg.Reason {
+	case AgentQuotaReasonMemory:
+		reason = fmt.Sprintf("the workspace is using %d%% of its memory (limit %d%%)",
+			msg.MemoryUsedBytes*100/msg.MemoryLimitBytes, msg.MemoryPercent)
+	default:
+		reason = fmt.Sprintf("%d of %d agents are already running in this workspace", msg.RunningAgents, msg.MaxAgents)
+	}
+	if queued {
+		return "Waiting to start agent: " + reason
+	}
+	return "Cannot start agent: " + reason + ". Stop another agent session and try again."
+}
+
+// holdsAgentSlot reports whether the host's agent is starting or running. A
+// host busy mid-transition is assumed to hold its slot.
+func (h *SessionHost) holdsAgentSlot() bool {
+	if !h.mu.TryRLock() {
+		return true
+	}
+	defer h.mu.RUnlock()
+	switch h.status {
+	case HostStarting, HostReady, HostPrompting:
+		return true
+	default:
+		return false
+	}
+}
+
+func (h *SessionHost) isStopped() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.status == HostStopped
+}
+
+func (h *SessionHost) broadcastAgentQuota(msg AgentQuotaMessage) {
+	data, _ := json.Marshal(msg)
+	h.broadcastMessage(data)
+}
+
+// admitAgent asks the configured AgentAdmitter for a slot before an agent
+// selection starts a process. A rejected selection fails with the quota
+// explanation.
+func (h *SessionHost) admitAgent(ctx context.Context, agentType string) bool {
+	if h.config.AgentAdmission == nil {
+		return true
+	}
+	if err := h.config.AgentAdmission.AdmitAgent(ctx, h, agentType); err != nil {
+		message := err.Error()
+		if errors.Is(err, ErrAgentQuotaExceeded) {
+			message = strings.TrimPrefix(message, ErrAgentQuotaExceeded.Error()+": ")
+		}
+		h.failAgentSelection(agentType, "agent_quota", message, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,181 @@
+package acp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newQuotaTestHost returns a host in the shared test workspace with the
+// given status.
+func newQuotaTestHost(t *testing.T, sessionID string, status SessionHostStatus) *SessionHost {
+	t.Helper()
+	host := newRecoveryTestHost(t, 30*time.Second)
+	host.config.SessionID = sessionID
+	host.status = status
+	return host
+}
+
+// bufferedQuotaMessages returns the agent_quota messages broadcast by host.
+func bufferedQuotaMessages(host *SessionHost) []AgentQuotaMessage {
+	host.bufMu.RLock()
+	defer host.bufMu.RUnlock()
+	var out []AgentQuotaMessage
+	for _, msg := range host.messageBuf {
+		var quota AgentQuotaMessage
+		if json.Unmarshal(msg.Data, &quota) == nil && quota.Type == MsgAgentQuota {
+			out = append(out, quota)
+		}
+	}
+	return out
+}
+
+func TestSessionHostManager_RejectsOverMaxAgents(t *testing.T) {
+	manager := NewSessionHostManager(AgentQuota{MaxAgents: 2})
+	ctx := context.Background()
+	for _, id := range []string{"s1", "s2"} {
+		if err := manager.AdmitAgent(ctx, newQuotaTestHost(t, id, HostReady), "claude-code"); err != nil {
+			t.Fatalf("AdmitAgent(%s) error = %v", id, err)
+		}
+	}
+
+	third := newQuotaTestHost(t, "s3", HostStarting)
+	err := manager.AdmitAgent(ctx, third, "claude-code")
+	if !errors.Is(err, ErrAgentQuotaExceeded) {
+		t.Fatalf("AdmitAgent() error = %v, want ErrAgentQuotaExceeded", err)
+	}
+	msgs := bufferedQuotaMessages(third)
+	if len(msgs) != 1 {
+		t.Fatalf("agent_quota messages = %d, want 1", len(msgs))
+	}
+	if got := msgs[0]; got.State != "rejected" || got.Reason != AgentQuotaReasonMaxAgents || got.RunningAgents != 2 || got.MaxAgents != 2 || got.AgentType != "claude-code" {
+		t.Fatalf("agent_quota message = %+v", got)
+	}
+
+	// Another workspace has its own quota.
+	other := newQuotaTestHost(t, "s4", HostStarting)
+	other.config.WorkspaceID = "other-workspace"
+	if err := manager.AdmitAgent(ctx, other, "claude-code"); err != nil {
+		t.Fatalf("AdmitAgent() in another workspace error = %v", err)
+	}
+}
+
+func TestSessionHostManager_ReadmitsSameHost(t *testing.T) {
+	manager := NewSessionHostManager(AgentQuota{MaxAgents: 1})
+	host := newQuotaTestHost(t, "s1", HostReady)
+	for i := 0; i < 2; i++ {
+		if err := manager.AdmitAgent(context.Background(), host, "claude-code"); err != nil {
+			t.Fatalf("AdmitAgent() #%d error = %v", i+1, err)
+		}
+	}
+}
+
+func TestSessionHostManager_QueuedSelectionAdmittedWhenSlotFrees(t *testing.T) {
+	manager := NewSessionHostManager(AgentQuota{MaxAgents: 1, QueueTimeout: 5 * time.Second})
+	manager.pollInterval = 10 * time.Millisecond
+	running := newQuotaTestHost(t, "s1", HostReady)
+	if err := manager.AdmitAgent(context.Background(), running, "claude-code"); err != nil {
+		t.Fatalf("AdmitAgent() error = %v", err)
+	}
+
+	waiting := newQuotaTestHost(t, "s2", HostStarting)
+	admitted := make(chan error, 1)
+	go func() { admitted <- manager.AdmitAgent(context.Background(), waiting, "codex") }()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(bufferedQuotaMessages(waiting)) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for queued agent_quota message")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := bufferedQuotaMessages(waiting)[0]; got.State != "queued" {
+		t.Fatalf("agent_quota state = %q, want queued", got.State)
+	}
+
+	running.setStatus(HostStopped, "")
+	select {
+	case err := <-admitted:
+		if err != nil {
+			t.Fatalf("queued AdmitAgent() error = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("queued selection was not admitted after the slot freed")
+	}
+}
+
+func TestSessionHostManager_RejectsOverMemoryPercent(t *testing.T) {
+	manager := NewSessionHostManager(AgentQuota{MemoryPercent: 80})
+	manager.memoryUsage = func(context.Context, string) (containerMemory, error) {
+		return containerMemory{used: 900, limit: 1000}, nil
+	}
+	host := newQuotaTestHost(t, "s1", HostStarting)
+	host.config.ContainerResolver = func() (string, error) { return "container", nil }
+
+	err := manager.AdmitAgent(context.Background(), host, "claude-code")
+	if !errors.Is(err, ErrAgentQuotaExceeded) {
+		t.Fatalf("AdmitAgent() error = %v, want ErrAgentQuotaExceeded", err)
+	}
+	msgs := bufferedQuotaMessages(host)
+	if len(msgs) != 1 || msgs[0].Reason != AgentQuotaReasonMemory || msgs[0].MemoryUsedBytes != 900 {
+		t.Fatalf("agent_quota messages = %+v", msgs)
+	}
+
+	manager.memoryUsage = func(context.Context, string) (containerMemory, error) {
+		return containerMemory{}, errors.New("docker unavailable")
+	}
+	if err := manager.AdmitAgent(context.Background(), host, "claude-code"); err != nil {
+		t.Fatalf("AdmitAgent() with unreadable memory error = %v, want admission", err)
+	}
+}
+
+func TestSessionHost_SelectAgentFailsOverQuota(t *testing.T) {
+	manager := NewSessionHostManager(AgentQuota{MaxAgents: 1})
+	running := newQuotaTestHost(t, "s1", HostReady)
+	if err := manager.AdmitAgent(context.Background(), running, "claude-code"); err != nil {
+		t.Fatalf("AdmitAgent() error = %v", err)
+	}
+
+	host := newQuotaTestHost(t, "s2", HostIdle)
+	host.config.AgentAdmission = manager
+	host.SelectAgent(context.Background(), "claude-code")
+
+	host.mu.RLock()
+	status, statusErr := host.status, host.statusErr
+	host.mu.RUnlock()
+	if status != HostError {
+		t.Fatalf("status = %s, want %s", status, HostError)
+	}
+	want := "Cannot start agent: 1 of 1 agents are already running in this workspace. Stop another agent session and try again."
+	if statusErr != want {
+		t.Fatalf("statusErr = %q, want %q", statusErr, want)
+	}
+}
+
+func TestParseContainerMemory(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    containerMemory
+		wantErr bool
+	}{
+		{name: "limited", output: "100 400 1000\n", want: containerMemory{used: 100, limit: 400}},
+		{name: "unlimited v2", output: "100 max 1000", want: containerMemory{used: 100, limit: 1000}},
+		{name: "v1 limit above host memory", output: "100 9223372036854771712 1000", want: containerMemory{used: 100, limit: 1000}},
+		{name: "missing field", output: "100 400", wantErr: true},
+		{name: "bad usage", output: "x 400 1000", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseContainerMemory(tt.output)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseContainerMemory() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("parseContainerMemory() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
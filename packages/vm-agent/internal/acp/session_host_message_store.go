@@ -0,0 +1,135 @@
+package acp
+
+import (
+	"log/slog"
+
+	"github.com/workspace/vm-agent/internal/persistence"
+)
+
+// --- Internal: replay buffer persistence ---
+
+// restoreMessageBuffer rebuilds the replay buffer from TabMessageStore so
+// viewers attaching after a VM agent restart still receive the conversation.
+// Sequence numbers continue from the last persisted message.
+func (h *SessionHost) restoreMessageBuffer() {
+	if h.config.SessionID == "" {
+		return
+	}
+	persisted, err := h.config.TabMessageStore.ListTabMessages(h.config.SessionID)
+	if err != nil {
+		slog.Warn("SessionHost: failed to restore message buffer", "sessionID", h.config.SessionID, "error", err)
+		return
+	}
+	if excess := len(persisted) - h.config.MessageBufferSize; excess > 0 {
+		persisted = persisted[excess:]
+	}
+	if len(persisted) == 0 {
+		return
+	}
+
+	h.bufMu.Lock()
+	for _, msg := range persisted {
+		h.messageBuf = append(h.messageBuf, BufferedMessage{
+			Data:      msg.Data,
+			SeqNum:    msg.Seq,
+			Timestamp: msg.Timestamp,
+		})
+	}
+	h.seqCounter = persisted[len(persisted)-1].Seq
+	h.bufMu.Unlock()
+
+	slog.Info("SessionHost: restored message buffer", "sessionID", h.config.SessionID, "messages", len(persisted))
+}
+
+func (h *SessionHost) startMessagePersister() {
+	h.persistWake = make(chan struct{}, 1)
+	h.persistDone = make(chan struct{})
+	go h.runMessagePersister()
+}
+
+// waitMessagePersister blocks until the writer has flushed its final batch,
+// so callers that delete the tab after Stop do not race a late write.
+func (h *SessionHost) waitMessagePersister() {
+	if h.persistDone != nil {
+		<-h.persistDone
+	}
+}
+
+// runMessagePersister writes queued messages in batches off the broadcast
+// path, so a slow disk never delays delivery to viewers.
+func (h *SessionHost) runMessagePersister() {
+	defer close(h.persistDone)
+	for {
+		select {
+		case <-h.persistWake:
+			h.flushPersistedMessages()
+		case <-h.ctx.Done():
+			h.flushPersistedMessages()
+			return
+		}
+	}
+}
+
+// queuePersistedMessage hands a buffered message to the writer. Called with
+// bufMu held so messages are queued in sequence order. The queue is bounded
+// like the buffer: messages evicted before they were written are dropped.
+func (h *SessionHost) queuePersistedMessage(msg BufferedMessage) {
+	if h.config.TabMessageStore == nil {
+		return
+	}
+	h.persistMu.Lock()
+	h.persistPending = append(h.persistPending, msg)
+	if excess := len(h.persistPending) - h.config.MessageBufferSize; excess > 0 {
+		h.persistPending = h.persistPending[excess:]
+	}
+	h.persistMu.Unlock()
+
+	select {
+	case h.persistWake <- struct{}{}:
+	default:
+	}
+}
+
+// persistClaimedMessageBuffer queues the whole buffer once a warm standby is
+// bound to a session; messages buffered while unclaimed had nowhere to go.
+func (h *SessionHost) persistClaimedMessageBuffer() {
+	if h.config.TabMessageStore == nil {
+		return
+	}
+	h.bufMu.RLock()
+	h.persistMu.Lock()
+	h.persistPending = append([]BufferedMessage(nil), h.messageBuf...)
+	h.persistMu.Unlock()
+	h.bufMu.RUnlock()
+
+	select {
+	case h.persistWake <- struct{}{}:
+	default:
+	}
+}
+
+func (h *SessionHost) flushPersistedMessages() {
+	h.persistMu.Lock()
+	pending := h.persistPending
+	h.persistPending = nil
+	h.persistMu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	h.mu.RLock()
+	sessionID := h.config.SessionID
+	h.mu.RUnlock()
+	if sessionID == "" {
+		// Unclaimed warm standby; ClaimStandby re-queues the buffer.
+		return
+	}
+
+	messages := make([]persistence.TabMessage, len(pending))
+	for i, msg := range pending {
+		messages[i] = persistence.TabMessage{Seq: msg.SeqNum, Data: msg.Data, Timestamp: msg.Timestamp}
+	}
+	if err := h.config.TabMessageStore.AppendTabMessages(h.config.WorkspaceID, sessionID, messages, h.config.MessageBufferSize); err != nil {
+		slog.Warn("SessionHost: failed to persist messages", "sessionID", sessionID, "count", len(messages), "error", err)
+	}
+}
@@ -0,0 +1,96 @@
+package acp
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/workspace/vm-agent/internal/persistence"
+)
+
+func openTestMessageStore(t *testing.T) *persistence.Store {
+	t.Helper()
+	store, err := persistence.Open(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatalf("persistence.Open: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func newPersistedTestHost(store *persistence.Store, sessionID string, bufferSize int) *SessionHost {
+	return NewSessionHost(SessionHostConfig{
+		GatewayConfig: GatewayConfig{
+			SessionID:       sessionID,
+			WorkspaceID:     "test-workspace",
+			TabMessageStore: store,
+		},
+		MessageBufferSize: bufferSize,
+	})
+}
+
+func TestSessionHostRestoresPersistedBuffer(t *testing.T) {
+	t.Parallel()
+
+	store := openTestMessageStore(t)
+	host := newPersistedTestHost(store, "chat-1", 3)
+	for i := 1; i <= 5; i++ {
+		host.broadcastMessage([]byte(fmt.Sprintf(`{"n":%d}`, i)))
+	}
+	// Stop flushes pending writes before returning.
+	host.Stop()
+
+	restored := newPersistedTestHost(store, "chat-1", 3)
+	t.Cleanup(restored.Stop)
+
+	restored.bufMu.RLock()
+	got := make([]string, 0, len(restored.messageBuf))
+	for _, msg := range restored.messageBuf {
+		got = append(got, fmt.Sprintf("%d:%s", msg.SeqNum, msg.Data))
+	}
+	restored.bufMu.RUnlock()
+	want := []string{`3:{"n":3}`, `4:{"n":4}`, `5:{"n":5}`}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("restored buffer = %v, want %v", got, want)
+	}
+
+	// New messages continue the sequence instead of overwriting old rows.
+	restored.broadcastMessage([]byte(`{"n":6}`))
+	restored.bufMu.RLock()
+	lastSeq := restored.messageBuf[len(restored.messageBuf)-1].SeqNum
+	restored.bufMu.RUnlock()
+	if lastSeq != 6 {
+		t.Fatalf("next seq = %d, want 6", lastSeq)
+	}
+
+	// Other tabs start empty.
+	other := newPersistedTestHost(store, "chat-2", 3)
+	t.Cleanup(other.Stop)
+	if n := len(other.messageBuf); n != 0 {
+		t.Fatalf("chat-2 buffer has %d messages, want 0", n)
+	}
+}
+
+func TestSessionHostPersistsStandbyBufferOnClaim(t *testing.T) {
+	t.Parallel()
+
+	store := openTestMessageStore(t)
+	host := newPersistedTestHost(store, "", 10)
+	host.broadcastMessage([]byte(`{"n":1}`))
+
+	// Claim without a ready agent: bind the session the way ClaimStandby does.
+	host.mu.Lock()
+	host.config.SessionID = "chat-claimed"
+	host.persistClaimedMessageBuffer()
+	host.mu.Unlock()
+	host.broadcastMessage([]byte(`{"n":2}`))
+	host.Stop()
+
+	msgs, err := store.ListTabMessages("chat-claimed")
+	if err != nil {
+		t.Fatalf("ListTabMessages: %v", err)
+	}
+	if len(msgs) != 2 || msgs[0].Seq != 1 || msgs[1].Seq != 2 {
+		t.Fatalf("persisted = %+v, want seq 1 and 2", msgs)
+	}
+}
@@ -0,0 +1,157 @@
+package acp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/redact"
+	"github.com/workspace/vm-agent/internal/retry"
+)
+
+// errControlPlaneUnreachable marks control-plane failures that say nothing
+// about the request itself (transport errors, 5xx). Only these fall back to
+// the offline cache; 4xx answers are authoritative and never do.
+var errControlPlaneUnreachable = errors.New("control plane unreachable")
+
+// Offline cache kinds.
+const (
+	offlineKindAgentKey      = "agent-key"
+	offlineKindAgentSettings = "agent-settings"
+)
+
+// offlineCredential is the cached form of agentCredential.
+type offlineCredential struct {
+	Credential      string                 `json:"credential"`
+	CredentialKind  string                 `json:"credentialKind"`
+	InferenceConfig *inferenceConfig       `json:"inferenceConfig,omitempty"`
+	CustomAgent     *customAgentDescriptor `json:"customAgent,omitempty"`
+}
+
+// fetchAgentKey retrieves the agent credential from the control plane,
+// caching it for offline use. When the control plane is unreachable the
+// last-known credential is returned instead, if one is cached, once
+// AgentKeyRetry is exhausted.
+func (h *SessionHost) fetchAgentKey(ctx context.Context, agentType string) (*agentCredential, error) {
+	var cred *agentCredential
+	err := retry.Do(ctx, h.config.AgentKeyRetry.OrDefault(retry.Policy{MaxAttempts: 1}), "agent-key-fetch", func(ctx context.Context) error {
+		var err error
+		cred, err = h.requestAgentKey(ctx, agentType)
+		if err != nil && !errors.Is(err, errControlPlaneUnreachable) {
+			return retry.Permanent(err)
+		}
+		return err
+	})
+	if err == nil {
+		redact.Register(cred.credential)
+		h.saveOffline(agentType, offlineKindAgentKey, offlineCredential{
+			Credential:      cred.credential,
+			CredentialKind:  cred.credentialKind,
+			InferenceConfig: cred.inferenceConfig,
+			CustomAgent:     cred.customAgent,
+		})
+		return cred, nil
+	}
+	if !errors.Is(err, errControlPlaneUnreachable) {
+		return nil, err
+	}
+	h.reportControlPlaneUnreachable("agent credential fetch failed")
+
+	var cached offlineCredential
+	if !h.loadOffline(agentType, offlineKindAgentKey, &cached) {
+		return nil, err
+	}
+	redact.Register(cached.Credential)
+	return &agentCredential{
+		credential:      cached.Credential,
+		credentialKind:  cached.CredentialKind,
+		inferenceConfig: cached.InferenceConfig,
+		customAgent:     cached.CustomAgent,
+	}, nil
+}
+
+// fetchAgentSettings retrieves user's agent settings from the control plane,
+// caching them for offline use. Returns the last-known settings when the
+// control plane is unreachable, or nil so defaults apply.
+func (h *SessionHost) fetchAgentSettings(ctx context.Context, agentType string) *agentSettingsPayload {
+	settings, err := h.requestAgentSettings(ctx, agentType)
+	if err == nil {
+		h.saveOffline(agentType, offlineKindAgentSettings, settings)
+		return settings
+	}
+	slog.Warn("Failed to fetch agent settings",
+		"error", err, "workspaceId", h.config.WorkspaceID, "agentType", agentType)
+	if !errors.Is(err, errControlPlaneUnreachable) {
+		return nil
+	}
+	h.reportControlPlaneUnreachable("agent settings fetch failed")
+
+	var cached agentSettingsPayload
+	if !h.loadOffline(agentType, offlineKindAgentSettings, &cached) {
+		return nil
+	}
+	return &cached
+}
+
+func (h *SessionHost) reportControlPlaneUnreachable(reason string) {
+	if h.config.ControlPlaneStatus != nil {
+		h.config.ControlPlaneStatus.ReportControlPlaneUnreachable(reason)
+	}
+}
+
+// saveOffline caches a successful control-plane response. Failures are
+// logged and otherwise ignored: the cache is best-effort.
+func (h *SessionHost) saveOffline(agentType, kind string, value any) {
+	if h.config.OfflineCache == nil {
+		return
+	}
+	payload, err := json.Marshal(value)
+	if err != nil {
+		slog.Warn("Failed to encode offline cache entry", "kind", kind, "error", err)
+		return
+	}
+	if err := h.config.OfflineCache.SaveOffline(h.config.WorkspaceID, agentType, kind, payload); err != nil {
+		slog.Warn("Failed to save offline cache entry",
+			"kind", kind, "agentType", agentType, "workspaceId", h.config.WorkspaceID, "error", err)
+	}
+}
+
+// loadOffline decodes the cached entry into value. Returns false when the
+// cache is disabled, empty, unreadable, or older than OfflineCacheMaxAge.
+func (h *SessionHost) loadOffline(agentType, kind string, value any) bool {
+	if h.config.OfflineCache == nil {
+		return false
+	}
+	payload, storedAt, err := h.config.OfflineCache.LoadOffline(h.config.WorkspaceID, agentType, kind)
+	if err != nil {
+		slog.Warn("Failed to load offline cache entry",
+			"kind", kind, "agentType", agentType, "workspaceId", h.config.WorkspaceID, "error", err)
+		return false
+	}
+	if payload == nil {
+		return false
+	}
+	age := time.Since(storedAt)
+	if maxAge := h.config.OfflineCacheMaxAge; maxAge > 0 && age > maxAge {
+		slog.Info("Offline cache entry expired",
+			"kind", kind, "agentType", agentType, "workspaceId", h.config.WorkspaceID, "age", age)
+		return false
+	}
+	if err := json.Unmarshal(payload, value); err != nil {
+		slog.Warn("Failed to decode offline cache entry", "kind", kind, "error", err)
+		return false
+	}
+
+	detail := map[string]interface{}{
+		"agentType": agentType,
+		"kind":      kind,
+		"storedAt":  storedAt.UTC().Format(time.RFC3339),
+	}
+	h.reportLifecycle("warn", fmt.Sprintf("Using cached %s while control plane is unreachable", kind), detail)
+	h.reportEvent("warn", "agent.offline_cache_used",
+		fmt.Sprintf("Control plane unreachable; using cached %s for %s", kind, agentType), detail)
+	return true
+}
@@ -0,0 +1,152 @@
+package acp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/retry"
+)
+
+type memoryOfflineCache struct {
+	mu       sync.Mutex
+	entries  map[string][]byte
+	storedAt time.Time
+}
+
+func (c *memoryOfflineCache) SaveOffline(workspaceID, agentType, kind string, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string][]byte)
+	}
+	c.entries[workspaceID+"/"+agentType+"/"+kind] = payload
+	c.storedAt = time.Now()
+	return nil
+}
+
+func (c *memoryOfflineCache) LoadOffline(workspaceID, agentType, kind string) ([]byte, time.Time, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries[workspaceID+"/"+agentType+"/"+kind], c.storedAt, nil
+}
+
+type recordingControlPlaneStatus struct {
+	reasons []string
+}
+
+func (r *recordingControlPlaneStatus) ReportControlPlaneUnreachable(reason string) {
+	r.reasons = append(r.reasons, reason)
+}
+
+func TestSessionHost_FetchFallsBackToOfflineCacheWhenControlPlaneUnreachable(t *testing.T) {
+	status := http.StatusOK
+	cp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/workspaces/test-workspace/agent-key":
+			_, _ = w.Write([]byte(`{"apiKey":"sk-live","credentialKind":"api-key"}`))
+		case "/api/workspaces/test-workspace/agent-settings":
+			_, _ = w.Write([]byte(`{"model":"opus"}`))
+		}
+	}))
+	defer cp.Close()
+
+	cache := &memoryOfflineCache{}
+	reporter := &recordingControlPlaneStatus{}
+	host := NewSessionHost(SessionHostConfig{
+		GatewayConfig: GatewayConfig{
+			SessionID:          "test-session",
+			WorkspaceID:        "test-workspace",
+			ControlPlaneURL:    cp.URL,
+			OfflineCache:       cache,
+			OfflineCacheMaxAge: time.Hour,
+			ControlPlaneStatus: reporter,
+		},
+	})
+	defer host.Stop()
+	ctx := context.Background()
+
+	if _, err := host.fetchAgentKey(ctx, "claude-code"); err != nil {
+		t.Fatalf("online fetchAgentKey: %v", err)
+	}
+	if host.fetchAgentSettings(ctx, "claude-code") == nil {
+		t.Fatal("online fetchAgentSettings returned nil")
+	}
+
+	status = http.StatusBadGateway
+	cred, err := host.fetchAgentKey(ctx, "claude-code")
+	if err != nil {
+		t.Fatalf("offline fetchAgentKey: %v", err)
+	}
+	if cred.credential != "sk-live" || cred.credentialKind != "api-key" {
+		t.Fatalf("cached credential = %+v", cred)
+	}
+	if settings := host.fetchAgentSettings(ctx, "claude-code"); settings == nil || settings.Model != "opus" {
+		t.Fatalf("cached settings = %+v", settings)
+	}
+	if len(reporter.reasons) != 2 {
+		t.Fatalf("unreachable reports = %v, want one per failed fetch", reporter.reasons)
+	}
+
+	// Authoritative answers never fall back to the cache.
+	status = http.StatusForbidden
+	if _, err := host.fetchAgentKey(ctx, "claude-code"); err == nil {
+		t.Fatal("expected 403 to fail without using the offline cache")
+	}
+
+	// Entries older than the max age are ignored.
+	status = http.StatusServiceUnavailable
+	cache.storedAt = time.Now().Add(-2 * time.Hour)
+	if _, err := host.fetchAgentKey(ctx, "claude-code"); err == nil {
+		t.Fatal("expected expired cache entry to be ignored")
+	}
+}
+
+func TestSessionHost_FetchAgentKeyRetriesUnreachableControlPlane(t *testing.T) {
+	var calls atomic.Int32
+	status := http.StatusServiceUnavailable
+	cp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(status)
+			return
+		}
+		_, _ = w.Write([]byte(`{"apiKey":"sk-live","credentialKind":"api-key"}`))
+	}))
+	defer cp.Close()
+
+	host := NewSessionHost(SessionHostConfig{
+		GatewayConfig: GatewayConfig{
+			SessionID:       "test-session",
+			WorkspaceID:     "test-workspace",
+			ControlPlaneURL: cp.URL,
+			AgentKeyRetry:   retry.Policy{InitialDelay: time.Millisecond, MaxAttempts: 3, Jitter: -1},
+		},
+	})
+	defer host.Stop()
+
+	cred, err := host.fetchAgentKey(context.Background(), "claude-code")
+	if err != nil {
+		t.Fatalf("fetchAgentKey: %v", err)
+	}
+	if cred.credential != "sk-live" || calls.Load() != 3 {
+		t.Fatalf("credential = %q after %d calls, want sk-live after 3", cred.credential, calls.Load())
+	}
+
+	// Authoritative answers are not retried.
+	calls.Store(0)
+	status = http.StatusForbidden
+	if _, err := host.fetchAgentKey(context.Background(), "claude-code"); err == nil {
+		t.Fatal("expected 403 to fail")
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("403 fetched %d times, want 1", calls.Load())
+	}
+}
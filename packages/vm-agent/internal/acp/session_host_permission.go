@@ -0,0 +1,223 @@
+package acp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	acpsdk "github.com/coder/acp-go-sdk"
+)
+
+// pendingPermission is an agent permission request waiting for a viewer.
+// result is buffered so the first answer never blocks the sender.
+type pendingPermission struct {
+	options []acpsdk.PermissionOption
+	result  chan permissionAnswer
+}
+
+type permissionAnswer struct {
+	outcome  acpsdk.RequestPermissionOutcome
+	optionID string
+	viewerID string
+	by       PermissionResolution
+}
+
+// permissionRequestParams is the permission/request notification sent to
+// viewers: the agent's request plus the correlation ID viewers answer with.
+type permissionRequestParams struct {
+	acpsdk.RequestPermissionRequest
+	RequestID string    `json:"requestId"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// requestPermission resolves an agent permission request. Permission modes
+// that never ask the user are answered immediately; otherwise the request is
+// broadcast to viewers and the first permission_response wins. If nobody
+// answers within PermissionTimeout, the request is rejected.
+func (h *SessionHost) requestPermission(ctx context.Context, params acpsdk.RequestPermissionRequest) (acpsdk.RequestPermissionResponse, error) {
+	h.mu.RLock()
+	mode := h.permissionMode
+	h.mu.RUnlock()
+	if mode == "" {
+		mode = "default"
+	}
+
+	switch mode {
+	case "bypassPermissions":
+		slog.Info("Permission request auto-approved", "mode", mode, "optionsCount", len(params.Options))
+		return acpsdk.RequestPermissionResponse{Outcome: allowPermissionOutcome(params.Options)}, nil
+	case "dontAsk":
+		slog.Info("Permission request auto-rejected", "mode", mode, "optionsCount", len(params.Options))
+		return acpsdk.RequestPermissionResponse{Outcome: rejectPermissionOutcome(params.Options)}, nil
+	}
+
+	timeout := h.config.PermissionTimeout
+	if timeout <= 0 {
+		timeout = DefaultPermissionTimeout
+	}
+	requestID, pending := h.registerPermission(params.Options)
+	defer h.unregisterPermission(requestID)
+
+	data, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  "permission/request",
+		"params": permissionRequestParams{
+			RequestPermissionRequest: params,
+			RequestID:                requestID,
+			ExpiresAt:                time.Now().Add(timeout).UTC(),
+		},
+	})
+	if err != nil {
+		return acpsdk.RequestPermissionResponse{}, fmt.Errorf("failed to marshal permission request: %w", err)
+	}
+	slog.Info("Permission request awaiting viewer", "requestId", requestID, "mode", mode, "optionsCount", len(params.Options), "timeout", timeout)
+	h.broadcastMessage(data)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var answer permissionAnswer
+	select {
+	case answer = <-pending.result:
+	case <-timer.C:
+		answer = permissionAnswer{outcome: rejectPermissionOutcome(params.Options), by: PermissionResolvedByTimeout}
+		slog.Warn("Permission request timed out; rejecting", "requestId", requestID, "timeout", timeout)
+		h.reportLifecycle("warn", "Permission request timed out", map[string]interface{}{
+			"requestId": requestID,
+			"timeout":   timeout.String(),
+		})
+	case <-ctx.Done():
+		answer = permissionAnswer{outcome: acpsdk.NewRequestPermissionOutcomeCancelled(), by: PermissionResolvedByCancel}
+	case <-h.ctx.Done():
+		answer = permissionAnswer{outcome: acpsdk.NewRequestPermissionOutcomeCancelled(), by: PermissionResolvedByCancel}
+	}
+
+	if answer.optionID == "" && answer.outcome.Selected != nil {
+		answer.optionID = string(answer.outcome.Selected.OptionId)
+	}
+	h.broadcastPermissionResolved(requestID, answer)
+	return acpsdk.RequestPermissionResponse{Outcome: answer.outcome}, nil
+}
+
+// HandlePermissionResponse applies a viewer's answer to a pending permission
+// request. Answers for unknown or already-resolved requests are ignored, so
+// when several viewers respond only the first one counts. Read-only viewers
+// cannot answer.
+func (h *SessionHost) HandlePermissionResponse(viewerID string, msg PermissionResponseMessage) {
+	if h.rejectReadOnlyViewer(viewerID, string(MsgPermissionResponse), nil) {
+		return
+	}
+	if c := h.companionForPermission(msg.RequestID); c != nil {
+		c.HandlePermissionResponse(viewerID, msg)
+		return
+	}
+	h.permissionMu.Lock()
+	pending, ok := h.pendingPermissions[msg.RequestID]
+	if !ok {
+		h.permissionMu.Unlock()
+		slog.Info("Permission response for unknown or resolved request", "requestId", msg.RequestID, "viewerID", viewerID)
+		return
+	}
+
+	answer := permissionAnswer{viewerID: viewerID, by: PermissionResolvedByViewer}
+	if msg.Cancelled {
+		answer.outcome = acpsdk.NewRequestPermissionOutcomeCancelled()
+	} else {
+		valid := false
+		for _, opt := range pending.options {
+			if string(opt.OptionId) == msg.OptionID {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			h.permissionMu.Unlock()
+			slog.Warn("Permission response with unknown option", "requestId", msg.RequestID, "optionId", msg.OptionID, "viewerID", viewerID)
+			return
+		}
+		answer.outcome = acpsdk.NewRequestPermissionOutcomeSelected(acpsdk.PermissionOptionId(msg.OptionID))
+		answer.optionID = msg.OptionID
+	}
+	delete(h.pendingPermissions, msg.RequestID)
+	h.permissionMu.Unlock()
+
+	slog.Info("Permission request answered", "requestId", msg.RequestID, "optionId", msg.OptionID, "cancelled", msg.Cancelled, "viewerID", viewerID)
+	pending.result <- answer
+}
+
+// cancelPendingPermissions answers every pending permission request with the
+// cancelled outcome. Used when the prompt is cancelled or the session stops.
+func (h *SessionHost) cancelPendingPermissions() {
+	h.permissionMu.Lock()
+	pending := h.pendingPermissions
+	h.pendingPermissions = nil
+	h.permissionMu.Unlock()
+
+	for _, p := range pending {
+		p.result <- permissionAnswer{outcome: acpsdk.NewRequestPermissionOutcomeCancelled(), by: PermissionResolvedByCancel}
+	}
+}
+
+func (h *SessionHost) registerPermission(options []acpsdk.PermissionOption) (string, *pendingPermission) {
+	requestID := fmt.Sprintf("%s%d", h.permissionIDPrefix(), atomic.AddUint64(&h.permissionSeq, 1))
+	pending := &pendingPermission{
+		options: options,
+		result:  make(chan permissionAnswer, 1),
+	}
+	h.permissionMu.Lock()
+	if h.pendingPermissions == nil {
+		h.pendingPermissions = make(map[string]*pendingPermission)
+	}
+	h.pendingPermissions[requestID] = pending
+	h.permissionMu.Unlock()
+	return requestID, pending
+}
+
+func (h *SessionHost) unregisterPermission(requestID string) {
+	h.permissionMu.Lock()
+	delete(h.pendingPermissions, requestID)
+	h.permissionMu.Unlock()
+}
+
+func (h *SessionHost) broadcastPermissionResolved(requestID string, answer permissionAnswer) {
+	data, _ := json.Marshal(PermissionResolvedMessage{
+		Type:       MsgPermissionResolved,
+		RequestID:  requestID,
+		OptionID:   answer.optionID,
+		ResolvedBy: answer.by,
+		ViewerID:   answer.viewerID,
+	})
+	h.broadcastMessage(data)
+}
+
+// allowPermissionOutcome selects the first allow option, falling back to the
+// first option offered.
+func allowPermissionOutcome(options []acpsdk.PermissionOption) acpsdk.RequestPermissionOutcome {
+	for _, kind := range []acpsdk.PermissionOptionKind{acpsdk.PermissionOptionKindAllowOnce, acpsdk.PermissionOptionKindAllowAlways} {
+		for _, opt := range options {
+			if opt.Kind == kind {
+				return acpsdk.NewRequestPermissionOutcomeSelected(opt.OptionId)
+			}
+		}
+	}
+	if len(options) > 0 {
+		return acpsdk.NewRequestPermissionOutcomeSelected(options[0].OptionId)
+	}
+	return acpsdk.NewRequestPermissionOutcomeCancelled()
+}
+
+// rejectPermissionOutcome selects a reject option, preferring a one-off
+// rejection, or cancels when the agent offered none.
+func rejectPermissionOutcome(options []acpsdk.PermissionOption) acpsdk.RequestPermissionOutcome {
+	for _, kind := range []acpsdk.PermissionOptionKind{acpsdk.PermissionOptionKindRejectOnce, acpsdk.PermissionOptionKindRejectAlways} {
+		for _, opt := range options {
+			if opt.Kind == kind {
+				return acpsdk.NewRequestPermissionOutcomeSelected(opt.OptionId)
+			}
+		}
+	}
+	return acpsdk.NewRequestPermissionOutcomeCancelled()
+}
@@ -0,0 +1,204 @@
+package acp
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	acpsdk "github.com/coder/acp-go-sdk"
+)
+
+func testPermissionRequest() acpsdk.RequestPermissionRequest {
+	return acpsdk.RequestPermissionRequest{
+		SessionId: "acp-session",
+		Options: []acpsdk.PermissionOption{
+			{OptionId: "allow", Kind: acpsdk.PermissionOptionKindAllowOnce, Name: "Allow"},
+			{OptionId: "always", Kind: acpsdk.PermissionOptionKindAllowAlways, Name: "Always allow"},
+			{OptionId: "reject", Kind: acpsdk.PermissionOptionKindRejectOnce, Name: "Reject"},
+		},
+	}
+}
+
+type permissionResult struct {
+	resp acpsdk.RequestPermissionResponse
+	err  error
+}
+
+func startPermissionRequest(host *SessionHost, ctx context.Context, req acpsdk.RequestPermissionRequest) <-chan permissionResult {
+	ch := make(chan permissionResult, 1)
+	go func() {
+		resp, err := host.requestPermission(ctx, req)
+		ch <- permissionResult{resp, err}
+	}()
+	return ch
+}
+
+// waitForPermissionRequestID returns the correlation ID of the buffered
+// permission/request notification.
+func waitForPermissionRequestID(t *testing.T, host *SessionHost) string {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		host.bufMu.RLock()
+		for _, msg := range host.messageBuf {
+			var probe struct {
+				Method string `json:"method"`
+				Params struct {
+					RequestID string `json:"requestId"`
+				} `json:"params"`
+			}
+			if json.Unmarshal(msg.Data, &probe) == nil && probe.Method == "permission/request" && probe.Params.RequestID != "" {
+				host.bufMu.RUnlock()
+				return probe.Params.RequestID
+			}
+		}
+		host.bufMu.RUnlock()
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("timed out waiting for permission/request broadcast")
+	return ""
+}
+
+func lastPermissionResolved(host *SessionHost) (PermissionResolvedMessage, bool) {
+	host.bufMu.RLock()
+	defer host.bufMu.RUnlock()
+	var last PermissionResolvedMessage
+	found := false
+	for _, msg := range host.messageBuf {
+		var probe PermissionResolvedMessage
+		if json.Unmarshal(msg.Data, &probe) == nil && probe.Type == MsgPermissionResolved {
+			last, found = probe, true
+		}
+	}
+	return last, found
+}
+
+func awaitPermissionResult(t *testing.T, ch <-chan permissionResult) acpsdk.RequestPermissionOutcome {
+	t.Helper()
+	select {
+	case res := <-ch:
+		if res.err != nil {
+			t.Fatalf("requestPermission: %v", res.err)
+		}
+		return res.resp.Outcome
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for permission outcome")
+		return acpsdk.RequestPermissionOutcome{}
+	}
+}
+
+func TestRequestPermissionUsesViewerChoice(t *testing.T) {
+	t.Parallel()
+
+	host := NewSessionHost(SessionHostConfig{})
+	t.Cleanup(host.Stop)
+
+	result := startPermissionRequest(host, context.Background(), testPermissionRequest())
+	requestID := waitForPermissionRequestID(t, host)
+
+	// Unknown requests and options are ignored.
+	host.HandlePermissionResponse("viewer-1", PermissionResponseMessage{RequestID: "perm-unknown", OptionID: "allow"})
+	host.HandlePermissionResponse("viewer-1", PermissionResponseMessage{RequestID: requestID, OptionID: "bogus"})
+	select {
+	case <-result:
+		t.Fatal("permission resolved by an invalid response")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	host.HandlePermissionResponse("viewer-2", PermissionResponseMessage{RequestID: requestID, OptionID: "reject"})
+	outcome := awaitPermissionResult(t, result)
+	if outcome.Selected == nil || outcome.Selected.OptionId != "reject" {
+		t.Fatalf("outcome = %+v, want selected reject", outcome)
+	}
+
+	resolved, ok := lastPermissionResolved(host)
+	if !ok || resolved.RequestID != requestID || resolved.OptionID != "reject" || resolved.ResolvedBy != PermissionResolvedByViewer || resolved.ViewerID != "viewer-2" {
+		t.Fatalf("permission_resolved = %+v (found %v)", resolved, ok)
+	}
+
+	// A late answer from another viewer is a no-op.
+	host.HandlePermissionResponse("viewer-1", PermissionResponseMessage{RequestID: requestID, OptionID: "allow"})
+}
+
+func TestRequestPermissionViewerCancel(t *testing.T) {
+	t.Parallel()
+
+	host := NewSessionHost(SessionHostConfig{})
+	t.Cleanup(host.Stop)
+
+	result := startPermissionRequest(host, context.Background(), testPermissionRequest())
+	requestID := waitForPermissionRequestID(t, host)
+	host.HandlePermissionResponse("viewer-1", PermissionResponseMessage{RequestID: requestID, Cancelled: true})
+
+	if outcome := awaitPermissionResult(t, result); outcome.Cancelled == nil {
+		t.Fatalf("outcome = %+v, want cancelled", outcome)
+	}
+}
+
+func TestRequestPermissionTimeoutRejects(t *testing.T) {
+	t.Parallel()
+
+	host := NewSessionHost(SessionHostConfig{PermissionTimeout: 20 * time.Millisecond})
+	t.Cleanup(host.Stop)
+
+	outcome := awaitPermissionResult(t, startPermissionRequest(host, context.Background(), testPermissionRequest()))
+	if outcome.Selected == nil || outcome.Selected.OptionId != "reject" {
+		t.Fatalf("outcome = %+v, want selected reject", outcome)
+	}
+	if resolved, ok := lastPermissionResolved(host); !ok || resolved.ResolvedBy != PermissionResolvedByTimeout {
+		t.Fatalf("permission_resolved = %+v (found %v)", resolved, ok)
+	}
+
+	// Without a reject option the fallback is cancellation.
+	req := testPermissionRequest()
+	req.Options = req.Options[:2]
+	if outcome := awaitPermissionResult(t, startPermissionRequest(host, context.Background(), req)); outcome.Cancelled == nil {
+		t.Fatalf("outcome = %+v, want cancelled", outcome)
+	}
+}
+
+func TestRequestPermissionCancelledWithPrompt(t *testing.T) {
+	t.Parallel()
+
+	host := NewSessionHost(SessionHostConfig{})
+	t.Cleanup(host.Stop)
+
+	result := startPermissionRequest(host, context.Background(), testPermissionRequest())
+	waitForPermissionRequestID(t, host)
+
+	host.cancelPendingPermissions()
+	if outcome := awaitPermissionResult(t, result); outcome.Cancelled == nil {
+		t.Fatalf("outcome = %+v, want cancelled", outcome)
+	}
+	if resolved, ok := lastPermissionResolved(host); !ok || resolved.ResolvedBy != PermissionResolvedByCancel {
+		t.Fatalf("permission_resolved = %+v (found %v)", resolved, ok)
+	}
+}
+
+func TestRequestPermissionModesSkipViewer(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		mode       string
+		wantOption acpsdk.PermissionOptionId
+	}{
+		{mode: "bypassPermissions", wantOption: "allow"},
+		{mode: "dontAsk", wantOption: "reject"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			host := NewSessionHost(SessionHostConfig{})
+			t.Cleanup(host.Stop)
+			host.permissionMode = tt.mode
+
+			outcome := awaitPermissionResult(t, startPermissionRequest(host, context.Background(), testPermissionRequest()))
+			if outcome.Selected == nil || outcome.Selected.OptionId != tt.wantOption {
+				t.Fatalf("outcome = %+v, want selected %s", outcome, tt.wantOption)
+			}
+			if _, ok := lastPermissionResolved(host); ok {
+				t.Fatal("auto-resolved request should not be broadcast")
+			}
+		})
+	}
+}
@@ -10,10 +10,14 @@ import (
 	"time"
 
 	acpsdk "github.com/coder/acp-go-sdk"
+	"github.com/google/uuid"
+	"github.com/workspace/vm-agent/internal/tracing"
 )
 
 // HandlePrompt routes a session/prompt request through the ACP SDK.
-// Only one prompt runs at a time — concurrent requests are serialized.
+// Only one prompt runs at a time. A prompt that arrives while another is
+// running is queued when SessionHostConfig.MaxQueuedPrompts allows it, and
+// rejected otherwise.
 //
 // trustedSource distinguishes a SAM control-plane prompt (the initial task
 // prompt / injected instructions built server-side) from a browser viewer
@@ -21,31 +25,73 @@ import (
 // (_meta["sam.origin"]="system") is only honored from a trusted source; an
 // untrusted browser prompt must not be able to mark its own content as
 // origin=system (which would hide it from search, dedup, topic, and attention).
+//
+// A prompt whose params name another agent type in "target" runs on a
+// companion agent instead; see routePromptToCompanion. Prompts from
+// read-only viewers are rejected.
 func (h *SessionHost) HandlePrompt(ctx context.Context, reqID json.RawMessage, params json.RawMessage, viewerID string, trustedSource bool) {
+	if h.rejectReadOnlyViewer(viewerID, "session/prompt", reqID) {
+		return
+	}
+	if h.routePromptToCompanion(ctx, reqID, params, viewerID, trustedSource) {
+		return
+	}
 	promptReq, ok := h.preparePromptRequest(params, viewerID, reqID, trustedSource)
 	if !ok {
 		return
 	}
+	p := queuedPrompt{
+		ctx:           ctx,
+		reqID:         reqID,
+		params:        params,
+		viewerID:      viewerID,
+		trustedSource: trustedSource,
+		messageID:     promptReq.messageID,
+	}
+	if h.enqueuePromptIfBusy(p) {
+		return
+	}
+	h.runPrompt(p, promptReq, false)
+}
+
+// runPrompt runs one prepared prompt to completion. reserved is true when
+// the prompt slot was handed over from a finished prompt by releasePromptSlot.
+func (h *SessionHost) runPrompt(p queuedPrompt, promptReq preparedPromptRequest, reserved bool) {
+	reqID, viewerID := p.reqID, p.viewerID
+	spanCtx, span := tracing.Start(p.ctx, "SessionHost.HandlePrompt",
+		tracing.String("workspace.id", h.config.WorkspaceID),
+		tracing.String("acp.session_id", string(promptReq.sessionID)),
+		tracing.String("acp.agent_type", h.AgentType()),
+		tracing.String("acp.message_id", promptReq.messageID),
+	)
+	// Ends early returns; the completed path ends the span with its error.
+	defer span.End(nil)
 	h.persistLastPrompt(promptReq.firstTextContent)
 	h.injectUserMessageNotifications(promptReq.sessionID, promptReq.blocks, promptReq.messageID)
 	h.cancelAutoSuspendTimer()
 
-	promptCtx, promptCancel, promptTimeout := h.newPromptContext(ctx)
-	promptID, ok := h.beginPrompt(promptCancel)
+	promptCtx, promptCancel, promptTimeout := h.newPromptContext(spanCtx, promptReq.timeoutOverride)
+	promptID, ok := h.beginPrompt(promptCancel, reserved)
 	if !ok {
 		promptCancel()
 		h.sendJSONRPCErrorToViewer(viewerID, reqID, -32603, "Prompt already in progress")
 		return
 	}
 	defer func() {
-		h.endPrompt(promptID)
+		next, hasNext := h.endPrompt(promptID)
 		promptCancel()
+		if hasNext {
+			go h.runQueuedPrompt(next)
+		}
 	}()
+	h.recordPromptMarker(promptReq.messageID)
+	h.notifyPromptStart(promptReq.messageID)
 
 	promptDone := h.startPromptWatchdog(promptID, promptCtx, viewerID, reqID, promptTimeout)
 	defer close(promptDone)
 
 	promptStart := time.Now()
+	costBefore := h.sessionCostSnapshot()
 	// Capture recovery prerequisites (session ID, agent type, LoadSession
 	// capability, process) BEFORE dispatching the prompt. If the agent process
 	// exits mid-prompt, a concurrent monitorProcessExit can clear these live
@@ -59,7 +105,29 @@ func (h *SessionHost) HandlePrompt(ctx context.Context, reqID json.RawMessage, p
 		return
 	}
 	cancelRequested := h.isPromptCancelRequested(promptID)
-	h.markPromptDone()
+	cancellation := h.promptCancellationFor(promptID, promptCtx, promptTimeout)
+	stopReason := ""
+	switch {
+	case cancellation != nil:
+		stopReason = "cancelled"
+	case err == nil:
+		stopReason = string(resp.StopReason)
+	}
+	h.markPromptDone(stopReason, cancellation)
+	h.observePromptDuration(stopReason, err, time.Since(promptStart))
+	span.SetAttributes(tracing.String("acp.stop_reason", promptOutcome(stopReason, err)))
+	span.End(err)
+	h.reportPromptUsage(promptUsageRecord{
+		messageID:  promptReq.messageID,
+		stopReason: promptOutcome(stopReason, err),
+		startedAt:  promptStart,
+		duration:   time.Since(promptStart),
+		usage:      resp.Usage,
+		costBefore: costBefore,
+	})
+	if cancellation != nil {
+		h.persistPromptCancellation(*cancellation)
+	}
 	h.finishPrompt(promptCtx, reqID, promptStartInfo{
 		startedAt: promptStart,
 		timeout:   promptTimeout,
@@ -96,10 +164,12 @@ func (h *SessionHost) promptWithTransientRetry(
 	var resp acpsdk.PromptResponse
 	var err error
 	for attempt := 1; attempt <= totalAttempts; attempt++ {
-		resp, err = promptReq.acpConn.Prompt(promptCtx, acpsdk.PromptRequest{
+		attemptCtx, attemptSpan := tracing.Start(promptCtx, "acp.prompt_attempt", tracing.Int("acp.attempt", attempt))
+		resp, err = promptReq.acpConn.Prompt(attemptCtx, acpsdk.PromptRequest{
 			SessionId: promptReq.sessionID,
 			Prompt:    promptReq.blocks,
 		})
+		attemptSpan.End(err)
 		if err == nil {
 			return resp, nil
 		}
@@ -249,6 +319,7 @@ type preparedPromptRequest struct {
 	blocks           []acpsdk.ContentBlock
 	firstTextContent string
 	messageID        string
+	timeoutOverride  time.Duration
 }
 
 type promptStartInfo struct {
@@ -290,6 +361,7 @@ func (h *SessionHost) preparePromptRequest(params json.RawMessage, viewerID stri
 		blocks:           blocks,
 		firstTextContent: firstTextContent,
 		messageID:        messageID,
+		timeoutOverride:  parsePromptTimeoutOverride(params),
 	}, true
 }
 
@@ -373,13 +445,13 @@ func (h *SessionHost) injectUserMessageNotifications(sessionID acpsdk.SessionId,
 		h.broadcastMessage(data)
 
 		// Enqueue to message reporter for Durable Object persistence.
-		if h.config.MessageReporter != nil {
+		if reporter := h.messageReporter(); reporter != nil {
 			for _, m := range ExtractMessages(notif) {
 				if userMessageID != "" && m.Role == "user" {
 					m.MessageID = userMessageID
 					userMessageID = ""
 				}
-				if err := h.config.MessageReporter.Enqueue(MessageReportEntry{
+				if err := reporter.Enqueue(MessageReportEntry{
 					MessageID:    m.MessageID,
 					Role:         m.Role,
 					Content:      m.Content,
@@ -404,8 +476,11 @@ func (h *SessionHost) cancelAutoSuspendTimer() {
 	h.viewerMu.Unlock()
 }
 
-func (h *SessionHost) newPromptContext(ctx context.Context) (context.Context, context.CancelFunc, time.Duration) {
-	promptTimeout := h.promptTimeout()
+func (h *SessionHost) newPromptContext(ctx context.Context, override time.Duration) (context.Context, context.CancelFunc, time.Duration) {
+	promptTimeout, source := h.resolvePromptTimeout(h.AgentType(), override)
+	if source != promptTimeoutSourceStatic {
+		slog.Info("ACP: prompt timeout resolved", "sessionId", h.config.SessionID, "timeout", promptTimeout.String(), "source", source)
+	}
 	if promptTimeout > 0 {
 		promptCtx, promptCancel := context.WithTimeout(ctx, promptTimeout)
 		return promptCtx, promptCancel, promptTimeout
@@ -442,13 +517,75 @@ func (h *SessionHost) markPromptStarted(sessionID acpsdk.SessionId, blockCount i
 	})
 }
 
-func (h *SessionHost) markPromptDone() {
+// markPromptDone returns the host to ready and tells viewers the prompt
+// finished. stopReason is omitted when the prompt failed; cancellation is nil
+// unless the prompt was cancelled or timed out.
+func (h *SessionHost) markPromptDone(stopReason string, cancellation *PromptCancellation) {
 	h.setStatus(HostReady, "")
-	h.broadcastControl(MsgSessionPromptDone, nil)
+	h.broadcastPromptDone(stopReason, cancellation)
 	h.stopPromptActivityRereport()
 	h.reportActivity("idle")
 }
 
+func (h *SessionHost) broadcastPromptDone(stopReason string, cancellation *PromptCancellation) {
+	data, _ := json.Marshal(SessionPromptDoneMessage{
+		Type:         MsgSessionPromptDone,
+		StopReason:   stopReason,
+		Cancellation: cancellation,
+	})
+	h.broadcastMessage(data)
+}
+
+// persistPromptCancellation records the cancellation as a system message in
+// the session transcript. The structured metadata rides in ToolMetadata under
+// "promptCancellation" so history views can render it like the live message.
+func (h *SessionHost) persistPromptCancellation(cancellation PromptCancellation) {
+	reporter := h.messageReporter()
+	if reporter == nil || h.config.SessionID == "" {
+		return
+	}
+	metadata, err := json.Marshal(map[string]interface{}{"promptCancellation": cancellation})
+	if err != nil {
+		slog.Warn("Failed to marshal prompt cancellation metadata", "error", err)
+		return
+	}
+	if err := reporter.Enqueue(MessageReportEntry{
+		MessageID:    uuid.NewString(),
+		SessionID:    h.config.SessionID,
+		Role:         "system",
+		Content:      promptCancellationSummary(cancellation),
+		ToolMetadata: string(metadata),
+		Timestamp:    cancellation.CancelledAt.UTC().Format(time.RFC3339Nano),
+	}); err != nil {
+		slog.Warn("Failed to persist prompt cancellation system message", "error", err)
+	}
+}
+
+// promptCancellationSummary is the plain-text transcript line for clients
+// that do not read the structured metadata.
+func promptCancellationSummary(cancellation PromptCancellation) string {
+	var summary string
+	switch cancellation.Initiator {
+	case CancelByUser:
+		summary = "Prompt stopped by user"
+	case CancelByTimeout:
+		summary = "Prompt stopped: timed out"
+	case CancelByAdmin:
+		summary = "Prompt stopped by an administrator"
+	case CancelByBudget:
+		summary = "Prompt stopped: usage budget reached"
+	default:
+		summary = "Prompt stopped"
+	}
+	if cancellation.Reason != "" && cancellation.Initiator != CancelByTimeout {
+		summary += ": " + cancellation.Reason
+	}
+	if cancellation.PartialOutput {
+		summary += " (partial output)"
+	}
+	return summary
+}
+
 func (h *SessionHost) startPromptActivityRereport() {
 	interval := h.config.ActivityRereportInterval
 	if interval <= 0 {
@@ -504,6 +641,9 @@ func (h *SessionHost) finishPrompt(
 	}
 
 	slog.Info("ACP: Prompt completed", "stopReason", string(resp.StopReason))
+	if resp.StopReason != acpsdk.StopReasonCancelled {
+		h.recordPromptDuration(time.Since(info.startedAt))
+	}
 	h.reportLifecycle("info", "ACP Prompt completed", map[string]interface{}{
 		"stopReason": string(resp.StopReason),
 		"duration":   time.Since(info.startedAt).String(),
@@ -524,10 +664,7 @@ func (h *SessionHost) finishPromptCancelled(reqID json.RawMessage, info promptSt
 
 func (h *SessionHost) finishPromptWithError(promptCtx context.Context, reqID json.RawMessage, info promptStartInfo, err error) {
 	if errors.Is(promptCtx.Err(), context.DeadlineExceeded) {
-		errMsg := "Prompt cancelled (context deadline exceeded)"
-		if info.timeout > 0 {
-			errMsg = fmt.Sprintf("Prompt timed out after %s", info.timeout)
-		}
+		errMsg := promptTimeoutMessage(info.timeout)
 		slog.Warn("ACP Prompt timed out", "error", err)
 		h.reportLifecycle("warn", "ACP Prompt timed out", map[string]interface{}{
 			"error":    errMsg,
@@ -612,8 +749,30 @@ func (h *SessionHost) broadcastPromptResponse(reqID json.RawMessage, resp acpsdk
 	h.broadcastMessage(data)
 }
 
+func (h *SessionHost) observePromptDuration(stopReason string, err error, duration time.Duration) {
+	if h.config.OnPromptDuration == nil {
+		return
+	}
+	h.config.OnPromptDuration(h.AgentType(), promptOutcome(stopReason, err), duration)
+}
+
+// promptOutcome is the stop reason reported for a finished prompt. Failed
+// prompts have no ACP stop reason and are reported as "error".
+func promptOutcome(stopReason string, err error) string {
+	if stopReason == "" && err != nil {
+		return "error"
+	}
+	return stopReason
+}
+
+func (h *SessionHost) notifyPromptStart(messageID string) {
+	if h.config.OnPromptStart != nil {
+		h.config.OnPromptStart(h.config.WorkspaceID, h.config.SessionID, messageID)
+	}
+}
+
 func (h *SessionHost) notifyPromptComplete(stopReason string, err error) {
-	if cb := h.config.OnPromptComplete; cb != nil {
+	if cb := h.OnPromptCompleteCallback(); cb != nil {
 		go cb(stopReason, err)
 	}
 }
@@ -0,0 +1,170 @@
+package acp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+)
+
+// queuedPrompt is a session/prompt request accepted while another prompt was
+// running. The raw params are kept and re-validated when the prompt starts,
+// since the ACP session may have changed while it waited.
+type queuedPrompt struct {
+	ctx           context.Context
+	reqID         json.RawMessage
+	params        json.RawMessage
+	viewerID      string
+	trustedSource bool
+	messageID     string
+}
+
+func (h *SessionHost) maxQueuedPrompts() int {
+	if h.config.MaxQueuedPrompts < 0 {
+		return 0
+	}
+	return h.config.MaxQueuedPrompts
+}
+
+// enqueuePromptIfBusy queues p behind the in-flight prompt when queueing is
+// enabled. It reports whether the prompt was handled here, either queued or
+// rejected because the queue is full; false means the caller should run it.
+func (h *SessionHost) enqueuePromptIfBusy(p queuedPrompt) bool {
+	maxQueued := h.maxQueuedPrompts()
+	if maxQueued == 0 {
+		return false
+	}
+
+	h.promptMu.Lock()
+	if !h.promptInFlight {
+		h.promptMu.Unlock()
+		return false
+	}
+	if len(h.promptQueue) >= maxQueued {
+		h.promptMu.Unlock()
+		slog.Warn("Prompt queue full; rejecting prompt", "sessionID", h.config.SessionID, "maxQueued", maxQueued)
+		h.sendJSONRPCErrorToViewer(p.viewerID, p.reqID, -32603, fmt.Sprintf("Prompt queue is full (%d waiting)", maxQueued))
+		return true
+	}
+	h.promptQueue = append(h.promptQueue, p)
+	queued := promptQueueInfoLocked(h.promptQueue)
+	h.promptMu.Unlock()
+
+	slog.Info("Prompt queued behind in-flight prompt", "sessionID", h.config.SessionID, "position", len(queued), "messageId", p.messageID)
+	h.broadcastPromptQueue(queued)
+	return true
+}
+
+// releasePromptSlot is called when a prompt finishes. If a prompt is waiting,
+// the slot is handed to it without clearing promptInFlight, so a newly
+// arriving prompt cannot run ahead of the queue. Queued prompts are dropped
+// when the agent is no longer ready to take them.
+func (h *SessionHost) releasePromptSlot() (queuedPrompt, bool) {
+	blocked := h.promptQueueBlockedReason()
+
+	h.promptMu.Lock()
+	if len(h.promptQueue) == 0 {
+		h.promptInFlight = false
+		h.promptMu.Unlock()
+		return queuedPrompt{}, false
+	}
+	if blocked != "" {
+		dropped := h.promptQueue
+		h.promptQueue = nil
+		h.promptInFlight = false
+		h.promptMu.Unlock()
+		h.rejectQueuedPrompts(dropped, blocked)
+		return queuedPrompt{}, false
+	}
+	next := h.promptQueue[0]
+	h.promptQueue = append([]queuedPrompt(nil), h.promptQueue[1:]...)
+	queued := promptQueueInfoLocked(h.promptQueue)
+	h.promptMu.Unlock()
+
+	h.broadcastPromptQueue(queued)
+	return next, true
+}
+
+// runQueuedPrompt runs prompts handed over by releasePromptSlot. A prompt
+// whose request context ended or that no longer validates gives its slot to
+// the next one in line.
+func (h *SessionHost) runQueuedPrompt(p queuedPrompt) {
+	for {
+		if err := p.ctx.Err(); err != nil {
+			h.sendJSONRPCErrorToViewer(p.viewerID, p.reqID, -32800, "Queued prompt cancelled")
+		} else if promptReq, ok := h.preparePromptRequest(p.params, p.viewerID, p.reqID, p.trustedSource); ok {
+			h.runPrompt(p, promptReq, true)
+			return
+		}
+
+		next, ok := h.releasePromptSlot()
+		if !ok {
+			return
+		}
+		p = next
+	}
+}
+
+// dropQueuedPrompts rejects every waiting prompt. Used when the session stops
+// or the running prompt is force-stopped.
+func (h *SessionHost) dropQueuedPrompts(reason string) {
+	h.promptMu.Lock()
+	dropped := h.promptQueue
+	h.promptQueue = nil
+	h.promptMu.Unlock()
+	if len(dropped) > 0 {
+		h.rejectQueuedPrompts(dropped, reason)
+	}
+}
+
+func (h *SessionHost) rejectQueuedPrompts(dropped []queuedPrompt, reason string) {
+	slog.Warn("Dropping queued prompts", "sessionID", h.config.SessionID, "count", len(dropped), "reason", reason)
+	h.reportLifecycle("warn", "Queued prompts dropped", map[string]interface{}{
+		"count":  len(dropped),
+		"reason": reason,
+	})
+	for _, p := range dropped {
+		h.sendJSONRPCErrorToViewer(p.viewerID, p.reqID, -32603, "Queued prompt dropped: "+reason)
+	}
+	h.broadcastPromptQueue(nil)
+}
+
+// promptQueueBlockedReason explains why queued prompts cannot start, or
+// returns "" when the agent is ready for the next prompt.
+func (h *SessionHost) promptQueueBlockedReason() string {
+	h.mu.RLock()
+	status, recovering := h.status, h.crashRecoveryInProgress
+	h.mu.RUnlock()
+	if recovering {
+		return "agent is recovering from a crash"
+	}
+	if status != HostReady {
+		return fmt.Sprintf("agent is not ready (status: %s)", status)
+	}
+	return ""
+}
+
+// promptQueueInfoLocked describes the queue for viewers. Caller holds promptMu.
+func promptQueueInfoLocked(queue []queuedPrompt) []QueuedPromptInfo {
+	queued := make([]QueuedPromptInfo, 0, len(queue))
+	for i, p := range queue {
+		queued = append(queued, QueuedPromptInfo{
+			Position:  i + 1,
+			MessageID: p.messageID,
+			ViewerID:  p.viewerID,
+		})
+	}
+	return queued
+}
+
+func (h *SessionHost) broadcastPromptQueue(queued []QueuedPromptInfo) {
+	if queued == nil {
+		queued = []QueuedPromptInfo{}
+	}
+	data, _ := json.Marshal(SessionPromptQueueMessage{
+		Type:      MsgSessionPromptQueue,
+		Queued:    queued,
+		MaxQueued: h.maxQueuedPrompts(),
+	})
+	h.broadcastMessage(data)
+}
@@ -0,0 +1,392 @@
+package acp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	acpsdk "github.com/coder/acp-go-sdk"
+)
+
+// gatedPromptAgent answers each session/prompt only when release is
+// signalled, recording prompt texts in arrival order.
+type gatedPromptAgent struct {
+	t        *testing.T
+	reader   *bufio.Reader
+	writer   io.Writer
+	release  chan struct{}
+	received chan string
+}
+
+func (a *gatedPromptAgent) Serve() {
+	for {
+		line, err := a.reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Params struct {
+				Prompt []struct {
+					Text string `json:"text"`
+				} `json:"prompt"`
+			} `json:"params"`
+		}
+		if err := json.Unmarshal([]byte(line), &req); err != nil {
+			a.t.Errorf("unmarshal prompt request: %v", err)
+			return
+		}
+		text := ""
+		if len(req.Params.Prompt) > 0 {
+			text = req.Params.Prompt[0].Text
+		}
+		a.received <- text
+		<-a.release
+		data, _ := json.Marshal(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      req.ID,
+			"result":  map[string]interface{}{"stopReason": "end_turn"},
+		})
+		if _, err := a.writer.Write(append(data, '\n')); err != nil {
+			return
+		}
+	}
+}
+
+type recordingViewerSink struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (s *recordingViewerSink) WriteMessage(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, string(data))
+	return nil
+}
+
+func (s *recordingViewerSink) CloseWithReason(string) {}
+func (s *recordingViewerSink) Close() error           { return nil }
+
+// contains waits briefly for a delivered message containing substr; viewer
+// messages arrive through the viewer's write pump.
+func (s *recordingViewerSink) contains(substr string) bool {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		s.mu.Lock()
+		for _, msg := range s.messages {
+			if strings.Contains(msg, substr) {
+				s.mu.Unlock()
+				return true
+			}
+		}
+		s.mu.Unlock()
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func newPromptQueueTestHost(t *testing.T, maxQueued int) (*SessionHost, *gatedPromptAgent) {
+	t.Helper()
+
+	host := NewSessionHost(SessionHostConfig{
+		GatewayConfig: GatewayConfig{
+			SessionID:   "test-session",
+			WorkspaceID: "test-workspace",
+		},
+		MessageBufferSize: 200,
+		ViewerSendBuffer:  64,
+		MaxQueuedPrompts:  maxQueued,
+	})
+	t.Cleanup(host.Stop)
+
+	clientToAgentReader, clientToAgentWriter := io.Pipe()
+	agentToClientReader, agentToClientWriter := io.Pipe()
+	t.Cleanup(func() {
+		clientToAgentReader.Close()
+		clientToAgentWriter.Close()
+		agentToClientReader.Close()
+		agentToClientWriter.Close()
+	})
+
+	agent := &gatedPromptAgent{
+		t:        t,
+		reader:   bufio.NewReader(clientToAgentReader),
+		writer:   agentToClientWriter,
+		release:  make(chan struct{}),
+		received: make(chan string, 8),
+	}
+	go agent.Serve()
+
+	host.mu.Lock()
+	host.acpConn = acpsdk.NewClientSideConnection(&sessionHostClient{host: host}, clientToAgentWriter, agentToClientReader)
+	host.sessionID = "acp-session-queue"
+	host.status = HostReady
+	host.mu.Unlock()
+	return host, agent
+}
+
+func promptQueueParams(messageID, text string) json.RawMessage {
+	params, _ := json.Marshal(map[string]interface{}{
+		"messageId": messageID,
+		"prompt":    []map[string]string{{"type": "text", "text": text}},
+	})
+	return params
+}
+
+func waitForPromptText(t *testing.T, agent *gatedPromptAgent) string {
+	t.Helper()
+	select {
+	case text := <-agent.received:
+		return text
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the agent to receive a prompt")
+		return ""
+	}
+}
+
+func lastPromptQueueMessage(t *testing.T, host *SessionHost) SessionPromptQueueMessage {
+	t.Helper()
+	var last SessionPromptQueueMessage
+	host.bufMu.RLock()
+	defer host.bufMu.RUnlock()
+	for _, msg := range host.messageBuf {
+		var probe SessionPromptQueueMessage
+		if json.Unmarshal(msg.Data, &probe) == nil && probe.Type == MsgSessionPromptQueue {
+			last = probe
+		}
+	}
+	return last
+}
+
+func TestHandlePromptQueuesFollowUpsInOrder(t *testing.T) {
+	t.Parallel()
+
+	host, agent := newPromptQueueTestHost(t, 2)
+	sink := &recordingViewerSink{}
+	host.AttachViewerSink("viewer-1", sink)
+
+	var completed sync.WaitGroup
+	completed.Add(3)
+	host.config.OnPromptComplete = func(string, error) { completed.Done() }
+
+	go host.HandlePrompt(context.Background(), json.RawMessage(`1`), promptQueueParams("msg-1", "first"), "viewer-1", false)
+	if got := waitForPromptText(t, agent); got != "first" {
+		t.Fatalf("first prompt = %q", got)
+	}
+
+	// Queued prompts return immediately instead of blocking.
+	host.HandlePrompt(context.Background(), json.RawMessage(`2`), promptQueueParams("msg-2", "second"), "viewer-1", false)
+	host.HandlePrompt(context.Background(), json.RawMessage(`3`), promptQueueParams("msg-3", "third"), "viewer-1", false)
+	queue := lastPromptQueueMessage(t, host)
+	if len(queue.Queued) != 2 || queue.MaxQueued != 2 || queue.Queued[0].MessageID != "msg-2" || queue.Queued[1].Position != 2 {
+		t.Fatalf("queue after two follow-ups = %+v", queue)
+	}
+
+	// The queue is bounded.
+	host.HandlePrompt(context.Background(), json.RawMessage(`4`), promptQueueParams("msg-4", "fourth"), "viewer-1", false)
+	if !sink.contains("Prompt queue is full") {
+		t.Fatal("expected queue-full error for the fourth prompt")
+	}
+
+	agent.release <- struct{}{}
+	if got := waitForPromptText(t, agent); got != "second" {
+		t.Fatalf("second prompt = %q", got)
+	}
+	if queue := lastPromptQueueMessage(t, host); len(queue.Queued) != 1 || queue.Queued[0].MessageID != "msg-3" || queue.Queued[0].Position != 1 {
+		t.Fatalf("queue after first dequeue = %+v", queue)
+	}
+
+	agent.release <- struct{}{}
+	if got := waitForPromptText(t, agent); got != "third" {
+		t.Fatalf("third prompt = %q", got)
+	}
+	if queue := lastPromptQueueMessage(t, host); len(queue.Queued) != 0 {
+		t.Fatalf("queue after draining = %+v", queue)
+	}
+	agent.release <- struct{}{}
+	completed.Wait()
+
+	// OnPromptComplete fires before the slot is released, so poll.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		host.promptMu.Lock()
+		inFlight := host.promptInFlight
+		host.promptMu.Unlock()
+		if !inFlight {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("prompt slot still held after the queue drained")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHandlePromptRejectsConcurrentPromptWhenQueueDisabled(t *testing.T) {
+	t.Parallel()
+
+	host, agent := newPromptQueueTestHost(t, 0)
+	sink := &recordingViewerSink{}
+	host.AttachViewerSink("viewer-1", sink)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		host.HandlePrompt(context.Background(), json.RawMessage(`1`), promptQueueParams("msg-1", "first"), "viewer-1", false)
+	}()
+	waitForPromptText(t, agent)
+
+	host.HandlePrompt(context.Background(), json.RawMessage(`2`), promptQueueParams("msg-2", "second"), "viewer-1", false)
+	if !sink.contains("Prompt already in progress") {
+		t.Fatal("expected concurrent prompt to be rejected")
+	}
+	agent.release <- struct{}{}
+	<-done
+}
+
+func TestStopDropsQueuedPrompts(t *testing.T) {
+	t.Parallel()
+
+	host, agent := newPromptQueueTestHost(t, 3)
+	sink := &recordingViewerSink{}
+	host.AttachViewerSink("viewer-1", sink)
+
+	go host.HandlePrompt(context.Background(), json.RawMessage(`1`), promptQueueParams("msg-1", "first"), "viewer-1", false)
+	waitForPromptText(t, agent)
+	host.HandlePrompt(context.Background(), json.RawMessage(`2`), promptQueueParams("msg-2", "second"), "viewer-1", false)
+
+	host.dropQueuedPrompts("the session stopped")
+	if !sink.contains("Queued prompt dropped: the session stopped") {
+		t.Fatal("expected queued prompt to be rejected")
+	}
+	if queue := lastPromptQueueMessage(t, host); len(queue.Queued) != 0 {
+		t.Fatalf("queue after drop = %+v", queue)
+	}
+
+	close(agent.release)
+	select {
+	case text := <-agent.received:
+		t.Fatalf("dropped prompt %q reached the agent", text)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRunQueuedPromptSkipsCancelledRequests(t *testing.T) {
+	t.Parallel()
+
+	host, agent := newPromptQueueTestHost(t, 2)
+	sink := &recordingViewerSink{}
+	host.AttachViewerSink("viewer-1", sink)
+
+	var completed sync.WaitGroup
+	completed.Add(2)
+	host.config.OnPromptComplete = func(string, error) { completed.Done() }
+
+	go host.HandlePrompt(context.Background(), json.RawMessage(`1`), promptQueueParams("msg-1", "first"), "viewer-1", false)
+	waitForPromptText(t, agent)
+
+	cancelledCtx, cancel := context.WithCancel(context.Background())
+	host.HandlePrompt(cancelledCtx, json.RawMessage(`2`), promptQueueParams("msg-2", "second"), "viewer-1", false)
+	host.HandlePrompt(context.Background(), json.RawMessage(`3`), promptQueueParams("msg-3", "third"), "viewer-1", false)
+	cancel()
+
+	agent.release <- struct{}{}
+	if got := waitForPromptText(t, agent); got != "third" {
+		t.Fatalf("next prompt = %q, want third", got)
+	}
+	if !sink.contains("Queued prompt cancelled") {
+		t.Fatal("expected cancelled queued prompt to be reported")
+	}
+	agent.release <- struct{}{}
+	completed.Wait()
+	if err := cancelledCtx.Err(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ctx err = %v", err)
+	}
+}
+
+func TestHandlePromptCallsOnPromptStartBeforeAgent(t *testing.T) {
+	t.Parallel()
+
+	host, agent := newPromptQueueTestHost(t, 0)
+	started := make(chan string, 1)
+	host.config.OnPromptStart = func(workspaceID, sessionID, messageID string) {
+		// Runs before the prompt reaches the agent.
+		select {
+		case text := <-agent.received:
+			t.Errorf("agent received %q before OnPromptStart", text)
+		default:
+		}
+		started <- workspaceID + "/" + sessionID + "/" + messageID
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		host.HandlePrompt(context.Background(), json.RawMessage(`1`), promptQueueParams("msg-1", "refactor"), "viewer-1", false)
+	}()
+	if got := waitForPromptText(t, agent); got != "refactor" {
+		t.Fatalf("prompt = %q", got)
+	}
+	if got := <-started; got != "test-workspace/test-session/msg-1" {
+		t.Fatalf("OnPromptStart args = %q", got)
+	}
+	agent.release <- struct{}{}
+	<-done
+}
+
+func TestReadOnlyViewerCannotDriveSession(t *testing.T) {
+	host, agent := newPromptQueueTestHost(t, 0)
+	owner := &recordingViewerSink{}
+	watcher := &recordingViewerSink{}
+	host.AttachViewerSink("owner", owner)
+	host.AttachViewerSink("watcher", watcher)
+	host.SetViewerRole("watcher", ViewerRoleReadOnly)
+	if got := host.ViewerRole("owner"); got != ViewerRoleReadWrite {
+		t.Fatalf("owner role = %q", got)
+	}
+
+	ctx := context.Background()
+	gateway := NewGateway(host, nil, "watcher", nil)
+	gateway.handleMessage(ctx, []byte(`{"jsonrpc":"2.0","id":7,"method":"session/prompt","params":`+string(promptQueueParams("m1", "delete everything"))+`}`))
+	if !watcher.contains(`"type":"viewer_error"`) || !watcher.contains(`"rejected":"session/prompt"`) {
+		t.Fatal("expected a viewer_error for the read-only prompt")
+	}
+	if !watcher.contains(`"id":7`) {
+		t.Fatal("expected a JSON-RPC error for the read-only prompt")
+	}
+	gateway.handleMessage(ctx, []byte(`{"type":"select_agent","agentType":"openai-codex"}`))
+	if !watcher.contains(`"rejected":"select_agent"`) {
+		t.Fatal("expected a viewer_error for select_agent")
+	}
+	gateway.handleMessage(ctx, []byte(`{"type":"ping"}`))
+	if !watcher.contains(`"type":"pong"`) {
+		t.Fatal("read-only viewers should still get pongs")
+	}
+	select {
+	case text := <-agent.received:
+		t.Fatalf("agent received %q from a read-only viewer", text)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// The read-write viewer drives the agent and the watcher sees it.
+	go NewGateway(host, nil, "owner", nil).handleMessage(ctx, []byte(`{"jsonrpc":"2.0","id":8,"method":"session/prompt","params":`+string(promptQueueParams("m2", "hello"))+`}`))
+	if got := waitForPromptText(t, agent); got != "hello" {
+		t.Fatalf("agent received %q", got)
+	}
+	if !watcher.contains(string(MsgSessionPrompting)) {
+		t.Fatal("read-only viewer did not receive the prompt broadcast")
+	}
+	close(agent.release)
+	if owner.contains(`"type":"viewer_error"`) {
+		t.Fatal("read-write viewer was rejected")
+	}
+}
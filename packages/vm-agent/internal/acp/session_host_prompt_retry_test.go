@@ -201,6 +201,10 @@ func TestHandlePromptDoesNotRetryNonRetryableError(t *testing.T) {
 type promptRetryResponse struct {
 	errMessage string
 	stopReason string
+	// usage is returned as the prompt response's token usage.
+	usage map[string]interface{}
+	// sessionCost is sent in a usage_update notification before the response.
+	sessionCost map[string]interface{}
 }
 
 type promptRetryScript struct {
@@ -268,12 +272,29 @@ func (a *promptRetryFakeAgent) Serve() {
 		if strings.TrimSpace(stopReason) == "" {
 			stopReason = "end_turn"
 		}
+		if response.sessionCost != nil {
+			a.writeJSON(map[string]interface{}{
+				"jsonrpc": "2.0",
+				"method":  "session/update",
+				"params": map[string]interface{}{
+					"sessionId": "acp-session-retry",
+					"update": map[string]interface{}{
+						"sessionUpdate": "usage_update",
+						"size":          200000,
+						"used":          1200,
+						"cost":          response.sessionCost,
+					},
+				},
+			})
+		}
+		result := map[string]interface{}{"stopReason": stopReason}
+		if response.usage != nil {
+			result["usage"] = response.usage
+		}
 		a.writeJSON(map[string]interface{}{
 			"jsonrpc": "2.0",
 			"id":      json.RawMessage(req.ID),
-			"result": map[string]interface{}{
-				"stopReason": stopReason,
-			},
+			"result":  result,
 		})
 	}
 }
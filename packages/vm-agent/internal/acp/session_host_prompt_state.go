@@ -29,10 +29,12 @@ func (h *SessionHost) promptCancelGracePeriod() time.Duration {
 	return DefaultPromptCancelGracePeriod
 }
 
-func (h *SessionHost) beginPrompt(cancel context.CancelFunc) (uint64, bool) {
+// beginPrompt claims the prompt slot. reserved is true when the slot was
+// already handed over by releasePromptSlot and promptInFlight is still set.
+func (h *SessionHost) beginPrompt(cancel context.CancelFunc, reserved bool) (uint64, bool) {
 	h.promptMu.Lock()
 	defer h.promptMu.Unlock()
-	if h.promptInFlight {
+	if h.promptInFlight && !reserved {
 		return 0, false
 	}
 	h.promptInFlight = true
@@ -42,22 +44,25 @@ func (h *SessionHost) beginPrompt(cancel context.CancelFunc) (uint64, bool) {
 	h.promptCancel = cancel
 	h.activePromptID = promptID
 	h.promptCancelRequested = false
+	h.promptCancellation = nil
 	h.promptCancelMu.Unlock()
+	h.promptOutputSeen.Store(false)
 	return promptID, true
 }
 
-func (h *SessionHost) endPrompt(promptID uint64) {
-	h.promptMu.Lock()
-	h.promptInFlight = false
-	h.promptMu.Unlock()
-
+// endPrompt clears the finished prompt's state and releases the prompt slot,
+// returning the queued prompt that now owns it, if any.
+func (h *SessionHost) endPrompt(promptID uint64) (queuedPrompt, bool) {
 	h.promptCancelMu.Lock()
 	if h.activePromptID == promptID {
 		h.activePromptID = 0
 		h.promptCancel = nil
 		h.promptCancelRequested = false
+		h.promptCancellation = nil
 	}
 	h.promptCancelMu.Unlock()
+
+	return h.releasePromptSlot()
 }
 
 func (h *SessionHost) isPromptActive(promptID uint64) bool {
@@ -72,6 +77,39 @@ func (h *SessionHost) isPromptCancelRequested(promptID uint64) bool {
 	return h.activePromptID == promptID && h.promptCancelRequested
 }
 
+// promptCancellationFor returns the cancellation metadata for a finished
+// prompt: the recorded cancel request, a timeout when the prompt deadline
+// expired, or nil when the prompt was not cancelled.
+func (h *SessionHost) promptCancellationFor(promptID uint64, promptCtx context.Context, timeout time.Duration) *PromptCancellation {
+	h.promptCancelMu.Lock()
+	var cancellation *PromptCancellation
+	if h.activePromptID == promptID && h.promptCancelRequested && h.promptCancellation != nil {
+		c := *h.promptCancellation
+		cancellation = &c
+	}
+	h.promptCancelMu.Unlock()
+
+	if cancellation == nil {
+		if !errors.Is(promptCtx.Err(), context.DeadlineExceeded) {
+			return nil
+		}
+		cancellation = &PromptCancellation{
+			Initiator:   CancelByTimeout,
+			Reason:      promptTimeoutMessage(timeout),
+			CancelledAt: time.Now().UTC(),
+		}
+	}
+	cancellation.PartialOutput = h.promptOutputSeen.Load()
+	return cancellation
+}
+
+func promptTimeoutMessage(timeout time.Duration) string {
+	if timeout > 0 {
+		return fmt.Sprintf("Prompt timed out after %s", timeout)
+	}
+	return "Prompt cancelled (context deadline exceeded)"
+}
+
 func (h *SessionHost) watchPromptTimeout(
 	promptID uint64,
 	promptCtx context.Context,
@@ -87,25 +125,36 @@ func (h *SessionHost) watchPromptTimeout(
 		if !errors.Is(promptCtx.Err(), context.DeadlineExceeded) {
 			return
 		}
-		msg := fmt.Sprintf("Prompt timed out after %s", timeout)
+		msg := promptTimeoutMessage(timeout)
 		h.sendJSONRPCErrorToViewer(viewerID, reqID, -32603, msg)
-		h.triggerPromptForceStopIfStuck(promptID, msg)
+		h.triggerPromptForceStopIfStuck(promptID, msg, CancelByTimeout)
 	}
 }
 
-func (h *SessionHost) triggerPromptForceStopIfStuck(promptID uint64, reason string) {
+// triggerPromptForceStopIfStuck kills the agent when the prompt is still
+// active after a timeout or cancel grace period. initiator labels the
+// cancellation when no cancel request was recorded (the timeout path).
+func (h *SessionHost) triggerPromptForceStopIfStuck(promptID uint64, reason string, initiator CancelInitiator) {
 	h.promptCancelMu.Lock()
 	if h.activePromptID != promptID {
 		h.promptCancelMu.Unlock()
 		return
 	}
+	cancellation := PromptCancellation{Initiator: initiator, Reason: reason, CancelledAt: time.Now().UTC()}
+	if h.promptCancellation != nil {
+		cancellation = *h.promptCancellation
+	}
 	h.activePromptID = 0
 	h.promptCancel = nil
+	h.promptCancellation = nil
 	h.promptCancelMu.Unlock()
+	cancellation.ForceStopped = true
+	cancellation.PartialOutput = h.promptOutputSeen.Load()
 
 	h.promptMu.Lock()
 	h.promptInFlight = false
 	h.promptMu.Unlock()
+	h.dropQueuedPrompts("the running prompt was force-stopped")
 
 	h.mu.Lock()
 	agentType := h.agentType
@@ -117,9 +166,11 @@ func (h *SessionHost) triggerPromptForceStopIfStuck(promptID uint64, reason stri
 	h.mu.Unlock()
 
 	h.reportLifecycle("error", "ACP prompt force-stopped", map[string]interface{}{
-		"reason": reason,
+		"reason":    reason,
+		"initiator": string(cancellation.Initiator),
 	})
-	h.broadcastControl(MsgSessionPromptDone, nil)
+	h.broadcastPromptDone("cancelled", &cancellation)
+	h.persistPromptCancellation(cancellation)
 	h.broadcastAgentStatus(StatusError, agentType, reason)
 	// Report idle so the browser status bar clears the "prompting" spinner.
 	// The error state is already broadcast via broadcastAgentStatus above.
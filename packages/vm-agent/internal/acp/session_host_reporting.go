@@ -56,8 +56,9 @@ func (h *SessionHost) reportEvent(level, eventType, message string, detail map[s
 	}
 }
 
-// fetchAgentKey retrieves the decrypted agent credential from the control plane.
-func (h *SessionHost) fetchAgentKey(ctx context.Context, agentType string) (*agentCredential, error) {
+// requestAgentKey retrieves the decrypted agent credential from the control plane.
+// Transport failures and 5xx responses wrap errControlPlaneUnreachable.
+func (h *SessionHost) requestAgentKey(ctx context.Context, agentType string) (*agentCredential, error) {
 	url := fmt.Sprintf("%s/api/workspaces/%s/agent-key", h.config.ControlPlaneURL, h.config.WorkspaceID)
 
 	body, err := json.Marshal(map[string]string{"agentType": agentType})
@@ -74,28 +75,33 @@ func (h *SessionHost) fetchAgentKey(ctx context.Context, agentType string) (*age
 
 	resp, err := h.httpClient().Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch agent key: %w", err)
+		return nil, fmt.Errorf("failed to fetch agent key: %w: %w", errControlPlaneUnreachable, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusNotFound {
 		return nil, fmt.Errorf("no credential configured for %s", agentType)
 	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, fmt.Errorf("%w: control plane returned status %d", errControlPlaneUnreachable, resp.StatusCode)
+	}
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("control plane returned status %d", resp.StatusCode)
 	}
 
 	var result struct {
-		APIKey          string           `json:"apiKey"`
-		CredentialKind  string           `json:"credentialKind"`
-		InferenceConfig *inferenceConfig `json:"inferenceConfig,omitempty"`
+		APIKey          string                 `json:"apiKey"`
+		CredentialKind  string                 `json:"credentialKind"`
+		InferenceConfig *inferenceConfig       `json:"inferenceConfig,omitempty"`
+		CustomAgent     *customAgentDescriptor `json:"customAgent,omitempty"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
-	// Allow empty APIKey when inferenceConfig is present (platform AI proxy path).
-	if result.APIKey == "" && result.InferenceConfig == nil {
+	// Allow empty APIKey when inferenceConfig is present (platform AI proxy
+	// path) or for custom agents that take no credential (e.g. local Ollama).
+	if result.APIKey == "" && result.InferenceConfig == nil && (result.CustomAgent == nil || isBuiltinAgentType(agentType)) {
 		return nil, fmt.Errorf("empty credential returned for %s", agentType)
 	}
 
@@ -107,49 +113,45 @@ func (h *SessionHost) fetchAgentKey(ctx context.Context, agentType string) (*age
 		credential:      result.APIKey,
 		credentialKind:  result.CredentialKind,
 		inferenceConfig: result.InferenceConfig,
+		customAgent:     result.CustomAgent,
 	}, nil
 }
 
-// fetchAgentSettings retrieves user's agent settings from the control plane.
-func (h *SessionHost) fetchAgentSettings(ctx context.Context, agentType string) *agentSettingsPayload {
+// requestAgentSettings retrieves user's agent settings from the control plane.
+// Transport failures and 5xx responses wrap errControlPlaneUnreachable.
+func (h *SessionHost) requestAgentSettings(ctx context.Context, agentType string) (*agentSettingsPayload, error) {
 	url := fmt.Sprintf("%s/api/workspaces/%s/agent-settings", h.config.ControlPlaneURL, h.config.WorkspaceID)
 
 	body, err := json.Marshal(map[string]string{"agentType": agentType})
 	if err != nil {
-		slog.Warn("Failed to marshal agent settings request", "error", err)
-		return nil
+		return nil, fmt.Errorf("failed to marshal agent settings request: %w", err)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, "POST", url, byteReader(body))
 	if err != nil {
-		slog.Warn("Failed to create agent settings request", "error", err)
-		return nil
+		return nil, fmt.Errorf("failed to create agent settings request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+h.config.CallbackToken)
 
 	resp, err := h.httpClient().Do(req)
 	if err != nil {
-		slog.Warn("Failed to fetch agent settings", "error", err)
-		return nil
+		return nil, fmt.Errorf("failed to fetch agent settings: %w: %w", errControlPlaneUnreachable, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
-		slog.Warn("Agent settings returned non-OK status, using defaults",
-			"statusCode", resp.StatusCode,
-			"responseBody", string(respBody),
-			"url", url,
-			"workspaceId", h.config.WorkspaceID,
-			"agentType", agentType)
-		return nil
+		err := fmt.Errorf("agent settings returned status %d: %s", resp.StatusCode, string(respBody))
+		if resp.StatusCode >= http.StatusInternalServerError {
+			err = fmt.Errorf("%w: %w", errControlPlaneUnreachable, err)
+		}
+		return nil, err
 	}
 
 	var result agentSettingsPayload
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		slog.Warn("Failed to decode agent settings", "error", err)
-		return nil
+		return nil, fmt.Errorf("failed to decode agent settings: %w", err)
 	}
 
 	slog.Info("Fetched agent settings from control plane",
@@ -159,7 +161,7 @@ func (h *SessionHost) fetchAgentSettings(ctx context.Context, agentType string)
 		"opencodeBaseURL", result.OpencodeBaseURL,
 		"workspaceId", h.config.WorkspaceID,
 		"agentType", agentType)
-	return &result
+	return &result, nil
 }
 
 // activityPayload is the enhanced JSON body sent to the control plane.
@@ -177,12 +179,19 @@ type activityPayload struct {
 // missed starts; terminal/error reports use a larger retry budget.
 // activity should be "prompting", "idle", "recovering", or "error".
 func (h *SessionHost) reportActivity(activity string) {
-	// h.config fields are immutable after construction — no lock needed.
+	// Session activity follows the primary agent.
+	if h.companionParent != nil {
+		return
+	}
+	// h.config fields are immutable after construction — no lock needed —
+	// except SessionID, which ClaimStandby binds once under h.mu.
 	projectID := h.config.ProjectID
 	nodeID := h.config.NodeID
 	controlPlaneURL := h.config.ControlPlaneURL
 	callbackToken := h.config.CallbackToken
+	h.mu.RLock()
 	sessionID := h.config.SessionID
+	h.mu.RUnlock()
 
 	if projectID == "" || nodeID == "" || controlPlaneURL == "" || sessionID == "" {
 		slog.Debug("reportActivity: skipping, missing config",
@@ -23,6 +23,10 @@ func (h *SessionHost) SelectAgent(ctx context.Context, agentType string) {
 		"sessionId":            h.config.SessionID,
 	})
 
+	if !h.admitAgent(ctx, agentType) {
+		return
+	}
+
 	cred, err := h.fetchAgentKey(ctx, agentType)
 	if err != nil {
 		h.failAgentSelection(agentType, "agent_key_fetch", fmt.Sprintf("Failed to fetch credential for %s — check Settings", agentType), err)
@@ -30,7 +34,11 @@ func (h *SessionHost) SelectAgent(ctx context.Context, agentType string) {
 	}
 	h.reportCredentialFetched(agentType, cred)
 
-	info := getAgentCommandInfo(agentType, cred.credentialKind)
+	info, err := resolveAgentCommandInfo(agentType, cred)
+	if err != nil {
+		h.failAgentSelection(agentType, "agent_descriptor", fmt.Sprintf("Invalid configuration for %s — check Settings", agentType), err)
+		return
+	}
 	if err := h.ensureAgentInstalled(ctx, info); err != nil {
 		h.failAgentSelection(agentType, "agent_install", fmt.Sprintf("Failed to install %s: %v", info.command, err), err)
 		return
@@ -18,8 +18,8 @@ func (h *SessionHost) persistAgentSelectionFailure(agentType, message string) {
 			slog.Warn("Failed to persist agent selection error", "error", err)
 		}
 	}
-	if h.config.MessageReporter != nil && h.config.SessionID != "" {
-		if err := h.config.MessageReporter.Enqueue(MessageReportEntry{
+	if reporter := h.messageReporter(); reporter != nil && h.config.SessionID != "" {
+		if err := reporter.Enqueue(MessageReportEntry{
 			MessageID: uuid.NewString(), SessionID: h.config.SessionID, Role: "system",
 			Content: "Agent startup failed: " + message, Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
 		}); err != nil {
@@ -0,0 +1,57 @@
+package acp
+
+import (
+	"log/slog"
+	"strings"
+)
+
+// StandbyClaim carries the session-scoped configuration a warm-standby
+// SessionHost adopts when the first real agent session attaches to it.
+type StandbyClaim struct {
+	// SessionID is the agent session the standby host is bound to.
+	SessionID string
+	// MessageReporter persists chat messages for the claimed session.
+	MessageReporter MessageReporter
+	// OnPromptComplete is the task completion callback for the claimed session.
+	OnPromptComplete func(stopReason string, promptErr error)
+}
+
+// IsStandby reports whether the host is an unclaimed warm standby, i.e. it
+// was created without a session binding and nothing has claimed it yet.
+func (h *SessionHost) IsStandby() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.config.SessionID == "" && h.status != HostStopped
+}
+
+// ClaimStandby binds an unclaimed warm-standby host to a real agent session.
+// The pre-started agent process and ACP session are kept, so the session is
+// ready for prompts as soon as a viewer attaches. Returns false when the host
+// is already claimed, has no ready agent, or has been stopped — callers then
+// fall back to creating a fresh SessionHost.
+func (h *SessionHost) ClaimStandby(claim StandbyClaim) bool {
+	sessionID := strings.TrimSpace(claim.SessionID)
+	if sessionID == "" {
+		return false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.config.SessionID != "" || h.status != HostReady || h.acpConn == nil {
+		return false
+	}
+
+	h.config.SessionID = sessionID
+	h.claimMu.Lock()
+	h.config.MessageReporter = claim.MessageReporter
+	h.config.OnPromptComplete = claim.OnPromptComplete
+	h.claimMu.Unlock()
+	h.persistAcpSessionID(h.agentType)
+	h.persistClaimedMessageBuffer()
+
+	slog.Info("SessionHost: warm standby claimed",
+		"workspaceId", h.config.WorkspaceID, "sessionID", sessionID,
+		"agentType", h.agentType, "acpSessionId", string(h.sessionID))
+	return true
+}
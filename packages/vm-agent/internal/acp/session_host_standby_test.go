@@ -0,0 +1,93 @@
+package acp
+
+import (
+	"testing"
+
+	acpsdk "github.com/coder/acp-go-sdk"
+)
+
+type recordingSessionUpdater struct {
+	workspaceID  string
+	sessionID    string
+	acpSessionID string
+	agentType    string
+}
+
+func (r *recordingSessionUpdater) UpdateAcpSessionID(workspaceID, sessionID, acpSessionID, agentType string) error {
+	r.workspaceID = workspaceID
+	r.sessionID = sessionID
+	r.acpSessionID = acpSessionID
+	r.agentType = agentType
+	return nil
+}
+
+func newStandbySessionHost(t *testing.T, updater SessionUpdater) *SessionHost {
+	t.Helper()
+	return NewSessionHost(SessionHostConfig{
+		GatewayConfig: GatewayConfig{
+			WorkspaceID:    "test-workspace",
+			SessionManager: updater,
+		},
+		MessageBufferSize: 100,
+		ViewerSendBuffer:  32,
+	})
+}
+
+func TestSessionHost_ClaimStandbyRequiresReadyAgent(t *testing.T) {
+	host := newStandbySessionHost(t, nil)
+	if !host.IsStandby() {
+		t.Fatal("expected session-less host to be a standby")
+	}
+	if host.ClaimStandby(StandbyClaim{SessionID: "sess-1"}) {
+		t.Fatal("expected claim of idle standby to fail")
+	}
+	if !host.IsStandby() {
+		t.Fatal("failed claim must leave host unclaimed")
+	}
+}
+
+func TestSessionHost_ClaimStandbyBindsSession(t *testing.T) {
+	updater := &recordingSessionUpdater{}
+	host := newStandbySessionHost(t, updater)
+	host.mu.Lock()
+	host.status = HostReady
+	host.agentType = "claude-code"
+	host.sessionID = acpsdk.SessionId("acp-123")
+	host.acpConn = &acpsdk.ClientSideConnection{}
+	host.mu.Unlock()
+
+	reporter := &mockMessageReporter{}
+	var called bool
+	claimed := host.ClaimStandby(StandbyClaim{
+		SessionID:        "sess-1",
+		MessageReporter:  reporter,
+		OnPromptComplete: func(string, error) { called = true },
+	})
+	if !claimed {
+		t.Fatal("expected claim of ready standby to succeed")
+	}
+	if host.IsStandby() {
+		t.Fatal("claimed host must no longer be a standby")
+	}
+	if got := host.config.SessionID; got != "sess-1" {
+		t.Fatalf("SessionID = %q, want sess-1", got)
+	}
+	if host.messageReporter() != reporter {
+		t.Fatal("expected claimed message reporter to be installed")
+	}
+	if cb := host.OnPromptCompleteCallback(); cb == nil {
+		t.Fatal("expected claimed prompt completion callback")
+	} else {
+		cb("end_turn", nil)
+	}
+	if !called {
+		t.Fatal("expected claimed callback to be invoked")
+	}
+	if updater.sessionID != "sess-1" || updater.acpSessionID != "acp-123" || updater.agentType != "claude-code" {
+		t.Fatalf("ACP session ID not persisted for claimed session: %+v", updater)
+	}
+
+	if host.ClaimStandby(StandbyClaim{SessionID: "sess-2"}) {
+		t.Fatal("expected second claim to fail")
+	}
+}
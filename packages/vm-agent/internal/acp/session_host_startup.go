@@ -11,6 +11,7 @@ import (
 	"strings"
 
 	acpsdk "github.com/coder/acp-go-sdk"
+	"github.com/workspace/vm-agent/internal/faultinject"
 )
 
 // --- Internal: agent lifecycle (extracted from Gateway) ---
@@ -40,6 +41,7 @@ func (h *SessionHost) startAgentWithSessionMode(ctx context.Context, agentType s
 	}
 
 	h.process = process
+	h.agentCred = cred
 	h.attachACPConnection(process)
 	go h.monitorStderr(process)
 	go h.monitorProcessExit(ctx, process, agentType, cred, startup.settings)
@@ -65,8 +67,11 @@ func (h *SessionHost) prepareAgentStartup(ctx context.Context, agentType string,
 		}
 	}
 
-	info := getAgentCommandInfo(agentType, cred.credentialKind)
-	envVars := h.resolveAgentEnvVars(ctx, containerID)
+	info, err := resolveAgentCommandInfo(agentType, cred)
+	if err != nil {
+		return nil, err
+	}
+	envVars := append(h.resolveAgentEnvVars(ctx, containerID), h.commitAuthorEnvVars(agentType)...)
 	secretEnvKeys := make(map[string]bool)
 	envVars, err = h.applyRuntimeAssets(ctx, containerID, envVars, secretEnvKeys)
 	if err != nil {
@@ -80,6 +85,7 @@ func (h *SessionHost) prepareAgentStartup(ctx context.Context, agentType string,
 	}
 	envVars, settings = h.applyModelAndExtraEnv(agentType, settings, envVars)
 	h.applyPermissionMode(settings)
+	h.mcpServerSpecs = h.resolveMcpServerSpecs(ctx, containerID, settings)
 
 	return &agentStartup{
 		containerID:  containerID,
@@ -123,6 +129,7 @@ func (h *SessionHost) resolveAgentEnvVars(ctx context.Context, containerID strin
 			envVars = append(envVars, fallback)
 		}
 	}
+	envVars = append(envVars, h.commitTraceEnvVars()...)
 
 	if h.config.GitTokenFetcher != nil {
 		envVars = removeEnvVar(envVars, "GH_TOKEN")
@@ -163,6 +170,9 @@ func (h *SessionHost) injectAgentCredential(
 	info agentCommandInfo,
 	envVars []string,
 ) ([]string, *agentSettingsPayload, error) {
+	if custom := customAgentFor(agentType, cred); custom != nil {
+		return custom.appendEnv(envVars, cred.credential), settings, nil
+	}
 	if info.injectionMode == "auth-file" {
 		envVars, err := h.injectAuthFileCredential(ctx, containerID, agentType, cred, info, envVars)
 		return envVars, settings, err
@@ -492,15 +502,25 @@ func (h *SessionHost) startAgentProcess(startup *agentStartup) (agentProcess, er
 	if launcher == nil {
 		launcher = DockerExecLauncher{}
 	}
-	return launcher.Start(ProcessConfig{
+	command, args := startup.info.command, startup.info.args
+	if err := faultinject.Check(faultinject.AgentStart); err != nil {
+		// Launch a command that exits immediately through the real launcher so
+		// the rapid-exit crash path runs exactly as it would for a broken agent.
+		command, args = "sh", []string{"-c", "echo '" + err.Error() + "' >&2; exit 1"}
+	}
+	cfg := ProcessConfig{
 		ContainerID:   startup.containerID,
 		ContainerUser: h.config.ContainerUser,
-		AcpCommand:    startup.info.command,
-		AcpArgs:       startup.info.args,
+		AcpCommand:    command,
+		AcpArgs:       args,
 		EnvVars:       startup.envVars,
 		SecretEnvKeys: startup.secretEnvKey,
 		WorkDir:       h.config.ContainerWorkDir,
-	})
+	}
+	if h.config.ProcessLauncher == nil && h.config.StdioReattach {
+		return startSupervisedDockerProcess(cfg, h.config.StdioReattachTimeout)
+	}
+	return launcher.Start(cfg)
 }
 
 func (h *SessionHost) attachACPConnection(process agentProcess) {
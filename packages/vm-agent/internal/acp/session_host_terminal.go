@@ -0,0 +1,425 @@
+package acp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	acpsdk "github.com/coder/acp-go-sdk"
+	"github.com/creack/pty"
+
+	"github.com/workspace/vm-agent/internal/containerruntime"
+)
+
+const (
+	// defaultTerminalOutputByteLimit applies when CreateTerminal does not set
+	// outputByteLimit; requested limits are capped at maxTerminalOutputByteLimit.
+	defaultTerminalOutputByteLimit = 1 << 20
+	maxTerminalOutputByteLimit     = 16 << 20
+	// maxAgentTerminals bounds the terminals one session may hold open.
+	maxAgentTerminals = 32
+	// terminalDrainTimeout bounds how long output is drained after the
+	// command exits, in case a background child keeps the PTY open.
+	terminalDrainTimeout = 2 * time.Second
+)
+
+// terminalPidDir holds the pid files used to signal terminal commands inside
+// the container, where killing the local docker exec client is not enough.
+const terminalPidDir = "/tmp/sam-acp-terminals"
+
+// terminalLaunchScript records the command's pid, then execs it. Under
+// docker exec -t the command leads its own process group, so the whole group
+// can be signalled later.
+//
+// Arguments: $1 is the pid file, the rest is the command line.
+const terminalLaunchScript = `mkdir -p "${1%/*}" && echo $$ >"$1" || exit 126
+shift
+exec "$@"`
+
+// terminalSignalScript signals the command's process group (falling back to
+// the process) unless $2 is empty, and removes the pid file when $3 is
+// "release".
+const terminalSignalScript = `if [ -n "$2" ] && [ -s "$1" ]; then p=$(cat "$1"); kill -"$2" -- -"$p" 2>/dev/null || kill -"$2" "$p" 2>/dev/null; fi
+[ "$3" = release ] && rm -f "$1"
+exit 0`
+
+// agentTerminal is a command started for the agent via ACP terminal/create.
+// Output is retained up to limit bytes, dropping the oldest output first.
+type agentTerminal struct {
+	id          string
+	containerID string
+	pidFile     string
+	cmd         *exec.Cmd
+	ptmx        *os.File
+
+	mu         sync.Mutex
+	output     []byte
+	limit      int
+	truncated  bool
+	pendingCR  bool
+	exitStatus *acpsdk.TerminalExitStatus
+
+	done chan struct{} // closed once the command has exited and output is drained
+}
+
+// createTerminal starts params.Command in a PTY inside the devcontainer, or
+// locally when the session has no container.
+func (h *SessionHost) createTerminal(params acpsdk.CreateTerminalRequest) (acpsdk.CreateTerminalResponse, error) {
+	if strings.TrimSpace(params.Command) == "" {
+		return acpsdk.CreateTerminalResponse{}, fmt.Errorf("command is required")
+	}
+	for _, s := range append([]string{params.Command}, params.Args...) {
+		if strings.ContainsRune(s, 0) {
+			return acpsdk.CreateTerminalResponse{}, fmt.Errorf("command contains null byte")
+		}
+	}
+	env := make([]string, 0, len(params.Env))
+	for _, v := range params.Env {
+		if !runtimeEnvKeyPattern.MatchString(v.Name) || strings.ContainsRune(v.Value, 0) {
+			return acpsdk.CreateTerminalResponse{}, fmt.Errorf("invalid env var %q", v.Name)
+		}
+		env = append(env, v.Name+"="+v.Value)
+	}
+	cwd, err := h.terminalCwd(params.Cwd)
+	if err != nil {
+		return acpsdk.CreateTerminalResponse{}, err
+	}
+	limit := defaultTerminalOutputByteLimit
+	if params.OutputByteLimit != nil && *params.OutputByteLimit > 0 {
+		limit = min(*params.OutputByteLimit, maxTerminalOutputByteLimit)
+	}
+
+	containerID := ""
+	if h.config.ContainerResolver != nil {
+		if containerID, err = h.config.ContainerResolver(); err != nil {
+			return acpsdk.CreateTerminalResponse{}, fmt.Errorf("failed to resolve container: %w", err)
+		}
+	}
+
+	h.terminalMu.Lock()
+	if len(h.terminals) >= maxAgentTerminals {
+		h.terminalMu.Unlock()
+		return acpsdk.CreateTerminalResponse{}, fmt.Errorf("too many open terminals (max %d)", maxAgentTerminals)
+	}
+	id := fmt.Sprintf("term-%d", atomic.AddUint64(&h.terminalSeq, 1))
+	h.terminalMu.Unlock()
+
+	term := &agentTerminal{
+		id:          id,
+		containerID: containerID,
+		limit:       limit,
+		done:        make(chan struct{}),
+	}
+	if containerID != "" {
+		term.pidFile = path.Join(terminalPidDir, fmt.Sprintf("%s-%s.pid", sanitizeTerminalPathPart(h.config.SessionID), id))
+		rt := containerruntime.Current()
+		command := append([]string{"sh", "-c", terminalLaunchScript, "sam-acp-terminal", term.pidFile, params.Command}, params.Args...)
+		term.cmd = rt.Command(context.Background(), rt.ExecArgs(containerID, containerruntime.ExecOptions{
+			User:        h.config.ContainerUser,
+			WorkDir:     cwd,
+			Env:         env,
+			Interactive: true,
+			TTY:         true,
+		}, command...)...)
+	} else {
+		term.cmd = exec.Command(params.Command, params.Args...)
+		term.cmd.Dir = cwd
+		term.cmd.Env = append(os.Environ(), env...)
+	}
+
+	term.ptmx, err = pty.Start(term.cmd)
+	if err != nil {
+		return acpsdk.CreateTerminalResponse{}, fmt.Errorf("failed to start terminal command: %w", err)
+	}
+
+	h.terminalMu.Lock()
+	if h.terminals == nil {
+		h.terminals = make(map[string]*agentTerminal)
+	}
+	h.terminals[id] = term
+	h.terminalMu.Unlock()
+
+	slog.Info("ACP terminal started", "sessionID", h.config.SessionID, "terminalId", id, "command", params.Command, "container", containerID)
+	go term.run()
+	return acpsdk.CreateTerminalResponse{TerminalId: id}, nil
+}
+
+// terminalCwd resolves the requested working directory against the session's
+// working directory.
+func (h *SessionHost) terminalCwd(cwd *string) (string, error) {
+	base := h.config.ContainerWorkDir
+	if cwd == nil || *cwd == "" {
+		return base, nil
+	}
+	if strings.ContainsRune(*cwd, 0) {
+		return "", fmt.Errorf("cwd contains null byte")
+	}
+	if path.IsAbs(*cwd) {
+		return path.Clean(*cwd), nil
+	}
+	if base == "" {
+		return "", fmt.Errorf("cwd %q must be absolute", *cwd)
+	}
+	return path.Join(base, *cwd), nil
+}
+
+func (h *SessionHost) lookupTerminal(id string) (*agentTerminal, error) {
+	h.terminalMu.Lock()
+	defer h.terminalMu.Unlock()
+	term, ok := h.terminals[id]
+	if !ok {
+		return nil, fmt.Errorf("terminal %q not found", id)
+	}
+	return term, nil
+}
+
+func (h *SessionHost) terminalOutput(id string) (acpsdk.TerminalOutputResponse, error) {
+	term, err := h.lookupTerminal(id)
+	if err != nil {
+		return acpsdk.TerminalOutputResponse{}, err
+	}
+	term.mu.Lock()
+	defer term.mu.Unlock()
+	return acpsdk.TerminalOutputResponse{
+		Output:     string(term.output),
+		Truncated:  term.truncated,
+		ExitStatus: term.exitStatus,
+	}, nil
+}
+
+func (h *SessionHost) waitForTerminalExit(ctx context.Context, id string) (acpsdk.WaitForTerminalExitResponse, error) {
+	term, err := h.lookupTerminal(id)
+	if err != nil {
+		return acpsdk.WaitForTerminalExitResponse{}, err
+	}
+	select {
+	case <-term.done:
+	case <-ctx.Done():
+		return acpsdk.WaitForTerminalExitResponse{}, ctx.Err()
+	}
+	term.mu.Lock()
+	defer term.mu.Unlock()
+	return acpsdk.WaitForTerminalExitResponse{ExitCode: term.exitStatus.ExitCode, Signal: term.exitStatus.Signal}, nil
+}
+
+// RunningTerminalCount returns how many agent-started terminal commands are
+// still running.
+func (h *SessionHost) RunningTerminalCount() int {
+	h.terminalMu.Lock()
+	defer h.terminalMu.Unlock()
+	running := 0
+	for _, term := range h.terminals {
+		select {
+		case <-term.done:
+		default:
+			running++
+		}
+	}
+	return running
+}
+
+// killTerminal stops the command but keeps the terminal, so the agent can
+// still read its output and exit status.
+func (h *SessionHost) killTerminal(id string) error {
+	term, err := h.lookupTerminal(id)
+	if err != nil {
+		return err
+	}
+	term.kill(false)
+	return nil
+}
+
+// releaseTerminal kills the command if it is still running and forgets the
+// terminal. Its ID is invalid afterwards.
+func (h *SessionHost) releaseTerminal(id string) error {
+	h.terminalMu.Lock()
+	term, ok := h.terminals[id]
+	delete(h.terminals, id)
+	h.terminalMu.Unlock()
+	if !ok {
+		return fmt.Errorf("terminal %q not found", id)
+	}
+	term.kill(true)
+	return nil
+}
+
+// releaseAllTerminals kills every terminal. Called when the session stops.
+func (h *SessionHost) releaseAllTerminals() {
+	h.terminalMu.Lock()
+	terms := h.terminals
+	h.terminals = nil
+	h.terminalMu.Unlock()
+	for _, term := range terms {
+		term.kill(true)
+	}
+}
+
+// run copies PTY output into the buffer until the command exits.
+func (t *agentTerminal) run() {
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := t.ptmx.Read(buf)
+			if n > 0 {
+				t.appendOutput(buf[:n])
+			}
+			if err != nil {
+				// EIO is how a PTY reports that the command side closed.
+				if !errors.Is(err, io.EOF) && !errors.Is(err, syscall.EIO) && !errors.Is(err, os.ErrClosed) {
+					slog.Debug("ACP terminal read ended", "terminalId", t.id, "error", err)
+				}
+				return
+			}
+		}
+	}()
+
+	waitErr := t.cmd.Wait()
+	select {
+	case <-drained:
+	case <-time.After(terminalDrainTimeout):
+	}
+	t.ptmx.Close()
+	<-drained
+
+	status := terminalExitStatus(t.cmd.ProcessState, waitErr)
+	t.mu.Lock()
+	t.exitStatus = status
+	t.mu.Unlock()
+	close(t.done)
+}
+
+// appendOutput stores PTY output, turning the PTY's CRLF line endings back
+// into LF and dropping the oldest output beyond the limit at a UTF-8
+// character boundary.
+func (t *agentTerminal) appendOutput(p []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, b := range p {
+		if t.pendingCR {
+			t.pendingCR = false
+			if b != '\n' {
+				t.output = append(t.output, '\r')
+			}
+		}
+		if b == '\r' {
+			t.pendingCR = true
+			continue
+		}
+		t.output = append(t.output, b)
+	}
+	if excess := len(t.output) - t.limit; excess > 0 {
+		for excess < len(t.output) && !utf8.RuneStart(t.output[excess]) {
+			excess++
+		}
+		t.output = append(t.output[:0], t.output[excess:]...)
+		t.truncated = true
+	}
+}
+
+// kill sends SIGKILL to the command's process group. Inside a container the
+// signal is delivered with a second docker exec, since killing the local
+// docker client would leave the command running.
+func (t *agentTerminal) kill(release bool) {
+	select {
+	case <-t.done:
+		// The command already exited; only clean up its pid file.
+		if release && t.containerID != "" {
+			t.signalInContainer("", true)
+		}
+		return
+	default:
+	}
+
+	if t.containerID != "" {
+		t.signalInContainer("KILL", release)
+	} else if t.cmd.Process != nil {
+		// pty.Start makes the command a session (and process group) leader.
+		_ = syscall.Kill(-t.cmd.Process.Pid, syscall.SIGKILL)
+	}
+
+	select {
+	case <-t.done:
+	case <-time.After(terminalDrainTimeout):
+		if t.cmd.Process != nil {
+			_ = t.cmd.Process.Kill()
+		}
+	}
+}
+
+func (t *agentTerminal) signalInContainer(signal string, release bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	mode := ""
+	if release {
+		mode = "release"
+	}
+	if _, stderr, err := execInContainer(ctx, t.containerID, "", "", "sh", "-c", terminalSignalScript, "sam-acp-terminal", t.pidFile, signal, mode); err != nil {
+		slog.Warn("Failed to signal ACP terminal command", "terminalId", t.id, "signal", signal, "error", err, "stderr", stderr)
+	}
+}
+
+func terminalExitStatus(state *os.ProcessState, waitErr error) *acpsdk.TerminalExitStatus {
+	status := &acpsdk.TerminalExitStatus{}
+	if state == nil {
+		code := -1
+		if waitErr == nil {
+			code = 0
+		}
+		status.ExitCode = &code
+		return status
+	}
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		name := terminalSignalName(ws.Signal())
+		status.Signal = &name
+		return status
+	}
+	code := state.ExitCode()
+	status.ExitCode = &code
+	return status
+}
+
+func terminalSignalName(sig syscall.Signal) string {
+	switch sig {
+	case syscall.SIGKILL:
+		return "SIGKILL"
+	case syscall.SIGTERM:
+		return "SIGTERM"
+	case syscall.SIGINT:
+		return "SIGINT"
+	case syscall.SIGHUP:
+		return "SIGHUP"
+	case syscall.SIGQUIT:
+		return "SIGQUIT"
+	case syscall.SIGABRT:
+		return "SIGABRT"
+	case syscall.SIGSEGV:
+		return "SIGSEGV"
+	case syscall.SIGPIPE:
+		return "SIGPIPE"
+	default:
+		return fmt.Sprintf("SIG%d", int(sig))
+	}
+}
+
+// sanitizeTerminalPathPart keeps session IDs safe to embed in a file name.
+func sanitizeTerminalPathPart(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,159 @@
+package acp
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	acpsdk "github.com/coder/acp-go-sdk"
+)
+
+func newTerminalTestHost(t *testing.T) (*SessionHost, string) {
+	t.Helper()
+	dir := t.TempDir()
+	host := NewSessionHost(SessionHostConfig{
+		GatewayConfig: GatewayConfig{
+			SessionID:        "test-session",
+			WorkspaceID:      "test-workspace",
+			ContainerWorkDir: dir,
+		},
+	})
+	t.Cleanup(host.Stop)
+	return host, dir
+}
+
+func waitTerminal(t *testing.T, host *SessionHost, id string) acpsdk.WaitForTerminalExitResponse {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := host.waitForTerminalExit(ctx, id)
+	if err != nil {
+		t.Fatalf("waitForTerminalExit: %v", err)
+	}
+	return resp
+}
+
+func TestTerminalRunsCommandAndReportsExit(t *testing.T) {
+	t.Parallel()
+
+	host, dir := newTerminalTestHost(t)
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cwd := "sub"
+	created, err := host.createTerminal(acpsdk.CreateTerminalRequest{
+		Command: "sh",
+		Args:    []string{"-c", `printf 'hello %s\n' "$GREETING"; pwd; exit 3`},
+		Env:     []acpsdk.EnvVariable{{Name: "GREETING", Value: "world"}},
+		Cwd:     &cwd,
+	})
+	if err != nil {
+		t.Fatalf("createTerminal: %v", err)
+	}
+
+	exit := waitTerminal(t, host, created.TerminalId)
+	if exit.ExitCode == nil || *exit.ExitCode != 3 || exit.Signal != nil {
+		t.Fatalf("exit = %+v, want code 3", exit)
+	}
+
+	out, err := host.terminalOutput(created.TerminalId)
+	if err != nil {
+		t.Fatalf("terminalOutput: %v", err)
+	}
+	want := "hello world\n" + filepath.Join(dir, "sub") + "\n"
+	if out.Output != want || out.Truncated || out.ExitStatus == nil || *out.ExitStatus.ExitCode != 3 {
+		t.Fatalf("output = %+v, want %q", out, want)
+	}
+
+	if err := host.releaseTerminal(created.TerminalId); err != nil {
+		t.Fatalf("releaseTerminal: %v", err)
+	}
+	if _, err := host.terminalOutput(created.TerminalId); err == nil {
+		t.Fatal("expected released terminal to be gone")
+	}
+}
+
+func TestTerminalKillKeepsOutput(t *testing.T) {
+	t.Parallel()
+
+	host, _ := newTerminalTestHost(t)
+	created, err := host.createTerminal(acpsdk.CreateTerminalRequest{
+		Command: "sh",
+		Args:    []string{"-c", "echo started; sleep 30"},
+	})
+	if err != nil {
+		t.Fatalf("createTerminal: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		out, _ := host.terminalOutput(created.TerminalId)
+		if strings.Contains(out.Output, "started") {
+			if out.ExitStatus != nil {
+				t.Fatalf("exit status set while running: %+v", out.ExitStatus)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for terminal output")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := host.RunningTerminalCount(); n != 1 {
+		t.Fatalf("RunningTerminalCount = %d, want 1", n)
+	}
+	if err := host.killTerminal(created.TerminalId); err != nil {
+		t.Fatalf("killTerminal: %v", err)
+	}
+	exit := waitTerminal(t, host, created.TerminalId)
+	if exit.Signal == nil || *exit.Signal != "SIGKILL" {
+		t.Fatalf("exit = %+v, want SIGKILL", exit)
+	}
+	if out, err := host.terminalOutput(created.TerminalId); err != nil || out.Output != "started\n" {
+		t.Fatalf("output after kill = %+v, %v", out, err)
+	}
+	if n := host.RunningTerminalCount(); n != 0 {
+		t.Fatalf("RunningTerminalCount after kill = %d, want 0", n)
+	}
+}
+
+func TestTerminalRejectsInvalidRequests(t *testing.T) {
+	t.Parallel()
+
+	host, _ := newTerminalTestHost(t)
+	for name, req := range map[string]acpsdk.CreateTerminalRequest{
+		"empty command": {Command: " "},
+		"bad env":       {Command: "true", Env: []acpsdk.EnvVariable{{Name: "BAD-NAME", Value: "x"}}},
+		"null in arg":   {Command: "echo", Args: []string{"a\x00"}},
+	} {
+		if _, err := host.createTerminal(req); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	if _, err := host.terminalOutput("term-missing"); err == nil {
+		t.Error("expected unknown terminal error")
+	}
+}
+
+func TestTerminalOutputLimitTruncatesAtCharBoundary(t *testing.T) {
+	t.Parallel()
+
+	term := &agentTerminal{limit: 3}
+	term.appendOutput([]byte("ab\r"))
+	term.appendOutput([]byte("\nc€d"))
+	// "ab\nc€d" is 8 bytes; keeping the last 3 would split the euro sign, so
+	// the cut moves forward to the next character.
+	if got := string(term.output); got != "d" || !term.truncated {
+		t.Fatalf("output = %q truncated=%v, want %q", got, term.truncated, "d")
+	}
+
+	term = &agentTerminal{limit: 100}
+	term.appendOutput([]byte("progress\r50%\r\n"))
+	if got := string(term.output); got != "progress\r50%\n" {
+		t.Fatalf("output = %q", got)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"sync"
 	"syscall"
@@ -602,6 +603,41 @@ func TestSessionHost_BroadcastToMultipleViewers(t *testing.T) {
 	}
 }
 
+func TestSessionHost_BroadcastTransientIsNotBuffered(t *testing.T) {
+	t.Parallel()
+
+	host := newTestSessionHost(t)
+	defer host.Stop()
+
+	server, client := testWSPair(t)
+	host.AttachViewer("v1", server)
+	for i := 0; i < 3; i++ {
+		client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, _, err := client.ReadMessage(); err != nil {
+			t.Fatalf("drain message %d: %v", i, err)
+		}
+	}
+
+	msg := []byte(`{"type":"workspace_announcement","id":"ann-1"}`)
+	host.BroadcastTransient(msg)
+
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, got, err := client.ReadMessage()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(got) != string(msg) {
+		t.Fatalf("viewer got %q, want %q", got, msg)
+	}
+
+	host.bufMu.RLock()
+	buffered := len(host.messageBuf)
+	host.bufMu.RUnlock()
+	if buffered != 0 {
+		t.Fatalf("transient broadcast was buffered (%d messages)", buffered)
+	}
+}
+
 func TestSessionHost_MessageBuffer(t *testing.T) {
 	t.Parallel()
 
@@ -778,6 +814,209 @@ func TestSessionHost_ReplayDoesNotDropMessages(t *testing.T) {
 	}
 }
 
+func TestSessionHost_DeltaReplayAfterLastSeq(t *testing.T) {
+	t.Parallel()
+
+	host := NewSessionHost(SessionHostConfig{
+		GatewayConfig: GatewayConfig{
+			SessionID:   "test-session",
+			WorkspaceID: "test-workspace",
+		},
+		MessageBufferSize: 10,
+		ViewerSendBuffer:  64,
+	})
+	defer host.Stop()
+	broadcast := func(from, to int) {
+		for i := from; i < to; i++ {
+			msg, _ := json.Marshal(map[string]int{"i": i})
+			host.broadcastMessage(msg)
+		}
+	}
+
+	// attach returns the pre-replay session_state and the replayed payloads.
+	attach := func(id string, lastSeq uint64) (SessionStateMessage, []string, *PollQueue) {
+		t.Helper()
+		queue := NewPollQueue(1000)
+		if host.AttachViewerSinkAfter(id, queue, lastSeq) == nil {
+			t.Fatal("attach returned nil")
+		}
+		var state SessionStateMessage
+		var replayed []string
+		var cursor uint64
+		for {
+			batch := queue.Poll(context.Background(), cursor, 2*time.Second, 100)
+			if len(batch.Messages) == 0 {
+				t.Fatal("timed out waiting for replay")
+			}
+			cursor = batch.Cursor
+			for _, msg := range batch.Messages {
+				var probe struct {
+					Type string `json:"type"`
+				}
+				_ = json.Unmarshal(msg.Data, &probe)
+				switch {
+				case probe.Type == string(MsgSessionState) && state.Type == "":
+					_ = json.Unmarshal(msg.Data, &state)
+				case probe.Type == string(MsgSessionReplayDone):
+					return state, replayed, queue
+				case probe.Type == "":
+					replayed = append(replayed, string(msg.Data))
+				}
+			}
+		}
+	}
+
+	broadcast(0, 5)
+	state, replayed, _ := attach("full", 0)
+	if state.ReplayCount != 5 || state.ReplayAfterSeq != 0 || len(replayed) != 5 {
+		t.Fatalf("full replay: state = %+v, replayed = %v", state, replayed)
+	}
+
+	broadcast(5, 7)
+	state, replayed, _ = attach("delta", 5)
+	if state.ReplayCount != 2 || state.ReplayAfterSeq != 5 {
+		t.Fatalf("delta state = %+v", state)
+	}
+	if len(replayed) != 2 || replayed[0] != `{"i":5}` || replayed[1] != `{"i":6}` {
+		t.Fatalf("delta replayed = %v", replayed)
+	}
+
+	// A sequence the host never issued falls back to a full replay.
+	if state, _, _ = attach("future", 99); state.ReplayCount != 7 || state.ReplayAfterSeq != 0 {
+		t.Fatalf("future last_seq state = %+v", state)
+	}
+
+	// Once messages after lastSeq are evicted the delta is unavailable.
+	broadcast(7, 20)
+	if state, _, _ = attach("evicted", 5); state.ReplayCount != 10 || state.ReplayAfterSeq != 0 {
+		t.Fatalf("evicted last_seq state = %+v", state)
+	}
+	if state, _, _ = attach("caught-up", 20); state.ReplayCount != 0 || state.ReplayAfterSeq != 20 {
+		t.Fatalf("caught-up state = %+v", state)
+	}
+}
+
+func TestSessionHost_ViewerAcks(t *testing.T) {
+	t.Parallel()
+
+	host := newTestSessionHost(t)
+	defer host.Stop()
+	for i := 0; i < 3; i++ {
+		host.broadcastMessage([]byte(`{"jsonrpc":"2.0","method":"session/update"}`))
+	}
+
+	sink := &recordingViewerSink{}
+	viewer := host.AttachViewerSink("v1", sink)
+	host.sendViewerAcks()
+	if !sink.contains(`{"type":"session_ack","seq":3}`) {
+		t.Fatal("expected an ack covering the replay")
+	}
+
+	host.broadcastMessage([]byte(`{"jsonrpc":"2.0","method":"session/update"}`))
+	host.sendViewerAcks()
+	if !sink.contains(`{"type":"session_ack","seq":4}`) {
+		t.Fatal("expected an ack for the live message")
+	}
+
+	// A lagging viewer is not acked until it resyncs.
+	host.markViewerLagging(viewer, 4)
+	host.broadcastMessage([]byte(`{"jsonrpc":"2.0","method":"session/update"}`))
+	host.sendViewerAcks()
+	time.Sleep(50 * time.Millisecond)
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	for _, msg := range sink.messages {
+		if strings.Contains(msg, `"seq":5`) {
+			t.Fatalf("lagging viewer was acked: %s", msg)
+		}
+	}
+}
+
+// gatedViewerSink records messages and blocks writes while gate is set.
+type gatedViewerSink struct {
+	recordingViewerSink
+	gateMu sync.Mutex
+	gate   chan struct{}
+}
+
+func (s *gatedViewerSink) WriteMessage(data []byte) error {
+	s.gateMu.Lock()
+	gate := s.gate
+	s.gateMu.Unlock()
+	if gate != nil {
+		<-gate
+	}
+	return s.recordingViewerSink.WriteMessage(data)
+}
+
+func TestSessionHost_LaggingViewerResyncs(t *testing.T) {
+	t.Parallel()
+
+	host := NewSessionHost(SessionHostConfig{
+		GatewayConfig: GatewayConfig{
+			SessionID:   "test-session",
+			WorkspaceID: "test-workspace",
+		},
+		MessageBufferSize: 100,
+		ViewerSendBuffer:  2,
+	})
+	defer host.Stop()
+
+	sink := &gatedViewerSink{}
+	viewer := host.AttachViewerSink("slow", sink)
+	if !sink.contains(`"replayCount":0`) {
+		t.Fatal("attach did not complete")
+	}
+
+	// Block the transport so the send channel overflows mid-response.
+	gate := make(chan struct{})
+	sink.gateMu.Lock()
+	sink.gate = gate
+	sink.gateMu.Unlock()
+	const total = 20
+	for i := 0; i < total; i++ {
+		host.broadcastMessage([]byte(fmt.Sprintf(`{"chunk":%d}`, i)))
+	}
+	if !viewer.isLagging() {
+		t.Fatal("expected the viewer to be lagging")
+	}
+
+	sink.gateMu.Lock()
+	sink.gate = nil
+	sink.gateMu.Unlock()
+	close(gate)
+	if !sink.contains(fmt.Sprintf(`{"chunk":%d}`, total-1)) {
+		t.Fatal("lagging viewer never caught up")
+	}
+
+	// Every chunk arrives exactly once and in order, after a resync marker.
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	next, resynced := 0, false
+	for _, msg := range sink.messages {
+		if strings.Contains(msg, `"type":"session_resync"`) {
+			var resync SessionResyncMessage
+			if err := json.Unmarshal([]byte(msg), &resync); err != nil || resync.ReplayAfterSeq != uint64(next) || resync.ReplayCount != total-next {
+				t.Fatalf("resync = %s after %d chunks", msg, next)
+			}
+			resynced = true
+			continue
+		}
+		if strings.HasPrefix(msg, `{"chunk":`) {
+			if msg != fmt.Sprintf(`{"chunk":%d}`, next) {
+				t.Fatalf("got %s, want chunk %d", msg, next)
+			}
+			next++
+		}
+	}
+	if !resynced || next != total {
+		t.Fatalf("resynced = %v, chunks = %d", resynced, next)
+	}
+	if viewer.isLagging() {
+		t.Fatal("viewer still lagging after resync")
+	}
+}
+
 func TestSessionHost_StopDisconnectsViewers(t *testing.T) {
 	t.Parallel()
 
@@ -1703,7 +1942,7 @@ func TestSessionHost_CancelPromptFromControlPlane_ForwardsSessionCancel(t *testi
 	host.promptCancel = cancel
 	host.promptCancelMu.Unlock()
 
-	host.CancelPromptFromControlPlane()
+	host.CancelPromptFromControlPlane(PromptCancellation{Initiator: CancelByUser})
 
 	select {
 	case <-ctx.Done():
@@ -1885,21 +2124,95 @@ func TestSessionHost_SendToViewerPriority_EvictsQueuedMessage(t *testing.T) {
 
 	viewer := &Viewer{
 		ID:     "v1",
-		sendCh: make(chan []byte, 1),
+		sendCh: make(chan viewerMessage, 1),
 		done:   make(chan struct{}),
 	}
 
-	viewer.sendCh <- []byte(`{"old":true}`)
+	viewer.sendCh <- viewerMessage{data: []byte(`{"old":true}`)}
 	host.sendToViewerPriority(viewer, []byte(`{"priority":true}`))
 
 	select {
 	case msg := <-viewer.sendCh:
-		if string(msg) != `{"priority":true}` {
-			t.Fatalf("priority message not delivered, got %s", string(msg))
+		if string(msg.data) != `{"priority":true}` {
+			t.Fatalf("priority message not delivered, got %s", string(msg.data))
 		}
 	default:
 		t.Fatal("expected a priority message in viewer channel")
 	}
+	if viewer.isLagging() {
+		t.Fatal("evicting a transient message should not make the viewer lag")
+	}
+}
+
+func TestSessionHost_SendToViewerPriority_ResyncsFromEvictedMessage(t *testing.T) {
+	t.Parallel()
+
+	host := newTestSessionHost(t)
+	defer host.Stop()
+
+	viewer := &Viewer{
+		ID:       "v1",
+		sink:     &recordingViewerSink{},
+		sendCh:   make(chan viewerMessage, 3),
+		done:     make(chan struct{}),
+		resyncCh: make(chan struct{}, 1),
+	}
+	host.viewerMu.Lock()
+	host.viewers[viewer.ID] = viewer
+	host.viewerMu.Unlock()
+
+	// Chunks 0 and 1 are delivered; 2-4 fill the channel.
+	for i := 0; i < 5; i++ {
+		host.broadcastMessage([]byte(fmt.Sprintf(`{"chunk":%d}`, i)))
+		if i == 1 {
+			<-viewer.sendCh
+			<-viewer.sendCh
+		}
+	}
+	host.sendToViewerPriority(viewer, []byte(`{"priority":true}`))
+	if !viewer.isLagging() {
+		t.Fatal("evicting a buffered message should make the viewer lag")
+	}
+
+	go host.viewerWritePump(viewer)
+	sink := viewer.sink.(*recordingViewerSink)
+	if !sink.contains(`{"chunk":4}`) {
+		t.Fatal("lagging viewer never caught up")
+	}
+
+	// The evicted chunk 2 and the chunks queued behind it arrive once, after
+	// a resync that resumes from chunk 1 (sequence 2).
+	want := []string{
+		`{"priority":true}`,
+		`{"type":"session_resync","replayCount":3,"replayAfterSeq":2}`,
+		`{"chunk":2}`, `{"chunk":3}`, `{"chunk":4}`,
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if !reflect.DeepEqual(sink.messages, want) {
+		t.Fatalf("messages = %q\nwant %q", sink.messages, want)
+	}
+}
+
+func TestSessionHost_LaggingIdleViewerResyncsWithoutBroadcast(t *testing.T) {
+	t.Parallel()
+
+	host := newTestSessionHost(t)
+	defer host.Stop()
+
+	sink := &recordingViewerSink{}
+	viewer := host.AttachViewerSink("v1", sink)
+	host.broadcastMessage([]byte(`{"chunk":0}`))
+	if !sink.contains(`{"chunk":0}`) {
+		t.Fatal("attach did not complete")
+	}
+
+	// The channel is already drained when the viewer is marked lagging, so
+	// only the wake-up can trigger the resync.
+	host.markViewerLagging(viewer, 0)
+	if !sink.contains(`"type":"session_resync"`) {
+		t.Fatal("idle lagging viewer was never resynced")
+	}
 }
 
 func TestSessionHost_Suspend(t *testing.T) {
@@ -2458,6 +2771,125 @@ func TestSessionHost_CancelPrompt_ForceStopsAfterGracePeriod(t *testing.T) {
 	}
 }
 
+func TestSessionHost_ForceStopBroadcastsStructuredCancellation(t *testing.T) {
+	t.Parallel()
+
+	reporter := &mockMessageReporter{}
+	host := NewSessionHost(SessionHostConfig{
+		GatewayConfig: GatewayConfig{
+			SessionID:               "test-session",
+			WorkspaceID:             "test-workspace",
+			PromptCancelGracePeriod: 10 * time.Millisecond,
+			MessageReporter:         reporter,
+		},
+		MessageBufferSize: 100,
+		ViewerSendBuffer:  32,
+	})
+	defer host.Stop()
+
+	host.mu.Lock()
+	host.status = HostPrompting
+	host.agentType = "claude-code"
+	host.mu.Unlock()
+
+	_, cancel := context.WithCancel(context.Background())
+	promptID, ok := host.beginPrompt(cancel, false)
+	if !ok {
+		t.Fatal("beginPrompt failed")
+	}
+
+	client := &sessionHostClient{host: host}
+	if err := client.SessionUpdate(context.Background(), acpsdk.SessionNotification{
+		SessionId: "acp-session",
+		Update:    acpsdk.UpdateAgentMessageText("half an answer"),
+	}); err != nil {
+		t.Fatalf("SessionUpdate: %v", err)
+	}
+
+	host.cancelPrompt(PromptCancellation{Initiator: CancelByBudget, Reason: "Monthly budget exhausted"}, true)
+	// A later user stop must not relabel the cancellation.
+	host.CancelPrompt()
+
+	var done SessionPromptDoneMessage
+	deadline := time.Now().Add(time.Second)
+	for done.Cancellation == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("expected session_prompt_done with cancellation metadata")
+		}
+		host.bufMu.RLock()
+		for _, msg := range host.messageBuf {
+			var probe SessionPromptDoneMessage
+			if json.Unmarshal(msg.Data, &probe) == nil && probe.Type == MsgSessionPromptDone {
+				done = probe
+			}
+		}
+		host.bufMu.RUnlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	c := done.Cancellation
+	if c.Initiator != CancelByBudget || c.Reason != "Monthly budget exhausted" || !c.PartialOutput || !c.ForceStopped || c.CancelledAt.IsZero() {
+		t.Fatalf("cancellation = %+v", c)
+	}
+	if done.StopReason != "cancelled" {
+		t.Fatalf("stopReason = %q, want cancelled", done.StopReason)
+	}
+	if host.isPromptActive(promptID) {
+		t.Fatal("prompt should not be active after force-stop")
+	}
+
+	var persisted *MessageReportEntry
+	for _, msg := range reporter.Messages() {
+		if msg.Role == "system" {
+			msg := msg
+			persisted = &msg
+		}
+	}
+	if persisted == nil {
+		t.Fatal("expected cancellation system message in transcript")
+	}
+	if want := "Prompt stopped: usage budget reached: Monthly budget exhausted (partial output)"; persisted.Content != want {
+		t.Fatalf("transcript content = %q, want %q", persisted.Content, want)
+	}
+	var metadata struct {
+		PromptCancellation PromptCancellation `json:"promptCancellation"`
+	}
+	if err := json.Unmarshal([]byte(persisted.ToolMetadata), &metadata); err != nil || metadata.PromptCancellation.Initiator != CancelByBudget {
+		t.Fatalf("transcript metadata = %q, %v", persisted.ToolMetadata, err)
+	}
+}
+
+func TestSessionHost_PromptCancellationFor(t *testing.T) {
+	t.Parallel()
+
+	host := newTestSessionHost(t)
+	defer host.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	promptID, _ := host.beginPrompt(cancel, false)
+	if got := host.promptCancellationFor(promptID, ctx, time.Minute); got != nil {
+		t.Fatalf("uncancelled prompt cancellation = %+v, want nil", got)
+	}
+
+	expired, expiredCancel := context.WithTimeout(context.Background(), -time.Second)
+	defer expiredCancel()
+	got := host.promptCancellationFor(promptID, expired, 90*time.Second)
+	if got == nil || got.Initiator != CancelByTimeout || got.Reason != "Prompt timed out after 1m30s" || got.PartialOutput {
+		t.Fatalf("timeout cancellation = %+v", got)
+	}
+
+	host.CancelPrompt()
+	got = host.promptCancellationFor(promptID, ctx, time.Minute)
+	if got == nil || got.Initiator != CancelByUser {
+		t.Fatalf("user cancellation = %+v", got)
+	}
+
+	host.endPrompt(promptID)
+	if got := host.promptCancellationFor(promptID, ctx, time.Minute); got != nil {
+		t.Fatalf("cancellation after endPrompt = %+v, want nil", got)
+	}
+}
+
 func TestHandlePrompt_InjectsSyntheticUserMessage(t *testing.T) {
 	t.Parallel()
 
@@ -0,0 +1,177 @@
+package acp
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"time"
+
+	acpsdk "github.com/coder/acp-go-sdk"
+)
+
+// promptUsagePayload is the per-prompt usage record sent to the control
+// plane for cost attribution. Token counts come from the prompt response and
+// are omitted when the agent does not report usage. Cost is the increase of
+// the session cost reported by usage_update notifications during the prompt.
+type promptUsagePayload struct {
+	NodeID            string   `json:"nodeId"`
+	WorkspaceID       string   `json:"workspaceId,omitempty"`
+	AgentType         string   `json:"agentType,omitempty"`
+	Model             string   `json:"model,omitempty"`
+	MessageID         string   `json:"messageId,omitempty"`
+	StopReason        string   `json:"stopReason"`
+	StartedAt         int64    `json:"startedAt"`
+	DurationMs        int64    `json:"durationMs"`
+	InputTokens       *int     `json:"inputTokens,omitempty"`
+	OutputTokens      *int     `json:"outputTokens,omitempty"`
+	CachedReadTokens  *int     `json:"cachedReadTokens,omitempty"`
+	CachedWriteTokens *int     `json:"cachedWriteTokens,omitempty"`
+	ThoughtTokens     *int     `json:"thoughtTokens,omitempty"`
+	TotalTokens       *int     `json:"totalTokens,omitempty"`
+	CostAmount        *float64 `json:"costAmount,omitempty"`
+	CostCurrency      string   `json:"costCurrency,omitempty"`
+}
+
+// promptUsageRecord collects what runPrompt knows about a finished prompt.
+type promptUsageRecord struct {
+	messageID  string
+	stopReason string
+	startedAt  time.Time
+	duration   time.Duration
+	usage      *acpsdk.Usage
+	costBefore *acpsdk.Cost
+}
+
+// recordSessionCost stores the cumulative session cost from a usage_update
+// notification.
+func (h *SessionHost) recordSessionCost(cost *acpsdk.Cost) {
+	if cost == nil {
+		return
+	}
+	h.usageMu.Lock()
+	snapshot := *cost
+	h.sessionCost = &snapshot
+	h.usageMu.Unlock()
+}
+
+// sessionCostSnapshot returns the last reported cumulative session cost, or
+// nil when the agent has not reported one.
+func (h *SessionHost) sessionCostSnapshot() *acpsdk.Cost {
+	h.usageMu.Lock()
+	defer h.usageMu.Unlock()
+	if h.sessionCost == nil {
+		return nil
+	}
+	snapshot := *h.sessionCost
+	return &snapshot
+}
+
+// resetSessionCost forgets the cumulative cost when a new ACP session starts.
+func (h *SessionHost) resetSessionCost() {
+	h.usageMu.Lock()
+	h.sessionCost = nil
+	h.usageMu.Unlock()
+}
+
+// promptCost returns the cost of one prompt from the cumulative session cost
+// before and after it. A lower or differently denominated total means the
+// agent started counting again, so the new total is the prompt's cost.
+func promptCost(before, after *acpsdk.Cost) (*float64, string) {
+	if after == nil {
+		return nil, ""
+	}
+	amount := after.Amount
+	if before != nil && before.Currency == after.Currency && before.Amount <= after.Amount {
+		amount = after.Amount - before.Amount
+	}
+	return &amount, after.Currency
+}
+
+// currentModel returns the model selected in the agent's session config
+// options, falling back to the profile's model override.
+func (h *SessionHost) currentModel() string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, option := range h.configOptions {
+		if option.Select == nil || option.Select.Category == nil {
+			continue
+		}
+		if *option.Select.Category == acpsdk.SessionConfigOptionCategoryModel && option.Select.CurrentValue != "" {
+			return string(option.Select.CurrentValue)
+		}
+	}
+	return h.config.ModelOverride
+}
+
+// reportPromptUsage sends the usage record of a finished prompt to the
+// control plane. Reports are retried like terminal activity reports; a report
+// that still cannot be delivered is handed to the callback outbox.
+func (h *SessionHost) reportPromptUsage(record promptUsageRecord) {
+	projectID := h.config.ProjectID
+	nodeID := h.config.NodeID
+	controlPlaneURL := h.config.ControlPlaneURL
+	callbackToken := h.config.CallbackToken
+	h.mu.RLock()
+	sessionID := h.config.SessionID
+	h.mu.RUnlock()
+	if projectID == "" || nodeID == "" || controlPlaneURL == "" || sessionID == "" {
+		return
+	}
+
+	payload := promptUsagePayload{
+		NodeID:      nodeID,
+		WorkspaceID: h.config.WorkspaceID,
+		AgentType:   h.AgentType(),
+		Model:       h.currentModel(),
+		MessageID:   record.messageID,
+		StopReason:  record.stopReason,
+		StartedAt:   record.startedAt.UnixMilli(),
+		DurationMs:  record.duration.Milliseconds(),
+	}
+	if usage := record.usage; usage != nil {
+		payload.InputTokens = &usage.InputTokens
+		payload.OutputTokens = &usage.OutputTokens
+		payload.TotalTokens = &usage.TotalTokens
+		payload.CachedReadTokens = usage.CachedReadTokens
+		payload.CachedWriteTokens = usage.CachedWriteTokens
+		payload.ThoughtTokens = usage.ThoughtTokens
+	}
+	payload.CostAmount, payload.CostCurrency = promptCost(record.costBefore, h.sessionCostSnapshot())
+
+	go func() {
+		path := "/api/projects/" + projectID + "/acp-sessions/" + sessionID + "/usage"
+		url := strings.TrimRight(controlPlaneURL, "/") + path
+
+		body, err := json.Marshal(payload)
+		if err != nil {
+			slog.Warn("reportPromptUsage: marshal failed", "error", err)
+			return
+		}
+
+		maxAttempts, retryBackoff := h.activityReportRetryPolicy("idle")
+		for attempt := 1; attempt <= maxAttempts; attempt++ {
+			statusCode, doErr := h.doActivityRequest(url, body, callbackToken)
+			if (doErr != nil || statusCode >= 500) && attempt < maxAttempts {
+				time.Sleep(retryBackoff)
+				continue
+			}
+			if doErr != nil || statusCode >= 500 {
+				slog.Warn("reportPromptUsage: all attempts failed; queueing for redelivery", "status", statusCode, "error", doErr)
+				h.enqueueCallback(path, body)
+			} else if statusCode >= 400 {
+				slog.Warn("reportPromptUsage: non-2xx response", "status", statusCode)
+			}
+			return
+		}
+	}()
+}
+
+// enqueueCallback hands an undeliverable callback to the configured outbox.
+func (h *SessionHost) enqueueCallback(path string, body []byte) {
+	if h.config.CallbackOutbox == nil {
+		return
+	}
+	if err := h.config.CallbackOutbox.EnqueueCallback(h.config.WorkspaceID, path, body); err != nil {
+		slog.Warn("Failed to queue callback for redelivery", "path", path, "error", err)
+	}
+}
@@ -0,0 +1,150 @@
+package acp
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	acpsdk "github.com/coder/acp-go-sdk"
+)
+
+func TestHandlePromptReportsUsage(t *testing.T) {
+	t.Parallel()
+
+	host, _ := newPromptRetryTestHost(t, promptRetryScript{
+		responses: []promptRetryResponse{{
+			stopReason:  "end_turn",
+			usage:       map[string]interface{}{"inputTokens": 1200, "outputTokens": 340, "cachedReadTokens": 800, "totalTokens": 2340},
+			sessionCost: map[string]interface{}{"amount": 0.35, "currency": "USD"},
+		}},
+	})
+
+	reports := make(chan promptUsagePayload, 1)
+	controlPlane := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/projects/proj-1/acp-sessions/test-session/usage" {
+			return
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer callback-token" {
+			t.Errorf("Authorization = %q", got)
+		}
+		var payload promptUsagePayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("decode usage payload: %v", err)
+		}
+		reports <- payload
+	}))
+	t.Cleanup(controlPlane.Close)
+
+	host.config.ProjectID = "proj-1"
+	host.config.NodeID = "node-1"
+	host.config.ControlPlaneURL = controlPlane.URL
+	host.config.CallbackToken = "callback-token"
+	host.config.ModelOverride = "claude-sonnet"
+	host.mu.Lock()
+	host.agentType = "claude-code"
+	host.mu.Unlock()
+	host.recordSessionCost(&acpsdk.Cost{Amount: 0.10, Currency: "USD"})
+
+	host.HandlePrompt(context.Background(), json.RawMessage(`1`), promptRetryParams(), "viewer-1", false)
+
+	var got promptUsagePayload
+	select {
+	case got = <-reports:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for usage report")
+	}
+	if got.NodeID != "node-1" || got.WorkspaceID != "test-workspace" || got.AgentType != "claude-code" || got.Model != "claude-sonnet" {
+		t.Fatalf("usage identity = %+v", got)
+	}
+	if got.MessageID != "retry-message-001" || got.StopReason != "end_turn" || got.StartedAt == 0 {
+		t.Fatalf("usage prompt = %+v", got)
+	}
+	if got.InputTokens == nil || *got.InputTokens != 1200 || got.OutputTokens == nil || *got.OutputTokens != 340 ||
+		got.CachedReadTokens == nil || *got.CachedReadTokens != 800 || got.TotalTokens == nil || *got.TotalTokens != 2340 {
+		t.Fatalf("usage tokens = %+v", got)
+	}
+	if got.CachedWriteTokens != nil || got.ThoughtTokens != nil {
+		t.Fatalf("unreported token counts should be omitted: %+v", got)
+	}
+	if got.CostAmount == nil || math.Abs(*got.CostAmount-0.25) > 1e-9 || got.CostCurrency != "USD" {
+		t.Fatalf("usage cost = %v %q, want 0.25 USD", got.CostAmount, got.CostCurrency)
+	}
+}
+
+type recordingOutbox struct {
+	callbacks chan string
+}
+
+func (o recordingOutbox) EnqueueCallback(workspaceID, path string, payload []byte) error {
+	o.callbacks <- workspaceID + " " + path
+	return nil
+}
+
+func TestHandlePromptQueuesUndeliveredUsage(t *testing.T) {
+	t.Parallel()
+
+	host, _ := newPromptRetryTestHost(t, promptRetryScript{
+		responses: []promptRetryResponse{{stopReason: "end_turn"}},
+	})
+
+	controlPlane := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(controlPlane.Close)
+
+	outbox := recordingOutbox{callbacks: make(chan string, 1)}
+	host.config.ProjectID = "proj-1"
+	host.config.NodeID = "node-1"
+	host.config.ControlPlaneURL = controlPlane.URL
+	host.config.TerminalActivityReportAttempts = 1
+	host.config.CallbackOutbox = outbox
+
+	host.HandlePrompt(context.Background(), json.RawMessage(`1`), promptRetryParams(), "viewer-1", false)
+
+	select {
+	case got := <-outbox.callbacks:
+		if got != "test-workspace /api/projects/proj-1/acp-sessions/test-session/usage" {
+			t.Fatalf("queued callback = %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for usage report to be queued")
+	}
+}
+
+func TestPromptCost(t *testing.T) {
+	t.Parallel()
+
+	usd := func(amount float64) *acpsdk.Cost { return &acpsdk.Cost{Amount: amount, Currency: "USD"} }
+	tests := []struct {
+		name     string
+		before   *acpsdk.Cost
+		after    *acpsdk.Cost
+		want     float64
+		wantNone bool
+	}{
+		{name: "no cost reported", wantNone: true},
+		{name: "first report", after: usd(0.4), want: 0.4},
+		{name: "increase", before: usd(1), after: usd(1.5), want: 0.5},
+		{name: "counter restarted", before: usd(2), after: usd(0.3), want: 0.3},
+		{name: "currency changed", before: usd(1), after: &acpsdk.Cost{Amount: 0.2, Currency: "EUR"}, want: 0.2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, _ := promptCost(tt.before, tt.after)
+			if tt.wantNone {
+				if got != nil {
+					t.Fatalf("promptCost() = %v, want nil", *got)
+				}
+				return
+			}
+			if got == nil || math.Abs(*got-tt.want) > 1e-9 {
+				t.Fatalf("promptCost() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,560 @@
+package acp
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/containerruntime"
+)
+
+// DefaultStdioReattachTimeout bounds how long the stdio supervisor keeps
+// trying to re-attach to a still-running agent before giving up and reporting
+// the process as exited, which falls back to a full agent restart.
+const DefaultStdioReattachTimeout = 30 * time.Second
+
+// stdioDirBase is where supervised agents keep their stdio FIFOs inside the
+// container.
+const stdioDirBase = "/tmp/sam-acp"
+
+// stdioLaunchScript starts the agent detached from the docker exec session
+// with its stdio bound to FIFOs. The FIFOs are opened read-write so the agent
+// never sees EOF on stdin or EPIPE on stdout while no relay is attached;
+// output produced in the meantime stays buffered in the pipe until a relay
+// drains it. After the agent exits its status is written to $dir/exit and any
+// relay still blocked opening a FIFO is killed.
+//
+// Arguments: $1 is the stdio directory, the rest is the agent command line.
+const stdioLaunchScript = `dir=$1; shift
+rm -rf "$dir" && mkdir -p "$dir" && chmod 700 "$dir" && mkfifo "$dir/in" "$dir/out" "$dir/err" || exit 1
+(
+  "$@" <>"$dir/in" 1<>"$dir/out" 2<>"$dir/err" &
+  echo $! >"$dir/agent.pid"
+  wait $!
+  echo $? >"$dir/exit.tmp" && mv "$dir/exit.tmp" "$dir/exit"
+  sleep 1
+  [ -s "$dir/relay.pids" ] && kill $(cat "$dir/relay.pids") 2>/dev/null
+) </dev/null >/dev/null 2>&1 &
+i=0
+while [ ! -s "$dir/agent.pid" ] && [ $i -lt 50 ]; do sleep 0.1; i=$((i+1)); done
+[ -s "$dir/agent.pid" ]`
+
+// stdioRelayScript copies the relay's stdin into the agent's stdin FIFO and
+// the agent's stdout/stderr FIFOs to the relay's stdout/stderr. A previous
+// relay that is still alive inside the container is killed first so it cannot
+// steal output. The relay exits once the agent closes its stdout.
+const stdioRelayScript = `dir=$1
+[ -s "$dir/relay.pids" ] && kill $(cat "$dir/relay.pids") 2>/dev/null
+exec 3<&0
+cat "$dir/err" >&2 &
+e=$!
+cat <&3 >"$dir/in" &
+i=$!
+exec 3<&-
+cat "$dir/out" &
+o=$!
+echo "$$ $e $i $o" >"$dir/relay.pids"
+wait $o
+kill $e $i 2>/dev/null
+exit 0`
+
+// stdioProbeScript reports the agent state as "exited <status>", "running",
+// or "gone" (no exit status recorded and no live process).
+const stdioProbeScript = `dir=$1
+if [ -s "$dir/exit" ]; then echo "exited $(cat "$dir/exit")"
+elif [ -s "$dir/agent.pid" ] && kill -0 "$(cat "$dir/agent.pid")" 2>/dev/null; then echo running
+else echo gone
+fi`
+
+const stdioSignalScript = `[ -s "$1/agent.pid" ] && kill -"$2" "$(cat "$1/agent.pid")" 2>/dev/null; exit 0`
+
+// stdioCleanupScript waits briefly for the launcher to record the exit status
+// so it cannot write into the directory while it is being removed.
+const stdioCleanupScript = `i=0
+while [ ! -s "$1/exit" ] && [ $i -lt 20 ]; do sleep 0.1; i=$((i+1)); done
+rm -rf "$1"`
+
+// stdioCommandFunc builds a command that runs a shell script where the agent
+// lives. withEnv applies the agent's environment and working directory. The
+// returned cleanup must be called once the command has exited.
+type stdioCommandFunc func(ctx context.Context, withEnv bool, script string, args ...string) (*exec.Cmd, func(), error)
+
+// dockerStdioCommand runs scripts inside the devcontainer via docker exec as
+// the configured container user.
+func dockerStdioCommand(cfg ProcessConfig) stdioCommandFunc {
+	return func(ctx context.Context, withEnv bool, script string, args ...string) (*exec.Cmd, func(), error) {
+		execCfg := ProcessConfig{
+			ContainerID:   cfg.ContainerID,
+			ContainerUser: cfg.ContainerUser,
+			AcpCommand:    "sh",
+			AcpArgs:       append([]string{"-c", script, "sam-acp"}, args...),
+		}
+		if withEnv {
+			execCfg.EnvVars = cfg.EnvVars
+			execCfg.SecretEnvKeys = cfg.SecretEnvKeys
+			execCfg.WorkDir = cfg.WorkDir
+		}
+		dockerArgs, envFilePath, err := buildDockerExecArgs(execCfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		return containerruntime.Command(ctx, dockerArgs...), func() { removeEnvFile(envFilePath) }, nil
+	}
+}
+
+// startSupervisedDockerProcess spawns an agent inside the devcontainer behind a
+// stdio supervisor. The agent runs detached from the docker exec session, so
+// if the exec pipe breaks (daemon restart, cgroup pressure) the supervisor
+// re-attaches to the still-running process instead of losing it. Only when
+// re-attach is impossible does the process report as exited, which lets the
+// session host fall back to a full restart.
+func startSupervisedDockerProcess(cfg ProcessConfig, reattachTimeout time.Duration) (agentProcess, error) {
+	p, err := startSupervisedProcess(cfg, dockerStdioCommand(cfg), reattachTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// supervisedProcess is an agentProcess whose stdio survives the loss of the
+// docker exec session that carries it.
+type supervisedProcess struct {
+	agentType       string
+	containerID     string
+	dir             string
+	command         stdioCommandFunc
+	startTime       time.Time
+	reattachTimeout time.Duration
+	stopGracePeriod time.Duration
+	stopTimeout     time.Duration
+
+	stdin   *supervisedStdin
+	stdoutR *io.PipeReader
+	stdoutW *io.PipeWriter
+	stderrR *io.PipeReader
+	stderrW *io.PipeWriter
+
+	mu       sync.Mutex
+	relay    *AgentProcess
+	relayGen int
+	changed  chan struct{} // closed when relay changes or supervision ends
+	stopped  bool
+	finished bool
+
+	recoveryMu     sync.Mutex
+	recoveryNotify recoveryNotify
+
+	waitErr  error
+	waitDone chan struct{}
+}
+
+func startSupervisedProcess(cfg ProcessConfig, command stdioCommandFunc, reattachTimeout time.Duration) (*supervisedProcess, error) {
+	if reattachTimeout <= 0 {
+		reattachTimeout = DefaultStdioReattachTimeout
+	}
+	gracePeriod := cfg.StopGracePeriod
+	if gracePeriod <= 0 {
+		gracePeriod = DefaultStopGracePeriod
+	}
+	stopTimeout := cfg.StopTimeout
+	if stopTimeout <= 0 {
+		stopTimeout = DefaultStopTimeout
+	}
+
+	var idBytes [8]byte
+	if _, err := rand.Read(idBytes[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate stdio id: %w", err)
+	}
+	p := &supervisedProcess{
+		agentType:       cfg.AcpCommand,
+		containerID:     cfg.ContainerID,
+		dir:             stdioDirBase + "/" + hex.EncodeToString(idBytes[:]),
+		command:         command,
+		reattachTimeout: reattachTimeout,
+		stopGracePeriod: gracePeriod,
+		stopTimeout:     stopTimeout,
+		changed:         make(chan struct{}),
+		waitDone:        make(chan struct{}),
+	}
+	p.stdin = &supervisedStdin{p: p}
+	p.stdoutR, p.stdoutW = io.Pipe()
+	p.stderrR, p.stderrW = io.Pipe()
+
+	launchArgs := append([]string{p.dir, cfg.AcpCommand}, cfg.AcpArgs...)
+	if _, err := p.run(30*time.Second, true, stdioLaunchScript, launchArgs...); err != nil {
+		return nil, fmt.Errorf("failed to start agent process: %w", err)
+	}
+	relay, err := p.attach()
+	if err != nil {
+		p.signalAgent(syscall.SIGKILL)
+		p.cleanup()
+		return nil, fmt.Errorf("failed to attach to agent stdio: %w", err)
+	}
+
+	p.startTime = time.Now()
+	p.relay = relay
+	slog.Info("ACP agent process started under stdio supervisor", "command", cfg.AcpCommand, "container", cfg.ContainerID, "stdioDir", p.dir)
+	go p.supervise(relay)
+	return p, nil
+}
+
+func (p *supervisedProcess) run(timeout time.Duration, withEnv bool, script string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd, cleanup, err := p.command(ctx, withEnv, script, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return out, fmt.Errorf("%w: %s", err, truncate(msg, 200))
+		}
+		return out, err
+	}
+	return out, nil
+}
+
+// attach starts a relay for the agent's FIFOs.
+func (p *supervisedProcess) attach() (*AgentProcess, error) {
+	cmd, cleanup, err := p.command(context.Background(), false, stdioRelayScript, p.dir)
+	if err != nil {
+		return nil, err
+	}
+	cleanup()
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	pipes, err := openProcessPipes(cmd, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		closeProcessPipes(pipes)
+		return nil, err
+	}
+	// The relay is an AgentProcess without a container ID, so stopping it
+	// only tears down the host-side exec and never signals the agent.
+	return &AgentProcess{
+		agentType:       "stdio-relay",
+		cmd:             cmd,
+		stdin:           pipes.stdin,
+		stdout:          pipes.stdout,
+		stderr:          pipes.stderr,
+		startTime:       time.Now(),
+		stopGracePeriod: time.Second,
+		stopTimeout:     3 * time.Second,
+		waitDone:        make(chan struct{}),
+	}, nil
+}
+
+// supervise pumps the current relay and re-attaches whenever it breaks while
+// the agent is still running.
+func (p *supervisedProcess) supervise(relay *AgentProcess) {
+	for {
+		go p.pumpLines(relay.Stderr(), p.stderrW)
+		p.pumpLines(relay.Stdout(), p.stdoutW)
+		relayErr := relay.Wait()
+
+		if p.isStopped() {
+			p.finish(nil)
+			return
+		}
+		next, err := p.reattach(relayErr)
+		if err != nil || next == nil {
+			p.finish(err)
+			return
+		}
+		relay = next
+	}
+}
+
+// pumpLines copies complete lines from src to dst. A partial line left when
+// src breaks is dropped so a re-attached stream never splices two halves of
+// different NDJSON messages together.
+func (p *supervisedProcess) pumpLines(src io.Reader, dst *io.PipeWriter) {
+	reader := bufio.NewReader(src)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			if len(line) > 0 {
+				slog.Warn("Dropping partial agent output line after stdio relay ended", "agentType", p.agentType, "bytes", len(line))
+			}
+			return
+		}
+		if _, err := dst.Write(line); err != nil {
+			return
+		}
+	}
+}
+
+// reattach decides what a broken relay means: the agent exited (its status is
+// returned as the error), the agent is gone, or the agent is still running and
+// a new relay is attached. It retries until reattachTimeout.
+func (p *supervisedProcess) reattach(relayErr error) (*AgentProcess, error) {
+	deadline := time.Now().Add(p.reattachTimeout)
+	backoff := 250 * time.Millisecond
+	goneProbes := 0
+	lastErr := relayErr
+	for {
+		state, status, err := p.probe()
+		switch {
+		case err != nil:
+			lastErr = err
+			slog.Warn("Agent stdio probe failed", "agentType", p.agentType, "error", err)
+		case state == "exited":
+			if status == 0 {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("agent exited with status %d", status)
+		case state == "gone":
+			// The exit status is written just after the process exits;
+			// give it a moment before declaring the agent lost.
+			goneProbes++
+			if goneProbes >= 3 {
+				return nil, errors.New("agent process is gone and left no exit status")
+			}
+			time.Sleep(100 * time.Millisecond)
+			continue
+		default:
+			relay, err := p.attach()
+			if err == nil {
+				if !p.setRelay(relay) {
+					_ = relay.Stop()
+					return nil, nil
+				}
+				slog.Info("Re-attached to running agent after stdio relay broke", "agentType", p.agentType, "relayError", relayErr)
+				return relay, nil
+			}
+			lastErr = err
+			slog.Warn("Agent stdio re-attach failed", "agentType", p.agentType, "error", err)
+		}
+
+		if p.isStopped() {
+			return nil, nil
+		}
+		if time.Now().After(deadline) {
+			slog.Error("Agent stdio re-attach timed out, falling back to restart", "agentType", p.agentType, "timeout", p.reattachTimeout, "error", lastErr)
+			p.signalAgent(syscall.SIGKILL)
+			return nil, fmt.Errorf("agent stdio lost and re-attach failed within %s: %v", p.reattachTimeout, lastErr)
+		}
+		time.Sleep(backoff)
+		if backoff < 2*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+func (p *supervisedProcess) probe() (string, int, error) {
+	out, err := p.run(5*time.Second, false, stdioProbeScript, p.dir)
+	if err != nil {
+		return "", 0, err
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return "", 0, errors.New("empty probe output")
+	}
+	if fields[0] == "exited" && len(fields) == 2 {
+		status, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return "", 0, fmt.Errorf("invalid exit status %q", fields[1])
+		}
+		return "exited", status, nil
+	}
+	return fields[0], 0, nil
+}
+
+func (p *supervisedProcess) signalAgent(sig syscall.Signal) {
+	sigName := "TERM"
+	if sig == syscall.SIGKILL {
+		sigName = "KILL"
+	}
+	if _, err := p.run(5*time.Second, false, stdioSignalScript, p.dir, sigName); err != nil {
+		slog.Warn("Failed to signal supervised agent", "signal", sigName, "error", err)
+	}
+	killContainerProcesses(p.containerID, p.agentType, sig)
+}
+
+func (p *supervisedProcess) cleanup() {
+	if _, err := p.run(5*time.Second, false, stdioCleanupScript, p.dir); err != nil {
+		slog.Debug("Failed to remove agent stdio directory", "dir", p.dir, "error", err)
+	}
+}
+
+func (p *supervisedProcess) isStopped() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stopped
+}
+
+func (p *supervisedProcess) setRelay(relay *AgentProcess) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped || p.finished {
+		return false
+	}
+	p.relay = relay
+	p.relayGen++
+	close(p.changed)
+	p.changed = make(chan struct{})
+	return true
+}
+
+func (p *supervisedProcess) finish(err error) {
+	p.mu.Lock()
+	p.finished = true
+	close(p.changed)
+	p.changed = make(chan struct{})
+	p.mu.Unlock()
+
+	p.stdoutW.Close()
+	p.stderrW.Close()
+	p.cleanup()
+	p.waitErr = err
+	close(p.waitDone)
+}
+
+// currentRelay returns the active relay and its generation, or ok=false once
+// supervision has ended.
+func (p *supervisedProcess) currentRelay() (*AgentProcess, int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.finished || p.stopped {
+		return nil, 0, false
+	}
+	return p.relay, p.relayGen, true
+}
+
+// awaitRelayAfter blocks until a relay newer than gen is attached. It returns
+// false if supervision ends first.
+func (p *supervisedProcess) awaitRelayAfter(gen int) bool {
+	for {
+		p.mu.Lock()
+		if p.finished || p.stopped {
+			p.mu.Unlock()
+			return false
+		}
+		if p.relayGen != gen {
+			p.mu.Unlock()
+			return true
+		}
+		ch := p.changed
+		p.mu.Unlock()
+		<-ch
+	}
+}
+
+// supervisedStdin writes to the current relay. A write that fails before any
+// byte is accepted is retried on the next relay once it is attached.
+type supervisedStdin struct {
+	p  *supervisedProcess
+	mu sync.Mutex
+}
+
+func (w *supervisedStdin) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for {
+		relay, gen, ok := w.p.currentRelay()
+		if !ok {
+			return 0, io.ErrClosedPipe
+		}
+		n, err := relay.stdin.Write(b)
+		if err == nil || n > 0 {
+			return n, err
+		}
+		if !w.p.awaitRelayAfter(gen) {
+			return 0, err
+		}
+	}
+}
+
+func (p *supervisedProcess) Stdin() io.Writer {
+	return p.stdin
+}
+
+func (p *supervisedProcess) Stdout() io.Reader {
+	return p.stdoutR
+}
+
+func (p *supervisedProcess) Stderr() io.Reader {
+	return p.stderrR
+}
+
+// Stop terminates the agent inside the container (SIGTERM, then SIGKILL after
+// the grace period) and tears down the relay. Bounded by stopTimeout.
+func (p *supervisedProcess) Stop() error {
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		return nil
+	}
+	p.stopped = true
+	relay := p.relay
+	close(p.changed)
+	p.changed = make(chan struct{})
+	p.mu.Unlock()
+
+	slog.Info("Stopping supervised ACP agent process", "agentType", p.agentType, "container", p.containerID)
+	deadline := time.NewTimer(p.stopTimeout)
+	defer deadline.Stop()
+	graceTimer := time.NewTimer(p.stopGracePeriod)
+	defer graceTimer.Stop()
+
+	p.signalAgent(syscall.SIGTERM)
+	select {
+	case <-p.waitDone:
+		return nil
+	case <-graceTimer.C:
+	case <-deadline.C:
+	}
+
+	p.signalAgent(syscall.SIGKILL)
+	if relay != nil {
+		_ = relay.Stop()
+	}
+	select {
+	case <-p.waitDone:
+		return nil
+	case <-deadline.C:
+		return fmt.Errorf("supervised agent %s did not exit within %s", p.agentType, p.stopTimeout)
+	}
+}
+
+// Wait blocks until the agent has exited or re-attach became impossible.
+func (p *supervisedProcess) Wait() error {
+	<-p.waitDone
+	return p.waitErr
+}
+
+func (p *supervisedProcess) StartedAt() time.Time {
+	return p.startTime
+}
+
+func (p *supervisedProcess) KillContainerProcesses(sig syscall.Signal) {
+	p.signalAgent(sig)
+}
+
+func (p *supervisedProcess) SetRecoveryNotify(notify recoveryNotify) {
+	p.recoveryMu.Lock()
+	defer p.recoveryMu.Unlock()
+	p.recoveryNotify = notify
+}
+
+func (p *supervisedProcess) RecoveryNotify() recoveryNotify {
+	p.recoveryMu.Lock()
+	defer p.recoveryMu.Unlock()
+	return p.recoveryNotify
+}
@@ -0,0 +1,140 @@
+package acp
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// localStdioCommand runs supervisor scripts on the host, standing in for
+// docker exec.
+func localStdioCommand(cfg ProcessConfig) stdioCommandFunc {
+	return func(ctx context.Context, withEnv bool, script string, args ...string) (*exec.Cmd, func(), error) {
+		cmd := exec.CommandContext(ctx, "sh", append([]string{"-c", script, "sam-acp"}, args...)...)
+		if withEnv {
+			cmd.Env = append(os.Environ(), cfg.EnvVars...)
+			cmd.Dir = cfg.WorkDir
+		}
+		return cmd, func() {}, nil
+	}
+}
+
+// echoAgentScript echoes each stdin line, exits 3 on "quit", and reports its
+// environment on "env".
+const echoAgentScript = `while read -r line; do
+  case "$line" in
+    quit) exit 3 ;;
+    env) echo "env:$SAM_TEST_VALUE" ;;
+    *) echo "echo:$line" ;;
+  esac
+done`
+
+func startTestSupervisedProcess(t *testing.T) (*supervisedProcess, *bufio.Reader) {
+	t.Helper()
+	for _, bin := range []string{"sh", "mkfifo"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s not available", bin)
+		}
+	}
+	cfg := ProcessConfig{
+		AcpCommand:      "sh",
+		AcpArgs:         []string{"-c", echoAgentScript},
+		EnvVars:         []string{"SAM_TEST_VALUE=from-launch"},
+		StopGracePeriod: time.Second,
+		StopTimeout:     5 * time.Second,
+	}
+	p, err := startSupervisedProcess(cfg, localStdioCommand(cfg), 5*time.Second)
+	if err != nil {
+		t.Fatalf("startSupervisedProcess: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = p.Stop()
+		_ = os.RemoveAll(p.dir)
+	})
+	return p, bufio.NewReader(p.Stdout())
+}
+
+func exchangeLine(t *testing.T, p *supervisedProcess, out *bufio.Reader, send, want string) {
+	t.Helper()
+	if _, err := p.Stdin().Write([]byte(send + "\n")); err != nil {
+		t.Fatalf("write %q: %v", send, err)
+	}
+	lineCh := make(chan string, 1)
+	go func() {
+		line, _ := out.ReadString('\n')
+		lineCh <- strings.TrimSpace(line)
+	}()
+	select {
+	case got := <-lineCh:
+		if got != want {
+			t.Fatalf("read %q, want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %q", want)
+	}
+}
+
+func TestSupervisedProcessReattachesAfterRelayBreaks(t *testing.T) {
+	p, out := startTestSupervisedProcess(t)
+	exchangeLine(t, p, out, "env", "env:from-launch")
+	exchangeLine(t, p, out, "hello", "echo:hello")
+
+	// Simulate the docker exec pipe dying: kill the host-side relay process
+	// group while the agent keeps running.
+	relay, gen, ok := p.currentRelay()
+	if !ok {
+		t.Fatal("no relay attached")
+	}
+	if err := syscall.Kill(-relay.cmd.Process.Pid, syscall.SIGKILL); err != nil {
+		t.Fatalf("kill relay: %v", err)
+	}
+	if !p.awaitRelayAfter(gen) {
+		t.Fatal("supervisor ended instead of re-attaching")
+	}
+
+	// The same agent process answers on the new relay; state is preserved
+	// because env was only set at launch.
+	exchangeLine(t, p, out, "again", "echo:again")
+	exchangeLine(t, p, out, "env", "env:from-launch")
+
+	if err := p.Stop(); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if err := p.Wait(); err != nil {
+		t.Fatalf("Wait after Stop = %v, want nil", err)
+	}
+	if _, err := os.Stat(p.dir); !os.IsNotExist(err) {
+		t.Fatalf("stdio dir not cleaned up: %v", err)
+	}
+}
+
+func TestSupervisedProcessReportsAgentExit(t *testing.T) {
+	p, out := startTestSupervisedProcess(t)
+	exchangeLine(t, p, out, "hi", "echo:hi")
+
+	if _, err := p.Stdin().Write([]byte("quit\n")); err != nil {
+		t.Fatalf("write quit: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- p.Wait() }()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "status 3") {
+			t.Fatalf("Wait = %v, want exit status 3", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Wait did not return after agent exit")
+	}
+	if _, err := io.ReadAll(out); err != nil {
+		t.Fatalf("stdout should end with EOF after exit: %v", err)
+	}
+	if _, err := p.Stdin().Write([]byte("late\n")); err == nil {
+		t.Fatal("write after exit succeeded")
+	}
+}
@@ -0,0 +1,153 @@
+package acp
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	acpsdk "github.com/coder/acp-go-sdk"
+)
+
+// TranscriptMessage is one conversation turn rebuilt from the replay buffer.
+// Consecutive text chunks of the same role are merged, and a tool call's
+// updates are folded into the call.
+type TranscriptMessage struct {
+	Role       string    `json:"role"` // user, assistant, thinking, plan, or tool
+	Content    string    `json:"content"`
+	Origin     string    `json:"origin,omitempty"`
+	ToolCallID string    `json:"toolCallId,omitempty"`
+	ToolTitle  string    `json:"toolTitle,omitempty"`
+	ToolStatus string    `json:"toolStatus,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// BufferedMessages returns a copy of the replay buffer, oldest first.
+func (h *SessionHost) BufferedMessages() []BufferedMessage {
+	h.bufMu.RLock()
+	defer h.bufMu.RUnlock()
+	return append([]BufferedMessage(nil), h.messageBuf...)
+}
+
+// BuildTranscript rebuilds the conversation from buffered session/update
+// notifications. Control messages are ignored. The result only covers what
+// is still in the buffer: on long sessions the oldest turns may have been
+// evicted.
+func BuildTranscript(messages []BufferedMessage) []TranscriptMessage {
+	out := []TranscriptMessage{}
+	tools := make(map[string]int) // tool call ID → index in out
+	for _, buffered := range messages {
+		var rpc struct {
+			Method string                     `json:"method"`
+			Params acpsdk.SessionNotification `json:"params"`
+		}
+		if err := json.Unmarshal(buffered.Data, &rpc); err != nil || rpc.Method != sessionUpdateMethod {
+			continue
+		}
+		for _, m := range ExtractMessages(rpc.Params) {
+			if m.Role != "tool" {
+				if last := len(out) - 1; last >= 0 && out[last].Role == m.Role && out[last].Origin == m.Origin && m.Role != "plan" {
+					out[last].Content += m.Content
+					continue
+				}
+				out = append(out, TranscriptMessage{Role: m.Role, Content: m.Content, Origin: m.Origin, Timestamp: buffered.Timestamp})
+				continue
+			}
+
+			var meta ToolMeta
+			_ = json.Unmarshal([]byte(m.ToolMetadata), &meta)
+			content := m.Content
+			if content == "(tool call)" || content == "(tool update)" {
+				content = ""
+			}
+			if i, ok := tools[meta.ToolCallId]; ok && meta.ToolCallId != "" {
+				if meta.Title != "" {
+					out[i].ToolTitle = meta.Title
+				}
+				if meta.Status != "" {
+					out[i].ToolStatus = meta.Status
+				}
+				if content != "" {
+					out[i].Content = content
+				}
+				continue
+			}
+			tools[meta.ToolCallId] = len(out)
+			out = append(out, TranscriptMessage{
+				Role:       "tool",
+				Content:    content,
+				ToolCallID: meta.ToolCallId,
+				ToolTitle:  meta.Title,
+				ToolStatus: meta.Status,
+				Timestamp:  buffered.Timestamp,
+			})
+		}
+	}
+	return out
+}
+
+// LastStopReason returns the stop reason of the last finished prompt in the
+// buffered messages. ok is false when no prompt has finished; an empty
+// reason with ok true means the prompt ended in an error.
+func LastStopReason(messages []BufferedMessage) (reason string, ok bool) {
+	for i := len(messages) - 1; i >= 0; i-- {
+		var done SessionPromptDoneMessage
+		if err := json.Unmarshal(messages[i].Data, &done); err != nil || done.Type != MsgSessionPromptDone {
+			continue
+		}
+		return done.StopReason, true
+	}
+	return "", false
+}
+
+var transcriptHeadings = map[string]string{
+	"user":      "User",
+	"assistant": "Assistant",
+	"thinking":  "Thinking",
+	"plan":      "Plan",
+}
+
+// RenderTranscriptMarkdown formats a transcript as a Markdown document
+// headed by title. Tool output is fenced so it renders verbatim.
+func RenderTranscriptMarkdown(title string, messages []TranscriptMessage) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n", title)
+	for _, m := range messages {
+		if m.Role != "tool" {
+			heading := transcriptHeadings[m.Role]
+			if heading == "" {
+				heading = m.Role
+			}
+			fmt.Fprintf(&b, "\n## %s\n\n%s\n", heading, strings.TrimSpace(m.Content))
+			continue
+		}
+		heading := "Tool"
+		if m.ToolTitle != "" {
+			heading += ": " + m.ToolTitle
+		}
+		if m.ToolStatus != "" {
+			heading += " (" + m.ToolStatus + ")"
+		}
+		fmt.Fprintf(&b, "\n## %s\n", heading)
+		if content := strings.TrimSpace(m.Content); content != "" {
+			fence := markdownFence(content)
+			fmt.Fprintf(&b, "\n%s\n%s\n%s\n", fence, content, fence)
+		}
+	}
+	return []byte(b.String())
+}
+
+// markdownFence returns a backtick fence longer than any backtick run in
+// content, so the content cannot close it early.
+func markdownFence(content string) string {
+	longest, run := 0, 0
+	for _, r := range content {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return strings.Repeat("`", max(3, longest+1))
+}
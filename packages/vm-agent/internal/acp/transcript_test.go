@@ -0,0 +1,68 @@
+package acp
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func bufferedUpdate(t *testing.T, update string) BufferedMessage {
+	t.Helper()
+	data := `{"jsonrpc":"2.0","method":"session/update","params":{"sessionId":"s1","update":` + update + `}}`
+	if !json.Valid([]byte(data)) {
+		t.Fatalf("invalid test update %s", update)
+	}
+	return BufferedMessage{Data: []byte(data), Timestamp: time.Now()}
+}
+
+func TestBuildTranscriptMergesChunksAndToolUpdates(t *testing.T) {
+	done, _ := json.Marshal(SessionPromptDoneMessage{Type: MsgSessionPromptDone, StopReason: "end_turn"})
+	messages := []BufferedMessage{
+		bufferedUpdate(t, `{"sessionUpdate":"user_message_chunk","content":{"type":"text","text":"Run the tests"}}`),
+		bufferedUpdate(t, `{"sessionUpdate":"agent_message_chunk","content":{"type":"text","text":"Running "}}`),
+		bufferedUpdate(t, `{"sessionUpdate":"agent_message_chunk","content":{"type":"text","text":"them now."}}`),
+		bufferedUpdate(t, `{"sessionUpdate":"tool_call","toolCallId":"tc1","title":"npm test","kind":"execute","status":"pending"}`),
+		bufferedUpdate(t, `{"sessionUpdate":"tool_call_update","toolCallId":"tc1","status":"completed","content":[{"type":"content","content":{"type":"text","text":"42 passing"}}]}`),
+		bufferedUpdate(t, `{"sessionUpdate":"agent_message_chunk","content":{"type":"text","text":"All tests pass."}}`),
+		{Data: done},
+	}
+
+	got := BuildTranscript(messages)
+	want := []TranscriptMessage{
+		{Role: "user", Content: "Run the tests"},
+		{Role: "assistant", Content: "Running them now."},
+		{Role: "tool", Content: "42 passing", ToolCallID: "tc1", ToolTitle: "npm test", ToolStatus: "completed"},
+		{Role: "assistant", Content: "All tests pass."},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("transcript = %+v, want %d messages", got, len(want))
+	}
+	for i := range want {
+		got[i].Timestamp = time.Time{}
+		if got[i] != want[i] {
+			t.Errorf("message %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	if reason, ok := LastStopReason(messages); !ok || reason != "end_turn" {
+		t.Fatalf("LastStopReason = %q, %v", reason, ok)
+	}
+	if _, ok := LastStopReason(messages[:3]); ok {
+		t.Fatal("LastStopReason found a stop reason before the prompt finished")
+	}
+}
+
+func TestRenderTranscriptMarkdownFencesToolOutput(t *testing.T) {
+	got := string(RenderTranscriptMarkdown("Session", []TranscriptMessage{
+		{Role: "user", Content: "Show the README"},
+		{Role: "tool", Content: "```go\nfmt.Println()\n```", ToolTitle: "cat README.md", ToolStatus: "completed"},
+		{Role: "assistant", Content: "Done."},
+	}))
+	want := "# Session\n" +
+		"\n## User\n\nShow the README\n" +
+		"\n## Tool: cat README.md (completed)\n\n````\n```go\nfmt.Println()\n```\n````\n" +
+		"\n## Assistant\n\nDone.\n"
+	if got != want {
+		t.Fatalf("markdown =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -23,7 +23,11 @@ const (
 	// allowing UIs to disable input and show a "working" indicator.
 	MsgSessionPrompting ControlMessageType = "session_prompting"
 	// MsgSessionPromptDone is broadcast to all viewers when a prompt completes.
+	// See SessionPromptDoneMessage for the payload.
 	MsgSessionPromptDone ControlMessageType = "session_prompt_done"
+	// MsgSessionPromptQueue is broadcast whenever the queue of prompts waiting
+	// behind the running prompt changes. See SessionPromptQueueMessage.
+	MsgSessionPromptQueue ControlMessageType = "session_prompt_queue"
 	// MsgAgentCrashReport is broadcast when an agent crash is detected and
 	// SAM either recovered or failed to recover the ACP session.
 	MsgAgentCrashReport ControlMessageType = "agent_crash_report"
@@ -33,8 +37,121 @@ const (
 	MsgPing ControlMessageType = "ping"
 	// MsgPong is the server's response to MsgPing.
 	MsgPong ControlMessageType = "pong"
+	// MsgAnnouncement is broadcast to every viewer of every session in a
+	// workspace when an operator posts a workspace-level announcement, and
+	// replayed to viewers that attach while it is still active.
+	MsgAnnouncement ControlMessageType = "workspace_announcement"
+	// MsgAnnouncementWithdrawn is broadcast when an announcement is withdrawn
+	// before it expires so UIs can remove the banner.
+	MsgAnnouncementWithdrawn ControlMessageType = "workspace_announcement_withdrawn"
+	// MsgDismissAnnouncement is sent by the browser when the user dismisses
+	// an announcement; dismissed announcements are not replayed to that user.
+	MsgDismissAnnouncement ControlMessageType = "dismiss_announcement"
+	// MsgNoteChanged is broadcast to every viewer in a workspace when a
+	// workspace note is created, updated, or deleted.
+	MsgNoteChanged ControlMessageType = "workspace_note_changed"
+	// MsgFilesChanged is broadcast to every viewer in a workspace when files
+	// in its git working tree change. See FilesChangedMessage.
+	MsgFilesChanged ControlMessageType = "workspace/files_changed"
+	// MsgFileEdit is broadcast when the agent writes a file through
+	// fs/write_text_file, with a diff of the change. See FileEditMessage.
+	MsgFileEdit ControlMessageType = "file_edit"
+	// MsgControlPlaneStatus is broadcast to every viewer on the node when the
+	// control plane becomes unreachable or reachable again, and sent to
+	// viewers that attach while the node is operating offline.
+	MsgControlPlaneStatus ControlMessageType = "control_plane_status"
+	// MsgPermissionResponse is sent by the browser to answer a
+	// permission/request from the agent. See PermissionResponseMessage.
+	MsgPermissionResponse ControlMessageType = "permission_response"
+	// MsgPermissionResolved is broadcast when a permission request is answered,
+	// times out, or is cancelled, so every viewer can close its prompt.
+	MsgPermissionResolved ControlMessageType = "permission_resolved"
+	// MsgWorkspaceShuttingDown is broadcast to every viewer on the node when
+	// it starts draining before shutdown, and sent to viewers that attach
+	// while the drain is in progress. See WorkspaceShuttingDownMessage.
+	MsgWorkspaceShuttingDown ControlMessageType = "workspace_shutting_down"
+	// MsgWorkspaceMigrating is broadcast to a workspace's agent-session
+	// viewers just before their sessions move to a rebuilt devcontainer. See
+	// WorkspaceMigratingMessage.
+	MsgWorkspaceMigrating ControlMessageType = "workspace_migrating"
+	// MsgRefreshCredential is sent after the agent's credential is rotated in
+	// the control plane. The host fetches the new credential and restarts the
+	// agent with it, resuming the ACP session via LoadSession.
+	MsgRefreshCredential ControlMessageType = "refresh_credential"
+	// MsgAgentQuota is sent when an agent selection is queued or rejected
+	// because the workspace is at its agent quota. See AgentQuotaMessage.
+	MsgAgentQuota ControlMessageType = "agent_quota"
+	// MsgCompanionAgentStatus is an agent_status from a companion agent (one
+	// run alongside the primary agent for prompts with a "target"). It has
+	// the AgentStatusMessage fields plus "agent".
+	MsgCompanionAgentStatus ControlMessageType = "companion_agent_status"
+	// MsgViewerError is sent to a single viewer when one of its messages is
+	// rejected, e.g. a prompt from a read-only viewer. See ViewerErrorMessage.
+	MsgViewerError ControlMessageType = "viewer_error"
+	// MsgSessionAck tells a viewer the sequence number of the last buffered
+	// message it has received. See SessionAckMessage.
+	MsgSessionAck ControlMessageType = "session_ack"
+	// MsgSessionResync is sent to a viewer that fell behind and missed
+	// messages, just before they are replayed. See SessionResyncMessage.
+	MsgSessionResync ControlMessageType = "session_resync"
 )
 
+// AnnouncementSeverity classifies how prominently UIs should surface an
+// announcement.
+type AnnouncementSeverity string
+
+const (
+	AnnouncementInfo     AnnouncementSeverity = "info"
+	AnnouncementWarning  AnnouncementSeverity = "warning"
+	AnnouncementCritical AnnouncementSeverity = "critical"
+)
+
+// Valid reports whether s is a known announcement severity.
+func (s AnnouncementSeverity) Valid() bool {
+	switch s {
+	case AnnouncementInfo, AnnouncementWarning, AnnouncementCritical:
+		return true
+	default:
+		return false
+	}
+}
+
+// ViewerRole controls what an attached viewer may do. Read-only viewers
+// receive every broadcast but cannot prompt, cancel, select an agent, or
+// answer permission requests.
+type ViewerRole string
+
+const (
+	ViewerRoleReadWrite ViewerRole = "read-write"
+	ViewerRoleReadOnly  ViewerRole = "read-only"
+)
+
+// ParseViewerRole parses the role requested when a viewer attaches. An empty
+// string means ViewerRoleReadWrite.
+func ParseViewerRole(s string) (ViewerRole, bool) {
+	switch ViewerRole(s) {
+	case "", ViewerRoleReadWrite:
+		return ViewerRoleReadWrite, true
+	case ViewerRoleReadOnly:
+		return ViewerRoleReadOnly, true
+	default:
+		return "", false
+	}
+}
+
+// ViewerErrorMessage tells a viewer why its message was rejected. Rejected
+// is the control message type or JSON-RPC method that was refused.
+type ViewerErrorMessage struct {
+	Type     ControlMessageType `json:"type"`
+	Code     string             `json:"code"`
+	Message  string             `json:"message"`
+	Rejected string             `json:"rejected"`
+}
+
+// ViewerErrorReadOnly is the ViewerErrorMessage code for messages that a
+// read-only viewer is not allowed to send.
+const ViewerErrorReadOnly = "read_only_viewer"
+
 // AgentStatus represents the lifecycle state of an agent session.
 type AgentStatus string
 
@@ -62,6 +179,22 @@ type AgentStatusMessage struct {
 	Error     string             `json:"error,omitempty"`
 }
 
+// AgentQuotaMessage tells viewers that an agent selection is waiting for, or
+// was refused, workspace capacity. State is "queued" or "rejected"; Reason is
+// "max_agents" or "memory".
+type AgentQuotaMessage struct {
+	Type             ControlMessageType `json:"type"`
+	AgentType        string             `json:"agentType"`
+	State            string             `json:"state"`
+	Reason           string             `json:"reason"`
+	Message          string             `json:"message"`
+	RunningAgents    int                `json:"runningAgents"`
+	MaxAgents        int                `json:"maxAgents"`
+	MemoryUsedBytes  uint64             `json:"memoryUsedBytes,omitempty"`
+	MemoryLimitBytes uint64             `json:"memoryLimitBytes,omitempty"`
+	MemoryPercent    int                `json:"memoryPercent,omitempty"`
+}
+
 // AgentCrashReportMessage gives users enough context to continue safely and
 // report an agent-vendor crash with useful debugging evidence.
 type AgentCrashReportMessage struct {
@@ -77,6 +210,221 @@ type AgentCrashReportMessage struct {
 	RecoveryError   string             `json:"recoveryError,omitempty"`
 }
 
+// CancelInitiator identifies who or what stopped a prompt.
+type CancelInitiator string
+
+const (
+	// CancelByUser is a viewer or the control plane acting for the user.
+	CancelByUser CancelInitiator = "user"
+	// CancelByTimeout is the agent's prompt timeout.
+	CancelByTimeout CancelInitiator = "timeout"
+	// CancelByAdmin is an operator stopping the prompt on the user's behalf.
+	CancelByAdmin CancelInitiator = "admin"
+	// CancelByBudget is the control plane enforcing a usage budget.
+	CancelByBudget CancelInitiator = "budget"
+)
+
+// Valid reports whether i is a known cancellation initiator.
+func (i CancelInitiator) Valid() bool {
+	switch i {
+	case CancelByUser, CancelByTimeout, CancelByAdmin, CancelByBudget:
+		return true
+	default:
+		return false
+	}
+}
+
+// PromptCancellation describes why a prompt stopped before the agent
+// finished, so UIs can tell "Stopped by you" apart from an agent failure.
+type PromptCancellation struct {
+	Initiator CancelInitiator `json:"initiator"`
+	Reason    string          `json:"reason,omitempty"`
+	// PartialOutput is true when the agent streamed output before it stopped.
+	PartialOutput bool `json:"partialOutput"`
+	// ForceStopped is true when the agent did not stop in time and SAM
+	// killed the agent process.
+	ForceStopped bool      `json:"forceStopped,omitempty"`
+	CancelledAt  time.Time `json:"cancelledAt"`
+}
+
+// SessionPromptDoneMessage is broadcast when a prompt finishes. Cancellation
+// is set only when the prompt was cancelled, timed out, or force-stopped.
+type SessionPromptDoneMessage struct {
+	Type         ControlMessageType  `json:"type"`
+	StopReason   string              `json:"stopReason,omitempty"`
+	Cancellation *PromptCancellation `json:"cancellation,omitempty"`
+}
+
+// SessionPromptQueueMessage carries the prompts waiting to run, in order.
+// An empty Queued list means the queue has drained.
+type SessionPromptQueueMessage struct {
+	Type      ControlMessageType `json:"type"`
+	Queued    []QueuedPromptInfo `json:"queued"`
+	MaxQueued int                `json:"maxQueued"`
+}
+
+// QueuedPromptInfo identifies one waiting prompt. Position is 1-based.
+type QueuedPromptInfo struct {
+	Position  int    `json:"position"`
+	MessageID string `json:"messageId,omitempty"`
+	ViewerID  string `json:"viewerId,omitempty"`
+}
+
+// AnnouncementMessage carries a workspace-level announcement (maintenance
+// warning, budget notice, policy change) to viewers.
+type AnnouncementMessage struct {
+	Type      ControlMessageType   `json:"type"`
+	ID        string               `json:"id"`
+	Severity  AnnouncementSeverity `json:"severity"`
+	Title     string               `json:"title,omitempty"`
+	Message   string               `json:"message"`
+	CreatedAt time.Time            `json:"createdAt"`
+	ExpiresAt *time.Time           `json:"expiresAt,omitempty"`
+}
+
+// AnnouncementWithdrawnMessage tells viewers to remove an announcement.
+type AnnouncementWithdrawnMessage struct {
+	Type ControlMessageType `json:"type"`
+	ID   string             `json:"id"`
+}
+
+// DismissAnnouncementMessage is sent by the browser to dismiss an announcement.
+type DismissAnnouncementMessage struct {
+	Type           ControlMessageType `json:"type"`
+	AnnouncementID string             `json:"announcementId"`
+}
+
+// NoteChangedMessage tells viewers that a workspace note changed. Note holds
+// the note's current JSON representation and is omitted for deletions.
+type NoteChangedMessage struct {
+	Type   ControlMessageType `json:"type"`
+	Action string             `json:"action"` // "created", "updated", or "deleted"
+	NoteID string             `json:"noteId"`
+	Note   json.RawMessage    `json:"note,omitempty"`
+}
+
+// FileChangeClean is the FileChange status of a path that is no longer
+// modified.
+const FileChangeClean = "clean"
+
+// FileChange is one file in a workspace's git working tree that differs from
+// HEAD. Additions and Deletions are line counts against HEAD; they are zero
+// for untracked and binary files.
+type FileChange struct {
+	Path      string `json:"path"`
+	Status    string `json:"status"` // git status letter ("M", "A", "D", "R", "??") or "clean"
+	OldPath   string `json:"oldPath,omitempty"`
+	Additions int    `json:"additions"`
+	Deletions int    `json:"deletions"`
+	Binary    bool   `json:"binary,omitempty"`
+}
+
+// FilesChangedMessage lists the files whose state changed since the previous
+// message. Totals cover every modified file in the working tree.
+type FilesChangedMessage struct {
+	Type        ControlMessageType `json:"type"`
+	WorkspaceID string             `json:"workspaceId"`
+	Files       []FileChange       `json:"files"`
+	Totals      FileChangeTotals   `json:"totals"`
+}
+
+// FileChangeTotals summarizes a workspace's uncommitted changes.
+type FileChangeTotals struct {
+	Files     int `json:"files"`
+	Additions int `json:"additions"`
+	Deletions int `json:"deletions"`
+}
+
+// FileEditMessage describes one fs/write_text_file call by the agent. Hunks
+// diff the file's previous content against what was written, with three
+// lines of context; a new file is a single hunk of additions. Hunks are
+// omitted for binary content and when the diff exceeds its size limit, in
+// which case Truncated is set and only the counts are given.
+type FileEditMessage struct {
+	Type      ControlMessageType `json:"type"`
+	Path      string             `json:"path"`
+	Created   bool               `json:"created,omitempty"`
+	OldBytes  int                `json:"oldBytes"`
+	NewBytes  int                `json:"newBytes"`
+	Additions int                `json:"additions"`
+	Deletions int                `json:"deletions"`
+	Hunks     []DiffHunk         `json:"hunks"`
+	Binary    bool               `json:"binary,omitempty"`
+	Truncated bool               `json:"truncated,omitempty"`
+}
+
+// DiffHunk is one hunk of a unified diff. Start lines are 1-based, and each
+// line keeps its " ", "-" or "+" prefix.
+type DiffHunk struct {
+	OldStart int      `json:"oldStart"`
+	OldLines int      `json:"oldLines"`
+	NewStart int      `json:"newStart"`
+	NewLines int      `json:"newLines"`
+	Lines    []string `json:"lines"`
+}
+
+// ControlPlaneStatusMessage tells viewers whether the session is operating
+// offline. While offline, agents run on cached credentials and settings and
+// callbacks to the control plane are queued for later delivery.
+type ControlPlaneStatusMessage struct {
+	Type    ControlMessageType `json:"type"`
+	Offline bool               `json:"offline"`
+	Since   time.Time          `json:"since"`
+	Reason  string             `json:"reason,omitempty"`
+}
+
+// WorkspaceShuttingDownMessage warns viewers that the node will stop its
+// workloads at ShutdownAt. New sessions are refused from this point on; UIs
+// should show the countdown so users can save their work.
+type WorkspaceShuttingDownMessage struct {
+	Type             ControlMessageType `json:"type"`
+	Reason           string             `json:"reason,omitempty"`
+	ShutdownAt       time.Time          `json:"shutdownAt"`
+	CountdownSeconds int                `json:"countdownSeconds"`
+}
+
+// WorkspaceMigratingMessage tells viewers that the workspace's devcontainer
+// is being replaced. The agent session is suspended and resumed around the
+// move, so the conversation continues in the new container; viewers are
+// disconnected and should reconnect once the workspace is running again.
+type WorkspaceMigratingMessage struct {
+	Type   ControlMessageType `json:"type"`
+	Reason string             `json:"reason,omitempty"`
+}
+
+// PermissionResponseMessage is sent by the browser to answer the pending
+// permission request identified by RequestID, either by choosing one of the
+// offered options or by cancelling it.
+type PermissionResponseMessage struct {
+	Type      ControlMessageType `json:"type"`
+	RequestID string             `json:"requestId"`
+	OptionID  string             `json:"optionId,omitempty"`
+	Cancelled bool               `json:"cancelled,omitempty"`
+}
+
+// PermissionResolution says how a permission request was resolved.
+type PermissionResolution string
+
+const (
+	// PermissionResolvedByViewer means a viewer chose an option or cancelled.
+	PermissionResolvedByViewer PermissionResolution = "viewer"
+	// PermissionResolvedByTimeout means no viewer answered in time and the
+	// fallback outcome was used.
+	PermissionResolvedByTimeout PermissionResolution = "timeout"
+	// PermissionResolvedByCancel means the prompt or session ended first.
+	PermissionResolvedByCancel PermissionResolution = "cancelled"
+)
+
+// PermissionResolvedMessage tells viewers a permission request is no longer
+// pending. OptionID is empty when the request was cancelled.
+type PermissionResolvedMessage struct {
+	Type       ControlMessageType   `json:"type"`
+	RequestID  string               `json:"requestId"`
+	OptionID   string               `json:"optionId,omitempty"`
+	ResolvedBy PermissionResolution `json:"resolvedBy"`
+	ViewerID   string               `json:"viewerId,omitempty"`
+}
+
 // SessionStateMessage is sent to newly attached viewers with the current
 // session status and the number of buffered messages about to be replayed.
 type SessionStateMessage struct {
@@ -85,6 +433,28 @@ type SessionStateMessage struct {
 	AgentType   string             `json:"agentType,omitempty"`
 	Error       string             `json:"error,omitempty"`
 	ReplayCount int                `json:"replayCount"`
+	// ReplayAfterSeq is set when the viewer attached with a last_seq the
+	// host could resume from: the replay holds only messages after it, and
+	// the client keeps the messages it already has.
+	ReplayAfterSeq uint64 `json:"replayAfterSeq,omitempty"`
+}
+
+// SessionResyncMessage precedes a replay of ReplayCount messages to a viewer
+// whose send buffer overflowed. With ReplayAfterSeq set, the client keeps its
+// messages and appends the replay; otherwise it rebuilds the conversation
+// from the replay, as for a session_state with replayCount.
+type SessionResyncMessage struct {
+	Type           ControlMessageType `json:"type"`
+	ReplayCount    int                `json:"replayCount"`
+	ReplayAfterSeq uint64             `json:"replayAfterSeq,omitempty"`
+}
+
+// SessionAckMessage is sent to a viewer periodically once it has received
+// every buffered message up to and including Seq. A reconnecting client
+// passes the last Seq it saw as last_seq to receive only newer messages.
+type SessionAckMessage struct {
+	Type ControlMessageType `json:"type"`
+	Seq  uint64             `json:"seq"`
 }
 
 // WebSocketMessage is a raw message received from the WebSocket.
@@ -121,12 +491,28 @@ func ParseWebSocketMessage(data []byte) (isControl bool, controlType ControlMess
 		return true, MsgSessionPrompting
 	case MsgSessionPromptDone:
 		return true, MsgSessionPromptDone
+	case MsgSessionPromptQueue:
+		return true, MsgSessionPromptQueue
 	case MsgAgentCrashReport:
 		return true, MsgAgentCrashReport
 	case MsgPing:
 		return true, MsgPing
 	case MsgPong:
 		return true, MsgPong
+	case MsgAnnouncement:
+		return true, MsgAnnouncement
+	case MsgAnnouncementWithdrawn:
+		return true, MsgAnnouncementWithdrawn
+	case MsgDismissAnnouncement:
+		return true, MsgDismissAnnouncement
+	case MsgNoteChanged:
+		return true, MsgNoteChanged
+	case MsgControlPlaneStatus:
+		return true, MsgControlPlaneStatus
+	case MsgPermissionResponse:
+		return true, MsgPermissionResponse
+	case MsgPermissionResolved:
+		return true, MsgPermissionResolved
 	default:
 		// Not a control message — treat as ACP JSON-RPC
 		return false, ""
@@ -60,6 +60,48 @@ func TestParseWebSocketMessage_PingPong(t *testing.T) {
 			wantControl: true,
 			wantType:    MsgSessionPromptDone,
 		},
+		{
+			name:        "session_prompt_queue message",
+			input:       `{"type":"session_prompt_queue","queued":[{"position":1}],"maxQueued":3}`,
+			wantControl: true,
+			wantType:    MsgSessionPromptQueue,
+		},
+		{
+			name:        "workspace_announcement message",
+			input:       `{"type":"workspace_announcement","id":"ann-1","severity":"warning","message":"Maintenance at 02:00"}`,
+			wantControl: true,
+			wantType:    MsgAnnouncement,
+		},
+		{
+			name:        "dismiss_announcement message",
+			input:       `{"type":"dismiss_announcement","announcementId":"ann-1"}`,
+			wantControl: true,
+			wantType:    MsgDismissAnnouncement,
+		},
+		{
+			name:        "workspace_note_changed message",
+			input:       `{"type":"workspace_note_changed","action":"deleted","noteId":"note-1"}`,
+			wantControl: true,
+			wantType:    MsgNoteChanged,
+		},
+		{
+			name:        "control_plane_status message",
+			input:       `{"type":"control_plane_status","offline":true,"since":"2026-01-01T00:00:00Z"}`,
+			wantControl: true,
+			wantType:    MsgControlPlaneStatus,
+		},
+		{
+			name:        "permission_response message",
+			input:       `{"type":"permission_response","requestId":"perm-1","optionId":"allow"}`,
+			wantControl: true,
+			wantType:    MsgPermissionResponse,
+		},
+		{
+			name:        "permission_resolved message",
+			input:       `{"type":"permission_resolved","requestId":"perm-1","resolvedBy":"timeout"}`,
+			wantControl: true,
+			wantType:    MsgPermissionResolved,
+		},
 		{
 			name:        "ACP JSON-RPC message",
 			input:       `{"jsonrpc":"2.0","method":"session/prompt","id":1}`,
@@ -0,0 +1,172 @@
+package acp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Poll queue close reasons reported to the viewer in PollBatch.Reason.
+const (
+	PollClosedOverflow = "overflow"
+	PollClosedDetached = "detached"
+)
+
+var (
+	// ErrPollQueueClosed is returned when writing to a closed PollQueue.
+	ErrPollQueueClosed = errors.New("poll queue closed")
+	// ErrPollQueueOverflow is returned when a viewer stopped polling long
+	// enough for its queue to fill. The viewer must re-attach and replay.
+	ErrPollQueueOverflow = errors.New("poll queue overflow")
+)
+
+// PollMessage is a single viewer message with its per-viewer sequence number.
+type PollMessage struct {
+	Seq  uint64          `json:"seq"`
+	Data json.RawMessage `json:"data"`
+}
+
+// PollBatch is the result of one PollQueue.Poll call.
+type PollBatch struct {
+	Messages []PollMessage `json:"messages"`
+	// Cursor is the sequence number to pass as "after" on the next poll.
+	Cursor uint64 `json:"cursor"`
+	// Closed is set once the queue is closed and fully drained by this batch;
+	// the viewer must re-attach to continue.
+	Closed bool   `json:"closed"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// PollQueue is a ViewerSink for HTTP long-poll and SSE viewers on networks
+// that block WebSockets. Messages written by the SessionHost write pump are
+// numbered and held until the viewer acknowledges them by polling with a
+// later cursor, so a poll lost in transit is re-delivered on the next one.
+type PollQueue struct {
+	maxPending int
+
+	mu      sync.Mutex
+	lastSeq uint64
+	pending []PollMessage
+	// wake is closed and replaced whenever messages arrive or the queue closes.
+	wake   chan struct{}
+	closed bool
+	reason string
+}
+
+// NewPollQueue creates a PollQueue that holds at most maxPending
+// unacknowledged messages before it closes with PollClosedOverflow.
+func NewPollQueue(maxPending int) *PollQueue {
+	if maxPending <= 0 {
+		maxPending = 1
+	}
+	return &PollQueue{
+		maxPending: maxPending,
+		wake:       make(chan struct{}),
+	}
+}
+
+// WriteMessage implements ViewerSink.
+func (q *PollQueue) WriteMessage(data []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrPollQueueClosed
+	}
+	if len(q.pending) >= q.maxPending {
+		q.closeLocked(PollClosedOverflow)
+		return ErrPollQueueOverflow
+	}
+	q.lastSeq++
+	q.pending = append(q.pending, PollMessage{
+		Seq:  q.lastSeq,
+		Data: append(json.RawMessage(nil), data...),
+	})
+	q.wakeLocked()
+	return nil
+}
+
+// CloseWithReason implements ViewerSink. Pending messages stay pollable.
+func (q *PollQueue) CloseWithReason(reason string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closeLocked(reason)
+}
+
+// Close implements ViewerSink.
+func (q *PollQueue) Close() error {
+	q.CloseWithReason(PollClosedDetached)
+	return nil
+}
+
+func (q *PollQueue) closeLocked(reason string) {
+	if q.closed {
+		return
+	}
+	q.closed = true
+	q.reason = reason
+	q.wakeLocked()
+}
+
+func (q *PollQueue) wakeLocked() {
+	close(q.wake)
+	q.wake = make(chan struct{})
+}
+
+// Poll acknowledges every message up to and including after, then returns up
+// to limit newer messages. It waits up to wait for messages to arrive; an
+// empty batch means the wait elapsed or ctx was cancelled.
+func (q *PollQueue) Poll(ctx context.Context, after uint64, wait time.Duration, limit int) PollBatch {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		q.mu.Lock()
+		drop := 0
+		for drop < len(q.pending) && q.pending[drop].Seq <= after {
+			drop++
+		}
+		if drop > 0 {
+			q.pending = append(q.pending[:0], q.pending[drop:]...)
+		}
+
+		if len(q.pending) > 0 || q.closed {
+			n := len(q.pending)
+			if limit > 0 && n > limit {
+				n = limit
+			}
+			batch := PollBatch{
+				Messages: make([]PollMessage, n),
+				Cursor:   after,
+			}
+			copy(batch.Messages, q.pending[:n])
+			if n > 0 {
+				batch.Cursor = batch.Messages[n-1].Seq
+			}
+			if q.closed && n == len(q.pending) {
+				batch.Closed = true
+				batch.Reason = q.reason
+			}
+			q.mu.Unlock()
+			return batch
+		}
+		wake := q.wake
+		q.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-timer.C:
+			return PollBatch{Messages: []PollMessage{}, Cursor: after}
+		case <-ctx.Done():
+			return PollBatch{Messages: []PollMessage{}, Cursor: after}
+		}
+	}
+}
+
+// Closed reports whether the queue is closed.
+func (q *PollQueue) Closed() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.closed
+}
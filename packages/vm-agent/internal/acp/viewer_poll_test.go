@@ -0,0 +1,144 @@
+package acp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPollQueue_AcknowledgesByCursor(t *testing.T) {
+	t.Parallel()
+
+	q := NewPollQueue(10)
+	for _, msg := range []string{`{"n":1}`, `{"n":2}`, `{"n":3}`} {
+		if err := q.WriteMessage([]byte(msg)); err != nil {
+			t.Fatalf("WriteMessage: %v", err)
+		}
+	}
+
+	batch := q.Poll(context.Background(), 0, time.Second, 2)
+	if len(batch.Messages) != 2 || batch.Cursor != 2 || batch.Closed {
+		t.Fatalf("first batch = %+v, want 2 messages, cursor 2", batch)
+	}
+
+	// Re-polling with the old cursor (response lost in transit) redelivers.
+	again := q.Poll(context.Background(), 0, time.Second, 0)
+	if len(again.Messages) != 3 || again.Messages[0].Seq != 1 {
+		t.Fatalf("redelivery = %+v, want all 3 messages from seq 1", again)
+	}
+
+	rest := q.Poll(context.Background(), batch.Cursor, time.Second, 0)
+	if len(rest.Messages) != 1 || string(rest.Messages[0].Data) != `{"n":3}` || rest.Cursor != 3 {
+		t.Fatalf("rest = %+v, want message 3", rest)
+	}
+}
+
+func TestPollQueue_WaitsForMessages(t *testing.T) {
+	t.Parallel()
+
+	q := NewPollQueue(10)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = q.WriteMessage([]byte(`{"late":true}`))
+	}()
+
+	batch := q.Poll(context.Background(), 0, 5*time.Second, 0)
+	if len(batch.Messages) != 1 {
+		t.Fatalf("batch = %+v, want the late message", batch)
+	}
+
+	empty := q.Poll(context.Background(), batch.Cursor, 20*time.Millisecond, 0)
+	if len(empty.Messages) != 0 || empty.Cursor != batch.Cursor || empty.Closed {
+		t.Fatalf("timed-out poll = %+v, want empty batch at cursor %d", empty, batch.Cursor)
+	}
+}
+
+func TestPollQueue_CloseDrainsPendingFirst(t *testing.T) {
+	t.Parallel()
+
+	q := NewPollQueue(10)
+	_ = q.WriteMessage([]byte(`{"n":1}`))
+	_ = q.WriteMessage([]byte(`{"n":2}`))
+	q.CloseWithReason("session stopped")
+
+	if err := q.WriteMessage([]byte(`{"n":3}`)); !errors.Is(err, ErrPollQueueClosed) {
+		t.Fatalf("write after close = %v, want ErrPollQueueClosed", err)
+	}
+
+	partial := q.Poll(context.Background(), 0, time.Second, 1)
+	if partial.Closed {
+		t.Fatalf("closed reported before pending messages drained: %+v", partial)
+	}
+	final := q.Poll(context.Background(), partial.Cursor, time.Second, 0)
+	if !final.Closed || final.Reason != "session stopped" || len(final.Messages) != 1 {
+		t.Fatalf("final batch = %+v, want last message and closed", final)
+	}
+}
+
+func TestPollQueue_OverflowCloses(t *testing.T) {
+	t.Parallel()
+
+	q := NewPollQueue(2)
+	_ = q.WriteMessage([]byte(`{}`))
+	_ = q.WriteMessage([]byte(`{}`))
+	if err := q.WriteMessage([]byte(`{}`)); !errors.Is(err, ErrPollQueueOverflow) {
+		t.Fatalf("third write = %v, want ErrPollQueueOverflow", err)
+	}
+	batch := q.Poll(context.Background(), 2, time.Second, 0)
+	if !batch.Closed || batch.Reason != PollClosedOverflow {
+		t.Fatalf("batch = %+v, want closed with overflow", batch)
+	}
+}
+
+func TestSessionHost_AttachViewerSinkReplaysToPollQueue(t *testing.T) {
+	t.Parallel()
+
+	host := newTestSessionHost(t)
+	host.appendMessage([]byte(`{"jsonrpc":"2.0","method":"session/update"}`))
+
+	q := NewPollQueue(100)
+	viewer := host.AttachViewerSink("poll-1", q)
+	if viewer == nil {
+		t.Fatal("AttachViewerSink returned nil")
+	}
+
+	var types []string
+	var cursor uint64
+	deadline := time.Now().Add(5 * time.Second)
+	for len(types) < 4 && time.Now().Before(deadline) {
+		batch := q.Poll(context.Background(), cursor, time.Second, 0)
+		cursor = batch.Cursor
+		for _, msg := range batch.Messages {
+			var envelope struct {
+				Type   string `json:"type"`
+				Method string `json:"method"`
+			}
+			if err := json.Unmarshal(msg.Data, &envelope); err != nil {
+				t.Fatalf("message %d is not JSON: %v", msg.Seq, err)
+			}
+			types = append(types, envelope.Type+envelope.Method)
+		}
+	}
+	want := []string{string(MsgSessionState), "session/update", string(MsgSessionReplayDone), string(MsgSessionState)}
+	if len(types) != len(want) {
+		t.Fatalf("received %v, want %v", types, want)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatalf("received %v, want %v", types, want)
+		}
+	}
+
+	host.Stop()
+	batch := q.Poll(context.Background(), cursor, time.Second, 0)
+	if !batch.Closed || batch.Reason != "session stopped" {
+		t.Fatalf("after Stop batch = %+v, want closed with session stopped", batch)
+	}
+	select {
+	case <-viewer.Done():
+	case <-time.After(time.Second):
+		t.Fatal("viewer not done after Stop")
+	}
+}
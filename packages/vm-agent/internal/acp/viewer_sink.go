@@ -0,0 +1,46 @@
+package acp
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// viewerWriteTimeout bounds a single WebSocket frame write to a viewer.
+const viewerWriteTimeout = 10 * time.Second
+
+// ViewerSink delivers SessionHost output to a single viewer. The SessionHost
+// fan-out (replay, priority eviction, per-viewer send buffers) is identical
+// for every transport; only the final write differs.
+type ViewerSink interface {
+	// WriteMessage delivers one JSON message. An error detaches the viewer.
+	WriteMessage(data []byte) error
+	// CloseWithReason tells the viewer the session went away, then releases
+	// the transport.
+	CloseWithReason(reason string)
+	// Close releases the transport without notifying the viewer.
+	Close() error
+}
+
+// websocketSink writes viewer messages as WebSocket text frames.
+type websocketSink struct {
+	conn *websocket.Conn
+}
+
+func (s websocketSink) WriteMessage(data []byte) error {
+	s.conn.SetWriteDeadline(time.Now().Add(viewerWriteTimeout))
+	return s.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func (s websocketSink) CloseWithReason(reason string) {
+	_ = s.conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseGoingAway, reason),
+		time.Now().Add(5*time.Second),
+	)
+	_ = s.conn.Close()
+}
+
+func (s websocketSink) Close() error {
+	return s.conn.Close()
+}
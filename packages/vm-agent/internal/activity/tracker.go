@@ -0,0 +1,126 @@
+// Package activity aggregates workspace activity from several sources —
+// terminals, ACP prompts, attached viewers, file API calls, and commands the
+// agent runs — so idle detection does not mistake an agent working with no
+// browser attached for an abandoned workspace.
+package activity
+
+import (
+	"sync"
+	"time"
+)
+
+// Source identifies a kind of workspace activity.
+type Source string
+
+const (
+	SourceTerminal Source = "terminal"   // PTY input or output
+	SourcePrompt   Source = "acp_prompt" // an ACP prompt in flight
+	SourceViewer   Source = "viewer"     // a viewer attached to an agent session
+	SourceFiles    Source = "file_api"   // a successful file API request
+	SourceCommand  Source = "command"    // an agent-started terminal command still running
+)
+
+// Weights scales how long activity from each source keeps a workspace from
+// counting as idle. With weight w, activity t ago contributes an idle time
+// of t/w: 1 counts it as-is, 2 makes it last twice as long, 0.5 half as
+// long. Sources with weight 0, or missing from the map, are ignored.
+type Weights map[Source]float64
+
+// Report is the aggregated activity of one workspace.
+type Report struct {
+	// LastActivityAt is the weighted last activity: now minus IdleFor.
+	LastActivityAt time.Time
+	IdleFor        time.Duration
+	// Sources holds the raw last activity time per source.
+	Sources map[Source]time.Time
+}
+
+// Tracker records the last activity per workspace and source. The zero value
+// is not usable; a nil *Tracker ignores all calls.
+type Tracker struct {
+	weights Weights
+	now     func() time.Time
+
+	mu   sync.Mutex
+	last map[string]map[Source]time.Time // workspaceID → source → last activity
+}
+
+// NewTracker returns a Tracker applying the given per-source weights.
+func NewTracker(weights Weights) *Tracker {
+	return &Tracker{
+		weights: weights,
+		now:     time.Now,
+		last:    make(map[string]map[Source]time.Time),
+	}
+}
+
+// Touch records activity from source happening now.
+func (t *Tracker) Touch(workspaceID string, source Source) {
+	if t == nil {
+		return
+	}
+	t.Observe(workspaceID, source, t.now())
+}
+
+// Observe records activity from source at the given time. Earlier times than
+// the one already recorded are ignored, so sources that report their own
+// last-activity time can be observed repeatedly.
+func (t *Tracker) Observe(workspaceID string, source Source, at time.Time) {
+	if t == nil || workspaceID == "" || at.IsZero() {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sources, ok := t.last[workspaceID]
+	if !ok {
+		sources = make(map[Source]time.Time)
+		t.last[workspaceID] = sources
+	}
+	if at.After(sources[source]) {
+		sources[source] = at
+	}
+}
+
+// Forget drops all activity recorded for a workspace.
+func (t *Tracker) Forget(workspaceID string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.last, workspaceID)
+}
+
+// Report returns the aggregated activity of a workspace. ok is false when no
+// weighted source has recorded any activity for it.
+func (t *Tracker) Report(workspaceID string) (report Report, ok bool) {
+	if t == nil {
+		return Report{}, false
+	}
+	now := t.now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sources := t.last[workspaceID]
+	report.Sources = make(map[Source]time.Time, len(sources))
+	for source, at := range sources {
+		report.Sources[source] = at
+		weight := t.weights[source]
+		if weight <= 0 {
+			continue
+		}
+		elapsed := now.Sub(at)
+		if elapsed < 0 {
+			elapsed = 0
+		}
+		idle := time.Duration(float64(elapsed) / weight)
+		if !ok || idle < report.IdleFor {
+			report.IdleFor = idle
+			ok = true
+		}
+	}
+	if ok {
+		report.LastActivityAt = now.Add(-report.IdleFor)
+	}
+	return report, ok
+}
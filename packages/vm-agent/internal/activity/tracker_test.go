@@ -0,0 +1,82 @@
+package activity
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestTracker(weights Weights, now time.Time) (*Tracker, *time.Time) {
+	tr := NewTracker(weights)
+	clock := now
+	tr.now = func() time.Time { return clock }
+	return tr, &clock
+}
+
+func TestReportUsesWeightedMostRecentSource(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tr, clock := newTestTracker(Weights{SourceTerminal: 1, SourcePrompt: 2, SourceViewer: 0.5}, start)
+
+	if _, ok := tr.Report("ws-1"); ok {
+		t.Fatal("expected no report before any activity")
+	}
+
+	tr.Observe("ws-1", SourceTerminal, start.Add(-40*time.Minute))
+	tr.Observe("ws-1", SourcePrompt, start.Add(-60*time.Minute))   // weighted: 30m
+	tr.Observe("ws-1", SourceViewer, start.Add(-20*time.Minute))   // weighted: 40m
+	tr.Observe("ws-1", SourceTerminal, start.Add(-90*time.Minute)) // older, ignored
+
+	report, ok := tr.Report("ws-1")
+	if !ok {
+		t.Fatal("expected a report")
+	}
+	if report.IdleFor != 30*time.Minute {
+		t.Fatalf("IdleFor = %s, want 30m", report.IdleFor)
+	}
+	if !report.LastActivityAt.Equal(start.Add(-30 * time.Minute)) {
+		t.Fatalf("LastActivityAt = %s", report.LastActivityAt)
+	}
+	if got := report.Sources[SourceTerminal]; !got.Equal(start.Add(-40 * time.Minute)) {
+		t.Fatalf("terminal source = %s, want the most recent observation", got)
+	}
+
+	*clock = start.Add(10 * time.Minute)
+	tr.Touch("ws-1", SourceViewer)
+	if report, _ := tr.Report("ws-1"); report.IdleFor != 0 {
+		t.Fatalf("IdleFor after touch = %s, want 0", report.IdleFor)
+	}
+
+	tr.Forget("ws-1")
+	if _, ok := tr.Report("ws-1"); ok {
+		t.Fatal("expected no report after Forget")
+	}
+}
+
+func TestReportIgnoresUnweightedSources(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tr, _ := newTestTracker(Weights{SourceTerminal: 1, SourceFiles: 0}, now)
+	tr.Touch("ws-1", SourceFiles)
+	tr.Touch("ws-1", SourceCommand) // no weight configured
+
+	report, ok := tr.Report("ws-1")
+	if ok {
+		t.Fatalf("expected unweighted activity to be ignored, got %+v", report)
+	}
+	if len(report.Sources) != 2 {
+		t.Fatalf("Sources = %v, want raw times for both sources", report.Sources)
+	}
+}
+
+func TestNilTrackerIsNoop(t *testing.T) {
+	t.Parallel()
+
+	var tr *Tracker
+	tr.Touch("ws-1", SourceTerminal)
+	tr.Forget("ws-1")
+	if _, ok := tr.Report("ws-1"); ok {
+		t.Fatal("nil tracker reported activity")
+	}
+}
@@ -24,6 +24,7 @@ type Session struct {
 	AgentType    string     `json:"agentType,omitempty"`
 	AcpSessionID string     `json:"acpSessionId,omitempty"`
 	LastPrompt   string     `json:"lastPrompt,omitempty"`
+	WorkDir      string     `json:"workDir,omitempty"`
 	CreatedAt    time.Time  `json:"createdAt"`
 	UpdatedAt    time.Time  `json:"updatedAt"`
 	StoppedAt    *time.Time `json:"stoppedAt,omitempty"`
@@ -192,6 +193,28 @@ func (m *Manager) UpdateLastPrompt(workspaceID, sessionID, lastPrompt string) er
 	return nil
 }
 
+// UpdateWorkDir records the container working directory (worktree) the
+// session's agent runs in.
+func (m *Manager) UpdateWorkDir(workspaceID, sessionID, workDir string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	workspaceMap, ok := m.workspaceSessions[workspaceID]
+	if !ok {
+		return fmt.Errorf("workspace not found: %s", workspaceID)
+	}
+
+	session, ok := workspaceMap[sessionID]
+	if !ok {
+		return fmt.Errorf("session not found: %s", sessionID)
+	}
+
+	session.WorkDir = workDir
+	session.UpdatedAt = time.Now().UTC()
+	workspaceMap[sessionID] = session
+	return nil
+}
+
 func (m *Manager) List(workspaceID string) []Session {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -358,3 +358,19 @@ func TestSuspendResumeRoundTrip(t *testing.T) {
 		t.Errorf("LastPrompt lost during round-trip, got %q", s.LastPrompt)
 	}
 }
+
+func TestUpdateWorkDir(t *testing.T) {
+	m := NewManager()
+	m.Create("ws1", "s1", "Chat 1", "")
+
+	if err := m.UpdateWorkDir("ws1", "s1", "/workspaces/repo-wt-feature"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	session, _ := m.Get("ws1", "s1")
+	if session.WorkDir != "/workspaces/repo-wt-feature" {
+		t.Errorf("expected WorkDir to be updated, got %q", session.WorkDir)
+	}
+	if err := m.UpdateWorkDir("ws1", "missing", "/workspaces/repo"); err == nil {
+		t.Fatal("expected error for non-existent session")
+	}
+}
@@ -0,0 +1,256 @@
+package bootlog
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FinalStep is the boot step whose completion means provisioning finished.
+// Its completed entry reports 100%.
+const FinalStep = "workspace_ready"
+
+// DefaultStepWeights are the expected durations of boot steps that have no
+// recorded history yet. Steps missing from the table weigh
+// defaultStepWeight. Once a step has run, its rolling average replaces the
+// weight.
+var DefaultStepWeights = map[string]time.Duration{
+	"bootstrap_redeem": 1 * time.Second,
+	"volume_create":    2 * time.Second,
+	"git_clone":        15 * time.Second,
+	"volume_populate":  5 * time.Second,
+	"devcontainer_up":  90 * time.Second,
+	"gh_cli":           5 * time.Second,
+	"git_creds":        1 * time.Second,
+	"git_lfs":          10 * time.Second,
+	"git_identity":     1 * time.Second,
+	"sam_env":          2 * time.Second,
+	FinalStep:          1 * time.Second,
+}
+
+const defaultStepWeight = time.Second
+
+// defaultBootPlan is the expected step list of a node's first boot, before
+// any boot has been recorded.
+var defaultBootPlan = []string{
+	"bootstrap_redeem", "volume_create", "git_clone", "devcontainer_up",
+	"gh_cli", "git_creds", "git_identity", "sam_env", FinalStep,
+}
+
+// historySmoothing is the weight of the newest sample in a step's rolling
+// average.
+const historySmoothing = 0.3
+
+// Progress is how far provisioning has come, reported with each entry.
+type Progress struct {
+	Percent    int // 0-100
+	ETASeconds int // estimated seconds until FinalStep completes
+}
+
+// historyMu serializes history file updates from the reporters of
+// workspaces provisioned at the same time.
+var historyMu sync.Mutex
+
+// progressHistory is the persisted form of step durations.
+type progressHistory struct {
+	// Steps holds each step's rolling average duration.
+	Steps map[string]stepHistory `json:"steps"`
+	// LastBoot lists the steps of the last completed boot, in order. It is
+	// the plan the next boot's percentage is measured against.
+	LastBoot []string `json:"lastBoot,omitempty"`
+}
+
+type stepHistory struct {
+	AverageMs float64 `json:"averageMs"`
+	Samples   int     `json:"samples"`
+}
+
+// progressTracker turns the started/completed entries of one boot into a
+// progress percentage and ETA. The expected duration of each step is its
+// rolling average from earlier boots, so estimates improve as a node
+// provisions more workspaces. Durations are persisted only when a boot
+// completes, so failed boots don't skew the averages.
+type progressTracker struct {
+	path string
+	now  func() time.Time
+
+	mu      sync.Mutex
+	history progressHistory
+	plan    []string // expected steps of this boot, in order
+	started map[string]time.Time
+	done    map[string]bool
+}
+
+func newProgressTracker(path string, now func() time.Time) *progressTracker {
+	t := &progressTracker{
+		path:    path,
+		now:     now,
+		history: loadProgressHistory(path),
+		started: map[string]time.Time{},
+		done:    map[string]bool{},
+	}
+	t.plan = append(t.plan, t.history.LastBoot...)
+	if len(t.plan) == 0 {
+		t.plan = append(t.plan, defaultBootPlan...)
+	}
+	return t
+}
+
+func loadProgressHistory(path string) progressHistory {
+	history := progressHistory{Steps: map[string]stepHistory{}}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("bootlog: failed to read progress history", "path", path, "error", err)
+		}
+		return history
+	}
+	if err := json.Unmarshal(data, &history); err != nil {
+		slog.Warn("bootlog: ignoring invalid progress history", "path", path, "error", err)
+		return progressHistory{Steps: map[string]stepHistory{}}
+	}
+	if history.Steps == nil {
+		history.Steps = map[string]stepHistory{}
+	}
+	return history
+}
+
+// observe records an entry and returns the progress after it.
+func (t *progressTracker) observe(step, status string) Progress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	if !t.inPlan(step) {
+		// Insert unplanned steps before FinalStep so it stays last.
+		if n := len(t.plan); n > 0 && t.plan[n-1] == FinalStep {
+			t.plan = append(t.plan[:n-1], step, FinalStep)
+		} else {
+			t.plan = append(t.plan, step)
+		}
+	}
+
+	switch status {
+	case "started":
+		t.started[step] = now
+		delete(t.done, step)
+	case "completed":
+		if start, ok := t.started[step]; ok {
+			t.recordDuration(step, now.Sub(start))
+		}
+		t.done[step] = true
+	default:
+		t.done[step] = true
+	}
+
+	if step == FinalStep && status == "completed" {
+		t.saveBoot()
+		return Progress{Percent: 100}
+	}
+	return t.progressLocked(now)
+}
+
+func (t *progressTracker) inPlan(step string) bool {
+	for _, s := range t.plan {
+		if s == step {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *progressTracker) expected(step string) time.Duration {
+	if h, ok := t.history.Steps[step]; ok && h.Samples > 0 {
+		return time.Duration(h.AverageMs * float64(time.Millisecond))
+	}
+	if w, ok := DefaultStepWeights[step]; ok {
+		return w
+	}
+	return defaultStepWeight
+}
+
+// progressLocked counts finished steps fully and running steps up to their
+// expected duration. The percentage stays below 100 until FinalStep
+// completes, since a step can always take longer than expected.
+func (t *progressTracker) progressLocked(now time.Time) Progress {
+	var total, finished, remaining time.Duration
+	for _, step := range t.plan {
+		expected := t.expected(step)
+		total += expected
+		switch start, running := t.started[step]; {
+		case t.done[step]:
+			finished += expected
+		case running:
+			elapsed := min(now.Sub(start), expected)
+			finished += elapsed
+			remaining += expected - elapsed
+		default:
+			remaining += expected
+		}
+	}
+	if total <= 0 {
+		return Progress{}
+	}
+	percent := int(math.Floor(float64(finished) / float64(total) * 100))
+	return Progress{
+		Percent:    min(percent, 99),
+		ETASeconds: int(math.Ceil(remaining.Seconds())),
+	}
+}
+
+func (t *progressTracker) recordDuration(step string, d time.Duration) {
+	ms := float64(d) / float64(time.Millisecond)
+	h := t.history.Steps[step]
+	if h.Samples == 0 {
+		h.AverageMs = ms
+	} else {
+		h.AverageMs = historySmoothing*ms + (1-historySmoothing)*h.AverageMs
+	}
+	h.Samples++
+	t.history.Steps[step] = h
+}
+
+// saveBoot persists the step averages and this boot's steps. It merges into
+// the file on disk, so reporters provisioning other workspaces on the node
+// don't lose each other's samples.
+func (t *progressTracker) saveBoot() {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	onDisk := loadProgressHistory(t.path)
+	for step := range t.started {
+		if h, ok := t.history.Steps[step]; ok {
+			onDisk.Steps[step] = h
+		}
+	}
+	onDisk.LastBoot = nil
+	for _, step := range t.plan {
+		if _, ran := t.started[step]; ran || t.done[step] {
+			onDisk.LastBoot = append(onDisk.LastBoot, step)
+		}
+	}
+
+	if err := writeProgressHistory(t.path, onDisk); err != nil {
+		slog.Warn("bootlog: failed to write progress history", "path", t.path, "error", err)
+	}
+}
+
+func writeProgressHistory(path string, history progressHistory) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(history)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, encoded, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,69 @@
+package bootlog
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time          { return c.now }
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+func (c *fakeClock) run(t *progressTracker, step string, d time.Duration) Progress {
+	t.observe(step, "started")
+	c.Advance(d)
+	return t.observe(step, "completed")
+}
+
+func TestProgressTrackerLearnsStepDurations(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "boot-step-durations.json")
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+
+	// First boot: only the default weights are known.
+	first := newProgressTracker(path, clock.Now)
+	if p := first.observe("git_clone", "started"); p.Percent != 0 || p.ETASeconds != 118 {
+		t.Fatalf("first boot start = %+v, want 0%% with the default ETA", p)
+	}
+	clock.Advance(10 * time.Second)
+	first.observe("git_clone", "completed")
+	clock.run(first, "devcontainer_up", 40*time.Second)
+	if p := clock.run(first, FinalStep, 2*time.Second); p.Percent != 100 || p.ETASeconds != 0 {
+		t.Fatalf("final step = %+v, want 100%% with no ETA", p)
+	}
+
+	// Second boot: the plan is the steps of the first boot and the
+	// expected durations are the recorded ones.
+	second := newProgressTracker(path, clock.Now)
+	p := second.observe("git_clone", "started")
+	if p.Percent != 0 || p.ETASeconds != 52 {
+		t.Fatalf("second boot start = %+v, want 0%% and a 52s ETA", p)
+	}
+	clock.Advance(5 * time.Second)
+	p = second.observe("git_clone", "completed")
+	if p.Percent != 16 || p.ETASeconds != 42 {
+		t.Fatalf("after a fast clone = %+v, want 16%% and a 42s ETA", p)
+	}
+	second.observe("devcontainer_up", "started")
+	clock.Advance(20 * time.Second)
+	// A step still running counts up to its expected duration.
+	if p := second.observe("volume_populate", "started"); p.Percent < 50 || p.Percent > 99 {
+		t.Fatalf("mid build = %+v, want between 50%% and 99%%", p)
+	}
+
+	if got := second.history.Steps["git_clone"]; got.Samples != 2 || got.AverageMs != 8500 {
+		t.Fatalf("git_clone history = %+v, want a 2-sample rolling average of 8500ms", got)
+	}
+}
+
+func TestProgressTrackerNeverReports100BeforeFinalStep(t *testing.T) {
+	t.Parallel()
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	tracker := newProgressTracker(filepath.Join(t.TempDir(), "history.json"), clock.Now)
+	for _, step := range defaultBootPlan[:len(defaultBootPlan)-1] {
+		if p := clock.run(tracker, step, time.Minute); p.Percent >= 100 {
+			t.Fatalf("after %s = %+v, want below 100%%", step, p)
+		}
+	}
+}
@@ -1,4 +1,5 @@
-// Package bootlog sends structured boot log entries to the control plane.
+// Package bootlog sends structured boot log entries to the control plane and
+// to optional operator-configured sinks (file, journald, webhook).
 // All methods are nil-safe: a nil *Reporter is a no-op.
 package bootlog
 
@@ -9,9 +10,11 @@ import (
 	"log/slog"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/workspace/vm-agent/internal/config"
+	"github.com/workspace/vm-agent/internal/redact"
 )
 
 // Broadcaster is an interface for local real-time delivery of boot log entries.
@@ -21,22 +24,44 @@ type Broadcaster interface {
 	Broadcast(step, status, message string, detail ...string)
 }
 
+// ProgressBroadcaster is implemented by broadcasters that also deliver the
+// boot progress of each entry. Reporters that track progress use it instead
+// of Broadcast.
+type ProgressBroadcaster interface {
+	BroadcastProgress(step, status, message string, progress Progress, detail ...string)
+}
+
+// maxQueuedEntries bounds the entries held while the control plane is
+// unreachable. The oldest entries are dropped first.
+const maxQueuedEntries = 200
+
 // Reporter sends structured log entries to the control plane boot-log endpoint.
 // It is safe to call methods on a nil *Reporter — they simply no-op.
+//
+// Entries that fail because the control plane is unreachable (transport
+// errors, 5xx) are queued and delivered, in order, before the next entry or
+// on Flush.
 type Reporter struct {
 	controlPlaneURL string
 	workspaceID     string
 	callbackToken   string
 	client          *http.Client
 	broadcaster     Broadcaster
+	sinks           []Sink
+	progress        *progressTracker
+
+	queueMu sync.Mutex
+	queue   []logEntry
 }
 
 type logEntry struct {
-	Step      string `json:"step"`
-	Status    string `json:"status"`
-	Message   string `json:"message"`
-	Detail    string `json:"detail,omitempty"`
-	Timestamp string `json:"timestamp"`
+	Step       string `json:"step"`
+	Status     string `json:"status"`
+	Message    string `json:"message"`
+	Detail     string `json:"detail,omitempty"`
+	Progress   int    `json:"progress,omitempty"`
+	ETASeconds int    `json:"etaSeconds,omitempty"`
+	Timestamp  string `json:"timestamp"`
 }
 
 // New creates a Reporter. The reporter starts without a token and will no-op
@@ -49,11 +74,13 @@ func New(controlPlaneURL, workspaceID string) *Reporter {
 	}
 }
 
-// SetToken enables log sending by providing the callback JWT.
+// SetToken enables log sending by providing the callback JWT. The token is
+// registered for redaction.
 func (r *Reporter) SetToken(token string) {
 	if r == nil {
 		return
 	}
+	redact.Register(token)
 	r.callbackToken = token
 }
 
@@ -67,6 +94,25 @@ func (r *Reporter) SetBroadcaster(b Broadcaster) {
 	r.broadcaster = b
 }
 
+// AddSinks registers operator-configured destinations that receive every
+// entry, after redaction, alongside the control-plane relay.
+func (r *Reporter) AddSinks(sinks ...Sink) {
+	if r == nil {
+		return
+	}
+	r.sinks = append(r.sinks, sinks...)
+}
+
+// TrackProgress makes every entry carry a progress percentage and ETA,
+// estimated from step durations that are kept as rolling averages in the
+// JSON file at historyPath. An empty historyPath leaves progress off.
+func (r *Reporter) TrackProgress(historyPath string) {
+	if r == nil || historyPath == "" {
+		return
+	}
+	r.progress = newProgressTracker(historyPath, time.Now)
+}
+
 // Phase wraps an operation with started/completed (or failed) log entries,
 // measuring wall-clock duration and emitting it in the detail field as
 // "duration_ms=...". The error returned by fn is propagated unchanged.
@@ -92,7 +138,8 @@ func (r *Reporter) Phase(step string, fn func() error) error {
 }
 
 // Log sends a boot log entry to the control plane. It also broadcasts locally
-// to any connected WebSocket clients via the broadcaster (if set).
+// to any connected WebSocket clients via the broadcaster (if set) and writes
+// the entry to every registered sink.
 //
 // The local broadcast happens BEFORE the token check so that early bootstrap
 // steps (before token redemption) are visible to WebSocket clients.
@@ -103,54 +150,126 @@ func (r *Reporter) Log(step, status, message string, detail ...string) {
 		return
 	}
 
+	message = redact.String(message)
+	if len(detail) > 0 {
+		detail = append([]string{redact.String(detail[0])}, detail[1:]...)
+	}
+
+	var progress Progress
+	if r.progress != nil {
+		progress = r.progress.observe(step, status)
+	}
+
 	// Broadcast locally first — works even before token redemption.
-	if r.broadcaster != nil {
+	if pb, ok := r.broadcaster.(ProgressBroadcaster); ok && r.progress != nil {
+		pb.BroadcastProgress(step, status, message, progress, detail...)
+	} else if r.broadcaster != nil {
 		r.broadcaster.Broadcast(step, status, message, detail...)
 	}
 
+	entry := logEntry{
+		Step:       step,
+		Status:     status,
+		Message:    message,
+		Progress:   progress.Percent,
+		ETASeconds: progress.ETASeconds,
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+	}
+	if len(detail) > 0 && detail[0] != "" {
+		entry.Detail = detail[0]
+	}
+
+	// Operator sinks do not depend on the control plane either.
+	r.writeSinks(entry)
+
 	// HTTP relay requires the callback token.
 	if r.callbackToken == "" {
 		return
 	}
 
-	r.logHTTP(step, status, message, detail...)
+	r.logHTTP(entry)
 }
 
-// logHTTP sends a boot log entry to the control plane via HTTP POST.
-func (r *Reporter) logHTTP(step, status, message string, detail ...string) {
-	entry := logEntry{
-		Step:      step,
-		Status:    status,
-		Message:   message,
-		Timestamp: time.Now().UTC().Format(time.RFC3339),
+// logHTTP sends a boot log entry to the control plane via HTTP POST, after
+// any entries queued while the control plane was unreachable.
+func (r *Reporter) logHTTP(entry logEntry) {
+	r.queueMu.Lock()
+	defer r.queueMu.Unlock()
+	r.queue = append(r.queue, entry)
+	r.flushLocked()
+}
+
+// Flush delivers entries queued while the control plane was unreachable.
+// Entries that still cannot be delivered stay queued.
+func (r *Reporter) Flush() {
+	if r == nil {
+		return
 	}
-	if len(detail) > 0 && detail[0] != "" {
-		entry.Detail = detail[0]
+	r.queueMu.Lock()
+	defer r.queueMu.Unlock()
+	r.flushLocked()
+}
+
+// QueuedEntries returns the number of entries awaiting delivery.
+func (r *Reporter) QueuedEntries() int {
+	if r == nil {
+		return 0
+	}
+	r.queueMu.Lock()
+	defer r.queueMu.Unlock()
+	return len(r.queue)
+}
+
+func (r *Reporter) flushLocked() {
+	if r.callbackToken == "" {
+		return
+	}
+	for len(r.queue) > 0 {
+		if !r.send(r.queue[0]) {
+			break
+		}
+		r.queue = r.queue[1:]
+	}
+	if dropped := len(r.queue) - maxQueuedEntries; dropped > 0 {
+		slog.Warn("bootlog: dropping queued entries while control plane is unreachable", "dropped", dropped)
+		r.queue = r.queue[dropped:]
 	}
+	if len(r.queue) == 0 {
+		r.queue = nil
+	}
+}
 
+// send posts one entry. Returns false only when the entry should be retried
+// later; entries rejected by the control plane are logged and discarded.
+func (r *Reporter) send(entry logEntry) bool {
 	body, err := json.Marshal(entry)
 	if err != nil {
 		slog.Error("bootlog: failed to marshal entry", "error", err)
-		return
+		return true
 	}
 
 	url := fmt.Sprintf("%s/api/workspaces/%s/boot-log", r.controlPlaneURL, r.workspaceID)
 	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
 	if err != nil {
 		slog.Error("bootlog: failed to create request", "error", err)
-		return
+		return true
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+r.callbackToken)
 
 	resp, err := r.client.Do(req)
 	if err != nil {
-		slog.Error("bootlog: failed to send log entry", "step", step, "error", err)
-		return
+		slog.Error("bootlog: failed to send log entry", "step", entry.Step, "error", err)
+		return false
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode >= http.StatusInternalServerError {
+		slog.Warn("bootlog: control plane unavailable, queueing entry", "statusCode", resp.StatusCode, "step", entry.Step)
+		return false
+	}
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		slog.Warn("bootlog: control plane returned non-OK status", "statusCode", resp.StatusCode, "step", step)
+		slog.Warn("bootlog: control plane returned non-OK status", "statusCode", resp.StatusCode, "step", entry.Step)
 	}
+	return true
 }
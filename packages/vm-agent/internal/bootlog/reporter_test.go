@@ -5,9 +5,12 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
+
+	"github.com/workspace/vm-agent/internal/redact"
 )
 
 func TestNilReporterSafe(t *testing.T) {
@@ -265,3 +268,134 @@ func TestLogHandlesServerError(t *testing.T) {
 	// Should not panic even if server returns 500
 	r.Log("test_step", "started", "test")
 }
+
+func TestLogQueuesEntriesWhileControlPlaneUnavailable(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	available := false
+	var steps []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !available {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var entry logEntry
+		_ = json.NewDecoder(r.Body).Decode(&entry)
+		steps = append(steps, entry.Step)
+	}))
+	defer server.Close()
+
+	r := New(server.URL, "ws-123")
+	r.SetToken("token")
+	r.Log("first", "started", "")
+	r.Log("second", "started", "")
+	if got := r.QueuedEntries(); got != 2 {
+		t.Fatalf("QueuedEntries = %d, want 2", got)
+	}
+
+	mu.Lock()
+	available = true
+	mu.Unlock()
+	r.Flush()
+	r.Log("third", "started", "")
+
+	if got := r.QueuedEntries(); got != 0 {
+		t.Fatalf("QueuedEntries after recovery = %d, want 0", got)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(steps, ",") != "first,second,third" {
+		t.Fatalf("delivered steps = %v, want queued entries first and in order", steps)
+	}
+}
+
+func TestLogRedactsRegisteredSecrets(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var received logEntry
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		_ = json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+
+	r := New(server.URL, "ws-redact")
+	r.SetToken("cb-token-redact-test")
+	redact.Register("ghs_bootlogsecret")
+	r.Log("git_clone", "failed", "auth failed for ghs_bootlogsecret", "token=cb-token-redact-test")
+
+	mu.Lock()
+	defer mu.Unlock()
+	if received.Message != "auth failed for ***" {
+		t.Fatalf("message=%q, want secret redacted", received.Message)
+	}
+	if received.Detail != "token=***" {
+		t.Fatalf("detail=%q, want callback token redacted", received.Detail)
+	}
+}
+
+type recordingProgressBroadcaster struct {
+	mu       sync.Mutex
+	progress []Progress
+}
+
+func (b *recordingProgressBroadcaster) Broadcast(step, status, message string, detail ...string) {
+	panic("reporters tracking progress must use BroadcastProgress")
+}
+
+func (b *recordingProgressBroadcaster) BroadcastProgress(step, status, message string, progress Progress, detail ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.progress = append(b.progress, progress)
+}
+
+func TestTrackProgressReportsPercentAndETA(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var entries []logEntry
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e logEntry
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Errorf("failed to decode: %v", err)
+		}
+		mu.Lock()
+		entries = append(entries, e)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	broadcaster := &recordingProgressBroadcaster{}
+	r := New(server.URL, "ws-progress")
+	r.SetToken("token")
+	r.SetBroadcaster(broadcaster)
+	r.TrackProgress(filepath.Join(t.TempDir(), "boot-step-durations.json"))
+
+	r.Log("git_clone", "started", "Cloning repository")
+	r.Log("git_clone", "completed", "Repository cloned")
+	r.Log(FinalStep, "started", "Marking workspace ready")
+	r.Log(FinalStep, "completed", "Workspace is ready")
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(entries) != 4 {
+		t.Fatalf("expected 4 entries, got %d", len(entries))
+	}
+	if entries[0].ETASeconds == 0 {
+		t.Fatalf("first entry = %+v, want an ETA", entries[0])
+	}
+	if entries[1].Progress >= 100 || entries[1].ETASeconds >= entries[0].ETASeconds {
+		t.Fatalf("clone completed entry = %+v, want a shorter ETA than %ds", entries[1], entries[0].ETASeconds)
+	}
+	if entries[3].Progress != 100 || entries[3].ETASeconds != 0 {
+		t.Fatalf("final entry = %+v, want 100%% with no ETA", entries[3])
+	}
+	if len(broadcaster.progress) != 4 || broadcaster.progress[3].Percent != 100 {
+		t.Fatalf("broadcast progress = %+v, want 4 entries ending at 100%%", broadcaster.progress)
+	}
+}
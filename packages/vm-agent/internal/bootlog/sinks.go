@@ -0,0 +1,332 @@
+package bootlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/config"
+	"github.com/workspace/vm-agent/internal/lifecyclehook"
+)
+
+// Sink names accepted in BOOTLOG_SINKS.
+const (
+	SinkFile     = "file"
+	SinkJournald = "journald"
+	SinkWebhook  = "webhook"
+)
+
+// DefaultJournalSocket is the systemd-journald native protocol socket.
+const DefaultJournalSocket = "/run/systemd/journal/socket"
+
+// webhookQueueSize bounds the entries waiting for webhook delivery. Entries
+// are dropped, not blocked on, when the endpoint falls behind.
+const webhookQueueSize = 256
+
+// Entry is a boot log entry as delivered to sinks.
+type Entry struct {
+	WorkspaceID string `json:"workspaceId,omitempty"`
+	Step        string `json:"step"`
+	Status      string `json:"status"`
+	Message     string `json:"message"`
+	Detail      string `json:"detail,omitempty"`
+	Progress    int    `json:"progress,omitempty"`
+	ETASeconds  int    `json:"etaSeconds,omitempty"`
+	Timestamp   string `json:"timestamp"`
+}
+
+// Sink receives every boot log entry in addition to the control-plane relay.
+// Write runs on the bootstrap path and must not block for long.
+type Sink interface {
+	Write(entry Entry) error
+}
+
+// NewSinks builds the sinks listed in cfg.BootLogSinks. The returned sinks
+// are shared by every Reporter on the node; release them with CloseSinks.
+func NewSinks(cfg *config.Config) ([]Sink, error) {
+	var sinks []Sink
+	for _, name := range cfg.BootLogSinks {
+		switch name {
+		case SinkFile:
+			sinks = append(sinks, NewFileSink(cfg.BootLogFilePath))
+		case SinkJournald:
+			sinks = append(sinks, NewJournaldSink(DefaultJournalSocket))
+		case SinkWebhook:
+			sinks = append(sinks, NewWebhookSink(cfg.BootLogWebhookURL, cfg.BootLogWebhookSecret))
+		default:
+			CloseSinks(sinks)
+			return nil, fmt.Errorf("unknown boot log sink %q", name)
+		}
+	}
+	return sinks, nil
+}
+
+// CloseSinks closes every sink that holds resources.
+func CloseSinks(sinks []Sink) {
+	for _, sink := range sinks {
+		if closer, ok := sink.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				slog.Warn("bootlog: failed to close sink", "error", err)
+			}
+		}
+	}
+}
+
+func (r *Reporter) writeSinks(entry logEntry) {
+	if len(r.sinks) == 0 {
+		return
+	}
+	out := Entry{
+		WorkspaceID: r.workspaceID,
+		Step:        entry.Step,
+		Status:      entry.Status,
+		Message:     entry.Message,
+		Detail:      entry.Detail,
+		Progress:    entry.Progress,
+		ETASeconds:  entry.ETASeconds,
+		Timestamp:   entry.Timestamp,
+	}
+	for _, sink := range r.sinks {
+		if err := sink.Write(out); err != nil {
+			slog.Warn("bootlog: sink write failed", "sink", fmt.Sprintf("%T", sink), "step", entry.Step, "error", err)
+		}
+	}
+}
+
+// FileSink appends entries as JSON lines to a local file. The file and its
+// directory are created on first write.
+type FileSink struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSink returns a sink appending to path.
+func NewFileSink(path string) *FileSink {
+	return &FileSink{path: path}
+}
+
+// Write appends entry as one JSON line.
+func (s *FileSink) Write(entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+			return fmt.Errorf("create boot log directory: %w", err)
+		}
+		f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+		if err != nil {
+			return fmt.Errorf("open boot log file: %w", err)
+		}
+		s.file = f
+	}
+	_, err = s.file.Write(line)
+	return err
+}
+
+// Close closes the underlying file.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// JournaldSink sends entries to systemd-journald over its native protocol,
+// as structured fields under SYSLOG_IDENTIFIER=vm-agent-bootlog.
+type JournaldSink struct {
+	socketPath string
+
+	mu   sync.Mutex
+	conn *net.UnixConn
+}
+
+// NewJournaldSink returns a sink writing to the journald socket at socketPath.
+func NewJournaldSink(socketPath string) *JournaldSink {
+	return &JournaldSink{socketPath: socketPath}
+}
+
+// Write sends entry as one journal record. Failed entries map to priority
+// err (3), everything else to info (6).
+func (s *JournaldSink) Write(entry Entry) error {
+	priority := "6"
+	if entry.Status == "failed" {
+		priority = "3"
+	}
+	message := fmt.Sprintf("[%s] %s", entry.Step, entry.Status)
+	if entry.Message != "" {
+		message += ": " + entry.Message
+	}
+
+	var buf bytes.Buffer
+	appendJournalField(&buf, "MESSAGE", message)
+	appendJournalField(&buf, "PRIORITY", priority)
+	appendJournalField(&buf, "SYSLOG_IDENTIFIER", "vm-agent-bootlog")
+	appendJournalField(&buf, "SAM_WORKSPACE_ID", entry.WorkspaceID)
+	appendJournalField(&buf, "SAM_BOOT_STEP", entry.Step)
+	appendJournalField(&buf, "SAM_BOOT_STATUS", entry.Status)
+	if entry.Detail != "" {
+		appendJournalField(&buf, "SAM_BOOT_DETAIL", entry.Detail)
+	}
+	appendJournalField(&buf, "SAM_BOOT_TIMESTAMP", entry.Timestamp)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: s.socketPath, Net: "unixgram"})
+		if err != nil {
+			return fmt.Errorf("connect to journald: %w", err)
+		}
+		s.conn = conn
+	}
+	if _, err := s.conn.Write(buf.Bytes()); err != nil {
+		// Reconnect on the next entry, e.g. after journald restarted.
+		s.conn.Close()
+		s.conn = nil
+		return fmt.Errorf("write to journald: %w", err)
+	}
+	return nil
+}
+
+// Close closes the journald socket.
+func (s *JournaldSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// appendJournalField encodes one field in the journald native protocol.
+// Values containing a newline use the length-prefixed binary form.
+func appendJournalField(buf *bytes.Buffer, key, value string) {
+	buf.WriteString(key)
+	if !strings.Contains(value, "\n") {
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+		return
+	}
+	buf.WriteByte('\n')
+	_ = binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+// WebhookSink POSTs each entry as JSON to an operator endpoint from a
+// background goroutine. When secret is set, bodies are signed like lifecycle
+// webhooks (X-SAM-Signature). Delivery is best effort: failed posts are
+// logged and not retried.
+type WebhookSink struct {
+	url    string
+	secret string
+	client *http.Client
+	queue  chan Entry
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// NewWebhookSink returns a sink posting to url and starts its delivery
+// goroutine.
+func NewWebhookSink(url, secret string) *WebhookSink {
+	s := &WebhookSink{
+		url:    url,
+		secret: secret,
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan Entry, webhookQueueSize),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Write queues entry for delivery. It never blocks; entries are dropped when
+// the queue is full.
+func (s *WebhookSink) Write(entry Entry) error {
+	select {
+	case <-s.done:
+		return fmt.Errorf("webhook sink closed")
+	default:
+	}
+	select {
+	case s.queue <- entry:
+		return nil
+	default:
+		return fmt.Errorf("webhook queue full, entry dropped")
+	}
+}
+
+// Close stops delivery after the queued entries have been posted.
+func (s *WebhookSink) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	return nil
+}
+
+func (s *WebhookSink) run() {
+	for {
+		select {
+		case entry := <-s.queue:
+			s.post(entry)
+		case <-s.done:
+			for {
+				select {
+				case entry := <-s.queue:
+					s.post(entry)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *WebhookSink) post(entry Entry) {
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		slog.Warn("bootlog: failed to create webhook request", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.secret != "" {
+		req.Header.Set(lifecyclehook.HeaderSignature, lifecyclehook.Sign(s.secret, body))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		slog.Warn("bootlog: webhook delivery failed", "step", entry.Step, "error", err)
+		return
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		slog.Warn("bootlog: webhook returned non-OK status", "statusCode", resp.StatusCode, "step", entry.Step)
+	}
+}
@@ -0,0 +1,167 @@
+package bootlog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/config"
+	"github.com/workspace/vm-agent/internal/lifecyclehook"
+	"github.com/workspace/vm-agent/internal/redact"
+)
+
+func TestFileSinkReceivesRedactedEntriesWithoutToken(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "sam", "bootlog.jsonl")
+	sink := NewFileSink(path)
+	defer sink.Close()
+
+	r := New("http://127.0.0.1:0", "ws-file")
+	r.AddSinks(sink)
+	redact.Register("ghs_filesinksecret")
+	r.Log("git_clone", "started", "")
+	r.Log("git_clone", "failed", "auth failed for ghs_filesinksecret", "exit=128")
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open sink file: %v", err)
+	}
+	defer f.Close()
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("decode line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("entries = %+v, want 2", entries)
+	}
+	got := entries[1]
+	if got.WorkspaceID != "ws-file" || got.Step != "git_clone" || got.Status != "failed" || got.Detail != "exit=128" || got.Timestamp == "" {
+		t.Fatalf("entry = %+v", got)
+	}
+	if got.Message != "auth failed for ***" {
+		t.Fatalf("message = %q, want secret redacted", got.Message)
+	}
+}
+
+func TestJournaldSinkWritesNativeProtocol(t *testing.T) {
+	t.Parallel()
+
+	// Unix socket paths are length-limited, so avoid the long t.TempDir().
+	dir, err := os.MkdirTemp("", "jd")
+	if err != nil {
+		t.Fatalf("MkdirTemp: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socketPath := filepath.Join(dir, "socket")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer conn.Close()
+
+	sink := NewJournaldSink(socketPath)
+	defer sink.Close()
+	if err := sink.Write(Entry{WorkspaceID: "ws-1", Step: "devcontainer_up", Status: "failed", Message: "build failed", Detail: "line1\nline2"}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	buf := make([]byte, 4096)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("read datagram: %v", err)
+	}
+	record := string(buf[:n])
+	for _, want := range []string{
+		"MESSAGE=[devcontainer_up] failed: build failed\n",
+		"PRIORITY=3\n",
+		"SAM_WORKSPACE_ID=ws-1\n",
+		"SAM_BOOT_STEP=devcontainer_up\n",
+	} {
+		if !strings.Contains(record, want) {
+			t.Fatalf("record %q missing %q", record, want)
+		}
+	}
+
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len("line1\nline2")))
+	if !strings.Contains(record, "SAM_BOOT_DETAIL\n"+string(size[:])+"line1\nline2\n") {
+		t.Fatalf("record %q missing binary-encoded detail", record)
+	}
+}
+
+func TestWebhookSinkPostsSignedEntries(t *testing.T) {
+	t.Parallel()
+
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL, "hook-secret")
+	if err := sink.Write(Entry{WorkspaceID: "ws-1", Step: "workspace_ready", Status: "completed", Timestamp: "2026-01-02T03:04:05Z"}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	select {
+	case r := <-received:
+		body := <-bodies
+		if r.Header.Get(lifecyclehook.HeaderSignature) != lifecyclehook.Sign("hook-secret", body) {
+			t.Fatalf("signature = %q", r.Header.Get(lifecyclehook.HeaderSignature))
+		}
+		var entry Entry
+		if err := json.NewDecoder(bytes.NewReader(body)).Decode(&entry); err != nil || entry.Step != "workspace_ready" || entry.WorkspaceID != "ws-1" {
+			t.Fatalf("entry = %+v, err = %v", entry, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for webhook delivery")
+	}
+
+	sink.Close()
+	if err := sink.Write(Entry{Step: "late"}); err == nil {
+		t.Fatal("Write after Close should fail")
+	}
+}
+
+func TestNewSinks(t *testing.T) {
+	t.Parallel()
+
+	sinks, err := NewSinks(&config.Config{
+		BootLogSinks:    []string{SinkFile, SinkJournald},
+		BootLogFilePath: filepath.Join(t.TempDir(), "bootlog.jsonl"),
+	})
+	if err != nil {
+		t.Fatalf("NewSinks: %v", err)
+	}
+	defer CloseSinks(sinks)
+	if len(sinks) != 2 {
+		t.Fatalf("sinks = %d, want 2", len(sinks))
+	}
+	if _, ok := sinks[0].(*FileSink); !ok {
+		t.Fatalf("sinks[0] = %T, want *FileSink", sinks[0])
+	}
+
+	if _, err := NewSinks(&config.Config{BootLogSinks: []string{"syslog"}}); err == nil {
+		t.Fatal("NewSinks with unknown sink should fail")
+	}
+}
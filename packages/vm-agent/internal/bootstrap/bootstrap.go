@@ -20,11 +20,21 @@ import (
 	"time"
 
 	"github.com/workspace/vm-agent/internal/bootlog"
+	"github.com/workspace/vm-agent/internal/bootstrap/gitprovider"
 	"github.com/workspace/vm-agent/internal/cache"
-	"github.com/workspace/vm-agent/internal/callbackretry"
 	"github.com/workspace/vm-agent/internal/config"
 	"github.com/workspace/vm-agent/internal/container"
+	"github.com/workspace/vm-agent/internal/containerruntime"
+	"github.com/workspace/vm-agent/internal/egress"
+	"github.com/workspace/vm-agent/internal/execaudit"
+	"github.com/workspace/vm-agent/internal/faultinject"
 	"github.com/workspace/vm-agent/internal/gitrepo"
+	"github.com/workspace/vm-agent/internal/provisionspec"
+	"github.com/workspace/vm-agent/internal/redact"
+	"github.com/workspace/vm-agent/internal/repocache"
+	"github.com/workspace/vm-agent/internal/retry"
+	"github.com/workspace/vm-agent/internal/tracing"
+	"golang.org/x/sync/errgroup"
 )
 
 const (
@@ -84,6 +94,35 @@ type bootstrapResponse struct {
 	GitUserEmail    *string `json:"gitUserEmail"`
 	GitHubID        *string `json:"githubId"`
 	ControlPlaneURL string  `json:"controlPlaneUrl"`
+	// Provider names the repository's git host (github, gitlab, bitbucket,
+	// artifacts). GitToken is that provider's token; GitHubToken remains the
+	// fallback for control planes that only send GitHub credentials.
+	Provider       *string `json:"provider"`
+	GitToken       *string `json:"gitToken"`
+	RepositoryHost *string `json:"repositoryHost"`
+	RepositoryPath *string `json:"repositoryPath"`
+	// DeployKey is an SSH private key for ssh:// and git@ remotes, with
+	// optional KnownHosts lines for the git host.
+	DeployKey  *string `json:"deployKey"`
+	KnownHosts *string `json:"knownHosts"`
+	// SigningKey is an SSH or armored OpenPGP private key used to sign
+	// commits made in the workspace.
+	SigningKey *string `json:"signingKey"`
+	// EnvironmentTemplate is the user's setup applied in the devcontainer.
+	EnvironmentTemplate *EnvironmentTemplate `json:"environmentTemplate"`
+	// ResourceLimits override the node's default devcontainer limits.
+	ResourceLimits *ResourceLimits `json:"resourceLimits"`
+	// EgressPolicy restricts the network destinations the devcontainer can
+	// reach; nil leaves egress unrestricted.
+	EgressPolicy *egress.Policy `json:"egressPolicy"`
+	// RegistryCredentials authenticate devcontainer image and feature
+	// pulls from private registries.
+	RegistryCredentials RegistryCredentials `json:"registryCredentials"`
+	// DevcontainerPolicy restricts the images and features the repository's
+	// devcontainer config may use.
+	DevcontainerPolicy *DevcontainerPolicy `json:"devcontainerPolicy"`
+	// Hooks are lifecycle hook scripts; see RunLifecycleHooks.
+	Hooks Hooks `json:"hooks"`
 }
 
 type bootstrapState struct {
@@ -93,6 +132,30 @@ type bootstrapState struct {
 	GitUserName   string `json:"gitUserName,omitempty"`
 	GitUserEmail  string `json:"gitUserEmail,omitempty"`
 	GitHubID      string `json:"githubId,omitempty"`
+	// Provider, RepositoryHost, and RepositoryPath come from the bootstrap
+	// response; GitHubToken holds the provider's token whatever the provider.
+	Provider       string `json:"provider,omitempty"`
+	RepositoryHost string `json:"repositoryHost,omitempty"`
+	RepositoryPath string `json:"repositoryPath,omitempty"`
+	DeployKey      string `json:"deployKey,omitempty"`
+	KnownHosts     string `json:"knownHosts,omitempty"`
+	SigningKey     string `json:"signingKey,omitempty"`
+	// EnvironmentTemplate is kept with the cached state so a restarted
+	// bootstrap applies the same template without redeeming again.
+	EnvironmentTemplate *EnvironmentTemplate `json:"environmentTemplate,omitempty"`
+	// ResourceLimits override the node's default devcontainer limits.
+	ResourceLimits *ResourceLimits `json:"resourceLimits,omitempty"`
+	// EgressPolicy is kept so a restarted bootstrap applies it again.
+	EgressPolicy *egress.Policy `json:"egressPolicy,omitempty"`
+	// RegistryCredentials are kept so a restarted bootstrap can pull the
+	// same private images.
+	RegistryCredentials RegistryCredentials `json:"registryCredentials,omitempty"`
+	// DevcontainerPolicy is kept so a restarted bootstrap checks the config
+	// it builds against it.
+	DevcontainerPolicy *DevcontainerPolicy `json:"devcontainerPolicy,omitempty"`
+	// Hooks are kept so preShutdown hooks can run after the bootstrap
+	// process has returned; see StateHooks.
+	Hooks Hooks `json:"hooks,omitempty"`
 }
 
 type ProjectRuntimeEnvVar struct {
@@ -105,6 +168,11 @@ type ProjectRuntimeFile struct {
 	Path     string
 	Content  string
 	IsSecret bool
+	// Mode is the file mode; zero means 0600 for secrets and 0644 otherwise.
+	Mode os.FileMode
+	// Owner is a chown spec ("user" or "user:group"); empty means the
+	// container user.
+	Owner string
 }
 
 // ProvisionState carries optional credential and git identity data used when
@@ -122,24 +190,75 @@ type ProvisionState struct {
 	ProjectFiles           []ProjectRuntimeFile
 	Lightweight            bool   // Skip devcontainer build, use fallback image for faster startup
 	DevcontainerConfigName string // Named devcontainer config (subdirectory under .devcontainer/)
+	CommitTrailers         *bool  // Per-workspace commit trailer hooks override; nil uses cfg.GitCommitTrailers
+	// Spec is the declarative provisioning spec. When set, its values take
+	// precedence over the loose fields above; see applyProvisionSpec.
+	Spec *provisionspec.Spec
+	// RepoCache is the node's repository mirror cache. When set, the clone
+	// borrows objects from a local mirror; nil clones straight from origin.
+	RepoCache *repocache.Cache
+	// SnapshotURL, when set, is a download URL for a volume snapshot taken
+	// with SnapshotVolume. An empty workspace volume is seeded from it before
+	// the repository and devcontainer steps run.
+	SnapshotURL string
+	// DeployKey and KnownHosts authenticate SSH remotes (ssh:// or git@host:path).
+	DeployKey  string
+	KnownHosts string
+	// SigningKey is an SSH or armored OpenPGP private key that git uses to
+	// sign commits and tags in the devcontainer; see ensureGitSigning.
+	SigningKey string
+	// RebuildContainer removes the workspace's existing devcontainer before
+	// the devcontainer step, so changes to its config take effect.
+	RebuildContainer bool
+	// ForceResync syncs the host clone into the workspace volume even when
+	// the host clone has not changed since the last sync.
+	ForceResync bool
+	// EnvironmentTemplate is applied in the devcontainer after the SAM
+	// environment is configured; see ensureEnvironmentTemplate.
+	EnvironmentTemplate *EnvironmentTemplate
+	// ResourceLimits override the node's default CPU, memory, and process
+	// limits for this workspace's devcontainer; nil uses the defaults.
+	ResourceLimits *ResourceLimits
+	// EgressPolicy restricts the devcontainer's network egress; see
+	// ensureEgressPolicy. nil leaves egress unrestricted.
+	EgressPolicy *egress.Policy
+	// RegistryCredentials are logged in to the host's docker config before
+	// devcontainer up; see loginDevcontainerRegistries.
+	RegistryCredentials RegistryCredentials
+	// DevcontainerPolicy is checked against the repository's devcontainer
+	// config before it is built; see ensureDevcontainerPolicy.
+	DevcontainerPolicy *DevcontainerPolicy
+	// Hooks are the control plane's lifecycle hook scripts. preClone,
+	// postClone, and postDevcontainerUp run during provisioning.
+	Hooks Hooks
 }
 
 // Run redeems bootstrap credentials (if configured), prepares the workspace, and signals ready.
 // The reporter is used to send structured boot log entries to the control plane for UI display.
-// It is safe to pass a nil reporter.
-func Run(ctx context.Context, cfg *config.Config, reporter *bootlog.Reporter) error {
+// It is safe to pass a nil reporter. recoveryMode reports that the workspace came up in
+// recovery mode, on the fallback image or with a recorded build error.
+func Run(ctx context.Context, cfg *config.Config, reporter *bootlog.Reporter) (recoveryMode bool, err error) {
 	if cfg.BootstrapToken == "" {
-		return nil
+		return false, nil
 	}
+	ctx, span := tracing.Start(ctx, "bootstrap.Run", tracing.String("workspace.id", cfg.WorkspaceID))
+	ctx = execaudit.WithActor(ctx, cfg.WorkspaceID, execaudit.InitiatorBootstrap)
+	recoveryMode, err = runBootstrap(ctx, cfg, reporter)
+	span.End(err)
+	return recoveryMode, err
+}
+
+func runBootstrap(ctx context.Context, cfg *config.Config, reporter *bootlog.Reporter) (bool, error) {
+	redact.Register(cfg.BootstrapToken)
 
 	state, err := loadState(cfg.BootstrapStatePath)
 	if err != nil {
-		return fmt.Errorf("failed to load bootstrap state: %w", err)
+		return false, fmt.Errorf("failed to load bootstrap state: %w", err)
 	}
 
 	if state != nil {
 		if state.WorkspaceID != cfg.WorkspaceID {
-			return fmt.Errorf("bootstrap state workspace mismatch: expected %s, found %s", cfg.WorkspaceID, state.WorkspaceID)
+			return false, fmt.Errorf("bootstrap state workspace mismatch: expected %s, found %s", cfg.WorkspaceID, state.WorkspaceID)
 		}
 		slog.Info("Using cached bootstrap state", "path", cfg.BootstrapStatePath)
 		cfg.CallbackToken = state.CallbackToken
@@ -148,19 +267,23 @@ func Run(ctx context.Context, cfg *config.Config, reporter *bootlog.Reporter) er
 		reporter.Log("bootstrap_redeem", "started", "Redeeming bootstrap credentials")
 		state, err = redeemBootstrapTokenWithRetry(ctx, cfg)
 		if err != nil {
-			return err
+			return false, err
 		}
 		cfg.CallbackToken = state.CallbackToken
 		reporter.SetToken(state.CallbackToken)
 		reporter.Log("bootstrap_redeem", "completed", "Bootstrap credentials redeemed")
 		if err := saveState(cfg.BootstrapStatePath, state); err != nil {
-			return fmt.Errorf("failed to persist bootstrap state: %w", err)
+			return false, fmt.Errorf("failed to persist bootstrap state: %w", err)
 		}
 	}
 
 	if cfg.CallbackToken == "" {
-		return errors.New("callback token is missing after bootstrap")
+		return false, errors.New("callback token is missing after bootstrap")
 	}
+	registerStateSecrets(state)
+	applyBootstrapProvider(cfg, state)
+	applyResourceLimitOverrides(cfg, state.ResourceLimits)
+	progress := loadProgress(cfg)
 
 	// Create a named Docker volume for container-mode workspaces.
 	// The volume replaces the host bind-mount, eliminating permission issues.
@@ -171,26 +294,28 @@ func Run(ctx context.Context, cfg *config.Config, reporter *bootlog.Reporter) er
 		volumeName, volErr = ensureVolumeReady(ctx, cfg.WorkspaceID)
 		if volErr != nil {
 			reporter.Log("volume_create", "failed", "Volume creation failed", volErr.Error())
-			return volErr
+			return false, volErr
 		}
 		reporter.Log("volume_create", "completed", "Workspace volume ready")
 	}
 
+	RunLifecycleHooks(ctx, cfg, state.Hooks, HookPreClone, reporter)
 	reporter.Log("git_clone", "started", "Cloning repository")
-	if err := ensureRepositoryReady(ctx, cfg, state, volumeName); err != nil {
+	if err := resumeClone(ctx, cfg, progress); err != nil {
 		reporter.Log("git_clone", "failed", "Repository clone failed", err.Error())
-		return err
+		return false, fmt.Errorf("failed to remove interrupted clone: %w", err)
 	}
+	progress.begin(stepGitClone)
+	if err := ensureRepositoryReady(ctx, cfg, state, nil); err != nil {
+		reporter.Log("git_clone", "failed", "Repository clone failed", err.Error())
+		return false, err
+	}
+	progress.complete(stepGitClone)
 	reporter.Log("git_clone", "completed", "Repository cloned")
+	RunLifecycleHooks(ctx, cfg, state.Hooks, HookPostClone, reporter)
 
-	// Pre-generate credential helper on the VM host so it can be bind-mounted
-	// into the container. This makes git authentication available during
-	// devcontainer lifecycle hooks (postCreateCommand, postStartCommand, etc.).
-	credHelperHostPath, credErr := writeCredentialHelperToHost(cfg)
-	if credErr != nil {
-		slog.Warn("Failed to write credential helper to host (non-fatal)", "error", credErr)
-		reporter.Log("git_credential_helper", "failed", "Credential helper setup failed — git auth may be unavailable in lifecycle hooks", credErr.Error())
-	}
+	setup, err := runParallelSetup(ctx, cfg, reporter, progress, volumeName, false, nil)
+	credHelperHostPath := setup.credHelperHostPath
 	bootstrapSucceeded := false
 	if credHelperHostPath != "" {
 		defer func() {
@@ -202,9 +327,18 @@ func Run(ctx context.Context, cfg *config.Config, reporter *bootlog.Reporter) er
 			}
 		}()
 	}
+	if err != nil {
+		return false, err
+	}
+
+	loginDevcontainerRegistries(ctx, cfg, state.RegistryCredentials, reporter)
+	if err := ensureDevcontainerPolicy(ctx, cfg, state.DevcontainerPolicy, "", reporter); err != nil {
+		return false, err
+	}
 
-	reporter.Log("devcontainer_wait", "started", "Waiting for devcontainer CLI")
 	reporter.Log("devcontainer_up", "started", "Building devcontainer")
+	resumeDevcontainer(ctx, cfg, progress)
+	progress.begin(stepDevcontainerUp)
 	// DevcontainerConfigName is not available in the bootstrap-token path because
 	// bootstrapState (from redeemBootstrapToken) does not carry it. Named
 	// devcontainer configs are only supported via the control-plane POST /workspaces
@@ -214,10 +348,11 @@ func Run(ctx context.Context, cfg *config.Config, reporter *bootlog.Reporter) er
 	usedFallback, err := ensureDevcontainerReady(ctx, cfg, volumeName, credHelperHostPath, "", "")
 	if err != nil {
 		reporter.Log("devcontainer_up", "failed", "Devcontainer build failed", err.Error())
-		return err
+		return false, err
 	}
+	progress.complete(stepDevcontainerUp)
 	if usedFallback {
-		reporter.Log("devcontainer_up", "completed", "Devcontainer ready (fallback to default image)")
+		reporter.Log("devcontainer_up", "completed", "Devcontainer ready (fallback to default image)", buildLogDetail(uploadBuildErrorLog(ctx, cfg)))
 	} else {
 		reporter.Log("devcontainer_up", "completed", "Devcontainer ready")
 	}
@@ -227,6 +362,12 @@ func Run(ctx context.Context, cfg *config.Config, reporter *bootlog.Reporter) er
 	if containerID, findErr := findDevcontainerID(ctx, cfg); findErr == nil {
 		injectAptRetryConfig(ctx, containerID)
 		injectAptMirrorConfig(ctx, cfg, containerID)
+		if err := injectContainerTrustStore(ctx, cfg, containerID); err != nil {
+			slog.Warn("Failed to add CA bundle to devcontainer trust store (non-fatal)", "error", err)
+		}
+		if err := applyContainerResourceLimits(ctx, cfg, containerID); err != nil {
+			slog.Warn("Failed to apply devcontainer resource limits (non-fatal)", "error", err)
+		}
 	} else {
 		slog.Debug("Could not find devcontainer for apt config injection (non-fatal)", "error", findErr)
 	}
@@ -244,25 +385,92 @@ func Run(ctx context.Context, cfg *config.Config, reporter *bootlog.Reporter) er
 	reporter.Log("git_creds", "started", "Configuring git credentials")
 	if err := ensureGitCredentialHelper(ctx, cfg); err != nil {
 		reporter.Log("git_creds", "failed", "Git credential setup failed", err.Error())
-		return err
+		return false, err
 	}
 	reporter.Log("git_creds", "completed", "Git credentials configured")
 
+	if state.DeployKey != "" {
+		reporter.Log("git_ssh_key", "started", "Installing SSH deploy key")
+		if err := ensureDeployKey(ctx, cfg, state); err != nil {
+			reporter.Log("git_ssh_key", "failed", "SSH deploy key setup failed", err.Error())
+			return false, err
+		}
+		reporter.Log("git_ssh_key", "completed", "SSH deploy key installed")
+	}
+
+	// Non-fatal: without LFS objects the checkout has pointer files, which
+	// the user can still pull by hand.
+	if repositoryUsesLFS(cfg.WorkspaceDir) {
+		reporter.Log("git_lfs", "started", "Pulling Git LFS objects")
+		if err := ensureGitLFS(ctx, cfg); err != nil {
+			reporter.Log("git_lfs", "failed", "Git LFS setup failed (non-fatal)", err.Error())
+			slog.Warn("Git LFS setup failed (non-fatal)", "error", err)
+		} else {
+			reporter.Log("git_lfs", "completed", "Git LFS objects pulled")
+		}
+	}
+
 	reporter.Log("git_identity", "started", "Configuring git identity")
 	if err := ensureGitIdentity(ctx, cfg, state); err != nil {
 		reporter.Log("git_identity", "failed", "Git identity setup failed", err.Error())
-		return err
+		return false, err
 	}
 	reporter.Log("git_identity", "completed", "Git identity configured")
 
+	// Non-fatal: commits still work without trailers, just lose traceability.
+	reporter.Log("git_hooks", "started", "Installing commit trailer git hooks")
+	if err := ensureCommitTrailerHooks(ctx, cfg, nil); err != nil {
+		reporter.Log("git_hooks", "failed", "Git hook setup failed (non-fatal)", err.Error())
+		slog.Warn("Git hook setup failed (non-fatal)", "error", err)
+	} else {
+		reporter.Log("git_hooks", "completed", "Commit trailer git hooks configured")
+	}
+
 	reporter.Log("sam_env", "started", "Configuring SAM environment")
-	if err := ensureSAMEnvironment(ctx, cfg, state.GitHubToken); err != nil {
+	if err := ensureSAMEnvironment(ctx, cfg); err != nil {
 		reporter.Log("sam_env", "failed", "SAM environment setup failed", err.Error())
 		slog.Warn("SAM environment setup failed (non-fatal)", "error", err)
 	} else {
 		reporter.Log("sam_env", "completed", "SAM environment configured")
 	}
 
+	// Non-fatal: the workspace works without the user's template, it just
+	// lacks their packages.
+	if state.EnvironmentTemplate.hasSetup() {
+		reporter.Log("env_template", "started", "Applying environment template")
+		if err := ensureEnvironmentTemplate(ctx, cfg, state.EnvironmentTemplate); err != nil {
+			reporter.Log("env_template", "failed", "Environment template failed (non-fatal)", err.Error())
+			slog.Warn("Environment template failed (non-fatal)", "error", err)
+		} else {
+			reporter.Log("env_template", "completed", "Environment template applied")
+		}
+	}
+
+	// Non-fatal, like the template. Runs last so install scripts can use the
+	// packages and shell the template set up.
+	if repo := resolveDotfilesRepository(cfg, state.EnvironmentTemplate); repo != "" {
+		reporter.Log("dotfiles", "started", "Installing dotfiles")
+		if err := ensureDotfiles(ctx, cfg, repo); err != nil {
+			reporter.Log("dotfiles", "failed", "Dotfiles install failed (non-fatal)", err.Error())
+			slog.Warn("Dotfiles install failed (non-fatal)", "error", err)
+		} else {
+			reporter.Log("dotfiles", "completed", "Dotfiles installed")
+		}
+	}
+
+	// Runs once the workspace is fully set up, so hooks can rely on git
+	// credentials, the SAM environment, and the user's template.
+	RunLifecycleHooks(ctx, cfg, state.Hooks, HookPostDevcontainerUp, reporter)
+
+	if state.EgressPolicy != nil {
+		reporter.Log("egress_policy", "started", "Applying network egress policy")
+		if err := ensureEgressPolicy(ctx, cfg, state.EgressPolicy); err != nil {
+			reporter.Log("egress_policy", "failed", "Egress policy failed", err.Error())
+			return false, err
+		}
+		reporter.Log("egress_policy", "completed", "Network egress policy applied")
+	}
+
 	readyStatus := workspaceReadyStatusRunning
 	if recovery, recoveryErr := hasBuildErrorMarker(cfg); recoveryErr != nil {
 		slog.Warn("Failed to inspect build error marker", "workspaceID", cfg.WorkspaceID, "error", recoveryErr)
@@ -283,10 +491,119 @@ func Run(ctx context.Context, cfg *config.Config, reporter *bootlog.Reporter) er
 	reporter.Log("workspace_ready", "started", "Marking workspace ready")
 	if err := markWorkspaceReady(ctx, cfg, readyStatus, ""); err != nil {
 		reporter.Log("workspace_ready", "failed", "Failed to mark workspace ready", err.Error())
-		return &CallbackError{Err: err, Status: readyStatus}
+		return readyStatus == workspaceReadyStatusRecovery, &CallbackError{Err: err, Status: readyStatus}
 	}
+	progress.complete(stepWorkspaceReady)
 	reporter.Log("workspace_ready", "completed", "Workspace is ready")
 
+	return readyStatus == workspaceReadyStatusRecovery, nil
+}
+
+// mergeSpecProjectFiles returns files with the spec's files added, each
+// replacing a file at the same path.
+func mergeSpecProjectFiles(files []ProjectRuntimeFile, spec *provisionspec.Spec) []ProjectRuntimeFile {
+	if spec == nil || len(spec.Files) == 0 {
+		return files
+	}
+	merged := make([]ProjectRuntimeFile, 0, len(files)+len(spec.Files))
+	overridden := make(map[string]bool, len(spec.Files))
+	for _, file := range spec.Files {
+		overridden[strings.TrimSpace(file.Path)] = true
+	}
+	for _, file := range files {
+		if !overridden[strings.TrimSpace(file.Path)] {
+			merged = append(merged, file)
+		}
+	}
+	for _, file := range spec.Files {
+		merged = append(merged, ProjectRuntimeFile{Path: strings.TrimSpace(file.Path), Content: file.Content, IsSecret: file.Secret})
+	}
+	return merged
+}
+
+// applyProvisionSpec folds state.Spec into cfg and state so the rest of
+// PrepareWorkspace consumes a single source of truth. Spec values win over the
+// loose ProvisionState fields; env vars and files are merged with the project
+// runtime assets, with spec entries replacing assets of the same key or path.
+// Spec features are layered over cfg.AdditionalFeatures.
+func applyProvisionSpec(cfg *config.Config, state *ProvisionState) error {
+	spec := state.Spec
+	if spec == nil {
+		return nil
+	}
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+
+	if repo, ok := spec.PrimaryRepository(); ok {
+		cfg.Repository = strings.TrimSpace(repo.Repository)
+		if branch := strings.TrimSpace(repo.Branch); branch != "" {
+			cfg.Branch = branch
+		}
+		state.RepoProvider = repo.Provider
+		state.CloneURL = repo.CloneURL
+		state.RepositoryHost = repo.Host
+		state.RepositoryPath = repo.Path
+	}
+
+	if len(spec.Env) > 0 {
+		merged := make([]ProjectRuntimeEnvVar, 0, len(state.ProjectEnvVars)+len(spec.Env))
+		overridden := make(map[string]bool, len(spec.Env))
+		for _, env := range spec.Env {
+			overridden[env.Key] = true
+		}
+		for _, env := range state.ProjectEnvVars {
+			if !overridden[env.Key] {
+				merged = append(merged, env)
+			}
+		}
+		for _, env := range spec.Env {
+			merged = append(merged, ProjectRuntimeEnvVar{Key: env.Key, Value: env.Value, IsSecret: env.Secret})
+		}
+		state.ProjectEnvVars = merged
+	}
+
+	state.ProjectFiles = mergeSpecProjectFiles(state.ProjectFiles, spec)
+
+	if len(spec.Features) > 0 {
+		features := map[string]map[string]interface{}{}
+		if base := strings.TrimSpace(cfg.AdditionalFeatures); base != "" {
+			if err := json.Unmarshal([]byte(base), &features); err != nil {
+				return fmt.Errorf("invalid ADDITIONAL_FEATURES: %w", err)
+			}
+		}
+		for id, opts := range spec.Features {
+			if opts == nil {
+				opts = map[string]interface{}{}
+			}
+			features[id] = opts
+		}
+		encoded, err := json.Marshal(features)
+		if err != nil {
+			return fmt.Errorf("failed to encode devcontainer features: %w", err)
+		}
+		cfg.AdditionalFeatures = string(encoded)
+	}
+
+	switch spec.Resources.Profile {
+	case provisionspec.ProfileLightweight:
+		state.Lightweight = true
+	case provisionspec.ProfileFull:
+		state.Lightweight = false
+	}
+	if name := strings.TrimSpace(spec.Resources.DevcontainerConfig); name != "" {
+		state.DevcontainerConfigName = name
+	}
+
+	if name := strings.TrimSpace(spec.Git.UserName); name != "" {
+		state.GitUserName = name
+	}
+	if email := strings.TrimSpace(spec.Git.UserEmail); email != "" {
+		state.GitUserEmail = email
+	}
+	if spec.Git.CommitTrailers != nil {
+		state.CommitTrailers = spec.Git.CommitTrailers
+	}
 	return nil
 }
 
@@ -303,19 +620,65 @@ func PrepareWorkspace(ctx context.Context, cfg *config.Config, state ProvisionSt
 	if cfg == nil {
 		return false, errors.New("config is required")
 	}
+	ctx, span := tracing.Start(ctx, "bootstrap.PrepareWorkspace", tracing.String("workspace.id", cfg.WorkspaceID))
+	ctx = execaudit.WithActor(ctx, cfg.WorkspaceID, execaudit.InitiatorBootstrap)
+	recoveryMode, err := prepareWorkspace(ctx, cfg, state, reporter)
+	span.SetAttributes(tracing.Bool("workspace.recovery_mode", recoveryMode))
+	span.End(err)
+	return recoveryMode, err
+}
+
+func prepareWorkspace(ctx context.Context, cfg *config.Config, state ProvisionState, reporter *bootlog.Reporter) (bool, error) {
+	if err := applyProvisionSpec(cfg, &state); err != nil {
+		return false, err
+	}
 
 	bootstrap := &bootstrapState{
-		WorkspaceID:   cfg.WorkspaceID,
-		CallbackToken: cfg.CallbackToken,
-		GitHubToken:   strings.TrimSpace(state.GitHubToken),
-		GitUserName:   strings.TrimSpace(state.GitUserName),
-		GitUserEmail:  strings.TrimSpace(state.GitUserEmail),
-		GitHubID:      strings.TrimSpace(state.GitHubID),
+		WorkspaceID:         cfg.WorkspaceID,
+		CallbackToken:       cfg.CallbackToken,
+		GitHubToken:         strings.TrimSpace(state.GitHubToken),
+		GitUserName:         strings.TrimSpace(state.GitUserName),
+		GitUserEmail:        strings.TrimSpace(state.GitUserEmail),
+		GitHubID:            strings.TrimSpace(state.GitHubID),
+		DeployKey:           strings.TrimSpace(state.DeployKey),
+		KnownHosts:          strings.TrimSpace(state.KnownHosts),
+		SigningKey:          strings.TrimSpace(state.SigningKey),
+		EnvironmentTemplate: state.EnvironmentTemplate,
+		ResourceLimits:      state.ResourceLimits,
+		EgressPolicy:        state.EgressPolicy,
+		RegistryCredentials: state.RegistryCredentials,
+		DevcontainerPolicy:  state.DevcontainerPolicy,
+		Hooks:               state.Hooks,
+	}
+	registerStateSecrets(bootstrap)
+	if bootstrap.DeployKey != "" {
+		if err := ValidateDeployKey(bootstrap.DeployKey); err != nil {
+			return false, err
+		}
+	}
+	if bootstrap.SigningKey != "" {
+		if err := ValidateSigningKey(bootstrap.SigningKey); err != nil {
+			return false, err
+		}
+	}
+	if err := state.ResourceLimits.Validate(); err != nil {
+		return false, fmt.Errorf("invalid resource limits: %w", err)
+	}
+	if err := state.EgressPolicy.Validate(); err != nil {
+		return false, fmt.Errorf("invalid egress policy: %w", err)
 	}
+	if err := state.RegistryCredentials.Validate(); err != nil {
+		return false, fmt.Errorf("invalid registry credentials: %w", err)
+	}
+	if err := state.DevcontainerPolicy.Validate(); err != nil {
+		return false, fmt.Errorf("invalid devcontainer policy: %w", err)
+	}
+	applyResourceLimitOverrides(cfg, state.ResourceLimits)
 	cfg.RepoProvider = strings.TrimSpace(state.RepoProvider)
 	cfg.CloneURL = strings.TrimSpace(state.CloneURL)
 	cfg.RepositoryHost = strings.TrimSpace(state.RepositoryHost)
 	cfg.RepositoryPath = strings.TrimSpace(state.RepositoryPath)
+	progress := loadProgress(cfg)
 
 	// Create a named Docker volume for container-mode workspaces.
 	volumeName := ""
@@ -328,14 +691,51 @@ func PrepareWorkspace(ctx context.Context, cfg *config.Config, state ProvisionSt
 			return false, volErr
 		}
 		reporter.Log("volume_create", "completed", "Workspace volume ready")
+
+		if snapshotURL := strings.TrimSpace(state.SnapshotURL); snapshotURL != "" {
+			reporter.Log("volume_restore", "started", "Restoring workspace volume from snapshot")
+			if err := resumeVolumeRestore(ctx, volumeName, progress); err != nil {
+				reporter.Log("volume_restore", "failed", "Snapshot restore failed", err.Error())
+				return false, fmt.Errorf("failed to clear interrupted snapshot restore: %w", err)
+			}
+			progress.begin(stepVolumeRestore)
+			restored, restoreErr := RestoreVolume(ctx, cfg.WorkspaceID, snapshotURL)
+			if restoreErr != nil {
+				reporter.Log("volume_restore", "failed", "Snapshot restore failed", restoreErr.Error())
+				return false, restoreErr
+			}
+			progress.complete(stepVolumeRestore)
+			msg := "Workspace volume restored from snapshot"
+			if !restored {
+				msg = "Workspace volume already has content, snapshot not applied"
+			}
+			reporter.Log("volume_restore", "completed", msg)
+		}
 	}
 
+	RunLifecycleHooks(ctx, cfg, bootstrap.Hooks, HookPreClone, reporter)
 	reporter.Log("git_clone", "started", "Cloning repository")
-	if err := ensureRepositoryReady(ctx, cfg, bootstrap, volumeName); err != nil {
+	if err := resumeClone(ctx, cfg, progress); err != nil {
+		reporter.Log("git_clone", "failed", "Repository clone failed", err.Error())
+		return false, fmt.Errorf("failed to remove interrupted clone: %w", err)
+	}
+	progress.begin(stepGitClone)
+	if err := ensureRepositoryReady(ctx, cfg, bootstrap, state.RepoCache); err != nil {
 		reporter.Log("git_clone", "failed", "Repository clone failed", err.Error())
 		return false, err
 	}
+	progress.complete(stepGitClone)
 	reporter.Log("git_clone", "completed", "Repository cloned")
+	RunLifecycleHooks(ctx, cfg, bootstrap.Hooks, HookPostClone, reporter)
+
+	if state.RebuildContainer {
+		reporter.Log("devcontainer_teardown", "started", "Removing existing devcontainer")
+		if err := teardownDevcontainer(ctx, cfg, volumeName); err != nil {
+			reporter.Log("devcontainer_teardown", "failed", "Devcontainer teardown failed", err.Error())
+			return false, err
+		}
+		reporter.Log("devcontainer_teardown", "completed", "Existing devcontainer removed")
+	}
 
 	repoHasDevcontainerConfig := hasDevcontainerConfig(cfg.WorkspaceDir)
 	effectiveWorkspaceProfile := ""
@@ -343,13 +743,16 @@ func PrepareWorkspace(ctx context.Context, cfg *config.Config, state ProvisionSt
 		effectiveWorkspaceProfile = "lightweight"
 	}
 
-	// Pre-generate credential helper on the VM host so it can be bind-mounted
-	// into the container during devcontainer lifecycle hooks.
-	credHelperHostPath, credErr := writeCredentialHelperToHost(cfg)
-	if credErr != nil {
-		slog.Warn("Failed to write credential helper to host (non-fatal)", "error", credErr)
-		reporter.Log("git_credential_helper", "failed", "Credential helper setup failed — git auth may be unavailable in lifecycle hooks", credErr.Error())
+	// Resolve devcontainer cache ref (best-effort, only for non-lightweight workspaces).
+	var cacheLogin func(context.Context) (string, error)
+	if cfg.DevcontainerCacheEnabled && !state.Lightweight && repoHasDevcontainerConfig {
+		cacheLogin = func(ctx context.Context) (string, error) {
+			return prepareDevcontainerCache(ctx, cfg, bootstrap.GitHubToken, state.DevcontainerConfigName)
+		}
 	}
+
+	setup, err := runParallelSetup(ctx, cfg, reporter, progress, volumeName, state.ForceResync, cacheLogin)
+	credHelperHostPath := setup.credHelperHostPath
 	prepareSucceeded := false
 	if credHelperHostPath != "" {
 		defer func() {
@@ -358,27 +761,27 @@ func PrepareWorkspace(ctx context.Context, cfg *config.Config, state ProvisionSt
 			}
 		}()
 	}
+	if err != nil {
+		return false, err
+	}
 
-	// Resolve devcontainer cache ref (best-effort, only for non-lightweight workspaces).
-	cacheRef := ""
-	if cfg.DevcontainerCacheEnabled && !state.Lightweight && repoHasDevcontainerConfig {
-		var cacheErr error
-		cacheRef, cacheErr = prepareDevcontainerCache(ctx, cfg, bootstrap.GitHubToken, state.DevcontainerConfigName)
-		if cacheErr != nil {
-			slog.Warn("Cache registry login failed (caching disabled for this build)", "registry", cfg.DevcontainerCacheRegistry, "error", cacheErr)
-			cacheRef = ""
-		}
-		if cacheRef != "" {
-			reporter.Log("devcontainer_cache", "started", "Checking devcontainer cache")
-		}
+	// Log in after the cache login so a workspace credential for the cache
+	// registry's host is the one left in the docker config for the build.
+	loginDevcontainerRegistries(ctx, cfg, bootstrap.RegistryCredentials, reporter)
+
+	cacheRef := setup.cacheRef
+	if cacheRef != "" {
+		reporter.Log("devcontainer_cache", "started", "Checking devcontainer cache")
 	}
 
 	var usedFallback bool
 	var recoveryMode bool
+	resumeDevcontainer(ctx, cfg, progress)
 	if state.Lightweight {
 		// Lightweight profile: skip devcontainer build entirely, use fallback image.
 		// This saves 30-120 seconds by avoiding the project's .devcontainer build.
 		reporter.Log("devcontainer_up", "started", "Starting lightweight container (skipping devcontainer build)")
+		progress.begin(stepDevcontainerUp)
 		slog.Info("Lightweight mode: forcing fallback image, skipping devcontainer build", "workspaceID", cfg.WorkspaceID)
 		var fallbackErr error
 		usedFallback, fallbackErr = ensureDevcontainerFallback(ctx, cfg, volumeName, credHelperHostPath)
@@ -386,17 +789,23 @@ func PrepareWorkspace(ctx context.Context, cfg *config.Config, state ProvisionSt
 			reporter.Log("devcontainer_up", "failed", "Lightweight container startup failed", fallbackErr.Error())
 			return false, fallbackErr
 		}
+		progress.complete(stepDevcontainerUp)
 		reporter.Log("devcontainer_up", "completed", "Lightweight container ready")
 	} else {
+		if err := ensureDevcontainerPolicy(ctx, cfg, bootstrap.DevcontainerPolicy, state.DevcontainerConfigName, reporter); err != nil {
+			return false, err
+		}
 		reporter.Log("devcontainer_up", "started", "Building devcontainer")
+		progress.begin(stepDevcontainerUp)
 		var devErr error
 		usedFallback, devErr = ensureDevcontainerReady(ctx, cfg, volumeName, credHelperHostPath, state.DevcontainerConfigName, cacheRef)
 		if devErr != nil {
 			reporter.Log("devcontainer_up", "failed", "Devcontainer build failed", devErr.Error())
 			return false, devErr
 		}
+		progress.complete(stepDevcontainerUp)
 		if usedFallback {
-			reporter.Log("devcontainer_up", "completed", "Devcontainer ready (fallback to default image)")
+			reporter.Log("devcontainer_up", "completed", "Devcontainer ready (fallback to default image)", buildLogDetail(uploadBuildErrorLog(ctx, cfg)))
 		} else {
 			reporter.Log("devcontainer_up", "completed", "Devcontainer ready")
 		}
@@ -414,6 +823,12 @@ func PrepareWorkspace(ctx context.Context, cfg *config.Config, state ProvisionSt
 	if containerID, findErr := findDevcontainerID(ctx, cfg); findErr == nil {
 		injectAptRetryConfig(ctx, containerID)
 		injectAptMirrorConfig(ctx, cfg, containerID)
+		if err := injectContainerTrustStore(ctx, cfg, containerID); err != nil {
+			slog.Warn("Failed to add CA bundle to devcontainer trust store (non-fatal)", "error", err)
+		}
+		if err := applyContainerResourceLimits(ctx, cfg, containerID); err != nil {
+			slog.Warn("Failed to apply devcontainer resource limits (non-fatal)", "error", err)
+		}
 	} else {
 		slog.Debug("Could not find devcontainer for apt config injection (non-fatal)", "error", findErr)
 	}
@@ -434,6 +849,27 @@ func PrepareWorkspace(ctx context.Context, cfg *config.Config, state ProvisionSt
 	}
 	reporter.Log("git_creds", "completed", "Git credentials configured")
 
+	if bootstrap.DeployKey != "" {
+		reporter.Log("git_ssh_key", "started", "Installing SSH deploy key")
+		if err := ensureDeployKey(ctx, cfg, bootstrap); err != nil {
+			reporter.Log("git_ssh_key", "failed", "SSH deploy key setup failed", err.Error())
+			return recoveryMode, err
+		}
+		reporter.Log("git_ssh_key", "completed", "SSH deploy key installed")
+	}
+
+	// Non-fatal: without LFS objects the checkout has pointer files, which
+	// the user can still pull by hand.
+	if repositoryUsesLFS(cfg.WorkspaceDir) {
+		reporter.Log("git_lfs", "started", "Pulling Git LFS objects")
+		if err := ensureGitLFS(ctx, cfg); err != nil {
+			reporter.Log("git_lfs", "failed", "Git LFS setup failed (non-fatal)", err.Error())
+			slog.Warn("Git LFS setup failed (non-fatal)", "error", err)
+		} else {
+			reporter.Log("git_lfs", "completed", "Git LFS objects pulled")
+		}
+	}
+
 	reporter.Log("git_identity", "started", "Configuring git identity")
 	if err := ensureGitIdentity(ctx, cfg, bootstrap); err != nil {
 		reporter.Log("git_identity", "failed", "Git identity setup failed", err.Error())
@@ -441,18 +877,64 @@ func PrepareWorkspace(ctx context.Context, cfg *config.Config, state ProvisionSt
 	}
 	reporter.Log("git_identity", "completed", "Git identity configured")
 
+	// Non-fatal: commits still work without trailers, just lose traceability.
+	reporter.Log("git_hooks", "started", "Installing commit trailer git hooks")
+	if err := ensureCommitTrailerHooks(ctx, cfg, state.CommitTrailers); err != nil {
+		reporter.Log("git_hooks", "failed", "Git hook setup failed (non-fatal)", err.Error())
+		slog.Warn("Git hook setup failed (non-fatal)", "error", err)
+	} else {
+		reporter.Log("git_hooks", "completed", "Commit trailer git hooks configured")
+	}
+
 	reporter.Log("sam_env", "started", "Configuring SAM environment")
-	if err := ensureSAMEnvironment(ctx, cfg, bootstrap.GitHubToken); err != nil {
+	if err := ensureSAMEnvironment(ctx, cfg); err != nil {
 		reporter.Log("sam_env", "failed", "SAM environment setup failed", err.Error())
 		slog.Warn("SAM environment setup failed (non-fatal)", "error", err)
 	} else {
 		reporter.Log("sam_env", "completed", "SAM environment configured")
 	}
 
+	// Non-fatal: the workspace works without the user's template, it just
+	// lacks their packages.
+	if bootstrap.EnvironmentTemplate.hasSetup() {
+		reporter.Log("env_template", "started", "Applying environment template")
+		if err := ensureEnvironmentTemplate(ctx, cfg, bootstrap.EnvironmentTemplate); err != nil {
+			reporter.Log("env_template", "failed", "Environment template failed (non-fatal)", err.Error())
+			slog.Warn("Environment template failed (non-fatal)", "error", err)
+		} else {
+			reporter.Log("env_template", "completed", "Environment template applied")
+		}
+	}
+
+	// Non-fatal, like the template. Runs last so install scripts can use the
+	// packages and shell the template set up.
+	if repo := resolveDotfilesRepository(cfg, bootstrap.EnvironmentTemplate); repo != "" {
+		reporter.Log("dotfiles", "started", "Installing dotfiles")
+		if err := ensureDotfiles(ctx, cfg, repo); err != nil {
+			reporter.Log("dotfiles", "failed", "Dotfiles install failed (non-fatal)", err.Error())
+			slog.Warn("Dotfiles install failed (non-fatal)", "error", err)
+		} else {
+			reporter.Log("dotfiles", "completed", "Dotfiles installed")
+		}
+	}
+
+	// Runs once the workspace is fully set up, so hooks can rely on git
+	// credentials, the SAM environment, and the user's template.
+	RunLifecycleHooks(ctx, cfg, bootstrap.Hooks, HookPostDevcontainerUp, reporter)
+
 	if err := ensureProjectRuntimeAssets(ctx, cfg, state.ProjectEnvVars, state.ProjectFiles); err != nil {
 		return recoveryMode, err
 	}
 
+	if bootstrap.EgressPolicy != nil {
+		reporter.Log("egress_policy", "started", "Applying network egress policy")
+		if err := ensureEgressPolicy(ctx, cfg, bootstrap.EgressPolicy); err != nil {
+			reporter.Log("egress_policy", "failed", "Egress policy failed", err.Error())
+			return recoveryMode, err
+		}
+		reporter.Log("egress_policy", "completed", "Network egress policy applied")
+	}
+
 	// Container is fully provisioned — keep the credential helper file even if
 	// markWorkspaceReady fails (CallbackError means workspace is running).
 	prepareSucceeded = true
@@ -469,6 +951,7 @@ func PrepareWorkspace(ctx context.Context, cfg *config.Config, state ProvisionSt
 		// a real provisioning failure and retry the callback later.
 		return recoveryMode, &CallbackError{Err: err, Status: readyStatus}
 	}
+	progress.complete(stepWorkspaceReady)
 	reporter.Log("workspace_ready", "completed", "Workspace is ready")
 
 	return recoveryMode, nil
@@ -508,9 +991,8 @@ func prepareDevcontainerCache(ctx context.Context, cfg *config.Config, githubTok
 func ensureVolumeReady(ctx context.Context, workspaceID string) (string, error) {
 	volumeName := VolumeNameForWorkspace(workspaceID)
 
-	// docker volume create is idempotent — returns the volume name if it already exists.
-	cmd := exec.CommandContext(ctx, "docker", "volume", "create", volumeName)
-	output, err := cmd.CombinedOutput()
+	// VolumeCreate is idempotent — it succeeds if the volume already exists.
+	output, err := containerruntime.Current().VolumeCreate(ctx, volumeName)
 	if err != nil {
 		return "", fmt.Errorf("failed to create Docker volume %s: %w: %s", volumeName, err, strings.TrimSpace(string(output)))
 	}
@@ -523,8 +1005,7 @@ func ensureVolumeReady(ctx context.Context, workspaceID string) (string, error)
 // even if the volume doesn't exist. Exported for use by workspace deletion.
 func RemoveVolume(ctx context.Context, workspaceID string) error {
 	volumeName := VolumeNameForWorkspace(workspaceID)
-	cmd := exec.CommandContext(ctx, "docker", "volume", "rm", "-f", volumeName)
-	output, err := cmd.CombinedOutput()
+	output, err := containerruntime.Current().VolumeRemove(ctx, volumeName)
 	if err != nil {
 		return fmt.Errorf("failed to remove Docker volume %s: %w: %s", volumeName, err, strings.TrimSpace(string(output)))
 	}
@@ -559,7 +1040,7 @@ func writeBuildErrorToHost(workspaceDir string, output []byte) error {
 // directory is not mounted into the fallback container, so errors written there
 // are invisible to users.
 func writeBuildErrorToVolume(ctx context.Context, volumeName string, output []byte) error {
-	cmd := exec.CommandContext(ctx, "docker", "run", "--rm",
+	cmd := containerruntime.Command(ctx, "run", "--rm",
 		"-v", volumeName+":/workspaces",
 		"-i", "alpine:latest",
 		"sh", "-c", "cat > /workspaces/"+buildErrorLogFilename,
@@ -572,6 +1053,7 @@ func writeBuildErrorToVolume(ctx context.Context, volumeName string, output []by
 }
 
 func persistBuildErrorArtifacts(ctx context.Context, cfg *config.Config, volumeName string, output []byte) error {
+	output = redact.Bytes(output)
 	if err := writeBuildErrorToHost(cfg.WorkspaceDir, output); err != nil {
 		return err
 	}
@@ -593,7 +1075,7 @@ func clearBuildErrorArtifacts(ctx context.Context, cfg *config.Config, volumeNam
 		return
 	}
 
-	cmd := exec.CommandContext(ctx, "docker", "run", "--rm",
+	cmd := containerruntime.Command(ctx, "run", "--rm",
 		"-v", volumeName+":/workspaces",
 		"alpine:latest",
 		"rm", "-f", "/workspaces/"+buildErrorLogFilename,
@@ -608,10 +1090,7 @@ func ensureVolumeWritable(ctx context.Context, volumeName string) error {
 		return nil
 	}
 
-	cmd := exec.CommandContext(
-		ctx,
-		"docker",
-		"run",
+	cmd := containerruntime.Command(ctx, "run",
 		"--rm",
 		"-v", volumeName+":/workspaces",
 		"alpine:latest",
@@ -625,10 +1104,102 @@ func ensureVolumeWritable(ctx context.Context, volumeName string) error {
 	return nil
 }
 
+// parallelSetupResult is what runParallelSetup prefetched for the
+// devcontainer build.
+type parallelSetupResult struct {
+	credHelperHostPath string // "" when the host-side credential helper could not be written
+	cacheRef           string // "" when devcontainer caching is off or the registry login failed
+}
+
+// runParallelSetup runs the steps between the clone and the devcontainer
+// build that don't depend on each other concurrently:
+//   - syncing the host clone into the workspace volume (container mode),
+//     unconditionally when forceResync is set or the checkpoint shows an
+//     earlier sync was interrupted,
+//   - waiting for cloud-init to install the devcontainer CLI, skipped when
+//     the devcontainer is already running,
+//   - prefetching credentials: the host-side git credential helper that is
+//     bind-mounted into the container for lifecycle hooks, and the cache
+//     registry login when cacheLogin is set.
+//
+// Credential prefetch failures are non-fatal. The first volume or CLI error
+// cancels the other steps and is returned; the result is still filled in as
+// far as it got, so the caller can clean up the credential helper.
+func runParallelSetup(ctx context.Context, cfg *config.Config, reporter *bootlog.Reporter, p *progress, volumeName string, forceResync bool, cacheLogin func(context.Context) (string, error)) (parallelSetupResult, error) {
+	var result parallelSetupResult
+	g, gctx := errgroup.WithContext(ctx)
+
+	if volumeName != "" && cfg.Repository != "" {
+		// The sync script treats a volume that has the repository as in
+		// sync, so a half-populated volume must be synced unconditionally.
+		forceResync = forceResync || p.interrupted(stepVolumePopulate)
+		g.Go(func() error {
+			reporter.Log("volume_populate", "started", "Syncing repository into workspace volume")
+			p.begin(stepVolumePopulate)
+			if err := populateWorkspaceVolume(gctx, cfg, volumeName, forceResync); err != nil {
+				reporter.Log("volume_populate", "failed", "Volume sync failed", err.Error())
+				return err
+			}
+			p.complete(stepVolumePopulate)
+			reporter.Log("volume_populate", "completed", "Workspace volume synced")
+			return nil
+		})
+	}
+
+	if _, err := findDevcontainerID(ctx, cfg); err != nil {
+		g.Go(func() error {
+			reporter.Log("devcontainer_wait", "started", "Waiting for devcontainer CLI")
+			if err := waitForCommand(gctx, "devcontainer"); err != nil {
+				reporter.Log("devcontainer_wait", "failed", "Devcontainer CLI not available", err.Error())
+				return fmt.Errorf("devcontainer CLI never became available: %w", err)
+			}
+			reporter.Log("devcontainer_wait", "completed", "Devcontainer CLI available")
+			return nil
+		})
+	}
+
+	g.Go(func() error {
+		hostPath, err := writeCredentialHelperToHost(cfg)
+		if err != nil {
+			slog.Warn("Failed to write credential helper to host (non-fatal)", "error", err)
+			reporter.Log("git_credential_helper", "failed", "Credential helper setup failed — git auth may be unavailable in lifecycle hooks", err.Error())
+		}
+		result.credHelperHostPath = hostPath
+		return nil
+	})
+
+	if cacheLogin != nil {
+		g.Go(func() error {
+			ref, err := cacheLogin(gctx)
+			if err != nil {
+				slog.Warn("Cache registry login failed (caching disabled for this build)", "registry", cfg.DevcontainerCacheRegistry, "error", err)
+				return nil
+			}
+			result.cacheRef = ref
+			return nil
+		})
+	}
+
+	err := g.Wait()
+	return result, err
+}
+
+// populateWorkspaceVolume syncs the host clone into the workspace volume. The
+// host clone stays for devcontainer CLI config discovery; the volume copy is
+// what the container actually uses at runtime (no bind-mount permission
+// issues).
+func populateWorkspaceVolume(ctx context.Context, cfg *config.Config, volumeName string, force bool) error {
+	repoDirName := config.DeriveRepoDirName(cfg.Repository)
+	if repoDirName == "" {
+		repoDirName = "workspace"
+	}
+	return syncVolumeFromHost(ctx, cfg.WorkspaceDir, volumeName, repoDirName, force)
+}
+
 // populateVolumeFromHost copies the host-cloned repository into a Docker named
-// volume using a lightweight throwaway container. The host clone is needed for
-// devcontainer CLI config discovery (it reads .devcontainer/ from the host), while
-// the volume copy is what the container actually uses at runtime.
+// volume using a lightweight throwaway container, unless the volume already has
+// it. It is the fallback of syncVolumeFromHost when the rsync helper image is
+// unavailable.
 func populateVolumeFromHost(ctx context.Context, hostPath, volumeName, repoDirName string) error {
 	targetPath := "/workspaces/" + repoDirName
 
@@ -639,7 +1210,7 @@ func populateVolumeFromHost(ctx context.Context, hostPath, volumeName, repoDirNa
 		"alpine:latest",
 		"test", "-d", targetPath + "/.git",
 	}
-	checkCmd := exec.CommandContext(ctx, "docker", checkArgs...)
+	checkCmd := containerruntime.Command(ctx, checkArgs...)
 	if err := checkCmd.Run(); err == nil {
 		slog.Info("Volume already has repository, skipping populate", "volumeName", volumeName, "targetPath", targetPath)
 		return ensureVolumeWritable(ctx, volumeName)
@@ -652,10 +1223,10 @@ func populateVolumeFromHost(ctx context.Context, hostPath, volumeName, repoDirNa
 		"-v", hostPath + ":/src:ro",
 		"-v", volumeName + ":/workspaces",
 		"alpine:latest",
-		"sh", "-c", fmt.Sprintf("cp -a /src %s", targetPath),
+		"cp", "-a", "/src", targetPath,
 	}
 	slog.Info("Populating volume from host clone", "volumeName", volumeName, "targetPath", targetPath, "hostPath", hostPath)
-	cmd := exec.CommandContext(ctx, "docker", copyArgs...)
+	cmd := containerruntime.Command(ctx, copyArgs...)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("failed to populate volume from host clone: %w: %s", err, strings.TrimSpace(string(output)))
@@ -712,6 +1283,10 @@ func redeemBootstrapTokenWithRetry(ctx context.Context, cfg *config.Config) (*bo
 }
 
 func redeemBootstrapToken(ctx context.Context, cfg *config.Config) (*bootstrapState, bool, error) {
+	if err := faultinject.Check(faultinject.BootstrapRedeem); err != nil {
+		return nil, true, fmt.Errorf("bootstrap endpoint returned HTTP %d: %w", http.StatusInternalServerError, err)
+	}
+
 	endpoint := fmt.Sprintf("%s/api/bootstrap/%s", strings.TrimRight(cfg.ControlPlaneURL, "/"), cfg.BootstrapToken)
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, nil)
 	if err != nil {
@@ -725,7 +1300,8 @@ func redeemBootstrapToken(ctx context.Context, cfg *config.Config) (*bootstrapSt
 	}
 	defer res.Body.Close()
 
-	body, err := io.ReadAll(io.LimitReader(res.Body, 8*1024))
+	// Room for inline lifecycle hook scripts and an environment template.
+	body, err := io.ReadAll(io.LimitReader(res.Body, 512*1024))
 	if err != nil {
 		return nil, true, fmt.Errorf("bootstrap: read response body: %w", err)
 	}
@@ -766,18 +1342,100 @@ func redeemBootstrapToken(ctx context.Context, cfg *config.Config) (*bootstrapSt
 	if payload.GitHubID != nil {
 		githubID = *payload.GitHubID
 	}
+	provider := ""
+	if payload.Provider != nil {
+		provider = strings.ToLower(strings.TrimSpace(*payload.Provider))
+	}
+	if !gitprovider.Known(provider) {
+		return nil, false, fmt.Errorf("bootstrap response has unsupported git provider %q", provider)
+	}
+	if payload.GitToken != nil && strings.TrimSpace(*payload.GitToken) != "" {
+		githubToken = *payload.GitToken
+	}
+	repositoryHost := ""
+	if payload.RepositoryHost != nil {
+		repositoryHost = *payload.RepositoryHost
+	}
+	repositoryPath := ""
+	if payload.RepositoryPath != nil {
+		repositoryPath = *payload.RepositoryPath
+	}
+	deployKey := ""
+	if payload.DeployKey != nil {
+		deployKey = strings.TrimSpace(*payload.DeployKey)
+	}
+	if deployKey != "" {
+		if err := ValidateDeployKey(deployKey); err != nil {
+			return nil, false, fmt.Errorf("bootstrap response: %w", err)
+		}
+	}
+	knownHosts := ""
+	if payload.KnownHosts != nil {
+		knownHosts = strings.TrimSpace(*payload.KnownHosts)
+	}
+	signingKey := ""
+	if payload.SigningKey != nil {
+		signingKey = strings.TrimSpace(*payload.SigningKey)
+	}
+	if signingKey != "" {
+		if err := ValidateSigningKey(signingKey); err != nil {
+			return nil, false, fmt.Errorf("bootstrap response: %w", err)
+		}
+	}
+	if err := payload.ResourceLimits.Validate(); err != nil {
+		return nil, false, fmt.Errorf("bootstrap response: invalid resource limits: %w", err)
+	}
+	if err := payload.EgressPolicy.Validate(); err != nil {
+		return nil, false, fmt.Errorf("bootstrap response: invalid egress policy: %w", err)
+	}
+	if err := payload.RegistryCredentials.Validate(); err != nil {
+		return nil, false, fmt.Errorf("bootstrap response: invalid registry credentials: %w", err)
+	}
+	if err := payload.DevcontainerPolicy.Validate(); err != nil {
+		return nil, false, fmt.Errorf("bootstrap response: invalid devcontainer policy: %w", err)
+	}
 
 	return &bootstrapState{
-		WorkspaceID:   payload.WorkspaceID,
-		CallbackToken: payload.CallbackToken,
-		GitHubToken:   githubToken,
-		GitUserName:   strings.TrimSpace(gitUserName),
-		GitUserEmail:  strings.TrimSpace(gitUserEmail),
-		GitHubID:      strings.TrimSpace(githubID),
+		WorkspaceID:         payload.WorkspaceID,
+		CallbackToken:       payload.CallbackToken,
+		GitHubToken:         githubToken,
+		GitUserName:         strings.TrimSpace(gitUserName),
+		GitUserEmail:        strings.TrimSpace(gitUserEmail),
+		GitHubID:            strings.TrimSpace(githubID),
+		Provider:            provider,
+		RepositoryHost:      strings.ToLower(strings.TrimSpace(repositoryHost)),
+		RepositoryPath:      strings.TrimSpace(repositoryPath),
+		DeployKey:           deployKey,
+		KnownHosts:          knownHosts,
+		SigningKey:          signingKey,
+		EnvironmentTemplate: payload.EnvironmentTemplate,
+		ResourceLimits:      payload.ResourceLimits,
+		EgressPolicy:        payload.EgressPolicy,
+		RegistryCredentials: payload.RegistryCredentials,
+		DevcontainerPolicy:  payload.DevcontainerPolicy,
+		Hooks:               payload.Hooks,
 	}, false, nil
 }
 
-func ensureRepositoryReady(ctx context.Context, cfg *config.Config, state *bootstrapState, volumeName string) error {
+// applyBootstrapProvider copies the provider binding from redeemed bootstrap
+// state into cfg. Values already set in the environment win, so an operator
+// can pin a provider or host on the VM.
+func applyBootstrapProvider(cfg *config.Config, state *bootstrapState) {
+	if cfg == nil || state == nil {
+		return
+	}
+	if cfg.RepoProvider == "" {
+		cfg.RepoProvider = state.Provider
+	}
+	if cfg.RepositoryHost == "" {
+		cfg.RepositoryHost = state.RepositoryHost
+	}
+	if cfg.RepositoryPath == "" {
+		cfg.RepositoryPath = state.RepositoryPath
+	}
+}
+
+func ensureRepositoryReady(ctx context.Context, cfg *config.Config, state *bootstrapState, mirrors *repocache.Cache) error {
 	if cfg.Repository == "" {
 		slog.Info("Repository is empty, skipping clone step")
 		return nil
@@ -788,20 +1446,34 @@ func ensureRepositoryReady(ctx context.Context, cfg *config.Config, state *boots
 		branch = "main"
 	}
 
-	repoURL := normalizeRepoURL(firstNonEmptyString(cfg.CloneURL, cfg.Repository))
+	repoURL := normalizeRepoURLForConfig(cfg)
 	cloneToken := ""
 	if state != nil {
 		cloneToken = state.GitHubToken
 	}
 
-	cloneURL, err := withGitToken(repoURL, cloneToken, cfg)
-	if err != nil {
-		return fmt.Errorf("failed to prepare clone URL: %w", err)
-	}
-
-	repoDirName := config.DeriveRepoDirName(cfg.Repository)
-	if repoDirName == "" {
-		repoDirName = "workspace"
+	// SSH remotes clone with the deploy key instead of an embedded token.
+	// They skip the mirror cache, whose fetches only know HTTPS credentials.
+	cloneEnv := []string{lfsSkipSmudgeEnv}
+	cloneURL := repoURL
+	if isSSHRepoURL(repoURL) {
+		mirrors = nil
+		if state != nil && state.DeployKey != "" {
+			sshCommand, cleanup, err := writeDeployKeyToHost(state.DeployKey, state.KnownHosts)
+			if err != nil {
+				return err
+			}
+			defer cleanup()
+			cloneEnv = append(cloneEnv, "GIT_SSH_COMMAND="+sshCommand)
+		} else {
+			slog.Warn("SSH repository without a deploy key; relying on the host's SSH configuration", "repository", repoURL)
+		}
+	} else {
+		var err error
+		cloneURL, err = withGitToken(repoURL, cloneToken, cfg)
+		if err != nil {
+			return fmt.Errorf("failed to prepare clone URL: %w", err)
+		}
 	}
 
 	// Always clone to the host filesystem. The devcontainer CLI needs the project
@@ -818,16 +1490,29 @@ func ensureRepositoryReady(ctx context.Context, cfg *config.Config, state *boots
 			return fmt.Errorf("failed to clean workspace directory: %w", err)
 		}
 
-		slog.Info("Cloning repository", "repository", cfg.Repository, "branch", branch, "workspaceDir", cfg.WorkspaceDir)
-		cmd := exec.CommandContext(ctx, "git", "clone", "--branch", branch, cloneURL, cfg.WorkspaceDir)
-		output, err := cmd.CombinedOutput()
+		opts := cloneOptionsFromConfig(cfg)
+		slog.Info("Cloning repository", "repository", cfg.Repository, "branch", branch, "workspaceDir", cfg.WorkspaceDir,
+			"depth", opts.depth, "singleBranch", opts.singleBranch, "sparsePaths", opts.sparsePaths)
+		err := retry.Do(ctx, cfg.CloneRetry.OrDefault(config.DefaultCloneRetry), "git-clone", func(ctx context.Context) error {
+			err := cloneRepository(ctx, mirrors, repoURL, cloneURL, branch, cfg.WorkspaceDir, cloneToken, cloneEnv, opts)
+			if err == nil {
+				return nil
+			}
+			if rmErr := os.RemoveAll(cfg.WorkspaceDir); rmErr != nil {
+				return retry.Permanent(fmt.Errorf("failed to clean workspace directory after clone failure: %w", rmErr))
+			}
+			if !isTransientError(err.Error()) {
+				return retry.Permanent(err)
+			}
+			return err
+		})
 		if err != nil {
-			return fmt.Errorf("git clone failed: %w: %s", err, redactSecret(strings.TrimSpace(string(output)), cloneToken))
+			return err
 		}
 
 		// Persist origin without embedded credentials.
-		cmd = exec.CommandContext(ctx, "git", "-C", cfg.WorkspaceDir, "remote", "set-url", "origin", repoURL)
-		output, err = cmd.CombinedOutput()
+		cmd := exec.CommandContext(ctx, "git", "-C", cfg.WorkspaceDir, "remote", "set-url", "origin", repoURL)
+		output, err := cmd.CombinedOutput()
 		if err != nil {
 			return fmt.Errorf("failed to sanitize repository origin URL: %w: %s", err, strings.TrimSpace(string(output)))
 		}
@@ -838,13 +1523,109 @@ func ensureRepositoryReady(ctx context.Context, cfg *config.Config, state *boots
 		initSubmodules(ctx, cfg.WorkspaceDir, cloneToken)
 	}
 
-	// When using a Docker volume, populate it from the host clone. The host clone
-	// stays for devcontainer CLI config discovery; the volume copy is what the
-	// container actually uses at runtime (no bind-mount permission issues).
-	if volumeName != "" {
-		return populateVolumeFromHost(ctx, cfg.WorkspaceDir, volumeName, repoDirName)
+	return nil
+}
+
+// cloneOptions narrows a clone for large repositories.
+type cloneOptions struct {
+	depth        int
+	singleBranch bool
+	sparsePaths  []string
+}
+
+// sparseCheckoutAlwaysIncluded keeps devcontainer configs on disk, since the
+// devcontainer CLI discovers them from the host clone.
+const sparseCheckoutAlwaysIncluded = ".devcontainer"
+
+func cloneOptionsFromConfig(cfg *config.Config) cloneOptions {
+	opts := cloneOptions{depth: cfg.CloneDepth, singleBranch: cfg.SingleBranch}
+	if len(cfg.SparseCheckoutPaths) > 0 {
+		opts.sparsePaths = append([]string{sparseCheckoutAlwaysIncluded}, cfg.SparseCheckoutPaths...)
 	}
+	return opts
+}
 
+// partial reports whether the clone omits history or files. Partial clones
+// skip the mirror cache: they are already cheap, and --dissociate would copy
+// the mirror's full object store into them.
+func (o cloneOptions) partial() bool {
+	return o.depth > 0 || len(o.sparsePaths) > 0
+}
+
+// args returns the git clone flags. Sparse clones are also blobless, so only
+// blobs inside the checked-out directories are downloaded.
+func (o cloneOptions) args() []string {
+	var args []string
+	if o.depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(o.depth))
+	}
+	if o.singleBranch {
+		args = append(args, "--single-branch")
+	}
+	if len(o.sparsePaths) > 0 {
+		args = append(args, "--filter=blob:none", "--sparse")
+	}
+	return args
+}
+
+// cloneRepository clones branch into workspaceDir. With a mirror cache, the
+// clone uses the repository's mirror as a --dissociate reference so most
+// objects are copied locally; any mirror failure falls back to a plain clone
+// so the cache can only speed up provisioning, never block it.
+func cloneRepository(ctx context.Context, mirrors *repocache.Cache, repoURL, cloneURL, branch, workspaceDir, token string, env []string, opts cloneOptions) (err error) {
+	if opts.partial() {
+		mirrors = nil
+	}
+	ctx, span := tracing.Start(ctx, "git.clone",
+		tracing.String("git.branch", branch),
+		tracing.Bool("git.mirror_cache", mirrors != nil),
+		tracing.Bool("git.partial", opts.partial()),
+	)
+	defer func() { span.End(err) }()
+	if mirrors != nil {
+		mirror, release, err := mirrors.Acquire(ctx, repoURL, cloneURL)
+		if err == nil {
+			cmd := exec.CommandContext(ctx, "git", "clone", "--reference", mirror, "--dissociate", "--branch", branch, cloneURL, workspaceDir)
+			if len(env) > 0 {
+				cmd.Env = append(os.Environ(), env...)
+			}
+			output, cloneErr := cmd.CombinedOutput()
+			release()
+			if cloneErr == nil {
+				slog.Info("Cloned repository from mirror cache", "repository", repoURL, "mirror", mirror)
+				return nil
+			}
+			err = fmt.Errorf("%w: %s", cloneErr, redactSecret(strings.TrimSpace(string(output)), token))
+			if rmErr := os.RemoveAll(workspaceDir); rmErr != nil {
+				return fmt.Errorf("failed to clean workspace directory after mirror clone failure: %w", rmErr)
+			}
+		}
+		slog.Warn("Mirror-assisted clone failed, cloning from origin", "repository", repoURL, "error", redactSecret(err.Error(), token))
+	}
+
+	args := append([]string{"clone"}, opts.args()...)
+	args = append(args, "--branch", branch, cloneURL, workspaceDir)
+	cmd := exec.CommandContext(ctx, "git", args...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git clone failed: %w: %s", err, redactSecret(strings.TrimSpace(string(output)), token))
+	}
+
+	if len(opts.sparsePaths) > 0 {
+		// Checking out the selected directories fetches their blobs from
+		// origin, which still carries the token at this point.
+		args := append([]string{"-C", workspaceDir, "sparse-checkout", "set"}, opts.sparsePaths...)
+		cmd := exec.CommandContext(ctx, "git", args...)
+		if len(env) > 0 {
+			cmd.Env = append(os.Environ(), env...)
+		}
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("git sparse-checkout failed: %w: %s", err, redactSecret(strings.TrimSpace(string(output)), token))
+		}
+	}
 	return nil
 }
 
@@ -902,7 +1683,10 @@ func ensureDevcontainerFallback(ctx context.Context, cfg *config.Config, volumeN
 		return false, nil
 	}
 
-	if err := waitForCommand(ctx, "devcontainer"); err != nil {
+	_, waitSpan := tracing.Start(ctx, "devcontainer.wait_cli")
+	err := waitForCommand(ctx, "devcontainer")
+	waitSpan.End(err)
+	if err != nil {
 		return false, fmt.Errorf("devcontainer CLI never became available: %w", err)
 	}
 
@@ -932,7 +1716,23 @@ func ensureDevcontainerFallback(ctx context.Context, cfg *config.Config, volumeN
 // cacheRef is an optional container image reference for cache-from. When non-empty,
 // it is injected into override configs as a cacheFrom source and the built image
 // is pushed to this ref asynchronously after a successful build.
+//
+// When cfg.DevcontainerPrebuildRef is set, the devcontainer is started from that
+// prebuilt image instead (see startPrebuiltDevcontainer), falling back to the
+// regular build when the prebuild path fails.
 func ensureDevcontainerReady(ctx context.Context, cfg *config.Config, volumeName, credHelperHostPath, devcontainerConfigName, cacheRef string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "bootstrap.ensureDevcontainerReady",
+		tracing.String("workspace.id", cfg.WorkspaceID),
+		tracing.Bool("devcontainer.cache", cacheRef != ""),
+		tracing.Bool("devcontainer.prebuild", cfg.DevcontainerPrebuildRef != ""),
+	)
+	usedFallback, err := startDevcontainer(ctx, cfg, volumeName, credHelperHostPath, devcontainerConfigName, cacheRef)
+	span.SetAttributes(tracing.Bool("devcontainer.used_fallback", usedFallback))
+	span.End(err)
+	return usedFallback, err
+}
+
+func startDevcontainer(ctx context.Context, cfg *config.Config, volumeName, credHelperHostPath, devcontainerConfigName, cacheRef string) (bool, error) {
 	if _, err := findDevcontainerID(ctx, cfg); err == nil {
 		slog.Info("Devcontainer already running", "labelKey", cfg.ContainerLabelKey, "labelValue", cfg.ContainerLabelValue)
 		ensureContainerUserResolved(ctx, cfg, devcontainerConfigName)
@@ -955,17 +1755,40 @@ func ensureDevcontainerReady(ctx context.Context, cfg *config.Config, volumeName
 	// Wait for devcontainer CLI to be available. Cloud-init installs Node.js and
 	// devcontainer CLI asynchronously AFTER the VM Agent starts — there is a race
 	// where the agent tries to run "devcontainer up" before the CLI exists.
-	if err := waitForCommand(ctx, "devcontainer"); err != nil {
+	_, waitSpan := tracing.Start(ctx, "devcontainer.wait_cli")
+	err := waitForCommand(ctx, "devcontainer")
+	waitSpan.End(err)
+	if err != nil {
 		return false, fmt.Errorf("devcontainer CLI never became available: %w", err)
 	}
 
 	slog.Info("Starting devcontainer for workspace", "workspaceDir", cfg.WorkspaceDir)
 
+	// Prebuild mode: start from the registry image when the control plane
+	// supplied one, skipping the build and feature installs.
+	if cfg.DevcontainerPrebuildRef != "" && volumeName != "" && hasDevcontainerConfig(cfg.WorkspaceDir) {
+		prebuildCtx, prebuildSpan := tracing.Start(ctx, "devcontainer.prebuild_start")
+		started := startPrebuiltDevcontainer(prebuildCtx, cfg, volumeName, credHelperHostPath, devcontainerConfigName)
+		prebuildSpan.SetAttributes(tracing.Bool("devcontainer.prebuild_started", started))
+		prebuildSpan.End(nil)
+		if started {
+			clearBuildErrorArtifacts(ctx, cfg, volumeName)
+			ensureContainerUserResolved(ctx, cfg, devcontainerConfigName)
+			if err := ensureWorkspaceOwnership(ctx, cfg); err != nil {
+				return false, err
+			}
+			return false, nil
+		}
+	}
+
 	// Best-effort cache pull: try to pull the cached image so Docker can use
 	// its layers during the build. Failures are non-fatal.
 	cacheImagePulled := false
 	if cacheRef != "" {
-		if pullErr := cache.PullCacheImage(ctx, cacheRef); pullErr != nil {
+		pullCtx, pullSpan := tracing.Start(ctx, "devcontainer.image_pull", tracing.String("image.ref", cacheRef))
+		pullErr := cache.PullCacheImage(pullCtx, cacheRef)
+		pullSpan.End(pullErr)
+		if pullErr != nil {
 			slog.Info("No cache image available (building from scratch)", "ref", cacheRef, "reason", pullErr)
 		} else {
 			slog.Info("Cache hit: pulled devcontainer cache image", "ref", cacheRef)
@@ -1031,13 +1854,32 @@ func ensureDevcontainerReady(ctx context.Context, cfg *config.Config, volumeName
 				slog.Info("Repo has its own devcontainer config, skipping additional-features injection")
 			}
 
-			buildCtx, buildCancel := devcontainerBuildContext(ctx, cfg)
-			cmd := exec.CommandContext(buildCtx, "devcontainer", args...)
-			output, err := cmd.CombinedOutput()
-			buildCancel() // Release timer immediately; fallback uses parent ctx.
+			// Transient registry and network failures are retried; any other
+			// failure goes straight to the fallback image.
+			var output []byte
+			timedOut := false
+			err := retry.Do(ctx, cfg.DevcontainerRetry.OrDefault(config.DefaultDevcontainerRetry), "devcontainer-up", func(ctx context.Context) error {
+				buildCtx, buildCancel := devcontainerBuildContext(ctx, cfg)
+				defer buildCancel() // Release timer immediately; fallback uses parent ctx.
+				// Covers the image build and feature installs done by `devcontainer up`.
+				buildCtx, buildSpan := tracing.Start(buildCtx, "devcontainer.up", tracing.Bool("devcontainer.cache_from", effectiveCacheRef != ""))
+				var err error
+				output, err = devcontainerCommand(buildCtx, args...).CombinedOutput()
+				buildSpan.End(err)
+				timedOut = buildCtx.Err() == context.DeadlineExceeded
+				if err != nil && (timedOut || !isTransientError(string(output))) {
+					return retry.Permanent(err)
+				}
+				if err != nil {
+					// A failure in a lifecycle command leaves a container behind;
+					// without removing it the next attempt would adopt it.
+					removeStaleContainers(ctx, cfg)
+				}
+				return err
+			})
 			if err != nil {
 				// Repo config failed — log the error and fall back to default image.
-				slog.Warn("Devcontainer build failed with repo config, falling back to default image", "error", err, "output", strings.TrimSpace(string(output)), "timedOut", buildCtx.Err() == context.DeadlineExceeded)
+				slog.Warn("Devcontainer build failed with repo config, falling back to default image", "error", err, "output", strings.TrimSpace(string(output)), "timedOut", timedOut, "transient", isTransientError(string(output)))
 				var fallbackErr error
 				usedFallback, fallbackErr = fallbackToDefaultDevcontainer(ctx, cfg, volumeName, credHelperHostPath, err, output)
 				if fallbackErr != nil {
@@ -1051,7 +1893,9 @@ func ensureDevcontainerReady(ctx context.Context, cfg *config.Config, volumeName
 		// devcontainer Features and the slower build path entirely.
 		slog.Info("No repo devcontainer config found; using lightweight default image", "workspaceDir", cfg.WorkspaceDir)
 		buildCtx, buildCancel := devcontainerBuildContext(ctx, cfg)
+		buildCtx, buildSpan := tracing.Start(buildCtx, "devcontainer.lightweight_up")
 		_, err := runLightweightDevcontainerWithDefault(buildCtx, cfg, volumeName, credHelperHostPath)
+		buildSpan.End(err)
 		buildCancel()
 		if err != nil {
 			return false, err
@@ -1100,10 +1944,9 @@ func devcontainerBuildContext(parent context.Context, cfg *config.Config) (conte
 // Non-fatal: if injection fails, apt will use default settings (no retries).
 func injectAptRetryConfig(ctx context.Context, containerID string) {
 	retryScript := `mkdir -p /etc/apt/apt.conf.d && printf 'Acquire::Retries "3";\nAcquire::http::Timeout "30";\nAcquire::https::Timeout "30";\n' > /etc/apt/apt.conf.d/80-retries`
-	cmd := exec.CommandContext(ctx, "docker", "exec", "-u", "root", containerID, "sh", "-c", retryScript)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		slog.Warn("Failed to inject apt retry config into container (non-fatal)", "error", err, "output", strings.TrimSpace(string(output)))
+	op := execaudit.Begin(ctx, containerID, "apt.retry_config", "/etc/apt/apt.conf.d/80-retries")
+	if _, err := containerruntime.Current().Exec(ctx, containerID, rootExec, "sh", "-c", retryScript); op.End(err) != nil {
+		slog.Warn("Failed to inject apt retry config into container (non-fatal)", "error", err)
 		return
 	}
 	slog.Info("Injected apt retry config into container", "containerID", containerID)
@@ -1128,19 +1971,19 @@ func injectAptMirrorConfig(ctx context.Context, cfg *config.Config, containerID
 		return
 	}
 
-	// Uses exec.Command with containerID as a direct argument (not shell-interpolated)
-	// to prevent any injection via containerID.
-	cmd := exec.CommandContext(ctx, "/usr/bin/docker", "exec", "-u", "root", containerID, "sh", "-c", buildAptMirrorScript(mirror))
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		slog.Warn("Failed to inject apt mirror config into container (non-fatal)", "error", err, "output", strings.TrimSpace(string(output)), "provider", cfg.Provider)
+	// The mirror is passed as an argument, not interpolated into the script.
+	op := execaudit.Begin(ctx, containerID, "apt.mirror_config", mirror)
+	if _, err := containerruntime.Current().Exec(ctx, containerID, rootExec, "sh", "-c", aptMirrorScript, "sh", mirror); op.End(err) != nil {
+		slog.Warn("Failed to inject apt mirror config into container (non-fatal)", "error", err, "provider", cfg.Provider)
 		return
 	}
 	slog.Info("Injected apt mirror config into container", "provider", cfg.Provider, "containerID", containerID, "mirror", mirror)
 }
 
-func buildAptMirrorScript(mirror string) string {
-	return fmt.Sprintf(`set -eu
+// aptMirrorScript points apt at the mirror host $1 and checks that apt-get
+// update works against it, restoring the original sources if it does not.
+const aptMirrorScript = `set -eu
+mirror="$1"
 tmp="$(mktemp -d /tmp/sam-apt-mirror.XXXXXX)"
 log="$tmp/apt-update.log"
 lists="$tmp/lists"
@@ -1152,16 +1995,15 @@ restore() {
 }
 [ -f /etc/apt/sources.list ] && cp /etc/apt/sources.list "$tmp/sources.list" || true
 [ -f /etc/apt/sources.list.d/ubuntu.sources ] && cp /etc/apt/sources.list.d/ubuntu.sources "$tmp/ubuntu.sources" || true
-[ -f /etc/apt/sources.list ] && sed -i 's|http://archive.ubuntu.com|http://%[1]s|g; s|http://security.ubuntu.com|http://%[1]s|g' /etc/apt/sources.list || true
-[ -f /etc/apt/sources.list.d/ubuntu.sources ] && sed -i 's|http://archive.ubuntu.com|http://%[1]s|g; s|http://security.ubuntu.com|http://%[1]s|g' /etc/apt/sources.list.d/ubuntu.sources || true
+[ -f /etc/apt/sources.list ] && sed -i "s|http://archive.ubuntu.com|http://$mirror|g; s|http://security.ubuntu.com|http://$mirror|g" /etc/apt/sources.list || true
+[ -f /etc/apt/sources.list.d/ubuntu.sources ] && sed -i "s|http://archive.ubuntu.com|http://$mirror|g; s|http://security.ubuntu.com|http://$mirror|g" /etc/apt/sources.list.d/ubuntu.sources || true
 if ! apt-get update -o Dir::State::Lists="$lists" -o Dir::Cache::Archives="$cache" >"$log" 2>&1; then
   restore
   cat "$log"
   rm -rf "$tmp"
   exit 1
 fi
-rm -rf "$tmp"`, mirror)
-}
+rm -rf "$tmp"`
 
 // resolveAptMirror returns the apt mirror hostname for the given cloud provider.
 // Returns empty string if no specific mirror is configured for the provider.
@@ -1192,6 +2034,9 @@ func fallbackToDefaultDevcontainer(
 	if len(bytes.TrimSpace(output)) == 0 {
 		output = []byte(fmt.Sprintf("devcontainer setup failed: %v\n", originalErr))
 	}
+	if registryAuthFailed(output) {
+		output = append(output[:len(output):len(output)], registryAuthHint...)
+	}
 
 	// Never start fallback if we cannot persist error artifacts first.
 	// This guarantees recovery containers always have diagnostics attached.
@@ -1204,6 +2049,10 @@ func fallbackToDefaultDevcontainer(
 	// broken container instead of creating a new one from the fallback image.
 	removeStaleContainers(ctx, cfg)
 
+	if registryAuthFailed(output) {
+		slog.Warn("Devcontainer build was refused by a container registry; falling back to default image", "workspaceID", cfg.WorkspaceID)
+	}
+
 	if _, err := runDevcontainerWithDefault(ctx, cfg, volumeName, credHelperHostPath); err != nil {
 		return false, fmt.Errorf("devcontainer fallback also failed: %w (original error: %v)", err, originalErr)
 	}
@@ -1219,6 +2068,13 @@ func fallbackToDefaultDevcontainer(
 // Volume mount settings are injected via the workspaceMount property in the
 // override config (NOT via the --mount CLI flag, which only adds supplementary
 // mounts and does not replace the default workspace bind mount).
+// devcontainerCommand runs the devcontainer CLI with args, pointed at the
+// selected container runtime.
+func devcontainerCommand(ctx context.Context, args ...string) *exec.Cmd {
+	args = append(args, containerruntime.Current().DevcontainerArgs()...)
+	return exec.CommandContext(ctx, "devcontainer", args...)
+}
+
 func devcontainerUpArgs(cfg *config.Config, overrideConfigPath, devcontainerConfigName string) []string {
 	args := []string{"up", "--workspace-folder", cfg.WorkspaceDir}
 
@@ -1242,6 +2098,9 @@ type devcontainerReadConfigurationResult struct {
 	Message             string                 `json:"message"`
 	Description         string                 `json:"description"`
 	MergedConfiguration map[string]interface{} `json:"mergedConfiguration"`
+	// Configuration is the repository's config before image metadata is
+	// merged in; the devcontainer policy checks both.
+	Configuration map[string]interface{} `json:"configuration"`
 }
 
 func hasReadConfigurationPayloadData(payload *devcontainerReadConfigurationResult) bool {
@@ -1412,7 +2271,7 @@ func runReadConfiguration(ctx context.Context, workspaceDir, devcontainerConfigN
 		configPath := namedDevcontainerConfigPath(workspaceDir, devcontainerConfigName)
 		args = append(args, "--config", configPath)
 	}
-	cmd := exec.CommandContext(ctx, "devcontainer", args...)
+	cmd := devcontainerCommand(ctx, args...)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return nil, fmt.Errorf("devcontainer read-configuration failed: %w: %s", err, strings.TrimSpace(string(output)))
@@ -1493,30 +2352,18 @@ func detectContainerUserFromMetadata(ctx context.Context, cfg *config.Config) st
 		return ""
 	}
 
-	cmd := exec.CommandContext(
-		ctx,
-		"docker",
-		"inspect",
-		"--format",
-		"{{json (index .Config.Labels \"devcontainer.metadata\")}}",
-		containerID,
-	)
-	output, err := cmd.CombinedOutput()
+	info, err := containerruntime.Current().Inspect(ctx, containerID)
 	if err != nil {
-		slog.Warn("Container user detection: docker inspect metadata failed", "error", err, "output", strings.TrimSpace(string(output)))
+		slog.Warn("Container user detection: docker inspect metadata failed", "error", err)
 		return ""
 	}
 
-	var encoded *string
-	if err := json.Unmarshal(bytes.TrimSpace(output), &encoded); err != nil {
-		slog.Warn("Container user detection: failed to decode metadata label payload", "error", err)
-		return ""
-	}
-	if encoded == nil || strings.TrimSpace(*encoded) == "" {
+	encoded := info.Labels["devcontainer.metadata"]
+	if strings.TrimSpace(encoded) == "" {
 		return ""
 	}
 
-	return extractContainerUserFromMetadataLabel(*encoded)
+	return extractContainerUserFromMetadataLabel(encoded)
 }
 
 func detectContainerUserFromExec(ctx context.Context, cfg *config.Config) string {
@@ -1526,14 +2373,13 @@ func detectContainerUserFromExec(ctx context.Context, cfg *config.Config) string
 		return ""
 	}
 
-	cmd := exec.CommandContext(ctx, "docker", "exec", containerID, "id", "-un")
-	output, err := cmd.CombinedOutput()
+	result, err := containerruntime.Current().Exec(ctx, containerID, containerruntime.ExecOptions{}, "id", "-un")
 	if err != nil {
-		slog.Warn("Container user detection: docker exec id fallback failed", "error", err, "output", strings.TrimSpace(string(output)))
+		slog.Warn("Container user detection: docker exec id fallback failed", "error", err)
 		return ""
 	}
 
-	return strings.TrimSpace(string(output))
+	return strings.TrimSpace(string(result.Stdout))
 }
 
 type containerUserDetector struct {
@@ -1648,6 +2494,9 @@ func ensureWorkspaceOwnership(ctx context.Context, cfg *config.Config) error {
 	if err != nil {
 		return err
 	}
+	if cfg.ContainerUsernsMode != "" {
+		return ensureWorkspaceOwnershipUserns(ctx, cfg, containerID, user, uid, gid)
+	}
 
 	ownerUID, ownerGID, err := statContainerPathOwnership(ctx, containerID, "/workspaces")
 	if err != nil {
@@ -1658,22 +2507,24 @@ func ensureWorkspaceOwnership(ctx context.Context, cfg *config.Config) error {
 		return nil
 	}
 
-	cmd := exec.CommandContext(ctx, "docker", "exec", "-u", "root", containerID, "chown", "-R", uid+":"+gid, "/workspaces")
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to chown /workspaces to %s (%s:%s): %w: %s", user, uid, gid, err, strings.TrimSpace(string(output)))
+	op := execaudit.Begin(ctx, containerID, "workspace.chown", "/workspaces "+uid+":"+gid)
+	if _, err := containerruntime.Current().Exec(ctx, containerID, rootExec, "chown", "-R", uid+":"+gid, "/workspaces"); op.End(err) != nil {
+		return fmt.Errorf("failed to chown /workspaces to %s (%s:%s): %w", user, uid, gid, err)
 	}
 	slog.Info("Adjusted /workspaces ownership", "user", user, "uid", uid, "gid", gid)
 	return nil
 }
 
+// rootExec runs a container command as root.
+var rootExec = containerruntime.ExecOptions{User: "root"}
+
 func resolveContainerUserID(ctx context.Context, containerID, user, flag, label string) (string, error) {
-	cmd := exec.CommandContext(ctx, "docker", "exec", "-u", "root", containerID, "id", flag, user)
-	output, err := cmd.CombinedOutput()
+	result, err := containerruntime.Current().Exec(ctx, containerID, rootExec, "id", flag, user)
 	if err != nil {
-		return "", fmt.Errorf("failed to resolve %s for user %s: %w: %s", label, user, err, strings.TrimSpace(string(output)))
+		return "", fmt.Errorf("failed to resolve %s for user %s: %w", label, user, err)
 	}
 
-	return parseNumericID(fmt.Sprintf("%s for user %s", label, user), string(output))
+	return parseNumericID(fmt.Sprintf("%s for user %s", label, user), string(result.Stdout))
 }
 
 func parseNumericID(label, value string) (string, error) {
@@ -1690,15 +2541,15 @@ func parseNumericID(label, value string) (string, error) {
 }
 
 func statContainerPathOwnership(ctx context.Context, containerID, path string) (string, string, error) {
-	cmd := exec.CommandContext(ctx, "docker", "exec", "-u", "root", containerID, "stat", "-c", "%u:%g", path)
-	output, err := cmd.CombinedOutput()
+	result, err := containerruntime.Current().Exec(ctx, containerID, rootExec, "stat", "-c", "%u:%g", path)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to stat %s ownership: %w: %s", path, err, strings.TrimSpace(string(output)))
+		return "", "", fmt.Errorf("failed to stat %s ownership: %w", path, err)
 	}
 
-	parts := strings.Split(strings.TrimSpace(string(output)), ":")
+	output := strings.TrimSpace(string(result.Stdout))
+	parts := strings.Split(output, ":")
 	if len(parts) != 2 {
-		return "", "", fmt.Errorf("unexpected stat output for %s: %q", path, strings.TrimSpace(string(output)))
+		return "", "", fmt.Errorf("unexpected stat output for %s: %q", path, output)
 	}
 	uid, err := parseNumericID("uid for "+path, parts[0])
 	if err != nil {
@@ -1716,6 +2567,21 @@ func statContainerPathOwnership(ctx context.Context, containerID, path string) (
 // includes workspaceMount/workspaceFolder for named-volume workspaces.
 // When cacheFrom is non-empty, it is injected as a cacheFrom source for the build.
 func writeMountOverrideConfig(ctx context.Context, cfg *config.Config, volumeName, credHelperHostPath, devcontainerConfigName, cacheFrom string) (string, error) {
+	merged, err := mountOverrideConfig(ctx, cfg, volumeName, credHelperHostPath, devcontainerConfigName, cacheFrom)
+	if err != nil {
+		return "", err
+	}
+	path, err := writeMergedOverrideConfig(merged, "devcontainer-mount-override-*.json")
+	if err != nil {
+		return "", err
+	}
+	slog.Info("Wrote mount override config", "path", path, "volume", volumeName, "workspaceFolder", merged["workspaceFolder"], "cacheFrom", cacheFrom)
+	return path, nil
+}
+
+// mountOverrideConfig returns the repo's merged devcontainer configuration
+// with the named-volume mount, credential helper, and cacheFrom injected.
+func mountOverrideConfig(ctx context.Context, cfg *config.Config, volumeName, credHelperHostPath, devcontainerConfigName, cacheFrom string) (map[string]interface{}, error) {
 	repoDirName := config.DeriveRepoDirName(cfg.Repository)
 	if repoDirName == "" {
 		repoDirName = filepath.Base(cfg.WorkspaceDir)
@@ -1723,16 +2589,22 @@ func writeMountOverrideConfig(ctx context.Context, cfg *config.Config, volumeNam
 
 	readResult, err := runReadConfiguration(ctx, cfg.WorkspaceDir, devcontainerConfigName)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	if len(readResult.MergedConfiguration) == 0 {
-		return "", errors.New("devcontainer read-configuration returned empty mergedConfiguration")
+		return nil, errors.New("devcontainer read-configuration returned empty mergedConfiguration")
 	}
 	if !hasMergedRuntimeSource(readResult.MergedConfiguration) {
-		return "", errors.New("devcontainer read-configuration mergedConfiguration missing image/dockerFile/dockerComposeFile")
+		return nil, errors.New("devcontainer read-configuration mergedConfiguration missing image/dockerFile/dockerComposeFile")
 	}
 
 	normalizeMergedLifecycleCommands(readResult.MergedConfiguration)
+	if cfg.ContainerUsernsMode != "" {
+		dropPrivilegedRunArgs(readResult.MergedConfiguration)
+	}
+	withResourceLimitRunArgs(readResult.MergedConfiguration, EffectiveResourceLimits(cfg, nil))
+	withOutboundConfig(readResult.MergedConfiguration, cfg)
+	withSecretFilesMount(readResult.MergedConfiguration, cfg)
 	readResult.MergedConfiguration["workspaceMount"] = fmt.Sprintf("source=%s,target=/workspaces,type=volume", volumeName)
 	readResult.MergedConfiguration["workspaceFolder"] = fmt.Sprintf("/workspaces/%s", repoDirName)
 
@@ -1768,13 +2640,19 @@ func writeMountOverrideConfig(ctx context.Context, cfg *config.Config, volumeNam
 		readResult.MergedConfiguration["cacheFrom"] = []string{cacheFrom}
 	}
 
-	configJSON, err := json.MarshalIndent(readResult.MergedConfiguration, "", "  ")
+	return readResult.MergedConfiguration, nil
+}
+
+// writeMergedOverrideConfig writes a merged devcontainer configuration to a
+// temp file matching pattern and returns its path.
+func writeMergedOverrideConfig(merged map[string]interface{}, pattern string) (string, error) {
+	configJSON, err := json.MarshalIndent(merged, "", "  ")
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal merged mount override config: %w", err)
 	}
 	configJSON = append(configJSON, '\n')
 
-	tmpFile, err := os.CreateTemp("", "devcontainer-mount-override-*.json")
+	tmpFile, err := os.CreateTemp("", pattern)
 	if err != nil {
 		return "", fmt.Errorf("failed to create mount override config: %w", err)
 	}
@@ -1788,8 +2666,6 @@ func writeMountOverrideConfig(ctx context.Context, cfg *config.Config, volumeNam
 		os.Remove(tmpFile.Name())
 		return "", fmt.Errorf("failed to finalize mount override config: %w", err)
 	}
-
-	slog.Info("Wrote mount override config", "path", tmpFile.Name(), "volume", volumeName, "workspaceFolder", "/workspaces/"+repoDirName, "cacheFrom", cacheFrom)
 	return tmpFile.Name(), nil
 }
 
@@ -1808,7 +2684,7 @@ func runDevcontainerWithDefault(ctx context.Context, cfg *config.Config, volumeN
 		args = append(args, "--additional-features", cfg.AdditionalFeatures)
 	}
 
-	cmd := exec.CommandContext(ctx, "devcontainer", args...)
+	cmd := devcontainerCommand(ctx, args...)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return false, fmt.Errorf("devcontainer up failed: %w: %s", err, strings.TrimSpace(string(output)))
@@ -1828,7 +2704,7 @@ func runLightweightDevcontainerWithDefault(ctx context.Context, cfg *config.Conf
 	slog.Info("Using lightweight default devcontainer config", "configPath", configPath, "image", cfg.DefaultDevcontainerImage)
 
 	args := devcontainerUpArgs(cfg, configPath, "")
-	cmd := exec.CommandContext(ctx, "devcontainer", args...)
+	cmd := devcontainerCommand(ctx, args...)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return false, fmt.Errorf("devcontainer up failed: %w: %s", err, strings.TrimSpace(string(output)))
@@ -1843,18 +2719,16 @@ func runLightweightDevcontainerWithDefault(ctx context.Context, cfg *config.Conf
 // broken container from a failed first attempt.
 func removeStaleContainers(ctx context.Context, cfg *config.Config) {
 	filter := fmt.Sprintf("label=%s=%s", cfg.ContainerLabelKey, cfg.ContainerLabelValue)
-	// Use -a to find containers in ANY state (running, stopped, created, exited).
-	cmd := exec.CommandContext(ctx, "docker", "ps", "-aq", "--filter", filter)
-	output, err := cmd.Output()
+	// Include containers in ANY state (running, stopped, created, exited).
+	containers, err := containerruntime.Current().ListContainers(ctx, true, filter)
 	if err != nil {
 		slog.Warn("Failed to list stale containers for cleanup", "error", err)
 		return
 	}
 
-	containers := strings.Fields(string(output))
 	for _, id := range containers {
 		slog.Info("Removing stale container before fallback", "containerID", id)
-		rmCmd := exec.CommandContext(ctx, "docker", "rm", "-f", id)
+		rmCmd := containerruntime.Command(ctx, "rm", "-f", id)
 		if rmOutput, rmErr := rmCmd.CombinedOutput(); rmErr != nil {
 			slog.Warn("Failed to remove stale container", "containerID", id, "error", rmErr, "output", strings.TrimSpace(string(rmOutput)))
 		}
@@ -1911,10 +2785,33 @@ func writeDefaultDevcontainerConfigForMode(cfg *config.Config, volumeName, credH
 	}
 
 	// When a credential helper host path is provided, add a bind mount and
-	// containerEnv so the helper is available during devcontainer lifecycle hooks.
-	credLines := ""
+	// containerEnv so the helper is available during devcontainer lifecycle
+	// hooks. Proxy settings and the CA bundle are added the same way.
+	mounts := outboundMounts(cfg)
+	if cfg.SecretFilesTmpfs {
+		mounts = append(mounts, secretFilesMount)
+	}
+	containerEnv := outboundContainerEnv(cfg)
 	if credHelperHostPath != "" {
-		credLines = fmt.Sprintf(",\n  \"mounts\": [\"%s\"],\n  \"containerEnv\": {\n    \"GIT_CONFIG_COUNT\": \"2\",\n    \"GIT_CONFIG_KEY_0\": \"credential.helper\",\n    \"GIT_CONFIG_VALUE_0\": \"%s\",\n    \"GIT_CONFIG_KEY_1\": \"credential.useHttpPath\",\n    \"GIT_CONFIG_VALUE_1\": \"true\"\n  }", credentialHelperMountEntry(credHelperHostPath), credentialHelperContainerPath)
+		mounts = append(mounts, credentialHelperMountEntry(credHelperHostPath))
+		for k, v := range credentialHelperContainerEnv() {
+			containerEnv[k] = v
+		}
+	}
+	credLines := ""
+	if len(mounts) > 0 {
+		encoded, err := json.Marshal(mounts)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode mounts: %w", err)
+		}
+		credLines += fmt.Sprintf(",\n  \"mounts\": %s", encoded)
+	}
+	if len(containerEnv) > 0 {
+		encoded, err := json.MarshalIndent(containerEnv, "  ", "  ")
+		if err != nil {
+			return "", fmt.Errorf("failed to encode containerEnv: %w", err)
+		}
+		credLines += fmt.Sprintf(",\n  \"containerEnv\": %s", encoded)
 	}
 
 	featuresLine := ""
@@ -1932,12 +2829,29 @@ func writeDefaultDevcontainerConfigForMode(cfg *config.Config, volumeName, credH
   "updateRemoteUserUID": false`
 	}
 
+	// Privileged mode lets workspaces install Docker on demand. It is left out
+	// in user namespace mode, where it is unsupported (userns-remap) or would
+	// grant the daemon user's full capabilities (rootless).
+	privilegedLine := ""
+	if cfg.ContainerUsernsMode == "" {
+		privilegedLine = `,
+  "privileged": true`
+	}
+
+	runArgsLine := ""
+	if flags := EffectiveResourceLimits(cfg, nil).dockerFlags(); len(flags) > 0 {
+		encoded, err := json.Marshal(flags)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode resource limit runArgs: %w", err)
+		}
+		runArgsLine = fmt.Sprintf(",\n  \"runArgs\": %s", encoded)
+	}
+
 	configJSON := fmt.Sprintf(`{
   "name": "Default Workspace",
-  "image": %q,
-  "privileged": true%s%s%s%s%s
+  "image": %q%s%s%s%s%s%s%s
 }
-`, image, featuresLine, updateRemoteUserUIDLine, remoteUserLine, mountLines, credLines)
+`, image, privilegedLine, runArgsLine, featuresLine, updateRemoteUserUIDLine, remoteUserLine, mountLines, credLines)
 
 	if err := os.WriteFile(configPath, []byte(configJSON), 0o644); err != nil {
 		return "", fmt.Errorf("failed to write default config: %w", err)
@@ -2017,7 +2931,7 @@ func ensureGitHubCLI(ctx context.Context, cfg *config.Config) error {
 	if cfg.Repository == "" {
 		return nil
 	}
-	if !gitrepo.IsGitHubRepo(cfg.Repository) {
+	if !isGitHubRepository(cfg) {
 		return nil
 	}
 
@@ -2027,8 +2941,7 @@ func ensureGitHubCLI(ctx context.Context, cfg *config.Config) error {
 	}
 
 	// Check if gh is already available
-	checkCmd := exec.CommandContext(ctx, "docker", "exec", containerID, "which", "gh")
-	if err := checkCmd.Run(); err == nil {
+	if _, err := containerruntime.Current().Exec(ctx, containerID, containerruntime.ExecOptions{}, "which", "gh"); err == nil {
 		slog.Info("gh CLI already available in devcontainer", "containerID", containerID)
 		return nil
 	}
@@ -2060,10 +2973,9 @@ else
   exit 0
 fi
 `
-	installCmd := exec.CommandContext(ctx, "docker", "exec", "-u", "root", containerID, "sh", "-c", installScript)
-	output, err := installCmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("failed to install gh CLI in devcontainer: %w: %s", err, strings.TrimSpace(string(output)))
+	op := execaudit.Begin(ctx, containerID, "package.install", "gh")
+	if _, err := containerruntime.Current().Exec(ctx, containerID, rootExec, "sh", "-c", installScript); op.End(err) != nil {
+		return fmt.Errorf("failed to install gh CLI in devcontainer: %w", err)
 	}
 
 	slog.Info("gh CLI installed in devcontainer", "containerID", containerID)
@@ -2092,8 +3004,7 @@ func ensureGitCredentialHelper(ctx context.Context, cfg *config.Config) error {
 	// bind-mounted file ("device or resource busy"). The GIT_CONFIG env vars
 	// are also already set via containerEnv in this case.
 	installPath := credentialHelperContainerPath
-	checkCmd := exec.CommandContext(ctx, "docker", "exec", containerID, "test", "-f", installPath)
-	if checkCmd.Run() == nil {
+	if _, err := containerruntime.Current().Exec(ctx, containerID, containerruntime.ExecOptions{}, "test", "-f", installPath); err == nil {
 		slog.Info("Git credential helper already present (bind-mounted), skipping post-build copy", "containerID", containerID)
 		// Still configure git to use the helper (belt-and-suspenders with containerEnv)
 		// and install the gh wrapper.
@@ -2101,7 +3012,7 @@ func ensureGitCredentialHelper(ctx context.Context, cfg *config.Config) error {
 			return err
 		}
 		slog.Info("Configured git credential helper in devcontainer", "containerID", containerID)
-		if gitrepo.IsGitHubRepo(cfg.Repository) {
+		if isGitHubRepository(cfg) {
 			if err := installGhWrapper(ctx, cfg, containerID); err != nil {
 				slog.Warn("gh wrapper install failed (non-fatal)", "error", err)
 			}
@@ -2133,16 +3044,15 @@ func ensureGitCredentialHelper(ctx context.Context, cfg *config.Config) error {
 		return fmt.Errorf("failed to chmod temporary credential helper script: %w", err)
 	}
 
-	cmd := exec.CommandContext(ctx, "docker", "cp", tempPath, containerID+":"+installPath)
-	if output, err := cmd.CombinedOutput(); err != nil {
+	if output, err := containerruntime.Current().Copy(ctx, tempPath, containerID+":"+installPath); err != nil {
 		return fmt.Errorf("failed to copy credential helper into devcontainer: %w: %s", err, strings.TrimSpace(string(output)))
 	}
 
 	// Use -u root because the container's default user (e.g. "node") may not have
 	// write permissions to /usr/local/bin/.
-	cmd = exec.CommandContext(ctx, "docker", "exec", "-u", "root", containerID, "chmod", "0755", installPath)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to chmod credential helper in devcontainer: %w: %s", err, strings.TrimSpace(string(output)))
+	op := execaudit.Begin(ctx, containerID, "credential_helper.install", installPath)
+	if _, err := containerruntime.Current().Exec(ctx, containerID, rootExec, "chmod", "0755", installPath); op.End(err) != nil {
+		return fmt.Errorf("failed to chmod credential helper in devcontainer: %w", err)
 	}
 
 	if err := configureGitCredentialHelper(ctx, containerID, installPath); err != nil {
@@ -2155,7 +3065,7 @@ func ensureGitCredentialHelper(ctx context.Context, cfg *config.Config) error {
 	// This ensures gh CLI works even for sessions longer than 1 hour when the
 	// initial GH_TOKEN has expired. The wrapper fetches a fresh token from the
 	// git credential helper (which calls back to the VM agent for a new token).
-	if gitrepo.IsGitHubRepo(cfg.Repository) {
+	if isGitHubRepository(cfg) {
 		if err := installGhWrapper(ctx, cfg, containerID); err != nil {
 			// Non-fatal: git clone/fetch still use the credential helper. Direct gh
 			// invocations may lack GH_TOKEN until shell startup fallback runs.
@@ -2170,55 +3080,80 @@ func ensureGitCredentialHelper(ctx context.Context, cfg *config.Config) error {
 // script that refreshes GH_TOKEN via git credential fill before every invocation.
 func installGhWrapper(ctx context.Context, cfg *config.Config, containerID string) error {
 	// Find where gh is installed
-	whichCmd := exec.CommandContext(ctx, "docker", "exec", containerID, "which", "gh")
-	whichOutput, err := whichCmd.Output()
+	rt := containerruntime.Current()
+	which, err := rt.Exec(ctx, containerID, containerruntime.ExecOptions{}, "which", "gh")
 	if err != nil {
 		return fmt.Errorf("gh not found in container: %w", err)
 	}
-	ghPath := strings.TrimSpace(string(whichOutput))
+	ghPath := strings.TrimSpace(string(which.Stdout))
 	if ghPath == "" {
 		return fmt.Errorf("gh path is empty")
 	}
 	ghRealPath := ghPath + ".real"
 
 	// Check if wrapper is already installed (gh.real exists)
-	checkCmd := exec.CommandContext(ctx, "docker", "exec", containerID, "test", "-f", ghRealPath)
-	if checkCmd.Run() == nil {
+	if _, err := rt.Exec(ctx, containerID, containerruntime.ExecOptions{}, "test", "-f", ghRealPath); err == nil {
 		slog.Info("gh wrapper already installed", "containerID", containerID)
 		return nil
 	}
 
 	// Move real gh to gh.real
-	moveCmd := exec.CommandContext(ctx, "docker", "exec", "-u", "root", containerID, "mv", ghPath, ghRealPath)
-	if output, err := moveCmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to move gh to gh.real: %w: %s", err, strings.TrimSpace(string(output)))
+	op := execaudit.Begin(ctx, containerID, "gh_wrapper.move", ghPath+" -> "+ghRealPath)
+	if _, err := rt.Exec(ctx, containerID, rootExec, "mv", ghPath, ghRealPath); op.End(err) != nil {
+		return fmt.Errorf("failed to move gh to gh.real: %w", err)
 	}
 
 	// Write wrapper script
 	wrapperScript := fmt.Sprintf(`#!/bin/sh
 # gh wrapper — refreshes GH_TOKEN from git credential helper before each invocation.
-# This ensures gh CLI works for sessions longer than 1 hour.
-_token=$(printf 'protocol=https\nhost=github.com\n\n' | git credential fill 2>/dev/null | sed -n 's/^password=//p')
+# This ensures gh CLI works for sessions longer than 1 hour. The "pr" capability
+# asks for the token that can create pull requests.
+_token=$(printf 'protocol=https\nhost=github.com\n\n' | SAM_GIT_CAPABILITY=pr git credential fill 2>/dev/null | sed -n 's/^password=//p')
 if [ -n "$_token" ]; then
   export GH_TOKEN="$_token"
 fi
 exec "%s" "$@"
 `, ghRealPath)
 
-	writeCmd := exec.CommandContext(ctx, "docker", "exec", "-u", "root", "-i", containerID, "sh", "-c",
-		fmt.Sprintf("cat > %s && chmod 0755 %s", ghPath, ghPath))
-	writeCmd.Stdin = strings.NewReader(wrapperScript)
-	if output, err := writeCmd.CombinedOutput(); err != nil {
+	op = execaudit.Begin(ctx, containerID, "gh_wrapper.install", ghPath)
+	if err := rt.WriteFile(ctx, containerID, ghPath, []byte(wrapperScript), 0o755); op.End(err) != nil {
 		// Restore original gh on failure
-		restoreCmd := exec.CommandContext(ctx, "docker", "exec", "-u", "root", containerID, "mv", ghRealPath, ghPath)
-		_ = restoreCmd.Run()
-		return fmt.Errorf("failed to write gh wrapper script: %w: %s", err, strings.TrimSpace(string(output)))
+		restoreOp := execaudit.Begin(ctx, containerID, "gh_wrapper.restore", ghRealPath+" -> "+ghPath)
+		_, restoreErr := rt.Exec(ctx, containerID, rootExec, "mv", ghRealPath, ghPath)
+		_ = restoreOp.End(restoreErr)
+		return fmt.Errorf("failed to write gh wrapper script: %w", err)
 	}
 
 	slog.Info("gh wrapper installed", "containerID", containerID, "ghPath", ghPath, "ghRealPath", ghRealPath)
 	return nil
 }
 
+// GitCredentialCapabilityScript is the credential helper fragment that sets
+// $capability to the access the calling git operation needs: "push" or
+// "read". Git does not tell credential helpers which operation a credential
+// is for, so it looks for a push in the command lines of the calling
+// processes. Callers that know better, such as the gh wrapper, set
+// SAM_GIT_CAPABILITY instead. The vm-agent /git-credential endpoint maps the
+// capability to a token purpose.
+const GitCredentialCapabilityScript = `capability="${SAM_GIT_CAPABILITY:-}"
+if [ -z "$capability" ]; then
+  capability=read
+  pid=$PPID
+  depth=0
+  while [ "$depth" -lt 6 ] && [ -r "/proc/$pid/cmdline" ]; do
+    if tr '\0' '\n' < "/proc/$pid/cmdline" | grep -qx -e push -e send-pack; then
+      capability=push
+      break
+    fi
+    pid=$(awk '/^PPid:/ {print $2}' "/proc/$pid/status" 2>/dev/null || true)
+    if [ -z "$pid" ] || [ "$pid" = 0 ]; then
+      break
+    fi
+    depth=$((depth + 1))
+  done
+fi
+`
+
 func renderGitCredentialHelperScript(cfg *config.Config) (string, error) {
 	if cfg == nil {
 		return "", errors.New("nil config")
@@ -2242,9 +3177,13 @@ func renderGitCredentialHelperScript(cfg *config.Config) (string, error) {
 	if workspaceID := strings.TrimSpace(cfg.WorkspaceID); workspaceID != "" {
 		query = "?workspaceId=" + url.QueryEscape(workspaceID)
 	}
-	// Hostnames are case-insensitive; normalize once here and lowercase the
-	// requested host in the shell so the comparison cannot fail on casing.
-	allowedGitLabHost := strings.ToLower(strings.TrimSpace(cfg.RepositoryHost))
+	// A GitLab or Bitbucket workspace also gets credentials for its bound
+	// host. Hostnames are case-insensitive; TokenHost lowercases here and the
+	// shell lowercases the requested host so the comparison cannot fail on casing.
+	allowedProviderHost := ""
+	if provider := boundGitProvider(cfg); provider.HostBound {
+		allowedProviderHost = provider.TokenHost(cfg.RepositoryHost)
+	}
 
 	// When TLS is enabled on the VM agent, the credential helper must use https://
 	// with -k (skip cert verification) because the TLS cert is issued for the
@@ -2280,9 +3219,9 @@ done
 case "$requested_host" in
   ""|github.com|api.github.com|artifacts.cloudflare.net|*.artifacts.cloudflare.net) ;;
   *)
-    allowed_gitlab_host=%s
+    allowed_provider_host=%s
     requested_host_lower=$(printf '%%s' "$requested_host" | tr '[:upper:]' '[:lower:]')
-    if [ -z "$allowed_gitlab_host" ] || [ "$requested_host_lower" != "$allowed_gitlab_host" ]; then
+    if [ -z "$allowed_provider_host" ] || [ "$requested_host_lower" != "$allowed_provider_host" ]; then
       exit 0
     fi
     ;;
@@ -2309,13 +3248,21 @@ if [ -n "$requested_path" ]; then
   fi
 fi
 
+%sencoded_capability=$(url_encode_query_value "$capability")
+if [ -n "$credential_query" ]; then
+  credential_query="${credential_query}&capability=${encoded_capability}"
+else
+  credential_query="?capability=${encoded_capability}"
+fi
+
 resolve_gateway() {
   ip route 2>/dev/null | awk '/default/ {print $3; exit}'
 }
 
+# The agent is on this node, so never send the request through a proxy.
 request_credentials() {
   target="$1"
-  curl -fsS --max-time %s%s \
+  curl -fsS --noproxy '*' --max-time %s%s \
     "%s://${target}:%d/git-credential${credential_query}"
 }
 
@@ -2328,7 +3275,7 @@ for target in host.docker.internal "$gateway" 172.17.0.1; do
 done
 
 exit 0
-`, shellSingleQuote(allowedGitLabHost), query, credentialTimeoutSeconds, curlTLSFlag, scheme, cfg.Port), nil
+`, shellSingleQuote(allowedProviderHost), query, GitCredentialCapabilityScript, credentialTimeoutSeconds, curlTLSFlag, scheme, cfg.Port), nil
 }
 
 // sanitizeWorkspaceID strips characters that are not alphanumeric or hyphens
@@ -2560,9 +3507,9 @@ func configureSystemGit(ctx context.Context, containerID, key, value, label stri
 		return hasActiveGitConfigProcess(ctx, containerID)
 	}
 	removeLock := func() error {
-		rmCmd := exec.CommandContext(ctx, "docker", "exec", "-u", "root", containerID, "rm", "-f", "/etc/gitconfig.lock")
-		if output, err := rmCmd.CombinedOutput(); err != nil {
-			return fmt.Errorf("rm failed: %w: %s", err, strings.TrimSpace(string(output)))
+		op := execaudit.Begin(ctx, containerID, "git.remove_config_lock", "/etc/gitconfig.lock")
+		if _, err := containerruntime.Current().Exec(ctx, containerID, rootExec, "rm", "-f", "/etc/gitconfig.lock"); op.End(err) != nil {
+			return fmt.Errorf("rm failed: %w", err)
 		}
 		return nil
 	}
@@ -2676,21 +3623,15 @@ func runSystemGitConfig(ctx context.Context, containerID, key, value string) ([]
 	// write permissions to /etc/gitconfig (system-level git config).
 	// Force LANG=C so git error messages are always in English — isGitConfigLockError
 	// matches on the English-locale error string.
-	cmd := exec.CommandContext(
-		ctx,
-		"docker",
-		"exec",
-		"-u", "root",
-		"-e", "LANG=C",
-		"-e", "LC_ALL=C",
-		containerID,
-		"git",
-		"config",
-		"--system",
-		key,
-		value,
+	op := execaudit.Begin(ctx, containerID, "git.system_config", key)
+	result, err := containerruntime.Current().Exec(ctx, containerID,
+		containerruntime.ExecOptions{User: "root", Env: []string{"LANG=C", "LC_ALL=C"}},
+		"git", "config", "--system", key, value,
 	)
-	return cmd.CombinedOutput()
+	if result != nil {
+		return append(result.Stdout, result.Stderr...), op.End(err)
+	}
+	return nil, op.End(err)
 }
 
 func isGitConfigLockError(output string) bool {
@@ -2700,20 +3641,19 @@ func isGitConfigLockError(output string) bool {
 func hasActiveGitConfigProcess(ctx context.Context, containerID string) (bool, error) {
 	// Try ps -eo args first (POSIX). Fall back to /proc/*/cmdline for minimal
 	// containers (Alpine/BusyBox) where ps -eo args may not be available.
-	cmd := exec.CommandContext(ctx, "docker", "exec", "-u", "root", containerID, "ps", "-eo", "args")
-	output, err := cmd.CombinedOutput()
+	rt := containerruntime.Current()
+	result, err := rt.Exec(ctx, containerID, rootExec, "ps", "-eo", "args")
 	if err != nil {
 		// Fallback: read /proc/*/cmdline which is universally available on Linux.
-		fallbackCmd := exec.CommandContext(ctx, "docker", "exec", "-u", "root", containerID,
+		fallback, fallbackErr := rt.Exec(ctx, containerID, rootExec,
 			"sh", "-c", `cat /proc/[0-9]*/cmdline 2>/dev/null | tr '\0' ' '`)
-		fallbackOut, fallbackErr := fallbackCmd.CombinedOutput()
 		if fallbackErr != nil {
-			return false, fmt.Errorf("failed to inspect container processes: %w: %s (fallback also failed: %v)", err, strings.TrimSpace(string(output)), fallbackErr)
+			return false, fmt.Errorf("failed to inspect container processes: %w (fallback also failed: %v)", err, fallbackErr)
 		}
-		return gitConfigProcessActive(string(fallbackOut)), nil
+		return gitConfigProcessActive(string(fallback.Stdout)), nil
 	}
 
-	return gitConfigProcessActive(string(output)), nil
+	return gitConfigProcessActive(string(result.Stdout)), nil
 }
 
 // gitConfigProcessRe matches process lines where the binary is "git" (or a
@@ -2780,15 +3720,19 @@ func resolveGitIdentity(state *bootstrapState) (name string, email string, ok bo
 	return "workspace-user", email, true
 }
 
+// ensureGitIdentity configures the system git identity in the devcontainer
+// and, when the control plane sent a signing key, commit signing.
 func ensureGitIdentity(ctx context.Context, cfg *config.Config, state *bootstrapState) error {
 	gitUserName, gitUserEmail, ok := resolveGitIdentity(state)
 	if !ok {
 		if state == nil {
 			slog.Warn("Git identity skipped — bootstrap state is nil")
-		} else {
-			slog.Warn("Git identity skipped — email is required", "name", state.GitUserName, "email", state.GitUserEmail)
+			return nil
+		}
+		slog.Warn("Git identity skipped — email is required", "name", state.GitUserName, "email", state.GitUserEmail)
+		if state.SigningKey == "" {
+			return nil
 		}
-		return nil
 	}
 
 	containerID, err := findDevcontainerID(ctx, cfg)
@@ -2796,15 +3740,149 @@ func ensureGitIdentity(ctx context.Context, cfg *config.Config, state *bootstrap
 		return fmt.Errorf("failed to locate devcontainer for git identity setup: %w", err)
 	}
 
-	if err := configureSystemGit(ctx, containerID, "user.email", gitUserEmail, "git user.email"); err != nil {
-		return err
+	if ok {
+		if err := configureSystemGit(ctx, containerID, "user.email", gitUserEmail, "git user.email"); err != nil {
+			return err
+		}
+
+		if err := configureSystemGit(ctx, containerID, "user.name", gitUserName, "git user.name"); err != nil {
+			return err
+		}
+
+		slog.Info("Configured git identity in devcontainer", "containerID", containerID, "name", gitUserName, "email", gitUserEmail)
 	}
+	return ensureGitSigning(ctx, cfg, containerID, state, gitUserEmail)
+}
 
-	if err := configureSystemGit(ctx, containerID, "user.name", gitUserName, "git user.name"); err != nil {
-		return err
+// gitHooksContainerDir holds the managed hooks; core.hooksPath points here.
+const gitHooksContainerDir = "/usr/local/share/sam/git-hooks"
+
+// gitHookNames are the client-side hooks the managed dispatcher is installed
+// as. Setting core.hooksPath disables $GIT_DIR/hooks, so every hook a
+// repository might rely on must be present here and chain to the repo's own.
+var gitHookNames = []string{
+	"applypatch-msg", "pre-applypatch", "post-applypatch",
+	"pre-commit", "pre-merge-commit", "prepare-commit-msg", "commit-msg", "post-commit",
+	"pre-rebase", "post-checkout", "post-merge", "pre-push", "post-rewrite", "pre-auto-gc",
+}
+
+// renderCommitTrailerHook returns the managed hook dispatcher. For
+// prepare-commit-msg it appends trailers identifying the SAM agent session and
+// prompt that produced the commit; every hook then chains to the repository's
+// own hook in $GIT_DIR/hooks so existing project hooks keep working.
+//
+// Trailers are only added when SAM_AGENT_SESSION_ID is set (i.e. the commit
+// was made by an agent process). The SAM-* trailers are added unless
+// `git config sam.commitTrailers` is false, and a Co-authored-by trailer
+// naming SAM_AGENT_NAME when `git config sam.coAuthoredBy` is true.
+// SAM_PROMPT_MARKER names a file holding the current prompt's ID.
+func renderCommitTrailerHook() string {
+	return `#!/bin/sh
+# SAM managed git hook (auto-generated). Do not edit.
+hook_name=$(basename "$0")
+
+if [ "$hook_name" = "prepare-commit-msg" ] && [ -n "$SAM_AGENT_SESSION_ID" ]; then
+	case "$2" in
+	merge|squash) ;;
+	*)
+		trailers=""
+		if [ "$(git config --bool sam.commitTrailers 2>/dev/null)" != "false" ]; then
+			trailers="SAM-Session: $SAM_AGENT_SESSION_ID"
+			if [ -n "$SAM_PROMPT_MARKER" ] && [ -s "$SAM_PROMPT_MARKER" ]; then
+				trailers="$trailers
+SAM-Prompt: $(head -c 128 "$SAM_PROMPT_MARKER" | tr -d '\r\n')"
+			fi
+			if [ -n "$SAM_CHAT_SESSION_ID" ]; then
+				trailers="$trailers
+SAM-Chat-Session: $SAM_CHAT_SESSION_ID"
+			fi
+			if [ -n "$SAM_TASK_ID" ]; then
+				trailers="$trailers
+SAM-Task: $SAM_TASK_ID"
+			fi
+		fi
+		if [ "$(git config --bool sam.coAuthoredBy 2>/dev/null)" = "true" ]; then
+			co_author="$(printf '%s' "${SAM_AGENT_NAME:-agent}" | tr -d '\r\n<>') (via SAM)"
+			co_author_email=$(git config sam.coAuthorEmail 2>/dev/null)
+			if [ -n "$co_author_email" ]; then
+				co_author="$co_author <$co_author_email>"
+			fi
+			trailers="${trailers:+$trailers
+}Co-authored-by: $co_author"
+		fi
+		if [ -n "$trailers" ]; then
+			printf '%s\n' "$trailers" | while IFS= read -r trailer; do
+				git interpret-trailers --in-place --if-exists addIfDifferent --trailer "$trailer" "$1" || true
+			done
+		fi
+		;;
+	esac
+fi
+
+repo_hook="$(git rev-parse --git-common-dir 2>/dev/null)/hooks/$hook_name"
+if [ -x "$repo_hook" ]; then
+	exec "$repo_hook" "$@"
+fi
+exit 0
+`
+}
+
+// gitHooksInstallScript writes stdin to $1/sam-hook and links each hook name
+// after $1 to it.
+const gitHooksInstallScript = `dir="$1"; shift; mkdir -p "$dir" && cat > "$dir/sam-hook" && chmod 755 "$dir/sam-hook" && for h in "$@"; do ln -sf sam-hook "$dir/$h"; done`
+
+// ensureCommitTrailerHooks installs the managed git hooks in the devcontainer
+// and points the system core.hooksPath at them. override is the workspace's
+// commit-trailer setting; nil defers to the node default (GitCommitTrailers)
+// and leaves any sam.commitTrailers value already set in the container alone,
+// so an explicit per-workspace opt-out survives re-provisioning. The hooks
+// are also installed for GitCoAuthoredBy, which is a node-wide setting.
+func ensureCommitTrailerHooks(ctx context.Context, cfg *config.Config, override *bool) error {
+	enabled := cfg.GitCommitTrailers
+	if override != nil {
+		enabled = *override
+	}
+	coAuthored := cfg.GitCoAuthoredBy
+	if !enabled && override == nil && !coAuthored {
+		return nil
 	}
 
-	slog.Info("Configured git identity in devcontainer", "containerID", containerID, "name", gitUserName, "email", gitUserEmail)
+	containerID, err := findDevcontainerID(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to locate devcontainer for git hook setup: %w", err)
+	}
+
+	if enabled || coAuthored {
+		op := execaudit.Begin(ctx, containerID, "git.hooks_install", gitHooksContainerDir)
+		if _, err := containerruntime.Current().Exec(ctx, containerID,
+			containerruntime.ExecOptions{User: "root", Stdin: strings.NewReader(renderCommitTrailerHook())},
+			append([]string{"sh", "-c", gitHooksInstallScript, "sh", gitHooksContainerDir}, gitHookNames...)...,
+		); op.End(err) != nil {
+			return fmt.Errorf("failed to install git hooks: %w", err)
+		}
+		if err := configureSystemGit(ctx, containerID, "core.hooksPath", gitHooksContainerDir, "git core.hooksPath"); err != nil {
+			return err
+		}
+	}
+	// With trailers off but the hooks installed for Co-authored-by, the
+	// SAM-* trailers must be switched off explicitly.
+	if override != nil || (!enabled && coAuthored) {
+		if err := configureSystemGit(ctx, containerID, "sam.commitTrailers", strconv.FormatBool(enabled), "git sam.commitTrailers"); err != nil {
+			return err
+		}
+	}
+	if coAuthored {
+		if err := configureSystemGit(ctx, containerID, "sam.coAuthoredBy", "true", "git sam.coAuthoredBy"); err != nil {
+			return err
+		}
+		if email := strings.TrimSpace(cfg.GitCoAuthorEmail); email != "" {
+			if err := configureSystemGit(ctx, containerID, "sam.coAuthorEmail", email, "git sam.coAuthorEmail"); err != nil {
+				return err
+			}
+		}
+	}
+
+	slog.Info("Configured commit trailer git hooks", "containerID", containerID, "enabled", enabled, "coAuthoredBy", coAuthored)
 	return nil
 }
 
@@ -2812,7 +3890,7 @@ func ensureGitIdentity(ctx context.Context, cfg *config.Config, state *bootstrap
 // as environment variables. Only non-empty values are included.
 // GitHub credentials are intentionally resolved on demand via the credential
 // helper/gh wrapper rather than persisted as static GH_TOKEN exports.
-func buildSAMEnvScript(cfg *config.Config, _ string) string {
+func buildSAMEnvScript(cfg *config.Config) string {
 	baseDomain := config.DeriveBaseDomain(cfg.ControlPlaneURL)
 
 	type envEntry struct {
@@ -2843,14 +3921,14 @@ func buildSAMEnvScript(cfg *config.Config, _ string) string {
 		}
 	}
 
-	if gitrepo.IsGitHubRepo(cfg.Repository) {
+	if isGitHubRepository(cfg) {
 		// Dynamic GH_TOKEN fallback: if the static value was empty (e.g. token
 		// wasn't available at provisioning time), fetch a fresh one from the git
 		// credential helper on shell startup. This ensures PTY sessions always
 		// have a working GH_TOKEN for GitHub-backed projects.
 		sb.WriteString("\n# Dynamic GH_TOKEN fallback — fetch from credential helper if not set\n")
 		sb.WriteString("if [ -z \"$GH_TOKEN\" ] && command -v git >/dev/null 2>&1; then\n")
-		sb.WriteString("  _gh_token=$(printf 'protocol=https\\nhost=github.com\\n\\n' | git credential fill 2>/dev/null | sed -n 's/^password=//p')\n")
+		sb.WriteString("  _gh_token=$(printf 'protocol=https\\nhost=github.com\\n\\n' | SAM_GIT_CAPABILITY=pr git credential fill 2>/dev/null | sed -n 's/^password=//p')\n")
 		sb.WriteString("  if [ -n \"$_gh_token\" ]; then\n")
 		sb.WriteString("    export GH_TOKEN=\"$_gh_token\"\n")
 		sb.WriteString("  fi\n")
@@ -2865,7 +3943,7 @@ func buildSAMEnvScript(cfg *config.Config, _ string) string {
 // shellSingleQuote) for /etc/sam/env. Format: export KEY='value'.
 // Parsed by ReadContainerEnvFiles (parseEnvExportLines) for ACP sessions.
 // GH_TOKEN is excluded so ACP sessions fetch a fresh scoped token at startup.
-func buildSAMStaticEnv(cfg *config.Config, _ string) string {
+func buildSAMStaticEnv(cfg *config.Config) string {
 	baseDomain := config.DeriveBaseDomain(cfg.ControlPlaneURL)
 
 	type envEntry struct {
@@ -2900,45 +3978,46 @@ func buildSAMStaticEnv(cfg *config.Config, _ string) string {
 // ensureSAMEnvironment injects SAM platform metadata as environment variables into
 // the devcontainer. Variables are written to /etc/profile.d/sam-env.sh (sourced by
 // login/interactive shells) and /etc/sam/env (for non-shell consumers).
-// GitHub tokens are resolved on demand; githubToken is accepted for legacy call
-// sites but is not persisted into either environment file.
-func ensureSAMEnvironment(ctx context.Context, cfg *config.Config, githubToken string) error {
+// GitHub tokens are resolved on demand and are not persisted into either file.
+func ensureSAMEnvironment(ctx context.Context, cfg *config.Config) error {
 	containerID, err := findDevcontainerID(ctx, cfg)
 	if err != nil {
 		return fmt.Errorf("failed to locate devcontainer for SAM environment setup: %w", err)
 	}
 
-	shellScript := buildSAMEnvScript(cfg, githubToken)
+	shellScript := buildSAMEnvScript(cfg)
 
 	// Write /etc/profile.d/sam-env.sh (sourced by login shells, includes
 	// dynamic GH_TOKEN fallback) and /etc/sam/env (static KEY=VALUE only,
 	// parsed by ReadContainerEnvFiles for ACP sessions).
 	// The two files are written separately because /etc/sam/env must be a
 	// simple parseable format without shell command substitution.
-	writeCmd := exec.CommandContext(
-		ctx, "docker", "exec", "-u", "root", "-i", containerID,
-		"sh", "-c", "mkdir -p /etc/sam && cat > /etc/profile.d/sam-env.sh",
-	)
-	writeCmd.Stdin = strings.NewReader(shellScript)
-	if output, err := writeCmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to write SAM shell script: %w: %s", err, strings.TrimSpace(string(output)))
+	rt := containerruntime.Current()
+	op := execaudit.Begin(ctx, containerID, "env.write", "/etc/profile.d/sam-env.sh")
+	if err := rt.WriteFile(ctx, containerID, "/etc/profile.d/sam-env.sh", []byte(shellScript), 0o644); op.End(err) != nil {
+		return fmt.Errorf("failed to write SAM shell script: %w", err)
 	}
 
 	// Write static-only env file (strip the dynamic fallback block).
-	staticEnv := buildSAMStaticEnv(cfg, githubToken)
-	writeEnvCmd := exec.CommandContext(
-		ctx, "docker", "exec", "-u", "root", "-i", containerID,
-		"sh", "-c", "cat > /etc/sam/env",
-	)
-	writeEnvCmd.Stdin = strings.NewReader(staticEnv)
-	if output, err := writeEnvCmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to write SAM env file: %w: %s", err, strings.TrimSpace(string(output)))
+	staticEnv := buildSAMStaticEnv(cfg)
+	op = execaudit.Begin(ctx, containerID, "env.write", "/etc/sam/env")
+	_, err = rt.Exec(ctx, containerID, rootExec, "mkdir", "-p", "/etc/sam")
+	if err == nil {
+		err = rt.WriteFile(ctx, containerID, "/etc/sam/env", []byte(staticEnv), 0o644)
+	}
+	if op.End(err) != nil {
+		return fmt.Errorf("failed to write SAM env file: %w", err)
 	}
 
 	slog.Info("Configured SAM environment in devcontainer", "containerID", containerID)
 	return nil
 }
 
+// ValidProjectEnvKey reports whether key can name a project env var.
+func ValidProjectEnvKey(key string) bool {
+	return projectEnvKeyPattern.MatchString(key)
+}
+
 func buildProjectRuntimeEnvScript(envVars []ProjectRuntimeEnvVar) (string, error) {
 	var sb strings.Builder
 	sb.WriteString("# Project runtime environment variables (auto-generated)\n")
@@ -3011,7 +4090,13 @@ func ensureProjectRuntimeAssets(
 	envVars []ProjectRuntimeEnvVar,
 	files []ProjectRuntimeFile,
 ) error {
-	if len(envVars) == 0 && len(files) == 0 {
+	// In secret tmpfs mode the secret install always runs, so its marker
+	// tells a restarted container apart even when there are no secrets.
+	var secretFiles []ProjectRuntimeFile
+	if cfg.SecretFilesTmpfs {
+		files, secretFiles = splitSecretFiles(files)
+	}
+	if len(envVars) == 0 && len(files) == 0 && !cfg.SecretFilesTmpfs {
 		return nil
 	}
 
@@ -3020,56 +4105,39 @@ func ensureProjectRuntimeAssets(
 		return fmt.Errorf("failed to locate devcontainer for project runtime injection: %w", err)
 	}
 
+	envScript := ""
 	if len(envVars) > 0 {
 		script, scriptErr := buildProjectRuntimeEnvScript(envVars)
 		if scriptErr != nil {
 			return scriptErr
 		}
+		envScript = script
+	}
 
-		writeCmd := exec.CommandContext(
-			ctx, "docker", "exec", "-u", "root", "-i", containerID,
-			"sh", "-c", "mkdir -p /etc/sam && cat > /etc/profile.d/sam-project-env.sh && cp /etc/profile.d/sam-project-env.sh /etc/sam/project-env",
-		)
-		writeCmd.Stdin = strings.NewReader(script)
-		if output, writeErr := writeCmd.CombinedOutput(); writeErr != nil {
-			return fmt.Errorf("failed to write project runtime env script: %w: %s", writeErr, strings.TrimSpace(string(output)))
-		}
+	workDir := strings.TrimSpace(cfg.ContainerWorkDir)
+	if len(files)+len(secretFiles) > 0 && workDir == "" {
+		return fmt.Errorf("container workdir is required to inject project runtime files")
 	}
 
-	if len(files) > 0 {
-		baseDir := strings.TrimSpace(cfg.ContainerWorkDir)
-		if baseDir == "" {
-			return fmt.Errorf("container workdir is required to inject project runtime files")
+	// All files and the env script go into one tar stream so projects
+	// injecting dozens of dotfiles pay for a single docker exec.
+	containerUser := strings.TrimSpace(cfg.ContainerUser)
+	if envScript != "" || len(files) > 0 {
+		batch, err := buildProjectRuntimeBatch(envScript, files, containerUser)
+		if err != nil {
+			return err
 		}
-
-		for _, file := range files {
-			normalizedPath, normalizeErr := normalizeProjectRuntimeFilePath(file.Path)
-			if normalizeErr != nil {
-				return normalizeErr
-			}
-
-			var targetPath string
-			if strings.HasPrefix(normalizedPath, "/") || strings.HasPrefix(normalizedPath, "~/") {
-				// Absolute or home-relative path: use as-is inside the container
-				targetPath = normalizedPath
-			} else {
-				// Relative path: resolve against container work directory
-				targetPath = filepath.ToSlash(filepath.Join(baseDir, normalizedPath))
-			}
-			targetDir := filepath.ToSlash(filepath.Dir(targetPath))
-
-			writeCmd := exec.CommandContext(
-				ctx, "docker", "exec", "-u", "root", "-i", containerID,
-				"sh", "-c", fmt.Sprintf("mkdir -p %s && cat > %s", shellSingleQuote(targetDir), shellSingleQuote(targetPath)),
-			)
-			writeCmd.Stdin = strings.NewReader(file.Content)
-			if output, writeErr := writeCmd.CombinedOutput(); writeErr != nil {
-				return fmt.Errorf("failed to write project runtime file %s: %w: %s", normalizedPath, writeErr, strings.TrimSpace(string(output)))
-			}
+		if err := installProjectRuntimeBatch(ctx, containerID, containerUser, workDir, batch); err != nil {
+			return err
+		}
+	}
+	if cfg.SecretFilesTmpfs {
+		if err := installSecretFiles(ctx, containerID, containerUser, workDir, secretFiles); err != nil {
+			return err
 		}
 	}
 
-	slog.Info("Injected project runtime assets in devcontainer", "containerID", containerID, "envVarCount", len(envVars), "fileCount", len(files))
+	slog.Info("Injected project runtime assets in devcontainer", "containerID", containerID, "envVarCount", len(envVars), "fileCount", len(files), "secretFileCount", len(secretFiles))
 	return nil
 }
 
@@ -3090,7 +4158,7 @@ func markWorkspaceReady(ctx context.Context, cfg *config.Config, status, workspa
 
 	endpoint := fmt.Sprintf("%s/api/workspaces/%s/ready", strings.TrimRight(cfg.ControlPlaneURL, "/"), cfg.WorkspaceID)
 
-	return callbackretry.Do(ctx, callbackretry.DefaultConfig(), "workspace-ready", func(retryCtx context.Context) error {
+	return retry.Do(ctx, cfg.ReadyCallbackRetry.OrDefault(retry.DefaultPolicy()), "workspace-ready", func(retryCtx context.Context) error {
 		// Per-request timeout to prevent a single hung request from consuming the entire retry budget
 		requestCtx, cancel := context.WithTimeout(retryCtx, 30*time.Second)
 		defer cancel()
@@ -3114,7 +4182,7 @@ func markWorkspaceReady(ctx context.Context, cfg *config.Config, status, workspa
 			err := fmt.Errorf("ready endpoint returned HTTP %d: %s", res.StatusCode, strings.TrimSpace(string(respBody)))
 			// 4xx errors are permanent — retrying won't help
 			if res.StatusCode >= 400 && res.StatusCode < 500 {
-				return callbackretry.Permanent(err)
+				return retry.Permanent(err)
 			}
 			return err
 		}
@@ -3140,6 +4208,13 @@ func normalizeRepoURL(repo string) string {
 	return "https://github.com/" + repo + ".git"
 }
 
+// AuthenticatedCloneURL embeds token in repoURL for the hosts the agent
+// vends tokens for. cfg identifies a GitLab host; URLs for other hosts are
+// returned unchanged.
+func AuthenticatedCloneURL(repoURL, token string, cfg *config.Config) (string, error) {
+	return withGitToken(repoURL, token, cfg)
+}
+
 func withGitHubToken(repoURL, token string) (string, error) {
 	return withGitToken(repoURL, token, nil)
 }
@@ -3157,27 +4232,55 @@ func withGitToken(repoURL, token string, cfg *config.Config) (string, error) {
 		return repoURL, nil
 	}
 
-	// Only inject credentials for hosts we actually vend tokens for.
+	// Only inject credentials for hosts we actually vend tokens for: GitHub
+	// and Artifacts always, and a host-bound provider's (GitLab, Bitbucket)
+	// host only when the workspace is bound to that provider.
 	host := strings.ToLower(u.Host)
-	isGitLabHost := cfg != nil &&
-		strings.EqualFold(strings.TrimSpace(cfg.RepoProvider), "gitlab") &&
-		strings.EqualFold(strings.TrimSpace(cfg.RepositoryHost), host)
-	if !gitrepo.IsKnownGitHost(host) && !isGitLabHost {
+	var provider gitprovider.Provider
+	if bound := boundGitProvider(cfg); bound.HostBound && bound.MatchesHost(host, cfg.RepositoryHost) {
+		provider = bound
+	} else if gitrepo.IsArtifactsHost(host) {
+		provider = gitprovider.Artifacts
+	} else if gitrepo.IsGitHubCredentialHost(host) {
+		provider = gitprovider.GitHub
+	} else {
 		return repoURL, nil
 	}
 
-	// For GitHub repos use "x-access-token"; for Artifacts use "x"; for
-	// GitLab OAuth use the conventional "oauth2" username.
-	username := "x-access-token"
-	if gitrepo.IsArtifactsHost(host) {
-		username = "x"
-	} else if isGitLabHost {
-		username = "oauth2"
-	}
-	u.User = url.UserPassword(username, token)
+	u.User = url.UserPassword(provider.TokenUsername, token)
 	return u.String(), nil
 }
 
+// boundGitProvider returns the provider cfg's repository is bound to. A nil
+// config is treated as GitHub.
+func boundGitProvider(cfg *config.Config) gitprovider.Provider {
+	if cfg == nil {
+		return gitprovider.GitHub
+	}
+	return gitprovider.ForConfig(cfg.RepoProvider)
+}
+
+// normalizeRepoURLForConfig expands the configured clone URL or repository
+// for the workspace's provider, so GitLab and Bitbucket shorthands do not
+// turn into GitHub URLs. SSH remotes are returned unchanged.
+func normalizeRepoURLForConfig(cfg *config.Config) string {
+	repo := firstNonEmptyString(cfg.CloneURL, cfg.Repository)
+	if isSSHRepoURL(repo) {
+		return repo
+	}
+	provider := boundGitProvider(cfg)
+	if !provider.HostBound {
+		return normalizeRepoURL(repo)
+	}
+	return provider.NormalizeURL(repo, cfg.RepositoryHost)
+}
+
+// isGitHubRepository reports whether the workspace repository is hosted on
+// GitHub, which gates the gh CLI and GH_TOKEN setup.
+func isGitHubRepository(cfg *config.Config) bool {
+	return boundGitProvider(cfg).Name == gitprovider.GitHub.Name && gitrepo.IsGitHubRepo(cfg.Repository)
+}
+
 func firstNonEmptyString(vals ...string) string {
 	for _, val := range vals {
 		if strings.TrimSpace(val) != "" {
@@ -3206,17 +4309,31 @@ func needsCredentialHelperForConfig(cfg *config.Config) bool {
 	if cfg == nil {
 		return false
 	}
-	if strings.TrimSpace(cfg.RepositoryHost) != "" && strings.EqualFold(strings.TrimSpace(cfg.RepoProvider), "gitlab") {
+	if boundGitProvider(cfg).HostBound {
 		return true
 	}
 	return needsCredentialHelper(firstNonEmptyString(cfg.CloneURL, cfg.Repository))
 }
 
+// redactSecret scrubs secret, and every secret registered with the redact
+// package, from input.
 func redactSecret(input, secret string) string {
-	if secret == "" {
-		return input
+	if secret != "" {
+		input = strings.ReplaceAll(input, secret, redact.Placeholder)
+	}
+	return redact.String(input)
+}
+
+// registerStateSecrets registers the credentials in state with the redact
+// package so they never reach logs or persisted artifacts.
+func registerStateSecrets(state *bootstrapState) {
+	if state == nil {
+		return
+	}
+	redact.Register(state.CallbackToken, state.GitHubToken, state.DeployKey, state.SigningKey)
+	for _, cred := range state.RegistryCredentials {
+		redact.Register(cred.Password)
 	}
-	return strings.ReplaceAll(input, secret, "***")
 }
 
 func loadState(path string) (*bootstrapState, error) {
@@ -0,0 +1,148 @@
+package bootstrap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/config"
+	"github.com/workspace/vm-agent/internal/retry"
+)
+
+// buildLogChunkSize bounds each build-log upload request. Logs whose gzipped
+// size exceeds it are sent as several chunks of one upload.
+const buildLogChunkSize = 1 << 20
+
+// uploadBuildErrorLog sends the build error log that persistBuildErrorArtifacts
+// left on the host to the control plane, so the full output of a failed
+// devcontainer build is available beyond the truncated boot log message. It
+// returns the link to the uploaded log, or "" when there is no log or the
+// upload failed. Failures are logged and never block provisioning.
+func uploadBuildErrorLog(ctx context.Context, cfg *config.Config) string {
+	if cfg.ControlPlaneURL == "" || cfg.CallbackToken == "" {
+		return ""
+	}
+	buildLog, err := os.ReadFile(buildErrorLogPath(cfg.WorkspaceDir))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("Failed to read build error log for upload", "workspaceID", cfg.WorkspaceID, "error", err)
+		}
+		return ""
+	}
+	link, err := uploadBuildLog(ctx, cfg, buildLog, buildLogChunkSize)
+	if err != nil {
+		slog.Warn("Failed to upload build error log", "workspaceID", cfg.WorkspaceID, "error", err)
+		return ""
+	}
+	slog.Info("Uploaded build error log", "workspaceID", cfg.WorkspaceID, "bytes", len(buildLog), "link", link)
+	return link
+}
+
+// uploadBuildLog gzips buildLog and POSTs it to
+// /api/workspaces/{id}/build-logs in chunks of at most chunkSize bytes. Every
+// chunk carries the upload ID, its index, and the chunk count as query
+// parameters; the control plane concatenates the chunks in index order to
+// get the gzip stream back. The link is taken from the "url" field of the
+// final chunk's response, falling back to the upload's API path.
+func uploadBuildLog(ctx context.Context, cfg *config.Config, buildLog []byte, chunkSize int) (string, error) {
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err := zw.Write(buildLog); err != nil {
+		return "", fmt.Errorf("failed to compress build log: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return "", fmt.Errorf("failed to compress build log: %w", err)
+	}
+
+	uploadID, err := newBuildLogUploadID()
+	if err != nil {
+		return "", err
+	}
+	endpoint := fmt.Sprintf("%s/api/workspaces/%s/build-logs", strings.TrimRight(cfg.ControlPlaneURL, "/"), url.PathEscape(cfg.WorkspaceID))
+	data := compressed.Bytes()
+	total := (len(data) + chunkSize - 1) / chunkSize
+
+	var link string
+	for i := 0; i < total; i++ {
+		chunk := data[i*chunkSize : min((i+1)*chunkSize, len(data))]
+		query := url.Values{
+			"upload": {uploadID},
+			"chunk":  {strconv.Itoa(i)},
+			"chunks": {strconv.Itoa(total)},
+		}
+		err := retry.Do(ctx, retry.DefaultPolicy(), "build-log-upload", func(ctx context.Context) error {
+			var err error
+			link, err = postBuildLogChunk(ctx, cfg, endpoint+"?"+query.Encode(), chunk)
+			return err
+		})
+		if err != nil {
+			return "", fmt.Errorf("chunk %d of %d: %w", i+1, total, err)
+		}
+	}
+	if link == "" {
+		link = fmt.Sprintf("/api/workspaces/%s/build-logs/%s", url.PathEscape(cfg.WorkspaceID), uploadID)
+	}
+	return link, nil
+}
+
+// buildLogDetail is the boot log detail linking an uploaded build log.
+func buildLogDetail(link string) string {
+	if link == "" {
+		return ""
+	}
+	return "build_log=" + link
+}
+
+func postBuildLogChunk(ctx context.Context, cfg *config.Config, endpoint string, chunk []byte) (string, error) {
+	requestCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(requestCtx, http.MethodPost, endpoint, bytes.NewReader(chunk))
+	if err != nil {
+		return "", retry.Permanent(fmt.Errorf("failed to create build log request: %w", err))
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+	req.Header.Set("Authorization", "Bearer "+cfg.CallbackToken)
+
+	res, err := config.NewControlPlaneClient(cfg.HTTPCallbackTimeout).Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to call build-logs endpoint: %w", err)
+	}
+	defer res.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(res.Body, 8*1024))
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		err := fmt.Errorf("build-logs endpoint returned HTTP %d: %s", res.StatusCode, strings.TrimSpace(string(respBody)))
+		// 4xx errors are permanent — retrying won't help
+		if res.StatusCode >= 400 && res.StatusCode < 500 {
+			return "", retry.Permanent(err)
+		}
+		return "", err
+	}
+
+	var resp struct {
+		URL string `json:"url"`
+	}
+	_ = json.Unmarshal(respBody, &resp)
+	return resp.URL, nil
+}
+
+func newBuildLogUploadID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate build log upload ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,95 @@
+package bootstrap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+func TestUploadBuildLogSendsGzippedChunks(t *testing.T) {
+	t.Parallel()
+	var (
+		mu     sync.Mutex
+		chunks = map[int][]byte{}
+		total  int
+		ids    = map[string]bool{}
+	)
+	cp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/workspaces/ws-1/build-logs" || r.Header.Get("Authorization") != "Bearer cb-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		q := r.URL.Query()
+		index, _ := strconv.Atoi(q.Get("chunk"))
+		mu.Lock()
+		defer mu.Unlock()
+		chunks[index] = body
+		total, _ = strconv.Atoi(q.Get("chunks"))
+		ids[q.Get("upload")] = true
+		if index == total-1 {
+			_, _ = w.Write([]byte(`{"url":"https://app.example.com/build-logs/1"}`))
+		}
+	}))
+	defer cp.Close()
+
+	cfg := &config.Config{ControlPlaneURL: cp.URL, WorkspaceID: "ws-1", CallbackToken: "cb-token"}
+	var buildLog strings.Builder
+	for i := 0; i < 2000; i++ {
+		buildLog.WriteString("step " + strconv.Itoa(i) + ": npm ERR! code E404\n")
+	}
+
+	link, err := uploadBuildLog(context.Background(), cfg, []byte(buildLog.String()), 256)
+	if err != nil {
+		t.Fatalf("uploadBuildLog: %v", err)
+	}
+	if link != "https://app.example.com/build-logs/1" {
+		t.Fatalf("link = %q", link)
+	}
+	if total < 2 || len(chunks) != total || len(ids) != 1 {
+		t.Fatalf("got %d chunks of %d with %d upload IDs, want one chunked upload", len(chunks), total, len(ids))
+	}
+
+	var compressed bytes.Buffer
+	for i := 0; i < total; i++ {
+		compressed.Write(chunks[i])
+	}
+	zr, err := gzip.NewReader(&compressed)
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("decompress: %v", err)
+	}
+	if string(got) != buildLog.String() {
+		t.Fatal("reassembled build log does not match the original")
+	}
+}
+
+func TestUploadBuildLogRejectedIsNotRetried(t *testing.T) {
+	t.Parallel()
+	var calls int
+	cp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer cp.Close()
+
+	cfg := &config.Config{ControlPlaneURL: cp.URL, WorkspaceID: "ws-1", CallbackToken: "cb-token"}
+	if _, err := uploadBuildLog(context.Background(), cfg, []byte("build failed"), buildLogChunkSize); err == nil {
+		t.Fatal("expected an error for a 404 response")
+	}
+	if calls != 1 {
+		t.Fatalf("endpoint called %d times, want 1", calls)
+	}
+}
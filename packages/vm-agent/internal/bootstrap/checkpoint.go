@@ -0,0 +1,220 @@
+package bootstrap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+// Checkpointed bootstrap steps. The names match their boot log steps.
+const (
+	stepVolumeRestore  = "volume_restore"
+	stepGitClone       = "git_clone"
+	stepVolumePopulate = "volume_populate"
+	stepDevcontainerUp = "devcontainer_up"
+	stepWorkspaceReady = "workspace_ready"
+)
+
+const (
+	checkpointStarted   = "started"
+	checkpointCompleted = "completed"
+)
+
+// progress is a workspace's bootstrap checkpoint file. Each step that leaves
+// state behind is marked started before it runs and completed after it
+// succeeds, so a bootstrap restarted after an agent crash can tell an
+// interrupted step (a partial clone or a half-populated volume) from a
+// finished one and repair it instead of building on it; see resumeClone,
+// resumeVolumeRestore, and resumeDevcontainer.
+//
+// Failing to write the file is logged and otherwise ignored: the checkpoint
+// only makes restarts safer and never blocks provisioning. A nil progress
+// records nothing.
+type progress struct {
+	path string
+
+	mu    sync.Mutex
+	state progressState
+}
+
+type progressState struct {
+	WorkspaceID string            `json:"workspaceId"`
+	Steps       map[string]string `json:"steps"`
+	UpdatedAt   time.Time         `json:"updatedAt"`
+}
+
+// progressPath returns the checkpoint file for workspaceID, kept beside the
+// bootstrap state file.
+func progressPath(statePath, workspaceID string) string {
+	return filepath.Join(filepath.Dir(statePath), "bootstrap-progress", sanitizeWorkspaceID(workspaceID)+".json")
+}
+
+// loadProgress reads the workspace's checkpoint file. A missing, unreadable,
+// or foreign file starts a fresh checkpoint. Without a bootstrap state path
+// nothing is checkpointed.
+func loadProgress(cfg *config.Config) *progress {
+	if cfg.BootstrapStatePath == "" {
+		return nil
+	}
+	p := &progress{
+		path:  progressPath(cfg.BootstrapStatePath, cfg.WorkspaceID),
+		state: progressState{WorkspaceID: cfg.WorkspaceID, Steps: map[string]string{}},
+	}
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("Failed to read bootstrap checkpoint, starting fresh", "path", p.path, "error", err)
+		}
+		return p
+	}
+	var saved progressState
+	if err := json.Unmarshal(data, &saved); err != nil || saved.WorkspaceID != cfg.WorkspaceID {
+		slog.Warn("Ignoring invalid bootstrap checkpoint", "path", p.path, "error", err)
+		return p
+	}
+	if saved.Steps != nil {
+		p.state.Steps = saved.Steps
+	}
+	if interrupted := p.interruptedSteps(); len(interrupted) > 0 {
+		slog.Info("Resuming bootstrap after interruption", "workspaceID", cfg.WorkspaceID, "interruptedSteps", interrupted)
+	}
+	return p
+}
+
+// RemoveProgress deletes a workspace's bootstrap checkpoint file.
+func RemoveProgress(cfg *config.Config, workspaceID string) {
+	if cfg.BootstrapStatePath == "" {
+		return
+	}
+	path := progressPath(cfg.BootstrapStatePath, workspaceID)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		slog.Warn("Failed to remove bootstrap checkpoint", "path", path, "error", err)
+	}
+}
+
+// interrupted reports whether step was started but never completed.
+func (p *progress) interrupted(step string) bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state.Steps[step] == checkpointStarted
+}
+
+// completed reports whether step finished in this or an earlier run.
+func (p *progress) completed(step string) bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state.Steps[step] == checkpointCompleted
+}
+
+func (p *progress) interruptedSteps() []string {
+	var steps []string
+	for step, status := range p.state.Steps {
+		if status == checkpointStarted {
+			steps = append(steps, step)
+		}
+	}
+	return steps
+}
+
+// begin marks step as started and persists the checkpoint before the step
+// touches anything.
+func (p *progress) begin(step string) {
+	p.set(step, checkpointStarted)
+}
+
+// complete marks step as completed.
+func (p *progress) complete(step string) {
+	p.set(step, checkpointCompleted)
+}
+
+func (p *progress) set(step, status string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.state.Steps[step] = status
+	p.state.UpdatedAt = time.Now().UTC()
+	if err := p.saveLocked(); err != nil {
+		slog.Warn("Failed to write bootstrap checkpoint", "path", p.path, "step", step, "status", status, "error", err)
+	}
+}
+
+// saveLocked writes the checkpoint atomically, so a crash mid-write leaves
+// the previous checkpoint rather than a truncated one.
+func (p *progress) saveLocked() error {
+	if err := os.MkdirAll(filepath.Dir(p.path), 0o700); err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(p.state)
+	if err != nil {
+		return err
+	}
+	tmp := p.path + ".tmp"
+	if err := os.WriteFile(tmp, encoded, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.path)
+}
+
+// resumeClone removes a host clone that cannot be trusted, so
+// ensureRepositoryReady clones again instead of skipping an existing .git:
+// either the previous clone was interrupted, or the checkpoint says it
+// completed but the clone has no resolvable HEAD.
+func resumeClone(ctx context.Context, cfg *config.Config, p *progress) error {
+	if cfg.Repository == "" {
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(cfg.WorkspaceDir, ".git")); err != nil {
+		return nil
+	}
+	switch {
+	case p.interrupted(stepGitClone):
+		slog.Info("Removing clone left by an interrupted bootstrap", "workspaceDir", cfg.WorkspaceDir)
+	case p.completed(stepGitClone) && !cloneHasHead(ctx, cfg.WorkspaceDir):
+		slog.Warn("Checkpointed clone has no HEAD, cloning again", "workspaceDir", cfg.WorkspaceDir)
+	default:
+		return nil
+	}
+	return os.RemoveAll(cfg.WorkspaceDir)
+}
+
+func cloneHasHead(ctx context.Context, workspaceDir string) bool {
+	return exec.CommandContext(ctx, "git", "-C", workspaceDir, "rev-parse", "--verify", "--quiet", "HEAD").Run() == nil
+}
+
+// resumeVolumeRestore empties a volume whose snapshot restore was
+// interrupted. RestoreVolume only restores into an empty volume, so a
+// partially extracted snapshot would otherwise be kept as the workspace.
+func resumeVolumeRestore(ctx context.Context, volumeName string, p *progress) error {
+	if volumeName == "" || !p.interrupted(stepVolumeRestore) {
+		return nil
+	}
+	slog.Info("Clearing volume left by an interrupted snapshot restore", "volumeName", volumeName)
+	return clearVolume(ctx, volumeName)
+}
+
+// resumeDevcontainer removes containers from an interrupted devcontainer
+// build, so devcontainer up does not adopt a container whose creation or
+// lifecycle commands never finished.
+func resumeDevcontainer(ctx context.Context, cfg *config.Config, p *progress) {
+	if !p.interrupted(stepDevcontainerUp) {
+		return
+	}
+	slog.Info("Removing devcontainer left by an interrupted bootstrap", "workspaceID", cfg.WorkspaceID)
+	removeStaleContainers(ctx, cfg)
+}
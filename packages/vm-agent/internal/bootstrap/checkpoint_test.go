@@ -0,0 +1,129 @@
+package bootstrap
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+func TestProgressSurvivesRestart(t *testing.T) {
+	t.Parallel()
+	cfg := &config.Config{
+		WorkspaceID:        "ws-1",
+		BootstrapStatePath: filepath.Join(t.TempDir(), "bootstrap-state.json"),
+	}
+
+	p := loadProgress(cfg)
+	p.begin(stepGitClone)
+	p.complete(stepGitClone)
+	p.begin(stepVolumePopulate)
+
+	// A restarted agent sees the finished clone and the interrupted sync.
+	resumed := loadProgress(cfg)
+	if !resumed.completed(stepGitClone) || resumed.interrupted(stepGitClone) {
+		t.Fatalf("git_clone not checkpointed as completed: %+v", resumed.state)
+	}
+	if !resumed.interrupted(stepVolumePopulate) {
+		t.Fatalf("volume_populate not checkpointed as interrupted: %+v", resumed.state)
+	}
+
+	other := *cfg
+	other.WorkspaceID = "ws-2"
+	if loadProgress(&other).interrupted(stepVolumePopulate) {
+		t.Fatal("checkpoint leaked into another workspace")
+	}
+
+	RemoveProgress(cfg, cfg.WorkspaceID)
+	if loadProgress(cfg).completed(stepGitClone) {
+		t.Fatal("checkpoint survived RemoveProgress")
+	}
+}
+
+func TestProgressIgnoresCorruptFile(t *testing.T) {
+	t.Parallel()
+	cfg := &config.Config{
+		WorkspaceID:        "ws-1",
+		BootstrapStatePath: filepath.Join(t.TempDir(), "bootstrap-state.json"),
+	}
+	path := progressPath(cfg.BootstrapStatePath, cfg.WorkspaceID)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("{truncated"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	p := loadProgress(cfg)
+	if p.interrupted(stepGitClone) || p.completed(stepGitClone) {
+		t.Fatalf("corrupt checkpoint was used: %+v", p.state)
+	}
+	p.begin(stepGitClone)
+	if !loadProgress(cfg).interrupted(stepGitClone) {
+		t.Fatal("checkpoint not rewritten over corrupt file")
+	}
+
+	var nilProgress *progress
+	nilProgress.begin(stepGitClone)
+	if nilProgress.interrupted(stepGitClone) {
+		t.Fatal("nil progress recorded a step")
+	}
+}
+
+func TestResumeCloneRemovesUntrustedClones(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	ctx := context.Background()
+
+	newClone := func(t *testing.T, withCommit bool) *config.Config {
+		t.Helper()
+		dir := filepath.Join(t.TempDir(), "repo")
+		cmds := [][]string{{"init", "-q", dir}}
+		if withCommit {
+			cmds = append(cmds, []string{"-C", dir, "-c", "user.name=t", "-c", "user.email=t@example.com", "commit", "-q", "--allow-empty", "-m", "init"})
+		}
+		for _, args := range cmds {
+			if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+				t.Fatalf("git %v: %v: %s", args, err, out)
+			}
+		}
+		return &config.Config{
+			WorkspaceID:        "ws-1",
+			Repository:         "octo/repo",
+			WorkspaceDir:       dir,
+			BootstrapStatePath: filepath.Join(t.TempDir(), "bootstrap-state.json"),
+		}
+	}
+
+	tests := []struct {
+		name       string
+		withCommit bool
+		status     string
+		wantKept   bool
+	}{
+		{name: "interrupted clone", withCommit: true, status: checkpointStarted, wantKept: false},
+		{name: "completed clone without HEAD", withCommit: false, status: checkpointCompleted, wantKept: false},
+		{name: "completed clone", withCommit: true, status: checkpointCompleted, wantKept: true},
+		{name: "clone from before checkpoints", withCommit: false, status: "", wantKept: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newClone(t, tt.withCommit)
+			p := loadProgress(cfg)
+			if tt.status != "" {
+				p.set(stepGitClone, tt.status)
+			}
+			if err := resumeClone(ctx, cfg, p); err != nil {
+				t.Fatalf("resumeClone: %v", err)
+			}
+			_, err := os.Stat(cfg.WorkspaceDir)
+			if kept := err == nil; kept != tt.wantKept {
+				t.Fatalf("clone kept = %v, want %v", kept, tt.wantKept)
+			}
+		})
+	}
+}
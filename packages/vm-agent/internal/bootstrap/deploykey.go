@@ -0,0 +1,164 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/workspace/vm-agent/internal/config"
+	"github.com/workspace/vm-agent/internal/containerruntime"
+	"github.com/workspace/vm-agent/internal/execaudit"
+)
+
+const (
+	// deployKeyContainerDir holds the deploy key and known_hosts inside the
+	// devcontainer. git's system-level core.sshCommand points here.
+	deployKeyContainerDir = "/etc/sam/ssh"
+	deployKeyFilename     = "deploy_key"
+	knownHostsFilename    = "known_hosts"
+)
+
+// scpRepoPattern matches scp-like SSH remotes such as git@github.com:owner/repo.git.
+var scpRepoPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+@[A-Za-z0-9.-]+:.+$`)
+
+// isSSHRepoURL reports whether repo is an ssh:// URL or an scp-like
+// user@host:path remote. These are cloned with a deploy key instead of an
+// HTTPS token.
+func isSSHRepoURL(repo string) bool {
+	repo = strings.TrimSpace(repo)
+	if strings.HasPrefix(repo, "ssh://") {
+		return true
+	}
+	return !strings.Contains(repo, "://") && scpRepoPattern.MatchString(repo)
+}
+
+// ValidateDeployKey checks that key is an unencrypted SSH private key, since
+// bootstrap has no way to prompt for a passphrase.
+func ValidateDeployKey(key string) error {
+	if _, err := ssh.ParseRawPrivateKey([]byte(key)); err != nil {
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			return errors.New("deploy key must not be passphrase-protected")
+		}
+		return fmt.Errorf("invalid deploy key: %w", err)
+	}
+	return nil
+}
+
+// gitSSHCommand builds the ssh command git uses for SSH remotes. With
+// known_hosts from the control plane, host keys are checked strictly;
+// without it, the first key seen for a host is trusted and recorded.
+func gitSSHCommand(keyPath, knownHostsPath string, strictHostKeys bool) string {
+	checking := "accept-new"
+	if strictHostKeys {
+		checking = "yes"
+	}
+	return fmt.Sprintf("ssh -i %s -o IdentitiesOnly=yes -o UserKnownHostsFile=%s -o StrictHostKeyChecking=%s",
+		shellSingleQuote(keyPath), shellSingleQuote(knownHostsPath), checking)
+}
+
+// writeDeployKeyToHost writes the deploy key and known_hosts to a private temp
+// directory for the host-side clone. It returns the GIT_SSH_COMMAND to use and
+// a cleanup func that removes the directory; the key is not kept on the host
+// once the clone is done.
+func writeDeployKeyToHost(key, knownHosts string) (string, func(), error) {
+	dir, err := os.MkdirTemp("", "sam-deploy-key-*")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create deploy key directory: %w", err)
+	}
+	cleanup := func() {
+		if err := os.RemoveAll(dir); err != nil {
+			slog.Warn("Failed to remove deploy key from host", "dir", dir, "error", err)
+		}
+	}
+
+	keyPath := filepath.Join(dir, deployKeyFilename)
+	knownHostsPath := filepath.Join(dir, knownHostsFilename)
+	if err := os.WriteFile(keyPath, []byte(normalizeKeyFile(key)), 0o600); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("failed to write deploy key: %w", err)
+	}
+	if err := os.WriteFile(knownHostsPath, []byte(normalizeKeyFile(knownHosts)), 0o600); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("failed to write known_hosts: %w", err)
+	}
+	return gitSSHCommand(keyPath, knownHostsPath, strings.TrimSpace(knownHosts) != ""), cleanup, nil
+}
+
+// normalizeKeyFile trims surrounding whitespace and ends non-empty content
+// with a newline; OpenSSH rejects private keys without a trailing newline.
+func normalizeKeyFile(content string) string {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return ""
+	}
+	return content + "\n"
+}
+
+// installKeyFileScript writes stdin to $2, creating its directory $1 with
+// mode 0755, and gives the file to owner $3 with mode $4.
+const installKeyFileScript = `mkdir -p "$1" && chmod 0755 "$1" && cat > "$2" && chown "$3" "$2" && chmod "$4" "$2"`
+
+// installKeyFile writes content to filePath in a container, owned by owner
+// with mode. The content travels on stdin, never in arguments.
+func installKeyFile(ctx context.Context, containerID, filePath, content, owner, mode string) error {
+	_, err := containerruntime.Current().Exec(ctx, containerID,
+		containerruntime.ExecOptions{User: "root", Stdin: strings.NewReader(content)},
+		"sh", "-c", installKeyFileScript, "sh", path.Dir(filePath), filePath, owner, mode)
+	return err
+}
+
+// ensureDeployKey installs the deploy key and known_hosts into the
+// devcontainer and points git's system-level core.sshCommand at them, so
+// fetches and pushes from inside the workspace use the same key as the clone.
+// It is a no-op unless the repository is an SSH remote and a key was delivered.
+func ensureDeployKey(ctx context.Context, cfg *config.Config, state *bootstrapState) error {
+	if state == nil || strings.TrimSpace(state.DeployKey) == "" {
+		return nil
+	}
+	if !isSSHRepoURL(firstNonEmptyString(cfg.CloneURL, cfg.Repository)) {
+		slog.Info("Repository is not an SSH remote, skipping deploy key setup", "repository", cfg.Repository)
+		return nil
+	}
+
+	containerID, err := findDevcontainerID(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to locate devcontainer for deploy key setup: %w", err)
+	}
+
+	owner := strings.TrimSpace(cfg.ContainerUser)
+	if owner == "" {
+		owner = "root"
+	}
+	keyPath := deployKeyContainerDir + "/" + deployKeyFilename
+	knownHostsPath := deployKeyContainerDir + "/" + knownHostsFilename
+	files := []struct {
+		path    string
+		content string
+		mode    string
+	}{
+		{keyPath, state.DeployKey, "0600"},
+		{knownHostsPath, state.KnownHosts, "0644"},
+	}
+	for _, f := range files {
+		op := execaudit.Begin(ctx, containerID, "deploy_key.install", f.path)
+		if err := installKeyFile(ctx, containerID, f.path, normalizeKeyFile(f.content), owner, f.mode); op.End(err) != nil {
+			return fmt.Errorf("failed to install %s in devcontainer: %w", filepath.Base(f.path), err)
+		}
+	}
+
+	sshCommand := gitSSHCommand(keyPath, knownHostsPath, strings.TrimSpace(state.KnownHosts) != "")
+	if err := configureSystemGit(ctx, containerID, "core.sshCommand", sshCommand, "git ssh command"); err != nil {
+		return err
+	}
+	slog.Info("Installed deploy key in devcontainer", "containerID", containerID, "owner", owner)
+	return nil
+}
@@ -0,0 +1,119 @@
+package bootstrap
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+func testDeployKey(t *testing.T, passphrase string) string {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	var block *pem.Block
+	if passphrase == "" {
+		block, err = ssh.MarshalPrivateKey(priv, "deploy")
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, "deploy", []byte(passphrase))
+	}
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	return string(pem.EncodeToMemory(block))
+}
+
+func TestIsSSHRepoURL(t *testing.T) {
+	t.Parallel()
+
+	for repo, want := range map[string]bool{
+		"git@github.com:octo/repo.git":             true,
+		"deploy@git.example.com:team/repo":         true,
+		"ssh://git@git.example.com:2222/team/repo": true,
+		"https://github.com/octo/repo.git":         false,
+		"https://user@github.com/octo/repo.git":    false,
+		"octo/repo":                                false,
+		"git@github.com:/srv/git/repo.git":         true,
+		"":                                         false,
+	} {
+		if got := isSSHRepoURL(repo); got != want {
+			t.Errorf("isSSHRepoURL(%q) = %v, want %v", repo, got, want)
+		}
+	}
+}
+
+func TestValidateDeployKey(t *testing.T) {
+	t.Parallel()
+
+	if err := ValidateDeployKey(testDeployKey(t, "")); err != nil {
+		t.Fatalf("valid key rejected: %v", err)
+	}
+	if err := ValidateDeployKey(testDeployKey(t, "secret")); err == nil || !strings.Contains(err.Error(), "passphrase") {
+		t.Fatalf("passphrase-protected key error = %v", err)
+	}
+	if err := ValidateDeployKey("not a key"); err == nil {
+		t.Fatal("expected garbage key to be rejected")
+	}
+}
+
+func TestWriteDeployKeyToHost(t *testing.T) {
+	t.Parallel()
+
+	key := testDeployKey(t, "")
+	sshCommand, cleanup, err := writeDeployKeyToHost(key, "")
+	if err != nil {
+		t.Fatalf("writeDeployKeyToHost: %v", err)
+	}
+	if !strings.Contains(sshCommand, "StrictHostKeyChecking=accept-new") || !strings.Contains(sshCommand, "IdentitiesOnly=yes") {
+		t.Fatalf("ssh command without known_hosts = %q", sshCommand)
+	}
+
+	keyPath := strings.Trim(strings.Fields(sshCommand)[2], "'")
+	info, err := os.Stat(keyPath)
+	if err != nil {
+		t.Fatalf("stat key: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("key mode = %o, want 600", info.Mode().Perm())
+	}
+	if data, _ := os.ReadFile(keyPath); string(data) != strings.TrimSpace(key)+"\n" {
+		t.Fatal("key file content does not match")
+	}
+
+	cleanup()
+	if _, err := os.Stat(filepath.Dir(keyPath)); !os.IsNotExist(err) {
+		t.Fatalf("deploy key directory still exists after cleanup: %v", err)
+	}
+
+	sshCommand, cleanup, err = writeDeployKeyToHost(key, "github.com ssh-ed25519 AAAA")
+	if err != nil {
+		t.Fatalf("writeDeployKeyToHost with known_hosts: %v", err)
+	}
+	defer cleanup()
+	if !strings.Contains(sshCommand, "StrictHostKeyChecking=yes") {
+		t.Fatalf("ssh command with known_hosts = %q", sshCommand)
+	}
+}
+
+func TestNormalizeRepoURLForConfigKeepsSSHRemotes(t *testing.T) {
+	t.Parallel()
+
+	for _, cfg := range []config.Config{
+		{Repository: "git@github.com:octo/repo.git"},
+		{RepoProvider: "gitlab", RepositoryHost: "git.example.com", Repository: "octo/repo", CloneURL: "ssh://git@git.example.com/octo/repo.git"},
+	} {
+		want := firstNonEmptyString(cfg.CloneURL, cfg.Repository)
+		if got := normalizeRepoURLForConfig(&cfg); got != want {
+			t.Errorf("normalizeRepoURLForConfig(%+v) = %q, want %q", cfg, got, want)
+		}
+	}
+}
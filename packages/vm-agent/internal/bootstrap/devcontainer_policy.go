@@ -0,0 +1,351 @@
+package bootstrap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/workspace/vm-agent/internal/bootlog"
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+// DevcontainerPolicy is an organization's rules for the images and features
+// a repository's devcontainer config may use. It is checked against the
+// resolved config before `devcontainer up` runs any of it.
+type DevcontainerPolicy struct {
+	// AllowedRegistries lists the registry hosts images and OCI features may
+	// come from, e.g. ghcr.io or mcr.microsoft.com. Empty allows any.
+	AllowedRegistries []string `json:"allowedRegistries,omitempty"`
+	// DeniedFeatures lists feature IDs without a version, e.g.
+	// ghcr.io/devcontainers/features/docker-in-docker. An entry ending in
+	// "/" denies every feature under that namespace.
+	DeniedFeatures []string `json:"deniedFeatures,omitempty"`
+	// RequireDigests requires images and OCI features to be pinned with an
+	// @sha256: digest.
+	RequireDigests bool `json:"requireDigests,omitempty"`
+}
+
+// Validate reports empty or malformed entries. A nil policy is valid.
+func (p *DevcontainerPolicy) Validate() error {
+	if p == nil {
+		return nil
+	}
+	for _, registry := range p.AllowedRegistries {
+		registry = strings.TrimSpace(registry)
+		if registry == "" || strings.ContainsAny(registry, "/@ ") {
+			return fmt.Errorf("allowedRegistries: %q is not a registry host", registry)
+		}
+	}
+	for _, feature := range p.DeniedFeatures {
+		if strings.TrimSpace(feature) == "" {
+			return fmt.Errorf("deniedFeatures: empty entry")
+		}
+	}
+	return nil
+}
+
+// DevcontainerPolicyError lists what a devcontainer config does that its
+// DevcontainerPolicy forbids.
+type DevcontainerPolicyError struct {
+	Violations []string
+}
+
+func (e *DevcontainerPolicyError) Error() string {
+	return "devcontainer config violates policy: " + strings.Join(e.Violations, "; ")
+}
+
+// ociRef is a parsed image or OCI feature reference.
+type ociRef struct {
+	registry string
+	name     string // repository path without tag or digest
+	pinned   bool   // has an @sha256: digest
+}
+
+func (r ociRef) id() string {
+	return r.registry + "/" + r.name
+}
+
+// parseOCIRef splits an image reference the way Docker does: the first path
+// component is the registry when it looks like a host, else Docker Hub.
+func parseOCIRef(ref string) ociRef {
+	ref = strings.ToLower(strings.TrimSpace(ref))
+	var parsed ociRef
+	if at := strings.Index(ref, "@"); at >= 0 {
+		parsed.pinned = strings.HasPrefix(ref[at+1:], "sha256:")
+		ref = ref[:at]
+	}
+	if slash := strings.LastIndex(ref, "/"); strings.LastIndex(ref, ":") > slash {
+		ref = ref[:strings.LastIndex(ref, ":")]
+	}
+	parsed.registry = "docker.io"
+	parsed.name = ref
+	if first, rest, ok := strings.Cut(ref, "/"); ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		parsed.registry, parsed.name = first, rest
+	}
+	switch parsed.registry {
+	case "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		parsed.registry = "docker.io"
+	}
+	if parsed.registry == "docker.io" && !strings.Contains(parsed.name, "/") {
+		parsed.name = "library/" + parsed.name
+	}
+	return parsed
+}
+
+func (p *DevcontainerPolicy) registryAllowed(registry string) bool {
+	if len(p.AllowedRegistries) == 0 {
+		return true
+	}
+	for _, allowed := range p.AllowedRegistries {
+		if strings.EqualFold(strings.TrimSpace(allowed), registry) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *DevcontainerPolicy) featureDenied(id string) bool {
+	for _, denied := range p.DeniedFeatures {
+		denied = strings.ToLower(strings.TrimSpace(denied))
+		if strings.HasSuffix(denied, "/") {
+			if strings.HasPrefix(id+"/", denied) {
+				return true
+			}
+			continue
+		}
+		if id == denied || id == parseOCIRef(denied).id() {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *DevcontainerPolicy) checkImage(ref, source string) []string {
+	var violations []string
+	parsed := parseOCIRef(ref)
+	if !p.registryAllowed(parsed.registry) {
+		violations = append(violations, fmt.Sprintf("%s %q is from registry %s, which is not allowed", source, ref, parsed.registry))
+	}
+	if p.RequireDigests && !parsed.pinned {
+		violations = append(violations, fmt.Sprintf("%s %q is not pinned to a sha256 digest", source, ref))
+	}
+	return violations
+}
+
+func (p *DevcontainerPolicy) checkFeature(ref string) []string {
+	trimmed := strings.TrimSpace(ref)
+	switch {
+	case strings.HasPrefix(trimmed, "./"), strings.HasPrefix(trimmed, "../"):
+		// Local features are part of the repository.
+		if p.featureDenied(strings.ToLower(trimmed)) {
+			return []string{fmt.Sprintf("feature %q is denied", ref)}
+		}
+		return nil
+	case strings.HasPrefix(trimmed, "https://"), strings.HasPrefix(trimmed, "http://"):
+		var violations []string
+		u, err := url.Parse(trimmed)
+		if err != nil {
+			return []string{fmt.Sprintf("feature %q is not a valid URL", ref)}
+		}
+		if p.featureDenied(strings.ToLower(trimmed)) {
+			violations = append(violations, fmt.Sprintf("feature %q is denied", ref))
+		}
+		if !p.registryAllowed(u.Host) {
+			violations = append(violations, fmt.Sprintf("feature %q is from %s, which is not allowed", ref, u.Host))
+		}
+		if p.RequireDigests {
+			violations = append(violations, fmt.Sprintf("feature %q is a tarball URL and cannot be pinned to a digest", ref))
+		}
+		return violations
+	}
+
+	if !strings.Contains(trimmed, "/") {
+		// Legacy short IDs resolve to the devcontainers feature collection.
+		trimmed = "ghcr.io/devcontainers/features/" + trimmed
+	}
+	parsed := parseOCIRef(trimmed)
+	var violations []string
+	if p.featureDenied(parsed.id()) {
+		violations = append(violations, fmt.Sprintf("feature %q is denied", ref))
+	}
+	if !p.registryAllowed(parsed.registry) {
+		violations = append(violations, fmt.Sprintf("feature %q is from registry %s, which is not allowed", ref, parsed.registry))
+	}
+	if p.RequireDigests && !parsed.pinned {
+		violations = append(violations, fmt.Sprintf("feature %q is not pinned to a sha256 digest", ref))
+	}
+	return violations
+}
+
+// Check returns the policy violations of a devcontainer configuration.
+// configs are the raw and merged configurations from read-configuration;
+// configDir is the directory holding devcontainer.json, against which a
+// build Dockerfile is resolved.
+func (p *DevcontainerPolicy) Check(configDir string, configs ...map[string]interface{}) []string {
+	if p == nil {
+		return nil
+	}
+	var violations []string
+	seenFeatures := map[string]bool{}
+	seenImages := map[string]bool{}
+	for _, c := range configs {
+		if image, ok := c["image"].(string); ok && strings.TrimSpace(image) != "" && !seenImages[image] {
+			seenImages[image] = true
+			violations = append(violations, p.checkImage(image, "image")...)
+		}
+		if dockerfile, buildArgs := configDockerfile(c); dockerfile != "" && !seenImages["dockerfile:"+dockerfile] {
+			seenImages["dockerfile:"+dockerfile] = true
+			violations = append(violations, p.checkDockerfile(filepath.Join(configDir, dockerfile), buildArgs)...)
+		}
+		features, _ := c["features"].(map[string]interface{})
+		ids := make([]string, 0, len(features))
+		for id := range features {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			if seenFeatures[id] {
+				continue
+			}
+			seenFeatures[id] = true
+			violations = append(violations, p.checkFeature(id)...)
+		}
+	}
+	return violations
+}
+
+// configDockerfile returns the Dockerfile path and build args of a
+// devcontainer config, from either build.dockerfile or the older top-level
+// dockerFile.
+func configDockerfile(c map[string]interface{}) (string, map[string]string) {
+	args := map[string]string{}
+	dockerfile, _ := c["dockerFile"].(string)
+	if build, ok := c["build"].(map[string]interface{}); ok {
+		if df, ok := build["dockerfile"].(string); ok && df != "" {
+			dockerfile = df
+		}
+		if rawArgs, ok := build["args"].(map[string]interface{}); ok {
+			for k, v := range rawArgs {
+				if s, ok := v.(string); ok {
+					args[k] = s
+				}
+			}
+		}
+	}
+	return strings.TrimSpace(dockerfile), args
+}
+
+// checkDockerfile checks the base images of a Dockerfile's FROM lines.
+// Variables are expanded from ARG defaults and the config's build args; a
+// base image that still cannot be resolved is reported, since its registry
+// cannot be verified.
+func (p *DevcontainerPolicy) checkDockerfile(path string, buildArgs map[string]string) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return []string{fmt.Sprintf("Dockerfile %s cannot be read: %v", filepath.Base(path), err)}
+	}
+	args := map[string]string{}
+	stages := map[string]bool{"scratch": true}
+	var violations []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "ARG":
+			name, value, _ := strings.Cut(fields[1], "=")
+			if v, ok := buildArgs[name]; ok {
+				value = v
+			}
+			args[name] = strings.Trim(value, `"'`)
+		case "FROM":
+			rest := fields[1:]
+			for len(rest) > 0 && strings.HasPrefix(rest[0], "--") {
+				rest = rest[1:]
+			}
+			if len(rest) == 0 {
+				continue
+			}
+			unresolved := false
+			image := os.Expand(rest[0], func(name string) string {
+				v, ok := args[name]
+				if !ok || v == "" {
+					unresolved = true
+				}
+				return v
+			})
+			switch {
+			case unresolved:
+				violations = append(violations, fmt.Sprintf("Dockerfile base image %q cannot be resolved", rest[0]))
+			case !stages[strings.ToLower(image)]:
+				violations = append(violations, p.checkImage(image, "Dockerfile base image")...)
+			}
+			if len(rest) >= 3 && strings.EqualFold(rest[1], "as") {
+				stages[strings.ToLower(rest[2])] = true
+			}
+		}
+	}
+	return violations
+}
+
+// devcontainerConfigDir returns the directory of the devcontainer.json that
+// `devcontainer up` uses for the workspace.
+func devcontainerConfigDir(workspaceDir, devcontainerConfigName string) string {
+	if devcontainerConfigName != "" {
+		return filepath.Dir(namedDevcontainerConfigPath(workspaceDir, devcontainerConfigName))
+	}
+	if _, err := os.Stat(filepath.Join(workspaceDir, devcontainerDirname, devcontainerFilename)); err == nil {
+		return filepath.Join(workspaceDir, devcontainerDirname)
+	}
+	return workspaceDir
+}
+
+// checkDevcontainerPolicy resolves the repository's devcontainer config with
+// read-configuration and checks it against policy. Repositories without a
+// config use the node's default image and are not checked.
+func checkDevcontainerPolicy(ctx context.Context, cfg *config.Config, policy *DevcontainerPolicy, devcontainerConfigName string) error {
+	if policy == nil || (devcontainerConfigName == "" && !hasDevcontainerConfig(cfg.WorkspaceDir)) {
+		return nil
+	}
+	if err := waitForCommand(ctx, "devcontainer"); err != nil {
+		return fmt.Errorf("devcontainer CLI never became available: %w", err)
+	}
+	readResult, err := runReadConfiguration(ctx, cfg.WorkspaceDir, devcontainerConfigName)
+	if err != nil {
+		return fmt.Errorf("cannot check devcontainer policy: %w", err)
+	}
+	configDir := devcontainerConfigDir(cfg.WorkspaceDir, devcontainerConfigName)
+	if violations := policy.Check(configDir, readResult.Configuration, readResult.MergedConfiguration); len(violations) > 0 {
+		return &DevcontainerPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// ensureDevcontainerPolicy checks the repository's devcontainer config
+// before it is built. A devcontainer that is already running was checked
+// when it was built and is left alone.
+func ensureDevcontainerPolicy(ctx context.Context, cfg *config.Config, policy *DevcontainerPolicy, devcontainerConfigName string, reporter *bootlog.Reporter) error {
+	if policy == nil {
+		return nil
+	}
+	if _, err := findDevcontainerID(ctx, cfg); err == nil {
+		return nil
+	}
+	reporter.Log("devcontainer_policy", "started", "Checking devcontainer config against policy")
+	if err := checkDevcontainerPolicy(ctx, cfg, policy, devcontainerConfigName); err != nil {
+		slog.Warn("Devcontainer policy check failed", "workspaceID", cfg.WorkspaceID, "error", err)
+		reporter.Log("devcontainer_policy", "failed", "Devcontainer config rejected by policy", err.Error())
+		return err
+	}
+	reporter.Log("devcontainer_policy", "completed", "Devcontainer config allowed by policy")
+	return nil
+}
@@ -0,0 +1,157 @@
+package bootstrap
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDevcontainerPolicyValidate(t *testing.T) {
+	t.Parallel()
+
+	valid := []*DevcontainerPolicy{
+		nil,
+		{},
+		{AllowedRegistries: []string{"ghcr.io", "registry.example.com:5000"}, DeniedFeatures: []string{"ghcr.io/devcontainers/features/docker-in-docker", "ghcr.io/untrusted/"}, RequireDigests: true},
+	}
+	for _, p := range valid {
+		if err := p.Validate(); err != nil {
+			t.Errorf("Validate(%+v) = %v", p, err)
+		}
+	}
+	invalid := []*DevcontainerPolicy{
+		{AllowedRegistries: []string{""}},
+		{AllowedRegistries: []string{"ghcr.io/org"}},
+		{DeniedFeatures: []string{" "}},
+	}
+	for _, p := range invalid {
+		if err := p.Validate(); err == nil {
+			t.Errorf("Validate(%+v) accepted invalid policy", p)
+		}
+	}
+}
+
+func TestParseOCIRef(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]ociRef{
+		"ubuntu:22.04": {registry: "docker.io", name: "library/ubuntu"},
+		"mcr.microsoft.com/devcontainers/base:ubuntu":    {registry: "mcr.microsoft.com", name: "devcontainers/base"},
+		"localhost:5000/team/img":                        {registry: "localhost:5000", name: "team/img"},
+		"ghcr.io/devcontainers/features/node@sha256:abc": {registry: "ghcr.io", name: "devcontainers/features/node", pinned: true},
+		"index.docker.io/org/img:1":                      {registry: "docker.io", name: "org/img"},
+		"GHCR.io/Org/Feature:1":                          {registry: "ghcr.io", name: "org/feature"},
+	}
+	for ref, want := range tests {
+		if got := parseOCIRef(ref); got != want {
+			t.Errorf("parseOCIRef(%q) = %+v, want %+v", ref, got, want)
+		}
+	}
+}
+
+func TestDevcontainerPolicyCheck(t *testing.T) {
+	t.Parallel()
+
+	policy := &DevcontainerPolicy{
+		AllowedRegistries: []string{"ghcr.io", "mcr.microsoft.com"},
+		DeniedFeatures:    []string{"ghcr.io/devcontainers/features/docker-in-docker", "ghcr.io/untrusted/"},
+	}
+	allowed := map[string]interface{}{
+		"image": "mcr.microsoft.com/devcontainers/base:ubuntu",
+		"features": map[string]interface{}{
+			"ghcr.io/devcontainers/features/node:1": map[string]interface{}{},
+			"./local-feature":                       map[string]interface{}{},
+		},
+	}
+	if got := policy.Check(t.TempDir(), allowed); len(got) != 0 {
+		t.Fatalf("allowed config reported %v", got)
+	}
+
+	rejected := map[string]interface{}{
+		"image": "docker.io/library/ubuntu:22.04",
+		"features": map[string]interface{}{
+			"ghcr.io/devcontainers/features/docker-in-docker:2": map[string]interface{}{},
+			"ghcr.io/untrusted/tools/miner:1":                   map[string]interface{}{},
+			"quay.io/org/feature:1":                             map[string]interface{}{},
+			"https://example.com/feature.tgz":                   map[string]interface{}{},
+		},
+	}
+	got := policy.Check(t.TempDir(), rejected)
+	for _, want := range []string{
+		`image "docker.io/library/ubuntu:22.04" is from registry docker.io`,
+		`feature "ghcr.io/devcontainers/features/docker-in-docker:2" is denied`,
+		`feature "ghcr.io/untrusted/tools/miner:1" is denied`,
+		`feature "quay.io/org/feature:1" is from registry quay.io`,
+		`feature "https://example.com/feature.tgz" is from example.com`,
+	} {
+		if !containsViolation(got, want) {
+			t.Errorf("violations %v missing %q", got, want)
+		}
+	}
+}
+
+func TestDevcontainerPolicyCheckRequireDigests(t *testing.T) {
+	t.Parallel()
+
+	policy := &DevcontainerPolicy{RequireDigests: true}
+	pinned := "ghcr.io/devcontainers/features/node@sha256:0123"
+	config := map[string]interface{}{
+		"image": "mcr.microsoft.com/devcontainers/base@sha256:4567",
+		"features": map[string]interface{}{
+			pinned:   map[string]interface{}{},
+			"node":   map[string]interface{}{},
+			"./mine": map[string]interface{}{},
+		},
+	}
+	got := policy.Check(t.TempDir(), config, config)
+	want := []string{`feature "node" is not pinned to a sha256 digest`}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("violations = %v, want %v", got, want)
+	}
+}
+
+func TestDevcontainerPolicyCheckDockerfile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	dockerfile := `ARG VARIANT=3.12
+FROM --platform=linux/amd64 mcr.microsoft.com/devcontainers/python:${VARIANT} AS base
+FROM base AS dev
+ARG TOOLS_IMAGE
+FROM ${TOOLS_IMAGE} AS tools
+FROM docker.io/library/golang:1.22
+FROM scratch
+`
+	if err := os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte(dockerfile), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	policy := &DevcontainerPolicy{AllowedRegistries: []string{"mcr.microsoft.com"}}
+	config := map[string]interface{}{"build": map[string]interface{}{"dockerfile": "Dockerfile"}}
+
+	got := policy.Check(dir, config)
+	want := []string{
+		`Dockerfile base image "${TOOLS_IMAGE}" cannot be resolved`,
+		`Dockerfile base image "docker.io/library/golang:1.22" is from registry docker.io, which is not allowed`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("violations = %v, want %v", got, want)
+	}
+
+	config["build"].(map[string]interface{})["args"] = map[string]interface{}{"TOOLS_IMAGE": "mcr.microsoft.com/tools:1"}
+	got = policy.Check(dir, config)
+	want = want[1:]
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("with build args: violations = %v, want %v", got, want)
+	}
+}
+
+func containsViolation(violations []string, prefix string) bool {
+	for _, v := range violations {
+		if strings.HasPrefix(v, prefix) {
+			return true
+		}
+	}
+	return false
+}
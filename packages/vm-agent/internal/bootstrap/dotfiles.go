@@ -0,0 +1,99 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/workspace/vm-agent/internal/config"
+	"github.com/workspace/vm-agent/internal/tracing"
+)
+
+const (
+	// dotfilesSection names the dotfiles marker under envTemplateMarkerDir.
+	dotfilesSection = "dotfiles"
+	// dotfilesDir is where the dotfiles repository is cloned, relative to
+	// the container user's home.
+	dotfilesDir = ".dotfiles"
+)
+
+// dotfilesScript clones or updates the dotfiles repository ($1) into
+// $HOME/$2, then runs its install script like Codespaces and Gitpod do. A
+// repository without one has its top-level dotfiles symlinked into $HOME.
+const dotfilesScript = `set -e
+dir="$HOME/$2"
+if [ -d "$dir/.git" ]; then
+  git -C "$dir" pull --ff-only
+elif [ -e "$dir" ]; then
+  echo "$dir exists and is not a git checkout" >&2
+  exit 1
+else
+  git clone --depth 1 "$1" "$dir"
+fi
+for script in install.sh install bootstrap.sh bootstrap setup.sh setup script/bootstrap script/setup; do
+  if [ -f "$dir/$script" ]; then
+    chmod +x "$dir/$script"
+    cd "$dir"
+    exec "./$script"
+  fi
+done
+for f in "$dir"/.[!.]*; do
+  [ -e "$f" ] || continue
+  name=$(basename "$f")
+  [ "$name" = ".git" ] && continue
+  ln -sfn "$f" "$HOME/$name"
+done
+`
+
+// ValidateDotfilesRepository checks that repo is an https:// URL without
+// embedded credentials. Dotfiles are cloned as the container user, which has
+// no credentials for arbitrary hosts, so only public https repositories work.
+func ValidateDotfilesRepository(repo string) error {
+	u, err := url.Parse(strings.TrimSpace(repo))
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return errors.New("dotfiles repository must be an https:// URL")
+	}
+	if u.User != nil {
+		return errors.New("dotfiles repository must not embed credentials")
+	}
+	return nil
+}
+
+// resolveDotfilesRepository returns the workspace's dotfiles repository: the
+// environment template's, else the node's DOTFILES_REPOSITORY.
+func resolveDotfilesRepository(cfg *config.Config, tmpl *EnvironmentTemplate) string {
+	if repo := tmpl.dotfilesRepository(); repo != "" {
+		return repo
+	}
+	return strings.TrimSpace(cfg.DotfilesRepository)
+}
+
+// ensureDotfiles clones repo into the container user's ~/.dotfiles and runs
+// its install script as that user. Like environment template sections, a
+// marker skips the step when the same repository was already installed in
+// this container.
+func ensureDotfiles(ctx context.Context, cfg *config.Config, repo string) (err error) {
+	ctx, span := tracing.Start(ctx, "dotfiles.install")
+	defer func() { span.End(err) }()
+
+	if err := ValidateDotfilesRepository(repo); err != nil {
+		return err
+	}
+	containerUser := strings.TrimSpace(cfg.ContainerUser)
+	if containerUser == "" {
+		return errors.New("container user is required to install dotfiles")
+	}
+
+	containerID, err := findDevcontainerID(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to locate devcontainer for dotfiles: %w", err)
+	}
+	return runEnvTemplateSteps(ctx, containerID, []envTemplateStep{{
+		section: dotfilesSection,
+		user:    containerUser,
+		script:  dotfilesScript,
+		args:    []string{repo, dotfilesDir},
+	}})
+}
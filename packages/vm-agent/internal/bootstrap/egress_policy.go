@@ -0,0 +1,23 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/workspace/vm-agent/internal/config"
+	"github.com/workspace/vm-agent/internal/egress"
+)
+
+// ensureEgressPolicy installs the workspace's egress policy in its
+// devcontainer. It runs after SAM's own setup steps, which need package
+// registries the policy may not allow, and before the workspace is marked
+// ready, so agent sessions never start unrestricted. Failure is fatal: a
+// workspace that asked for a policy must not run without one.
+func ensureEgressPolicy(ctx context.Context, cfg *config.Config, policy *egress.Policy) error {
+	containerID, err := findDevcontainerID(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to find devcontainer for egress policy: %w", err)
+	}
+	return egress.Apply(ctx, containerID, policy, net.DefaultResolver)
+}
@@ -0,0 +1,341 @@
+package bootstrap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/workspace/vm-agent/internal/config"
+	"github.com/workspace/vm-agent/internal/containerruntime"
+	"github.com/workspace/vm-agent/internal/execaudit"
+	"github.com/workspace/vm-agent/internal/tracing"
+)
+
+const (
+	// envTemplateMarkerDir holds one marker per applied template section,
+	// containing the hash of the section's inputs. It lives in the container
+	// filesystem, so a rebuilt container starts without markers and the
+	// template is applied again.
+	envTemplateMarkerDir = "/var/lib/sam/env-template"
+	// envTemplateProfileScript holds the template's shell aliases.
+	envTemplateProfileScript = "/etc/profile.d/sam-template.sh"
+
+	maxEnvTemplatePackages = 100
+	maxEnvTemplateAliases  = 100
+)
+
+// Environment template sections, in the order they are applied.
+const (
+	envTemplateSectionApt   = "apt"
+	envTemplateSectionNpm   = "npm"
+	envTemplateSectionPip   = "pip"
+	envTemplateSectionShell = "shell"
+)
+
+var (
+	// envTemplatePackagePattern accepts apt (name=version), npm
+	// (@scope/name@version), and pip (name[extra]>=version) specs while
+	// excluding whitespace and shell metacharacters. A leading '-' is
+	// rejected so a package can never be read as an option.
+	envTemplatePackagePattern = regexp.MustCompile(`^[A-Za-z0-9@][A-Za-z0-9._+:/@=<>~^!,\[\]-]*$`)
+	envTemplateAliasPattern   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+	envTemplateShells         = map[string]bool{"bash": true, "zsh": true, "fish": true, "sh": true}
+)
+
+// EnvironmentTemplate is per-user setup the control plane sends with the
+// bootstrap response, applied inside the devcontainer after it is built so
+// users do not have to rerun the same setup in every workspace.
+type EnvironmentTemplate struct {
+	AptPackages []string `json:"aptPackages,omitempty"`
+	NpmPackages []string `json:"npmPackages,omitempty"`
+	PipPackages []string `json:"pipPackages,omitempty"`
+	// DotfilesRepo overrides DOTFILES_REPOSITORY for this workspace. It is
+	// installed by the dotfiles step after the rest of the template; see
+	// ensureDotfiles.
+	DotfilesRepo string `json:"dotfilesRepo,omitempty"`
+	// Shell is the container user's login shell: bash, zsh, fish, or sh. It
+	// must be installed, e.g. through AptPackages.
+	Shell string `json:"shell,omitempty"`
+	// Aliases are shell aliases written to /etc/profile.d/sam-template.sh.
+	Aliases map[string]string `json:"aliases,omitempty"`
+}
+
+// hasSetup reports whether the template has packages, a shell, or aliases
+// to apply. DotfilesRepo is handled by the separate dotfiles step.
+func (t *EnvironmentTemplate) hasSetup() bool {
+	return t != nil && (len(t.AptPackages) > 0 || len(t.NpmPackages) > 0 || len(t.PipPackages) > 0 ||
+		strings.TrimSpace(t.Shell) != "" || len(t.Aliases) > 0)
+}
+
+// dotfilesRepository returns the template's dotfiles repository, if any.
+func (t *EnvironmentTemplate) dotfilesRepository() string {
+	if t == nil {
+		return ""
+	}
+	return strings.TrimSpace(t.DotfilesRepo)
+}
+
+// Validate checks package names, the dotfiles URL, the shell, and alias
+// names. Values are passed to the container as arguments rather than spliced
+// into scripts, but are still restricted so a template cannot smuggle in
+// options or shell syntax.
+func (t *EnvironmentTemplate) Validate() error {
+	if t == nil {
+		return nil
+	}
+	for field, packages := range map[string][]string{
+		"aptPackages": t.AptPackages,
+		"npmPackages": t.NpmPackages,
+		"pipPackages": t.PipPackages,
+	} {
+		if len(packages) > maxEnvTemplatePackages {
+			return fmt.Errorf("%s: at most %d packages are allowed", field, maxEnvTemplatePackages)
+		}
+		for _, pkg := range packages {
+			if !envTemplatePackagePattern.MatchString(pkg) {
+				return fmt.Errorf("%s: invalid package %q", field, pkg)
+			}
+		}
+	}
+	if repo := strings.TrimSpace(t.DotfilesRepo); repo != "" {
+		if err := ValidateDotfilesRepository(repo); err != nil {
+			return fmt.Errorf("dotfilesRepo: %w", err)
+		}
+	}
+	if shell := strings.TrimSpace(t.Shell); shell != "" && !envTemplateShells[shell] {
+		return fmt.Errorf("shell must be one of bash, zsh, fish, or sh")
+	}
+	if len(t.Aliases) > maxEnvTemplateAliases {
+		return fmt.Errorf("aliases: at most %d aliases are allowed", maxEnvTemplateAliases)
+	}
+	for name, value := range t.Aliases {
+		if !envTemplateAliasPattern.MatchString(name) {
+			return fmt.Errorf("aliases: invalid alias name %q", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("aliases: alias %q must be a single line", name)
+		}
+	}
+	return nil
+}
+
+// envTemplateStep is one section of a template, run with a single docker exec.
+type envTemplateStep struct {
+	section string
+	// user runs the script; empty means the container's default user.
+	user   string
+	script string
+	args   []string
+}
+
+// hash identifies the step's inputs; the step is skipped when its marker
+// already holds this value.
+func (s envTemplateStep) hash() string {
+	sum := sha256.New()
+	sum.Write([]byte(s.section + "\n" + s.user + "\n" + s.script))
+	for _, arg := range s.args {
+		sum.Write([]byte("\n" + arg))
+	}
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+const envTemplateAptScript = `set -e
+if ! command -v apt-get >/dev/null 2>&1; then
+  echo "apt-get is not available in this container" >&2
+  exit 1
+fi
+missing=""
+for pkg in "$@"; do
+  case "$pkg" in
+  *=*) missing="$missing $pkg" ;;
+  *) dpkg -s "$pkg" >/dev/null 2>&1 || missing="$missing $pkg" ;;
+  esac
+done
+if [ -n "$missing" ]; then
+  apt-get update
+  DEBIAN_FRONTEND=noninteractive apt-get install -y --no-install-recommends $missing
+fi
+`
+
+const envTemplateNpmScript = `set -e
+if ! command -v npm >/dev/null 2>&1; then
+  echo "npm is not available in this container" >&2
+  exit 1
+fi
+npm install -g --no-fund --no-audit --prefer-offline "$@"
+`
+
+const envTemplatePipScript = `set -e
+if command -v python3 >/dev/null 2>&1 && python3 -m pip --version >/dev/null 2>&1; then
+  PIP_BREAK_SYSTEM_PACKAGES=1 python3 -m pip install --user --disable-pip-version-check "$@"
+else
+  echo "pip is not available in this container" >&2
+  exit 1
+fi
+`
+
+const envTemplateShellScript = `set -e
+shell_path=$(command -v "$1") || { echo "$1 is not installed in this container" >&2; exit 1; }
+grep -qx "$shell_path" /etc/shells 2>/dev/null || echo "$shell_path" >> /etc/shells
+if command -v chsh >/dev/null 2>&1; then
+  chsh -s "$shell_path" "$2"
+else
+  usermod -s "$shell_path" "$2"
+fi
+`
+
+// buildEnvTemplateSteps turns a validated template into the steps to run.
+// System-wide installs run as root; pip runs as containerUser so packages
+// land in that user's home.
+func buildEnvTemplateSteps(t *EnvironmentTemplate, containerUser string) ([]envTemplateStep, error) {
+	if !t.hasSetup() {
+		return nil, nil
+	}
+	var steps []envTemplateStep
+	if len(t.AptPackages) > 0 {
+		steps = append(steps, envTemplateStep{section: envTemplateSectionApt, user: "root", script: envTemplateAptScript, args: t.AptPackages})
+	}
+	if len(t.NpmPackages) > 0 {
+		steps = append(steps, envTemplateStep{section: envTemplateSectionNpm, user: "root", script: envTemplateNpmScript, args: t.NpmPackages})
+	}
+	if len(t.PipPackages) > 0 {
+		steps = append(steps, envTemplateStep{section: envTemplateSectionPip, user: containerUser, script: envTemplatePipScript, args: t.PipPackages})
+	}
+	if shell := strings.TrimSpace(t.Shell); shell != "" || len(t.Aliases) > 0 {
+		if shell != "" && containerUser == "" {
+			return nil, errors.New("container user is required to set the login shell")
+		}
+		steps = append(steps, envTemplateStep{
+			section: envTemplateSectionShell,
+			user:    "root",
+			script:  buildEnvTemplateShellScript(shell, t.Aliases),
+			args:    []string{shell, containerUser},
+		})
+	}
+	return steps, nil
+}
+
+// buildEnvTemplateShellScript writes the alias profile script and, when
+// shell is set, changes the login shell.
+func buildEnvTemplateShellScript(shell string, aliases map[string]string) string {
+	var profile strings.Builder
+	profile.WriteString("# SAM environment template aliases (auto-generated)\n")
+	names := make([]string, 0, len(aliases))
+	for name := range aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		profile.WriteString(fmt.Sprintf("alias %s=%s\n", name, shellSingleQuote(aliases[name])))
+	}
+
+	var sb strings.Builder
+	sb.WriteString("set -e\n")
+	if len(aliases) > 0 {
+		sb.WriteString(fmt.Sprintf("cat > %s <<'SAM_TEMPLATE_EOF'\n%sSAM_TEMPLATE_EOF\n", envTemplateProfileScript, profile.String()))
+	} else {
+		sb.WriteString(fmt.Sprintf("rm -f %s\n", envTemplateProfileScript))
+	}
+	if shell != "" {
+		// Drop the leading "set -e"; it is already written above.
+		sb.WriteString(strings.TrimPrefix(envTemplateShellScript, "set -e\n"))
+	}
+	return sb.String()
+}
+
+// parseEnvTemplateMarkers parses "<section> <hash>" lines.
+func parseEnvTemplateMarkers(output string) map[string]string {
+	markers := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		section, hash, ok := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		if ok && section != "" && hash != "" {
+			markers[section] = strings.TrimSpace(hash)
+		}
+	}
+	return markers
+}
+
+// ensureEnvironmentTemplate applies the environment template inside the
+// devcontainer. Each section is skipped when its marker shows the same inputs
+// were already applied to this container, so restarts and recovery do not
+// reinstall anything.
+func ensureEnvironmentTemplate(ctx context.Context, cfg *config.Config, tmpl *EnvironmentTemplate) (err error) {
+	if !tmpl.hasSetup() {
+		return nil
+	}
+	ctx, span := tracing.Start(ctx, "env_template.apply")
+	defer func() { span.End(err) }()
+
+	if err := tmpl.Validate(); err != nil {
+		return fmt.Errorf("invalid environment template: %w", err)
+	}
+	steps, err := buildEnvTemplateSteps(tmpl, strings.TrimSpace(cfg.ContainerUser))
+	if err != nil {
+		return err
+	}
+
+	containerID, err := findDevcontainerID(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to locate devcontainer for environment template: %w", err)
+	}
+	return runEnvTemplateSteps(ctx, containerID, steps)
+}
+
+// runEnvTemplateSteps runs the steps whose markers are missing or stale. A
+// failing step does not stop the others; its marker is left unchanged so it
+// is retried on the next provisioning run.
+func runEnvTemplateSteps(ctx context.Context, containerID string, steps []envTemplateStep) error {
+	rt := containerruntime.Current()
+	markers := map[string]string{}
+	if result, err := rt.Exec(ctx, containerID, rootExec, "sh", "-c",
+		`for f in "$1"/*; do [ -f "$f" ] && printf '%s %s\n' "$(basename "$f")" "$(cat "$f")"; done; exit 0`,
+		"sh", envTemplateMarkerDir); err == nil {
+		markers = parseEnvTemplateMarkers(string(result.Stdout))
+	}
+
+	var errs []error
+	var applied []string
+	for _, step := range steps {
+		hash := step.hash()
+		if markers[step.section] == hash {
+			slog.Info("Environment template section already applied", "section", step.section, "containerID", containerID)
+			continue
+		}
+		var op *execaudit.Op
+		if step.user == "root" {
+			op = execaudit.Begin(ctx, containerID, "env_template."+step.section, strings.Join(step.args, " "))
+		}
+		var output bytes.Buffer
+		_, runErr := rt.Exec(ctx, containerID, containerruntime.ExecOptions{User: step.user, Output: &output},
+			append([]string{"sh", "-c", step.script, "sh"}, step.args...)...)
+		if op != nil {
+			op.End(runErr)
+		}
+		if runErr != nil {
+			errs = append(errs, fmt.Errorf("%s: %w: %s", step.section, runErr, strings.TrimSpace(output.String())))
+			continue
+		}
+		applied = append(applied, step.section, hash)
+		slog.Info("Applied environment template section", "section", step.section, "containerID", containerID)
+	}
+
+	if len(applied) > 0 {
+		op := execaudit.Begin(ctx, containerID, "env_template.markers", envTemplateMarkerDir)
+		if _, writeErr := rt.Exec(ctx, containerID, rootExec, append([]string{"sh", "-c",
+			`set -e; dir="$1"; shift; mkdir -p "$dir"; while [ $# -gt 1 ]; do printf '%s\n' "$2" > "$dir/$1"; shift 2; done`,
+			"sh", envTemplateMarkerDir,
+		}, applied...)...); op.End(writeErr) != nil {
+			errs = append(errs, fmt.Errorf("write environment template markers: %w", writeErr))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,126 @@
+// Package gitprovider describes the git hosting providers a workspace
+// repository can live on: how owner/repo shorthands expand to clone URLs,
+// which host a vended token is good for, and which username the token is
+// paired with over HTTPS.
+package gitprovider
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/workspace/vm-agent/internal/gitrepo"
+)
+
+// Provider describes one git hosting provider.
+type Provider struct {
+	// Name is the provider identifier used in control-plane payloads.
+	Name string
+	// DefaultHost is the hosted service's git host. Shorthand repositories
+	// expand against it when no repository host is bound.
+	DefaultHost string
+	// TokenUsername is the basic-auth username that goes with an access
+	// token for this provider.
+	TokenUsername string
+	// HostBound is true for providers whose credentials are only released
+	// for the workspace's bound repository host.
+	HostBound bool
+	// PathBound is true for providers whose tokens may reach more than the
+	// workspace's repository (user OAuth tokens, app passwords), so the
+	// credential exchange must verify the requested repository path.
+	PathBound bool
+}
+
+var (
+	GitHub    = Provider{Name: "github", DefaultHost: "github.com", TokenUsername: "x-access-token"}
+	GitLab    = Provider{Name: "gitlab", DefaultHost: "gitlab.com", TokenUsername: "oauth2", HostBound: true, PathBound: true}
+	Bitbucket = Provider{Name: "bitbucket", DefaultHost: "bitbucket.org", TokenUsername: "x-token-auth", HostBound: true, PathBound: true}
+	Artifacts = Provider{Name: "artifacts", TokenUsername: "x"}
+)
+
+var providers = []Provider{GitHub, GitLab, Bitbucket, Artifacts}
+
+// Lookup returns the provider with the given name. An empty name is GitHub,
+// which is what workspaces created before providers existed use.
+func Lookup(name string) (Provider, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return GitHub, true
+	}
+	for _, p := range providers {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Provider{}, false
+}
+
+// Known reports whether name is a supported provider, including the empty
+// GitHub default.
+func Known(name string) bool {
+	_, ok := Lookup(name)
+	return ok
+}
+
+// ForConfig resolves a configured provider name, treating unknown names as
+// GitHub so callers never need to handle a missing provider.
+func ForConfig(name string) Provider {
+	p, ok := Lookup(name)
+	if !ok {
+		return GitHub
+	}
+	return p
+}
+
+// TokenHost is the host this provider's token is vended for. Host-bound
+// providers use the workspace's bound repository host, falling back to the
+// hosted service. GitHub returns github.com; Artifacts has no fixed host and
+// returns repositoryHost as given.
+func (p Provider) TokenHost(repositoryHost string) string {
+	repositoryHost = strings.ToLower(strings.TrimSpace(repositoryHost))
+	if p.Name == GitHub.Name {
+		return GitHub.DefaultHost
+	}
+	if repositoryHost != "" {
+		return repositoryHost
+	}
+	return p.DefaultHost
+}
+
+// MatchesHost reports whether a token for this provider may be sent to host.
+func (p Provider) MatchesHost(host, repositoryHost string) bool {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if host == "" {
+		return false
+	}
+	switch p.Name {
+	case GitHub.Name:
+		return gitrepo.IsGitHubCredentialHost(host)
+	case Artifacts.Name:
+		return gitrepo.IsArtifactsHost(host)
+	}
+	return host == p.TokenHost(repositoryHost)
+}
+
+// NormalizeURL expands repo into an HTTPS clone URL ending in .git. Full
+// http(s) URLs keep their host; owner/repo shorthands, optionally prefixed
+// with the host, expand against TokenHost(repositoryHost).
+func (p Provider) NormalizeURL(repo, repositoryHost string) string {
+	repo = strings.TrimSpace(repo)
+	if repo == "" {
+		return ""
+	}
+	if p.Name == GitHub.Name || p.Name == Artifacts.Name {
+		return gitrepo.NormalizeURL(repo)
+	}
+	if strings.HasPrefix(repo, "http://") || strings.HasPrefix(repo, "https://") {
+		if !strings.HasSuffix(repo, ".git") {
+			return repo + ".git"
+		}
+		return repo
+	}
+
+	host := p.TokenHost(repositoryHost)
+	repo = strings.TrimPrefix(repo, host+"/")
+	repo = strings.Trim(strings.TrimSuffix(repo, ".git"), "/")
+	return (&url.URL{Scheme: "https", Host: host, Path: "/" + repo + ".git"}).String()
+}
@@ -0,0 +1,72 @@
+package gitprovider
+
+import "testing"
+
+func TestLookup(t *testing.T) {
+	t.Parallel()
+
+	for name, want := range map[string]string{"": "github", "GitHub": "github", " gitlab ": "gitlab", "bitbucket": "bitbucket", "artifacts": "artifacts"} {
+		got, ok := Lookup(name)
+		if !ok || got.Name != want {
+			t.Errorf("Lookup(%q) = %q, %v; want %q", name, got.Name, ok, want)
+		}
+	}
+	if _, ok := Lookup("sourcehut"); ok {
+		t.Error("Lookup(sourcehut) should fail")
+	}
+	if got := ForConfig("sourcehut"); got.Name != "github" {
+		t.Errorf("ForConfig(sourcehut) = %q, want github", got.Name)
+	}
+}
+
+func TestNormalizeURL(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		provider Provider
+		repo     string
+		host     string
+		want     string
+	}{
+		{GitHub, "octo/repo", "", "https://github.com/octo/repo.git"},
+		{GitLab, "group/sub/project", "", "https://gitlab.com/group/sub/project.git"},
+		{GitLab, "group/project", "GitLab.Example.com", "https://gitlab.example.com/group/project.git"},
+		{GitLab, "gitlab.example.com/group/project.git", "gitlab.example.com", "https://gitlab.example.com/group/project.git"},
+		{Bitbucket, "team/repo", "", "https://bitbucket.org/team/repo.git"},
+		{Bitbucket, "https://bitbucket.org/team/repo", "", "https://bitbucket.org/team/repo.git"},
+		{Bitbucket, "", "", ""},
+	}
+	for _, tc := range tests {
+		if got := tc.provider.NormalizeURL(tc.repo, tc.host); got != tc.want {
+			t.Errorf("%s.NormalizeURL(%q, %q) = %q, want %q", tc.provider.Name, tc.repo, tc.host, got, tc.want)
+		}
+	}
+}
+
+func TestMatchesHost(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		provider Provider
+		host     string
+		bound    string
+		want     bool
+	}{
+		{GitHub, "github.com", "", true},
+		{GitHub, "api.github.com", "", true},
+		{GitHub, "gitlab.com", "", false},
+		{GitLab, "gitlab.com", "", true},
+		{GitLab, "GitLab.Example.com", "gitlab.example.com", true},
+		{GitLab, "gitlab.com", "gitlab.example.com", false},
+		{Bitbucket, "bitbucket.org", "", true},
+		{Bitbucket, "github.com", "", false},
+		{Artifacts, "acct.artifacts.cloudflare.net", "", true},
+		{Artifacts, "github.com", "", false},
+		{GitLab, "", "", false},
+	}
+	for _, tc := range tests {
+		if got := tc.provider.MatchesHost(tc.host, tc.bound); got != tc.want {
+			t.Errorf("%s.MatchesHost(%q, %q) = %v, want %v", tc.provider.Name, tc.host, tc.bound, got, tc.want)
+		}
+	}
+}
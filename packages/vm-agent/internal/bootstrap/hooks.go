@@ -0,0 +1,239 @@
+package bootstrap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/bootlog"
+	"github.com/workspace/vm-agent/internal/config"
+	"github.com/workspace/vm-agent/internal/containerruntime"
+)
+
+// Lifecycle hook points. preClone and postClone run on the host; the others
+// run inside the devcontainer.
+const (
+	HookPreClone           = "preClone"
+	HookPostClone          = "postClone"
+	HookPostDevcontainerUp = "postDevcontainerUp"
+	HookPreShutdown        = "preShutdown"
+)
+
+const (
+	// repoHooksDir holds repository-defined hooks, relative to the repository
+	// root. A hook is a file named after its point, with or without ".sh".
+	repoHooksDir = ".sam/hooks"
+	// maxHookScriptSize bounds an inline hook script from the control plane.
+	maxHookScriptSize = 64 * 1024
+	// maxHookOutputDetail is how much of a failed hook's output, from the
+	// end, is attached to its boot log entry.
+	maxHookOutputDetail = 2048
+	// hookWaitDelay is how long a timed-out host hook's output pipes may
+	// stay open after the hook is killed.
+	hookWaitDelay = 5 * time.Second
+)
+
+// hookRunsOnHost lists the points that run before a container exists. Only
+// control-plane hooks are run there: repository hooks are untrusted and only
+// ever run inside the devcontainer.
+var hookRunsOnHost = map[string]bool{HookPreClone: true, HookPostClone: true}
+
+var knownHookPoints = map[string]bool{
+	HookPreClone:           true,
+	HookPostClone:          true,
+	HookPostDevcontainerUp: true,
+	HookPreShutdown:        true,
+}
+
+// Hooks maps lifecycle points to inline shell scripts defined by the user or
+// an admin on the control plane.
+type Hooks map[string]string
+
+// Validate rejects unknown hook points and oversized scripts.
+func (h Hooks) Validate() error {
+	for point, script := range h {
+		if !knownHookPoints[point] {
+			return fmt.Errorf("unknown hook point %q (want preClone, postClone, postDevcontainerUp, or preShutdown)", point)
+		}
+		if len(script) > maxHookScriptSize {
+			return fmt.Errorf("%s hook exceeds %d bytes", point, maxHookScriptSize)
+		}
+	}
+	return nil
+}
+
+// hookEnv is the environment injected into every hook. workDir is the
+// repository root as seen by the hook: the host clone for host hooks, the
+// container workdir otherwise.
+func hookEnv(cfg *config.Config, point, workDir string) []string {
+	return []string{
+		"SAM_HOOK=" + point,
+		"SAM_WORKSPACE_ID=" + cfg.WorkspaceID,
+		"SAM_REPOSITORY=" + cfg.Repository,
+		"SAM_BRANCH=" + cfg.Branch,
+		"SAM_WORKSPACE_DIR=" + workDir,
+	}
+}
+
+// RunLifecycleHooks runs the hooks for point and reports each in the boot
+// log as the hook_<point> step. The control plane's hook runs first, then
+// the repository's .sam/hooks/<point> for in-container points. Hooks are
+// bounded by HOOK_TIMEOUT. A failing hook is logged and does not stop
+// provisioning or shutdown, so hooks should be idempotent and safe to skip.
+// It is safe to pass a nil reporter.
+func RunLifecycleHooks(ctx context.Context, cfg *config.Config, hooks Hooks, point string, reporter *bootlog.Reporter) {
+	step := "hook_" + point
+	if script := strings.TrimSpace(hooks[point]); script != "" {
+		reporter.Log(step, "started", fmt.Sprintf("Running %s hook", point))
+		if output, err := runHookScript(ctx, cfg, point, script); err != nil {
+			reporter.Log(step, "failed", fmt.Sprintf("%s hook failed (non-fatal)", point), hookFailureDetail(err, output))
+			slog.Warn("Lifecycle hook failed (non-fatal)", "hook", point, "workspaceID", cfg.WorkspaceID, "error", err)
+		} else {
+			reporter.Log(step, "completed", fmt.Sprintf("%s hook completed", point))
+		}
+	}
+
+	if hookRunsOnHost[point] {
+		return
+	}
+	hookPath, containerID, err := findRepoHook(ctx, cfg, point)
+	if err != nil {
+		slog.Debug("Skipping repository lifecycle hook", "hook", point, "workspaceID", cfg.WorkspaceID, "error", err)
+		return
+	}
+	if hookPath == "" {
+		return
+	}
+	reporter.Log(step, "started", fmt.Sprintf("Running repository %s hook", point), hookPath)
+	if output, err := runRepoHook(ctx, cfg, containerID, point, hookPath); err != nil {
+		reporter.Log(step, "failed", fmt.Sprintf("Repository %s hook failed (non-fatal)", point), hookFailureDetail(err, output))
+		slog.Warn("Repository lifecycle hook failed (non-fatal)", "hook", point, "path", hookPath, "workspaceID", cfg.WorkspaceID, "error", err)
+	} else {
+		reporter.Log(step, "completed", fmt.Sprintf("Repository %s hook completed", point))
+	}
+}
+
+// StateHooks returns the hooks redeemed with the workspace's bootstrap token,
+// for running preShutdown hooks in workspace mode.
+func StateHooks(cfg *config.Config) (Hooks, error) {
+	state, err := loadState(cfg.BootstrapStatePath)
+	if err != nil || state == nil {
+		return nil, err
+	}
+	return state.Hooks, nil
+}
+
+func hookContext(ctx context.Context, cfg *config.Config) (context.Context, context.CancelFunc) {
+	if cfg.HookTimeout > 0 {
+		return context.WithTimeout(ctx, cfg.HookTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// runHookScript runs an inline control-plane hook, on the host for
+// preClone/postClone and as the container user in the devcontainer
+// otherwise.
+func runHookScript(ctx context.Context, cfg *config.Config, point, script string) ([]byte, error) {
+	ctx, cancel := hookContext(ctx, cfg)
+	defer cancel()
+
+	if hookRunsOnHost[point] {
+		cmd := exec.CommandContext(ctx, "sh", "-c", script)
+		cmd.Env = append(os.Environ(), hookEnv(cfg, point, cfg.WorkspaceDir)...)
+		if info, err := os.Stat(cfg.WorkspaceDir); err == nil && info.IsDir() {
+			cmd.Dir = cfg.WorkspaceDir
+		}
+		return runHookCommand(ctx, cmd)
+	}
+
+	containerID, err := findDevcontainerID(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate devcontainer for %s hook: %w", point, err)
+	}
+	return runContainerHook(ctx, cfg, containerID, point, "sh", "-c", script)
+}
+
+// findRepoHook returns the container path of the repository's hook for
+// point, or "" when the repository does not define one.
+func findRepoHook(ctx context.Context, cfg *config.Config, point string) (string, string, error) {
+	workDir := strings.TrimSpace(cfg.ContainerWorkDir)
+	if workDir == "" {
+		return "", "", errors.New("container workdir is not set")
+	}
+	containerID, err := findDevcontainerID(ctx, cfg)
+	if err != nil {
+		return "", "", err
+	}
+	base := path.Join(workDir, repoHooksDir, point)
+	result, err := containerruntime.Current().Exec(ctx, containerID, containerruntime.ExecOptions{}, "sh", "-c",
+		`for f in "$1" "$1.sh"; do if [ -f "$f" ]; then echo "$f"; exit 0; fi; done`, "sh", base)
+	if err != nil {
+		return "", "", fmt.Errorf("check for repository hook: %w", err)
+	}
+	return strings.TrimSpace(string(result.Stdout)), containerID, nil
+}
+
+// runRepoHook runs a repository hook as the container user. Executable
+// hooks run directly so they can use any interpreter; others run with sh.
+func runRepoHook(ctx context.Context, cfg *config.Config, containerID, point, hookPath string) ([]byte, error) {
+	ctx, cancel := hookContext(ctx, cfg)
+	defer cancel()
+	return runContainerHook(ctx, cfg, containerID, point,
+		"sh", "-c", `if [ -x "$1" ]; then exec "$1"; fi; exec sh "$1"`, "sh", hookPath)
+}
+
+// runHookCommand runs a host hook and reports a kill by the hook timeout as
+// such. WaitDelay stops a background process that inherited the output pipe
+// from holding the hook open past its timeout.
+func runHookCommand(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	cmd.WaitDelay = hookWaitDelay
+	output, err := cmd.CombinedOutput()
+	return output, hookTimeoutError(ctx, err)
+}
+
+// runContainerHook runs command in the devcontainer as the container user,
+// with the hook environment, and returns its combined output.
+func runContainerHook(ctx context.Context, cfg *config.Config, containerID, point string, command ...string) ([]byte, error) {
+	var output bytes.Buffer
+	_, err := containerruntime.Current().Exec(ctx, containerID, hookExecOptions(cfg, point, &output), command...)
+	return output.Bytes(), hookTimeoutError(ctx, err)
+}
+
+// hookTimeoutError marks err as a hook timeout when ctx's deadline passed.
+func hookTimeoutError(ctx context.Context, err error) error {
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("hook timed out: %w", err)
+	}
+	return err
+}
+
+// hookExecOptions configures the exec of an in-container hook.
+func hookExecOptions(cfg *config.Config, point string, output io.Writer) containerruntime.ExecOptions {
+	workDir := strings.TrimSpace(cfg.ContainerWorkDir)
+	return containerruntime.ExecOptions{
+		User:    strings.TrimSpace(cfg.ContainerUser),
+		WorkDir: workDir,
+		Env:     hookEnv(cfg, point, workDir),
+		Output:  output,
+	}
+}
+
+// hookFailureDetail combines a hook's error with the tail of its output.
+func hookFailureDetail(err error, output []byte) string {
+	out := strings.TrimSpace(string(output))
+	if len(out) > maxHookOutputDetail {
+		out = "..." + out[len(out)-maxHookOutputDetail:]
+	}
+	if out == "" {
+		return err.Error()
+	}
+	return err.Error() + "\n" + out
+}
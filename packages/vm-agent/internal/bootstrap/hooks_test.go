@@ -0,0 +1,139 @@
+package bootstrap
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/bootlog"
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+type recordingSink struct {
+	mu      sync.Mutex
+	entries []bootlog.Entry
+}
+
+func (s *recordingSink) Write(entry bootlog.Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+func (s *recordingSink) statuses() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []string
+	for _, entry := range s.entries {
+		out = append(out, entry.Step+":"+entry.Status)
+	}
+	return out
+}
+
+func TestHooksValidate(t *testing.T) {
+	t.Parallel()
+
+	if err := (Hooks{HookPreClone: "echo hi", HookPreShutdown: "make stop"}).Validate(); err != nil {
+		t.Fatalf("Validate() = %v, want nil", err)
+	}
+	if err := (Hooks{"postStart": "echo hi"}).Validate(); err == nil {
+		t.Fatal("unknown hook point should be rejected")
+	}
+	if err := (Hooks{HookPostClone: strings.Repeat("x", maxHookScriptSize+1)}).Validate(); err == nil {
+		t.Fatal("oversized hook should be rejected")
+	}
+}
+
+func TestRunLifecycleHooksOnHost(t *testing.T) {
+	t.Parallel()
+
+	workDir := t.TempDir()
+	cfg := &config.Config{
+		WorkspaceID:  "ws-hooks",
+		Repository:   "octo/repo",
+		Branch:       "main",
+		WorkspaceDir: workDir,
+		HookTimeout:  5 * time.Second,
+	}
+	sink := &recordingSink{}
+	reporter := bootlog.New("http://127.0.0.1:0", cfg.WorkspaceID)
+	reporter.AddSinks(sink)
+
+	hooks := Hooks{
+		HookPreClone:  `printf '%s %s %s %s %s' "$SAM_HOOK" "$SAM_WORKSPACE_ID" "$SAM_REPOSITORY" "$SAM_BRANCH" "$PWD" > env.txt`,
+		HookPostClone: "echo cloning broke >&2; exit 3",
+	}
+	RunLifecycleHooks(context.Background(), cfg, hooks, HookPreClone, reporter)
+	RunLifecycleHooks(context.Background(), cfg, hooks, HookPostClone, reporter)
+
+	got, err := os.ReadFile(filepath.Join(workDir, "env.txt"))
+	if err != nil {
+		t.Fatalf("preClone hook did not run in the workspace dir: %v", err)
+	}
+	resolved, _ := filepath.EvalSymlinks(workDir)
+	if want := "preClone ws-hooks octo/repo main "; !strings.HasPrefix(string(got), want) || (!strings.HasSuffix(string(got), workDir) && !strings.HasSuffix(string(got), resolved)) {
+		t.Fatalf("hook env = %q", got)
+	}
+
+	if statuses := strings.Join(sink.statuses(), ","); statuses != "hook_preClone:started,hook_preClone:completed,hook_postClone:started,hook_postClone:failed" {
+		t.Fatalf("boot log = %s", statuses)
+	}
+	failed := sink.entries[3]
+	if !strings.Contains(failed.Detail, "exit status 3") || !strings.Contains(failed.Detail, "cloning broke") {
+		t.Fatalf("failure detail = %q", failed.Detail)
+	}
+}
+
+func TestRunLifecycleHooksTimeout(t *testing.T) {
+	t.Parallel()
+
+	cfg := &config.Config{WorkspaceID: "ws-timeout", WorkspaceDir: t.TempDir(), HookTimeout: 100 * time.Millisecond}
+	sink := &recordingSink{}
+	reporter := bootlog.New("http://127.0.0.1:0", cfg.WorkspaceID)
+	reporter.AddSinks(sink)
+
+	start := time.Now()
+	RunLifecycleHooks(context.Background(), cfg, Hooks{HookPreClone: "sleep 30"}, HookPreClone, reporter)
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("hook ran for %s, want it killed at the timeout", elapsed)
+	}
+	if len(sink.entries) != 2 || sink.entries[1].Status != "failed" || !strings.Contains(sink.entries[1].Detail, "hook timed out") {
+		t.Fatalf("boot log = %+v", sink.entries)
+	}
+}
+
+func TestHookFailureDetailKeepsOutputTail(t *testing.T) {
+	t.Parallel()
+
+	output := strings.Repeat("a", maxHookOutputDetail) + "the end"
+	detail := hookFailureDetail(context.Canceled, []byte(output))
+	if !strings.HasSuffix(detail, "the end") || len(detail) > maxHookOutputDetail+len("context canceled\n...") {
+		t.Fatalf("detail length %d, suffix %q", len(detail), detail[len(detail)-10:])
+	}
+}
+
+func TestStateHooks(t *testing.T) {
+	t.Parallel()
+
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	cfg := &config.Config{BootstrapStatePath: statePath}
+	if hooks, err := StateHooks(cfg); err != nil || hooks != nil {
+		t.Fatalf("StateHooks() without state = %v, %v", hooks, err)
+	}
+	if err := saveState(statePath, &bootstrapState{
+		WorkspaceID:   "ws-1",
+		CallbackToken: "cb-1",
+		Hooks:         Hooks{HookPreShutdown: "make stop"},
+	}); err != nil {
+		t.Fatalf("saveState: %v", err)
+	}
+	hooks, err := StateHooks(cfg)
+	if err != nil || hooks[HookPreShutdown] != "make stop" {
+		t.Fatalf("StateHooks() = %v, %v", hooks, err)
+	}
+}
@@ -0,0 +1,96 @@
+package bootstrap
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/workspace/vm-agent/internal/config"
+	"github.com/workspace/vm-agent/internal/containerruntime"
+	"github.com/workspace/vm-agent/internal/execaudit"
+)
+
+// lfsSkipSmudgeEnv keeps host-side clones from downloading LFS objects. The
+// host clone has the token in its URL only for the clone itself, so objects
+// are pulled later in the devcontainer, through the credential helper.
+const lfsSkipSmudgeEnv = "GIT_LFS_SKIP_SMUDGE=1"
+
+// gitLFSInstallScript installs git-lfs when the image lacks it and enables
+// its filters in the system git config, so every user and clone in the
+// container gets them.
+const gitLFSInstallScript = `set -e
+if ! git lfs version >/dev/null 2>&1; then
+  if command -v apt-get >/dev/null 2>&1; then
+    apt-get update && apt-get install -y git-lfs
+  elif command -v apk >/dev/null 2>&1; then
+    apk add --no-cache git-lfs
+  else
+    echo "Unsupported package manager, cannot install git-lfs" >&2
+    exit 1
+  fi
+fi
+git lfs install --system --skip-repo
+`
+
+// repositoryUsesLFS reports whether the root .gitattributes of the clone in
+// dir routes any path through the LFS filter.
+func repositoryUsesLFS(dir string) bool {
+	f, err := os.Open(filepath.Join(dir, ".gitattributes"))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		for _, attr := range fields[1:] {
+			if attr == "filter=lfs" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ensureGitLFS installs git-lfs in the devcontainer and pulls the
+// repository's LFS objects as the container user. The host clone skipped
+// them (see lfsSkipSmudgeEnv), so the pull authenticates through the git
+// credential helper like any other fetch. Repositories without LFS
+// attributes are left alone.
+func ensureGitLFS(ctx context.Context, cfg *config.Config) error {
+	if cfg.Repository == "" || !repositoryUsesLFS(cfg.WorkspaceDir) {
+		return nil
+	}
+	containerUser := strings.TrimSpace(cfg.ContainerUser)
+	workDir := strings.TrimSpace(cfg.ContainerWorkDir)
+	if containerUser == "" || workDir == "" {
+		return errors.New("container user and workdir are required to pull LFS objects")
+	}
+
+	containerID, err := findDevcontainerID(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to locate devcontainer for git-lfs setup: %w", err)
+	}
+
+	rt := containerruntime.Current()
+	op := execaudit.Begin(ctx, containerID, "package.install", "git-lfs")
+	if _, err := rt.Exec(ctx, containerID, rootExec, "sh", "-c", gitLFSInstallScript); op.End(err) != nil {
+		return fmt.Errorf("failed to install git-lfs in devcontainer: %w", err)
+	}
+
+	if _, err := rt.Exec(ctx, containerID, containerruntime.ExecOptions{User: containerUser, WorkDir: workDir}, "git", "lfs", "pull"); err != nil {
+		return fmt.Errorf("git lfs pull failed: %w", err)
+	}
+
+	slog.Info("Pulled git LFS objects in devcontainer", "containerID", containerID)
+	return nil
+}
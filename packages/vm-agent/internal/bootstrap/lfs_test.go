@@ -0,0 +1,37 @@
+package bootstrap
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRepositoryUsesLFS(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		attributes string
+		want       bool
+	}{
+		{name: "no attributes file", want: false},
+		{name: "lfs filter", attributes: "*.psd filter=lfs diff=lfs merge=lfs -text\n", want: true},
+		{name: "other attributes", attributes: "*.sh text eol=lf\n*.png binary\n", want: false},
+		{name: "commented out", attributes: "# *.psd filter=lfs diff=lfs merge=lfs -text\n", want: false},
+		{name: "pattern only", attributes: "filter=lfs\n", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			dir := t.TempDir()
+			if tt.attributes != "" {
+				if err := os.WriteFile(filepath.Join(dir, ".gitattributes"), []byte(tt.attributes), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := repositoryUsesLFS(dir); got != tt.want {
+				t.Fatalf("repositoryUsesLFS = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package bootstrap
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/workspace/vm-agent/internal/config"
+	"github.com/workspace/vm-agent/internal/containerruntime"
+	"github.com/workspace/vm-agent/internal/execaudit"
+)
+
+// caBundleContainerPath is where CA_BUNDLE_PATH is bind-mounted in the
+// devcontainer. NODE_EXTRA_CA_CERTS points at it so Node-based agents and
+// lifecycle hooks trust the bundle from the first command, before
+// injectContainerTrustStore has run.
+const caBundleContainerPath = "/usr/local/share/sam/ca-bundle.crt"
+
+// outboundContainerEnv returns the devcontainer containerEnv entries for
+// the node's proxy and CA settings.
+func outboundContainerEnv(cfg *config.Config) map[string]string {
+	env := map[string]string{}
+	for _, v := range []struct{ value, upper, lower string }{
+		{cfg.HTTPProxy, "HTTP_PROXY", "http_proxy"},
+		{cfg.HTTPSProxy, "HTTPS_PROXY", "https_proxy"},
+		{cfg.NoProxy, "NO_PROXY", "no_proxy"},
+	} {
+		if v.value != "" {
+			env[v.upper] = v.value
+			env[v.lower] = v.value
+		}
+	}
+	if cfg.CABundlePath != "" {
+		env["NODE_EXTRA_CA_CERTS"] = caBundleContainerPath
+	}
+	return env
+}
+
+// outboundMounts returns the read-only CA bundle bind mount, if any.
+func outboundMounts(cfg *config.Config) []string {
+	if cfg.CABundlePath == "" {
+		return nil
+	}
+	return []string{fmt.Sprintf("source=%s,target=%s,type=bind,readonly", cfg.CABundlePath, caBundleContainerPath)}
+}
+
+// withOutboundConfig adds the proxy and CA settings to a merged devcontainer
+// configuration. The node's proxy wins over a repository's own containerEnv.
+func withOutboundConfig(merged map[string]interface{}, cfg *config.Config) {
+	if mounts := outboundMounts(cfg); len(mounts) > 0 {
+		existing, _ := merged["mounts"].([]interface{})
+		for _, m := range mounts {
+			existing = append(existing, m)
+		}
+		merged["mounts"] = existing
+	}
+	env := outboundContainerEnv(cfg)
+	if len(env) == 0 {
+		return
+	}
+	containerEnv, ok := merged["containerEnv"].(map[string]interface{})
+	if !ok {
+		containerEnv = map[string]interface{}{}
+	}
+	for k, v := range env {
+		containerEnv[k] = v
+	}
+	merged["containerEnv"] = containerEnv
+}
+
+// caTrustScript installs the bundle read from stdin into the container's
+// system trust store, which git, curl, apt, and most language runtimes use.
+const caTrustScript = `set -e
+if command -v update-ca-certificates >/dev/null 2>&1; then
+  mkdir -p /usr/local/share/ca-certificates
+  cat > /usr/local/share/ca-certificates/sam-custom-ca.crt
+  update-ca-certificates >/dev/null
+elif command -v update-ca-trust >/dev/null 2>&1; then
+  cat > /etc/pki/ca-trust/source/anchors/sam-custom-ca.crt
+  update-ca-trust extract
+else
+  echo "no update-ca-certificates or update-ca-trust in image" >&2
+  exit 1
+fi`
+
+// injectContainerTrustStore adds CA_BUNDLE_PATH to the devcontainer's
+// system trust store. The bundle is piped over stdin rather than read from
+// the bind mount, so it also works for configs the mount was not added to.
+func injectContainerTrustStore(ctx context.Context, cfg *config.Config, containerID string) error {
+	if cfg.CABundlePath == "" {
+		return nil
+	}
+	bundle, err := os.ReadFile(cfg.CABundlePath)
+	if err != nil {
+		return fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+	op := execaudit.Begin(ctx, containerID, "ca_bundle.install", cfg.CABundlePath)
+	if _, err := containerruntime.Current().Exec(ctx, containerID,
+		containerruntime.ExecOptions{User: "root", Stdin: bytes.NewReader(bundle)}, "sh", "-c", caTrustScript,
+	); op.End(err) != nil {
+		return fmt.Errorf("failed to install CA bundle: %w", err)
+	}
+	slog.Info("Added CA bundle to devcontainer trust store", "containerID", containerID)
+	return nil
+}
+
+// WarnDockerOutboundConfig logs hints when the Docker daemon will not use the
+// node's proxy or CA bundle. Image pulls and builds are made by the daemon,
+// which reads neither the agent's environment nor its trust settings.
+func WarnDockerOutboundConfig(ctx context.Context, cfg *config.Config) {
+	if cfg.HTTPProxy == "" && cfg.HTTPSProxy == "" && cfg.CABundlePath == "" {
+		return
+	}
+	if cfg.HTTPProxy != "" || cfg.HTTPSProxy != "" {
+		output, err := containerruntime.Command(ctx, "info", "--format", "{{.HTTPProxy}}|{{.HTTPSProxy}}").Output()
+		if err != nil {
+			slog.Warn("Could not read Docker daemon proxy settings", "error", err)
+		} else if strings.TrimSpace(string(output)) == "|" {
+			slog.Warn("Docker daemon has no proxy configured; image pulls will bypass HTTP(S)_PROXY. " +
+				`Set "proxies" in /etc/docker/daemon.json (or a systemd drop-in for docker.service) and restart Docker.`)
+		}
+	}
+	if cfg.CABundlePath != "" {
+		slog.Info("The Docker daemon does not read CA_BUNDLE_PATH; for registries behind the custom CA, " +
+			"install it in the host trust store or /etc/docker/certs.d/<registry>/ca.crt and restart Docker.")
+	}
+}
@@ -0,0 +1,76 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+func TestWithOutboundConfigMergesProxyAndCA(t *testing.T) {
+	t.Parallel()
+
+	cfg := &config.Config{HTTPSProxy: "http://proxy.corp:3128", NoProxy: "localhost", CABundlePath: "/etc/sam/corp-ca.pem"}
+	merged := map[string]interface{}{
+		"mounts":       []interface{}{"source=cache,target=/cache,type=volume"},
+		"containerEnv": map[string]interface{}{"FOO": "bar", "HTTPS_PROXY": "http://repo-proxy:8080"},
+	}
+	withOutboundConfig(merged, cfg)
+
+	wantMounts := []interface{}{
+		"source=cache,target=/cache,type=volume",
+		"source=/etc/sam/corp-ca.pem,target=" + caBundleContainerPath + ",type=bind,readonly",
+	}
+	if !reflect.DeepEqual(merged["mounts"], wantMounts) {
+		t.Fatalf("mounts = %v, want %v", merged["mounts"], wantMounts)
+	}
+	env := merged["containerEnv"].(map[string]interface{})
+	if env["FOO"] != "bar" || env["HTTPS_PROXY"] != cfg.HTTPSProxy || env["https_proxy"] != cfg.HTTPSProxy ||
+		env["no_proxy"] != "localhost" || env["NODE_EXTRA_CA_CERTS"] != caBundleContainerPath {
+		t.Fatalf("containerEnv = %v", env)
+	}
+	if _, ok := env["HTTP_PROXY"]; ok {
+		t.Fatalf("unset HTTP_PROXY was added: %v", env)
+	}
+
+	untouched := map[string]interface{}{"image": "ubuntu"}
+	withOutboundConfig(untouched, &config.Config{})
+	if len(untouched) != 1 {
+		t.Fatalf("config changed without proxy or CA settings: %v", untouched)
+	}
+}
+
+func TestWriteDefaultDevcontainerConfigOutbound(t *testing.T) {
+	t.Parallel()
+
+	configPath := filepath.Join(t.TempDir(), "default-devcontainer.json")
+	cfg := &config.Config{
+		DefaultDevcontainerConfigPath: configPath,
+		HTTPProxy:                     "http://proxy.corp:3128",
+		CABundlePath:                  "/etc/sam/corp-ca.pem",
+	}
+	if _, err := writeDefaultDevcontainerConfig(cfg, "sam-ws-1", "/tmp/git-credential-sam-ws-1"); err != nil {
+		t.Fatalf("writeDefaultDevcontainerConfig: %v", err)
+	}
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed struct {
+		Mounts       []string          `json:"mounts"`
+		ContainerEnv map[string]string `json:"containerEnv"`
+	}
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		t.Fatalf("generated config is not valid JSON: %v\n%s", err, data)
+	}
+	if len(parsed.Mounts) != 2 || !strings.Contains(parsed.Mounts[0], caBundleContainerPath) || !strings.Contains(parsed.Mounts[1], credentialHelperContainerPath) {
+		t.Fatalf("mounts = %v", parsed.Mounts)
+	}
+	if parsed.ContainerEnv["http_proxy"] != cfg.HTTPProxy || parsed.ContainerEnv["GIT_CONFIG_COUNT"] != "2" {
+		t.Fatalf("containerEnv = %v", parsed.ContainerEnv)
+	}
+}
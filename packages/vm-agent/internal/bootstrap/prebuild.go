@@ -0,0 +1,104 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/workspace/vm-agent/internal/cache"
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+// prebuildDroppedKeys are removed from the merged configuration when starting
+// from a prebuilt image. The image already contains the build output and the
+// installed features (their metadata travels in the devcontainer.metadata
+// label), so keeping them would make `devcontainer up` build again.
+var prebuildDroppedKeys = []string{"build", "dockerFile", "context", "features", "cacheFrom"}
+
+// startPrebuiltDevcontainer starts the workspace devcontainer from the image
+// at cfg.DevcontainerPrebuildRef. When the registry has no such image yet it
+// is built once with `devcontainer build --push`, so later workspaces for the
+// same repo skip the build and feature installs entirely.
+//
+// Returns false when the regular build path should run instead — prebuild
+// mode is an optimization and never fails provisioning on its own.
+func startPrebuiltDevcontainer(ctx context.Context, cfg *config.Config, volumeName, credHelperHostPath, devcontainerConfigName string) bool {
+	ref := cfg.DevcontainerPrebuildRef
+	if cfg.DevcontainerCachePassword != "" {
+		if err := cache.DockerLogin(ctx, cfg.DevcontainerCacheRegistry, cfg.DevcontainerCacheUsername, cfg.DevcontainerCachePassword); err != nil {
+			slog.Warn("Prebuild registry login failed (building devcontainer instead)", "registry", cfg.DevcontainerCacheRegistry, "error", err)
+			return false
+		}
+	}
+
+	merged, err := mountOverrideConfig(ctx, cfg, volumeName, credHelperHostPath, devcontainerConfigName, "")
+	if err != nil {
+		slog.Warn("Failed to resolve devcontainer config for prebuild (building devcontainer instead)", "error", err)
+		return false
+	}
+	if _, ok := merged["dockerComposeFile"]; ok {
+		slog.Info("Prebuild mode does not support Docker Compose devcontainers", "ref", ref)
+		return false
+	}
+
+	if pullErr := cache.PullCacheImage(ctx, ref); pullErr != nil {
+		slog.Info("No prebuilt devcontainer image (building and pushing)", "ref", ref, "reason", pullErr)
+		if buildErr := buildPrebuiltImage(ctx, cfg, devcontainerConfigName, ref); buildErr != nil {
+			slog.Warn("Devcontainer prebuild failed (building devcontainer instead)", "ref", ref, "error", buildErr)
+			return false
+		}
+	} else {
+		slog.Info("Prebuild hit: pulled devcontainer image", "ref", ref)
+	}
+
+	applyPrebuiltImage(merged, ref)
+	overridePath, err := writeMergedOverrideConfig(merged, "devcontainer-prebuild-override-*.json")
+	if err != nil {
+		slog.Warn("Failed to write prebuild override config (building devcontainer instead)", "error", err)
+		return false
+	}
+	defer os.Remove(overridePath)
+
+	upCtx, upCancel := devcontainerBuildContext(ctx, cfg)
+	defer upCancel()
+	cmd := devcontainerCommand(upCtx, devcontainerUpArgs(cfg, overridePath, devcontainerConfigName)...)
+	if output, upErr := cmd.CombinedOutput(); upErr != nil {
+		slog.Warn("Devcontainer up from prebuilt image failed (building devcontainer instead)", "ref", ref, "error", upErr, "output", strings.TrimSpace(string(output)))
+		// Don't let the regular build reuse a half-started container.
+		removeStaleContainers(ctx, cfg)
+		return false
+	}
+
+	slog.Info("Devcontainer started from prebuilt image", "ref", ref)
+	return true
+}
+
+// buildPrebuiltImage builds the repo devcontainer, features included, into
+// ref and pushes it to the registry.
+func buildPrebuiltImage(ctx context.Context, cfg *config.Config, devcontainerConfigName, ref string) error {
+	args := []string{"build", "--workspace-folder", cfg.WorkspaceDir}
+	if devcontainerConfigName != "" {
+		args = append(args, "--config", namedDevcontainerConfigPath(cfg.WorkspaceDir, devcontainerConfigName))
+	}
+	args = append(args, "--image-name", ref, "--push")
+
+	buildCtx, buildCancel := devcontainerBuildContext(ctx, cfg)
+	defer buildCancel()
+	output, err := devcontainerCommand(buildCtx, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("devcontainer build --push failed: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	slog.Info("Pushed prebuilt devcontainer image", "ref", ref)
+	return nil
+}
+
+// applyPrebuiltImage points a merged devcontainer configuration at the
+// prebuilt image instead of its build sources.
+func applyPrebuiltImage(merged map[string]interface{}, ref string) {
+	for _, key := range prebuildDroppedKeys {
+		delete(merged, key)
+	}
+	merged["image"] = ref
+}
@@ -0,0 +1,142 @@
+package bootstrap
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+// installPrebuildMocks puts devcontainer and docker mocks on PATH. Both log
+// their arguments to the returned file; `devcontainer up` also copies its
+// override config next to it. docker pull succeeds only when pullHit is set.
+func installPrebuildMocks(t *testing.T, pullHit bool) (logPath, overrideCopy string) {
+	t.Helper()
+	mockBinDir := t.TempDir()
+	logPath = filepath.Join(mockBinDir, "calls.log")
+	overrideCopy = filepath.Join(mockBinDir, "override.json")
+
+	devcontainerScript := `#!/bin/sh
+echo "devcontainer $@" >> ` + shellSingleQuote(logPath) + `
+if [ "$1" = "read-configuration" ]; then
+  cat <<'EOF'
+{"outcome":"success","mergedConfiguration":{"build":{"dockerfile":"Dockerfile"},"features":{"ghcr.io/devcontainers/features/node:1":{}},"remoteUser":"node"}}
+EOF
+  exit 0
+fi
+if [ "$1" = "up" ]; then
+  while [ $# -gt 0 ]; do
+    if [ "$1" = "--override-config" ]; then cp "$2" ` + shellSingleQuote(overrideCopy) + `; fi
+    shift
+  done
+fi
+exit 0
+`
+	pullExit := "1"
+	if pullHit {
+		pullExit = "0"
+	}
+	dockerScript := `#!/bin/sh
+echo "docker $@" >> ` + shellSingleQuote(logPath) + `
+if [ "$1" = "pull" ]; then exit ` + pullExit + `; fi
+exit 0
+`
+	if err := os.WriteFile(filepath.Join(mockBinDir, "devcontainer"), []byte(devcontainerScript), 0o755); err != nil {
+		t.Fatalf("failed to write mock devcontainer command: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(mockBinDir, "docker"), []byte(dockerScript), 0o755); err != nil {
+		t.Fatalf("failed to write mock docker command: %v", err)
+	}
+	t.Setenv("PATH", mockBinDir+":"+os.Getenv("PATH"))
+	return logPath, overrideCopy
+}
+
+func TestStartPrebuiltDevcontainer(t *testing.T) {
+	const ref = "ghcr.io/acme/app/devcontainer:prebuild"
+
+	for _, tc := range []struct {
+		name      string
+		pullHit   bool
+		wantBuild bool
+	}{
+		{name: "registry miss builds and pushes", pullHit: false, wantBuild: true},
+		{name: "registry hit skips build", pullHit: true, wantBuild: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			logPath, overrideCopy := installPrebuildMocks(t, tc.pullHit)
+			cfg := &config.Config{
+				WorkspaceDir:              t.TempDir(),
+				Repository:                "acme/app",
+				DevcontainerCacheRegistry: "ghcr.io",
+				DevcontainerCachePassword: "token",
+				DevcontainerPrebuildRef:   ref,
+			}
+
+			if !startPrebuiltDevcontainer(context.Background(), cfg, "sam-ws-1", "", "") {
+				t.Fatal("startPrebuiltDevcontainer returned false")
+			}
+
+			logData, err := os.ReadFile(logPath)
+			if err != nil {
+				t.Fatalf("read call log: %v", err)
+			}
+			calls := string(logData)
+			if !strings.Contains(calls, "docker login ghcr.io") {
+				t.Errorf("expected registry login, calls:\n%s", calls)
+			}
+			wantBuildCall := "devcontainer build --workspace-folder " + cfg.WorkspaceDir + " --image-name " + ref + " --push"
+			if got := strings.Contains(calls, wantBuildCall); got != tc.wantBuild {
+				t.Errorf("build call present=%v, want %v; calls:\n%s", got, tc.wantBuild, calls)
+			}
+
+			overrideData, err := os.ReadFile(overrideCopy)
+			if err != nil {
+				t.Fatalf("devcontainer up did not receive an override config: %v", err)
+			}
+			var override map[string]interface{}
+			if err := json.Unmarshal(overrideData, &override); err != nil {
+				t.Fatalf("parse override config: %v", err)
+			}
+			if override["image"] != ref {
+				t.Errorf("image = %v, want %q", override["image"], ref)
+			}
+			for _, key := range []string{"build", "features"} {
+				if _, ok := override[key]; ok {
+					t.Errorf("override config still has %q", key)
+				}
+			}
+			if override["workspaceFolder"] != "/workspaces/app" {
+				t.Errorf("workspaceFolder = %v, want /workspaces/app", override["workspaceFolder"])
+			}
+		})
+	}
+}
+
+func TestStartPrebuiltDevcontainerSkipsCompose(t *testing.T) {
+	mockBinDir := t.TempDir()
+	devcontainerScript := `#!/bin/sh
+if [ "$1" = "read-configuration" ]; then
+  echo '{"outcome":"success","mergedConfiguration":{"dockerComposeFile":"compose.yml","service":"app"}}'
+  exit 0
+fi
+echo "unexpected devcontainer command: $@" >&2
+exit 1
+`
+	if err := os.WriteFile(filepath.Join(mockBinDir, "devcontainer"), []byte(devcontainerScript), 0o755); err != nil {
+		t.Fatalf("failed to write mock devcontainer command: %v", err)
+	}
+	t.Setenv("PATH", mockBinDir+":"+os.Getenv("PATH"))
+
+	cfg := &config.Config{
+		WorkspaceDir:            t.TempDir(),
+		Repository:              "acme/app",
+		DevcontainerPrebuildRef: "ghcr.io/acme/app/devcontainer:prebuild",
+	}
+	if startPrebuiltDevcontainer(context.Background(), cfg, "sam-ws-1", "", "") {
+		t.Fatal("expected compose devcontainer to use the regular build path")
+	}
+}
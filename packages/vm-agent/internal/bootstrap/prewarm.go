@@ -0,0 +1,81 @@
+package bootstrap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/workspace/vm-agent/internal/bootlog"
+	"github.com/workspace/vm-agent/internal/config"
+	"github.com/workspace/vm-agent/internal/containerruntime"
+)
+
+// prewarmImageName tags the throwaway image built by Prewarm.
+const prewarmImageName = "sam-prewarm:latest"
+
+// Prewarm readies a pre-provisioned node before it is assigned a workspace:
+// it pulls the default devcontainer image and builds it with the default and
+// additional features, filling Docker's layer cache and the devcontainer
+// CLI's feature cache. Failures are logged and leave the node to build cold.
+func Prewarm(ctx context.Context, cfg *config.Config, reporter *bootlog.Reporter) {
+	image := cfg.DefaultDevcontainerImage
+	if image == "" {
+		image = config.DefaultDevcontainerImage
+	}
+
+	reporter.Log("prewarm", "started", "Pre-pulling default devcontainer image and features")
+	if output, err := containerruntime.Command(ctx, "pull", image).CombinedOutput(); err != nil {
+		slog.Warn("Prewarm: image pull failed", "image", image, "error", err, "output", strings.TrimSpace(string(output)))
+		reporter.Log("prewarm", "failed", "Default devcontainer image pull failed", err.Error())
+		return
+	}
+	if err := buildPrewarmImage(ctx, cfg, image); err != nil {
+		slog.Warn("Prewarm: feature build failed", "image", image, "error", err)
+		reporter.Log("prewarm", "failed", "Devcontainer feature prewarm failed", err.Error())
+		return
+	}
+	slog.Info("Prewarm completed", "image", image)
+	reporter.Log("prewarm", "completed", "Default devcontainer image and features cached")
+}
+
+// buildPrewarmImage runs `devcontainer build` for a scratch config using
+// image and the features every default workspace installs.
+func buildPrewarmImage(ctx context.Context, cfg *config.Config, image string) error {
+	dir, err := os.MkdirTemp("", "sam-prewarm-")
+	if err != nil {
+		return fmt.Errorf("failed to create prewarm workspace: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	devcontainerJSON, err := json.MarshalIndent(map[string]interface{}{
+		"image": image,
+		"features": map[string]interface{}{
+			"ghcr.io/devcontainers/features/git:1":        map[string]interface{}{},
+			"ghcr.io/devcontainers/features/github-cli:1": map[string]interface{}{},
+		},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.Mkdir(filepath.Join(dir, ".devcontainer"), 0o755); err != nil {
+		return fmt.Errorf("failed to create prewarm config directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".devcontainer", "devcontainer.json"), devcontainerJSON, 0o644); err != nil {
+		return fmt.Errorf("failed to write prewarm config: %w", err)
+	}
+
+	args := []string{"build", "--workspace-folder", dir, "--image-name", prewarmImageName}
+	if cfg.AdditionalFeatures != "" {
+		args = append(args, "--additional-features", cfg.AdditionalFeatures)
+	}
+	buildCtx, buildCancel := devcontainerBuildContext(ctx, cfg)
+	defer buildCancel()
+	if output, err := devcontainerCommand(buildCtx, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("devcontainer build failed: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package bootstrap
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+func TestPrewarmPullsImageAndBuildsFeatures(t *testing.T) {
+	logPath, _ := installPrebuildMocks(t, true)
+	cfg := &config.Config{
+		DefaultDevcontainerImage: "mcr.microsoft.com/devcontainers/base:bookworm",
+		AdditionalFeatures:       `{"ghcr.io/devcontainers/features/node:1":{}}`,
+	}
+
+	Prewarm(context.Background(), cfg, nil)
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("read calls log: %v", err)
+	}
+	calls := string(data)
+	if !strings.Contains(calls, "docker pull mcr.microsoft.com/devcontainers/base:bookworm") {
+		t.Fatalf("image not pulled; calls:\n%s", calls)
+	}
+	if !strings.Contains(calls, "devcontainer build --workspace-folder ") ||
+		!strings.Contains(calls, "--image-name "+prewarmImageName) ||
+		!strings.Contains(calls, `--additional-features {"ghcr.io/devcontainers/features/node:1":{}}`) {
+		t.Fatalf("prewarm build not run with features; calls:\n%s", calls)
+	}
+}
+
+func TestPrewarmSkipsBuildWhenPullFails(t *testing.T) {
+	logPath, _ := installPrebuildMocks(t, false)
+
+	Prewarm(context.Background(), &config.Config{}, nil)
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("read calls log: %v", err)
+	}
+	calls := string(data)
+	if !strings.Contains(calls, "docker pull "+config.DefaultDevcontainerImage) {
+		t.Fatalf("default image not pulled; calls:\n%s", calls)
+	}
+	if strings.Contains(calls, "devcontainer build") {
+		t.Fatalf("build ran after failed pull; calls:\n%s", calls)
+	}
+}
@@ -0,0 +1,95 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/workspace/vm-agent/internal/containerruntime"
+	"github.com/workspace/vm-agent/internal/execaudit"
+)
+
+// projectEnvFile is the copy of the project env script that ReadProjectEnv
+// reads back; sam-project-env.sh in /etc/profile.d holds the same content.
+const projectEnvFile = "/etc/sam/project-env"
+
+// ReadProjectEnv returns the project env vars currently written in a running
+// devcontainer, in file order. A container without project env has none.
+func ReadProjectEnv(ctx context.Context, containerID string) ([]ProjectRuntimeEnvVar, error) {
+	op := execaudit.Begin(ctx, containerID, "project_env.read", "")
+	result, err := containerruntime.Current().Exec(ctx, containerID, rootExec,
+		"sh", "-c", `[ ! -e "$1" ] || cat "$1"`, "sh", projectEnvFile,
+	)
+	if op.End(err) != nil {
+		return nil, fmt.Errorf("failed to read project env: %w", err)
+	}
+	return parseProjectRuntimeEnvScript(string(result.Stdout))
+}
+
+// WriteProjectEnv replaces the project env of a running devcontainer, in
+// both /etc/profile.d/sam-project-env.sh and /etc/sam/project-env. An empty
+// envVars leaves both files without variables.
+func WriteProjectEnv(ctx context.Context, containerID string, envVars []ProjectRuntimeEnvVar) error {
+	script, err := buildProjectRuntimeEnvScript(envVars)
+	if err != nil {
+		return err
+	}
+	batch, err := buildProjectRuntimeBatch(script, nil, "")
+	if err != nil {
+		return err
+	}
+	return installProjectRuntimeBatch(ctx, containerID, "", "", batch)
+}
+
+// parseProjectRuntimeEnvScript reverses buildProjectRuntimeEnvScript. Values
+// are sequences of single-quoted runs and the "'" escape shellSingleQuote
+// emits, so values spanning several lines are read whole.
+func parseProjectRuntimeEnvScript(script string) ([]ProjectRuntimeEnvVar, error) {
+	var envVars []ProjectRuntimeEnvVar
+	rest := script
+	for rest != "" {
+		line := rest
+		if i := strings.IndexByte(rest, '\n'); i >= 0 {
+			line, rest = rest[:i], rest[i+1:]
+		} else {
+			rest = ""
+		}
+		line = strings.TrimLeft(line, " \t")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, quoted, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !ok || !projectEnvKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("malformed project env line %q", line)
+		}
+
+		// Re-join the rest of the script so a quoted value may continue on
+		// following lines.
+		if rest != "" {
+			quoted += "\n" + rest
+		}
+		var value strings.Builder
+		pos := 0
+		for pos < len(quoted) && (quoted[pos] == '\'' || quoted[pos] == '"') {
+			quote := quoted[pos]
+			end := strings.IndexByte(quoted[pos+1:], quote)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated value for project env var %s", key)
+			}
+			value.WriteString(quoted[pos+1 : pos+1+end])
+			pos += end + 2
+		}
+		if pos == 0 {
+			// Unquoted values run to the end of the line.
+			end := strings.IndexByte(quoted, '\n')
+			if end < 0 {
+				end = len(quoted)
+			}
+			value.WriteString(strings.TrimSpace(quoted[:end]))
+			pos = end
+		}
+		rest = strings.TrimPrefix(quoted[pos:], "\n")
+		envVars = append(envVars, ProjectRuntimeEnvVar{Key: key, Value: value.String()})
+	}
+	return envVars, nil
+}
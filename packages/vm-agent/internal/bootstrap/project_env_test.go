@@ -0,0 +1,42 @@
+package bootstrap
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseProjectRuntimeEnvScriptRoundTrips(t *testing.T) {
+	t.Parallel()
+
+	envVars := []ProjectRuntimeEnvVar{
+		{Key: "API_KEY", Value: "sk-123"},
+		{Key: "QUOTED", Value: `it's "quoted"`},
+		{Key: "MULTILINE", Value: "line one \nline two\n"},
+		{Key: "EMPTY", Value: ""},
+	}
+	script, err := buildProjectRuntimeEnvScript(envVars)
+	if err != nil {
+		t.Fatalf("buildProjectRuntimeEnvScript() error = %v", err)
+	}
+	got, err := parseProjectRuntimeEnvScript(script)
+	if err != nil {
+		t.Fatalf("parseProjectRuntimeEnvScript() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, envVars) {
+		t.Fatalf("parseProjectRuntimeEnvScript() = %#v, want %#v", got, envVars)
+	}
+}
+
+func TestParseProjectRuntimeEnvScriptRejectsMalformedLines(t *testing.T) {
+	t.Parallel()
+
+	for _, script := range []string{
+		"export 1BAD='x'\n",
+		"export KEY='unterminated\n",
+		"not an assignment\n",
+	} {
+		if _, err := parseProjectRuntimeEnvScript(script); err == nil {
+			t.Errorf("parseProjectRuntimeEnvScript(%q) succeeded, want error", script)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/workspace/vm-agent/internal/config"
+	"github.com/workspace/vm-agent/internal/containerruntime"
+)
+
+// devcontainerConfigSyncScript mirrors the devcontainer config from the volume
+// copy of the repository ($1) into the host clone mounted at /host. Paths that
+// no longer exist in the volume are removed from the host as well, so deleting
+// a broken config is also picked up. A volume without the repository is left
+// alone.
+const devcontainerConfigSyncScript = `src="$1"
+[ -d "$src" ] || exit 0
+rm -rf /host/.devcontainer /host/.devcontainer.json
+for p in .devcontainer .devcontainer.json; do
+  if [ -e "$src/$p" ]; then cp -R "$src/$p" /host/; fi
+done`
+
+// teardownDevcontainer removes the workspace's devcontainer so the next
+// ensureDevcontainerReady builds it again from the repository's current
+// config. For volume-backed workspaces the config is first copied from the
+// volume, where the user edits it, to the host clone, where the devcontainer
+// CLI reads it. The build error marker of a previous failed build is cleared
+// so a successful rebuild leaves recovery mode.
+func teardownDevcontainer(ctx context.Context, cfg *config.Config, volumeName string) error {
+	if volumeName != "" {
+		if err := syncDevcontainerConfigFromVolume(ctx, cfg, volumeName); err != nil {
+			return err
+		}
+	}
+
+	removeStaleContainers(ctx, cfg)
+	if containerID, err := findDevcontainerID(ctx, cfg); err == nil {
+		return fmt.Errorf("devcontainer %s is still running after teardown", containerID)
+	}
+	clearBuildErrorArtifacts(ctx, cfg, volumeName)
+
+	slog.Info("Devcontainer removed for rebuild", "workspaceID", cfg.WorkspaceID, "labelValue", cfg.ContainerLabelValue)
+	return nil
+}
+
+// ProbeDevcontainerBuild runs `devcontainer build` for the repository's
+// devcontainer config without touching the running container. The recovery
+// retry loop uses it to find out whether a build that failed before, sending
+// the workspace to the fallback image, succeeds now; the built layers are
+// cached, so the rebuild that follows is quick. In container mode the config
+// is first synced from the workspace volume, so fixes made from inside the
+// fallback container are picked up, and checked against policy.
+func ProbeDevcontainerBuild(ctx context.Context, cfg *config.Config, devcontainerConfigName string, policy *DevcontainerPolicy) error {
+	if cfg == nil {
+		return fmt.Errorf("config is required")
+	}
+	if cfg.ContainerMode {
+		if err := syncDevcontainerConfigFromVolume(ctx, cfg, VolumeNameForWorkspace(cfg.WorkspaceID)); err != nil {
+			return err
+		}
+	}
+	if devcontainerConfigName == "" && !hasDevcontainerConfig(cfg.WorkspaceDir) {
+		return fmt.Errorf("repository has no devcontainer config")
+	}
+	if err := checkDevcontainerPolicy(ctx, cfg, policy, devcontainerConfigName); err != nil {
+		return err
+	}
+
+	args := []string{"build", "--workspace-folder", cfg.WorkspaceDir}
+	if devcontainerConfigName != "" {
+		args = append(args, "--config", namedDevcontainerConfigPath(cfg.WorkspaceDir, devcontainerConfigName))
+	}
+	buildCtx, buildCancel := devcontainerBuildContext(ctx, cfg)
+	defer buildCancel()
+	output, err := devcontainerCommand(buildCtx, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("devcontainer build failed: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func syncDevcontainerConfigFromVolume(ctx context.Context, cfg *config.Config, volumeName string) error {
+	cmd := containerruntime.Command(ctx, devcontainerConfigSyncArgs(cfg, volumeName)...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to sync devcontainer config from volume %s: %w: %s", volumeName, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// devcontainerConfigSyncArgs builds the `docker run` arguments for
+// devcontainerConfigSyncScript.
+func devcontainerConfigSyncArgs(cfg *config.Config, volumeName string) []string {
+	repoDirName := config.DeriveRepoDirName(cfg.Repository)
+	if repoDirName == "" {
+		repoDirName = "workspace"
+	}
+	return []string{
+		"run", "--rm",
+		"-v", cfg.WorkspaceDir + ":/host",
+		"-v", volumeName + ":/workspaces:ro",
+		"alpine:latest",
+		"sh", "-c", devcontainerConfigSyncScript, "sh", "/workspaces/" + repoDirName,
+	}
+}
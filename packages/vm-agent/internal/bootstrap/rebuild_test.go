@@ -0,0 +1,60 @@
+package bootstrap
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+func TestTeardownDevcontainerSyncsConfigAndRemovesContainer(t *testing.T) {
+	mockBinDir := t.TempDir()
+	logPath := filepath.Join(mockBinDir, "calls.log")
+	// `docker ps -aq` reports one stale container; every other listing is
+	// empty, as it would be once the container has been removed.
+	dockerScript := `#!/bin/sh
+echo "docker $@" >> ` + shellSingleQuote(logPath) + `
+if [ "$1" = "ps" ] && [ "$2" = "-aq" ]; then echo stale-1; fi
+exit 0
+`
+	if err := os.WriteFile(filepath.Join(mockBinDir, "docker"), []byte(dockerScript), 0o755); err != nil {
+		t.Fatalf("write docker mock: %v", err)
+	}
+	t.Setenv("PATH", mockBinDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	workspaceDir := t.TempDir()
+	if err := os.WriteFile(buildErrorLogPath(workspaceDir), []byte("build failed"), 0o644); err != nil {
+		t.Fatalf("write build error marker: %v", err)
+	}
+	cfg := &config.Config{
+		WorkspaceID:         "ws-1",
+		Repository:          "owner/repo",
+		WorkspaceDir:        workspaceDir,
+		ContainerLabelKey:   "devcontainer.local_folder",
+		ContainerLabelValue: workspaceDir,
+	}
+
+	if err := teardownDevcontainer(context.Background(), cfg, "sam-ws-ws-1"); err != nil {
+		t.Fatalf("teardownDevcontainer: %v", err)
+	}
+
+	logBytes, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("read docker log: %v", err)
+	}
+	log := string(logBytes)
+	syncAt := strings.Index(log, "docker run --rm -v "+workspaceDir+":/host -v sam-ws-ws-1:/workspaces:ro")
+	removeAt := strings.Index(log, "docker rm -f stale-1")
+	if syncAt < 0 || removeAt < syncAt {
+		t.Fatalf("expected config sync from the volume, then stale container removal; docker calls:\n%s", log)
+	}
+	if args := devcontainerConfigSyncArgs(cfg, "sam-ws-ws-1"); args[len(args)-1] != "/workspaces/repo" {
+		t.Fatalf("config sync source = %q, want the repository inside the volume", args[len(args)-1])
+	}
+	if _, err := os.Stat(buildErrorLogPath(workspaceDir)); !os.IsNotExist(err) {
+		t.Fatalf("build error marker still present: %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package bootstrap
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/workspace/vm-agent/internal/bootlog"
+	"github.com/workspace/vm-agent/internal/cache"
+	"github.com/workspace/vm-agent/internal/config"
+	"github.com/workspace/vm-agent/internal/redact"
+)
+
+// RegistryCredential authenticates pulls of devcontainer images and features
+// from one private container registry (GHCR, ECR, Artifactory, ...).
+type RegistryCredential struct {
+	Server   string `json:"server"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password"`
+}
+
+// RegistryCredentials are logged in to the host's docker config before
+// `devcontainer up`, so both Docker and the devcontainer CLI can use them.
+type RegistryCredentials []RegistryCredential
+
+// Validate reports a missing server or password and duplicate servers.
+func (c RegistryCredentials) Validate() error {
+	seen := make(map[string]bool, len(c))
+	for i, cred := range c {
+		server := strings.TrimSpace(cred.Server)
+		if server == "" {
+			return fmt.Errorf("registry %d: server is required", i)
+		}
+		if strings.Contains(server, "/") && !strings.Contains(server, "://") {
+			return fmt.Errorf("registry %q: server must be a registry host, not an image reference", server)
+		}
+		if strings.TrimSpace(cred.Password) == "" {
+			return fmt.Errorf("registry %q: password is required", server)
+		}
+		if seen[server] {
+			return fmt.Errorf("registry %q: listed more than once", server)
+		}
+		seen[server] = true
+	}
+	return nil
+}
+
+// effectiveRegistryCredentials returns the node's registry credential from
+// DEVCONTAINER_REGISTRY_* followed by the workspace's credentials. A
+// workspace credential for the same server replaces the node's.
+func effectiveRegistryCredentials(cfg *config.Config, workspace RegistryCredentials) RegistryCredentials {
+	var creds RegistryCredentials
+	if cfg != nil && cfg.DevcontainerRegistryServer != "" && cfg.DevcontainerRegistryPassword != "" {
+		overridden := false
+		for _, cred := range workspace {
+			if strings.TrimSpace(cred.Server) == cfg.DevcontainerRegistryServer {
+				overridden = true
+				break
+			}
+		}
+		if !overridden {
+			creds = append(creds, RegistryCredential{
+				Server:   cfg.DevcontainerRegistryServer,
+				Username: cfg.DevcontainerRegistryUsername,
+				Password: cfg.DevcontainerRegistryPassword,
+			})
+		}
+	}
+	return append(creds, workspace...)
+}
+
+// dockerLogin is replaced in tests.
+var dockerLogin = cache.DockerLogin
+
+// loginDevcontainerRegistries runs docker login for each credential. A failed
+// login is reported but not fatal: the image may still be public, and a
+// build that cannot pull falls back to the default image with a hint in the
+// build error log.
+func loginDevcontainerRegistries(ctx context.Context, cfg *config.Config, workspace RegistryCredentials, reporter *bootlog.Reporter) {
+	creds := effectiveRegistryCredentials(cfg, workspace)
+	if len(creds) == 0 {
+		return
+	}
+	reporter.Log("registry_login", "started", "Authenticating to container registries")
+	var failed []string
+	for _, cred := range creds {
+		server := strings.TrimSpace(cred.Server)
+		redact.Register(cred.Password)
+		if err := dockerLogin(ctx, server, strings.TrimSpace(cred.Username), strings.TrimSpace(cred.Password)); err != nil {
+			slog.Warn("Container registry login failed", "registry", server, "error", err)
+			failed = append(failed, server)
+			continue
+		}
+		slog.Info("Logged in to container registry", "registry", server)
+	}
+	if len(failed) > 0 {
+		reporter.Log("registry_login", "failed", "Container registry login failed — private images may not be pullable", strings.Join(failed, ", "))
+		return
+	}
+	reporter.Log("registry_login", "completed", fmt.Sprintf("Authenticated to %d container registries", len(creds)))
+}
+
+// registryAuthFailureMarkers are substrings of Docker and devcontainer CLI
+// output that mean a registry refused a pull for lack of credentials.
+var registryAuthFailureMarkers = []string{
+	"pull access denied",
+	"unauthorized: ",
+	"authentication required",
+	"denied: requested access",
+	"no basic auth credentials",
+}
+
+// registryAuthFailed reports whether build output shows a registry refusing
+// an image or feature pull.
+func registryAuthFailed(output []byte) bool {
+	lower := bytes.ToLower(output)
+	for _, marker := range registryAuthFailureMarkers {
+		if bytes.Contains(lower, []byte(marker)) {
+			return true
+		}
+	}
+	return false
+}
+
+const registryAuthHint = "\nA container registry refused to serve an image or feature. " +
+	"Provide credentials for it as registryCredentials in the workspace request or with DEVCONTAINER_REGISTRY_SERVER, " +
+	"DEVCONTAINER_REGISTRY_USERNAME, and DEVCONTAINER_REGISTRY_PASSWORD on the node.\n"
@@ -0,0 +1,102 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+func TestRegistryCredentialsValidate(t *testing.T) {
+	t.Parallel()
+
+	valid := []RegistryCredentials{
+		nil,
+		{{Server: "ghcr.io", Username: "bot", Password: "token"}},
+		{{Server: "123456789012.dkr.ecr.us-east-1.amazonaws.com", Username: "AWS", Password: "pw"}, {Server: "registry.example.com:5000", Password: "pw"}},
+		{{Server: "https://index.docker.io/v1/", Username: "user", Password: "pw"}},
+	}
+	for _, c := range valid {
+		if err := c.Validate(); err != nil {
+			t.Errorf("Validate(%+v) = %v", c, err)
+		}
+	}
+	invalid := []RegistryCredentials{
+		{{Server: "", Password: "pw"}},
+		{{Server: "ghcr.io"}},
+		{{Server: "ghcr.io/org/image", Password: "pw"}},
+		{{Server: "ghcr.io", Password: "a"}, {Server: "ghcr.io", Password: "b"}},
+	}
+	for _, c := range invalid {
+		if err := c.Validate(); err == nil {
+			t.Errorf("Validate(%+v) accepted invalid credentials", c)
+		}
+	}
+}
+
+func TestEffectiveRegistryCredentials(t *testing.T) {
+	t.Parallel()
+
+	cfg := &config.Config{
+		DevcontainerRegistryServer:   "artifactory.example.com",
+		DevcontainerRegistryUsername: "node",
+		DevcontainerRegistryPassword: "node-pw",
+	}
+	node := RegistryCredential{Server: "artifactory.example.com", Username: "node", Password: "node-pw"}
+	ghcr := RegistryCredential{Server: "ghcr.io", Username: "bot", Password: "ws-pw"}
+
+	if got := effectiveRegistryCredentials(&config.Config{}, nil); len(got) != 0 {
+		t.Fatalf("no credentials: got %+v", got)
+	}
+	if got, want := effectiveRegistryCredentials(cfg, RegistryCredentials{ghcr}), (RegistryCredentials{node, ghcr}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("node + workspace = %+v, want %+v", got, want)
+	}
+	override := RegistryCredential{Server: "artifactory.example.com", Username: "ws", Password: "ws-pw"}
+	if got, want := effectiveRegistryCredentials(cfg, RegistryCredentials{override}), (RegistryCredentials{override}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("workspace override = %+v, want %+v", got, want)
+	}
+}
+
+func TestLoginDevcontainerRegistries(t *testing.T) {
+	var logins []string
+	orig := dockerLogin
+	dockerLogin = func(_ context.Context, registry, username, token string) error {
+		logins = append(logins, registry+"|"+username+"|"+token)
+		if registry == "bad.example.com" {
+			return errors.New("unauthorized")
+		}
+		return nil
+	}
+	t.Cleanup(func() { dockerLogin = orig })
+
+	cfg := &config.Config{DevcontainerRegistryServer: "ghcr.io", DevcontainerRegistryPassword: "node-pw"}
+	loginDevcontainerRegistries(context.Background(), cfg, RegistryCredentials{
+		{Server: " bad.example.com ", Username: "u", Password: "p1"},
+		{Server: "ecr.example.com", Username: "AWS", Password: "p2"},
+	}, nil)
+
+	want := []string{"ghcr.io||node-pw", "bad.example.com|u|p1", "ecr.example.com|AWS|p2"}
+	if !reflect.DeepEqual(logins, want) {
+		t.Fatalf("logins = %v, want %v", logins, want)
+	}
+}
+
+func TestRegistryAuthFailed(t *testing.T) {
+	t.Parallel()
+
+	for _, output := range []string{
+		"Error response from daemon: pull access denied for ghcr.io/org/private, repository does not exist or may require 'docker login'",
+		"Error: unauthorized: authentication required",
+		"error pulling image configuration: denied: requested access to the resource is denied",
+		"no basic auth credentials",
+	} {
+		if !registryAuthFailed([]byte(output)) {
+			t.Errorf("registryAuthFailed(%q) = false", output)
+		}
+	}
+	if registryAuthFailed([]byte("npm ERR! code E404")) {
+		t.Error("registryAuthFailed matched an unrelated build failure")
+	}
+}
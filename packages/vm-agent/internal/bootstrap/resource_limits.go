@@ -0,0 +1,195 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/workspace/vm-agent/internal/config"
+	"github.com/workspace/vm-agent/internal/containerruntime"
+)
+
+// ResourceLimits caps the CPU, memory, and process count of a workspace's
+// devcontainer so one runaway build cannot starve the other workspaces on a
+// shared node. Empty fields leave that resource unlimited.
+type ResourceLimits struct {
+	// CPUs is a fractional CPU count, e.g. "1.5" (docker --cpus).
+	CPUs string `json:"cpus,omitempty"`
+	// Memory is a docker memory size, e.g. "4g" or "512m". Swap is not
+	// allowed on top of it.
+	Memory string `json:"memory,omitempty"`
+	// Pids caps the number of processes (docker --pids-limit).
+	Pids int64 `json:"pids,omitempty"`
+}
+
+// minMemoryLimitBytes is the smallest memory limit docker accepts.
+const minMemoryLimitBytes = 6 << 20
+
+// Validate checks that each set limit is one docker accepts. A nil receiver
+// is valid.
+func (l *ResourceLimits) Validate() error {
+	if l == nil {
+		return nil
+	}
+	if cpus := strings.TrimSpace(l.CPUs); cpus != "" {
+		if v, err := strconv.ParseFloat(cpus, 64); err != nil || v <= 0 {
+			return fmt.Errorf("cpus must be a positive number, got %q", l.CPUs)
+		}
+	}
+	if memory := strings.TrimSpace(l.Memory); memory != "" {
+		bytes, err := ParseMemoryLimit(memory)
+		if err != nil {
+			return err
+		}
+		if bytes < minMemoryLimitBytes {
+			return fmt.Errorf("memory must be at least 6m, got %q", l.Memory)
+		}
+	}
+	if l.Pids < 0 {
+		return fmt.Errorf("pids must not be negative, got %d", l.Pids)
+	}
+	return nil
+}
+
+// IsZero reports whether no limit is set.
+func (l ResourceLimits) IsZero() bool {
+	return strings.TrimSpace(l.CPUs) == "" && strings.TrimSpace(l.Memory) == "" && l.Pids == 0
+}
+
+// ParseMemoryLimit parses a docker memory size: a number of bytes with an
+// optional b, k, m, or g suffix.
+func ParseMemoryLimit(s string) (int64, error) {
+	value := strings.ToLower(strings.TrimSpace(s))
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(value, "g"):
+		multiplier = 1 << 30
+	case strings.HasSuffix(value, "m"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(value, "k"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(value, "b"):
+	default:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("memory must be a size such as 512m or 4g, got %q", s)
+		}
+		return n, nil
+	}
+	n, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || n <= 0 || n > (1<<62)/multiplier {
+		return 0, fmt.Errorf("memory must be a size such as 512m or 4g, got %q", s)
+	}
+	return n * multiplier, nil
+}
+
+// EffectiveResourceLimits returns the node's default limits from cfg with
+// the fields set in override taking precedence.
+func EffectiveResourceLimits(cfg *config.Config, override *ResourceLimits) ResourceLimits {
+	var limits ResourceLimits
+	if cfg != nil {
+		limits = ResourceLimits{
+			CPUs:   strings.TrimSpace(cfg.WorkspaceCPULimit),
+			Memory: strings.TrimSpace(cfg.WorkspaceMemoryLimit),
+			Pids:   cfg.WorkspacePidsLimit,
+		}
+	}
+	if override != nil {
+		if cpus := strings.TrimSpace(override.CPUs); cpus != "" {
+			limits.CPUs = cpus
+		}
+		if memory := strings.TrimSpace(override.Memory); memory != "" {
+			limits.Memory = memory
+		}
+		if override.Pids > 0 {
+			limits.Pids = override.Pids
+		}
+	}
+	return limits
+}
+
+// applyResourceLimitOverrides stores a workspace's limits in its config, so
+// the devcontainer steps that only see cfg pick them up.
+func applyResourceLimitOverrides(cfg *config.Config, override *ResourceLimits) {
+	if cfg == nil || override == nil {
+		return
+	}
+	limits := EffectiveResourceLimits(cfg, override)
+	cfg.WorkspaceCPULimit = limits.CPUs
+	cfg.WorkspaceMemoryLimit = limits.Memory
+	cfg.WorkspacePidsLimit = limits.Pids
+}
+
+// dockerFlags returns the docker run/update flags for the set limits.
+func (l ResourceLimits) dockerFlags() []string {
+	var flags []string
+	if l.CPUs != "" {
+		flags = append(flags, "--cpus", l.CPUs)
+	}
+	if l.Memory != "" {
+		flags = append(flags, "--memory", l.Memory, "--memory-swap", l.Memory)
+	}
+	if l.Pids > 0 {
+		flags = append(flags, "--pids-limit", strconv.FormatInt(l.Pids, 10))
+	}
+	return flags
+}
+
+// resourceLimitFlags are the docker flags dockerFlags sets. A repository's
+// own values for them are replaced by the node's.
+var resourceLimitFlags = []string{"--cpus", "--memory", "--memory-swap", "--pids-limit", "-m"}
+
+// withResourceLimitRunArgs replaces the resource limit flags in a merged
+// devcontainer configuration's runArgs with limits. Docker Compose configs
+// ignore runArgs; applyContainerResourceLimits covers them after start.
+func withResourceLimitRunArgs(merged map[string]interface{}, limits ResourceLimits) {
+	if limits.IsZero() {
+		return
+	}
+	existing, _ := merged["runArgs"].([]interface{})
+	runArgs := make([]interface{}, 0, len(existing)+6)
+	for i := 0; i < len(existing); i++ {
+		arg, _ := existing[i].(string)
+		if flag, _, hasValue := strings.Cut(arg, "="); isResourceLimitFlag(flag) {
+			if !hasValue {
+				i++ // skip the separate value
+			}
+			continue
+		}
+		runArgs = append(runArgs, existing[i])
+	}
+	for _, flag := range limits.dockerFlags() {
+		runArgs = append(runArgs, flag)
+	}
+	merged["runArgs"] = runArgs
+}
+
+func isResourceLimitFlag(flag string) bool {
+	for _, f := range resourceLimitFlags {
+		if flag == f {
+			return true
+		}
+	}
+	return false
+}
+
+// applyContainerResourceLimits applies cfg's limits to a running
+// devcontainer with docker update. This covers Docker Compose configs, which
+// ignore runArgs, and containers created before the limits changed.
+func applyContainerResourceLimits(ctx context.Context, cfg *config.Config, containerID string) error {
+	limits := EffectiveResourceLimits(cfg, nil)
+	if limits.IsZero() {
+		return nil
+	}
+	args := append([]string{"update"}, limits.dockerFlags()...)
+	args = append(args, containerID)
+	output, err := containerruntime.Command(ctx, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to update container resource limits: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	slog.Info("Applied devcontainer resource limits", "containerID", containerID,
+		"cpus", limits.CPUs, "memory", limits.Memory, "pids", limits.Pids)
+	return nil
+}
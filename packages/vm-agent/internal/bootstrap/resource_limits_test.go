@@ -0,0 +1,94 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+func TestResourceLimitsValidate(t *testing.T) {
+	t.Parallel()
+
+	valid := []*ResourceLimits{
+		nil,
+		{},
+		{CPUs: "1.5", Memory: "512m", Pids: 256},
+		{Memory: "4G"},
+		{Memory: "1073741824"},
+	}
+	for _, l := range valid {
+		if err := l.Validate(); err != nil {
+			t.Errorf("Validate(%+v) = %v", l, err)
+		}
+	}
+	invalid := []*ResourceLimits{
+		{CPUs: "0"},
+		{CPUs: "two"},
+		{Memory: "4x"},
+		{Memory: "1m"},
+		{Pids: -1},
+	}
+	for _, l := range invalid {
+		if err := l.Validate(); err == nil {
+			t.Errorf("Validate(%+v) accepted invalid limits", l)
+		}
+	}
+}
+
+func TestWithResourceLimitRunArgsReplacesRepoLimits(t *testing.T) {
+	t.Parallel()
+
+	merged := map[string]interface{}{
+		"runArgs": []interface{}{"--memory", "64g", "--cap-add=SYS_PTRACE", "--cpus=16", "--init"},
+	}
+	cfg := &config.Config{WorkspaceCPULimit: "1", WorkspaceMemoryLimit: "2g"}
+	applyResourceLimitOverrides(cfg, &ResourceLimits{Pids: 100})
+	withResourceLimitRunArgs(merged, EffectiveResourceLimits(cfg, nil))
+
+	want := []interface{}{
+		"--cap-add=SYS_PTRACE", "--init",
+		"--cpus", "1", "--memory", "2g", "--memory-swap", "2g", "--pids-limit", "100",
+	}
+	if !reflect.DeepEqual(merged["runArgs"], want) {
+		t.Fatalf("runArgs = %v, want %v", merged["runArgs"], want)
+	}
+
+	// Without limits the repository's runArgs are left alone.
+	untouched := map[string]interface{}{"runArgs": []interface{}{"--memory", "64g"}}
+	withResourceLimitRunArgs(untouched, ResourceLimits{})
+	if !reflect.DeepEqual(untouched["runArgs"], []interface{}{"--memory", "64g"}) {
+		t.Fatalf("runArgs = %v, want unchanged", untouched["runArgs"])
+	}
+}
+
+func TestWriteDefaultDevcontainerConfigResourceLimits(t *testing.T) {
+	t.Parallel()
+
+	configPath := filepath.Join(t.TempDir(), "default-devcontainer.json")
+	cfg := &config.Config{
+		DefaultDevcontainerConfigPath: configPath,
+		WorkspaceMemoryLimit:          "4g",
+		WorkspacePidsLimit:            1024,
+	}
+	if _, err := writeDefaultDevcontainerConfig(cfg, "sam-ws-1", ""); err != nil {
+		t.Fatalf("writeDefaultDevcontainerConfig: %v", err)
+	}
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed struct {
+		RunArgs []string `json:"runArgs"`
+	}
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		t.Fatalf("generated config is not valid JSON: %v\n%s", err, data)
+	}
+	want := []string{"--memory", "4g", "--memory-swap", "4g", "--pids-limit", "1024"}
+	if !reflect.DeepEqual(parsed.RunArgs, want) {
+		t.Fatalf("runArgs = %v, want %v", parsed.RunArgs, want)
+	}
+}
@@ -0,0 +1,228 @@
+package bootstrap
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/containerruntime"
+	"github.com/workspace/vm-agent/internal/execaudit"
+)
+
+// projectFileOwnerPattern matches chown specs: "user", "user:group", or
+// numeric IDs.
+var projectFileOwnerPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*(:[A-Za-z0-9_][A-Za-z0-9_.-]*)?$`)
+
+// Areas of the project runtime batch archive. Entries are unpacked into a
+// staging directory and moved to the matching location in the container.
+const (
+	runtimeAreaAbsolute = "abs"  // absolute container paths
+	runtimeAreaHome     = "home" // paths under the container user's home ("~/")
+	runtimeAreaWorkDir  = "work" // paths relative to the container workdir
+)
+
+// projectRuntimeBatchScript installs a project runtime batch in one docker
+// exec. It runs as root with the archive on stdin; $1 is the container user,
+// whose home "~/" paths resolve against, and $2 the container workdir. The
+// remaining arguments are the AREA PATH MODE OWNER of each file.
+const projectRuntimeBatchScript = `set -e
+user="$1"
+workdir="$2"
+home=""
+if [ -n "$user" ]; then
+  home=$(getent passwd "$user" 2>/dev/null | cut -d: -f6) || true
+  [ -n "$home" ] || home=$(awk -F: -v u="$user" '$1 == u { print $6 }' /etc/passwd 2>/dev/null) || true
+fi
+[ -n "$home" ] || home=/root
+stage=$(mktemp -d)
+trap 'rm -rf "$stage"' EXIT
+tar -x -f - -C "$stage"
+# place AREA PATH MODE OWNER
+place() {
+  case "$1" in
+    home) dest="$home/$2" ;;
+    work) dest="$workdir/$2" ;;
+    *) dest="/$2" ;;
+  esac
+  dir=$(dirname "$dest")
+  top=""
+  probe="$dir"
+  while [ ! -d "$probe" ]; do top="$probe"; probe=$(dirname "$probe"); done
+  mkdir -p "$dir"
+  if [ -n "$top" ] && [ -n "$4" ]; then find "$top" -type d -exec chown "$4" {} \; ; fi
+  mv -f "$stage/$1/$2" "$dest"
+  chmod "$3" "$dest"
+  if [ -n "$4" ]; then chown "$4" "$dest"; fi
+}
+shift 2
+while [ $# -gt 0 ]; do
+  place "$1" "$2" "$3" "$4"
+  shift 4
+done
+`
+
+// projectRuntimeBatchEntry is one file of a project runtime batch.
+type projectRuntimeBatchEntry struct {
+	area    string
+	relPath string // within area, without a leading slash
+	label   string // path for error messages
+	content []byte
+	mode    os.FileMode
+	owner   string // chown spec; "" leaves the file owned by root
+}
+
+// projectRuntimeBatch is the archive, script and per-file script arguments
+// that install project runtime files in one round-trip.
+type projectRuntimeBatch struct {
+	archive []byte
+	script  string
+	args    []string
+	files   int
+}
+
+// buildProjectRuntimeBatch resolves files to their container locations and
+// packs them, together with the env script when envScript is non-empty,
+// into a tar archive plus the script that installs it. Files without an
+// explicit owner are owned by defaultOwner. A later file replaces an earlier
+// one at the same path.
+func buildProjectRuntimeBatch(envScript string, files []ProjectRuntimeFile, defaultOwner string) (projectRuntimeBatch, error) {
+	entries, err := projectRuntimeBatchEntries(envScript, files, defaultOwner)
+	if err != nil {
+		return projectRuntimeBatch{}, err
+	}
+	return packProjectRuntimeBatch(entries, projectRuntimeBatchScript, func(entry projectRuntimeBatchEntry) []string {
+		return []string{entry.area, entry.relPath, fmt.Sprintf("%04o", uint32(entry.mode.Perm())|specialModeBits(entry.mode)), entry.owner}
+	})
+}
+
+// projectRuntimeBatchEntries resolves the env script and files to their
+// container locations, the first half of buildProjectRuntimeBatch.
+func projectRuntimeBatchEntries(envScript string, files []ProjectRuntimeFile, defaultOwner string) ([]projectRuntimeBatchEntry, error) {
+	entries := make([]projectRuntimeBatchEntry, 0, len(files)+2)
+	if envScript != "" {
+		for _, target := range []string{"etc/profile.d/sam-project-env.sh", "etc/sam/project-env"} {
+			entries = append(entries, projectRuntimeBatchEntry{
+				area:    runtimeAreaAbsolute,
+				relPath: target,
+				label:   "/" + target,
+				content: []byte(envScript),
+				mode:    0o644,
+			})
+		}
+	}
+
+	index := make(map[string]int, len(files))
+	for _, file := range files {
+		normalizedPath, err := normalizeProjectRuntimeFilePath(file.Path)
+		if err != nil {
+			return nil, err
+		}
+		entry := projectRuntimeBatchEntry{
+			label:   normalizedPath,
+			content: []byte(file.Content),
+			mode:    file.Mode.Perm() | (file.Mode & (os.ModeSetuid | os.ModeSetgid | os.ModeSticky)),
+			owner:   strings.TrimSpace(file.Owner),
+		}
+		switch {
+		case strings.HasPrefix(normalizedPath, "/"):
+			entry.area, entry.relPath = runtimeAreaAbsolute, strings.TrimPrefix(normalizedPath, "/")
+		case strings.HasPrefix(normalizedPath, "~/"):
+			entry.area, entry.relPath = runtimeAreaHome, strings.TrimPrefix(normalizedPath, "~/")
+		default:
+			entry.area, entry.relPath = runtimeAreaWorkDir, normalizedPath
+		}
+		if entry.relPath == "" {
+			return nil, fmt.Errorf("project file path %s must name a file", normalizedPath)
+		}
+		if file.Mode == 0 {
+			entry.mode = 0o644
+			if file.IsSecret {
+				entry.mode = 0o600
+			}
+		}
+		if entry.owner == "" {
+			entry.owner = defaultOwner
+		}
+		if entry.owner != "" && !projectFileOwnerPattern.MatchString(entry.owner) {
+			return nil, fmt.Errorf("invalid owner %q for project file %s", entry.owner, normalizedPath)
+		}
+
+		key := entry.area + "/" + entry.relPath
+		if i, ok := index[key]; ok {
+			entries[i] = entry
+			continue
+		}
+		index[key] = len(entries)
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// packProjectRuntimeBatch archives entries and collects the script
+// arguments entryArgs returns for each.
+func packProjectRuntimeBatch(entries []projectRuntimeBatchEntry, script string, entryArgs func(projectRuntimeBatchEntry) []string) (projectRuntimeBatch, error) {
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	var args []string
+	now := time.Now()
+	for _, entry := range entries {
+		header := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     path.Join(entry.area, entry.relPath),
+			Mode:     int64(entry.mode),
+			Size:     int64(len(entry.content)),
+			ModTime:  now,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return projectRuntimeBatch{}, fmt.Errorf("failed to archive project file %s: %w", entry.label, err)
+		}
+		if _, err := tw.Write(entry.content); err != nil {
+			return projectRuntimeBatch{}, fmt.Errorf("failed to archive project file %s: %w", entry.label, err)
+		}
+		args = append(args, entryArgs(entry)...)
+	}
+	if err := tw.Close(); err != nil {
+		return projectRuntimeBatch{}, fmt.Errorf("failed to archive project files: %w", err)
+	}
+
+	return projectRuntimeBatch{archive: archive.Bytes(), script: script, args: args, files: len(entries)}, nil
+}
+
+// specialModeBits converts setuid, setgid and sticky to their octal chmod
+// digits.
+func specialModeBits(mode os.FileMode) uint32 {
+	var bits uint32
+	if mode&os.ModeSetuid != 0 {
+		bits |= 0o4000
+	}
+	if mode&os.ModeSetgid != 0 {
+		bits |= 0o2000
+	}
+	if mode&os.ModeSticky != 0 {
+		bits |= 0o1000
+	}
+	return bits
+}
+
+// installProjectRuntimeBatch installs batch into the container with a single
+// docker exec.
+func installProjectRuntimeBatch(ctx context.Context, containerID, containerUser, workDir string, batch projectRuntimeBatch) error {
+	op := execaudit.Begin(ctx, containerID, "runtime_files.install", workDir)
+	if _, err := execBatchScript(ctx, containerID, containerUser, workDir, batch); op.End(err) != nil {
+		return fmt.Errorf("failed to install project runtime files: %w", err)
+	}
+	return nil
+}
+
+// execBatchScript runs batch's script as root with its archive on stdin.
+func execBatchScript(ctx context.Context, containerID, containerUser, workDir string, batch projectRuntimeBatch) (*containerruntime.ExecResult, error) {
+	command := append([]string{"sh", "-c", batch.script, "sh", containerUser, workDir}, batch.args...)
+	return containerruntime.Current().Exec(ctx, containerID,
+		containerruntime.ExecOptions{User: "root", Stdin: bytes.NewReader(batch.archive)}, command...)
+}
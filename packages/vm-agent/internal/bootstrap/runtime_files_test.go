@@ -0,0 +1,144 @@
+package bootstrap
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+func TestBuildProjectRuntimeBatch(t *testing.T) {
+	t.Parallel()
+
+	batch, err := buildProjectRuntimeBatch("export A='1'\n", []ProjectRuntimeFile{
+		{Path: ".env.local", Content: "old"},
+		{Path: "~/.npmrc", Content: "token", IsSecret: true},
+		{Path: "/opt/tools/run.sh", Content: "#!/bin/sh\n", Mode: 0o755, Owner: "root"},
+		{Path: "./.env.local", Content: "new"},
+	}, "node")
+	if err != nil {
+		t.Fatalf("buildProjectRuntimeBatch returned error: %v", err)
+	}
+	if batch.files != 5 {
+		t.Fatalf("files = %d, want 5 (2 env script copies, 3 deduplicated files)", batch.files)
+	}
+
+	contents := map[string]string{}
+	modes := map[string]int64{}
+	tr := tar.NewReader(bytes.NewReader(batch.archive))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read archive: %v", err)
+		}
+		data, _ := io.ReadAll(tr)
+		contents[header.Name] = string(data)
+		modes[header.Name] = header.Mode
+	}
+	want := map[string]int64{
+		"abs/etc/profile.d/sam-project-env.sh": 0o644,
+		"abs/etc/sam/project-env":              0o644,
+		"work/.env.local":                      0o644,
+		"home/.npmrc":                          0o600,
+		"abs/opt/tools/run.sh":                 0o755,
+	}
+	for name, mode := range want {
+		if modes[name] != mode {
+			t.Fatalf("archive entry %s mode = %o, want %o (entries: %v)", name, modes[name], mode, modes)
+		}
+	}
+	if contents["work/.env.local"] != "new" {
+		t.Fatalf("duplicate path kept %q, want last content", contents["work/.env.local"])
+	}
+
+	wantArgs := []string{
+		"abs", "etc/profile.d/sam-project-env.sh", "0644", "",
+		"abs", "etc/sam/project-env", "0644", "",
+		"work", ".env.local", "0644", "node",
+		"home", ".npmrc", "0600", "node",
+		"abs", "opt/tools/run.sh", "0755", "root",
+	}
+	if !reflect.DeepEqual(batch.args, wantArgs) {
+		t.Fatalf("args = %q\nwant %q", batch.args, wantArgs)
+	}
+}
+
+func TestBuildProjectRuntimeBatchRejectsInvalidOwner(t *testing.T) {
+	t.Parallel()
+
+	_, err := buildProjectRuntimeBatch("", []ProjectRuntimeFile{
+		{Path: ".env", Content: "x", Owner: "node; rm -rf /"},
+	}, "")
+	if err == nil || !strings.Contains(err.Error(), "invalid owner") {
+		t.Fatalf("expected invalid owner error, got %v", err)
+	}
+}
+
+func TestEnsureProjectRuntimeAssetsUsesSingleExec(t *testing.T) {
+	workDir := t.TempDir()
+	logPath := filepath.Join(t.TempDir(), "docker.log")
+	mockBinDir := t.TempDir()
+	// The mock runs the exec'd command locally so the batch script really
+	// extracts the archive into workDir.
+	dockerScript := `#!/bin/sh
+echo "$1" >> ` + shellSingleQuote(logPath) + `
+case "$1" in
+  ps) echo container-1 ;;
+  exec) shift 5; exec "$@" ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(mockBinDir, "docker"), []byte(dockerScript), 0o755); err != nil {
+		t.Fatalf("write docker mock: %v", err)
+	}
+	t.Setenv("PATH", mockBinDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	cfg := &config.Config{
+		ContainerWorkDir:    workDir,
+		ContainerLabelKey:   "devcontainer.local_folder",
+		ContainerLabelValue: "/workspace/repo",
+	}
+	files := []ProjectRuntimeFile{
+		{Path: ".env.local", Content: "FOO=bar\n", IsSecret: true},
+		{Path: "config/nested/settings.json", Content: "{}"},
+		{Path: "bin/run.sh", Content: "#!/bin/sh\n", Mode: 0o750},
+	}
+	if err := ensureProjectRuntimeAssets(context.Background(), cfg, nil, files); err != nil {
+		t.Fatalf("ensureProjectRuntimeAssets returned error: %v", err)
+	}
+
+	log, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("read docker log: %v", err)
+	}
+	if got := strings.Count(string(log), "exec\n"); got != 1 {
+		t.Fatalf("docker exec calls = %d, want 1 (log: %s)", got, log)
+	}
+
+	for rel, want := range map[string]os.FileMode{
+		".env.local":                  0o600,
+		"config/nested/settings.json": 0o644,
+		"bin/run.sh":                  0o750,
+	} {
+		info, err := os.Stat(filepath.Join(workDir, rel))
+		if err != nil {
+			t.Fatalf("stat %s: %v", rel, err)
+		}
+		if info.Mode().Perm() != want {
+			t.Fatalf("%s mode = %o, want %o", rel, info.Mode().Perm(), want)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(workDir, ".env.local"))
+	if err != nil || string(data) != "FOO=bar\n" {
+		t.Fatalf(".env.local content = %q, err = %v", data, err)
+	}
+}
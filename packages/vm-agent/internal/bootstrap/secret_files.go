@@ -0,0 +1,186 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/workspace/vm-agent/internal/config"
+	"github.com/workspace/vm-agent/internal/containerruntime"
+	"github.com/workspace/vm-agent/internal/execaudit"
+	"github.com/workspace/vm-agent/internal/provisionspec"
+)
+
+// SecretFilesDir is the tmpfs in the devcontainer that holds secret project
+// files when SECRET_FILES_TMPFS is enabled.
+const SecretFilesDir = "/run/secrets/sam"
+
+// secretFilesMarker is written once secret files are installed. A restarted
+// devcontainer comes up with an empty tmpfs and without it.
+const secretFilesMarker = SecretFilesDir + "/.installed"
+
+// secretFilesMount is the devcontainer mount entry of the secret tmpfs.
+const secretFilesMount = "type=tmpfs,target=" + SecretFilesDir + ",tmpfs-mode=0711"
+
+// secretFilesZeroFunc defines zero, which overwrites a regular file with
+// zeros before removing it so a deleted secret does not linger in freed
+// pages or disk blocks.
+const secretFilesZeroFunc = `zero() {
+  if [ -f "$1" ] && [ ! -L "$1" ]; then
+    size=$(wc -c < "$1")
+    if [ "$size" -gt 0 ]; then
+      dd if=/dev/zero of="$1" bs="$size" count=1 conv=notrunc 2>/dev/null || true
+    fi
+  fi
+  rm -f "$1"
+}
+`
+
+// secretFilesScript installs secret project files in one docker exec. It
+// runs as root with the archive on stdin; $1 is the container user and $2
+// the container workdir, as for projectRuntimeBatchScript. The archive is
+// unpacked inside the tmpfs, so secret contents never reach the disk. The
+// remaining arguments are the AREA PATH OWNER of each file.
+const secretFilesScript = `set -e
+user="$1"
+workdir="$2"
+home=""
+if [ -n "$user" ]; then
+  home=$(getent passwd "$user" 2>/dev/null | cut -d: -f6) || true
+  [ -n "$home" ] || home=$(awk -F: -v u="$user" '$1 == u { print $6 }' /etc/passwd 2>/dev/null) || true
+fi
+[ -n "$home" ] || home=/root
+dir=` + SecretFilesDir + `
+mkdir -p "$dir"
+if ! awk -v d="$dir" '$2 == d && $3 == "tmpfs" { found = 1 } END { exit !found }' /proc/mounts; then
+  mount -t tmpfs -o mode=0711,size=16m tmpfs "$dir" 2>/dev/null || { echo "$dir is not a tmpfs and one could not be mounted" >&2; exit 1; }
+fi
+chmod 0711 "$dir"
+` + secretFilesZeroFunc + `stage=$(mktemp -d "$dir/.stage.XXXXXX")
+trap 'find "$stage" -type f | while read -r f; do zero "$f"; done; rm -rf "$stage"' EXIT
+tar -x -f - -C "$stage"
+: > "$stage/.keep"
+# secret AREA PATH OWNER
+secret() {
+  case "$1" in
+    home) dest="$home/$2" ;;
+    work) dest="$workdir/$2" ;;
+    *) dest="/$2" ;;
+  esac
+  target="$dir/$1/$2"
+  d=$(dirname "$target")
+  mkdir -p "$d"
+  while [ "$d" != "$dir" ]; do chmod 0711 "$d"; d=$(dirname "$d"); done
+  [ ! -f "$target" ] || zero "$target"
+  mv -f "$stage/$1/$2" "$target"
+  chmod 0400 "$target"
+  if [ -n "$3" ]; then chown "$3" "$target"; fi
+  echo "$target" >> "$stage/.keep"
+  destdir=$(dirname "$dest")
+  top=""
+  probe="$destdir"
+  while [ ! -d "$probe" ]; do top="$probe"; probe=$(dirname "$probe"); done
+  mkdir -p "$destdir"
+  if [ -n "$top" ] && [ -n "$3" ]; then find "$top" -type d -exec chown "$3" {} \; ; fi
+  if [ -f "$dest" ] && [ ! -L "$dest" ]; then zero "$dest"; fi
+  ln -sfn "$target" "$dest"
+  if [ -n "$3" ]; then chown -h "$3" "$dest"; fi
+}
+# finish zeroes secrets of an earlier install that are no longer wanted and
+# marks the install complete.
+finish() {
+  find "$dir" -type f ! -path "$stage/*" ! -path "$dir/.installed" | while read -r f; do
+    grep -qxF "$f" "$stage/.keep" || zero "$f"
+  done
+  : > "$dir/.installed"
+}
+shift 2
+while [ $# -gt 0 ]; do
+  secret "$1" "$2" "$3"
+  shift 3
+done
+finish
+`
+
+// withSecretFilesMount adds the secret tmpfs to a merged devcontainer
+// configuration when SECRET_FILES_TMPFS is enabled.
+func withSecretFilesMount(merged map[string]interface{}, cfg *config.Config) {
+	if !cfg.SecretFilesTmpfs {
+		return
+	}
+	existing, _ := merged["mounts"].([]interface{})
+	merged["mounts"] = append(existing, secretFilesMount)
+}
+
+// splitSecretFiles separates the files that go on the secret tmpfs from
+// the ones written to the container filesystem.
+func splitSecretFiles(files []ProjectRuntimeFile) (regular, secret []ProjectRuntimeFile) {
+	for _, file := range files {
+		if file.IsSecret {
+			secret = append(secret, file)
+		} else {
+			regular = append(regular, file)
+		}
+	}
+	return regular, secret
+}
+
+// buildSecretFilesBatch packs secret files for secretFilesScript. Each is
+// installed read-only on the tmpfs, with a symlink at its declared path.
+func buildSecretFilesBatch(files []ProjectRuntimeFile, defaultOwner string) (projectRuntimeBatch, error) {
+	entries, err := projectRuntimeBatchEntries("", files, defaultOwner)
+	if err != nil {
+		return projectRuntimeBatch{}, err
+	}
+	return packProjectRuntimeBatch(entries, secretFilesScript, func(entry projectRuntimeBatchEntry) []string {
+		return []string{entry.area, entry.relPath, entry.owner}
+	})
+}
+
+// installSecretFiles replaces the secret files of a container with files,
+// zeroing the ones it no longer holds. Contents travel on stdin and never
+// appear in arguments or logs.
+func installSecretFiles(ctx context.Context, containerID, containerUser, workDir string, files []ProjectRuntimeFile) error {
+	batch, err := buildSecretFilesBatch(files, containerUser)
+	if err != nil {
+		return err
+	}
+	op := execaudit.Begin(ctx, containerID, "secret_files.install", workDir)
+	if _, err := execBatchScript(ctx, containerID, containerUser, workDir, batch); op.End(err) != nil {
+		return fmt.Errorf("failed to install secret files: %w", err)
+	}
+	slog.Info("Installed secret files on tmpfs", "containerID", containerID, "fileCount", len(files))
+	return nil
+}
+
+// SecretFilesInstalled reports whether a container's secret files are in
+// place. It is false once a restart has emptied the tmpfs.
+func SecretFilesInstalled(ctx context.Context, containerID string) (bool, error) {
+	result, err := containerruntime.Current().Exec(ctx, containerID, rootExec,
+		"sh", "-c", `if [ -e "$1" ]; then echo yes; else echo no; fi`, "sh", secretFilesMarker,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to check secret files: %w", err)
+	}
+	return strings.TrimSpace(string(result.Stdout)) == "yes", nil
+}
+
+// ReinjectSecretFiles installs the secret files among files and spec, as
+// provisioning merges them, in a running container again.
+func ReinjectSecretFiles(ctx context.Context, containerID, containerUser, workDir string, files []ProjectRuntimeFile, spec *provisionspec.Spec) error {
+	_, secret := splitSecretFiles(mergeSpecProjectFiles(files, spec))
+	return installSecretFiles(ctx, containerID, strings.TrimSpace(containerUser), strings.TrimSpace(workDir), secret)
+}
+
+// ZeroSecretFiles overwrites and removes a running container's secret
+// files, ahead of removing the container.
+func ZeroSecretFiles(ctx context.Context, containerID string) error {
+	op := execaudit.Begin(ctx, containerID, "secret_files.zero", "")
+	if _, err := containerruntime.Current().Exec(ctx, containerID, rootExec,
+		"sh", "-c", secretFilesZeroFunc+`[ ! -d "$1" ] || find "$1" -type f | while read -r f; do zero "$f"; done`, "sh", SecretFilesDir,
+	); op.End(err) != nil {
+		return fmt.Errorf("failed to zero secret files: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package bootstrap
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+func TestBuildSecretFilesBatch(t *testing.T) {
+	t.Parallel()
+
+	batch, err := buildSecretFilesBatch([]ProjectRuntimeFile{
+		{Path: "~/.npmrc", Content: "token", IsSecret: true},
+		{Path: "/etc/app/key.pem", Content: "pem", IsSecret: true, Owner: "root"},
+	}, "node")
+	if err != nil {
+		t.Fatalf("buildSecretFilesBatch returned error: %v", err)
+	}
+
+	contents := map[string]string{}
+	tr := tar.NewReader(bytes.NewReader(batch.archive))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read archive: %v", err)
+		}
+		data, _ := io.ReadAll(tr)
+		contents[header.Name] = string(data)
+	}
+	if contents["home/.npmrc"] != "token" || contents["abs/etc/app/key.pem"] != "pem" {
+		t.Fatalf("archive contents = %v", contents)
+	}
+
+	wantArgs := []string{"home", ".npmrc", "node", "abs", "etc/app/key.pem", "root"}
+	if !reflect.DeepEqual(batch.args, wantArgs) {
+		t.Fatalf("args = %q\nwant %q", batch.args, wantArgs)
+	}
+	if !strings.HasSuffix(batch.script, "finish\n") {
+		t.Fatalf("script does not end with finish:\n%s", batch.script)
+	}
+}
+
+func TestEnsureProjectRuntimeAssetsWritesSecretsToTmpfs(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "docker.log")
+	mockBinDir := t.TempDir()
+	// The mock logs each exec's arguments and swallows the archive.
+	dockerScript := `#!/bin/sh
+case "$1" in
+  ps) echo container-1 ;;
+  exec) printf '%s\n---\n' "$*" >> ` + shellSingleQuote(logPath) + `; cat > /dev/null ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(mockBinDir, "docker"), []byte(dockerScript), 0o755); err != nil {
+		t.Fatalf("write docker mock: %v", err)
+	}
+	t.Setenv("PATH", mockBinDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	cfg := &config.Config{
+		ContainerWorkDir:    "/workspaces/repo",
+		ContainerUser:       "node",
+		ContainerLabelKey:   "devcontainer.local_folder",
+		ContainerLabelValue: "/workspace/repo",
+		SecretFilesTmpfs:    true,
+	}
+	files := []ProjectRuntimeFile{
+		{Path: ".env.local", Content: "SECRET=hunter2\n", IsSecret: true},
+		{Path: "config.json", Content: "{}"},
+	}
+	if err := ensureProjectRuntimeAssets(context.Background(), cfg, nil, files); err != nil {
+		t.Fatalf("ensureProjectRuntimeAssets returned error: %v", err)
+	}
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("read docker log: %v", err)
+	}
+	execs := strings.Split(strings.TrimSuffix(string(data), "---\n"), "---\n")
+	if len(execs) != 2 {
+		t.Fatalf("docker exec calls = %d, want 2 (log: %s)", len(execs), data)
+	}
+	if !strings.HasSuffix(execs[0], "work config.json 0644 node\n") || strings.Contains(execs[0], ".env.local") {
+		t.Fatalf("regular batch should hold only config.json:\n%s", execs[0])
+	}
+	if !strings.HasSuffix(execs[1], "work .env.local node\n") {
+		t.Fatalf("secret batch missing .env.local:\n%s", execs[1])
+	}
+	if strings.Contains(string(data), "hunter2") {
+		t.Fatal("secret content appeared in docker exec arguments")
+	}
+}
+
+func TestWithSecretFilesMount(t *testing.T) {
+	t.Parallel()
+
+	merged := map[string]interface{}{"mounts": []interface{}{"source=a,target=/a,type=bind"}}
+	withSecretFilesMount(merged, &config.Config{})
+	if got := len(merged["mounts"].([]interface{})); got != 1 {
+		t.Fatalf("mounts = %d with SECRET_FILES_TMPFS off, want 1", got)
+	}
+	withSecretFilesMount(merged, &config.Config{SecretFilesTmpfs: true})
+	mounts := merged["mounts"].([]interface{})
+	if len(mounts) != 2 || mounts[1] != secretFilesMount {
+		t.Fatalf("mounts = %v, want the secret tmpfs appended", mounts)
+	}
+}
@@ -0,0 +1,237 @@
+package bootstrap
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/workspace/vm-agent/internal/containerruntime"
+)
+
+// snapshotHTTPClient transfers snapshot archives. It has no client timeout;
+// callers bound transfers with their context instead, since archives of
+// large workspaces can take many minutes to move.
+var snapshotHTTPClient = &http.Client{}
+
+// SnapshotInfo describes a workspace volume snapshot after it was uploaded.
+type SnapshotInfo struct {
+	SizeBytes int64
+	SHA256    string
+}
+
+// ValidateSnapshotURL checks that a control-plane-provided object storage URL
+// is an absolute http(s) URL.
+func ValidateSnapshotURL(raw string) error {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return fmt.Errorf("invalid snapshot URL: %w", err)
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return errors.New("snapshot URL must be an absolute http(s) URL")
+	}
+	return nil
+}
+
+// redactSnapshotURL drops the query string, which carries the presigned
+// credentials, so snapshot URLs can be logged.
+func redactSnapshotURL(raw string) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "<invalid>"
+	}
+	return u.Scheme + "://" + u.Host + u.Path
+}
+
+// snapshotArchiveArgs returns the docker arguments that stream a gzipped tar
+// of the whole volume to stdout. The volume is mounted read-only.
+func snapshotArchiveArgs(volumeName string) []string {
+	return []string{
+		"run", "--rm",
+		"-v", volumeName + ":/workspaces:ro",
+		"alpine:latest",
+		"tar", "-czf", "-", "-C", "/workspaces", ".",
+	}
+}
+
+// restoreArchiveArgs returns the docker arguments that extract a gzipped tar
+// read from stdin into the volume root.
+func restoreArchiveArgs(volumeName string) []string {
+	return []string{
+		"run", "--rm", "-i",
+		"-v", volumeName + ":/workspaces",
+		"alpine:latest",
+		"tar", "-xzf", "-", "-C", "/workspaces",
+	}
+}
+
+// SnapshotVolume archives the workspace's sam-ws-<id> volume and uploads it
+// with an HTTP PUT to uploadURL, typically a presigned object storage URL
+// issued by the control plane. The archive is staged in a temp file so the
+// upload carries a Content-Length, which presigned PUTs require.
+//
+// The snapshot is taken from the live volume. Callers that need a consistent
+// copy should stop the workspace first.
+func SnapshotVolume(ctx context.Context, workspaceID, uploadURL string) (SnapshotInfo, error) {
+	if err := ValidateSnapshotURL(uploadURL); err != nil {
+		return SnapshotInfo{}, err
+	}
+	volumeName := VolumeNameForWorkspace(workspaceID)
+
+	archive, err := os.CreateTemp("", "sam-snapshot-*.tar.gz")
+	if err != nil {
+		return SnapshotInfo{}, fmt.Errorf("failed to create snapshot staging file: %w", err)
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	hash := sha256.New()
+	var stderr bytes.Buffer
+	cmd := containerruntime.Command(ctx, snapshotArchiveArgs(volumeName)...)
+	cmd.Stdout = io.MultiWriter(archive, hash)
+	cmd.Stderr = &stderr
+	slog.Info("Archiving workspace volume", "volumeName", volumeName)
+	if err := cmd.Run(); err != nil {
+		return SnapshotInfo{}, fmt.Errorf("failed to archive volume %s: %w: %s", volumeName, err, strings.TrimSpace(stderr.String()))
+	}
+
+	size, err := archive.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return SnapshotInfo{}, fmt.Errorf("failed to size snapshot archive: %w", err)
+	}
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return SnapshotInfo{}, fmt.Errorf("failed to rewind snapshot archive: %w", err)
+	}
+	if err := uploadSnapshot(ctx, uploadURL, archive, size); err != nil {
+		return SnapshotInfo{}, err
+	}
+
+	info := SnapshotInfo{SizeBytes: size, SHA256: hex.EncodeToString(hash.Sum(nil))}
+	slog.Info("Workspace volume snapshot uploaded", "volumeName", volumeName, "url", redactSnapshotURL(uploadURL), "sizeBytes", info.SizeBytes)
+	return info, nil
+}
+
+func uploadSnapshot(ctx context.Context, uploadURL string, body io.Reader, size int64) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, uploadURL, body)
+	if err != nil {
+		return fmt.Errorf("failed to build snapshot upload request: %w", err)
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/gzip")
+
+	resp, err := snapshotHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("snapshot upload failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("snapshot upload returned HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+func openSnapshot(ctx context.Context, downloadURL string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build snapshot download request: %w", err)
+	}
+	resp, err := snapshotHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot download failed: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("snapshot download returned HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return resp.Body, nil
+}
+
+// volumeIsEmpty reports whether the volume has no entries at its root.
+func volumeIsEmpty(ctx context.Context, volumeName string) (bool, error) {
+	cmd := containerruntime.Command(ctx, "run", "--rm",
+		"-v", volumeName+":/workspaces:ro",
+		"alpine:latest",
+		"ls", "-A", "/workspaces",
+	)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect volume %s: %w: %s", volumeName, err, strings.TrimSpace(string(output)))
+	}
+	return strings.TrimSpace(string(output)) == "", nil
+}
+
+// clearVolume removes everything in the volume so a failed restore does not
+// leave a half-extracted tree behind for the next attempt to trip over.
+func clearVolume(ctx context.Context, volumeName string) error {
+	cmd := containerruntime.Command(ctx, "run", "--rm",
+		"-v", volumeName+":/workspaces",
+		"alpine:latest",
+		"find", "/workspaces", "-mindepth", "1", "-delete",
+	)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to clear volume %s: %w: %s", volumeName, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// RestoreVolume seeds the workspace's volume from a snapshot archive at
+// downloadURL. It must run before the repository is populated and before
+// `devcontainer up`. A volume that already has content is left untouched and
+// RestoreVolume returns false, which keeps re-provisioning and recovery on the
+// same node idempotent. A failed extract clears the volume and returns an
+// error rather than falling back to a fresh clone, so a migration never
+// silently drops the workspace's state.
+func RestoreVolume(ctx context.Context, workspaceID, downloadURL string) (bool, error) {
+	if err := ValidateSnapshotURL(downloadURL); err != nil {
+		return false, err
+	}
+	volumeName, err := ensureVolumeReady(ctx, workspaceID)
+	if err != nil {
+		return false, err
+	}
+
+	empty, err := volumeIsEmpty(ctx, volumeName)
+	if err != nil {
+		return false, err
+	}
+	if !empty {
+		slog.Info("Volume already has content, skipping snapshot restore", "volumeName", volumeName)
+		return false, nil
+	}
+
+	body, err := openSnapshot(ctx, downloadURL)
+	if err != nil {
+		return false, err
+	}
+	defer body.Close()
+
+	var stderr bytes.Buffer
+	cmd := containerruntime.Command(ctx, restoreArchiveArgs(volumeName)...)
+	cmd.Stdin = body
+	cmd.Stderr = &stderr
+	slog.Info("Restoring workspace volume from snapshot", "volumeName", volumeName, "url", redactSnapshotURL(downloadURL))
+	if err := cmd.Run(); err != nil {
+		restoreErr := fmt.Errorf("failed to extract snapshot into volume %s: %w: %s", volumeName, err, strings.TrimSpace(stderr.String()))
+		if clearErr := clearVolume(context.WithoutCancel(ctx), volumeName); clearErr != nil {
+			slog.Warn("Failed to clear volume after snapshot restore failure", "volumeName", volumeName, "error", clearErr)
+		}
+		return false, restoreErr
+	}
+
+	if err := ensureVolumeWritable(ctx, volumeName); err != nil {
+		return false, err
+	}
+	slog.Info("Workspace volume restored from snapshot", "volumeName", volumeName)
+	return true, nil
+}
@@ -0,0 +1,105 @@
+package bootstrap
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidateSnapshotURL(t *testing.T) {
+	t.Parallel()
+
+	for _, raw := range []string{"https://storage.example.com/a.tar.gz?sig=1", "http://127.0.0.1:9000/b"} {
+		if err := ValidateSnapshotURL(raw); err != nil {
+			t.Errorf("ValidateSnapshotURL(%q) = %v, want nil", raw, err)
+		}
+	}
+	for _, raw := range []string{"", "not a url", "file:///tmp/a.tar.gz", "https:///no-host"} {
+		if err := ValidateSnapshotURL(raw); err == nil {
+			t.Errorf("ValidateSnapshotURL(%q) = nil, want error", raw)
+		}
+	}
+}
+
+func TestRedactSnapshotURL(t *testing.T) {
+	t.Parallel()
+
+	got := redactSnapshotURL("https://storage.example.com/snaps/ws.tar.gz?X-Amz-Signature=secret")
+	if got != "https://storage.example.com/snaps/ws.tar.gz" {
+		t.Fatalf("redactSnapshotURL = %q", got)
+	}
+}
+
+func TestSnapshotArchiveArgsMountVolume(t *testing.T) {
+	t.Parallel()
+
+	archive := strings.Join(snapshotArchiveArgs("sam-ws-abc"), " ")
+	if !strings.Contains(archive, "-v sam-ws-abc:/workspaces:ro") || !strings.HasSuffix(archive, "tar -czf - -C /workspaces .") {
+		t.Fatalf("snapshotArchiveArgs = %q", archive)
+	}
+	restore := strings.Join(restoreArchiveArgs("sam-ws-abc"), " ")
+	if !strings.Contains(restore, "run --rm -i") || !strings.Contains(restore, "-v sam-ws-abc:/workspaces ") || !strings.HasSuffix(restore, "tar -xzf - -C /workspaces") {
+		t.Fatalf("restoreArchiveArgs = %q", restore)
+	}
+}
+
+func TestUploadSnapshotSendsSizedPut(t *testing.T) {
+	t.Parallel()
+
+	var gotMethod, gotBody string
+	var gotLength int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod = r.Method
+		gotLength = r.ContentLength
+		data, _ := io.ReadAll(r.Body)
+		gotBody = string(data)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	if err := uploadSnapshot(context.Background(), server.URL+"/snap?sig=1", strings.NewReader("archive"), 7); err != nil {
+		t.Fatalf("uploadSnapshot: %v", err)
+	}
+	if gotMethod != http.MethodPut || gotLength != 7 || gotBody != "archive" {
+		t.Fatalf("upload = %s length %d body %q", gotMethod, gotLength, gotBody)
+	}
+}
+
+func TestSnapshotTransfersReportHTTPErrors(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "SignatureDoesNotMatch", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	err := uploadSnapshot(context.Background(), server.URL, strings.NewReader("x"), 1)
+	if err == nil || !strings.Contains(err.Error(), "HTTP 403") || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Fatalf("uploadSnapshot error = %v", err)
+	}
+	if _, err := openSnapshot(context.Background(), server.URL); err == nil || !strings.Contains(err.Error(), "HTTP 403") {
+		t.Fatalf("openSnapshot error = %v", err)
+	}
+}
+
+func TestOpenSnapshotStreamsBody(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("archive-bytes"))
+	}))
+	defer server.Close()
+
+	body, err := openSnapshot(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("openSnapshot: %v", err)
+	}
+	defer body.Close()
+	data, _ := io.ReadAll(body)
+	if string(data) != "archive-bytes" {
+		t.Fatalf("body = %q", data)
+	}
+}
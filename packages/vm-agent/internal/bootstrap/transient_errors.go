@@ -0,0 +1,62 @@
+package bootstrap
+
+import "strings"
+
+// transientErrorMarkers are output fragments of git, docker, and the
+// devcontainer CLI that mean the remote end (a git host or an image
+// registry) failed or was unreachable, not that the request was wrong.
+var transientErrorMarkers = []string{
+	"500 internal server error",
+	"502 bad gateway",
+	"503 service unavailable",
+	"504 gateway time",
+	"429 too many requests",
+	"toomanyrequests",
+	"the requested url returned error: 5",
+	"tls handshake timeout",
+	"i/o timeout",
+	"connection reset by peer",
+	"connection refused",
+	"could not resolve host",
+	"temporary failure in name resolution",
+	"unexpected eof",
+	"early eof",
+	"rpc failed",
+	"the remote end hung up unexpectedly",
+}
+
+// fatalErrorMarkers are output fragments that mean the request itself is
+// wrong: bad credentials, a missing repository or image, or a broken
+// devcontainer.json or Dockerfile. They win over transientErrorMarkers, since
+// a parse error can mention an "unexpected EOF" of its own.
+var fatalErrorMarkers = []string{
+	"authentication failed",
+	"repository not found",
+	"pull access denied",
+	"manifest unknown",
+	"invalid reference format",
+	"dockerfile parse error",
+	"unknown instruction",
+	"failed to read dockerfile",
+	"failed to parse",
+	"syntax error",
+}
+
+// isTransientError reports whether command output shows a failure worth
+// retrying. Output matching a fatal marker, and anything it does not
+// recognize, such as a missing branch or a failing build step, is treated as
+// permanent.
+func isTransientError(output string) bool {
+	output = strings.ToLower(output)
+	for _, marker := range fatalErrorMarkers {
+		if strings.Contains(output, marker) {
+			return false
+		}
+	}
+	for _, marker := range transientErrorMarkers {
+		if strings.Contains(output, marker) {
+			return true
+		}
+	}
+	return false
+}
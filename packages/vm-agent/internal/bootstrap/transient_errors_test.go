@@ -0,0 +1,30 @@
+package bootstrap
+
+import "testing"
+
+func TestIsTransientError(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		output string
+		want   bool
+	}{
+		{"error pulling image: received unexpected HTTP status: 503 Service Unavailable", true},
+		{"toomanyrequests: You have reached your pull rate limit", true},
+		{"net/http: TLS handshake timeout", true},
+		{"fatal: unable to access 'https://github.com/o/r.git/': The requested URL returned error: 502", true},
+		{"error: RPC failed; curl 56 GnuTLS recv error (-9)\nfatal: early EOF", true},
+		{"fatal: Authentication failed for 'https://github.com/o/r.git/'", false},
+		{"fatal: Remote branch nope not found in upstream origin", false},
+		{"The requested URL returned error: 404", false},
+		{"failed to solve: process \"/bin/sh -c make\" did not complete successfully: exit code: 2", false},
+		{"failed to solve: dockerfile parse error on line 3: unknown instruction: RUNN", false},
+		{"failed to solve: failed to read dockerfile: open Dockerfile: no such file or directory", false},
+		{"Error: failed to parse devcontainer.json: unexpected EOF", false},
+		{"ghcr.io/acme/base:1: manifest unknown", false},
+	}
+	for _, tt := range tests {
+		if got := isTransientError(tt.output); got != tt.want {
+			t.Errorf("isTransientError(%q) = %v, want %v", tt.output, got, tt.want)
+		}
+	}
+}
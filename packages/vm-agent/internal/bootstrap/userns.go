@@ -0,0 +1,172 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/workspace/vm-agent/internal/config"
+	"github.com/workspace/vm-agent/internal/containerruntime"
+)
+
+// idMapRange is one line of /proc/<pid>/uid_map or gid_map: count IDs
+// starting at inside in the container's user namespace are the IDs starting
+// at outside on the host.
+type idMapRange struct {
+	inside, outside, count uint64
+}
+
+// parseIDMap parses the contents of a uid_map or gid_map file.
+func parseIDMap(data string) ([]idMapRange, error) {
+	var ranges []idMapRange
+	for _, line := range strings.Split(strings.TrimSpace(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("malformed ID map line %q", line)
+		}
+		var values [3]uint64
+		for i, field := range fields {
+			v, err := strconv.ParseUint(field, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("malformed ID map line %q: %w", line, err)
+			}
+			values[i] = v
+		}
+		ranges = append(ranges, idMapRange{inside: values[0], outside: values[1], count: values[2]})
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("empty ID map")
+	}
+	return ranges, nil
+}
+
+// hostID translates a container ID to the host ID it maps to. ok is false
+// when the ID is outside every range, i.e. the container cannot use it.
+func hostID(ranges []idMapRange, id uint64) (uint64, bool) {
+	for _, r := range ranges {
+		if id >= r.inside && id-r.inside < r.count {
+			return r.outside + (id - r.inside), true
+		}
+	}
+	return 0, false
+}
+
+// containerIDMaps reads the UID and GID maps of a running container's user
+// namespace from its init process.
+func containerIDMaps(ctx context.Context, containerID string) (uidMap, gidMap []idMapRange, err error) {
+	info, err := containerruntime.Current().Inspect(ctx, containerID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to inspect container pid: %w", err)
+	}
+	if info.Pid <= 0 {
+		return nil, nil, fmt.Errorf("container %s is not running (pid %d)", containerID, info.Pid)
+	}
+	pid := strconv.Itoa(info.Pid)
+
+	readMap := func(name string) ([]idMapRange, error) {
+		data, err := os.ReadFile(filepath.Join("/proc", pid, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read container %s: %w", name, err)
+		}
+		ranges, err := parseIDMap(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("container %s: %w", name, err)
+		}
+		return ranges, nil
+	}
+	if uidMap, err = readMap("uid_map"); err != nil {
+		return nil, nil, err
+	}
+	if gidMap, err = readMap("gid_map"); err != nil {
+		return nil, nil, err
+	}
+	return uidMap, gidMap, nil
+}
+
+// volumeMountpoint returns the host directory backing a Docker named volume.
+func volumeMountpoint(ctx context.Context, volumeName string) (string, error) {
+	mountpoint, err := containerruntime.Current().VolumeMountpoint(ctx, volumeName)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect volume %s: %w", volumeName, err)
+	}
+	return mountpoint, nil
+}
+
+// ensureWorkspaceOwnershipUserns gives the container user ownership of the
+// workspace volume when the daemon runs containers in a user namespace. The
+// volume is chowned from the host to the host IDs the container user maps
+// to: inside the container, files owned by unmapped IDs all show up as the
+// overflow ID and cannot be chowned even by the container's root.
+func ensureWorkspaceOwnershipUserns(ctx context.Context, cfg *config.Config, containerID, user, uid, gid string) error {
+	uidMap, gidMap, err := containerIDMaps(ctx, containerID)
+	if err != nil {
+		return fmt.Errorf("failed to read user namespace mapping: %w", err)
+	}
+	containerUID, _ := strconv.ParseUint(uid, 10, 32)
+	containerGID, _ := strconv.ParseUint(gid, 10, 32)
+	hostUID, ok := hostID(uidMap, containerUID)
+	if !ok {
+		return fmt.Errorf("uid %s of container user %s is not mapped in the container's user namespace; widen the daemon's subordinate UID range", uid, user)
+	}
+	hostGID, ok := hostID(gidMap, containerGID)
+	if !ok {
+		return fmt.Errorf("gid %s of container user %s is not mapped in the container's user namespace; widen the daemon's subordinate GID range", gid, user)
+	}
+
+	root, err := volumeMountpoint(ctx, VolumeNameForWorkspace(cfg.WorkspaceID))
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(root); err != nil {
+		return fmt.Errorf("failed to stat workspace volume: %w", err)
+	} else if st, ok := info.Sys().(*syscall.Stat_t); ok && uint64(st.Uid) == hostUID && uint64(st.Gid) == hostGID {
+		slog.Info("Workspace ownership already set", "user", user, "uid", uid, "gid", gid, "hostUid", hostUID, "hostGid", hostGID)
+		return nil
+	}
+
+	err = filepath.WalkDir(root, func(path string, _ fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		return os.Lchown(path, int(hostUID), int(hostGID))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to chown workspace volume to %s (%s:%s, host %d:%d): %w", user, uid, gid, hostUID, hostGID, err)
+	}
+	slog.Info("Adjusted workspace volume ownership from host",
+		"user", user, "uid", uid, "gid", gid, "hostUid", hostUID, "hostGid", hostGID, "usernsMode", cfg.ContainerUsernsMode)
+	return nil
+}
+
+// dropPrivilegedRunArgs removes privileged mode from a merged devcontainer
+// configuration. Privileged containers cannot run under userns-remap, and
+// under rootless Docker they would hand the workspace the daemon user's full
+// capabilities.
+func dropPrivilegedRunArgs(merged map[string]interface{}) {
+	if priv, _ := merged["privileged"].(bool); priv {
+		slog.Warn("Ignoring privileged devcontainer setting in user namespace mode")
+		merged["privileged"] = false
+	}
+	runArgs, ok := merged["runArgs"].([]interface{})
+	if !ok {
+		return
+	}
+	kept := runArgs[:0]
+	for _, arg := range runArgs {
+		if s, _ := arg.(string); s == "--privileged" || s == "--privileged=true" {
+			slog.Warn("Ignoring --privileged devcontainer runArg in user namespace mode")
+			continue
+		}
+		kept = append(kept, arg)
+	}
+	merged["runArgs"] = kept
+}
@@ -0,0 +1,88 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+func TestParseIDMapAndHostID(t *testing.T) {
+	t.Parallel()
+
+	// Rootless Docker: container root is the daemon user, everything else
+	// comes from its subordinate range.
+	ranges, err := parseIDMap("         0       1000          1\n         1     100000      65536\n")
+	if err != nil {
+		t.Fatalf("parseIDMap: %v", err)
+	}
+	cases := []struct {
+		id   uint64
+		want uint64
+		ok   bool
+	}{
+		{0, 1000, true},
+		{1, 100000, true},
+		{1000, 100999, true},
+		{65536, 165535, true},
+		{65537, 0, false},
+	}
+	for _, tc := range cases {
+		got, ok := hostID(ranges, tc.id)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("hostID(%d) = %d, %v; want %d, %v", tc.id, got, ok, tc.want, tc.ok)
+		}
+	}
+
+	for _, bad := range []string{"", "0 1000", "0 x 1"} {
+		if _, err := parseIDMap(bad); err == nil {
+			t.Errorf("parseIDMap(%q) accepted a malformed map", bad)
+		}
+	}
+}
+
+func TestDropPrivilegedRunArgs(t *testing.T) {
+	t.Parallel()
+
+	merged := map[string]interface{}{
+		"privileged": true,
+		"runArgs":    []interface{}{"--privileged", "--cap-add=SYS_PTRACE", "--privileged=true"},
+	}
+	dropPrivilegedRunArgs(merged)
+	if priv, _ := merged["privileged"].(bool); priv {
+		t.Fatalf("privileged = %v, want false", merged["privileged"])
+	}
+	if want := []interface{}{"--cap-add=SYS_PTRACE"}; !reflect.DeepEqual(merged["runArgs"], want) {
+		t.Fatalf("runArgs = %v, want %v", merged["runArgs"], want)
+	}
+}
+
+func TestWriteDefaultDevcontainerConfigUsernsOmitsPrivileged(t *testing.T) {
+	t.Parallel()
+
+	configPath := filepath.Join(t.TempDir(), "default-devcontainer.json")
+	cfg := &config.Config{
+		DefaultDevcontainerConfigPath: configPath,
+		ContainerUsernsMode:           config.UsernsModeRemap,
+	}
+	if _, err := writeDefaultDevcontainerConfig(cfg, "sam-ws-1", ""); err != nil {
+		t.Fatalf("writeDefaultDevcontainerConfig: %v", err)
+	}
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed map[string]interface{}
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		t.Fatalf("generated config is not valid JSON: %v\n%s", err, data)
+	}
+	if _, ok := parsed["privileged"]; ok {
+		t.Fatalf("expected no privileged setting in user namespace mode:\n%s", data)
+	}
+	if parsed["workspaceMount"] == nil {
+		t.Fatalf("expected the workspace volume mount to be kept:\n%s", data)
+	}
+}
@@ -0,0 +1,96 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/workspace/vm-agent/internal/containerruntime"
+)
+
+// volumeSyncImage is a local helper image with rsync, built from alpine on
+// first use so no additional registry image has to be trusted or pulled.
+const volumeSyncImage = "sam-volume-sync:latest"
+
+const volumeSyncDockerfile = "FROM alpine:latest\nRUN apk add --no-cache rsync\n"
+
+// volumeSyncScript syncs the host clone mounted at /src into the volume copy
+// of the repository ($1). A volume that already has the repository is only
+// synced when a host file changed since the last sync, recorded by a marker
+// file outside the repository, or when $2 is "force". Volumes populated
+// before markers existed are treated as in sync.
+//
+// rsync runs with --update and without --delete: the volume is the user's
+// working copy, so files that are newer there or only exist there are kept.
+// The script prints "synced" or "skipped".
+const volumeSyncScript = `target="/workspaces/$1"
+marker="/workspaces/.sam-sync/$1"
+mkdir -p /workspaces/.sam-sync
+if [ -d "$target/.git" ] && [ "$2" != "force" ]; then
+  if [ ! -e "$marker" ]; then touch "$marker"; echo skipped; exit 0; fi
+  if [ -z "$(find /src -newer "$marker" | head -n 1)" ]; then echo skipped; exit 0; fi
+fi
+mkdir -p "$target"
+rsync -a --update /src/ "$target/" || exit 1
+touch "$marker"
+echo synced`
+
+// volumeSyncImageMu serializes helper image builds across workspaces
+// provisioning at the same time.
+var volumeSyncImageMu sync.Mutex
+
+// syncVolumeFromHost brings the workspace volume's copy of the repository up
+// to date with the host clone. The first sync copies the whole clone; later
+// syncs only transfer files changed on the host. With force, the volume is
+// synced even if the host clone has not changed since the last sync, which
+// restart flows use to repair a volume copy. Falls back to a full copy into
+// an empty volume when the rsync helper image cannot be built.
+func syncVolumeFromHost(ctx context.Context, hostPath, volumeName, repoDirName string, force bool) error {
+	if err := ensureVolumeSyncImage(ctx); err != nil {
+		slog.Warn("rsync helper image unavailable, falling back to a full volume copy", "error", err)
+		return populateVolumeFromHost(ctx, hostPath, volumeName, repoDirName)
+	}
+
+	output, err := containerruntime.Command(ctx, volumeSyncArgs(hostPath, volumeName, repoDirName, force)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to sync volume from host clone: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	slog.Info("Volume sync finished", "volumeName", volumeName, "repoDir", repoDirName, "force", force, "result", lines[len(lines)-1])
+
+	return ensureVolumeWritable(ctx, volumeName)
+}
+
+// volumeSyncArgs builds the `docker run` arguments for volumeSyncScript.
+func volumeSyncArgs(hostPath, volumeName, repoDirName string, force bool) []string {
+	mode := ""
+	if force {
+		mode = "force"
+	}
+	return []string{
+		"run", "--rm",
+		"-v", hostPath + ":/src:ro",
+		"-v", volumeName + ":/workspaces",
+		volumeSyncImage,
+		"sh", "-c", volumeSyncScript, "sh", repoDirName, mode,
+	}
+}
+
+// ensureVolumeSyncImage builds volumeSyncImage unless it already exists.
+func ensureVolumeSyncImage(ctx context.Context) error {
+	volumeSyncImageMu.Lock()
+	defer volumeSyncImageMu.Unlock()
+
+	if containerruntime.Command(ctx, "image", "inspect", volumeSyncImage).Run() == nil {
+		return nil
+	}
+	cmd := containerruntime.Command(ctx, "build", "-t", volumeSyncImage, "-")
+	cmd.Stdin = strings.NewReader(volumeSyncDockerfile)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to build %s: %w: %s", volumeSyncImage, err, strings.TrimSpace(string(output)))
+	}
+	slog.Info("Built volume sync helper image", "image", volumeSyncImage)
+	return nil
+}
@@ -0,0 +1,93 @@
+package bootstrap
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// installVolumeSyncDockerMock puts a docker mock on PATH that logs its calls
+// and build input. The helper image is missing; building it succeeds unless
+// buildFails.
+func installVolumeSyncDockerMock(t *testing.T, buildFails bool) string {
+	t.Helper()
+	mockBinDir := t.TempDir()
+	logPath := filepath.Join(mockBinDir, "calls.log")
+	buildExit := "0"
+	if buildFails {
+		buildExit = "1"
+	}
+	dockerScript := `#!/bin/sh
+echo "docker $@" >> ` + shellSingleQuote(logPath) + `
+case "$1 $2" in
+  "image inspect") exit 1 ;;
+  "build -t") cat >> ` + shellSingleQuote(logPath) + `; exit ` + buildExit + ` ;;
+esac
+case "$*" in
+  *"test -d"*) exit 1 ;;
+  *"rsync -a"*) echo synced ;;
+esac
+exit 0
+`
+	if err := os.WriteFile(filepath.Join(mockBinDir, "docker"), []byte(dockerScript), 0o755); err != nil {
+		t.Fatalf("write docker mock: %v", err)
+	}
+	t.Setenv("PATH", mockBinDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return logPath
+}
+
+func readDockerCalls(t *testing.T, logPath string) string {
+	t.Helper()
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("read docker log: %v", err)
+	}
+	return string(data)
+}
+
+func TestSyncVolumeFromHostUsesRsyncHelper(t *testing.T) {
+	logPath := installVolumeSyncDockerMock(t, false)
+	hostPath := t.TempDir()
+
+	if err := syncVolumeFromHost(context.Background(), hostPath, "sam-ws-1", "repo", true); err != nil {
+		t.Fatalf("syncVolumeFromHost: %v", err)
+	}
+
+	calls := readDockerCalls(t, logPath)
+	if !strings.Contains(calls, "docker build -t "+volumeSyncImage+" -") || !strings.Contains(calls, "apk add --no-cache rsync") {
+		t.Fatalf("expected the rsync helper image to be built; docker calls:\n%s", calls)
+	}
+	if !strings.Contains(calls, "docker run --rm -v "+hostPath+":/src:ro -v sam-ws-1:/workspaces "+volumeSyncImage) {
+		t.Fatalf("expected the sync to run in the helper image; docker calls:\n%s", calls)
+	}
+	if strings.Contains(calls, "cp -a") {
+		t.Fatalf("full copy used although the helper image was available; docker calls:\n%s", calls)
+	}
+
+	args := volumeSyncArgs(hostPath, "sam-ws-1", "repo", true)
+	if got := args[len(args)-2:]; got[0] != "repo" || got[1] != "force" {
+		t.Fatalf("sync script arguments = %q, want repo and force", got)
+	}
+	if args := volumeSyncArgs(hostPath, "sam-ws-1", "repo", false); args[len(args)-1] != "" {
+		t.Fatalf("sync without force passed mode %q", args[len(args)-1])
+	}
+}
+
+func TestSyncVolumeFromHostFallsBackToFullCopy(t *testing.T) {
+	logPath := installVolumeSyncDockerMock(t, true)
+	hostPath := t.TempDir()
+
+	if err := syncVolumeFromHost(context.Background(), hostPath, "sam-ws-1", "repo", false); err != nil {
+		t.Fatalf("syncVolumeFromHost: %v", err)
+	}
+
+	calls := readDockerCalls(t, logPath)
+	if !strings.Contains(calls, "cp -a /src /workspaces/repo") {
+		t.Fatalf("expected a full copy when the helper image cannot be built; docker calls:\n%s", calls)
+	}
+	if strings.Contains(calls, "rsync -a") {
+		t.Fatalf("rsync used without the helper image; docker calls:\n%s", calls)
+	}
+}
@@ -7,9 +7,10 @@ import (
 	"fmt"
 	"log/slog"
 	"net/url"
-	"os/exec"
 	"strings"
 	"time"
+
+	"github.com/workspace/vm-agent/internal/containerruntime"
 )
 
 // ParseGitHubRepo extracts the owner and repo name from a GitHub repository URL or owner/repo string.
@@ -90,7 +91,7 @@ func DockerLogin(ctx context.Context, registry, username, token string) error {
 		username = "x-access-token"
 	}
 
-	cmd := exec.CommandContext(ctx, "docker", "login", registry,
+	cmd := containerruntime.Command(ctx, "login", registry,
 		"--username", username,
 		"--password-stdin")
 	cmd.Stdin = strings.NewReader(token)
@@ -118,7 +119,7 @@ func redactSensitive(message string, values ...string) string {
 // Returns an error if the pull fails (caller decides whether this is fatal).
 func PullCacheImage(ctx context.Context, ref string) error {
 	start := time.Now()
-	cmd := exec.CommandContext(ctx, "docker", "pull", ref)
+	cmd := containerruntime.Command(ctx, "pull", ref)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("docker pull failed: %w: %s", err, strings.TrimSpace(string(output)))
@@ -132,14 +133,13 @@ func PullCacheImage(ctx context.Context, ref string) error {
 // a background goroutine after a successful build.
 func PushCacheImage(ctx context.Context, containerLabelKey, containerLabelValue, cacheRef string) error {
 	// Find the running container by label.
+	rt := containerruntime.Current()
 	filter := fmt.Sprintf("label=%s=%s", containerLabelKey, containerLabelValue)
-	psCmd := exec.CommandContext(ctx, "docker", "ps", "-q", "--filter", filter)
-	psOutput, err := psCmd.Output()
+	containers, err := rt.ListContainers(ctx, false, filter)
 	if err != nil {
 		return fmt.Errorf("failed to find devcontainer: %w", err)
 	}
 
-	containers := strings.Fields(string(psOutput))
 	if len(containers) == 0 {
 		return fmt.Errorf("no running devcontainer found for label %s=%s", containerLabelKey, containerLabelValue)
 	}
@@ -152,25 +152,24 @@ func PushCacheImage(ctx context.Context, containerLabelKey, containerLabelValue,
 	containerID := containers[0]
 
 	// Get the image ID from the container.
-	inspectCmd := exec.CommandContext(ctx, "docker", "inspect", "--format", "{{.Image}}", containerID)
-	inspectOutput, err := inspectCmd.Output()
+	info, err := rt.Inspect(ctx, containerID)
 	if err != nil {
 		return fmt.Errorf("failed to inspect container image: %w", err)
 	}
-	imageID := strings.TrimSpace(string(inspectOutput))
+	imageID := info.Image
 	if imageID == "" {
 		return fmt.Errorf("container %s has no image ID", containerID)
 	}
 
 	// Tag the image.
-	tagCmd := exec.CommandContext(ctx, "docker", "tag", imageID, cacheRef)
+	tagCmd := rt.Command(ctx, "tag", imageID, cacheRef)
 	if tagOutput, tagErr := tagCmd.CombinedOutput(); tagErr != nil {
 		return fmt.Errorf("docker tag failed: %w: %s", tagErr, strings.TrimSpace(string(tagOutput)))
 	}
 
 	// Push the tagged image.
 	start := time.Now()
-	pushCmd := exec.CommandContext(ctx, "docker", "push", cacheRef)
+	pushCmd := rt.Command(ctx, "push", cacheRef)
 	pushOutput, err := pushCmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("docker push failed: %w: %s", err, strings.TrimSpace(string(pushOutput)))
@@ -9,6 +9,8 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
+
+	"github.com/workspace/vm-agent/internal/retry"
 )
 
 // DefaultAdditionalFeatures is the default JSON for --additional-features on devcontainer up.
@@ -36,6 +38,10 @@ const DefaultDevcontainerImage = "mcr.microsoft.com/devcontainers/typescript-nod
 // when a repo has no devcontainer config. Override via DEFAULT_DEVCONTAINER_CONFIG_PATH env var.
 const DefaultDevcontainerConfigPath = "/etc/sam/default-devcontainer.json"
 
+// DefaultControlSocketPath is the unix socket of the local debugging API
+// that `vm-agent samctl` talks to.
+const DefaultControlSocketPath = "/run/sam-agent.sock"
+
 const (
 	// DefaultACPRecoveryWatchdogTimeout bounds crash recovery after an ACP
 	// disconnect. Override via DEFAULT_RECOVERY_WATCHDOG_TIMEOUT.
@@ -103,6 +109,19 @@ const (
 	DefaultDeployTeardownTimeout = 2 * time.Minute
 )
 
+// Container user namespace modes. See Config.ContainerUsernsMode.
+const (
+	UsernsModeRemap    = "remap"
+	UsernsModeRootless = "rootless"
+)
+
+// Default retry policies; see Config.CloneRetry and its neighbours.
+var (
+	DefaultCloneRetry         = retry.Policy{InitialDelay: 5 * time.Second, MaxDelay: 30 * time.Second, MaxElapsed: 5 * time.Minute, MaxAttempts: 3, Jitter: 0.5}
+	DefaultDevcontainerRetry  = retry.Policy{InitialDelay: 10 * time.Second, MaxDelay: time.Minute, MaxElapsed: 15 * time.Minute, MaxAttempts: 3, Jitter: 0.5}
+	DefaultAgentKeyFetchRetry = retry.Policy{InitialDelay: time.Second, MaxDelay: 5 * time.Second, MaxElapsed: 20 * time.Second, MaxAttempts: 3, Jitter: 0.5}
+)
+
 // Node role constants.
 const (
 	RoleWorkspace  = "workspace"
@@ -143,12 +162,25 @@ type Config struct {
 	BootstrapStatePath string
 	BootstrapMaxWait   time.Duration
 	BootstrapTimeout   time.Duration // Overall bootstrap timeout including devcontainer build
+	HookTimeout        time.Duration // Timeout for each lifecycle hook script; 0 leaves hooks bounded only by the caller (env: HOOK_TIMEOUT, default: 5m)
+
+	// PreProvisioned boots a warm-pool node: after provisioning it pre-pulls
+	// the default devcontainer image and features, then waits for POST
+	// /assign to deliver the workspace ID and bootstrap token
+	// (env: PRE_PROVISIONED, default: false).
+	PreProvisioned bool
 
 	// StandaloneCloneFilter is the resolved git partial-clone filter for
 	// standalone (container) workspace clones. Empty means "full clone".
 	// See DefaultStandaloneCloneFilter and env STANDALONE_CLONE_FILTER.
 	StandaloneCloneFilter string
 
+	// Node-mode clone settings for large repositories. Shallow and sparse
+	// clones bypass the repository mirror cache.
+	CloneDepth          int      // Shallow clone depth; 0 clones full history (env: CLONE_DEPTH, default: 0)
+	SingleBranch        bool     // Clone only the workspace branch's history (env: CLONE_SINGLE_BRANCH, default: false)
+	SparseCheckoutPaths []string // Cone-mode sparse checkout directories, comma-separated; empty checks out the whole tree (env: SPARSE_CHECKOUT_PATHS, default: "")
+
 	// Session settings
 	SessionTTL             time.Duration
 	SessionCleanupInterval time.Duration
@@ -158,6 +190,9 @@ type Config struct {
 
 	// Node health reporter interval
 	HeartbeatInterval time.Duration
+	// HeartbeatTelemetry adds per-workspace container state and session
+	// counts to each heartbeat (env: HEARTBEAT_TELEMETRY_ENABLED, default: true)
+	HeartbeatTelemetry bool
 
 	// HTTP server timeouts
 	HTTPReadTimeout     time.Duration
@@ -196,10 +231,28 @@ type Config struct {
 	ACPMessageBufferSize              int           // Max buffered messages per SessionHost for late-join replay
 	ACPViewerSendBuffer               int           // Per-viewer send channel buffer size
 	ACPStderrBufferBytes              int           // Max agent stderr bytes retained for crash reports
+	ACPMaxQueuedPrompts               int           // Max prompts queued behind a running prompt; 0 = reject concurrent prompts (env: ACP_MAX_QUEUED_PROMPTS, default: 0)
+	ACPMaxCompanionAgents             int           // Max agents a session runs alongside its primary agent for targeted prompts; 0 = disabled (env: ACP_MAX_COMPANION_AGENTS, default: 2)
+	ACPMaxAgentsPerWorkspace          int           // Max agent processes starting or running at once in a workspace; 0 = unlimited (env: ACP_MAX_AGENTS_PER_WORKSPACE, default: 5)
+	ACPWorkspaceMemoryPercent         int           // Refuse new agents while the devcontainer uses at least this percent of its memory limit; 0 = disabled (env: ACP_WORKSPACE_MEMORY_PERCENT, default: 85)
+	ACPAgentQuotaQueueTimeout         time.Duration // Wait for workspace agent capacity before rejecting a selection; 0 = reject immediately (env: ACP_AGENT_QUOTA_QUEUE_TIMEOUT, default: 0)
+	ACPPermissionTimeout              time.Duration // Wait for a viewer to answer an agent permission request before rejecting it (env: ACP_PERMISSION_TIMEOUT, default: 5m)
 	ACPPingInterval                   time.Duration // WebSocket ping interval (default: 30s)
 	ACPPongTimeout                    time.Duration // WebSocket pong deadline after ping (default: 10s)
+	ACPPollWait                       time.Duration // Max time a long-poll viewer request is held open waiting for messages (env: ACP_POLL_WAIT, default: 25s)
+	ACPPollIdleTimeout                time.Duration // Detach long-poll viewers that have not polled for this long (env: ACP_POLL_IDLE_TIMEOUT, default: 60s)
+	ACPPollQueueSize                  int           // Max unacknowledged messages per long-poll viewer before it must re-attach (env: ACP_POLL_QUEUE_SIZE, default: 10000)
+	ACPViewerAckInterval              time.Duration // How often viewers are sent session_ack with their last received sequence number; 0 = disabled (env: ACP_VIEWER_ACK_INTERVAL, default: 5s)
 	ACPPromptTimeout                  time.Duration // Max prompt runtime; 0 = no timeout (default: 0). Used for workspace sessions; task sessions use ACPTaskPromptTimeout via effectivePromptTimeout().
 	ACPTaskPromptTimeout              time.Duration // Max prompt runtime for task-driven sessions; 0 = no timeout (default: 6h)
+	ACPPromptTimeoutAdaptive          bool          // Derive prompt timeouts from historical per-agent-type durations (env: ACP_PROMPT_TIMEOUT_ADAPTIVE, default: false)
+	ACPPromptTimeoutPercentile        float64       // Duration percentile the adaptive timeout is based on (env: ACP_PROMPT_TIMEOUT_PERCENTILE, default: 95)
+	ACPPromptTimeoutMultiplier        float64       // Headroom multiplier applied to the percentile (env: ACP_PROMPT_TIMEOUT_MULTIPLIER, default: 3)
+	ACPPromptTimeoutMinSamples        int           // Completed prompts required before the adaptive timeout applies (env: ACP_PROMPT_TIMEOUT_MIN_SAMPLES, default: 20)
+	ACPPromptTimeoutWindow            int           // Recent prompt durations retained per agent type (env: ACP_PROMPT_TIMEOUT_WINDOW, default: 200)
+	ACPPromptTimeoutMin               time.Duration // Lower bound for the adaptive timeout (env: ACP_PROMPT_TIMEOUT_MIN, default: 10m)
+	ACPPromptTimeoutMax               time.Duration // Upper bound for the adaptive timeout (env: ACP_PROMPT_TIMEOUT_MAX, default: 6h)
+	ACPPromptTimeoutOverrideMax       time.Duration // Hard cap for client-supplied per-prompt timeouts; 0 ignores overrides (env: ACP_PROMPT_TIMEOUT_OVERRIDE_MAX, default: 12h)
 	ACPPromptCancelGrace              time.Duration // Wait after cancel before force-stop fallback (default: 5s)
 	ACPPromptRetryMaxRetries          int           // Retryable transient provider prompt errors after initial attempt (default: 2)
 	ACPPromptRetryInitial             time.Duration // Initial backoff for transient provider prompt retries (default: 15s)
@@ -212,10 +265,37 @@ type Config struct {
 	ACPActivityRereportInterval       time.Duration // Re-report prompting while a prompt is active (default: 60s, env: ACTIVITY_REREPORT_INTERVAL)
 	ACPTerminalActivityReportAttempts int           // Retry attempts for terminal activity reports (default: 5, env: ACTIVITY_TERMINAL_REPORT_ATTEMPTS)
 	ACPTerminalActivityReportBackoff  time.Duration // Retry backoff for terminal activity reports (default: 1s, env: ACTIVITY_TERMINAL_REPORT_BACKOFF)
+	ACPWarmStandbyAgent               string        // Agent type pre-started in a viewerless SessionHost once a workspace is ready; empty disables (default: "", env: ACP_WARM_STANDBY_AGENT)
+	ACPStdioReattach                  bool          // Run agents behind a stdio supervisor that re-attaches when the docker exec pipe breaks (env: ACP_STDIO_REATTACH, default: false)
+	ACPStdioReattachTimeout           time.Duration // Max time to re-attach before falling back to a full agent restart (env: ACP_STDIO_REATTACH_TIMEOUT, default: 30s)
+	ACPFileMaxSize                    int           // Max bytes one agent fs/read_text_file returns or fs/write_text_file accepts; larger files go in parts (env: ACP_FILE_MAX_SIZE, default: 4MB, capped at 8MB)
 
 	// Event log settings - configurable per constitution principle XI
-	MaxNodeEvents      int // Max node-level events retained in memory (default: 500)
-	MaxWorkspaceEvents int // Max workspace-level events retained in memory (default: 500)
+	MaxNodeEvents        int  // Max node-level events retained in memory (default: 500)
+	MaxWorkspaceEvents   int  // Max workspace-level events retained in memory (default: 500)
+	EventUploadEnabled   bool // Upload locally stored events to the control plane after successful heartbeats (env: EVENT_UPLOAD_ENABLED, default: true)
+	EventUploadBatchSize int  // Max events per upload request (env: EVENT_UPLOAD_BATCH_SIZE, default: 200)
+
+	// Boot log sink settings - configurable per constitution principle XI
+	BootLogSinks         []string // Extra destinations for boot log entries besides the control plane: file, journald, webhook (env: BOOTLOG_SINKS, comma-separated, default: none)
+	BootLogFilePath      string   // JSONL file appended to by the file sink (env: BOOTLOG_FILE_PATH, default: /var/log/sam/bootlog.jsonl)
+	BootLogWebhookURL    string   // URL each entry is POSTed to by the webhook sink (env: BOOTLOG_WEBHOOK_URL)
+	BootLogWebhookSecret string   // HMAC-SHA256 signing secret for webhook sink payloads; empty sends unsigned payloads (env: BOOTLOG_WEBHOOK_SECRET, default: "")
+	BootLogHistoryPath   string   // Rolling average boot step durations behind the progress percentage and ETA of each entry; empty disables progress (env: BOOTLOG_HISTORY_PATH, default: /var/lib/vm-agent/boot-step-durations.json)
+
+	// Tracing settings - configurable per constitution principle XI
+	TracingOTLPEndpoint   string        // OTLP/HTTP collector base URL; spans are POSTed to <endpoint>/v1/traces; empty disables tracing (env: OTEL_EXPORTER_OTLP_ENDPOINT, default: "")
+	TracingOTLPHeaders    string        // Extra export headers as comma-separated key=value pairs, e.g. collector auth (env: OTEL_EXPORTER_OTLP_HEADERS, default: "")
+	TracingServiceName    string        // service.name resource attribute on exported spans (env: OTEL_SERVICE_NAME, default: vm-agent)
+	TracingExportInterval time.Duration // Max delay before ended spans are exported (env: TRACING_EXPORT_INTERVAL, default: 5s)
+
+	// Outbound proxy and trust settings - configurable per constitution principle XI.
+	// Applied to the agent's HTTP clients, the git/devcontainer commands it
+	// runs, and workspace devcontainers; see ApplyOutbound.
+	HTTPProxy    string // Proxy for http:// requests (env: HTTP_PROXY or http_proxy, default: "")
+	HTTPSProxy   string // Proxy for https:// requests (env: HTTPS_PROXY or https_proxy, default: "")
+	NoProxy      string // Comma-separated hosts and CIDRs that bypass the proxy (env: NO_PROXY or no_proxy, default: "")
+	CABundlePath string // PEM file of extra CA certificates trusted alongside the system roots (env: CA_BUNDLE_PATH, default: "")
 
 	// Container settings - exec into devcontainer instead of host shell
 	ContainerMode       bool
@@ -224,6 +304,51 @@ type Config struct {
 	ContainerLabelKey   string
 	ContainerLabelValue string
 	ContainerCacheTTL   time.Duration
+	// ContainerUsernsMode declares that the Docker daemon runs containers in a
+	// user namespace: "remap" (daemon userns-remap) or "rootless" (rootless
+	// Docker). Workspace containers are then started unprivileged, and
+	// bind-mounted workspace ownership is set from the host using the
+	// container's UID/GID map (env: CONTAINER_USERNS_MODE, default: "").
+	ContainerUsernsMode string
+	// ContainerRuntime selects the container CLI: "docker", "podman", or
+	// "auto", which uses docker when installed and podman otherwise
+	// (env: CONTAINER_RUNTIME, default: auto).
+	ContainerRuntime string
+
+	// Default per-workspace devcontainer resource limits - configurable per
+	// constitution principle XI. A workspace's bootstrap payload may override
+	// them. Empty/zero leaves the resource unlimited.
+	WorkspaceCPULimit    string // Fractional CPUs, e.g. "2" (env: WORKSPACE_CPU_LIMIT, default: "")
+	WorkspaceMemoryLimit string // Docker memory size, e.g. "4g" (env: WORKSPACE_MEMORY_LIMIT, default: "")
+	WorkspacePidsLimit   int64  // Maximum processes (env: WORKSPACE_PIDS_LIMIT, default: 0)
+
+	// EgressPolicyRefreshInterval is how often workspace egress policies are
+	// re-applied, re-resolving domain entries and restoring rules lost when a
+	// devcontainer restarts. 0 disables refreshing
+	// (env: EGRESS_POLICY_REFRESH_INTERVAL, default: 5m).
+	EgressPolicyRefreshInterval time.Duration
+
+	// SecretFilesTmpfs mounts a tmpfs at /run/secrets/sam in devcontainers
+	// and writes secret project files there, read-only, with a symlink at
+	// their declared path, so secrets never reach the container's disk
+	// (env: SECRET_FILES_TMPFS, default: false).
+	SecretFilesTmpfs bool
+	// SecretFilesCheckInterval is how often the agent checks that secret
+	// files survived a devcontainer restart, which empties the tmpfs, and
+	// injects them again. 0 disables the check
+	// (env: SECRET_FILES_CHECK_INTERVAL, default: 1m).
+	SecretFilesCheckInterval time.Duration
+
+	// SettingsOverridePath is an env file of reloadable settings re-read on
+	// SIGHUP. Its values override the process environment; see
+	// ReloadableSettings (env: SETTINGS_OVERRIDE_PATH,
+	// default: /etc/vm-agent/settings.env).
+	SettingsOverridePath string
+
+	// ConfigFilePath is the JSON config file Load reads settings from, keyed
+	// by env var name. Environment variables override it. Read from the
+	// environment only (env: CONFIG_FILE_PATH, default: /etc/sam/agent.json).
+	ConfigFilePath string
 
 	// Devcontainer features to inject via --additional-features on devcontainer up.
 	// JSON string matching the "features" section of devcontainer.json.
@@ -247,6 +372,14 @@ type Config struct {
 	DevcontainerCacheUsername string // Optional registry username (env: DEVCONTAINER_CACHE_USERNAME)
 	DevcontainerCachePassword string // Optional registry password/token (env: DEVCONTAINER_CACHE_PASSWORD)
 	DevcontainerCacheRef      string // Optional full cache image ref (env: DEVCONTAINER_CACHE_REF)
+	DevcontainerPrebuildRef   string // Optional prebuilt devcontainer image ref; built and pushed on a miss (env: DEVCONTAINER_PREBUILD_REF)
+
+	// Private registry for devcontainer images and features. Logged in to the
+	// host's docker config before devcontainer up; workspaces can add more
+	// through registryCredentials.
+	DevcontainerRegistryServer   string // Registry host, e.g. ghcr.io or 123456789012.dkr.ecr.us-east-1.amazonaws.com (env: DEVCONTAINER_REGISTRY_SERVER)
+	DevcontainerRegistryUsername string // Registry username (env: DEVCONTAINER_REGISTRY_USERNAME, default: x-access-token)
+	DevcontainerRegistryPassword string // Registry password or token (env: DEVCONTAINER_REGISTRY_PASSWORD)
 
 	// Cloud provider — used for provider-specific optimizations (apt mirrors, etc.)
 	Provider string // Cloud provider name (env: PROVIDER, e.g. "hetzner", "scaleway", "gcp")
@@ -263,22 +396,32 @@ type Config struct {
 	EventStoreDBPath  string        // SQLite database path for persistent event logs
 	MetricsDBPath     string        // SQLite database path for resource metrics snapshots
 	MetricsInterval   time.Duration // Resource metrics collection interval (default: 1m)
+	MetricsToken      string        // Static bearer token accepted by GET /metrics for Prometheus scrapes (env: METRICS_TOKEN)
 
 	// Git integration settings - configurable per constitution principle XI
 	GitCredentialTimeout     time.Duration // Timeout for credential-helper callbacks (env: GIT_CREDENTIAL_TIMEOUT, default: 5s)
 	GitExecTimeout           time.Duration // Timeout for git commands via docker exec (default: 30s)
+	GitLockTimeout           time.Duration // How long a git write operation waits for the workspace git lock (env: GIT_LOCK_TIMEOUT, default: 1m)
 	GitFileMaxSize           int           // Max file size in bytes for /git/file (default: 1MB)
 	GitWorktreeTimeout       time.Duration // Timeout for git worktree commands (default: 30s)
 	WorktreeCacheTTL         time.Duration // Cache TTL for git worktree list output (default: 5s)
 	MaxWorktreesPerWorkspace int           // Max worktrees per workspace (default: 5)
+	FileChangePollInterval   time.Duration // How often git status is polled for workspace/files_changed while viewers are attached; 0 disables (env: FILE_CHANGE_POLL_INTERVAL, default: 5s)
+	GitCommitTrailers        bool          // Install hooks that add SAM-Session/SAM-Prompt trailers to agent commits (env: GIT_COMMIT_TRAILERS, default: true)
+	GitCoAuthoredBy          bool          // Add a "Co-authored-by: <agent> (via SAM)" trailer to agent commits (env: GIT_CO_AUTHORED_BY, default: false)
+	GitCoAuthorEmail         string        // Email for the Co-authored-by trailer; empty omits it (env: GIT_CO_AUTHOR_EMAIL)
+	DotfilesRepository       string        // https:// dotfiles repository installed in each devcontainer as the container user (env: DOTFILES_REPOSITORY)
 
 	// File browser settings - configurable per constitution principle XI
-	FileListTimeout    time.Duration // Timeout for file listing commands (default: 10s)
-	FileListMaxEntries int           // Max entries returned per directory listing (default: 1000)
-	FileFindTimeout    time.Duration // Timeout for recursive file find (default: 15s)
-	FileFindMaxEntries int           // Max entries returned by file find (default: 5000)
-	FileRawMaxSize     int           // Max file size in bytes for /files/raw binary endpoint (default: 50MB, env: FILE_RAW_MAX_SIZE)
-	FileRawTimeout     time.Duration // Timeout for raw file reads (default: 60s, env: FILE_RAW_TIMEOUT)
+	FileListTimeout      time.Duration // Timeout for file listing commands (default: 10s)
+	FileListMaxEntries   int           // Max entries returned per directory listing (default: 1000)
+	FileFindTimeout      time.Duration // Timeout for recursive file find (default: 15s)
+	FileFindMaxEntries   int           // Max entries returned by file find (default: 5000)
+	FileRawMaxSize       int           // Max file size in bytes for /files/raw binary endpoint (default: 50MB, env: FILE_RAW_MAX_SIZE)
+	FileRawTimeout       time.Duration // Timeout for raw file reads (default: 60s, env: FILE_RAW_TIMEOUT)
+	SearchTimeout        time.Duration // Timeout for a workspace code search (env: SEARCH_TIMEOUT, default: 15s)
+	SearchMaxResults     int           // Max matches returned by a workspace code search (env: SEARCH_MAX_RESULTS, default: 500)
+	SearchInstallTimeout time.Duration // Timeout for installing ripgrep in a devcontainer that lacks it (env: SEARCH_INSTALL_TIMEOUT, default: 2m)
 
 	// File transfer settings - configurable per constitution principle XI
 	FileUploadMaxBytes      int64         // Max single file size in bytes (default: 50MB)
@@ -296,6 +439,82 @@ type Config struct {
 	ErrorReportMaxQueueSize  int           // Max queued entries before dropping (default: 100)
 	ErrorReportHTTPTimeout   time.Duration // HTTP POST timeout (default: 10s)
 
+	// Lifecycle webhook settings - configurable per constitution principle XI
+	LifecycleWebhookURL           string        // External orchestration endpoint for lifecycle events; empty disables (env: LIFECYCLE_WEBHOOK_URL, default: "")
+	LifecycleWebhookSecret        string        // HMAC-SHA256 signing secret for webhook payloads (env: LIFECYCLE_WEBHOOK_SECRET, default: "")
+	LifecycleWebhookFlushInterval time.Duration // Outbox delivery poll interval (env: LIFECYCLE_WEBHOOK_FLUSH_INTERVAL, default: 5s)
+	LifecycleWebhookHTTPTimeout   time.Duration // Per-delivery HTTP timeout (env: LIFECYCLE_WEBHOOK_HTTP_TIMEOUT, default: 10s)
+	LifecycleWebhookRetryMax      time.Duration // Max backoff between delivery attempts (env: LIFECYCLE_WEBHOOK_RETRY_MAX, default: 5m)
+	LifecycleWebhookOutboxMaxSize int           // Max undelivered events retained on disk (env: LIFECYCLE_WEBHOOK_OUTBOX_MAX_SIZE, default: 1000)
+
+	// Workspace announcement settings - configurable per constitution principle XI
+	AnnouncementMaxActive int // Max active announcements retained per workspace (env: ANNOUNCEMENT_MAX_ACTIVE, default: 20)
+	AnnouncementMaxBytes  int // Max announcement message size in bytes (env: ANNOUNCEMENT_MAX_BYTES, default: 4096)
+
+	// Workspace notes settings - configurable per constitution principle XI
+	NotesDBPath          string // SQLite database path for workspace notes (env: NOTES_DB_PATH, default: /var/lib/vm-agent/notes.db)
+	NotesMaxPerWorkspace int    // Max notes retained per workspace (env: NOTES_MAX_PER_WORKSPACE, default: 200)
+	NotesMaxContentBytes int    // Max note content size in bytes (env: NOTES_MAX_CONTENT_BYTES, default: 16384)
+
+	// Background job settings - configurable per constitution principle XI
+	JobsMaxPerWorkspace int           // Max jobs, running or finished, tracked per workspace (env: JOBS_MAX_PER_WORKSPACE, default: 20)
+	JobsMaxOutputBytes  int           // Output kept per job, newest bytes first (env: JOBS_MAX_OUTPUT_BYTES, default: 1048576)
+	JobsKillTimeout     time.Duration // Max wait for a killed job to exit (env: JOBS_KILL_TIMEOUT, default: 10s)
+
+	// Workspace schedule settings - configurable per constitution principle XI
+	ScheduleMaxEntries  int           // Max schedule entries per workspace, repository and control plane combined (env: SCHEDULE_MAX_ENTRIES, default: 20)
+	ScheduleHistorySize int           // Scheduled runs kept per workspace (env: SCHEDULE_HISTORY_SIZE, default: 100)
+	ScheduleRunTimeout  time.Duration // Max duration of a scheduled command before it is killed; 0 disables (env: SCHEDULE_RUN_TIMEOUT, default: 2h)
+
+	// Headless prompt settings - configurable per constitution principle XI
+	HeadlessPromptWebhookTimeout time.Duration // Per-attempt timeout posting an async headless prompt result (env: HEADLESS_PROMPT_WEBHOOK_TIMEOUT, default: 10s)
+
+	// Viewer access audit settings - configurable per constitution principle XI
+	AccessAuditDBPath        string        // SQLite database path for viewer attach/detach records (env: ACCESS_AUDIT_DB_PATH, default: /var/lib/vm-agent/access-audit.db)
+	AccessAuditRetention     time.Duration // Local retention for access records, trimmed on startup (env: ACCESS_AUDIT_RETENTION, default: 2160h)
+	AccessAuditShipInterval  time.Duration // Interval for shipping closed access records to the control plane; 0 disables (env: ACCESS_AUDIT_SHIP_INTERVAL, default: 1m)
+	AccessAuditShipBatchSize int           // Max access records shipped per request (env: ACCESS_AUDIT_SHIP_BATCH_SIZE, default: 100)
+
+	// Privileged exec audit settings - configurable per constitution principle XI
+	ExecAuditDBPath        string        // SQLite database path for docker exec -u root records (env: EXEC_AUDIT_DB_PATH, default: /var/lib/vm-agent/exec-audit.db)
+	ExecAuditRetention     time.Duration // Local retention for exec records, trimmed on startup (env: EXEC_AUDIT_RETENTION, default: 2160h)
+	ExecAuditShipInterval  time.Duration // Interval for shipping exec records to the control plane; 0 disables (env: EXEC_AUDIT_SHIP_INTERVAL, default: 1m)
+	ExecAuditShipBatchSize int           // Max exec records shipped per request (env: EXEC_AUDIT_SHIP_BATCH_SIZE, default: 100)
+
+	// Data retention settings - configurable per constitution principle XI
+	RetentionDBPath              string        // SQLite database path for retention policies and deletion receipts (env: RETENTION_DB_PATH, default: /var/lib/vm-agent/retention.db)
+	RetentionPurgeInterval       time.Duration // Interval for applying workspace retention policies; 0 disables scheduled purges (env: RETENTION_PURGE_INTERVAL, default: 1h)
+	RetentionReceiptShipInterval time.Duration // Interval for shipping deletion receipts to the control plane; 0 disables (env: RETENTION_RECEIPT_SHIP_INTERVAL, default: 1m)
+	RetentionReceiptRetention    time.Duration // Local retention for shipped deletion receipts, trimmed on startup (env: RETENTION_RECEIPT_RETENTION, default: 8760h)
+
+	// Repository mirror cache settings - configurable per constitution principle XI
+	RepoMirrorCacheEnabled        bool          // Clone node-mode workspaces through per-node bare mirrors (env: REPO_MIRROR_CACHE_ENABLED, default: true)
+	RepoMirrorCacheDir            string        // Directory holding one bare mirror per repository (env: REPO_MIRROR_CACHE_DIR, default: /var/lib/vm-agent/repo-mirrors)
+	RepoMirrorCacheMaxBytes       int64         // Total mirror size above which least recently used mirrors are evicted; 0 disables eviction (env: REPO_MIRROR_CACHE_MAX_BYTES, default: 10GiB)
+	RepoMirrorFetchInterval       time.Duration // Age after which a mirror is refreshed from its origin; 0 disables refreshes (env: REPO_MIRROR_FETCH_INTERVAL, default: 15m)
+	RepoMirrorMaintenanceInterval time.Duration // Interval for background mirror refresh and eviction; 0 disables (env: REPO_MIRROR_MAINTENANCE_INTERVAL, default: 10m)
+
+	// Offline mode settings - configurable per constitution principle XI
+	OfflineCredentialCacheEnabled    bool          // Cache last-known agent credentials and settings encrypted in the persistence store for use while the control plane is unreachable (env: OFFLINE_CREDENTIAL_CACHE_ENABLED, default: true)
+	OfflineCredentialCacheMaxAge     time.Duration // Age after which cached credentials and settings are no longer used; 0 means no limit (env: OFFLINE_CREDENTIAL_CACHE_MAX_AGE, default: 24h)
+	ControlPlaneOfflineAfterFailures int           // Consecutive failed heartbeats before the node is marked offline (env: CONTROL_PLANE_OFFLINE_AFTER_FAILURES, default: 2)
+	CallbackOutboxMaxEntries         int           // Undelivered non-critical callbacks kept in the persistence store for redelivery, oldest evicted first; 0 disables the outbox (env: CALLBACK_OUTBOX_MAX_ENTRIES, default: 1000)
+	CallbackOutboxMaxAttempts        int           // Redelivery attempts before a queued callback is abandoned; 0 means no limit (env: CALLBACK_OUTBOX_MAX_ATTEMPTS, default: 20)
+
+	// SSH server settings - configurable per constitution principle XI
+	SSHServerEnabled   bool          // Serve ssh/scp/Remote-SSH access to workspaces; also requires SSHUserCAKeys (env: SSH_SERVER_ENABLED, default: false)
+	SSHListenAddr      string        // Listen address for the SSH server (env: SSH_LISTEN_ADDR, default: :2222)
+	SSHHostKeyPath     string        // Host key, generated as ed25519 on first start (env: SSH_HOST_KEY_PATH, default: /var/lib/vm-agent/ssh_host_ed25519_key)
+	SSHUserCAKeys      string        // Control-plane SSH CA public keys trusted to sign user certificates, authorized_keys format, one per line (env: SSH_USER_CA_KEYS, default: "")
+	SSHMaxCertLifetime time.Duration // Certificates valid for longer than this are rejected; 0 disables the check (env: SSH_MAX_CERT_LIFETIME, default: 12h)
+
+	// Local control socket used by `vm-agent samctl`
+	ControlSocketEnabled bool   // Serve the local debugging API on a unix socket (env: CONTROL_SOCKET_ENABLED, default: true)
+	ControlSocketPath    string // Root-only unix socket of the local debugging API (env: CONTROL_SOCKET_PATH, default: /run/sam-agent.sock)
+
+	// Workspace snapshot settings - configurable per constitution principle XI
+	WorkspaceSnapshotTimeout time.Duration // Max time to archive and upload a workspace volume snapshot (env: WORKSPACE_SNAPSHOT_TIMEOUT, default: 30m)
+
 	// System info collection settings - configurable per constitution principle XI
 	SysInfoDockerTimeout  time.Duration // Timeout for Docker CLI commands in system info (default: 10s)
 	SysInfoVersionTimeout time.Duration // Timeout for version check commands (default: 5s)
@@ -323,6 +542,41 @@ type Config struct {
 	DiagMemExhaustedThreshold  float64 // Memory % above which build is "memory exhausted" (env: DIAG_MEM_EXHAUSTED_THRESHOLD, default: 90)
 	DiagDiskFullThreshold      float64 // Disk % above which build is "disk full" (env: DIAG_DISK_FULL_THRESHOLD, default: 90)
 
+	// Idle detection - per-source activity weights; 0 ignores a source (see internal/activity)
+	ActivityWeightTerminal float64 // PTY input/output (env: ACTIVITY_WEIGHT_TERMINAL, default: 1)
+	ActivityWeightPrompt   float64 // ACP prompts in flight (env: ACTIVITY_WEIGHT_PROMPT, default: 1)
+	ActivityWeightViewer   float64 // Viewers attached to agent sessions (env: ACTIVITY_WEIGHT_VIEWER, default: 1)
+	ActivityWeightFiles    float64 // Successful file API requests (env: ACTIVITY_WEIGHT_FILES, default: 1)
+	ActivityWeightCommand  float64 // Running agent terminal commands (env: ACTIVITY_WEIGHT_COMMAND, default: 1)
+
+	// Shutdown drain - warn viewers and save in-flight work before the node stops
+	ShutdownDrainCountdown  time.Duration // Countdown announced to viewers before workloads stop (env: SHUTDOWN_DRAIN_COUNTDOWN, default: 30s)
+	WorkPreservationEnabled bool          // Push uncommitted work to a sam/autosave-<timestamp> branch on suspend and shutdown (env: WORK_PRESERVATION_ENABLED, default: false)
+	CheckpointMaxPerSession int           // Working-tree checkpoints kept per agent session under refs/sam/checkpoints; 0 disables prompt checkpoints (env: CHECKPOINT_MAX_PER_SESSION, default: 20)
+
+	// Recovery retry - re-attempt the repo's devcontainer build for workspaces running on the fallback image
+	RecoveryRetryMaxAttempts  int           // Build attempts before giving up; 0 disables (env: RECOVERY_RETRY_MAX_ATTEMPTS, default: 5)
+	RecoveryRetryInitialDelay time.Duration // Delay before the first attempt, doubled after each failure (env: RECOVERY_RETRY_INITIAL_DELAY, default: 1m)
+	RecoveryRetryMaxDelay     time.Duration // Max delay between attempts (env: RECOVERY_RETRY_MAX_DELAY, default: 30m)
+
+	// Disk monitor - watch filesystem usage and reclaim space from dangling images (see internal/diskmon)
+	DiskMonitorInterval time.Duration // Check interval; 0 disables (env: DISK_MONITOR_INTERVAL, default: 1m)
+	DiskMonitorPaths    []string      // Filesystems to watch, deduplicated by device (env: DISK_MONITOR_PATHS, default: "/,/var/lib/docker")
+	DiskWarnPercent     float64       // Usage % that raises a warning event (env: DISK_WARN_PERCENT, default: 80)
+	DiskCriticalPercent float64       // Usage % that raises a critical event (env: DISK_CRITICAL_PERCENT, default: 90)
+	DiskPrunePercent    float64       // Usage % above which dangling images are pruned; 0 disables (env: DISK_PRUNE_PERCENT, default: 85)
+	DiskPruneCooldown   time.Duration // Min time between prunes (env: DISK_PRUNE_COOLDOWN, default: 15m)
+
+	// Self-update - replace the agent binary with newer signed releases and re-exec in place (see internal/selfupdate)
+	SelfUpdateInterval time.Duration // Release check interval; 0 disables self-update (env: SELF_UPDATE_INTERVAL, default: 0)
+	SelfUpdatePubKey   string        // Ed25519 public key releases are signed with, base64-encoded (env: SELF_UPDATE_PUB_KEY)
+
+	// Retry policies - per-operation backoff for transient failures, as "attempts=3,initial=5s,max=30s,budget=5m,jitter=0.5" specs (see retry.ParsePolicy)
+	CloneRetry         retry.Policy // git clone of the workspace repository (env: RETRY_POLICY_CLONE, default: attempts=3,initial=5s,max=30s,budget=5m)
+	DevcontainerRetry  retry.Policy // devcontainer up after transient registry/network errors (env: RETRY_POLICY_DEVCONTAINER_UP, default: attempts=3,initial=10s,max=1m,budget=15m)
+	ReadyCallbackRetry retry.Policy // workspace-ready callback to the control plane (env: RETRY_POLICY_READY_CALLBACK, default: attempts=5,initial=1s,max=30s,budget=2m)
+	AgentKeyFetchRetry retry.Policy // agent credential fetch before falling back to the offline cache (env: RETRY_POLICY_AGENT_KEY, default: attempts=3,initial=1s,max=5s,budget=20s)
+
 	// Deployment mode settings (only used when Role == "deployment")
 	EnvironmentID         string        // Deployment environment ID (env: ENVIRONMENT_ID)
 	DeployBaseDir         string        // Base directory for deployment state (env: DEPLOY_BASE_DIR, default: /var/lib/sam-deploy)
@@ -341,12 +595,16 @@ type Config struct {
 	DeployArtifactIdleTimeout           time.Duration // Max no-progress body read interval for artifact downloads (env: DEPLOY_ARTIFACT_IDLE_TIMEOUT)
 	DeployApplyIdleTimeout              time.Duration // Max no-progress interval for detached apply goroutines (env: DEPLOY_APPLY_IDLE_TIMEOUT)
 	DeployBuildPublishTimeout           time.Duration // Max host build/push/release publish duration (env: DEPLOY_BUILD_PUBLISH_TIMEOUT)
+
+	// Failure injection for integration testing only — never set in production.
+	FaultInjection string // Fault spec, e.g. "bootstrap.redeem=2,ws.write" (env: SAM_FAULT_INJECTION, default: "")
 }
 
 func loadCallbackToken() (string, error) {
-	tokenFile := strings.TrimSpace(os.Getenv("CALLBACK_TOKEN_FILE"))
+	tokenFile := strings.TrimSpace(getEnv("CALLBACK_TOKEN_FILE", ""))
+	envToken := getEnv("CALLBACK_TOKEN", "")
 	if tokenFile == "" {
-		return getEnv("CALLBACK_TOKEN", ""), nil
+		return envToken, nil
 	}
 
 	data, err := os.ReadFile(tokenFile)
@@ -362,8 +620,17 @@ func loadCallbackToken() (string, error) {
 	return token, nil
 }
 
-// Load reads configuration from environment variables.
+// Load reads configuration from environment variables, falling back to the
+// JSON config file at CONFIG_FILE_PATH.
 func Load() (*Config, error) {
+	configFilePath := os.Getenv("CONFIG_FILE_PATH")
+	if configFilePath == "" {
+		configFilePath = DefaultConfigFilePath
+	}
+	if err := loadConfigFile(configFilePath); err != nil {
+		return nil, err
+	}
+
 	controlPlaneURL := getEnv("CONTROL_PLANE_URL", "")
 	repository := getEnv("REPOSITORY", "")
 	callbackToken, err := loadCallbackToken()
@@ -372,8 +639,8 @@ func Load() (*Config, error) {
 	}
 
 	workspaceDir := getEnv("WORKSPACE_DIR", "")
+	workspaceBaseDir := getEnv("WORKSPACE_BASE_DIR", "/workspace")
 	if workspaceDir == "" {
-		workspaceBaseDir := getEnv("WORKSPACE_BASE_DIR", "/workspace")
 		workspaceDir = deriveWorkspaceDir(workspaceBaseDir, repository)
 	}
 
@@ -410,6 +677,7 @@ func Load() (*Config, error) {
 		WorkspaceID:        getEnv("WORKSPACE_ID", ""),
 		CallbackToken:      callbackToken,
 		BootstrapToken:     getEnv("BOOTSTRAP_TOKEN", ""),
+		PreProvisioned:     getEnvBool("PRE_PROVISIONED", false),
 		Repository:         repository,
 		Branch:             getEnv("BRANCH", "main"),
 		WorkspaceDir:       workspaceDir,
@@ -418,16 +686,22 @@ func Load() (*Config, error) {
 		// Must be <= API-side TASK_RUNNER_WORKSPACE_READY_TIMEOUT_MS (default 30m).
 		// If larger, the API declares the workspace dead while bootstrap is still running.
 		BootstrapTimeout: getEnvDuration("BOOTSTRAP_TIMEOUT", 30*time.Minute),
+		HookTimeout:      getEnvDuration("HOOK_TIMEOUT", 5*time.Minute),
 
 		StandaloneCloneFilter: ResolveStandaloneCloneFilter(getEnv("STANDALONE_CLONE_FILTER", DefaultStandaloneCloneFilter)),
 
+		CloneDepth:          getEnvInt("CLONE_DEPTH", 0),
+		SingleBranch:        getEnvBool("CLONE_SINGLE_BRANCH", false),
+		SparseCheckoutPaths: getEnvStringSlice("SPARSE_CHECKOUT_PATHS", nil),
+
 		SessionTTL:             getEnvDuration("SESSION_TTL", 24*time.Hour),
 		SessionCleanupInterval: getEnvDuration("SESSION_CLEANUP_INTERVAL", 1*time.Minute),
 		SessionMaxCount:        getEnvInt("SESSION_MAX_COUNT", 100),
 		CookieName:             getEnv("COOKIE_NAME", "vm_session"),
 		CookieSecure:           getEnvBool("COOKIE_SECURE", true),
 
-		HeartbeatInterval: getEnvDuration("HEARTBEAT_INTERVAL", 60*time.Second),
+		HeartbeatInterval:  getEnvDuration("HEARTBEAT_INTERVAL", 60*time.Second),
+		HeartbeatTelemetry: getEnvBool("HEARTBEAT_TELEMETRY_ENABLED", true),
 
 		// HTTP server timeouts - configurable per constitution
 		HTTPReadTimeout:     getEnvDuration("HTTP_READ_TIMEOUT", 15*time.Second),
@@ -466,10 +740,28 @@ func Load() (*Config, error) {
 		ACPMessageBufferSize:              getEnvInt("ACP_MESSAGE_BUFFER_SIZE", 5000),
 		ACPViewerSendBuffer:               getEnvInt("ACP_VIEWER_SEND_BUFFER", 256),
 		ACPStderrBufferBytes:              getEnvInt("ACP_STDERR_BUFFER_BYTES", 4096),
+		ACPMaxQueuedPrompts:               getEnvInt("ACP_MAX_QUEUED_PROMPTS", 0),
+		ACPMaxCompanionAgents:             getEnvInt("ACP_MAX_COMPANION_AGENTS", 2),
+		ACPMaxAgentsPerWorkspace:          getEnvInt("ACP_MAX_AGENTS_PER_WORKSPACE", 5),
+		ACPWorkspaceMemoryPercent:         getEnvInt("ACP_WORKSPACE_MEMORY_PERCENT", 85),
+		ACPAgentQuotaQueueTimeout:         getEnvDuration("ACP_AGENT_QUOTA_QUEUE_TIMEOUT", 0),
+		ACPPermissionTimeout:              getEnvDuration("ACP_PERMISSION_TIMEOUT", 5*time.Minute),
 		ACPPingInterval:                   getEnvDuration("ACP_PING_INTERVAL", 30*time.Second),
 		ACPPongTimeout:                    getEnvDuration("ACP_PONG_TIMEOUT", 10*time.Second),
+		ACPPollWait:                       getEnvDuration("ACP_POLL_WAIT", 25*time.Second),
+		ACPPollIdleTimeout:                getEnvDuration("ACP_POLL_IDLE_TIMEOUT", 60*time.Second),
+		ACPPollQueueSize:                  getEnvInt("ACP_POLL_QUEUE_SIZE", 10000),
+		ACPViewerAckInterval:              getEnvDuration("ACP_VIEWER_ACK_INTERVAL", 5*time.Second),
 		ACPPromptTimeout:                  getEnvDuration("ACP_PROMPT_TIMEOUT", 0),
 		ACPTaskPromptTimeout:              getEnvDuration("ACP_TASK_PROMPT_TIMEOUT", 6*time.Hour),
+		ACPPromptTimeoutAdaptive:          getEnvBool("ACP_PROMPT_TIMEOUT_ADAPTIVE", false),
+		ACPPromptTimeoutPercentile:        getEnvFloat("ACP_PROMPT_TIMEOUT_PERCENTILE", 95),
+		ACPPromptTimeoutMultiplier:        getEnvFloat("ACP_PROMPT_TIMEOUT_MULTIPLIER", 3),
+		ACPPromptTimeoutMinSamples:        getEnvInt("ACP_PROMPT_TIMEOUT_MIN_SAMPLES", 20),
+		ACPPromptTimeoutWindow:            getEnvInt("ACP_PROMPT_TIMEOUT_WINDOW", 200),
+		ACPPromptTimeoutMin:               getEnvDuration("ACP_PROMPT_TIMEOUT_MIN", 10*time.Minute),
+		ACPPromptTimeoutMax:               getEnvDuration("ACP_PROMPT_TIMEOUT_MAX", 6*time.Hour),
+		ACPPromptTimeoutOverrideMax:       getEnvDuration("ACP_PROMPT_TIMEOUT_OVERRIDE_MAX", 12*time.Hour),
 		ACPPromptCancelGrace:              getEnvDuration("ACP_PROMPT_CANCEL_GRACE_PERIOD", 5*time.Second),
 		ACPPromptRetryMaxRetries:          getEnvInt("ACP_PROMPT_RETRY_MAX_RETRIES", 2),
 		ACPPromptRetryInitial:             getEnvDuration("ACP_PROMPT_RETRY_INITIAL_BACKOFF", 15*time.Second),
@@ -482,10 +774,35 @@ func Load() (*Config, error) {
 		ACPActivityRereportInterval:       getEnvDuration("ACTIVITY_REREPORT_INTERVAL", DefaultACPActivityRereportInterval),
 		ACPTerminalActivityReportAttempts: getEnvInt("ACTIVITY_TERMINAL_REPORT_ATTEMPTS", DefaultACPTerminalActivityReportAttempts),
 		ACPTerminalActivityReportBackoff:  getEnvDuration("ACTIVITY_TERMINAL_REPORT_BACKOFF", DefaultACPTerminalActivityReportBackoff),
+		ACPWarmStandbyAgent:               strings.TrimSpace(getEnv("ACP_WARM_STANDBY_AGENT", "")),
+		ACPStdioReattach:                  getEnvBool("ACP_STDIO_REATTACH", false),
+		ACPStdioReattachTimeout:           getEnvDuration("ACP_STDIO_REATTACH_TIMEOUT", 30*time.Second),
+		ACPFileMaxSize:                    getEnvInt("ACP_FILE_MAX_SIZE", 4*1024*1024), // 4 MB
 
 		// Event log settings
-		MaxNodeEvents:      getEnvInt("MAX_NODE_EVENTS", 500),
-		MaxWorkspaceEvents: getEnvInt("MAX_WORKSPACE_EVENTS", 500),
+		MaxNodeEvents:        getEnvInt("MAX_NODE_EVENTS", 500),
+		MaxWorkspaceEvents:   getEnvInt("MAX_WORKSPACE_EVENTS", 500),
+		EventUploadEnabled:   getEnvBool("EVENT_UPLOAD_ENABLED", true),
+		EventUploadBatchSize: getEnvInt("EVENT_UPLOAD_BATCH_SIZE", 200),
+
+		// Boot log sink settings
+		BootLogSinks:         getEnvStringSlice("BOOTLOG_SINKS", nil),
+		BootLogFilePath:      getEnv("BOOTLOG_FILE_PATH", "/var/log/sam/bootlog.jsonl"),
+		BootLogWebhookURL:    strings.TrimSpace(getEnv("BOOTLOG_WEBHOOK_URL", "")),
+		BootLogWebhookSecret: getEnv("BOOTLOG_WEBHOOK_SECRET", ""),
+		BootLogHistoryPath:   getEnv("BOOTLOG_HISTORY_PATH", "/var/lib/vm-agent/boot-step-durations.json"),
+
+		// Tracing settings
+		TracingOTLPEndpoint:   strings.TrimSpace(getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", "")),
+		TracingOTLPHeaders:    getEnv("OTEL_EXPORTER_OTLP_HEADERS", ""),
+		TracingServiceName:    getEnv("OTEL_SERVICE_NAME", "vm-agent"),
+		TracingExportInterval: getEnvDuration("TRACING_EXPORT_INTERVAL", 5*time.Second),
+
+		// Outbound proxy and trust settings
+		HTTPProxy:    strings.TrimSpace(getEnv("HTTP_PROXY", os.Getenv("http_proxy"))),
+		HTTPSProxy:   strings.TrimSpace(getEnv("HTTPS_PROXY", os.Getenv("https_proxy"))),
+		NoProxy:      strings.TrimSpace(getEnv("NO_PROXY", os.Getenv("no_proxy"))),
+		CABundlePath: strings.TrimSpace(getEnv("CA_BUNDLE_PATH", "")),
 
 		ContainerMode: getEnvBool("CONTAINER_MODE", true),
 		// Optional manual override for docker exec user.
@@ -496,6 +813,20 @@ func Load() (*Config, error) {
 		ContainerLabelKey:   getEnv("CONTAINER_LABEL_KEY", "devcontainer.local_folder"),
 		ContainerLabelValue: containerLabelValue,
 		ContainerCacheTTL:   getEnvDuration("CONTAINER_CACHE_TTL", 30*time.Second),
+		ContainerUsernsMode: strings.TrimSpace(getEnv("CONTAINER_USERNS_MODE", "")),
+		ContainerRuntime:    strings.ToLower(strings.TrimSpace(getEnv("CONTAINER_RUNTIME", "auto"))),
+
+		WorkspaceCPULimit:    strings.TrimSpace(getEnv("WORKSPACE_CPU_LIMIT", "")),
+		WorkspaceMemoryLimit: strings.TrimSpace(getEnv("WORKSPACE_MEMORY_LIMIT", "")),
+		WorkspacePidsLimit:   getEnvInt64("WORKSPACE_PIDS_LIMIT", 0),
+
+		EgressPolicyRefreshInterval: getEnvDuration("EGRESS_POLICY_REFRESH_INTERVAL", 5*time.Minute),
+
+		SecretFilesTmpfs:         getEnvBool("SECRET_FILES_TMPFS", false),
+		SecretFilesCheckInterval: getEnvDuration("SECRET_FILES_CHECK_INTERVAL", time.Minute),
+
+		SettingsOverridePath: getEnv("SETTINGS_OVERRIDE_PATH", "/etc/vm-agent/settings.env"),
+		ConfigFilePath:       configFilePath,
 
 		// Default installs Node.js (required by ACP adapters) and claude-agent-acp.
 		// Override via ADDITIONAL_FEATURES env var. Set to empty string to disable.
@@ -515,6 +846,11 @@ func Load() (*Config, error) {
 		DevcontainerCacheUsername: getEnv("DEVCONTAINER_CACHE_USERNAME", ""),
 		DevcontainerCachePassword: getEnv("DEVCONTAINER_CACHE_PASSWORD", ""),
 		DevcontainerCacheRef:      getEnv("DEVCONTAINER_CACHE_REF", ""),
+		DevcontainerPrebuildRef:   getEnv("DEVCONTAINER_PREBUILD_REF", ""),
+
+		DevcontainerRegistryServer:   getEnv("DEVCONTAINER_REGISTRY_SERVER", ""),
+		DevcontainerRegistryUsername: getEnv("DEVCONTAINER_REGISTRY_USERNAME", ""),
+		DevcontainerRegistryPassword: getEnv("DEVCONTAINER_REGISTRY_PASSWORD", ""),
 
 		// Cloud provider (set via cloud-init)
 		Provider: getEnv("PROVIDER", ""),
@@ -530,22 +866,32 @@ func Load() (*Config, error) {
 		EventStoreDBPath:  getEnv("EVENTSTORE_DB_PATH", "/var/lib/vm-agent/events.db"),
 		MetricsDBPath:     getEnv("METRICS_DB_PATH", "/var/lib/vm-agent/metrics.db"),
 		MetricsInterval:   getEnvDuration("METRICS_INTERVAL", time.Minute),
+		MetricsToken:      getEnv("METRICS_TOKEN", ""),
 
 		// Git integration settings - configurable per constitution principle XI
 		GitCredentialTimeout:     getEnvDuration("GIT_CREDENTIAL_TIMEOUT", DefaultGitCredentialTimeout),
 		GitExecTimeout:           getEnvDuration("GIT_EXEC_TIMEOUT", 30*time.Second),
+		GitLockTimeout:           getEnvDuration("GIT_LOCK_TIMEOUT", time.Minute),
 		GitFileMaxSize:           getEnvInt("GIT_FILE_MAX_SIZE", 1048576), // 1 MB
 		GitWorktreeTimeout:       getEnvDuration("GIT_WORKTREE_TIMEOUT", 30*time.Second),
 		WorktreeCacheTTL:         getEnvDuration("WORKTREE_CACHE_TTL", 5*time.Second),
 		MaxWorktreesPerWorkspace: getEnvInt("MAX_WORKTREES_PER_WORKSPACE", 5),
+		FileChangePollInterval:   getEnvDuration("FILE_CHANGE_POLL_INTERVAL", 5*time.Second),
+		GitCommitTrailers:        getEnvBool("GIT_COMMIT_TRAILERS", true),
+		GitCoAuthoredBy:          getEnvBool("GIT_CO_AUTHORED_BY", false),
+		GitCoAuthorEmail:         getEnv("GIT_CO_AUTHOR_EMAIL", ""),
+		DotfilesRepository:       getEnv("DOTFILES_REPOSITORY", ""),
 
 		// File browser settings
-		FileListTimeout:    getEnvDuration("FILE_LIST_TIMEOUT", 10*time.Second),
-		FileListMaxEntries: getEnvInt("FILE_LIST_MAX_ENTRIES", 1000),
-		FileFindTimeout:    getEnvDuration("FILE_FIND_TIMEOUT", 15*time.Second),
-		FileFindMaxEntries: getEnvInt("FILE_FIND_MAX_ENTRIES", 5000),
-		FileRawMaxSize:     getEnvInt("FILE_RAW_MAX_SIZE", 50*1024*1024), // 50 MB
-		FileRawTimeout:     getEnvDuration("FILE_RAW_TIMEOUT", 60*time.Second),
+		FileListTimeout:      getEnvDuration("FILE_LIST_TIMEOUT", 10*time.Second),
+		FileListMaxEntries:   getEnvInt("FILE_LIST_MAX_ENTRIES", 1000),
+		FileFindTimeout:      getEnvDuration("FILE_FIND_TIMEOUT", 15*time.Second),
+		FileFindMaxEntries:   getEnvInt("FILE_FIND_MAX_ENTRIES", 5000),
+		FileRawMaxSize:       getEnvInt("FILE_RAW_MAX_SIZE", 50*1024*1024), // 50 MB
+		FileRawTimeout:       getEnvDuration("FILE_RAW_TIMEOUT", 60*time.Second),
+		SearchTimeout:        getEnvDuration("SEARCH_TIMEOUT", 15*time.Second),
+		SearchMaxResults:     getEnvInt("SEARCH_MAX_RESULTS", 500),
+		SearchInstallTimeout: getEnvDuration("SEARCH_INSTALL_TIMEOUT", 2*time.Minute),
 
 		// File transfer settings
 		FileUploadMaxBytes:      getEnvInt64("FILE_UPLOAD_MAX_BYTES", 50*1024*1024),        // 50 MB
@@ -563,6 +909,81 @@ func Load() (*Config, error) {
 		ErrorReportMaxQueueSize:  getEnvInt("ERROR_REPORT_MAX_QUEUE_SIZE", 100),
 		ErrorReportHTTPTimeout:   getEnvDuration("ERROR_REPORT_HTTP_TIMEOUT", 10*time.Second),
 
+		// Lifecycle webhook settings - configurable per constitution principle XI
+		LifecycleWebhookURL:           strings.TrimSpace(getEnv("LIFECYCLE_WEBHOOK_URL", "")),
+		LifecycleWebhookSecret:        getEnv("LIFECYCLE_WEBHOOK_SECRET", ""),
+		LifecycleWebhookFlushInterval: getEnvDuration("LIFECYCLE_WEBHOOK_FLUSH_INTERVAL", 5*time.Second),
+		LifecycleWebhookHTTPTimeout:   getEnvDuration("LIFECYCLE_WEBHOOK_HTTP_TIMEOUT", 10*time.Second),
+		LifecycleWebhookRetryMax:      getEnvDuration("LIFECYCLE_WEBHOOK_RETRY_MAX", 5*time.Minute),
+		LifecycleWebhookOutboxMaxSize: getEnvInt("LIFECYCLE_WEBHOOK_OUTBOX_MAX_SIZE", 1000),
+
+		// Workspace announcement settings - configurable per constitution principle XI
+		AnnouncementMaxActive: getEnvInt("ANNOUNCEMENT_MAX_ACTIVE", 20),
+		AnnouncementMaxBytes:  getEnvInt("ANNOUNCEMENT_MAX_BYTES", 4096),
+
+		// Workspace notes settings - configurable per constitution principle XI
+		NotesDBPath:          getEnv("NOTES_DB_PATH", "/var/lib/vm-agent/notes.db"),
+		NotesMaxPerWorkspace: getEnvInt("NOTES_MAX_PER_WORKSPACE", 200),
+		NotesMaxContentBytes: getEnvInt("NOTES_MAX_CONTENT_BYTES", 16384),
+
+		// Background job settings - configurable per constitution principle XI
+		JobsMaxPerWorkspace: getEnvInt("JOBS_MAX_PER_WORKSPACE", 20),
+		JobsMaxOutputBytes:  getEnvInt("JOBS_MAX_OUTPUT_BYTES", 1048576),
+		JobsKillTimeout:     getEnvDuration("JOBS_KILL_TIMEOUT", 10*time.Second),
+
+		// Workspace schedule settings - configurable per constitution principle XI
+		ScheduleMaxEntries:  getEnvInt("SCHEDULE_MAX_ENTRIES", 20),
+		ScheduleHistorySize: getEnvInt("SCHEDULE_HISTORY_SIZE", 100),
+		ScheduleRunTimeout:  getEnvDuration("SCHEDULE_RUN_TIMEOUT", 2*time.Hour),
+
+		// Headless prompt settings - configurable per constitution principle XI
+		HeadlessPromptWebhookTimeout: getEnvDuration("HEADLESS_PROMPT_WEBHOOK_TIMEOUT", 10*time.Second),
+
+		// Viewer access audit settings - configurable per constitution principle XI
+		AccessAuditDBPath:        getEnv("ACCESS_AUDIT_DB_PATH", "/var/lib/vm-agent/access-audit.db"),
+		AccessAuditRetention:     getEnvDuration("ACCESS_AUDIT_RETENTION", 90*24*time.Hour),
+		AccessAuditShipInterval:  getEnvDuration("ACCESS_AUDIT_SHIP_INTERVAL", time.Minute),
+		AccessAuditShipBatchSize: getEnvInt("ACCESS_AUDIT_SHIP_BATCH_SIZE", 100),
+
+		// Privileged exec audit settings - configurable per constitution principle XI
+		ExecAuditDBPath:        getEnv("EXEC_AUDIT_DB_PATH", "/var/lib/vm-agent/exec-audit.db"),
+		ExecAuditRetention:     getEnvDuration("EXEC_AUDIT_RETENTION", 90*24*time.Hour),
+		ExecAuditShipInterval:  getEnvDuration("EXEC_AUDIT_SHIP_INTERVAL", time.Minute),
+		ExecAuditShipBatchSize: getEnvInt("EXEC_AUDIT_SHIP_BATCH_SIZE", 100),
+
+		// Data retention settings - configurable per constitution principle XI
+		RetentionDBPath:              getEnv("RETENTION_DB_PATH", "/var/lib/vm-agent/retention.db"),
+		RetentionPurgeInterval:       getEnvDuration("RETENTION_PURGE_INTERVAL", time.Hour),
+		RetentionReceiptShipInterval: getEnvDuration("RETENTION_RECEIPT_SHIP_INTERVAL", time.Minute),
+		RetentionReceiptRetention:    getEnvDuration("RETENTION_RECEIPT_RETENTION", 365*24*time.Hour),
+
+		// Repository mirror cache settings - configurable per constitution principle XI
+		RepoMirrorCacheEnabled:        getEnvBool("REPO_MIRROR_CACHE_ENABLED", true),
+		RepoMirrorCacheDir:            getEnv("REPO_MIRROR_CACHE_DIR", "/var/lib/vm-agent/repo-mirrors"),
+		RepoMirrorCacheMaxBytes:       getEnvInt64("REPO_MIRROR_CACHE_MAX_BYTES", 10*1024*1024*1024), // 10 GiB
+		RepoMirrorFetchInterval:       getEnvDuration("REPO_MIRROR_FETCH_INTERVAL", 15*time.Minute),
+		RepoMirrorMaintenanceInterval: getEnvDuration("REPO_MIRROR_MAINTENANCE_INTERVAL", 10*time.Minute),
+
+		// Offline mode settings - configurable per constitution principle XI
+		OfflineCredentialCacheEnabled:    getEnvBool("OFFLINE_CREDENTIAL_CACHE_ENABLED", true),
+		OfflineCredentialCacheMaxAge:     getEnvDuration("OFFLINE_CREDENTIAL_CACHE_MAX_AGE", 24*time.Hour),
+		ControlPlaneOfflineAfterFailures: getEnvInt("CONTROL_PLANE_OFFLINE_AFTER_FAILURES", 2),
+		CallbackOutboxMaxEntries:         getEnvInt("CALLBACK_OUTBOX_MAX_ENTRIES", 1000),
+		CallbackOutboxMaxAttempts:        getEnvInt("CALLBACK_OUTBOX_MAX_ATTEMPTS", 20),
+
+		// SSH server settings - configurable per constitution principle XI
+		SSHServerEnabled:   getEnvBool("SSH_SERVER_ENABLED", false),
+		SSHListenAddr:      getEnv("SSH_LISTEN_ADDR", ":2222"),
+		SSHHostKeyPath:     getEnv("SSH_HOST_KEY_PATH", "/var/lib/vm-agent/ssh_host_ed25519_key"),
+		SSHUserCAKeys:      getEnv("SSH_USER_CA_KEYS", ""),
+		SSHMaxCertLifetime: getEnvDuration("SSH_MAX_CERT_LIFETIME", 12*time.Hour),
+
+		ControlSocketEnabled: getEnvBool("CONTROL_SOCKET_ENABLED", true),
+		ControlSocketPath:    getEnv("CONTROL_SOCKET_PATH", DefaultControlSocketPath),
+
+		// Workspace snapshot settings - configurable per constitution principle XI
+		WorkspaceSnapshotTimeout: getEnvDuration("WORKSPACE_SNAPSHOT_TIMEOUT", 30*time.Minute),
+
 		// System info settings - configurable per constitution principle XI
 		SysInfoDockerTimeout:  getEnvDuration("SYSINFO_DOCKER_TIMEOUT", 10*time.Second),
 		SysInfoVersionTimeout: getEnvDuration("SYSINFO_VERSION_TIMEOUT", 5*time.Second),
@@ -588,6 +1009,35 @@ func Load() (*Config, error) {
 		DiagMemExhaustedThreshold:  getEnvFloat("DIAG_MEM_EXHAUSTED_THRESHOLD", 90),
 		DiagDiskFullThreshold:      getEnvFloat("DIAG_DISK_FULL_THRESHOLD", 90),
 
+		ActivityWeightTerminal: getEnvFloat("ACTIVITY_WEIGHT_TERMINAL", 1),
+		ActivityWeightPrompt:   getEnvFloat("ACTIVITY_WEIGHT_PROMPT", 1),
+		ActivityWeightViewer:   getEnvFloat("ACTIVITY_WEIGHT_VIEWER", 1),
+		ActivityWeightFiles:    getEnvFloat("ACTIVITY_WEIGHT_FILES", 1),
+		ActivityWeightCommand:  getEnvFloat("ACTIVITY_WEIGHT_COMMAND", 1),
+
+		ShutdownDrainCountdown:  getEnvDuration("SHUTDOWN_DRAIN_COUNTDOWN", 30*time.Second),
+		WorkPreservationEnabled: getEnvBool("WORK_PRESERVATION_ENABLED", false),
+		CheckpointMaxPerSession: getEnvInt("CHECKPOINT_MAX_PER_SESSION", 20),
+
+		RecoveryRetryMaxAttempts:  getEnvInt("RECOVERY_RETRY_MAX_ATTEMPTS", 5),
+		RecoveryRetryInitialDelay: getEnvDuration("RECOVERY_RETRY_INITIAL_DELAY", time.Minute),
+		RecoveryRetryMaxDelay:     getEnvDuration("RECOVERY_RETRY_MAX_DELAY", 30*time.Minute),
+
+		DiskMonitorInterval: getEnvDuration("DISK_MONITOR_INTERVAL", time.Minute),
+		DiskMonitorPaths:    getEnvStringSlice("DISK_MONITOR_PATHS", []string{"/", "/var/lib/docker"}),
+		DiskWarnPercent:     getEnvFloat("DISK_WARN_PERCENT", 80),
+		DiskCriticalPercent: getEnvFloat("DISK_CRITICAL_PERCENT", 90),
+		DiskPrunePercent:    getEnvFloat("DISK_PRUNE_PERCENT", 85),
+		DiskPruneCooldown:   getEnvDuration("DISK_PRUNE_COOLDOWN", 15*time.Minute),
+
+		SelfUpdateInterval: getEnvDuration("SELF_UPDATE_INTERVAL", 0),
+		SelfUpdatePubKey:   getEnv("SELF_UPDATE_PUB_KEY", ""),
+
+		CloneRetry:         getEnvRetryPolicy("RETRY_POLICY_CLONE", DefaultCloneRetry),
+		DevcontainerRetry:  getEnvRetryPolicy("RETRY_POLICY_DEVCONTAINER_UP", DefaultDevcontainerRetry),
+		ReadyCallbackRetry: getEnvRetryPolicy("RETRY_POLICY_READY_CALLBACK", retry.DefaultPolicy()),
+		AgentKeyFetchRetry: getEnvRetryPolicy("RETRY_POLICY_AGENT_KEY", DefaultAgentKeyFetchRetry),
+
 		// Deployment mode settings
 		EnvironmentID:         getEnv("ENVIRONMENT_ID", ""),
 		DeployBaseDir:         getEnv("DEPLOY_BASE_DIR", "/var/lib/sam-deploy"),
@@ -605,6 +1055,13 @@ func Load() (*Config, error) {
 		DeployArtifactIdleTimeout:           getEnvDuration("DEPLOY_ARTIFACT_IDLE_TIMEOUT", DefaultDeployArtifactIdleTimeout),
 		DeployApplyIdleTimeout:              getEnvDuration("DEPLOY_APPLY_IDLE_TIMEOUT", DefaultDeployApplyIdleTimeout),
 		DeployBuildPublishTimeout:           getEnvDuration("DEPLOY_BUILD_PUBLISH_TIMEOUT", DefaultDeployBuildPublishTimeout),
+
+		FaultInjection: getEnv("SAM_FAULT_INJECTION", ""),
+	}
+
+	// Every setting has been read, so config file keys left unread are unknown.
+	if err := checkConfigFile(); err != nil {
+		return nil, err
 	}
 
 	// Derive TLS enabled state from cert/key paths
@@ -664,6 +1121,20 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("TASK_MODE must be %q or %q, got %q", TaskModeTask, TaskModeConversation, cfg.TaskMode)
 	}
 
+	switch cfg.ContainerUsernsMode {
+	case "", UsernsModeRemap, UsernsModeRootless:
+		// valid
+	default:
+		return nil, fmt.Errorf("CONTAINER_USERNS_MODE must be empty, %q, or %q, got %q", UsernsModeRemap, UsernsModeRootless, cfg.ContainerUsernsMode)
+	}
+
+	switch cfg.ContainerRuntime {
+	case "auto", "docker", "podman":
+		// valid
+	default:
+		return nil, fmt.Errorf("CONTAINER_RUNTIME must be auto, docker, or podman, got %q", cfg.ContainerRuntime)
+	}
+
 	if cfg.NodeID == "" {
 		return nil, fmt.Errorf("NODE_ID is required")
 	}
@@ -674,6 +1145,15 @@ func Load() (*Config, error) {
 		cfg.WorktreeCacheTTL = 5 * time.Second
 	}
 
+	// Settings reloaded on SIGHUP also apply at startup, so they survive a restart.
+	overrides, err := LoadSettingsFile(cfg.SettingsOverridePath)
+	if err != nil {
+		return nil, fmt.Errorf("SETTINGS_OVERRIDE_PATH: %w", err)
+	}
+	if _, err := cfg.ApplySettings(overrides); err != nil {
+		return nil, fmt.Errorf("SETTINGS_OVERRIDE_PATH %q: %w", cfg.SettingsOverridePath, err)
+	}
+
 	return cfg, nil
 }
 
@@ -682,6 +1162,27 @@ func (c *Config) IsDeploymentMode() bool {
 	return c.Role == RoleDeployment
 }
 
+// AssignWorkspace binds a pre-provisioned node to the workspace delivered by
+// POST /assign. Workspace paths not set explicitly in the environment are
+// re-derived from repository, as Load derives them.
+func (c *Config) AssignWorkspace(workspaceID, bootstrapToken, repository, branch string) {
+	c.WorkspaceID = workspaceID
+	c.BootstrapToken = bootstrapToken
+	c.Repository = repository
+	if branch != "" {
+		c.Branch = branch
+	}
+	if getEnv("WORKSPACE_DIR", "") == "" {
+		c.WorkspaceDir = deriveWorkspaceDir(getEnv("WORKSPACE_BASE_DIR", "/workspace"), repository)
+	}
+	if getEnv("CONTAINER_LABEL_VALUE", "") == "" {
+		c.ContainerLabelValue = c.WorkspaceDir
+	}
+	if getEnv("CONTAINER_WORK_DIR", "") == "" {
+		c.ContainerWorkDir = deriveContainerWorkDir(c.WorkspaceDir)
+	}
+}
+
 // IsStandaloneMode returns true if the agent is running directly inside a
 // single-workspace container without Docker/devcontainer indirection.
 func (c *Config) IsStandaloneMode() bool {
@@ -722,11 +1223,14 @@ func DeriveRepoDirName(repository string) string {
 		return ""
 	}
 
-	// Handle full URLs (https://github.com/org/repo.git).
+	// Handle full URLs (https://github.com/org/repo.git) and scp-like SSH
+	// remotes (git@github.com:org/repo.git).
 	if strings.Contains(repo, "://") {
 		if parsed, err := url.Parse(repo); err == nil {
 			repo = parsed.Path
 		}
+	} else if at, colon := strings.Index(repo, "@"), strings.Index(repo, ":"); at >= 0 && colon > at {
+		repo = repo[colon+1:]
 	}
 
 	repo = strings.Trim(repo, "/")
@@ -0,0 +1,229 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultConfigFilePath is where Load looks for the JSON config file when
+// CONFIG_FILE_PATH is unset.
+const DefaultConfigFilePath = "/etc/sam/agent.json"
+
+// configFile holds the settings of the JSON config file that Load read. Its
+// keys are env var names, so cloud-init can write the same settings it would
+// otherwise export. Environment variables take precedence over it.
+type configFile struct {
+	path    string
+	values  map[string]string
+	read    map[string]bool // keys Load looked up, known or not set
+	invalid []string        // "KEY: error" for file values that failed to parse
+}
+
+var (
+	configFileMu sync.Mutex
+	activeFile   *configFile
+)
+
+// lookupSetting returns the value of an environment variable, falling back to
+// the config file. fromFile reports whether the value came from the file.
+func lookupSetting(key string) (value string, fromFile bool) {
+	configFileMu.Lock()
+	defer configFileMu.Unlock()
+	if activeFile != nil {
+		activeFile.read[key] = true
+	}
+	if value := os.Getenv(key); value != "" {
+		return value, false
+	}
+	if activeFile == nil {
+		return "", false
+	}
+	value = activeFile.values[key]
+	return value, value != ""
+}
+
+// reportInvalidSetting records a value that failed to parse. Values from the
+// config file fail Load; values from the environment are logged and the
+// default is used, as before the config file existed.
+func reportInvalidSetting(key, value string, fromFile bool, defaultValue any, err error) {
+	if fromFile {
+		configFileMu.Lock()
+		defer configFileMu.Unlock()
+		if activeFile != nil {
+			activeFile.invalid = append(activeFile.invalid, fmt.Sprintf("%s: %v", key, err))
+			return
+		}
+	}
+	slog.Warn("config: could not parse env var", "key", key, "value", value, "default", defaultValue, "error", err)
+}
+
+// loadConfigFile reads the JSON config file at path and makes it the
+// fallback for env lookups. A missing file is not an error.
+func loadConfigFile(path string) error {
+	file := &configFile{path: path, values: map[string]string{}, read: map[string]bool{}}
+	defer func() {
+		configFileMu.Lock()
+		activeFile = file
+		configFileMu.Unlock()
+	}()
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	values, err := parseConfigFile(data)
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	file.values = values
+	slog.Info("config: loaded config file", "path", path, "settings", len(values))
+	return nil
+}
+
+// parseConfigFile flattens a JSON object into env-style values. Strings,
+// numbers, and booleans are used as written, arrays of them are joined with
+// commas, and objects (such as ADDITIONAL_FEATURES) are kept as JSON text.
+// null leaves a setting unset.
+func parseConfigFile(data []byte) (map[string]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var raw map[string]any
+	if err := decoder.Decode(&raw); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			line, col := offsetPosition(data, syntaxErr.Offset)
+			return nil, fmt.Errorf("line %d, column %d: %w", line, col, err)
+		}
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return nil, errors.New("must be a JSON object of settings keyed by env var name")
+		}
+		return nil, err
+	}
+
+	values := make(map[string]string, len(raw))
+	var invalid []string
+	for key, value := range raw {
+		flat, err := flattenConfigValue(value)
+		if err != nil {
+			invalid = append(invalid, fmt.Sprintf("%s: %v", key, err))
+			continue
+		}
+		if flat != "" {
+			values[key] = flat
+		}
+	}
+	if len(invalid) > 0 {
+		sort.Strings(invalid)
+		return nil, fmt.Errorf("invalid fields: %s", strings.Join(invalid, "; "))
+	}
+	return values, nil
+}
+
+func flattenConfigValue(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		if v {
+			return "true", nil
+		}
+		return "false", nil
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			switch item.(type) {
+			case string, json.Number, bool:
+				flat, _ := flattenConfigValue(item)
+				items = append(items, flat)
+			default:
+				return "", errors.New("arrays may only hold strings, numbers, and booleans")
+			}
+		}
+		return strings.Join(items, ","), nil
+	case map[string]any:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(encoded), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", v)
+	}
+}
+
+// checkConfigFile reports config file keys Load never looked up and values
+// that failed to parse. Call it once Load has read every setting.
+func checkConfigFile() error {
+	configFileMu.Lock()
+	defer configFileMu.Unlock()
+	if activeFile == nil {
+		return nil
+	}
+
+	known := make(map[string]string, len(activeFile.read))
+	for key := range activeFile.read {
+		known[normalizeSettingName(key)] = key
+	}
+	var unknown []string
+	for key := range activeFile.values {
+		if activeFile.read[key] {
+			continue
+		}
+		if suggestion, ok := known[normalizeSettingName(key)]; ok {
+			key = fmt.Sprintf("%s (did you mean %s?)", key, suggestion)
+		}
+		unknown = append(unknown, key)
+	}
+	sort.Strings(unknown)
+	invalid := append([]string(nil), activeFile.invalid...)
+	sort.Strings(invalid)
+
+	var problems []string
+	if len(unknown) > 0 {
+		problems = append(problems, "unknown fields: "+strings.Join(unknown, ", "))
+	}
+	if len(invalid) > 0 {
+		problems = append(problems, "invalid fields: "+strings.Join(invalid, "; "))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("config file %s: %s", activeFile.path, strings.Join(problems, "; "))
+}
+
+// normalizeSettingName folds the spellings a hand-written config file is
+// likely to use, such as control-plane-url, onto the env var name.
+func normalizeSettingName(key string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_", " ", "_").Replace(strings.TrimSpace(key)))
+}
+
+func offsetPosition(data []byte, offset int64) (line, col int) {
+	line, col = 1, 1
+	for i := int64(0); i < offset && i < int64(len(data)); i++ {
+		if data[i] == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return line, col
+}
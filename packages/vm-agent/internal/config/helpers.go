@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"math"
 	"net"
 	"net/http"
 	"net/url"
@@ -16,11 +17,13 @@ import (
 	"time"
 
 	"github.com/workspace/vm-agent/internal/auth"
+	"github.com/workspace/vm-agent/internal/retry"
 )
 
-// getEnv returns the value of an environment variable or a default.
+// getEnv returns the value of an environment variable or a default. Unset
+// variables fall back to the JSON config file read by Load.
 func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
+	if value, _ := lookupSetting(key); value != "" {
 		return value
 	}
 	return defaultValue
@@ -28,10 +31,10 @@ func getEnv(key, defaultValue string) string {
 
 // getEnvInt returns an integer environment variable or a default.
 func getEnvInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
+	if value, fromFile := lookupSetting(key); value != "" {
 		i, err := strconv.Atoi(value)
 		if err != nil {
-			slog.Warn("config: could not parse env var", "key", key, "value", value, "default", defaultValue, "error", err)
+			reportInvalidSetting(key, value, fromFile, defaultValue, err)
 			return defaultValue
 		}
 		return i
@@ -41,10 +44,10 @@ func getEnvInt(key string, defaultValue int) int {
 
 // getEnvInt64 returns an int64 environment variable or a default.
 func getEnvInt64(key string, defaultValue int64) int64 {
-	if value := os.Getenv(key); value != "" {
+	if value, fromFile := lookupSetting(key); value != "" {
 		i, err := strconv.ParseInt(value, 10, 64)
 		if err != nil {
-			slog.Warn("config: could not parse env var", "key", key, "value", value, "default", defaultValue, "error", err)
+			reportInvalidSetting(key, value, fromFile, defaultValue, err)
 			return defaultValue
 		}
 		return i
@@ -54,10 +57,10 @@ func getEnvInt64(key string, defaultValue int64) int64 {
 
 // getEnvBool returns a boolean environment variable or a default.
 func getEnvBool(key string, defaultValue bool) bool {
-	if value := os.Getenv(key); value != "" {
+	if value, fromFile := lookupSetting(key); value != "" {
 		b, err := strconv.ParseBool(value)
 		if err != nil {
-			slog.Warn("config: could not parse env var", "key", key, "value", value, "default", defaultValue, "error", err)
+			reportInvalidSetting(key, value, fromFile, defaultValue, err)
 			return defaultValue
 		}
 		return b
@@ -67,10 +70,10 @@ func getEnvBool(key string, defaultValue bool) bool {
 
 // getEnvDuration returns a duration environment variable or a default.
 func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
-	if value := os.Getenv(key); value != "" {
+	if value, fromFile := lookupSetting(key); value != "" {
 		d, err := time.ParseDuration(value)
 		if err != nil {
-			slog.Warn("config: could not parse env var", "key", key, "value", value, "default", defaultValue, "error", err)
+			reportInvalidSetting(key, value, fromFile, defaultValue, err)
 			return defaultValue
 		}
 		return d
@@ -80,10 +83,10 @@ func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
 
 // getEnvFloat returns a float64 environment variable or a default.
 func getEnvFloat(key string, defaultValue float64) float64 {
-	if value := os.Getenv(key); value != "" {
+	if value, fromFile := lookupSetting(key); value != "" {
 		f, err := strconv.ParseFloat(value, 64)
 		if err != nil {
-			slog.Warn("config: could not parse env var", "key", key, "value", value, "default", defaultValue, "error", err)
+			reportInvalidSetting(key, value, fromFile, defaultValue, err)
 			return defaultValue
 		}
 		return f
@@ -91,9 +94,23 @@ func getEnvFloat(key string, defaultValue float64) float64 {
 	return defaultValue
 }
 
+// getEnvRetryPolicy returns defaultValue overridden by a retry policy spec
+// environment variable; see retry.ParsePolicy.
+func getEnvRetryPolicy(key string, defaultValue retry.Policy) retry.Policy {
+	if value, fromFile := lookupSetting(key); value != "" {
+		p, err := retry.ParsePolicy(defaultValue, value)
+		if err != nil {
+			reportInvalidSetting(key, value, fromFile, defaultValue, err)
+			return defaultValue
+		}
+		return p
+	}
+	return defaultValue
+}
+
 // getEnvStringSlice returns a slice from a comma-separated environment variable.
 func getEnvStringSlice(key string, defaultValue []string) []string {
-	if value := os.Getenv(key); value != "" {
+	if value, _ := lookupSetting(key); value != "" {
 		parts := strings.Split(value, ",")
 		result := make([]string, 0, len(parts))
 		for _, p := range parts {
@@ -125,11 +142,55 @@ func ResolveStandaloneCloneFilter(raw string) string {
 	return trimmed
 }
 
+// validateSparseCheckoutPath accepts a repository-relative directory for
+// cone-mode sparse checkout. Paths are passed to git as arguments, so option
+// lookalikes are rejected along with anything escaping the repository.
+func validateSparseCheckoutPath(p string) error {
+	switch {
+	case p == "" || strings.HasPrefix(p, "/"):
+		return fmt.Errorf("path %q must be relative to the repository root", p)
+	case strings.HasPrefix(p, "-"):
+		return fmt.Errorf("path %q must not start with '-'", p)
+	case strings.ContainsAny(p, "\x00\n\r*?[\\"):
+		return fmt.Errorf("path %q must be a plain directory, not a pattern", p)
+	}
+	for _, segment := range strings.Split(p, "/") {
+		if segment == ".." {
+			return fmt.Errorf("path %q must not contain '..'", p)
+		}
+	}
+	return nil
+}
+
+// ParseKeyValueList parses comma-separated key=value pairs, as used by
+// OTEL_EXPORTER_OTLP_HEADERS. Keys and values are trimmed; values may be
+// percent-encoded. A blank list yields an empty map.
+func ParseKeyValueList(raw string) (map[string]string, error) {
+	pairs := make(map[string]string)
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(item, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("entry %q must be key=value", item)
+		}
+		decoded, err := url.PathUnescape(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("entry %q: %w", key, err)
+		}
+		pairs[key] = decoded
+	}
+	return pairs, nil
+}
+
 // getEnvOrGenerate returns the value of an environment variable, or generates
 // a cryptographically random hex password of the given byte length.
 // If the operator sets a value shorter than 8 characters, a warning is logged.
 func getEnvOrGenerate(key string, byteLen int) string {
-	if value := os.Getenv(key); value != "" {
+	if value, _ := lookupSetting(key); value != "" {
 		if len(value) < 8 {
 			slog.Warn("config: weak password detected — consider using at least 8 characters", "key", key)
 		}
@@ -234,6 +295,39 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	if c.LifecycleWebhookURL != "" {
+		if u, err := url.Parse(c.LifecycleWebhookURL); err != nil {
+			errs = append(errs, fmt.Errorf("LIFECYCLE_WEBHOOK_URL is not a valid URL: %w", err))
+		} else if u.Scheme != "http" && u.Scheme != "https" {
+			errs = append(errs, fmt.Errorf("LIFECYCLE_WEBHOOK_URL must use http or https scheme, got %q", u.Scheme))
+		}
+	}
+
+	if c.PreProvisioned {
+		if c.IsDeploymentMode() || c.IsStandaloneMode() {
+			errs = append(errs, fmt.Errorf("PRE_PROVISIONED requires NODE_ROLE=%s", RoleWorkspace))
+		}
+		if c.BootstrapToken != "" || c.WorkspaceID != "" {
+			errs = append(errs, fmt.Errorf("PRE_PROVISIONED nodes receive BOOTSTRAP_TOKEN and WORKSPACE_ID from POST /assign; they must not be set"))
+		}
+	}
+
+	if c.HookTimeout < 0 {
+		errs = append(errs, fmt.Errorf("HOOK_TIMEOUT must be >= 0, got %s", c.HookTimeout))
+	}
+	if c.GitCoAuthorEmail != "" {
+		if strings.HasPrefix(c.GitCoAuthorEmail, "-") || !strings.Contains(c.GitCoAuthorEmail, "@") || strings.ContainsAny(c.GitCoAuthorEmail, "<> \t\r\n") {
+			errs = append(errs, fmt.Errorf("GIT_CO_AUTHOR_EMAIL must be a plain email address, got %q", c.GitCoAuthorEmail))
+		}
+	}
+	if c.DotfilesRepository != "" {
+		if u, err := url.Parse(c.DotfilesRepository); err != nil {
+			errs = append(errs, fmt.Errorf("DOTFILES_REPOSITORY is not a valid URL: %w", err))
+		} else if u.Scheme != "https" || u.User != nil {
+			errs = append(errs, fmt.Errorf("DOTFILES_REPOSITORY must be an https URL without credentials"))
+		}
+	}
+
 	// TLS cert/key paths must exist when TLS is enabled
 	if c.TLSEnabled {
 		if _, err := os.Stat(c.TLSCertPath); err != nil {
@@ -244,6 +338,77 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	for _, proxy := range []struct{ name, value string }{{"HTTP_PROXY", c.HTTPProxy}, {"HTTPS_PROXY", c.HTTPSProxy}} {
+		if proxy.value == "" {
+			continue
+		}
+		raw := proxy.value
+		if !strings.Contains(raw, "://") {
+			raw = "http://" + raw // as http.ProxyFromEnvironment does
+		}
+		if u, err := url.Parse(raw); err != nil || u.Host == "" {
+			errs = append(errs, fmt.Errorf("%s must be a proxy URL such as http://proxy:3128, got %q", proxy.name, proxy.value))
+		}
+	}
+	if c.CABundlePath != "" {
+		if _, err := loadCABundle(c.CABundlePath); err != nil {
+			errs = append(errs, fmt.Errorf("CA_BUNDLE_PATH: %w", err))
+		}
+	}
+
+	if (c.DevcontainerRegistryServer == "") != (c.DevcontainerRegistryPassword == "") {
+		errs = append(errs, fmt.Errorf("DEVCONTAINER_REGISTRY_SERVER and DEVCONTAINER_REGISTRY_PASSWORD must be set together"))
+	}
+
+	if c.EventUploadEnabled && c.EventUploadBatchSize <= 0 {
+		errs = append(errs, fmt.Errorf("EVENT_UPLOAD_BATCH_SIZE must be > 0, got %d", c.EventUploadBatchSize))
+	}
+	if c.TracingOTLPEndpoint != "" {
+		if u, err := url.Parse(c.TracingOTLPEndpoint); err != nil {
+			errs = append(errs, fmt.Errorf("OTEL_EXPORTER_OTLP_ENDPOINT is not a valid URL: %w", err))
+		} else if u.Scheme != "http" && u.Scheme != "https" {
+			errs = append(errs, fmt.Errorf("OTEL_EXPORTER_OTLP_ENDPOINT must use http or https scheme, got %q", u.Scheme))
+		}
+		if c.TracingExportInterval <= 0 {
+			errs = append(errs, fmt.Errorf("TRACING_EXPORT_INTERVAL must be > 0, got %s", c.TracingExportInterval))
+		}
+	}
+	if _, err := ParseKeyValueList(c.TracingOTLPHeaders); err != nil {
+		errs = append(errs, fmt.Errorf("OTEL_EXPORTER_OTLP_HEADERS: %w", err))
+	}
+
+	for _, sink := range c.BootLogSinks {
+		switch sink {
+		case "file":
+			if strings.TrimSpace(c.BootLogFilePath) == "" {
+				errs = append(errs, fmt.Errorf("BOOTLOG_FILE_PATH is required when BOOTLOG_SINKS includes file"))
+			}
+		case "journald":
+		case "webhook":
+			if c.BootLogWebhookURL == "" {
+				errs = append(errs, fmt.Errorf("BOOTLOG_WEBHOOK_URL is required when BOOTLOG_SINKS includes webhook"))
+			} else if u, err := url.Parse(c.BootLogWebhookURL); err != nil {
+				errs = append(errs, fmt.Errorf("BOOTLOG_WEBHOOK_URL is not a valid URL: %w", err))
+			} else if u.Scheme != "http" && u.Scheme != "https" {
+				errs = append(errs, fmt.Errorf("BOOTLOG_WEBHOOK_URL must use http or https scheme, got %q", u.Scheme))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("BOOTLOG_SINKS: unknown sink %q (want file, journald or webhook)", sink))
+		}
+	}
+	if c.CallbackOutboxMaxEntries < 0 {
+		errs = append(errs, fmt.Errorf("CALLBACK_OUTBOX_MAX_ENTRIES must be >= 0, got %d", c.CallbackOutboxMaxEntries))
+	}
+	if c.CallbackOutboxMaxAttempts < 0 {
+		errs = append(errs, fmt.Errorf("CALLBACK_OUTBOX_MAX_ATTEMPTS must be >= 0, got %d", c.CallbackOutboxMaxAttempts))
+	}
+	if c.SelfUpdateInterval < 0 {
+		errs = append(errs, fmt.Errorf("SELF_UPDATE_INTERVAL must be >= 0, got %s", c.SelfUpdateInterval))
+	}
+	if c.SelfUpdateInterval > 0 && c.SelfUpdatePubKey == "" {
+		errs = append(errs, fmt.Errorf("SELF_UPDATE_PUB_KEY is required when SELF_UPDATE_INTERVAL is set"))
+	}
+
 	// Workspace-specific validations (skip in deployment mode)
 	if !c.IsDeploymentMode() {
 		if c.GitCredentialTimeout <= 0 {
@@ -287,6 +452,59 @@ func (c *Config) Validate() error {
 		if c.TerminalSessionIDMaxLength < 1 {
 			errs = append(errs, fmt.Errorf("TERMINAL_SESSION_ID_MAX_LENGTH must be > 0, got %d", c.TerminalSessionIDMaxLength))
 		}
+
+		if c.CloneDepth < 0 {
+			errs = append(errs, fmt.Errorf("CLONE_DEPTH must be >= 0, got %d", c.CloneDepth))
+		}
+		for _, p := range c.SparseCheckoutPaths {
+			if err := validateSparseCheckoutPath(p); err != nil {
+				errs = append(errs, fmt.Errorf("SPARSE_CHECKOUT_PATHS: %w", err))
+			}
+		}
+
+		for _, w := range []struct {
+			name  string
+			value float64
+		}{
+			{"ACTIVITY_WEIGHT_TERMINAL", c.ActivityWeightTerminal},
+			{"ACTIVITY_WEIGHT_PROMPT", c.ActivityWeightPrompt},
+			{"ACTIVITY_WEIGHT_VIEWER", c.ActivityWeightViewer},
+			{"ACTIVITY_WEIGHT_FILES", c.ActivityWeightFiles},
+			{"ACTIVITY_WEIGHT_COMMAND", c.ActivityWeightCommand},
+		} {
+			if w.value < 0 || math.IsNaN(w.value) || math.IsInf(w.value, 0) {
+				errs = append(errs, fmt.Errorf("%s must be a finite number >= 0, got %v", w.name, w.value))
+			}
+		}
+		if c.ShutdownDrainCountdown < 0 {
+			errs = append(errs, fmt.Errorf("SHUTDOWN_DRAIN_COUNTDOWN must be >= 0, got %s", c.ShutdownDrainCountdown))
+		}
+		if c.RecoveryRetryMaxAttempts < 0 {
+			errs = append(errs, fmt.Errorf("RECOVERY_RETRY_MAX_ATTEMPTS must be >= 0, got %d", c.RecoveryRetryMaxAttempts))
+		}
+		if c.RecoveryRetryMaxAttempts > 0 && (c.RecoveryRetryInitialDelay <= 0 || c.RecoveryRetryMaxDelay < c.RecoveryRetryInitialDelay) {
+			errs = append(errs, fmt.Errorf("RECOVERY_RETRY_INITIAL_DELAY must be > 0 and <= RECOVERY_RETRY_MAX_DELAY, got %s and %s", c.RecoveryRetryInitialDelay, c.RecoveryRetryMaxDelay))
+		}
+		if c.ACPMaxAgentsPerWorkspace < 0 {
+			errs = append(errs, fmt.Errorf("ACP_MAX_AGENTS_PER_WORKSPACE must be >= 0, got %d", c.ACPMaxAgentsPerWorkspace))
+		}
+		if c.ACPWorkspaceMemoryPercent < 0 || c.ACPWorkspaceMemoryPercent > 100 {
+			errs = append(errs, fmt.Errorf("ACP_WORKSPACE_MEMORY_PERCENT must be between 0 and 100, got %d", c.ACPWorkspaceMemoryPercent))
+		}
+		if c.ACPAgentQuotaQueueTimeout < 0 {
+			errs = append(errs, fmt.Errorf("ACP_AGENT_QUOTA_QUEUE_TIMEOUT must be >= 0, got %s", c.ACPAgentQuotaQueueTimeout))
+		}
+		if c.DiskMonitorInterval > 0 {
+			if c.DiskWarnPercent <= 0 || c.DiskCriticalPercent < c.DiskWarnPercent || c.DiskCriticalPercent > 100 {
+				errs = append(errs, fmt.Errorf("DISK_WARN_PERCENT must be > 0 and <= DISK_CRITICAL_PERCENT <= 100, got %v and %v", c.DiskWarnPercent, c.DiskCriticalPercent))
+			}
+			if c.DiskPrunePercent < 0 || c.DiskPrunePercent > 100 {
+				errs = append(errs, fmt.Errorf("DISK_PRUNE_PERCENT must be between 0 and 100, got %v", c.DiskPrunePercent))
+			}
+			if c.DiskPrunePercent > 0 && c.DiskPruneCooldown < 0 {
+				errs = append(errs, fmt.Errorf("DISK_PRUNE_COOLDOWN must be >= 0, got %s", c.DiskPruneCooldown))
+			}
+		}
 	}
 
 	return errors.Join(errs...)
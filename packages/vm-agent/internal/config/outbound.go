@@ -0,0 +1,156 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// systemCABundlePaths are the usual locations of the host's PEM trust store,
+// in the order Go's crypto/x509 checks them on Linux.
+var systemCABundlePaths = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/ca-bundle.pem",
+	"/etc/pki/tls/cacert.pem",
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem",
+	"/etc/ssl/cert.pem",
+}
+
+var (
+	outboundMu        sync.RWMutex
+	outboundTLSConfig *tls.Config
+)
+
+// loadCABundle reads a PEM file and checks that it holds at least one
+// certificate.
+func loadCABundle(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil, fmt.Errorf("%s contains no PEM certificates", path)
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return nil, fmt.Errorf("%s: invalid certificate: %w", path, err)
+		}
+		return data, nil
+	}
+}
+
+// ApplyOutbound makes the configured proxy and CA bundle take effect for the
+// agent process and the commands it runs:
+//   - the proxy variables are exported in both cases, so git, curl, and the
+//     devcontainer CLI pick them up;
+//   - the CA bundle is added to the root pool of the shared control-plane
+//     transport and http.DefaultTransport;
+//   - a combined bundle (system roots plus CA_BUNDLE_PATH) is written next to
+//     the bootstrap state and exported as GIT_SSL_CAINFO and CURL_CA_BUNDLE,
+//     and NODE_EXTRA_CA_CERTS points the devcontainer CLI at the extra CAs.
+//
+// Call it once at startup before any HTTP request is made:
+// http.ProxyFromEnvironment reads the environment only on first use.
+func ApplyOutbound(c *Config) error {
+	for _, v := range []struct{ value, upper, lower string }{
+		{c.HTTPProxy, "HTTP_PROXY", "http_proxy"},
+		{c.HTTPSProxy, "HTTPS_PROXY", "https_proxy"},
+		{c.NoProxy, "NO_PROXY", "no_proxy"},
+	} {
+		if v.value == "" {
+			continue
+		}
+		if err := errors.Join(os.Setenv(v.upper, v.value), os.Setenv(v.lower, v.value)); err != nil {
+			return fmt.Errorf("failed to export %s: %w", v.upper, err)
+		}
+	}
+
+	if c.CABundlePath == "" {
+		return nil
+	}
+	extra, err := loadCABundle(c.CABundlePath)
+	if err != nil {
+		return fmt.Errorf("CA_BUNDLE_PATH: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		slog.Warn("System CA pool unavailable; trusting only CA_BUNDLE_PATH", "error", err)
+		pool = x509.NewCertPool()
+	}
+	pool.AppendCertsFromPEM(extra)
+	tlsConfig := &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+
+	outboundMu.Lock()
+	outboundTLSConfig = tlsConfig
+	outboundMu.Unlock()
+	controlPlaneTransport.TLSClientConfig = tlsConfig.Clone()
+	if t, ok := http.DefaultTransport.(*http.Transport); ok {
+		t.TLSClientConfig = tlsConfig.Clone()
+	}
+
+	combinedPath := CombinedCABundlePath(c)
+	if err := writeCombinedCABundle(combinedPath, extra); err != nil {
+		return err
+	}
+	for key, value := range map[string]string{
+		"GIT_SSL_CAINFO":      combinedPath,
+		"CURL_CA_BUNDLE":      combinedPath,
+		"NODE_EXTRA_CA_CERTS": c.CABundlePath,
+	} {
+		if err := os.Setenv(key, value); err != nil {
+			return fmt.Errorf("failed to export %s: %w", key, err)
+		}
+	}
+	slog.Info("Custom CA bundle trusted", "path", c.CABundlePath, "combined", combinedPath)
+	return nil
+}
+
+// OutboundTLSConfig returns a copy of the TLS client config that trusts
+// CA_BUNDLE_PATH, or nil when no bundle is configured. HTTP clients with
+// their own transport set it as TLSClientConfig.
+func OutboundTLSConfig() *tls.Config {
+	outboundMu.RLock()
+	defer outboundMu.RUnlock()
+	if outboundTLSConfig == nil {
+		return nil
+	}
+	return outboundTLSConfig.Clone()
+}
+
+// CombinedCABundlePath is where ApplyOutbound writes the system roots
+// followed by CA_BUNDLE_PATH.
+func CombinedCABundlePath(c *Config) string {
+	return filepath.Join(filepath.Dir(c.BootstrapStatePath), "ca-bundle.pem")
+}
+
+func writeCombinedCABundle(path string, extra []byte) error {
+	var combined []byte
+	for _, systemPath := range systemCABundlePaths {
+		if data, err := os.ReadFile(systemPath); err == nil {
+			combined = append(data, '\n')
+			break
+		}
+	}
+	combined = append(combined, extra...)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create CA bundle directory: %w", err)
+	}
+	if err := os.WriteFile(path, combined, 0o644); err != nil {
+		return fmt.Errorf("failed to write combined CA bundle: %w", err)
+	}
+	return nil
+}
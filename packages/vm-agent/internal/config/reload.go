@@ -0,0 +1,171 @@
+package config
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reloadableSetting parses a setting value into a Config field and copies
+// that field between configs.
+type reloadableSetting struct {
+	set  func(c *Config, value string) error
+	copy func(dst, src *Config)
+}
+
+// reloadableSettings are the settings that may change while the agent runs,
+// keyed by env var name. Each is read when a session or workspace is created,
+// so a new value applies to sessions and workspaces created afterwards.
+// Identity, credentials, listen addresses, and storage paths are absent on
+// purpose: they are fixed for the life of the process.
+var reloadableSettings = map[string]reloadableSetting{
+	"ACP_PROMPT_TIMEOUT":              fieldSetting(func(c *Config) *time.Duration { return &c.ACPPromptTimeout }, parseDurationSetting),
+	"ACP_TASK_PROMPT_TIMEOUT":         fieldSetting(func(c *Config) *time.Duration { return &c.ACPTaskPromptTimeout }, parseDurationSetting),
+	"ACP_PROMPT_TIMEOUT_OVERRIDE_MAX": fieldSetting(func(c *Config) *time.Duration { return &c.ACPPromptTimeoutOverrideMax }, parseDurationSetting),
+	"ACP_POLL_IDLE_TIMEOUT":           fieldSetting(func(c *Config) *time.Duration { return &c.ACPPollIdleTimeout }, parseDurationSetting),
+	"ACP_MESSAGE_BUFFER_SIZE":         fieldSetting(func(c *Config) *int { return &c.ACPMessageBufferSize }, parsePositiveIntSetting),
+	"ACP_VIEWER_SEND_BUFFER":          fieldSetting(func(c *Config) *int { return &c.ACPViewerSendBuffer }, parsePositiveIntSetting),
+	"ACP_STDERR_BUFFER_BYTES":         fieldSetting(func(c *Config) *int { return &c.ACPStderrBufferBytes }, parsePositiveIntSetting),
+	"PTY_OUTPUT_BUFFER_SIZE":          fieldSetting(func(c *Config) *int { return &c.PTYOutputBufferSize }, parsePositiveIntSetting),
+	"ADDITIONAL_FEATURES":             fieldSetting(func(c *Config) *string { return &c.AdditionalFeatures }, parseAdditionalFeatures),
+}
+
+// ReloadableSettings returns the env var names ApplySettings accepts, sorted.
+func ReloadableSettings() []string {
+	names := make([]string, 0, len(reloadableSettings))
+	for name := range reloadableSettings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ApplySettings updates reloadable settings from env-style values and returns
+// the names it applied, sorted. Every value is validated before any is
+// applied, so a bad value leaves the config unchanged.
+func (c *Config) ApplySettings(values map[string]string) ([]string, error) {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	scratch := *c
+	for _, name := range names {
+		set, ok := reloadableSettings[name]
+		if !ok {
+			return nil, fmt.Errorf("%s cannot be reloaded", name)
+		}
+		if err := set.set(&scratch, values[name]); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	for _, name := range names {
+		_ = reloadableSettings[name].set(c, values[name])
+	}
+	return names, nil
+}
+
+// CopySettings copies every reloadable setting from src into c, leaving the
+// other fields alone.
+func (c *Config) CopySettings(src *Config) {
+	for _, setting := range reloadableSettings {
+		setting.copy(c, src)
+	}
+}
+
+// LoadSettingsFile reads reloadable settings from an env file of KEY=VALUE
+// lines. Blank lines and lines starting with # are skipped, and values may be
+// wrapped in single or double quotes. A missing file yields no settings.
+func LoadSettingsFile(path string) (map[string]string, error) {
+	values := make(map[string]string)
+	if path == "" {
+		return values, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return values, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%s:%d: line must be KEY=VALUE", path, lineNo)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// fieldSetting is a reloadable setting stored in the field returned by field.
+func fieldSetting[T any](field func(*Config) *T, parse func(string) (T, error)) reloadableSetting {
+	return reloadableSetting{
+		set: func(c *Config, value string) error {
+			v, err := parse(value)
+			if err != nil {
+				return err
+			}
+			*field(c) = v
+			return nil
+		},
+		copy: func(dst, src *Config) { *field(dst) = *field(src) },
+	}
+}
+
+func parseDurationSetting(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("must not be negative")
+	}
+	return d, nil
+}
+
+func parsePositiveIntSetting(value string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("must be positive")
+	}
+	return n, nil
+}
+
+// parseAdditionalFeatures accepts a devcontainer features JSON object, or an
+// empty value to stop injecting features.
+func parseAdditionalFeatures(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value != "" {
+		var features map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(value), &features); err != nil {
+			return "", fmt.Errorf("must be a JSON object: %w", err)
+		}
+	}
+	return value, nil
+}
@@ -5,13 +5,13 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
-	"os"
 	"os/exec"
-	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/workspace/vm-agent/internal/containerruntime"
 )
 
 type containerCandidate struct {
@@ -25,19 +25,14 @@ var (
 	inspectContainerBridgeIP     = dockerInspectContainerBridgeIP
 )
 
-// DockerCLIPath resolves an absolute path to the docker CLI. It prefers the
-// SAM_DOCKER_CLI_PATH override, then a PATH lookup (only if it resolves to an
-// absolute path), and finally falls back to the conventional install location.
-// Returning an absolute path keeps callers off a bare-name PATH search.
+// DockerCLIPath resolves an absolute path to the container CLI: docker, or
+// podman when that runtime is selected. It prefers the SAM_DOCKER_CLI_PATH
+// (SAM_PODMAN_CLI_PATH) override, then a PATH lookup (only if it resolves to
+// an absolute path), and finally falls back to the conventional install
+// location. Returning an absolute path keeps callers off a bare-name PATH
+// search.
 func DockerCLIPath() string {
-	path := os.Getenv("SAM_DOCKER_CLI_PATH")
-	if filepath.IsAbs(path) {
-		return path
-	}
-	if path, err := exec.LookPath("docker"); err == nil && filepath.IsAbs(path) {
-		return path
-	}
-	return "/usr/bin/docker"
+	return containerruntime.Current().Path()
 }
 
 // Discovery finds and caches the devcontainer's Docker container ID and network metadata.
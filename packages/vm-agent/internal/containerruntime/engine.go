@@ -0,0 +1,181 @@
+package containerruntime
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/moby/moby/api/pkg/stdcopy"
+	"github.com/moby/moby/client"
+)
+
+// podmanSocket is the rootful Podman API socket, used when DOCKER_HOST is
+// unset and the runtime is Podman.
+const podmanSocket = "unix:///run/podman/podman.sock"
+
+// Engine serves Runtime's structured operations through the engine API with
+// the Docker Go client, and everything else through the wrapped CLI runtime.
+// Podman's Docker-compatible API works with the same client.
+type Engine struct {
+	Runtime
+	client *client.Client
+}
+
+// NewEngine connects to the engine API of rt, as configured by DOCKER_HOST
+// and the other DOCKER_* variables, and checks that it answers.
+func NewEngine(ctx context.Context, rt Runtime) (*Engine, error) {
+	opts := []client.Opt{client.FromEnv}
+	if os.Getenv("DOCKER_HOST") == "" && rt.Name() == NamePodman {
+		opts = append(opts, client.WithHost(podmanSocket))
+	}
+	c, err := client.New(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%s engine API client: %w", rt.Name(), err)
+	}
+	if _, err := c.Ping(ctx, client.PingOptions{NegotiateAPIVersion: true}); err != nil {
+		_ = c.Close()
+		return nil, fmt.Errorf("%s engine API unreachable: %w", rt.Name(), err)
+	}
+	return &Engine{Runtime: rt, client: c}, nil
+}
+
+// Close closes the API client.
+func (e *Engine) Close() error {
+	return e.client.Close()
+}
+
+func (e *Engine) ListContainers(ctx context.Context, all bool, filters ...string) ([]string, error) {
+	apiFilters := make(client.Filters)
+	for _, filter := range filters {
+		key, value, _ := strings.Cut(filter, "=")
+		apiFilters.Add(key, value)
+	}
+	result, err := e.client.ContainerList(ctx, client.ContainerListOptions{All: all, Filters: apiFilters})
+	if err != nil {
+		return nil, fmt.Errorf("%s list containers: %w", e.Name(), err)
+	}
+	ids := make([]string, 0, len(result.Items))
+	for _, item := range result.Items {
+		ids = append(ids, item.ID)
+	}
+	return ids, nil
+}
+
+func (e *Engine) Inspect(ctx context.Context, containerID string) (ContainerInfo, error) {
+	result, err := e.client.ContainerInspect(ctx, containerID, client.ContainerInspectOptions{})
+	if err != nil {
+		return ContainerInfo{}, fmt.Errorf("%s inspect %s: %w", e.Name(), containerID, err)
+	}
+	info := ContainerInfo{ID: result.Container.ID, Image: result.Container.Image}
+	if state := result.Container.State; state != nil {
+		info.Running, info.Pid = state.Running, state.Pid
+	}
+	if cfg := result.Container.Config; cfg != nil {
+		info.User, info.Labels = cfg.User, cfg.Labels
+	}
+	return info, nil
+}
+
+func (e *Engine) Exec(ctx context.Context, containerID string, opts ExecOptions, command ...string) (*ExecResult, error) {
+	created, err := e.client.ExecCreate(ctx, containerID, client.ExecCreateOptions{
+		User:         opts.User,
+		WorkingDir:   opts.WorkDir,
+		Env:          opts.Env,
+		Cmd:          command,
+		AttachStdin:  opts.Stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s exec create: %w", e.Name(), err)
+	}
+	attached, err := e.client.ExecAttach(ctx, created.ID, client.ExecAttachOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("%s exec attach: %w", e.Name(), err)
+	}
+	defer attached.Close()
+	// The hijacked connection ignores ctx once established.
+	stop := context.AfterFunc(ctx, attached.Close)
+	defer stop()
+
+	if opts.Stdin != nil {
+		go func() {
+			_, _ = io.Copy(attached.Conn, opts.Stdin)
+			_ = attached.CloseWrite()
+		}()
+	}
+
+	var stdout, stderr bytes.Buffer
+	stdoutW, stderrW := execWriters(&stdout, &stderr, opts.Output)
+	if _, err := stdcopy.StdCopy(stdoutW, stderrW, attached.Reader); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%s exec: %w", e.Name(), ctx.Err())
+		}
+		return nil, fmt.Errorf("%s exec output: %w", e.Name(), err)
+	}
+	inspected, err := e.client.ExecInspect(ctx, created.ID, client.ExecInspectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("%s exec inspect: %w", e.Name(), err)
+	}
+	return newExecResult(command, stdout.Bytes(), stderr.Bytes(), inspected.ExitCode)
+}
+
+func (e *Engine) WriteFile(ctx context.Context, containerID, filePath string, data []byte, mode os.FileMode) error {
+	archive, err := fileArchive(filePath, data, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := e.client.CopyToContainer(ctx, containerID, client.CopyToContainerOptions{
+		DestinationPath: path.Dir(filePath),
+		Content:         archive,
+	}); err != nil {
+		return fmt.Errorf("%s copy %s: %w", e.Name(), filePath, err)
+	}
+	return nil
+}
+
+// Copy uploads a host file into a container through the API. Directories
+// and copies out of a container go through the CLI.
+func (e *Engine) Copy(ctx context.Context, src, dst string) ([]byte, error) {
+	containerID, containerPath, toContainer := strings.Cut(dst, ":")
+	info, err := os.Stat(src)
+	if !toContainer || err != nil || !info.Mode().IsRegular() {
+		return e.Runtime.Copy(ctx, src, dst)
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return nil, err
+	}
+	return nil, e.WriteFile(ctx, containerID, containerPath, data, info.Mode())
+}
+
+// VolumeCreate is idempotent: the API returns an existing volume of the
+// same name.
+func (e *Engine) VolumeCreate(ctx context.Context, name string) ([]byte, error) {
+	if _, err := e.client.VolumeCreate(ctx, client.VolumeCreateOptions{Name: name}); err != nil {
+		return nil, fmt.Errorf("%s volume create %s: %w", e.Name(), name, err)
+	}
+	return nil, nil
+}
+
+func (e *Engine) VolumeRemove(ctx context.Context, name string) ([]byte, error) {
+	if _, err := e.client.VolumeRemove(ctx, name, client.VolumeRemoveOptions{Force: true}); err != nil {
+		return nil, fmt.Errorf("%s volume remove %s: %w", e.Name(), name, err)
+	}
+	return nil, nil
+}
+
+func (e *Engine) VolumeMountpoint(ctx context.Context, name string) (string, error) {
+	result, err := e.client.VolumeInspect(ctx, name, client.VolumeInspectOptions{})
+	if err != nil {
+		return "", fmt.Errorf("%s volume inspect %s: %w", e.Name(), name, err)
+	}
+	if result.Volume.Mountpoint == "" {
+		return "", fmt.Errorf("volume %s has no mountpoint", name)
+	}
+	return result.Volume.Mountpoint, nil
+}
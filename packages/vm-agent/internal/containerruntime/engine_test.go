@@ -0,0 +1,143 @@
+package containerruntime
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeEngineAPI serves the engine API endpoints Engine uses. Paths are
+// matched without the /vX.Y version prefix.
+func fakeEngineAPI(t *testing.T, handle func(w http.ResponseWriter, r *http.Request, path string)) *Engine {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if strings.HasPrefix(path, "/v") {
+			if i := strings.Index(path[1:], "/"); i >= 0 {
+				path = path[i+1:]
+			}
+		}
+		if path == "/_ping" {
+			w.Header().Set("API-Version", "1.47")
+			_, _ = io.WriteString(w, "OK")
+			return
+		}
+		handle(w, r, path)
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("DOCKER_HOST", "tcp://"+strings.TrimPrefix(server.URL, "http://"))
+	engine, err := NewEngine(t.Context(), NewDocker())
+	if err != nil {
+		t.Fatalf("NewEngine: %v", err)
+	}
+	t.Cleanup(func() { _ = engine.Close() })
+	return engine
+}
+
+func TestEngineListContainers(t *testing.T) {
+	engine := fakeEngineAPI(t, func(w http.ResponseWriter, r *http.Request, path string) {
+		if path != "/containers/json" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Query().Get("all") != "1" {
+			t.Errorf("all = %q, want 1", r.URL.Query().Get("all"))
+		}
+		var filters map[string]map[string]bool
+		if err := json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters); err != nil {
+			t.Errorf("decode filters: %v", err)
+		}
+		if !filters["label"]["devcontainer.local_folder=/workspace"] {
+			t.Errorf("filters = %v, want label devcontainer.local_folder=/workspace", filters)
+		}
+		_, _ = io.WriteString(w, `[{"Id":"abc"},{"Id":"def"}]`)
+	})
+
+	ids, err := engine.ListContainers(t.Context(), true, "label=devcontainer.local_folder=/workspace")
+	if err != nil {
+		t.Fatalf("ListContainers: %v", err)
+	}
+	if want := []string{"abc", "def"}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("ListContainers = %v, want %v", ids, want)
+	}
+}
+
+func TestEngineInspect(t *testing.T) {
+	engine := fakeEngineAPI(t, func(w http.ResponseWriter, r *http.Request, path string) {
+		if path != "/containers/abc/json" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = io.WriteString(w, `{"Id":"abc","State":{"Running":true,"Pid":4242},"Config":{"User":"vscode","Labels":{"devcontainer.metadata":"[]"}}}`)
+	})
+
+	info, err := engine.Inspect(t.Context(), "abc")
+	if err != nil {
+		t.Fatalf("Inspect: %v", err)
+	}
+	want := ContainerInfo{ID: "abc", Running: true, Pid: 4242, User: "vscode", Labels: map[string]string{"devcontainer.metadata": "[]"}}
+	if !reflect.DeepEqual(info, want) {
+		t.Fatalf("Inspect = %+v, want %+v", info, want)
+	}
+}
+
+func TestEngineVolumeMountpoint(t *testing.T) {
+	engine := fakeEngineAPI(t, func(w http.ResponseWriter, r *http.Request, path string) {
+		if path != "/volumes/ws-data" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = io.WriteString(w, `{"Name":"ws-data","Mountpoint":"/var/lib/docker/volumes/ws-data/_data"}`)
+	})
+
+	got, err := engine.VolumeMountpoint(t.Context(), "ws-data")
+	if err != nil {
+		t.Fatalf("VolumeMountpoint: %v", err)
+	}
+	if got != "/var/lib/docker/volumes/ws-data/_data" {
+		t.Fatalf("VolumeMountpoint = %q", got)
+	}
+}
+
+func TestEngineWriteFile(t *testing.T) {
+	var gotDir, gotName, gotContent string
+	var gotMode int64
+	engine := fakeEngineAPI(t, func(w http.ResponseWriter, r *http.Request, path string) {
+		if r.Method != http.MethodPut || path != "/containers/abc/archive" {
+			http.NotFound(w, r)
+			return
+		}
+		gotDir = r.URL.Query().Get("path")
+		tr := tar.NewReader(r.Body)
+		header, err := tr.Next()
+		if err != nil {
+			t.Errorf("read archive: %v", err)
+			return
+		}
+		content, _ := io.ReadAll(tr)
+		gotName, gotMode, gotContent = header.Name, header.Mode, string(content)
+	})
+
+	if err := engine.WriteFile(t.Context(), "abc", "/usr/bin/gh", []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if gotDir != "/usr/bin" || gotName != "gh" || gotMode != 0o755 || gotContent != "#!/bin/sh\n" {
+		t.Fatalf("archive = dir %q name %q mode %o content %q", gotDir, gotName, gotMode, gotContent)
+	}
+}
+
+func TestEngineInspectNotFound(t *testing.T) {
+	engine := fakeEngineAPI(t, func(w http.ResponseWriter, r *http.Request, path string) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = io.WriteString(w, `{"message":"No such container: gone"}`)
+	})
+
+	if _, err := engine.Inspect(t.Context(), "gone"); err == nil || !strings.Contains(err.Error(), "No such container") {
+		t.Fatalf("Inspect error = %v, want No such container", err)
+	}
+}
@@ -0,0 +1,419 @@
+// Package containerruntime abstracts the container engine the agent drives.
+// Docker is the default; Podman is supported on hosts where it is the only
+// runtime. Both CLIs accept the same arguments for everything the agent runs,
+// so callers without a structured method use Command. Listing, inspecting,
+// exec, copying, and volumes are methods on Runtime, which Engine serves
+// through the engine API when its socket is reachable.
+package containerruntime
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Runtime is a container engine CLI.
+type Runtime interface {
+	// Name is "docker" or "podman".
+	Name() string
+	// Path is the absolute path of the CLI, for callers that must not rely
+	// on a PATH lookup.
+	Path() string
+	// Command returns a command running the CLI with args.
+	Command(ctx context.Context, args ...string) *exec.Cmd
+	// ExecArgs returns the CLI arguments that run command in a container.
+	ExecArgs(containerID string, opts ExecOptions, command ...string) []string
+	// ListContainers returns the IDs of the containers matching every
+	// filter, e.g. "label=devcontainer.local_folder=/workspace". Stopped
+	// containers are included when all is true.
+	ListContainers(ctx context.Context, all bool, filters ...string) ([]string, error)
+	// VolumeCreate creates a named volume, succeeding when it already exists.
+	VolumeCreate(ctx context.Context, name string) ([]byte, error)
+	// VolumeRemove force-removes a named volume.
+	VolumeRemove(ctx context.Context, name string) ([]byte, error)
+	// Copy copies between the host and a container; either side may be
+	// "<container>:<path>".
+	Copy(ctx context.Context, src, dst string) ([]byte, error)
+	// DevcontainerArgs are extra flags for the devcontainer CLI so it drives
+	// this runtime.
+	DevcontainerArgs() []string
+	// Inspect returns the state and configuration of a container.
+	Inspect(ctx context.Context, containerID string) (ContainerInfo, error)
+	// Exec runs command in a container and waits for it. A non-zero exit
+	// status is returned as an *ExecError alongside the result.
+	Exec(ctx context.Context, containerID string, opts ExecOptions, command ...string) (*ExecResult, error)
+	// WriteFile writes data to an absolute path in a container as a
+	// root-owned file with mode. The parent directory must exist.
+	WriteFile(ctx context.Context, containerID, path string, data []byte, mode os.FileMode) error
+	// VolumeMountpoint returns the host directory backing a named volume.
+	VolumeMountpoint(ctx context.Context, name string) (string, error)
+}
+
+// ExecOptions configures ExecArgs and Exec.
+type ExecOptions struct {
+	User        string
+	WorkDir     string
+	Env         []string // KEY=VALUE
+	Interactive bool
+	TTY         bool // ExecArgs only; Exec never allocates a TTY
+	// EnvFile names a host file of KEY=VALUE lines passed with --env-file,
+	// keeping the values out of the process table. ExecArgs only.
+	EnvFile string
+	// Stdin, when set, is copied to the command's standard input.
+	Stdin io.Reader
+	// Output, when set, receives stdout and stderr as they are produced.
+	Output io.Writer
+}
+
+// ContainerInfo is the subset of container inspect output the agent uses.
+type ContainerInfo struct {
+	ID      string
+	Image   string // image ID the container was created from
+	Running bool
+	Pid     int
+	User    string
+	Labels  map[string]string
+}
+
+// ExecResult is the captured output of Exec.
+type ExecResult struct {
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int
+}
+
+// ExecError reports a command that ran in a container and exited non-zero.
+type ExecError struct {
+	Command  []string
+	ExitCode int
+	Stderr   string
+}
+
+func (e *ExecError) Error() string {
+	name := "command"
+	if len(e.Command) > 0 {
+		name = e.Command[0]
+	}
+	if e.Stderr == "" {
+		return fmt.Sprintf("%s exited with status %d", name, e.ExitCode)
+	}
+	return fmt.Sprintf("%s exited with status %d: %s", name, e.ExitCode, e.Stderr)
+}
+
+func newExecResult(command []string, stdout, stderr []byte, exitCode int) (*ExecResult, error) {
+	result := &ExecResult{Stdout: stdout, Stderr: stderr, ExitCode: exitCode}
+	if exitCode != 0 {
+		return result, &ExecError{Command: command, ExitCode: exitCode, Stderr: strings.TrimSpace(string(stderr))}
+	}
+	return result, nil
+}
+
+// execWriters returns the stdout and stderr destinations for Exec, teeing
+// both to output when it is set.
+func execWriters(stdout, stderr *bytes.Buffer, output io.Writer) (io.Writer, io.Writer) {
+	if output == nil {
+		return stdout, stderr
+	}
+	output = &syncWriter{w: output}
+	return io.MultiWriter(stdout, output), io.MultiWriter(stderr, output)
+}
+
+// syncWriter serializes writes from the stdout and stderr copiers.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+// fileArchive returns a tar archive holding one root-owned file, named by
+// the base of path, for extraction into path's directory.
+func fileArchive(filePath string, data []byte, mode os.FileMode) (*bytes.Buffer, error) {
+	if !path.IsAbs(filePath) || path.Base(filePath) == "/" {
+		return nil, fmt.Errorf("container path %q must be an absolute file path", filePath)
+	}
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     path.Base(filePath),
+		Mode:     int64(mode.Perm()),
+		Size:     int64(len(data)),
+		ModTime:  time.Now(),
+	}); err != nil {
+		return nil, err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return &archive, nil
+}
+
+// execWaitDelay bounds how long a CLI Exec whose context is done waits for
+// its output, which a background process in the container may hold open.
+const execWaitDelay = 5 * time.Second
+
+// cliError wraps a failed CLI command with its stderr.
+func cliError(op string, err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(bytes.TrimSpace(exitErr.Stderr)) > 0 {
+		return fmt.Errorf("%s: %w: %s", op, err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return fmt.Errorf("%s: %w", op, err)
+}
+
+// cli implements Runtime for a Docker-compatible CLI.
+type cli struct {
+	name string
+	// pathEnv overrides the CLI path when it holds an absolute path.
+	pathEnv string
+}
+
+func (c cli) Name() string { return c.name }
+
+func (c cli) Path() string {
+	if path := os.Getenv(c.pathEnv); filepath.IsAbs(path) {
+		return path
+	}
+	if path, err := exec.LookPath(c.name); err == nil && filepath.IsAbs(path) {
+		return path
+	}
+	return "/usr/bin/" + c.name
+}
+
+func (c cli) Command(ctx context.Context, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, c.name, args...)
+}
+
+func (c cli) ExecArgs(containerID string, opts ExecOptions, command ...string) []string {
+	args := []string{"exec"}
+	if opts.Interactive {
+		args = append(args, "-i")
+	}
+	if opts.TTY {
+		args = append(args, "-t")
+	}
+	if opts.User != "" {
+		args = append(args, "-u", opts.User)
+	}
+	if opts.WorkDir != "" {
+		args = append(args, "-w", opts.WorkDir)
+	}
+	for _, env := range opts.Env {
+		args = append(args, "-e", env)
+	}
+	if opts.EnvFile != "" {
+		args = append(args, "--env-file", opts.EnvFile)
+	}
+	args = append(args, containerID)
+	return append(args, command...)
+}
+
+func (c cli) ListContainers(ctx context.Context, all bool, filters ...string) ([]string, error) {
+	args := []string{"ps", "-q"}
+	if all {
+		args[1] = "-aq"
+	}
+	for _, filter := range filters {
+		args = append(args, "--filter", filter)
+	}
+	output, err := c.Command(ctx, args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s ps: %w", c.name, err)
+	}
+	return strings.Fields(string(output)), nil
+}
+
+func (c cli) VolumeCreate(ctx context.Context, name string) ([]byte, error) {
+	// docker volume create is idempotent.
+	return c.Command(ctx, "volume", "create", name).CombinedOutput()
+}
+
+func (c cli) VolumeRemove(ctx context.Context, name string) ([]byte, error) {
+	return c.Command(ctx, "volume", "rm", "-f", name).CombinedOutput()
+}
+
+func (c cli) Copy(ctx context.Context, src, dst string) ([]byte, error) {
+	return c.Command(ctx, "cp", src, dst).CombinedOutput()
+}
+
+func (c cli) DevcontainerArgs() []string { return nil }
+
+// inspectOutput is the subset of `inspect --format '{{json .}}'` output
+// ContainerInfo is read from; docker and podman share these fields.
+type inspectOutput struct {
+	ID    string `json:"Id"`
+	Image string
+	State struct {
+		Running bool
+		Pid     int
+	}
+	Config struct {
+		User   string
+		Labels map[string]string
+	}
+}
+
+func (c cli) Inspect(ctx context.Context, containerID string) (ContainerInfo, error) {
+	output, err := c.Command(ctx, "inspect", "--format", "{{json .}}", containerID).Output()
+	if err != nil {
+		return ContainerInfo{}, cliError(c.name+" inspect "+containerID, err)
+	}
+	var inspected inspectOutput
+	if err := json.Unmarshal(bytes.TrimSpace(output), &inspected); err != nil {
+		return ContainerInfo{}, fmt.Errorf("%s inspect %s: decode output: %w", c.name, containerID, err)
+	}
+	return ContainerInfo{
+		ID:      inspected.ID,
+		Image:   inspected.Image,
+		Running: inspected.State.Running,
+		Pid:     inspected.State.Pid,
+		User:    inspected.Config.User,
+		Labels:  inspected.Config.Labels,
+	}, nil
+}
+
+func (c cli) Exec(ctx context.Context, containerID string, opts ExecOptions, command ...string) (*ExecResult, error) {
+	opts.Interactive = opts.Interactive || opts.Stdin != nil
+	opts.TTY, opts.EnvFile = false, ""
+	cmd := c.Command(ctx, c.ExecArgs(containerID, opts, command...)...)
+	cmd.Stdin = opts.Stdin
+	cmd.WaitDelay = execWaitDelay
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = execWriters(&stdout, &stderr, opts.Output)
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, fmt.Errorf("%s exec: %w", c.name, err)
+	}
+	exitCode := 0
+	if exitErr != nil {
+		exitCode = exitErr.ExitCode()
+	}
+	return newExecResult(command, stdout.Bytes(), stderr.Bytes(), exitCode)
+}
+
+// WriteFile streams a one-file archive to `cp -`, which extracts it into
+// the destination directory.
+func (c cli) WriteFile(ctx context.Context, containerID, filePath string, data []byte, mode os.FileMode) error {
+	archive, err := fileArchive(filePath, data, mode)
+	if err != nil {
+		return err
+	}
+	cmd := c.Command(ctx, "cp", "-", containerID+":"+path.Dir(filePath))
+	cmd.Stdin = archive
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s cp %s: %w: %s", c.name, filePath, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func (c cli) VolumeMountpoint(ctx context.Context, name string) (string, error) {
+	output, err := c.Command(ctx, "volume", "inspect", "--format", "{{.Mountpoint}}", name).Output()
+	if err != nil {
+		return "", cliError(c.name+" volume inspect "+name, err)
+	}
+	mountpoint := strings.TrimSpace(string(output))
+	if mountpoint == "" {
+		return "", fmt.Errorf("volume %s has no mountpoint", name)
+	}
+	return mountpoint, nil
+}
+
+// Docker is the docker CLI. SAM_DOCKER_CLI_PATH overrides its path.
+type Docker struct{ cli }
+
+// Podman is the podman CLI. SAM_PODMAN_CLI_PATH overrides its path.
+type Podman struct{ cli }
+
+// NewDocker returns the Docker runtime.
+func NewDocker() Docker { return Docker{cli{name: "docker", pathEnv: "SAM_DOCKER_CLI_PATH"}} }
+
+// NewPodman returns the Podman runtime.
+func NewPodman() Podman { return Podman{cli{name: "podman", pathEnv: "SAM_PODMAN_CLI_PATH"}} }
+
+// VolumeCreate passes --ignore: unlike docker, podman volume create fails
+// when the volume already exists.
+func (p Podman) VolumeCreate(ctx context.Context, name string) ([]byte, error) {
+	return p.Command(ctx, "volume", "create", "--ignore", name).CombinedOutput()
+}
+
+// DevcontainerArgs points the devcontainer CLI at podman; it runs docker
+// otherwise.
+func (p Podman) DevcontainerArgs() []string {
+	return []string{"--docker-path", p.name}
+}
+
+// Runtime names accepted by Detect.
+const (
+	NameAuto   = "auto"
+	NameDocker = "docker"
+	NamePodman = "podman"
+)
+
+// lookPath is replaced in tests.
+var lookPath = exec.LookPath
+
+// Detect returns the runtime for a CONTAINER_RUNTIME value. "auto" (or
+// empty) prefers docker and uses podman only when docker is not installed;
+// when neither is found it returns Docker, which provisioning installs.
+func Detect(name string) (Runtime, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case NameDocker:
+		return NewDocker(), nil
+	case NamePodman:
+		return NewPodman(), nil
+	case "", NameAuto:
+		if _, err := lookPath(NameDocker); err == nil {
+			return NewDocker(), nil
+		}
+		if _, err := lookPath(NamePodman); err == nil {
+			return NewPodman(), nil
+		}
+		return NewDocker(), nil
+	default:
+		return nil, fmt.Errorf("unknown container runtime %q (want auto, docker, or podman)", name)
+	}
+}
+
+var (
+	mu      sync.RWMutex
+	current Runtime = NewDocker()
+)
+
+// Current returns the runtime selected at startup; Docker until Set is called.
+func Current() Runtime {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// Set selects the runtime used by Current and Command.
+func Set(r Runtime) {
+	mu.Lock()
+	defer mu.Unlock()
+	current = r
+}
+
+// Command runs the current runtime's CLI with args.
+func Command(ctx context.Context, args ...string) *exec.Cmd {
+	return Current().Command(ctx, args...)
+}
@@ -0,0 +1,194 @@
+package containerruntime
+
+import (
+	"archive/tar"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func stubLookPath(t *testing.T, installed ...string) {
+	t.Helper()
+	orig := lookPath
+	t.Cleanup(func() { lookPath = orig })
+	lookPath = func(file string) (string, error) {
+		for _, name := range installed {
+			if name == file {
+				return "/usr/bin/" + file, nil
+			}
+		}
+		return "", errors.New("not found")
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     string
+		installed []string
+		want      string
+	}{
+		{"auto prefers docker", "auto", []string{"docker", "podman"}, NameDocker},
+		{"auto falls back to podman", "auto", []string{"podman"}, NamePodman},
+		{"auto with neither installed", "", nil, NameDocker},
+		{"explicit docker", "docker", []string{"podman"}, NameDocker},
+		{"explicit podman", " Podman ", []string{"docker"}, NamePodman},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubLookPath(t, tt.installed...)
+			rt, err := Detect(tt.value)
+			if err != nil {
+				t.Fatalf("Detect(%q): %v", tt.value, err)
+			}
+			if rt.Name() != tt.want {
+				t.Fatalf("Detect(%q) = %s, want %s", tt.value, rt.Name(), tt.want)
+			}
+		})
+	}
+
+	if _, err := Detect("containerd"); err == nil {
+		t.Fatal("Detect(containerd) succeeded, want error")
+	}
+}
+
+func TestExecArgs(t *testing.T) {
+	got := NewPodman().ExecArgs("abc123", ExecOptions{
+		User:        "vscode",
+		WorkDir:     "/workspaces/repo",
+		Env:         []string{"TERM=xterm", "LANG=C.UTF-8"},
+		EnvFile:     "/dev/shm/env",
+		Interactive: true,
+		TTY:         true,
+	}, "/bin/bash", "-l")
+	want := []string{
+		"exec", "-i", "-t", "-u", "vscode", "-w", "/workspaces/repo",
+		"-e", "TERM=xterm", "-e", "LANG=C.UTF-8", "--env-file", "/dev/shm/env",
+		"abc123", "/bin/bash", "-l",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ExecArgs() = %v, want %v", got, want)
+	}
+
+	got = NewDocker().ExecArgs("abc123", ExecOptions{}, "true")
+	if want := []string{"exec", "abc123", "true"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ExecArgs() = %v, want %v", got, want)
+	}
+}
+
+func TestDevcontainerArgs(t *testing.T) {
+	if args := NewDocker().DevcontainerArgs(); len(args) != 0 {
+		t.Fatalf("Docker DevcontainerArgs() = %v, want none", args)
+	}
+	if got, want := NewPodman().DevcontainerArgs(), []string{"--docker-path", "podman"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Podman DevcontainerArgs() = %v, want %v", got, want)
+	}
+}
+
+func TestPathOverride(t *testing.T) {
+	override := filepath.Join(t.TempDir(), "podman")
+	t.Setenv("SAM_PODMAN_CLI_PATH", override)
+	if got := NewPodman().Path(); got != override {
+		t.Fatalf("Path() = %q, want %q", got, override)
+	}
+
+	t.Setenv("SAM_PODMAN_CLI_PATH", "relative/podman")
+	t.Setenv("PATH", "")
+	if got := NewPodman().Path(); got != "/usr/bin/podman" {
+		t.Fatalf("Path() with relative override = %q, want /usr/bin/podman", got)
+	}
+}
+
+func TestSetSelectsCurrent(t *testing.T) {
+	t.Cleanup(func() { Set(NewDocker()) })
+	Set(NewPodman())
+	if got := Current().Name(); got != NamePodman {
+		t.Fatalf("Current() = %s, want podman", got)
+	}
+	if cmd := Command(t.Context(), "ps"); filepath.Base(cmd.Args[0]) != "podman" {
+		t.Fatalf("Command() runs %q, want podman", cmd.Args[0])
+	}
+}
+
+// fakeCLI puts a docker script first on PATH that appends its arguments to
+// the returned log file before running script.
+func fakeCLI(t *testing.T, script string) string {
+	t.Helper()
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "calls.log")
+	body := "#!/bin/sh\necho \"$@\" >> " + logPath + "\n" + script
+	if err := os.WriteFile(filepath.Join(dir, "docker"), []byte(body), 0o755); err != nil {
+		t.Fatalf("write fake docker: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return logPath
+}
+
+func TestCLIInspect(t *testing.T) {
+	fakeCLI(t, `echo '{"Id":"abc","State":{"Running":true,"Pid":7},"Config":{"User":"node","Labels":{"a":"b"}}}'`)
+
+	info, err := NewDocker().Inspect(t.Context(), "abc")
+	if err != nil {
+		t.Fatalf("Inspect: %v", err)
+	}
+	want := ContainerInfo{ID: "abc", Running: true, Pid: 7, User: "node", Labels: map[string]string{"a": "b"}}
+	if !reflect.DeepEqual(info, want) {
+		t.Fatalf("Inspect = %+v, want %+v", info, want)
+	}
+}
+
+func TestCLIExec(t *testing.T) {
+	logPath := fakeCLI(t, `cat >/dev/null
+echo out
+echo "no such user" >&2
+exit 3
+`)
+
+	result, err := NewDocker().Exec(t.Context(), "abc", ExecOptions{User: "root", Stdin: strings.NewReader("input")}, "id", "-u", "nobody")
+	var execErr *ExecError
+	if !errors.As(err, &execErr) {
+		t.Fatalf("Exec error = %v, want *ExecError", err)
+	}
+	if execErr.ExitCode != 3 || execErr.Stderr != "no such user" {
+		t.Fatalf("ExecError = %+v", execErr)
+	}
+	if string(result.Stdout) != "out\n" || result.ExitCode != 3 {
+		t.Fatalf("result = %+v", result)
+	}
+	calls, _ := os.ReadFile(logPath)
+	if got := strings.TrimSpace(string(calls)); got != "exec -i -u root abc id -u nobody" {
+		t.Fatalf("docker called with %q", got)
+	}
+}
+
+func TestCLIWriteFile(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "archive.tar")
+	logPath := fakeCLI(t, "cat > "+archivePath+"\n")
+
+	if err := NewDocker().WriteFile(t.Context(), "abc", "/usr/bin/gh", []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	calls, _ := os.ReadFile(logPath)
+	if got := strings.TrimSpace(string(calls)); got != "cp - abc:/usr/bin" {
+		t.Fatalf("docker called with %q", got)
+	}
+	archive, err := os.Open(archivePath)
+	if err != nil {
+		t.Fatalf("open archive: %v", err)
+	}
+	defer archive.Close()
+	header, err := tar.NewReader(archive).Next()
+	if err != nil {
+		t.Fatalf("read archive: %v", err)
+	}
+	if header.Name != "gh" || header.Mode != 0o755 {
+		t.Fatalf("archive entry = %s mode %o", header.Name, header.Mode)
+	}
+
+	if err := NewDocker().WriteFile(t.Context(), "abc", "relative/gh", nil, 0o644); err == nil {
+		t.Fatal("WriteFile with a relative path succeeded, want error")
+	}
+}
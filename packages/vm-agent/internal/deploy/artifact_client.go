@@ -36,7 +36,8 @@ func NewArtifactHTTPClient(cfg ArtifactHTTPClientConfig) *http.Client {
 	}
 	return &http.Client{
 		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: config.OutboundTLSConfig(),
 			DialContext: (&net.Dialer{
 				Timeout:   cfg.DialTimeout,
 				KeepAlive: defaultArtifactKeepAlive,
@@ -0,0 +1,282 @@
+// Package diskmon watches filesystem usage on the node — the VM root
+// filesystem and Docker's data root, which holds images, containers and
+// workspace volumes — and classifies it against warning and critical
+// watermarks. Above a separate prune watermark it reclaims space by removing
+// dangling Docker images. The caller runs Check on its own schedule and
+// reports the returned transitions.
+package diskmon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/containerruntime"
+	"github.com/workspace/vm-agent/internal/sysinfo"
+)
+
+// recoveryMargin is how many percentage points usage must fall below a
+// watermark before its level is cleared, so usage hovering around a
+// watermark does not report a crossing on every check.
+const recoveryMargin = 2.0
+
+// Level classifies the usage of one filesystem.
+type Level string
+
+const (
+	LevelOK       Level = "ok"
+	LevelWarning  Level = "warning"
+	LevelCritical Level = "critical"
+)
+
+// Config holds the watermarks; percentages are of the filesystem size.
+type Config struct {
+	Paths           []string      // Filesystems to watch; paths on the same device are checked once
+	WarnPercent     float64       // Usage at or above which a filesystem is LevelWarning
+	CriticalPercent float64       // Usage at or above which a filesystem is LevelCritical
+	PrunePercent    float64       // Usage at or above which dangling images are pruned; 0 disables
+	PruneCooldown   time.Duration // Min time between prunes
+}
+
+// Usage is the measured usage of one watched filesystem.
+type Usage struct {
+	sysinfo.DiskInfo
+	Level Level `json:"level"`
+}
+
+// Transition is a level change of one filesystem since the previous check.
+type Transition struct {
+	Usage    Usage `json:"usage"`
+	Previous Level `json:"previous"`
+}
+
+// DockerUsage is the space used by Docker objects, as reported by
+// `docker system df`.
+type DockerUsage struct {
+	ImagesBytes      uint64 `json:"imagesBytes"`
+	ContainersBytes  uint64 `json:"containersBytes"`
+	VolumesBytes     uint64 `json:"volumesBytes"`
+	BuildCacheBytes  uint64 `json:"buildCacheBytes"`
+	ReclaimableBytes uint64 `json:"reclaimableBytes"`
+}
+
+// PruneResult describes a prune run by Check.
+type PruneResult struct {
+	MountPath      string  `json:"mountPath"`
+	UsedPercent    float64 `json:"usedPercent"`
+	ReclaimedBytes uint64  `json:"reclaimedBytes"`
+	Err            error   `json:"-"`
+}
+
+// Report is the outcome of one Check.
+type Report struct {
+	Filesystems []Usage
+	Docker      *DockerUsage // nil when Docker is unavailable
+	Transitions []Transition
+	Prune       *PruneResult // nil when no prune ran
+}
+
+// Monitor tracks the level of each watched filesystem between checks.
+type Monitor struct {
+	config Config
+
+	mu        sync.Mutex
+	levels    map[string]Level
+	lastPrune time.Time
+
+	// Replaced in tests.
+	statFS      func(path string) (sysinfo.DiskInfo, uint64, error)
+	dockerUsage func(ctx context.Context) (*DockerUsage, error)
+	prune       func(ctx context.Context) (uint64, error)
+	now         func() time.Time
+}
+
+// New creates a monitor for the given watermarks.
+func New(cfg Config) *Monitor {
+	return &Monitor{
+		config:      cfg,
+		levels:      make(map[string]Level),
+		statFS:      statFilesystem,
+		dockerUsage: dockerSystemDF,
+		prune:       pruneDanglingImages,
+		now:         time.Now,
+	}
+}
+
+// Check measures every watched filesystem, returns the level changes since
+// the previous check and prunes dangling images if a filesystem is above the
+// prune watermark. Paths that cannot be measured (e.g. no Docker data root on
+// this node) are skipped.
+func (m *Monitor) Check(ctx context.Context) Report {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var report Report
+	seen := make(map[uint64]bool, len(m.config.Paths))
+	for _, path := range m.config.Paths {
+		info, device, err := m.statFS(path)
+		if err != nil || seen[device] {
+			continue
+		}
+		seen[device] = true
+
+		previous := m.levels[path]
+		usage := Usage{DiskInfo: info, Level: classify(info.UsedPercent, previous, m.config.WarnPercent, m.config.CriticalPercent)}
+		m.levels[path] = usage.Level
+		report.Filesystems = append(report.Filesystems, usage)
+		if previous == "" {
+			previous = LevelOK
+		}
+		if usage.Level != previous {
+			report.Transitions = append(report.Transitions, Transition{Usage: usage, Previous: previous})
+		}
+	}
+
+	if docker, err := m.dockerUsage(ctx); err == nil {
+		report.Docker = docker
+	}
+
+	if m.config.PrunePercent > 0 && (m.lastPrune.IsZero() || m.now().Sub(m.lastPrune) >= m.config.PruneCooldown) {
+		for _, usage := range report.Filesystems {
+			if usage.UsedPercent < m.config.PrunePercent {
+				continue
+			}
+			m.lastPrune = m.now()
+			reclaimed, err := m.prune(ctx)
+			report.Prune = &PruneResult{
+				MountPath:      usage.MountPath,
+				UsedPercent:    usage.UsedPercent,
+				ReclaimedBytes: reclaimed,
+				Err:            err,
+			}
+			break
+		}
+	}
+
+	return report
+}
+
+// classify returns the level for usedPercent. A filesystem keeps its previous
+// higher level until usage drops recoveryMargin below that level's watermark.
+func classify(usedPercent float64, previous Level, warn, critical float64) Level {
+	switch {
+	case usedPercent >= critical, previous == LevelCritical && usedPercent > critical-recoveryMargin:
+		return LevelCritical
+	case usedPercent >= warn, previous != "" && previous != LevelOK && usedPercent > warn-recoveryMargin:
+		return LevelWarning
+	default:
+		return LevelOK
+	}
+}
+
+// statFilesystem measures the filesystem containing path and returns its
+// device number for deduplication.
+func statFilesystem(path string) (sysinfo.DiskInfo, uint64, error) {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return sysinfo.DiskInfo{}, 0, err
+	}
+	var device uint64
+	if stat, ok := fileInfo.Sys().(*syscall.Stat_t); ok {
+		device = uint64(stat.Dev)
+	}
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return sysinfo.DiskInfo{}, 0, err
+	}
+	return sysinfo.StatFSToDiskInfo(&stat, path), device, nil
+}
+
+// dockerSystemDF reads the per-type totals of `docker system df`.
+func dockerSystemDF(ctx context.Context) (*DockerUsage, error) {
+	output, err := containerruntime.Command(ctx, "system", "df", "--format", "{{json .}}").Output()
+	if err != nil {
+		return nil, fmt.Errorf("docker system df: %w", err)
+	}
+	return parseSystemDF(string(output))
+}
+
+func parseSystemDF(output string) (*DockerUsage, error) {
+	usage := &DockerUsage{}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var row struct {
+			Type        string
+			Size        string
+			Reclaimable string
+		}
+		if err := json.Unmarshal([]byte(line), &row); err != nil {
+			return nil, fmt.Errorf("parse docker system df: %w", err)
+		}
+		size, _ := parseSize(row.Size)
+		// Reclaimable is "1.2GB (50%)".
+		reclaimable, _ := parseSize(strings.Fields(row.Reclaimable + " ")[0])
+		usage.ReclaimableBytes += reclaimable
+		switch row.Type {
+		case "Images":
+			usage.ImagesBytes = size
+		case "Containers":
+			usage.ContainersBytes = size
+		case "Local Volumes":
+			usage.VolumesBytes = size
+		case "Build Cache":
+			usage.BuildCacheBytes = size
+		}
+	}
+	return usage, nil
+}
+
+// pruneDanglingImages removes untagged images, typically left behind by
+// devcontainer rebuilds. Stopped containers are deliberately left alone —
+// unlike `docker system prune` — since a stopped workspace's container is
+// still restarted on resume.
+func pruneDanglingImages(ctx context.Context) (uint64, error) {
+	output, err := containerruntime.Command(ctx, "image", "prune", "--force").CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("docker image prune: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return parsePruneOutput(string(output)), nil
+}
+
+// parsePruneOutput extracts the "Total reclaimed space: 1.2GB" line.
+func parsePruneOutput(output string) uint64 {
+	const prefix = "Total reclaimed space:"
+	for _, line := range strings.Split(output, "\n") {
+		if rest, ok := strings.CutPrefix(strings.TrimSpace(line), prefix); ok {
+			size, _ := parseSize(strings.TrimSpace(rest))
+			return size
+		}
+	}
+	return 0
+}
+
+// sizeUnits are the decimal units Docker uses for human-readable sizes.
+var sizeUnits = []struct {
+	suffix string
+	factor float64
+}{
+	{"kB", 1e3}, {"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12}, {"PB", 1e15}, {"B", 1},
+}
+
+// parseSize parses a Docker size such as "1.234GB" or "0B".
+func parseSize(value string) (uint64, error) {
+	value = strings.TrimSpace(value)
+	for _, unit := range sizeUnits {
+		if number, ok := strings.CutSuffix(value, unit.suffix); ok {
+			n, err := strconv.ParseFloat(number, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid size %q", value)
+			}
+			return uint64(n * unit.factor), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid size %q", value)
+}
@@ -0,0 +1,148 @@
+package diskmon
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/sysinfo"
+)
+
+// newTestMonitor returns a monitor whose filesystems report the percentages
+// in usage, keyed by path. Paths map to devices through devices.
+func newTestMonitor(cfg Config, usage map[string]float64, devices map[string]uint64) (*Monitor, *int) {
+	m := New(cfg)
+	m.statFS = func(path string) (sysinfo.DiskInfo, uint64, error) {
+		percent, ok := usage[path]
+		if !ok {
+			return sysinfo.DiskInfo{}, 0, errors.New("no such file or directory")
+		}
+		return sysinfo.DiskInfo{MountPath: path, UsedPercent: percent}, devices[path], nil
+	}
+	m.dockerUsage = func(context.Context) (*DockerUsage, error) { return nil, errors.New("docker unavailable") }
+	prunes := 0
+	m.prune = func(context.Context) (uint64, error) {
+		prunes++
+		return 512, nil
+	}
+	return m, &prunes
+}
+
+func TestCheckReportsThresholdCrossings(t *testing.T) {
+	t.Parallel()
+	usage := map[string]float64{"/": 50}
+	m, _ := newTestMonitor(Config{Paths: []string{"/", "/var/lib/docker"}, WarnPercent: 80, CriticalPercent: 90}, usage, map[string]uint64{"/": 1})
+
+	if report := m.Check(context.Background()); len(report.Transitions) != 0 || len(report.Filesystems) != 1 {
+		t.Fatalf("first check below watermarks = %+v, want one filesystem and no transitions", report)
+	}
+
+	steps := []struct {
+		percent  float64
+		previous Level
+		level    Level
+	}{
+		{85, LevelOK, LevelWarning},
+		{92, LevelWarning, LevelCritical},
+		{89, "", ""},                      // within the recovery margin: stays critical
+		{87, LevelCritical, LevelWarning}, // 3 points below the critical watermark
+		{79, "", ""},                      // within the recovery margin: stays warning
+		{70, LevelWarning, LevelOK},
+	}
+	for _, step := range steps {
+		usage["/"] = step.percent
+		report := m.Check(context.Background())
+		if step.level == "" {
+			if len(report.Transitions) != 0 {
+				t.Fatalf("at %v%%: transitions = %+v, want none", step.percent, report.Transitions)
+			}
+			continue
+		}
+		if len(report.Transitions) != 1 {
+			t.Fatalf("at %v%%: transitions = %+v, want one", step.percent, report.Transitions)
+		}
+		if got := report.Transitions[0]; got.Previous != step.previous || got.Usage.Level != step.level {
+			t.Fatalf("at %v%%: transition %s -> %s, want %s -> %s", step.percent, got.Previous, got.Usage.Level, step.previous, step.level)
+		}
+	}
+}
+
+func TestCheckDeduplicatesFilesystemsByDevice(t *testing.T) {
+	t.Parallel()
+	m, _ := newTestMonitor(
+		Config{Paths: []string{"/", "/var/lib/docker", "/mnt/data"}, WarnPercent: 80, CriticalPercent: 90},
+		map[string]float64{"/": 85, "/var/lib/docker": 85, "/mnt/data": 95},
+		map[string]uint64{"/": 1, "/var/lib/docker": 1, "/mnt/data": 2},
+	)
+
+	report := m.Check(context.Background())
+	if len(report.Filesystems) != 2 || report.Filesystems[1].MountPath != "/mnt/data" {
+		t.Fatalf("filesystems = %+v, want / and /mnt/data", report.Filesystems)
+	}
+	if len(report.Transitions) != 2 {
+		t.Fatalf("transitions = %+v, want one per filesystem", report.Transitions)
+	}
+}
+
+func TestCheckPrunesAboveWatermarkWithCooldown(t *testing.T) {
+	t.Parallel()
+	usage := map[string]float64{"/": 80}
+	m, prunes := newTestMonitor(Config{Paths: []string{"/"}, WarnPercent: 80, CriticalPercent: 90, PrunePercent: 85, PruneCooldown: time.Hour}, usage, nil)
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	if report := m.Check(context.Background()); report.Prune != nil {
+		t.Fatalf("pruned below the watermark: %+v", report.Prune)
+	}
+
+	usage["/"] = 86
+	report := m.Check(context.Background())
+	if report.Prune == nil || report.Prune.ReclaimedBytes != 512 || report.Prune.MountPath != "/" {
+		t.Fatalf("prune = %+v, want 512 bytes reclaimed on /", report.Prune)
+	}
+
+	m.Check(context.Background())
+	if *prunes != 1 {
+		t.Fatalf("prunes within cooldown = %d, want 1", *prunes)
+	}
+	now = now.Add(time.Hour)
+	m.Check(context.Background())
+	if *prunes != 2 {
+		t.Fatalf("prunes after cooldown = %d, want 2", *prunes)
+	}
+}
+
+func TestParseSystemDF(t *testing.T) {
+	t.Parallel()
+	output := `{"Active":"2","Reclaimable":"1.5GB (60%)","Size":"2.5GB","TotalCount":"4","Type":"Images"}
+{"Active":"1","Reclaimable":"0B (0%)","Size":"12.3kB","TotalCount":"1","Type":"Containers"}
+{"Active":"1","Reclaimable":"0B","Size":"3.2GB","TotalCount":"1","Type":"Local Volumes"}
+{"Active":"0","Reclaimable":"500MB","Size":"500MB","TotalCount":"9","Type":"Build Cache"}
+`
+	usage, err := parseSystemDF(output)
+	if err != nil {
+		t.Fatalf("parseSystemDF: %v", err)
+	}
+	want := DockerUsage{
+		ImagesBytes:      2_500_000_000,
+		ContainersBytes:  12_300,
+		VolumesBytes:     3_200_000_000,
+		BuildCacheBytes:  500_000_000,
+		ReclaimableBytes: 2_000_000_000,
+	}
+	if *usage != want {
+		t.Fatalf("parseSystemDF = %+v, want %+v", *usage, want)
+	}
+}
+
+func TestParsePruneOutput(t *testing.T) {
+	t.Parallel()
+	output := "Deleted Images:\ndeleted: sha256:abc\n\nTotal reclaimed space: 1.25GB\n"
+	if got := parsePruneOutput(output); got != 1_250_000_000 {
+		t.Fatalf("parsePruneOutput = %d, want 1250000000", got)
+	}
+	if got := parsePruneOutput("Total reclaimed space: 0B\n"); got != 0 {
+		t.Fatalf("parsePruneOutput for nothing pruned = %d, want 0", got)
+	}
+}
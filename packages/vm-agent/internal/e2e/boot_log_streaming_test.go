@@ -408,7 +408,7 @@ func TestBootLogStreaming_HealthBeforeBootstrap(t *testing.T) {
 	// Now run bootstrap
 	bootstrapCtx, bootstrapCancel := context.WithTimeout(context.Background(), 5*time.Minute)
 	defer bootstrapCancel()
-	if err := bootstrap.Run(bootstrapCtx, cfg, reporter); err != nil {
+	if _, err := bootstrap.Run(bootstrapCtx, cfg, reporter); err != nil {
 		t.Fatalf("bootstrap.Run: %v", err)
 	}
 
@@ -531,7 +531,7 @@ func TestBootLogStreaming_WebSocketDuringBootstrap(t *testing.T) {
 	// Run bootstrap
 	bootstrapCtx, bootstrapCancel := context.WithTimeout(context.Background(), 5*time.Minute)
 	defer bootstrapCancel()
-	if err := bootstrap.Run(bootstrapCtx, cfg, reporter); err != nil {
+	if _, err := bootstrap.Run(bootstrapCtx, cfg, reporter); err != nil {
 		t.Fatalf("bootstrap.Run: %v", err)
 	}
 
@@ -639,7 +639,7 @@ func TestBootLogStreaming_LateJoinCatchUp(t *testing.T) {
 	// Run bootstrap to completion FIRST (no WS client connected yet)
 	bootstrapCtx, bootstrapCancel := context.WithTimeout(context.Background(), 5*time.Minute)
 	defer bootstrapCancel()
-	if err := bootstrap.Run(bootstrapCtx, cfg, reporter); err != nil {
+	if _, err := bootstrap.Run(bootstrapCtx, cfg, reporter); err != nil {
 		t.Fatalf("bootstrap.Run: %v", err)
 	}
 	srv.UpdateAfterBootstrap(cfg)
@@ -0,0 +1,324 @@
+// Package egress restricts the network destinations a workspace's
+// devcontainer can reach.
+//
+// A Policy is enforced with iptables rules installed from the host in the
+// container's network namespace, so the container needs neither iptables nor
+// NET_ADMIN, and code running in it cannot remove the rules. Domain names are
+// resolved on the host when the policy is applied; callers re-apply the
+// policy periodically to follow DNS changes and container restarts, which
+// start with a fresh network namespace.
+package egress
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/workspace/vm-agent/internal/containerruntime"
+)
+
+// Policy default actions.
+const (
+	ActionAllow = "allow"
+	ActionDeny  = "deny"
+)
+
+// maxEntries bounds the number of allow plus deny entries in a policy.
+const maxEntries = 512
+
+// chainName is the iptables chain holding a container's egress rules.
+const chainName = "SAM-EGRESS"
+
+// Policy is a workspace's egress allowlist or denylist.
+type Policy struct {
+	// Default is the action for destinations no entry matches: "allow" makes
+	// Deny a denylist, "deny" makes Allow an allowlist.
+	Default string `json:"default"`
+	// Allow and Deny list destinations as IP addresses, CIDRs, or domain
+	// names. Deny wins when a destination matches both.
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+var domainPattern = regexp.MustCompile(`^(?i)[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?(\.[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?)+\.?$`)
+
+// Validate checks the default action and that every entry is an IP, CIDR,
+// or domain name. A nil policy is valid.
+func (p *Policy) Validate() error {
+	if p == nil {
+		return nil
+	}
+	switch p.Default {
+	case ActionAllow, ActionDeny:
+	default:
+		return fmt.Errorf("default must be %q or %q, got %q", ActionAllow, ActionDeny, p.Default)
+	}
+	if n := len(p.Allow) + len(p.Deny); n > maxEntries {
+		return fmt.Errorf("too many entries (%d, max %d)", n, maxEntries)
+	}
+	for _, list := range []struct {
+		name    string
+		entries []string
+	}{{"allow", p.Allow}, {"deny", p.Deny}} {
+		for _, entry := range list.entries {
+			if _, _, err := parseEntry(entry); err != nil {
+				return fmt.Errorf("%s: %w", list.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// HasDomains reports whether any entry is a domain name, whose addresses
+// can change after the policy is applied.
+func (p *Policy) HasDomains() bool {
+	if p == nil {
+		return false
+	}
+	for _, entry := range append(append([]string{}, p.Allow...), p.Deny...) {
+		if _, domain, err := parseEntry(entry); err == nil && domain != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// parseEntry returns an entry's network, or its domain name when it is not
+// an IP or CIDR.
+func parseEntry(entry string) (*net.IPNet, string, error) {
+	entry = strings.TrimSpace(entry)
+	if _, network, err := net.ParseCIDR(entry); err == nil {
+		return network, "", nil
+	}
+	if ip := net.ParseIP(entry); ip != nil {
+		return hostNet(ip), "", nil
+	}
+	if domainPattern.MatchString(entry) {
+		return nil, strings.ToLower(strings.TrimSuffix(entry, ".")), nil
+	}
+	return nil, "", fmt.Errorf("%q is not an IP address, CIDR, or domain name", entry)
+}
+
+func hostNet(ip net.IP) *net.IPNet {
+	if v4 := ip.To4(); v4 != nil {
+		return &net.IPNet{IP: v4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+// Resolver looks up a domain's addresses. net.DefaultResolver satisfies it.
+type Resolver interface {
+	LookupIP(ctx context.Context, network, host string) ([]net.IP, error)
+}
+
+// target is a policy with domains resolved, plus the destinations the
+// container always needs.
+type target struct {
+	// gateway is the container's default gateway, i.e. the node, where the
+	// git credential helper and other agent endpoints are reached.
+	gateway net.IP
+	// resolvers are the container's DNS servers; DNS to them is allowed so
+	// names keep resolving under an allowlist.
+	resolvers   []net.IP
+	allow, deny []*net.IPNet
+	defaultDeny bool
+}
+
+// resolve turns the policy's entries into networks. Domains that fail to
+// resolve are skipped with a warning: an unresolvable allow entry grants
+// nothing, and an unresolvable deny entry has no address to block.
+func resolve(ctx context.Context, p *Policy, resolver Resolver) (allow, deny []*net.IPNet) {
+	convert := func(entries []string) []*net.IPNet {
+		var networks []*net.IPNet
+		for _, entry := range entries {
+			network, domain, err := parseEntry(entry)
+			if err != nil {
+				continue
+			}
+			if network != nil {
+				networks = append(networks, network)
+				continue
+			}
+			ips, err := resolver.LookupIP(ctx, "ip", domain)
+			if err != nil {
+				slog.Warn("egress: failed to resolve domain", "domain", domain, "error", err)
+				continue
+			}
+			for _, ip := range ips {
+				networks = append(networks, hostNet(ip))
+			}
+		}
+		return networks
+	}
+	return convert(p.Allow), convert(p.Deny)
+}
+
+// restoreInput renders the iptables-restore input for one address family.
+// Declaring the chain flushes it, so re-applying replaces the old rules.
+func restoreInput(t target, v6 bool) string {
+	family := func(ip net.IP) bool { return (ip.To4() == nil) == v6 }
+	reject := "-j REJECT"
+	if v6 {
+		reject = "-j REJECT --reject-with icmp6-port-unreachable"
+	}
+
+	var b strings.Builder
+	b.WriteString("*filter\n:" + chainName + " - [0:0]\n")
+	rule := func(format string, args ...interface{}) {
+		fmt.Fprintf(&b, "-A "+chainName+" "+format+"\n", args...)
+	}
+	rule("-o lo -j ACCEPT")
+	if t.gateway != nil && family(t.gateway) {
+		rule("-d %s -j ACCEPT", hostNet(t.gateway))
+	}
+	for _, r := range t.resolvers {
+		if family(r) {
+			rule("-d %s -p udp --dport 53 -j ACCEPT", hostNet(r))
+			rule("-d %s -p tcp --dport 53 -j ACCEPT", hostNet(r))
+		}
+	}
+	for _, n := range t.deny {
+		if family(n.IP) {
+			rule("-d %s %s", n, reject)
+		}
+	}
+	rule("-m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT")
+	for _, n := range t.allow {
+		if family(n.IP) {
+			rule("-d %s -j ACCEPT", n)
+		}
+	}
+	if t.defaultDeny {
+		rule(reject)
+	}
+	b.WriteString("COMMIT\n")
+	return b.String()
+}
+
+// runCommand runs a host command with optional stdin. It is a variable so
+// tests can capture the commands Apply runs.
+var runCommand = func(ctx context.Context, stdin string, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %w: %s", name, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// containerPid returns the host PID of a running container's init process.
+var containerPid = func(ctx context.Context, containerID string) (string, error) {
+	info, err := containerruntime.Current().Inspect(ctx, containerID)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect container pid: %w", err)
+	}
+	if info.Pid == 0 {
+		return "", fmt.Errorf("container %s is not running", containerID)
+	}
+	return strconv.Itoa(info.Pid), nil
+}
+
+// procRoot is where containerGateway and containerResolvers read a
+// container's /proc entries; tests point it at a fixture tree.
+var procRoot = "/proc"
+
+// Apply installs p in the container's network namespace, replacing any
+// rules from an earlier Apply. IPv4 rules are required; IPv6 rules are
+// best-effort because many containers have no IPv6 stack.
+func Apply(ctx context.Context, containerID string, p *Policy, resolver Resolver) error {
+	if p == nil {
+		return nil
+	}
+	if err := p.Validate(); err != nil {
+		return fmt.Errorf("invalid egress policy: %w", err)
+	}
+	pid, err := containerPid(ctx, containerID)
+	if err != nil {
+		return err
+	}
+
+	t := target{
+		gateway:     containerGateway(pid),
+		resolvers:   containerResolvers(pid),
+		defaultDeny: p.Default == ActionDeny,
+	}
+	t.allow, t.deny = resolve(ctx, p, resolver)
+
+	if err := applyFamily(ctx, pid, restoreInput(t, false), "iptables"); err != nil {
+		return fmt.Errorf("failed to apply egress policy: %w", err)
+	}
+	if err := applyFamily(ctx, pid, restoreInput(t, true), "ip6tables"); err != nil {
+		slog.Warn("egress: IPv6 rules not applied", "containerID", containerID, "error", err)
+	}
+	slog.Info("Applied egress policy", "containerID", containerID, "default", p.Default,
+		"allow", len(t.allow), "deny", len(t.deny))
+	return nil
+}
+
+// applyFamily loads the chain and hooks it into OUTPUT once.
+func applyFamily(ctx context.Context, pid, input, iptables string) error {
+	nsenter := []string{"--target", pid, "--net"}
+	if err := runCommand(ctx, input, "nsenter", append(nsenter, iptables+"-restore", "--noflush")...); err != nil {
+		return err
+	}
+	if runCommand(ctx, "", "nsenter", append(nsenter, iptables, "-w", "-C", "OUTPUT", "-j", chainName)...) == nil {
+		return nil
+	}
+	return runCommand(ctx, "", "nsenter", append(nsenter, iptables, "-w", "-I", "OUTPUT", "1", "-j", chainName)...)
+}
+
+// containerGateway reads the default IPv4 gateway from the container's
+// routing table, or nil when it has none.
+func containerGateway(pid string) net.IP {
+	data, err := os.ReadFile(filepath.Join(procRoot, pid, "net", "route"))
+	if err != nil {
+		return nil
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[2])
+		if err != nil || len(raw) != 4 {
+			continue
+		}
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, binary.LittleEndian.Uint32(raw))
+		return ip
+	}
+	return nil
+}
+
+// containerResolvers reads the nameservers from the container's
+// /etc/resolv.conf.
+func containerResolvers(pid string) []net.IP {
+	data, err := os.ReadFile(filepath.Join(procRoot, pid, "root", "etc", "resolv.conf"))
+	if err != nil {
+		return nil
+	}
+	var resolvers []net.IP
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			if ip := net.ParseIP(fields[1]); ip != nil {
+				resolvers = append(resolvers, ip)
+			}
+		}
+	}
+	return resolvers
+}
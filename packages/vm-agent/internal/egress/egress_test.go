@@ -0,0 +1,137 @@
+package egress
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type fakeResolver map[string][]net.IP
+
+func (f fakeResolver) LookupIP(_ context.Context, _, host string) ([]net.IP, error) {
+	if ips, ok := f[host]; ok {
+		return ips, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func TestPolicyValidate(t *testing.T) {
+	valid := []*Policy{
+		nil,
+		{Default: ActionAllow},
+		{Default: ActionDeny, Allow: []string{"github.com", "10.0.0.0/8", "2001:db8::1", "registry.npmjs.org."}},
+		{Default: ActionAllow, Deny: []string{"169.254.169.254"}},
+	}
+	for _, p := range valid {
+		if err := p.Validate(); err != nil {
+			t.Errorf("Validate(%+v) = %v", p, err)
+		}
+	}
+	invalid := []*Policy{
+		{},
+		{Default: "block"},
+		{Default: ActionDeny, Allow: []string{"*.github.com"}},
+		{Default: ActionAllow, Deny: []string{"10.0.0.0/33"}},
+		{Default: ActionAllow, Deny: []string{"http://example.com"}},
+	}
+	for _, p := range invalid {
+		if err := p.Validate(); err == nil {
+			t.Errorf("Validate(%+v) accepted an invalid policy", p)
+		}
+	}
+	if !(&Policy{Default: ActionDeny, Allow: []string{"10.0.0.1", "github.com"}}).HasDomains() {
+		t.Error("HasDomains = false for a policy with a domain")
+	}
+}
+
+func TestRestoreInputOrdersRules(t *testing.T) {
+	_, private, _ := net.ParseCIDR("10.0.0.0/8")
+	tgt := target{
+		gateway:     net.ParseIP("172.17.0.1"),
+		resolvers:   []net.IP{net.ParseIP("1.1.1.1")},
+		allow:       []*net.IPNet{hostNet(net.ParseIP("140.82.112.3")), hostNet(net.ParseIP("2001:db8::1"))},
+		deny:        []*net.IPNet{private},
+		defaultDeny: true,
+	}
+	got := restoreInput(tgt, false)
+	want := strings.Join([]string{
+		"*filter",
+		":SAM-EGRESS - [0:0]",
+		"-A SAM-EGRESS -o lo -j ACCEPT",
+		"-A SAM-EGRESS -d 172.17.0.1/32 -j ACCEPT",
+		"-A SAM-EGRESS -d 1.1.1.1/32 -p udp --dport 53 -j ACCEPT",
+		"-A SAM-EGRESS -d 1.1.1.1/32 -p tcp --dport 53 -j ACCEPT",
+		"-A SAM-EGRESS -d 10.0.0.0/8 -j REJECT",
+		"-A SAM-EGRESS -m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT",
+		"-A SAM-EGRESS -d 140.82.112.3/32 -j ACCEPT",
+		"-A SAM-EGRESS -j REJECT",
+		"COMMIT",
+		"",
+	}, "\n")
+	if got != want {
+		t.Fatalf("IPv4 rules:\n%s\nwant:\n%s", got, want)
+	}
+
+	v6 := restoreInput(tgt, true)
+	if !strings.Contains(v6, "-d 2001:db8::1/128 -j ACCEPT") || strings.Contains(v6, "172.17.0.1") {
+		t.Fatalf("IPv6 rules mix address families:\n%s", v6)
+	}
+}
+
+func TestApplyInstallsRulesInContainerNamespace(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "42", "net"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "42", "root", "etc"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	route := "Iface\tDestination\tGateway\tFlags\neth0\t00000000\t010011AC\t0003\neth0\t000011AC\t00000000\t0001\n"
+	if err := os.WriteFile(filepath.Join(root, "42", "net", "route"), []byte(route), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "42", "root", "etc", "resolv.conf"), []byte("nameserver 8.8.8.8\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	origRun, origPid, origRoot := runCommand, containerPid, procRoot
+	t.Cleanup(func() { runCommand, containerPid, procRoot = origRun, origPid, origRoot })
+	procRoot = root
+	containerPid = func(context.Context, string) (string, error) { return "42", nil }
+	var commands, inputs []string
+	runCommand = func(_ context.Context, stdin, name string, args ...string) error {
+		commands = append(commands, name+" "+strings.Join(args, " "))
+		if stdin != "" {
+			inputs = append(inputs, stdin)
+		}
+		if len(args) > 5 && args[5] == "-C" {
+			return errors.New("no such rule")
+		}
+		return nil
+	}
+
+	policy := &Policy{Default: ActionDeny, Allow: []string{"github.com"}}
+	resolver := fakeResolver{"github.com": {net.ParseIP("140.82.112.3")}}
+	if err := Apply(context.Background(), "ctr-1", policy, resolver); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	if len(inputs) != 2 {
+		t.Fatalf("restore inputs = %d, want IPv4 and IPv6", len(inputs))
+	}
+	for _, want := range []string{"-d 172.17.0.1/32 -j ACCEPT", "-d 8.8.8.8/32 -p udp --dport 53", "-d 140.82.112.3/32 -j ACCEPT", "-A SAM-EGRESS -j REJECT"} {
+		if !strings.Contains(inputs[0], want) {
+			t.Errorf("IPv4 rules missing %q:\n%s", want, inputs[0])
+		}
+	}
+	if commands[0] != "nsenter --target 42 --net iptables-restore --noflush" {
+		t.Errorf("first command = %q", commands[0])
+	}
+	if commands[2] != "nsenter --target 42 --net iptables -w -I OUTPUT 1 -j SAM-EGRESS" {
+		t.Errorf("jump command = %q", commands[2])
+	}
+}
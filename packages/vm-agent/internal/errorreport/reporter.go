@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/workspace/vm-agent/internal/config"
+	"github.com/workspace/vm-agent/internal/redact"
 )
 
 // ErrorEntry represents a single error to report to the control plane.
@@ -84,6 +85,7 @@ func (r *Reporter) SetToken(token string) {
 	if r == nil {
 		return
 	}
+	redact.Register(token)
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.authToken = token
@@ -117,6 +119,7 @@ func (r *Reporter) Report(entry ErrorEntry) {
 	if entry.Timestamp == "" {
 		entry.Timestamp = time.Now().UTC().Format(time.RFC3339)
 	}
+	redactEntry(&entry)
 
 	r.mu.Lock()
 	if len(r.queue) >= r.config.MaxQueueSize {
@@ -133,6 +136,24 @@ func (r *Reporter) Report(entry ErrorEntry) {
 	}
 }
 
+// redactEntry scrubs registered secrets from the entry's text and string
+// context values. The context map is copied, not modified.
+func redactEntry(entry *ErrorEntry) {
+	entry.Message = redact.String(entry.Message)
+	entry.Stack = redact.String(entry.Stack)
+	if len(entry.Context) == 0 {
+		return
+	}
+	ctx := make(map[string]interface{}, len(entry.Context))
+	for key, value := range entry.Context {
+		if s, ok := value.(string); ok {
+			value = redact.String(s)
+		}
+		ctx[key] = value
+	}
+	entry.Context = ctx
+}
+
 // ReportError is a convenience method that creates an ErrorEntry from an error.
 func (r *Reporter) ReportError(err error, source, workspaceID string, ctx map[string]interface{}) {
 	if r == nil {
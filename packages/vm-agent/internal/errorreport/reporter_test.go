@@ -9,6 +9,8 @@ import (
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/workspace/vm-agent/internal/redact"
 )
 
 func TestNilReporterSafe(t *testing.T) {
@@ -372,3 +374,36 @@ func TestDefaultConfig(t *testing.T) {
 		t.Errorf("expected default HTTP timeout 10s, got %v", r.config.HTTPTimeout)
 	}
 }
+
+func TestReportRedactsRegisteredSecrets(t *testing.T) {
+	r := New("http://localhost", "node-1", "node-callback-token", Config{
+		FlushInterval: 1 * time.Hour,
+		MaxBatchSize:  100,
+		MaxQueueSize:  50,
+	})
+	r.SetToken("node-callback-token")
+	redact.Register("sk-errorreport-secret")
+
+	ctx := map[string]interface{}{"output": "key sk-errorreport-secret", "attempt": 2}
+	r.Report(ErrorEntry{
+		Message: "agent failed with sk-errorreport-secret",
+		Stack:   "Authorization: Bearer node-callback-token",
+		Context: ctx,
+	})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry := r.queue[0]
+	if entry.Message != "agent failed with ***" {
+		t.Errorf("Message = %q, want secret redacted", entry.Message)
+	}
+	if entry.Stack != "Authorization: Bearer ***" {
+		t.Errorf("Stack = %q, want token redacted", entry.Stack)
+	}
+	if entry.Context["output"] != "key ***" || entry.Context["attempt"] != 2 {
+		t.Errorf("Context = %v, want string values redacted", entry.Context)
+	}
+	if ctx["output"] != "key sk-errorreport-secret" {
+		t.Error("caller's context map was modified")
+	}
+}
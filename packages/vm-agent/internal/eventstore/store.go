@@ -8,9 +8,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/workspace/vm-agent/internal/retention"
 	_ "modernc.org/sqlite"
 )
 
@@ -84,6 +86,22 @@ func migrate(db *sql.DB) error {
 		CREATE INDEX IF NOT EXISTS idx_events_level ON events(level, created_at);
 		CREATE INDEX IF NOT EXISTS idx_events_type_created ON events(type, created_at);
 	`)
+	if err != nil {
+		return err
+	}
+
+	// uploaded_at records when an event was accepted by the control plane;
+	// NULL means it still has to be uploaded.
+	var hasUploadedAt int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('events') WHERE name = 'uploaded_at'`).Scan(&hasUploadedAt); err != nil {
+		return err
+	}
+	if hasUploadedAt == 0 {
+		if _, err := db.Exec(`ALTER TABLE events ADD COLUMN uploaded_at TEXT`); err != nil {
+			return err
+		}
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_events_pending_upload ON events(uploaded_at, created_at)`)
 	return err
 }
 
@@ -158,6 +176,111 @@ func (s *Store) ListByTypePrefix(prefix string, limit int) ([]EventRecord, error
 	return scanEvents(rows)
 }
 
+// Query filters events. Zero fields do not filter.
+type Query struct {
+	// WorkspaceID restricts results to one workspace.
+	WorkspaceID string
+	// Since keeps events created at or after this time.
+	Since time.Time
+	// Levels keeps events with any of these levels.
+	Levels []string
+	// Types keeps events with any of these types. A type ending in "*"
+	// matches by prefix.
+	Types []string
+	// Limit caps the number of events returned. Defaults to 100.
+	Limit int
+}
+
+// List returns the events matching q, newest first.
+func (s *Store) List(q Query) ([]EventRecord, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+
+	var where []string
+	var args []interface{}
+	if q.WorkspaceID != "" {
+		where = append(where, "workspace_id = ?")
+		args = append(args, q.WorkspaceID)
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, q.Since.UTC().Format(time.RFC3339))
+	}
+	if len(q.Levels) > 0 {
+		where = append(where, "level IN ("+strings.TrimSuffix(strings.Repeat("?,", len(q.Levels)), ",")+")")
+		for _, level := range q.Levels {
+			args = append(args, level)
+		}
+	}
+	if len(q.Types) > 0 {
+		clauses := make([]string, 0, len(q.Types))
+		for _, eventType := range q.Types {
+			if prefix, ok := strings.CutSuffix(eventType, "*"); ok {
+				clauses = append(clauses, "substr(type, 1, ?) = ?")
+				args = append(args, len(prefix), prefix)
+				continue
+			}
+			clauses = append(clauses, "type = ?")
+			args = append(args, eventType)
+		}
+		where = append(where, "("+strings.Join(clauses, " OR ")+")")
+	}
+
+	query := `SELECT id, node_id, workspace_id, level, type, message, detail, created_at FROM events`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY created_at DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanEvents(rows)
+}
+
+// PendingUpload returns up to limit events not yet uploaded to the control
+// plane, oldest first.
+func (s *Store) PendingUpload(limit int) ([]EventRecord, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	rows, err := s.db.Query(
+		`SELECT id, node_id, workspace_id, level, type, message, detail, created_at
+		 FROM events WHERE uploaded_at IS NULL ORDER BY created_at ASC LIMIT ?`, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanEvents(rows)
+}
+
+// MarkUploaded records that the control plane accepted the given events.
+func (s *Store) MarkUploaded(ids []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	for start := 0; start < len(ids); start += deleteBatchSize {
+		batch := ids[start:min(start+deleteBatchSize, len(ids))]
+		args := make([]interface{}, 0, len(batch)+1)
+		args = append(args, now)
+		for _, id := range batch {
+			args = append(args, id)
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(batch)), ",")
+		if _, err := s.db.Exec(`UPDATE events SET uploaded_at = ? WHERE id IN (`+placeholders+`)`, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func scanEvents(rows *sql.Rows) ([]EventRecord, error) {
 	var events []EventRecord
 	for rows.Next() {
@@ -184,6 +307,58 @@ func (s *Store) trimOlderThan(d time.Duration) (int64, error) {
 	return result.RowsAffected()
 }
 
+// RetentionItems lists a workspace's events with their approximate stored
+// size, for retention policy enforcement.
+func (s *Store) RetentionItems(workspaceID string) ([]retention.Item, error) {
+	rows, err := s.db.Query(
+		`SELECT id, length(id) + length(type) + length(message) + coalesce(length(detail), 0), created_at
+		 FROM events WHERE workspace_id = ?`,
+		workspaceID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []retention.Item
+	for rows.Next() {
+		var item retention.Item
+		var createdAt string
+		if err := rows.Scan(&item.ID, &item.Bytes, &createdAt); err != nil {
+			return nil, err
+		}
+		item.CreatedAt = retention.ParseTimestamp(createdAt)
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// DeleteRetentionItems deletes a workspace's events by ID.
+func (s *Store) DeleteRetentionItems(workspaceID string, ids []string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for start := 0; start < len(ids); start += deleteBatchSize {
+		batch := ids[start:min(start+deleteBatchSize, len(ids))]
+		args := make([]interface{}, 0, len(batch)+1)
+		args = append(args, workspaceID)
+		for _, id := range batch {
+			args = append(args, id)
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(batch)), ",")
+		result, err := s.db.Exec(`DELETE FROM events WHERE workspace_id = ? AND id IN (`+placeholders+`)`, args...)
+		if err != nil {
+			return deleted, err
+		}
+		n, _ := result.RowsAffected()
+		deleted += n
+	}
+	return deleted, nil
+}
+
+// deleteBatchSize bounds the number of bound parameters per DELETE or UPDATE.
+const deleteBatchSize = 500
+
 // Checkpoint forces a WAL checkpoint so the main database file contains all data.
 // Must be called before serving the database file for download.
 func (s *Store) Checkpoint() error {
@@ -1,7 +1,9 @@
 package eventstore
 
 import (
+	"database/sql"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -132,3 +134,105 @@ func TestListByTypePrefix_PreservesDetail(t *testing.T) {
 		t.Errorf("detail.status mismatch: %+v", got[0].Detail)
 	}
 }
+
+func eventIDs(events []EventRecord) []string {
+	ids := make([]string, 0, len(events))
+	for _, e := range events {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+func TestList_Filters(t *testing.T) {
+	s := newTestStore(t)
+	base := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	seed := []EventRecord{
+		{ID: "a", WorkspaceID: "ws-1", Level: "info", Type: "agent.ready", CreatedAt: base.Format(time.RFC3339)},
+		{ID: "b", WorkspaceID: "ws-1", Level: "error", Type: "agent.crashed", CreatedAt: base.Add(time.Minute).Format(time.RFC3339)},
+		{ID: "c", WorkspaceID: "ws-2", Level: "warn", Type: "agent.ready", CreatedAt: base.Add(2 * time.Minute).Format(time.RFC3339)},
+		{ID: "d", WorkspaceID: "ws-1", Level: "warn", Type: "port.detected", CreatedAt: base.Add(3 * time.Minute).Format(time.RFC3339)},
+	}
+	for _, e := range seed {
+		s.Append(e)
+	}
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{name: "all newest first", query: Query{}, want: []string{"d", "c", "b", "a"}},
+		{name: "workspace", query: Query{WorkspaceID: "ws-1"}, want: []string{"d", "b", "a"}},
+		{name: "since is inclusive", query: Query{Since: base.Add(time.Minute)}, want: []string{"d", "c", "b"}},
+		{name: "levels", query: Query{Levels: []string{"warn", "error"}}, want: []string{"d", "c", "b"}},
+		{name: "exact type", query: Query{Types: []string{"agent.ready"}}, want: []string{"c", "a"}},
+		{name: "type prefix", query: Query{WorkspaceID: "ws-1", Types: []string{"agent.*"}}, want: []string{"b", "a"}},
+		{name: "combined with limit", query: Query{Levels: []string{"warn"}, Limit: 1}, want: []string{"d"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.List(tt.query)
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			if ids := eventIDs(got); strings.Join(ids, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("List(%+v) = %v, want %v", tt.query, ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestPendingUpload_MarkUploaded(t *testing.T) {
+	s := newTestStore(t)
+	base := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	for i, id := range []string{"p3", "p1", "p2"} {
+		offset := []time.Duration{2 * time.Second, 0, time.Second}[i]
+		s.Append(EventRecord{ID: id, Level: "info", Type: "t", CreatedAt: base.Add(offset).Format(time.RFC3339)})
+	}
+
+	pending, err := s.PendingUpload(2)
+	if err != nil {
+		t.Fatalf("PendingUpload: %v", err)
+	}
+	if ids := eventIDs(pending); strings.Join(ids, ",") != "p1,p2" {
+		t.Fatalf("PendingUpload = %v, want oldest first [p1 p2]", ids)
+	}
+	if err := s.MarkUploaded(eventIDs(pending)); err != nil {
+		t.Fatalf("MarkUploaded: %v", err)
+	}
+	pending, err = s.PendingUpload(10)
+	if err != nil {
+		t.Fatalf("PendingUpload: %v", err)
+	}
+	if ids := eventIDs(pending); strings.Join(ids, ",") != "p3" {
+		t.Fatalf("PendingUpload after mark = %v, want [p3]", ids)
+	}
+}
+
+func TestNew_MigratesStoreWithoutUploadColumn(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if _, err := db.Exec(`CREATE TABLE events (
+		id TEXT PRIMARY KEY, node_id TEXT NOT NULL DEFAULT '', workspace_id TEXT NOT NULL DEFAULT '',
+		level TEXT NOT NULL DEFAULT 'info', type TEXT NOT NULL DEFAULT '', message TEXT NOT NULL DEFAULT '',
+		detail TEXT, created_at TEXT NOT NULL)`); err != nil {
+		t.Fatalf("create legacy table: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO events (id, created_at) VALUES ('old', ?)`, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		t.Fatalf("insert legacy event: %v", err)
+	}
+	_ = db.Close()
+
+	s, err := New(path)
+	if err != nil {
+		t.Fatalf("New on legacy store: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	pending, err := s.PendingUpload(10)
+	if err != nil || len(pending) != 1 || pending[0].ID != "old" {
+		t.Fatalf("PendingUpload on migrated store = %v, %v; want [old]", eventIDs(pending), err)
+	}
+}
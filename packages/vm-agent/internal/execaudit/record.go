@@ -0,0 +1,108 @@
+package execaudit
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/redact"
+)
+
+// Initiators record which part of the vm-agent ran an operation.
+const (
+	InitiatorBootstrap    = "bootstrap"
+	InitiatorAgentSession = "agent-session"
+	InitiatorUnknown      = "unknown"
+)
+
+// maxErrorLength bounds the error text stored per record.
+const maxErrorLength = 512
+
+var (
+	defaultMu    sync.RWMutex
+	defaultStore *Store
+)
+
+// SetDefault sets the store that Begin records into. Until it is called, or
+// after it is called with nil, operations are only logged.
+func SetDefault(s *Store) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultStore = s
+}
+
+type actorKey struct{}
+
+type actor struct {
+	workspaceID string
+	initiator   string
+}
+
+// WithActor returns a context whose privileged operations are recorded for
+// workspaceID on behalf of initiator.
+func WithActor(ctx context.Context, workspaceID, initiator string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor{workspaceID: workspaceID, initiator: initiator})
+}
+
+// Op is a privileged operation in progress. Call End with its result.
+type Op struct {
+	entry   Entry
+	started time.Time
+}
+
+// Begin starts recording a privileged operation in containerID. The
+// workspace and initiator come from ctx; see WithActor.
+func Begin(ctx context.Context, containerID, operation, target string) *Op {
+	a, _ := ctx.Value(actorKey{}).(actor)
+	if a.initiator == "" {
+		a.initiator = InitiatorUnknown
+	}
+	return &Op{
+		entry: Entry{
+			WorkspaceID: a.workspaceID,
+			ContainerID: containerID,
+			Operation:   operation,
+			Target:      redact.String(target),
+			Initiator:   a.initiator,
+		},
+		started: time.Now(),
+	}
+}
+
+// End records the operation with the error returned by the command, if any,
+// and returns err unchanged so it can wrap a return statement.
+func (op *Op) End(err error) error {
+	e := op.entry
+	e.StartedAt = op.started.UTC().Format(time.RFC3339Nano)
+	e.DurationMs = time.Since(op.started).Milliseconds()
+	e.Result = ResultSucceeded
+	if err != nil {
+		e.Result = ResultFailed
+		e.ExitCode = -1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			e.ExitCode = exitErr.ExitCode()
+		}
+		e.Error = redact.String(err.Error())
+		if len(e.Error) > maxErrorLength {
+			e.Error = e.Error[:maxErrorLength]
+		}
+	}
+
+	slog.Info("Privileged container exec",
+		"operation", e.Operation, "target", e.Target, "initiator", e.Initiator,
+		"workspace", e.WorkspaceID, "container", e.ContainerID, "result", e.Result, "exitCode", e.ExitCode)
+
+	defaultMu.RLock()
+	store := defaultStore
+	defaultMu.RUnlock()
+	if store != nil {
+		if _, storeErr := store.Add(e); storeErr != nil {
+			slog.Warn("Failed to record privileged exec in audit log", "operation", e.Operation, "error", storeErr)
+		}
+	}
+	return err
+}
@@ -0,0 +1,298 @@
+// Package execaudit provides a SQLite-backed audit trail of privileged
+// operations the vm-agent runs inside workspace containers with
+// `docker exec -u root`: what was done (operation and target), on whose
+// behalf (initiator), and whether it succeeded. Records are shipped upstream
+// to the control plane and retained locally for a bounded period so security
+// teams have evidence of what the agent did with root in the container.
+package execaudit
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/retention"
+	_ "modernc.org/sqlite"
+)
+
+// Results recorded for an operation.
+const (
+	ResultSucceeded = "succeeded"
+	ResultFailed    = "failed"
+)
+
+// Entry is a single privileged operation.
+type Entry struct {
+	ID          string `json:"id"`
+	WorkspaceID string `json:"workspaceId"`
+	ContainerID string `json:"containerId"`
+	// Operation names what was done, e.g. "workspace.chown"; Target is what
+	// it was done to, e.g. a path or package list.
+	Operation string `json:"operation"`
+	Target    string `json:"target,omitempty"`
+	// Initiator is the vm-agent component that ran the operation, e.g.
+	// "bootstrap" or "agent-session".
+	Initiator  string `json:"initiator"`
+	Result     string `json:"result"`
+	ExitCode   int    `json:"exitCode"`
+	Error      string `json:"error,omitempty"`
+	StartedAt  string `json:"startedAt"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// Query filters records. WorkspaceID is required.
+type Query struct {
+	WorkspaceID string
+	Operation   string
+	Initiator   string
+	// Since limits results to records started at or after this time.
+	Since time.Time
+	Limit int
+}
+
+// Store is a SQLite-backed privileged exec audit store.
+type Store struct {
+	db *sql.DB
+	mu sync.Mutex // serializes writes
+}
+
+// New opens (or creates) a SQLite exec audit store at the given path.
+// Records started longer ago than retention are trimmed on open (zero keeps
+// everything).
+func New(dbPath string, retention time.Duration) (*Store, error) {
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?cache=shared&mode=rwc&_journal_mode=WAL", dbPath))
+	if err != nil {
+		return nil, fmt.Errorf("execaudit: open: %w", err)
+	}
+	for _, pragma := range []string{
+		"PRAGMA journal_mode=WAL",
+		"PRAGMA busy_timeout=5000",
+		"PRAGMA synchronous=NORMAL",
+	} {
+		if _, err := db.Exec(pragma); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("execaudit: %s: %w", pragma, err)
+		}
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("execaudit: migrate: %w", err)
+	}
+
+	if retention > 0 {
+		cutoff := time.Now().UTC().Add(-retention).Format(time.RFC3339Nano)
+		if result, err := db.Exec(`DELETE FROM privileged_exec WHERE started_at < ?`, cutoff); err != nil {
+			slog.Warn("execaudit: trim on startup failed", "error", err)
+		} else if n, _ := result.RowsAffected(); n > 0 {
+			slog.Info("execaudit: trimmed old records on startup", "deleted", n)
+		}
+	}
+	return &Store{db: db}, nil
+}
+
+func migrate(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS privileged_exec (
+			id           TEXT PRIMARY KEY,
+			workspace_id TEXT NOT NULL DEFAULT '',
+			container_id TEXT NOT NULL DEFAULT '',
+			operation    TEXT NOT NULL,
+			target       TEXT NOT NULL DEFAULT '',
+			initiator    TEXT NOT NULL DEFAULT '',
+			result       TEXT NOT NULL,
+			exit_code    INTEGER NOT NULL DEFAULT 0,
+			error        TEXT NOT NULL DEFAULT '',
+			started_at   TEXT NOT NULL,
+			duration_ms  INTEGER NOT NULL DEFAULT 0,
+			shipped      INTEGER NOT NULL DEFAULT 0
+		);
+		CREATE INDEX IF NOT EXISTS idx_privileged_exec_workspace ON privileged_exec(workspace_id, started_at);
+		CREATE INDEX IF NOT EXISTS idx_privileged_exec_unshipped ON privileged_exec(shipped, started_at);
+	`)
+	return err
+}
+
+// Add stores a record and returns it. ID and StartedAt are filled in when
+// empty.
+func (s *Store) Add(e Entry) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e.ID == "" {
+		e.ID = newExecID()
+	}
+	if e.StartedAt == "" {
+		e.StartedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	if _, err := s.db.Exec(
+		`INSERT INTO privileged_exec (id, workspace_id, container_id, operation, target, initiator, result, exit_code, error, started_at, duration_ms)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.ID, e.WorkspaceID, e.ContainerID, e.Operation, e.Target, e.Initiator, e.Result, e.ExitCode, e.Error, e.StartedAt, e.DurationMs,
+	); err != nil {
+		return Entry{}, fmt.Errorf("execaudit: insert: %w", err)
+	}
+	return e, nil
+}
+
+// List returns records matching the query, newest first.
+func (s *Store) List(q Query) ([]Entry, error) {
+	var where []string
+	var args []interface{}
+	where = append(where, "workspace_id = ?")
+	args = append(args, q.WorkspaceID)
+	if q.Operation != "" {
+		where = append(where, "operation = ?")
+		args = append(args, q.Operation)
+	}
+	if q.Initiator != "" {
+		where = append(where, "initiator = ?")
+		args = append(args, q.Initiator)
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "started_at >= ?")
+		args = append(args, q.Since.UTC().Format(time.RFC3339Nano))
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	args = append(args, limit)
+
+	rows, err := s.db.Query(
+		`SELECT `+entryColumns+` FROM privileged_exec WHERE `+strings.Join(where, " AND ")+
+			` ORDER BY started_at DESC, id DESC LIMIT ?`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanEntries(rows)
+}
+
+// Unshipped returns up to limit records of workspaces that have not yet been
+// shipped upstream, oldest first. Node-level records without a workspace are
+// kept locally only.
+func (s *Store) Unshipped(limit int) ([]Entry, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	rows, err := s.db.Query(
+		`SELECT `+entryColumns+` FROM privileged_exec WHERE shipped = 0 AND workspace_id != ''
+		 ORDER BY started_at ASC, id ASC LIMIT ?`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanEntries(rows)
+}
+
+// MarkShipped flags records as delivered upstream.
+func (s *Store) MarkShipped(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	if _, err := s.db.Exec(`UPDATE privileged_exec SET shipped = 1 WHERE id IN (`+placeholders+`)`, args...); err != nil {
+		return fmt.Errorf("execaudit: mark shipped: %w", err)
+	}
+	return nil
+}
+
+// RetentionItems lists a workspace's records with their approximate stored
+// size, for retention policy enforcement.
+func (s *Store) RetentionItems(workspaceID string) ([]retention.Item, error) {
+	rows, err := s.db.Query(
+		`SELECT id, length(id) + length(container_id) + length(operation) + length(target) + length(initiator) +
+			length(result) + length(error) + length(started_at),
+			started_at
+		 FROM privileged_exec WHERE workspace_id = ?`,
+		workspaceID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []retention.Item
+	for rows.Next() {
+		var item retention.Item
+		var startedAt string
+		if err := rows.Scan(&item.ID, &item.Bytes, &startedAt); err != nil {
+			return nil, err
+		}
+		item.CreatedAt = retention.ParseTimestamp(startedAt)
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// DeleteRetentionItems deletes a workspace's records by ID.
+func (s *Store) DeleteRetentionItems(workspaceID string, ids []string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for start := 0; start < len(ids); start += deleteBatchSize {
+		batch := ids[start:min(start+deleteBatchSize, len(ids))]
+		args := make([]interface{}, 0, len(batch)+1)
+		args = append(args, workspaceID)
+		for _, id := range batch {
+			args = append(args, id)
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(batch)), ",")
+		result, err := s.db.Exec(
+			`DELETE FROM privileged_exec WHERE workspace_id = ? AND id IN (`+placeholders+`)`, args...,
+		)
+		if err != nil {
+			return deleted, fmt.Errorf("execaudit: delete: %w", err)
+		}
+		n, _ := result.RowsAffected()
+		deleted += n
+	}
+	return deleted, nil
+}
+
+// deleteBatchSize bounds the number of bound parameters per DELETE.
+const deleteBatchSize = 500
+
+// Close closes the underlying database connection.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+const entryColumns = `id, workspace_id, container_id, operation, target, initiator, result, exit_code, error,
+	started_at, duration_ms`
+
+func scanEntries(rows *sql.Rows) ([]Entry, error) {
+	defer rows.Close()
+	entries := []Entry{}
+	for rows.Next() {
+		var e Entry
+		if err := rows.Scan(
+			&e.ID, &e.WorkspaceID, &e.ContainerID, &e.Operation, &e.Target, &e.Initiator, &e.Result, &e.ExitCode, &e.Error,
+			&e.StartedAt, &e.DurationMs,
+		); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func newExecID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return "exec-" + hex.EncodeToString(b)
+}
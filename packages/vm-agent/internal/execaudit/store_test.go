@@ -0,0 +1,144 @@
+package execaudit
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/redact"
+)
+
+func newTestStore(t *testing.T, dbPath string) *Store {
+	t.Helper()
+	if dbPath == "" {
+		dbPath = filepath.Join(t.TempDir(), "exec-audit.db")
+	}
+	s, err := New(dbPath, 0)
+	if err != nil {
+		t.Fatalf("execaudit.New: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func TestBeginEndRecordsIntoDefaultStore(t *testing.T) {
+	s := newTestStore(t, "")
+	SetDefault(s)
+	t.Cleanup(func() { SetDefault(nil) })
+
+	redact.Register("ghs_secrettoken")
+	ctx := WithActor(context.Background(), "ws-1", InitiatorBootstrap)
+	if err := Begin(ctx, "ctr-1", "workspace.chown", "/workspaces 1000:1000").End(nil); err != nil {
+		t.Fatalf("End(nil) = %v", err)
+	}
+	exitErr := exec.Command("sh", "-c", "exit 3").Run()
+	if got := Begin(ctx, "ctr-1", "env.write", "token=ghs_secrettoken").End(exitErr); got != exitErr {
+		t.Fatalf("End returned %v, want the command error", got)
+	}
+	if err := Begin(context.Background(), "ctr-2", "agent.install", "claude").End(errors.New("no such container")); err == nil {
+		t.Fatal("End dropped the error")
+	}
+
+	entries, err := s.List(Query{WorkspaceID: "ws-1"})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("entries = %+v, want 2", entries)
+	}
+	byOp := map[string]Entry{}
+	for _, e := range entries {
+		byOp[e.Operation] = e
+	}
+	chown := byOp["workspace.chown"]
+	if chown.Result != ResultSucceeded || chown.Initiator != InitiatorBootstrap || chown.ContainerID != "ctr-1" || chown.ExitCode != 0 {
+		t.Fatalf("chown entry = %+v", chown)
+	}
+	env := byOp["env.write"]
+	if env.Result != ResultFailed || env.ExitCode != 3 || env.Error == "" {
+		t.Fatalf("env entry = %+v", env)
+	}
+	if strings.Contains(env.Target, "ghs_secrettoken") {
+		t.Fatalf("target was not redacted: %q", env.Target)
+	}
+
+	// Operations without an actor are kept locally but never shipped.
+	unknown, err := s.List(Query{WorkspaceID: ""})
+	if err != nil || len(unknown) != 1 || unknown[0].Initiator != InitiatorUnknown || unknown[0].ExitCode != -1 {
+		t.Fatalf("unattributed entries = %+v, err = %v", unknown, err)
+	}
+	pending, err := s.Unshipped(10)
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("Unshipped = %+v, err = %v", pending, err)
+	}
+}
+
+func TestListFiltersAndShipping(t *testing.T) {
+	s := newTestStore(t, "")
+	base := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	for i, op := range []string{"workspace.chown", "git.system_config", "git.system_config"} {
+		initiator := InitiatorBootstrap
+		if i == 2 {
+			initiator = InitiatorAgentSession
+		}
+		if _, err := s.Add(Entry{
+			WorkspaceID: "ws-1", Operation: op, Initiator: initiator, Result: ResultSucceeded,
+			StartedAt: base.Add(time.Duration(i) * time.Minute).Format(time.RFC3339Nano),
+		}); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	list, err := s.List(Query{WorkspaceID: "ws-1", Operation: "git.system_config"})
+	if err != nil || len(list) != 2 || list[0].Initiator != InitiatorAgentSession {
+		t.Fatalf("List(operation) = %+v, err = %v", list, err)
+	}
+	list, err = s.List(Query{WorkspaceID: "ws-1", Initiator: InitiatorBootstrap, Since: base.Add(30 * time.Second)})
+	if err != nil || len(list) != 1 || list[0].Operation != "git.system_config" {
+		t.Fatalf("List(initiator, since) = %+v, err = %v", list, err)
+	}
+
+	pending, err := s.Unshipped(2)
+	if err != nil || len(pending) != 2 || pending[0].Operation != "workspace.chown" {
+		t.Fatalf("Unshipped = %+v, err = %v", pending, err)
+	}
+	if err := s.MarkShipped([]string{pending[0].ID, pending[1].ID}); err != nil {
+		t.Fatalf("MarkShipped: %v", err)
+	}
+	if pending, _ = s.Unshipped(10); len(pending) != 1 {
+		t.Fatalf("Unshipped after MarkShipped = %+v", pending)
+	}
+
+	items, err := s.RetentionItems("ws-1")
+	if err != nil || len(items) != 3 || items[0].Bytes == 0 {
+		t.Fatalf("RetentionItems = %+v, err = %v", items, err)
+	}
+	if n, err := s.DeleteRetentionItems("ws-1", []string{items[0].ID}); err != nil || n != 1 {
+		t.Fatalf("DeleteRetentionItems = %d, %v", n, err)
+	}
+}
+
+func TestNewTrimsExpiredRecords(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "exec-audit.db")
+	s := newTestStore(t, dbPath)
+	for _, startedAt := range []time.Time{time.Now().Add(-48 * time.Hour), time.Now()} {
+		if _, err := s.Add(Entry{WorkspaceID: "ws-1", Operation: "env.write", Result: ResultSucceeded, StartedAt: startedAt.UTC().Format(time.RFC3339Nano)}); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	_ = s.Close()
+
+	reopened, err := New(dbPath, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer reopened.Close()
+	list, err := reopened.List(Query{WorkspaceID: "ws-1"})
+	if err != nil || len(list) != 1 {
+		t.Fatalf("List after trim = %+v, err = %v", list, err)
+	}
+}
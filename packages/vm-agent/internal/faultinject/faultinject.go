@@ -0,0 +1,149 @@
+// Package faultinject provides deterministic failure injection for integration
+// testing of the vm-agent's retry and fallback paths. It is disabled unless
+// SAM_FAULT_INJECTION is set (or a test calls Configure). While no fault is
+// armed, an injection point costs one atomic load; only armed points take the
+// package lock.
+//
+// The spec is a comma-separated list of point[=count] entries, e.g.
+//
+//	bootstrap.redeem=2,docker.exec=1,agent.start,ws.write=3
+//
+// A count arms the point for exactly that many calls, after which it passes
+// through normally; omitting the count fails every call. This keeps failure
+// sequences deterministic so tests can assert on retry counts and recovery.
+package faultinject
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Point identifies a location in the vm-agent where a failure can be injected.
+type Point string
+
+const (
+	// BootstrapRedeem makes bootstrap token redemption behave as if the
+	// control plane returned HTTP 500 (retryable).
+	BootstrapRedeem Point = "bootstrap.redeem"
+	// DockerExec makes docker exec helpers fail as if the command timed out.
+	DockerExec Point = "docker.exec"
+	// AgentStart replaces the ACP agent command with one that exits
+	// immediately, exercising the rapid-exit crash path.
+	AgentStart Point = "agent.start"
+	// WebSocketWrite makes viewer WebSocket writes fail.
+	WebSocketWrite Point = "ws.write"
+)
+
+// knownPoints lists the valid injection points accepted by Configure.
+var knownPoints = map[Point]bool{
+	BootstrapRedeem: true,
+	DockerExec:      true,
+	AgentStart:      true,
+	WebSocketWrite:  true,
+}
+
+// unlimited marks a point that fails on every call.
+const unlimited = -1
+
+// ErrInjected is wrapped by every error returned from an armed point so
+// callers and tests can distinguish injected failures from real ones.
+var ErrInjected = errors.New("faultinject: injected failure")
+
+var (
+	// enabled mirrors len(armed) > 0 so disarmed checks skip mu.
+	enabled atomic.Bool
+
+	mu     sync.Mutex
+	armed  = map[Point]int{}
+	counts = map[Point]int{}
+)
+
+// Configure replaces the armed faults with those described by spec. An empty
+// spec disarms everything. Unknown points and malformed counts are rejected
+// without changing the current configuration.
+func Configure(spec string) error {
+	next := map[Point]int{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, rawCount, hasCount := strings.Cut(entry, "=")
+		point := Point(strings.TrimSpace(name))
+		if !knownPoints[point] {
+			return fmt.Errorf("faultinject: unknown point %q", point)
+		}
+		count := unlimited
+		if hasCount {
+			n, err := strconv.Atoi(strings.TrimSpace(rawCount))
+			if err != nil || n <= 0 {
+				return fmt.Errorf("faultinject: invalid count %q for point %q", rawCount, point)
+			}
+			count = n
+		}
+		next[point] = count
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	armed = next
+	counts = map[Point]int{}
+	enabled.Store(len(next) > 0)
+	if len(next) > 0 {
+		slog.Warn("Fault injection enabled — do not use in production", "spec", spec)
+	}
+	return nil
+}
+
+// Reset disarms all faults and clears injection counters.
+func Reset() {
+	mu.Lock()
+	defer mu.Unlock()
+	armed = map[Point]int{}
+	counts = map[Point]int{}
+	enabled.Store(false)
+}
+
+// Enabled reports whether any fault is currently armed.
+func Enabled() bool {
+	return enabled.Load()
+}
+
+// Check returns a non-nil error wrapping ErrInjected if a fault is armed for
+// point, consuming one unit of its count. Returns nil when the point is not
+// armed or its count is exhausted.
+func Check(point Point) error {
+	if !enabled.Load() {
+		return nil
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	remaining, ok := armed[point]
+	if !ok {
+		return nil
+	}
+	if remaining != unlimited {
+		if remaining == 1 {
+			delete(armed, point)
+			enabled.Store(len(armed) > 0)
+		} else {
+			armed[point] = remaining - 1
+		}
+	}
+	counts[point]++
+	slog.Warn("Fault injected", "point", string(point), "injection", counts[point])
+	return fmt.Errorf("%w at %s", ErrInjected, point)
+}
+
+// Injected returns how many failures have been injected at point since the
+// last Configure or Reset.
+func Injected(point Point) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return counts[point]
+}
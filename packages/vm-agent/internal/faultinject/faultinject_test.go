@@ -0,0 +1,91 @@
+package faultinject
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckDisarmedByDefault(t *testing.T) {
+	t.Cleanup(Reset)
+	Reset()
+
+	if err := Check(BootstrapRedeem); err != nil {
+		t.Fatalf("expected no fault when disarmed, got %v", err)
+	}
+	if Enabled() {
+		t.Fatal("expected Enabled() to be false when disarmed")
+	}
+}
+
+func TestConfigureCountedFaultsAreDeterministic(t *testing.T) {
+	t.Cleanup(Reset)
+	if err := Configure("bootstrap.redeem=2, ws.write"); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := Check(BootstrapRedeem); !errors.Is(err, ErrInjected) {
+			t.Fatalf("call %d: expected injected failure, got %v", i+1, err)
+		}
+	}
+	if err := Check(BootstrapRedeem); err != nil {
+		t.Fatalf("expected fault to be exhausted after 2 calls, got %v", err)
+	}
+	if got := Injected(BootstrapRedeem); got != 2 {
+		t.Fatalf("Injected(BootstrapRedeem) = %d, want 2", got)
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := Check(WebSocketWrite); err == nil {
+			t.Fatalf("call %d: expected unlimited fault to keep failing", i+1)
+		}
+	}
+	if err := Check(DockerExec); err != nil {
+		t.Fatalf("expected unarmed point to pass, got %v", err)
+	}
+}
+
+func TestExhaustedFaultsDisable(t *testing.T) {
+	t.Cleanup(Reset)
+	if err := Configure("docker.exec=1"); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	if !Enabled() {
+		t.Fatal("expected Enabled() to be true while a fault is armed")
+	}
+	if err := Check(DockerExec); !errors.Is(err, ErrInjected) {
+		t.Fatalf("expected injected failure, got %v", err)
+	}
+	if Enabled() {
+		t.Fatal("expected Enabled() to be false once every fault is exhausted")
+	}
+}
+
+func TestConfigureRejectsInvalidSpecs(t *testing.T) {
+	t.Cleanup(Reset)
+	if err := Configure("docker.exec=1"); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+
+	for _, spec := range []string{"bogus.point", "docker.exec=0", "agent.start=abc"} {
+		if err := Configure(spec); err == nil {
+			t.Fatalf("expected Configure(%q) to fail", spec)
+		}
+	}
+	if err := Check(DockerExec); err == nil {
+		t.Fatal("rejected spec must not replace the existing configuration")
+	}
+}
+
+func TestConfigureEmptySpecDisarms(t *testing.T) {
+	t.Cleanup(Reset)
+	if err := Configure("agent.start"); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	if err := Configure(""); err != nil {
+		t.Fatalf("Configure(\"\"): %v", err)
+	}
+	if Enabled() {
+		t.Fatal("expected empty spec to disarm all faults")
+	}
+}
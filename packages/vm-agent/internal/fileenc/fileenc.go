@@ -0,0 +1,130 @@
+// Package fileenc decides how file content crosses text-only channels such as
+// ACP JSON-RPC messages: as UTF-8 text, or base64 when it is binary.
+package fileenc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// Content encodings.
+const (
+	Text   = "text"
+	Base64 = "base64"
+)
+
+const (
+	// sniffLen is how much of the content IsBinary examines.
+	sniffLen = 8192
+	// legacyTextMaxEntropy is the share of the highest possible byte entropy
+	// above which content that is not valid UTF-8 counts as binary. Text in a
+	// legacy 8-bit charset such as Latin-1 stays well below it; compressed
+	// and compiled data comes close to 1.
+	legacyTextMaxEntropy = 0.75
+)
+
+// ErrUnknownEncoding is returned for an encoding other than Text or Base64.
+var ErrUnknownEncoding = errors.New("unknown content encoding")
+
+// ParseEncoding normalizes a requested encoding. Empty means Text.
+func ParseEncoding(encoding string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", Text, "utf-8", "utf8":
+		return Text, nil
+	case Base64:
+		return Base64, nil
+	default:
+		return "", fmt.Errorf("%w %q: use %q or %q", ErrUnknownEncoding, encoding, Text, Base64)
+	}
+}
+
+// IsBinary reports whether content cannot be carried as text without being
+// corrupted. It looks at the first 8 KiB: a NUL byte makes it binary, and so
+// does invalid UTF-8 with the byte entropy of compressed or compiled data.
+func IsBinary(content []byte) bool {
+	sample := content
+	if len(sample) > sniffLen {
+		sample = sample[:sniffLen]
+		// Drop a multi-byte rune cut off by the end of the sample.
+		for i := len(sample) - 1; i >= len(sample)-utf8.UTFMax+1; i-- {
+			if utf8.RuneStart(sample[i]) {
+				if !utf8.FullRune(sample[i:]) {
+					sample = sample[:i]
+				}
+				break
+			}
+		}
+	}
+	if bytes.IndexByte(sample, 0) >= 0 {
+		return true
+	}
+	if utf8.Valid(sample) {
+		return false
+	}
+	// A sample of n bytes has at most log2(n) bits of entropy per byte.
+	maxEntropy := math.Log2(float64(min(len(sample), 256)))
+	return maxEntropy == 0 || Entropy(sample)/maxEntropy > legacyTextMaxEntropy
+}
+
+// Entropy returns the Shannon entropy of data in bits per byte, from 0 for a
+// single repeated byte to 8 for uniformly random bytes.
+func Entropy(data []byte) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+	entropy := 0.0
+	total := float64(len(data))
+	for _, count := range counts {
+		if count == 0 {
+			continue
+		}
+		p := float64(count) / total
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// SniffMIME returns the media type of content from its leading bytes, using
+// the WHATWG sniffing algorithm. It needs no host MIME database, so it gives
+// the same answer on every runtime.
+func SniffMIME(content []byte) string {
+	return http.DetectContentType(content)
+}
+
+// Encode returns content in the given encoding.
+func Encode(content []byte, encoding string) string {
+	if encoding == Base64 {
+		return base64.StdEncoding.EncodeToString(content)
+	}
+	return string(content)
+}
+
+// Decode returns the bytes of content sent in the given encoding.
+func Decode(content, encoding string) ([]byte, error) {
+	if encoding != Base64 {
+		return []byte(content), nil
+	}
+	data, err := base64.StdEncoding.DecodeString(content)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 content: %w", err)
+	}
+	return data, nil
+}
+
+// DecodedLen returns how many raw bytes fit in n bytes of encoded content.
+func DecodedLen(n int, encoding string) int {
+	if encoding == Base64 {
+		return base64.StdEncoding.DecodedLen(n)
+	}
+	return n
+}
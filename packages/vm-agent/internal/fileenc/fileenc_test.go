@@ -0,0 +1,82 @@
+package fileenc
+
+import (
+	"bytes"
+	"errors"
+	"math/rand/v2"
+	"strings"
+	"testing"
+)
+
+func TestParseEncoding(t *testing.T) {
+	for input, want := range map[string]string{"": Text, "utf-8": Text, "TEXT": Text, " base64 ": Base64} {
+		got, err := ParseEncoding(input)
+		if err != nil || got != want {
+			t.Errorf("ParseEncoding(%q) = %q, %v; want %q", input, got, err, want)
+		}
+	}
+	if _, err := ParseEncoding("hex"); !errors.Is(err, ErrUnknownEncoding) {
+		t.Fatalf("ParseEncoding(hex) error = %v, want ErrUnknownEncoding", err)
+	}
+}
+
+func TestIsBinary(t *testing.T) {
+	random := make([]byte, 4096)
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := range random {
+		random[i] = byte(rng.IntN(255) + 1) // no NUL bytes
+	}
+	// "é" is 0xC3 0xA9 in UTF-8; cut a long text right after the 0xC3.
+	cutRune := append(bytes.Repeat([]byte("a"), sniffLen-1), "é"...)
+
+	tests := []struct {
+		name    string
+		content []byte
+		want    bool
+	}{
+		{"empty", nil, false},
+		{"source code", []byte("package main\n\nfunc main() {}\n"), false},
+		{"utf-8 text", []byte("Grüße, 世界 🌍\n"), false},
+		{"latin-1 text", []byte(strings.Repeat("caf\xe9 cr\xe8me br\xfbl\xe9e, ", 20)), false},
+		{"rune cut by the sample end", cutRune, false},
+		{"nul byte", []byte("text\x00more"), true},
+		{"png header", []byte("\x89PNG\r\n\x1a\n"), true},
+		{"random bytes", random, true},
+	}
+	for _, tt := range tests {
+		if got := IsBinary(tt.content); got != tt.want {
+			t.Errorf("%s: IsBinary() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEntropy(t *testing.T) {
+	if got := Entropy(bytes.Repeat([]byte("x"), 100)); got != 0 {
+		t.Fatalf("Entropy(repeated byte) = %v, want 0", got)
+	}
+	all := make([]byte, 256)
+	for i := range all {
+		all[i] = byte(i)
+	}
+	if got := Entropy(all); got != 8 {
+		t.Fatalf("Entropy(every byte once) = %v, want 8", got)
+	}
+}
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	content := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	encoded := Encode(content, Base64)
+	decoded, err := Decode(encoded, Base64)
+	if err != nil || !bytes.Equal(decoded, content) {
+		t.Fatalf("Decode(Encode()) = %q, %v", decoded, err)
+	}
+	if _, err := Decode("not base64!", Base64); err == nil {
+		t.Fatal("Decode() of invalid base64 succeeded")
+	}
+	if got := DecodedLen(4096, Base64); got != 3072 {
+		t.Fatalf("DecodedLen(4096, base64) = %d, want 3072", got)
+	}
+	if got := SniffMIME(content); got != "image/png" {
+		t.Fatalf("SniffMIME(png) = %q", got)
+	}
+}
@@ -0,0 +1,122 @@
+// Package gitlock serializes git operations that write to a repository, so
+// agent commits, checkpoints, auto-push and browser-driven git actions do not
+// race on the index or refs of the same workspace. Waiters are served in
+// arrival order and give up when their context ends.
+package gitlock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrBusy is returned by Acquire when the lock was not granted before the
+// caller's context ended.
+var ErrBusy = errors.New("another git operation is in progress")
+
+// Holder describes the operation holding a lock.
+type Holder struct {
+	Op    string    `json:"op"`
+	Since time.Time `json:"since"`
+}
+
+// Manager holds one lock per key. The zero value is ready to use.
+type Manager struct {
+	mu    sync.Mutex
+	locks map[string]*lockState
+}
+
+type lockState struct {
+	holder  Holder
+	waiters []*waiter
+}
+
+type waiter struct {
+	op    string
+	ready chan struct{}
+}
+
+// Acquire blocks until the lock for key is free and all earlier waiters have
+// had their turn, then takes it for op. The returned release must be called
+// exactly once. When ctx ends first, Acquire returns an error wrapping ErrBusy
+// that names the current holder.
+func (m *Manager) Acquire(ctx context.Context, key, op string) (release func(), err error) {
+	m.mu.Lock()
+	if m.locks == nil {
+		m.locks = make(map[string]*lockState)
+	}
+	state, held := m.locks[key]
+	if !held {
+		m.locks[key] = &lockState{holder: Holder{Op: op, Since: time.Now()}}
+		m.mu.Unlock()
+		return m.releaseFunc(key), nil
+	}
+	w := &waiter{op: op, ready: make(chan struct{})}
+	state.waiters = append(state.waiters, w)
+	m.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return m.releaseFunc(key), nil
+	case <-ctx.Done():
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	select {
+	case <-w.ready:
+		// Granted while giving up; pass it on to the next waiter.
+		m.releaseLocked(key)
+	default:
+		for i, queued := range state.waiters {
+			if queued == w {
+				state.waiters = append(state.waiters[:i], state.waiters[i+1:]...)
+				break
+			}
+		}
+	}
+	holder := state.holder
+	return nil, fmt.Errorf("%w: %s has held the lock for %s", ErrBusy, holder.Op, time.Since(holder.Since).Round(time.Second))
+}
+
+// Holder reports the operation holding the lock for key and how many
+// operations are queued behind it.
+func (m *Manager) Holder(key string) (holder Holder, queued int, held bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state, held := m.locks[key]
+	if !held {
+		return Holder{}, 0, false
+	}
+	return state.holder, len(state.waiters), true
+}
+
+func (m *Manager) releaseFunc(key string) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			m.releaseLocked(key)
+		})
+	}
+}
+
+// releaseLocked hands the lock for key to the first waiter, or frees it.
+// Must hold m.mu.
+func (m *Manager) releaseLocked(key string) {
+	state, ok := m.locks[key]
+	if !ok {
+		return
+	}
+	if len(state.waiters) == 0 {
+		delete(m.locks, key)
+		return
+	}
+	next := state.waiters[0]
+	state.waiters = state.waiters[1:]
+	state.holder = Holder{Op: next.op, Since: time.Now()}
+	close(next.ready)
+}
@@ -0,0 +1,87 @@
+package gitlock
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAcquireServesWaitersInOrder(t *testing.T) {
+	var m Manager
+	release, err := m.Acquire(context.Background(), "ws-1", "checkout")
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	order := make(chan string, 3)
+	for i, op := range []string{"checkpoint", "push", "autosave"} {
+		go func() {
+			r, err := m.Acquire(context.Background(), "ws-1", op)
+			if err != nil {
+				t.Errorf("Acquire(%s) error = %v", op, err)
+				return
+			}
+			order <- op
+			r()
+		}()
+		waitForQueue(t, &m, "ws-1", i+1)
+	}
+
+	if holder, queued, held := m.Holder("ws-1"); !held || holder.Op != "checkout" || queued != 3 {
+		t.Fatalf("Holder() = %+v, %d, %v; want checkout with 3 queued", holder, queued, held)
+	}
+	release()
+	release() // a second release is a no-op
+
+	for _, want := range []string{"checkpoint", "push", "autosave"} {
+		if got := <-order; got != want {
+			t.Fatalf("granted %s, want %s", got, want)
+		}
+	}
+	if _, _, held := m.Holder("ws-1"); held {
+		t.Fatal("lock still held after every operation released it")
+	}
+}
+
+func TestAcquireTimesOutNamingHolder(t *testing.T) {
+	var m Manager
+	release, err := m.Acquire(context.Background(), "ws-1", "git push")
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = m.Acquire(ctx, "ws-1", "checkout")
+	if !errors.Is(err, ErrBusy) || !strings.Contains(err.Error(), "git push") {
+		t.Fatalf("Acquire() error = %v, want ErrBusy naming git push", err)
+	}
+	if _, queued, _ := m.Holder("ws-1"); queued != 0 {
+		t.Fatalf("queued = %d, want the timed-out waiter removed", queued)
+	}
+
+	// Other keys are independent.
+	other, err := m.Acquire(context.Background(), "ws-2", "checkout")
+	if err != nil {
+		t.Fatalf("Acquire(ws-2) error = %v", err)
+	}
+	other()
+}
+
+func waitForQueue(t *testing.T, m *Manager, key string, want int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		_, queued, _ := m.Holder(key)
+		if queued >= want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("queue length = %d, want %d", queued, want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,365 @@
+// Package jobs tracks background commands run in a workspace. A job outlives
+// the request and any terminal that started it, and its combined output is
+// kept so it can be read or followed later. Jobs are held in memory: they do
+// not survive an agent restart.
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os/exec"
+	"sort"
+	"sync"
+	"time"
+)
+
+// outputWaitDelay bounds how long a finished job waits for background
+// processes it left behind to close its output.
+const outputWaitDelay = 5 * time.Second
+
+var (
+	// ErrNotFound is returned when a job does not exist in the workspace.
+	ErrNotFound = errors.New("job not found")
+	// ErrLimitReached is returned by Start when the workspace already has
+	// the maximum number of jobs and none of them has finished.
+	ErrLimitReached = errors.New("workspace job limit reached")
+)
+
+// Status is a job's lifecycle state.
+type Status string
+
+const (
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusKilled    Status = "killed"
+)
+
+// Job is a snapshot of a tracked command.
+type Job struct {
+	ID          string `json:"id"`
+	WorkspaceID string `json:"workspaceId"`
+	Command     string `json:"command"`
+	WorkDir     string `json:"workDir,omitempty"`
+	Status      Status `json:"status"`
+	// ExitCode is set once the job has finished; -1 means it did not exit
+	// normally, e.g. it was killed by a signal.
+	ExitCode *int   `json:"exitCode,omitempty"`
+	Error    string `json:"error,omitempty"`
+	// OutputBytes counts all output written, including output dropped from
+	// the front of the kept tail.
+	OutputBytes int64      `json:"outputBytes"`
+	StartedAt   time.Time  `json:"startedAt"`
+	EndedAt     *time.Time `json:"endedAt,omitempty"`
+}
+
+// KillFunc stops a job's process tree. It is called in addition to killing
+// the local process, for commands whose real work runs elsewhere (e.g. in a
+// container behind docker exec).
+type KillFunc func(ctx context.Context) error
+
+// CommandFunc builds the command for a new job. The job ID is passed so the
+// command can name per-job state, such as a pid file. kill may be nil.
+type CommandFunc func(jobID string) (cmd *exec.Cmd, kill KillFunc, err error)
+
+// Manager runs and tracks jobs.
+type Manager struct {
+	maxPerWorkspace int
+	maxOutput       int
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+type job struct {
+	mu      sync.Mutex
+	info    Job
+	cmd     *exec.Cmd
+	kill    KillFunc
+	killed  bool
+	output  []byte // tail of the output, at most maxOutput bytes
+	changed chan struct{}
+	done    chan struct{}
+}
+
+// New returns a Manager. maxPerWorkspace caps the jobs tracked per workspace,
+// running or finished; the oldest finished job is dropped to make room.
+// maxOutput caps the output kept per job. Zero means unlimited for either.
+func New(maxPerWorkspace, maxOutput int) *Manager {
+	return &Manager{maxPerWorkspace: maxPerWorkspace, maxOutput: maxOutput, jobs: make(map[string]*job)}
+}
+
+// Start runs the command built by build as a new job. The command's Stdout
+// and Stderr are replaced to capture output.
+func (m *Manager) Start(workspaceID, command, workDir string, build CommandFunc) (Job, error) {
+	id, err := newID()
+	if err != nil {
+		return Job{}, err
+	}
+	cmd, kill, err := build(id)
+	if err != nil {
+		return Job{}, err
+	}
+	j := &job{
+		info: Job{
+			ID:          id,
+			WorkspaceID: workspaceID,
+			Command:     command,
+			WorkDir:     workDir,
+			Status:      StatusRunning,
+			StartedAt:   time.Now().UTC(),
+		},
+		cmd:     cmd,
+		kill:    kill,
+		changed: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.makeRoomLocked(workspaceID); err != nil {
+		return Job{}, err
+	}
+	writer := &outputWriter{job: j, max: m.maxOutput}
+	cmd.Stdout = writer
+	cmd.Stderr = writer
+	if cmd.WaitDelay == 0 {
+		cmd.WaitDelay = outputWaitDelay
+	}
+	if err := cmd.Start(); err != nil {
+		return Job{}, err
+	}
+	m.jobs[id] = j
+	go j.wait()
+	return j.snapshot(), nil
+}
+
+// makeRoomLocked drops the oldest finished jobs until the workspace is
+// under its limit.
+func (m *Manager) makeRoomLocked(workspaceID string) error {
+	if m.maxPerWorkspace <= 0 {
+		return nil
+	}
+	var finished []*job
+	count := 0
+	for _, j := range m.jobs {
+		if j.info.WorkspaceID != workspaceID {
+			continue
+		}
+		count++
+		if j.finished() {
+			finished = append(finished, j)
+		}
+	}
+	sort.Slice(finished, func(a, b int) bool { return finished[a].info.StartedAt.Before(finished[b].info.StartedAt) })
+	for count >= m.maxPerWorkspace {
+		if len(finished) == 0 {
+			return ErrLimitReached
+		}
+		delete(m.jobs, finished[0].info.ID)
+		finished = finished[1:]
+		count--
+	}
+	return nil
+}
+
+// List returns the workspace's jobs, oldest first.
+func (m *Manager) List(workspaceID string) []Job {
+	m.mu.Lock()
+	var list []*job
+	for _, j := range m.jobs {
+		if j.info.WorkspaceID == workspaceID {
+			list = append(list, j)
+		}
+	}
+	m.mu.Unlock()
+
+	out := make([]Job, 0, len(list))
+	for _, j := range list {
+		out = append(out, j.snapshot())
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].StartedAt.Before(out[b].StartedAt) })
+	return out
+}
+
+// Get returns a job in the workspace.
+func (m *Manager) Get(workspaceID, id string) (Job, error) {
+	j, err := m.get(workspaceID, id)
+	if err != nil {
+		return Job{}, err
+	}
+	return j.snapshot(), nil
+}
+
+// Output returns the job's output from offset, the offset to read from next,
+// and a channel that is closed when more output arrives or the job ends.
+// Output older than the kept tail is skipped. done reports whether the job
+// had finished, in which case the returned output is the last of it.
+func (m *Manager) Output(workspaceID, id string, offset int64) (data []byte, next int64, done bool, changed <-chan struct{}, err error) {
+	j, err := m.get(workspaceID, id)
+	if err != nil {
+		return nil, 0, false, nil, err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	total := j.info.OutputBytes
+	start := total - int64(len(j.output))
+	if offset < start {
+		offset = start
+	}
+	if offset < total {
+		data = append([]byte(nil), j.output[offset-start:]...)
+	}
+	return data, total, j.info.Status != StatusRunning, j.changed, nil
+}
+
+// Kill stops a running job and waits up to the context deadline for it to
+// exit. Killing a finished job is a no-op.
+func (m *Manager) Kill(ctx context.Context, workspaceID, id string) (Job, error) {
+	j, err := m.get(workspaceID, id)
+	if err != nil {
+		return Job{}, err
+	}
+	j.terminate(ctx)
+	select {
+	case <-j.done:
+	case <-ctx.Done():
+	}
+	return j.snapshot(), nil
+}
+
+// Wait blocks until the job finishes or ctx is done and returns its latest
+// state.
+func (m *Manager) Wait(ctx context.Context, workspaceID, id string) (Job, error) {
+	j, err := m.get(workspaceID, id)
+	if err != nil {
+		return Job{}, err
+	}
+	select {
+	case <-j.done:
+	case <-ctx.Done():
+		return j.snapshot(), ctx.Err()
+	}
+	return j.snapshot(), nil
+}
+
+// RemoveWorkspace kills the workspace's running jobs and forgets all of its
+// jobs.
+func (m *Manager) RemoveWorkspace(ctx context.Context, workspaceID string) {
+	m.mu.Lock()
+	var removed []*job
+	for id, j := range m.jobs {
+		if j.info.WorkspaceID == workspaceID {
+			removed = append(removed, j)
+			delete(m.jobs, id)
+		}
+	}
+	m.mu.Unlock()
+	for _, j := range removed {
+		j.terminate(ctx)
+	}
+}
+
+func (m *Manager) get(workspaceID, id string) (*job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok || j.info.WorkspaceID != workspaceID {
+		return nil, ErrNotFound
+	}
+	return j, nil
+}
+
+func (j *job) snapshot() Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.info
+}
+
+func (j *job) finished() bool {
+	select {
+	case <-j.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// terminate marks the job killed and stops it, first through its KillFunc
+// and then by killing the local process.
+func (j *job) terminate(ctx context.Context) {
+	if j.finished() {
+		return
+	}
+	j.mu.Lock()
+	j.killed = true
+	j.mu.Unlock()
+	if j.kill != nil {
+		_ = j.kill(ctx)
+	}
+	if j.cmd.Process != nil {
+		_ = j.cmd.Process.Kill()
+	}
+}
+
+func (j *job) wait() {
+	err := j.cmd.Wait()
+	now := time.Now().UTC()
+
+	j.mu.Lock()
+	code := -1
+	if j.cmd.ProcessState != nil {
+		code = j.cmd.ProcessState.ExitCode()
+	}
+	j.info.ExitCode = &code
+	j.info.EndedAt = &now
+	switch {
+	case j.killed:
+		j.info.Status = StatusKilled
+	case err == nil:
+		j.info.Status = StatusSucceeded
+	default:
+		j.info.Status = StatusFailed
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			j.info.Error = err.Error()
+		}
+	}
+	close(j.changed)
+	j.changed = make(chan struct{})
+	j.mu.Unlock()
+	close(j.done)
+}
+
+// outputWriter appends to a job's output tail and wakes followers.
+type outputWriter struct {
+	job *job
+	max int
+}
+
+var _ io.Writer = (*outputWriter)(nil)
+
+func (w *outputWriter) Write(p []byte) (int, error) {
+	j := w.job
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.output = append(j.output, p...)
+	if w.max > 0 && len(j.output) > w.max {
+		j.output = append(j.output[:0], j.output[len(j.output)-w.max:]...)
+	}
+	j.info.OutputBytes += int64(len(p))
+	close(j.changed)
+	j.changed = make(chan struct{})
+	return len(p), nil
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "job_" + hex.EncodeToString(b), nil
+}
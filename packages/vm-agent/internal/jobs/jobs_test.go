@@ -0,0 +1,185 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func shell(script string) CommandFunc {
+	return func(string) (*exec.Cmd, KillFunc, error) {
+		return exec.Command("/bin/sh", "-c", script), nil, nil
+	}
+}
+
+// waitDone follows a job's output until it finishes and returns all of it.
+func waitDone(t *testing.T, m *Manager, workspaceID, id string) string {
+	t.Helper()
+	var out strings.Builder
+	var offset int64
+	deadline := time.After(10 * time.Second)
+	for {
+		data, next, done, changed, err := m.Output(workspaceID, id, offset)
+		if err != nil {
+			t.Fatalf("Output: %v", err)
+		}
+		out.Write(data)
+		offset = next
+		if done {
+			return out.String()
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			t.Fatalf("job %s did not finish; output so far %q", id, out.String())
+		}
+	}
+}
+
+func TestStartCapturesOutputAndExitStatus(t *testing.T) {
+	m := New(0, 0)
+
+	ok, err := m.Start("ws-1", "echo hello; echo oops >&2", "", shell("echo hello; echo oops >&2"))
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if ok.Status != StatusRunning || !strings.HasPrefix(ok.ID, "job_") {
+		t.Fatalf("unexpected started job: %+v", ok)
+	}
+	if out := waitDone(t, m, "ws-1", ok.ID); out != "hello\noops\n" {
+		t.Fatalf("output = %q", out)
+	}
+	got, err := m.Get("ws-1", ok.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.Status != StatusSucceeded || got.ExitCode == nil || *got.ExitCode != 0 || got.EndedAt == nil {
+		t.Fatalf("unexpected finished job: %+v", got)
+	}
+
+	failed, err := m.Start("ws-1", "exit 3", "", shell("exit 3"))
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	waitDone(t, m, "ws-1", failed.ID)
+	got, _ = m.Get("ws-1", failed.ID)
+	if got.Status != StatusFailed || *got.ExitCode != 3 || got.Error != "" {
+		t.Fatalf("unexpected failed job: %+v", got)
+	}
+
+	if list := m.List("ws-1"); len(list) != 2 || list[0].ID != ok.ID {
+		t.Fatalf("List = %+v", list)
+	}
+	// Jobs are scoped to their workspace.
+	if _, err := m.Get("ws-2", ok.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("cross-workspace Get error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestOutputKeepsTail(t *testing.T) {
+	m := New(0, 4)
+
+	job, err := m.Start("ws-1", "printf", "", shell("printf 0123456789"))
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if out := waitDone(t, m, "ws-1", job.ID); out != "6789" {
+		t.Fatalf("output = %q, want the last 4 bytes", out)
+	}
+	got, _ := m.Get("ws-1", job.ID)
+	if got.OutputBytes != 10 {
+		t.Fatalf("OutputBytes = %d, want 10", got.OutputBytes)
+	}
+}
+
+func TestKillStopsJob(t *testing.T) {
+	m := New(0, 0)
+
+	killed := false
+	job, err := m.Start("ws-1", "sleep 30", "", func(string) (*exec.Cmd, KillFunc, error) {
+		return exec.Command("/bin/sh", "-c", "exec sleep 30"), func(context.Context) error {
+			killed = true
+			return nil
+		}, nil
+	})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	got, err := m.Kill(ctx, "ws-1", job.ID)
+	if err != nil {
+		t.Fatalf("Kill: %v", err)
+	}
+	if got.Status != StatusKilled || !killed {
+		t.Fatalf("Kill = %+v, KillFunc called = %v", got, killed)
+	}
+	if _, err := m.Kill(ctx, "ws-1", "job_missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Kill missing error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestStartEvictsOldestFinishedJob(t *testing.T) {
+	m := New(2, 0)
+
+	first, err := m.Start("ws-1", "true", "", shell("true"))
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	waitDone(t, m, "ws-1", first.ID)
+	if _, err := m.Start("ws-1", "sleep 30", "", shell("exec sleep 30")); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() { m.RemoveWorkspace(context.Background(), "ws-1") })
+
+	if _, err := m.Start("ws-1", "sleep 30", "", shell("exec sleep 30")); err != nil {
+		t.Fatalf("Start with a finished job to evict: %v", err)
+	}
+	if _, err := m.Get("ws-1", first.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("oldest finished job was not evicted: %v", err)
+	}
+	if _, err := m.Start("ws-1", "true", "", shell("true")); !errors.Is(err, ErrLimitReached) {
+		t.Fatalf("Start with only running jobs error = %v, want ErrLimitReached", err)
+	}
+	if _, err := m.Start("ws-2", "true", "", shell("true")); err != nil {
+		t.Fatalf("limit must be per workspace: %v", err)
+	}
+
+	m.RemoveWorkspace(context.Background(), "ws-1")
+	if list := m.List("ws-1"); len(list) != 0 {
+		t.Fatalf("List after RemoveWorkspace = %+v", list)
+	}
+}
+
+func TestWaitReturnsFinishedJob(t *testing.T) {
+	m := New(0, 0)
+
+	job, err := m.Start("ws-1", "exit 2", "", shell("exit 2"))
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	got, err := m.Wait(ctx, "ws-1", job.ID)
+	if err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if got.Status != StatusFailed || *got.ExitCode != 2 {
+		t.Fatalf("Wait = %+v, want failed with exit code 2", got)
+	}
+
+	running, err := m.Start("ws-1", "sleep 30", "", shell("exec sleep 30"))
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() { m.RemoveWorkspace(context.Background(), "ws-1") })
+	short, cancelShort := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelShort()
+	if _, err := m.Wait(short, "ws-1", running.ID); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait on running job error = %v, want deadline exceeded", err)
+	}
+}
@@ -0,0 +1,375 @@
+// Package lifecyclehook delivers workspace lifecycle events observed by the
+// VM agent to an external orchestration endpoint, in addition to the control
+// plane's own /ready callback.
+//
+// Events are persisted to a SQLite outbox before delivery and removed only
+// after the endpoint acknowledges them, so delivery is at-least-once across
+// transient failures and agent restarts. Receivers should de-duplicate on the
+// X-SAM-Delivery header.
+//
+// All methods on *Notifier are nil-safe: a nil receiver is a no-op, which is
+// what New returns when no webhook URL is configured.
+package lifecyclehook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/config"
+	_ "modernc.org/sqlite"
+)
+
+// Event names emitted to the lifecycle webhook.
+const (
+	EventBootstrapStarted  = "bootstrap_started"
+	EventDevcontainerBuilt = "devcontainer_built"
+	EventRecoveryEntered   = "recovery_entered"
+	EventSuspended         = "suspended"
+	EventResumed           = "resumed"
+	EventShutdownRequested = "shutdown_requested"
+)
+
+// Header names set on every webhook delivery.
+const (
+	HeaderEvent     = "X-SAM-Event"
+	HeaderDelivery  = "X-SAM-Delivery"
+	HeaderSignature = "X-SAM-Signature"
+)
+
+// Config holds configuration for the lifecycle notifier.
+type Config struct {
+	URL           string        // Webhook endpoint; empty disables the notifier
+	Secret        string        // HMAC-SHA256 signing secret; empty sends unsigned payloads
+	NodeID        string        // Node identifier included in every payload
+	FlushInterval time.Duration // Outbox poll interval (default: 5s)
+	HTTPTimeout   time.Duration // Per-delivery HTTP timeout (default: 10s)
+	RetryInitial  time.Duration // Backoff after the first failed attempt (default: 1s)
+	RetryMax      time.Duration // Max backoff between attempts (default: 5m)
+	OutboxMaxSize int           // Max undelivered events retained (default: 1000)
+}
+
+// Payload is the JSON body POSTed to the webhook endpoint.
+type Payload struct {
+	ID          string                 `json:"id"`
+	Event       string                 `json:"event"`
+	NodeID      string                 `json:"nodeId,omitempty"`
+	WorkspaceID string                 `json:"workspaceId,omitempty"`
+	Timestamp   string                 `json:"timestamp"`
+	Detail      map[string]interface{} `json:"detail,omitempty"`
+}
+
+// Notifier persists lifecycle events to a SQLite outbox and delivers them to
+// the configured endpoint from a background goroutine.
+type Notifier struct {
+	cfg    Config
+	db     *sql.DB
+	client *http.Client
+
+	// mu serializes outbox writes against the capacity check in Emit.
+	mu sync.Mutex
+
+	kickC chan struct{}
+	stopC chan struct{}
+	doneC chan struct{}
+	once  sync.Once
+}
+
+// New creates a Notifier backed by the given SQLite database, runs the outbox
+// migration, and starts the background delivery goroutine. Events left in the
+// outbox by a previous agent process are redelivered. The Notifier owns db
+// from then on and closes it in Shutdown.
+//
+// Returns (nil, nil) if cfg.URL is empty — lifecycle webhooks are disabled.
+func New(db *sql.DB, cfg Config) (*Notifier, error) {
+	if cfg.URL == "" {
+		return nil, nil
+	}
+	if db == nil {
+		return nil, fmt.Errorf("lifecyclehook: db must not be nil")
+	}
+
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = 5 * time.Second
+	}
+	if cfg.HTTPTimeout <= 0 {
+		cfg.HTTPTimeout = 10 * time.Second
+	}
+	if cfg.RetryInitial <= 0 {
+		cfg.RetryInitial = 1 * time.Second
+	}
+	if cfg.RetryMax <= 0 {
+		cfg.RetryMax = 5 * time.Minute
+	}
+	if cfg.OutboxMaxSize <= 0 {
+		cfg.OutboxMaxSize = 1000
+	}
+
+	if err := migrateOutbox(db); err != nil {
+		return nil, fmt.Errorf("lifecyclehook: migrate outbox: %w", err)
+	}
+
+	n := &Notifier{
+		cfg:    cfg,
+		db:     db,
+		client: config.NewControlPlaneClient(cfg.HTTPTimeout),
+		kickC:  make(chan struct{}, 1),
+		stopC:  make(chan struct{}),
+		doneC:  make(chan struct{}),
+	}
+	go n.deliverLoop()
+	return n, nil
+}
+
+// Emit records a lifecycle event for delivery. It never blocks on the
+// network; delivery happens asynchronously. Returns an error only when the
+// event could not be persisted (outbox full or database failure).
+func (n *Notifier) Emit(event, workspaceID string, detail map[string]interface{}) error {
+	if n == nil {
+		return nil
+	}
+
+	id, err := newEventID()
+	if err != nil {
+		return fmt.Errorf("lifecyclehook: generate event id: %w", err)
+	}
+	payload := Payload{
+		ID:          id,
+		Event:       event,
+		NodeID:      n.cfg.NodeID,
+		WorkspaceID: workspaceID,
+		Timestamp:   time.Now().UTC().Format(time.RFC3339Nano),
+		Detail:      detail,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("lifecyclehook: marshal payload: %w", err)
+	}
+
+	n.mu.Lock()
+	var count int
+	if err := n.db.QueryRow(
+		"SELECT COUNT(*) FROM (SELECT 1 FROM lifecycle_outbox LIMIT ?)",
+		n.cfg.OutboxMaxSize+1,
+	).Scan(&count); err != nil {
+		n.mu.Unlock()
+		return fmt.Errorf("lifecyclehook: check outbox capacity: %w", err)
+	}
+	if count >= n.cfg.OutboxMaxSize {
+		n.mu.Unlock()
+		slog.Warn("lifecyclehook: outbox full, dropping event",
+			"event", event, "workspaceId", workspaceID, "maxSize", n.cfg.OutboxMaxSize)
+		return fmt.Errorf("lifecyclehook: outbox full (%d/%d)", count, n.cfg.OutboxMaxSize)
+	}
+	_, err = n.db.Exec(
+		`INSERT INTO lifecycle_outbox (event_id, event, payload, created_at) VALUES (?, ?, ?, ?)`,
+		id, event, string(body), payload.Timestamp,
+	)
+	n.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("lifecyclehook: insert outbox: %w", err)
+	}
+
+	select {
+	case n.kickC <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Shutdown attempts a final delivery of queued events, stops the background
+// goroutine, and closes the outbox database. Undelivered events stay in the
+// outbox and are redelivered by the next agent process.
+func (n *Notifier) Shutdown() {
+	if n == nil {
+		return
+	}
+	n.once.Do(func() {
+		close(n.stopC)
+		<-n.doneC
+		if err := n.db.Close(); err != nil {
+			slog.Warn("lifecyclehook: close outbox", "error", err)
+		}
+	})
+}
+
+// Pending returns the number of events awaiting delivery.
+func (n *Notifier) Pending() int {
+	if n == nil {
+		return 0
+	}
+	var count int
+	if err := n.db.QueryRow("SELECT COUNT(*) FROM lifecycle_outbox").Scan(&count); err != nil {
+		return 0
+	}
+	return count
+}
+
+// --- background delivery loop ---
+
+func (n *Notifier) deliverLoop() {
+	defer close(n.doneC)
+
+	ticker := time.NewTicker(n.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	n.deliverDue()
+	for {
+		select {
+		case <-n.stopC:
+			n.deliverDue() // final attempt
+			return
+		case <-ticker.C:
+			n.deliverDue()
+		case <-n.kickC:
+			n.deliverDue()
+		}
+	}
+}
+
+type outboxRow struct {
+	id       int64
+	eventID  string
+	event    string
+	payload  string
+	attempts int
+}
+
+// deliverDue sends due events in insertion order. Delivery stops at the first
+// transient failure so a receiver never observes a later transition before an
+// earlier one that is still being retried.
+func (n *Notifier) deliverDue() {
+	for {
+		row, ok, err := n.nextDue()
+		if err != nil {
+			slog.Error("lifecyclehook: read outbox", "error", err)
+			return
+		}
+		if !ok {
+			return
+		}
+
+		statusCode, responseBody, err := n.post(row)
+		switch {
+		case err == nil && statusCode >= 200 && statusCode < 300:
+			n.deleteRow(row.id)
+		case err == nil && isPermanentError(statusCode):
+			slog.Warn("lifecyclehook: permanent error, discarding event",
+				"event", row.event, "eventId", row.eventID,
+				"statusCode", statusCode, "responseBody", responseBody)
+			n.deleteRow(row.id)
+		default:
+			delay := n.retryDelay(row.attempts)
+			slog.Warn("lifecyclehook: delivery failed, will retry",
+				"event", row.event, "eventId", row.eventID, "attempt", row.attempts+1,
+				"statusCode", statusCode, "responseBody", responseBody, "error", err, "retryIn", delay)
+			n.deferRow(row.id, delay)
+			return
+		}
+	}
+}
+
+func (n *Notifier) nextDue() (outboxRow, bool, error) {
+	var row outboxRow
+	var nextAttemptAt int64
+	err := n.db.QueryRow(
+		`SELECT id, event_id, event, payload, attempts, next_attempt_at
+		 FROM lifecycle_outbox ORDER BY id ASC LIMIT 1`,
+	).Scan(&row.id, &row.eventID, &row.event, &row.payload, &row.attempts, &nextAttemptAt)
+	if err == sql.ErrNoRows {
+		return outboxRow{}, false, nil
+	}
+	if err != nil {
+		return outboxRow{}, false, err
+	}
+	if nextAttemptAt > time.Now().UnixMilli() {
+		return outboxRow{}, false, nil
+	}
+	return row, true, nil
+}
+
+func (n *Notifier) post(row outboxRow) (int, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), n.cfg.HTTPTimeout)
+	defer cancel()
+
+	body := []byte(row.payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, row.event)
+	req.Header.Set(HeaderDelivery, row.eventID)
+	if n.cfg.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(n.cfg.Secret, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return resp.StatusCode, string(respBody), nil
+}
+
+func (n *Notifier) deleteRow(id int64) {
+	if _, err := n.db.Exec("DELETE FROM lifecycle_outbox WHERE id = ?", id); err != nil {
+		slog.Error("lifecyclehook: delete delivered event", "error", err)
+	}
+}
+
+func (n *Notifier) deferRow(id int64, delay time.Duration) {
+	if _, err := n.db.Exec(
+		"UPDATE lifecycle_outbox SET attempts = attempts + 1, next_attempt_at = ? WHERE id = ?",
+		time.Now().Add(delay).UnixMilli(), id,
+	); err != nil {
+		slog.Error("lifecyclehook: record failed attempt", "error", err)
+	}
+}
+
+func (n *Notifier) retryDelay(attempts int) time.Duration {
+	delay := n.cfg.RetryInitial
+	for i := 0; i < attempts && delay < n.cfg.RetryMax; i++ {
+		delay *= 2
+	}
+	if delay > n.cfg.RetryMax {
+		delay = n.cfg.RetryMax
+	}
+	return delay
+}
+
+// isPermanentError reports whether a response status means redelivery cannot
+// succeed. Rate limiting and request timeouts are retried like 5xx responses.
+func isPermanentError(statusCode int) bool {
+	return statusCode >= 400 && statusCode < 500 &&
+		statusCode != http.StatusRequestTimeout &&
+		statusCode != http.StatusTooManyRequests
+}
+
+// Sign returns the X-SAM-Signature header value for body: "sha256=" followed
+// by the hex HMAC-SHA256 of the raw request body keyed with secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newEventID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
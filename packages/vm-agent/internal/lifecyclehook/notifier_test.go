@@ -0,0 +1,274 @@
+package lifecyclehook
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func openTestDB(t *testing.T) (*sql.DB, string) {
+	t.Helper()
+	dbPath := filepath.Join(t.TempDir(), "lifecycle.db")
+	db := reopenTestDB(t, dbPath)
+	return db, dbPath
+}
+
+func reopenTestDB(t *testing.T, dbPath string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?mode=rwc&_journal_mode=WAL", dbPath))
+	if err != nil {
+		t.Fatalf("open test db: %v", err)
+	}
+	db.Exec("PRAGMA busy_timeout=5000")
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+type recordingEndpoint struct {
+	mu       sync.Mutex
+	payloads []Payload
+	headers  []http.Header
+	bodies   [][]byte
+}
+
+func (e *recordingEndpoint) record(r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var p Payload
+	_ = json.Unmarshal(body, &p)
+	e.mu.Lock()
+	e.payloads = append(e.payloads, p)
+	e.headers = append(e.headers, r.Header.Clone())
+	e.bodies = append(e.bodies, body)
+	e.mu.Unlock()
+}
+
+func (e *recordingEndpoint) events() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := make([]string, 0, len(e.payloads))
+	for _, p := range e.payloads {
+		out = append(out, p.Event)
+	}
+	return out
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("condition not met before deadline")
+}
+
+func TestNewDisabledWithoutURL(t *testing.T) {
+	t.Parallel()
+
+	n, err := New(nil, Config{})
+	if err != nil || n != nil {
+		t.Fatalf("New() = %v, %v; want nil, nil", n, err)
+	}
+	// Nil receiver is a no-op.
+	if err := n.Emit(EventSuspended, "ws-1", nil); err != nil {
+		t.Fatalf("nil Emit() error = %v", err)
+	}
+	n.Shutdown()
+}
+
+func TestEmitDeliversSignedPayloadsInOrder(t *testing.T) {
+	t.Parallel()
+
+	endpoint := &recordingEndpoint{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		endpoint.record(r)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	db, _ := openTestDB(t)
+	n, err := New(db, Config{URL: srv.URL, Secret: "s3cret", NodeID: "node-1", FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer n.Shutdown()
+
+	if err := n.Emit(EventBootstrapStarted, "ws-1", nil); err != nil {
+		t.Fatalf("Emit() error = %v", err)
+	}
+	if err := n.Emit(EventDevcontainerBuilt, "ws-1", map[string]interface{}{"recoveryMode": false}); err != nil {
+		t.Fatalf("Emit() error = %v", err)
+	}
+
+	waitFor(t, func() bool { return len(endpoint.events()) == 2 })
+	if got := endpoint.events(); got[0] != EventBootstrapStarted || got[1] != EventDevcontainerBuilt {
+		t.Fatalf("events = %v, want bootstrap_started then devcontainer_built", got)
+	}
+
+	endpoint.mu.Lock()
+	defer endpoint.mu.Unlock()
+	p := endpoint.payloads[0]
+	if p.NodeID != "node-1" || p.WorkspaceID != "ws-1" || p.ID == "" || p.Timestamp == "" {
+		t.Fatalf("unexpected payload: %+v", p)
+	}
+	h := endpoint.headers[0]
+	if h.Get(HeaderEvent) != EventBootstrapStarted {
+		t.Fatalf("%s = %q", HeaderEvent, h.Get(HeaderEvent))
+	}
+	if h.Get(HeaderDelivery) != p.ID {
+		t.Fatalf("%s = %q, want payload id %q", HeaderDelivery, h.Get(HeaderDelivery), p.ID)
+	}
+	if want := Sign("s3cret", endpoint.bodies[0]); h.Get(HeaderSignature) != want {
+		t.Fatalf("%s = %q, want %q", HeaderSignature, h.Get(HeaderSignature), want)
+	}
+}
+
+func TestTransientFailureIsRetriedWithoutReordering(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	endpoint := &recordingEndpoint{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		endpoint.record(r)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	db, _ := openTestDB(t)
+	n, err := New(db, Config{
+		URL:           srv.URL,
+		FlushInterval: 10 * time.Millisecond,
+		RetryInitial:  10 * time.Millisecond,
+		RetryMax:      20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer n.Shutdown()
+
+	_ = n.Emit(EventSuspended, "ws-1", nil)
+	_ = n.Emit(EventResumed, "ws-1", nil)
+
+	waitFor(t, func() bool { return len(endpoint.events()) == 2 })
+	if got := endpoint.events(); got[0] != EventSuspended || got[1] != EventResumed {
+		t.Fatalf("events = %v, want suspended then resumed", got)
+	}
+	waitFor(t, func() bool { return n.Pending() == 0 })
+}
+
+func TestPermanentFailureDiscardsEvent(t *testing.T) {
+	t.Parallel()
+
+	endpoint := &recordingEndpoint{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		endpoint.record(r)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	db, _ := openTestDB(t)
+	n, err := New(db, Config{URL: srv.URL, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer n.Shutdown()
+
+	_ = n.Emit(EventShutdownRequested, "", nil)
+	waitFor(t, func() bool { return len(endpoint.events()) == 1 && n.Pending() == 0 })
+}
+
+func TestUndeliveredEventsSurviveRestart(t *testing.T) {
+	t.Parallel()
+
+	var up atomic.Bool
+	endpoint := &recordingEndpoint{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		endpoint.record(r)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	db, dbPath := openTestDB(t)
+	first, err := New(db, Config{URL: srv.URL, FlushInterval: time.Hour, RetryInitial: time.Hour})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	_ = first.Emit(EventRecoveryEntered, "ws-1", nil)
+	first.Shutdown()
+	if err := db.Ping(); err == nil {
+		t.Fatal("Shutdown left the outbox database open")
+	}
+
+	// A restarted agent picks the event up once its backoff has elapsed.
+	db2 := reopenTestDB(t, dbPath)
+	var pending int
+	if err := db2.QueryRow("SELECT COUNT(*) FROM lifecycle_outbox").Scan(&pending); err != nil || pending != 1 {
+		t.Fatalf("pending after failed delivery = %d (%v), want 1", pending, err)
+	}
+	if _, err := db2.Exec("UPDATE lifecycle_outbox SET next_attempt_at = 0"); err != nil {
+		t.Fatalf("reset backoff: %v", err)
+	}
+	up.Store(true)
+	second, err := New(db2, Config{URL: srv.URL, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer second.Shutdown()
+
+	waitFor(t, func() bool { return len(endpoint.events()) == 1 })
+	if got := endpoint.events()[0]; got != EventRecoveryEntered {
+		t.Fatalf("redelivered event = %q", got)
+	}
+}
+
+func TestEmitRejectsWhenOutboxFull(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	db, _ := openTestDB(t)
+	n, err := New(db, Config{URL: srv.URL, FlushInterval: time.Hour, RetryInitial: time.Hour, OutboxMaxSize: 1})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer n.Shutdown()
+
+	if err := n.Emit(EventSuspended, "ws-1", nil); err != nil {
+		t.Fatalf("first Emit() error = %v", err)
+	}
+	if err := n.Emit(EventResumed, "ws-1", nil); err == nil {
+		t.Fatal("second Emit() should fail when the outbox is full")
+	}
+}
+
+func TestRetryDelayIsCapped(t *testing.T) {
+	t.Parallel()
+
+	n := &Notifier{cfg: Config{RetryInitial: time.Second, RetryMax: 5 * time.Second}}
+	for attempts, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if got := n.retryDelay(attempts); got != want {
+			t.Fatalf("retryDelay(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
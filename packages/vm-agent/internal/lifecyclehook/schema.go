@@ -0,0 +1,28 @@
+package lifecyclehook
+
+import "database/sql"
+
+// outboxDDL is the SQLite schema for the lifecycle webhook outbox.
+//
+// Events are inserted before delivery is attempted and deleted only after the
+// endpoint acknowledges them with a 2xx response, giving at-least-once
+// delivery across agent restarts. event_id is stable per event and sent as
+// the X-SAM-Delivery header so receivers can de-duplicate redeliveries.
+const outboxDDL = `
+CREATE TABLE IF NOT EXISTS lifecycle_outbox (
+	id              INTEGER PRIMARY KEY AUTOINCREMENT,
+	event_id        TEXT    NOT NULL UNIQUE,
+	event           TEXT    NOT NULL,
+	payload         TEXT    NOT NULL,
+	created_at      TEXT    NOT NULL,
+	attempts        INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at INTEGER NOT NULL DEFAULT 0
+);
+`
+
+// migrateOutbox creates the lifecycle_outbox table if it does not already
+// exist. It is safe to call multiple times.
+func migrateOutbox(db *sql.DB) error {
+	_, err := db.Exec(outboxDDL)
+	return err
+}
@@ -7,6 +7,8 @@ import (
 	"log/slog"
 	"os"
 	"strings"
+
+	"github.com/workspace/vm-agent/internal/redact"
 )
 
 // Level is a package-level LevelVar that allows runtime log level changes.
@@ -29,6 +31,9 @@ func Setup() {
 // SetupWithConfig configures slog with explicit parameters (useful for testing).
 func SetupWithConfig(levelStr, formatStr string, w io.Writer) {
 	Level.Set(ParseLevel(levelStr))
+	// Registered credentials are scrubbed from every record before it is
+	// written.
+	w = redact.Writer(w)
 
 	var handler slog.Handler
 	opts := &slog.HandlerOptions{Level: &Level}
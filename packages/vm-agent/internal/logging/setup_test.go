@@ -6,6 +6,8 @@ import (
 	"log/slog"
 	"strings"
 	"testing"
+
+	"github.com/workspace/vm-agent/internal/redact"
 )
 
 func TestParseLevel(t *testing.T) {
@@ -126,3 +128,18 @@ func TestSlogWriter_BridgesStdlib(t *testing.T) {
 		t.Errorf("source = %v, want %q", entry["source"], "stdlib")
 	}
 }
+
+func TestSetupWithConfig_RedactsRegisteredSecrets(t *testing.T) {
+	var buf bytes.Buffer
+	SetupWithConfig("info", "json", &buf)
+	redact.Register("gho_loggingsecret")
+
+	slog.Error("clone failed", "error", "bad credentials gho_loggingsecret")
+
+	if strings.Contains(buf.String(), "gho_loggingsecret") {
+		t.Fatalf("secret leaked into log output: %s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "bad credentials ***") {
+		t.Fatalf("expected redacted placeholder in output: %s", buf.String())
+	}
+}
@@ -11,6 +11,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/workspace/vm-agent/internal/containerruntime"
 )
 
 // ContainerInfo is the lightweight container shape exposed to the control plane.
@@ -28,7 +30,7 @@ func (r *Reader) ListContainers(ctx context.Context) ([]ContainerInfo, error) {
 	defer cancel()
 
 	args := []string{"ps", "--format", "{{json .}}"}
-	cmd := r.exec(ctx, "docker", args...)
+	cmd := r.exec(ctx, containerruntime.Current().Name(), args...)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		return nil, fmt.Errorf("docker ps: %w: %s", err, strings.TrimSpace(string(out)))
@@ -134,7 +136,7 @@ func (r *Reader) readDockerContainerLogs(ctx context.Context, filter LogFilter,
 	}
 	args = append(args, container)
 
-	cmd := r.exec(ctx, "docker", args...)
+	cmd := r.exec(ctx, containerruntime.Current().Name(), args...)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		return nil, fmt.Errorf("docker logs: %w: %s", err, strings.TrimSpace(string(out)))
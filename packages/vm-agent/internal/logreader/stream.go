@@ -12,6 +12,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/workspace/vm-agent/internal/containerruntime"
 )
 
 var (
@@ -181,7 +183,7 @@ func (r *Reader) runDockerFollowProcess(ctx context.Context, filter LogFilter, c
 	}
 	args = append(args, container)
 
-	cmd := r.exec(ctx, "docker", args...)
+	cmd := r.exec(ctx, containerruntime.Current().Name(), args...)
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		return fmt.Errorf("stdout pipe: %w", err)
@@ -0,0 +1,195 @@
+package messagereport
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEnqueue_FullBatchFlushesBeforeMaxWait(t *testing.T) {
+	var mu sync.Mutex
+	var batchSizes []int
+	ts := httptest.NewServer(recordingBatchSizeHandler(t, &mu, &batchSizes))
+	defer ts.Close()
+
+	db := openTestDB(t)
+	cfg := testConfig(ts.URL, "ws-1")
+	cfg.BatchMaxWait = time.Hour // only the count threshold can trigger a flush
+	cfg.BatchMaxSize = 3
+	r, err := New(db, cfg)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	defer r.Shutdown()
+	r.SetToken("test-token")
+
+	for i := 0; i < 3; i++ {
+		if err := r.Enqueue(Message{MessageID: fmt.Sprintf("m%d", i), Role: "assistant", Content: "chunk"}); err != nil {
+			t.Fatalf("enqueue m%d: %v", i, err)
+		}
+	}
+
+	waitForOutboxCount(t, db, 0, "after full batch")
+	mu.Lock()
+	defer mu.Unlock()
+	if len(batchSizes) != 1 || batchSizes[0] != 3 {
+		t.Fatalf("batch sizes = %v, want [3]", batchSizes)
+	}
+}
+
+func TestEnqueue_ByteThresholdFlushesBeforeMaxWait(t *testing.T) {
+	var mu sync.Mutex
+	var batchSizes []int
+	ts := httptest.NewServer(recordingBatchSizeHandler(t, &mu, &batchSizes))
+	defer ts.Close()
+
+	db := openTestDB(t)
+	cfg := testConfig(ts.URL, "ws-1")
+	cfg.BatchMaxWait = time.Hour
+	cfg.BatchMaxBytes = 4096
+	r, err := New(db, cfg)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	defer r.Shutdown()
+	r.SetToken("test-token")
+
+	if err := r.Enqueue(Message{MessageID: "big", Role: "assistant", Content: strings.Repeat("x", 4096)}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	waitForOutboxCount(t, db, 0, "after byte threshold")
+}
+
+func TestReadBatch_DoesNotInterleaveSessions(t *testing.T) {
+	db := openTestDB(t)
+	r, err := New(db, testConfig("http://localhost", "ws-1"))
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	defer r.Shutdown()
+
+	// Rows left behind by a previous agent process can belong to another
+	// session; insert them directly in interleaved order.
+	for i, session := range []string{"sess-a", "sess-b", "sess-a", "sess-b"} {
+		if _, err := db.Exec(
+			`INSERT INTO message_outbox (message_id, session_id, role, content, created_at) VALUES (?, ?, 'assistant', 'x', ?)`,
+			fmt.Sprintf("m%d", i), session, "2024-01-01T00:00:00Z",
+		); err != nil {
+			t.Fatalf("insert m%d: %v", i, err)
+		}
+	}
+
+	batch, err := r.readBatch()
+	if err != nil {
+		t.Fatalf("readBatch: %v", err)
+	}
+	if len(batch) != 2 || batch[0].messageID != "m0" || batch[1].messageID != "m2" {
+		t.Fatalf("first batch = %+v, want sess-a rows m0,m2", batch)
+	}
+	r.deleteBatch(batch)
+
+	batch, err = r.readBatch()
+	if err != nil {
+		t.Fatalf("readBatch: %v", err)
+	}
+	if len(batch) != 2 || batch[0].messageID != "m1" || batch[1].messageID != "m3" {
+		t.Fatalf("second batch = %+v, want sess-b rows m1,m3", batch)
+	}
+}
+
+func TestFlush_CompressesPayloadAboveThreshold(t *testing.T) {
+	var mu sync.Mutex
+	var encodings []string
+	var ids []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := io.Reader(r.Body)
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Errorf("gzip reader: %v", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body = zr
+		}
+		var payload struct {
+			Messages []struct {
+				MessageID string `json:"messageId"`
+			} `json:"messages"`
+		}
+		if err := json.NewDecoder(body).Decode(&payload); err != nil {
+			t.Errorf("decode payload: %v", err)
+		}
+		mu.Lock()
+		encodings = append(encodings, r.Header.Get("Content-Encoding"))
+		for _, m := range payload.Messages {
+			ids = append(ids, m.MessageID)
+		}
+		mu.Unlock()
+		writePersistedCount(w, len(payload.Messages))
+	}))
+	defer ts.Close()
+
+	db := openTestDB(t)
+	cfg := testConfig(ts.URL, "ws-1")
+	cfg.CompressMinBytes = 1024
+	r, err := New(db, cfg)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	r.SetToken("test-token")
+
+	_ = r.Enqueue(Message{MessageID: "small", Role: "user", Content: "hi"})
+	waitForOutboxCount(t, db, 0, "after small flush")
+	_ = r.Enqueue(Message{MessageID: "large", Role: "assistant", Content: strings.Repeat("tool output ", 200)})
+	waitForOutboxCount(t, db, 0, "after large flush")
+	r.Shutdown()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(ids, ",") != "small,large" {
+		t.Fatalf("delivered ids = %v", ids)
+	}
+	if encodings[0] != "" || encodings[1] != "gzip" {
+		t.Fatalf("content encodings = %q, want [\"\" \"gzip\"]", encodings)
+	}
+}
+
+func TestNextFlushWait_BacksOffUnderBackpressure(t *testing.T) {
+	r := &Reporter{cfg: Config{BatchMaxWait: time.Second, RetryMax: 5 * time.Second}}
+
+	wait := r.cfg.BatchMaxWait
+	for _, want := range []time.Duration{2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		wait = r.nextFlushWait(wait, true)
+		if wait != want {
+			t.Fatalf("nextFlushWait under backpressure = %v, want %v", wait, want)
+		}
+	}
+	if got := r.nextFlushWait(wait, false); got != time.Second {
+		t.Fatalf("nextFlushWait after recovery = %v, want %v", got, time.Second)
+	}
+}
+
+func TestFlush_MissingTokenIsNotBackpressure(t *testing.T) {
+	db := openTestDB(t)
+	cfg := testConfig("http://localhost", "ws-1")
+	cfg.BatchMaxWait = time.Hour
+	r, err := New(db, cfg)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	defer r.Shutdown()
+
+	_ = r.Enqueue(Message{MessageID: "m1", Role: "user", Content: "hello"})
+	if r.flush() {
+		t.Fatal("flush without a token reported backpressure")
+	}
+	assertOutboxCount(t, db, 1, "without token")
+}
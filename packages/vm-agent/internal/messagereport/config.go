@@ -15,12 +15,17 @@ import (
 // All values have sensible defaults; override via MSG_* environment variables.
 type Config struct {
 	// BatchMaxWait is the maximum time to wait before flushing a partial batch.
+	// After a failed flush the interval doubles up to RetryMax, and resets once
+	// the control plane accepts a batch again.
 	BatchMaxWait time.Duration
 
-	// BatchMaxSize is the maximum number of messages per HTTP POST.
+	// BatchMaxSize is the maximum number of messages per HTTP POST. Enqueueing
+	// this many messages since the last flush triggers an immediate flush.
 	BatchMaxSize int
 
 	// BatchMaxBytes is the maximum marshaled JSON payload size per batch.
+	// Enqueueing this much content since the last flush triggers an immediate
+	// flush.
 	BatchMaxBytes int
 
 	// MaxMessageContentBytes is the maximum message content size before
@@ -44,6 +49,10 @@ type Config struct {
 	// HTTPTimeout is the per-request timeout for batch POST calls.
 	HTTPTimeout time.Duration
 
+	// CompressMinBytes is the marshaled payload size at or above which a batch
+	// is gzip-compressed (Content-Encoding: gzip). Zero disables compression.
+	CompressMinBytes int
+
 	// Endpoint is the control plane URL (without trailing slash).
 	// The batch endpoint will be: {Endpoint}/api/workspaces/{workspaceId}/messages
 	Endpoint string
@@ -73,6 +82,7 @@ func DefaultConfig() Config {
 		RetryMax:               30 * time.Second,
 		RetryMaxElapsed:        5 * time.Minute,
 		HTTPTimeout:            10 * time.Second,
+		CompressMinBytes:       8 * 1024,
 	}
 }
 
@@ -90,6 +100,7 @@ func LoadConfigFromEnv() Config {
 	cfg.RetryMax = envDuration("MSG_RETRY_MAX", cfg.RetryMax)
 	cfg.RetryMaxElapsed = envDuration("MSG_RETRY_MAX_ELAPSED", cfg.RetryMaxElapsed)
 	cfg.HTTPTimeout = envDuration("MSG_HTTP_TIMEOUT", cfg.HTTPTimeout)
+	cfg.CompressMinBytes = envInt("MSG_COMPRESS_MIN_BYTES", cfg.CompressMinBytes)
 
 	cfg.Endpoint = os.Getenv("CONTROL_PLANE_URL")
 	cfg.WorkspaceID = os.Getenv("WORKSPACE_ID")
@@ -2,9 +2,11 @@ package messagereport
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -58,6 +60,13 @@ type Reporter struct {
 	// are held. Acquiring mu first would risk deadlock with flush().
 	flushMu sync.Mutex
 
+	// pendingCount and pendingBytes track what was enqueued since the last
+	// flush so Enqueue can wake the flush loop as soon as a full batch is
+	// ready instead of waiting for BatchMaxWait. Guarded by mu.
+	pendingCount int
+	pendingBytes int
+
+	kickC chan struct{}
 	stopC chan struct{}
 	doneC chan struct{}
 }
@@ -116,6 +125,7 @@ func New(db *sql.DB, cfg Config) (*Reporter, error) {
 		client:      config.NewControlPlaneClient(cfg.HTTPTimeout),
 		workspaceID: cfg.WorkspaceID,
 		sessionID:   cfg.SessionID,
+		kickC:       make(chan struct{}, 1),
 		stopC:       make(chan struct{}),
 		doneC:       make(chan struct{}),
 	}
@@ -288,6 +298,15 @@ func (r *Reporter) Enqueue(msg Message) error {
 	if err != nil {
 		return fmt.Errorf("messagereport: insert outbox: %w", err)
 	}
+
+	r.pendingCount++
+	r.pendingBytes += len(msg.Content) + len(msg.ToolMetadata)
+	if r.pendingCount >= r.cfg.BatchMaxSize || r.pendingBytes >= r.cfg.BatchMaxBytes {
+		select {
+		case r.kickC <- struct{}{}:
+		default:
+		}
+	}
 	return nil
 }
 
@@ -301,44 +320,90 @@ func (r *Reporter) Shutdown() {
 	<-r.doneC
 }
 
+// Flush sends everything in the outbox now instead of waiting for the next
+// scheduled flush, blocking until the outbox is empty or a send fails.
+// Returns false if messages remain queued (control plane unreachable or
+// rejecting batches); they are retried by the background loop as usual.
+func (r *Reporter) Flush() bool {
+	if r == nil {
+		return true
+	}
+	return !r.flush()
+}
+
 // --- background flush loop ---
 
+// flushLoop flushes the outbox every BatchMaxWait, or immediately when
+// Enqueue reports that a full batch is waiting. While the control plane is
+// rejecting batches the interval backs off (see nextFlushWait) and early
+// flushes are suppressed so a busy session does not hammer a struggling API.
 func (r *Reporter) flushLoop() {
 	defer close(r.doneC)
 
-	ticker := time.NewTicker(r.cfg.BatchMaxWait)
-	defer ticker.Stop()
+	wait := r.cfg.BatchMaxWait
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
 
 	for {
 		select {
 		case <-r.stopC:
 			r.flush() // final flush
 			return
-		case <-ticker.C:
-			r.flush()
+		case <-r.kickC:
+			if wait > r.cfg.BatchMaxWait {
+				continue
+			}
+		case <-timer.C:
 		}
+
+		wait = r.nextFlushWait(wait, r.flush())
+		timer.Reset(wait)
+	}
+}
+
+// nextFlushWait returns the delay before the next scheduled flush. A flush
+// that hit backpressure doubles the previous delay up to RetryMax; any other
+// outcome returns to BatchMaxWait.
+func (r *Reporter) nextFlushWait(current time.Duration, backpressure bool) time.Duration {
+	if !backpressure {
+		return r.cfg.BatchMaxWait
+	}
+	next := current * 2
+	if next > r.cfg.RetryMax {
+		next = r.cfg.RetryMax
+	}
+	if next < r.cfg.BatchMaxWait {
+		next = r.cfg.BatchMaxWait
 	}
+	return next
 }
 
 // flush reads the oldest batch from the outbox and sends it.
 // On success the sent rows are deleted; on transient failure they remain
-// (attempts counter is bumped) for retry on the next tick.
+// (attempts counter is bumped) for retry on the next tick. Returns true if a
+// send was attempted and failed, i.e. the control plane is applying
+// backpressure; a missing token or workspace ID is not backpressure.
 //
 // flushMu is held for the duration to serialize with SetSessionID's
 // outbox clear, preventing stale messages from being shipped after a
 // session switch.
-func (r *Reporter) flush() {
+func (r *Reporter) flush() (backpressure bool) {
 	r.flushMu.Lock()
 	defer r.flushMu.Unlock()
 
+	r.mu.Lock()
+	r.pendingCount = 0
+	r.pendingBytes = 0
+	r.mu.Unlock()
+
 	for {
 		batch, err := r.readBatch()
 		if err != nil {
 			slog.Error("messagereport: read batch", "error", err)
-			return
+			return false
 		}
 		if len(batch) == 0 {
-			return
+			return false
 		}
 
 		if err := r.sendBatch(batch); err != nil {
@@ -346,7 +411,7 @@ func (r *Reporter) flush() {
 			// batch was NOT sent and remains in the outbox for the next tick.
 			slog.Warn("messagereport: send batch failed", "error", err, "count", len(batch))
 			r.bumpAttempts(batch)
-			return
+			return !errors.Is(err, errSenderNotReady)
 		}
 
 		// Success — delete sent messages from the outbox.
@@ -365,10 +430,15 @@ type outboxRow struct {
 	origin       sql.NullString
 }
 
+// readBatch returns the oldest outbox rows for a single session, in outbox
+// id order. Batches never mix sessions and flush stops at the first batch
+// that fails, so a session's history always reaches the control plane in
+// the order it was enqueued, even when several sessions share the outbox.
 func (r *Reporter) readBatch() ([]outboxRow, error) {
 	rows, err := r.db.Query(
 		`SELECT id, message_id, session_id, role, content, tool_metadata, created_at, origin
 		 FROM message_outbox
+		 WHERE session_id = (SELECT session_id FROM message_outbox ORDER BY id ASC LIMIT 1)
 		 ORDER BY id ASC
 		 LIMIT ?`,
 		r.cfg.BatchMaxSize,
@@ -457,11 +527,11 @@ func (r *Reporter) sendBatch(batch []outboxRow) error {
 	}
 	if token == "" {
 		// No token yet — leave messages in outbox for later.
-		return fmt.Errorf("no auth token")
+		return fmt.Errorf("no auth token: %w", errSenderNotReady)
 	}
 	if wsID == "" {
 		// No workspace yet — leave messages in outbox for later.
-		return fmt.Errorf("no workspace ID")
+		return fmt.Errorf("no workspace ID: %w", errSenderNotReady)
 	}
 
 	body, err := buildBatchBody(batch)
@@ -474,6 +544,10 @@ func (r *Reporter) sendBatch(batch []outboxRow) error {
 	return r.sendBatchWithRetry(batch, url, token, wsID, body)
 }
 
+// errSenderNotReady marks send failures caused by missing local state (auth
+// token or workspace ID) rather than by the control plane.
+var errSenderNotReady = errors.New("sender not ready")
+
 func (r *Reporter) senderState() (token, workspaceID string, messageLimitReached bool) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -734,11 +808,23 @@ func (r *Reporter) doPost(url, token string, body []byte) (int, string, error) {
 }
 
 func (r *Reporter) doPostWithContext(ctx context.Context, url, token string, body []byte) (int, string, error) {
+	encoding := ""
+	if r.cfg.CompressMinBytes > 0 && len(body) >= r.cfg.CompressMinBytes {
+		compressed, err := gzipBody(body)
+		if err != nil {
+			return 0, "", err
+		}
+		body, encoding = compressed, "gzip"
+	}
+
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
 	if err != nil {
 		return 0, "", err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
 	req.Header.Set("Authorization", "Bearer "+token)
 
 	resp, err := r.client.Do(req)
@@ -750,6 +836,18 @@ func (r *Reporter) doPostWithContext(ctx context.Context, url, token string, bod
 	return resp.StatusCode, responseBody, nil
 }
 
+func gzipBody(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(body); err != nil {
+		return nil, fmt.Errorf("gzip payload: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("gzip payload: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
 const maxLoggedResponseBodyBytes int64 = 2048
 
 func readBoundedHTTPBody(body httpBodyReader) string {
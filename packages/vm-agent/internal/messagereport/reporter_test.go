@@ -488,6 +488,34 @@ func TestShutdown_FinalFlush(t *testing.T) {
 	}
 }
 
+func TestFlush_SendsQueuedMessagesImmediately(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writePersistedCount(w, len(requestMessageIDs(t, r)))
+	}))
+	defer ts.Close()
+
+	db := openTestDB(t)
+	cfg := testConfig(ts.URL, "ws-1")
+	cfg.BatchMaxWait = 10 * time.Second // the scheduled flush must not be what empties the outbox
+	r, err := New(db, cfg)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	defer r.Shutdown()
+	r.SetToken("test-token")
+
+	enqueueAssistantPair(t, r)
+	if !r.Flush() {
+		t.Fatal("Flush reported messages still queued")
+	}
+	assertOutboxCount(t, db, 0, "after flush")
+
+	var nilReporter *Reporter
+	if !nilReporter.Flush() {
+		t.Fatal("nil reporter Flush should report nothing queued")
+	}
+}
+
 func TestNilReporter_NilSafe(t *testing.T) {
 	var r *Reporter
 
@@ -0,0 +1,60 @@
+package messagereport
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/workspace/vm-agent/internal/retention"
+)
+
+// retentionDeleteBatchSize bounds the number of bound parameters per DELETE.
+const retentionDeleteBatchSize = 500
+
+// OutboxRetentionItems lists the transcript messages still held in an outbox
+// database, keyed by message ID, for retention policy enforcement. It works
+// on any outbox database, whether or not a Reporter currently owns it.
+func OutboxRetentionItems(db *sql.DB) ([]retention.Item, error) {
+	if err := migrateOutbox(db); err != nil {
+		return nil, fmt.Errorf("messagereport: migrate outbox: %w", err)
+	}
+	rows, err := db.Query(
+		`SELECT message_id, length(content) + coalesce(length(tool_metadata), 0), created_at FROM message_outbox`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []retention.Item
+	for rows.Next() {
+		var item retention.Item
+		var createdAt string
+		if err := rows.Scan(&item.ID, &item.Bytes, &createdAt); err != nil {
+			return nil, err
+		}
+		item.CreatedAt = retention.ParseTimestamp(createdAt)
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// DeleteOutboxRetentionItems deletes outbox messages by message ID. Deleted
+// messages are never delivered to the control plane.
+func DeleteOutboxRetentionItems(db *sql.DB, messageIDs []string) (int64, error) {
+	var deleted int64
+	for start := 0; start < len(messageIDs); start += retentionDeleteBatchSize {
+		batch := messageIDs[start:min(start+retentionDeleteBatchSize, len(messageIDs))]
+		args := make([]interface{}, len(batch))
+		for i, id := range batch {
+			args[i] = id
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(batch)), ",")
+		result, err := db.Exec(`DELETE FROM message_outbox WHERE message_id IN (`+placeholders+`)`, args...)
+		if err != nil {
+			return deleted, fmt.Errorf("messagereport: delete outbox messages: %w", err)
+		}
+		n, _ := result.RowsAffected()
+		deleted += n
+	}
+	return deleted, nil
+}
@@ -0,0 +1,274 @@
+// Package notes provides a SQLite-backed store for free-form workspace notes.
+// Users and agents use notes to leave TODOs and follow-ups attached to a
+// workspace ("remaining follow-ups from last session") that survive agent
+// restarts and travel with the workspace through hibernate/restore.
+package notes
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+var (
+	// ErrNotFound is returned when a note does not exist in the workspace.
+	ErrNotFound = errors.New("note not found")
+	// ErrLimitReached is returned by Create when the workspace already holds
+	// the maximum number of notes.
+	ErrLimitReached = errors.New("workspace note limit reached")
+)
+
+// Note is a single free-form note attached to a workspace.
+type Note struct {
+	ID          string `json:"id"`
+	WorkspaceID string `json:"workspaceId"`
+	Content     string `json:"content"`
+	// Author is a free-form attribution such as "user" or the agent type.
+	Author    string `json:"author,omitempty"`
+	Done      bool   `json:"done"`
+	CreatedAt string `json:"createdAt"`
+	UpdatedAt string `json:"updatedAt"`
+}
+
+// Update describes a partial note update; nil fields are left unchanged.
+type Update struct {
+	Content *string
+	Done    *bool
+}
+
+// Store is a SQLite-backed notes store.
+type Store struct {
+	db       *sql.DB
+	maxNotes int
+	mu       sync.Mutex // serializes writes so the per-workspace limit holds
+}
+
+// New opens (or creates) a SQLite notes store at the given path. maxNotes
+// caps the number of notes per workspace; zero means unlimited.
+func New(dbPath string, maxNotes int) (*Store, error) {
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?cache=shared&mode=rwc&_journal_mode=WAL", dbPath))
+	if err != nil {
+		return nil, fmt.Errorf("notes: open: %w", err)
+	}
+	for _, pragma := range []string{
+		"PRAGMA journal_mode=WAL",
+		"PRAGMA busy_timeout=5000",
+		"PRAGMA synchronous=NORMAL",
+	} {
+		if _, err := db.Exec(pragma); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("notes: %s: %w", pragma, err)
+		}
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("notes: migrate: %w", err)
+	}
+	return &Store{db: db, maxNotes: maxNotes}, nil
+}
+
+func migrate(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS workspace_notes (
+			id           TEXT PRIMARY KEY,
+			workspace_id TEXT NOT NULL,
+			content      TEXT NOT NULL,
+			author       TEXT NOT NULL DEFAULT '',
+			done         INTEGER NOT NULL DEFAULT 0,
+			created_at   TEXT NOT NULL,
+			updated_at   TEXT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_workspace_notes_workspace ON workspace_notes(workspace_id, created_at);
+	`)
+	return err
+}
+
+// Create adds a note to the workspace.
+func (s *Store) Create(workspaceID, content, author string) (Note, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maxNotes > 0 {
+		var count int
+		if err := s.db.QueryRow(
+			`SELECT COUNT(*) FROM workspace_notes WHERE workspace_id = ?`, workspaceID,
+		).Scan(&count); err != nil {
+			return Note{}, fmt.Errorf("notes: count: %w", err)
+		}
+		if count >= s.maxNotes {
+			return Note{}, ErrLimitReached
+		}
+	}
+
+	now := nowString()
+	n := Note{
+		ID:          newNoteID(),
+		WorkspaceID: workspaceID,
+		Content:     content,
+		Author:      author,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if _, err := s.db.Exec(
+		`INSERT INTO workspace_notes (id, workspace_id, content, author, done, created_at, updated_at)
+		 VALUES (?, ?, ?, ?, 0, ?, ?)`,
+		n.ID, n.WorkspaceID, n.Content, n.Author, n.CreatedAt, n.UpdatedAt,
+	); err != nil {
+		return Note{}, fmt.Errorf("notes: insert: %w", err)
+	}
+	return n, nil
+}
+
+// Get returns a single note, or ErrNotFound.
+func (s *Store) Get(workspaceID, id string) (Note, error) {
+	row := s.db.QueryRow(
+		`SELECT id, workspace_id, content, author, done, created_at, updated_at
+		 FROM workspace_notes WHERE workspace_id = ? AND id = ?`,
+		workspaceID, id,
+	)
+	n, err := scanNote(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Note{}, ErrNotFound
+	}
+	return n, err
+}
+
+// List returns the workspace's notes, oldest first.
+func (s *Store) List(workspaceID string) ([]Note, error) {
+	rows, err := s.db.Query(
+		`SELECT id, workspace_id, content, author, done, created_at, updated_at
+		 FROM workspace_notes WHERE workspace_id = ? ORDER BY created_at ASC, id ASC`,
+		workspaceID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notes := []Note{}
+	for rows.Next() {
+		n, err := scanNote(rows)
+		if err != nil {
+			return nil, err
+		}
+		notes = append(notes, n)
+	}
+	return notes, rows.Err()
+}
+
+// Update applies a partial update and returns the updated note, or
+// ErrNotFound.
+func (s *Store) Update(workspaceID, id string, u Update) (Note, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, err := s.Get(workspaceID, id)
+	if err != nil {
+		return Note{}, err
+	}
+	if u.Content != nil {
+		n.Content = *u.Content
+	}
+	if u.Done != nil {
+		n.Done = *u.Done
+	}
+	n.UpdatedAt = nowString()
+	if _, err := s.db.Exec(
+		`UPDATE workspace_notes SET content = ?, done = ?, updated_at = ? WHERE workspace_id = ? AND id = ?`,
+		n.Content, n.Done, n.UpdatedAt, workspaceID, id,
+	); err != nil {
+		return Note{}, fmt.Errorf("notes: update: %w", err)
+	}
+	return n, nil
+}
+
+// Delete removes a note, or returns ErrNotFound.
+func (s *Store) Delete(workspaceID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result, err := s.db.Exec(`DELETE FROM workspace_notes WHERE workspace_id = ? AND id = ?`, workspaceID, id)
+	if err != nil {
+		return fmt.Errorf("notes: delete: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteWorkspace removes every note belonging to a workspace.
+func (s *Store) DeleteWorkspace(workspaceID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.db.Exec(`DELETE FROM workspace_notes WHERE workspace_id = ?`, workspaceID); err != nil {
+		return fmt.Errorf("notes: delete workspace: %w", err)
+	}
+	return nil
+}
+
+// Import restores previously exported notes into a workspace, keeping their
+// IDs and timestamps. Existing notes with the same ID are overwritten, so
+// importing the same export twice is idempotent.
+func (s *Store) Import(workspaceID string, notes []Note) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("notes: import: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // no-op after commit
+
+	for _, n := range notes {
+		if n.ID == "" {
+			n.ID = newNoteID()
+		}
+		if n.CreatedAt == "" {
+			n.CreatedAt = nowString()
+		}
+		if n.UpdatedAt == "" {
+			n.UpdatedAt = n.CreatedAt
+		}
+		if _, err := tx.Exec(
+			`INSERT OR REPLACE INTO workspace_notes (id, workspace_id, content, author, done, created_at, updated_at)
+			 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			n.ID, workspaceID, n.Content, n.Author, n.Done, n.CreatedAt, n.UpdatedAt,
+		); err != nil {
+			return fmt.Errorf("notes: import %s: %w", n.ID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Close closes the underlying database connection.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanNote(row rowScanner) (Note, error) {
+	var n Note
+	err := row.Scan(&n.ID, &n.WorkspaceID, &n.Content, &n.Author, &n.Done, &n.CreatedAt, &n.UpdatedAt)
+	return n, err
+}
+
+func nowString() string {
+	return time.Now().UTC().Format(time.RFC3339Nano)
+}
+
+func newNoteID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return "note-" + hex.EncodeToString(b)
+}
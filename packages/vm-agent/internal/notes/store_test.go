@@ -0,0 +1,106 @@
+package notes
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func newTestStore(t *testing.T, maxNotes int) *Store {
+	t.Helper()
+	s, err := New(filepath.Join(t.TempDir(), "notes.db"), maxNotes)
+	if err != nil {
+		t.Fatalf("notes.New: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func TestCreateListUpdateDelete(t *testing.T) {
+	s := newTestStore(t, 0)
+
+	first, err := s.Create("ws-1", "rerun flaky e2e suite", "user")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := s.Create("ws-1", "bump go toolchain", "claude-code"); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := s.Create("ws-2", "other workspace", "user"); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	list, err := s.List("ws-1")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(list) != 2 || list[0].ID != first.ID || list[1].Author != "claude-code" {
+		t.Fatalf("List(ws-1) = %+v", list)
+	}
+
+	done := true
+	content := "rerun flaky e2e suite (passed)"
+	updated, err := s.Update("ws-1", first.ID, Update{Content: &content, Done: &done})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if !updated.Done || updated.Content != content || updated.UpdatedAt == first.UpdatedAt {
+		t.Fatalf("unexpected updated note: %+v", updated)
+	}
+
+	// Notes are scoped to their workspace.
+	if _, err := s.Update("ws-2", first.ID, Update{Done: &done}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("cross-workspace Update error = %v, want ErrNotFound", err)
+	}
+	if err := s.Delete("ws-2", first.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("cross-workspace Delete error = %v, want ErrNotFound", err)
+	}
+
+	if err := s.Delete("ws-1", first.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Get("ws-1", first.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get after delete error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestCreateEnforcesPerWorkspaceLimit(t *testing.T) {
+	s := newTestStore(t, 1)
+
+	if _, err := s.Create("ws-1", "one", ""); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := s.Create("ws-1", "two", ""); !errors.Is(err, ErrLimitReached) {
+		t.Fatalf("Create over limit error = %v, want ErrLimitReached", err)
+	}
+	if _, err := s.Create("ws-2", "other", ""); err != nil {
+		t.Fatalf("limit must be per workspace: %v", err)
+	}
+}
+
+func TestImportIsIdempotentAndPreservesIdentity(t *testing.T) {
+	src := newTestStore(t, 0)
+	n, _ := src.Create("ws-1", "follow up on review comments", "user")
+	exported, _ := src.List("ws-1")
+
+	dst := newTestStore(t, 0)
+	for i := 0; i < 2; i++ {
+		if err := dst.Import("ws-1", exported); err != nil {
+			t.Fatalf("Import #%d: %v", i, err)
+		}
+	}
+	got, err := dst.List("ws-1")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(got) != 1 || got[0].ID != n.ID || got[0].CreatedAt != n.CreatedAt {
+		t.Fatalf("imported notes = %+v, want single note %s", got, n.ID)
+	}
+
+	if err := dst.DeleteWorkspace("ws-1"); err != nil {
+		t.Fatalf("DeleteWorkspace: %v", err)
+	}
+	if got, _ := dst.List("ws-1"); len(got) != 0 {
+		t.Fatalf("notes after DeleteWorkspace = %+v", got)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
@@ -45,7 +46,10 @@ type WorkspaceMetadata struct {
 	RepositoryPath         string `json:"repositoryPath,omitempty"`
 	Lightweight            bool   `json:"lightweight"`
 	DevcontainerConfigName string `json:"devcontainerConfigName,omitempty"`
-	UpdatedAt              string `json:"updatedAt"`
+	// CredentialRemotes maps remotes added through the git remotes API to
+	// their repository paths; see WorkspaceRuntime.CredentialRemotes.
+	CredentialRemotes map[string]string `json:"credentialRemotes,omitempty"`
+	UpdatedAt         string            `json:"updatedAt"`
 }
 
 // Tab represents a persisted tab (terminal or chat session).
@@ -59,6 +63,7 @@ type Tab struct {
 	CreatedAt    string `json:"createdAt"`    // ISO 8601
 	AcpSessionID string `json:"acpSessionId"` // ACP session ID for LoadSession on reconnect
 	LastPrompt   string `json:"lastPrompt"`   // Last user message for session discoverability
+	WorkDir      string `json:"workDir"`      // Container working directory (worktree) for chat tabs; empty uses the workspace default
 }
 
 // Store provides persistent session state backed by SQLite.
@@ -149,6 +154,11 @@ func (s *Store) migrate() error {
 		migrateV7,
 		migrateV8,
 		migrateV9,
+		migrateV10,
+		migrateV11,
+		migrateV12,
+		migrateV13,
+		migrateV14,
 	}
 
 	for i := version; i < len(migrations); i++ {
@@ -227,14 +237,23 @@ func (s *Store) UpsertWorkspaceMetadata(meta WorkspaceMetadata) error {
 		return err
 	}
 
+	credentialRemotes := ""
+	if len(meta.CredentialRemotes) > 0 {
+		encoded, err := json.Marshal(meta.CredentialRemotes)
+		if err != nil {
+			return fmt.Errorf("encode credential remotes: %w", err)
+		}
+		credentialRemotes = string(encoded)
+	}
+
 	_, err = s.db.Exec(
 		`INSERT OR REPLACE INTO workspace_metadata
-			(workspace_id, repository, branch, container_work_dir, container_user, container_label_value, workspace_dir, callback_token, repo_provider, clone_url, repository_host, repository_path, lightweight, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			(workspace_id, repository, branch, container_work_dir, container_user, container_label_value, workspace_dir, callback_token, repo_provider, clone_url, repository_host, repository_path, lightweight, credential_remotes, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		meta.WorkspaceID, meta.Repository, meta.Branch, meta.ContainerWorkDir,
 		meta.ContainerUser, meta.ContainerLabelVal, meta.WorkspaceDir, callbackToken,
 		meta.RepoProvider, meta.CloneURL, meta.RepositoryHost, meta.RepositoryPath,
-		meta.Lightweight, meta.UpdatedAt,
+		meta.Lightweight, credentialRemotes, meta.UpdatedAt,
 	)
 	if err != nil {
 		return fmt.Errorf("upsert workspace metadata: %w", err)
@@ -249,20 +268,26 @@ func (s *Store) GetWorkspaceMetadata(workspaceID string) (*WorkspaceMetadata, er
 	defer s.mu.RUnlock()
 
 	var m WorkspaceMetadata
+	var credentialRemotes string
 	err := s.db.QueryRow(
-		`SELECT workspace_id, repository, branch, container_work_dir, container_user, container_label_value, workspace_dir, callback_token, repo_provider, clone_url, repository_host, repository_path, lightweight, updated_at
+		`SELECT workspace_id, repository, branch, container_work_dir, container_user, container_label_value, workspace_dir, callback_token, repo_provider, clone_url, repository_host, repository_path, lightweight, credential_remotes, updated_at
 		FROM workspace_metadata WHERE workspace_id = ?`,
 		workspaceID,
 	).Scan(&m.WorkspaceID, &m.Repository, &m.Branch, &m.ContainerWorkDir,
 		&m.ContainerUser, &m.ContainerLabelVal, &m.WorkspaceDir, &m.CallbackToken,
 		&m.RepoProvider, &m.CloneURL, &m.RepositoryHost, &m.RepositoryPath,
-		&m.Lightweight, &m.UpdatedAt)
+		&m.Lightweight, &credentialRemotes, &m.UpdatedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, fmt.Errorf("get workspace metadata: %w", err)
 	}
+	if credentialRemotes != "" {
+		if err := json.Unmarshal([]byte(credentialRemotes), &m.CredentialRemotes); err != nil {
+			return nil, fmt.Errorf("decode credential remotes: %w", err)
+		}
+	}
 	m.CallbackToken, err = s.decryptCallbackTokenLocked(m.CallbackToken)
 	if err != nil {
 		return nil, err
@@ -334,8 +359,8 @@ func (s *Store) InsertTab(tab Tab) error {
 	}
 
 	_, err := s.db.Exec(
-		"INSERT OR REPLACE INTO tabs (id, workspace_id, type, label, agent_id, sort_order, created_at, acp_session_id, last_prompt) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
-		tab.ID, tab.WorkspaceID, tab.Type, tab.Label, tab.AgentID, tab.SortOrder, tab.CreatedAt, tab.AcpSessionID, tab.LastPrompt,
+		"INSERT OR REPLACE INTO tabs (id, workspace_id, type, label, agent_id, sort_order, created_at, acp_session_id, last_prompt, work_dir) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		tab.ID, tab.WorkspaceID, tab.Type, tab.Label, tab.AgentID, tab.SortOrder, tab.CreatedAt, tab.AcpSessionID, tab.LastPrompt, tab.WorkDir,
 	)
 	if err != nil {
 		return fmt.Errorf("insert tab: %w", err)
@@ -343,7 +368,7 @@ func (s *Store) InsertTab(tab Tab) error {
 	return nil
 }
 
-// DeleteTab removes a tab from the store.
+// DeleteTab removes a tab and its persisted replay buffer from the store.
 func (s *Store) DeleteTab(tabID string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -352,6 +377,9 @@ func (s *Store) DeleteTab(tabID string) error {
 	if err != nil {
 		return fmt.Errorf("delete tab: %w", err)
 	}
+	if _, err := s.db.Exec("DELETE FROM tab_messages WHERE tab_id = ?", tabID); err != nil {
+		return fmt.Errorf("delete tab messages: %w", err)
+	}
 	return nil
 }
 
@@ -361,7 +389,7 @@ func (s *Store) ListTabs(workspaceID string) ([]Tab, error) {
 	defer s.mu.RUnlock()
 
 	rows, err := s.db.Query(
-		"SELECT id, workspace_id, type, label, agent_id, sort_order, created_at, acp_session_id, last_prompt FROM tabs WHERE workspace_id = ? ORDER BY sort_order ASC, created_at ASC",
+		"SELECT id, workspace_id, type, label, agent_id, sort_order, created_at, acp_session_id, last_prompt, work_dir FROM tabs WHERE workspace_id = ? ORDER BY sort_order ASC, created_at ASC",
 		workspaceID,
 	)
 	if err != nil {
@@ -372,7 +400,7 @@ func (s *Store) ListTabs(workspaceID string) ([]Tab, error) {
 	var tabs []Tab
 	for rows.Next() {
 		var t Tab
-		if err := rows.Scan(&t.ID, &t.WorkspaceID, &t.Type, &t.Label, &t.AgentID, &t.SortOrder, &t.CreatedAt, &t.AcpSessionID, &t.LastPrompt); err != nil {
+		if err := rows.Scan(&t.ID, &t.WorkspaceID, &t.Type, &t.Label, &t.AgentID, &t.SortOrder, &t.CreatedAt, &t.AcpSessionID, &t.LastPrompt, &t.WorkDir); err != nil {
 			return nil, fmt.Errorf("scan tab: %w", err)
 		}
 		tabs = append(tabs, t)
@@ -387,7 +415,7 @@ func (s *Store) ListTabs(workspaceID string) ([]Tab, error) {
 	return tabs, nil
 }
 
-// DeleteWorkspaceTabs removes all tabs for a workspace.
+// DeleteWorkspaceTabs removes all tabs and their replay buffers for a workspace.
 func (s *Store) DeleteWorkspaceTabs(workspaceID string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -396,6 +424,9 @@ func (s *Store) DeleteWorkspaceTabs(workspaceID string) error {
 	if err != nil {
 		return fmt.Errorf("delete workspace tabs: %w", err)
 	}
+	if _, err := s.db.Exec("DELETE FROM tab_messages WHERE workspace_id = ?", workspaceID); err != nil {
+		return fmt.Errorf("delete workspace tab messages: %w", err)
+	}
 	return nil
 }
 
@@ -450,6 +481,18 @@ func (s *Store) UpdateTabAcpSessionID(tabID, acpSessionID string) error {
 	return nil
 }
 
+// UpdateTabWorkDir updates the container working directory for a tab.
+func (s *Store) UpdateTabWorkDir(tabID, workDir string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.db.Exec("UPDATE tabs SET work_dir = ? WHERE id = ?", workDir, tabID)
+	if err != nil {
+		return fmt.Errorf("update tab work dir: %w", err)
+	}
+	return nil
+}
+
 // TabCount returns the number of tabs for a workspace.
 func (s *Store) TabCount(workspaceID string) (int, error) {
 	s.mu.RLock()
@@ -505,6 +548,20 @@ func migrateV9(db *sql.DB) error {
 	return err
 }
 
+// migrateV11 adds work_dir column so each chat tab can run its agent in its
+// own worktree.
+func migrateV11(db *sql.DB) error {
+	_, err := db.Exec(`ALTER TABLE tabs ADD COLUMN work_dir TEXT NOT NULL DEFAULT ''`)
+	return err
+}
+
+// migrateV14 adds credential_remotes to workspace_metadata so remotes added
+// through the git remotes API keep their credentials across agent restarts.
+func migrateV14(db *sql.DB) error {
+	_, err := db.Exec(`ALTER TABLE workspace_metadata ADD COLUMN credential_remotes TEXT NOT NULL DEFAULT ''`)
+	return err
+}
+
 // UpsertSessionMcpServers replaces all MCP server entries for a session.
 // Passing an empty slice removes all servers for the session without error.
 // This is intentionally a full replace (delete + insert) so that the
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"regexp"
 	"time"
+
+	"github.com/workspace/vm-agent/internal/retention"
 )
 
 type JobRecord struct {
@@ -209,6 +211,79 @@ func (s *Store) MarkActiveJobsInterrupted() error {
 	return nil
 }
 
+// CompletedJobRetentionItems lists completed jobs of a kind for a scope with
+// the approximate stored size of each job and its events. Jobs that are still
+// running are never listed.
+func (s *Store) CompletedJobRetentionItems(kind, scopeID string) ([]retention.Item, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows, err := s.db.Query(
+		`SELECT j.id,
+			length(j.id) + length(j.current_step) + length(j.error_message) + length(j.result_json) + coalesce(
+				(SELECT sum(length(e.message) + length(e.error_message) + length(e.detail_json))
+				 FROM vm_job_events e WHERE e.job_id = j.id), 0),
+			j.completed_at
+		FROM vm_jobs j WHERE j.kind = ? AND j.scope_id = ? AND j.completed_at != ''`,
+		kind, scopeID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list completed vm jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var items []retention.Item
+	for rows.Next() {
+		var item retention.Item
+		var completedAt string
+		if err := rows.Scan(&item.ID, &item.Bytes, &completedAt); err != nil {
+			return nil, fmt.Errorf("scan completed vm job: %w", err)
+		}
+		item.CreatedAt = retention.ParseTimestamp(completedAt)
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate completed vm jobs: %w", err)
+	}
+	return items, nil
+}
+
+// DeleteCompletedJobs deletes completed jobs of a kind for a scope, with
+// their events, and returns how many jobs were deleted.
+func (s *Store) DeleteCompletedJobs(kind, scopeID string, jobIDs []string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("begin delete vm jobs: %w", err)
+	}
+	defer tx.Rollback()
+
+	var deleted int64
+	for _, jobID := range jobIDs {
+		result, err := tx.Exec(
+			`DELETE FROM vm_jobs WHERE id = ? AND kind = ? AND scope_id = ? AND completed_at != ''`,
+			jobID, kind, scopeID,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("delete vm job: %w", err)
+		}
+		n, _ := result.RowsAffected()
+		if n == 0 {
+			continue
+		}
+		if _, err := tx.Exec(`DELETE FROM vm_job_events WHERE job_id = ?`, jobID); err != nil {
+			return 0, fmt.Errorf("delete vm job events: %w", err)
+		}
+		deleted += n
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit delete vm jobs: %w", err)
+	}
+	return deleted, nil
+}
+
 func firstNonEmpty(values ...string) string {
 	for _, value := range values {
 		if value != "" {
@@ -0,0 +1,116 @@
+package persistence
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// TabMessage is one entry of a chat tab's SessionHost replay buffer.
+type TabMessage struct {
+	Seq       uint64
+	Data      []byte
+	Timestamp time.Time
+}
+
+// migrateV12 creates the tab_messages table holding each chat tab's replay
+// buffer so late-join replay survives VM agent restarts.
+func migrateV12(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS tab_messages (
+			tab_id TEXT NOT NULL,
+			workspace_id TEXT NOT NULL,
+			seq INTEGER NOT NULL,
+			data BLOB NOT NULL,
+			created_at TEXT NOT NULL,
+			PRIMARY KEY (tab_id, seq)
+		);
+		CREATE INDEX IF NOT EXISTS idx_tab_messages_workspace ON tab_messages(workspace_id);
+	`)
+	return err
+}
+
+// AppendTabMessages stores messages for a tab, then evicts the oldest rows so
+// at most keep messages remain — the same policy as the in-memory buffer.
+// keep <= 0 disables eviction.
+func (s *Store) AppendTabMessages(workspaceID, tabID string, messages []TabMessage, keep int) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("append tab messages: begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmt, err := tx.Prepare(
+		"INSERT OR REPLACE INTO tab_messages (tab_id, workspace_id, seq, data, created_at) VALUES (?, ?, ?, ?, ?)",
+	)
+	if err != nil {
+		return fmt.Errorf("append tab messages: prepare: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, msg := range messages {
+		if _, err := stmt.Exec(tabID, workspaceID, int64(msg.Seq), msg.Data, msg.Timestamp.UTC().Format(time.RFC3339Nano)); err != nil {
+			return fmt.Errorf("append tab messages: insert seq %d: %w", msg.Seq, err)
+		}
+	}
+
+	if keep > 0 {
+		if _, err := tx.Exec(
+			`DELETE FROM tab_messages WHERE tab_id = ? AND seq <= (
+				SELECT seq FROM tab_messages WHERE tab_id = ? ORDER BY seq DESC LIMIT 1 OFFSET ?
+			)`,
+			tabID, tabID, keep,
+		); err != nil {
+			return fmt.Errorf("append tab messages: evict: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("append tab messages: commit: %w", err)
+	}
+	return nil
+}
+
+// ListTabMessages returns the persisted replay buffer for a tab, ordered by
+// sequence number. Returns an empty (non-nil) slice when none exist.
+func (s *Store) ListTabMessages(tabID string) ([]TabMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows, err := s.db.Query(
+		"SELECT seq, data, created_at FROM tab_messages WHERE tab_id = ? ORDER BY seq ASC",
+		tabID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list tab messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages := []TabMessage{}
+	for rows.Next() {
+		var (
+			msg       TabMessage
+			seq       int64
+			createdAt string
+		)
+		if err := rows.Scan(&seq, &msg.Data, &createdAt); err != nil {
+			return nil, fmt.Errorf("list tab messages: scan: %w", err)
+		}
+		msg.Seq = uint64(seq)
+		if msg.Timestamp, err = time.Parse(time.RFC3339Nano, createdAt); err != nil {
+			return nil, fmt.Errorf("list tab messages: parse timestamp: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list tab messages: iterate: %w", err)
+	}
+	return messages, nil
+}
@@ -0,0 +1,94 @@
+package persistence
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// OfflineEntry is a last-known control-plane response cached for use while
+// the control plane is unreachable.
+type OfflineEntry struct {
+	Payload  string
+	StoredAt time.Time
+}
+
+// migrateV10 creates the offline_cache table holding encrypted last-known
+// agent credentials and settings.
+func migrateV10(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS offline_cache (
+			workspace_id TEXT NOT NULL,
+			agent_type TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			stored_at TEXT NOT NULL,
+			PRIMARY KEY (workspace_id, agent_type, kind)
+		)
+	`)
+	return err
+}
+
+// PutOfflineEntry stores payload for the workspace, agent type and kind,
+// replacing any previous entry. The payload is always encrypted; writes fail
+// when token encryption has not been configured.
+func (s *Store) PutOfflineEntry(workspaceID, agentType, kind, payload string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.callbackTokenAE == nil {
+		return fmt.Errorf("offline cache encryption is not configured")
+	}
+	sealed, err := s.encryptCallbackTokenLocked(payload)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(
+		`INSERT OR REPLACE INTO offline_cache (workspace_id, agent_type, kind, payload, stored_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		workspaceID, agentType, kind, sealed, time.Now().UTC().Format(time.RFC3339Nano),
+	)
+	if err != nil {
+		return fmt.Errorf("put offline entry: %w", err)
+	}
+	return nil
+}
+
+// GetOfflineEntry returns the cached entry for the workspace, agent type and
+// kind. Returns nil, nil if nothing is cached.
+func (s *Store) GetOfflineEntry(workspaceID, agentType, kind string) (*OfflineEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var sealed, storedAt string
+	err := s.db.QueryRow(
+		`SELECT payload, stored_at FROM offline_cache WHERE workspace_id = ? AND agent_type = ? AND kind = ?`,
+		workspaceID, agentType, kind,
+	).Scan(&sealed, &storedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get offline entry: %w", err)
+	}
+	payload, err := s.decryptCallbackTokenLocked(sealed)
+	if err != nil {
+		return nil, err
+	}
+	at, err := time.Parse(time.RFC3339Nano, storedAt)
+	if err != nil {
+		return nil, fmt.Errorf("parse offline entry timestamp: %w", err)
+	}
+	return &OfflineEntry{Payload: payload, StoredAt: at}, nil
+}
+
+// DeleteOfflineEntries removes every cached entry for a workspace.
+func (s *Store) DeleteOfflineEntries(workspaceID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.db.Exec("DELETE FROM offline_cache WHERE workspace_id = ?", workspaceID); err != nil {
+		return fmt.Errorf("delete offline entries: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package persistence
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// OutboxCallback is a control-plane callback that could not be delivered and
+// is waiting to be retried. Path is relative to the control-plane URL; the
+// callback token is resolved at delivery time and never stored.
+type OutboxCallback struct {
+	ID          int64
+	WorkspaceID string
+	Path        string
+	Payload     []byte
+	Attempts    int
+	LastError   string
+	CreatedAt   time.Time
+}
+
+// migrateV13 creates the callback_outbox table holding undelivered
+// non-critical callbacks so they survive VM agent restarts.
+func migrateV13(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS callback_outbox (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			workspace_id TEXT NOT NULL,
+			path TEXT NOT NULL,
+			payload BLOB NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_callback_outbox_workspace ON callback_outbox(workspace_id);
+	`)
+	return err
+}
+
+// EnqueueCallback appends a callback to the outbox, then evicts the oldest
+// entries so at most keep remain. keep <= 0 disables eviction.
+func (s *Store) EnqueueCallback(workspaceID, path string, payload []byte, keep int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("enqueue callback: begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	if _, err := tx.Exec(
+		"INSERT INTO callback_outbox (workspace_id, path, payload, created_at) VALUES (?, ?, ?, ?)",
+		workspaceID, path, payload, time.Now().UTC().Format(time.RFC3339Nano),
+	); err != nil {
+		return fmt.Errorf("enqueue callback: insert: %w", err)
+	}
+
+	if keep > 0 {
+		if _, err := tx.Exec(
+			`DELETE FROM callback_outbox WHERE id <= (
+				SELECT id FROM callback_outbox ORDER BY id DESC LIMIT 1 OFFSET ?
+			)`,
+			keep,
+		); err != nil {
+			return fmt.Errorf("enqueue callback: evict: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("enqueue callback: commit: %w", err)
+	}
+	return nil
+}
+
+// PendingCallbacks returns up to limit queued callbacks, oldest first.
+func (s *Store) PendingCallbacks(limit int) ([]OutboxCallback, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows, err := s.db.Query(
+		`SELECT id, workspace_id, path, payload, attempts, last_error, created_at
+		FROM callback_outbox ORDER BY id ASC LIMIT ?`,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list pending callbacks: %w", err)
+	}
+	defer rows.Close()
+
+	var callbacks []OutboxCallback
+	for rows.Next() {
+		var cb OutboxCallback
+		var createdAt string
+		if err := rows.Scan(&cb.ID, &cb.WorkspaceID, &cb.Path, &cb.Payload, &cb.Attempts, &cb.LastError, &createdAt); err != nil {
+			return nil, fmt.Errorf("scan pending callback: %w", err)
+		}
+		if cb.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt); err != nil {
+			return nil, fmt.Errorf("parse pending callback timestamp: %w", err)
+		}
+		callbacks = append(callbacks, cb)
+	}
+	return callbacks, rows.Err()
+}
+
+// RecordCallbackFailure increments a queued callback's attempt count and
+// records the most recent error.
+func (s *Store) RecordCallbackFailure(id int64, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.db.Exec(
+		"UPDATE callback_outbox SET attempts = attempts + 1, last_error = ? WHERE id = ?",
+		lastError, id,
+	); err != nil {
+		return fmt.Errorf("record callback failure: %w", err)
+	}
+	return nil
+}
+
+// DeleteCallback removes a delivered or abandoned callback.
+func (s *Store) DeleteCallback(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.db.Exec("DELETE FROM callback_outbox WHERE id = ?", id); err != nil {
+		return fmt.Errorf("delete callback: %w", err)
+	}
+	return nil
+}
+
+// DeleteWorkspaceCallbacks removes every queued callback for a workspace.
+func (s *Store) DeleteWorkspaceCallbacks(workspaceID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.db.Exec("DELETE FROM callback_outbox WHERE workspace_id = ?", workspaceID); err != nil {
+		return fmt.Errorf("delete workspace callbacks: %w", err)
+	}
+	return nil
+}
@@ -1,10 +1,13 @@
 package persistence
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func tempDBPath(t *testing.T) string {
@@ -1004,6 +1007,33 @@ func TestWorkspaceMetadataLightweightRoundTrip(t *testing.T) {
 	}
 }
 
+func TestWorkspaceMetadataCredentialRemotesRoundTrip(t *testing.T) {
+	store, err := Open(tempDBPath(t))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer store.Close()
+
+	remotes := map[string]string{"upstream": "group/upstream", "fork": "me/fork"}
+	if err := store.UpsertWorkspaceMetadata(WorkspaceMetadata{WorkspaceID: "ws-remotes", CredentialRemotes: remotes}); err != nil {
+		t.Fatalf("UpsertWorkspaceMetadata: %v", err)
+	}
+	meta, err := store.GetWorkspaceMetadata("ws-remotes")
+	if err != nil || meta == nil {
+		t.Fatalf("GetWorkspaceMetadata = %+v, %v", meta, err)
+	}
+	if !reflect.DeepEqual(meta.CredentialRemotes, remotes) {
+		t.Fatalf("CredentialRemotes = %v, want %v", meta.CredentialRemotes, remotes)
+	}
+
+	if err := store.UpsertWorkspaceMetadata(WorkspaceMetadata{WorkspaceID: "ws-remotes"}); err != nil {
+		t.Fatalf("UpsertWorkspaceMetadata: %v", err)
+	}
+	if meta, _ := store.GetWorkspaceMetadata("ws-remotes"); meta == nil || meta.CredentialRemotes != nil {
+		t.Fatalf("CredentialRemotes after clearing = %+v", meta)
+	}
+}
+
 func TestWorkspaceMetadataLightweightDefaultsFalse(t *testing.T) {
 	store, err := Open(tempDBPath(t))
 	if err != nil {
@@ -1079,3 +1109,181 @@ func TestMigrationV3AddsLastPromptColumn(t *testing.T) {
 		t.Errorf("expected LastPrompt persisted after reopen, got %q", tabs[0].LastPrompt)
 	}
 }
+
+func TestOfflineEntriesAreEncryptedAndScopedToWorkspace(t *testing.T) {
+	dbPath := tempDBPath(t)
+	store, err := Open(dbPath)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.PutOfflineEntry("ws-1", "claude-code", "agent-key", `{"credential":"sk-secret"}`); err == nil {
+		t.Fatal("expected PutOfflineEntry to fail without encryption configured")
+	}
+	configureTestTokenEncryption(t, store)
+
+	if err := store.PutOfflineEntry("ws-1", "claude-code", "agent-key", `{"credential":"sk-secret"}`); err != nil {
+		t.Fatalf("PutOfflineEntry: %v", err)
+	}
+	var raw string
+	if err := store.db.QueryRow("SELECT payload FROM offline_cache").Scan(&raw); err != nil {
+		t.Fatalf("read raw payload: %v", err)
+	}
+	if strings.Contains(raw, "sk-secret") {
+		t.Fatalf("offline payload stored in plaintext: %q", raw)
+	}
+
+	entry, err := store.GetOfflineEntry("ws-1", "claude-code", "agent-key")
+	if err != nil || entry == nil {
+		t.Fatalf("GetOfflineEntry = %v, %v", entry, err)
+	}
+	if entry.Payload != `{"credential":"sk-secret"}` || entry.StoredAt.IsZero() {
+		t.Fatalf("entry = %+v", entry)
+	}
+	if entry, err := store.GetOfflineEntry("ws-2", "claude-code", "agent-key"); err != nil || entry != nil {
+		t.Fatalf("other workspace entry = %v, %v; want nil", entry, err)
+	}
+
+	if err := store.DeleteOfflineEntries("ws-1"); err != nil {
+		t.Fatalf("DeleteOfflineEntries: %v", err)
+	}
+	if entry, err := store.GetOfflineEntry("ws-1", "claude-code", "agent-key"); err != nil || entry != nil {
+		t.Fatalf("entry after delete = %v, %v; want nil", entry, err)
+	}
+}
+
+func TestTabWorkDirPersistedAcrossReopen(t *testing.T) {
+	dbPath := tempDBPath(t)
+	store, err := Open(dbPath)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	_ = store.InsertTab(Tab{ID: "chat-1", WorkspaceID: "ws-1", Type: "chat", WorkDir: "/workspaces/repo"})
+	if err := store.UpdateTabWorkDir("chat-1", "/workspaces/repo-wt-feature"); err != nil {
+		t.Fatalf("UpdateTabWorkDir: %v", err)
+	}
+	store.Close()
+
+	store, err = Open(dbPath)
+	if err != nil {
+		t.Fatalf("Reopen: %v", err)
+	}
+	defer store.Close()
+	tabs, _ := store.ListTabs("ws-1")
+	if len(tabs) != 1 || tabs[0].WorkDir != "/workspaces/repo-wt-feature" {
+		t.Fatalf("tabs = %+v, want the updated work dir", tabs)
+	}
+}
+
+func TestTabMessagesEvictAndPersistAcrossReopen(t *testing.T) {
+	dbPath := tempDBPath(t)
+	store, err := Open(dbPath)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	at := time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC)
+	batch := func(from, to uint64) []TabMessage {
+		var msgs []TabMessage
+		for seq := from; seq <= to; seq++ {
+			msgs = append(msgs, TabMessage{Seq: seq, Data: []byte(fmt.Sprintf(`{"n":%d}`, seq)), Timestamp: at})
+		}
+		return msgs
+	}
+	if err := store.AppendTabMessages("ws-1", "chat-1", batch(1, 3), 4); err != nil {
+		t.Fatalf("AppendTabMessages: %v", err)
+	}
+	if err := store.AppendTabMessages("ws-1", "chat-1", batch(4, 6), 4); err != nil {
+		t.Fatalf("AppendTabMessages: %v", err)
+	}
+	if err := store.AppendTabMessages("ws-1", "chat-2", batch(1, 1), 4); err != nil {
+		t.Fatalf("AppendTabMessages: %v", err)
+	}
+	store.Close()
+
+	store, err = Open(dbPath)
+	if err != nil {
+		t.Fatalf("Reopen: %v", err)
+	}
+	defer store.Close()
+
+	msgs, err := store.ListTabMessages("chat-1")
+	if err != nil {
+		t.Fatalf("ListTabMessages: %v", err)
+	}
+	if len(msgs) != 4 || msgs[0].Seq != 3 || msgs[3].Seq != 6 {
+		t.Fatalf("messages = %+v, want seq 3..6", msgs)
+	}
+	if string(msgs[3].Data) != `{"n":6}` || !msgs[3].Timestamp.Equal(at) {
+		t.Fatalf("last message = %+v", msgs[3])
+	}
+
+	if err := store.DeleteTab("chat-1"); err != nil {
+		t.Fatalf("DeleteTab: %v", err)
+	}
+	if msgs, _ := store.ListTabMessages("chat-1"); len(msgs) != 0 {
+		t.Fatalf("messages after DeleteTab = %+v", msgs)
+	}
+	if err := store.DeleteWorkspaceTabs("ws-1"); err != nil {
+		t.Fatalf("DeleteWorkspaceTabs: %v", err)
+	}
+	if msgs, _ := store.ListTabMessages("chat-2"); len(msgs) != 0 {
+		t.Fatalf("messages after DeleteWorkspaceTabs = %+v", msgs)
+	}
+}
+
+func TestCallbackOutboxEvictsAndPersistsAcrossReopen(t *testing.T) {
+	dbPath := tempDBPath(t)
+	store, err := Open(dbPath)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	for i := 1; i <= 4; i++ {
+		if err := store.EnqueueCallback("ws-1", fmt.Sprintf("/api/cb/%d", i), []byte(fmt.Sprintf(`{"n":%d}`, i)), 3); err != nil {
+			t.Fatalf("EnqueueCallback: %v", err)
+		}
+	}
+	if err := store.EnqueueCallback("ws-2", "/api/cb/5", []byte(`{"n":5}`), 0); err != nil {
+		t.Fatalf("EnqueueCallback: %v", err)
+	}
+	store.Close()
+
+	store, err = Open(dbPath)
+	if err != nil {
+		t.Fatalf("Reopen: %v", err)
+	}
+	defer store.Close()
+
+	pending, err := store.PendingCallbacks(10)
+	if err != nil {
+		t.Fatalf("PendingCallbacks: %v", err)
+	}
+	if len(pending) != 4 || pending[0].Path != "/api/cb/2" || pending[3].WorkspaceID != "ws-2" {
+		t.Fatalf("pending = %+v, want /api/cb/2../api/cb/5", pending)
+	}
+	if string(pending[0].Payload) != `{"n":2}` || pending[0].CreatedAt.IsZero() {
+		t.Fatalf("first pending = %+v", pending[0])
+	}
+
+	if err := store.RecordCallbackFailure(pending[0].ID, "HTTP 503"); err != nil {
+		t.Fatalf("RecordCallbackFailure: %v", err)
+	}
+	if err := store.DeleteCallback(pending[1].ID); err != nil {
+		t.Fatalf("DeleteCallback: %v", err)
+	}
+	pending, _ = store.PendingCallbacks(1)
+	if len(pending) != 1 || pending[0].Attempts != 1 || pending[0].LastError != "HTTP 503" {
+		t.Fatalf("after failure = %+v", pending)
+	}
+
+	if err := store.DeleteWorkspaceCallbacks("ws-1"); err != nil {
+		t.Fatalf("DeleteWorkspaceCallbacks: %v", err)
+	}
+	pending, _ = store.PendingCallbacks(10)
+	if len(pending) != 1 || pending[0].WorkspaceID != "ws-2" {
+		t.Fatalf("after DeleteWorkspaceCallbacks = %+v", pending)
+	}
+}
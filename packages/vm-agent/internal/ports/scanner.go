@@ -1,14 +1,16 @@
 package ports
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
-	"os/exec"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/workspace/vm-agent/internal/containerruntime"
 )
 
 // DetectedPort represents a port actively listening inside a container.
@@ -366,12 +368,11 @@ func (s *Scanner) handleScanFailure(containerID string, err error) {
 // empty (which can happen in some devcontainer configurations).
 // The -H flag suppresses the header line; -t = TCP, -l = listening, -n = numeric.
 func readSSListening(containerID string) ([]TCPEntry, error) {
-	cmd := exec.Command("docker", "exec", containerID, "ss", "-tlnH")
-	output, err := cmd.Output()
+	result, err := containerruntime.Current().Exec(context.Background(), containerID, containerruntime.ExecOptions{}, "ss", "-tlnH")
 	if err != nil {
-		return nil, fmt.Errorf("docker exec ss -tlnH: %w", err)
+		return nil, fmt.Errorf("exec ss -tlnH: %w", err)
 	}
-	return ParseSSOutput(string(output))
+	return ParseSSOutput(string(result.Stdout))
 }
 
 // ParseSSOutput parses the output of `ss -tlnH` into TCPEntry structs.
@@ -453,19 +454,18 @@ func parseSSAddress(s string) (string, int, error) {
 // via docker exec. Many applications (Node.js, Go) default to IPv6 dual-stack
 // listening, so ports only appear in /proc/net/tcp6.
 func readProcNetTCP(containerID string) (string, error) {
-	cmd := exec.Command("docker", "exec", containerID, "cat", "/proc/net/tcp")
-	output, err := cmd.Output()
+	rt := containerruntime.Current()
+	output, err := rt.Exec(context.Background(), containerID, containerruntime.ExecOptions{}, "cat", "/proc/net/tcp")
 	if err != nil {
-		return "", fmt.Errorf("docker exec cat /proc/net/tcp: %w", err)
+		return "", fmt.Errorf("exec cat /proc/net/tcp: %w", err)
 	}
-	result := string(output)
+	result := string(output.Stdout)
 
 	// Also read tcp6 — many servers bind to :: (IPv6 any) by default.
-	cmd6 := exec.Command("docker", "exec", containerID, "cat", "/proc/net/tcp6")
-	output6, err6 := cmd6.Output()
+	output6, err6 := rt.Exec(context.Background(), containerID, containerruntime.ExecOptions{}, "cat", "/proc/net/tcp6")
 	if err6 == nil {
 		// Append tcp6 content, skipping its header line since we already have one.
-		lines := strings.SplitN(string(output6), "\n", 2)
+		lines := strings.SplitN(string(output6.Stdout), "\n", 2)
 		if len(lines) > 1 {
 			result += lines[1]
 		}
@@ -0,0 +1,272 @@
+// Package provisionspec defines the declarative workspace provisioning spec:
+// a single versioned JSON or YAML document describing the repository, env,
+// files, devcontainer features, resource profile, and agent defaults of a
+// workspace. The control plane, CLI, and tests share it instead of threading
+// a growing list of loose create-request fields and ProvisionState arguments.
+package provisionspec
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CurrentVersion is the only spec version this agent understands.
+const CurrentVersion = 1
+
+// Resource profiles.
+const (
+	ProfileFull        = "full"
+	ProfileLightweight = "lightweight"
+)
+
+// MaxRepositories is the number of repositories a workspace can clone.
+// Multi-repository workspaces are reserved in the format but not yet
+// provisioned, so specs listing more are rejected rather than half-applied.
+const MaxRepositories = 1
+
+// Schema is the JSON Schema (draft 2020-12) describing a spec document.
+//
+//go:embed schema.json
+var Schema []byte
+
+var (
+	envKeyPattern  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	validProviders = map[string]bool{"": true, "github": true, "gitlab": true, "bitbucket": true, "artifacts": true}
+	validProfiles  = map[string]bool{"": true, ProfileFull: true, ProfileLightweight: true}
+)
+
+// Spec is a versioned, reproducible workspace definition.
+type Spec struct {
+	Version      int                               `json:"version" yaml:"version"`
+	Repositories []Repository                      `json:"repositories,omitempty" yaml:"repositories,omitempty"`
+	Env          []EnvVar                          `json:"env,omitempty" yaml:"env,omitempty"`
+	Files        []File                            `json:"files,omitempty" yaml:"files,omitempty"`
+	Features     map[string]map[string]interface{} `json:"features,omitempty" yaml:"features,omitempty"`
+	Resources    Resources                         `json:"resources,omitempty" yaml:"resources,omitempty"`
+	Git          Git                               `json:"git,omitempty" yaml:"git,omitempty"`
+	Agent        Agent                             `json:"agent,omitempty" yaml:"agent,omitempty"`
+}
+
+// Repository describes a repository cloned into the workspace.
+type Repository struct {
+	// Repository is the repository slug, e.g. "owner/name".
+	Repository string `json:"repository,omitempty" yaml:"repository,omitempty"`
+	Branch     string `json:"branch,omitempty" yaml:"branch,omitempty"`
+	// Provider is github (default), gitlab, bitbucket, or artifacts.
+	Provider string `json:"provider,omitempty" yaml:"provider,omitempty"`
+	CloneURL string `json:"cloneUrl,omitempty" yaml:"cloneUrl,omitempty"`
+	Host     string `json:"host,omitempty" yaml:"host,omitempty"`
+	Path     string `json:"path,omitempty" yaml:"path,omitempty"`
+}
+
+// EnvVar is an environment variable exported into the workspace.
+type EnvVar struct {
+	Key    string `json:"key" yaml:"key"`
+	Value  string `json:"value" yaml:"value"`
+	Secret bool   `json:"secret,omitempty" yaml:"secret,omitempty"`
+}
+
+// File is a file written into the workspace. Relative paths resolve against
+// the repository root; "~/" paths resolve against the container user's home.
+type File struct {
+	Path    string `json:"path" yaml:"path"`
+	Content string `json:"content" yaml:"content"`
+	Secret  bool   `json:"secret,omitempty" yaml:"secret,omitempty"`
+}
+
+// Resources selects how the workspace container is built.
+type Resources struct {
+	// Profile is "full" (build the repo devcontainer) or "lightweight" (skip
+	// the build and use the fallback image).
+	Profile string `json:"profile,omitempty" yaml:"profile,omitempty"`
+	// DevcontainerConfig names a config under .devcontainer/<name>/.
+	DevcontainerConfig string `json:"devcontainerConfig,omitempty" yaml:"devcontainerConfig,omitempty"`
+}
+
+// Git holds the commit identity and hook settings for the workspace.
+type Git struct {
+	UserName       string `json:"userName,omitempty" yaml:"userName,omitempty"`
+	UserEmail      string `json:"userEmail,omitempty" yaml:"userEmail,omitempty"`
+	CommitTrailers *bool  `json:"commitTrailers,omitempty" yaml:"commitTrailers,omitempty"`
+}
+
+// Agent holds defaults for agent sessions started in the workspace. Explicit
+// values on a start request take precedence. Model and PermissionMode are
+// passed to the agent as-is.
+type Agent struct {
+	Type           string `json:"type,omitempty" yaml:"type,omitempty"`
+	Model          string `json:"model,omitempty" yaml:"model,omitempty"`
+	PermissionMode string `json:"permissionMode,omitempty" yaml:"permissionMode,omitempty"`
+}
+
+// Parse decodes a JSON or YAML spec document and validates it. Unknown
+// fields are rejected so that typos do not silently drop configuration.
+func Parse(data []byte) (*Spec, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return nil, errors.New("provisioning spec is empty")
+	}
+
+	var spec Spec
+	if trimmed[0] == '{' {
+		dec := json.NewDecoder(bytes.NewReader(trimmed))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&spec); err != nil {
+			return nil, fmt.Errorf("decode provisioning spec: %w", err)
+		}
+		if dec.More() {
+			return nil, errors.New("decode provisioning spec: unexpected data after document")
+		}
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(trimmed))
+		dec.KnownFields(true)
+		if err := dec.Decode(&spec); err != nil {
+			return nil, fmt.Errorf("decode provisioning spec: %w", err)
+		}
+		var extra interface{}
+		if err := dec.Decode(&extra); err != io.EOF {
+			return nil, errors.New("decode provisioning spec: multiple YAML documents are not supported")
+		}
+	}
+
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+// Load reads and parses a spec file.
+func Load(filePath string) (*Spec, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("read provisioning spec: %w", err)
+	}
+	return Parse(data)
+}
+
+// ValidationError lists every problem found in a spec.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid provisioning spec: " + strings.Join(e.Problems, "; ")
+}
+
+// Validate checks the spec against the same rules as Schema, plus the
+// agent's provisioning limits.
+func (s *Spec) Validate() error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if s.Version != CurrentVersion {
+		add("version must be %d", CurrentVersion)
+	}
+
+	if len(s.Repositories) > MaxRepositories {
+		add("at most %d repository is supported", MaxRepositories)
+	}
+	for i, repo := range s.Repositories {
+		if strings.TrimSpace(repo.Repository) == "" && strings.TrimSpace(repo.CloneURL) == "" {
+			add("repositories[%d]: repository or cloneUrl is required", i)
+		}
+		if strings.ContainsAny(repo.Repository, " \t\n") {
+			add("repositories[%d].repository must not contain whitespace", i)
+		}
+		if !validProviders[repo.Provider] {
+			add("repositories[%d].provider %q is not supported", i, repo.Provider)
+		}
+		if strings.ContainsAny(repo.Branch, " \t\n~^:?*[\\") || strings.Contains(repo.Branch, "..") {
+			add("repositories[%d].branch is not a valid git ref", i)
+		}
+	}
+
+	seenEnv := make(map[string]bool, len(s.Env))
+	for i, env := range s.Env {
+		if !envKeyPattern.MatchString(env.Key) {
+			add("env[%d].key %q is not a valid environment variable name", i, env.Key)
+		} else if seenEnv[env.Key] {
+			add("env[%d].key %q is duplicated", i, env.Key)
+		}
+		seenEnv[env.Key] = true
+	}
+
+	seenFiles := make(map[string]bool, len(s.Files))
+	for i, file := range s.Files {
+		p := strings.TrimSpace(file.Path)
+		switch {
+		case p == "":
+			add("files[%d].path is required", i)
+		case strings.HasPrefix(p, "/"):
+			add("files[%d].path must be relative or start with ~/", i)
+		case strings.Contains("/"+path.Clean(strings.TrimPrefix(p, "~/")), "/.."):
+			add("files[%d].path must not escape its base directory", i)
+		case seenFiles[p]:
+			add("files[%d].path %q is duplicated", i, p)
+		}
+		seenFiles[p] = true
+	}
+
+	for id := range s.Features {
+		if strings.TrimSpace(id) == "" {
+			add("features keys must be non-empty feature references")
+		}
+	}
+
+	if !validProfiles[s.Resources.Profile] {
+		add("resources.profile must be %q or %q", ProfileFull, ProfileLightweight)
+	}
+	if name := s.Resources.DevcontainerConfig; strings.Contains(name, "/") || strings.Contains(name, "\\") || strings.Contains(name, "..") {
+		add("resources.devcontainerConfig must not contain path separators or '..'")
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// PrimaryRepository returns the first repository, if any.
+func (s *Spec) PrimaryRepository() (Repository, bool) {
+	if s == nil || len(s.Repositories) == 0 {
+		return Repository{}, false
+	}
+	return s.Repositories[0], true
+}
+
+// Lightweight reports whether the spec selects the lightweight profile.
+func (s *Spec) Lightweight() bool {
+	return s != nil && s.Resources.Profile == ProfileLightweight
+}
+
+// FeaturesJSON returns the features map in the devcontainer
+// ADDITIONAL_FEATURES format, or "" when the spec declares none.
+func (s *Spec) FeaturesJSON() (string, error) {
+	if s == nil || len(s.Features) == 0 {
+		return "", nil
+	}
+	features := make(map[string]map[string]interface{}, len(s.Features))
+	for id, opts := range s.Features {
+		if opts == nil {
+			opts = map[string]interface{}{}
+		}
+		features[id] = opts
+	}
+	data, err := json.Marshal(features)
+	if err != nil {
+		return "", fmt.Errorf("encode features: %w", err)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,189 @@
+package provisionspec
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const yamlSpec = `
+version: 1
+repositories:
+  - repository: octo/app
+    branch: feature/x
+env:
+  - key: API_URL
+    value: https://example.test
+  - key: API_TOKEN
+    value: s3cret
+    secret: true
+files:
+  - path: ~/.npmrc
+    content: registry=https://npm.example.test
+features:
+  ghcr.io/devcontainers/features/go:1:
+    version: "1.25"
+  ghcr.io/devcontainers/features/github-cli:1:
+resources:
+  profile: lightweight
+git:
+  userName: Octo Cat
+  commitTrailers: false
+agent:
+  type: claude-code
+  model: sonnet
+`
+
+func TestParseYAMLAndJSONAreEquivalent(t *testing.T) {
+	fromYAML, err := Parse([]byte(yamlSpec))
+	if err != nil {
+		t.Fatalf("Parse yaml: %v", err)
+	}
+	data, err := json.Marshal(fromYAML)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	fromJSON, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse json: %v", err)
+	}
+	if !reflect.DeepEqual(fromYAML, fromJSON) {
+		t.Fatalf("yaml and json specs differ:\n%+v\n%+v", fromYAML, fromJSON)
+	}
+
+	repo, ok := fromYAML.PrimaryRepository()
+	if !ok || repo.Repository != "octo/app" || repo.Branch != "feature/x" {
+		t.Fatalf("PrimaryRepository = %+v, %v", repo, ok)
+	}
+	if !fromYAML.Lightweight() {
+		t.Fatal("expected lightweight profile")
+	}
+	if fromYAML.Git.CommitTrailers == nil || *fromYAML.Git.CommitTrailers {
+		t.Fatalf("commitTrailers = %v, want explicit false", fromYAML.Git.CommitTrailers)
+	}
+	features, err := fromYAML.FeaturesJSON()
+	if err != nil {
+		t.Fatalf("FeaturesJSON: %v", err)
+	}
+	var decoded map[string]map[string]interface{}
+	if err := json.Unmarshal([]byte(features), &decoded); err != nil {
+		t.Fatalf("features json: %v", err)
+	}
+	if decoded["ghcr.io/devcontainers/features/go:1"]["version"] != "1.25" {
+		t.Fatalf("features = %s", features)
+	}
+	if opts, ok := decoded["ghcr.io/devcontainers/features/github-cli:1"]; !ok || opts == nil {
+		t.Fatalf("option-less feature should encode as {}: %s", features)
+	}
+}
+
+func TestParseRejectsUnknownFields(t *testing.T) {
+	for name, doc := range map[string]string{
+		"json": `{"version":1,"repo":"octo/app"}`,
+		"yaml": "version: 1\nresources:\n  cpu: 4\n",
+	} {
+		if _, err := Parse([]byte(doc)); err == nil {
+			t.Errorf("%s: expected unknown field error", name)
+		}
+	}
+}
+
+func TestValidateAggregatesProblems(t *testing.T) {
+	_, err := Parse([]byte(`{
+		"version": 2,
+		"repositories": [{"branch": "main"}, {"repository": "octo/other"}],
+		"env": [{"key": "1BAD", "value": ""}, {"key": "OK", "value": ""}, {"key": "OK", "value": ""}],
+		"files": [{"path": "/etc/passwd", "content": ""}, {"path": "~/../root", "content": ""}],
+		"resources": {"profile": "huge", "devcontainerConfig": "../x"}
+	}`))
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Parse error = %v, want ValidationError", err)
+	}
+	want := []string{
+		"version must be 1",
+		"at most 1 repository",
+		"repository or cloneUrl is required",
+		`"1BAD" is not a valid`,
+		`"OK" is duplicated`,
+		"files[0].path must be relative",
+		"files[1].path must not escape",
+		"resources.profile",
+		"resources.devcontainerConfig",
+	}
+	joined := verr.Error()
+	for _, w := range want {
+		if !strings.Contains(joined, w) {
+			t.Errorf("missing problem %q in %s", w, joined)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "workspace.yaml")
+	if err := os.WriteFile(p, []byte(yamlSpec), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	spec, err := Load(p)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if spec.Agent.Type != "claude-code" {
+		t.Fatalf("agent type = %q", spec.Agent.Type)
+	}
+}
+
+// TestSchemaMatchesStructTags keeps schema.json in sync with the Go types.
+func TestSchemaMatchesStructTags(t *testing.T) {
+	var schema struct {
+		Properties map[string]json.RawMessage `json:"properties"`
+	}
+	if err := json.Unmarshal(Schema, &schema); err != nil {
+		t.Fatalf("schema is not valid JSON: %v", err)
+	}
+
+	assertProps := func(name string, raw json.RawMessage, typ reflect.Type) {
+		t.Helper()
+		var node struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+			Items      struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+			} `json:"items"`
+		}
+		if raw != nil {
+			_ = json.Unmarshal(raw, &node)
+		} else {
+			node.Properties = schema.Properties
+		}
+		props := node.Properties
+		if props == nil {
+			props = node.Items.Properties
+		}
+		var got, want []string
+		for k := range props {
+			got = append(got, k)
+		}
+		for i := 0; i < typ.NumField(); i++ {
+			tag, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+			want = append(want, tag)
+		}
+		sort.Strings(got)
+		sort.Strings(want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: schema properties %v, struct fields %v", name, got, want)
+		}
+	}
+
+	assertProps("spec", nil, reflect.TypeOf(Spec{}))
+	assertProps("repositories", schema.Properties["repositories"], reflect.TypeOf(Repository{}))
+	assertProps("env", schema.Properties["env"], reflect.TypeOf(EnvVar{}))
+	assertProps("files", schema.Properties["files"], reflect.TypeOf(File{}))
+	assertProps("resources", schema.Properties["resources"], reflect.TypeOf(Resources{}))
+	assertProps("git", schema.Properties["git"], reflect.TypeOf(Git{}))
+	assertProps("agent", schema.Properties["agent"], reflect.TypeOf(Agent{}))
+}
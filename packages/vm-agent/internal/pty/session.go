@@ -2,6 +2,7 @@
 package pty
 
 import (
+	"context"
 	"errors"
 	"io"
 	"log/slog"
@@ -12,6 +13,8 @@ import (
 	"time"
 
 	"github.com/creack/pty"
+
+	"github.com/workspace/vm-agent/internal/containerruntime"
 )
 
 const defaultCloseGracePeriod = 250 * time.Millisecond
@@ -130,20 +133,15 @@ func NewSession(cfg SessionConfig) (*Session, error) {
 
 	if cfg.ContainerID != "" {
 		// Exec into the devcontainer via docker exec
-		args := []string{"exec", "-it"}
-		if cfg.ContainerUser != "" {
-			args = append(args, "-u", cfg.ContainerUser)
-		}
-		if cfg.WorkDir != "" {
-			args = append(args, "-w", cfg.WorkDir)
-		}
-		// Pass environment variables into the container
-		for _, env := range cfg.Env {
-			args = append(args, "-e", env)
-		}
-		args = append(args, "-e", "TERM=xterm-256color")
-		args = append(args, cfg.ContainerID, shell, "-l")
-		cmd = exec.Command("docker", args...)
+		rt := containerruntime.Current()
+		args := rt.ExecArgs(cfg.ContainerID, containerruntime.ExecOptions{
+			User:        cfg.ContainerUser,
+			WorkDir:     cfg.WorkDir,
+			Env:         append(append([]string{}, cfg.Env...), "TERM=xterm-256color"),
+			Interactive: true,
+			TTY:         true,
+		}, shell, "-l")
+		cmd = rt.Command(context.Background(), args...)
 	} else {
 		// Direct host shell (fallback)
 		cmd = exec.Command(shell)
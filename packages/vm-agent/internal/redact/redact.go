@@ -0,0 +1,135 @@
+// Package redact scrubs credentials known to the VM Agent from text before it
+// is logged or persisted. Secrets are registered where the agent learns them
+// (bootstrap redemption, token refreshes, credential fetches) and every log
+// sink filters its output through the same registry.
+package redact
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Placeholder replaces every occurrence of a registered secret.
+const Placeholder = "***"
+
+// minSecretLength is the shortest value accepted as a secret. Shorter values
+// would redact ordinary words and numbers in unrelated output.
+const minSecretLength = 8
+
+// Redactor replaces registered secrets in text. It is safe for concurrent use.
+type Redactor struct {
+	mu       sync.RWMutex
+	secrets  map[string]struct{}
+	replacer *strings.Replacer
+}
+
+// New creates an empty Redactor.
+func New() *Redactor {
+	return &Redactor{secrets: make(map[string]struct{})}
+}
+
+// Register adds secrets to the redactor. Blank values and values shorter than
+// eight characters are ignored.
+func (r *Redactor) Register(secrets ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changed := false
+	for _, secret := range secrets {
+		secret = strings.TrimSpace(secret)
+		if len(secret) < minSecretLength {
+			continue
+		}
+		if _, ok := r.secrets[secret]; ok {
+			continue
+		}
+		r.secrets[secret] = struct{}{}
+		changed = true
+	}
+	if !changed {
+		return
+	}
+
+	// Longest first, so a secret containing another is replaced whole.
+	ordered := make([]string, 0, len(r.secrets))
+	for secret := range r.secrets {
+		ordered = append(ordered, secret)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		if len(ordered[i]) != len(ordered[j]) {
+			return len(ordered[i]) > len(ordered[j])
+		}
+		return ordered[i] < ordered[j]
+	})
+	pairs := make([]string, 0, 2*len(ordered))
+	for _, secret := range ordered {
+		pairs = append(pairs, secret, Placeholder)
+	}
+	r.replacer = strings.NewReplacer(pairs...)
+}
+
+// String returns s with every registered secret replaced by Placeholder.
+func (r *Redactor) String(s string) string {
+	r.mu.RLock()
+	replacer := r.replacer
+	r.mu.RUnlock()
+	if replacer == nil || s == "" {
+		return s
+	}
+	return replacer.Replace(s)
+}
+
+// Bytes is String for byte slices. The input is returned unchanged when it
+// contains no secret.
+func (r *Redactor) Bytes(b []byte) []byte {
+	s := string(b)
+	if redacted := r.String(s); redacted != s {
+		return []byte(redacted)
+	}
+	return b
+}
+
+// Writer wraps w so that everything written through it is redacted. Each
+// Write is redacted on its own, which suits line- and record-oriented
+// writers such as log handlers.
+func (r *Redactor) Writer(w io.Writer) io.Writer {
+	return &writer{redactor: r, w: w}
+}
+
+type writer struct {
+	redactor *Redactor
+	w        io.Writer
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if _, err := w.w.Write(w.redactor.Bytes(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// defaultRedactor is the process-wide registry used by the package-level
+// functions.
+var defaultRedactor = New()
+
+// Register adds secrets to the process-wide redactor.
+func Register(secrets ...string) {
+	defaultRedactor.Register(secrets...)
+}
+
+// String redacts s with the process-wide redactor.
+func String(s string) string {
+	return defaultRedactor.String(s)
+}
+
+// Bytes redacts b with the process-wide redactor.
+func Bytes(b []byte) []byte {
+	return defaultRedactor.Bytes(b)
+}
+
+// Writer wraps w with the process-wide redactor.
+func Writer(w io.Writer) io.Writer {
+	return defaultRedactor.Writer(w)
+}
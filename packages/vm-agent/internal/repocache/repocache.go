@@ -0,0 +1,394 @@
+// Package repocache keeps per-node bare mirrors of repositories so that
+// workspaces cloning the same repository transfer most objects locally
+// instead of from the git host.
+//
+// Workspace clones borrow a mirror with `git clone --reference <mirror>
+// --dissociate`: objects are copied out of the mirror during the clone, so a
+// finished clone never depends on the cache and a mirror can be evicted as
+// soon as no clone is reading it. Clones still authenticate against the
+// origin for refs, so the cache never grants access to a repository.
+package repocache
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	mirrorSuffix = ".git"
+	// metadataFile lives inside each bare mirror; git ignores unknown files
+	// at the top of a git directory.
+	metadataFile = "sam-mirror.json"
+)
+
+// Config controls a Cache.
+type Config struct {
+	// Dir holds one bare mirror per repository.
+	Dir string
+	// MaxBytes is the total mirror size above which least recently used
+	// mirrors are evicted. 0 disables eviction.
+	MaxBytes int64
+	// FetchInterval is how old a mirror's last fetch may be before it is
+	// refreshed. 0 never refreshes mirrors.
+	FetchInterval time.Duration
+}
+
+// Mirror describes one cached repository.
+type Mirror struct {
+	Key         string    `json:"key"`
+	URL         string    `json:"url"`
+	Path        string    `json:"path"`
+	Bytes       int64     `json:"bytes"`
+	LastUsed    time.Time `json:"lastUsed"`
+	LastFetched time.Time `json:"lastFetched"`
+	InUse       bool      `json:"inUse"`
+}
+
+type metadata struct {
+	URL         string    `json:"url"`
+	LastUsed    time.Time `json:"lastUsed"`
+	LastFetched time.Time `json:"lastFetched"`
+}
+
+// entry serializes mirror creation, fetch, and eviction for one repository
+// and counts the clones currently reading the mirror.
+type entry struct {
+	mu    sync.Mutex
+	users int // guarded by Cache.mu
+}
+
+// Cache manages the mirrors under Config.Dir. It is safe for concurrent use.
+type Cache struct {
+	cfg Config
+
+	mu      sync.Mutex
+	entries map[string]*entry
+
+	// runGit and now are replaced in tests.
+	runGit func(ctx context.Context, args ...string) (string, error)
+	now    func() time.Time
+}
+
+// New creates the cache directory and returns a Cache over it.
+func New(cfg Config) (*Cache, error) {
+	if strings.TrimSpace(cfg.Dir) == "" {
+		return nil, errors.New("repocache: directory is required")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("repocache: create %s: %w", cfg.Dir, err)
+	}
+	return &Cache{
+		cfg:     cfg,
+		entries: make(map[string]*entry),
+		runGit:  runGit,
+		now:     time.Now,
+	}, nil
+}
+
+func runGit(ctx context.Context, args ...string) (string, error) {
+	// NOSONAR - git is resolved from the controlled VM-agent PATH; repository
+	// URLs come from the control plane, not from workspace users.
+	cmd := exec.CommandContext(ctx, "git", args...) // NOSONAR
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	output, err := cmd.CombinedOutput()
+	return strings.TrimSpace(string(output)), err
+}
+
+var unsafeKeyChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// Key returns the cache key for a credential-free repository URL: the
+// repository name for readability plus a hash of the full URL.
+func Key(repoURL string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(repoURL)))
+	name := strings.TrimSuffix(filepath.Base(strings.TrimRight(repoURL, "/")), ".git")
+	name = strings.Trim(unsafeKeyChars.ReplaceAllString(name, "-"), "-.")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	if name == "" {
+		name = "repo"
+	}
+	return name + "-" + hex.EncodeToString(sum[:8])
+}
+
+func (c *Cache) mirrorPath(key string) string {
+	return filepath.Join(c.cfg.Dir, key+mirrorSuffix)
+}
+
+func (c *Cache) entry(key string) *entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		e = &entry{}
+		c.entries[key] = e
+	}
+	return e
+}
+
+// Acquire returns the path of an up-to-date mirror of repoURL for use as a
+// clone reference, creating it if needed. repoURL must not carry
+// credentials; cloneURL is the URL used to reach the origin and may embed a
+// token, which is never persisted in the mirror. The mirror is protected
+// from eviction until release is called. A failed refresh is logged and the
+// stale mirror is returned, since a stale reference still saves transfer.
+func (c *Cache) Acquire(ctx context.Context, repoURL, cloneURL string) (path string, release func(), err error) {
+	key := Key(repoURL)
+	path = c.mirrorPath(key)
+	e := c.entry(key)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	meta, err := readMetadata(path)
+	switch {
+	case err != nil:
+		if err := c.create(ctx, path, repoURL, cloneURL); err != nil {
+			return "", nil, err
+		}
+		meta = metadata{URL: repoURL, LastFetched: c.now()}
+	case c.stale(meta):
+		if err := c.fetch(ctx, path, cloneURL); err != nil {
+			slog.Warn("repocache: mirror refresh failed, using stale mirror", "mirror", key, "error", err)
+		} else {
+			meta.LastFetched = c.now()
+		}
+	}
+	meta.LastUsed = c.now()
+	if err := writeMetadata(path, meta); err != nil {
+		slog.Warn("repocache: failed to record mirror use", "mirror", key, "error", err)
+	}
+
+	c.mu.Lock()
+	e.users++
+	c.mu.Unlock()
+	var once sync.Once
+	return path, func() {
+		once.Do(func() {
+			c.mu.Lock()
+			e.users--
+			c.mu.Unlock()
+		})
+	}, nil
+}
+
+func (c *Cache) stale(meta metadata) bool {
+	return c.cfg.FetchInterval > 0 && c.now().Sub(meta.LastFetched) >= c.cfg.FetchInterval
+}
+
+// create clones a new mirror into a temporary directory and renames it into
+// place, so an interrupted clone never leaves a half-populated mirror.
+func (c *Cache) create(ctx context.Context, path, repoURL, cloneURL string) error {
+	if err := os.RemoveAll(path); err != nil {
+		return fmt.Errorf("repocache: remove incomplete mirror: %w", err)
+	}
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	tmp := path + ".tmp-" + hex.EncodeToString(suffix)
+	defer os.RemoveAll(tmp)
+
+	if output, err := c.runGit(ctx, "clone", "--mirror", "--quiet", cloneURL, tmp); err != nil {
+		return fmt.Errorf("repocache: mirror clone failed: %w: %s", err, redact(output, cloneURL))
+	}
+	// Persist the origin without credentials. Automatic gc is disabled so a
+	// fetch never prunes objects while a clone is reading them.
+	for _, args := range [][]string{
+		{"-C", tmp, "remote", "set-url", "origin", repoURL},
+		{"-C", tmp, "config", "gc.auto", "0"},
+	} {
+		if output, err := c.runGit(ctx, args...); err != nil {
+			return fmt.Errorf("repocache: configure mirror: %w: %s", err, output)
+		}
+	}
+	if err := writeMetadata(tmp, metadata{URL: repoURL, LastFetched: c.now()}); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("repocache: install mirror: %w", err)
+	}
+	slog.Info("repocache: created mirror", "mirror", filepath.Base(path), "repository", repoURL)
+	return nil
+}
+
+func (c *Cache) fetch(ctx context.Context, path, cloneURL string) error {
+	output, err := c.runGit(ctx, "-C", path, "fetch", "--prune", "--quiet", cloneURL,
+		"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*")
+	if err != nil {
+		return fmt.Errorf("mirror fetch failed: %w: %s", err, redact(output, cloneURL))
+	}
+	return nil
+}
+
+// Mirrors lists the cached mirrors, least recently used first.
+func (c *Cache) Mirrors() ([]Mirror, error) {
+	dirEntries, err := os.ReadDir(c.cfg.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("repocache: list mirrors: %w", err)
+	}
+	var mirrors []Mirror
+	for _, d := range dirEntries {
+		if !d.IsDir() || !strings.HasSuffix(d.Name(), mirrorSuffix) {
+			continue
+		}
+		key := strings.TrimSuffix(d.Name(), mirrorSuffix)
+		path := c.mirrorPath(key)
+		meta, err := readMetadata(path)
+		if err != nil {
+			continue // incomplete mirror; the next Acquire recreates it
+		}
+		c.mu.Lock()
+		inUse := c.entries[key] != nil && c.entries[key].users > 0
+		c.mu.Unlock()
+		mirrors = append(mirrors, Mirror{
+			Key:         key,
+			URL:         meta.URL,
+			Path:        path,
+			Bytes:       dirSize(path),
+			LastUsed:    meta.LastUsed,
+			LastFetched: meta.LastFetched,
+			InUse:       inUse,
+		})
+	}
+	sort.Slice(mirrors, func(i, j int) bool { return mirrors[i].LastUsed.Before(mirrors[j].LastUsed) })
+	return mirrors, nil
+}
+
+// Refresh fetches every stale mirror that no other operation holds.
+// cloneURL returns the URL, possibly with credentials, used to reach the
+// origin of a mirror's repository URL.
+func (c *Cache) Refresh(ctx context.Context, cloneURL func(ctx context.Context, repoURL string) string) {
+	mirrors, err := c.Mirrors()
+	if err != nil {
+		slog.Warn("repocache: refresh skipped", "error", err)
+		return
+	}
+	for _, m := range mirrors {
+		if ctx.Err() != nil {
+			return
+		}
+		e := c.entry(m.Key)
+		if !e.mu.TryLock() {
+			continue // being created, fetched, or evicted right now
+		}
+		meta, err := readMetadata(m.Path)
+		if err == nil && c.stale(meta) {
+			if err := c.fetch(ctx, m.Path, cloneURL(ctx, meta.URL)); err != nil {
+				slog.Warn("repocache: periodic mirror refresh failed", "mirror", m.Key, "error", err)
+			} else {
+				meta.LastFetched = c.now()
+				if err := writeMetadata(m.Path, meta); err != nil {
+					slog.Warn("repocache: failed to record mirror refresh", "mirror", m.Key, "error", err)
+				}
+			}
+		}
+		e.mu.Unlock()
+	}
+}
+
+// Evict removes least recently used mirrors until the cache fits within
+// MaxBytes, skipping mirrors that clones are reading. It returns the evicted
+// mirrors.
+func (c *Cache) Evict() ([]Mirror, error) {
+	if c.cfg.MaxBytes <= 0 {
+		return nil, nil
+	}
+	mirrors, err := c.Mirrors()
+	if err != nil {
+		return nil, err
+	}
+	var total int64
+	for _, m := range mirrors {
+		total += m.Bytes
+	}
+
+	var evicted []Mirror
+	for _, m := range mirrors {
+		if total <= c.cfg.MaxBytes {
+			break
+		}
+		e := c.entry(m.Key)
+		if !e.mu.TryLock() {
+			continue
+		}
+		c.mu.Lock()
+		busy := e.users > 0
+		c.mu.Unlock()
+		if !busy {
+			if err := os.RemoveAll(m.Path); err != nil {
+				slog.Warn("repocache: failed to evict mirror", "mirror", m.Key, "error", err)
+			} else {
+				total -= m.Bytes
+				evicted = append(evicted, m)
+				slog.Info("repocache: evicted mirror", "mirror", m.Key, "bytes", m.Bytes)
+			}
+		}
+		e.mu.Unlock()
+	}
+	return evicted, nil
+}
+
+func readMetadata(path string) (metadata, error) {
+	var meta metadata
+	data, err := os.ReadFile(filepath.Join(path, metadataFile))
+	if err != nil {
+		return meta, err
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, fmt.Errorf("repocache: parse mirror metadata: %w", err)
+	}
+	return meta, nil
+}
+
+func writeMetadata(path string, meta metadata) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(path, metadataFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("repocache: write mirror metadata: %w", err)
+	}
+	return os.Rename(tmp, filepath.Join(path, metadataFile))
+}
+
+func dirSize(path string) int64 {
+	var size int64
+	_ = filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// redact removes the password embedded in cloneURL from git output.
+func redact(output, cloneURL string) string {
+	u, err := url.Parse(cloneURL)
+	if err != nil || u.User == nil {
+		return output
+	}
+	if password, ok := u.User.Password(); ok && password != "" {
+		output = strings.ReplaceAll(output, password, "***")
+	}
+	return output
+}
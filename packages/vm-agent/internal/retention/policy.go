@@ -0,0 +1,149 @@
+// Package retention enforces workspace-level data retention policies pushed
+// from the control plane. A policy sets a maximum age and/or maximum size per
+// data category (transcripts, events, artifacts, audit logs, boot logs); the
+// purger deletes the oldest local data that falls outside those limits and
+// records a deletion receipt for every category it purged, which is shipped
+// upstream as compliance evidence.
+package retention
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Data categories a policy can govern.
+const (
+	CategoryTranscripts = "transcripts"
+	CategoryEvents      = "events"
+	CategoryArtifacts   = "artifacts"
+	CategoryAuditLogs   = "auditLogs"
+	CategoryBootLogs    = "bootLogs"
+)
+
+// Categories lists every supported category in the order the purger applies them.
+var Categories = []string{
+	CategoryTranscripts,
+	CategoryEvents,
+	CategoryArtifacts,
+	CategoryAuditLogs,
+	CategoryBootLogs,
+}
+
+// Rule limits one category of data. Zero fields are unlimited.
+type Rule struct {
+	MaxAgeSeconds int64 `json:"maxAgeSeconds,omitempty"`
+	// MaxBytes caps the category's total size; the oldest items beyond the
+	// cap are purged.
+	MaxBytes int64 `json:"maxBytes,omitempty"`
+}
+
+// IsZero reports whether the rule limits nothing.
+func (r Rule) IsZero() bool {
+	return r.MaxAgeSeconds <= 0 && r.MaxBytes <= 0
+}
+
+// Policy is the retention policy for one workspace, keyed by category.
+type Policy struct {
+	WorkspaceID string          `json:"workspaceId"`
+	Rules       map[string]Rule `json:"rules"`
+	UpdatedAt   string          `json:"updatedAt,omitempty"`
+}
+
+// ValidationError lists every problem found in a policy.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid retention policy: " + strings.Join(e.Problems, "; ")
+}
+
+// Validate checks that every rule names a known category and has no negative limits.
+func (p Policy) Validate() error {
+	var problems []string
+	categories := make([]string, 0, len(p.Rules))
+	for category := range p.Rules {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	for _, category := range categories {
+		rule := p.Rules[category]
+		if !knownCategory(category) {
+			problems = append(problems, fmt.Sprintf("unknown category %q", category))
+			continue
+		}
+		if rule.MaxAgeSeconds < 0 {
+			problems = append(problems, fmt.Sprintf("%s: maxAgeSeconds must not be negative", category))
+		}
+		if rule.MaxBytes < 0 {
+			problems = append(problems, fmt.Sprintf("%s: maxBytes must not be negative", category))
+		}
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func knownCategory(category string) bool {
+	for _, c := range Categories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// Item is one unit of retained data: a row, record, or log entry.
+type Item struct {
+	ID    string
+	Bytes int64
+	// CreatedAt is zero when the source has no parseable timestamp; such
+	// items are only subject to the size limit.
+	CreatedAt time.Time
+}
+
+// Select returns the items a rule purges at now: everything older than
+// MaxAgeSeconds, then the oldest remaining items until the rest fit within
+// MaxBytes. items may be in any order.
+func Select(items []Item, rule Rule, now time.Time) []Item {
+	sorted := make([]Item, len(items))
+	copy(sorted, items)
+	// Newest first, so the size budget is spent on the most recent data.
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].CreatedAt.After(sorted[j].CreatedAt)
+	})
+
+	var cutoff time.Time
+	if rule.MaxAgeSeconds > 0 {
+		cutoff = now.Add(-time.Duration(rule.MaxAgeSeconds) * time.Second)
+	}
+
+	var purge []Item
+	var kept int64
+	overBudget := false
+	for _, item := range sorted {
+		expired := !cutoff.IsZero() && !item.CreatedAt.IsZero() && item.CreatedAt.Before(cutoff)
+		if !expired && !overBudget && rule.MaxBytes > 0 && kept+item.Bytes > rule.MaxBytes {
+			overBudget = true
+		}
+		if expired || overBudget {
+			purge = append(purge, item)
+			continue
+		}
+		kept += item.Bytes
+	}
+	return purge
+}
+
+// ParseTimestamp parses an RFC 3339 timestamp as stored by the agent's
+// SQLite stores, returning the zero time when it cannot be parsed.
+func ParseTimestamp(value string) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
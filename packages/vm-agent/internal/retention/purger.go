@@ -0,0 +1,171 @@
+package retention
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Source exposes one category of a workspace's local data to the purger.
+type Source interface {
+	// RetentionItems lists the workspace's purgeable items.
+	RetentionItems(workspaceID string) ([]Item, error)
+	// DeleteRetentionItems deletes the given items and returns how many
+	// were deleted.
+	DeleteRetentionItems(workspaceID string, ids []string) (int64, error)
+}
+
+// CategoryReport is the outcome of applying one rule.
+type CategoryReport struct {
+	Category string `json:"category"`
+	Rule     Rule   `json:"rule"`
+	// Items and Bytes count what was deleted, or what would be deleted in a dry run.
+	Items         int64  `json:"items"`
+	Bytes         int64  `json:"bytes"`
+	OldestDeleted string `json:"oldestDeleted,omitempty"`
+	NewestDeleted string `json:"newestDeleted,omitempty"`
+	ReceiptID     string `json:"receiptId,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// Report is the outcome of applying a workspace's policy.
+type Report struct {
+	WorkspaceID string           `json:"workspaceId"`
+	DryRun      bool             `json:"dryRun"`
+	GeneratedAt string           `json:"generatedAt"`
+	Categories  []CategoryReport `json:"categories"`
+}
+
+// Purger applies stored policies to the registered sources.
+type Purger struct {
+	store   *Store
+	sources map[string]Source
+	now     func() time.Time
+}
+
+// NewPurger creates a purger. Categories without a source are skipped.
+func NewPurger(store *Store, sources map[string]Source) *Purger {
+	return &Purger{store: store, sources: sources, now: time.Now}
+}
+
+// Run applies the workspace's policy. A dry run reports what would be
+// deleted without deleting anything or recording receipts. Per-category
+// failures are reported in the result rather than aborting the run.
+func (p *Purger) Run(workspaceID string, dryRun bool) (Report, error) {
+	policy, err := p.store.Policy(workspaceID)
+	if err != nil {
+		return Report{}, err
+	}
+	now := p.now().UTC()
+	report := Report{
+		WorkspaceID: workspaceID,
+		DryRun:      dryRun,
+		GeneratedAt: now.Format(time.RFC3339Nano),
+		Categories:  []CategoryReport{},
+	}
+	for _, category := range Categories {
+		rule, ok := policy.Rules[category]
+		if !ok || rule.IsZero() {
+			continue
+		}
+		source := p.sources[category]
+		if source == nil {
+			continue
+		}
+		report.Categories = append(report.Categories, p.apply(workspaceID, category, rule, source, now, dryRun))
+	}
+	return report, nil
+}
+
+// RunAll applies every stored policy and returns the reports.
+func (p *Purger) RunAll() ([]Report, error) {
+	policies, err := p.store.Policies()
+	if err != nil {
+		return nil, err
+	}
+	reports := make([]Report, 0, len(policies))
+	for _, policy := range policies {
+		report, err := p.Run(policy.WorkspaceID, false)
+		if err != nil {
+			if !errors.Is(err, ErrNotFound) {
+				slog.Warn("retention: purge failed", "workspace", policy.WorkspaceID, "error", err)
+			}
+			continue
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+func (p *Purger) apply(workspaceID, category string, rule Rule, source Source, now time.Time, dryRun bool) CategoryReport {
+	result := CategoryReport{Category: category, Rule: rule}
+
+	items, err := source.RetentionItems(workspaceID)
+	if err != nil {
+		result.Error = fmt.Sprintf("list items: %v", err)
+		return result
+	}
+	purge := Select(items, rule, now)
+	if len(purge) == 0 {
+		return result
+	}
+
+	ids := make([]string, len(purge))
+	var oldest, newest time.Time
+	for i, item := range purge {
+		ids[i] = item.ID
+		result.Bytes += item.Bytes
+		if item.CreatedAt.IsZero() {
+			continue
+		}
+		if oldest.IsZero() || item.CreatedAt.Before(oldest) {
+			oldest = item.CreatedAt
+		}
+		if item.CreatedAt.After(newest) {
+			newest = item.CreatedAt
+		}
+	}
+	if !oldest.IsZero() {
+		result.OldestDeleted = oldest.UTC().Format(time.RFC3339Nano)
+		result.NewestDeleted = newest.UTC().Format(time.RFC3339Nano)
+	}
+	if dryRun {
+		result.Items = int64(len(purge))
+		return result
+	}
+
+	deleted, err := source.DeleteRetentionItems(workspaceID, ids)
+	result.Items = deleted
+	if err != nil {
+		result.Error = fmt.Sprintf("delete items: %v", err)
+	}
+	if deleted == 0 {
+		return result
+	}
+	if deleted < int64(len(purge)) {
+		// Some items vanished between listing and deletion (e.g. shipped and
+		// removed by their owner); the byte count is an upper bound.
+		slog.Debug("retention: fewer items deleted than selected", "workspace", workspaceID, "category", category, "selected", len(purge), "deleted", deleted)
+	}
+
+	receipt, err := p.store.AddReceipt(Receipt{
+		WorkspaceID:   workspaceID,
+		Category:      category,
+		Rule:          rule,
+		Items:         deleted,
+		Bytes:         result.Bytes,
+		OldestDeleted: result.OldestDeleted,
+		NewestDeleted: result.NewestDeleted,
+	})
+	if err != nil {
+		slog.Error("retention: recording deletion receipt failed", "workspace", workspaceID, "category", category, "error", err)
+		if result.Error == "" {
+			result.Error = fmt.Sprintf("record receipt: %v", err)
+		}
+		return result
+	}
+	result.ReceiptID = receipt.ID
+	slog.Info("retention: purged data", "workspace", workspaceID, "category", category, "items", deleted, "bytes", result.Bytes)
+	return result
+}
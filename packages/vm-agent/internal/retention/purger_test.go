@@ -0,0 +1,180 @@
+package retention
+
+import (
+	"errors"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// memorySource is an in-memory Source keyed by item ID.
+type memorySource struct {
+	items map[string]Item
+}
+
+func (m *memorySource) RetentionItems(string) ([]Item, error) {
+	items := make([]Item, 0, len(m.items))
+	for _, item := range m.items {
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (m *memorySource) DeleteRetentionItems(_ string, ids []string) (int64, error) {
+	var deleted int64
+	for _, id := range ids {
+		if _, ok := m.items[id]; ok {
+			delete(m.items, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func openTestStore(t *testing.T) *Store {
+	t.Helper()
+	store, err := New(filepath.Join(t.TempDir(), "retention.db"), 0)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+// hourlyItems returns n 100-byte items, one per hour, the newest an hour before now.
+func hourlyItems(now time.Time, n int) map[string]Item {
+	items := make(map[string]Item, n)
+	for i := 1; i <= n; i++ {
+		id := strconv.Itoa(i)
+		items[id] = Item{ID: id, Bytes: 100, CreatedAt: now.Add(-time.Duration(i) * time.Hour)}
+	}
+	return items
+}
+
+func ids(items []Item) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[item.ID] = true
+	}
+	return set
+}
+
+func TestSelectAppliesAgeThenSize(t *testing.T) {
+	now := time.Now()
+	var items []Item
+	for _, item := range hourlyItems(now, 5) {
+		items = append(items, item)
+	}
+	items = append(items, Item{ID: "undated", Bytes: 100})
+
+	byAge := ids(Select(items, Rule{MaxAgeSeconds: int64((150 * time.Minute).Seconds())}, now))
+	if len(byAge) != 3 || !byAge["3"] || !byAge["4"] || !byAge["5"] {
+		t.Fatalf("age purge = %v, want items 3-5 (undated items are exempt)", byAge)
+	}
+
+	bySize := ids(Select(items, Rule{MaxBytes: 250}, now))
+	if len(bySize) != 4 || bySize["1"] || bySize["2"] || !bySize["undated"] {
+		t.Fatalf("size purge = %v, want all but the two newest", bySize)
+	}
+
+	if purged := Select(items, Rule{}, now); len(purged) != 0 {
+		t.Fatalf("empty rule purged %d items", len(purged))
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	valid := Policy{Rules: map[string]Rule{CategoryEvents: {MaxAgeSeconds: 3600}, CategoryBootLogs: {MaxBytes: 1024}}}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("Validate(valid) = %v", err)
+	}
+
+	invalid := Policy{Rules: map[string]Rule{"logs": {}, CategoryTranscripts: {MaxAgeSeconds: -1, MaxBytes: -1}}}
+	var verr *ValidationError
+	if err := invalid.Validate(); !errors.As(err, &verr) || len(verr.Problems) != 3 {
+		t.Fatalf("Validate(invalid) = %v, want 3 problems", err)
+	}
+}
+
+func TestPurgerDryRunThenPurge(t *testing.T) {
+	store := openTestStore(t)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	events := &memorySource{items: hourlyItems(now, 5)}
+	bootLogs := &memorySource{items: hourlyItems(now, 2)}
+	purger := NewPurger(store, map[string]Source{CategoryEvents: events, CategoryBootLogs: bootLogs})
+	purger.now = func() time.Time { return now }
+
+	if _, err := purger.Run("ws-1", true); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Run without policy = %v, want ErrNotFound", err)
+	}
+	if _, err := store.SetPolicy(Policy{WorkspaceID: "ws-1", Rules: map[string]Rule{
+		CategoryEvents:    {MaxAgeSeconds: int64((3 * time.Hour).Seconds())},
+		CategoryBootLogs:  {MaxAgeSeconds: int64((24 * time.Hour).Seconds())},
+		CategoryAuditLogs: {MaxBytes: 1}, // no source registered
+	}}); err != nil {
+		t.Fatalf("SetPolicy: %v", err)
+	}
+
+	dry, err := purger.Run("ws-1", true)
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if len(dry.Categories) != 2 || dry.Categories[0].Category != CategoryEvents || dry.Categories[0].Items != 2 || dry.Categories[0].Bytes != 200 {
+		t.Fatalf("dry run report = %+v, want 2 events (200 bytes) and boot logs", dry.Categories)
+	}
+	if len(events.items) != 5 {
+		t.Fatalf("dry run deleted %d events", 5-len(events.items))
+	}
+	if receipts, _ := store.Receipts("ws-1", 0); len(receipts) != 0 {
+		t.Fatalf("dry run recorded %d receipts", len(receipts))
+	}
+
+	report, err := purger.Run("ws-1", false)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	got := report.Categories[0]
+	if got.Items != 2 || got.ReceiptID == "" || got.OldestDeleted != now.Add(-5*time.Hour).Format(time.RFC3339Nano) {
+		t.Fatalf("events report = %+v", got)
+	}
+	if report.Categories[1].Items != 0 || report.Categories[1].ReceiptID != "" {
+		t.Fatalf("boot logs report = %+v, want nothing purged", report.Categories[1])
+	}
+	if len(events.items) != 3 {
+		t.Fatalf("events left = %d, want 3", len(events.items))
+	}
+
+	receipts, err := store.Receipts("ws-1", 0)
+	if err != nil || len(receipts) != 1 {
+		t.Fatalf("Receipts = %+v, %v; want one", receipts, err)
+	}
+	if r := receipts[0]; r.Category != CategoryEvents || r.Items != 2 || r.Bytes != 200 || r.Rule.MaxAgeSeconds != 3*3600 {
+		t.Fatalf("receipt = %+v", r)
+	}
+}
+
+func TestStoreShipsReceiptsOnce(t *testing.T) {
+	store := openTestStore(t)
+	first, err := store.AddReceipt(Receipt{WorkspaceID: "ws-1", Category: CategoryEvents, Items: 1})
+	if err != nil {
+		t.Fatalf("AddReceipt: %v", err)
+	}
+	if _, err := store.AddReceipt(Receipt{WorkspaceID: "ws-2", Category: CategoryBootLogs, Items: 1}); err != nil {
+		t.Fatalf("AddReceipt: %v", err)
+	}
+
+	if err := store.MarkShipped([]string{first.ID}); err != nil {
+		t.Fatalf("MarkShipped: %v", err)
+	}
+	pending, err := store.Unshipped(10)
+	if err != nil || len(pending) != 1 || pending[0].WorkspaceID != "ws-2" {
+		t.Fatalf("Unshipped = %+v, %v; want only ws-2", pending, err)
+	}
+
+	if err := store.DeletePolicy("ws-1"); err != nil {
+		t.Fatalf("DeletePolicy: %v", err)
+	}
+	if receipts, _ := store.Receipts("ws-1", 0); len(receipts) != 1 {
+		t.Fatalf("receipts after policy delete = %d, want 1", len(receipts))
+	}
+}
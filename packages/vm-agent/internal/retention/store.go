@@ -0,0 +1,282 @@
+package retention
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// ErrNotFound is returned when a workspace has no retention policy.
+var ErrNotFound = errors.New("retention policy not found")
+
+// Receipt documents one completed purge of one category for a workspace.
+type Receipt struct {
+	ID          string `json:"id"`
+	WorkspaceID string `json:"workspaceId"`
+	Category    string `json:"category"`
+	Rule        Rule   `json:"rule"`
+	Items       int64  `json:"items"`
+	Bytes       int64  `json:"bytes"`
+	// OldestDeleted and NewestDeleted bound the creation times of the
+	// deleted items, when known.
+	OldestDeleted string `json:"oldestDeleted,omitempty"`
+	NewestDeleted string `json:"newestDeleted,omitempty"`
+	ExecutedAt    string `json:"executedAt"`
+}
+
+// Store is a SQLite-backed store of retention policies and deletion receipts.
+type Store struct {
+	db *sql.DB
+	mu sync.Mutex // serializes writes
+}
+
+// New opens (or creates) a retention store at the given path. Receipts
+// executed longer ago than receiptRetention are trimmed on open once shipped
+// (zero keeps everything).
+func New(dbPath string, receiptRetention time.Duration) (*Store, error) {
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?cache=shared&mode=rwc&_journal_mode=WAL", dbPath))
+	if err != nil {
+		return nil, fmt.Errorf("retention: open: %w", err)
+	}
+	for _, pragma := range []string{
+		"PRAGMA journal_mode=WAL",
+		"PRAGMA busy_timeout=5000",
+		"PRAGMA synchronous=NORMAL",
+	} {
+		if _, err := db.Exec(pragma); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("retention: %s: %w", pragma, err)
+		}
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("retention: migrate: %w", err)
+	}
+
+	if receiptRetention > 0 {
+		cutoff := time.Now().UTC().Add(-receiptRetention).Format(time.RFC3339Nano)
+		if result, err := db.Exec(`DELETE FROM retention_receipts WHERE shipped = 1 AND executed_at < ?`, cutoff); err != nil {
+			slog.Warn("retention: trim receipts on startup failed", "error", err)
+		} else if n, _ := result.RowsAffected(); n > 0 {
+			slog.Info("retention: trimmed old receipts on startup", "deleted", n)
+		}
+	}
+	return &Store{db: db}, nil
+}
+
+func migrate(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS retention_policies (
+			workspace_id TEXT PRIMARY KEY,
+			rules        TEXT NOT NULL,
+			updated_at   TEXT NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS retention_receipts (
+			id             TEXT PRIMARY KEY,
+			workspace_id   TEXT NOT NULL,
+			category       TEXT NOT NULL,
+			rule           TEXT NOT NULL,
+			items          INTEGER NOT NULL DEFAULT 0,
+			bytes          INTEGER NOT NULL DEFAULT 0,
+			oldest_deleted TEXT NOT NULL DEFAULT '',
+			newest_deleted TEXT NOT NULL DEFAULT '',
+			executed_at    TEXT NOT NULL,
+			shipped        INTEGER NOT NULL DEFAULT 0
+		);
+		CREATE INDEX IF NOT EXISTS idx_retention_receipts_workspace ON retention_receipts(workspace_id, executed_at);
+		CREATE INDEX IF NOT EXISTS idx_retention_receipts_unshipped ON retention_receipts(shipped, executed_at);
+	`)
+	return err
+}
+
+// SetPolicy stores (or replaces) a workspace's policy and returns it with
+// UpdatedAt filled in. The policy must already be valid.
+func (s *Store) SetPolicy(p Policy) (Policy, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p.Rules == nil {
+		p.Rules = map[string]Rule{}
+	}
+	rules, err := json.Marshal(p.Rules)
+	if err != nil {
+		return Policy{}, fmt.Errorf("retention: encode rules: %w", err)
+	}
+	p.UpdatedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	if _, err := s.db.Exec(
+		`INSERT INTO retention_policies (workspace_id, rules, updated_at) VALUES (?, ?, ?)
+		 ON CONFLICT(workspace_id) DO UPDATE SET rules = excluded.rules, updated_at = excluded.updated_at`,
+		p.WorkspaceID, string(rules), p.UpdatedAt,
+	); err != nil {
+		return Policy{}, fmt.Errorf("retention: upsert policy: %w", err)
+	}
+	return p, nil
+}
+
+// Policy returns a workspace's policy, or ErrNotFound.
+func (s *Store) Policy(workspaceID string) (Policy, error) {
+	rows, err := s.db.Query(`SELECT workspace_id, rules, updated_at FROM retention_policies WHERE workspace_id = ?`, workspaceID)
+	if err != nil {
+		return Policy{}, err
+	}
+	policies, err := scanPolicies(rows)
+	if err != nil {
+		return Policy{}, err
+	}
+	if len(policies) == 0 {
+		return Policy{}, ErrNotFound
+	}
+	return policies[0], nil
+}
+
+// Policies returns every stored policy, ordered by workspace ID.
+func (s *Store) Policies() ([]Policy, error) {
+	rows, err := s.db.Query(`SELECT workspace_id, rules, updated_at FROM retention_policies ORDER BY workspace_id`)
+	if err != nil {
+		return nil, err
+	}
+	return scanPolicies(rows)
+}
+
+// DeletePolicy removes a workspace's policy. Its receipts are kept.
+func (s *Store) DeletePolicy(workspaceID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.db.Exec(`DELETE FROM retention_policies WHERE workspace_id = ?`, workspaceID); err != nil {
+		return fmt.Errorf("retention: delete policy: %w", err)
+	}
+	return nil
+}
+
+// AddReceipt records a deletion receipt, filling in ID and ExecutedAt when empty.
+func (s *Store) AddReceipt(r Receipt) (Receipt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.ID == "" {
+		r.ID = newReceiptID()
+	}
+	if r.ExecutedAt == "" {
+		r.ExecutedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	rule, err := json.Marshal(r.Rule)
+	if err != nil {
+		return Receipt{}, fmt.Errorf("retention: encode rule: %w", err)
+	}
+	if _, err := s.db.Exec(
+		`INSERT INTO retention_receipts (id, workspace_id, category, rule, items, bytes, oldest_deleted, newest_deleted, executed_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.ID, r.WorkspaceID, r.Category, string(rule), r.Items, r.Bytes, r.OldestDeleted, r.NewestDeleted, r.ExecutedAt,
+	); err != nil {
+		return Receipt{}, fmt.Errorf("retention: insert receipt: %w", err)
+	}
+	return r, nil
+}
+
+// Receipts returns a workspace's receipts, newest first.
+func (s *Store) Receipts(workspaceID string, limit int) ([]Receipt, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	rows, err := s.db.Query(
+		`SELECT `+receiptColumns+` FROM retention_receipts WHERE workspace_id = ?
+		 ORDER BY executed_at DESC, id DESC LIMIT ?`,
+		workspaceID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanReceipts(rows)
+}
+
+// Unshipped returns up to limit receipts not yet shipped upstream, oldest first.
+func (s *Store) Unshipped(limit int) ([]Receipt, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	rows, err := s.db.Query(
+		`SELECT `+receiptColumns+` FROM retention_receipts WHERE shipped = 0
+		 ORDER BY executed_at ASC, id ASC LIMIT ?`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanReceipts(rows)
+}
+
+// MarkShipped flags receipts as delivered upstream.
+func (s *Store) MarkShipped(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	if _, err := s.db.Exec(`UPDATE retention_receipts SET shipped = 1 WHERE id IN (`+placeholders+`)`, args...); err != nil {
+		return fmt.Errorf("retention: mark shipped: %w", err)
+	}
+	return nil
+}
+
+// Close closes the underlying database connection.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+const receiptColumns = `id, workspace_id, category, rule, items, bytes, oldest_deleted, newest_deleted, executed_at`
+
+func scanPolicies(rows *sql.Rows) ([]Policy, error) {
+	defer rows.Close()
+	var policies []Policy
+	for rows.Next() {
+		var p Policy
+		var rules string
+		if err := rows.Scan(&p.WorkspaceID, &rules, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(rules), &p.Rules); err != nil {
+			return nil, fmt.Errorf("retention: decode rules for %s: %w", p.WorkspaceID, err)
+		}
+		policies = append(policies, p)
+	}
+	return policies, rows.Err()
+}
+
+func scanReceipts(rows *sql.Rows) ([]Receipt, error) {
+	defer rows.Close()
+	receipts := []Receipt{}
+	for rows.Next() {
+		var r Receipt
+		var rule string
+		if err := rows.Scan(
+			&r.ID, &r.WorkspaceID, &r.Category, &rule, &r.Items, &r.Bytes, &r.OldestDeleted, &r.NewestDeleted, &r.ExecutedAt,
+		); err != nil {
+			return nil, err
+		}
+		_ = json.Unmarshal([]byte(rule), &r.Rule)
+		receipts = append(receipts, r)
+	}
+	return receipts, rows.Err()
+}
+
+func newReceiptID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return "retention-" + hex.EncodeToString(b)
+}
@@ -0,0 +1,227 @@
+// Package retry provides exponential backoff retry logic for VM agent
+// operations that can fail transiently: control plane callbacks, repository
+// clones, devcontainer builds, and agent credential fetches. Each operation
+// runs under a Policy, which operators can tune per operation through
+// config.Config; see ParsePolicy.
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PermanentError wraps an error that should not be retried.
+// Return Permanent(err) from the fn callback to stop retries immediately.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent wraps err as a PermanentError to stop retries.
+func Permanent(err error) error {
+	return &PermanentError{Err: err}
+}
+
+// Policy configures the retry behavior of one operation. Zero fields take
+// the DefaultPolicy value, except MaxAttempts.
+type Policy struct {
+	// InitialDelay is the base delay before the first retry.
+	InitialDelay time.Duration
+	// MaxDelay caps the exponential backoff.
+	MaxDelay time.Duration
+	// MaxElapsed is the operation's time budget: no retry starts once it
+	// has been spent.
+	MaxElapsed time.Duration
+	// MaxAttempts limits total attempts (0 = unlimited, use MaxElapsed).
+	MaxAttempts int
+	// Jitter is the largest random extra delay, as a fraction of the
+	// backoff delay, so callers failing together don't retry in lockstep.
+	// Negative disables jitter.
+	Jitter float64
+}
+
+// DefaultPolicy returns sensible defaults for control plane callbacks.
+func DefaultPolicy() Policy {
+	return Policy{
+		InitialDelay: 1 * time.Second,
+		MaxDelay:     30 * time.Second,
+		MaxElapsed:   2 * time.Minute,
+		MaxAttempts:  5,
+		Jitter:       0.5,
+	}
+}
+
+// OrDefault returns p, or def when p is the zero Policy, for callers whose
+// policy comes from a config that may not set it.
+func (p Policy) OrDefault(def Policy) Policy {
+	if p == (Policy{}) {
+		return def
+	}
+	return p
+}
+
+// ParsePolicy overrides base with a comma-separated spec such as
+// "attempts=3,initial=2s,max=30s,budget=5m,jitter=0.2". Keys that are left
+// out keep their base value; "jitter=0" disables jitter.
+func ParsePolicy(base Policy, spec string) (Policy, error) {
+	p := base
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return base, fmt.Errorf("retry policy entry %q must be key=value", item)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		var err error
+		switch key {
+		case "attempts":
+			p.MaxAttempts, err = strconv.Atoi(value)
+			if err == nil && p.MaxAttempts < 0 {
+				err = errors.New("must not be negative")
+			}
+		case "initial":
+			p.InitialDelay, err = parsePositiveDuration(value)
+		case "max":
+			p.MaxDelay, err = parsePositiveDuration(value)
+		case "budget":
+			p.MaxElapsed, err = parsePositiveDuration(value)
+		case "jitter":
+			p.Jitter, err = strconv.ParseFloat(value, 64)
+			if err == nil && (p.Jitter < 0 || p.Jitter > 1) {
+				err = errors.New("must be between 0 and 1")
+			}
+			if p.Jitter == 0 {
+				p.Jitter = -1
+			}
+		default:
+			return base, fmt.Errorf("unknown retry policy key %q", key)
+		}
+		if err != nil {
+			return base, fmt.Errorf("retry policy %s: %w", key, err)
+		}
+	}
+	return p, nil
+}
+
+func parsePositiveDuration(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err == nil && d <= 0 {
+		err = errors.New("must be positive")
+	}
+	return d, err
+}
+
+// Do executes fn with exponential backoff and jitter.
+// It stops retrying if fn returns a PermanentError (use Permanent() to wrap).
+// Returns the last error if all retries are exhausted.
+func Do(ctx context.Context, policy Policy, operationName string, fn func(ctx context.Context) error) error {
+	defaults := DefaultPolicy()
+	if policy.InitialDelay <= 0 {
+		policy.InitialDelay = defaults.InitialDelay
+	}
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = defaults.MaxDelay
+	}
+	if policy.MaxElapsed <= 0 {
+		policy.MaxElapsed = defaults.MaxElapsed
+	}
+	if policy.Jitter == 0 {
+		policy.Jitter = defaults.Jitter
+	}
+
+	start := time.Now()
+	delay := policy.InitialDelay
+	var lastErr error
+
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			if attempt > 1 {
+				slog.Info("Operation succeeded after retry",
+					"operation", operationName,
+					"attempt", attempt,
+					"elapsed", time.Since(start).Round(time.Millisecond),
+				)
+			}
+			return nil
+		}
+
+		// Check for permanent (non-retryable) error
+		var permErr *PermanentError
+		if errors.As(err, &permErr) {
+			slog.Warn("Operation returned permanent error, not retrying",
+				"operation", operationName,
+				"attempt", attempt,
+				"error", permErr.Err,
+			)
+			return permErr.Err
+		}
+
+		lastErr = err
+
+		// Check max attempts
+		if policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts {
+			slog.Warn("Operation retries exhausted (max attempts)",
+				"operation", operationName,
+				"attempts", attempt,
+				"elapsed", time.Since(start).Round(time.Millisecond),
+				"lastError", err,
+			)
+			return fmt.Errorf("%s: retries exhausted after %d attempts: %w", operationName, attempt, lastErr)
+		}
+
+		// Check max elapsed
+		if time.Since(start) >= policy.MaxElapsed {
+			slog.Warn("Operation retries exhausted (max elapsed)",
+				"operation", operationName,
+				"attempts", attempt,
+				"elapsed", time.Since(start).Round(time.Millisecond),
+				"lastError", err,
+			)
+			return fmt.Errorf("%s: retries exhausted after %v: %w", operationName, time.Since(start).Round(time.Millisecond), lastErr)
+		}
+
+		// Compute jittered delay (guard against zero/negative delay)
+		var jitter time.Duration
+		if maxN := int64(float64(delay) * policy.Jitter); maxN > 0 {
+			jitter = time.Duration(rand.Int63n(maxN))
+		}
+		sleepDur := delay + jitter
+
+		slog.Info("Operation failed, retrying",
+			"operation", operationName,
+			"attempt", attempt,
+			"delay", sleepDur.Round(time.Millisecond),
+			"error", err,
+		)
+
+		// Sleep with context cancellation support
+		timer := time.NewTimer(sleepDur)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%s: context cancelled during retry: %w", operationName, ctx.Err())
+		case <-timer.C:
+		}
+
+		// Exponential increase capped at MaxDelay
+		delay = time.Duration(math.Min(float64(delay*2), float64(policy.MaxDelay)))
+	}
+}
@@ -1,4 +1,4 @@
-package callbackretry
+package retry
 
 import (
 	"context"
@@ -13,7 +13,7 @@ func TestDoSucceedsOnFirstAttempt(t *testing.T) {
 	t.Parallel()
 
 	var attempts int32
-	err := Do(context.Background(), DefaultConfig(), "test-op", func(_ context.Context) error {
+	err := Do(context.Background(), DefaultPolicy(), "test-op", func(_ context.Context) error {
 		atomic.AddInt32(&attempts, 1)
 		return nil
 	})
@@ -30,14 +30,14 @@ func TestDoRetriesOnTransientError(t *testing.T) {
 	t.Parallel()
 
 	var attempts int32
-	cfg := Config{
+	policy := Policy{
 		InitialDelay: 10 * time.Millisecond,
 		MaxDelay:     50 * time.Millisecond,
 		MaxElapsed:   5 * time.Second,
 		MaxAttempts:  5,
 	}
 
-	err := Do(context.Background(), cfg, "test-retry", func(_ context.Context) error {
+	err := Do(context.Background(), policy, "test-retry", func(_ context.Context) error {
 		n := atomic.AddInt32(&attempts, 1)
 		if n < 3 {
 			return errors.New("transient error")
@@ -57,14 +57,14 @@ func TestDoExhaustsMaxAttempts(t *testing.T) {
 	t.Parallel()
 
 	var attempts int32
-	cfg := Config{
+	policy := Policy{
 		InitialDelay: 5 * time.Millisecond,
 		MaxDelay:     10 * time.Millisecond,
 		MaxElapsed:   10 * time.Second,
 		MaxAttempts:  3,
 	}
 
-	err := Do(context.Background(), cfg, "test-exhaust", func(_ context.Context) error {
+	err := Do(context.Background(), policy, "test-exhaust", func(_ context.Context) error {
 		atomic.AddInt32(&attempts, 1)
 		return errors.New("persistent failure")
 	})
@@ -88,7 +88,7 @@ func TestDoRespectsContextCancellation(t *testing.T) {
 
 	ctx, cancel := context.WithCancel(context.Background())
 	var attempts int32
-	cfg := Config{
+	policy := Policy{
 		InitialDelay: 100 * time.Millisecond,
 		MaxDelay:     200 * time.Millisecond,
 		MaxElapsed:   10 * time.Second,
@@ -101,7 +101,7 @@ func TestDoRespectsContextCancellation(t *testing.T) {
 		cancel()
 	}()
 
-	err := Do(ctx, cfg, "test-cancel", func(_ context.Context) error {
+	err := Do(ctx, policy, "test-cancel", func(_ context.Context) error {
 		atomic.AddInt32(&attempts, 1)
 		return errors.New("always fail")
 	})
@@ -117,7 +117,7 @@ func TestDoRespectsContextCancellation(t *testing.T) {
 func TestDoExhaustsMaxElapsed(t *testing.T) {
 	t.Parallel()
 
-	cfg := Config{
+	policy := Policy{
 		InitialDelay: 5 * time.Millisecond,
 		MaxDelay:     10 * time.Millisecond,
 		MaxElapsed:   30 * time.Millisecond,
@@ -125,7 +125,7 @@ func TestDoExhaustsMaxElapsed(t *testing.T) {
 	}
 
 	start := time.Now()
-	err := Do(context.Background(), cfg, "test-elapsed", func(_ context.Context) error {
+	err := Do(context.Background(), policy, "test-elapsed", func(_ context.Context) error {
 		return errors.New("keep failing")
 	})
 
@@ -146,14 +146,14 @@ func TestDoWrapsOriginalError(t *testing.T) {
 	t.Parallel()
 
 	originalErr := errors.New("the original problem")
-	cfg := Config{
+	policy := Policy{
 		InitialDelay: 5 * time.Millisecond,
 		MaxDelay:     10 * time.Millisecond,
 		MaxElapsed:   10 * time.Second,
 		MaxAttempts:  1,
 	}
 
-	err := Do(context.Background(), cfg, "test-wrap", func(_ context.Context) error {
+	err := Do(context.Background(), policy, "test-wrap", func(_ context.Context) error {
 		return originalErr
 	})
 
@@ -165,13 +165,13 @@ func TestDoWrapsOriginalError(t *testing.T) {
 	}
 }
 
-func TestDoAppliesDefaultsForZeroConfig(t *testing.T) {
+func TestDoAppliesDefaultsForZeroPolicy(t *testing.T) {
 	t.Parallel()
 
 	var attempts int32
-	cfg := Config{} // all zero values
+	policy := Policy{} // all zero values
 
-	err := Do(context.Background(), cfg, "test-defaults", func(_ context.Context) error {
+	err := Do(context.Background(), policy, "test-defaults", func(_ context.Context) error {
 		n := atomic.AddInt32(&attempts, 1)
 		if n < 2 {
 			return errors.New("fail once")
@@ -190,14 +190,14 @@ func TestDoAppliesDefaultsForZeroConfig(t *testing.T) {
 func TestDoIncludesOperationNameInError(t *testing.T) {
 	t.Parallel()
 
-	cfg := Config{
+	policy := Policy{
 		InitialDelay: 5 * time.Millisecond,
 		MaxDelay:     10 * time.Millisecond,
 		MaxElapsed:   10 * time.Second,
 		MaxAttempts:  1,
 	}
 
-	err := Do(context.Background(), cfg, "my-special-operation", func(_ context.Context) error {
+	err := Do(context.Background(), policy, "my-special-operation", func(_ context.Context) error {
 		return errors.New("fail")
 	})
 
@@ -213,7 +213,7 @@ func TestDoStopsOnPermanentError(t *testing.T) {
 	t.Parallel()
 
 	var attempts int32
-	cfg := Config{
+	policy := Policy{
 		InitialDelay: 5 * time.Millisecond,
 		MaxDelay:     10 * time.Millisecond,
 		MaxElapsed:   10 * time.Second,
@@ -221,7 +221,7 @@ func TestDoStopsOnPermanentError(t *testing.T) {
 	}
 
 	originalErr := errors.New("bad request: invalid workspace ID")
-	err := Do(context.Background(), cfg, "test-permanent", func(_ context.Context) error {
+	err := Do(context.Background(), policy, "test-permanent", func(_ context.Context) error {
 		atomic.AddInt32(&attempts, 1)
 		return Permanent(originalErr)
 	})
@@ -243,14 +243,14 @@ func TestDoRetriesThenHitsPermanentError(t *testing.T) {
 	t.Parallel()
 
 	var attempts int32
-	cfg := Config{
+	policy := Policy{
 		InitialDelay: 5 * time.Millisecond,
 		MaxDelay:     10 * time.Millisecond,
 		MaxElapsed:   10 * time.Second,
 		MaxAttempts:  10,
 	}
 
-	err := Do(context.Background(), cfg, "test-transient-then-permanent", func(_ context.Context) error {
+	err := Do(context.Background(), policy, "test-transient-then-permanent", func(_ context.Context) error {
 		n := atomic.AddInt32(&attempts, 1)
 		if n < 3 {
 			return errors.New("transient 503")
@@ -269,3 +269,56 @@ func TestDoRetriesThenHitsPermanentError(t *testing.T) {
 		t.Fatalf("expected 3 attempts, got %d", atomic.LoadInt32(&attempts))
 	}
 }
+
+func TestParsePolicy(t *testing.T) {
+	t.Parallel()
+
+	base := DefaultPolicy()
+	got, err := ParsePolicy(base, " attempts=3, initial=2s,max=1m ,budget=10m,jitter=0.2")
+	if err != nil {
+		t.Fatalf("ParsePolicy: %v", err)
+	}
+	want := Policy{InitialDelay: 2 * time.Second, MaxDelay: time.Minute, MaxElapsed: 10 * time.Minute, MaxAttempts: 3, Jitter: 0.2}
+	if got != want {
+		t.Fatalf("ParsePolicy = %+v, want %+v", got, want)
+	}
+
+	got, err = ParsePolicy(base, "budget=30s")
+	if err != nil || got.MaxElapsed != 30*time.Second || got.MaxAttempts != base.MaxAttempts || got.InitialDelay != base.InitialDelay {
+		t.Fatalf("partial spec = %+v, %v; want base with a 30s budget", got, err)
+	}
+	if got, err := ParsePolicy(base, ""); err != nil || got != base {
+		t.Fatalf("empty spec = %+v, %v; want base", got, err)
+	}
+	if got, err := ParsePolicy(base, "jitter=0"); err != nil || got.Jitter >= 0 {
+		t.Fatalf("jitter=0 = %+v, %v; want jitter disabled", got, err)
+	}
+
+	for _, spec := range []string{"attempts", "attempts=-1", "initial=0s", "max=soon", "jitter=2", "retries=3"} {
+		if got, err := ParsePolicy(base, spec); err == nil {
+			t.Errorf("ParsePolicy(%q) = %+v, want error", spec, got)
+		} else if got != base {
+			t.Errorf("ParsePolicy(%q) returned %+v on error, want base", spec, got)
+		}
+	}
+}
+
+func TestDoWithoutJitterWaitsExactBackoff(t *testing.T) {
+	t.Parallel()
+
+	policy := Policy{
+		InitialDelay: 20 * time.Millisecond,
+		MaxDelay:     20 * time.Millisecond,
+		MaxElapsed:   10 * time.Second,
+		MaxAttempts:  3,
+		Jitter:       -1,
+	}
+
+	start := time.Now()
+	_ = Do(context.Background(), policy, "test-no-jitter", func(_ context.Context) error {
+		return errors.New("keep failing")
+	})
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("two 20ms backoffs took %v", elapsed)
+	}
+}
@@ -0,0 +1,242 @@
+// Package samctl is the local debugging CLI of the VM agent, run as
+// `vm-agent samctl <command>` (or through a symlink named samctl). It talks
+// to the running agent over its root-only control socket, so debugging a
+// workspace on the node does not need a control-plane token.
+package samctl
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+const usage = `usage: samctl [-socket path] <command> [args]
+
+commands:
+  sessions                        list agent sessions
+  replay <workspace> <session>    dump a session's replay buffer as JSON lines
+  rebuild <workspace>             tear down and rebuild a workspace's devcontainer
+  logs [-f] [workspace]           print a workspace's boot log; -f follows it
+  idle-shutdown                   drain the node and ask the control plane to tear it down
+`
+
+// logsPollInterval is how often logs -f asks the agent for new entries.
+const logsPollInterval = time.Second
+
+// Run executes a samctl command line and returns the process exit code.
+func Run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("samctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { fmt.Fprint(stderr, usage) }
+	socket := flags.String("socket", defaultSocketPath(), "control socket of the running agent")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	c := &client{socket: *socket, stdout: stdout}
+	command, rest := flags.Arg(0), flags.Args()[1:]
+	var err error
+	switch {
+	case command == "sessions" && len(rest) == 0:
+		err = c.sessions()
+	case command == "replay" && len(rest) == 2:
+		err = c.replay(rest[0], rest[1])
+	case command == "rebuild" && len(rest) == 1:
+		err = c.rebuild(rest[0])
+	case command == "logs":
+		err = c.logs(rest, stderr)
+	case command == "idle-shutdown" && len(rest) == 0:
+		err = c.idleShutdown()
+	default:
+		flags.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "samctl:", err)
+		return 1
+	}
+	return 0
+}
+
+// defaultSocketPath is CONTROL_SOCKET_PATH when set, so samctl finds an agent
+// started with the same environment.
+func defaultSocketPath() string {
+	if path := os.Getenv("CONTROL_SOCKET_PATH"); path != "" {
+		return path
+	}
+	return config.DefaultControlSocketPath
+}
+
+type client struct {
+	socket string
+	stdout io.Writer
+}
+
+// call sends a request to the agent and decodes its JSON response into out.
+func (c *client) call(method, path string, out interface{}) error {
+	httpClient := &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", c.socket)
+			},
+		},
+	}
+	req, err := http.NewRequest(method, "http://samctl"+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("agent not reachable on %s: %w", c.socket, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error   string `json:"error"`
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(body, &apiErr)
+		detail := strings.TrimSpace(apiErr.Message + " " + apiErr.Error)
+		if detail == "" {
+			detail = strings.TrimSpace(string(body))
+		}
+		return fmt.Errorf("%s %s: HTTP %d: %s", method, path, resp.StatusCode, detail)
+	}
+	return json.Unmarshal(body, out)
+}
+
+func (c *client) sessions() error {
+	var resp struct {
+		Sessions []struct {
+			WorkspaceID    string `json:"workspaceId"`
+			SessionID      string `json:"sessionId"`
+			Status         string `json:"status"`
+			AgentType      string `json:"agentType"`
+			Viewers        int    `json:"viewers"`
+			ReplayMessages int    `json:"replayMessages"`
+			ReplayBytes    int    `json:"replayBytes"`
+		} `json:"sessions"`
+	}
+	if err := c.call(http.MethodGet, "/sessions", &resp); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "WORKSPACE\tSESSION\tSTATUS\tAGENT\tVIEWERS\tREPLAY MSGS\tREPLAY BYTES")
+	for _, s := range resp.Sessions {
+		agent := s.AgentType
+		if agent == "" {
+			agent = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\t%d\n", s.WorkspaceID, s.SessionID, s.Status, agent, s.Viewers, s.ReplayMessages, s.ReplayBytes)
+	}
+	return tw.Flush()
+}
+
+func (c *client) replay(workspaceID, sessionID string) error {
+	var resp struct {
+		Messages []json.RawMessage `json:"messages"`
+	}
+	path := "/sessions/" + url.PathEscape(workspaceID) + "/" + url.PathEscape(sessionID) + "/replay"
+	if err := c.call(http.MethodGet, path, &resp); err != nil {
+		return err
+	}
+	for _, msg := range resp.Messages {
+		fmt.Fprintln(c.stdout, string(msg))
+	}
+	return nil
+}
+
+func (c *client) rebuild(workspaceID string) error {
+	var resp struct {
+		Status string `json:"status"`
+	}
+	if err := c.call(http.MethodPost, "/workspaces/"+url.PathEscape(workspaceID)+"/rebuild", &resp); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "workspace %s: %s; follow with `samctl logs -f %s`\n", workspaceID, resp.Status, workspaceID)
+	return nil
+}
+
+func (c *client) logs(args []string, stderr io.Writer) error {
+	flags := flag.NewFlagSet("logs", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	follow := flags.Bool("f", false, "follow the boot log until the boot completes")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		return fmt.Errorf("logs takes at most one workspace")
+	}
+
+	var cursor uint64
+	for {
+		query := url.Values{"after": {fmt.Sprint(cursor)}}
+		if flags.NArg() == 1 {
+			query.Set("workspace", flags.Arg(0))
+		}
+		var resp struct {
+			Entries []struct {
+				Step      string `json:"step"`
+				Status    string `json:"status"`
+				Message   string `json:"message"`
+				Detail    string `json:"detail"`
+				Progress  int    `json:"progress"`
+				Timestamp string `json:"timestamp"`
+			} `json:"entries"`
+			Cursor   uint64 `json:"cursor"`
+			Complete bool   `json:"complete"`
+		}
+		if err := c.call(http.MethodGet, "/boot-logs?"+query.Encode(), &resp); err != nil {
+			return err
+		}
+		for _, e := range resp.Entries {
+			line := fmt.Sprintf("%s %-18s %-9s %s", e.Timestamp, e.Step, e.Status, e.Message)
+			if e.Progress > 0 {
+				line += fmt.Sprintf(" (%d%%)", e.Progress)
+			}
+			if e.Detail != "" {
+				line += " [" + e.Detail + "]"
+			}
+			fmt.Fprintln(c.stdout, line)
+		}
+		cursor = resp.Cursor
+		if !*follow || resp.Complete {
+			return nil
+		}
+		time.Sleep(logsPollInterval)
+	}
+}
+
+func (c *client) idleShutdown() error {
+	var resp struct {
+		Reason           string `json:"reason"`
+		CountdownSeconds int    `json:"countdownSeconds"`
+	}
+	if err := c.call(http.MethodPost, "/idle-shutdown", &resp); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "node draining (%s); shutdown in %ds\n", resp.Reason, resp.CountdownSeconds)
+	return nil
+}
@@ -0,0 +1,29 @@
+package samctl
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunRejectsUnknownCommands(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := Run([]string{"frobnicate"}, &stdout, &stderr); code != 2 {
+		t.Fatalf("exit code = %d, want 2", code)
+	}
+	if !strings.Contains(stderr.String(), "usage: samctl") {
+		t.Fatalf("stderr = %q, want usage", stderr.String())
+	}
+}
+
+func TestRunReportsUnreachableAgent(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "missing.sock")
+	var stdout, stderr bytes.Buffer
+	if code := Run([]string{"-socket", socket, "sessions"}, &stdout, &stderr); code != 1 {
+		t.Fatalf("exit code = %d, want 1", code)
+	}
+	if !strings.Contains(stderr.String(), "agent not reachable on "+socket) {
+		t.Fatalf("stderr = %q", stderr.String())
+	}
+}
@@ -0,0 +1,53 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSpecNext(t *testing.T) {
+	// 2026-10-15 is a Thursday.
+	from := time.Date(2026, 10, 15, 10, 17, 30, 0, time.UTC)
+	cases := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, 10, 15, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 10, 15, 10, 30, 0, 0, time.UTC)},
+		{"@nightly", time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)},
+		{"30 2 * * 1-5", time.Date(2026, 10, 16, 2, 30, 0, 0, time.UTC)},
+		{"0 9 * * 7", time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)},
+		{"0 0 1 1 *", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"5,45 10 * * *", time.Date(2026, 10, 15, 10, 45, 0, 0, time.UTC)},
+		// Both day fields restricted: either may match.
+		{"0 0 20 * 5", time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tc := range cases {
+		spec, err := Parse(tc.expr)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tc.expr, err)
+		}
+		if got := spec.Next(from); !got.Equal(tc.want) {
+			t.Errorf("Next(%q) = %v, want %v", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestSpecNextNeverMatches(t *testing.T) {
+	spec, err := Parse("0 0 31 2 *")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if got := spec.Next(time.Now()); !got.IsZero() {
+		t.Fatalf("Next = %v, want zero", got)
+	}
+}
+
+func TestParseRejectsInvalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@often"} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", expr)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+// Package schedule runs workspace commands and agent prompts on cron
+// schedules. Entries come from the repository's .sam/schedule.json and from
+// the control plane; each source is replaced as a whole. Schedules and run
+// history are held in memory and are rebuilt when the workspace is next
+// ready after an agent restart.
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Source identifies where a workspace's schedule entries came from.
+type Source string
+
+const (
+	SourceRepo Source = "repo"
+	SourceAPI  Source = "api"
+)
+
+// RepoFile is the repository-relative path of the repository schedule.
+const RepoFile = ".sam/schedule.json"
+
+const (
+	maxCommandBytes = 16 * 1024
+	maxPromptBytes  = 64 * 1024
+)
+
+// ErrNoAgent is returned by a RunFunc when a prompt entry has no agent type
+// and the workspace has no default.
+var ErrNoAgent = errors.New("no agent type for scheduled prompt")
+
+var entryIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// Entry is one scheduled command or agent prompt. Exactly one of Command
+// and Prompt is set.
+type Entry struct {
+	ID       string `json:"id"`
+	Schedule string `json:"schedule"`
+	Command  string `json:"command,omitempty"`
+	Prompt   string `json:"prompt,omitempty"`
+	// AgentType selects the agent for a prompt. Empty uses the workspace's
+	// default agent.
+	AgentType string     `json:"agentType,omitempty"`
+	Source    Source     `json:"source"`
+	NextRunAt *time.Time `json:"nextRunAt,omitempty"`
+}
+
+// File is the format of .sam/schedule.json and of control-plane updates.
+type File struct {
+	Entries []Entry `json:"entries"`
+}
+
+// RunStatus is the outcome of a scheduled run.
+type RunStatus string
+
+const (
+	RunRunning   RunStatus = "running"
+	RunSucceeded RunStatus = "succeeded"
+	RunFailed    RunStatus = "failed"
+	// RunSkipped means the entry was due while its previous run was still
+	// going.
+	RunSkipped RunStatus = "skipped"
+)
+
+// Run is a record of one scheduled run.
+type Run struct {
+	EntryID   string     `json:"entryId"`
+	Source    Source     `json:"source"`
+	Status    RunStatus  `json:"status"`
+	JobID     string     `json:"jobId,omitempty"`
+	SessionID string     `json:"sessionId,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	StartedAt time.Time  `json:"startedAt"`
+	EndedAt   *time.Time `json:"endedAt,omitempty"`
+}
+
+// Result is what a RunFunc reports about a finished run.
+type Result struct {
+	JobID     string
+	SessionID string
+	Err       error
+}
+
+// RunFunc runs an entry to completion.
+type RunFunc func(ctx context.Context, workspaceID string, entry Entry) Result
+
+// ReportFunc is called when a run starts, finishes, or is skipped.
+type ReportFunc func(workspaceID string, run Run)
+
+// Scheduler tracks workspace schedules and starts due runs.
+type Scheduler struct {
+	run        RunFunc
+	report     ReportFunc
+	maxEntries int
+	maxHistory int
+
+	mu         sync.Mutex
+	workspaces map[string]*workspaceSchedule
+}
+
+type workspaceSchedule struct {
+	entries map[Source][]*scheduledEntry
+	history []Run // newest last
+}
+
+type scheduledEntry struct {
+	Entry
+	spec    *Spec
+	next    time.Time
+	running bool
+}
+
+// New returns a Scheduler. maxEntries caps the entries per workspace across
+// both sources and maxHistory the runs kept per workspace; zero means
+// unlimited for either. report may be nil.
+func New(run RunFunc, report ReportFunc, maxEntries, maxHistory int) *Scheduler {
+	return &Scheduler{
+		run:        run,
+		report:     report,
+		maxEntries: maxEntries,
+		maxHistory: maxHistory,
+		workspaces: make(map[string]*workspaceSchedule),
+	}
+}
+
+// Validate checks entries from one source and parses their schedules.
+func Validate(entries []Entry) error {
+	seen := make(map[string]bool, len(entries))
+	for i, e := range entries {
+		if !entryIDPattern.MatchString(e.ID) {
+			return fmt.Errorf("entries[%d].id must be 1-64 letters, digits, '.', '_' or '-'", i)
+		}
+		if seen[e.ID] {
+			return fmt.Errorf("duplicate entry id %q", e.ID)
+		}
+		seen[e.ID] = true
+		if _, err := Parse(e.Schedule); err != nil {
+			return fmt.Errorf("entry %q: %w", e.ID, err)
+		}
+		hasCommand, hasPrompt := strings.TrimSpace(e.Command) != "", strings.TrimSpace(e.Prompt) != ""
+		switch {
+		case hasCommand == hasPrompt:
+			return fmt.Errorf("entry %q must set exactly one of command and prompt", e.ID)
+		case len(e.Command) > maxCommandBytes:
+			return fmt.Errorf("entry %q command must be at most %d bytes", e.ID, maxCommandBytes)
+		case len(e.Prompt) > maxPromptBytes:
+			return fmt.Errorf("entry %q prompt must be at most %d bytes", e.ID, maxPromptBytes)
+		case strings.ContainsRune(e.Command, '\x00') || strings.ContainsRune(e.Prompt, '\x00'):
+			return fmt.Errorf("entry %q contains NUL byte", e.ID)
+		case hasCommand && e.AgentType != "":
+			return fmt.Errorf("entry %q sets agentType without a prompt", e.ID)
+		}
+	}
+	return nil
+}
+
+// Set replaces the workspace's entries from source. An empty list clears
+// them. Entries keep their next run time when their schedule is unchanged,
+// and an entry still running finishes normally.
+func (s *Scheduler) Set(workspaceID string, source Source, entries []Entry, now time.Time) error {
+	if err := Validate(entries); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ws := s.workspaces[workspaceID]
+	if ws == nil {
+		ws = &workspaceSchedule{entries: make(map[Source][]*scheduledEntry)}
+		s.workspaces[workspaceID] = ws
+	}
+	if s.maxEntries > 0 {
+		total := len(entries)
+		for src, list := range ws.entries {
+			if src != source {
+				total += len(list)
+			}
+		}
+		if total > s.maxEntries {
+			return fmt.Errorf("workspace schedule is limited to %d entries", s.maxEntries)
+		}
+	}
+
+	previous := make(map[string]*scheduledEntry, len(ws.entries[source]))
+	for _, e := range ws.entries[source] {
+		previous[e.ID] = e
+	}
+	list := make([]*scheduledEntry, 0, len(entries))
+	for _, e := range entries {
+		spec, _ := Parse(e.Schedule)
+		e.Source = source
+		e.NextRunAt = nil
+		se := &scheduledEntry{Entry: e, spec: spec, next: spec.Next(now)}
+		if old, ok := previous[e.ID]; ok {
+			se.running = old.running
+			if old.Schedule == e.Schedule {
+				se.next = old.next
+			}
+		}
+		list = append(list, se)
+	}
+	if len(list) == 0 {
+		delete(ws.entries, source)
+	} else {
+		ws.entries[source] = list
+	}
+	return nil
+}
+
+// Entries returns the workspace's entries, repository entries first.
+func (s *Scheduler) Entries(workspaceID string) []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := []Entry{}
+	ws := s.workspaces[workspaceID]
+	if ws == nil {
+		return out
+	}
+	for _, source := range []Source{SourceRepo, SourceAPI} {
+		for _, se := range ws.entries[source] {
+			e := se.Entry
+			if !se.next.IsZero() {
+				next := se.next
+				e.NextRunAt = &next
+			}
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// History returns the workspace's recorded runs, newest first.
+func (s *Scheduler) History(workspaceID string) []Run {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := []Run{}
+	if ws := s.workspaces[workspaceID]; ws != nil {
+		for i := len(ws.history) - 1; i >= 0; i-- {
+			out = append(out, ws.history[i])
+		}
+	}
+	return out
+}
+
+// RemoveWorkspace forgets the workspace's entries and history. Runs already
+// started are not stopped.
+func (s *Scheduler) RemoveWorkspace(workspaceID string) {
+	s.mu.Lock()
+	delete(s.workspaces, workspaceID)
+	s.mu.Unlock()
+}
+
+// Tick starts every entry due at or before now. Each run gets its own
+// goroutine; Tick does not wait for them.
+func (s *Scheduler) Tick(ctx context.Context, now time.Time) {
+	type due struct {
+		workspaceID string
+		entry       *scheduledEntry
+		run         Run
+	}
+	var started []due
+	var skipped []due
+
+	s.mu.Lock()
+	ids := make([]string, 0, len(s.workspaces))
+	for id := range s.workspaces {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		ws := s.workspaces[id]
+		for _, source := range []Source{SourceRepo, SourceAPI} {
+			for _, se := range ws.entries[source] {
+				if se.next.IsZero() || se.next.After(now) {
+					continue
+				}
+				se.next = se.spec.Next(now)
+				run := Run{EntryID: se.ID, Source: source, StartedAt: now.UTC()}
+				if se.running {
+					ended := run.StartedAt
+					run.Status, run.EndedAt = RunSkipped, &ended
+					run.Error = "previous run still in progress"
+					ws.appendRun(run, s.maxHistory)
+					skipped = append(skipped, due{id, se, run})
+					continue
+				}
+				se.running = true
+				run.Status = RunRunning
+				started = append(started, due{id, se, run})
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	for _, d := range skipped {
+		s.emit(d.workspaceID, d.run)
+	}
+	for _, d := range started {
+		s.emit(d.workspaceID, d.run)
+		go s.execute(ctx, d.workspaceID, d.entry, d.run)
+	}
+}
+
+func (s *Scheduler) execute(ctx context.Context, workspaceID string, se *scheduledEntry, run Run) {
+	result := s.run(ctx, workspaceID, se.Entry)
+	ended := time.Now().UTC()
+	run.JobID, run.SessionID, run.EndedAt = result.JobID, result.SessionID, &ended
+	run.Status = RunSucceeded
+	if result.Err != nil {
+		run.Status, run.Error = RunFailed, result.Err.Error()
+	}
+
+	s.mu.Lock()
+	se.running = false
+	// The workspace may have been removed, or the entry replaced by Set,
+	// while the run was going.
+	if ws := s.workspaces[workspaceID]; ws != nil {
+		for _, current := range ws.entries[run.Source] {
+			if current.ID == se.ID {
+				current.running = false
+			}
+		}
+		ws.appendRun(run, s.maxHistory)
+	}
+	s.mu.Unlock()
+	s.emit(workspaceID, run)
+}
+
+func (s *Scheduler) emit(workspaceID string, run Run) {
+	if s.report != nil {
+		s.report(workspaceID, run)
+	}
+}
+
+func (ws *workspaceSchedule) appendRun(run Run, max int) {
+	ws.history = append(ws.history, run)
+	if max > 0 && len(ws.history) > max {
+		ws.history = append(ws.history[:0], ws.history[len(ws.history)-max:]...)
+	}
+}
+
+// Start calls Tick at the top of every minute until ctx is done.
+func (s *Scheduler) Start(ctx context.Context) {
+	for {
+		now := time.Now()
+		timer := time.NewTimer(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case t := <-timer.C:
+			s.Tick(ctx, t.Truncate(time.Minute))
+		}
+	}
+}
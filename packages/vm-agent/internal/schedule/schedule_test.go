@@ -0,0 +1,129 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recorder struct {
+	mu   sync.Mutex
+	runs []Run
+	ch   chan Run
+}
+
+func newRecorder() *recorder { return &recorder{ch: make(chan Run, 16)} }
+
+func (r *recorder) report(_ string, run Run) {
+	r.mu.Lock()
+	r.runs = append(r.runs, run)
+	r.mu.Unlock()
+	r.ch <- run
+}
+
+func (r *recorder) next(t *testing.T) Run {
+	t.Helper()
+	select {
+	case run := <-r.ch:
+		return run
+	case <-time.After(5 * time.Second):
+		t.Fatal("no run reported")
+		return Run{}
+	}
+}
+
+func TestTickRunsDueEntriesAndRecordsHistory(t *testing.T) {
+	rec := newRecorder()
+	s := New(func(_ context.Context, _ string, e Entry) Result {
+		if e.ID == "broken" {
+			return Result{JobID: "job_1", Err: errors.New("exit status 1")}
+		}
+		return Result{JobID: "job_2"}
+	}, rec.report, 0, 0)
+
+	now := time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC)
+	if err := s.Set("ws-1", SourceRepo, []Entry{
+		{ID: "broken", Schedule: "* * * * *", Command: "false"},
+		{ID: "hourly", Schedule: "@hourly", Command: "true"},
+	}, now); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	s.Tick(context.Background(), now.Add(time.Minute))
+	if run := rec.next(t); run.EntryID != "broken" || run.Status != RunRunning {
+		t.Fatalf("first report = %+v, want broken running", run)
+	}
+	if run := rec.next(t); run.Status != RunFailed || run.Error != "exit status 1" || run.JobID != "job_1" {
+		t.Fatalf("second report = %+v, want failed run of job_1", run)
+	}
+
+	history := s.History("ws-1")
+	if len(history) != 1 || history[0].EntryID != "broken" || history[0].EndedAt == nil {
+		t.Fatalf("history = %+v", history)
+	}
+	entries := s.Entries("ws-1")
+	if len(entries) != 2 || entries[1].NextRunAt == nil || !entries[1].NextRunAt.Equal(now.Add(time.Hour)) {
+		t.Fatalf("entries = %+v", entries)
+	}
+}
+
+func TestTickSkipsEntryStillRunning(t *testing.T) {
+	rec := newRecorder()
+	release := make(chan struct{})
+	s := New(func(context.Context, string, Entry) Result {
+		<-release
+		return Result{}
+	}, rec.report, 0, 2)
+
+	now := time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC)
+	if err := s.Set("ws-1", SourceAPI, []Entry{{ID: "slow", Schedule: "* * * * *", Prompt: "update deps"}}, now); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	s.Tick(context.Background(), now.Add(time.Minute))
+	rec.next(t)
+	s.Tick(context.Background(), now.Add(2*time.Minute))
+	if run := rec.next(t); run.Status != RunSkipped {
+		t.Fatalf("report = %+v, want skipped", run)
+	}
+	close(release)
+	if run := rec.next(t); run.Status != RunSucceeded {
+		t.Fatalf("report = %+v, want succeeded", run)
+	}
+	if got := s.History("ws-1"); len(got) != 2 || got[0].Status != RunSucceeded {
+		t.Fatalf("history = %+v", got)
+	}
+}
+
+func TestSetValidatesAndLimitsEntries(t *testing.T) {
+	s := New(nil, nil, 2, 0)
+	now := time.Now()
+	bad := [][]Entry{
+		{{ID: "", Schedule: "* * * * *", Command: "true"}},
+		{{ID: "a", Schedule: "nope", Command: "true"}},
+		{{ID: "a", Schedule: "* * * * *"}},
+		{{ID: "a", Schedule: "* * * * *", Command: "true", Prompt: "hi"}},
+		{{ID: "a", Schedule: "* * * * *", Command: "true", AgentType: "claude-code"}},
+		{{ID: "a", Schedule: "* * * * *", Command: "true"}, {ID: "a", Schedule: "* * * * *", Command: "true"}},
+	}
+	for _, entries := range bad {
+		if err := s.Set("ws-1", SourceAPI, entries, now); err == nil {
+			t.Errorf("Set(%+v) succeeded, want error", entries)
+		}
+	}
+
+	if err := s.Set("ws-1", SourceRepo, []Entry{{ID: "a", Schedule: "@daily", Command: "true"}}, now); err != nil {
+		t.Fatalf("Set repo: %v", err)
+	}
+	two := []Entry{{ID: "b", Schedule: "@daily", Command: "true"}, {ID: "c", Schedule: "@daily", Command: "true"}}
+	if err := s.Set("ws-1", SourceAPI, two, now); err == nil {
+		t.Fatal("Set over the entry limit succeeded")
+	}
+	if err := s.Set("ws-1", SourceAPI, two[:1], now); err != nil {
+		t.Fatalf("Set api: %v", err)
+	}
+	if got := s.Entries("ws-1"); len(got) != 2 || got[0].Source != SourceRepo || got[1].Source != SourceAPI {
+		t.Fatalf("entries = %+v", got)
+	}
+}
@@ -0,0 +1,59 @@
+package selfupdate
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+)
+
+// ListenFDEnv names the environment variable through which Exec passes the
+// inherited listener's file descriptor to the new agent process.
+const ListenFDEnv = "VM_AGENT_LISTEN_FD"
+
+// Listen returns a TCP listener for addr. After a self-update re-exec it
+// adopts the listener inherited from the previous process, so connections
+// queued during the handoff are served instead of refused; otherwise it
+// listens afresh.
+func Listen(addr string) (net.Listener, error) {
+	raw, ok := os.LookupEnv(ListenFDEnv)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	_ = os.Unsetenv(ListenFDEnv)
+
+	ln, err := inheritListener(raw, addr)
+	if err != nil {
+		slog.Warn("Ignoring inherited listener", "fd", raw, "error", err)
+		return net.Listen("tcp", addr)
+	}
+	slog.Info("Adopted listener from previous agent process", "addr", ln.Addr().String())
+	return ln, nil
+}
+
+func inheritListener(raw, addr string) (net.Listener, error) {
+	fd, err := strconv.Atoi(raw)
+	if err != nil || fd < 3 {
+		return nil, fmt.Errorf("invalid %s %q", ListenFDEnv, raw)
+	}
+	f := os.NewFile(uintptr(fd), "inherited-listener")
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only adopt the listener if it is bound where this process would bind.
+	_, wantPort, err := net.SplitHostPort(addr)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	tcpAddr, ok := ln.Addr().(*net.TCPAddr)
+	if !ok || strconv.Itoa(tcpAddr.Port) != wantPort {
+		ln.Close()
+		return nil, fmt.Errorf("inherited listener is bound to %s, want %s", ln.Addr(), addr)
+	}
+	return ln, nil
+}
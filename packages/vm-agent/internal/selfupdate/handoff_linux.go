@@ -0,0 +1,39 @@
+//go:build linux
+
+package selfupdate
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// Exec replaces the current process with binaryPath, keeping the PID (so
+// systemd keeps supervising it) and passing ln to the new process via
+// ListenFDEnv. It only returns on failure.
+func Exec(binaryPath string, ln net.Listener) error {
+	env := os.Environ()
+	if ln != nil {
+		tcp, ok := ln.(*net.TCPListener)
+		if !ok {
+			return fmt.Errorf("cannot hand off %T listener", ln)
+		}
+		// File returns a dup of the socket; it must survive exec.
+		f, err := tcp.File()
+		if err != nil {
+			return fmt.Errorf("duplicate listener: %w", err)
+		}
+		fd := f.Fd()
+		if _, _, errno := syscall.Syscall(syscall.SYS_FCNTL, fd, syscall.F_SETFD, 0); errno != 0 {
+			f.Close()
+			return fmt.Errorf("clear close-on-exec on listener: %w", errno)
+		}
+		env = append(env, ListenFDEnv+"="+strconv.Itoa(int(fd)))
+	}
+	if err := syscall.Exec(binaryPath, os.Args, env); err != nil {
+		return fmt.Errorf("exec %s: %w", binaryPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package selfupdate
+
+import (
+	"errors"
+	"net"
+)
+
+// Exec is only supported on Linux, where the agent runs.
+func Exec(binaryPath string, ln net.Listener) error {
+	return errors.New("self-update exec is only supported on linux")
+}
@@ -0,0 +1,280 @@
+// Package selfupdate upgrades the running VM agent in place. An Updater asks
+// the control plane for the release this node should run, downloads it,
+// checks its SHA-256 digest and Ed25519 signature, and swaps it in for the
+// agent binary. Exec then replaces the agent process with the new binary,
+// handing over the HTTP listener so clients see no refused connections; the
+// caller persists session state beforehand so the new process can resume it.
+package selfupdate
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// maxBinaryBytes bounds a release download.
+const maxBinaryBytes = 512 << 20
+
+// Release describes an agent binary published by the control plane.
+type Release struct {
+	Version string `json:"version"`
+	// URL is absolute or relative to the control plane.
+	URL string `json:"url"`
+	// SHA256 is the hex digest of the binary.
+	SHA256 string `json:"sha256"`
+	// Signature is the base64 Ed25519 signature of SignedMessage.
+	Signature string `json:"signature"`
+}
+
+// SignedMessage returns the bytes a release signature covers: the version
+// and the binary digest, so a signature cannot be replayed for another
+// version or binary.
+func (r Release) SignedMessage() []byte {
+	return []byte("vm-agent " + r.Version + " " + strings.ToLower(r.SHA256))
+}
+
+// Config configures an Updater.
+type Config struct {
+	ControlPlaneURL string
+	NodeID          string
+	CurrentVersion  string
+	PublicKey       string        // Ed25519 public key releases are signed with, base64-encoded
+	BinaryPath      string        // Agent binary to replace; defaults to os.Executable
+	Token           func() string // Bearer token for the control plane
+	HTTPClient      *http.Client
+}
+
+// Updater checks for and installs agent releases.
+type Updater struct {
+	cfg Config
+	key ed25519.PublicKey
+}
+
+// New returns an Updater, or an error if the public key is invalid or the
+// agent binary cannot be located.
+func New(cfg Config) (*Updater, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(cfg.PublicKey))
+	if err != nil {
+		return nil, fmt.Errorf("decode self-update public key: %w", err)
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("self-update public key must be %d bytes, got %d", ed25519.PublicKeySize, len(raw))
+	}
+	if cfg.BinaryPath == "" {
+		if cfg.BinaryPath, err = os.Executable(); err != nil {
+			return nil, fmt.Errorf("locate agent binary: %w", err)
+		}
+	}
+	if resolved, err := filepath.EvalSymlinks(cfg.BinaryPath); err == nil {
+		cfg.BinaryPath = resolved
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+	if cfg.Token == nil {
+		cfg.Token = func() string { return "" }
+	}
+	return &Updater{cfg: cfg, key: ed25519.PublicKey(raw)}, nil
+}
+
+// BinaryPath returns the agent binary the Updater replaces.
+func (u *Updater) BinaryPath() string {
+	return u.cfg.BinaryPath
+}
+
+// Check asks the control plane for this node's target release. It returns
+// nil when the node already runs it, and an error when the release is not
+// newer than the running version: the agent never downgrades itself.
+func (u *Updater) Check(ctx context.Context) (*Release, error) {
+	query := url.Values{
+		"version": {u.cfg.CurrentVersion},
+		"os":      {runtime.GOOS},
+		"arch":    {runtime.GOARCH},
+	}
+	endpoint := strings.TrimRight(u.cfg.ControlPlaneURL, "/") + "/api/nodes/" + url.PathEscape(u.cfg.NodeID) + "/agent-release?" + query.Encode()
+	resp, err := u.get(ctx, endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("check agent release: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotFound:
+		return nil, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("check agent release: HTTP %d", resp.StatusCode)
+	}
+	var release Release
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&release); err != nil {
+		return nil, fmt.Errorf("decode agent release: %w", err)
+	}
+	if release.Version == "" || release.Version == u.cfg.CurrentVersion {
+		return nil, nil
+	}
+	if !newerVersion(release.Version, u.cfg.CurrentVersion) {
+		return nil, fmt.Errorf("agent release %s is not newer than %s", release.Version, u.cfg.CurrentVersion)
+	}
+	return &release, nil
+}
+
+// version is a parsed vMAJOR.MINOR.PATCH[-PRERELEASE] version; build
+// metadata after + is ignored.
+type version struct {
+	core       [3]int
+	prerelease string
+}
+
+func parseVersion(s string) (version, bool) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	s, _, _ = strings.Cut(s, "+")
+	s, prerelease, _ := strings.Cut(s, "-")
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return version{}, false
+	}
+	v := version{prerelease: prerelease}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return version{}, false
+		}
+		v.core[i] = n
+	}
+	return v, true
+}
+
+// newerVersion reports whether candidate is a later release than current.
+// A current version that does not parse, such as "dev", is a local build:
+// any parseable release replaces it.
+func newerVersion(candidate, current string) bool {
+	next, ok := parseVersion(candidate)
+	if !ok {
+		return false
+	}
+	running, ok := parseVersion(current)
+	if !ok {
+		return true
+	}
+	for i := range next.core {
+		if next.core[i] != running.core[i] {
+			return next.core[i] > running.core[i]
+		}
+	}
+	// A release sorts after its prereleases.
+	switch {
+	case next.prerelease == running.prerelease:
+		return false
+	case next.prerelease == "":
+		return true
+	case running.prerelease == "":
+		return false
+	}
+	return next.prerelease > running.prerelease
+}
+
+// Verify checks the release signature against the Updater's public key.
+func (u *Updater) Verify(release *Release) error {
+	sig, err := base64.StdEncoding.DecodeString(release.Signature)
+	if err != nil {
+		return fmt.Errorf("decode release signature: %w", err)
+	}
+	if !ed25519.Verify(u.key, release.SignedMessage(), sig) {
+		return fmt.Errorf("release %s signature verification failed", release.Version)
+	}
+	return nil
+}
+
+// Install downloads release, verifies it, and atomically replaces the agent
+// binary with it. The replaced binary is kept beside it with a .prev suffix
+// for manual rollback. The running process is unaffected until Exec.
+func (u *Updater) Install(ctx context.Context, release *Release) error {
+	if err := u.Verify(release); err != nil {
+		return err
+	}
+	wantDigest, err := hex.DecodeString(release.SHA256)
+	if err != nil || len(wantDigest) != sha256.Size {
+		return fmt.Errorf("release %s has an invalid sha256 %q", release.Version, release.SHA256)
+	}
+
+	downloadURL := release.URL
+	if !strings.Contains(downloadURL, "://") {
+		downloadURL = strings.TrimRight(u.cfg.ControlPlaneURL, "/") + "/" + strings.TrimLeft(downloadURL, "/")
+	}
+	resp, err := u.get(ctx, downloadURL)
+	if err != nil {
+		return fmt.Errorf("download release %s: %w", release.Version, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download release %s: HTTP %d", release.Version, resp.StatusCode)
+	}
+
+	// Stage beside the binary so the final rename stays on one filesystem.
+	dir := filepath.Dir(u.cfg.BinaryPath)
+	staged, err := os.CreateTemp(dir, ".vm-agent-update-*")
+	if err != nil {
+		return fmt.Errorf("stage release %s: %w", release.Version, err)
+	}
+	defer os.Remove(staged.Name())
+
+	hash := sha256.New()
+	n, copyErr := io.Copy(io.MultiWriter(staged, hash), io.LimitReader(resp.Body, maxBinaryBytes+1))
+	closeErr := staged.Close()
+	switch {
+	case copyErr != nil:
+		return fmt.Errorf("download release %s: %w", release.Version, copyErr)
+	case closeErr != nil:
+		return fmt.Errorf("stage release %s: %w", release.Version, closeErr)
+	case n > maxBinaryBytes:
+		return fmt.Errorf("release %s exceeds %d bytes", release.Version, maxBinaryBytes)
+	}
+	if got := hash.Sum(nil); hex.EncodeToString(got) != hex.EncodeToString(wantDigest) {
+		return fmt.Errorf("release %s digest mismatch: got %x, want %s", release.Version, got, release.SHA256)
+	}
+	if err := os.Chmod(staged.Name(), 0o755); err != nil {
+		return fmt.Errorf("stage release %s: %w", release.Version, err)
+	}
+
+	previous := u.cfg.BinaryPath + ".prev"
+	_ = os.Remove(previous)
+	if err := os.Link(u.cfg.BinaryPath, previous); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("keep previous binary: %w", err)
+	}
+	if err := os.Rename(staged.Name(), u.cfg.BinaryPath); err != nil {
+		return fmt.Errorf("install release %s: %w", release.Version, err)
+	}
+	return nil
+}
+
+// get requests endpoint, sending the node token only when endpoint is on
+// the control plane, so a release hosted elsewhere never receives it.
+func (u *Updater) get(ctx context.Context, endpoint string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := u.cfg.Token(); token != "" && u.onControlPlane(req.URL) {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return u.cfg.HTTPClient.Do(req)
+}
+
+func (u *Updater) onControlPlane(target *url.URL) bool {
+	controlPlane, err := url.Parse(strings.TrimSpace(u.cfg.ControlPlaneURL))
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(target.Scheme, controlPlane.Scheme) && strings.EqualFold(target.Host, controlPlane.Host)
+}
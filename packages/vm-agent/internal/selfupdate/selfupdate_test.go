@@ -0,0 +1,237 @@
+package selfupdate
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+type testRelease struct {
+	priv    ed25519.PrivateKey
+	pub     string
+	binary  []byte
+	release Release
+}
+
+func newTestRelease(t *testing.T, version string) *testRelease {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary := []byte("#!/bin/sh\necho " + version + "\n")
+	sum := sha256.Sum256(binary)
+	rel := Release{Version: version, URL: "/releases/vm-agent", SHA256: hex.EncodeToString(sum[:])}
+	rel.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(priv, rel.SignedMessage()))
+	return &testRelease{priv: priv, pub: base64.StdEncoding.EncodeToString(pub), binary: binary, release: rel}
+}
+
+func (tr *testRelease) server(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer node-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/nodes/node-1/agent-release":
+			if r.URL.Query().Get("version") == tr.release.Version {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(tr.release)
+		case "/releases/vm-agent":
+			_, _ = w.Write(tr.binary)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newTestUpdater(t *testing.T, tr *testRelease, controlPlaneURL, currentVersion string) *Updater {
+	t.Helper()
+	binaryPath := filepath.Join(t.TempDir(), "vm-agent")
+	if err := os.WriteFile(binaryPath, []byte("old"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	u, err := New(Config{
+		ControlPlaneURL: controlPlaneURL,
+		NodeID:          "node-1",
+		CurrentVersion:  currentVersion,
+		PublicKey:       tr.pub,
+		BinaryPath:      binaryPath,
+		Token:           func() string { return "node-token" },
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return u
+}
+
+func TestCheckAndInstall(t *testing.T) {
+	t.Parallel()
+	tr := newTestRelease(t, "v1.2.0")
+	srv := tr.server(t)
+
+	current := newTestUpdater(t, tr, srv.URL, "v1.2.0")
+	if rel, err := current.Check(context.Background()); err != nil || rel != nil {
+		t.Fatalf("Check on current version = %+v, %v; want nil, nil", rel, err)
+	}
+
+	u := newTestUpdater(t, tr, srv.URL, "v1.1.0")
+	rel, err := u.Check(context.Background())
+	if err != nil || rel == nil || rel.Version != "v1.2.0" {
+		t.Fatalf("Check = %+v, %v; want v1.2.0", rel, err)
+	}
+	if err := u.Install(context.Background(), rel); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+
+	got, err := os.ReadFile(u.BinaryPath())
+	if err != nil || string(got) != string(tr.binary) {
+		t.Fatalf("installed binary = %q, %v", got, err)
+	}
+	if info, _ := os.Stat(u.BinaryPath()); info.Mode().Perm()&0o100 == 0 {
+		t.Fatalf("installed binary mode = %v, want executable", info.Mode())
+	}
+	if prev, err := os.ReadFile(u.BinaryPath() + ".prev"); err != nil || string(prev) != "old" {
+		t.Fatalf("previous binary = %q, %v", prev, err)
+	}
+}
+
+func TestCheckRejectsDowngrade(t *testing.T) {
+	t.Parallel()
+	tr := newTestRelease(t, "v1.2.0")
+	srv := tr.server(t)
+
+	for _, current := range []string{"v1.3.0", "v1.2.1", "1.10.0"} {
+		u := newTestUpdater(t, tr, srv.URL, current)
+		if rel, err := u.Check(context.Background()); err == nil || rel != nil {
+			t.Errorf("Check from %s = %+v, %v; want a downgrade error", current, rel, err)
+		}
+	}
+	if rel, err := newTestUpdater(t, tr, srv.URL, "dev").Check(context.Background()); err != nil || rel == nil {
+		t.Fatalf("Check from a dev build = %+v, %v; want v1.2.0", rel, err)
+	}
+}
+
+func TestNewerVersion(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		candidate, current string
+		want               bool
+	}{
+		{"v1.2.0", "v1.1.9", true},
+		{"v1.10.0", "v1.9.0", true},
+		{"v1.2.0", "v1.2.0-rc.1", true},
+		{"v1.2.0-rc.2", "v1.2.0-rc.1", true},
+		{"v1.2.0-rc.1", "v1.2.0", false},
+		{"v1.2.0", "v1.2.0+build.5", false},
+		{"v1.1.0", "v1.2.0", false},
+		{"latest", "v1.2.0", false},
+		{"v1.2.0", "dev", true},
+	} {
+		if got := newerVersion(tc.candidate, tc.current); got != tc.want {
+			t.Errorf("newerVersion(%q, %q) = %v, want %v", tc.candidate, tc.current, got, tc.want)
+		}
+	}
+}
+
+func TestInstallSendsTokenOnlyToControlPlane(t *testing.T) {
+	t.Parallel()
+	tr := newTestRelease(t, "v1.2.0")
+	srv := tr.server(t)
+
+	var gotAuth string
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		_, _ = w.Write(tr.binary)
+	}))
+	t.Cleanup(mirror.Close)
+
+	rel := tr.release
+	rel.URL = mirror.URL + "/vm-agent"
+	u := newTestUpdater(t, tr, srv.URL, "v1.1.0")
+	if err := u.Install(context.Background(), &rel); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+	if gotAuth != "" {
+		t.Fatalf("release host received Authorization %q", gotAuth)
+	}
+}
+
+func TestInstallRejectsUnverifiedRelease(t *testing.T) {
+	t.Parallel()
+	tr := newTestRelease(t, "v1.2.0")
+	srv := tr.server(t)
+
+	forged := tr.release
+	forged.Version = "v9.9.9" // signature covers the version
+	tampered := tr.release
+	tampered.SHA256 = hex.EncodeToString(make([]byte, sha256.Size))
+	tampered.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(tr.priv, tampered.SignedMessage()))
+
+	for name, rel := range map[string]Release{"bad signature": forged, "digest mismatch": tampered} {
+		u := newTestUpdater(t, tr, srv.URL, "v1.1.0")
+		if err := u.Install(context.Background(), &rel); err == nil {
+			t.Errorf("%s: Install succeeded", name)
+		}
+		if got, _ := os.ReadFile(u.BinaryPath()); string(got) != "old" {
+			t.Errorf("%s: binary replaced with %q", name, got)
+		}
+	}
+}
+
+func TestNewRejectsInvalidKey(t *testing.T) {
+	t.Parallel()
+	for _, key := range []string{"", "not base64!", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := New(Config{PublicKey: key, BinaryPath: "/usr/local/bin/vm-agent"}); err == nil {
+			t.Errorf("New(PublicKey: %q) succeeded", key)
+		}
+	}
+}
+
+func TestListenAdoptsInheritedListener(t *testing.T) {
+	orig, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer orig.Close()
+	f, err := orig.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// Listen takes ownership of the descriptor, as the new process would.
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(ListenFDEnv, strconv.Itoa(fd))
+
+	ln, err := Listen(orig.Addr().String())
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+	if ln.Addr().String() != orig.Addr().String() {
+		t.Fatalf("Listen addr = %s, want inherited %s", ln.Addr(), orig.Addr())
+	}
+	if _, ok := os.LookupEnv(ListenFDEnv); ok {
+		t.Fatalf("%s still set after Listen", ListenFDEnv)
+	}
+}
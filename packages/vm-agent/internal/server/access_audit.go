@@ -0,0 +1,246 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/accessaudit"
+)
+
+const maxAccessAuditUserAgentLength = 256
+
+// clientIP returns the originating client address for r, preferring the
+// headers set by the edge proxy in front of the agent (Cloudflare, then the
+// first X-Forwarded-For hop, then X-Real-IP) and falling back to the peer.
+func clientIP(r *http.Request) string {
+	if ip := strings.TrimSpace(r.Header.Get("CF-Connecting-IP")); ip != "" {
+		return ip
+	}
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		first, _, _ := strings.Cut(forwarded, ",")
+		if ip := strings.TrimSpace(first); ip != "" {
+			return ip
+		}
+	}
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+		return ip
+	}
+	return remoteHost(r)
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// auditViewerAttach records a viewer attaching to an agent session and
+// returns the access record ID, or "" when auditing is unavailable.
+func (s *Server) auditViewerAttach(r *http.Request, workspaceID, sessionID, viewerID, subject string) string {
+	if s.accessAudit == nil {
+		return ""
+	}
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxAccessAuditUserAgentLength {
+		userAgent = userAgent[:maxAccessAuditUserAgentLength]
+	}
+	entry, err := s.accessAudit.Attach(accessaudit.Entry{
+		WorkspaceID: workspaceID,
+		SessionID:   sessionID,
+		ViewerID:    viewerID,
+		Subject:     subject,
+		IP:          clientIP(r),
+		RemoteAddr:  remoteHost(r),
+		UserAgent:   userAgent,
+	})
+	if err != nil {
+		slog.Warn("Failed to record viewer attach in access audit", "workspace", workspaceID, "sessionId", sessionID, "error", err)
+		return ""
+	}
+	return entry.ID
+}
+
+// auditViewerDrive marks the viewer's access record as driving the agent.
+func (s *Server) auditViewerDrive(accessID string) {
+	if s.accessAudit == nil || accessID == "" {
+		return
+	}
+	if err := s.accessAudit.RecordDrive(accessID); err != nil {
+		slog.Warn("Failed to record viewer prompt in access audit", "accessId", accessID, "error", err)
+	}
+}
+
+// auditViewerDetach closes the viewer's access record.
+func (s *Server) auditViewerDetach(accessID string) {
+	if s.accessAudit == nil || accessID == "" {
+		return
+	}
+	if _, err := s.accessAudit.Detach(accessID, time.Now()); err != nil {
+		slog.Warn("Failed to record viewer detach in access audit", "accessId", accessID, "error", err)
+	}
+}
+
+// handleListAccessAudit returns viewer access records for a workspace, newest
+// first. Optional filters: sessionId, subject, since (RFC 3339), limit.
+func (s *Server) handleListAccessAudit(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.PathValue("workspaceId")
+	if workspaceID == "" {
+		writeError(w, http.StatusBadRequest, workspaceIDRequiredMessage)
+		return
+	}
+	// Same auth as workspace events: browser session / workspace token, or
+	// management auth for control-plane proxies. Agent MCP tokens are not
+	// accepted because records contain viewer IP addresses.
+	if !s.checkWorkspaceRequestAuth(r, workspaceID) {
+		if !s.requireNodeManagementAuth(w, r, workspaceID) {
+			return
+		}
+	}
+	if s.accessAudit == nil {
+		writeError(w, http.StatusServiceUnavailable, "access audit unavailable")
+		return
+	}
+
+	query := r.URL.Query()
+	q := accessaudit.Query{
+		WorkspaceID: workspaceID,
+		SessionID:   strings.TrimSpace(query.Get("sessionId")),
+		Subject:     strings.TrimSpace(query.Get("subject")),
+		Limit:       parseEventLimit(query.Get("limit")),
+	}
+	if raw := strings.TrimSpace(query.Get("since")); raw != "" {
+		since, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "since must be an RFC 3339 timestamp")
+			return
+		}
+		q.Since = since
+	}
+
+	entries, err := s.accessAudit.List(q)
+	if err != nil {
+		slog.Error("Failed to query access audit", "workspace", workspaceID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to query access audit")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"entries": entries})
+}
+
+// startAccessAuditShipper periodically ships closed access records to the
+// control plane so that access history outlives the node.
+func (s *Server) startAccessAuditShipper() {
+	interval := s.config.AccessAuditShipInterval
+	if s.accessAudit == nil || s.config.ControlPlaneURL == "" || interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				s.shipAccessAudit()
+			}
+		}
+	}()
+}
+
+// shipAccessAudit sends one batch of unshipped records, grouped by workspace.
+// Records are marked shipped after a 2xx response. Transient failures are
+// retried on the next tick; records the control plane rejects outright (a 4xx
+// other than 401/403/408/429, e.g. for a deleted workspace) are dropped so
+// they cannot block later records. They stay queryable locally until
+// retention expires.
+func (s *Server) shipAccessAudit() {
+	entries, err := s.accessAudit.Unshipped(s.config.AccessAuditShipBatchSize)
+	if err != nil {
+		slog.Warn("access_audit: reading unshipped records failed", "error", err)
+		return
+	}
+
+	byWorkspace := make(map[string][]accessaudit.Entry)
+	var order []string
+	for _, e := range entries {
+		if _, ok := byWorkspace[e.WorkspaceID]; !ok {
+			order = append(order, e.WorkspaceID)
+		}
+		byWorkspace[e.WorkspaceID] = append(byWorkspace[e.WorkspaceID], e)
+	}
+
+	for _, workspaceID := range order {
+		batch := byWorkspace[workspaceID]
+		token := s.callbackTokenForWorkspace(workspaceID)
+		if token == "" {
+			slog.Debug("access_audit: skipping workspace without callback token", "workspace", workspaceID)
+			continue
+		}
+		if permanent, err := s.sendAccessAuditBatch(workspaceID, token, batch); err != nil {
+			slog.Warn("access_audit: shipping records failed", "workspace", workspaceID, "count", len(batch), "dropped", permanent, "error", err)
+			if !permanent {
+				continue
+			}
+		}
+		ids := make([]string, len(batch))
+		for i, e := range batch {
+			ids[i] = e.ID
+		}
+		if err := s.accessAudit.MarkShipped(ids); err != nil {
+			slog.Warn("access_audit: marking records shipped failed", "workspace", workspaceID, "error", err)
+		}
+	}
+}
+
+// sendAccessAuditBatch POSTs records to the control plane. permanent reports
+// whether a failure should not be retried.
+func (s *Server) sendAccessAuditBatch(workspaceID, token string, batch []accessaudit.Entry) (permanent bool, err error) {
+	return s.postWorkspaceRecords(workspaceID, token, "access-audit", map[string]interface{}{"entries": batch})
+}
+
+// postWorkspaceRecords POSTs a batch of locally recorded records to the
+// control plane at /api/workspaces/{workspaceID}/{path}. permanent reports
+// whether a failure should not be retried.
+func (s *Server) postWorkspaceRecords(workspaceID, token, path string, payload interface{}) (permanent bool, err error) {
+	endpoint := strings.TrimRight(s.config.ControlPlaneURL, "/") +
+		"/api/workspaces/" + url.PathEscape(workspaceID) + "/" + path
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.controlPlaneHTTPClient(0).Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		switch resp.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests:
+			// Token refreshes and throttling are retried.
+		default:
+			permanent = resp.StatusCode >= 400 && resp.StatusCode < 500
+		}
+		return permanent, fmt.Errorf("control plane returned HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return false, nil
+}
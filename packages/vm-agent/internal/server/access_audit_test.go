@@ -0,0 +1,175 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/workspace/vm-agent/internal/accessaudit"
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+func openTestAccessAudit(t *testing.T) *accessaudit.Store {
+	t.Helper()
+	store, err := accessaudit.New(filepath.Join(t.TempDir(), "access-audit.db"), 0)
+	if err != nil {
+		t.Fatalf("accessaudit.New: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func TestClientIPPrefersForwardedHeaders(t *testing.T) {
+	cases := []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{"cloudflare", map[string]string{"CF-Connecting-IP": "198.51.100.1", "X-Forwarded-For": "203.0.113.9"}, "198.51.100.1"},
+		{"first forwarded hop", map[string]string{"X-Forwarded-For": "203.0.113.9, 10.0.0.1"}, "203.0.113.9"},
+		{"real ip", map[string]string{"X-Real-IP": "192.0.2.4"}, "192.0.2.4"},
+		{"peer", nil, "192.0.2.77"},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "192.0.2.77:51234"
+		for k, v := range tc.headers {
+			req.Header.Set(k, v)
+		}
+		if got := clientIP(req); got != tc.want {
+			t.Errorf("%s: clientIP = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestAgentWSAttachIsAudited(t *testing.T) {
+	s, ts, cookieSessionID := newAgentWSTestServer(t)
+	s.accessAudit = openTestAccessAudit(t)
+
+	const workspaceID = "WS_TEST"
+	if _, _, err := s.agentSessions.Create(workspaceID, "sess-audit", "Chat", ""); err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+
+	header := http.Header{}
+	header.Set("Cookie", "session="+cookieSessionID)
+	header.Set("X-SAM-Workspace-Id", workspaceID)
+	header.Set("X-Forwarded-For", "203.0.113.50")
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "?sessionId=sess-audit"
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatalf("dial ws: %v", err)
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","method":"session/cancel"}`)); err != nil {
+		t.Fatalf("write cancel: %v", err)
+	}
+
+	var entries []accessaudit.Entry
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		entries, _ = s.accessAudit.List(accessaudit.Query{WorkspaceID: workspaceID})
+		if len(entries) == 1 && entries[0].Role == accessaudit.RoleDriver {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if len(entries) != 1 || entries[0].Role != accessaudit.RoleDriver {
+		t.Fatalf("expected one driver record while attached, got %+v", entries)
+	}
+	if e := entries[0]; e.Subject != "test-user" || e.IP != "203.0.113.50" || e.SessionID != "sess-audit" || e.EndReason != "" {
+		t.Fatalf("unexpected open record: %+v", e)
+	}
+
+	conn.Close()
+	deadline = time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		entries, _ = s.accessAudit.List(accessaudit.Query{WorkspaceID: workspaceID})
+		if len(entries) == 1 && entries[0].EndReason == accessaudit.EndReasonDisconnected {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if len(entries) != 1 || entries[0].DetachedAt == "" {
+		t.Fatalf("record was not closed on detach: %+v", entries)
+	}
+
+	mux := http.NewServeMux()
+	s.setupRoutes(mux)
+	req := httptest.NewRequest(http.MethodGet, "/workspaces/WS_TEST/access-audit?sessionId=sess-audit", nil)
+	req.Header.Set("Cookie", "session="+cookieSessionID)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	var body struct {
+		Entries []accessaudit.Entry `json:"entries"`
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &body)
+	if rec.Code != http.StatusOK || len(body.Entries) != 1 || body.Entries[0].Prompts != 1 {
+		t.Fatalf("query = %d %s", rec.Code, rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/workspaces/WS_TEST/access-audit?since=yesterday", nil)
+	req.Header.Set("Cookie", "session="+cookieSessionID)
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid since status = %d, want 400", rec.Code)
+	}
+}
+
+func TestShipAccessAuditMarksDeliveredAndDropsRejected(t *testing.T) {
+	var received atomic.Int32
+	controlPlane := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer node-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/workspaces/ws-live/access-audit":
+			var body struct {
+				Entries []accessaudit.Entry `json:"entries"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			received.Add(int32(len(body.Entries)))
+			w.WriteHeader(http.StatusOK)
+		case "/api/workspaces/ws-flaky/access-audit":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer controlPlane.Close()
+
+	store := openTestAccessAudit(t)
+	s := &Server{
+		config:      &config.Config{ControlPlaneURL: controlPlane.URL, CallbackToken: "node-token", AccessAuditShipBatchSize: 100},
+		accessAudit: store,
+	}
+	for _, ws := range []string{"ws-live", "ws-live", "ws-flaky", "ws-deleted"} {
+		e, err := store.Attach(accessaudit.Entry{WorkspaceID: ws, SessionID: "sess", ViewerID: "viewer"})
+		if err != nil {
+			t.Fatalf("Attach: %v", err)
+		}
+		if _, err := store.Detach(e.ID, time.Now()); err != nil {
+			t.Fatalf("Detach: %v", err)
+		}
+	}
+
+	s.shipAccessAudit()
+
+	if got := received.Load(); got != 2 {
+		t.Fatalf("control plane received %d records, want 2", got)
+	}
+	pending, err := store.Unshipped(10)
+	if err != nil {
+		t.Fatalf("Unshipped: %v", err)
+	}
+	if len(pending) != 1 || pending[0].WorkspaceID != "ws-flaky" {
+		t.Fatalf("pending after ship = %+v, want only the transient failure", pending)
+	}
+}
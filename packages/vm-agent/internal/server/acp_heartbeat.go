@@ -69,23 +69,20 @@ func (s *Server) sendAcpHeartbeats() {
 
 // activeProjectIDs returns deduplicated project IDs from running workspace runtimes.
 func (s *Server) activeProjectIDs() []string {
-	s.workspaceMu.RLock()
-	defer s.workspaceMu.RUnlock()
-
 	seen := make(map[string]struct{})
 	var result []string
-	for _, ws := range s.workspaces {
+	s.workspaces.Range(func(_ string, ws *WorkspaceRuntime) {
 		if ws.ProjectID == "" {
-			continue
+			return
 		}
 		if ws.Status != "running" && ws.Status != "recovery" {
-			continue
+			return
 		}
 		if _, ok := seen[ws.ProjectID]; !ok {
 			seen[ws.ProjectID] = struct{}{}
 			result = append(result, ws.ProjectID)
 		}
-	}
+	})
 	return result
 }
 
@@ -19,16 +19,16 @@ import (
 func TestActiveProjectIDs_ReturnsUniqueRunningProjects(t *testing.T) {
 	s := &Server{
 		config: &config.Config{NodeID: "node-1"},
-		workspaces: map[string]*WorkspaceRuntime{
-			"ws-1": {ID: "ws-1", ProjectID: "proj-a", Status: "running"},
-			"ws-2": {ID: "ws-2", ProjectID: "proj-a", Status: "running"},  // duplicate project
-			"ws-3": {ID: "ws-3", ProjectID: "proj-b", Status: "running"},
-			"ws-4": {ID: "ws-4", ProjectID: "proj-c", Status: "stopped"},  // not running
-			"ws-5": {ID: "ws-5", ProjectID: "", Status: "running"},         // no project
-			"ws-6": {ID: "ws-6", ProjectID: "proj-d", Status: "recovery"}, // recovery counts
-		},
-		done: make(chan struct{}),
+		done:   make(chan struct{}),
 	}
+	putWorkspaces(&s.workspaces, map[string]*WorkspaceRuntime{
+		"ws-1": {ID: "ws-1", ProjectID: "proj-a", Status: "running"},
+		"ws-2": {ID: "ws-2", ProjectID: "proj-a", Status: "running"}, // duplicate project
+		"ws-3": {ID: "ws-3", ProjectID: "proj-b", Status: "running"},
+		"ws-4": {ID: "ws-4", ProjectID: "proj-c", Status: "stopped"},  // not running
+		"ws-5": {ID: "ws-5", ProjectID: "", Status: "running"},        // no project
+		"ws-6": {ID: "ws-6", ProjectID: "proj-d", Status: "recovery"}, // recovery counts
+	})
 
 	ids := s.activeProjectIDs()
 	if len(ids) != 3 {
@@ -48,9 +48,8 @@ func TestActiveProjectIDs_ReturnsUniqueRunningProjects(t *testing.T) {
 
 func TestActiveProjectIDs_EmptyWhenNoWorkspaces(t *testing.T) {
 	s := &Server{
-		config:     &config.Config{NodeID: "node-1"},
-		workspaces: map[string]*WorkspaceRuntime{},
-		done:       make(chan struct{}),
+		config: &config.Config{NodeID: "node-1"},
+		done:   make(chan struct{}),
 	}
 
 	ids := s.activeProjectIDs()
@@ -97,15 +96,16 @@ func TestSendAcpHeartbeats_PostsToCorrectEndpoint(t *testing.T) {
 			ACPHeartbeatInterval: 100 * time.Millisecond,
 			HTTPCallbackTimeout:  5 * time.Second,
 		},
-		workspaces: map[string]*WorkspaceRuntime{
-			"ws-1": {ID: "ws-1", ProjectID: "proj-a", Status: "running"},
-			"ws-2": {ID: "ws-2", ProjectID: "proj-b", Status: "running"},
-		},
 		callbackToken: "test-token",
 		httpClient:    &http.Client{Timeout: 5 * time.Second},
 		done:          make(chan struct{}),
 	}
 
+	putWorkspaces(&s.workspaces, map[string]*WorkspaceRuntime{
+		"ws-1": {ID: "ws-1", ProjectID: "proj-a", Status: "running"},
+		"ws-2": {ID: "ws-2", ProjectID: "proj-b", Status: "running"},
+	})
+
 	s.sendAcpHeartbeats()
 
 	mu.Lock()
@@ -156,9 +156,6 @@ func TestStartAcpHeartbeatReporter_StopsOnDoneChannel(t *testing.T) {
 			ACPHeartbeatInterval: 50 * time.Millisecond,
 			HTTPCallbackTimeout:  5 * time.Second,
 		},
-		workspaces: map[string]*WorkspaceRuntime{
-			"ws-1": {ID: "ws-1", ProjectID: "proj-a", Status: "running"},
-		},
 		ptyManager:       ptyManager,
 		agentSessions:    agentsessions.NewManager(),
 		callbackToken:    "test-token",
@@ -167,6 +164,10 @@ func TestStartAcpHeartbeatReporter_StopsOnDoneChannel(t *testing.T) {
 		done:             make(chan struct{}),
 	}
 
+	putWorkspaces(&s.workspaces, map[string]*WorkspaceRuntime{
+		"ws-1": {ID: "ws-1", ProjectID: "proj-a", Status: "running"},
+	})
+
 	s.startAcpHeartbeatReporter()
 
 	// Wait for a few ticks
@@ -238,14 +239,15 @@ func TestSendAcpHeartbeats_SkipsWhenNoToken(t *testing.T) {
 			NodeID:          "node-test",
 			ControlPlaneURL: ts.URL,
 		},
-		workspaces: map[string]*WorkspaceRuntime{
-			"ws-1": {ID: "ws-1", ProjectID: "proj-a", Status: "running"},
-		},
 		callbackToken: "", // empty token
 		httpClient:    &http.Client{Timeout: 5 * time.Second},
 		done:          make(chan struct{}),
 	}
 
+	putWorkspaces(&s.workspaces, map[string]*WorkspaceRuntime{
+		"ws-1": {ID: "ws-1", ProjectID: "proj-a", Status: "running"},
+	})
+
 	s.sendAcpHeartbeats()
 
 	if requestCount != 0 {
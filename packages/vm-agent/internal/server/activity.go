@@ -0,0 +1,151 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/acp"
+	"github.com/workspace/vm-agent/internal/activity"
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+func newActivityTracker(cfg *config.Config) *activity.Tracker {
+	return activity.NewTracker(activity.Weights{
+		activity.SourceTerminal: cfg.ActivityWeightTerminal,
+		activity.SourcePrompt:   cfg.ActivityWeightPrompt,
+		activity.SourceViewer:   cfg.ActivityWeightViewer,
+		activity.SourceFiles:    cfg.ActivityWeightFiles,
+		activity.SourceCommand:  cfg.ActivityWeightCommand,
+	})
+}
+
+// workspaceActivity is one entry of the heartbeat's workspaceActivity list.
+type workspaceActivity struct {
+	WorkspaceID    string           `json:"workspaceId"`
+	LastActivityAt string           `json:"lastActivityAt"`
+	IdleSeconds    int64            `json:"idleSeconds"`
+	Sources        map[string]int64 `json:"sources"` // source → seconds since its last activity
+}
+
+// sampleWorkspaceActivity records ongoing activity that has no discrete
+// event: prompts in flight, attached viewers, and running agent commands are
+// counted as active at sampling time, and PTY activity is read from each
+// workspace's PTY manager. Called before activity is reported, so these
+// sources are as fresh as the report itself.
+func (s *Server) sampleWorkspaceActivity() {
+	if s.activityTracker == nil {
+		return
+	}
+
+	s.workspaces.Range(func(id string, workspace *WorkspaceRuntime) {
+		if workspace.PTY != nil {
+			s.activityTracker.Observe(id, activity.SourceTerminal, workspace.PTY.GetLastActivity())
+		}
+	})
+
+	s.sessionHostMu.Lock()
+	hosts := make(map[string][]*acp.SessionHost)
+	for key, host := range s.sessionHosts {
+		if host == nil {
+			continue
+		}
+		workspaceID, _, _ := strings.Cut(key, ":")
+		hosts[workspaceID] = append(hosts[workspaceID], host)
+	}
+	s.sessionHostMu.Unlock()
+
+	for workspaceID, workspaceHosts := range hosts {
+		for _, host := range workspaceHosts {
+			if host.IsPrompting() {
+				s.activityTracker.Touch(workspaceID, activity.SourcePrompt)
+			}
+			if host.ViewerCount() > 0 {
+				s.activityTracker.Touch(workspaceID, activity.SourceViewer)
+			}
+			if host.RunningTerminalCount() > 0 {
+				s.activityTracker.Touch(workspaceID, activity.SourceCommand)
+			}
+		}
+	}
+}
+
+// workspaceActivityReports samples current activity and returns a report for
+// every known workspace that has recorded any, sorted by workspace ID.
+func (s *Server) workspaceActivityReports() []workspaceActivity {
+	if s.activityTracker == nil {
+		return nil
+	}
+	s.sampleWorkspaceActivity()
+
+	ids := s.workspaces.IDs()
+
+	now := time.Now()
+	reports := make([]workspaceActivity, 0, len(ids))
+	for _, id := range ids {
+		report, ok := s.activityTracker.Report(id)
+		if !ok {
+			continue
+		}
+		entry := workspaceActivity{
+			WorkspaceID:    id,
+			LastActivityAt: report.LastActivityAt.UTC().Format(time.RFC3339),
+			IdleSeconds:    int64(report.IdleFor / time.Second),
+			Sources:        make(map[string]int64, len(report.Sources)),
+		}
+		for source, at := range report.Sources {
+			entry.Sources[string(source)] = int64(now.Sub(at) / time.Second)
+		}
+		reports = append(reports, entry)
+	}
+	return reports
+}
+
+// handleTrackedPrompt runs a server-dispatched prompt, recording prompt
+// activity when it starts and when it finishes.
+func (s *Server) handleTrackedPrompt(ctx context.Context, workspaceID string, host *acp.SessionHost, reqID, params json.RawMessage, viewerID string, trustedSource bool) {
+	s.activityTracker.Touch(workspaceID, activity.SourcePrompt)
+	defer s.activityTracker.Touch(workspaceID, activity.SourcePrompt)
+	host.HandlePrompt(ctx, reqID, params, viewerID, trustedSource)
+}
+
+// activityStatusRecorder captures the response status so only successful
+// requests count as activity.
+type activityStatusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *activityStatusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *activityStatusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func (r *activityStatusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// withWorkspaceActivity wraps a workspace-scoped handler so successful
+// requests record activity from source. Failed requests, including
+// unauthenticated ones, never keep a workspace awake.
+func (s *Server) withWorkspaceActivity(source activity.Source, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec := &activityStatusRecorder{ResponseWriter: w}
+		next(rec, r)
+		if rec.status != 0 && rec.status < http.StatusBadRequest {
+			s.activityTracker.Touch(r.PathValue("workspaceId"), source)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/workspace/vm-agent/internal/acp"
+	"github.com/workspace/vm-agent/internal/activity"
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+func newActivityTestServer(t *testing.T, cfg *config.Config) *Server {
+	t.Helper()
+	s := &Server{
+		config:          cfg,
+		sessionHosts:    make(map[string]*acp.SessionHost),
+		activityTracker: newActivityTracker(cfg),
+	}
+	putWorkspaces(&s.workspaces, map[string]*WorkspaceRuntime{"ws-1": {ID: "ws-1"}, "ws-2": {ID: "ws-2"}})
+	return s
+}
+
+func TestWithWorkspaceActivityTouchesOnlyOnSuccess(t *testing.T) {
+	t.Parallel()
+
+	s := newActivityTestServer(t, &config.Config{ActivityWeightFiles: 1})
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /workspaces/{workspaceId}/files/list", s.withWorkspaceActivity(activity.SourceFiles, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			writeError(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{})
+	}))
+
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/workspaces/ws-2/files/list", nil))
+	if reports := s.workspaceActivityReports(); len(reports) != 0 {
+		t.Fatalf("unauthorized request recorded activity: %+v", reports)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/workspaces/ws-1/files/list", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+	reports := s.workspaceActivityReports()
+	if len(reports) != 1 || reports[0].WorkspaceID != "ws-1" || reports[0].IdleSeconds != 0 {
+		t.Fatalf("reports = %+v, want fresh activity for ws-1 only", reports)
+	}
+	if _, ok := reports[0].Sources[string(activity.SourceFiles)]; !ok {
+		t.Fatalf("sources = %v, want file_api", reports[0].Sources)
+	}
+}
+
+func TestWorkspaceActivitySamplesAttachedViewers(t *testing.T) {
+	t.Parallel()
+
+	s := newActivityTestServer(t, &config.Config{ActivityWeightViewer: 1})
+	host := acp.NewSessionHost(acp.SessionHostConfig{
+		GatewayConfig: acp.GatewayConfig{SessionID: "chat-1", WorkspaceID: "ws-2"},
+	})
+	t.Cleanup(host.Stop)
+	s.sessionHosts["ws-2:chat-1"] = host
+
+	if reports := s.workspaceActivityReports(); len(reports) != 0 {
+		t.Fatalf("session without viewers recorded activity: %+v", reports)
+	}
+
+	if host.AttachViewerSink("viewer-1", acp.NewPollQueue(100)) == nil {
+		t.Fatal("AttachViewerSink returned nil")
+	}
+	reports := s.workspaceActivityReports()
+	if len(reports) != 1 || reports[0].WorkspaceID != "ws-2" {
+		t.Fatalf("reports = %+v, want viewer activity for ws-2", reports)
+	}
+	if _, ok := reports[0].Sources[string(activity.SourceViewer)]; !ok {
+		t.Fatalf("sources = %v, want viewer", reports[0].Sources)
+	}
+}
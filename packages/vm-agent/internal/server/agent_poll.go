@@ -0,0 +1,393 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/acp"
+	"github.com/workspace/vm-agent/internal/activity"
+)
+
+const (
+	// agentPollMaxBatch caps the messages returned by a single poll so replay
+	// of a large buffer is spread over several responses.
+	agentPollMaxBatch = 500
+	// agentPollMaxMessageBytes caps a POSTed viewer message. Prompts may carry
+	// inline attachments, so this is generous.
+	agentPollMaxMessageBytes = 16 << 20
+)
+
+// pollViewer is a SessionHost viewer attached over the HTTP long-poll/SSE
+// fallback transport. It is the long-poll counterpart of one handleAgentWS
+// invocation: the WebSocket read loop is replaced by POSTed messages, and
+// the connection lifetime by an idle timeout between polls.
+type pollViewer struct {
+	id          string
+	workspaceID string
+	sessionID   string
+	userID      string
+	accessID    string
+	host        *acp.SessionHost
+	queue       *acp.PollQueue
+	gateway     *acp.Gateway
+	done        <-chan struct{}
+
+	// inflight counts poll requests currently being served; the viewer never
+	// idles out while one is open.
+	inflight atomic.Int32
+	touch    chan struct{}
+	detach   chan struct{}
+	once     sync.Once
+}
+
+func (v *pollViewer) markActive() {
+	select {
+	case v.touch <- struct{}{}:
+	default:
+	}
+}
+
+func (v *pollViewer) close() {
+	v.once.Do(func() { close(v.detach) })
+}
+
+// handleAgentPollAttach attaches a long-poll viewer to an agent session. It
+// accepts the same query parameters and credentials as /agent/ws and is used
+// by clients whose WebSocket upgrade fails.
+func (s *Server) handleAgentPollAttach(w http.ResponseWriter, r *http.Request) {
+	workspaceID := s.resolveWorkspaceIDForWebsocket(r)
+	if workspaceID == "" {
+		writeSessionError(w, http.StatusBadRequest, "workspace_required", "Missing workspace route")
+		return
+	}
+
+	userID, ok := s.authenticateWorkspaceWebsocket(w, r, workspaceID)
+	if !ok {
+		return
+	}
+
+	role, ok := requestedViewerRole(w, r)
+	if !ok {
+		return
+	}
+	lastSeq, ok := requestedReplaySeq(w, r)
+	if !ok {
+		return
+	}
+
+	host, session, sessionID, ok := s.resolveAgentSessionHost(w, r, workspaceID)
+	if !ok {
+		return
+	}
+
+	// The viewer ID addresses the viewer on every later request, so it gets
+	// 128 bits rather than the 64 used for WebSocket viewers.
+	viewerID := "viewer-" + randomEventID() + randomEventID()
+	queue := acp.NewPollQueue(s.config.ACPPollQueueSize)
+	viewer := host.AttachViewerSinkAfter(viewerID, queue, lastSeq)
+	if viewer == nil {
+		writeSessionError(w, http.StatusConflict, "session_not_running", "Session was stopped")
+		return
+	}
+	host.SetViewerRole(viewerID, role)
+	s.activityTracker.Touch(workspaceID, activity.SourceViewer)
+
+	announcementSubject := userID
+	if announcementSubject == "" {
+		announcementSubject = viewerID
+	}
+	s.sendActiveAnnouncements(host, workspaceID, viewerID, announcementSubject)
+	s.sendControlPlaneStatus(host, viewerID)
+	s.sendShutdownNotice(host, viewerID)
+	accessID := s.auditViewerAttach(r, workspaceID, sessionID, viewerID, userID)
+
+	gateway := acp.NewGateway(host, nil, viewerID, viewer.Done())
+	gateway.SetAnnouncementDismissHandler(func(announcementID string) {
+		s.dismissAnnouncement(workspaceID, announcementID, announcementSubject)
+	})
+	gateway.SetDriveHandler(func() { s.auditViewerDrive(accessID) })
+
+	pv := &pollViewer{
+		id:          viewerID,
+		workspaceID: workspaceID,
+		sessionID:   sessionID,
+		userID:      userID,
+		accessID:    accessID,
+		host:        host,
+		queue:       queue,
+		gateway:     gateway,
+		done:        viewer.Done(),
+		touch:       make(chan struct{}, 1),
+		detach:      make(chan struct{}),
+	}
+	s.pollViewersMu.Lock()
+	if s.pollViewers == nil {
+		s.pollViewers = make(map[string]*pollViewer)
+	}
+	s.pollViewers[viewerID] = pv
+	s.pollViewersMu.Unlock()
+
+	go s.runPollViewer(pv)
+
+	s.appendNodeEvent(workspaceID, "info", "agent.poll_connected", "Agent long-poll viewer attached", map[string]interface{}{
+		"sessionId":          sessionID,
+		"viewerId":           viewerID,
+		"viewerRole":         role,
+		"lastSeq":            lastSeq,
+		"viewerCount":        host.ViewerCount(),
+		"hasPreviousSession": session.AcpSessionID != "",
+	})
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"viewerId":      viewerID,
+		"sessionId":     sessionID,
+		"role":          role,
+		"cursor":        0,
+		"pollWaitMs":    s.config.ACPPollWait.Milliseconds(),
+		"idleTimeoutMs": s.currentSettings().ACPPollIdleTimeout.Milliseconds(),
+	})
+}
+
+// runPollViewer owns a long-poll viewer's lifetime. It detaches the viewer
+// from the SessionHost when the client detaches, the viewer stops polling
+// for ACPPollIdleTimeout, or the SessionHost closes the viewer.
+func (s *Server) runPollViewer(pv *pollViewer) {
+	idleTimeout := s.currentSettings().ACPPollIdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = time.Minute
+	}
+	idle := time.NewTimer(idleTimeout)
+	defer idle.Stop()
+
+	reason := ""
+	for reason == "" {
+		select {
+		case <-pv.detach:
+			reason = "detached"
+		case <-pv.done:
+			reason = "closed"
+		case <-pv.touch:
+			idle.Reset(idleTimeout)
+		case <-idle.C:
+			if pv.inflight.Load() > 0 {
+				idle.Reset(idleTimeout)
+				continue
+			}
+			reason = "idle"
+		}
+	}
+
+	s.pollViewersMu.Lock()
+	delete(s.pollViewers, pv.id)
+	s.pollViewersMu.Unlock()
+
+	pv.host.DetachViewer(pv.id)
+	pv.queue.CloseWithReason(acp.PollClosedDetached)
+	s.auditViewerDetach(pv.accessID)
+
+	s.appendNodeEvent(pv.workspaceID, "info", "agent.poll_disconnected", "Agent long-poll viewer detached", map[string]interface{}{
+		"sessionId":   pv.sessionID,
+		"viewerId":    pv.id,
+		"reason":      reason,
+		"viewerCount": pv.host.ViewerCount(),
+	})
+}
+
+// lookupPollViewer resolves and authorizes the viewer named in the path. The
+// caller must present the same workspace credentials as on attach; viewers
+// of another user are reported as not found.
+func (s *Server) lookupPollViewer(w http.ResponseWriter, r *http.Request) (*pollViewer, bool) {
+	viewerID := r.PathValue("viewerId")
+	s.pollViewersMu.Lock()
+	pv, ok := s.pollViewers[viewerID]
+	s.pollViewersMu.Unlock()
+	if !ok {
+		writeSessionError(w, http.StatusNotFound, "viewer_not_found", "Viewer is not attached; re-attach to continue")
+		return nil, false
+	}
+
+	userID, ok := s.authenticateWorkspaceWebsocket(w, r, pv.workspaceID)
+	if !ok {
+		return nil, false
+	}
+	if pv.userID != "" && userID != pv.userID {
+		writeSessionError(w, http.StatusNotFound, "viewer_not_found", "Viewer is not attached; re-attach to continue")
+		return nil, false
+	}
+	return pv, true
+}
+
+// handleAgentPoll returns messages after the "after" cursor, waiting up to
+// waitMs (capped at ACPPollWait) for new ones. Clients sending
+// Accept: text/event-stream receive an SSE stream instead.
+func (s *Server) handleAgentPoll(w http.ResponseWriter, r *http.Request) {
+	pv, ok := s.lookupPollViewer(w, r)
+	if !ok {
+		return
+	}
+
+	after, err := parsePollCursor(r)
+	if err != nil {
+		writeSessionError(w, http.StatusBadRequest, "invalid_cursor", err.Error())
+		return
+	}
+
+	pv.inflight.Add(1)
+	defer func() {
+		pv.inflight.Add(-1)
+		pv.markActive()
+	}()
+	pv.markActive()
+
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		s.streamAgentPoll(w, r, pv, after)
+		return
+	}
+
+	wait := s.config.ACPPollWait
+	if raw := strings.TrimSpace(r.URL.Query().Get("waitMs")); raw != "" {
+		ms, err := strconv.Atoi(raw)
+		if err != nil || ms < 0 {
+			writeSessionError(w, http.StatusBadRequest, "invalid_wait", "waitMs must be a non-negative integer")
+			return
+		}
+		if requested := time.Duration(ms) * time.Millisecond; requested < wait {
+			wait = requested
+		}
+	}
+
+	batch := pv.queue.Poll(r.Context(), after, wait, agentPollMaxBatch)
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, batch)
+}
+
+// streamAgentPoll serves a poll viewer as Server-Sent Events. Each message
+// is an event whose id is its sequence number, so EventSource reconnects
+// resume from Last-Event-ID. A final "closed" event carries the reason.
+func (s *Server) streamAgentPoll(w http.ResponseWriter, r *http.Request, pv *pollViewer, after uint64) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeSessionError(w, http.StatusNotAcceptable, "streaming_unsupported", "Streaming is not supported on this connection")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	// Disable response buffering in nginx-style proxies.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := s.config.ACPPollWait
+	if keepalive <= 0 {
+		keepalive = 25 * time.Second
+	}
+
+	var buf bytes.Buffer
+	for {
+		batch := pv.queue.Poll(r.Context(), after, keepalive, agentPollMaxBatch)
+		if r.Context().Err() != nil {
+			return
+		}
+		pv.markActive()
+
+		if len(batch.Messages) == 0 && !batch.Closed {
+			if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+			continue
+		}
+
+		for _, msg := range batch.Messages {
+			buf.Reset()
+			if err := json.Compact(&buf, msg.Data); err != nil {
+				buf.Reset()
+				buf.Write(bytes.ReplaceAll(msg.Data, []byte("\n"), nil))
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", msg.Seq, buf.Bytes()); err != nil {
+				return
+			}
+		}
+		after = batch.Cursor
+
+		if batch.Closed {
+			data, _ := json.Marshal(map[string]string{"reason": batch.Reason})
+			_, _ = fmt.Fprintf(w, "event: closed\ndata: %s\n\n", data)
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// handleAgentPollMessage routes one POSTed viewer message (a control message
+// or ACP JSON-RPC request) to the session, as if read from the WebSocket.
+func (s *Server) handleAgentPollMessage(w http.ResponseWriter, r *http.Request) {
+	pv, ok := s.lookupPollViewer(w, r)
+	if !ok {
+		return
+	}
+	pv.markActive()
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, agentPollMaxMessageBytes))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeSessionError(w, http.StatusRequestEntityTooLarge, "message_too_large", "Message exceeds the size limit")
+			return
+		}
+		writeSessionError(w, http.StatusBadRequest, "invalid_message", "Failed to read message body")
+		return
+	}
+	if !json.Valid(body) {
+		writeSessionError(w, http.StatusBadRequest, "invalid_message", "Message must be a JSON document")
+		return
+	}
+	if pv.queue.Closed() {
+		writeSessionError(w, http.StatusGone, "viewer_closed", "Viewer is closed; re-attach to continue")
+		return
+	}
+
+	pv.gateway.Dispatch(context.Background(), body)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleAgentPollDetach detaches a long-poll viewer. The agent keeps running.
+func (s *Server) handleAgentPollDetach(w http.ResponseWriter, r *http.Request) {
+	pv, ok := s.lookupPollViewer(w, r)
+	if !ok {
+		return
+	}
+	pv.close()
+	slog.Info("Agent long-poll viewer detach requested", "workspace", pv.workspaceID, "sessionId", pv.sessionID, "viewerId", pv.id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parsePollCursor reads the resume cursor from the "after" query parameter,
+// falling back to the SSE Last-Event-ID header.
+func parsePollCursor(r *http.Request) (uint64, error) {
+	raw := strings.TrimSpace(r.URL.Query().Get("after"))
+	if raw == "" {
+		raw = strings.TrimSpace(r.Header.Get("Last-Event-ID"))
+	}
+	if raw == "" {
+		return 0, nil
+	}
+	after, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		return 0, errors.New("after must be a non-negative integer")
+	}
+	return after, nil
+}
@@ -0,0 +1,266 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/acp"
+)
+
+const pollTestWorkspaceID = "WS_TEST"
+
+func newAgentPollTestServer(t *testing.T, idleTimeout time.Duration) (*Server, *httptest.Server, string) {
+	t.Helper()
+
+	s, _, cookieSessionID := newAgentWSTestServer(t)
+	s.config.ACPPollWait = 2 * time.Second
+	s.config.ACPPollIdleTimeout = idleTimeout
+	s.config.ACPPollQueueSize = 100
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /agent/poll", s.handleAgentPollAttach)
+	mux.HandleFunc("GET /agent/poll/{viewerId}", s.handleAgentPoll)
+	mux.HandleFunc("POST /agent/poll/{viewerId}/messages", s.handleAgentPollMessage)
+	mux.HandleFunc("DELETE /agent/poll/{viewerId}", s.handleAgentPollDetach)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	t.Cleanup(func() {
+		s.sessionHostMu.Lock()
+		defer s.sessionHostMu.Unlock()
+		for _, host := range s.sessionHosts {
+			host.Stop()
+		}
+	})
+	return s, ts, cookieSessionID
+}
+
+func pollRequest(t *testing.T, method, url, cookieSessionID string, body io.Reader) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	if cookieSessionID != "" {
+		req.Header.Set("Cookie", "session="+cookieSessionID)
+	}
+	req.Header.Set("X-SAM-Workspace-Id", pollTestWorkspaceID)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func attachPollViewer(t *testing.T, ts *httptest.Server, cookieSessionID, sessionID string) string {
+	t.Helper()
+	resp := pollRequest(t, http.MethodPost, ts.URL+"/agent/poll?sessionId="+sessionID, cookieSessionID, nil)
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("attach status = %d, body = %s", resp.StatusCode, body)
+	}
+	var attached struct {
+		ViewerID  string `json:"viewerId"`
+		SessionID string `json:"sessionId"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&attached); err != nil {
+		t.Fatalf("decode attach: %v", err)
+	}
+	if attached.ViewerID == "" || attached.SessionID != sessionID {
+		t.Fatalf("attach response = %+v", attached)
+	}
+	return attached.ViewerID
+}
+
+// pollUntil polls until a message of type msgType arrives, returning the
+// cursor after it.
+func pollUntil(t *testing.T, ts *httptest.Server, cookieSessionID, viewerID string, after uint64, msgType string) uint64 {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		resp := pollRequest(t, http.MethodGet, ts.URL+"/agent/poll/"+viewerID+"?waitMs=1000&after="+strconv.FormatUint(after, 10), cookieSessionID, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("poll status = %d", resp.StatusCode)
+		}
+		var batch acp.PollBatch
+		if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+			t.Fatalf("decode batch: %v", err)
+		}
+		after = batch.Cursor
+		for _, msg := range batch.Messages {
+			var envelope struct {
+				Type string `json:"type"`
+			}
+			_ = json.Unmarshal(msg.Data, &envelope)
+			if envelope.Type == msgType {
+				return msg.Seq
+			}
+		}
+		if batch.Closed {
+			t.Fatalf("viewer closed (%s) before %q arrived", batch.Reason, msgType)
+		}
+	}
+	t.Fatalf("timed out waiting for %q", msgType)
+	return 0
+}
+
+func TestAgentPoll_AttachPollSendDetach(t *testing.T) {
+	s, ts, cookieSessionID := newAgentPollTestServer(t, time.Minute)
+
+	viewerID := attachPollViewer(t, ts, cookieSessionID, "sess-poll")
+	cursor := pollUntil(t, ts, cookieSessionID, viewerID, 0, string(acp.MsgSessionReplayDone))
+
+	resp := pollRequest(t, http.MethodPost, ts.URL+"/agent/poll/"+viewerID+"/messages", cookieSessionID, strings.NewReader(`{"type":"ping"}`))
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("send status = %d", resp.StatusCode)
+	}
+	pollUntil(t, ts, cookieSessionID, viewerID, cursor, string(acp.MsgPong))
+
+	resp = pollRequest(t, http.MethodPost, ts.URL+"/agent/poll/"+viewerID+"/messages", cookieSessionID, strings.NewReader(`not json`))
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("invalid message status = %d, want 400", resp.StatusCode)
+	}
+
+	host, ok := s.sessionHosts[pollTestWorkspaceID+":sess-poll"]
+	if !ok || host.ViewerCount() != 1 {
+		t.Fatalf("expected one attached viewer, host found = %v", ok)
+	}
+
+	resp = pollRequest(t, http.MethodDelete, ts.URL+"/agent/poll/"+viewerID, cookieSessionID, nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("detach status = %d", resp.StatusCode)
+	}
+	waitForPollViewerGone(t, s, host, viewerID)
+
+	resp = pollRequest(t, http.MethodGet, ts.URL+"/agent/poll/"+viewerID, cookieSessionID, nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("poll after detach status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestAgentPoll_RequiresAuth(t *testing.T) {
+	_, ts, cookieSessionID := newAgentPollTestServer(t, time.Minute)
+
+	resp := pollRequest(t, http.MethodPost, ts.URL+"/agent/poll?sessionId=sess-auth", "", nil)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated attach status = %d, want 401", resp.StatusCode)
+	}
+
+	viewerID := attachPollViewer(t, ts, cookieSessionID, "sess-auth")
+	resp = pollRequest(t, http.MethodGet, ts.URL+"/agent/poll/"+viewerID, "", nil)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated poll status = %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestAgentPoll_ReadOnlyRole(t *testing.T) {
+	s, ts, cookieSessionID := newAgentPollTestServer(t, time.Minute)
+
+	resp := pollRequest(t, http.MethodPost, ts.URL+"/agent/poll?sessionId=sess-role&role=owner", cookieSessionID, nil)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("invalid role status = %d, want 400", resp.StatusCode)
+	}
+
+	resp = pollRequest(t, http.MethodPost, ts.URL+"/agent/poll?sessionId=sess-role&role=read-only", cookieSessionID, nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("attach status = %d", resp.StatusCode)
+	}
+	var attached struct {
+		ViewerID string `json:"viewerId"`
+		Role     string `json:"role"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&attached); err != nil || attached.Role != "read-only" {
+		t.Fatalf("attach response = %+v, err = %v", attached, err)
+	}
+	host := s.sessionHosts[pollTestWorkspaceID+":sess-role"]
+	if got := host.ViewerRole(attached.ViewerID); got != acp.ViewerRoleReadOnly {
+		t.Fatalf("viewer role = %q", got)
+	}
+	cursor := pollUntil(t, ts, cookieSessionID, attached.ViewerID, 0, string(acp.MsgSessionReplayDone))
+
+	resp = pollRequest(t, http.MethodPost, ts.URL+"/agent/poll/"+attached.ViewerID+"/messages", cookieSessionID, strings.NewReader(`{"type":"select_agent","agentType":"claude-code"}`))
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("send status = %d", resp.StatusCode)
+	}
+	pollUntil(t, ts, cookieSessionID, attached.ViewerID, cursor, string(acp.MsgViewerError))
+}
+
+func TestAgentPoll_LastSeq(t *testing.T) {
+	_, ts, cookieSessionID := newAgentPollTestServer(t, time.Minute)
+
+	resp := pollRequest(t, http.MethodPost, ts.URL+"/agent/poll?sessionId=sess-seq&last_seq=-1", cookieSessionID, nil)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("invalid last_seq status = %d, want 400", resp.StatusCode)
+	}
+	resp = pollRequest(t, http.MethodPost, ts.URL+"/agent/poll?sessionId=sess-seq&last_seq=0", cookieSessionID, nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("last_seq=0 attach status = %d", resp.StatusCode)
+	}
+}
+
+func TestAgentPoll_IdleViewerIsDetached(t *testing.T) {
+	s, ts, cookieSessionID := newAgentPollTestServer(t, 100*time.Millisecond)
+
+	viewerID := attachPollViewer(t, ts, cookieSessionID, "sess-idle")
+	host := s.sessionHosts[pollTestWorkspaceID+":sess-idle"]
+	waitForPollViewerGone(t, s, host, viewerID)
+}
+
+func TestAgentPoll_ServerSentEvents(t *testing.T) {
+	_, ts, cookieSessionID := newAgentPollTestServer(t, time.Minute)
+	viewerID := attachPollViewer(t, ts, cookieSessionID, "sess-sse")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/agent/poll/"+viewerID, nil)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cookie", "session="+cookieSessionID)
+	req.Header.Set("X-SAM-Workspace-Id", pollTestWorkspaceID)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("sse request: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content type = %q", ct)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	var id, data string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "id: ") {
+			id = strings.TrimPrefix(line, "id: ")
+		}
+		if strings.HasPrefix(line, "data: ") {
+			data = strings.TrimPrefix(line, "data: ")
+			break
+		}
+	}
+	if id != "1" || !strings.Contains(data, `"type":"`+string(acp.MsgSessionState)+`"`) {
+		t.Fatalf("first event id=%q data=%q, want session_state with id 1", id, data)
+	}
+}
+
+func waitForPollViewerGone(t *testing.T, s *Server, host *acp.SessionHost, viewerID string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s.pollViewersMu.Lock()
+		_, registered := s.pollViewers[viewerID]
+		s.pollViewersMu.Unlock()
+		if !registered && host.ViewerCount() == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("viewer %s still attached", viewerID)
+}
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
@@ -9,6 +11,7 @@ import (
 
 	"github.com/workspace/vm-agent/internal/acp"
 	"github.com/workspace/vm-agent/internal/agentsessions"
+	"github.com/workspace/vm-agent/internal/auth"
 	"github.com/workspace/vm-agent/internal/config"
 	"github.com/workspace/vm-agent/internal/messagereport"
 	"github.com/workspace/vm-agent/internal/persistence"
@@ -109,7 +112,6 @@ func TestCreateAgentSessionMcpServersPersistAndBackfillBeforeSessionHost(t *test
 			ACPViewerSendBuffer:  8,
 			CallbackToken:        "node-callback-token",
 		},
-		workspaces:          map[string]*WorkspaceRuntime{},
 		workspaceEvents:     map[string][]EventRecord{},
 		agentSessions:       agentsessions.NewManager(),
 		sessionHosts:        map[string]*acp.SessionHost{},
@@ -151,7 +153,7 @@ func TestCreateAgentSessionMcpServersPersistAndBackfillBeforeSessionHost(t *test
 		AgentType:   "amp",
 		CreatedAt:   time.Now().UTC(),
 		UpdatedAt:   time.Now().UTC(),
-	}, nil, "")
+	}, nil, "", "")
 	if host == nil {
 		t.Fatal("expected SessionHost")
 	}
@@ -161,3 +163,88 @@ func TestCreateAgentSessionMcpServersPersistAndBackfillBeforeSessionHost(t *test
 		t.Fatalf("expected persisted MCP servers to backfill before SessionHost creation, got %#v", backfilled)
 	}
 }
+
+func TestCancelAgentSessionValidatesCancellationBody(t *testing.T) {
+	validator, privateKey := newWorkspaceCreateJWTValidator(t, "node-1")
+	s := newWorkspaceCreateServer(t, "", validator)
+	token := signWorkspaceCreateNodeToken(t, privateKey, "node-1", "ws-1")
+
+	cases := []struct {
+		name     string
+		body     string
+		wantCode int
+	}{
+		{"empty body defaults to user", "", http.StatusNotFound},
+		{"admin with reason", `{"initiator":"admin","reason":"Maintenance"}`, http.StatusNotFound},
+		{"timeout is agent-only", `{"initiator":"timeout"}`, http.StatusBadRequest},
+		{"unknown initiator", `{"initiator":"robot"}`, http.StatusBadRequest},
+		{"reason too long", `{"reason":"` + strings.Repeat("x", maxCancelReasonLength+1) + `"}`, http.StatusBadRequest},
+		{"malformed", `{`, http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/workspaces/ws-1/agent-sessions/sess-1/cancel", strings.NewReader(tc.body))
+			req.SetPathValue("workspaceId", "ws-1")
+			req.SetPathValue("sessionId", "sess-1")
+			req.Header.Set("Authorization", "Bearer "+token)
+			req.Header.Set("X-SAM-Node-Id", "node-1")
+			req.Header.Set("X-SAM-Workspace-Id", "ws-1")
+			rec := httptest.NewRecorder()
+			s.handleCancelAgentSession(rec, req)
+			// Valid bodies reach the session lookup, which 404s without a host.
+			if rec.Code != tc.wantCode {
+				t.Fatalf("status = %d %s, want %d", rec.Code, rec.Body.String(), tc.wantCode)
+			}
+		})
+	}
+}
+
+func TestAgentSessionWorkDirRecordedAndListed(t *testing.T) {
+	store, err := persistence.Open(filepath.Join(t.TempDir(), "vm-agent.db"))
+	if err != nil {
+		t.Fatalf("Open persistence store: %v", err)
+	}
+	defer store.Close()
+
+	validator, privateKey := newWorkspaceCreateJWTValidator(t, "node-1")
+	s := newWorkspaceCreateServer(t, "", validator)
+	s.store = store
+	s.sessionManager = auth.NewSessionManager("session", false, time.Hour)
+	token := signWorkspaceCreateNodeToken(t, privateKey, "node-1", "ws-1")
+	newRequest := func(method, body string) *http.Request {
+		req := httptest.NewRequest(method, "/workspaces/ws-1/agent-sessions", strings.NewReader(body))
+		req.SetPathValue("workspaceId", "ws-1")
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("X-SAM-Node-Id", "node-1")
+		req.Header.Set("X-SAM-Workspace-Id", "ws-1")
+		return req
+	}
+
+	// A worktree that cannot be validated must not create the session.
+	rec := httptest.NewRecorder()
+	s.handleCreateAgentSession(rec, newRequest(http.MethodPost, `{"sessionId":"sess-1","worktree":"/workspaces/repo-feature"}`))
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("create with unresolvable worktree status = %d %s, want 409", rec.Code, rec.Body.String())
+	}
+	if _, ok := s.agentSessions.Get("ws-1", "sess-1"); ok {
+		t.Fatal("session created despite invalid worktree")
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleCreateAgentSession(rec, newRequest(http.MethodPost, `{"sessionId":"sess-1","label":"Chat"}`))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create status = %d %s", rec.Code, rec.Body.String())
+	}
+	s.recordSessionWorkDir("ws-1", "sess-1", "/workspaces/repo-feature")
+
+	tabs, err := store.ListTabs("ws-1")
+	if err != nil || len(tabs) != 1 || tabs[0].WorkDir != "/workspaces/repo-feature" {
+		t.Fatalf("persisted tabs = %+v, err = %v", tabs, err)
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleListAgentSessions(rec, newRequest(http.MethodGet, ""))
+	if !strings.Contains(rec.Body.String(), `"workDir":"/workspaces/repo-feature"`) {
+		t.Fatalf("list body = %s, want workDir", rec.Body.String())
+	}
+}
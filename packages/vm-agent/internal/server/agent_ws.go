@@ -4,11 +4,14 @@ import (
 	"context"
 	"log/slog"
 	"net/http"
+	"path"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/workspace/vm-agent/internal/acp"
+	"github.com/workspace/vm-agent/internal/activity"
 	"github.com/workspace/vm-agent/internal/agentsessions"
 	"github.com/workspace/vm-agent/internal/config"
 	"github.com/workspace/vm-agent/internal/gitrepo"
@@ -26,9 +29,7 @@ func (a *serverEventAppender) AppendEvent(workspaceID, level, eventType, message
 
 func (s *Server) gitHubTokenFetcherForWorkspace(workspaceID string) func(context.Context) (string, error) {
 	repository := ""
-	if strings.TrimSpace(workspaceID) == strings.TrimSpace(s.config.WorkspaceID) {
-		repository = s.config.Repository
-	} else if runtime, ok := s.getWorkspaceRuntime(workspaceID); ok {
+	if runtime, ok := s.getWorkspaceRuntime(workspaceID); ok {
 		repository = runtime.Repository
 	}
 	if !gitrepo.IsGitHubRepo(repository) {
@@ -39,10 +40,9 @@ func (s *Server) gitHubTokenFetcherForWorkspace(workspaceID string) func(context
 	// git-token endpoint. The callback token is resolved at call time via
 	// callbackTokenForWorkspace(), so token rotations are automatically picked up.
 	// Without this override, the server-level default (nil) would leave GH_TOKEN
-	// unset; the previous bug had a server-level s.fetchGitToken that silently
-	// used s.config.WorkspaceID (the node-level ID) instead.
+	// unset rather than fall back to the boot workspace's token.
 	return func(ctx context.Context) (string, error) {
-		return s.fetchGitTokenForWorkspace(ctx, workspaceID, "")
+		return s.fetchGitTokenForWorkspace(ctx, workspaceID, "", gitTokenPurposePullRequest)
 	}
 }
 
@@ -53,6 +53,34 @@ func writeSessionError(w http.ResponseWriter, statusCode int, code, message stri
 	})
 }
 
+// requestedViewerRole reads the optional "role" query parameter of an agent
+// attach request. It writes a 400 response and returns false when the role
+// is not recognized.
+func requestedViewerRole(w http.ResponseWriter, r *http.Request) (acp.ViewerRole, bool) {
+	role, ok := acp.ParseViewerRole(strings.TrimSpace(r.URL.Query().Get("role")))
+	if !ok {
+		writeSessionError(w, http.StatusBadRequest, "invalid_role", "role must be read-write or read-only")
+		return "", false
+	}
+	return role, true
+}
+
+// requestedReplaySeq reads the optional "last_seq" query parameter of an
+// agent attach request: the last session_ack a reconnecting client received.
+// It writes a 400 response and returns false when the value is malformed.
+func requestedReplaySeq(w http.ResponseWriter, r *http.Request) (uint64, bool) {
+	raw := strings.TrimSpace(r.URL.Query().Get("last_seq"))
+	if raw == "" {
+		return 0, true
+	}
+	seq, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		writeSessionError(w, http.StatusBadRequest, "invalid_last_seq", "last_seq must be a non-negative integer")
+		return 0, false
+	}
+	return seq, true
+}
+
 // handleAgentWS handles WebSocket connections for ACP agent communication.
 // Multiple viewers can connect to the same session simultaneously.
 // The agent process lives in a SessionHost which persists independently of
@@ -64,11 +92,113 @@ func (s *Server) handleAgentWS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, ok := s.authenticateWorkspaceWebsocket(w, r, workspaceID)
+	userID, ok := s.authenticateWorkspaceWebsocket(w, r, workspaceID)
+	if !ok {
+		return
+	}
+
+	role, ok := requestedViewerRole(w, r)
+	if !ok {
+		return
+	}
+	lastSeq, ok := requestedReplaySeq(w, r)
+	if !ok {
+		return
+	}
+
+	host, session, requestedSessionID, ok := s.resolveAgentSessionHost(w, r, workspaceID)
 	if !ok {
 		return
 	}
 
+	upgrader := s.createUpgrader()
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Error("ACP WebSocket upgrade failed", "error", err)
+		return
+	}
+
+	// Post-upgrade race check: if session was stopped between request and upgrade
+	postUpgradeSession, postUpgradeExists := s.agentSessions.Get(workspaceID, requestedSessionID)
+	if !postUpgradeExists || postUpgradeSession.Status != agentsessions.StatusRunning {
+		_ = conn.WriteJSON(map[string]string{
+			"error":   "session_not_running",
+			"message": "Requested session is not running",
+		})
+		_ = conn.WriteControl(
+			websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "session_not_running"),
+			time.Now().Add(5*time.Second),
+		)
+		_ = conn.Close()
+		return
+	}
+
+	// Attach as a viewer — multiple viewers can connect simultaneously.
+	// The SessionHost replays all buffered messages to the new viewer.
+	viewerID := "viewer-" + randomEventID()
+	viewer := host.AttachViewerAfter(viewerID, conn, lastSeq)
+	if viewer == nil {
+		// Session was stopped between getOrCreate and attach
+		_ = conn.WriteJSON(map[string]string{
+			"error":   "session_not_running",
+			"message": "Session was stopped",
+		})
+		_ = conn.Close()
+		return
+	}
+	host.SetViewerRole(viewerID, role)
+	s.activityTracker.Touch(workspaceID, activity.SourceViewer)
+
+	// Deliver active workspace announcements after the session replay.
+	// Dismissals are tracked per user; token-only viewers without a user ID
+	// are tracked per connection.
+	announcementSubject := userID
+	if announcementSubject == "" {
+		announcementSubject = viewerID
+	}
+	s.sendActiveAnnouncements(host, workspaceID, viewerID, announcementSubject)
+	s.sendControlPlaneStatus(host, viewerID)
+	s.sendShutdownNotice(host, viewerID)
+	accessID := s.auditViewerAttach(r, workspaceID, requestedSessionID, viewerID, userID)
+
+	// Create thin Gateway relay (reads WebSocket messages, routes to SessionHost)
+	gateway := acp.NewGateway(host, conn, viewerID, viewer.Done())
+	gateway.SetAnnouncementDismissHandler(func(announcementID string) {
+		s.dismissAnnouncement(workspaceID, announcementID, announcementSubject)
+	})
+	gateway.SetDriveHandler(func() { s.auditViewerDrive(accessID) })
+
+	s.appendNodeEvent(workspaceID, "info", "agent.websocket_connected", "Agent WebSocket connected", map[string]interface{}{
+		"sessionId":          requestedSessionID,
+		"viewerId":           viewerID,
+		"viewerRole":         role,
+		"lastSeq":            lastSeq,
+		"viewerCount":        host.ViewerCount(),
+		"hasPreviousSession": session.AcpSessionID != "",
+		"previousAcpSession": session.AcpSessionID,
+		"previousAgentType":  session.AgentType,
+	})
+
+	// Run the gateway read loop (blocks until WebSocket closes)
+	gateway.Run(context.Background())
+
+	// Detach the viewer — agent continues running in the SessionHost
+	host.DetachViewer(viewerID)
+	s.auditViewerDetach(accessID)
+
+	s.appendNodeEvent(workspaceID, "info", "agent.websocket_disconnected", "Agent WebSocket disconnected", map[string]interface{}{
+		"sessionId":   requestedSessionID,
+		"viewerId":    viewerID,
+		"viewerCount": host.ViewerCount(),
+	})
+}
+
+// resolveAgentSessionHost resolves the agent session a viewer asked for via
+// the sessionId query parameter, creating, hydrating, or resuming it as
+// needed, and returns its SessionHost. Shared by the WebSocket and long-poll
+// viewer transports. On failure it writes an error response and returns false.
+func (s *Server) resolveAgentSessionHost(w http.ResponseWriter, r *http.Request, workspaceID string) (*acp.SessionHost, agentsessions.Session, string, bool) {
 	runtime := s.upsertWorkspaceRuntime(workspaceID, "", "", "running", "")
 
 	requestedSessionID := strings.TrimSpace(r.URL.Query().Get("sessionId"))
@@ -84,7 +214,7 @@ func (s *Server) handleAgentWS(w http.ResponseWriter, r *http.Request) {
 		created, _, err := s.agentSessions.Create(workspaceID, requestedSessionID, "", idempotencyKey)
 		if err != nil {
 			writeSessionError(w, http.StatusConflict, "session_create_failed", err.Error())
-			return
+			return nil, agentsessions.Session{}, "", false
 		}
 		session = created
 
@@ -92,6 +222,12 @@ func (s *Server) handleAgentWS(w http.ResponseWriter, r *http.Request) {
 		if s.store != nil {
 			if tabs, tabErr := s.store.ListTabs(workspaceID); tabErr == nil {
 				for _, tab := range tabs {
+					if tab.ID == requestedSessionID && tab.WorkDir != "" {
+						session.WorkDir = tab.WorkDir
+						if updateErr := s.agentSessions.UpdateWorkDir(workspaceID, requestedSessionID, tab.WorkDir); updateErr != nil {
+							slog.Error("Failed to hydrate WorkDir in session manager", "workspace", workspaceID, "sessionId", requestedSessionID, "error", updateErr)
+						}
+					}
 					if tab.ID == requestedSessionID && tab.AcpSessionID != "" {
 						session.AcpSessionID = tab.AcpSessionID
 						session.AgentType = tab.AgentID
@@ -117,9 +253,9 @@ func (s *Server) handleAgentWS(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Auto-resume suspended sessions on WebSocket attach. This handles the case
+	// Auto-resume suspended sessions on viewer attach. This handles the case
 	// where the control plane's resumeAgentSessionOnNode() call failed (best-effort)
-	// but the browser is now trying to connect via WebSocket.
+	// but the browser is now trying to connect.
 	if session.Status == agentsessions.StatusSuspended {
 		resumed, resumeErr := s.agentSessions.Resume(workspaceID, requestedSessionID)
 		if resumeErr != nil {
@@ -132,7 +268,7 @@ func (s *Server) handleAgentWS(w http.ResponseWriter, r *http.Request) {
 			} else {
 				slog.Warn("Auto-resume on WebSocket attach failed", "workspace", workspaceID, "session", requestedSessionID, "error", resumeErr)
 				writeSessionError(w, http.StatusConflict, "session_not_running", "Requested session is suspended and could not be resumed")
-				return
+				return nil, agentsessions.Session{}, "", false
 			}
 		} else {
 			session = resumed
@@ -142,79 +278,25 @@ func (s *Server) handleAgentWS(w http.ResponseWriter, r *http.Request) {
 
 	if session.Status != agentsessions.StatusRunning {
 		writeSessionError(w, http.StatusConflict, "session_not_running", "Requested session is not running")
-		return
+		return nil, agentsessions.Session{}, "", false
 	}
 
 	// Get or create SessionHost for this session.
 	// The SessionHost persists independently of any WebSocket connection.
 	hostKey := workspaceID + ":" + requestedSessionID
 	requestedWorktree := strings.TrimSpace(r.URL.Query().Get("worktree"))
-	host := s.getOrCreateSessionHost(hostKey, workspaceID, requestedSessionID, session, runtime, requestedWorktree)
-
-	upgrader := s.createUpgrader()
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		slog.Error("ACP WebSocket upgrade failed", "error", err)
-		return
-	}
-
-	// Post-upgrade race check: if session was stopped between request and upgrade
-	postUpgradeSession, postUpgradeExists := s.agentSessions.Get(workspaceID, requestedSessionID)
-	if !postUpgradeExists || postUpgradeSession.Status != agentsessions.StatusRunning {
-		_ = conn.WriteJSON(map[string]string{
-			"error":   "session_not_running",
-			"message": "Requested session is not running",
-		})
-		_ = conn.WriteControl(
-			websocket.CloseMessage,
-			websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "session_not_running"),
-			time.Now().Add(5*time.Second),
-		)
-		_ = conn.Close()
-		return
-	}
-
-	// Attach as a viewer — multiple viewers can connect simultaneously.
-	// The SessionHost replays all buffered messages to the new viewer.
-	viewerID := "viewer-" + randomEventID()
-	viewer := host.AttachViewer(viewerID, conn)
-	if viewer == nil {
-		// Session was stopped between getOrCreate and attach
-		_ = conn.WriteJSON(map[string]string{
-			"error":   "session_not_running",
-			"message": "Session was stopped",
-		})
-		_ = conn.Close()
-		return
+	if requestedWorktree == "" {
+		requestedWorktree = session.WorkDir
 	}
-
-	// Create thin Gateway relay (reads WebSocket messages, routes to SessionHost)
-	gateway := acp.NewGateway(host, conn, viewerID, viewer.Done())
-
-	s.appendNodeEvent(workspaceID, "info", "agent.websocket_connected", "Agent WebSocket connected", map[string]interface{}{
-		"sessionId":          requestedSessionID,
-		"viewerId":           viewerID,
-		"viewerCount":        host.ViewerCount(),
-		"hasPreviousSession": session.AcpSessionID != "",
-		"previousAcpSession": session.AcpSessionID,
-		"previousAgentType":  session.AgentType,
-	})
-
-	// Run the gateway read loop (blocks until WebSocket closes)
-	gateway.Run(context.Background())
-
-	// Detach the viewer — agent continues running in the SessionHost
-	host.DetachViewer(viewerID)
-
-	s.appendNodeEvent(workspaceID, "info", "agent.websocket_disconnected", "Agent WebSocket disconnected", map[string]interface{}{
-		"sessionId":   requestedSessionID,
-		"viewerId":    viewerID,
-		"viewerCount": host.ViewerCount(),
-	})
+	requestedSubdir := strings.TrimSpace(r.URL.Query().Get("subdir"))
+	host := s.getOrCreateSessionHost(hostKey, workspaceID, requestedSessionID, session, runtime, requestedWorktree, requestedSubdir)
+	return host, session, requestedSessionID, true
 }
 
 // getOrCreateSessionHost returns an existing SessionHost or creates a new one.
-func (s *Server) getOrCreateSessionHost(hostKey, workspaceID, sessionID string, session agentsessions.Session, runtime *WorkspaceRuntime, requestedWorktree string) *acp.SessionHost {
+// A new host runs in requestedWorktree, narrowed to requestedSubdir of it when
+// set; both default to the workspace's working directory.
+func (s *Server) getOrCreateSessionHost(hostKey, workspaceID, sessionID string, session agentsessions.Session, runtime *WorkspaceRuntime, requestedWorktree, requestedSubdir string) *acp.SessionHost {
 	// Fast path: check if host already exists.
 	s.sessionHostMu.Lock()
 	if host, ok := s.sessionHosts[hostKey]; ok {
@@ -266,9 +348,15 @@ func (s *Server) getOrCreateSessionHost(hostKey, workspaceID, sessionID string,
 	cfg.SessionManager = s.agentSessions
 	cfg.TabStore = s.store
 	cfg.TabLastPromptStore = s.store
+	if s.store != nil {
+		// Guarded: a nil *Store would make the interface non-nil, and the
+		// host reads the buffer back at construction.
+		cfg.TabMessageStore = s.store
+	}
 	cfg.SessionLastPromptManager = s.agentSessions
 	cfg.EventAppender = &serverEventAppender{server: s}
 	cfg.CredentialSyncer = s
+	cfg.ControlPlaneStatus = s
 	// Disable auto-suspend for both conversation and task mode. Viewer presence
 	// is not the right lifecycle signal — the correct shutdown mechanisms are:
 	// 1. 15-min DO alarm after last agent activity (control-plane side)
@@ -282,6 +370,9 @@ func (s *Server) getOrCreateSessionHost(hostKey, workspaceID, sessionID string,
 	cfg.OnSuspend = func(wsID, sessID string) {
 		s.handleAutoSuspend(wsID, sessID)
 	}
+	if s.config != nil && s.config.CheckpointMaxPerSession > 0 {
+		cfg.OnPromptStart = s.checkpointBeforePrompt
+	}
 
 	if session.AcpSessionID != "" {
 		cfg.PreviousAcpSessionID = session.AcpSessionID
@@ -293,7 +384,7 @@ func (s *Server) getOrCreateSessionHost(hostKey, workspaceID, sessionID string,
 		cfg.CallbackToken = callbackToken
 	}
 	taskCtx, hasTaskCtx := s.sessionTaskCtx[hostKey]
-	if !hasTaskCtx && s.config != nil && s.config.TaskID != "" && s.config.ProjectID != "" && workspaceID == strings.TrimSpace(s.config.WorkspaceID) {
+	if !hasTaskCtx && s.config != nil && s.config.TaskID != "" && s.config.ProjectID != "" && workspaceID == s.workspaces.BootID() {
 		taskMode := strings.TrimSpace(s.config.TaskMode)
 		if taskMode == "" {
 			taskMode = config.TaskModeTask
@@ -336,13 +427,22 @@ func (s *Server) getOrCreateSessionHost(hostKey, workspaceID, sessionID string,
 		if user := strings.TrimSpace(runtime.ContainerUser); user != "" {
 			cfg.ContainerUser = user
 		}
-		if requestedWorktree != "" {
+		if requestedWorktree != "" || requestedSubdir != "" {
 			containerID, defaultWorkDir, user, resolveErr := s.resolveContainerForWorkspace(workspaceID)
 			if resolveErr == nil {
-				if effectiveWorkDir, err := s.resolveExplicitWorktreeWorkDir(context.Background(), workspaceID, containerID, user, defaultWorkDir, requestedWorktree); err == nil {
+				var effectiveWorkDir string
+				_, effectiveWorkDir, resolveErr = s.resolveSessionWorkDir(context.Background(), workspaceID, containerID, user, defaultWorkDir, requestedWorktree, requestedSubdir)
+				if resolveErr == nil {
 					cfg.ContainerWorkDir = effectiveWorkDir
+					if effectiveWorkDir != session.WorkDir {
+						s.recordSessionWorkDir(workspaceID, sessionID, effectiveWorkDir)
+					}
 				}
 			}
+			if resolveErr != nil {
+				slog.Warn("Ignoring requested worktree for agent session; using default workdir",
+					"workspace", workspaceID, "sessionId", sessionID, "worktree", requestedWorktree, "subdir", requestedSubdir, "error", resolveErr)
+			}
 		}
 		if resolver := s.ptyManagerContainerResolverForLabel(runtime.ContainerLabelValue); resolver != nil {
 			cfg.ContainerResolver = resolver
@@ -373,14 +473,14 @@ func (s *Server) getOrCreateSessionHost(hostKey, workspaceID, sessionID string,
 		cfg.OpencodeBaseURLOverride = ovr.OpencodeBaseURL
 	}
 
-	hostCfg := acp.SessionHostConfig{
-		GatewayConfig:         cfg,
-		MessageBufferSize:     s.config.ACPMessageBufferSize,
-		ViewerSendBuffer:      s.config.ACPViewerSendBuffer,
-		StderrBufferBytes:     s.config.ACPStderrBufferBytes,
-		NotifSerializeTimeout: s.config.ACPNotifSerializeTimeout,
-		RuntimeAssetsProvider: runtimeAssetsProvider,
+	if host := s.claimWarmStandbyLocked(workspaceID, sessionID, cfg, path.Join(requestedWorktree, requestedSubdir), runtimeAssetsProvider != nil); host != nil {
+		s.sessionHosts[hostKey] = host
+		slog.Info("SessionHost claimed from warm standby", "workspace", workspaceID, "sessionId", sessionID)
+		return host
 	}
+
+	hostCfg := s.sessionHostConfig(cfg)
+	hostCfg.RuntimeAssetsProvider = runtimeAssetsProvider
 	host := acp.NewSessionHost(hostCfg)
 	s.sessionHosts[hostKey] = host
 
@@ -37,7 +37,6 @@ func newAgentWSTestServer(t *testing.T) (*Server, *httptest.Server, string) {
 	s := &Server{
 		config:          cfg,
 		sessionManager:  sessionManager,
-		workspaces:      make(map[string]*WorkspaceRuntime),
 		workspaceEvents: make(map[string][]EventRecord),
 		agentSessions:   agentsessions.NewManager(),
 		acpConfig:       acp.GatewayConfig{},
@@ -0,0 +1,306 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/acp"
+)
+
+// workspaceAnnouncement is an operator-posted message shown to every viewer of
+// every agent session in a workspace. Announcements are held in memory until
+// they expire, are withdrawn, or the workspace is deleted; viewers that attach
+// while one is active receive it after their session replay.
+type workspaceAnnouncement struct {
+	ID        string
+	Severity  acp.AnnouncementSeverity
+	Title     string
+	Message   string
+	CreatedAt time.Time
+	ExpiresAt *time.Time
+	// dismissals maps the dismissing subject (user ID, or viewer ID for
+	// anonymous token viewers) to when they dismissed the announcement.
+	dismissals map[string]time.Time
+}
+
+func (a *workspaceAnnouncement) expired(now time.Time) bool {
+	return a.ExpiresAt != nil && !now.Before(*a.ExpiresAt)
+}
+
+func (a *workspaceAnnouncement) controlMessage() acp.AnnouncementMessage {
+	return acp.AnnouncementMessage{
+		Type:      acp.MsgAnnouncement,
+		ID:        a.ID,
+		Severity:  a.Severity,
+		Title:     a.Title,
+		Message:   a.Message,
+		CreatedAt: a.CreatedAt,
+		ExpiresAt: a.ExpiresAt,
+	}
+}
+
+type announcementDismissal struct {
+	Subject     string    `json:"subject"`
+	DismissedAt time.Time `json:"dismissedAt"`
+}
+
+type announcementResponse struct {
+	ID         string                   `json:"id"`
+	Severity   acp.AnnouncementSeverity `json:"severity"`
+	Title      string                   `json:"title,omitempty"`
+	Message    string                   `json:"message"`
+	CreatedAt  time.Time                `json:"createdAt"`
+	ExpiresAt  *time.Time               `json:"expiresAt,omitempty"`
+	Dismissals []announcementDismissal  `json:"dismissals"`
+}
+
+func (a *workspaceAnnouncement) response() announcementResponse {
+	dismissals := make([]announcementDismissal, 0, len(a.dismissals))
+	for subject, at := range a.dismissals {
+		dismissals = append(dismissals, announcementDismissal{Subject: subject, DismissedAt: at})
+	}
+	sort.Slice(dismissals, func(i, j int) bool {
+		return dismissals[i].DismissedAt.Before(dismissals[j].DismissedAt)
+	})
+	return announcementResponse{
+		ID:         a.ID,
+		Severity:   a.Severity,
+		Title:      a.Title,
+		Message:    a.Message,
+		CreatedAt:  a.CreatedAt,
+		ExpiresAt:  a.ExpiresAt,
+		Dismissals: dismissals,
+	}
+}
+
+type createAnnouncementRequest struct {
+	Severity   acp.AnnouncementSeverity `json:"severity"`
+	Title      string                   `json:"title"`
+	Message    string                   `json:"message"`
+	TTLSeconds int                      `json:"ttlSeconds"`
+}
+
+// handleCreateAnnouncement posts an announcement to every viewer of every
+// agent session in the workspace and retains it for late joiners.
+func (s *Server) handleCreateAnnouncement(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.PathValue("workspaceId")
+	if workspaceID == "" {
+		writeError(w, http.StatusBadRequest, workspaceIDRequiredMessage)
+		return
+	}
+	if !s.requireNodeManagementAuth(w, r, workspaceID) {
+		return
+	}
+
+	var body createAnnouncementRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	body.Message = strings.TrimSpace(body.Message)
+	body.Title = strings.TrimSpace(body.Title)
+	if body.Severity == "" {
+		body.Severity = acp.AnnouncementInfo
+	}
+	if !body.Severity.Valid() {
+		writeError(w, http.StatusBadRequest, "severity must be one of info, warning, critical")
+		return
+	}
+	if body.Message == "" {
+		writeError(w, http.StatusBadRequest, "message is required")
+		return
+	}
+	if maxBytes := s.config.AnnouncementMaxBytes; maxBytes > 0 && len(body.Title)+len(body.Message) > maxBytes {
+		writeError(w, http.StatusRequestEntityTooLarge, "announcement exceeds maximum size")
+		return
+	}
+	if body.TTLSeconds < 0 {
+		writeError(w, http.StatusBadRequest, "ttlSeconds must be >= 0")
+		return
+	}
+
+	now := nowUTC()
+	announcement := &workspaceAnnouncement{
+		ID:         "ann-" + randomEventID(),
+		Severity:   body.Severity,
+		Title:      body.Title,
+		Message:    body.Message,
+		CreatedAt:  now,
+		dismissals: make(map[string]time.Time),
+	}
+	if body.TTLSeconds > 0 {
+		expiresAt := now.Add(time.Duration(body.TTLSeconds) * time.Second)
+		announcement.ExpiresAt = &expiresAt
+	}
+
+	s.announcementMu.Lock()
+	if s.announcements == nil {
+		s.announcements = make(map[string][]*workspaceAnnouncement)
+	}
+	active := pruneExpiredAnnouncements(s.announcements[workspaceID], now)
+	if limit := s.config.AnnouncementMaxActive; limit > 0 && len(active) >= limit {
+		// Evict the oldest so an operator can always post the newest notice.
+		active = active[len(active)-limit+1:]
+	}
+	s.announcements[workspaceID] = append(active, announcement)
+	response := announcement.response()
+	s.announcementMu.Unlock()
+
+	data, _ := json.Marshal(announcement.controlMessage())
+	viewers := s.broadcastToWorkspaceViewers(workspaceID, data)
+
+	s.appendNodeEvent(workspaceID, "info", "workspace.announcement_posted", "Workspace announcement posted", map[string]interface{}{
+		"announcementId": announcement.ID,
+		"severity":       string(announcement.Severity),
+		"viewerCount":    viewers,
+	})
+
+	writeJSON(w, http.StatusCreated, response)
+}
+
+// handleListAnnouncements returns the workspace's active announcements along
+// with who has dismissed each of them.
+func (s *Server) handleListAnnouncements(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.PathValue("workspaceId")
+	if workspaceID == "" {
+		writeError(w, http.StatusBadRequest, workspaceIDRequiredMessage)
+		return
+	}
+	if !s.requireNodeManagementAuth(w, r, workspaceID) {
+		return
+	}
+
+	now := nowUTC()
+	s.announcementMu.Lock()
+	active := pruneExpiredAnnouncements(s.announcements[workspaceID], now)
+	if s.announcements != nil {
+		s.announcements[workspaceID] = active
+	}
+	announcements := make([]announcementResponse, 0, len(active))
+	for _, a := range active {
+		announcements = append(announcements, a.response())
+	}
+	s.announcementMu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"announcements": announcements})
+}
+
+// handleWithdrawAnnouncement removes an announcement before it expires and
+// tells attached viewers to hide it.
+func (s *Server) handleWithdrawAnnouncement(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.PathValue("workspaceId")
+	announcementID := r.PathValue("announcementId")
+	if workspaceID == "" || announcementID == "" {
+		writeError(w, http.StatusBadRequest, "workspaceId and announcementId are required")
+		return
+	}
+	if !s.requireNodeManagementAuth(w, r, workspaceID) {
+		return
+	}
+
+	found := false
+	s.announcementMu.Lock()
+	current := s.announcements[workspaceID]
+	for i, a := range current {
+		if a.ID == announcementID {
+			s.announcements[workspaceID] = append(current[:i:i], current[i+1:]...)
+			found = true
+			break
+		}
+	}
+	s.announcementMu.Unlock()
+
+	if !found {
+		writeError(w, http.StatusNotFound, "announcement not found")
+		return
+	}
+
+	data, _ := json.Marshal(acp.AnnouncementWithdrawnMessage{Type: acp.MsgAnnouncementWithdrawn, ID: announcementID})
+	s.broadcastToWorkspaceViewers(workspaceID, data)
+
+	s.appendNodeEvent(workspaceID, "info", "workspace.announcement_withdrawn", "Workspace announcement withdrawn", map[string]interface{}{
+		"announcementId": announcementID,
+	})
+	writeJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+}
+
+// broadcastToWorkspaceViewers sends data to every viewer attached to any
+// agent session in the workspace. Returns the number of viewers reached.
+func (s *Server) broadcastToWorkspaceViewers(workspaceID string, data []byte) int {
+	prefix := workspaceID + ":"
+	s.sessionHostMu.Lock()
+	hosts := make([]*acp.SessionHost, 0, len(s.sessionHosts))
+	for key, host := range s.sessionHosts {
+		if host != nil && strings.HasPrefix(key, prefix) {
+			hosts = append(hosts, host)
+		}
+	}
+	s.sessionHostMu.Unlock()
+
+	viewers := 0
+	for _, host := range hosts {
+		host.BroadcastTransient(data)
+		viewers += host.ViewerCount()
+	}
+	return viewers
+}
+
+// sendActiveAnnouncements delivers the workspace's active announcements that
+// subject has not dismissed to a newly attached viewer, oldest first.
+func (s *Server) sendActiveAnnouncements(host *acp.SessionHost, workspaceID, viewerID, subject string) {
+	now := nowUTC()
+	s.announcementMu.Lock()
+	var pending []acp.AnnouncementMessage
+	for _, a := range s.announcements[workspaceID] {
+		if a.expired(now) {
+			continue
+		}
+		if _, dismissed := a.dismissals[subject]; dismissed {
+			continue
+		}
+		pending = append(pending, a.controlMessage())
+	}
+	s.announcementMu.Unlock()
+
+	for _, msg := range pending {
+		data, _ := json.Marshal(msg)
+		host.SendToViewer(viewerID, data)
+	}
+}
+
+// dismissAnnouncement records that subject dismissed an announcement so it
+// is not replayed to them on reconnect. Returns false if the announcement is
+// unknown (already expired or withdrawn).
+func (s *Server) dismissAnnouncement(workspaceID, announcementID, subject string) bool {
+	s.announcementMu.Lock()
+	defer s.announcementMu.Unlock()
+	for _, a := range s.announcements[workspaceID] {
+		if a.ID == announcementID {
+			if _, ok := a.dismissals[subject]; !ok {
+				a.dismissals[subject] = nowUTC()
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// clearAnnouncements drops all announcements for a deleted workspace.
+func (s *Server) clearAnnouncements(workspaceID string) {
+	s.announcementMu.Lock()
+	delete(s.announcements, workspaceID)
+	s.announcementMu.Unlock()
+}
+
+func pruneExpiredAnnouncements(announcements []*workspaceAnnouncement, now time.Time) []*workspaceAnnouncement {
+	active := announcements[:0:0]
+	for _, a := range announcements {
+		if !a.expired(now) {
+			active = append(active, a)
+		}
+	}
+	return active
+}
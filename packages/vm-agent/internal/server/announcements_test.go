@@ -0,0 +1,224 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/workspace/vm-agent/internal/acp"
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+func newAnnouncementTestServer(t *testing.T) (*Server, *http.ServeMux, string) {
+	t.Helper()
+	validator, key := newWorkspaceCreateJWTValidator(t, "node-1")
+	s := &Server{
+		config: &config.Config{
+			NodeID:                "node-1",
+			AnnouncementMaxActive: 2,
+			AnnouncementMaxBytes:  64,
+		},
+		jwtValidator:    validator,
+		workspaceEvents: make(map[string][]EventRecord),
+		sessionHosts:    make(map[string]*acp.SessionHost),
+	}
+	mux := http.NewServeMux()
+	s.setupRoutes(mux)
+	return s, mux, signWorkspaceCreateNodeToken(t, key, "node-1", "ws-1")
+}
+
+func doAnnouncementRequest(t *testing.T, mux *http.ServeMux, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var payload []byte
+	if body != nil {
+		payload, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-SAM-Workspace-Id", "ws-1")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAnnouncementsCreateListWithdraw(t *testing.T) {
+	t.Parallel()
+	s, mux, token := newAnnouncementTestServer(t)
+
+	rec := doAnnouncementRequest(t, mux, http.MethodPost, "/workspaces/ws-1/announcements", token, map[string]interface{}{
+		"severity":   "warning",
+		"title":      "Maintenance",
+		"message":    "Node restarts at 02:00 UTC",
+		"ttlSeconds": 3600,
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var created announcementResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatalf("decode create response: %v", err)
+	}
+	if created.ID == "" || created.Severity != acp.AnnouncementWarning || created.ExpiresAt == nil {
+		t.Fatalf("unexpected created announcement: %+v", created)
+	}
+
+	if !s.dismissAnnouncement("ws-1", created.ID, "user-1") {
+		t.Fatal("dismissAnnouncement returned false for active announcement")
+	}
+
+	rec = doAnnouncementRequest(t, mux, http.MethodGet, "/workspaces/ws-1/announcements", token, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("list status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var listed struct {
+		Announcements []announcementResponse `json:"announcements"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil {
+		t.Fatalf("decode list response: %v", err)
+	}
+	if len(listed.Announcements) != 1 || len(listed.Announcements[0].Dismissals) != 1 ||
+		listed.Announcements[0].Dismissals[0].Subject != "user-1" {
+		t.Fatalf("unexpected list response: %+v", listed.Announcements)
+	}
+
+	rec = doAnnouncementRequest(t, mux, http.MethodDelete, "/workspaces/ws-1/announcements/"+created.ID, token, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("withdraw status = %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = doAnnouncementRequest(t, mux, http.MethodDelete, "/workspaces/ws-1/announcements/"+created.ID, token, nil)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("second withdraw status = %d, want 404", rec.Code)
+	}
+}
+
+func TestAnnouncementsValidation(t *testing.T) {
+	t.Parallel()
+	_, mux, token := newAnnouncementTestServer(t)
+
+	cases := []struct {
+		name string
+		body map[string]interface{}
+		want int
+	}{
+		{"missing message", map[string]interface{}{"severity": "info"}, http.StatusBadRequest},
+		{"unknown severity", map[string]interface{}{"severity": "urgent", "message": "hi"}, http.StatusBadRequest},
+		{"negative ttl", map[string]interface{}{"message": "hi", "ttlSeconds": -1}, http.StatusBadRequest},
+		{"too large", map[string]interface{}{"message": string(bytes.Repeat([]byte("x"), 65))}, http.StatusRequestEntityTooLarge},
+	}
+	for _, tc := range cases {
+		rec := doAnnouncementRequest(t, mux, http.MethodPost, "/workspaces/ws-1/announcements", token, tc.body)
+		if rec.Code != tc.want {
+			t.Errorf("%s: status = %d, want %d (%s)", tc.name, rec.Code, tc.want, rec.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/workspaces/ws-1/announcements", bytes.NewReader([]byte(`{"message":"hi"}`)))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated status = %d, want 401", rec.Code)
+	}
+}
+
+func TestAnnouncementsEvictOldestAndPruneExpired(t *testing.T) {
+	t.Parallel()
+	s, mux, token := newAnnouncementTestServer(t)
+
+	for _, msg := range []string{"first", "second", "third"} {
+		rec := doAnnouncementRequest(t, mux, http.MethodPost, "/workspaces/ws-1/announcements", token, map[string]interface{}{"message": msg})
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("create %q status = %d", msg, rec.Code)
+		}
+	}
+
+	s.announcementMu.Lock()
+	active := s.announcements["ws-1"]
+	if len(active) != 2 || active[0].Message != "second" || active[1].Message != "third" {
+		s.announcementMu.Unlock()
+		t.Fatalf("expected oldest evicted at limit, got %d announcements", len(active))
+	}
+	past := nowUTC().Add(-time.Second)
+	active[0].ExpiresAt = &past
+	s.announcementMu.Unlock()
+
+	rec := doAnnouncementRequest(t, mux, http.MethodGet, "/workspaces/ws-1/announcements", token, nil)
+	var listed struct {
+		Announcements []announcementResponse `json:"announcements"`
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &listed)
+	if len(listed.Announcements) != 1 || listed.Announcements[0].Message != "third" {
+		t.Fatalf("expected expired announcement pruned, got %+v", listed.Announcements)
+	}
+}
+
+// readUntilAnnouncement reads viewer messages until a workspace_announcement
+// arrives or the deadline passes. Returns nil on timeout.
+func readUntilAnnouncement(t *testing.T, conn *websocket.Conn, wait time.Duration) *acp.AnnouncementMessage {
+	t.Helper()
+	deadline := time.Now().Add(wait)
+	for {
+		conn.SetReadDeadline(deadline)
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return nil
+		}
+		var msg acp.AnnouncementMessage
+		if json.Unmarshal(data, &msg) == nil && msg.Type == acp.MsgAnnouncement {
+			return &msg
+		}
+	}
+}
+
+func TestAnnouncementsReplayedToLateJoinersUntilDismissed(t *testing.T) {
+	s, ts, cookieSessionID := newAgentWSTestServer(t)
+	const (
+		workspaceID = "WS_TEST"
+		sessionID   = "sess-announce"
+	)
+	if _, _, err := s.agentSessions.Create(workspaceID, sessionID, "Chat", ""); err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	s.announcements = map[string][]*workspaceAnnouncement{
+		workspaceID: {{
+			ID:         "ann-1",
+			Severity:   acp.AnnouncementCritical,
+			Message:    "Budget exhausted",
+			CreatedAt:  nowUTC(),
+			dismissals: make(map[string]time.Time),
+		}},
+	}
+
+	conn := dialAgentWS(t, ts, cookieSessionID, workspaceID, sessionID)
+	got := readUntilAnnouncement(t, conn, 5*time.Second)
+	if got == nil || got.ID != "ann-1" || got.Severity != acp.AnnouncementCritical {
+		t.Fatalf("late joiner did not receive announcement, got %+v", got)
+	}
+
+	if err := conn.WriteJSON(acp.DismissAnnouncementMessage{Type: acp.MsgDismissAnnouncement, AnnouncementID: "ann-1"}); err != nil {
+		t.Fatalf("send dismiss: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.announcementMu.Lock()
+		_, dismissed := s.announcements[workspaceID][0].dismissals["test-user"]
+		s.announcementMu.Unlock()
+		if dismissed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("dismissal was not recorded for the authenticated user")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	_ = conn.Close()
+
+	conn = dialAgentWS(t, ts, cookieSessionID, workspaceID, sessionID)
+	defer conn.Close()
+	if got := readUntilAnnouncement(t, conn, 500*time.Millisecond); got != nil {
+		t.Fatalf("dismissed announcement was replayed: %+v", got)
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/workspace/vm-agent/internal/redact"
+)
+
+// Assignment binds a pre-provisioned (warm pool) node to the workspace it
+// will serve. It is the POST /assign request body.
+type Assignment struct {
+	WorkspaceID    string `json:"workspaceId"`
+	BootstrapToken string `json:"bootstrapToken"`
+	Repository     string `json:"repository"`
+	Branch         string `json:"branch"`
+}
+
+func (s *Server) handleAssign(w http.ResponseWriter, r *http.Request) {
+	if !s.requireNodeManagementAuth(w, r, "") {
+		return
+	}
+	if !s.config.PreProvisioned {
+		writeError(w, http.StatusConflict, "node is not pre-provisioned")
+		return
+	}
+
+	var body Assignment
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	body.WorkspaceID = strings.TrimSpace(body.WorkspaceID)
+	body.BootstrapToken = strings.TrimSpace(body.BootstrapToken)
+	body.Repository = strings.TrimSpace(body.Repository)
+	body.Branch = strings.TrimSpace(body.Branch)
+	if body.WorkspaceID == "" {
+		writeError(w, http.StatusBadRequest, "workspaceId is required")
+		return
+	}
+	if body.BootstrapToken == "" {
+		writeError(w, http.StatusBadRequest, "bootstrapToken is required")
+		return
+	}
+
+	if !s.assigned.CompareAndSwap(false, true) {
+		writeError(w, http.StatusConflict, "node is already assigned")
+		return
+	}
+	s.assignments <- body
+	slog.Info("Pre-provisioned node assigned", "workspaceId", body.WorkspaceID, "repository", body.Repository)
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"assigned":    true,
+		"workspaceId": body.WorkspaceID,
+	})
+}
+
+// WaitForAssignment blocks until POST /assign delivers this pre-provisioned
+// node's workspace, then points the node config and the boot workspace at
+// it so bootstrap can run as if the node had been created for it.
+func (s *Server) WaitForAssignment(ctx context.Context) (Assignment, error) {
+	select {
+	case <-ctx.Done():
+		return Assignment{}, ctx.Err()
+	case a := <-s.assignments:
+		redact.Register(a.BootstrapToken)
+		s.config.AssignWorkspace(a.WorkspaceID, a.BootstrapToken, a.Repository, a.Branch)
+		s.acpConfig.WorkspaceID = defaultWorkspaceScope(a.WorkspaceID, s.config.NodeID)
+
+		s.workspaces.PutBoot(a.WorkspaceID, s.bootWorkspaceRuntime())
+		return a, nil
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+func TestAssignBindsPreProvisionedNode(t *testing.T) {
+	t.Parallel()
+	s, mux, key := newDrainTestServer(t, 0)
+	s.config = &config.Config{NodeID: "node-1", PreProvisioned: true}
+	s.assignments = make(chan Assignment, 1)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/assign", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+signWorkspaceCreateNodeToken(t, key, "node-1", ""))
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := post(`{"workspaceId":"ws-1"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("assign without bootstrap token: status = %d, want 400", rec.Code)
+	}
+	if rec := post(`{"workspaceId":"ws-1","bootstrapToken":"tok","repository":"octo/repo","branch":"main"}`); rec.Code != http.StatusAccepted {
+		t.Fatalf("assign: status = %d, want 202 (%s)", rec.Code, rec.Body.String())
+	}
+	if rec := post(`{"workspaceId":"ws-2","bootstrapToken":"tok"}`); rec.Code != http.StatusConflict {
+		t.Fatalf("second assign: status = %d, want 409", rec.Code)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	a, err := s.WaitForAssignment(ctx)
+	if err != nil {
+		t.Fatalf("WaitForAssignment: %v", err)
+	}
+	if a.WorkspaceID != "ws-1" || s.config.WorkspaceID != "ws-1" || s.config.BootstrapToken != "tok" || s.config.Repository != "octo/repo" {
+		t.Fatalf("assignment = %+v, config = %+v", a, s.config)
+	}
+	if s.acpConfig.WorkspaceID != "ws-1" {
+		t.Fatalf("acp workspace = %q, want ws-1", s.acpConfig.WorkspaceID)
+	}
+	if ws, ok := s.workspaces.Get("ws-1"); !ok || ws.Branch != "main" || ws.WorkspaceDir == "" {
+		t.Fatalf("boot workspace not registered: %+v", ws)
+	}
+	if got := s.workspaces.BootID(); got != "ws-1" {
+		t.Fatalf("boot workspace ID = %q, want ws-1", got)
+	}
+}
+
+func TestAssignRejectedWhenNotPreProvisioned(t *testing.T) {
+	t.Parallel()
+	_, mux, key := newDrainTestServer(t, 0)
+
+	req := httptest.NewRequest(http.MethodPost, "/assign", strings.NewReader(`{"workspaceId":"ws-1","bootstrapToken":"tok"}`))
+	req.Header.Set("Authorization", "Bearer "+signWorkspaceCreateNodeToken(t, key, "node-1", ""))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want 409", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/assign", strings.NewReader(`{}`))
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated status = %d, want 401", rec.Code)
+	}
+}
@@ -4,23 +4,30 @@ import (
 	"encoding/json"
 	"log/slog"
 	"net/http"
-	"strings"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/workspace/vm-agent/internal/bootlog"
+	"github.com/workspace/vm-agent/internal/retention"
 )
 
 const bootLogMaxBuffered = 200
 
 // BootLogWSEntry is the JSON structure sent to WebSocket clients.
 type BootLogWSEntry struct {
-	Type      string `json:"type"`
-	Step      string `json:"step,omitempty"`
-	Status    string `json:"status,omitempty"`
-	Message   string `json:"message,omitempty"`
-	Detail    string `json:"detail,omitempty"`
-	Timestamp string `json:"timestamp,omitempty"`
+	Type    string `json:"type"`
+	Step    string `json:"step,omitempty"`
+	Status  string `json:"status,omitempty"`
+	Message string `json:"message,omitempty"`
+	Detail  string `json:"detail,omitempty"`
+	// Progress and ETASeconds are set when the reporter tracks boot progress.
+	Progress   int    `json:"progress,omitempty"`
+	ETASeconds int    `json:"etaSeconds,omitempty"`
+	Timestamp  string `json:"timestamp,omitempty"`
+
+	seq uint64 // identifies buffered entries for retention purges
 }
 
 // BootLogBroadcaster manages a ring buffer of boot log entries and fans out
@@ -30,6 +37,7 @@ type BootLogBroadcaster struct {
 	entries  []BootLogWSEntry
 	clients  map[*websocket.Conn]struct{}
 	complete bool
+	seq      uint64
 }
 
 // NewBootLogBroadcaster creates a new broadcaster.
@@ -45,21 +53,31 @@ func NewBootLogBroadcaster() *BootLogBroadcaster {
 // Nil-safe: no-ops when called on a nil receiver (which can happen when a nil
 // *BootLogBroadcaster is stored in a non-nil Broadcaster interface).
 func (b *BootLogBroadcaster) Broadcast(step, status, message string, detail ...string) {
+	b.BroadcastProgress(step, status, message, bootlog.Progress{}, detail...)
+}
+
+// BroadcastProgress is Broadcast for an entry that carries boot progress.
+// Implements the bootlog.ProgressBroadcaster interface.
+func (b *BootLogBroadcaster) BroadcastProgress(step, status, message string, progress bootlog.Progress, detail ...string) {
 	if b == nil {
 		return
 	}
 	entry := BootLogWSEntry{
-		Type:      "log",
-		Step:      step,
-		Status:    status,
-		Message:   message,
-		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Type:       "log",
+		Step:       step,
+		Status:     status,
+		Message:    message,
+		Progress:   progress.Percent,
+		ETASeconds: progress.ETASeconds,
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
 	}
 	if len(detail) > 0 && detail[0] != "" {
 		entry.Detail = detail[0]
 	}
 
 	b.mu.Lock()
+	b.seq++
+	entry.seq = b.seq
 	// Ring buffer: drop oldest if at capacity.
 	if len(b.entries) >= bootLogMaxBuffered {
 		b.entries = b.entries[1:]
@@ -115,6 +133,24 @@ func (b *BootLogBroadcaster) AddClient(conn *websocket.Conn) {
 	b.clients[conn] = struct{}{}
 }
 
+// EntriesAfter returns the buffered entries newer than cursor, the cursor to
+// pass next time, and whether the boot has completed. Cursor 0 returns the
+// whole buffer. Nil-safe: a nil receiver has no entries.
+func (b *BootLogBroadcaster) EntriesAfter(cursor uint64) ([]BootLogWSEntry, uint64, bool) {
+	if b == nil {
+		return nil, cursor, false
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	var entries []BootLogWSEntry
+	for _, entry := range b.entries {
+		if entry.seq > cursor {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, b.seq, b.complete
+}
+
 // RemoveClient removes a WebSocket connection from the broadcast list.
 // Nil-safe: no-ops when called on a nil receiver.
 func (b *BootLogBroadcaster) RemoveClient(conn *websocket.Conn) {
@@ -148,6 +184,46 @@ func (b *BootLogBroadcaster) MarkComplete() {
 	}
 }
 
+// retentionItems lists the buffered entries for retention policy enforcement.
+func (b *BootLogBroadcaster) retentionItems() []retention.Item {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	items := make([]retention.Item, len(b.entries))
+	for i, entry := range b.entries {
+		items[i] = retention.Item{
+			ID:        strconv.FormatUint(entry.seq, 10),
+			Bytes:     int64(len(entry.Step) + len(entry.Status) + len(entry.Message) + len(entry.Detail)),
+			CreatedAt: retention.ParseTimestamp(entry.Timestamp),
+		}
+	}
+	return items
+}
+
+// deleteEntries drops buffered entries by retention item ID. Clients that
+// already received them are unaffected; late joiners no longer see them.
+func (b *BootLogBroadcaster) deleteEntries(ids []string) int64 {
+	drop := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		drop[id] = struct{}{}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	kept := b.entries[:0]
+	var deleted int64
+	for _, entry := range b.entries {
+		if _, ok := drop[strconv.FormatUint(entry.seq, 10)]; ok {
+			deleted++
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	b.entries = kept
+	return deleted
+}
+
 // BootLogBroadcasterManager manages per-workspace broadcasters. On multi-workspace
 // nodes, each workspace gets its own broadcaster so logs don't mix. For the
 // boot-time bootstrap path, the default workspace ID is used as the key.
@@ -213,10 +289,7 @@ func (s *Server) handleBootLogWS(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Determine which workspace's boot logs to stream.
-	workspaceID := strings.TrimSpace(r.URL.Query().Get("workspace"))
-	if workspaceID == "" {
-		workspaceID = s.config.WorkspaceID
-	}
+	workspaceID := s.workspaces.Resolve(r.URL.Query().Get("workspace"))
 	if workspaceID == "" {
 		http.Error(w, "workspace query parameter required", http.StatusBadRequest)
 		return
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/workspace/vm-agent/internal/persistence"
+)
+
+const (
+	// callbackOutboxBatchSize is how many queued callbacks are read per batch.
+	callbackOutboxBatchSize = 50
+	// maxCallbackOutboxBatches bounds one delivery run, so a large backlog
+	// after a long outage drains over several heartbeats.
+	maxCallbackOutboxBatches = 10
+)
+
+// deliverOutboxCallbacks redelivers callbacks persisted in the callback
+// outbox, oldest first. Delivery stops at the first callback the control
+// plane cannot accept yet, so ordering is kept and an outage that resumes
+// mid-run does not burn every entry's attempts. Callbacks rejected with a
+// 4xx, or that reach CallbackOutboxMaxAttempts, are abandoned.
+func (s *Server) deliverOutboxCallbacks() {
+	if s.store == nil || s.config.CallbackOutboxMaxEntries <= 0 || s.config.ControlPlaneURL == "" {
+		return
+	}
+
+	delivered := 0
+	defer func() {
+		if delivered > 0 {
+			slog.Info("Delivered queued callbacks to control plane", "callbacks", delivered)
+		}
+	}()
+
+	for batch := 0; batch < maxCallbackOutboxBatches; batch++ {
+		callbacks, err := s.store.PendingCallbacks(callbackOutboxBatchSize)
+		if err != nil {
+			slog.Warn("persistence: list pending callbacks failed", "error", err)
+			return
+		}
+		for _, cb := range callbacks {
+			statusCode, err := s.postOutboxCallback(cb)
+			if err == nil && statusCode >= 500 {
+				err = fmt.Errorf("control plane returned HTTP %d", statusCode)
+			}
+			if err != nil {
+				if limit := s.config.CallbackOutboxMaxAttempts; limit > 0 && cb.Attempts+1 >= limit {
+					slog.Warn("Abandoning queued callback after repeated failures",
+						"workspace", cb.WorkspaceID, "path", cb.Path, "attempts", cb.Attempts+1, "error", err)
+					if err := s.store.DeleteCallback(cb.ID); err != nil {
+						slog.Warn("persistence: delete callback failed", "error", err)
+						return
+					}
+					continue
+				}
+				if err := s.store.RecordCallbackFailure(cb.ID, err.Error()); err != nil {
+					slog.Warn("persistence: record callback failure failed", "error", err)
+				}
+				slog.Warn("Queued callback delivery failed; will retry after next heartbeat",
+					"workspace", cb.WorkspaceID, "path", cb.Path, "error", err)
+				return
+			}
+			if statusCode >= 400 {
+				slog.Warn("Queued callback rejected by control plane, dropping",
+					"workspace", cb.WorkspaceID, "path", cb.Path, "statusCode", statusCode)
+			} else {
+				delivered++
+			}
+			if err := s.store.DeleteCallback(cb.ID); err != nil {
+				slog.Warn("persistence: delete callback failed", "error", err)
+				return
+			}
+		}
+		if len(callbacks) < callbackOutboxBatchSize {
+			return
+		}
+	}
+}
+
+// postOutboxCallback posts one queued callback with the workspace callback
+// token, falling back to the node token. Returns the HTTP status code, or an
+// error when the control plane could not be reached.
+func (s *Server) postOutboxCallback(cb persistence.OutboxCallback) (int, error) {
+	token := s.workspaceCallbackToken(cb.WorkspaceID)
+	if token == "" {
+		token = s.getCallbackToken()
+	}
+
+	url := strings.TrimRight(s.config.ControlPlaneURL, "/") + cb.Path
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(cb.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.controlPlaneHTTPClient(0).Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	return resp.StatusCode, nil
+}
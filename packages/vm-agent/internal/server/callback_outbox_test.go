@@ -0,0 +1,102 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/workspace/vm-agent/internal/persistence"
+)
+
+func TestDeliverOutboxCallbacks_RetriesInOrderAndDropsRejected(t *testing.T) {
+	var mu sync.Mutex
+	var delivered []string
+	var unavailable atomic.Bool
+	controlPlane := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer callback-token" {
+			t.Errorf("unexpected Authorization %q", r.Header.Get("Authorization"))
+		}
+		if unavailable.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path == "/api/gone" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		mu.Lock()
+		delivered = append(delivered, r.URL.Path)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(controlPlane.Close)
+
+	store, err := persistence.Open(filepath.Join(t.TempDir(), "vm-agent.db"))
+	if err != nil {
+		t.Fatalf("Open persistence store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	s := newWorkspaceCreateServer(t, controlPlane.URL, nil)
+	s.store = store
+	s.callbackToken = "callback-token"
+	s.config.CallbackOutboxMaxEntries = 100
+	s.config.CallbackOutboxMaxAttempts = 3
+	for _, path := range []string{"/api/first", "/api/gone", "/api/second"} {
+		if err := store.EnqueueCallback("ws-1", path, []byte(`{}`), 100); err != nil {
+			t.Fatalf("EnqueueCallback: %v", err)
+		}
+	}
+
+	unavailable.Store(true)
+	s.deliverOutboxCallbacks()
+	pending, _ := store.PendingCallbacks(10)
+	if len(pending) != 3 || pending[0].Attempts != 1 || pending[1].Attempts != 0 {
+		t.Fatalf("pending after outage = %+v, want 3 with only the head attempted", pending)
+	}
+
+	unavailable.Store(false)
+	s.deliverOutboxCallbacks()
+	mu.Lock()
+	got := delivered
+	mu.Unlock()
+	if len(got) != 2 || got[0] != "/api/first" || got[1] != "/api/second" {
+		t.Fatalf("delivered = %v, want [/api/first /api/second]", got)
+	}
+	if pending, _ := store.PendingCallbacks(10); len(pending) != 0 {
+		t.Fatalf("pending after delivery = %+v, want none", pending)
+	}
+}
+
+func TestDeliverOutboxCallbacks_AbandonsAfterMaxAttempts(t *testing.T) {
+	controlPlane := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	t.Cleanup(controlPlane.Close)
+
+	store, err := persistence.Open(filepath.Join(t.TempDir(), "vm-agent.db"))
+	if err != nil {
+		t.Fatalf("Open persistence store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	s := newWorkspaceCreateServer(t, controlPlane.URL, nil)
+	s.store = store
+	s.config.CallbackOutboxMaxEntries = 100
+	s.config.CallbackOutboxMaxAttempts = 2
+	if err := store.EnqueueCallback("ws-1", "/api/usage", []byte(`{}`), 100); err != nil {
+		t.Fatalf("EnqueueCallback: %v", err)
+	}
+
+	s.deliverOutboxCallbacks()
+	if pending, _ := store.PendingCallbacks(10); len(pending) != 1 {
+		t.Fatalf("pending after first failure = %d, want 1", len(pending))
+	}
+	s.deliverOutboxCallbacks()
+	if pending, _ := store.PendingCallbacks(10); len(pending) != 0 {
+		t.Fatalf("pending after max attempts = %d, want 0", len(pending))
+	}
+}
@@ -0,0 +1,319 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// checkpointRefPrefix namespaces prompt checkpoints. Refs outside refs/heads
+// and refs/tags are hidden from branch and tag listings and are never pushed
+// by a plain `git push`.
+const checkpointRefPrefix = "refs/sam/checkpoints/"
+
+// checkpointRmBatch bounds the paths passed to one rm invocation during a
+// rollback.
+const checkpointRmBatch = 200
+
+var checkpointIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+// promptCheckpoint is a snapshot of a session's working tree taken before a
+// prompt ran.
+type promptCheckpoint struct {
+	ID        string    `json:"id"`
+	CommitSHA string    `json:"commitSha"`
+	MessageID string    `json:"messageId,omitempty"`
+	Reason    string    `json:"reason"` // "prompt" or "rollback"
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// checkpointGit runs git at the root of the session's worktree, so a session
+// scoped to a subdirectory still checkpoints the whole tree.
+func (s *Server) checkpointGit(workspaceID, sessionID string) (git func(args ...string) (string, error), containerID, user, workDir string, err error) {
+	containerID, workDir, user, err = s.resolveContainerForWorkspace(workspaceID)
+	if err != nil {
+		return nil, "", "", "", err
+	}
+	if session, ok := s.agentSessions.Get(workspaceID, sessionID); ok && session.WorkDir != "" {
+		workDir, _, err = s.resolveSessionWorkDir(context.Background(), workspaceID, containerID, user, workDir, session.WorkDir, "")
+		if err != nil {
+			return nil, "", "", "", err
+		}
+	}
+	git = func(args ...string) (string, error) {
+		return s.runWorkspaceGitCommand(containerID, workDir, user, args...)
+	}
+	return git, containerID, user, workDir, nil
+}
+
+// checkpointBeforePrompt is the SessionHost OnPromptStart hook. Failures are
+// recorded as events and never hold up the prompt.
+func (s *Server) checkpointBeforePrompt(workspaceID, sessionID, messageID string) {
+	if s.config.CheckpointMaxPerSession <= 0 || !checkpointIDPattern.MatchString(sessionID) {
+		return
+	}
+	git, containerID, user, workDir, err := s.checkpointGit(workspaceID, sessionID)
+	if err == nil {
+		// Waiting on another git operation delays the prompt, so give up
+		// after a single git command's timeout.
+		ctx, cancel := context.WithTimeout(context.Background(), s.config.GitExecTimeout)
+		err = s.withGitLock(ctx, workspaceID, containerID, user, workDir, "checkpoint", func() error {
+			_, err := s.createCheckpoint(git, sessionID, "prompt", messageID)
+			return err
+		})
+		cancel()
+	}
+	if err != nil {
+		slog.Warn("Prompt checkpoint failed", "workspace", workspaceID, "session", sessionID, "error", err)
+		s.appendNodeEvent(workspaceID, "warn", "agent.checkpoint_failed", "Could not checkpoint the working tree before the prompt", map[string]interface{}{
+			"sessionId": sessionID,
+			"error":     err.Error(),
+		})
+	}
+}
+
+// createCheckpoint commits the full working tree, honoring .gitignore, to a
+// new checkpoint ref without touching the user's branch, working tree, or
+// staged changes, then prunes the session's oldest checkpoints.
+func (s *Server) createCheckpoint(git func(args ...string) (string, error), sessionID, reason, messageID string) (*promptCheckpoint, error) {
+	// snapshotWorkingTree rewrites the index, so checkpoints of one node must
+	// not interleave.
+	s.checkpointMu.Lock()
+	defer s.checkpointMu.Unlock()
+
+	head, err := git("rev-parse", "--verify", "HEAD")
+	if err != nil {
+		return nil, fmt.Errorf("no commit to base a checkpoint on: %s", head)
+	}
+	tree, err := snapshotWorkingTree(git)
+	if err != nil {
+		return nil, err
+	}
+	now := nowUTC()
+	// Checkpoint IDs are creation times in milliseconds, so refnames sort by
+	// age. Bump past the newest to keep them unique within a millisecond.
+	id := now.UnixMilli()
+	if existing, err := listCheckpoints(git, sessionID); err == nil && len(existing) > 0 {
+		if newest, err := strconv.ParseInt(existing[0].ID, 10, 64); err == nil && newest >= id {
+			id = newest + 1
+		}
+	}
+	checkpoint := &promptCheckpoint{
+		ID:        strconv.FormatInt(id, 10),
+		MessageID: messageID,
+		Reason:    reason,
+		CreatedAt: now,
+	}
+	message := fmt.Sprintf("sam checkpoint: %s\n\nSAM-Reason: %s\nSAM-Prompt: %s", reason, reason, messageID)
+	checkpoint.CommitSHA, err = git("commit-tree", tree, "-p", head, "-m", message)
+	if err != nil {
+		return nil, fmt.Errorf("git commit-tree failed: %s", checkpoint.CommitSHA)
+	}
+	if output, err := git("update-ref", checkpointRefPrefix+sessionID+"/"+checkpoint.ID, checkpoint.CommitSHA); err != nil {
+		return nil, fmt.Errorf("git update-ref failed: %s", output)
+	}
+	s.pruneCheckpoints(git, sessionID)
+	return checkpoint, nil
+}
+
+// pruneCheckpoints deletes all but the newest CheckpointMaxPerSession
+// checkpoints of a session.
+func (s *Server) pruneCheckpoints(git func(args ...string) (string, error), sessionID string) {
+	checkpoints, err := listCheckpoints(git, sessionID)
+	if err != nil {
+		return
+	}
+	for i := s.config.CheckpointMaxPerSession; i < len(checkpoints); i++ {
+		_, _ = git("update-ref", "-d", checkpointRefPrefix+sessionID+"/"+checkpoints[i].ID)
+	}
+}
+
+// listCheckpoints returns a session's checkpoints, newest first.
+func listCheckpoints(git func(args ...string) (string, error), sessionID string) ([]promptCheckpoint, error) {
+	output, err := git("for-each-ref", "--format=%(refname:lstrip=4)%09%(objectname)%09%(contents:body)%00",
+		checkpointRefPrefix+sessionID+"/")
+	if err != nil {
+		return nil, fmt.Errorf("git for-each-ref failed: %s", output)
+	}
+	checkpoints := []promptCheckpoint{}
+	for _, record := range strings.Split(output, "\x00") {
+		fields := strings.SplitN(strings.TrimSpace(record), "\t", 3)
+		if len(fields) < 2 {
+			continue
+		}
+		millis, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		checkpoint := promptCheckpoint{ID: fields[0], CommitSHA: fields[1], CreatedAt: time.UnixMilli(millis).UTC()}
+		if len(fields) == 3 {
+			for _, line := range strings.Split(fields[2], "\n") {
+				if value, ok := strings.CutPrefix(line, "SAM-Reason: "); ok {
+					checkpoint.Reason = strings.TrimSpace(value)
+				} else if value, ok := strings.CutPrefix(line, "SAM-Prompt: "); ok {
+					checkpoint.MessageID = strings.TrimSpace(value)
+				}
+			}
+		}
+		checkpoints = append(checkpoints, checkpoint)
+	}
+	sort.Slice(checkpoints, func(i, j int) bool { return checkpoints[i].CreatedAt.After(checkpoints[j].CreatedAt) })
+	return checkpoints, nil
+}
+
+// restoreCheckpoint makes the working tree match a checkpoint: files it
+// holds are restored, and files created since are deleted. Ignored files,
+// HEAD, and the index are left alone.
+func (s *Server) restoreCheckpoint(ctx context.Context, git func(args ...string) (string, error), containerID, user, commitSHA string) error {
+	// Paths are compared and deleted relative to the repository root, since
+	// the session may run in a subdirectory.
+	top, err := git("rev-parse", "--show-toplevel")
+	if err != nil {
+		return fmt.Errorf("git rev-parse failed: %s", top)
+	}
+	current, err := git("ls-files", "-z", "--full-name", "--cached", "--others", "--exclude-standard", ":/")
+	if err != nil {
+		return fmt.Errorf("git ls-files failed: %s", current)
+	}
+	saved, err := git("ls-tree", "-r", "-z", "--full-tree", "--name-only", commitSHA)
+	if err != nil {
+		return fmt.Errorf("git ls-tree failed: %s", saved)
+	}
+	keep := make(map[string]bool)
+	for _, path := range strings.Split(saved, "\x00") {
+		if path != "" {
+			keep[path] = true
+		}
+	}
+	var extra []string
+	for _, path := range strings.Split(current, "\x00") {
+		if path != "" && !keep[path] {
+			extra = append(extra, path)
+		}
+	}
+	for start := 0; start < len(extra); start += checkpointRmBatch {
+		end := min(start+checkpointRmBatch, len(extra))
+		args := append([]string{"rm", "-f", "--"}, extra[start:end]...)
+		if _, stderr, err := s.execInContainer(ctx, containerID, user, top, args...); err != nil {
+			return fmt.Errorf("remove files created after the checkpoint: %s", firstNonEmpty(stderr, err.Error()))
+		}
+	}
+	if len(keep) > 0 {
+		if output, err := git("restore", "--source="+commitSHA, "--worktree", "--", ":/"); err != nil {
+			return fmt.Errorf("git restore failed: %s", output)
+		}
+	}
+	return nil
+}
+
+// handleListCheckpoints lists an agent session's checkpoints, newest first.
+// GET /workspaces/{workspaceId}/agent-sessions/{sessionId}/checkpoints
+func (s *Server) handleListCheckpoints(w http.ResponseWriter, r *http.Request) {
+	workspaceID, sessionID, ok := s.checkpointRequest(w, r)
+	if !ok {
+		return
+	}
+	git, _, _, _, err := s.checkpointGit(workspaceID, sessionID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	checkpoints, err := listCheckpoints(git, sessionID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"checkpoints": checkpoints})
+}
+
+// handleRollbackCheckpoint restores the session's working tree to a
+// checkpoint. The current state is checkpointed first, so a rollback can
+// itself be rolled back.
+// POST /workspaces/{workspaceId}/agent-sessions/{sessionId}/rollback?checkpoint=<id>
+func (s *Server) handleRollbackCheckpoint(w http.ResponseWriter, r *http.Request) {
+	workspaceID, sessionID, ok := s.checkpointRequest(w, r)
+	if !ok {
+		return
+	}
+	checkpointID := strings.TrimSpace(r.URL.Query().Get("checkpoint"))
+	if !checkpointIDPattern.MatchString(checkpointID) {
+		writeError(w, http.StatusBadRequest, "checkpoint is required")
+		return
+	}
+	s.sessionHostMu.Lock()
+	host := s.sessionHosts[workspaceID+":"+sessionID]
+	s.sessionHostMu.Unlock()
+	if host != nil && host.IsPrompting() {
+		writeError(w, http.StatusConflict, "cannot roll back while a prompt is running")
+		return
+	}
+
+	git, containerID, user, workDir, err := s.checkpointGit(workspaceID, sessionID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	release, err := s.lockWorkspaceGit(r.Context(), workspaceID, containerID, user, workDir, "checkpoint rollback")
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	defer release()
+	commitSHA, err := git("rev-parse", "--verify", "--quiet", checkpointRefPrefix+sessionID+"/"+checkpointID+"^{commit}")
+	if err != nil || commitSHA == "" {
+		writeError(w, http.StatusNotFound, "checkpoint not found")
+		return
+	}
+	safety, err := s.createCheckpoint(git, sessionID, "rollback", "")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("checkpoint current state: %v", err))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.config.GitExecTimeout)
+	defer cancel()
+	if err := s.restoreCheckpoint(ctx, git, containerID, user, commitSHA); err != nil {
+		slog.Error("Checkpoint rollback failed", "workspace", workspaceID, "session", sessionID, "checkpoint", checkpointID, "error", err)
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	s.appendNodeEvent(workspaceID, "info", "agent.checkpoint_restored", "Working tree rolled back to a checkpoint", map[string]interface{}{
+		"sessionId":    sessionID,
+		"checkpointId": checkpointID,
+		"undoId":       safety.ID,
+	})
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"checkpoint": checkpointID,
+		"commitSha":  commitSHA,
+		"undo":       safety,
+	})
+}
+
+// checkpointRequest validates and authorizes a checkpoint request.
+func (s *Server) checkpointRequest(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	workspaceID := r.PathValue("workspaceId")
+	sessionID := r.PathValue("sessionId")
+	if workspaceID == "" || sessionID == "" {
+		writeError(w, http.StatusBadRequest, "workspaceId and sessionId are required")
+		return "", "", false
+	}
+	if !s.requireWorkspaceRequestAuth(w, r, workspaceID) {
+		return "", "", false
+	}
+	if !checkpointIDPattern.MatchString(sessionID) {
+		writeError(w, http.StatusBadRequest, "invalid sessionId")
+		return "", "", false
+	}
+	if _, ok := s.agentSessions.Get(workspaceID, sessionID); !ok {
+		writeError(w, http.StatusNotFound, "session not found")
+		return "", "", false
+	}
+	return workspaceID, sessionID, true
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/agentsessions"
+)
+
+func TestCheckpointBeforePromptAndRollback(t *testing.T) {
+	srv, workspaceID, repo, cookie := newFileHandlerTestServer(t)
+	srv.config.GitExecTimeout = 10 * time.Second
+	srv.config.CheckpointMaxPerSession = 2
+	srv.workspaceEvents = make(map[string][]EventRecord)
+	srv.agentSessions = agentsessions.NewManager()
+	if _, _, err := srv.agentSessions.Create(workspaceID, "sess-1", "Refactor", ""); err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+
+	runGit := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v (%s)", args, err, out)
+		}
+		return string(out)
+	}
+	writeFile := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(repo, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	readFile := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(repo, name))
+		if err != nil {
+			return "<missing>"
+		}
+		return string(data)
+	}
+	runGit("init", "-q")
+	runGit("config", "user.name", "Test")
+	runGit("config", "user.email", "test@example.com")
+	writeFile(".gitignore", "build.log\n")
+	writeFile("main.go", "package main\n")
+	runGit("add", ".")
+	runGit("commit", "-q", "-m", "init")
+	writeFile("notes.txt", "draft\n")
+
+	srv.checkpointBeforePrompt(workspaceID, "sess-1", "msg-1")
+
+	// The agent edits a file, deletes the draft, and creates a new file. An
+	// ignored file is left alone by a rollback.
+	writeFile("main.go", "package main\n\nfunc main() {}\n")
+	if err := os.Remove(filepath.Join(repo, "notes.txt")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile("extra.go", "package main\n")
+	writeFile("build.log", "ok\n")
+	if status := runGit("status", "--porcelain"); status == "" {
+		t.Fatal("expected a dirty tree")
+	}
+
+	request := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.SetPathValue("workspaceId", workspaceID)
+		req.SetPathValue("sessionId", "sess-1")
+		req.AddCookie(&http.Cookie{Name: "session", Value: cookie})
+		rec := httptest.NewRecorder()
+		if method == http.MethodGet {
+			srv.handleListCheckpoints(rec, req)
+		} else {
+			srv.handleRollbackCheckpoint(rec, req)
+		}
+		return rec
+	}
+
+	rec := request(http.MethodGet, "/workspaces/"+workspaceID+"/agent-sessions/sess-1/checkpoints")
+	var listed struct {
+		Checkpoints []promptCheckpoint `json:"checkpoints"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("list status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if len(listed.Checkpoints) != 1 || listed.Checkpoints[0].MessageID != "msg-1" || listed.Checkpoints[0].Reason != "prompt" {
+		t.Fatalf("checkpoints = %+v", listed.Checkpoints)
+	}
+	checkpointID := listed.Checkpoints[0].ID
+
+	rec = request(http.MethodPost, "/workspaces/"+workspaceID+"/agent-sessions/sess-1/rollback?checkpoint="+checkpointID)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("rollback status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if got := readFile("main.go"); got != "package main\n" {
+		t.Errorf("main.go = %q", got)
+	}
+	if got := readFile("notes.txt"); got != "draft\n" {
+		t.Errorf("notes.txt = %q", got)
+	}
+	if got := readFile("extra.go"); got != "<missing>" {
+		t.Errorf("extra.go = %q, want it removed", got)
+	}
+	if got := readFile("build.log"); got != "ok\n" {
+		t.Errorf("ignored build.log = %q", got)
+	}
+	if count := runGit("rev-list", "--count", "HEAD"); count != "1\n" {
+		t.Errorf("rollback moved HEAD: %s commits", count)
+	}
+
+	// The rollback checkpointed the agent's state first, so it can be undone.
+	var resp struct {
+		Undo promptCheckpoint `json:"undo"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Undo.Reason != "rollback" {
+		t.Fatalf("rollback response = %s", rec.Body.String())
+	}
+	rec = request(http.MethodPost, "/workspaces/"+workspaceID+"/agent-sessions/sess-1/rollback?checkpoint="+resp.Undo.ID)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("undo status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if got := readFile("extra.go"); got != "package main\n" {
+		t.Errorf("extra.go after undo = %q", got)
+	}
+
+	// Only the newest CheckpointMaxPerSession checkpoints are kept.
+	rec = request(http.MethodGet, "/workspaces/"+workspaceID+"/agent-sessions/sess-1/checkpoints")
+	if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil || len(listed.Checkpoints) != 2 {
+		t.Fatalf("checkpoints after pruning = %s", rec.Body.String())
+	}
+	if rec := request(http.MethodPost, "/workspaces/"+workspaceID+"/agent-sessions/sess-1/rollback?checkpoint="+checkpointID); rec.Code != http.StatusNotFound {
+		t.Fatalf("pruned checkpoint status = %d, want 404", rec.Code)
+	}
+}
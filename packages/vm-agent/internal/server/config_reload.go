@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/workspace/vm-agent/internal/acp"
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+// ReloadConfig applies reloadable settings (see config.ReloadableSettings)
+// and returns the names it applied. Sessions and workspaces created
+// afterwards use the new values; running ones keep the values they started
+// with. Nothing is applied if any value is invalid.
+//
+// s.config is never written: the settings are applied to a copy of the
+// current snapshot, which then replaces it, so readers of currentSettings
+// never see a half-applied reload.
+func (s *Server) ReloadConfig(values map[string]string) ([]string, error) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	next := *s.currentSettings()
+	applied, err := next.ApplySettings(values)
+	if err != nil {
+		return nil, err
+	}
+	s.settings.Store(&next)
+	slog.Info("Reloaded settings", "settings", applied)
+	return applied, nil
+}
+
+// currentSettings returns the snapshot holding the current reloadable
+// settings. Only the fields config.ReloadableSettings names are kept up to
+// date in it; read everything else from s.config. The snapshot must not be
+// modified.
+func (s *Server) currentSettings() *config.Config {
+	if settings := s.settings.Load(); settings != nil {
+		return settings
+	}
+	return s.config
+}
+
+// workspaceConfig returns a copy of the node config with the current
+// reloadable settings applied.
+func (s *Server) workspaceConfig() config.Config {
+	cfg := *s.config
+	cfg.CopySettings(s.currentSettings())
+	return cfg
+}
+
+// sessionHostConfig returns the configuration of a new SessionHost running
+// on gateway, with the current reloadable settings applied.
+func (s *Server) sessionHostConfig(gateway acp.GatewayConfig) acp.SessionHostConfig {
+	settings := s.currentSettings()
+	gateway.PromptTimeout = effectivePromptTimeout(settings)
+	gateway.PromptTimeoutOverrideMax = settings.ACPPromptTimeoutOverrideMax
+	return acp.SessionHostConfig{
+		GatewayConfig:         gateway,
+		MessageBufferSize:     settings.ACPMessageBufferSize,
+		ViewerSendBuffer:      settings.ACPViewerSendBuffer,
+		StderrBufferBytes:     settings.ACPStderrBufferBytes,
+		NotifSerializeTimeout: s.config.ACPNotifSerializeTimeout,
+		MaxQueuedPrompts:      s.config.ACPMaxQueuedPrompts,
+		MaxCompanionAgents:    s.config.ACPMaxCompanionAgents,
+		ViewerAckInterval:     s.config.ACPViewerAckInterval,
+		PermissionTimeout:     s.config.ACPPermissionTimeout,
+	}
+}
+
+// ReloadConfigFile re-reads SettingsOverridePath and applies it. The agent
+// calls it on SIGHUP.
+func (s *Server) ReloadConfigFile() ([]string, error) {
+	values, err := config.LoadSettingsFile(s.config.SettingsOverridePath)
+	if err != nil {
+		return nil, err
+	}
+	return s.ReloadConfig(values)
+}
+
+// handleReloadConfig applies settings pushed by the control plane.
+func (s *Server) handleReloadConfig(w http.ResponseWriter, r *http.Request) {
+	if !s.requireNodeManagementAuth(w, r, "") {
+		return
+	}
+
+	var body struct {
+		Settings map[string]string `json:"settings"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if len(body.Settings) == 0 {
+		writeError(w, http.StatusBadRequest, "settings is required")
+		return
+	}
+
+	applied, err := s.ReloadConfig(body.Settings)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":      err.Error(),
+			"reloadable": config.ReloadableSettings(),
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"applied": applied,
+	})
+}
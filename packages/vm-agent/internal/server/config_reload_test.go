@@ -0,0 +1,97 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReloadConfigAppliesToNewSessions(t *testing.T) {
+	t.Parallel()
+	s, mux, key := newDrainTestServer(t, 0)
+	s.config.ACPPromptTimeout = time.Hour
+	s.config.ACPMessageBufferSize = 5000
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/config", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+signWorkspaceCreateNodeToken(t, key, "node-1", ""))
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	unauthenticated := httptest.NewRecorder()
+	mux.ServeHTTP(unauthenticated, httptest.NewRequest(http.MethodPost, "/config", strings.NewReader(`{"settings":{"ACP_PROMPT_TIMEOUT":"2h"}}`)))
+	if unauthenticated.Code != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated reload: status = %d, want 401", unauthenticated.Code)
+	}
+
+	if rec := post(`{"settings":{"ACP_PROMPT_TIMEOUT":"2h","CALLBACK_TOKEN":"x"}}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("reload of identity setting: status = %d, want 400", rec.Code)
+	}
+	if got := s.sessionHostConfig(s.acpConfig).PromptTimeout; got != time.Hour {
+		t.Fatalf("rejected reload changed prompt timeout to %s", got)
+	}
+
+	rec := post(`{"settings":{"ACP_PROMPT_TIMEOUT":"2h","ACP_MESSAGE_BUFFER_SIZE":"100"}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reload: status = %d, want 200 (%s)", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Applied []string `json:"applied"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || len(resp.Applied) != 2 {
+		t.Fatalf("response = %s", rec.Body.String())
+	}
+	hostCfg := s.sessionHostConfig(s.acpConfig)
+	if hostCfg.PromptTimeout != 2*time.Hour || hostCfg.MessageBufferSize != 100 {
+		t.Fatalf("prompt timeout = %s, message buffer = %d after reload", hostCfg.PromptTimeout, hostCfg.MessageBufferSize)
+	}
+	if s.config.ACPMessageBufferSize != 5000 {
+		t.Fatalf("reload wrote s.config in place: message buffer = %d", s.config.ACPMessageBufferSize)
+	}
+}
+
+// Reloads race with provisioning; run with -race.
+func TestReloadConfigConcurrentWithWorkspaceBootstrapConfig(t *testing.T) {
+	t.Parallel()
+	s, _, _ := newDrainTestServer(t, 0)
+	s.config.AdditionalFeatures = `{"a":{}}`
+	runtime := &WorkspaceRuntime{ID: "ws-1", Repository: "org/repo"}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := range 200 {
+			features := `{"a":{}}`
+			if i%2 == 1 {
+				features = `{"b":{"version":"1"}}`
+			}
+			if _, err := s.ReloadConfig(map[string]string{"ADDITIONAL_FEATURES": features, "PTY_OUTPUT_BUFFER_SIZE": strconv.Itoa(i + 1)}); err != nil {
+				t.Errorf("ReloadConfig: %v", err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for range 200 {
+			cfg := s.workspaceBootstrapConfig(runtime, "cb-token")
+			if cfg.AdditionalFeatures != `{"a":{}}` && cfg.AdditionalFeatures != `{"b":{"version":"1"}}` {
+				t.Errorf("workspace config saw torn features %q", cfg.AdditionalFeatures)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	if cfg := s.workspaceBootstrapConfig(runtime, "cb-token"); cfg.AdditionalFeatures != `{"b":{"version":"1"}}` || cfg.PTYOutputBufferSize != 200 {
+		t.Fatalf("after reloads: features = %q, PTY buffer = %d", cfg.AdditionalFeatures, cfg.PTYOutputBufferSize)
+	}
+}
@@ -45,10 +45,10 @@ func newFileHandlerTestServer(t *testing.T) (srv *Server, workspaceID, tmpDir, s
 		config:         cfg,
 		sessionManager: sm,
 		done:           make(chan struct{}),
-		workspaces: map[string]*WorkspaceRuntime{
-			workspaceID: {ID: workspaceID, Status: "running", WorkspaceDir: tmpDir},
-		},
 	}
+	putWorkspaces(&srv.workspaces, map[string]*WorkspaceRuntime{
+		workspaceID: {ID: workspaceID, Status: "running", WorkspaceDir: tmpDir},
+	})
 	return srv, workspaceID, tmpDir, sess.ID
 }
 
@@ -27,28 +27,31 @@ func newContractTestServer() *Server {
 		BufferSize:   1024,
 	})
 
-	return &Server{
+	s := &Server{
 		config: &config.Config{
 			NodeID: "node-contract-test",
 		},
-		ptyManager: ptyManager,
-		workspaces: map[string]*WorkspaceRuntime{
-			"ws-existing": {
-				ID:         "ws-existing",
-				Repository: "owner/repo",
-				Branch:     "main",
-				Status:     "running",
-				CreatedAt:  time.Now().UTC(),
-				UpdatedAt:  time.Now().UTC(),
-				PTY:        ptyManager,
-			},
-		},
+		ptyManager:          ptyManager,
 		nodeEvents:          make([]EventRecord, 0),
 		workspaceEvents:     map[string][]EventRecord{},
 		agentSessions:       agentsessions.NewManager(),
 		sessionHosts:        make(map[string]*acp.SessionHost),
 		bootLogBroadcasters: NewBootLogBroadcasterManager(),
 	}
+
+	putWorkspaces(&s.workspaces, map[string]*WorkspaceRuntime{
+		"ws-existing": {
+			ID:         "ws-existing",
+			Repository: "owner/repo",
+			Branch:     "main",
+			Status:     "running",
+			CreatedAt:  time.Now().UTC(),
+			UpdatedAt:  time.Now().UTC(),
+			PTY:        ptyManager,
+		},
+	})
+
+	return s
 }
 
 // =============================================================================
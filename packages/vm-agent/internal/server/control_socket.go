@@ -0,0 +1,239 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/acp"
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+// controlSocketIdleShutdownReason is the drain reason of samctl
+// idle-shutdown, the same one the control plane's idle shutdown uses.
+const controlSocketIdleShutdownReason = "idle_timeout"
+
+// newControlSocket builds the local debugging API served on a unix socket for
+// `vm-agent samctl`, or returns nil when it is disabled. Requests are not
+// authenticated: the socket is created root-only, so file permissions are
+// the access check.
+func (s *Server) newControlSocket(cfg *config.Config) *http.Server {
+	if !cfg.ControlSocketEnabled || cfg.ControlSocketPath == "" {
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sessions", s.handleControlListSessions)
+	mux.HandleFunc("GET /sessions/{workspaceId}/{sessionId}/replay", s.handleControlDumpReplay)
+	mux.HandleFunc("POST /workspaces/{workspaceId}/rebuild", s.handleControlRebuild)
+	mux.HandleFunc("GET /boot-logs", s.handleControlBootLogs)
+	mux.HandleFunc("POST /idle-shutdown", s.handleControlIdleShutdown)
+	return &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+}
+
+// startControlSocket serves the local debugging API in the background until
+// Stop. A stale socket left by an earlier process is replaced.
+//
+// The socket is bound inside a fresh 0700 directory and restricted to 0600
+// before it is renamed onto its path, so it is never reachable by other
+// users, not even between bind and chmod.
+func (s *Server) startControlSocket() {
+	if s.controlSocket == nil {
+		return
+	}
+	path := s.config.ControlSocketPath
+	if info, err := os.Lstat(path); err == nil && info.Mode().Type() == fs.ModeSocket {
+		_ = os.Remove(path)
+	}
+	ln, err := listenPrivateUnix(path)
+	if err != nil {
+		slog.Error("Failed to listen on control socket; samctl unavailable", "path", path, "error", err)
+		return
+	}
+	go func() {
+		slog.Info("Starting control socket", "path", path)
+		if err := s.controlSocket.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Control socket stopped", "path", path, "error", err)
+		}
+	}()
+}
+
+func (s *Server) stopControlSocket() {
+	if s.controlSocket == nil {
+		return
+	}
+	if err := s.controlSocket.Close(); err != nil {
+		slog.Warn("Failed to close control socket", "error", err)
+	}
+	_ = os.Remove(s.config.ControlSocketPath)
+}
+
+// listenPrivateUnix listens on a unix socket at path that only its owner can
+// connect to. The socket is created in a private directory beside path and
+// moved into place once its mode is 0600.
+func listenPrivateUnix(path string) (*net.UnixListener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".sam-agent-sock-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "sock")
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	// The bound name moves, so the listener must not unlink it on Close;
+	// stopControlSocket removes the socket instead.
+	ln.SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, 0o600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// controlSessionInfo describes one agent session for samctl sessions.
+type controlSessionInfo struct {
+	WorkspaceID    string `json:"workspaceId"`
+	SessionID      string `json:"sessionId"`
+	Status         string `json:"status"`
+	AgentType      string `json:"agentType,omitempty"`
+	Viewers        int    `json:"viewers"`
+	ReplayMessages int    `json:"replayMessages"`
+	ReplayBytes    int    `json:"replayBytes"`
+}
+
+func (s *Server) handleControlListSessions(w http.ResponseWriter, _ *http.Request) {
+	s.sessionHostMu.Lock()
+	hosts := make(map[string]*acp.SessionHost, len(s.sessionHosts))
+	for key, host := range s.sessionHosts {
+		if host != nil {
+			hosts[key] = host
+		}
+	}
+	s.sessionHostMu.Unlock()
+
+	sessions := make([]controlSessionInfo, 0, len(hosts))
+	for key, host := range hosts {
+		workspaceID, sessionID, _ := strings.Cut(key, ":")
+		messages, bytes := host.ReplayBufferSize()
+		sessions = append(sessions, controlSessionInfo{
+			WorkspaceID:    workspaceID,
+			SessionID:      sessionID,
+			Status:         string(host.Status()),
+			AgentType:      host.AgentType(),
+			Viewers:        host.ViewerCount(),
+			ReplayMessages: messages,
+			ReplayBytes:    bytes,
+		})
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].WorkspaceID != sessions[j].WorkspaceID {
+			return sessions[i].WorkspaceID < sessions[j].WorkspaceID
+		}
+		return sessions[i].SessionID < sessions[j].SessionID
+	})
+	writeJSON(w, http.StatusOK, map[string]interface{}{"sessions": sessions})
+}
+
+// controlReplayMessage is one replay buffer entry for samctl replay. Message
+// holds the raw ACP message, or a JSON string when it is not valid JSON.
+type controlReplayMessage struct {
+	Seq       uint64          `json:"seq"`
+	Timestamp time.Time       `json:"timestamp"`
+	Message   json.RawMessage `json:"message"`
+}
+
+func (s *Server) handleControlDumpReplay(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("workspaceId") + ":" + r.PathValue("sessionId")
+	s.sessionHostMu.Lock()
+	host := s.sessionHosts[key]
+	s.sessionHostMu.Unlock()
+	if host == nil {
+		writeError(w, http.StatusNotFound, "session not found")
+		return
+	}
+
+	buffered := host.BufferedMessages()
+	messages := make([]controlReplayMessage, 0, len(buffered))
+	for _, msg := range buffered {
+		data := json.RawMessage(msg.Data)
+		if !json.Valid(data) {
+			data, _ = json.Marshal(string(msg.Data))
+		}
+		messages = append(messages, controlReplayMessage{Seq: msg.SeqNum, Timestamp: msg.Timestamp, Message: data})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"messages": messages})
+}
+
+func (s *Server) handleControlRebuild(w http.ResponseWriter, r *http.Request) {
+	s.rebuildDevcontainer(w, r.PathValue("workspaceId"))
+}
+
+// handleControlBootLogs returns the buffered boot log of a workspace, the
+// boot-time workspace when none is named. Clients follow the log by passing
+// the returned cursor as ?after= until complete is true.
+func (s *Server) handleControlBootLogs(w http.ResponseWriter, r *http.Request) {
+	workspaceID := s.workspaces.Resolve(r.URL.Query().Get("workspace"))
+	var after uint64
+	if raw := r.URL.Query().Get("after"); raw != "" {
+		parsed, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "after must be a cursor returned by an earlier request")
+			return
+		}
+		after = parsed
+	}
+
+	var broadcaster *BootLogBroadcaster
+	if workspaceID != "" && s.bootLogBroadcasters != nil {
+		broadcaster = s.bootLogBroadcasters.Get(workspaceID)
+	}
+	if broadcaster == nil {
+		writeError(w, http.StatusNotFound, "no boot log for workspace")
+		return
+	}
+	entries, cursor, complete := broadcaster.EntriesAfter(after)
+	if entries == nil {
+		entries = []BootLogWSEntry{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"workspaceId": workspaceID,
+		"entries":     entries,
+		"cursor":      cursor,
+		"complete":    complete,
+	})
+}
+
+// handleControlIdleShutdown starts the drain the control plane runs before
+// an idle shutdown and asks the control plane, through the heartbeat, to tear
+// the node down.
+func (s *Server) handleControlIdleShutdown(w http.ResponseWriter, _ *http.Request) {
+	s.idleShutdownForced.Store(true)
+	state, started := s.beginDrain(controlSocketIdleShutdownReason)
+	if started {
+		go s.runDrain(context.Background(), state)
+	}
+	go s.sendNodeHeartbeat()
+	slog.Info("Idle shutdown forced from control socket")
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"draining":         true,
+		"reason":           state.reason,
+		"shutdownAt":       state.shutdownAt,
+		"countdownSeconds": countdownSeconds(state.shutdownAt),
+	})
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/acp"
+	"github.com/workspace/vm-agent/internal/samctl"
+)
+
+func TestControlSocketServesSamctl(t *testing.T) {
+	heartbeats := make(chan map[string]interface{}, 4)
+	cp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/heartbeat") {
+			var payload map[string]interface{}
+			body, _ := io.ReadAll(r.Body)
+			_ = json.Unmarshal(body, &payload)
+			heartbeats <- payload
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer cp.Close()
+
+	socketDir, err := os.MkdirTemp("", "samctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(socketDir)
+	socket := filepath.Join(socketDir, "agent.sock")
+
+	s, _, _ := newDrainTestServer(t, time.Hour)
+	s.config.ControlPlaneURL = cp.URL
+	s.config.WorkspaceID = "ws-1"
+	s.workspaces.PutBoot("ws-1", s.bootWorkspaceRuntime())
+	s.config.ControlSocketEnabled = true
+	s.config.ControlSocketPath = socket
+	s.errorReporter = newTestErrorReporter()
+	s.bootLogBroadcasters = NewBootLogBroadcasterManager()
+	s.bootLogBroadcasters.GetOrCreate("ws-1").Broadcast("git_clone", "completed", "Cloned repository")
+
+	host := acp.NewSessionHost(acp.SessionHostConfig{
+		GatewayConfig: acp.GatewayConfig{SessionID: "chat-1", WorkspaceID: "ws-1"},
+	})
+	t.Cleanup(host.Stop)
+	s.sessionHosts["ws-1:chat-1"] = host
+
+	s.controlSocket = s.newControlSocket(s.config)
+	s.startControlSocket()
+	defer s.stopControlSocket()
+
+	if info, err := os.Stat(socket); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("control socket = %v, %v; want a 0600 socket", info, err)
+	}
+
+	run := func(args ...string) (string, int) {
+		var stdout, stderr bytes.Buffer
+		code := samctl.Run(append([]string{"-socket", socket}, args...), &stdout, &stderr)
+		return stdout.String() + stderr.String(), code
+	}
+
+	if out, code := run("sessions"); code != 0 || !strings.Contains(out, "ws-1") || !strings.Contains(out, "chat-1") {
+		t.Fatalf("sessions exited %d:\n%s", code, out)
+	}
+	if out, code := run("replay", "ws-1", "chat-1"); code != 0 {
+		t.Fatalf("replay exited %d:\n%s", code, out)
+	}
+	if out, code := run("replay", "ws-1", "missing"); code != 1 || !strings.Contains(out, "session not found") {
+		t.Fatalf("replay of unknown session exited %d:\n%s", code, out)
+	}
+	if out, code := run("logs"); code != 0 || !strings.Contains(out, "Cloned repository") {
+		t.Fatalf("logs exited %d:\n%s", code, out)
+	}
+	if out, code := run("idle-shutdown"); code != 0 || !strings.Contains(out, "idle_timeout") {
+		t.Fatalf("idle-shutdown exited %d:\n%s", code, out)
+	}
+	if state := s.drainStatus(); state == nil || state.reason != "idle_timeout" {
+		t.Fatalf("drain state = %+v, want an idle_timeout drain", state)
+	}
+	select {
+	case payload := <-heartbeats:
+		if payload["idleShutdownRequested"] != true {
+			t.Fatalf("heartbeat = %v, want idleShutdownRequested", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no heartbeat sent after idle-shutdown")
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/workspace/vm-agent/internal/containerruntime"
 	"github.com/workspace/vm-agent/internal/eventstore"
 	"github.com/workspace/vm-agent/internal/logreader"
 	"github.com/workspace/vm-agent/internal/sysinfo"
@@ -75,16 +76,17 @@ func (s *Server) handleDebugPackage(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// 5. Docker ps output
+	// 5. Docker ps output (podman when that is the selected runtime)
+	runtimeCLI := containerruntime.Current().Name()
 	addCommandOutputToTar(ctx, tw, "docker-ps.txt",
-		"docker", "ps", "-a", "--no-trunc")
+		runtimeCLI, "ps", "-a", "--no-trunc")
 
 	// 6. Docker inspect (all containers)
 	addCommandOutputToTar(ctx, tw, "docker-inspect.json",
-		"docker", "inspect", "--format={{json .}}")
+		runtimeCLI, "inspect", "--format={{json .}}")
 	// Fallback: try docker inspect on all containers
 	addCommandOutputToTar(ctx, tw, "docker-inspect-all.json",
-		"sh", "-c", "docker ps -aq | xargs -r docker inspect 2>/dev/null || echo '[]'")
+		"sh", "-c", runtimeCLI+" ps -aq | xargs -r "+runtimeCLI+" inspect 2>/dev/null || echo '[]'")
 
 	// 7. System info snapshot
 	if s.sysInfoCollector != nil {
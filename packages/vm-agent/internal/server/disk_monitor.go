@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/config"
+	"github.com/workspace/vm-agent/internal/diskmon"
+	"github.com/workspace/vm-agent/internal/errorreport"
+)
+
+// diskMonitorCheckTimeout bounds one check, including `docker system df`
+// and a possible image prune.
+const diskMonitorCheckTimeout = 2 * time.Minute
+
+func newDiskMonitor(cfg *config.Config) *diskmon.Monitor {
+	if cfg.DiskMonitorInterval <= 0 || cfg.IsDeploymentMode() {
+		return nil
+	}
+	return diskmon.New(diskmon.Config{
+		Paths:           cfg.DiskMonitorPaths,
+		WarnPercent:     cfg.DiskWarnPercent,
+		CriticalPercent: cfg.DiskCriticalPercent,
+		PrunePercent:    cfg.DiskPrunePercent,
+		PruneCooldown:   cfg.DiskPruneCooldown,
+	})
+}
+
+// startDiskMonitor checks disk usage on startup and then periodically,
+// reporting watermark crossings and prunes.
+func (s *Server) startDiskMonitor() {
+	if s.diskMonitor == nil {
+		return
+	}
+
+	go func() {
+		s.checkDiskUsage()
+
+		ticker := time.NewTicker(s.config.DiskMonitorInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				s.checkDiskUsage()
+			}
+		}
+	}()
+}
+
+// checkDiskUsage runs one disk monitor check. Watermark crossings are recorded
+// as node events and forwarded to the control plane, so a node running out of
+// space is visible before builds start failing with ENOSPC.
+func (s *Server) checkDiskUsage() {
+	ctx, cancel := context.WithTimeout(context.Background(), diskMonitorCheckTimeout)
+	defer cancel()
+
+	report := s.diskMonitor.Check(ctx)
+	for _, transition := range report.Transitions {
+		usage := transition.Usage
+		detail := map[string]interface{}{
+			"mountPath":      usage.MountPath,
+			"usedPercent":    usage.UsedPercent,
+			"availableBytes": usage.AvailableBytes,
+			"totalBytes":     usage.TotalBytes,
+			"previousLevel":  transition.Previous,
+		}
+		if report.Docker != nil {
+			detail["docker"] = report.Docker
+		}
+
+		var level, eventType, message string
+		switch usage.Level {
+		case diskmon.LevelCritical:
+			level, eventType = "error", "node.disk_critical"
+			message = fmt.Sprintf("Disk usage on %s is critical (%.1f%% used)", usage.MountPath, usage.UsedPercent)
+		case diskmon.LevelWarning:
+			level, eventType = "warn", "node.disk_warning"
+			message = fmt.Sprintf("Disk usage on %s is high (%.1f%% used)", usage.MountPath, usage.UsedPercent)
+		default:
+			level, eventType = "info", "node.disk_recovered"
+			message = fmt.Sprintf("Disk usage on %s is back to normal (%.1f%% used)", usage.MountPath, usage.UsedPercent)
+		}
+		slog.Log(ctx, diskEventLogLevel(level), "Disk usage watermark crossed", "mountPath", usage.MountPath, "usedPercent", usage.UsedPercent, "level", usage.Level)
+		s.appendNodeEvent("", level, eventType, message, detail)
+		s.errorReporter.Report(errorreport.ErrorEntry{
+			Level:   level,
+			Message: message,
+			Source:  "disk-monitor",
+			Context: detail,
+		})
+	}
+
+	if prune := report.Prune; prune != nil {
+		detail := map[string]interface{}{
+			"mountPath":      prune.MountPath,
+			"usedPercent":    prune.UsedPercent,
+			"reclaimedBytes": prune.ReclaimedBytes,
+		}
+		if prune.Err != nil {
+			slog.Warn("Disk monitor: image prune failed", "error", prune.Err)
+			detail["error"] = prune.Err.Error()
+			s.appendNodeEvent("", "warn", "node.disk_prune_failed", "Failed to prune dangling Docker images", detail)
+			return
+		}
+		slog.Info("Disk monitor: pruned dangling Docker images", "mountPath", prune.MountPath, "reclaimedBytes", prune.ReclaimedBytes)
+		s.appendNodeEvent("", "info", "node.disk_pruned", "Pruned dangling Docker images", detail)
+	}
+}
+
+func diskEventLogLevel(level string) slog.Level {
+	switch level {
+	case "error":
+		return slog.LevelError
+	case "warn":
+		return slog.LevelWarn
+	default:
+		return slog.LevelInfo
+	}
+}
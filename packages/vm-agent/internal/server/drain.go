@@ -0,0 +1,301 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/workspace/vm-agent/internal/acp"
+	"github.com/workspace/vm-agent/internal/bootlog"
+	"github.com/workspace/vm-agent/internal/bootstrap"
+	"github.com/workspace/vm-agent/internal/messagereport"
+)
+
+// drainState records a node drain in progress. Once set it is never cleared:
+// a draining node only ever proceeds to shutdown.
+type drainState struct {
+	reason     string
+	shutdownAt time.Time
+	done       chan struct{} // closed when the drain phase has finished
+}
+
+// terminalConn is a terminal WebSocket registered to receive node-level
+// notices. writeMu is the connection's write lock shared with its handler.
+type terminalConn struct {
+	conn    *websocket.Conn
+	writeMu *sync.Mutex
+}
+
+// registerTerminalConn tracks a terminal WebSocket so drain notices reach it.
+// A connection that opens while the node is draining is told immediately.
+// The returned func unregisters it.
+func (s *Server) registerTerminalConn(conn *websocket.Conn, writeMu *sync.Mutex) func() {
+	tc := &terminalConn{conn: conn, writeMu: writeMu}
+	s.terminalConnsMu.Lock()
+	if s.terminalConns == nil {
+		s.terminalConns = make(map[*terminalConn]struct{})
+	}
+	s.terminalConns[tc] = struct{}{}
+	s.terminalConnsMu.Unlock()
+
+	if state := s.drainStatus(); state != nil {
+		tc.send(NewShuttingDownMessage(state.reason, state.shutdownAt, countdownSeconds(state.shutdownAt)))
+	}
+
+	return func() {
+		s.terminalConnsMu.Lock()
+		delete(s.terminalConns, tc)
+		s.terminalConnsMu.Unlock()
+	}
+}
+
+func (tc *terminalConn) send(data []byte) {
+	tc.writeMu.Lock()
+	_ = tc.conn.WriteMessage(websocket.TextMessage, data)
+	tc.writeMu.Unlock()
+}
+
+// drainStatus returns the drain in progress, or nil if the node is not
+// draining.
+func (s *Server) drainStatus() *drainState {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+	return s.drain
+}
+
+// refuseWhileDraining writes 503 and returns true if the node is draining.
+// Handlers that start new sessions call it so no work begins that the
+// shutdown would cut off.
+func (s *Server) refuseWhileDraining(w http.ResponseWriter) bool {
+	if s.drainStatus() == nil {
+		return false
+	}
+	writeError(w, http.StatusServiceUnavailable, "node is shutting down")
+	return true
+}
+
+// Drain runs the pre-shutdown drain phase: it warns every PTY and ACP viewer
+// with a countdown, refuses new sessions from then on, flushes the message
+// reporters, and pushes uncommitted work to an autosave branch when
+// WORK_PRESERVATION_ENABLED is set. It returns once the countdown has elapsed or ctx is done, or as
+// soon as that work is finished when no viewer was attached to warn.
+// Concurrent and repeated calls wait for the first drain instead of starting
+// another one.
+func (s *Server) Drain(ctx context.Context, reason string) {
+	state, started := s.beginDrain(reason)
+	if !started {
+		select {
+		case <-state.done:
+		case <-ctx.Done():
+		}
+		return
+	}
+	s.runDrain(ctx, state)
+}
+
+// runDrain performs the drain phase for a state created by beginDrain.
+func (s *Server) runDrain(ctx context.Context, state *drainState) {
+	defer close(state.done)
+	reason := state.reason
+
+	countdown := countdownSeconds(state.shutdownAt)
+	slog.Info("Draining node before shutdown", "reason", reason, "countdownSeconds", countdown)
+	viewers := s.broadcastShutdownNotice(state)
+	s.appendNodeEvent("", "info", "node.draining", "Node draining before shutdown", map[string]interface{}{
+		"reason":           reason,
+		"countdownSeconds": countdown,
+		"viewerCount":      viewers,
+	})
+
+	s.flushAllReporters()
+	// Before work preservation, so anything a hook commits or writes is saved.
+	s.runPreShutdownHooks(ctx)
+	if s.config.WorkPreservationEnabled {
+		s.preserveRunningWorkspaces(ctx, "shutdown")
+	}
+	if viewers == 0 {
+		// Nobody to warn; don't hold up the shutdown for the countdown.
+		return
+	}
+
+	timer := time.NewTimer(time.Until(state.shutdownAt))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// runLifecycleHooks is swapped out in tests.
+var runLifecycleHooks = bootstrap.RunLifecycleHooks
+
+// runPreShutdownHooks runs the preShutdown lifecycle hooks of every running
+// workspace, one workspace at a time. Each hook is bounded by HOOK_TIMEOUT
+// and reported on the workspace's boot log; failures never block shutdown.
+func (s *Server) runPreShutdownHooks(ctx context.Context) {
+	if !s.config.ContainerMode {
+		return
+	}
+	var runtimes []*WorkspaceRuntime
+	s.workspaces.Range(func(_ string, runtime *WorkspaceRuntime) {
+		if runtime.Status == "running" || runtime.Status == "recovery" {
+			runtimes = append(runtimes, runtime)
+		}
+	})
+	sort.Slice(runtimes, func(i, j int) bool { return runtimes[i].ID < runtimes[j].ID })
+
+	for _, runtime := range runtimes {
+		if ctx.Err() != nil {
+			return
+		}
+		callbackToken := s.callbackTokenForWorkspace(runtime.ID)
+		cfg := s.workspaceBootstrapConfig(runtime, callbackToken)
+		var hooks bootstrap.Hooks
+		s.workspaces.View(runtime.ID, func(runtime *WorkspaceRuntime) { hooks = runtime.Hooks })
+
+		reporter := bootlog.New(s.config.ControlPlaneURL, runtime.ID)
+		reporter.SetToken(callbackToken)
+		if broadcaster := s.GetBootLogBroadcasterForWorkspace(runtime.ID); broadcaster != nil {
+			reporter.SetBroadcaster(broadcaster)
+		}
+		reporter.AddSinks(s.bootLogSinks...)
+		runLifecycleHooks(ctx, &cfg, hooks, bootstrap.HookPreShutdown, reporter)
+	}
+}
+
+// beginDrain marks the node as draining. started is false if a drain was
+// already in progress, in which case the existing state is returned.
+func (s *Server) beginDrain(reason string) (state *drainState, started bool) {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+	if s.drain != nil {
+		return s.drain, false
+	}
+	s.drain = &drainState{
+		reason:     reason,
+		shutdownAt: nowUTC().Add(s.config.ShutdownDrainCountdown),
+		done:       make(chan struct{}),
+	}
+	return s.drain, true
+}
+
+// broadcastShutdownNotice sends the drain warning to every ACP viewer and
+// terminal WebSocket on the node. Returns the number of viewers reached.
+func (s *Server) broadcastShutdownNotice(state *drainState) int {
+	data, _ := json.Marshal(shutdownNoticeMessage(state))
+	s.sessionHostMu.Lock()
+	hosts := make([]*acp.SessionHost, 0, len(s.sessionHosts))
+	for _, host := range s.sessionHosts {
+		if host != nil {
+			hosts = append(hosts, host)
+		}
+	}
+	s.sessionHostMu.Unlock()
+
+	viewers := 0
+	for _, host := range hosts {
+		host.BroadcastTransient(data)
+		viewers += host.ViewerCount()
+	}
+
+	s.terminalConnsMu.Lock()
+	conns := make([]*terminalConn, 0, len(s.terminalConns))
+	for tc := range s.terminalConns {
+		conns = append(conns, tc)
+	}
+	s.terminalConnsMu.Unlock()
+
+	ptyData := NewShuttingDownMessage(state.reason, state.shutdownAt, countdownSeconds(state.shutdownAt))
+	for _, tc := range conns {
+		tc.send(ptyData)
+	}
+	return viewers + len(conns)
+}
+
+// sendShutdownNotice tells a newly attached viewer that the node is
+// draining. No-op while the node is not draining.
+func (s *Server) sendShutdownNotice(host *acp.SessionHost, viewerID string) {
+	state := s.drainStatus()
+	if state == nil {
+		return
+	}
+	data, _ := json.Marshal(shutdownNoticeMessage(state))
+	host.SendToViewer(viewerID, data)
+}
+
+func shutdownNoticeMessage(state *drainState) acp.WorkspaceShuttingDownMessage {
+	return acp.WorkspaceShuttingDownMessage{
+		Type:             acp.MsgWorkspaceShuttingDown,
+		Reason:           state.reason,
+		ShutdownAt:       state.shutdownAt,
+		CountdownSeconds: countdownSeconds(state.shutdownAt),
+	}
+}
+
+// countdownSeconds returns the whole seconds left until shutdownAt, rounded
+// up so a warning is never reported as already expired.
+func countdownSeconds(shutdownAt time.Time) int {
+	remaining := time.Until(shutdownAt)
+	if remaining <= 0 {
+		return 0
+	}
+	return int((remaining + time.Second - 1) / time.Second)
+}
+
+// flushAllReporters pushes every queued chat message to the control plane so
+// agent output produced just before shutdown is not lost with the node.
+func (s *Server) flushAllReporters() {
+	s.messageReportersMu.RLock()
+	reporters := make(map[string]*messagereport.Reporter, len(s.messageReporters))
+	for workspaceID, reporter := range s.messageReporters {
+		reporters[workspaceID] = reporter
+	}
+	s.messageReportersMu.RUnlock()
+
+	for workspaceID, reporter := range reporters {
+		if !reporter.Flush() {
+			slog.Warn("Messages still queued after drain flush", "workspaceId", workspaceID)
+		}
+	}
+}
+
+// handleDrain starts the drain phase ahead of an idle or scheduled shutdown
+// and returns immediately with the announced shutdown time. The control plane
+// calls it before tearing the node down; repeated calls report the drain
+// already in progress.
+func (s *Server) handleDrain(w http.ResponseWriter, r *http.Request) {
+	if !s.requireNodeManagementAuth(w, r, "") {
+		return
+	}
+
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+	}
+	reason := strings.TrimSpace(body.Reason)
+	if reason == "" {
+		reason = "shutdown"
+	}
+
+	state, started := s.beginDrain(reason)
+	if started {
+		go s.runDrain(context.Background(), state)
+	}
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"draining":         true,
+		"reason":           state.reason,
+		"shutdownAt":       state.shutdownAt,
+		"countdownSeconds": countdownSeconds(state.shutdownAt),
+	})
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/acp"
+	"github.com/workspace/vm-agent/internal/bootlog"
+	"github.com/workspace/vm-agent/internal/bootstrap"
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+func newDrainTestServer(t *testing.T, countdown time.Duration) (*Server, *http.ServeMux, *rsa.PrivateKey) {
+	t.Helper()
+	validator, key := newWorkspaceCreateJWTValidator(t, "node-1")
+	s := &Server{
+		config:          &config.Config{NodeID: "node-1", ShutdownDrainCountdown: countdown},
+		jwtValidator:    validator,
+		workspaceEvents: make(map[string][]EventRecord),
+		sessionHosts:    make(map[string]*acp.SessionHost),
+	}
+	mux := http.NewServeMux()
+	s.setupRoutes(mux)
+	return s, mux, key
+}
+
+func TestDrainWarnsViewersAndRefusesNewSessions(t *testing.T) {
+	t.Parallel()
+	s, mux, key := newDrainTestServer(t, 200*time.Millisecond)
+
+	host := acp.NewSessionHost(acp.SessionHostConfig{
+		GatewayConfig: acp.GatewayConfig{SessionID: "chat-1", WorkspaceID: "ws-1"},
+	})
+	t.Cleanup(host.Stop)
+	s.sessionHosts["ws-1:chat-1"] = host
+	queue := acp.NewPollQueue(100)
+	if host.AttachViewerSink("viewer-1", queue) == nil {
+		t.Fatal("AttachViewerSink returned nil")
+	}
+	attached := queue.Poll(context.Background(), 0, time.Second, 100)
+
+	start := time.Now()
+	s.Drain(context.Background(), "idle")
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("Drain returned after %s, want it to wait out the countdown for attached viewers", elapsed)
+	}
+
+	batch := queue.Poll(context.Background(), attached.Cursor, time.Second, 100)
+	var notice acp.WorkspaceShuttingDownMessage
+	for _, msg := range batch.Messages {
+		var probe acp.WorkspaceShuttingDownMessage
+		if json.Unmarshal(msg.Data, &probe) == nil && probe.Type == acp.MsgWorkspaceShuttingDown {
+			notice = probe
+		}
+	}
+	if notice.Type == "" || notice.Reason != "idle" || notice.CountdownSeconds != 1 {
+		t.Fatalf("shutdown notice = %+v, want idle notice with a 1s countdown", notice)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/workspaces/ws-1/agent-sessions", bytes.NewReader([]byte(`{"sessionId":"chat-2"}`)))
+	req.Header.Set("Authorization", "Bearer "+signWorkspaceCreateNodeToken(t, key, "node-1", "ws-1"))
+	req.Header.Set("X-SAM-Workspace-Id", "ws-1")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("create session while draining: status = %d, want 503 (%s)", rec.Code, rec.Body.String())
+	}
+
+	// A second drain request reports the drain already done instead of
+	// starting a new countdown.
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/drain", nil)
+	req.Header.Set("Authorization", "Bearer "+signWorkspaceCreateNodeToken(t, key, "node-1", ""))
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("drain status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Reason           string `json:"reason"`
+		CountdownSeconds int    `json:"countdownSeconds"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode drain response: %v", err)
+	}
+	if resp.Reason != "idle" || resp.CountdownSeconds != 0 {
+		t.Fatalf("drain response = %+v, want the original idle drain", resp)
+	}
+}
+
+func TestHandleDrainStartsDrainWithoutViewers(t *testing.T) {
+	t.Parallel()
+	s, mux, key := newDrainTestServer(t, time.Hour)
+
+	req := httptest.NewRequest(http.MethodPost, "/drain", nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated drain status = %d, want 401", rec.Code)
+	}
+	if s.drainStatus() != nil {
+		t.Fatal("unauthenticated request started a drain")
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/drain", bytes.NewReader([]byte(`{"reason":"idle_timeout"}`)))
+	req.Header.Set("Authorization", "Bearer "+signWorkspaceCreateNodeToken(t, key, "node-1", ""))
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("drain status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// With nobody attached the drain finishes without waiting out the
+	// hour-long countdown, so a SIGTERM right after is not held up.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.Drain(ctx, "node_shutdown")
+	if ctx.Err() != nil {
+		t.Fatal("Drain waited for the countdown with no viewers attached")
+	}
+	if state := s.drainStatus(); state == nil || state.reason != "idle_timeout" {
+		t.Fatalf("drain state = %+v, want the idle_timeout drain", state)
+	}
+}
+
+func TestDrainRunsPreShutdownHooks(t *testing.T) {
+	originalRun := runLifecycleHooks
+	defer func() { runLifecycleHooks = originalRun }()
+
+	type hookCall struct {
+		workspaceID string
+		point       string
+		script      string
+	}
+	var calls []hookCall
+	runLifecycleHooks = func(_ context.Context, cfg *config.Config, hooks bootstrap.Hooks, point string, _ *bootlog.Reporter) {
+		calls = append(calls, hookCall{cfg.WorkspaceID, point, hooks[point]})
+	}
+
+	s, _, _ := newDrainTestServer(t, time.Hour)
+	s.config.ContainerMode = true
+	s.workspaces.Put("ws-b", &WorkspaceRuntime{ID: "ws-b", Status: "recovery"})
+	s.workspaces.Put("ws-a", &WorkspaceRuntime{ID: "ws-a", Status: "running", Hooks: bootstrap.Hooks{bootstrap.HookPreShutdown: "make stop"}})
+	s.workspaces.Put("ws-stopped", &WorkspaceRuntime{ID: "ws-stopped", Status: "stopped"})
+
+	s.Drain(context.Background(), "node_shutdown")
+
+	want := []hookCall{
+		{"ws-a", bootstrap.HookPreShutdown, "make stop"},
+		{"ws-b", bootstrap.HookPreShutdown, ""},
+	}
+	if len(calls) != len(want) {
+		t.Fatalf("hook calls = %+v, want %+v", calls, want)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Fatalf("hook calls = %+v, want %+v", calls, want)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/egress"
+)
+
+// egressApplyTimeout bounds one workspace's policy refresh, which resolves
+// domains and runs a few iptables commands.
+const egressApplyTimeout = 30 * time.Second
+
+// startEgressPolicyRefresher periodically re-applies workspace egress
+// policies so domain entries follow DNS changes and restarted devcontainers,
+// which come up with a fresh network namespace, are restricted again.
+func (s *Server) startEgressPolicyRefresher() {
+	interval := s.config.EgressPolicyRefreshInterval
+	if !s.config.ContainerMode || interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				s.refreshEgressPolicies()
+			}
+		}
+	}()
+}
+
+// refreshEgressPolicies re-applies the egress policy of every running
+// workspace that has one. Failures are logged and retried next interval.
+func (s *Server) refreshEgressPolicies() {
+	type pending struct {
+		workspaceID string
+		policy      *egress.Policy
+	}
+	var workspaces []pending
+	s.workspaces.Range(func(_ string, runtime *WorkspaceRuntime) {
+		if runtime.EgressPolicy != nil && (runtime.Status == "running" || runtime.Status == "recovery") {
+			workspaces = append(workspaces, pending{runtime.ID, runtime.EgressPolicy})
+		}
+	})
+
+	for _, ws := range workspaces {
+		containerID, _, _, err := s.resolveContainerForWorkspace(ws.workspaceID)
+		if err != nil || containerID == "" {
+			slog.Warn("egress: cannot resolve devcontainer for policy refresh", "workspace", ws.workspaceID, "error", err)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), egressApplyTimeout)
+		err = egress.Apply(ctx, containerID, ws.policy, net.DefaultResolver)
+		cancel()
+		if err != nil {
+			slog.Warn("egress: policy refresh failed", "workspace", ws.workspaceID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/workspace/vm-agent/internal/eventstore"
+)
+
+// maxEventUploadBatches bounds how many batches one upload run sends, so a
+// large backlog after a long outage drains over several heartbeats.
+const maxEventUploadBatches = 10
+
+// uploadPendingEvents sends locally stored events the control plane has not
+// accepted yet, oldest first, in batches of EventUploadBatchSize. Events
+// recorded while the node was offline are uploaded once heartbeats succeed
+// again. A failed batch stays pending for the next run.
+func (s *Server) uploadPendingEvents() {
+	if !s.config.EventUploadEnabled || s.eventStore == nil || s.config.ControlPlaneURL == "" || s.config.NodeID == "" {
+		return
+	}
+	batchSize := s.config.EventUploadBatchSize
+	if batchSize <= 0 {
+		batchSize = 200
+	}
+
+	uploaded := 0
+	for batch := 0; batch < maxEventUploadBatches; batch++ {
+		events, err := s.eventStore.PendingUpload(batchSize)
+		if err != nil {
+			slog.Warn("eventstore: list pending uploads failed", "error", err)
+			return
+		}
+		if len(events) == 0 {
+			break
+		}
+		if err := s.postEventBatch(events); err != nil {
+			slog.Warn("Event upload failed; will retry after next heartbeat", "events", len(events), "error", err)
+			break
+		}
+		ids := make([]string, 0, len(events))
+		for _, event := range events {
+			ids = append(ids, event.ID)
+		}
+		if err := s.eventStore.MarkUploaded(ids); err != nil {
+			slog.Warn("eventstore: mark uploaded failed", "error", err)
+			break
+		}
+		uploaded += len(events)
+		if len(events) < batchSize {
+			break
+		}
+	}
+	if uploaded > 0 {
+		slog.Info("Uploaded stored events to control plane", "events", uploaded)
+	}
+}
+
+// postEventBatch posts one batch of events to the control plane.
+func (s *Server) postEventBatch(events []eventstore.EventRecord) error {
+	body, err := json.Marshal(map[string]interface{}{"events": events})
+	if err != nil {
+		return fmt.Errorf("marshal events: %w", err)
+	}
+	url := strings.TrimRight(s.config.ControlPlaneURL, "/") + "/api/nodes/" + s.config.NodeID + "/events"
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+s.getCallbackToken())
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.controlPlaneHTTPClient(0).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("control plane returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/auth"
+	"github.com/workspace/vm-agent/internal/eventstore"
+)
+
+func TestListWorkspaceEvents_Filters(t *testing.T) {
+	validator, privateKey := newWorkspaceCreateJWTValidator(t, "node-1")
+	s := newWorkspaceCreateServer(t, "", validator)
+	s.sessionManager = auth.NewSessionManager("session", false, time.Hour)
+	s.eventStore = newEventStore(t)
+
+	const workspaceID = "ws-events"
+	base := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	for i, event := range []EventRecord{
+		{ID: "e1", WorkspaceID: workspaceID, Level: "info", Type: "agent.ready"},
+		{ID: "e2", WorkspaceID: workspaceID, Level: "error", Type: "agent.crashed"},
+		{ID: "e3", WorkspaceID: workspaceID, Level: "warn", Type: "port.detected"},
+		{ID: "e4", WorkspaceID: "ws-other", Level: "error", Type: "agent.crashed"},
+	} {
+		event.CreatedAt = base.Add(time.Duration(i) * time.Minute).Format(time.RFC3339)
+		s.eventStore.Append(eventstore.EventRecord(event))
+	}
+
+	token := signWorkspaceCreateNodeToken(t, privateKey, "node-1", workspaceID)
+	list := func(query string) (int, []string) {
+		req := httptest.NewRequest(http.MethodGet, "/workspaces/"+workspaceID+"/events?"+query, nil)
+		req.SetPathValue("workspaceId", workspaceID)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("X-SAM-Node-Id", "node-1")
+		req.Header.Set("X-SAM-Workspace-Id", workspaceID)
+		rec := httptest.NewRecorder()
+		s.handleListWorkspaceEvents(rec, req)
+		var body struct {
+			Events []EventRecord `json:"events"`
+		}
+		_ = json.Unmarshal(rec.Body.Bytes(), &body)
+		ids := make([]string, 0, len(body.Events))
+		for _, event := range body.Events {
+			ids = append(ids, event.ID)
+		}
+		return rec.Code, ids
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{query: "", want: "e3,e2,e1"},
+		{query: "level=warn,error", want: "e3,e2"},
+		{query: "type=agent.*", want: "e2,e1"},
+		{query: "since=" + base.Add(time.Minute).Format(time.RFC3339), want: "e3,e2"},
+		{query: "type=agent.*&level=error&limit=5", want: "e2"},
+	}
+	for _, tt := range tests {
+		code, ids := list(tt.query)
+		if code != http.StatusOK || strings.Join(ids, ",") != tt.want {
+			t.Errorf("GET events?%s = %d %v, want 200 [%s]", tt.query, code, ids, tt.want)
+		}
+	}
+	if code, _ := list("since=yesterday"); code != http.StatusBadRequest {
+		t.Errorf("invalid since = %d, want 400", code)
+	}
+}
+
+func TestListEvents_InMemoryFallback(t *testing.T) {
+	s := newWorkspaceCreateServer(t, "", nil)
+	s.appendNodeEvent("ws-1", "info", "agent.ready", "ready", nil)
+	s.appendNodeEvent("ws-1", "error", "agent.crashed", "crashed", nil)
+	s.appendNodeEvent("ws-2", "error", "agent.crashed", "crashed", nil)
+
+	events := s.listEvents(eventstore.Query{WorkspaceID: "ws-1", Levels: []string{"error"}, Limit: 10})
+	if len(events) != 1 || events[0].Type != "agent.crashed" || events[0].WorkspaceID != "ws-1" {
+		t.Fatalf("listEvents() = %+v, want the ws-1 crash", events)
+	}
+	if events := s.listEvents(eventstore.Query{Types: []string{"agent.*"}, Limit: 2}); len(events) != 2 {
+		t.Fatalf("listEvents() with limit = %d events, want 2", len(events))
+	}
+}
+
+func TestUploadPendingEvents_BatchesAndRetries(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]string
+	var fail atomic.Bool
+	controlPlane := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/nodes/node-1/events" || r.Header.Get("Authorization") != "Bearer callback-token" {
+			t.Errorf("unexpected upload request %s %q", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		if fail.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var body struct {
+			Events []EventRecord `json:"events"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode upload: %v", err)
+		}
+		ids := make([]string, 0, len(body.Events))
+		for _, event := range body.Events {
+			ids = append(ids, event.ID)
+		}
+		mu.Lock()
+		batches = append(batches, ids)
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(controlPlane.Close)
+
+	s := newWorkspaceCreateServer(t, controlPlane.URL, nil)
+	s.config.EventUploadEnabled = true
+	s.config.EventUploadBatchSize = 2
+	s.callbackToken = "callback-token"
+	s.eventStore = newEventStore(t)
+	base := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	for i := 1; i <= 5; i++ {
+		s.eventStore.Append(eventstore.EventRecord{
+			ID: fmt.Sprintf("e%d", i), Level: "info", Type: "test",
+			CreatedAt: base.Add(time.Duration(i) * time.Second).Format(time.RFC3339),
+		})
+	}
+
+	fail.Store(true)
+	s.uploadPendingEvents()
+	if pending, _ := s.eventStore.PendingUpload(10); len(pending) != 5 {
+		t.Fatalf("pending after failed upload = %d, want 5", len(pending))
+	}
+
+	fail.Store(false)
+	s.uploadPendingEvents()
+	mu.Lock()
+	got := fmt.Sprint(batches)
+	mu.Unlock()
+	if got != "[[e1 e2] [e3 e4] [e5]]" {
+		t.Fatalf("uploaded batches = %s, want [[e1 e2] [e3 e4] [e5]]", got)
+	}
+	if pending, _ := s.eventStore.PendingUpload(10); len(pending) != 0 {
+		t.Fatalf("pending after upload = %d, want 0", len(pending))
+	}
+}
@@ -5,8 +5,12 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/eventstore"
 )
 
 func (s *Server) handleListNodeEvents(w http.ResponseWriter, r *http.Request) {
@@ -15,18 +19,14 @@ func (s *Server) handleListNodeEvents(w http.ResponseWriter, r *http.Request) {
 	if !s.requireNodeEventAuth(w, r) {
 		return
 	}
-	limit := parseEventLimit(r.URL.Query().Get("limit"))
-
-	s.eventMu.RLock()
-	defer s.eventMu.RUnlock()
-
-	result := s.nodeEvents
-	if len(result) > limit {
-		result = result[:limit]
+	query, err := parseEventQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
 	writeJSON(w, http.StatusOK, map[string]interface{}{
-		"events":     result,
+		"events":     s.listEvents(query),
 		"nextCursor": nil,
 	})
 }
@@ -48,20 +48,107 @@ func (s *Server) handleListWorkspaceEvents(w http.ResponseWriter, r *http.Reques
 		}
 	}
 
-	limit := parseEventLimit(r.URL.Query().Get("limit"))
+	query, err := parseEventQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	query.WorkspaceID = workspaceID
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"events":     s.listEvents(query),
+		"nextCursor": nil,
+	})
+}
+
+// parseEventQuery reads the limit, since, level and type filters of an event
+// list request. since is an RFC 3339 timestamp; level and type take
+// comma-separated values, and a type ending in "*" matches by prefix.
+func parseEventQuery(r *http.Request) (eventstore.Query, error) {
+	params := r.URL.Query()
+	query := eventstore.Query{
+		Limit:  parseEventLimit(params.Get("limit")),
+		Levels: splitEventFilter(params.Get("level")),
+		Types:  splitEventFilter(params.Get("type")),
+	}
+	if raw := strings.TrimSpace(params.Get("since")); raw != "" {
+		since, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return eventstore.Query{}, fmt.Errorf("since must be an RFC 3339 timestamp")
+		}
+		query.Since = since
+	}
+	return query, nil
+}
+
+func splitEventFilter(raw string) []string {
+	var values []string
+	for _, value := range strings.Split(raw, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// listEvents returns the events matching query, newest first. Events come
+// from the SQLite store, which survives agent restarts; the in-memory ring
+// buffers are used when the store is unavailable.
+func (s *Server) listEvents(query eventstore.Query) []EventRecord {
+	if s.eventStore != nil {
+		stored, err := s.eventStore.List(query)
+		if err == nil {
+			events := make([]EventRecord, 0, len(stored))
+			for _, event := range stored {
+				events = append(events, EventRecord(event))
+			}
+			return events
+		}
+		slog.Warn("eventstore: list failed, serving in-memory events", "error", err)
+	}
 
 	s.eventMu.RLock()
 	defer s.eventMu.RUnlock()
 
-	workspaceEvents := s.workspaceEvents[workspaceID]
-	if len(workspaceEvents) > limit {
-		workspaceEvents = workspaceEvents[:limit]
+	source := s.nodeEvents
+	if query.WorkspaceID != "" {
+		source = s.workspaceEvents[query.WorkspaceID]
 	}
+	events := make([]EventRecord, 0, min(len(source), query.Limit))
+	for _, event := range source {
+		if len(events) >= query.Limit {
+			break
+		}
+		if eventMatchesQuery(event, query) {
+			events = append(events, event)
+		}
+	}
+	return events
+}
 
-	writeJSON(w, http.StatusOK, map[string]interface{}{
-		"events":     workspaceEvents,
-		"nextCursor": nil,
-	})
+func eventMatchesQuery(event EventRecord, query eventstore.Query) bool {
+	if !query.Since.IsZero() {
+		createdAt, err := time.Parse(time.RFC3339, event.CreatedAt)
+		if err != nil || createdAt.Before(query.Since.Truncate(time.Second)) {
+			return false
+		}
+	}
+	if len(query.Levels) > 0 && !slices.Contains(query.Levels, event.Level) {
+		return false
+	}
+	if len(query.Types) == 0 {
+		return true
+	}
+	for _, eventType := range query.Types {
+		if prefix, ok := strings.CutSuffix(eventType, "*"); ok {
+			if strings.HasPrefix(event.Type, prefix) {
+				return true
+			}
+		} else if event.Type == eventType {
+			return true
+		}
+	}
+	return false
 }
 
 // requireNodeEventAuth authenticates node-level event requests.
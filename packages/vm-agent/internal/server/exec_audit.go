@@ -0,0 +1,119 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/execaudit"
+)
+
+// handleListExecAudit returns privileged container exec records for a
+// workspace, newest first. Optional filters: operation, initiator, since
+// (RFC 3339), limit.
+func (s *Server) handleListExecAudit(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.PathValue("workspaceId")
+	if workspaceID == "" {
+		writeError(w, http.StatusBadRequest, workspaceIDRequiredMessage)
+		return
+	}
+	if !s.checkWorkspaceRequestAuth(r, workspaceID) {
+		if !s.requireNodeManagementAuth(w, r, workspaceID) {
+			return
+		}
+	}
+	if s.execAudit == nil {
+		writeError(w, http.StatusServiceUnavailable, "exec audit unavailable")
+		return
+	}
+
+	query := r.URL.Query()
+	q := execaudit.Query{
+		WorkspaceID: workspaceID,
+		Operation:   strings.TrimSpace(query.Get("operation")),
+		Initiator:   strings.TrimSpace(query.Get("initiator")),
+		Limit:       parseEventLimit(query.Get("limit")),
+	}
+	if raw := strings.TrimSpace(query.Get("since")); raw != "" {
+		since, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "since must be an RFC 3339 timestamp")
+			return
+		}
+		q.Since = since
+	}
+
+	entries, err := s.execAudit.List(q)
+	if err != nil {
+		slog.Error("Failed to query exec audit", "workspace", workspaceID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to query exec audit")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"entries": entries})
+}
+
+// startExecAuditShipper periodically ships privileged exec records to the
+// control plane.
+func (s *Server) startExecAuditShipper() {
+	interval := s.config.ExecAuditShipInterval
+	if s.execAudit == nil || s.config.ControlPlaneURL == "" || interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				s.shipExecAudit()
+			}
+		}
+	}()
+}
+
+// shipExecAudit sends one batch of unshipped records, grouped by workspace,
+// with the same retry and drop rules as shipAccessAudit.
+func (s *Server) shipExecAudit() {
+	entries, err := s.execAudit.Unshipped(s.config.ExecAuditShipBatchSize)
+	if err != nil {
+		slog.Warn("exec_audit: reading unshipped records failed", "error", err)
+		return
+	}
+
+	byWorkspace := make(map[string][]execaudit.Entry)
+	var order []string
+	for _, e := range entries {
+		if _, ok := byWorkspace[e.WorkspaceID]; !ok {
+			order = append(order, e.WorkspaceID)
+		}
+		byWorkspace[e.WorkspaceID] = append(byWorkspace[e.WorkspaceID], e)
+	}
+
+	for _, workspaceID := range order {
+		batch := byWorkspace[workspaceID]
+		token := s.callbackTokenForWorkspace(workspaceID)
+		if token == "" {
+			slog.Debug("exec_audit: skipping workspace without callback token", "workspace", workspaceID)
+			continue
+		}
+		permanent, err := s.postWorkspaceRecords(workspaceID, token, "exec-audit", map[string]interface{}{"entries": batch})
+		if err != nil {
+			slog.Warn("exec_audit: shipping records failed", "workspace", workspaceID, "count", len(batch), "dropped", permanent, "error", err)
+			if !permanent {
+				continue
+			}
+		}
+		ids := make([]string, len(batch))
+		for i, e := range batch {
+			ids[i] = e.ID
+		}
+		if err := s.execAudit.MarkShipped(ids); err != nil {
+			slog.Warn("exec_audit: marking records shipped failed", "workspace", workspaceID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/accessaudit"
+	"github.com/workspace/vm-agent/internal/execaudit"
+)
+
+func openTestExecAudit(t *testing.T) *execaudit.Store {
+	t.Helper()
+	store, err := execaudit.New(filepath.Join(t.TempDir(), "exec-audit.db"), 0)
+	if err != nil {
+		t.Fatalf("execaudit.New: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func TestExecAuditListShipAndRetention(t *testing.T) {
+	var received atomic.Int32
+	controlPlane := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/workspaces/WS_TEST/exec-audit" || r.Header.Get("Authorization") != "Bearer node-token" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var body struct {
+			Entries []execaudit.Entry `json:"entries"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		received.Add(int32(len(body.Entries)))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer controlPlane.Close()
+
+	s, _, cookieSessionID := newAgentWSTestServer(t)
+	s.config.ControlPlaneURL = controlPlane.URL
+	s.config.CallbackToken = "node-token"
+	s.config.ExecAuditShipBatchSize = 100
+	store := openTestExecAudit(t)
+	s.execAudit = store
+	s.accessAudit = openTestAccessAudit(t)
+	for _, op := range []string{"workspace.chown", "env.write"} {
+		if _, err := store.Add(execaudit.Entry{WorkspaceID: "WS_TEST", Operation: op, Initiator: execaudit.InitiatorBootstrap, Result: execaudit.ResultSucceeded}); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	s.setupRoutes(mux)
+	req := httptest.NewRequest(http.MethodGet, "/workspaces/WS_TEST/exec-audit?operation=env.write", nil)
+	req.Header.Set("Cookie", "session="+cookieSessionID)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	var body struct {
+		Entries []execaudit.Entry `json:"entries"`
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &body)
+	if rec.Code != http.StatusOK || len(body.Entries) != 1 || body.Entries[0].Operation != "env.write" {
+		t.Fatalf("list = %d %s", rec.Code, rec.Body.String())
+	}
+
+	s.shipExecAudit()
+	if got := received.Load(); got != 2 {
+		t.Fatalf("control plane received %d records, want 2", got)
+	}
+	if pending, _ := store.Unshipped(10); len(pending) != 0 {
+		t.Fatalf("pending after ship = %+v", pending)
+	}
+
+	// Audit log retention spans both the access and exec audit stores.
+	access, err := s.accessAudit.Attach(accessaudit.Entry{WorkspaceID: "WS_TEST", SessionID: "sess", ViewerID: "viewer"})
+	if err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+	if _, err := s.accessAudit.Detach(access.ID, time.Now()); err != nil {
+		t.Fatalf("Detach: %v", err)
+	}
+	source := auditLogRetentionSource{s: s}
+	items, err := source.RetentionItems("WS_TEST")
+	if err != nil || len(items) != 3 {
+		t.Fatalf("RetentionItems = %+v, err = %v", items, err)
+	}
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+	if n, err := source.DeleteRetentionItems("WS_TEST", ids); err != nil || n != 3 {
+		t.Fatalf("DeleteRetentionItems = %d, %v", n, err)
+	}
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/acp"
+)
+
+// startFileChangeWatcher polls the git working tree of every workspace that
+// has attached agent-session viewers and broadcasts the files that changed
+// since the last poll, so the chat UI can show what the agent is modifying.
+func (s *Server) startFileChangeWatcher() {
+	if s.config.FileChangePollInterval <= 0 || s.config.IsDeploymentMode() {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(s.config.FileChangePollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				s.pollFileChanges()
+			}
+		}
+	}()
+}
+
+// workspacesWithViewers returns the IDs of workspaces with at least one
+// viewer attached to an agent session.
+func (s *Server) workspacesWithViewers() []string {
+	s.sessionHostMu.Lock()
+	defer s.sessionHostMu.Unlock()
+	seen := make(map[string]bool)
+	var ids []string
+	for key, host := range s.sessionHosts {
+		workspaceID, _, ok := strings.Cut(key, ":")
+		if !ok || host == nil || seen[workspaceID] || host.ViewerCount() == 0 {
+			continue
+		}
+		seen[workspaceID] = true
+		ids = append(ids, workspaceID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// pollFileChanges runs one poll. Snapshots of workspaces without viewers are
+// dropped, so the first poll after a viewer attaches again reports every
+// modified file.
+func (s *Server) pollFileChanges() {
+	workspaceIDs := s.workspacesWithViewers()
+	active := make(map[string]bool, len(workspaceIDs))
+	for _, workspaceID := range workspaceIDs {
+		active[workspaceID] = true
+		current, err := s.workspaceFileChanges(workspaceID)
+		if err != nil {
+			slog.Debug("File change poll failed", "workspace", workspaceID, "error", err)
+			continue
+		}
+		if s.fileChanges == nil {
+			s.fileChanges = make(map[string]map[string]acp.FileChange)
+		}
+		changed := diffFileChanges(s.fileChanges[workspaceID], current)
+		s.fileChanges[workspaceID] = current
+		if len(changed) == 0 {
+			continue
+		}
+		msg := acp.FilesChangedMessage{Type: acp.MsgFilesChanged, WorkspaceID: workspaceID, Files: changed}
+		for _, change := range current {
+			msg.Totals.Files++
+			msg.Totals.Additions += change.Additions
+			msg.Totals.Deletions += change.Deletions
+		}
+		data, _ := json.Marshal(msg)
+		s.broadcastToWorkspaceViewers(workspaceID, data)
+	}
+	for workspaceID := range s.fileChanges {
+		if !active[workspaceID] {
+			delete(s.fileChanges, workspaceID)
+		}
+	}
+}
+
+// workspaceFileChanges returns the modified, added, deleted, and untracked
+// files in the workspace's working tree, keyed by path, with line counts
+// against HEAD.
+func (s *Server) workspaceFileChanges(workspaceID string) (map[string]acp.FileChange, error) {
+	containerID, workDir, user, err := s.resolveContainerForWorkspace(workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.GitExecTimeout)
+	defer cancel()
+
+	// --no-optional-locks keeps the poll from taking index.lock and racing
+	// the agent's own git commands.
+	status, _, err := s.execInContainer(ctx, containerID, user, workDir, "git", "--no-optional-locks", "status", "--porcelain=v1", "--untracked-files=all")
+	if err != nil {
+		return nil, err
+	}
+	// Fails in a repository without commits; the files are still reported,
+	// just without line counts.
+	numstat, _, _ := s.execInContainer(ctx, containerID, user, workDir, "git", "--no-optional-locks", "diff", "--numstat", "--no-renames", "HEAD")
+	return buildFileChanges(status, numstat), nil
+}
+
+// buildFileChanges combines `git status --porcelain=v1` and
+// `git diff --numstat HEAD` output into one entry per path.
+func buildFileChanges(status, numstat string) map[string]acp.FileChange {
+	staged, unstaged, untracked := parseGitStatusPorcelain(status)
+	changes := make(map[string]acp.FileChange)
+	for _, group := range [][]GitFileStatus{staged, unstaged, untracked} {
+		for _, f := range group {
+			if _, ok := changes[f.Path]; ok {
+				continue
+			}
+			changes[f.Path] = acp.FileChange{Path: f.Path, Status: f.Status, OldPath: f.OldPath}
+		}
+	}
+	for _, line := range strings.Split(numstat, "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		change, ok := changes[fields[2]]
+		if !ok {
+			continue
+		}
+		if fields[0] == "-" && fields[1] == "-" {
+			change.Binary = true
+		} else {
+			change.Additions, _ = strconv.Atoi(fields[0])
+			change.Deletions, _ = strconv.Atoi(fields[1])
+		}
+		changes[fields[2]] = change
+	}
+	return changes
+}
+
+// diffFileChanges returns the entries of current that differ from previous,
+// plus an entry with status "clean" for every path that is no longer
+// modified, sorted by path.
+func diffFileChanges(previous, current map[string]acp.FileChange) []acp.FileChange {
+	var changed []acp.FileChange
+	for path, change := range current {
+		if old, ok := previous[path]; !ok || old != change {
+			changed = append(changed, change)
+		}
+	}
+	for path := range previous {
+		if _, ok := current[path]; !ok {
+			changed = append(changed, acp.FileChange{Path: path, Status: acp.FileChangeClean})
+		}
+	}
+	sort.Slice(changed, func(i, j int) bool { return changed[i].Path < changed[j].Path })
+	return changed
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/acp"
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+func TestBuildFileChanges(t *testing.T) {
+	t.Parallel()
+
+	status := "M  staged.go\n M edited.go\nR  old.go -> new.go\n?? notes.txt\n"
+	numstat := "3\t1\tedited.go\n-\t-\tlogo.png\n10\t0\tnew.go\n2\t2\tstaged.go\n"
+	changes := buildFileChanges(status, numstat)
+	if len(changes) != 4 {
+		t.Fatalf("expected 4 files, got %+v", changes)
+	}
+	if got := changes["edited.go"]; got.Status != "M" || got.Additions != 3 || got.Deletions != 1 {
+		t.Errorf("edited.go = %+v", got)
+	}
+	if got := changes["new.go"]; got.Status != "R" || got.OldPath != "old.go" || got.Additions != 10 {
+		t.Errorf("new.go = %+v", got)
+	}
+	if got := changes["notes.txt"]; got.Status != "??" || got.Additions != 0 {
+		t.Errorf("notes.txt = %+v", got)
+	}
+}
+
+func TestDiffFileChanges(t *testing.T) {
+	t.Parallel()
+
+	previous := map[string]acp.FileChange{
+		"a.go": {Path: "a.go", Status: "M", Additions: 1},
+		"b.go": {Path: "b.go", Status: "M", Additions: 1},
+		"c.go": {Path: "c.go", Status: "??"},
+	}
+	current := map[string]acp.FileChange{
+		"a.go": {Path: "a.go", Status: "M", Additions: 1},
+		"b.go": {Path: "b.go", Status: "M", Additions: 4},
+		"d.go": {Path: "d.go", Status: "A", Additions: 2},
+	}
+	changed := diffFileChanges(previous, current)
+	if len(changed) != 3 {
+		t.Fatalf("expected 3 changes, got %+v", changed)
+	}
+	if changed[0].Path != "b.go" || changed[0].Additions != 4 {
+		t.Errorf("changed[0] = %+v", changed[0])
+	}
+	if changed[1].Path != "c.go" || changed[1].Status != acp.FileChangeClean {
+		t.Errorf("changed[1] = %+v", changed[1])
+	}
+	if changed[2].Path != "d.go" {
+		t.Errorf("changed[2] = %+v", changed[2])
+	}
+	if len(diffFileChanges(current, current)) != 0 {
+		t.Error("expected no changes for identical snapshots")
+	}
+}
+
+func TestPollFileChangesBroadcastsToViewers(t *testing.T) {
+	t.Parallel()
+
+	repo := t.TempDir()
+	runGit := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v (%s)", args, err, out)
+		}
+	}
+	runGit("init", "-q")
+	if err := os.WriteFile(filepath.Join(repo, "main.go"), []byte("package main\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	runGit("add", "main.go")
+	runGit("commit", "-q", "-m", "init")
+
+	s := &Server{
+		config:       &config.Config{Role: config.RoleStandalone, GitExecTimeout: 10 * time.Second},
+		sessionHosts: make(map[string]*acp.SessionHost),
+	}
+
+	putWorkspaces(&s.workspaces, map[string]*WorkspaceRuntime{
+		"ws-1": {ID: "ws-1", Status: "running", ContainerWorkDir: repo},
+	})
+	host := acp.NewSessionHost(acp.SessionHostConfig{
+		GatewayConfig: acp.GatewayConfig{SessionID: "chat-1", WorkspaceID: "ws-1"},
+	})
+	t.Cleanup(host.Stop)
+	s.sessionHosts["ws-1:chat-1"] = host
+	queue := acp.NewPollQueue(100)
+	if host.AttachViewerSink("viewer-1", queue) == nil {
+		t.Fatal("AttachViewerSink returned nil")
+	}
+	cursor := queue.Poll(context.Background(), 0, time.Second, 100).Cursor
+
+	nextFilesChanged := func() *acp.FilesChangedMessage {
+		t.Helper()
+		s.pollFileChanges()
+		batch := queue.Poll(context.Background(), cursor, 100*time.Millisecond, 100)
+		cursor = batch.Cursor
+		for _, msg := range batch.Messages {
+			var probe acp.FilesChangedMessage
+			if json.Unmarshal(msg.Data, &probe) == nil && probe.Type == acp.MsgFilesChanged {
+				return &probe
+			}
+		}
+		return nil
+	}
+
+	if msg := nextFilesChanged(); msg != nil {
+		t.Fatalf("clean tree broadcast %+v", msg)
+	}
+
+	if err := os.WriteFile(filepath.Join(repo, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	msg := nextFilesChanged()
+	if msg == nil || msg.WorkspaceID != "ws-1" || len(msg.Files) != 1 {
+		t.Fatalf("files_changed = %+v", msg)
+	}
+	if f := msg.Files[0]; f.Path != "main.go" || f.Status != "M" || f.Additions != 2 {
+		t.Fatalf("file = %+v", f)
+	}
+	if msg.Totals.Files != 1 || msg.Totals.Additions != 2 {
+		t.Fatalf("totals = %+v", msg.Totals)
+	}
+
+	// Nothing changed since the last poll.
+	if msg := nextFilesChanged(); msg != nil {
+		t.Fatalf("unchanged tree broadcast %+v", msg)
+	}
+
+	runGit("checkout", "--", "main.go")
+	msg = nextFilesChanged()
+	if msg == nil || len(msg.Files) != 1 || msg.Files[0].Status != acp.FileChangeClean || msg.Totals.Files != 0 {
+		t.Fatalf("files_changed after revert = %+v", msg)
+	}
+}
@@ -288,7 +288,7 @@ func (s *Server) handleFileRaw(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Build ETag from mtime and size
-	etag := fmt.Sprintf(`"%x-%x"`, fileMtime, fileSize)
+	etag := fileETag(fileMtime, fileSize)
 
 	// Check If-None-Match for 304 support
 	if match := r.Header.Get("If-None-Match"); match == etag {
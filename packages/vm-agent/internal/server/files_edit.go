@@ -0,0 +1,251 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileWriteResponse describes a file after it was written.
+type FileWriteResponse struct {
+	Path       string `json:"path"`
+	Size       int64  `json:"size"`
+	ETag       string `json:"etag"`
+	ModifiedAt string `json:"modifiedAt"`
+}
+
+// workspaceFileStat is the subset of stat(1) output the file endpoints use.
+type workspaceFileStat struct {
+	Type  string // stat %F, e.g. "regular file", "directory"
+	Size  int64
+	Mtime int64
+}
+
+func (st workspaceFileStat) isRegular() bool {
+	return st.Type == "regular file" || st.Type == "regular empty file"
+}
+
+// fileETag builds the validator used by /files/raw and PUT /files.
+func fileETag(mtime, size int64) string {
+	return fmt.Sprintf(`"%x-%x"`, mtime, size)
+}
+
+// statWorkspaceFile stats a path inside the workspace. Returns an error when
+// the path does not exist.
+func (s *Server) statWorkspaceFile(ctx context.Context, containerID, user, workDir, filePath string) (workspaceFileStat, error) {
+	out, stderr, err := s.execInContainer(ctx, containerID, user, workDir,
+		"stat", "-c", "%F\t%s\t%Y", "--", filePath)
+	if err != nil {
+		return workspaceFileStat{}, fmt.Errorf("stat %s: %w (%s)", filePath, err, strings.TrimSpace(stderr))
+	}
+	parts := strings.SplitN(strings.TrimSpace(out), "\t", 3)
+	if len(parts) < 3 {
+		return workspaceFileStat{}, fmt.Errorf("unexpected stat output %q", out)
+	}
+	size, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return workspaceFileStat{}, fmt.Errorf("parse size: %w", err)
+	}
+	mtime, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return workspaceFileStat{}, fmt.Errorf("parse mtime: %w", err)
+	}
+	return workspaceFileStat{Type: parts[0], Size: size, Mtime: mtime}, nil
+}
+
+// validateEditableFilePath rejects empty paths, traversal, and the workdir
+// root itself, which can be neither overwritten nor deleted.
+func validateEditableFilePath(filePath string) error {
+	if filePath == "" {
+		return errors.New("path query parameter is required")
+	}
+	if err := sanitizeFilePath(filePath); err != nil {
+		return err
+	}
+	if cleaned := filepath.Clean(filePath); cleaned == "." || cleaned == "/" {
+		return errors.New("path must name a file inside the workspace")
+	}
+	return nil
+}
+
+// handleFileWrite handles PUT /workspaces/{workspaceId}/files?path=...
+// The request body is the complete new file content. Parent directories are
+// created as needed. Editors pass the ETag from /files/raw as If-Match so a
+// save fails with 412 instead of overwriting a concurrent change;
+// If-None-Match: * only creates new files.
+func (s *Server) handleFileWrite(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.PathValue("workspaceId")
+	if workspaceID == "" {
+		writeError(w, http.StatusBadRequest, "workspaceId is required")
+		return
+	}
+
+	if !s.requireWorkspaceRequestAuth(w, r, workspaceID) {
+		return
+	}
+
+	filePath := r.URL.Query().Get("path")
+	if err := validateEditableFilePath(filePath); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, s.config.FileUploadMaxBytes)
+	content, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeError(w, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("file exceeds maximum size of %d bytes", s.config.FileUploadMaxBytes))
+			return
+		}
+		writeError(w, http.StatusBadRequest, "failed to read request body")
+		return
+	}
+
+	containerID, workDir, user, err := s.resolveContainerForWorkspace(workspaceID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	workDir, err = s.resolveWorktreeWorkDir(r, workspaceID, containerID, user, workDir)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.config.FileUploadTimeout)
+	defer cancel()
+
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	ifNoneMatch := strings.TrimSpace(r.Header.Get("If-None-Match"))
+	current, statErr := s.statWorkspaceFile(ctx, containerID, user, workDir, filePath)
+	exists := statErr == nil
+	if exists && !current.isRegular() {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("not a regular file (type: %s)", current.Type))
+		return
+	}
+	if ifMatch != "" && (!exists || (ifMatch != "*" && ifMatch != fileETag(current.Mtime, current.Size))) {
+		writeError(w, http.StatusPreconditionFailed, "file changed since it was read")
+		return
+	}
+	if ifNoneMatch == "*" && exists {
+		writeError(w, http.StatusPreconditionFailed, "file already exists")
+		return
+	}
+
+	if dir := filepath.Dir(filePath); dir != "." {
+		if _, stderr, err := s.execInContainer(ctx, containerID, user, workDir, "mkdir", "-p", "--", dir); err != nil {
+			slog.Error("Failed to create parent directory", "workspace", workspaceID, "dir", dir, "error", err, "stderr", strings.TrimSpace(stderr))
+			writeError(w, http.StatusInternalServerError, "failed to create parent directory")
+			return
+		}
+	}
+
+	writeCmd, err := s.workspaceExecCommand(ctx, containerID, user, workDir, "tee", "--", filePath)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to create write command")
+		return
+	}
+	writeCmd.Stdin = bytes.NewReader(content)
+	writeCmd.Stdout = io.Discard
+	var stderrBuf bytes.Buffer
+	writeCmd.Stderr = &stderrBuf
+	if err := writeCmd.Run(); err != nil {
+		slog.Error("Failed to write file to container",
+			"workspace", workspaceID,
+			"path", filePath,
+			"error", err,
+			"stderr", strings.TrimSpace(stderrBuf.String()),
+		)
+		writeError(w, http.StatusInternalServerError, "failed to write file")
+		return
+	}
+
+	written, err := s.statWorkspaceFile(ctx, containerID, user, workDir, filePath)
+	if err != nil {
+		slog.Warn("stat failed after file write", "workspace", workspaceID, "path", filePath, "error", err)
+		written = workspaceFileStat{Size: int64(len(content)), Mtime: time.Now().Unix()}
+	}
+	etag := fileETag(written.Mtime, written.Size)
+	slog.Info("File written in workspace", "workspace", workspaceID, "path", filePath, "size", written.Size, "created", !exists)
+
+	status := http.StatusOK
+	if !exists {
+		status = http.StatusCreated
+	}
+	w.Header().Set("ETag", etag)
+	writeJSON(w, status, FileWriteResponse{
+		Path:       filePath,
+		Size:       written.Size,
+		ETag:       etag,
+		ModifiedAt: time.Unix(written.Mtime, 0).UTC().Format(time.RFC3339),
+	})
+}
+
+// handleFileDelete handles DELETE /workspaces/{workspaceId}/files?path=...
+// Files and empty directories are removed directly; non-empty directories
+// require recursive=true and otherwise return 409.
+func (s *Server) handleFileDelete(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.PathValue("workspaceId")
+	if workspaceID == "" {
+		writeError(w, http.StatusBadRequest, "workspaceId is required")
+		return
+	}
+
+	if !s.requireWorkspaceRequestAuth(w, r, workspaceID) {
+		return
+	}
+
+	filePath := r.URL.Query().Get("path")
+	if err := validateEditableFilePath(filePath); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	recursive := r.URL.Query().Get("recursive") == "true"
+
+	containerID, workDir, user, err := s.resolveContainerForWorkspace(workspaceID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	workDir, err = s.resolveWorktreeWorkDir(r, workspaceID, containerID, user, workDir)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.config.FileUploadTimeout)
+	defer cancel()
+
+	current, err := s.statWorkspaceFile(ctx, containerID, user, workDir, filePath)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "file not found")
+		return
+	}
+
+	args := []string{"rm", "-d", "--", filePath}
+	if recursive {
+		args = []string{"rm", "-r", "--", filePath}
+	}
+	if _, stderr, err := s.execInContainer(ctx, containerID, user, workDir, args...); err != nil {
+		if current.Type == "directory" && !recursive {
+			writeError(w, http.StatusConflict, "directory is not empty; pass recursive=true to delete it")
+			return
+		}
+		slog.Error("Failed to delete file in container", "workspace", workspaceID, "path", filePath, "error", err, "stderr", strings.TrimSpace(stderr))
+		writeError(w, http.StatusInternalServerError, "failed to delete file")
+		return
+	}
+
+	slog.Info("File deleted in workspace", "workspace", workspaceID, "path", filePath, "type", current.Type, "recursive", recursive)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileWriteAndDeleteInWorkspace(t *testing.T) {
+	srv, workspaceID, tmpDir, sessionID := newFileHandlerTestServer(t)
+	srv.config.FileUploadMaxBytes = 1024
+	srv.config.FileUploadTimeout = 30 * time.Second
+
+	do := func(handler http.HandlerFunc, method, query, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/workspaces/"+workspaceID+"/files?"+query, strings.NewReader(body))
+		req.SetPathValue("workspaceId", workspaceID)
+		req.AddCookie(&http.Cookie{Name: "session", Value: sessionID})
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	// Create a new file, including its parent directory.
+	rec := do(srv.handleFileWrite, http.MethodPut, "path=src/main.go", "package main\n", map[string]string{"If-None-Match": "*"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	var created FileWriteResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(tmpDir, "src", "main.go")); string(got) != "package main\n" {
+		t.Fatalf("file content = %q", got)
+	}
+	if created.Size != int64(len("package main\n")) || created.ETag == "" || rec.Header().Get("ETag") != created.ETag {
+		t.Fatalf("create response = %+v", created)
+	}
+
+	// Create-only and stale-ETag saves are refused.
+	if rec := do(srv.handleFileWrite, http.MethodPut, "path=src/main.go", "x", map[string]string{"If-None-Match": "*"}); rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("create-only over existing status = %d", rec.Code)
+	}
+	if rec := do(srv.handleFileWrite, http.MethodPut, "path=src/main.go", "x", map[string]string{"If-Match": `"0-0"`}); rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("stale If-Match status = %d", rec.Code)
+	}
+
+	// A save with the current ETag succeeds.
+	rec = do(srv.handleFileWrite, http.MethodPut, "path=src/main.go", "package main\n\nfunc main() {}\n", map[string]string{"If-Match": created.ETag})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	// Size limit, traversal, and the workdir root are rejected.
+	if rec := do(srv.handleFileWrite, http.MethodPut, "path=big.txt", strings.Repeat("x", 1025), nil); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized write status = %d", rec.Code)
+	}
+	for _, bad := range []string{"path=../escape.txt", "path=.", ""} {
+		if rec := do(srv.handleFileWrite, http.MethodPut, bad, "x", nil); rec.Code != http.StatusBadRequest {
+			t.Fatalf("write %q status = %d, want 400", bad, rec.Code)
+		}
+		if rec := do(srv.handleFileDelete, http.MethodDelete, bad, "", nil); rec.Code != http.StatusBadRequest {
+			t.Fatalf("delete %q status = %d, want 400", bad, rec.Code)
+		}
+	}
+
+	// Non-empty directories need recursive=true.
+	if rec := do(srv.handleFileDelete, http.MethodDelete, "path=src", "", nil); rec.Code != http.StatusConflict {
+		t.Fatalf("delete non-empty dir status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(srv.handleFileDelete, http.MethodDelete, "path=src/main.go", "", nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete file status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(srv.handleFileDelete, http.MethodDelete, "path=src/main.go", "", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("delete missing file status = %d", rec.Code)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "src", "other.go"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if rec := do(srv.handleFileDelete, http.MethodDelete, "path=src&recursive=true", "", nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("recursive delete status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "src")); !os.IsNotExist(err) {
+		t.Fatalf("src still exists after recursive delete: %v", err)
+	}
+}
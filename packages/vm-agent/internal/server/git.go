@@ -3,11 +3,16 @@ package server
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"path/filepath"
+	"strconv"
 	"strings"
+
+	"github.com/workspace/vm-agent/internal/faultinject"
 )
 
 // ---------- Response types ----------
@@ -24,6 +29,11 @@ type GitStatusResponse struct {
 	Staged    []GitFileStatus `json:"staged"`
 	Unstaged  []GitFileStatus `json:"unstaged"`
 	Untracked []GitFileStatus `json:"untracked"`
+	// Branch is the checked-out branch; empty when HEAD is detached.
+	Branch   string `json:"branch,omitempty"`
+	Upstream string `json:"upstream,omitempty"`
+	Ahead    int    `json:"ahead,omitempty"`
+	Behind   int    `json:"behind,omitempty"`
 }
 
 // GitDiffResponse contains a unified diff for a single file.
@@ -67,18 +77,20 @@ func (s *Server) handleGitStatus(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), s.config.GitExecTimeout)
 	defer cancel()
 
-	stdout, _, err := s.execInContainer(ctx, containerID, user, workDir, "git", "status", "--porcelain=v1")
+	stdout, _, err := s.execInContainer(ctx, containerID, user, workDir, "git", "status", "--porcelain=v1", "--branch")
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, fmt.Sprintf("git status failed: %v", err))
 		return
 	}
 
 	staged, unstaged, untracked := parseGitStatusPorcelain(stdout)
-	writeJSON(w, http.StatusOK, GitStatusResponse{
+	resp := GitStatusResponse{
 		Staged:    staged,
 		Unstaged:  unstaged,
 		Untracked: untracked,
-	})
+	}
+	resp.Branch, resp.Upstream, resp.Ahead, resp.Behind = parseGitStatusBranchHeader(stdout)
+	writeJSON(w, http.StatusOK, resp)
 }
 
 // handleGitDiff returns the unified diff for a single file.
@@ -212,17 +224,23 @@ func (s *Server) handleGitFile(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// GitBranchListResponse contains remote branches available in the repository.
+// GitBranchListResponse contains the branches available in the repository.
 type GitBranchListResponse struct {
 	Branches []GitBranchInfo `json:"branches"`
+	// Current is the checked-out branch; empty when HEAD is detached.
+	Current string `json:"current,omitempty"`
 }
 
 // GitBranchInfo represents a single branch name.
 type GitBranchInfo struct {
 	Name string `json:"name"`
+	// Local is true for branches that exist only in the workspace and have
+	// not been pushed to origin.
+	Local bool `json:"local,omitempty"`
 }
 
-// handleGitBranches returns the list of remote branches available in the workspace repository.
+// handleGitBranches returns the remote branches available in the workspace
+// repository, followed by any local-only branches, and the current branch.
 // GET /workspaces/{workspaceId}/git/branches
 func (s *Server) handleGitBranches(w http.ResponseWriter, r *http.Request) {
 	workspaceID := r.PathValue("workspaceId")
@@ -256,7 +274,30 @@ func (s *Server) handleGitBranches(w http.ResponseWriter, r *http.Request) {
 	}
 
 	branches := parseRemoteBranches(stdout)
-	writeJSON(w, http.StatusOK, GitBranchListResponse{Branches: branches})
+	if localOut, _, err := s.execInContainer(ctx, containerID, user, workDir, "git", "branch", "--format=%(refname:short)"); err == nil {
+		branches = appendLocalBranches(branches, localOut)
+	}
+	current, _, _ := s.execInContainer(ctx, containerID, user, workDir, "git", "branch", "--show-current")
+	writeJSON(w, http.StatusOK, GitBranchListResponse{Branches: branches, Current: strings.TrimSpace(current)})
+}
+
+// appendLocalBranches adds branches from `git branch --format='%(refname:short)'`
+// that are not already in branches, marked Local.
+func appendLocalBranches(branches []GitBranchInfo, output string) []GitBranchInfo {
+	seen := make(map[string]bool, len(branches))
+	for _, b := range branches {
+		seen[b.Name] = true
+	}
+	for _, line := range strings.Split(output, "\n") {
+		name := strings.TrimSpace(line)
+		// Skip blanks and "(HEAD detached at ...)" entries.
+		if name == "" || strings.HasPrefix(name, "(") || seen[name] {
+			continue
+		}
+		seen[name] = true
+		branches = append(branches, GitBranchInfo{Name: name, Local: true})
+	}
+	return branches
 }
 
 // parseRemoteBranches parses the output of `git branch -r --format='%(refname:short)'`
@@ -406,12 +447,23 @@ func (s *Server) resolveStandaloneWorkspaceExecContext(runtime *WorkspaceRuntime
 // execInContainer runs a command inside a devcontainer and returns stdout.
 // Uses docker exec with optional user and workdir flags.
 func (s *Server) execInContainer(ctx context.Context, containerID, user, workDir string, args ...string) (stdout string, stderr string, err error) {
+	return s.execInContainerWithStdin(ctx, containerID, user, workDir, nil, args...)
+}
+
+// execInContainerWithStdin is execInContainer with stdin fed to the command,
+// for input too large to pass as arguments.
+func (s *Server) execInContainerWithStdin(ctx context.Context, containerID, user, workDir string, stdin io.Reader, args ...string) (stdout string, stderr string, err error) {
+	if err := faultinject.Check(faultinject.DockerExec); err != nil {
+		return "", "", fmt.Errorf("command failed: %w", errors.Join(err, context.DeadlineExceeded))
+	}
+
 	cmd, err := s.workspaceExecCommand(ctx, containerID, user, workDir, args...)
 	if err != nil {
 		return "", "", err
 	}
 
 	var stdoutBuf, stderrBuf bytes.Buffer
+	cmd.Stdin = stdin
 	cmd.Stdout = &stdoutBuf
 	cmd.Stderr = &stderrBuf
 
@@ -439,7 +491,7 @@ func parseGitStatusPorcelain(output string) (staged, unstaged, untracked []GitFi
 
 	lines := strings.Split(output, "\n")
 	for _, line := range lines {
-		if len(line) < 3 {
+		if len(line) < 3 || strings.HasPrefix(line, "## ") {
 			continue
 		}
 
@@ -498,6 +550,38 @@ func parseGitStatusPorcelain(output string) (staged, unstaged, untracked []GitFi
 	return staged, unstaged, untracked
 }
 
+// parseGitStatusBranchHeader parses the "## " header that `git status
+// --porcelain=v1 --branch` prints first, e.g.
+// "## main...origin/main [ahead 1, behind 2]". Branch is empty when HEAD is
+// detached.
+func parseGitStatusBranchHeader(output string) (branch, upstream string, ahead, behind int) {
+	header, _, _ := strings.Cut(output, "\n")
+	header, ok := strings.CutPrefix(header, "## ")
+	if !ok {
+		return "", "", 0, 0
+	}
+	if rest, ok := strings.CutPrefix(header, "No commits yet on "); ok {
+		return strings.TrimSpace(rest), "", 0, 0
+	}
+	if rest, ok := strings.CutPrefix(header, "Initial commit on "); ok {
+		return strings.TrimSpace(rest), "", 0, 0
+	}
+	if strings.HasPrefix(header, "HEAD (no branch)") {
+		return "", "", 0, 0
+	}
+
+	refs, counts, _ := strings.Cut(header, " [")
+	branch, upstream, _ = strings.Cut(refs, "...")
+	for _, part := range strings.Split(strings.TrimSuffix(counts, "]"), ", ") {
+		if n, ok := strings.CutPrefix(part, "ahead "); ok {
+			ahead, _ = strconv.Atoi(n)
+		} else if n, ok := strings.CutPrefix(part, "behind "); ok {
+			behind, _ = strconv.Atoi(n)
+		}
+	}
+	return strings.TrimSpace(branch), strings.TrimSpace(upstream), ahead, behind
+}
+
 // formatAsAdditions converts file content into a unified diff format where all lines are additions.
 // Used for untracked files where `git diff` returns empty.
 func formatAsAdditions(filePath, content string) string {
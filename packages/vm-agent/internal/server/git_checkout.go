@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+type gitCheckoutRequest struct {
+	Branch string `json:"branch"`
+	// Create makes a new branch from BaseBranch (default HEAD) instead of
+	// switching to an existing one.
+	Create     bool   `json:"create"`
+	BaseBranch string `json:"baseBranch"`
+	// AllowDirty carries uncommitted changes to tracked files across the
+	// switch. git still refuses a switch that would overwrite them.
+	AllowDirty bool `json:"allowDirty"`
+}
+
+// GitCheckoutResponse describes the branch checked out by a checkout request.
+type GitCheckoutResponse struct {
+	Branch         string `json:"branch"`
+	PreviousBranch string `json:"previousBranch,omitempty"`
+	HeadCommit     string `json:"headCommit"`
+	Created        bool   `json:"created"`
+}
+
+// handleGitCheckout switches the workspace repository, or the worktree given
+// by ?worktree=, to another branch, optionally creating it. Switching a
+// working tree with uncommitted changes to tracked files is refused unless
+// allowDirty is set.
+// POST /workspaces/{workspaceId}/git/checkout
+func (s *Server) handleGitCheckout(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.PathValue("workspaceId")
+	if workspaceID == "" {
+		writeError(w, http.StatusBadRequest, "workspaceId is required")
+		return
+	}
+	if !s.requireWorkspaceRequestAuth(w, r, workspaceID) {
+		return
+	}
+
+	var req gitCheckoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	req.Branch = strings.TrimSpace(req.Branch)
+	if req.Branch == "" {
+		writeError(w, http.StatusBadRequest, "branch is required")
+		return
+	}
+	if strings.HasPrefix(req.Branch, "-") {
+		writeError(w, http.StatusBadRequest, "invalid branch name")
+		return
+	}
+	base := strings.TrimSpace(req.BaseBranch)
+	if req.Create && base != "" {
+		if err := sanitizeGitRef(base); err != nil || strings.HasPrefix(base, "-") {
+			writeError(w, http.StatusBadRequest, "invalid baseBranch")
+			return
+		}
+	}
+
+	containerID, primaryWorkDir, user, err := s.resolveContainerForWorkspace(workspaceID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	workDir, err := s.resolveWorktreeWorkDir(r, workspaceID, containerID, user, primaryWorkDir)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	release, err := s.lockWorkspaceGit(r.Context(), workspaceID, containerID, user, workDir, "checkout")
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.config.GitWorktreeTimeout)
+	defer cancel()
+
+	if _, _, err := s.execInContainer(ctx, containerID, user, workDir, "git", "check-ref-format", "--branch", req.Branch); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid branch name")
+		return
+	}
+
+	previous, _, _ := s.execInContainer(ctx, containerID, user, workDir, "git", "branch", "--show-current")
+	previous = strings.TrimSpace(previous)
+	if previous == req.Branch && !req.Create {
+		head, _, _ := s.execInContainer(ctx, containerID, user, workDir, "git", "rev-parse", "--short", "HEAD")
+		writeJSON(w, http.StatusOK, GitCheckoutResponse{Branch: req.Branch, PreviousBranch: previous, HeadCommit: strings.TrimSpace(head)})
+		return
+	}
+
+	if !req.AllowDirty {
+		statusOut, _, err := s.execInContainer(ctx, containerID, user, workDir, "git", "status", "--porcelain", "--untracked-files=no")
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("git status failed: %v", err))
+			return
+		}
+		if trimmed := strings.TrimSpace(statusOut); trimmed != "" {
+			writeJSON(w, http.StatusConflict, map[string]interface{}{
+				"error":          "WORKING_TREE_DIRTY",
+				"message":        "Working tree has uncommitted changes",
+				"dirtyFileCount": len(strings.Split(trimmed, "\n")),
+			})
+			return
+		}
+	}
+
+	// git refuses to check out a branch that another worktree already has.
+	// Report it as a conflict up front, like worktree creation does.
+	worktrees, err := s.listWorktrees(ctx, workspaceID, containerID, user, primaryWorkDir, true)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for _, wt := range worktrees {
+		if wt.Branch == req.Branch && wt.Path != workDir {
+			writeError(w, http.StatusConflict, fmt.Sprintf("branch '%s' is already checked out in worktree at %s", req.Branch, wt.Path))
+			return
+		}
+	}
+
+	localExists := s.gitRefExists(ctx, containerID, user, workDir, "refs/heads/"+req.Branch)
+	var args []string
+	switch {
+	case req.Create:
+		if localExists {
+			writeError(w, http.StatusConflict, fmt.Sprintf("branch '%s' already exists", req.Branch))
+			return
+		}
+		if base == "" {
+			base = "HEAD"
+		}
+		args = []string{"git", "switch", "--no-track", "-c", req.Branch, base}
+	case localExists:
+		args = []string{"git", "switch", req.Branch}
+	case s.gitRefExists(ctx, containerID, user, workDir, "refs/remotes/origin/"+req.Branch):
+		args = []string{"git", "switch", "--track", "-c", req.Branch, "origin/" + req.Branch}
+	default:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("branch '%s' does not exist", req.Branch))
+		return
+	}
+
+	if _, stderr, err := s.execInContainer(ctx, containerID, user, workDir, args...); err != nil {
+		if strings.Contains(stderr, "would be overwritten") {
+			writeError(w, http.StatusConflict, fmt.Sprintf("checkout would overwrite local changes: %s", stderr))
+			return
+		}
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("git switch failed: %v: %s", err, stderr))
+		return
+	}
+
+	s.invalidateWorktreeCache(workspaceID)
+	head, _, _ := s.execInContainer(ctx, containerID, user, workDir, "git", "rev-parse", "--short", "HEAD")
+	slog.Info("Switched workspace branch", "workspaceID", workspaceID, "workDir", workDir, "from", previous, "to", req.Branch, "created", req.Create)
+	writeJSON(w, http.StatusOK, GitCheckoutResponse{
+		Branch:         req.Branch,
+		PreviousBranch: previous,
+		HeadCommit:     strings.TrimSpace(head),
+		Created:        req.Create,
+	})
+}
+
+// gitRefExists reports whether ref resolves to a commit in the repository at workDir.
+func (s *Server) gitRefExists(ctx context.Context, containerID, user, workDir, ref string) bool {
+	_, _, err := s.execInContainer(ctx, containerID, user, workDir, "git", "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	return err == nil
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func runTestGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestGitCheckoutInStandaloneWorkspace(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	srv, workspaceID, repoDir, sessionID := newFileHandlerTestServer(t)
+	srv.config.GitExecTimeout = 10 * time.Second
+	srv.config.GitWorktreeTimeout = 10 * time.Second
+	srv.config.WorktreeCacheTTL = time.Second
+	srv.worktreeCache = map[string]cachedWorktreeList{}
+
+	runTestGit(t, repoDir, "init", "-q", "-b", "main")
+	if err := os.WriteFile(filepath.Join(repoDir, "README.md"), []byte("hello\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	runTestGit(t, repoDir, "add", "README.md")
+	runTestGit(t, repoDir, "commit", "-q", "-m", "initial")
+	runTestGit(t, repoDir, "remote", "add", "origin", "https://git.example.com/team/repo.git")
+	runTestGit(t, repoDir, "update-ref", "refs/remotes/origin/remote-only", "HEAD")
+
+	checkout := func(body string) (*httptest.ResponseRecorder, GitCheckoutResponse) {
+		req := httptest.NewRequest(http.MethodPost, "/workspaces/"+workspaceID+"/git/checkout", strings.NewReader(body))
+		req.SetPathValue("workspaceId", workspaceID)
+		req.AddCookie(&http.Cookie{Name: "session", Value: sessionID})
+		rec := httptest.NewRecorder()
+		srv.handleGitCheckout(rec, req)
+		var resp GitCheckoutResponse
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+		}
+		return rec, resp
+	}
+
+	// Create a new branch from HEAD.
+	rec, resp := checkout(`{"branch":"feature/login","create":true}`)
+	if rec.Code != http.StatusOK || !resp.Created || resp.Branch != "feature/login" || resp.PreviousBranch != "main" || resp.HeadCommit == "" {
+		t.Fatalf("create = %d %s", rec.Code, rec.Body.String())
+	}
+	if got := runTestGit(t, repoDir, "branch", "--show-current"); got != "feature/login" {
+		t.Fatalf("current branch = %q, want feature/login", got)
+	}
+
+	// Invalid, missing, and duplicate branches are rejected.
+	for body, want := range map[string]int{
+		`{"branch":""}`:          http.StatusBadRequest,
+		`{"branch":"bad..name"}`: http.StatusBadRequest,
+		`{"branch":"-f"}`:        http.StatusBadRequest,
+		`{"branch":"x","create":true,"baseBranch":"-"}`: http.StatusBadRequest,
+		`{"branch":"does-not-exist"}`:                   http.StatusNotFound,
+		`{"branch":"main","create":true}`:               http.StatusConflict,
+	} {
+		if rec, _ := checkout(body); rec.Code != want {
+			t.Errorf("checkout %s = %d %s, want %d", body, rec.Code, rec.Body.String(), want)
+		}
+	}
+
+	// A dirty working tree blocks the switch unless allowDirty is set.
+	if err := os.WriteFile(filepath.Join(repoDir, "README.md"), []byte("changed\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rec, _ = checkout(`{"branch":"main"}`)
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "WORKING_TREE_DIRTY") {
+		t.Fatalf("dirty checkout = %d %s, want 409 WORKING_TREE_DIRTY", rec.Code, rec.Body.String())
+	}
+	rec, resp = checkout(`{"branch":"main","allowDirty":true}`)
+	if rec.Code != http.StatusOK || resp.Branch != "main" || resp.PreviousBranch != "feature/login" {
+		t.Fatalf("allowDirty checkout = %d %s", rec.Code, rec.Body.String())
+	}
+	if data, _ := os.ReadFile(filepath.Join(repoDir, "README.md")); string(data) != "changed\n" {
+		t.Fatalf("local change not carried across checkout: %q", data)
+	}
+	runTestGit(t, repoDir, "checkout", "--", "README.md")
+
+	// A branch that only exists on origin is checked out as a tracking branch.
+	rec, resp = checkout(`{"branch":"remote-only"}`)
+	if rec.Code != http.StatusOK || resp.Created {
+		t.Fatalf("remote checkout = %d %s", rec.Code, rec.Body.String())
+	}
+	if got := runTestGit(t, repoDir, "rev-parse", "--abbrev-ref", "remote-only@{upstream}"); got != "origin/remote-only" {
+		t.Fatalf("upstream = %q, want origin/remote-only", got)
+	}
+
+	// Checking out the current branch is a no-op.
+	if rec, resp := checkout(`{"branch":"remote-only"}`); rec.Code != http.StatusOK || resp.PreviousBranch != "remote-only" {
+		t.Fatalf("no-op checkout = %d %s", rec.Code, rec.Body.String())
+	}
+}
@@ -13,9 +13,46 @@ import (
 	"net/url"
 	"strings"
 
+	"github.com/workspace/vm-agent/internal/bootstrap/gitprovider"
 	"github.com/workspace/vm-agent/internal/gitrepo"
+	"github.com/workspace/vm-agent/internal/redact"
 )
 
+// gitTokenPurpose names the operation a git token is requested for, so the
+// control plane can issue a short-lived token with only the scopes that
+// operation needs. A control plane that issues a single token per workspace
+// ignores the purpose and returns that token for every request.
+type gitTokenPurpose string
+
+const (
+	// gitTokenPurposeDefault requests the workspace's general-purpose token.
+	gitTokenPurposeDefault gitTokenPurpose = ""
+	// gitTokenPurposeClone requests a read-only token for clone and fetch.
+	gitTokenPurposeClone gitTokenPurpose = "clone"
+	// gitTokenPurposePush requests a token with contents:write for push.
+	gitTokenPurposePush gitTokenPurpose = "push"
+	// gitTokenPurposePullRequest requests a token that can create pull
+	// requests. It backs GH_TOKEN and the pull request APIs.
+	gitTokenPurposePullRequest gitTokenPurpose = "pull_request"
+)
+
+// gitTokenPurposeForCapability maps the capability a credential helper asks
+// for on /git-credential to a token purpose. Helpers installed before
+// capabilities existed send none and get the default token.
+func gitTokenPurposeForCapability(capability string) (gitTokenPurpose, bool) {
+	switch strings.ToLower(strings.TrimSpace(capability)) {
+	case "":
+		return gitTokenPurposeDefault, true
+	case "read":
+		return gitTokenPurposeClone, true
+	case "push":
+		return gitTokenPurposePush, true
+	case "pr":
+		return gitTokenPurposePullRequest, true
+	}
+	return "", false
+}
+
 type gitTokenResponse struct {
 	Provider  string `json:"provider,omitempty"`
 	Token     string `json:"token"`
@@ -38,17 +75,25 @@ func (s *Server) handleGitCredential(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	purpose, ok := gitTokenPurposeForCapability(r.URL.Query().Get("capability"))
+	if !ok {
+		writeError(w, http.StatusBadRequest, "unknown capability")
+		return
+	}
+
 	bearerToken := bearerTokenFromHeader(r.Header.Get("Authorization"))
 	requestedHost := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("host")))
 	requestedPath := strings.TrimSpace(r.URL.Query().Get("path"))
-	// GitLab-bound workspaces vend a broad user OAuth token, so the exchange is
-	// fail-closed: the caller must identify both the host and the repository path
-	// it is requesting credentials for. GitHub/Artifacts keep the empty-allow
-	// behavior because the gh wrapper flow sends host=github.com with no path.
+	// GitLab- and Bitbucket-bound workspaces vend a broad user token, so the
+	// exchange is fail-closed: the caller must identify both the host and the
+	// repository path it is requesting credentials for. GitHub/Artifacts keep the
+	// empty-allow behavior because the gh wrapper flow sends host=github.com with
+	// no path.
 	boundProvider, _ := s.credentialPathBinding(workspaceID)
-	if strings.EqualFold(boundProvider, "gitlab") && (requestedHost == "" || requestedPath == "") {
-		slog.Warn("Git credential request refused: gitlab-bound workspace requires host and path",
+	if gitprovider.ForConfig(boundProvider).PathBound && (requestedHost == "" || requestedPath == "") {
+		slog.Warn("Git credential request refused: path-bound workspace requires host and path",
 			"workspaceID", workspaceID,
+			"provider", boundProvider,
 			"hasHost", requestedHost != "",
 			"hasPath", requestedPath != "",
 		)
@@ -64,9 +109,9 @@ func (s *Server) handleGitCredential(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp, err := s.fetchGitTokenResponseForWorkspace(r.Context(), workspaceID, bearerToken)
+	resp, err := s.fetchGitTokenResponseForWorkspace(r.Context(), workspaceID, bearerToken, purpose)
 	if err != nil {
-		slog.Error("Failed to fetch git token", "error", err)
+		slog.Error("Failed to fetch git token", "purpose", purpose, "error", err)
 		writeError(w, http.StatusBadGateway, "failed to fetch git token")
 		return
 	}
@@ -87,6 +132,10 @@ func (s *Server) handleGitCredential(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 	}
+	if provider := gitprovider.ForConfig(resp.Provider); provider.HostBound {
+		host = provider.DefaultHost
+		username = provider.TokenUsername
+	}
 	if resp.Host != "" {
 		host = strings.ToLower(strings.TrimSpace(resp.Host))
 	}
@@ -94,15 +143,17 @@ func (s *Server) handleGitCredential(w http.ResponseWriter, r *http.Request) {
 		username = strings.TrimSpace(resp.Username)
 	}
 
-	// Response-side fail-closed gate for GitLab credentials: regardless of what
-	// the local binding said pre-fetch, a GitLab token is only released when the
-	// caller supplied a host and path AND both verifiably match the credential
-	// the control plane resolved. An empty resolved repositoryPath means we
-	// cannot verify the binding — refuse rather than vend a broad OAuth token.
-	gitlabResponse := strings.EqualFold(strings.TrimSpace(resp.Provider), "gitlab")
-	if gitlabResponse && (requestedHost == "" || requestedPath == "" || repositoryPath == "") {
-		slog.Warn("Git credential response withheld: gitlab credential requires verified host and path",
+	// Response-side fail-closed gate for GitLab and Bitbucket credentials:
+	// regardless of what the local binding said pre-fetch, such a token is only
+	// released when the caller supplied a host and path AND both verifiably
+	// match the credential the control plane resolved. An empty resolved
+	// repositoryPath means we cannot verify the binding — refuse rather than
+	// vend a broad user token.
+	pathBoundResponse := gitprovider.ForConfig(resp.Provider).PathBound
+	if pathBoundResponse && (requestedHost == "" || requestedPath == "" || repositoryPath == "") {
+		slog.Warn("Git credential response withheld: path-bound credential requires verified host and path",
 			"workspaceID", workspaceID,
+			"provider", resp.Provider,
 			"hasRequestedHost", requestedHost != "",
 			"hasRequestedPath", requestedPath != "",
 			"hasResolvedPath", repositoryPath != "",
@@ -114,7 +165,8 @@ func (s *Server) handleGitCredential(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
-	if requestedPath != "" && repositoryPath != "" && !credentialPathMatchesRequest(repositoryPath, requestedPath) {
+	if requestedPath != "" && repositoryPath != "" && !credentialPathMatchesRequest(repositoryPath, requestedPath) &&
+		!s.hasCredentialRemotePath(workspaceID, requestedPath) {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
@@ -144,10 +196,16 @@ func (s *Server) isAllowedCredentialHostForWorkspace(workspaceID, requestedHost
 	if requestedHost == "" || gitrepo.IsKnownGitHost(requestedHost) {
 		return true
 	}
+	provider, repositoryHost := "", ""
 	if runtime, ok := s.getWorkspaceRuntime(workspaceID); ok {
-		return strings.EqualFold(strings.TrimSpace(runtime.RepositoryHost), requestedHost)
+		provider, repositoryHost = runtime.RepoProvider, runtime.RepositoryHost
+	} else {
+		provider, repositoryHost = s.config.RepoProvider, s.config.RepositoryHost
+	}
+	if bound := gitprovider.ForConfig(provider); bound.HostBound {
+		return bound.MatchesHost(requestedHost, repositoryHost)
 	}
-	return strings.EqualFold(strings.TrimSpace(s.config.RepositoryHost), requestedHost)
+	return strings.EqualFold(strings.TrimSpace(repositoryHost), requestedHost)
 }
 
 func (s *Server) isAllowedCredentialPathForWorkspace(workspaceID, requestedPath string) bool {
@@ -160,20 +218,24 @@ func (s *Server) isAllowedCredentialPathForWorkspace(workspaceID, requestedPath
 	// request racing with runtime setup), fall back to the process config instead
 	// of failing open — mirroring isAllowedCredentialHostForWorkspace.
 	provider, repositoryPath := s.credentialPathBinding(workspaceID)
-	if !strings.EqualFold(provider, "gitlab") {
+	if !gitprovider.ForConfig(provider).PathBound {
 		return true
 	}
-	// Fail closed: a gitlab-bound workspace with no bound repository path cannot
-	// verify the request, so refuse rather than vend a broad user OAuth token.
+	// Fail closed: a path-bound workspace with no bound repository path cannot
+	// verify the request, so refuse rather than vend a broad user token.
 	// (The response-side gate re-checks this against the control-plane-resolved
 	// path, but the pre-fetch gate must not be the weaker of the two.)
 	if repositoryPath == "" {
-		slog.Warn("Git credential path check refused: gitlab-bound workspace has no bound repository path",
+		slog.Warn("Git credential path check refused: path-bound workspace has no bound repository path",
 			"workspaceID", workspaceID,
+			"provider", provider,
 		)
 		return false
 	}
-	return credentialPathMatchesRequest(repositoryPath, requestedPath)
+	// Remotes added through the git remotes API (e.g. a fork) are explicitly
+	// authorized by the workspace user and served like the bound repository.
+	return credentialPathMatchesRequest(repositoryPath, requestedPath) ||
+		s.hasCredentialRemotePath(workspaceID, requestedPath)
 }
 
 func (s *Server) credentialPathBinding(workspaceID string) (provider, repositoryPath string) {
@@ -186,6 +248,11 @@ func (s *Server) credentialPathBinding(workspaceID string) (provider, repository
 func credentialPathMatchesRequest(repositoryPath, requestedPath string) bool {
 	normalize := func(path string) string {
 		path = strings.TrimSpace(path)
+		// Git LFS asks for credentials for the repository's LFS endpoint,
+		// <repo>.git/info/lfs, which is served with the repository's token.
+		if i := strings.Index(path, "/info/lfs"); i >= 0 {
+			path = path[:i]
+		}
 		path = strings.TrimPrefix(path, "/")
 		path = strings.TrimSuffix(path, ".git")
 		path = strings.TrimSuffix(path, "/")
@@ -196,27 +263,16 @@ func credentialPathMatchesRequest(repositoryPath, requestedPath string) bool {
 	return repositoryPath != "" && requestedPath != "" && repositoryPath == requestedPath
 }
 
-func (s *Server) fetchGitToken(ctx context.Context) (string, error) {
-	resp, err := s.fetchGitTokenResponseForWorkspace(ctx, s.config.WorkspaceID, s.config.CallbackToken)
+func (s *Server) fetchGitTokenForWorkspace(ctx context.Context, workspaceID, callbackToken string, purpose gitTokenPurpose) (string, error) {
+	resp, err := s.fetchGitTokenResponseForWorkspace(ctx, workspaceID, callbackToken, purpose)
 	if err != nil {
 		return "", err
 	}
 	return resp.Token, nil
 }
 
-func (s *Server) fetchGitTokenForWorkspace(ctx context.Context, workspaceID, callbackToken string) (string, error) {
-	resp, err := s.fetchGitTokenResponseForWorkspace(ctx, workspaceID, callbackToken)
-	if err != nil {
-		return "", err
-	}
-	return resp.Token, nil
-}
-
-func (s *Server) fetchGitTokenResponseForWorkspace(ctx context.Context, workspaceID, callbackToken string) (*gitTokenResponse, error) {
-	targetWorkspaceID := strings.TrimSpace(workspaceID)
-	if targetWorkspaceID == "" {
-		targetWorkspaceID = strings.TrimSpace(s.config.WorkspaceID)
-	}
+func (s *Server) fetchGitTokenResponseForWorkspace(ctx context.Context, workspaceID, callbackToken string, purpose gitTokenPurpose) (*gitTokenResponse, error) {
+	targetWorkspaceID := s.workspaces.Resolve(workspaceID)
 	if targetWorkspaceID == "" {
 		return nil, fmt.Errorf("workspace id is required for git-token request")
 	}
@@ -234,7 +290,11 @@ func (s *Server) fetchGitTokenResponseForWorkspace(ctx context.Context, workspac
 		strings.TrimRight(s.config.ControlPlaneURL, "/"),
 		targetWorkspaceID,
 	)
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader([]byte("{}")))
+	reqBody := []byte("{}")
+	if purpose != gitTokenPurposeDefault {
+		reqBody, _ = json.Marshal(map[string]string{"purpose": string(purpose)})
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(reqBody))
 	if err != nil {
 		return nil, fmt.Errorf("failed to build git-token request: %w", err)
 	}
@@ -262,6 +322,7 @@ func (s *Server) fetchGitTokenResponseForWorkspace(ctx context.Context, workspac
 	if payload.Token == "" {
 		return nil, fmt.Errorf("git-token response missing token")
 	}
+	redact.Register(payload.Token)
 
 	return &payload, nil
 }
@@ -299,20 +360,13 @@ func isAuthorizedGitCredentialRequest(s *Server, r *http.Request, workspaceID st
 // ~1h, owner-scoped) token covering only the project's primary repository plus any
 // explicitly granted additional repositories, so equal treatment here does not widen
 // the blast radius: it only lets each running workspace refresh its own token over the
-// loopback interface. An empty workspace id defaults to the node's primary so the
+// loopback interface. An empty workspace id resolves to the boot workspace so the
 // single-workspace host path is unchanged.
 func isKnownWorkspaceGitCredentialRequest(s *Server, workspaceID string) bool {
-	requestedWorkspaceID := strings.TrimSpace(workspaceID)
-	primaryWorkspaceID := strings.TrimSpace(s.config.WorkspaceID)
-	if requestedWorkspaceID == "" {
-		requestedWorkspaceID = primaryWorkspaceID
-	}
+	requestedWorkspaceID := s.workspaces.Resolve(workspaceID)
 	if requestedWorkspaceID == "" {
 		return false
 	}
-	if primaryWorkspaceID != "" && requestedWorkspaceID == primaryWorkspaceID {
-		return true
-	}
 	_, ok := s.getWorkspaceRuntime(requestedWorkspaceID)
 	return ok
 }
@@ -0,0 +1,505 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// gitLogDefaultLimit and gitLogMaxLimit bound the ?limit= commits of one
+	// git/log page.
+	gitLogDefaultLimit = 50
+	gitLogMaxLimit     = 500
+	// gitCommitFormat prints the GitCommit fields of a commit, separated by
+	// US and terminated by RS.
+	gitCommitFormat = "--format=%H%x1f%P%x1f%an%x1f%ae%x1f%aI%x1f%cn%x1f%ce%x1f%cI%x1f%s%x1f%b%x1e"
+	// gitUncommittedSHA is the commit git blame reports for lines that are
+	// not committed yet.
+	gitUncommittedSHA = "0000000000000000000000000000000000000000"
+)
+
+// errGitRevisionNotFound is returned when a ref, commit or path does not
+// exist in the repository.
+var errGitRevisionNotFound = errors.New("revision or path not found")
+
+// GitCommit describes one commit.
+type GitCommit struct {
+	SHA            string   `json:"sha"`
+	Parents        []string `json:"parents"`
+	AuthorName     string   `json:"authorName"`
+	AuthorEmail    string   `json:"authorEmail"`
+	AuthoredAt     string   `json:"authoredAt"` // ISO 8601
+	CommitterName  string   `json:"committerName"`
+	CommitterEmail string   `json:"committerEmail"`
+	CommittedAt    string   `json:"committedAt"` // ISO 8601
+	Subject        string   `json:"subject"`
+	Body           string   `json:"body,omitempty"`
+	// Agent is set for commits an agent made in SAM, from the trailers the
+	// commit hooks add (see GIT_COMMIT_TRAILERS).
+	Agent *GitCommitAgent `json:"agent,omitempty"`
+}
+
+// GitCommitAgent identifies the agent session and prompt behind a commit.
+type GitCommitAgent struct {
+	SessionID     string `json:"sessionId"`
+	PromptID      string `json:"promptId,omitempty"`
+	ChatSessionID string `json:"chatSessionId,omitempty"`
+	TaskID        string `json:"taskId,omitempty"`
+}
+
+// GitLogResponse is one page of commit history.
+type GitLogResponse struct {
+	Commits []GitCommit `json:"commits"`
+	HasMore bool        `json:"hasMore"`
+}
+
+// GitBlameResponse attributes each line of a file to a commit.
+type GitBlameResponse struct {
+	Path   string          `json:"path"`
+	Ranges []GitBlameRange `json:"ranges"`
+	// Commits holds the commits named by Ranges, by SHA. Uncommitted lines
+	// name gitUncommittedSHA, which has no entry.
+	Commits map[string]GitCommit `json:"commits"`
+}
+
+// GitBlameRange is a run of consecutive lines last changed by one commit.
+type GitBlameRange struct {
+	SHA       string `json:"sha"`
+	StartLine int    `json:"startLine"` // 1-based
+	Lines     int    `json:"lines"`
+}
+
+// GitShowResponse describes what a commit changed, against its first parent.
+type GitShowResponse struct {
+	Commit GitCommit        `json:"commit"`
+	Files  []GitChangedFile `json:"files"`
+	Diff   string           `json:"diff"`
+	// DiffTruncated is set when the diff was cut at GIT_FILE_MAX_SIZE bytes.
+	DiffTruncated bool `json:"diffTruncated,omitempty"`
+}
+
+// GitChangedFile is one file changed by a commit.
+type GitChangedFile struct {
+	Path      string `json:"path"`
+	OldPath   string `json:"oldPath,omitempty"` // set for renames and copies
+	Status    string `json:"status"`            // "A", "M", "D", "R", "C", "T"
+	Additions int    `json:"additions"`
+	Deletions int    `json:"deletions"`
+	Binary    bool   `json:"binary,omitempty"`
+}
+
+// ---------- Handlers ----------
+
+// handleGitLog returns commit history, newest first.
+// GET /workspaces/{workspaceId}/git/log?ref=&path=&limit=&skip=
+func (s *Server) handleGitLog(w http.ResponseWriter, r *http.Request) {
+	containerID, workDir, user, ok := s.gitHistoryInput(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	ref, path := query.Get("ref"), query.Get("path")
+	if !validGitHistoryArgs(w, ref, path) {
+		return
+	}
+	limit, skip := gitLogDefaultLimit, 0
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > gitLogMaxLimit {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", gitLogMaxLimit))
+			return
+		}
+		limit = n
+	}
+	if raw := query.Get("skip"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "skip must be a non-negative integer")
+			return
+		}
+		skip = n
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.config.GitExecTimeout)
+	defer cancel()
+	resp, err := s.gitLog(ctx, containerID, user, workDir, ref, path, skip, limit)
+	writeGitHistoryResult(w, "git log", resp, err)
+}
+
+// handleGitBlame returns the commit that last changed each line of a file.
+// Without ?ref= the working tree is blamed, so uncommitted lines show up.
+// GET /workspaces/{workspaceId}/git/blame?path=&ref=
+func (s *Server) handleGitBlame(w http.ResponseWriter, r *http.Request) {
+	containerID, workDir, user, ok := s.gitHistoryInput(w, r)
+	if !ok {
+		return
+	}
+	ref, path := r.URL.Query().Get("ref"), r.URL.Query().Get("path")
+	if path == "" {
+		writeError(w, http.StatusBadRequest, "path query parameter is required")
+		return
+	}
+	if !validGitHistoryArgs(w, ref, path) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.config.GitExecTimeout)
+	defer cancel()
+	resp, err := s.gitBlame(ctx, containerID, user, workDir, ref, path)
+	writeGitHistoryResult(w, "git blame", resp, err)
+}
+
+// handleGitShow returns a commit with the files it changed and its diff.
+// GET /workspaces/{workspaceId}/git/show/{sha}
+func (s *Server) handleGitShow(w http.ResponseWriter, r *http.Request) {
+	containerID, workDir, user, ok := s.gitHistoryInput(w, r)
+	if !ok {
+		return
+	}
+	sha := r.PathValue("sha")
+	if sha == "" {
+		writeError(w, http.StatusBadRequest, "sha is required")
+		return
+	}
+	if !validGitHistoryArgs(w, sha, "") {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.config.GitExecTimeout)
+	defer cancel()
+	resp, err := s.gitShow(ctx, containerID, user, workDir, sha)
+	writeGitHistoryResult(w, "git show", resp, err)
+}
+
+// gitHistoryInput authenticates a git history request and resolves where
+// it runs.
+func (s *Server) gitHistoryInput(w http.ResponseWriter, r *http.Request) (containerID, workDir, user string, ok bool) {
+	workspaceID := r.PathValue("workspaceId")
+	if workspaceID == "" {
+		writeError(w, http.StatusBadRequest, "workspaceId is required")
+		return "", "", "", false
+	}
+	if !s.requireWorkspaceRequestAuth(w, r, workspaceID) {
+		return "", "", "", false
+	}
+	containerID, workDir, user, err := s.resolveContainerForWorkspace(workspaceID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return "", "", "", false
+	}
+	workDir, err = s.resolveWorktreeWorkDir(r, workspaceID, containerID, user, workDir)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return "", "", "", false
+	}
+	return containerID, workDir, user, true
+}
+
+// validGitHistoryArgs validates an optional ref and path. A ref must not
+// look like an option, since it is passed to git as an argument.
+func validGitHistoryArgs(w http.ResponseWriter, ref, path string) bool {
+	if ref != "" {
+		if err := sanitizeGitRef(ref); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return false
+		}
+		if strings.HasPrefix(ref, "-") {
+			writeError(w, http.StatusBadRequest, "git ref must not start with '-'")
+			return false
+		}
+	}
+	if path != "" {
+		if err := sanitizeFilePath(path); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return false
+		}
+	}
+	return true
+}
+
+func writeGitHistoryResult(w http.ResponseWriter, op string, resp any, err error) {
+	switch {
+	case errors.Is(err, errGitRevisionNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("%s failed: %v", op, err))
+	default:
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// ---------- Git ----------
+
+// gitHistoryError turns a failed git command into errGitRevisionNotFound
+// when git could not resolve a ref, commit or path.
+func gitHistoryError(err error, stderr string) error {
+	for _, marker := range []string{"unknown revision", "bad revision", "bad object", "no such path", "no such ref", "ambiguous argument", "not a valid object name"} {
+		if strings.Contains(stderr, marker) {
+			return fmt.Errorf("%w: %s", errGitRevisionNotFound, strings.TrimSpace(stderr))
+		}
+	}
+	if stderr = strings.TrimSpace(stderr); stderr != "" {
+		return fmt.Errorf("%w: %s", err, stderr)
+	}
+	return err
+}
+
+func (s *Server) gitLog(ctx context.Context, containerID, user, workDir, ref, path string, skip, limit int) (GitLogResponse, error) {
+	args := []string{"git", "log", "--no-color", gitCommitFormat, "--max-count=" + strconv.Itoa(limit+1), "--skip=" + strconv.Itoa(skip)}
+	if path != "" {
+		args = append(args, "--follow")
+	}
+	if ref != "" {
+		args = append(args, ref)
+	}
+	args = append(args, "--")
+	if path != "" {
+		args = append(args, path)
+	}
+	stdout, stderr, err := s.execInContainer(ctx, containerID, user, workDir, args...)
+	if err != nil {
+		if ref == "" && strings.Contains(stderr, "does not have any commits yet") {
+			return GitLogResponse{Commits: []GitCommit{}}, nil
+		}
+		return GitLogResponse{}, gitHistoryError(err, stderr)
+	}
+	commits := parseGitCommits(stdout)
+	resp := GitLogResponse{Commits: commits, HasMore: len(commits) > limit}
+	if resp.HasMore {
+		resp.Commits = commits[:limit]
+	}
+	return resp, nil
+}
+
+func (s *Server) gitBlame(ctx context.Context, containerID, user, workDir, ref, path string) (GitBlameResponse, error) {
+	args := []string{"git", "blame", "--porcelain"}
+	if ref != "" {
+		args = append(args, ref)
+	}
+	stdout, stderr, err := s.execInContainer(ctx, containerID, user, workDir, append(args, "--", path)...)
+	if err != nil {
+		return GitBlameResponse{}, gitHistoryError(err, stderr)
+	}
+	resp := GitBlameResponse{Path: path, Ranges: parseGitBlamePorcelain(stdout), Commits: map[string]GitCommit{}}
+
+	// A long-lived file can be blamed to thousands of commits, more than fit
+	// in one argv, so the commits are passed to git log on stdin.
+	var revs strings.Builder
+	seen := map[string]bool{}
+	for _, blameRange := range resp.Ranges {
+		if blameRange.SHA != gitUncommittedSHA && !seen[blameRange.SHA] {
+			seen[blameRange.SHA] = true
+			revs.WriteString(blameRange.SHA + "\n")
+		}
+	}
+	if len(seen) == 0 {
+		return resp, nil
+	}
+	stdout, stderr, err = s.execInContainerWithStdin(ctx, containerID, user, workDir, strings.NewReader(revs.String()),
+		"git", "log", "--stdin", "--no-walk=unsorted", "--no-color", gitCommitFormat, "--")
+	if err != nil {
+		return GitBlameResponse{}, gitHistoryError(err, stderr)
+	}
+	for _, commit := range parseGitCommits(stdout) {
+		resp.Commits[commit.SHA] = commit
+	}
+	return resp, nil
+}
+
+func (s *Server) gitShow(ctx context.Context, containerID, user, workDir, sha string) (GitShowResponse, error) {
+	stdout, stderr, err := s.execInContainer(ctx, containerID, user, workDir, "git", "log", "--max-count=1", "--no-color", gitCommitFormat, sha, "--")
+	if err != nil {
+		return GitShowResponse{}, gitHistoryError(err, stderr)
+	}
+	commits := parseGitCommits(stdout)
+	if len(commits) == 0 {
+		return GitShowResponse{}, fmt.Errorf("%w: %s", errGitRevisionNotFound, sha)
+	}
+	resp := GitShowResponse{Commit: commits[0]}
+
+	// Diff against the first parent, so merges show what they brought in.
+	// diff-tree --root diffs a root commit against the empty tree.
+	diffArgs := []string{"git", "diff-tree", "-r", "-M", "--root", "--no-commit-id", "--no-color"}
+	revisions := []string{resp.Commit.SHA}
+	if len(resp.Commit.Parents) > 0 {
+		revisions = []string{resp.Commit.Parents[0], resp.Commit.SHA}
+	}
+
+	stdout, stderr, err = s.execInContainer(ctx, containerID, user, workDir, append(append(diffArgs, "--raw", "--numstat", "-z"), revisions...)...)
+	if err != nil {
+		return GitShowResponse{}, gitHistoryError(err, stderr)
+	}
+	resp.Files = parseGitRawNumstat(stdout)
+
+	stdout, stderr, err = s.execInContainer(ctx, containerID, user, workDir, append(append(diffArgs, "--patch"), revisions...)...)
+	if err != nil {
+		return GitShowResponse{}, gitHistoryError(err, stderr)
+	}
+	resp.Diff = stdout
+	if maxSize := s.config.GitFileMaxSize; maxSize > 0 && len(resp.Diff) > maxSize {
+		resp.Diff = resp.Diff[:maxSize]
+		resp.DiffTruncated = true
+	}
+	return resp, nil
+}
+
+// ---------- Parsers ----------
+
+// parseGitCommits parses git log output printed with gitCommitFormat.
+func parseGitCommits(output string) []GitCommit {
+	commits := []GitCommit{}
+	for _, record := range strings.Split(output, "\x1e") {
+		fields := strings.Split(strings.TrimLeft(record, "\n"), "\x1f")
+		if len(fields) != 10 {
+			continue
+		}
+		commit := GitCommit{
+			SHA:            fields[0],
+			Parents:        strings.Fields(fields[1]),
+			AuthorName:     fields[2],
+			AuthorEmail:    fields[3],
+			AuthoredAt:     normalizeGitTime(fields[4]),
+			CommitterName:  fields[5],
+			CommitterEmail: fields[6],
+			CommittedAt:    normalizeGitTime(fields[7]),
+			Subject:        fields[8],
+			Body:           strings.TrimSpace(fields[9]),
+		}
+		commit.Agent = parseGitCommitAgent(commit.Body)
+		commits = append(commits, commit)
+	}
+	return commits
+}
+
+// normalizeGitTime converts git's strict ISO 8601 dates to UTC RFC 3339.
+func normalizeGitTime(value string) string {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return value
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// parseGitCommitAgent reads the SAM-* trailers of a commit message body.
+func parseGitCommitAgent(body string) *GitCommitAgent {
+	var agent GitCommitAgent
+	for _, line := range strings.Split(body, "\n") {
+		key, value, ok := strings.Cut(line, ": ")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "SAM-Session":
+			agent.SessionID = value
+		case "SAM-Prompt":
+			agent.PromptID = value
+		case "SAM-Chat-Session":
+			agent.ChatSessionID = value
+		case "SAM-Task":
+			agent.TaskID = value
+		}
+	}
+	if agent.SessionID == "" {
+		return nil
+	}
+	return &agent
+}
+
+// parseGitBlamePorcelain parses git blame --porcelain output into runs of
+// consecutive lines from the same commit.
+func parseGitBlamePorcelain(output string) []GitBlameRange {
+	ranges := []GitBlameRange{}
+	for _, line := range strings.Split(output, "\n") {
+		// Each line of the file has a header "<sha> <orig line> <final line>
+		// [<group size>]", commit details the first time a commit appears,
+		// and the content prefixed by a tab. Only headers matter here.
+		fields := strings.Fields(line)
+		if strings.HasPrefix(line, "\t") || len(fields) < 3 || !isGitObjectID(fields[0]) {
+			continue
+		}
+		finalLine, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+		if n := len(ranges); n > 0 && ranges[n-1].SHA == fields[0] && ranges[n-1].StartLine+ranges[n-1].Lines == finalLine {
+			ranges[n-1].Lines++
+			continue
+		}
+		ranges = append(ranges, GitBlameRange{SHA: fields[0], StartLine: finalLine, Lines: 1})
+	}
+	return ranges
+}
+
+// isGitObjectID reports whether s is a full SHA-1 or SHA-256 object ID.
+func isGitObjectID(s string) bool {
+	if len(s) != 40 && len(s) != 64 {
+		return false
+	}
+	for _, r := range s {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseGitRawNumstat parses git diff-tree --raw --numstat -z output. The raw
+// entries come first, then a numstat entry for each of them in order.
+func parseGitRawNumstat(output string) []GitChangedFile {
+	files := []GitChangedFile{}
+	tokens := strings.Split(output, "\x00")
+	stat := 0
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		if token == "" {
+			continue
+		}
+		if strings.HasPrefix(token, ":") {
+			// :<old mode> <new mode> <old sha> <new sha> <status>, then the
+			// path, or the old and new paths for renames and copies.
+			fields := strings.Fields(token)
+			if len(fields) < 5 || i+1 >= len(tokens) {
+				break
+			}
+			file := GitChangedFile{Status: fields[4][:1]}
+			if file.Status == "R" || file.Status == "C" {
+				if i+2 >= len(tokens) {
+					break
+				}
+				file.OldPath, file.Path = tokens[i+1], tokens[i+2]
+				i += 2
+			} else {
+				file.Path = tokens[i+1]
+				i++
+			}
+			files = append(files, file)
+			continue
+		}
+
+		// <additions>\t<deletions>\t<path>, with an empty path followed by
+		// the old and new paths for renames and copies.
+		counts := strings.SplitN(token, "\t", 3)
+		if len(counts) != 3 {
+			continue
+		}
+		if counts[2] == "" {
+			i += 2
+		}
+		if stat < len(files) {
+			if counts[0] == "-" {
+				files[stat].Binary = true
+			} else {
+				files[stat].Additions, _ = strconv.Atoi(counts[0])
+				files[stat].Deletions, _ = strconv.Atoi(counts[1])
+			}
+		}
+		stat++
+	}
+	return files
+}
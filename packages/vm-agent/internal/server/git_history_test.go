@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+// newGitHistoryRepo creates a repository with three commits: the first adds
+// a.txt and logo.bin, the second is an agent commit that edits a.txt, and
+// the third renames a.txt to b.txt and deletes logo.bin.
+func newGitHistoryRepo(t *testing.T) (*Server, string) {
+	t.Helper()
+	repo := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=Dev", "-c", "user.email=dev@example.com"}, args...)...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(repo, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	git("init", "-q")
+	write("a.txt", "one\ntwo\nthree\n")
+	write("logo.bin", "\x89PNG\x00\x01")
+	git("add", ".")
+	git("commit", "-q", "-m", "Initial commit")
+	write("a.txt", "one\nTWO\nthree\nfour\n")
+	git("commit", "-q", "-a", "-m", "Shout two", "-m", "SAM-Session: sess-1\nSAM-Prompt: msg-9\nSAM-Task: task-3")
+	git("mv", "a.txt", "b.txt")
+	git("rm", "-q", "logo.bin")
+	git("commit", "-q", "-m", "Rename a.txt")
+
+	s := &Server{config: &config.Config{Role: config.RoleStandalone, GitExecTimeout: 5 * time.Second, GitFileMaxSize: 1 << 20}}
+	return s, repo
+}
+
+func TestGitLogPagesAndParsesAgentTrailers(t *testing.T) {
+	s, repo := newGitHistoryRepo(t)
+	ctx := context.Background()
+
+	page, err := s.gitLog(ctx, "", "", repo, "", "", 0, 2)
+	if err != nil {
+		t.Fatalf("gitLog() error = %v", err)
+	}
+	if len(page.Commits) != 2 || !page.HasMore {
+		t.Fatalf("first page: %d commits, hasMore=%v", len(page.Commits), page.HasMore)
+	}
+	agentCommit := page.Commits[1]
+	if agentCommit.Subject != "Shout two" || agentCommit.AuthorEmail != "dev@example.com" || len(agentCommit.Parents) != 1 {
+		t.Fatalf("agent commit = %+v", agentCommit)
+	}
+	wantAgent := &GitCommitAgent{SessionID: "sess-1", PromptID: "msg-9", TaskID: "task-3"}
+	if !reflect.DeepEqual(agentCommit.Agent, wantAgent) {
+		t.Fatalf("agent = %+v, want %+v", agentCommit.Agent, wantAgent)
+	}
+	if page.Commits[0].Agent != nil {
+		t.Fatalf("commit without trailers has agent %+v", page.Commits[0].Agent)
+	}
+	if _, err := time.Parse(time.RFC3339, agentCommit.AuthoredAt); err != nil {
+		t.Fatalf("authoredAt %q: %v", agentCommit.AuthoredAt, err)
+	}
+
+	rest, err := s.gitLog(ctx, "", "", repo, "", "", 2, 2)
+	if err != nil || len(rest.Commits) != 1 || rest.HasMore || len(rest.Commits[0].Parents) != 0 {
+		t.Fatalf("second page = %+v, err=%v", rest, err)
+	}
+
+	// History of a renamed file follows it across the rename.
+	followed, err := s.gitLog(ctx, "", "", repo, "", "b.txt", 0, 10)
+	if err != nil || len(followed.Commits) != 3 {
+		t.Fatalf("gitLog(b.txt) = %d commits, err=%v", len(followed.Commits), err)
+	}
+
+	if _, err := s.gitLog(ctx, "", "", repo, "no-such-branch", "", 0, 10); !errors.Is(err, errGitRevisionNotFound) {
+		t.Fatalf("unknown ref error = %v, want errGitRevisionNotFound", err)
+	}
+}
+
+func TestGitBlameAttributesLines(t *testing.T) {
+	s, repo := newGitHistoryRepo(t)
+	if err := os.WriteFile(filepath.Join(repo, "b.txt"), []byte("one\nTWO\nthree\nfour\nfive\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	blame, err := s.gitBlame(context.Background(), "", "", repo, "", "b.txt")
+	if err != nil {
+		t.Fatalf("gitBlame() error = %v", err)
+	}
+	log, err := s.gitLog(context.Background(), "", "", repo, "", "", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	initial, agent := log.Commits[2].SHA, log.Commits[1].SHA
+	want := []GitBlameRange{
+		{SHA: initial, StartLine: 1, Lines: 1},
+		{SHA: agent, StartLine: 2, Lines: 1},
+		{SHA: initial, StartLine: 3, Lines: 1},
+		{SHA: agent, StartLine: 4, Lines: 1},
+		{SHA: gitUncommittedSHA, StartLine: 5, Lines: 1},
+	}
+	if !reflect.DeepEqual(blame.Ranges, want) {
+		t.Fatalf("ranges = %+v\nwant %+v", blame.Ranges, want)
+	}
+	if len(blame.Commits) != 2 || blame.Commits[agent].Agent == nil || blame.Commits[agent].Agent.SessionID != "sess-1" {
+		t.Fatalf("commits = %+v", blame.Commits)
+	}
+
+	if _, err := s.gitBlame(context.Background(), "", "", repo, "HEAD", "missing.txt"); !errors.Is(err, errGitRevisionNotFound) {
+		t.Fatalf("missing file error = %v, want errGitRevisionNotFound", err)
+	}
+}
+
+func TestGitShowListsChangedFiles(t *testing.T) {
+	s, repo := newGitHistoryRepo(t)
+	ctx := context.Background()
+
+	rename, err := s.gitShow(ctx, "", "", repo, "HEAD")
+	if err != nil {
+		t.Fatalf("gitShow(HEAD) error = %v", err)
+	}
+	wantFiles := []GitChangedFile{
+		{Path: "b.txt", OldPath: "a.txt", Status: "R"},
+		{Path: "logo.bin", Status: "D", Binary: true},
+	}
+	if rename.Commit.Subject != "Rename a.txt" || !reflect.DeepEqual(rename.Files, wantFiles) {
+		t.Fatalf("gitShow(HEAD) = %+v, files %+v", rename.Commit, rename.Files)
+	}
+
+	edit, err := s.gitShow(ctx, "", "", repo, "HEAD~1")
+	if err != nil {
+		t.Fatalf("gitShow(HEAD~1) error = %v", err)
+	}
+	if !reflect.DeepEqual(edit.Files, []GitChangedFile{{Path: "a.txt", Status: "M", Additions: 2, Deletions: 1}}) {
+		t.Fatalf("edit files = %+v", edit.Files)
+	}
+	if !strings.Contains(edit.Diff, "-two\n+TWO\n") || edit.Commit.Agent == nil {
+		t.Fatalf("edit diff = %q, agent = %+v", edit.Diff, edit.Commit.Agent)
+	}
+
+	root, err := s.gitShow(ctx, "", "", repo, "HEAD~2")
+	if err != nil || len(root.Files) != 2 || root.Files[0].Status != "A" || root.Files[0].Additions != 3 {
+		t.Fatalf("root commit = %+v, err=%v", root.Files, err)
+	}
+
+	s.config.GitFileMaxSize = 10
+	truncated, err := s.gitShow(ctx, "", "", repo, "HEAD~1")
+	if err != nil || !truncated.DiffTruncated || len(truncated.Diff) != 10 {
+		t.Fatalf("truncated diff = %q (truncated=%v), err=%v", truncated.Diff, truncated.DiffTruncated, err)
+	}
+
+	if _, err := s.gitShow(ctx, "", "", repo, "deadbeef"); !errors.Is(err, errGitRevisionNotFound) {
+		t.Fatalf("unknown sha error = %v, want errGitRevisionNotFound", err)
+	}
+}
+
+func TestValidGitHistoryArgsRejectsOptions(t *testing.T) {
+	for _, tc := range []struct{ ref, path string }{
+		{ref: "--output=/tmp/x"},
+		{ref: "HEAD;rm"},
+		{path: "../etc/passwd"},
+	} {
+		rec := httptest.NewRecorder()
+		if validGitHistoryArgs(rec, tc.ref, tc.path) || rec.Code != http.StatusBadRequest {
+			t.Errorf("validGitHistoryArgs(%q, %q) accepted, status %d", tc.ref, tc.path, rec.Code)
+		}
+	}
+	if !validGitHistoryArgs(httptest.NewRecorder(), "origin/main~2", "src/a.go") {
+		t.Error("validGitHistoryArgs() rejected a valid ref and path")
+	}
+}
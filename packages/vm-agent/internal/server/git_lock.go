@@ -0,0 +1,144 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/gitlock"
+)
+
+const (
+	// gitLockFile is the advisory lock, in the repository's common git dir,
+	// held while the agent runs a git write operation. Scripts in the
+	// workspace can take part with `flock "$(git rev-parse --git-common-dir)/sam-git.lock" git ...`.
+	gitLockFile = "sam-git.lock"
+	// gitLockBusyExit is the flock exit status for a lock wait that timed out.
+	gitLockBusyExit = 75
+	// gitLockReleaseTimeout bounds how long releasing waits for the flock
+	// process to exit before killing it.
+	gitLockReleaseTimeout = 5 * time.Second
+)
+
+// withGitLock runs fn as the exclusive git write operation op of the
+// workspace. It queues behind other operations of the workspace for up to
+// GIT_LOCK_TIMEOUT, then also takes the advisory flock in the repository at
+// workDir, and returns an error wrapping gitlock.ErrBusy when either is not
+// granted in time.
+func (s *Server) withGitLock(ctx context.Context, workspaceID, containerID, user, workDir, op string, fn func() error) error {
+	release, err := s.lockWorkspaceGit(ctx, workspaceID, containerID, user, workDir, op)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return fn()
+}
+
+// lockWorkspaceGit takes the workspace git lock for op. The returned release
+// must be called once the operation is done.
+func (s *Server) lockWorkspaceGit(ctx context.Context, workspaceID, containerID, user, workDir, op string) (func(), error) {
+	timeout := time.Minute
+	if s.config != nil && s.config.GitLockTimeout > 0 {
+		timeout = s.config.GitLockTimeout
+	}
+	lockCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	release, err := s.gitLocks.Acquire(lockCtx, workspaceID, op)
+	if err != nil {
+		return nil, err
+	}
+	unlockFile, err := s.holdGitLockFile(lockCtx, containerID, user, workDir)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return func() {
+		unlockFile()
+		release()
+	}, nil
+}
+
+// holdGitLockFile takes the advisory flock in the repository at workDir and
+// keeps it until the returned func is called. flock runs cat on a pipe: cat
+// starts only once the lock is held, and echoing a line back is the signal.
+// A workspace that is not a git repository, or a container without flock,
+// gets only the in-process lock.
+func (s *Server) holdGitLockFile(ctx context.Context, containerID, user, workDir string) (func(), error) {
+	noop := func() {}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(time.Minute)
+	}
+	gitDir, _, err := s.execInContainer(ctx, containerID, user, workDir, "git", "rev-parse", "--path-format=absolute", "--git-common-dir")
+	gitDir = strings.TrimSpace(gitDir)
+	if err != nil || gitDir == "" {
+		return noop, nil
+	}
+
+	wait := strconv.FormatFloat(time.Until(deadline).Seconds(), 'f', 1, 64)
+	cmd, err := s.workspaceExecCommand(context.Background(), containerID, user, workDir,
+		"flock", "-w", wait, "-E", strconv.Itoa(gitLockBusyExit), gitDir+"/"+gitLockFile, "cat")
+	if err != nil {
+		return noop, nil
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("git lock stdin: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("git lock stdout: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		slog.Debug("Advisory git lock unavailable", "workDir", workDir, "error", err)
+		return noop, nil
+	}
+	exited := make(chan error, 1)
+	locked := make(chan error, 1)
+	go func() {
+		_, err := bufio.NewReader(stdout).ReadString('\n')
+		locked <- err
+		_, _ = io.Copy(io.Discard, stdout)
+		exited <- cmd.Wait()
+	}()
+	// A failed write means flock already exited; its status is read below.
+	_, _ = io.WriteString(stdin, "locked\n")
+
+	select {
+	case err := <-locked:
+		if err == nil {
+			return func() { stopGitLockHolder(cmd, stdin, exited) }, nil
+		}
+		waitErr := <-exited
+		var exitErr *exec.ExitError
+		if errors.As(waitErr, &exitErr) && exitErr.ExitCode() == gitLockBusyExit {
+			return nil, fmt.Errorf("%w: %s is locked by another process", gitlock.ErrBusy, gitLockFile)
+		}
+		slog.Debug("Advisory git lock unavailable", "workDir", workDir, "error", waitErr)
+		return noop, nil
+	case <-ctx.Done():
+		_ = cmd.Process.Kill()
+		<-exited
+		return nil, fmt.Errorf("%w: %s is locked by another process", gitlock.ErrBusy, gitLockFile)
+	}
+}
+
+// stopGitLockHolder releases the flock by closing cat's input, killing the
+// holder if it does not exit promptly.
+func stopGitLockHolder(cmd *exec.Cmd, stdin io.Closer, exited <-chan error) {
+	_ = stdin.Close()
+	select {
+	case <-exited:
+	case <-time.After(gitLockReleaseTimeout):
+		_ = cmd.Process.Kill()
+		<-exited
+	}
+}
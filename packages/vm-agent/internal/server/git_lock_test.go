@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/config"
+	"github.com/workspace/vm-agent/internal/gitlock"
+)
+
+func TestLockWorkspaceGitHoldsAdvisoryFlock(t *testing.T) {
+	if _, err := exec.LookPath("flock"); err != nil {
+		t.Skip("flock not installed")
+	}
+	repo := t.TempDir()
+	if out, err := exec.Command("git", "init", "-q", repo).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v: %s", err, out)
+	}
+	lockPath := filepath.Join(repo, ".git", gitLockFile)
+	flockFree := func() bool {
+		return exec.Command("flock", "-n", lockPath, "true").Run() == nil
+	}
+
+	s := &Server{config: &config.Config{Role: config.RoleStandalone, GitExecTimeout: 5 * time.Second, GitLockTimeout: 5 * time.Second}}
+	release, err := s.lockWorkspaceGit(context.Background(), "ws-1", "", "", repo, "checkout")
+	if err != nil {
+		t.Fatalf("lockWorkspaceGit() error = %v", err)
+	}
+	if flockFree() {
+		t.Fatal("advisory flock is free while the git lock is held")
+	}
+
+	// A second operation on the workspace queues and times out.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := s.lockWorkspaceGit(ctx, "ws-1", "", "", repo, "auto-push"); !errors.Is(err, gitlock.ErrBusy) {
+		t.Fatalf("second lockWorkspaceGit() error = %v, want ErrBusy", err)
+	}
+
+	release()
+	if !flockFree() {
+		t.Fatal("advisory flock still held after release")
+	}
+}
+
+func TestLockWorkspaceGitWaitsForOutsideFlock(t *testing.T) {
+	if _, err := exec.LookPath("flock"); err != nil {
+		t.Skip("flock not installed")
+	}
+	repo := t.TempDir()
+	if out, err := exec.Command("git", "init", "-q", repo).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v: %s", err, out)
+	}
+
+	// A script in the workspace holds the lock, as a cooperating user would.
+	holder := exec.Command("flock", filepath.Join(repo, ".git", gitLockFile), "sleep", "5")
+	if err := holder.Start(); err != nil {
+		t.Fatalf("start holder: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = holder.Process.Kill()
+		_ = holder.Wait()
+	})
+	time.Sleep(100 * time.Millisecond)
+
+	s := &Server{config: &config.Config{Role: config.RoleStandalone, GitExecTimeout: 5 * time.Second, GitLockTimeout: 300 * time.Millisecond}}
+	if _, err := s.lockWorkspaceGit(context.Background(), "ws-1", "", "", repo, "checkout"); !errors.Is(err, gitlock.ErrBusy) {
+		t.Fatalf("lockWorkspaceGit() error = %v, want ErrBusy", err)
+	}
+	if _, queued, held := s.gitLocks.Holder("ws-1"); held || queued != 0 {
+		t.Fatal("in-process lock kept after the advisory flock timed out")
+	}
+}
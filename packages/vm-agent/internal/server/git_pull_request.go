@@ -0,0 +1,327 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/gitrepo"
+)
+
+// maxPullRequestBodyBytes bounds the description accepted for a new PR;
+// GitHub itself rejects bodies over 65536 characters.
+const maxPullRequestBodyBytes = 64 * 1024
+
+type gitPullRequestRequest struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	// Base is the branch to merge into; empty uses the repository's default
+	// branch.
+	Base  string `json:"base"`
+	Draft bool   `json:"draft"`
+}
+
+// GitPullRequestResponse describes the pull request opened, or found, for
+// the workspace's current branch.
+type GitPullRequestResponse struct {
+	URL    string `json:"url"`
+	Number int    `json:"number"`
+	Branch string `json:"branch"`
+	Base   string `json:"base"`
+	// Created is false when an open pull request already existed for the
+	// branch and was returned instead.
+	Created bool `json:"created"`
+}
+
+type githubPullRequest struct {
+	HTMLURL string `json:"html_url"`
+	Number  int    `json:"number"`
+	Base    struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
+}
+
+// errGitHubPullRequestExists is returned by createGitHubPullRequest when
+// GitHub reports an open pull request for the same head and base.
+var errGitHubPullRequestExists = errors.New("pull request already exists")
+
+// handleGitPullRequest pushes the workspace's current branch, or the
+// branch of the worktree given by ?worktree=, and opens a GitHub pull
+// request for it with the workspace's git token. An open pull request for
+// the same branch is returned rather than treated as an error.
+// POST /workspaces/{workspaceId}/git/pull-request
+func (s *Server) handleGitPullRequest(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.PathValue("workspaceId")
+	if workspaceID == "" {
+		writeError(w, http.StatusBadRequest, "workspaceId is required")
+		return
+	}
+	if !s.requireWorkspaceRequestAuth(w, r, workspaceID) {
+		return
+	}
+
+	var req gitPullRequestRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 2*maxPullRequestBodyBytes)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	req.Title = strings.TrimSpace(req.Title)
+	req.Base = strings.TrimSpace(req.Base)
+	if len(req.Body) > maxPullRequestBodyBytes {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("body must be at most %d bytes", maxPullRequestBodyBytes))
+		return
+	}
+	if req.Base != "" && (sanitizeGitRef(req.Base) != nil || strings.HasPrefix(req.Base, "-")) {
+		writeError(w, http.StatusBadRequest, "invalid base")
+		return
+	}
+
+	runtime, ok := s.getWorkspaceRuntime(workspaceID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "workspace not found")
+		return
+	}
+	provider := strings.ToLower(strings.TrimSpace(runtime.RepoProvider))
+	if provider != "" && provider != "github" {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("pull requests are only supported for GitHub repositories, not %s", provider))
+		return
+	}
+	owner, repo, err := githubRepositoryOwnerAndName(runtime)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	containerID, primaryWorkDir, user, err := s.resolveContainerForWorkspace(workspaceID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	workDir, err := s.resolveWorktreeWorkDir(r, workspaceID, containerID, user, primaryWorkDir)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	release, err := s.lockWorkspaceGit(r.Context(), workspaceID, containerID, user, workDir, "pull request push")
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	defer release()
+
+	gitTimeout := s.config.GitExecTimeout
+	if gitTimeout <= 0 {
+		gitTimeout = 30 * time.Second
+	}
+	gitCtx, cancel := context.WithTimeout(r.Context(), gitTimeout)
+	defer cancel()
+
+	branch, _, _ := s.execInContainer(gitCtx, containerID, user, workDir, "git", "branch", "--show-current")
+	branch = strings.TrimSpace(branch)
+	if branch == "" {
+		writeError(w, http.StatusConflict, "HEAD is detached; check out a branch before opening a pull request")
+		return
+	}
+	if req.Base == branch {
+		writeError(w, http.StatusConflict, fmt.Sprintf("cannot open a pull request from '%s' into itself", branch))
+		return
+	}
+	if req.Title == "" {
+		subject, _, _ := s.execInContainer(gitCtx, containerID, user, workDir, "git", "log", "-1", "--format=%s")
+		req.Title = strings.TrimSpace(subject)
+		if req.Title == "" {
+			req.Title = branch
+		}
+	}
+
+	if _, stderr, err := s.execInContainer(gitCtx, containerID, user, workDir, "git", "push", "--set-upstream", "origin", "HEAD"); err != nil {
+		writeError(w, http.StatusBadGateway, fmt.Sprintf("git push failed: %v: %s", err, redactTaskCallbackDiagnosticText(strings.TrimSpace(stderr))))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), gitTimeout)
+	defer cancel()
+	token, err := s.fetchGitTokenResponseForWorkspace(ctx, workspaceID, "", gitTokenPurposePullRequest)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, fmt.Sprintf("failed to get git token: %v", err))
+		return
+	}
+	api := githubAPIBaseURL(runtime.RepositoryHost)
+	if req.Base == "" {
+		req.Base, err = s.githubDefaultBranch(ctx, api, owner, repo, token.Token)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		if req.Base == branch {
+			writeError(w, http.StatusConflict, fmt.Sprintf("'%s' is the default branch; check out a feature branch first", branch))
+			return
+		}
+	}
+
+	pr, err := s.createGitHubPullRequest(ctx, api, owner, repo, token.Token, branch, req)
+	created := true
+	if errors.Is(err, errGitHubPullRequestExists) {
+		created = false
+		pr, err = s.findGitHubPullRequest(ctx, api, owner, repo, token.Token, branch, req.Base)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	slog.Info("Pull request ready", "workspaceId", workspaceID, "branch", branch, "base", req.Base, "url", pr.HTMLURL, "created", created)
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, GitPullRequestResponse{URL: pr.HTMLURL, Number: pr.Number, Branch: branch, Base: req.Base, Created: created})
+}
+
+// githubRepositoryOwnerAndName returns the owner and name of the workspace's
+// GitHub repository, preferring RepositoryPath from the control plane.
+func githubRepositoryOwnerAndName(runtime *WorkspaceRuntime) (string, string, error) {
+	repoPath := strings.Trim(strings.TrimSpace(runtime.RepositoryPath), "/")
+	if repoPath == "" {
+		u, err := url.Parse(gitrepo.NormalizeURL(runtime.Repository))
+		if err != nil || strings.TrimSpace(runtime.Repository) == "" {
+			return "", "", fmt.Errorf("workspace repository %q is not a GitHub repository", runtime.Repository)
+		}
+		repoPath = strings.Trim(u.Path, "/")
+	}
+	repoPath = strings.TrimSuffix(repoPath, ".git")
+	owner, repo, ok := strings.Cut(repoPath, "/")
+	if !ok || owner == "" || repo == "" || strings.Contains(repo, "/") {
+		return "", "", fmt.Errorf("workspace repository %q is not an owner/repo GitHub repository", repoPath)
+	}
+	return owner, repo, nil
+}
+
+// githubAPIBaseURL returns the REST API root for a GitHub host: api.github.com
+// for github.com, and /api/v3 on GitHub Enterprise Server hosts.
+func githubAPIBaseURL(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if host == "" || host == "github.com" || host == "api.github.com" {
+		return "https://api.github.com"
+	}
+	return "https://" + host + "/api/v3"
+}
+
+func (s *Server) githubAPIRequest(ctx context.Context, method, endpoint, token string, payload interface{}, out interface{}) (int, []byte, error) {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return 0, nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("build GitHub request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.controlPlaneHTTPClient(0).Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("GitHub request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 && out != nil {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return resp.StatusCode, respBody, fmt.Errorf("decode GitHub response: %w", err)
+		}
+	}
+	return resp.StatusCode, respBody, nil
+}
+
+func (s *Server) githubDefaultBranch(ctx context.Context, api, owner, repo, token string) (string, error) {
+	var payload struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	endpoint := fmt.Sprintf("%s/repos/%s/%s", api, url.PathEscape(owner), url.PathEscape(repo))
+	status, body, err := s.githubAPIRequest(ctx, http.MethodGet, endpoint, token, nil, &payload)
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK || payload.DefaultBranch == "" {
+		return "", fmt.Errorf("GitHub repository lookup returned HTTP %d: %s", status, githubErrorMessage(body))
+	}
+	return payload.DefaultBranch, nil
+}
+
+func (s *Server) createGitHubPullRequest(ctx context.Context, api, owner, repo, token, branch string, req gitPullRequestRequest) (*githubPullRequest, error) {
+	endpoint := fmt.Sprintf("%s/repos/%s/%s/pulls", api, url.PathEscape(owner), url.PathEscape(repo))
+	var pr githubPullRequest
+	status, body, err := s.githubAPIRequest(ctx, http.MethodPost, endpoint, token, map[string]interface{}{
+		"title": req.Title,
+		"head":  branch,
+		"base":  req.Base,
+		"body":  req.Body,
+		"draft": req.Draft,
+	}, &pr)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusUnprocessableEntity && strings.Contains(string(body), "already exists") {
+		return nil, errGitHubPullRequestExists
+	}
+	if status != http.StatusCreated {
+		return nil, fmt.Errorf("GitHub pull request create returned HTTP %d: %s", status, githubErrorMessage(body))
+	}
+	return &pr, nil
+}
+
+func (s *Server) findGitHubPullRequest(ctx context.Context, api, owner, repo, token, branch, base string) (*githubPullRequest, error) {
+	params := url.Values{}
+	params.Set("state", "open")
+	params.Set("head", owner+":"+branch)
+	params.Set("base", base)
+	params.Set("per_page", "1")
+	endpoint := fmt.Sprintf("%s/repos/%s/%s/pulls?%s", api, url.PathEscape(owner), url.PathEscape(repo), params.Encode())
+	var prs []githubPullRequest
+	status, body, err := s.githubAPIRequest(ctx, http.MethodGet, endpoint, token, nil, &prs)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("GitHub pull request lookup returned HTTP %d: %s", status, githubErrorMessage(body))
+	}
+	if len(prs) == 0 {
+		return nil, fmt.Errorf("GitHub reported an existing pull request for '%s' but none is open", branch)
+	}
+	return &prs[0], nil
+}
+
+// githubErrorMessage extracts GitHub's error message, and the first
+// validation error's message, from an API error body.
+func githubErrorMessage(body []byte) string {
+	var payload struct {
+		Message string `json:"message"`
+		Errors  []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil || payload.Message == "" {
+		return redactTaskCallbackDiagnosticText(strings.TrimSpace(string(body)))
+	}
+	if len(payload.Errors) > 0 && payload.Errors[0].Message != "" {
+		return payload.Message + ": " + payload.Errors[0].Message
+	}
+	return payload.Message
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGitPullRequestPushesAndOpensPullRequest(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	var creates atomic.Int32
+	var created map[string]interface{}
+	api := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/api/workspaces/ws-content-type-test/git-token":
+			if r.Header.Get("Authorization") != "Bearer pr-callback-token" {
+				t.Errorf("token request Authorization = %q", r.Header.Get("Authorization"))
+			}
+			_, _ = w.Write([]byte(`{"provider":"github","token":"gh_token"}`))
+		case r.Header.Get("Authorization") != "Bearer gh_token":
+			t.Errorf("GitHub request %s without the workspace token", r.URL.Path)
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v3/repos/octo/app":
+			_, _ = w.Write([]byte(`{"default_branch":"main"}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v3/repos/octo/app/pulls":
+			if creates.Add(1) > 1 {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				_, _ = w.Write([]byte(`{"message":"Validation Failed","errors":[{"message":"A pull request already exists for octo:feature/login."}]}`))
+				return
+			}
+			_ = json.NewDecoder(r.Body).Decode(&created)
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"html_url":"https://github.example/octo/app/pull/12","number":12}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v3/repos/octo/app/pulls":
+			if r.URL.Query().Get("head") != "octo:feature/login" || r.URL.Query().Get("base") != "main" {
+				t.Errorf("pull request lookup query = %s", r.URL.RawQuery)
+			}
+			_, _ = w.Write([]byte(`[{"html_url":"https://github.example/octo/app/pull/12","number":12}]`))
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer api.Close()
+
+	srv, workspaceID, repoDir, sessionID := newFileHandlerTestServer(t)
+	srv.config.GitExecTimeout = 10 * time.Second
+	srv.config.ControlPlaneURL = api.URL
+	srv.httpClient = api.Client()
+	runtime, _ := srv.workspaces.Get(workspaceID)
+	runtime.Repository = "octo/app"
+	runtime.RepositoryHost = strings.TrimPrefix(api.URL, "https://")
+	runtime.CallbackToken = "pr-callback-token"
+
+	origin := filepath.Join(t.TempDir(), "origin.git")
+	runTestGit(t, t.TempDir(), "init", "-q", "--bare", origin)
+	runTestGit(t, repoDir, "init", "-q", "-b", "main")
+	if err := os.WriteFile(filepath.Join(repoDir, "README.md"), []byte("hello\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	runTestGit(t, repoDir, "add", "README.md")
+	runTestGit(t, repoDir, "commit", "-q", "-m", "initial")
+	runTestGit(t, repoDir, "remote", "add", "origin", origin)
+	runTestGit(t, repoDir, "push", "-q", "origin", "main")
+
+	openPR := func(body string) (*httptest.ResponseRecorder, GitPullRequestResponse) {
+		req := httptest.NewRequest(http.MethodPost, "/workspaces/"+workspaceID+"/git/pull-request", strings.NewReader(body))
+		req.SetPathValue("workspaceId", workspaceID)
+		req.AddCookie(&http.Cookie{Name: "session", Value: sessionID})
+		rec := httptest.NewRecorder()
+		srv.handleGitPullRequest(rec, req)
+		var resp GitPullRequestResponse
+		if rec.Code == http.StatusCreated || rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+		}
+		return rec, resp
+	}
+
+	// The default branch cannot be proposed into itself.
+	if rec, _ := openPR(`{}`); rec.Code != http.StatusConflict {
+		t.Fatalf("pull request from main = %d %s, want 409", rec.Code, rec.Body.String())
+	}
+
+	runTestGit(t, repoDir, "switch", "-q", "-c", "feature/login")
+	runTestGit(t, repoDir, "commit", "-q", "--allow-empty", "-m", "Add login form")
+
+	rec, resp := openPR(`{"body":"Adds the form.","draft":true}`)
+	if rec.Code != http.StatusCreated || !resp.Created || resp.URL != "https://github.example/octo/app/pull/12" || resp.Number != 12 ||
+		resp.Branch != "feature/login" || resp.Base != "main" {
+		t.Fatalf("create = %d %s", rec.Code, rec.Body.String())
+	}
+	if created["title"] != "Add login form" || created["head"] != "feature/login" || created["base"] != "main" ||
+		created["body"] != "Adds the form." || created["draft"] != true {
+		t.Fatalf("pull request payload = %v", created)
+	}
+	if got := runTestGit(t, origin, "rev-parse", "refs/heads/feature/login"); got != runTestGit(t, repoDir, "rev-parse", "HEAD") {
+		t.Fatalf("origin feature/login = %s, want the pushed HEAD", got)
+	}
+
+	// A second request returns the pull request that is already open.
+	rec, resp = openPR(`{"title":"Login","base":"main"}`)
+	if rec.Code != http.StatusOK || resp.Created || resp.Number != 12 {
+		t.Fatalf("existing = %d %s", rec.Code, rec.Body.String())
+	}
+
+	for body, want := range map[string]int{
+		`{"base":"-x"}`:            http.StatusBadRequest,
+		`{"base":"feature/login"}`: http.StatusConflict,
+		`not json`:                 http.StatusBadRequest,
+	} {
+		if rec, _ := openPR(body); rec.Code != want {
+			t.Errorf("pull request %s = %d %s, want %d", body, rec.Code, rec.Body.String(), want)
+		}
+	}
+
+	runtime.RepoProvider = "gitlab"
+	if rec, _ := openPR(`{}`); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "GitHub") {
+		t.Fatalf("gitlab workspace = %d %s, want 400", rec.Code, rec.Body.String())
+	}
+}
+
+func TestGitHubRepositoryOwnerAndName(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		runtime     WorkspaceRuntime
+		owner, repo string
+	}{
+		{WorkspaceRuntime{Repository: "octo/app"}, "octo", "app"},
+		{WorkspaceRuntime{Repository: "https://github.com/octo/app.git"}, "octo", "app"},
+		{WorkspaceRuntime{Repository: "https://ghe.example.com/team/app", RepositoryPath: "team/app"}, "team", "app"},
+	} {
+		owner, repo, err := githubRepositoryOwnerAndName(&tc.runtime)
+		if err != nil || owner != tc.owner || repo != tc.repo {
+			t.Errorf("githubRepositoryOwnerAndName(%+v) = %q, %q, %v", tc.runtime, owner, repo, err)
+		}
+	}
+	if _, _, err := githubRepositoryOwnerAndName(&WorkspaceRuntime{}); err == nil {
+		t.Error("empty repository should be rejected")
+	}
+	if got := githubAPIBaseURL("github.com"); got != "https://api.github.com" {
+		t.Errorf("githubAPIBaseURL(github.com) = %q", got)
+	}
+	if got := githubAPIBaseURL("ghe.example.com"); got != "https://ghe.example.com/api/v3" {
+		t.Errorf("githubAPIBaseURL(ghe) = %q", got)
+	}
+}
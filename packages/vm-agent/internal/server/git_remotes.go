@@ -0,0 +1,380 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/workspace/vm-agent/internal/bootstrap/gitprovider"
+)
+
+// gitRemoteNamePattern restricts remote names to characters that are valid
+// in a ref component and cannot be mistaken for a git option.
+var gitRemoteNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// scpRemotePattern matches scp-like SSH remotes such as git@github.com:owner/repo.git.
+// The user part must start with an alphanumeric so the URL cannot be taken
+// for an option.
+var scpRemotePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*@([A-Za-z0-9.-]+):([A-Za-z0-9._~/-]+)$`)
+
+// GitRemote is a configured git remote in the workspace repository.
+type GitRemote struct {
+	Name     string `json:"name"`
+	FetchURL string `json:"fetchUrl"`
+	PushURL  string `json:"pushUrl,omitempty"`
+	// CredentialHelper reports whether HTTPS operations against this remote
+	// receive credentials from the SAM git credential helper.
+	CredentialHelper bool `json:"credentialHelper"`
+}
+
+// GitRemoteListResponse lists the remotes of the workspace repository.
+type GitRemoteListResponse struct {
+	Remotes []GitRemote `json:"remotes"`
+}
+
+type addGitRemoteRequest struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// handleListGitRemotes lists the remotes of the workspace repository.
+// GET /workspaces/{workspaceId}/git/remotes
+func (s *Server) handleListGitRemotes(w http.ResponseWriter, r *http.Request) {
+	workspaceID, containerID, workDir, user, ok := s.resolveGitRemoteRequest(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.config.GitExecTimeout)
+	defer cancel()
+
+	remotes, err := s.listGitRemotes(ctx, workspaceID, containerID, user, workDir)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("git remote failed: %v", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, GitRemoteListResponse{Remotes: remotes})
+}
+
+// handleAddGitRemote adds a remote to the workspace repository. HTTPS remotes
+// on the workspace's credential host are registered with the credential
+// exchange so that pushes and fetches authenticate like origin.
+// POST /workspaces/{workspaceId}/git/remotes
+func (s *Server) handleAddGitRemote(w http.ResponseWriter, r *http.Request) {
+	workspaceID, containerID, workDir, user, ok := s.resolveGitRemoteRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var body addGitRemoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	name := strings.TrimSpace(body.Name)
+	rawURL := strings.TrimSpace(body.URL)
+	if err := validateGitRemoteName(name); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	host, path, https, err := parseGitRemoteURL(rawURL)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	release, err := s.lockWorkspaceGit(r.Context(), workspaceID, containerID, user, workDir, "remote add")
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.config.GitExecTimeout)
+	defer cancel()
+
+	existing, err := s.listGitRemotes(ctx, workspaceID, containerID, user, workDir)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("git remote failed: %v", err))
+		return
+	}
+	for _, remote := range existing {
+		if remote.Name == name {
+			writeError(w, http.StatusConflict, fmt.Sprintf("remote %q already exists", name))
+			return
+		}
+	}
+
+	if _, stderr, err := s.execInContainer(ctx, containerID, user, workDir, "git", "remote", "add", "--", name, rawURL); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("git remote add failed: %s", firstNonEmpty(stderr, err.Error())))
+		return
+	}
+
+	if https && credentialHostMatchesRequest(s.workspaceCredentialHost(workspaceID), host) {
+		s.setCredentialRemote(workspaceID, name, path)
+	}
+	s.appendNodeEvent(workspaceID, "info", "git.remote_added", "Git remote added", map[string]interface{}{
+		"name": name,
+		"host": host,
+	})
+
+	remote := GitRemote{Name: name, FetchURL: rawURL}
+	remote.CredentialHelper = s.gitRemoteHasCredentials(workspaceID, rawURL)
+	writeJSON(w, http.StatusCreated, remote)
+}
+
+// handleRemoveGitRemote removes a remote from the workspace repository.
+// origin cannot be removed because push and pull-request flows depend on it.
+// DELETE /workspaces/{workspaceId}/git/remotes/{name}
+func (s *Server) handleRemoveGitRemote(w http.ResponseWriter, r *http.Request) {
+	workspaceID, containerID, workDir, user, ok := s.resolveGitRemoteRequest(w, r)
+	if !ok {
+		return
+	}
+
+	name := strings.TrimSpace(r.PathValue("name"))
+	if err := validateGitRemoteName(name); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if name == "origin" {
+		writeError(w, http.StatusBadRequest, "the origin remote cannot be removed")
+		return
+	}
+
+	release, err := s.lockWorkspaceGit(r.Context(), workspaceID, containerID, user, workDir, "remote remove")
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.config.GitExecTimeout)
+	defer cancel()
+
+	existing, err := s.listGitRemotes(ctx, workspaceID, containerID, user, workDir)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("git remote failed: %v", err))
+		return
+	}
+	found := false
+	for _, remote := range existing {
+		if remote.Name == name {
+			found = true
+			break
+		}
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("remote %q not found", name))
+		return
+	}
+
+	if _, stderr, err := s.execInContainer(ctx, containerID, user, workDir, "git", "remote", "remove", name); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("git remote remove failed: %s", firstNonEmpty(stderr, err.Error())))
+		return
+	}
+	s.setCredentialRemote(workspaceID, name, "")
+	s.appendNodeEvent(workspaceID, "info", "git.remote_removed", "Git remote removed", map[string]interface{}{
+		"name": name,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) resolveGitRemoteRequest(w http.ResponseWriter, r *http.Request) (workspaceID, containerID, workDir, user string, ok bool) {
+	workspaceID = r.PathValue("workspaceId")
+	if workspaceID == "" {
+		writeError(w, http.StatusBadRequest, "workspaceId is required")
+		return "", "", "", "", false
+	}
+	if !s.requireWorkspaceRequestAuth(w, r, workspaceID) {
+		return "", "", "", "", false
+	}
+
+	containerID, workDir, user, err := s.resolveContainerForWorkspace(workspaceID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return "", "", "", "", false
+	}
+	return workspaceID, containerID, workDir, user, true
+}
+
+func (s *Server) listGitRemotes(ctx context.Context, workspaceID, containerID, user, workDir string) ([]GitRemote, error) {
+	stdout, _, err := s.execInContainer(ctx, containerID, user, workDir, "git", "remote", "-v")
+	if err != nil {
+		return nil, err
+	}
+	remotes := parseGitRemotes(stdout)
+	for i := range remotes {
+		remotes[i].CredentialHelper = s.gitRemoteHasCredentials(workspaceID, firstNonEmpty(remotes[i].PushURL, remotes[i].FetchURL))
+	}
+	return remotes, nil
+}
+
+// parseGitRemotes parses `git remote -v` output ("name<TAB>url (fetch|push)").
+// PushURL is only set when it differs from the fetch URL.
+func parseGitRemotes(output string) []GitRemote {
+	byName := make(map[string]*GitRemote)
+	var names []string
+	for _, line := range strings.Split(output, "\n") {
+		name, rest, found := strings.Cut(strings.TrimSpace(line), "\t")
+		if !found {
+			continue
+		}
+		rawURL, kind, found := strings.Cut(rest, " ")
+		if !found {
+			continue
+		}
+		remote := byName[name]
+		if remote == nil {
+			remote = &GitRemote{Name: name}
+			byName[name] = remote
+			names = append(names, name)
+		}
+		switch kind {
+		case "(fetch)":
+			remote.FetchURL = rawURL
+		case "(push)":
+			remote.PushURL = rawURL
+		}
+	}
+
+	sort.Strings(names)
+	remotes := make([]GitRemote, 0, len(names))
+	for _, name := range names {
+		remote := *byName[name]
+		if remote.PushURL == remote.FetchURL {
+			remote.PushURL = ""
+		}
+		remotes = append(remotes, remote)
+	}
+	return remotes
+}
+
+func validateGitRemoteName(name string) error {
+	if !gitRemoteNamePattern.MatchString(name) || strings.Contains(name, "..") || strings.HasSuffix(name, ".lock") {
+		return fmt.Errorf("invalid remote name %q", name)
+	}
+	return nil
+}
+
+// parseGitRemoteURL accepts https://, ssh://, and scp-like SSH remotes and
+// returns the host and repository path. Other transports (file://, ext::,
+// local paths) are rejected because they can read the host filesystem or run
+// commands, and URLs with embedded credentials are rejected so that secrets
+// never land in .git/config.
+func parseGitRemoteURL(rawURL string) (host, path string, https bool, err error) {
+	if rawURL == "" {
+		return "", "", false, fmt.Errorf("url is required")
+	}
+	if m := scpRemotePattern.FindStringSubmatch(rawURL); m != nil {
+		return strings.ToLower(m[1]), strings.Trim(strings.TrimSuffix(m[2], ".git"), "/"), false, nil
+	}
+
+	parsed, parseErr := url.Parse(rawURL)
+	if parseErr != nil || parsed.Host == "" {
+		return "", "", false, fmt.Errorf("url must be an https://, ssh://, or user@host:path remote")
+	}
+	switch parsed.Scheme {
+	case "https":
+		if parsed.User != nil {
+			return "", "", false, fmt.Errorf("url must not embed credentials")
+		}
+		https = true
+	case "ssh":
+	default:
+		return "", "", false, fmt.Errorf("url must be an https://, ssh://, or user@host:path remote")
+	}
+	if parsed.RawQuery != "" || parsed.Fragment != "" {
+		return "", "", false, fmt.Errorf("url must not contain a query or fragment")
+	}
+	path = strings.Trim(strings.TrimSuffix(parsed.Path, ".git"), "/")
+	if path == "" {
+		return "", "", false, fmt.Errorf("url must include a repository path")
+	}
+	return strings.ToLower(parsed.Host), path, https, nil
+}
+
+// workspaceCredentialHost is the host the workspace's git credential is
+// issued for: the bound host for GitLab and Bitbucket workspaces, the clone
+// host for Artifacts, and github.com otherwise.
+func (s *Server) workspaceCredentialHost(workspaceID string) string {
+	provider, repositoryHost, cloneURL := "", "", ""
+	if runtime, ok := s.getWorkspaceRuntime(workspaceID); ok {
+		provider, repositoryHost, cloneURL = runtime.RepoProvider, runtime.RepositoryHost, runtime.CloneURL
+	} else if s.config != nil {
+		provider, repositoryHost, cloneURL = s.config.RepoProvider, s.config.RepositoryHost, s.config.CloneURL
+	}
+	bound := gitprovider.ForConfig(provider)
+	switch {
+	case bound.HostBound:
+		return bound.TokenHost(repositoryHost)
+	case bound.Name == gitprovider.Artifacts.Name:
+		if parsed, err := url.Parse(strings.TrimSpace(cloneURL)); err == nil {
+			return strings.ToLower(parsed.Host)
+		}
+		return ""
+	default:
+		return "github.com"
+	}
+}
+
+// gitRemoteHasCredentials reports whether the credential exchange serves the
+// given remote URL. GitLab and Bitbucket credentials are path-bound, so a
+// remote other than the bound repository is only covered once it is
+// registered via the API.
+func (s *Server) gitRemoteHasCredentials(workspaceID, rawURL string) bool {
+	host, path, https, err := parseGitRemoteURL(rawURL)
+	if err != nil || !https {
+		return false
+	}
+	if !credentialHostMatchesRequest(s.workspaceCredentialHost(workspaceID), host) {
+		return false
+	}
+	provider, repositoryPath := s.credentialPathBinding(workspaceID)
+	if !gitprovider.ForConfig(provider).PathBound {
+		return true
+	}
+	return credentialPathMatchesRequest(repositoryPath, path) || s.hasCredentialRemotePath(workspaceID, path)
+}
+
+// setCredentialRemote registers (or, with an empty path, unregisters) the
+// repository path of a remote added through the API, and persists the
+// registrations so they survive an agent restart.
+func (s *Server) setCredentialRemote(workspaceID, name, path string) {
+	s.workspaces.Update(workspaceID, func(runtime *WorkspaceRuntime) {
+		if _, ok := runtime.CredentialRemotes[name]; !ok && path == "" {
+			return
+		}
+		remotes := make(map[string]string, len(runtime.CredentialRemotes)+1)
+		for remote, remotePath := range runtime.CredentialRemotes {
+			remotes[remote] = remotePath
+		}
+		if path == "" {
+			delete(remotes, name)
+		} else {
+			remotes[name] = path
+		}
+		runtime.CredentialRemotes = remotes
+		s.persistWorkspaceMetadata(runtime)
+	})
+}
+
+// hasCredentialRemotePath reports whether requestedPath belongs to a remote
+// registered through the git remotes API.
+func (s *Server) hasCredentialRemotePath(workspaceID, requestedPath string) bool {
+	matched := false
+	s.workspaces.View(workspaceID, func(runtime *WorkspaceRuntime) {
+		for _, path := range runtime.CredentialRemotes {
+			if credentialPathMatchesRequest(path, requestedPath) {
+				matched = true
+				return
+			}
+		}
+	})
+	return matched
+}
@@ -98,6 +98,15 @@ func TestParseGitStatusPorcelain(t *testing.T) {
 			wantUnstaged:  []GitFileStatus{},
 			wantUntracked: []GitFileStatus{},
 		},
+		{
+			name:       "skips branch header",
+			output:     "## main...origin/main [ahead 1]\n M src/index.ts\n",
+			wantStaged: []GitFileStatus{},
+			wantUnstaged: []GitFileStatus{
+				{Path: "src/index.ts", Status: "M"},
+			},
+			wantUntracked: []GitFileStatus{},
+		},
 		{
 			name:   "staged modified",
 			output: "M  src/index.ts\n",
@@ -275,6 +284,47 @@ func containsStr(s, substr string) bool {
 	return false
 }
 
+func TestParseGitStatusBranchHeader(t *testing.T) {
+	tests := []struct {
+		output       string
+		wantBranch   string
+		wantUpstream string
+		wantAhead    int
+		wantBehind   int
+	}{
+		{output: "## main\n", wantBranch: "main"},
+		{output: "## main...origin/main\n M a.txt\n", wantBranch: "main", wantUpstream: "origin/main"},
+		{output: "## feature/x...origin/feature/x [ahead 2, behind 3]\n", wantBranch: "feature/x", wantUpstream: "origin/feature/x", wantAhead: 2, wantBehind: 3},
+		{output: "## main...origin/main [gone]\n", wantBranch: "main", wantUpstream: "origin/main"},
+		{output: "## No commits yet on main\n", wantBranch: "main"},
+		{output: "## HEAD (no branch)\n"},
+		{output: " M a.txt\n"},
+		{output: ""},
+	}
+
+	for _, tt := range tests {
+		branch, upstream, ahead, behind := parseGitStatusBranchHeader(tt.output)
+		if branch != tt.wantBranch || upstream != tt.wantUpstream || ahead != tt.wantAhead || behind != tt.wantBehind {
+			t.Errorf("parseGitStatusBranchHeader(%q) = %q, %q, %d, %d; want %q, %q, %d, %d",
+				tt.output, branch, upstream, ahead, behind, tt.wantBranch, tt.wantUpstream, tt.wantAhead, tt.wantBehind)
+		}
+	}
+}
+
+func TestAppendLocalBranches(t *testing.T) {
+	remote := []GitBranchInfo{{Name: "main"}, {Name: "develop"}}
+	got := appendLocalBranches(remote, "main\nscratch\n(HEAD detached at 1a2b3c4)\n\nscratch\n")
+	want := []GitBranchInfo{{Name: "main"}, {Name: "develop"}, {Name: "scratch", Local: true}}
+	if len(got) != len(want) {
+		t.Fatalf("appendLocalBranches() = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("branch[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
 func TestParseRemoteBranches(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -405,17 +455,18 @@ exit 1
 			ContainerUser:       "root",
 			ContainerLabelValue: "/workspace/ws-1",
 		},
-		workspaces: map[string]*WorkspaceRuntime{
-			"ws-1": {
-				ID:                  "ws-1",
-				Status:              "running",
-				ContainerLabelValue: "/workspace/ws-1",
-				ContainerWorkDir:    "/workspaces/repo",
-				ContainerUser:       "node",
-			},
-		},
 	}
 
+	putWorkspaces(&s.workspaces, map[string]*WorkspaceRuntime{
+		"ws-1": {
+			ID:                  "ws-1",
+			Status:              "running",
+			ContainerLabelValue: "/workspace/ws-1",
+			ContainerWorkDir:    "/workspaces/repo",
+			ContainerUser:       "node",
+		},
+	})
+
 	containerID, workDir, user, err := s.resolveContainerForWorkspace("ws-1")
 	if err != nil {
 		t.Fatalf("resolveContainerForWorkspace() error = %v", err)
@@ -442,15 +493,15 @@ func TestResolveContainerForWorkspaceStandaloneUsesLocalWorkDir(t *testing.T) {
 			ContainerWorkDir:  workDir,
 			ContainerCacheTTL: time.Second,
 		},
-		workspaces: map[string]*WorkspaceRuntime{
-			"ws-standalone": {
-				ID:               "ws-standalone",
-				Status:           "running",
-				WorkspaceDir:     workDir,
-				ContainerWorkDir: workDir,
-			},
-		},
 	}
+	putWorkspaces(&s.workspaces, map[string]*WorkspaceRuntime{
+		"ws-standalone": {
+			ID:               "ws-standalone",
+			Status:           "running",
+			WorkspaceDir:     workDir,
+			ContainerWorkDir: workDir,
+		},
+	})
 
 	containerID, resolvedWorkDir, user, err := s.resolveContainerForWorkspace("ws-standalone")
 	if err != nil {
@@ -0,0 +1,272 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/workspace/vm-agent/internal/acp"
+	"github.com/workspace/vm-agent/internal/lifecyclehook"
+)
+
+const (
+	maxHeadlessPromptBodyBytes = 1024 * 1024
+	// headlessWebhookAttempts is how many times an async result is posted
+	// before it is dropped.
+	headlessWebhookAttempts = 3
+)
+
+type headlessPromptRequest struct {
+	// WorkspaceID defaults to the node's primary workspace.
+	WorkspaceID string `json:"workspaceId,omitempty"`
+	// AgentType defaults to the workspace's provisioned agent.
+	AgentType      string  `json:"agentType,omitempty"`
+	Prompt         string  `json:"prompt"`
+	Model          string  `json:"model,omitempty"`
+	PermissionMode string  `json:"permissionMode,omitempty"`
+	TimeoutSeconds float64 `json:"timeoutSeconds,omitempty"`
+	// WebhookURL makes the request asynchronous: it returns 202 at once and
+	// the result is POSTed here, signed with WebhookSecret when set.
+	WebhookURL    string `json:"webhookUrl,omitempty"`
+	WebhookSecret string `json:"webhookSecret,omitempty"`
+}
+
+type headlessPromptResult struct {
+	WorkspaceID string                  `json:"workspaceId"`
+	SessionID   string                  `json:"sessionId"`
+	AgentType   string                  `json:"agentType"`
+	Status      string                  `json:"status"` // running, completed, or failed
+	StopReason  string                  `json:"stopReason,omitempty"`
+	Error       string                  `json:"error,omitempty"`
+	Messages    []acp.TranscriptMessage `json:"messages,omitempty"`
+	StartedAt   time.Time               `json:"startedAt"`
+	EndedAt     *time.Time              `json:"endedAt,omitempty"`
+}
+
+// requireCallbackTokenAuth accepts the node or workspace callback token, or
+// a workspace callback JWT, as a bearer token.
+func (s *Server) requireCallbackTokenAuth(w http.ResponseWriter, r *http.Request, workspaceID string) bool {
+	given := bearerTokenFromHeader(r.Header.Get("Authorization"))
+	if given != "" {
+		for _, candidate := range s.callbackAuthCandidates(workspaceID) {
+			if constantTimeTokenEqual(given, candidate.token) {
+				return true
+			}
+		}
+		if s.jwtValidator != nil {
+			if _, err := s.jwtValidator.ValidateWorkspaceCallbackToken(given, workspaceID); err == nil {
+				return true
+			}
+		}
+	}
+	writeError(w, http.StatusUnauthorized, "authentication required")
+	return false
+}
+
+// handleHeadlessPrompt runs one prompt in a new agent session with no
+// viewer attached, for CI and other automation. By default the request
+// waits for the agent to finish and returns the stop reason and transcript;
+// with webhookUrl it returns 202 and posts the same result when done. The
+// session is suspended afterwards, so it can still be opened in the UI.
+// POST /acp/prompt
+func (s *Server) handleHeadlessPrompt(w http.ResponseWriter, r *http.Request) {
+	var body headlessPromptRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxHeadlessPromptBodyBytes)).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	workspaceID := s.workspaces.Resolve(body.WorkspaceID)
+	if workspaceID == "" {
+		writeError(w, http.StatusBadRequest, workspaceIDRequiredMessage)
+		return
+	}
+	if !s.requireCallbackTokenAuth(w, r, workspaceID) {
+		return
+	}
+	if s.refuseWhileDraining(w) {
+		return
+	}
+
+	prompt := strings.TrimSpace(body.Prompt)
+	if prompt == "" {
+		writeError(w, http.StatusBadRequest, "prompt is required")
+		return
+	}
+	if body.TimeoutSeconds < 0 {
+		writeError(w, http.StatusBadRequest, "timeoutSeconds must not be negative")
+		return
+	}
+	webhookURL := strings.TrimSpace(body.WebhookURL)
+	if webhookURL != "" && !isAllowedHeadlessWebhookURL(webhookURL) {
+		writeError(w, http.StatusBadRequest, "webhookUrl must use HTTPS")
+		return
+	}
+
+	runtime, ok := s.getWorkspaceRuntime(workspaceID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "workspace not found")
+		return
+	}
+	if runtime.Status != "running" && runtime.Status != "recovery" {
+		writeError(w, http.StatusConflict, fmt.Sprintf("workspace is not running (status: %s)", runtime.Status))
+		return
+	}
+	agentType := strings.TrimSpace(body.AgentType)
+	if agentType == "" && runtime.ProvisionSpec != nil {
+		agentType = runtime.ProvisionSpec.Agent.Type
+	}
+	if agentType == "" {
+		agentType = s.config.ACPWarmStandbyAgent
+	}
+	if agentType == "" {
+		writeError(w, http.StatusBadRequest, "agentType is required")
+		return
+	}
+
+	sessionID := "headless-" + uuid.NewString()
+	session, _, err := s.agentSessions.Create(workspaceID, sessionID, "Headless prompt", "")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	hostKey := workspaceID + ":" + sessionID
+	s.sessionHostMu.Lock()
+	s.sessionProfileOvr[hostKey] = profileOverrides{Model: body.Model, PermissionMode: body.PermissionMode}
+	s.sessionHostMu.Unlock()
+	host := s.getOrCreateSessionHost(hostKey, workspaceID, sessionID, session, runtime, "", "")
+
+	result := headlessPromptResult{
+		WorkspaceID: workspaceID,
+		SessionID:   sessionID,
+		AgentType:   agentType,
+		Status:      "running",
+		StartedAt:   time.Now().UTC(),
+	}
+	s.appendNodeEvent(workspaceID, "info", "headless_prompt.started", "Headless prompt started", map[string]interface{}{
+		"sessionId": sessionID,
+		"agentType": agentType,
+		"async":     webhookURL != "",
+	})
+
+	if webhookURL != "" {
+		go func() {
+			final := s.runHeadlessPrompt(context.Background(), host, result, prompt, body.TimeoutSeconds)
+			s.deliverHeadlessPromptResult(webhookURL, body.WebhookSecret, final)
+		}()
+		writeJSON(w, http.StatusAccepted, result)
+		return
+	}
+
+	// The prompt is cancelled if the caller disconnects.
+	result = s.runHeadlessPrompt(r.Context(), host, result, prompt, body.TimeoutSeconds)
+	writeJSON(w, http.StatusOK, result)
+}
+
+// runHeadlessPrompt starts the agent, runs the prompt to completion, and
+// suspends the session.
+func (s *Server) runHeadlessPrompt(ctx context.Context, host *acp.SessionHost, result headlessPromptResult, prompt string, timeoutSeconds float64) headlessPromptResult {
+	workspaceID, sessionID := result.WorkspaceID, result.SessionID
+	defer s.suspendFinishedAgentSession(workspaceID, sessionID)
+
+	host.SelectAgent(ctx, result.AgentType)
+	if status := host.Status(); status != acp.HostReady {
+		result.Error = fmt.Sprintf("agent failed to start: status is %s", status)
+	} else {
+		params, _ := json.Marshal(map[string]interface{}{
+			"prompt":         []map[string]string{{"type": "text", "text": prompt}},
+			"timeoutSeconds": timeoutSeconds,
+		})
+		reqID, _ := json.Marshal("headless-prompt")
+		// trustedSource=false: the prompt text comes from the caller.
+		s.handleTrackedPrompt(ctx, workspaceID, host, reqID, params, "headless", false)
+	}
+
+	messages := host.BufferedMessages()
+	stopReason, finished := acp.LastStopReason(messages)
+	ended := time.Now().UTC()
+	result.EndedAt = &ended
+	result.StopReason = stopReason
+	result.Messages = acp.BuildTranscript(messages)
+	switch {
+	case result.Error != "":
+		result.Status = "failed"
+	case !finished || stopReason == "":
+		result.Status = "failed"
+		result.Error = "prompt did not complete"
+	default:
+		result.Status = "completed"
+	}
+
+	level, eventType, message := "info", "headless_prompt.completed", "Headless prompt completed"
+	if result.Status == "failed" {
+		level, eventType, message = "error", "headless_prompt.failed", "Headless prompt failed"
+	}
+	s.appendNodeEvent(workspaceID, level, eventType, message, map[string]interface{}{
+		"sessionId":  sessionID,
+		"stopReason": stopReason,
+		"error":      result.Error,
+	})
+	return result
+}
+
+// deliverHeadlessPromptResult posts an async result to its webhook,
+// retrying transient failures. Delivery is best effort.
+func (s *Server) deliverHeadlessPromptResult(url, secret string, result headlessPromptResult) {
+	payload, err := json.Marshal(result)
+	if err != nil {
+		slog.Error("Failed to encode headless prompt result", "session", result.SessionID, "error", err)
+		return
+	}
+	client := &http.Client{Timeout: s.config.HeadlessPromptWebhookTimeout}
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		retry, err := postHeadlessPromptResult(client, url, secret, payload)
+		if err == nil {
+			return
+		}
+		slog.Warn("Headless prompt webhook delivery failed", "session", result.SessionID, "attempt", attempt, "error", err)
+		if !retry || attempt == headlessWebhookAttempts {
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// postHeadlessPromptResult makes one delivery attempt. retry reports
+// whether a failure is worth retrying.
+func postHeadlessPromptResult(client *http.Client, url, secret string, payload []byte) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set(lifecyclehook.HeaderSignature, lifecyclehook.Sign(secret, payload))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout
+	return retry, fmt.Errorf("webhook returned HTTP %d", resp.StatusCode)
+}
+
+// isAllowedHeadlessWebhookURL accepts HTTPS URLs, and plain HTTP only on
+// localhost, matching the rule for MCP server URLs.
+func isAllowedHeadlessWebhookURL(u string) bool {
+	return strings.HasPrefix(u, "https://") ||
+		strings.HasPrefix(u, "http://localhost:") ||
+		strings.HasPrefix(u, "http://127.0.0.1:")
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/config"
+	"github.com/workspace/vm-agent/internal/lifecyclehook"
+)
+
+func newHeadlessPromptTestServer() *Server {
+	s := &Server{
+		config: &config.Config{
+			WorkspaceID:                  "ws-1",
+			CallbackToken:                "cb-token",
+			HeadlessPromptWebhookTimeout: 5 * time.Second,
+		},
+		workspaceEvents: make(map[string][]EventRecord),
+	}
+	s.workspaces.PutBoot("ws-1", &WorkspaceRuntime{ID: "ws-1", Status: "running"})
+	return s
+}
+
+func doHeadlessPrompt(s *Server, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/acp/prompt", strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	s.handleHeadlessPrompt(rec, req)
+	return rec
+}
+
+func TestHeadlessPromptRequiresCallbackToken(t *testing.T) {
+	s := newHeadlessPromptTestServer()
+	for _, token := range []string{"", "wrong-token"} {
+		if rec := doHeadlessPrompt(s, token, `{"prompt":"run tests","agentType":"claude-code"}`); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("token %q: status = %d, want 401", token, rec.Code)
+		}
+	}
+}
+
+func TestHeadlessPromptValidation(t *testing.T) {
+	s := newHeadlessPromptTestServer()
+	cases := []struct {
+		body string
+		want int
+	}{
+		{`not json`, http.StatusBadRequest},
+		{`{"agentType":"claude-code"}`, http.StatusBadRequest},
+		{`{"prompt":"run tests"}`, http.StatusBadRequest},
+		{`{"prompt":"run tests","agentType":"claude-code","timeoutSeconds":-1}`, http.StatusBadRequest},
+		{`{"prompt":"run tests","agentType":"claude-code","webhookUrl":"http://example.com/hook"}`, http.StatusBadRequest},
+		{`{"prompt":"run tests","agentType":"claude-code","workspaceId":"ws-missing"}`, http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		if rec := doHeadlessPrompt(s, "cb-token", tc.body); rec.Code != tc.want {
+			t.Errorf("body %s: status = %d, want %d (%s)", tc.body, rec.Code, tc.want, rec.Body.String())
+		}
+	}
+
+	s.workspaces.Update("ws-1", func(ws *WorkspaceRuntime) { ws.Status = "creating" })
+	if rec := doHeadlessPrompt(s, "cb-token", `{"prompt":"run tests","agentType":"claude-code"}`); rec.Code != http.StatusConflict {
+		t.Fatalf("non-running workspace status = %d, want 409", rec.Code)
+	}
+}
+
+func TestDeliverHeadlessPromptResultSignsAndRetries(t *testing.T) {
+	var attempts atomic.Int32
+	var gotSignature string
+	var got headlessPromptResult
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		gotSignature = r.Header.Get(lifecyclehook.HeaderSignature)
+		if gotSignature != lifecyclehook.Sign("hook-secret", body) {
+			t.Errorf("signature %q does not match body", gotSignature)
+		}
+		_ = json.Unmarshal(body, &got)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer hook.Close()
+
+	s := newHeadlessPromptTestServer()
+	s.deliverHeadlessPromptResult(hook.URL, "hook-secret", headlessPromptResult{SessionID: "headless-1", Status: "completed", StopReason: "end_turn"})
+	if attempts.Load() != 2 {
+		t.Fatalf("attempts = %d, want 2", attempts.Load())
+	}
+	if got.SessionID != "headless-1" || got.StopReason != "end_turn" {
+		t.Fatalf("delivered result = %+v", got)
+	}
+}
@@ -90,11 +90,18 @@ func (s *Server) sendNodeReady() {
 
 	resp, err := s.controlPlaneHTTPClient(0).Do(req)
 	if err != nil {
-		slog.Error("Node ready callback failed", "error", err)
+		slog.Error("Node ready callback failed; queued for retry after reconnect", "error", err)
+		s.nodeReadyPending.Store(true)
 		return
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode >= http.StatusInternalServerError {
+		slog.Warn("Node ready callback returned server error; queued for retry after reconnect", "statusCode", resp.StatusCode)
+		s.nodeReadyPending.Store(true)
+		return
+	}
+	s.nodeReadyPending.Store(false)
 	if resp.StatusCode >= 300 {
 		slog.Warn("Node ready callback returned non-success status", "statusCode", resp.StatusCode)
 	}
@@ -139,8 +146,13 @@ func (s *Server) sendNodeHeartbeat() {
 	url := strings.TrimRight(s.config.ControlPlaneURL, "/") + "/api/nodes/" + s.config.NodeID + "/heartbeat"
 
 	payload := map[string]interface{}{
-		"activeWorkspaces": s.activeWorkspaceCount(),
-		"nodeId":           s.config.NodeID,
+		"activeWorkspaces":   s.activeWorkspaceCount(),
+		"nodeId":             s.config.NodeID,
+		"agentUptimeSeconds": int64(time.Since(s.startedAt) / time.Second),
+	}
+	// Set by samctl idle-shutdown: the node is draining and asks to be torn down.
+	if s.idleShutdownForced.Load() {
+		payload["idleShutdownRequested"] = true
 	}
 
 	// In deployment mode, include observed deployment state + disk telemetry per environment.
@@ -176,13 +188,32 @@ func (s *Server) sendNodeHeartbeat() {
 		payload["deployment"] = map[string]interface{}{"environments": environments}
 	}
 
+	// Per-workspace activity lets the control plane's idle shutdown see agent
+	// work, viewers, and file access, not just terminal use.
+	if reports := s.workspaceActivityReports(); len(reports) > 0 {
+		payload["workspaceActivity"] = reports
+	}
+
+	// Container state and session counts let the control plane detect
+	// orphaned workspaces without polling the node.
+	if s.config.HeartbeatTelemetry {
+		if reports := s.workspaceTelemetryReports(); len(reports) > 0 {
+			payload["workspaces"] = reports
+		}
+	}
+
 	// Enrich heartbeat with lightweight system metrics (procfs only, no exec calls).
 	if s.sysInfoCollector != nil {
 		if quick, err := s.sysInfoCollector.CollectQuick(); err == nil {
 			payload["metrics"] = map[string]interface{}{
-				"cpuLoadAvg1":   quick.CPULoadAvg1,
-				"memoryPercent": quick.MemoryPercent,
-				"diskPercent":   quick.DiskPercent,
+				"cpuLoadAvg1":      quick.CPULoadAvg1,
+				"memoryPercent":    quick.MemoryPercent,
+				"memoryUsedBytes":  quick.MemoryUsedBytes,
+				"memoryTotalBytes": quick.MemoryTotalBytes,
+				"diskPercent":      quick.DiskPercent,
+				"diskUsedBytes":    quick.DiskUsedBytes,
+				"diskTotalBytes":   quick.DiskTotalBytes,
+				"uptimeSeconds":    int64(quick.UptimeSeconds),
 			}
 		} else {
 			slog.Warn("Heartbeat metrics collection failed", "error", err)
@@ -206,14 +237,21 @@ func (s *Server) sendNodeHeartbeat() {
 	resp, err := s.controlPlaneHTTPClient(0).Do(req)
 	if err != nil {
 		slog.Error("Node heartbeat failed", "error", err)
+		s.recordHeartbeatFailure("heartbeat failed: " + err.Error())
 		return
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode >= http.StatusInternalServerError {
+		slog.Warn("Node heartbeat returned non-success status", "statusCode", resp.StatusCode)
+		s.recordHeartbeatFailure(fmt.Sprintf("heartbeat returned status %d", resp.StatusCode))
+		return
+	}
 	if resp.StatusCode >= 300 {
 		slog.Warn("Node heartbeat returned non-success status", "statusCode", resp.StatusCode)
 		return
 	}
+	s.recordHeartbeatSuccess()
 
 	// Parse response to check for a refreshed callback token.
 	respBody, readErr := io.ReadAll(io.LimitReader(resp.Body, 8192))
@@ -328,14 +366,17 @@ func (s *Server) sendNodeHeartbeat() {
 	}
 
 	// Heartbeat succeeded — connectivity to the control plane is confirmed.
-	// Retry any pending workspace-ready callbacks in a background goroutine
-	// so the heartbeat ticker is not blocked by potentially slow HTTP calls.
+	// Retry any pending workspace-ready callbacks and other queued callbacks,
+	// and upload stored events, in a background goroutine so the heartbeat ticker is not blocked by
+	// potentially slow HTTP calls.
 	go func() {
 		if !s.readyRetryMu.TryLock() {
 			return // previous retry run still in flight — skip this cycle
 		}
 		defer s.readyRetryMu.Unlock()
 		s.retryPendingReadyCallbacks()
+		s.deliverQueuedCallbacks()
+		s.uploadPendingEvents()
 	}()
 }
 
@@ -475,13 +516,11 @@ func (s *Server) retryPendingReadyCallbacks() {
 }
 
 func (s *Server) activeWorkspaceCount() int {
-	s.workspaceMu.RLock()
-	defer s.workspaceMu.RUnlock()
 	count := 0
-	for _, runtime := range s.workspaces {
+	s.workspaces.Range(func(_ string, runtime *WorkspaceRuntime) {
 		if runtime.Status == "running" || runtime.Status == "recovery" {
 			count++
 		}
-	}
+	})
 	return count
 }
@@ -11,6 +11,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/workspace/vm-agent/internal/acp"
 	"github.com/workspace/vm-agent/internal/config"
 	"github.com/workspace/vm-agent/internal/deploy"
 	"github.com/workspace/vm-agent/internal/errorreport"
@@ -61,13 +62,14 @@ func TestCallbackTokenRefresh(t *testing.T) {
 	s := &Server{
 		config:        cfg,
 		callbackToken: cfg.CallbackToken,
-		workspaces: map[string]*WorkspaceRuntime{
-			"ws-token-scoped": {ID: "ws-token-scoped", CallbackToken: "workspace-token"},
-		},
 		errorReporter: newTestErrorReporter(),
 		done:          make(chan struct{}),
 	}
 
+	putWorkspaces(&s.workspaces, map[string]*WorkspaceRuntime{
+		"ws-token-scoped": {ID: "ws-token-scoped", CallbackToken: "workspace-token"},
+	})
+
 	if got := s.getCallbackToken(); got != "original-token" {
 		t.Fatalf("expected initial token 'original-token', got %q", got)
 	}
@@ -77,8 +79,8 @@ func TestCallbackTokenRefresh(t *testing.T) {
 	if got := s.getCallbackToken(); got != refreshedToken {
 		t.Fatalf("expected refreshed token %q, got %q", refreshedToken, got)
 	}
-	if got := s.workspaces["ws-token-scoped"].CallbackToken; got != "workspace-token" {
-		t.Fatalf("expected workspace token to remain scoped, got %q", got)
+	if ws, _ := s.workspaces.Get("ws-token-scoped"); ws.CallbackToken != "workspace-token" {
+		t.Fatalf("expected workspace token to remain scoped, got %q", ws.CallbackToken)
 	}
 
 	s.sendNodeHeartbeat()
@@ -221,7 +223,6 @@ func TestCallbackTokenRefreshUsesNewTokenForSubsequentRequests(t *testing.T) {
 	s := &Server{
 		config:        cfg,
 		callbackToken: cfg.CallbackToken,
-		workspaces:    make(map[string]*WorkspaceRuntime),
 		errorReporter: newTestErrorReporter(),
 		done:          make(chan struct{}),
 	}
@@ -259,7 +260,6 @@ func TestHeartbeatNoRefreshOnServerError(t *testing.T) {
 	s := &Server{
 		config:        cfg,
 		callbackToken: cfg.CallbackToken,
-		workspaces:    make(map[string]*WorkspaceRuntime),
 		errorReporter: newTestErrorReporter(),
 		done:          make(chan struct{}),
 	}
@@ -342,7 +342,6 @@ func newDeploymentHeartbeatHarness(t *testing.T, configureResponse func(*heartbe
 	s := &Server{
 		config:        cfg,
 		callbackToken: cfg.CallbackToken,
-		workspaces:    make(map[string]*WorkspaceRuntime),
 		errorReporter: newTestErrorReporter(),
 		done:          make(chan struct{}),
 		deployEngines: make(map[string]*deploy.Engine),
@@ -513,19 +512,20 @@ func TestHeartbeatRetriesPendingReadyCallback(t *testing.T) {
 	s := &Server{
 		config:        cfg,
 		callbackToken: cfg.CallbackToken,
-		workspaces: map[string]*WorkspaceRuntime{
-			"ws-retry-01": {
-				ID:                   "ws-retry-01",
-				Status:               "running",
-				CallbackToken:        "ws-token-123",
-				ReadyCallbackPending: true,
-				ReadyCallbackStatus:  "running",
-			},
-		},
 		errorReporter: newTestErrorReporter(),
 		done:          make(chan struct{}),
 	}
 
+	putWorkspaces(&s.workspaces, map[string]*WorkspaceRuntime{
+		"ws-retry-01": {
+			ID:                   "ws-retry-01",
+			Status:               "running",
+			CallbackToken:        "ws-token-123",
+			ReadyCallbackPending: true,
+			ReadyCallbackStatus:  "running",
+		},
+	})
+
 	// Heartbeat should trigger the pending callback retry (runs in background goroutine)
 	s.sendNodeHeartbeat()
 
@@ -547,10 +547,8 @@ func TestHeartbeatRetriesPendingReadyCallback(t *testing.T) {
 	}
 
 	// Verify pending flag was cleared
-	s.workspaceMu.RLock()
-	ws := s.workspaces["ws-retry-01"]
-	pending := ws.ReadyCallbackPending
-	s.workspaceMu.RUnlock()
+	var pending bool
+	s.workspaces.View("ws-retry-01", func(ws *WorkspaceRuntime) { pending = ws.ReadyCallbackPending })
 	if pending {
 		t.Fatal("expected ReadyCallbackPending to be cleared after successful retry")
 	}
@@ -582,13 +580,14 @@ func TestHeartbeatRetrySkipsWhenNoPending(t *testing.T) {
 	s := &Server{
 		config:        cfg,
 		callbackToken: cfg.CallbackToken,
-		workspaces: map[string]*WorkspaceRuntime{
-			"ws-ok": {ID: "ws-ok", Status: "running", ReadyCallbackPending: false},
-		},
 		errorReporter: newTestErrorReporter(),
 		done:          make(chan struct{}),
 	}
 
+	putWorkspaces(&s.workspaces, map[string]*WorkspaceRuntime{
+		"ws-ok": {ID: "ws-ok", Status: "running", ReadyCallbackPending: false},
+	})
+
 	s.sendNodeHeartbeat()
 
 	// Only the heartbeat request — no workspace-ready retry
@@ -629,28 +628,28 @@ func TestHeartbeatRetryPermanentErrorClearsPending(t *testing.T) {
 	s := &Server{
 		config:        cfg,
 		callbackToken: cfg.CallbackToken,
-		workspaces: map[string]*WorkspaceRuntime{
-			"ws-perm-fail": {
-				ID:                   "ws-perm-fail",
-				Status:               "running",
-				CallbackToken:        "ws-token",
-				ReadyCallbackPending: true,
-				ReadyCallbackStatus:  "running",
-			},
-		},
 		errorReporter: newTestErrorReporter(),
 		done:          make(chan struct{}),
 	}
 
+	putWorkspaces(&s.workspaces, map[string]*WorkspaceRuntime{
+		"ws-perm-fail": {
+			ID:                   "ws-perm-fail",
+			Status:               "running",
+			CallbackToken:        "ws-token",
+			ReadyCallbackPending: true,
+			ReadyCallbackStatus:  "running",
+		},
+	})
+
 	s.sendNodeHeartbeat()
 
 	// Wait for background retry goroutine to complete
 	time.Sleep(100 * time.Millisecond)
 
 	// Even on permanent error, pending should be cleared (stop retrying)
-	s.workspaceMu.RLock()
-	pending := s.workspaces["ws-perm-fail"].ReadyCallbackPending
-	s.workspaceMu.RUnlock()
+	var pending bool
+	s.workspaces.View("ws-perm-fail", func(ws *WorkspaceRuntime) { pending = ws.ReadyCallbackPending })
 	if pending {
 		t.Fatal("expected ReadyCallbackPending to be cleared on permanent error")
 	}
@@ -688,28 +687,28 @@ func TestHeartbeatRetryTransientErrorKeepsPending(t *testing.T) {
 	s := &Server{
 		config:        cfg,
 		callbackToken: cfg.CallbackToken,
-		workspaces: map[string]*WorkspaceRuntime{
-			"ws-transient": {
-				ID:                   "ws-transient",
-				Status:               "running",
-				CallbackToken:        "ws-token",
-				ReadyCallbackPending: true,
-				ReadyCallbackStatus:  "running",
-			},
-		},
 		errorReporter: newTestErrorReporter(),
 		done:          make(chan struct{}),
 	}
 
+	putWorkspaces(&s.workspaces, map[string]*WorkspaceRuntime{
+		"ws-transient": {
+			ID:                   "ws-transient",
+			Status:               "running",
+			CallbackToken:        "ws-token",
+			ReadyCallbackPending: true,
+			ReadyCallbackStatus:  "running",
+		},
+	})
+
 	s.sendNodeHeartbeat()
 
 	// Wait for background retry goroutine to complete
 	time.Sleep(100 * time.Millisecond)
 
 	// On transient error (5xx), pending flag should remain set for next retry
-	s.workspaceMu.RLock()
-	pending := s.workspaces["ws-transient"].ReadyCallbackPending
-	s.workspaceMu.RUnlock()
+	var pending bool
+	s.workspaces.View("ws-transient", func(ws *WorkspaceRuntime) { pending = ws.ReadyCallbackPending })
 	if !pending {
 		t.Fatal("expected ReadyCallbackPending to remain true on transient 5xx error")
 	}
@@ -752,19 +751,20 @@ func TestHeartbeatRetryUsesNodeTokenWhenWorkspaceHasNone(t *testing.T) {
 	s := &Server{
 		config:        cfg,
 		callbackToken: cfg.CallbackToken,
-		workspaces: map[string]*WorkspaceRuntime{
-			"ws-no-token": {
-				ID:                   "ws-no-token",
-				Status:               "running",
-				CallbackToken:        "", // empty — should fall back to node token
-				ReadyCallbackPending: true,
-				ReadyCallbackStatus:  "running",
-			},
-		},
 		errorReporter: newTestErrorReporter(),
 		done:          make(chan struct{}),
 	}
 
+	putWorkspaces(&s.workspaces, map[string]*WorkspaceRuntime{
+		"ws-no-token": {
+			ID:                   "ws-no-token",
+			Status:               "running",
+			CallbackToken:        "", // empty — should fall back to node token
+			ReadyCallbackPending: true,
+			ReadyCallbackStatus:  "running",
+		},
+	})
+
 	s.sendNodeHeartbeat()
 
 	// Wait for background retry goroutine to complete
@@ -800,7 +800,6 @@ func TestHeartbeatNoRefreshWhenFieldEmpty(t *testing.T) {
 	s := &Server{
 		config:        cfg,
 		callbackToken: cfg.CallbackToken,
-		workspaces:    make(map[string]*WorkspaceRuntime),
 		errorReporter: newTestErrorReporter(),
 		done:          make(chan struct{}),
 	}
@@ -811,3 +810,69 @@ func TestHeartbeatNoRefreshWhenFieldEmpty(t *testing.T) {
 		t.Fatalf("expected token unchanged when no refresh, got %q", got)
 	}
 }
+
+func TestHeartbeatIncludesWorkspaceTelemetry(t *testing.T) {
+	var payload struct {
+		AgentUptimeSeconds *int64               `json:"agentUptimeSeconds"`
+		Workspaces         []workspaceTelemetry `json:"workspaces"`
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("decode heartbeat: %v", err)
+		}
+		json.NewEncoder(w).Encode(heartbeatResponse{Status: "running"})
+	}))
+	defer ts.Close()
+
+	cfg := &config.Config{
+		ControlPlaneURL:    ts.URL,
+		NodeID:             "test-node-001",
+		CallbackToken:      "token",
+		HeartbeatTelemetry: true,
+	}
+	s := &Server{
+		config:        cfg,
+		callbackToken: cfg.CallbackToken,
+		startedAt:     time.Now().Add(-time.Minute),
+		sessionHosts:  make(map[string]*acp.SessionHost),
+		errorReporter: newTestErrorReporter(),
+		done:          make(chan struct{}),
+	}
+	putWorkspaces(&s.workspaces, map[string]*WorkspaceRuntime{
+		"ws-1": {ID: "ws-1", Status: "running"},
+		"ws-2": {ID: "ws-2", Status: "stopped"},
+	})
+	host := acp.NewSessionHost(acp.SessionHostConfig{
+		GatewayConfig: acp.GatewayConfig{SessionID: "chat-1", WorkspaceID: "ws-1"},
+	})
+	t.Cleanup(host.Stop)
+	s.sessionHosts["ws-1:chat-1"] = host
+	if host.AttachViewerSink("viewer-1", acp.NewPollQueue(100)) == nil {
+		t.Fatal("AttachViewerSink returned nil")
+	}
+
+	s.sendNodeHeartbeat()
+
+	if payload.AgentUptimeSeconds == nil || *payload.AgentUptimeSeconds < 60 {
+		t.Fatalf("agentUptimeSeconds = %v, want at least 60", payload.AgentUptimeSeconds)
+	}
+	want := []workspaceTelemetry{
+		{WorkspaceID: "ws-1", Status: "running", AgentSessions: 1, Viewers: 1},
+		{WorkspaceID: "ws-2", Status: "stopped"},
+	}
+	if len(payload.Workspaces) != len(want) {
+		t.Fatalf("workspaces = %+v, want %+v", payload.Workspaces, want)
+	}
+	for i := range want {
+		if payload.Workspaces[i] != want[i] {
+			t.Fatalf("workspaces[%d] = %+v, want %+v", i, payload.Workspaces[i], want[i])
+		}
+	}
+
+	cfg.HeartbeatTelemetry = false
+	payload.Workspaces = nil
+	s.sendNodeHeartbeat()
+	if payload.Workspaces != nil {
+		t.Fatalf("workspaces sent with telemetry disabled: %+v", payload.Workspaces)
+	}
+}
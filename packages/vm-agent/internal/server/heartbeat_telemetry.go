@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/containerruntime"
+)
+
+// heartbeatContainerStateTimeout bounds the container lookups of one
+// heartbeat, so a hung container engine cannot stall the heartbeat ticker.
+const heartbeatContainerStateTimeout = 5 * time.Second
+
+// Container states reported in heartbeat telemetry.
+const (
+	containerStateRunning = "running"
+	containerStateStopped = "stopped"
+	containerStateMissing = "missing"
+	containerStateUnknown = "unknown"
+)
+
+// workspaceTelemetry is the per-workspace state pushed with each heartbeat,
+// so the control plane can spot orphaned workspaces and make idle decisions
+// without polling the node. Last-activity timestamps are reported separately
+// in workspaceActivity.
+type workspaceTelemetry struct {
+	WorkspaceID string `json:"workspaceId"`
+	Status      string `json:"status"`
+	// ContainerState is running, stopped, missing, or unknown when the
+	// container engine could not be queried. Empty for workspaces without a
+	// devcontainer (standalone mode or container mode off).
+	ContainerState    string `json:"containerState,omitempty"`
+	AgentSessions     int    `json:"agentSessions"`
+	PromptingSessions int    `json:"promptingSessions"`
+	Viewers           int    `json:"viewers"`
+	RunningCommands   int    `json:"runningCommands"`
+	TerminalSessions  int    `json:"terminalSessions"`
+}
+
+// workspaceTelemetryReports returns telemetry for every hosted workspace,
+// sorted by workspace ID.
+func (s *Server) workspaceTelemetryReports() []workspaceTelemetry {
+	reports := make(map[string]*workspaceTelemetry)
+	labels := make(map[string]string)
+	s.workspaces.Range(func(id string, workspace *WorkspaceRuntime) {
+		report := &workspaceTelemetry{WorkspaceID: id, Status: workspace.Status}
+		if workspace.PTY != nil {
+			report.TerminalSessions = workspace.PTY.SessionCount()
+		}
+		reports[id] = report
+		labels[id] = workspace.ContainerLabelValue
+	})
+
+	s.sessionHostMu.Lock()
+	for key, host := range s.sessionHosts {
+		if host == nil {
+			continue
+		}
+		workspaceID, _, _ := strings.Cut(key, ":")
+		report, ok := reports[workspaceID]
+		if !ok {
+			continue
+		}
+		report.AgentSessions++
+		if host.IsPrompting() {
+			report.PromptingSessions++
+		}
+		report.Viewers += host.ViewerCount()
+		report.RunningCommands += host.RunningTerminalCount()
+	}
+	s.sessionHostMu.Unlock()
+
+	if s.config.ContainerMode && !s.config.IsStandaloneMode() {
+		ctx, cancel := context.WithTimeout(context.Background(), heartbeatContainerStateTimeout)
+		defer cancel()
+		for id, report := range reports {
+			report.ContainerState = s.containerState(ctx, labels[id])
+		}
+	}
+
+	out := make([]workspaceTelemetry, 0, len(reports))
+	for _, report := range reports {
+		out = append(out, *report)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].WorkspaceID < out[j].WorkspaceID })
+	return out
+}
+
+// containerState reports the state of the devcontainer carrying the
+// workspace's container label.
+func (s *Server) containerState(ctx context.Context, labelValue string) string {
+	if labelValue == "" {
+		return containerStateMissing
+	}
+	filter := "label=" + s.config.ContainerLabelKey + "=" + labelValue
+	rt := containerruntime.Current()
+	running, err := rt.ListContainers(ctx, false, filter)
+	if err != nil {
+		slog.Warn("Heartbeat container state lookup failed", "label", labelValue, "error", err)
+		return containerStateUnknown
+	}
+	if len(running) > 0 {
+		return containerStateRunning
+	}
+	all, err := rt.ListContainers(ctx, true, filter)
+	if err != nil {
+		slog.Warn("Heartbeat container state lookup failed", "label", labelValue, "error", err)
+		return containerStateUnknown
+	}
+	if len(all) > 0 {
+		return containerStateStopped
+	}
+	return containerStateMissing
+}
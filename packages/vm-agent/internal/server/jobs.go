@@ -0,0 +1,259 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/containerruntime"
+	"github.com/workspace/vm-agent/internal/jobs"
+)
+
+const (
+	maxJobCommandBytes = 16 * 1024
+
+	// jobPidDir holds one pid file per job inside the devcontainer, so a
+	// kill reaches the job's shell rather than just the docker exec client.
+	jobPidDir = "/tmp/sam-jobs"
+	// jobContainerScript records the shell's pid in $0 and replaces itself
+	// with the job's command, given as $1.
+	jobContainerScript = `mkdir -p ` + jobPidDir + ` && echo $$ > "$0" && exec sh -c "$1"`
+	// jobKillScript signals the job's children and then its shell.
+	jobKillScript = `pid=$(cat "$0" 2>/dev/null) || exit 0
+pkill -TERM -P "$pid" 2>/dev/null
+kill -TERM "$pid" 2>/dev/null
+true`
+)
+
+type createJobRequest struct {
+	Command string `json:"command"`
+}
+
+// jobsWorkspaceInput validates the workspace path value, authenticates the
+// request, and confirms the job manager is available.
+func (s *Server) jobsWorkspaceInput(w http.ResponseWriter, r *http.Request) (string, bool) {
+	workspaceID := r.PathValue("workspaceId")
+	if workspaceID == "" {
+		writeError(w, http.StatusBadRequest, workspaceIDRequiredMessage)
+		return "", false
+	}
+	if !s.requireWorkspaceRequestAuth(w, r, workspaceID) {
+		return "", false
+	}
+	if s.jobManager == nil {
+		writeError(w, http.StatusServiceUnavailable, "background jobs unavailable")
+		return "", false
+	}
+	return workspaceID, true
+}
+
+// handleListJobs returns the workspace's jobs, oldest first.
+// GET /workspaces/{workspaceId}/jobs
+func (s *Server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	workspaceID, ok := s.jobsWorkspaceInput(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"jobs": s.jobManager.List(workspaceID)})
+}
+
+// handleCreateJob starts a shell command in the devcontainer, or in the
+// worktree given by ?worktree=, as a tracked background job.
+// POST /workspaces/{workspaceId}/jobs
+func (s *Server) handleCreateJob(w http.ResponseWriter, r *http.Request) {
+	workspaceID, ok := s.jobsWorkspaceInput(w, r)
+	if !ok {
+		return
+	}
+
+	var body createJobRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 2*maxJobCommandBytes)).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if strings.TrimSpace(body.Command) == "" {
+		writeError(w, http.StatusBadRequest, "command is required")
+		return
+	}
+	if len(body.Command) > maxJobCommandBytes {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("command must be at most %d bytes", maxJobCommandBytes))
+		return
+	}
+	if strings.ContainsRune(body.Command, '\x00') {
+		writeError(w, http.StatusBadRequest, "command contains NUL byte")
+		return
+	}
+
+	containerID, workDir, user, err := s.resolveContainerForWorkspace(workspaceID)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	workDir, err = s.resolveWorktreeWorkDir(r, workspaceID, containerID, user, workDir)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	job, err := s.jobManager.Start(workspaceID, body.Command, workDir, func(jobID string) (*exec.Cmd, jobs.KillFunc, error) {
+		return s.jobCommand(jobID, containerID, user, workDir, body.Command)
+	})
+	if errors.Is(err, jobs.ErrLimitReached) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to start workspace job", "workspace", workspaceID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to start job")
+		return
+	}
+
+	slog.Info("Started workspace job", "workspace", workspaceID, "jobId", job.ID, "workDir", workDir)
+	s.appendNodeEvent(workspaceID, "info", "job.started", "Background job started", map[string]interface{}{"jobId": job.ID})
+	writeJSON(w, http.StatusCreated, job)
+}
+
+// jobCommand builds the command for a job and the function that kills it.
+// Jobs are not bound to the request context: they run until they exit or
+// are killed.
+func (s *Server) jobCommand(jobID, containerID, user, workDir, command string) (*exec.Cmd, jobs.KillFunc, error) {
+	if s.isStandaloneWorkspaceExec() {
+		cmd := exec.Command("/bin/sh", "-c", command)
+		cmd.Dir = workDir
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		return cmd, func(context.Context) error {
+			if cmd.Process == nil {
+				return nil
+			}
+			return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		}, nil
+	}
+
+	pidFile := jobPidDir + "/" + jobID + ".pid"
+	cmd := dockerWorkspaceExecCommand(context.Background(), containerID, containerruntime.ExecOptions{User: user, WorkDir: workDir},
+		"sh", "-c", jobContainerScript, pidFile, command)
+
+	kill := func(ctx context.Context) error {
+		killCmd := dockerWorkspaceExecCommand(ctx, containerID, containerruntime.ExecOptions{User: user},
+			"sh", "-c", jobKillScript, pidFile)
+		if output, err := killCmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("kill job in devcontainer: %w: %s", err, strings.TrimSpace(string(output)))
+		}
+		return nil
+	}
+	return cmd, kill, nil
+}
+
+// handleJobLogs returns a job's output from ?offset= (default 0) as plain
+// text. With ?follow=true the response streams new output until the job
+// finishes or the client disconnects. X-Job-Output-Offset carries the
+// offset to resume from.
+// GET /workspaces/{workspaceId}/jobs/{jobId}/logs
+func (s *Server) handleJobLogs(w http.ResponseWriter, r *http.Request) {
+	workspaceID, ok := s.jobsWorkspaceInput(w, r)
+	if !ok {
+		return
+	}
+	jobID := r.PathValue("jobId")
+
+	var offset int64
+	if raw := r.URL.Query().Get("offset"); raw != "" {
+		parsed, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || parsed < 0 {
+			writeError(w, http.StatusBadRequest, "offset must be a non-negative integer")
+			return
+		}
+		offset = parsed
+	}
+	follow := r.URL.Query().Get("follow") == "true"
+
+	data, next, done, changed, err := s.jobManager.Output(workspaceID, jobID, offset)
+	if errors.Is(err, jobs.ErrNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to read job output")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if !follow {
+		w.Header().Set("X-Job-Output-Offset", strconv.FormatInt(next, 10))
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(data)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusNotAcceptable, "streaming is not supported on this connection")
+		return
+	}
+	// Disable response buffering in nginx-style proxies.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	for {
+		if len(data) > 0 {
+			if _, err := w.Write(data); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+		if done {
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+		data, next, done, changed, err = s.jobManager.Output(workspaceID, jobID, next)
+		if err != nil {
+			// The job was removed with its workspace.
+			return
+		}
+	}
+}
+
+// handleKillJob kills a running job and returns its final state. Killing a
+// finished job returns it unchanged.
+// DELETE /workspaces/{workspaceId}/jobs/{jobId}
+func (s *Server) handleKillJob(w http.ResponseWriter, r *http.Request) {
+	workspaceID, ok := s.jobsWorkspaceInput(w, r)
+	if !ok {
+		return
+	}
+	jobID := r.PathValue("jobId")
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.jobKillTimeout())
+	defer cancel()
+	job, err := s.jobManager.Kill(ctx, workspaceID, jobID)
+	if errors.Is(err, jobs.ErrNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to kill job")
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// jobKillTimeout is how long a kill waits for a job to exit.
+func (s *Server) jobKillTimeout() time.Duration {
+	if s.config.JobsKillTimeout > 0 {
+		return s.config.JobsKillTimeout
+	}
+	return 10 * time.Second
+}
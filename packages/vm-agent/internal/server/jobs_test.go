@@ -0,0 +1,128 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/jobs"
+)
+
+func newJobsTestServer(t *testing.T) (*Server, string, string, string) {
+	t.Helper()
+	srv, workspaceID, dir, sessionID := newFileHandlerTestServer(t)
+	srv.config.JobsKillTimeout = 10 * time.Second
+	srv.workspaceEvents = make(map[string][]EventRecord)
+	srv.jobManager = jobs.New(2, 0)
+	t.Cleanup(func() { srv.jobManager.RemoveWorkspace(t.Context(), workspaceID) })
+	return srv, workspaceID, dir, sessionID
+}
+
+func jobsRequest(method, target, workspaceID, jobID, sessionID, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.SetPathValue("workspaceId", workspaceID)
+	if jobID != "" {
+		req.SetPathValue("jobId", jobID)
+	}
+	req.AddCookie(&http.Cookie{Name: "session", Value: sessionID})
+	return req
+}
+
+func startTestJob(t *testing.T, srv *Server, workspaceID, sessionID, command string) jobs.Job {
+	t.Helper()
+	body, _ := json.Marshal(createJobRequest{Command: command})
+	rec := httptest.NewRecorder()
+	srv.handleCreateJob(rec, jobsRequest(http.MethodPost, "/workspaces/"+workspaceID+"/jobs", workspaceID, "", sessionID, string(body)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create job status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	var job jobs.Job
+	if err := json.Unmarshal(rec.Body.Bytes(), &job); err != nil {
+		t.Fatalf("decode job: %v", err)
+	}
+	return job
+}
+
+func TestJobRunsInWorkspaceAndFollowsLogs(t *testing.T) {
+	srv, workspaceID, dir, sessionID := newJobsTestServer(t)
+
+	job := startTestJob(t, srv, workspaceID, sessionID, "pwd; echo done")
+	if job.Status != jobs.StatusRunning || job.WorkDir != dir {
+		t.Fatalf("unexpected job: %+v", job)
+	}
+
+	rec := httptest.NewRecorder()
+	srv.handleJobLogs(rec, jobsRequest(http.MethodGet, "/workspaces/"+workspaceID+"/jobs/"+job.ID+"/logs?follow=true", workspaceID, job.ID, sessionID, ""))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("logs status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if got, want := rec.Body.String(), dir+"\ndone\n"; got != want {
+		t.Fatalf("followed logs = %q, want %q", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	srv.handleJobLogs(rec, jobsRequest(http.MethodGet, "/workspaces/"+workspaceID+"/jobs/"+job.ID+"/logs?offset=5", workspaceID, job.ID, sessionID, ""))
+	if got := rec.Header().Get("X-Job-Output-Offset"); got != "0" && rec.Body.Len() == 0 {
+		t.Fatalf("offset read returned nothing, next offset %q", got)
+	}
+
+	rec = httptest.NewRecorder()
+	srv.handleListJobs(rec, jobsRequest(http.MethodGet, "/workspaces/"+workspaceID+"/jobs", workspaceID, "", sessionID, ""))
+	var list struct {
+		Jobs []jobs.Job `json:"jobs"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("decode list: %v", err)
+	}
+	if len(list.Jobs) != 1 || list.Jobs[0].Status != jobs.StatusSucceeded {
+		t.Fatalf("listed jobs = %+v", list.Jobs)
+	}
+}
+
+func TestKillJobEndpoint(t *testing.T) {
+	srv, workspaceID, _, sessionID := newJobsTestServer(t)
+
+	job := startTestJob(t, srv, workspaceID, sessionID, "sleep 30 & wait")
+
+	rec := httptest.NewRecorder()
+	srv.handleKillJob(rec, jobsRequest(http.MethodDelete, "/workspaces/"+workspaceID+"/jobs/"+job.ID, workspaceID, job.ID, sessionID, ""))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("kill status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	var killed jobs.Job
+	if err := json.Unmarshal(rec.Body.Bytes(), &killed); err != nil {
+		t.Fatalf("decode job: %v", err)
+	}
+	if killed.Status != jobs.StatusKilled {
+		t.Fatalf("killed job = %+v", killed)
+	}
+
+	rec = httptest.NewRecorder()
+	srv.handleKillJob(rec, jobsRequest(http.MethodDelete, "/workspaces/"+workspaceID+"/jobs/job_missing", workspaceID, "job_missing", sessionID, ""))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("kill missing status = %d, want 404", rec.Code)
+	}
+}
+
+func TestCreateJobValidation(t *testing.T) {
+	srv, workspaceID, _, sessionID := newJobsTestServer(t)
+
+	for _, body := range []string{`{}`, `{"command":"  "}`, `not json`} {
+		rec := httptest.NewRecorder()
+		srv.handleCreateJob(rec, jobsRequest(http.MethodPost, "/workspaces/"+workspaceID+"/jobs", workspaceID, "", sessionID, body))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("body %s: status = %d, want 400", body, rec.Code)
+		}
+	}
+
+	startTestJob(t, srv, workspaceID, sessionID, "sleep 30")
+	startTestJob(t, srv, workspaceID, sessionID, "sleep 30")
+	rec := httptest.NewRecorder()
+	srv.handleCreateJob(rec, jobsRequest(http.MethodPost, "/workspaces/"+workspaceID+"/jobs", workspaceID, "", sessionID, `{"command":"true"}`))
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("over limit status = %d, want 409", rec.Code)
+	}
+}
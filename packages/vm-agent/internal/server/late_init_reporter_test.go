@@ -54,7 +54,6 @@ func newServerWithoutReporter(t *testing.T) (*Server, string) {
 			ControlPlaneURL:   "http://localhost:8787",
 		},
 		ptyManager:          ptyManager,
-		workspaces:          make(map[string]*WorkspaceRuntime),
 		nodeEvents:          make([]EventRecord, 0),
 		workspaceEvents:     map[string][]EventRecord{},
 		agentSessions:       agentsessions.NewManager(),
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"log/slog"
+	"path/filepath"
+
+	"github.com/workspace/vm-agent/internal/config"
+	"github.com/workspace/vm-agent/internal/lifecyclehook"
+)
+
+// newLifecycleNotifier opens the lifecycle webhook outbox next to the
+// persistence DB and starts delivery. Returns nil when LIFECYCLE_WEBHOOK_URL
+// is unset or the outbox cannot be opened — lifecycle webhooks are
+// best-effort integration hooks and never block agent startup.
+func newLifecycleNotifier(cfg *config.Config) *lifecyclehook.Notifier {
+	if cfg.LifecycleWebhookURL == "" {
+		return nil
+	}
+
+	dbPath := filepath.Join(filepath.Dir(cfg.PersistenceDBPath), "lifecycle-webhooks.db")
+	db, err := openSQLiteDB(dbPath)
+	if err != nil {
+		slog.Warn("Failed to open lifecycle webhook outbox; lifecycle webhooks disabled", "error", err)
+		return nil
+	}
+	notifier, err := lifecyclehook.New(db, lifecyclehook.Config{
+		URL:           cfg.LifecycleWebhookURL,
+		Secret:        cfg.LifecycleWebhookSecret,
+		NodeID:        cfg.NodeID,
+		FlushInterval: cfg.LifecycleWebhookFlushInterval,
+		HTTPTimeout:   cfg.LifecycleWebhookHTTPTimeout,
+		RetryMax:      cfg.LifecycleWebhookRetryMax,
+		OutboxMaxSize: cfg.LifecycleWebhookOutboxMaxSize,
+	})
+	if err != nil {
+		slog.Warn("Failed to create lifecycle webhook notifier; lifecycle webhooks disabled", "error", err)
+		db.Close()
+		return nil
+	}
+	slog.Info("Lifecycle webhooks enabled", "outbox", dbPath)
+	return notifier
+}
+
+// NotifyLifecycle queues a lifecycle event for the external orchestration
+// webhook. workspaceID may be empty for node-scoped events. No-op when
+// lifecycle webhooks are disabled.
+func (s *Server) NotifyLifecycle(event, workspaceID string, detail map[string]interface{}) {
+	if err := s.lifecycleNotifier.Emit(event, workspaceID, detail); err != nil {
+		slog.Warn("Failed to queue lifecycle webhook event", "event", event, "workspace", workspaceID, "error", err)
+	}
+}
+
+// FlushLifecycleWebhooks attempts final delivery of queued lifecycle events
+// and stops the notifier, for an agent exiting without Stop. Events that
+// cannot be delivered stay in the outbox for the next agent process.
+func (s *Server) FlushLifecycleWebhooks() {
+	s.lifecycleNotifier.Shutdown()
+}
+
+// NotifyWorkspaceProvisioned emits devcontainer_built for a workspace whose
+// container is up, followed by recovery_entered when it came up in recovery
+// mode (devcontainer build failed and the fallback image was used).
+func (s *Server) NotifyWorkspaceProvisioned(workspaceID string, recoveryMode bool) {
+	s.NotifyLifecycle(lifecyclehook.EventDevcontainerBuilt, workspaceID, map[string]interface{}{
+		"recoveryMode": recoveryMode,
+	})
+	if recoveryMode {
+		s.NotifyLifecycle(lifecyclehook.EventRecoveryEntered, workspaceID, nil)
+	}
+}
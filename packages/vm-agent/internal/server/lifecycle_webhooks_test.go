@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/config"
+	"github.com/workspace/vm-agent/internal/lifecyclehook"
+)
+
+func TestNewLifecycleNotifierDisabledWithoutURL(t *testing.T) {
+	t.Parallel()
+
+	cfg := &config.Config{PersistenceDBPath: filepath.Join(t.TempDir(), "state.db")}
+	if n := newLifecycleNotifier(cfg); n != nil {
+		t.Fatal("expected nil notifier when LIFECYCLE_WEBHOOK_URL is unset")
+	}
+
+	// Server methods are safe with lifecycle webhooks disabled.
+	s := &Server{}
+	s.NotifyLifecycle(lifecyclehook.EventShutdownRequested, "", nil)
+}
+
+func TestNotifyWorkspaceProvisionedEmitsRecoveryEntered(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var got []lifecyclehook.Payload
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p lifecyclehook.Payload
+		_ = json.NewDecoder(r.Body).Decode(&p)
+		mu.Lock()
+		got = append(got, p)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer endpoint.Close()
+
+	cfg := &config.Config{
+		NodeID:                        "node-1",
+		PersistenceDBPath:             filepath.Join(t.TempDir(), "state.db"),
+		LifecycleWebhookURL:           endpoint.URL,
+		LifecycleWebhookFlushInterval: time.Hour,
+	}
+	s := &Server{lifecycleNotifier: newLifecycleNotifier(cfg)}
+	if s.lifecycleNotifier == nil {
+		t.Fatal("expected lifecycle notifier to be created")
+	}
+	defer s.lifecycleNotifier.Shutdown()
+
+	s.NotifyWorkspaceProvisioned("ws-1", true)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		n := len(got)
+		mu.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 2 {
+		t.Fatalf("delivered %d events, want 2", len(got))
+	}
+	if got[0].Event != lifecyclehook.EventDevcontainerBuilt || got[1].Event != lifecyclehook.EventRecoveryEntered {
+		t.Fatalf("events = [%s %s], want devcontainer_built then recovery_entered", got[0].Event, got[1].Event)
+	}
+	if got[0].WorkspaceID != "ws-1" || got[0].NodeID != "node-1" {
+		t.Fatalf("unexpected payload: %+v", got[0])
+	}
+	if got[0].Detail["recoveryMode"] != true {
+		t.Fatalf("detail.recoveryMode = %v, want true", got[0].Detail["recoveryMode"])
+	}
+}
@@ -91,13 +91,10 @@ func (s *Server) localForwardWorkspaceDiscovery(workspaceID string) (*container.
 	}
 	s.portScannerMu.RUnlock()
 
-	s.workspaceMu.RLock()
-	runtime, ok := s.workspaces[workspaceID]
 	var labelValue string
-	if ok {
+	ok := s.workspaces.View(workspaceID, func(runtime *WorkspaceRuntime) {
 		labelValue = strings.TrimSpace(runtime.ContainerLabelValue)
-	}
-	s.workspaceMu.RUnlock()
+	})
 	if !ok {
 		return nil, fmt.Errorf("workspace container route is not registered")
 	}
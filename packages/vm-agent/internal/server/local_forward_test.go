@@ -139,7 +139,6 @@ func TestResolveWorkspaceBridgeIPRejectsContainerModeFallback(t *testing.T) {
 			LabelValue: "/workspace/global",
 		}),
 		portDiscoveries: map[string]*container.Discovery{},
-		workspaces:      map[string]*WorkspaceRuntime{},
 	}
 
 	got, err := s.resolveWorkspaceBridgeIP("ws-1")
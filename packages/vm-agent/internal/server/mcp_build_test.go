@@ -135,13 +135,13 @@ func TestMcpBuildAndPublishJobStart_RequestCancelDoesNotCancelBackgroundJob(t *t
 	}))
 	defer callbacks.Close()
 	s.config.ControlPlaneURL = callbacks.URL
-	s.workspaces["ws-001"] = &WorkspaceRuntime{
+	s.workspaces.Put("ws-001", &WorkspaceRuntime{
 		ID:            "ws-001",
 		Status:        "running",
 		WorkspaceDir:  "/workspace/WS_001",
 		ProjectID:     "proj-1",
 		CallbackToken: "callback-token",
-	}
+	})
 
 	started := make(chan context.Context, 1)
 	release := make(chan struct{})
@@ -388,12 +388,12 @@ func TestMcpBuildAndPublish_MissingWorkspaceDir(t *testing.T) {
 	s, key := mcpBuildTestServer(t)
 
 	// Workspace exists but has no cloned repository path.
-	s.workspaces["ws-001"] = &WorkspaceRuntime{
+	s.workspaces.Put("ws-001", &WorkspaceRuntime{
 		ID:            "ws-001",
 		Status:        "running",
 		ProjectID:     "proj-1",
 		CallbackToken: "tok",
-	}
+	})
 
 	rec := mcpBuildPOST(t, s, key, "/workspaces/ws-001/mcp/build-and-publish", "ws-001",
 		validMcpBuildRequest())
@@ -416,12 +416,12 @@ func TestMcpBuildAndPublish_MissingProjectID(t *testing.T) {
 	// value is the only source.
 	s.config.ProjectID = ""
 
-	s.workspaces["ws-001"] = &WorkspaceRuntime{
+	s.workspaces.Put("ws-001", &WorkspaceRuntime{
 		ID:            "ws-001",
 		Status:        "running",
 		WorkspaceDir:  "/workspace/WS_001",
 		CallbackToken: "tok",
-	}
+	})
 
 	rec := mcpBuildPOST(t, s, key, "/workspaces/ws-001/mcp/build-and-publish", "ws-001",
 		validMcpBuildRequest())
@@ -441,12 +441,12 @@ func TestMcpBuildAndPublish_MissingCallbackToken(t *testing.T) {
 	t.Parallel()
 	s, key := mcpBuildTestServer(t)
 
-	s.workspaces["ws-001"] = &WorkspaceRuntime{
+	s.workspaces.Put("ws-001", &WorkspaceRuntime{
 		ID:           "ws-001",
 		Status:       "running",
 		WorkspaceDir: "/workspace/WS_001",
 		ProjectID:    "proj-1",
-	}
+	})
 
 	rec := mcpBuildPOST(t, s, key, "/workspaces/ws-001/mcp/build-and-publish", "ws-001",
 		validMcpBuildRequest())
@@ -472,13 +472,13 @@ func TestMcpBuildAndPublish_ProjectIDFallsBackToConfig(t *testing.T) {
 	s, key := mcpBuildTestServer(t)
 	s.config.ProjectID = "proj-from-config"
 
-	s.workspaces["ws-001"] = &WorkspaceRuntime{
+	s.workspaces.Put("ws-001", &WorkspaceRuntime{
 		ID:           "ws-001",
 		Status:       "running",
 		WorkspaceDir: "/workspace/WS_001",
 		// No runtime ProjectID — must fall back to config.
 		CallbackToken: "tok",
-	}
+	})
 
 	rec := mcpBuildPOST(t, s, key, "/workspaces/ws-001/mcp/build-and-publish", "ws-001",
 		validMcpBuildRequest())
@@ -115,13 +115,8 @@ func (s *Server) handleMcpWorkspaceInfo(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Get workspace runtime info (single read under lock)
-	s.workspaceMu.RLock()
-	runtime, ok := s.workspaces[workspaceID]
 	var runtimeCopy WorkspaceRuntime
-	if ok {
-		runtimeCopy = *runtime
-	}
-	s.workspaceMu.RUnlock()
+	ok := s.workspaces.View(workspaceID, func(runtime *WorkspaceRuntime) { runtimeCopy = *runtime })
 
 	resp := McpWorkspaceInfoResponse{
 		WorkspaceID: workspaceID,
@@ -39,7 +39,6 @@ func mcpTestServer(t *testing.T, controlPlaneURL string) *Server {
 			ControlPlaneURL: controlPlaneURL,
 		},
 		sessionManager:  sm,
-		workspaces:      make(map[string]*WorkspaceRuntime),
 		portScanners:    make(map[string]*ports.Scanner),
 		workspaceEvents: map[string][]EventRecord{},
 		nodeEvents:      make([]EventRecord, 0),
@@ -170,13 +169,13 @@ func TestMcpWorkspaceInfo_WorkspaceNotRunning(t *testing.T) {
 	s := mcpTestServer(t, "https://api.example.com")
 
 	// Workspace exists but is in "stopped" status.
-	s.workspaces["ws-stopped"] = &WorkspaceRuntime{
+	s.workspaces.Put("ws-stopped", &WorkspaceRuntime{
 		ID:         "ws-stopped",
 		Status:     "stopped",
 		Repository: "octo/repo",
 		Branch:     "main",
 		CreatedAt:  time.Now().Add(-10 * time.Minute),
-	}
+	})
 
 	rec := mcpGET(t, s, "/workspaces/ws-stopped/mcp/workspace-info", "ws-stopped", true, s.handleMcpWorkspaceInfo)
 
@@ -191,13 +190,13 @@ func TestMcpWorkspaceInfo_ContainerModeDisabled(t *testing.T) {
 	s := mcpTestServer(t, "https://api.example.com")
 	s.config.ContainerMode = false
 
-	s.workspaces["ws-001"] = &WorkspaceRuntime{
+	s.workspaces.Put("ws-001", &WorkspaceRuntime{
 		ID:         "ws-001",
 		Status:     "running",
 		Repository: "octo/fallback-repo",
 		Branch:     "feature-branch",
 		CreatedAt:  time.Now().Add(-5 * time.Minute),
-	}
+	})
 
 	// Container mode is off — resolveContainerForWorkspace returns "container mode is not enabled".
 	rec := mcpGET(t, s, "/workspaces/ws-001/mcp/workspace-info", "ws-001", true, s.handleMcpWorkspaceInfo)
@@ -215,7 +214,7 @@ func TestMcpWorkspaceInfo_StandaloneUsesLocalWorkspace(t *testing.T) {
 	s.config.WorkspaceDir = workDir
 	s.config.ContainerWorkDir = workDir
 
-	s.workspaces["ws-standalone"] = &WorkspaceRuntime{
+	s.workspaces.Put("ws-standalone", &WorkspaceRuntime{
 		ID:               "ws-standalone",
 		Status:           "running",
 		Repository:       "octo/repo",
@@ -223,7 +222,7 @@ func TestMcpWorkspaceInfo_StandaloneUsesLocalWorkspace(t *testing.T) {
 		WorkspaceDir:     workDir,
 		ContainerWorkDir: workDir,
 		CreatedAt:        time.Now().Add(-5 * time.Minute),
-	}
+	})
 
 	rec := mcpGET(t, s, "/workspaces/ws-standalone/mcp/workspace-info", "ws-standalone", true, s.handleMcpWorkspaceInfo)
 	if rec.Code != http.StatusOK {
@@ -274,10 +273,10 @@ func TestMcpCredentialStatus_WorkspaceNotRunning(t *testing.T) {
 	t.Parallel()
 	s := mcpTestServer(t, "https://api.example.com")
 
-	s.workspaces["ws-creating"] = &WorkspaceRuntime{
+	s.workspaces.Put("ws-creating", &WorkspaceRuntime{
 		ID:     "ws-creating",
 		Status: "creating",
-	}
+	})
 
 	rec := mcpGET(t, s, "/workspaces/ws-creating/mcp/credential-status", "ws-creating", true, s.handleMcpCredentialStatus)
 
@@ -291,10 +290,10 @@ func TestMcpCredentialStatus_ContainerModeDisabled(t *testing.T) {
 	s := mcpTestServer(t, "https://api.example.com")
 	s.config.ContainerMode = false
 
-	s.workspaces["ws-001"] = &WorkspaceRuntime{
+	s.workspaces.Put("ws-001", &WorkspaceRuntime{
 		ID:     "ws-001",
 		Status: "running",
-	}
+	})
 
 	// Without container mode, resolveContainerForWorkspace returns an error.
 	rec := mcpGET(t, s, "/workspaces/ws-001/mcp/credential-status", "ws-001", true, s.handleMcpCredentialStatus)
@@ -680,10 +679,10 @@ func TestMcpDiffSummary_WorkspaceNotRunning(t *testing.T) {
 	t.Parallel()
 	s := mcpTestServer(t, "https://api.example.com")
 
-	s.workspaces["ws-stopped"] = &WorkspaceRuntime{
+	s.workspaces.Put("ws-stopped", &WorkspaceRuntime{
 		ID:     "ws-stopped",
 		Status: "stopped",
-	}
+	})
 
 	rec := mcpGET(t, s, "/workspaces/ws-stopped/mcp/diff-summary", "ws-stopped", true, s.handleMcpDiffSummary)
 
@@ -697,10 +696,10 @@ func TestMcpDiffSummary_ContainerModeDisabled(t *testing.T) {
 	s := mcpTestServer(t, "https://api.example.com")
 	s.config.ContainerMode = false
 
-	s.workspaces["ws-001"] = &WorkspaceRuntime{
+	s.workspaces.Put("ws-001", &WorkspaceRuntime{
 		ID:     "ws-001",
 		Status: "running",
-	}
+	})
 
 	rec := mcpGET(t, s, "/workspaces/ws-001/mcp/diff-summary", "ws-001", true, s.handleMcpDiffSummary)
 
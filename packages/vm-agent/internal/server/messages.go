@@ -30,6 +30,7 @@ const (
 	MessageTypeSessionList       MessageType = "session_list"
 	MessageTypeSessionReattached MessageType = "session_reattached"
 	MessageTypeScrollback        MessageType = "scrollback"
+	MessageTypeShuttingDown      MessageType = "workspace_shutting_down"
 )
 
 // BaseMessage is the common structure for all WebSocket messages
@@ -146,6 +147,14 @@ type SessionListMessage struct {
 	Sessions []SessionInfo `json:"sessions"`
 }
 
+// ShuttingDownMessage warns terminal clients that the node is draining and
+// will stop its workloads at ShutdownAt
+type ShuttingDownMessage struct {
+	Reason           string    `json:"reason,omitempty"`
+	ShutdownAt       time.Time `json:"shutdownAt"`
+	CountdownSeconds int       `json:"countdownSeconds"`
+}
+
 // ErrorMessage represents an error message
 type ErrorMessage struct {
 	Error   string `json:"error"`
@@ -328,3 +337,18 @@ func NewScrollbackMessage(sessionID, data string) []byte {
 	result, _ := json.Marshal(msg)
 	return result
 }
+
+// NewShuttingDownMessage creates a node shutdown warning message
+func NewShuttingDownMessage(reason string, shutdownAt time.Time, countdownSeconds int) []byte {
+	msg := BaseMessage{
+		Type: MessageTypeShuttingDown,
+	}
+	data, _ := json.Marshal(ShuttingDownMessage{
+		Reason:           reason,
+		ShutdownAt:       shutdownAt,
+		CountdownSeconds: countdownSeconds,
+	})
+	msg.Data = data
+	result, _ := json.Marshal(msg)
+	return result
+}
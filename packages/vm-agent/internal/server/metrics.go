@@ -0,0 +1,284 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/acp"
+)
+
+// promptDurationBuckets are the histogram upper bounds, in seconds, for
+// vm_agent_acp_prompt_duration_seconds. Agent turns range from seconds to
+// the better part of an hour.
+var promptDurationBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600}
+
+type promptDurationKey struct {
+	agentType  string
+	stopReason string
+}
+
+type promptDurationSeries struct {
+	buckets []uint64 // cumulative counts, one per promptDurationBuckets entry
+	count   uint64
+	sum     float64
+}
+
+// promptDurationHistogram accumulates prompt durations reported by session
+// hosts through acp.GatewayConfig.OnPromptDuration.
+type promptDurationHistogram struct {
+	mu     sync.Mutex
+	series map[promptDurationKey]*promptDurationSeries
+}
+
+func newPromptDurationHistogram() *promptDurationHistogram {
+	return &promptDurationHistogram{series: make(map[promptDurationKey]*promptDurationSeries)}
+}
+
+func (h *promptDurationHistogram) observe(agentType, stopReason string, duration time.Duration) {
+	seconds := duration.Seconds()
+	key := promptDurationKey{agentType: agentType, stopReason: stopReason}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	series, ok := h.series[key]
+	if !ok {
+		series = &promptDurationSeries{buckets: make([]uint64, len(promptDurationBuckets))}
+		h.series[key] = series
+	}
+	for i, bound := range promptDurationBuckets {
+		if seconds <= bound {
+			series.buckets[i]++
+		}
+	}
+	series.count++
+	series.sum += seconds
+}
+
+func (h *promptDurationHistogram) write(w *metricsWriter) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	keys := make([]promptDurationKey, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].agentType != keys[j].agentType {
+			return keys[i].agentType < keys[j].agentType
+		}
+		return keys[i].stopReason < keys[j].stopReason
+	})
+
+	const name = "vm_agent_acp_prompt_duration_seconds"
+	w.header(name, "histogram", "Duration of completed ACP prompts.")
+	for _, key := range keys {
+		series := h.series[key]
+		for i, bound := range promptDurationBuckets {
+			w.sample(name+"_bucket", float64(series.buckets[i]), "agent_type", key.agentType, "stop_reason", key.stopReason, "le", strconv.FormatFloat(bound, 'g', -1, 64))
+		}
+		w.sample(name+"_bucket", float64(series.count), "agent_type", key.agentType, "stop_reason", key.stopReason, "le", "+Inf")
+		w.sample(name+"_sum", series.sum, "agent_type", key.agentType, "stop_reason", key.stopReason)
+		w.sample(name+"_count", float64(series.count), "agent_type", key.agentType, "stop_reason", key.stopReason)
+	}
+}
+
+// metricsWriter renders the Prometheus text exposition format.
+type metricsWriter struct {
+	b strings.Builder
+}
+
+func (w *metricsWriter) header(name, metricType, help string) {
+	fmt.Fprintf(&w.b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// sample writes one sample. labels are name/value pairs.
+func (w *metricsWriter) sample(name string, value float64, labels ...string) {
+	w.b.WriteString(name)
+	if len(labels) > 0 {
+		w.b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				w.b.WriteByte(',')
+			}
+			fmt.Fprintf(&w.b, "%s=\"%s\"", labels[i], escapeMetricLabel(labels[i+1]))
+		}
+		w.b.WriteByte('}')
+	}
+	w.b.WriteByte(' ')
+	w.b.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	w.b.WriteByte('\n')
+}
+
+func escapeMetricLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// workspaceSessionMetrics aggregates the agent session hosts of one workspace.
+type workspaceSessionMetrics struct {
+	byStatus       map[acp.SessionHostStatus]int
+	viewers        int
+	replayMessages int
+	replayBytes    int
+}
+
+// handleMetrics serves node and workspace health in the Prometheus text
+// format. Accepts the static METRICS_TOKEN bearer token (for scrapers) or a
+// node management token, like GET /system-info.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if !s.hasMetricsToken(r) && !s.requireNodeEventAuth(w, r) {
+		return
+	}
+
+	mw := &metricsWriter{}
+	s.writeSystemMetrics(mw)
+	s.writeWorkspaceMetrics(mw)
+	s.writeSessionMetrics(mw)
+	s.writeActivityMetrics(mw)
+	if s.promptDurations != nil {
+		s.promptDurations.write(mw)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(mw.b.String()))
+}
+
+func (s *Server) hasMetricsToken(r *http.Request) bool {
+	if s.config == nil || s.config.MetricsToken == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(strings.TrimSpace(r.Header.Get("Authorization")), "Bearer ")
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(s.config.MetricsToken)) == 1
+}
+
+func (s *Server) writeSystemMetrics(w *metricsWriter) {
+	w.header("vm_agent_cpu_count", "gauge", "Number of CPUs on the VM.")
+	w.sample("vm_agent_cpu_count", float64(runtime.NumCPU()))
+
+	bootstrapped := 0.0
+	if s.bootstrapComplete.Load() {
+		bootstrapped = 1
+	}
+	w.header("vm_agent_bootstrap_complete", "gauge", "Whether node bootstrap has finished (1) or not (0).")
+	w.sample("vm_agent_bootstrap_complete", bootstrapped)
+
+	if s.sysInfoCollector == nil {
+		return
+	}
+	quick, err := s.sysInfoCollector.CollectQuick()
+	if err != nil {
+		slog.Warn("Metrics system info collection failed", "error", err)
+		return
+	}
+	w.header("vm_agent_cpu_load1", "gauge", "One-minute load average.")
+	w.sample("vm_agent_cpu_load1", quick.CPULoadAvg1)
+	w.header("vm_agent_memory_used_percent", "gauge", "Memory in use, in percent.")
+	w.sample("vm_agent_memory_used_percent", quick.MemoryPercent)
+	w.header("vm_agent_disk_used_percent", "gauge", "Disk space in use, in percent.")
+	w.sample("vm_agent_disk_used_percent", quick.DiskPercent)
+}
+
+func (s *Server) writeWorkspaceMetrics(w *metricsWriter) {
+	type workspaceSnapshot struct {
+		id     string
+		status string
+		pty    int
+	}
+	var workspaces []workspaceSnapshot
+	s.workspaces.Range(func(id string, workspace *WorkspaceRuntime) {
+		snapshot := workspaceSnapshot{id: id, status: workspace.Status}
+		if workspace.PTY != nil {
+			snapshot.pty = workspace.PTY.SessionCount()
+		}
+		workspaces = append(workspaces, snapshot)
+	})
+	sort.Slice(workspaces, func(i, j int) bool { return workspaces[i].id < workspaces[j].id })
+
+	w.header("vm_agent_workspace_status", "gauge", "Workspace devcontainer status; the series with the current status is 1.")
+	for _, ws := range workspaces {
+		w.sample("vm_agent_workspace_status", 1, "workspace_id", ws.id, "status", ws.status)
+	}
+	w.header("vm_agent_pty_sessions", "gauge", "Active PTY sessions per workspace.")
+	for _, ws := range workspaces {
+		w.sample("vm_agent_pty_sessions", float64(ws.pty), "workspace_id", ws.id)
+	}
+}
+
+func (s *Server) writeSessionMetrics(w *metricsWriter) {
+	type keyedHost struct {
+		workspaceID string
+		host        *acp.SessionHost
+	}
+	s.sessionHostMu.Lock()
+	hosts := make([]keyedHost, 0, len(s.sessionHosts))
+	for key, host := range s.sessionHosts {
+		if host == nil {
+			continue
+		}
+		workspaceID, _, _ := strings.Cut(key, ":")
+		hosts = append(hosts, keyedHost{workspaceID: workspaceID, host: host})
+	}
+	s.sessionHostMu.Unlock()
+
+	byWorkspace := make(map[string]*workspaceSessionMetrics)
+	for _, kh := range hosts {
+		m, ok := byWorkspace[kh.workspaceID]
+		if !ok {
+			m = &workspaceSessionMetrics{byStatus: make(map[acp.SessionHostStatus]int)}
+			byWorkspace[kh.workspaceID] = m
+		}
+		m.byStatus[kh.host.Status()]++
+		m.viewers += kh.host.ViewerCount()
+		messages, bytes := kh.host.ReplayBufferSize()
+		m.replayMessages += messages
+		m.replayBytes += bytes
+	}
+	workspaceIDs := make([]string, 0, len(byWorkspace))
+	for id := range byWorkspace {
+		workspaceIDs = append(workspaceIDs, id)
+	}
+	sort.Strings(workspaceIDs)
+
+	w.header("vm_agent_acp_sessions", "gauge", "ACP session hosts per workspace by status.")
+	for _, id := range workspaceIDs {
+		statuses := make([]string, 0, len(byWorkspace[id].byStatus))
+		for status := range byWorkspace[id].byStatus {
+			statuses = append(statuses, string(status))
+		}
+		sort.Strings(statuses)
+		for _, status := range statuses {
+			w.sample("vm_agent_acp_sessions", float64(byWorkspace[id].byStatus[acp.SessionHostStatus(status)]), "workspace_id", id, "status", status)
+		}
+	}
+	w.header("vm_agent_acp_viewers", "gauge", "Viewers attached to ACP sessions per workspace.")
+	for _, id := range workspaceIDs {
+		w.sample("vm_agent_acp_viewers", float64(byWorkspace[id].viewers), "workspace_id", id)
+	}
+	w.header("vm_agent_acp_replay_buffer_messages", "gauge", "Messages held in ACP replay buffers per workspace.")
+	for _, id := range workspaceIDs {
+		w.sample("vm_agent_acp_replay_buffer_messages", float64(byWorkspace[id].replayMessages), "workspace_id", id)
+	}
+	w.header("vm_agent_acp_replay_buffer_bytes", "gauge", "Bytes held in ACP replay buffers per workspace.")
+	for _, id := range workspaceIDs {
+		w.sample("vm_agent_acp_replay_buffer_bytes", float64(byWorkspace[id].replayBytes), "workspace_id", id)
+	}
+}
+
+func (s *Server) writeActivityMetrics(w *metricsWriter) {
+	reports := s.workspaceActivityReports()
+	w.header("vm_agent_workspace_idle_seconds", "gauge", "Weighted time since the last workspace activity from any source.")
+	for _, report := range reports {
+		w.sample("vm_agent_workspace_idle_seconds", float64(report.IdleSeconds), "workspace_id", report.WorkspaceID)
+	}
+}
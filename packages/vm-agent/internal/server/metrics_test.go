@@ -0,0 +1,94 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/acp"
+	"github.com/workspace/vm-agent/internal/auth"
+	"github.com/workspace/vm-agent/internal/config"
+	"github.com/workspace/vm-agent/internal/pty"
+)
+
+func TestHandleMetrics(t *testing.T) {
+	t.Parallel()
+
+	host := acp.NewSessionHost(acp.SessionHostConfig{
+		GatewayConfig: acp.GatewayConfig{SessionID: "chat-1", WorkspaceID: "ws-1"},
+	})
+	t.Cleanup(host.Stop)
+
+	s := &Server{
+		config:          &config.Config{MetricsToken: "scrape-secret"},
+		sessionHosts:    map[string]*acp.SessionHost{"ws-1:chat-1": host},
+		promptDurations: newPromptDurationHistogram(),
+	}
+
+	putWorkspaces(&s.workspaces, map[string]*WorkspaceRuntime{
+		"ws-1": {ID: "ws-1", Status: "running", PTY: pty.NewManager(pty.ManagerConfig{})},
+		"ws-2": {ID: "ws-2", Status: "creating"},
+	})
+	s.promptDurations.observe("claude-code", "end_turn", 20*time.Second)
+	s.promptDurations.observe("claude-code", "end_turn", 2*time.Hour)
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer scrape-secret")
+	rec := httptest.NewRecorder()
+	s.handleMetrics(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE vm_agent_cpu_count gauge\n",
+		`vm_agent_workspace_status{workspace_id="ws-1",status="running"} 1`,
+		`vm_agent_workspace_status{workspace_id="ws-2",status="creating"} 1`,
+		`vm_agent_pty_sessions{workspace_id="ws-1"} 0`,
+		`vm_agent_acp_sessions{workspace_id="ws-1",status="idle"} 1`,
+		`vm_agent_acp_viewers{workspace_id="ws-1"} 0`,
+		`vm_agent_acp_replay_buffer_messages{workspace_id="ws-1"} 0`,
+		"# TYPE vm_agent_acp_prompt_duration_seconds histogram\n",
+		`vm_agent_acp_prompt_duration_seconds_bucket{agent_type="claude-code",stop_reason="end_turn",le="15"} 0`,
+		`vm_agent_acp_prompt_duration_seconds_bucket{agent_type="claude-code",stop_reason="end_turn",le="30"} 1`,
+		`vm_agent_acp_prompt_duration_seconds_bucket{agent_type="claude-code",stop_reason="end_turn",le="+Inf"} 2`,
+		`vm_agent_acp_prompt_duration_seconds_sum{agent_type="claude-code",stop_reason="end_turn"} 7220`,
+		`vm_agent_acp_prompt_duration_seconds_count{agent_type="claude-code",stop_reason="end_turn"} 2`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics output missing %q\n%s", want, body)
+		}
+	}
+}
+
+func TestHandleMetricsRejectsWrongToken(t *testing.T) {
+	t.Parallel()
+
+	validator, _ := newWorkspaceCreateJWTValidator(t, "node-1")
+	s := &Server{
+		config:         &config.Config{NodeID: "node-1", MetricsToken: "scrape-secret"},
+		jwtValidator:   validator,
+		sessionManager: auth.NewSessionManager("session", false, time.Hour),
+	}
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	rec := httptest.NewRecorder()
+	s.handleMetrics(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", rec.Code)
+	}
+}
+
+func TestEscapeMetricLabel(t *testing.T) {
+	t.Parallel()
+
+	if got := escapeMetricLabel("a\"b\\c\nd"); got != `a\"b\\c\nd` {
+		t.Fatalf("escapeMetricLabel = %q", got)
+	}
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/workspace/vm-agent/internal/acp"
+	"github.com/workspace/vm-agent/internal/notes"
+)
+
+const (
+	noteActionCreated = "created"
+	noteActionUpdated = "updated"
+	noteActionDeleted = "deleted"
+
+	maxNoteAuthorLength = 128
+)
+
+type createNoteRequest struct {
+	Content string `json:"content"`
+	Author  string `json:"author"`
+}
+
+type updateNoteRequest struct {
+	Content *string `json:"content"`
+	Done    *bool   `json:"done"`
+}
+
+// notesWorkspaceInput validates the workspace path value, authenticates the
+// request (browser session cookie, workspace token, or agent MCP token), and
+// confirms the notes store is available.
+func (s *Server) notesWorkspaceInput(w http.ResponseWriter, r *http.Request) (string, bool) {
+	workspaceID := r.PathValue("workspaceId")
+	if workspaceID == "" {
+		writeError(w, http.StatusBadRequest, workspaceIDRequiredMessage)
+		return "", false
+	}
+	if !s.requireWorkspaceRequestAuth(w, r, workspaceID) {
+		return "", false
+	}
+	if s.notesStore == nil {
+		writeError(w, http.StatusServiceUnavailable, "workspace notes unavailable")
+		return "", false
+	}
+	return workspaceID, true
+}
+
+// handleListNotes returns the workspace's notes, oldest first.
+func (s *Server) handleListNotes(w http.ResponseWriter, r *http.Request) {
+	workspaceID, ok := s.notesWorkspaceInput(w, r)
+	if !ok {
+		return
+	}
+	list, err := s.notesStore.List(workspaceID)
+	if err != nil {
+		slog.Error("Failed to list workspace notes", "workspace", workspaceID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to list notes")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"notes": list})
+}
+
+// handleCreateNote adds a note to the workspace and broadcasts it to viewers.
+func (s *Server) handleCreateNote(w http.ResponseWriter, r *http.Request) {
+	workspaceID, ok := s.notesWorkspaceInput(w, r)
+	if !ok {
+		return
+	}
+
+	var body createNoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if !s.validNoteContent(w, &body.Content) {
+		return
+	}
+	author := strings.TrimSpace(body.Author)
+	if author == "" {
+		author = "user"
+	}
+	if len(author) > maxNoteAuthorLength {
+		writeError(w, http.StatusBadRequest, "author is too long")
+		return
+	}
+
+	note, err := s.notesStore.Create(workspaceID, body.Content, author)
+	if errors.Is(err, notes.ErrLimitReached) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to create workspace note", "workspace", workspaceID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to create note")
+		return
+	}
+
+	s.broadcastNoteChange(workspaceID, noteActionCreated, note.ID, &note)
+	writeJSON(w, http.StatusCreated, note)
+}
+
+// handleUpdateNote edits a note's content or done flag.
+func (s *Server) handleUpdateNote(w http.ResponseWriter, r *http.Request) {
+	workspaceID, ok := s.notesWorkspaceInput(w, r)
+	if !ok {
+		return
+	}
+	noteID := r.PathValue("noteId")
+
+	var body updateNoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if body.Content == nil && body.Done == nil {
+		writeError(w, http.StatusBadRequest, "content or done is required")
+		return
+	}
+	if body.Content != nil && !s.validNoteContent(w, body.Content) {
+		return
+	}
+
+	note, err := s.notesStore.Update(workspaceID, noteID, notes.Update{Content: body.Content, Done: body.Done})
+	if errors.Is(err, notes.ErrNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to update workspace note", "workspace", workspaceID, "note", noteID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to update note")
+		return
+	}
+
+	s.broadcastNoteChange(workspaceID, noteActionUpdated, note.ID, &note)
+	writeJSON(w, http.StatusOK, note)
+}
+
+// handleDeleteNote removes a note from the workspace.
+func (s *Server) handleDeleteNote(w http.ResponseWriter, r *http.Request) {
+	workspaceID, ok := s.notesWorkspaceInput(w, r)
+	if !ok {
+		return
+	}
+	noteID := r.PathValue("noteId")
+
+	err := s.notesStore.Delete(workspaceID, noteID)
+	if errors.Is(err, notes.ErrNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to delete workspace note", "workspace", workspaceID, "note", noteID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to delete note")
+		return
+	}
+
+	s.broadcastNoteChange(workspaceID, noteActionDeleted, noteID, nil)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+}
+
+// validNoteContent trims content in place and writes an error response if it
+// is empty or exceeds NotesMaxContentBytes.
+func (s *Server) validNoteContent(w http.ResponseWriter, content *string) bool {
+	*content = strings.TrimSpace(*content)
+	if *content == "" {
+		writeError(w, http.StatusBadRequest, "content is required")
+		return false
+	}
+	if maxBytes := s.config.NotesMaxContentBytes; maxBytes > 0 && len(*content) > maxBytes {
+		writeError(w, http.StatusRequestEntityTooLarge, "note exceeds maximum size")
+		return false
+	}
+	return true
+}
+
+// broadcastNoteChange tells every viewer in the workspace that a note changed.
+// Note changes are transient: viewers that attach later load the current list
+// through handleListNotes.
+func (s *Server) broadcastNoteChange(workspaceID, action, noteID string, note *notes.Note) {
+	msg := acp.NoteChangedMessage{Type: acp.MsgNoteChanged, Action: action, NoteID: noteID}
+	if note != nil {
+		msg.Note, _ = json.Marshal(note)
+	}
+	data, _ := json.Marshal(msg)
+	s.broadcastToWorkspaceViewers(workspaceID, data)
+}
+
+// workspaceNotesForArchive returns the workspace's notes for inclusion in a
+// session snapshot manifest. Failures are logged and yield no notes so that
+// archival never fails because of notes.
+func (s *Server) workspaceNotesForArchive(workspaceID string) []notes.Note {
+	if s.notesStore == nil {
+		return nil
+	}
+	list, err := s.notesStore.List(workspaceID)
+	if err != nil {
+		slog.Warn("Failed to read workspace notes for snapshot", "workspace", workspaceID, "error", err)
+		return nil
+	}
+	return list
+}
+
+// restoreWorkspaceNotes imports notes recorded in a snapshot manifest.
+func (s *Server) restoreWorkspaceNotes(workspaceID string, manifest map[string]interface{}) {
+	raw, ok := manifest["notes"]
+	if !ok || s.notesStore == nil {
+		return
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return
+	}
+	var archived []notes.Note
+	if err := json.Unmarshal(data, &archived); err != nil {
+		slog.Warn("Ignoring malformed notes in snapshot manifest", "workspace", workspaceID, "error", err)
+		return
+	}
+	if err := s.notesStore.Import(workspaceID, archived); err != nil {
+		slog.Warn("Failed to restore workspace notes from snapshot", "workspace", workspaceID, "error", err)
+	}
+}
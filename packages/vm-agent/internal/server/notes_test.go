@@ -0,0 +1,175 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/workspace/vm-agent/internal/acp"
+	"github.com/workspace/vm-agent/internal/notes"
+)
+
+func openTestNotesStore(t *testing.T) *notes.Store {
+	t.Helper()
+	store, err := notes.New(filepath.Join(t.TempDir(), "notes.db"), 0)
+	if err != nil {
+		t.Fatalf("notes.New: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func doNotesRequest(t *testing.T, mux *http.ServeMux, cookieSessionID, method, path string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var payload []byte
+	if body != nil {
+		payload, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+	req.Header.Set("Cookie", "session="+cookieSessionID)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+// readUntilNoteChange reads viewer messages until a workspace_note_changed
+// arrives or the deadline passes. Returns nil on timeout.
+func readUntilNoteChange(t *testing.T, conn *websocket.Conn, wait time.Duration) *acp.NoteChangedMessage {
+	t.Helper()
+	deadline := time.Now().Add(wait)
+	for {
+		conn.SetReadDeadline(deadline)
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return nil
+		}
+		var msg acp.NoteChangedMessage
+		if json.Unmarshal(data, &msg) == nil && msg.Type == acp.MsgNoteChanged {
+			return &msg
+		}
+	}
+}
+
+func TestNotesCRUDBroadcastsToViewers(t *testing.T) {
+	s, ts, cookieSessionID := newAgentWSTestServer(t)
+	s.config.NotesMaxContentBytes = 64
+	s.notesStore = openTestNotesStore(t)
+	mux := http.NewServeMux()
+	s.setupRoutes(mux)
+
+	const workspaceID = "WS_TEST"
+	if _, _, err := s.agentSessions.Create(workspaceID, "sess-notes", "Chat", ""); err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	conn := dialAgentWS(t, ts, cookieSessionID, workspaceID, "sess-notes")
+	defer conn.Close()
+
+	rec := doNotesRequest(t, mux, cookieSessionID, http.MethodPost, "/workspaces/WS_TEST/notes", map[string]string{
+		"content": "  remaining follow-ups: flaky e2e  ",
+		"author":  "claude-code",
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var created notes.Note
+	_ = json.Unmarshal(rec.Body.Bytes(), &created)
+	if created.ID == "" || created.Content != "remaining follow-ups: flaky e2e" || created.Author != "claude-code" {
+		t.Fatalf("unexpected created note: %+v", created)
+	}
+	msg := readUntilNoteChange(t, conn, 5*time.Second)
+	if msg == nil || msg.Action != noteActionCreated || msg.NoteID != created.ID || len(msg.Note) == 0 {
+		t.Fatalf("viewer did not receive created note, got %+v", msg)
+	}
+
+	rec = doNotesRequest(t, mux, cookieSessionID, http.MethodPatch, "/workspaces/WS_TEST/notes/"+created.ID, map[string]bool{"done": true})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if msg := readUntilNoteChange(t, conn, 5*time.Second); msg == nil || msg.Action != noteActionUpdated {
+		t.Fatalf("viewer did not receive note update, got %+v", msg)
+	}
+
+	rec = doNotesRequest(t, mux, cookieSessionID, http.MethodGet, "/workspaces/WS_TEST/notes", nil)
+	var listed struct {
+		Notes []notes.Note `json:"notes"`
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &listed)
+	if rec.Code != http.StatusOK || len(listed.Notes) != 1 || !listed.Notes[0].Done {
+		t.Fatalf("list = %d %+v", rec.Code, listed.Notes)
+	}
+
+	rec = doNotesRequest(t, mux, cookieSessionID, http.MethodDelete, "/workspaces/WS_TEST/notes/"+created.ID, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("delete status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if msg := readUntilNoteChange(t, conn, 5*time.Second); msg == nil || msg.Action != noteActionDeleted || msg.Note != nil {
+		t.Fatalf("viewer did not receive note deletion, got %+v", msg)
+	}
+	rec = doNotesRequest(t, mux, cookieSessionID, http.MethodDelete, "/workspaces/WS_TEST/notes/"+created.ID, nil)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("second delete status = %d, want 404", rec.Code)
+	}
+}
+
+func TestNotesValidation(t *testing.T) {
+	s, _, cookieSessionID := newAgentWSTestServer(t)
+	s.config.NotesMaxContentBytes = 8
+	mux := http.NewServeMux()
+	s.setupRoutes(mux)
+
+	rec := doNotesRequest(t, mux, cookieSessionID, http.MethodGet, "/workspaces/WS_TEST/notes", nil)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status without store = %d, want 503", rec.Code)
+	}
+
+	s.notesStore = openTestNotesStore(t)
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+		want   int
+	}{
+		{"empty content", http.MethodPost, "/workspaces/WS_TEST/notes", map[string]string{"content": "   "}, http.StatusBadRequest},
+		{"too large", http.MethodPost, "/workspaces/WS_TEST/notes", map[string]string{"content": "123456789"}, http.StatusRequestEntityTooLarge},
+		{"empty patch", http.MethodPatch, "/workspaces/WS_TEST/notes/note-x", map[string]string{}, http.StatusBadRequest},
+		{"unknown note", http.MethodPatch, "/workspaces/WS_TEST/notes/note-x", map[string]bool{"done": true}, http.StatusNotFound},
+		{"other workspace", http.MethodGet, "/workspaces/WS_OTHER/notes", nil, http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		rec := doNotesRequest(t, mux, cookieSessionID, tc.method, tc.path, tc.body)
+		if rec.Code != tc.want {
+			t.Errorf("%s: status = %d, want %d (%s)", tc.name, rec.Code, tc.want, rec.Body.String())
+		}
+	}
+}
+
+func TestWorkspaceNotesSurviveSnapshotManifest(t *testing.T) {
+	source := &Server{notesStore: openTestNotesStore(t)}
+	note, err := source.notesStore.Create("ws-1", "resume the migration after restore", "user")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	manifest := snapshotManifest{Notes: source.workspaceNotesForArchive("ws-1")}
+	data, _ := json.Marshal(manifest)
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("decode manifest: %v", err)
+	}
+
+	target := &Server{notesStore: openTestNotesStore(t)}
+	target.restoreWorkspaceNotes("ws-1", decoded)
+	got, err := target.notesStore.List("ws-1")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(got) != 1 || got[0].ID != note.ID || got[0].Content != note.Content {
+		t.Fatalf("restored notes = %+v, want %+v", got, note)
+	}
+}
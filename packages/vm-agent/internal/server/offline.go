@@ -0,0 +1,205 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/acp"
+	"github.com/workspace/vm-agent/internal/persistence"
+)
+
+// controlPlaneState tracks whether the node is operating offline. The node
+// goes offline after ControlPlaneOfflineAfterFailures consecutive failed
+// heartbeats, or immediately when a session has to fall back to cached
+// credentials, and comes back online on the next successful heartbeat.
+type controlPlaneState struct {
+	offline           bool
+	since             time.Time
+	reason            string
+	heartbeatFailures int
+}
+
+// queuedCallbackSink holds callbacks that could not be delivered while the
+// control plane was unreachable. bootlog.Reporter implements it.
+type queuedCallbackSink interface {
+	Flush()
+	QueuedEntries() int
+}
+
+// offlineCacheStore adapts the persistence store to acp.OfflineCache. The
+// store encrypts every entry with the node callback-token key.
+type offlineCacheStore struct {
+	store *persistence.Store
+}
+
+func (c offlineCacheStore) SaveOffline(workspaceID, agentType, kind string, payload []byte) error {
+	return c.store.PutOfflineEntry(workspaceID, agentType, kind, string(payload))
+}
+
+func (c offlineCacheStore) LoadOffline(workspaceID, agentType, kind string) ([]byte, time.Time, error) {
+	entry, err := c.store.GetOfflineEntry(workspaceID, agentType, kind)
+	if err != nil || entry == nil {
+		return nil, time.Time{}, err
+	}
+	return []byte(entry.Payload), entry.StoredAt, nil
+}
+
+// callbackOutboxStore adapts the persistence store to acp.CallbackOutbox,
+// keeping at most keep undelivered callbacks.
+type callbackOutboxStore struct {
+	store *persistence.Store
+	keep  int
+}
+
+func (o callbackOutboxStore) EnqueueCallback(workspaceID, path string, payload []byte) error {
+	return o.store.EnqueueCallback(workspaceID, path, payload, o.keep)
+}
+
+// ReportControlPlaneUnreachable implements acp.ControlPlaneStatusReporter.
+// A session that could not reach the control plane marks the node offline
+// without waiting for the heartbeat threshold.
+func (s *Server) ReportControlPlaneUnreachable(reason string) {
+	s.markControlPlaneOffline(reason)
+}
+
+// recordHeartbeatFailure counts a failed heartbeat and marks the node offline
+// once the configured threshold is reached.
+func (s *Server) recordHeartbeatFailure(reason string) {
+	threshold := s.config.ControlPlaneOfflineAfterFailures
+	if threshold < 1 {
+		threshold = 1
+	}
+	s.controlPlaneMu.Lock()
+	s.controlPlane.heartbeatFailures++
+	failures := s.controlPlane.heartbeatFailures
+	s.controlPlaneMu.Unlock()
+
+	if failures >= threshold {
+		s.markControlPlaneOffline(reason)
+	}
+}
+
+// recordHeartbeatSuccess resets the failure count and, if the node was
+// offline, tells every viewer that it is back online.
+func (s *Server) recordHeartbeatSuccess() {
+	s.controlPlaneMu.Lock()
+	s.controlPlane.heartbeatFailures = 0
+	if !s.controlPlane.offline {
+		s.controlPlaneMu.Unlock()
+		return
+	}
+	offlineSince := s.controlPlane.since
+	s.controlPlane = controlPlaneState{since: nowUTC()}
+	msg := s.controlPlaneStatusMessageLocked()
+	s.controlPlaneMu.Unlock()
+
+	slog.Info("Control plane reachable again", "offlineFor", msg.Since.Sub(offlineSince).String())
+	s.broadcastControlPlaneStatus(msg)
+	s.appendNodeEvent("", "info", "control_plane.online", "Control plane reachable again", map[string]interface{}{
+		"offlineSince": offlineSince.Format(time.RFC3339),
+	})
+}
+
+func (s *Server) markControlPlaneOffline(reason string) {
+	s.controlPlaneMu.Lock()
+	if s.controlPlane.offline {
+		s.controlPlaneMu.Unlock()
+		return
+	}
+	s.controlPlane.offline = true
+	s.controlPlane.since = nowUTC()
+	s.controlPlane.reason = reason
+	msg := s.controlPlaneStatusMessageLocked()
+	s.controlPlaneMu.Unlock()
+
+	slog.Warn("Control plane unreachable; operating offline", "reason", reason)
+	s.broadcastControlPlaneStatus(msg)
+	s.appendNodeEvent("", "warn", "control_plane.offline", "Control plane unreachable; operating offline", map[string]interface{}{
+		"reason": reason,
+	})
+}
+
+func (s *Server) controlPlaneStatusMessageLocked() acp.ControlPlaneStatusMessage {
+	return acp.ControlPlaneStatusMessage{
+		Type:    acp.MsgControlPlaneStatus,
+		Offline: s.controlPlane.offline,
+		Since:   s.controlPlane.since,
+		Reason:  s.controlPlane.reason,
+	}
+}
+
+// broadcastControlPlaneStatus sends msg to every viewer of every session on
+// the node.
+func (s *Server) broadcastControlPlaneStatus(msg acp.ControlPlaneStatusMessage) {
+	data, _ := json.Marshal(msg)
+	s.sessionHostMu.Lock()
+	hosts := make([]*acp.SessionHost, 0, len(s.sessionHosts))
+	for _, host := range s.sessionHosts {
+		if host != nil {
+			hosts = append(hosts, host)
+		}
+	}
+	s.sessionHostMu.Unlock()
+
+	for _, host := range hosts {
+		host.BroadcastTransient(data)
+	}
+}
+
+// sendControlPlaneStatus tells a newly attached viewer that the node is
+// operating offline. No-op while the control plane is reachable.
+func (s *Server) sendControlPlaneStatus(host *acp.SessionHost, viewerID string) {
+	s.controlPlaneMu.Lock()
+	if !s.controlPlane.offline {
+		s.controlPlaneMu.Unlock()
+		return
+	}
+	msg := s.controlPlaneStatusMessageLocked()
+	s.controlPlaneMu.Unlock()
+
+	data, _ := json.Marshal(msg)
+	host.SendToViewer(viewerID, data)
+}
+
+// trackCallbackQueue registers a sink whose queued callbacks are redelivered
+// after the control plane becomes reachable again.
+func (s *Server) trackCallbackQueue(workspaceID string, sink queuedCallbackSink) {
+	s.controlPlaneMu.Lock()
+	defer s.controlPlaneMu.Unlock()
+	if s.callbackQueues == nil {
+		s.callbackQueues = make(map[string]queuedCallbackSink)
+	}
+	s.callbackQueues[workspaceID] = sink
+}
+
+// untrackCallbackQueue drops a deleted workspace's sink.
+func (s *Server) untrackCallbackQueue(workspaceID string) {
+	s.controlPlaneMu.Lock()
+	defer s.controlPlaneMu.Unlock()
+	delete(s.callbackQueues, workspaceID)
+}
+
+// deliverQueuedCallbacks redelivers the node-ready callback, the persisted
+// callback outbox and boot-log entries that failed while the control plane
+// was unreachable. Called after a successful heartbeat.
+func (s *Server) deliverQueuedCallbacks() {
+	if s.nodeReadyPending.Load() {
+		slog.Info("Retrying queued node-ready callback")
+		s.sendNodeReady()
+	}
+	s.deliverOutboxCallbacks()
+
+	s.controlPlaneMu.Lock()
+	sinks := make([]queuedCallbackSink, 0, len(s.callbackQueues))
+	for _, sink := range s.callbackQueues {
+		sinks = append(sinks, sink)
+	}
+	s.controlPlaneMu.Unlock()
+
+	for _, sink := range sinks {
+		if sink.QueuedEntries() > 0 {
+			sink.Flush()
+		}
+	}
+}
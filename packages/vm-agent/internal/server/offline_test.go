@@ -0,0 +1,95 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/workspace/vm-agent/internal/acp"
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+func readUntilControlPlaneStatus(t *testing.T, conn *websocket.Conn, wait time.Duration) *acp.ControlPlaneStatusMessage {
+	t.Helper()
+	deadline := time.Now().Add(wait)
+	for {
+		conn.SetReadDeadline(deadline)
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return nil
+		}
+		var msg acp.ControlPlaneStatusMessage
+		if json.Unmarshal(data, &msg) == nil && msg.Type == acp.MsgControlPlaneStatus {
+			return &msg
+		}
+	}
+}
+
+func TestControlPlaneOfflineStatusReachesViewers(t *testing.T) {
+	s, ts, cookieSessionID := newAgentWSTestServer(t)
+	s.config.ControlPlaneOfflineAfterFailures = 2
+	const (
+		workspaceID = "WS_TEST"
+		sessionID   = "sess-offline"
+	)
+	if _, _, err := s.agentSessions.Create(workspaceID, sessionID, "Chat", ""); err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+
+	s.recordHeartbeatFailure("heartbeat failed")
+	if s.controlPlane.offline {
+		t.Fatal("node marked offline before the failure threshold")
+	}
+	s.recordHeartbeatFailure("heartbeat failed")
+
+	conn := dialAgentWS(t, ts, cookieSessionID, workspaceID, sessionID)
+	defer conn.Close()
+	got := readUntilControlPlaneStatus(t, conn, 5*time.Second)
+	if got == nil || !got.Offline || got.Reason != "heartbeat failed" || got.Since.IsZero() {
+		t.Fatalf("late joiner status = %+v, want offline", got)
+	}
+
+	s.recordHeartbeatSuccess()
+	if got := readUntilControlPlaneStatus(t, conn, 5*time.Second); got == nil || got.Offline {
+		t.Fatalf("recovery status = %+v, want online", got)
+	}
+	if s.controlPlane.heartbeatFailures != 0 {
+		t.Fatalf("heartbeatFailures = %d after success, want 0", s.controlPlane.heartbeatFailures)
+	}
+}
+
+func TestQueuedNodeReadyDeliveredAfterReconnect(t *testing.T) {
+	var available atomic.Bool
+	var delivered atomic.Int32
+	cp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !available.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path == "/api/nodes/node-1/ready" {
+			delivered.Add(1)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer cp.Close()
+
+	s := &Server{config: &config.Config{NodeID: "node-1", ControlPlaneURL: cp.URL, CallbackToken: "callback-token"}}
+	s.sendNodeReady()
+	if !s.nodeReadyPending.Load() {
+		t.Fatal("expected node-ready callback to be queued after a 503")
+	}
+
+	available.Store(true)
+	s.deliverQueuedCallbacks()
+	if s.nodeReadyPending.Load() || delivered.Load() != 1 {
+		t.Fatalf("pending=%v delivered=%d, want the queued callback delivered once", s.nodeReadyPending.Load(), delivered.Load())
+	}
+	s.deliverQueuedCallbacks()
+	if delivered.Load() != 1 {
+		t.Fatalf("delivered=%d, want no redelivery once acknowledged", delivered.Load())
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"strings"
 
 	"github.com/workspace/vm-agent/internal/config"
+	"github.com/workspace/vm-agent/internal/container"
 )
 
 // handleWorkspacePortProxy proxies workspace port traffic through the node agent.
@@ -55,12 +56,7 @@ func (s *Server) handleWorkspacePortProxy(w http.ResponseWriter, r *http.Request
 	// when REPOSITORY was empty at startup). Per-workspace discoveries are created
 	// in StartPortScanner with the correct label for the workspace's repository.
 	targetHost := "127.0.0.1"
-	discovery := s.containerDiscovery // fallback
-	s.portScannerMu.RLock()
-	if wsDisc, ok := s.portDiscoveries[workspaceID]; ok {
-		discovery = wsDisc
-	}
-	s.portScannerMu.RUnlock()
+	discovery := s.workspaceDiscovery(workspaceID)
 
 	if discovery != nil {
 		bridgeIP, bridgeErr := discovery.GetBridgeIP()
@@ -105,6 +101,17 @@ func (s *Server) handleWorkspacePortProxy(w http.ResponseWriter, r *http.Request
 	s.servePortProxy(w, r, workspaceID, port, targetURLStr, forwardPath)
 }
 
+// workspaceDiscovery returns the container discovery for a workspace,
+// falling back to the server-level discovery. Nil when not in container mode.
+func (s *Server) workspaceDiscovery(workspaceID string) *container.Discovery {
+	s.portScannerMu.RLock()
+	defer s.portScannerMu.RUnlock()
+	if wsDisc, ok := s.portDiscoveries[workspaceID]; ok {
+		return wsDisc
+	}
+	return s.containerDiscovery
+}
+
 // servePortProxy builds a reverse proxy for a workspace port and serves the request.
 // It sets the Host header to the original client-facing hostname (from X-Forwarded-Host)
 // so that dev servers (Vite, Next.js, etc.) see the correct origin. Falls back to a
@@ -203,12 +210,10 @@ func (s *Server) handleListWorkspacePorts(w http.ResponseWriter, r *http.Request
 		}
 	}
 	// Include workspace runtime info
-	s.workspaceMu.RLock()
-	if runtime, ok := s.workspaces[workspaceID]; ok {
+	s.workspaces.View(workspaceID, func(runtime *WorkspaceRuntime) {
 		diag["containerLabelValue"] = runtime.ContainerLabelValue
 		diag["workspaceDir"] = runtime.WorkspaceDir
-	}
-	s.workspaceMu.RUnlock()
+	})
 
 	if scanner != nil {
 		diag["consecutiveFailures"] = scanner.ConsecutiveFailures()
@@ -4,8 +4,10 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/gorilla/websocket"
 	"github.com/workspace/vm-agent/internal/config"
 )
 
@@ -93,3 +95,47 @@ func TestPortProxyForwardsHostHeader(t *testing.T) {
 		})
 	}
 }
+
+func TestPortProxyUpgradesWebSocketsAndStripsToken(t *testing.T) {
+	t.Parallel()
+
+	type upstreamRequest struct{ token, path string }
+	seen := make(chan upstreamRequest, 1)
+	upgrader := websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- upstreamRequest{token: r.URL.Query().Get("token"), path: r.URL.Path}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		msgType, data, err := conn.ReadMessage()
+		if err == nil {
+			_ = conn.WriteMessage(msgType, data)
+		}
+	}))
+	defer backend.Close()
+
+	s := &Server{config: &config.Config{ControlPlaneURL: "https://api.example.com"}}
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.servePortProxy(w, r, "WS001", 5173, backend.URL, "/hmr")
+	}))
+	defer proxy.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(proxy.URL, "http")+"/workspaces/WS001/ports/5173/hmr?token=secret", nil)
+	if err != nil {
+		t.Fatalf("dial through proxy: %v", err)
+	}
+	defer conn.Close()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("reload")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_, data, err := conn.ReadMessage()
+	if err != nil || string(data) != "reload" {
+		t.Fatalf("echo = %q, %v", data, err)
+	}
+	got := <-seen
+	if got.path != "/hmr" || got.token != "" {
+		t.Fatalf("backend saw path %q token %q, want /hmr and no token", got.path, got.token)
+	}
+}
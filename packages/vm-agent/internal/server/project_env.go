@@ -0,0 +1,240 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/acp"
+	"github.com/workspace/vm-agent/internal/bootstrap"
+)
+
+const (
+	// maxProjectEnvBodyBytes caps a PUT /env body.
+	maxProjectEnvBodyBytes = 1 << 20
+	// envReloadRetryInterval is how often a session busy with a prompt is
+	// asked again to reload its environment.
+	envReloadRetryInterval = 5 * time.Second
+	// projectEnvTimeout bounds reading and rewriting the container's env files.
+	projectEnvTimeout = 30 * time.Second
+)
+
+// Overridable for tests.
+var (
+	readProjectEnv  = bootstrap.ReadProjectEnv
+	writeProjectEnv = bootstrap.WriteProjectEnv
+)
+
+type putProjectEnvRequest struct {
+	EnvVars []struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	} `json:"envVars"`
+}
+
+// handlePutProjectEnv sets project env vars in a running workspace, adding
+// new keys and replacing existing ones, and restarts its agent sessions so
+// they see the change.
+func (s *Server) handlePutProjectEnv(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.PathValue("workspaceId")
+	if workspaceID == "" {
+		writeError(w, http.StatusBadRequest, workspaceIDRequiredMessage)
+		return
+	}
+	if !s.requireNodeManagementAuth(w, r, workspaceID) {
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxProjectEnvBodyBytes+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "failed to read request body")
+		return
+	}
+	if len(data) > maxProjectEnvBodyBytes {
+		writeError(w, http.StatusRequestEntityTooLarge, "env vars too large")
+		return
+	}
+	var body putProjectEnvRequest
+	if err := json.Unmarshal(data, &body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if len(body.EnvVars) == 0 {
+		writeError(w, http.StatusBadRequest, "envVars is required")
+		return
+	}
+	updates := make([]bootstrap.ProjectRuntimeEnvVar, 0, len(body.EnvVars))
+	for _, envVar := range body.EnvVars {
+		key := strings.TrimSpace(envVar.Key)
+		if !bootstrap.ValidProjectEnvKey(key) {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid env var key %q", envVar.Key))
+			return
+		}
+		updates = append(updates, bootstrap.ProjectRuntimeEnvVar{Key: key, Value: envVar.Value})
+	}
+
+	s.updateProjectEnv(w, workspaceID, func(current []bootstrap.ProjectRuntimeEnvVar) ([]bootstrap.ProjectRuntimeEnvVar, bool) {
+		index := make(map[string]int, len(current))
+		for i, envVar := range current {
+			index[envVar.Key] = i
+		}
+		for _, update := range updates {
+			if i, ok := index[update.Key]; ok {
+				current[i] = update
+				continue
+			}
+			index[update.Key] = len(current)
+			current = append(current, update)
+		}
+		return current, true
+	})
+}
+
+// handleDeleteProjectEnv removes a project env var from a running workspace
+// and restarts its agent sessions so they see the change.
+func (s *Server) handleDeleteProjectEnv(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.PathValue("workspaceId")
+	if workspaceID == "" {
+		writeError(w, http.StatusBadRequest, workspaceIDRequiredMessage)
+		return
+	}
+	if !s.requireNodeManagementAuth(w, r, workspaceID) {
+		return
+	}
+	key := r.PathValue("key")
+	if !bootstrap.ValidProjectEnvKey(key) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid env var key %q", key))
+		return
+	}
+
+	s.updateProjectEnv(w, workspaceID, func(current []bootstrap.ProjectRuntimeEnvVar) ([]bootstrap.ProjectRuntimeEnvVar, bool) {
+		kept := current[:0]
+		for _, envVar := range current {
+			if envVar.Key != key {
+				kept = append(kept, envVar)
+			}
+		}
+		return kept, len(kept) < len(current)
+	})
+}
+
+// updateProjectEnv rewrites the workspace's project env files with the
+// result of change, which reports false when the requested key is not set,
+// and schedules an environment reload of the workspace's agent sessions.
+func (s *Server) updateProjectEnv(w http.ResponseWriter, workspaceID string, change func([]bootstrap.ProjectRuntimeEnvVar) ([]bootstrap.ProjectRuntimeEnvVar, bool)) {
+	if _, ok := s.getWorkspaceRuntime(workspaceID); !ok {
+		writeError(w, http.StatusNotFound, "workspace not found")
+		return
+	}
+	containerID, _, _, err := s.resolveContainerForWorkspace(workspaceID)
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if containerID == "" {
+		writeError(w, http.StatusConflict, "project env vars require a devcontainer")
+		return
+	}
+
+	s.projectEnvMu.Lock()
+	defer s.projectEnvMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), projectEnvTimeout)
+	defer cancel()
+	current, err := readProjectEnv(ctx, containerID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	envVars, found := change(current)
+	if !found {
+		writeError(w, http.StatusNotFound, "env var not set")
+		return
+	}
+	if err := writeProjectEnv(ctx, containerID, envVars); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	keys := make([]string, 0, len(envVars))
+	for _, envVar := range envVars {
+		keys = append(keys, envVar.Key)
+	}
+	sessions := s.reloadWorkspaceEnvironmentLocked(workspaceID)
+	slog.Info("Updated project env vars", "workspace", workspaceID, "keys", keys, "sessionsReloading", sessions)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys":              keys,
+		"sessionsReloading": sessions,
+	})
+}
+
+// reloadWorkspaceEnvironmentLocked asks each agent session of the workspace,
+// including its warm standby, to restart with the updated environment, and
+// returns how many it asked. The caller must hold projectEnvMu.
+func (s *Server) reloadWorkspaceEnvironmentLocked(workspaceID string) int {
+	var hosts []*acp.SessionHost
+	s.sessionHostMu.Lock()
+	prefix := workspaceID + ":"
+	for key, host := range s.sessionHosts {
+		if host != nil && strings.HasPrefix(key, prefix) {
+			hosts = append(hosts, host)
+		}
+	}
+	if host := s.warmStandbyHosts[workspaceID]; host != nil {
+		hosts = append(hosts, host)
+	}
+	s.sessionHostMu.Unlock()
+
+	if s.envReloadWaiting == nil {
+		s.envReloadWaiting = make(map[*acp.SessionHost]bool)
+	}
+	for _, host := range hosts {
+		// A reload already waiting for a prompt to finish will read the
+		// files as they are when it runs.
+		if s.envReloadWaiting[host] {
+			continue
+		}
+		s.envReloadWaiting[host] = true
+		go s.reloadSessionEnvironment(workspaceID, host)
+	}
+	return len(hosts)
+}
+
+// reloadSessionEnvironment restarts host with the updated environment,
+// waiting for a running prompt to finish first so the turn is not lost.
+func (s *Server) reloadSessionEnvironment(workspaceID string, host *acp.SessionHost) {
+	for {
+		s.projectEnvMu.Lock()
+		delete(s.envReloadWaiting, host)
+		s.projectEnvMu.Unlock()
+
+		err := host.ReloadEnvironment(context.Background())
+		if !errors.Is(err, acp.ErrEnvReloadBusy) {
+			if err != nil {
+				slog.Debug("Skipped agent environment reload", "workspace", workspaceID, "error", err)
+			}
+			return
+		}
+
+		s.projectEnvMu.Lock()
+		if s.envReloadWaiting[host] {
+			// A newer update scheduled its own reload.
+			s.projectEnvMu.Unlock()
+			return
+		}
+		s.envReloadWaiting[host] = true
+		s.projectEnvMu.Unlock()
+
+		select {
+		case <-s.done:
+			return
+		case <-time.After(envReloadRetryInterval):
+		}
+	}
+}
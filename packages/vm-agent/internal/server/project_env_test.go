@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/acp"
+	"github.com/workspace/vm-agent/internal/bootstrap"
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+func TestProjectEnvPutAndDeleteRewriteContainerEnv(t *testing.T) {
+	mockDocker := filepath.Join(t.TempDir(), "docker")
+	if err := os.WriteFile(mockDocker, []byte("#!/bin/sh\nif [ \"$1\" = \"ps\" ]; then echo container-123; exit 0; fi\nexit 1\n"), 0o755); err != nil {
+		t.Fatalf("failed to write mock docker command: %v", err)
+	}
+	t.Setenv("SAM_DOCKER_CLI_PATH", mockDocker)
+
+	stored := []bootstrap.ProjectRuntimeEnvVar{{Key: "EXISTING", Value: "old"}}
+	origRead, origWrite := readProjectEnv, writeProjectEnv
+	t.Cleanup(func() { readProjectEnv, writeProjectEnv = origRead, origWrite })
+	readProjectEnv = func(_ context.Context, containerID string) ([]bootstrap.ProjectRuntimeEnvVar, error) {
+		if containerID != "container-123" {
+			t.Errorf("read containerID = %q, want container-123", containerID)
+		}
+		return append([]bootstrap.ProjectRuntimeEnvVar(nil), stored...), nil
+	}
+	writeProjectEnv = func(_ context.Context, _ string, envVars []bootstrap.ProjectRuntimeEnvVar) error {
+		stored = append([]bootstrap.ProjectRuntimeEnvVar(nil), envVars...)
+		return nil
+	}
+
+	validator, key := newWorkspaceCreateJWTValidator(t, "node-1")
+	s := &Server{
+		config: &config.Config{
+			NodeID:              "node-1",
+			ContainerMode:       true,
+			ContainerLabelKey:   "devcontainer.local_folder",
+			ContainerCacheTTL:   time.Second,
+			ContainerLabelValue: "/workspace/ws-1",
+		},
+		jwtValidator: validator,
+		sessionHosts: make(map[string]*acp.SessionHost),
+	}
+	putWorkspaces(&s.workspaces, map[string]*WorkspaceRuntime{
+		"ws-1": {ID: "ws-1", Status: "running", ContainerLabelValue: "/workspace/ws-1"},
+	})
+	mux := http.NewServeMux()
+	s.setupRoutes(mux)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+signWorkspaceCreateNodeToken(t, key, "node-1", "ws-1"))
+		req.Header.Set("X-SAM-Workspace-Id", "ws-1")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPut, "/workspaces/ws-1/env", `{"envVars":[{"key":"API_KEY","value":"sk-1"},{"key":"EXISTING","value":"new"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Keys []string `json:"keys"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode PUT response: %v", err)
+	}
+	if !reflect.DeepEqual(resp.Keys, []string{"EXISTING", "API_KEY"}) {
+		t.Fatalf("keys = %v, want [EXISTING API_KEY]", resp.Keys)
+	}
+	if strings.Contains(rec.Body.String(), "sk-1") {
+		t.Fatalf("PUT response leaks a value: %s", rec.Body.String())
+	}
+	want := []bootstrap.ProjectRuntimeEnvVar{{Key: "EXISTING", Value: "new"}, {Key: "API_KEY", Value: "sk-1"}}
+	if !reflect.DeepEqual(stored, want) {
+		t.Fatalf("stored env = %+v, want %+v", stored, want)
+	}
+
+	if rec := do(http.MethodPut, "/workspaces/ws-1/env", `{"envVars":[{"key":"BAD-KEY","value":"x"}]}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid key status = %d, want 400", rec.Code)
+	}
+
+	if rec := do(http.MethodDelete, "/workspaces/ws-1/env/EXISTING", ""); rec.Code != http.StatusOK {
+		t.Fatalf("DELETE status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if want := []bootstrap.ProjectRuntimeEnvVar{{Key: "API_KEY", Value: "sk-1"}}; !reflect.DeepEqual(stored, want) {
+		t.Fatalf("stored env after delete = %+v, want %+v", stored, want)
+	}
+	if rec := do(http.MethodDelete, "/workspaces/ws-1/env/EXISTING", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("DELETE of unset key status = %d, want 404", rec.Code)
+	}
+	if stored[0].Value != "sk-1" {
+		t.Fatalf("a rejected request changed the env: %+v", stored)
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"os"
+	"strconv"
 	"strings"
 
 	"github.com/workspace/vm-agent/internal/acp"
@@ -23,6 +25,8 @@ type projectRuntimeFilePayload struct {
 	Path     string `json:"path"`
 	Content  string `json:"content"`
 	IsSecret bool   `json:"isSecret"`
+	Mode     string `json:"mode,omitempty"`  // octal, e.g. "0755"
+	Owner    string `json:"owner,omitempty"` // chown spec, e.g. "node:node"
 }
 
 type projectRuntimeAssetsPayload struct {
@@ -42,10 +46,7 @@ func (s *Server) fetchProjectRuntimeAssetsForWorkspace(
 	callbackToken string,
 	agentSessionID string,
 ) (projectRuntimeAssets, error) {
-	targetWorkspaceID := strings.TrimSpace(workspaceID)
-	if targetWorkspaceID == "" {
-		targetWorkspaceID = strings.TrimSpace(s.config.WorkspaceID)
-	}
+	targetWorkspaceID := s.workspaces.Resolve(workspaceID)
 	if targetWorkspaceID == "" {
 		return projectRuntimeAssets{}, fmt.Errorf("workspace id is required for runtime-assets request")
 	}
@@ -102,10 +103,16 @@ func (s *Server) fetchProjectRuntimeAssetsForWorkspace(
 
 	files := make([]bootstrap.ProjectRuntimeFile, 0, len(payload.Files))
 	for _, item := range payload.Files {
+		mode, err := parseProjectRuntimeFileMode(item.Mode)
+		if err != nil {
+			return projectRuntimeAssets{}, fmt.Errorf("runtime-assets file %s: %w", item.Path, err)
+		}
 		files = append(files, bootstrap.ProjectRuntimeFile{
 			Path:     item.Path,
 			Content:  item.Content,
 			IsSecret: item.IsSecret,
+			Mode:     mode,
+			Owner:    item.Owner,
 		})
 	}
 
@@ -115,6 +122,30 @@ func (s *Server) fetchProjectRuntimeAssetsForWorkspace(
 	}, nil
 }
 
+// parseProjectRuntimeFileMode parses an octal file mode such as "0755".
+// An empty value returns zero, which selects the default mode.
+func parseProjectRuntimeFileMode(value string) (os.FileMode, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	parsed, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || parsed == 0 || parsed > 0o7777 {
+		return 0, fmt.Errorf("invalid file mode %q", value)
+	}
+	mode := os.FileMode(parsed).Perm()
+	if parsed&0o4000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if parsed&0o2000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if parsed&0o1000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode, nil
+}
+
 func (s *Server) runtimeAssetsProviderForWorkspaceSession(workspaceID, sessionID string) acp.RuntimeAssetsProvider {
 	return func(ctx context.Context) (acp.RuntimeAssets, error) {
 		assets, err := s.fetchProjectRuntimeAssetsForWorkspace(ctx, workspaceID, "", sessionID)
@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
 
@@ -86,3 +87,48 @@ func TestFetchProjectRuntimeAssetsForWorkspaceHTTPError(t *testing.T) {
 		t.Fatalf("error leaked response body: %v", err)
 	}
 }
+
+func TestFetchProjectRuntimeAssetsFileModeAndOwner(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"workspaceId":"ws-123","envVars":[],"files":[{"path":"bin/run.sh","content":"#!/bin/sh\n","isSecret":false,"mode":"0755","owner":"node:node"}]}`))
+	}))
+	defer server.Close()
+
+	s := &Server{config: &config.Config{ControlPlaneURL: server.URL, WorkspaceID: "ws-123"}}
+
+	assets, err := s.fetchProjectRuntimeAssetsForWorkspace(context.Background(), "ws-123", "callback-token", "")
+	if err != nil {
+		t.Fatalf("fetchProjectRuntimeAssetsForWorkspace returned error: %v", err)
+	}
+	if len(assets.Files) != 1 {
+		t.Fatalf("expected 1 file, got %d", len(assets.Files))
+	}
+	if assets.Files[0].Mode != 0o755 {
+		t.Fatalf("file Mode = %o, want 755", assets.Files[0].Mode)
+	}
+	if assets.Files[0].Owner != "node:node" {
+		t.Fatalf("file Owner = %q, want node:node", assets.Files[0].Owner)
+	}
+}
+
+func TestParseProjectRuntimeFileMode(t *testing.T) {
+	for input, want := range map[string]os.FileMode{
+		"":     0,
+		"644":  0o644,
+		"0600": 0o600,
+		"4755": 0o755 | os.ModeSetuid,
+	} {
+		got, err := parseProjectRuntimeFileMode(input)
+		if err != nil {
+			t.Fatalf("parseProjectRuntimeFileMode(%q) error = %v", input, err)
+		}
+		if got != want {
+			t.Fatalf("parseProjectRuntimeFileMode(%q) = %v, want %v", input, got, want)
+		}
+	}
+	for _, input := range []string{"0", "999", "rwx", "17777"} {
+		if _, err := parseProjectRuntimeFileMode(input); err == nil {
+			t.Fatalf("parseProjectRuntimeFileMode(%q) expected error", input)
+		}
+	}
+}
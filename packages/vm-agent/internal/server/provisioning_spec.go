@@ -0,0 +1,53 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/workspace/vm-agent/internal/provisionspec"
+)
+
+const maxProvisioningSpecBytes = 1 << 20
+
+// handleProvisioningSpecSchema serves the JSON Schema for provisioning specs
+// so the control plane and CLI validate against the agent's own contract.
+func (s *Server) handleProvisioningSpecSchema(w http.ResponseWriter, r *http.Request) {
+	if !s.requireNodeManagementAuth(w, r, "") {
+		return
+	}
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(provisionspec.Schema)
+}
+
+// handleValidateProvisioningSpec validates a JSON or YAML spec document sent
+// as the raw request body without provisioning anything.
+func (s *Server) handleValidateProvisioningSpec(w http.ResponseWriter, r *http.Request) {
+	if !s.requireNodeManagementAuth(w, r, "") {
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxProvisioningSpecBytes+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "failed to read request body")
+		return
+	}
+	if len(data) > maxProvisioningSpecBytes {
+		writeError(w, http.StatusRequestEntityTooLarge, "provisioning spec too large")
+		return
+	}
+
+	if _, err := provisionspec.Parse(data); err != nil {
+		problems := []string{err.Error()}
+		var verr *provisionspec.ValidationError
+		if errors.As(err, &verr) {
+			problems = verr.Problems
+		}
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"valid":    false,
+			"problems": problems,
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"valid": true})
+}
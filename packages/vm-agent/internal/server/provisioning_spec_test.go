@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/workspace/vm-agent/internal/bootlog"
+	"github.com/workspace/vm-agent/internal/bootstrap"
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+const testProvisionSpecYAML = `version: 1
+repositories:
+  - repository: octo/app
+    branch: release
+resources:
+  profile: lightweight
+agent:
+  type: claude-code
+  model: sonnet
+`
+
+func TestCreateWorkspaceFromProvisionSpec(t *testing.T) {
+	originalPrepare := prepareWorkspaceForRuntime
+	defer func() { prepareWorkspaceForRuntime = originalPrepare }()
+
+	states := make(chan bootstrap.ProvisionState, 1)
+	prepareWorkspaceForRuntime = func(_ context.Context, _ *config.Config, state bootstrap.ProvisionState, _ *bootlog.Reporter) (bool, error) {
+		states <- state
+		return false, nil
+	}
+
+	controlPlane := newWorkspaceCreateControlPlane(t)
+	validator, privateKey := newWorkspaceCreateJWTValidator(t, "node-1")
+	s := newWorkspaceCreateServer(t, controlPlane.URL, validator)
+	token := signWorkspaceCreateNodeToken(t, privateKey, "node-1", "ws-spec")
+
+	post := func(spec string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]interface{}{
+			"workspaceId":   "ws-spec",
+			"repository":    "owner/ignored",
+			"callbackToken": "callback-token",
+			"spec":          spec,
+		})
+		req := httptest.NewRequest(http.MethodPost, "/workspaces", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("X-SAM-Node-Id", "node-1")
+		req.Header.Set("X-SAM-Workspace-Id", "ws-spec")
+		rec := httptest.NewRecorder()
+		s.handleCreateWorkspace(rec, req)
+		return rec
+	}
+
+	if rec := post("version: 2\n"); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "version must be 1") {
+		t.Fatalf("invalid spec = %d %s, want 400", rec.Code, rec.Body.String())
+	}
+
+	if rec := post(testProvisionSpecYAML); rec.Code != http.StatusAccepted {
+		t.Fatalf("create = %d %s, want 202", rec.Code, rec.Body.String())
+	}
+	state := <-states
+	if state.Spec == nil || state.Spec.Agent.Type != "claude-code" || !state.Lightweight {
+		t.Fatalf("provision state did not carry the spec: %+v", state)
+	}
+	waitForProvisioningInactive(t, s, "ws-spec")
+
+	runtime, ok := s.getWorkspaceRuntime("ws-spec")
+	if !ok || runtime.Repository != "octo/app" || runtime.Branch != "release" || !runtime.Lightweight {
+		t.Fatalf("runtime metadata not taken from spec: %+v", runtime)
+	}
+}
+
+func TestValidateProvisioningSpecEndpoint(t *testing.T) {
+	validator, privateKey := newWorkspaceCreateJWTValidator(t, "node-1")
+	s := newWorkspaceCreateServer(t, "http://control-plane.invalid", validator)
+	token := signWorkspaceCreateNodeToken(t, privateKey, "node-1", "")
+	mux := http.NewServeMux()
+	s.setupRoutes(mux)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("X-SAM-Node-Id", "node-1")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodGet, "/provisioning-spec/schema", ""); rec.Code != http.StatusOK || !json.Valid(rec.Body.Bytes()) {
+		t.Fatalf("schema = %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodPost, "/provisioning-spec/validate", testProvisionSpecYAML); rec.Code != http.StatusOK {
+		t.Fatalf("valid spec = %d %s", rec.Code, rec.Body.String())
+	}
+	rec := do(http.MethodPost, "/provisioning-spec/validate", `{"version":1,"env":[{"key":"1X","value":""}]}`)
+	var body struct {
+		Valid    bool     `json:"valid"`
+		Problems []string `json:"problems"`
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &body)
+	if rec.Code != http.StatusUnprocessableEntity || body.Valid || len(body.Problems) != 1 {
+		t.Fatalf("invalid spec = %d %s", rec.Code, rec.Body.String())
+	}
+}
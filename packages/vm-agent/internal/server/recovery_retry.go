@@ -0,0 +1,215 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/acp"
+	"github.com/workspace/vm-agent/internal/agentsessions"
+	"github.com/workspace/vm-agent/internal/bootstrap"
+)
+
+var probeDevcontainerBuild = bootstrap.ProbeDevcontainerBuild
+
+// recoveryRetryErrorLimit caps the build output kept in node events; the
+// end of the output is where the failure is.
+const recoveryRetryErrorLimit = 2000
+
+// recoveryRetryState tracks the devcontainer build retries of one workspace
+// in recovery mode. Guarded by Server.recoveryRetryMu.
+type recoveryRetryState struct {
+	attempts  int
+	timer     *time.Timer // pending attempt, nil while none is scheduled
+	exhausted bool
+}
+
+// scheduleRecoveryRetry schedules the next attempt to move a workspace off the
+// fallback image onto its own devcontainer. Attempts back off exponentially
+// from RECOVERY_RETRY_INITIAL_DELAY up to RECOVERY_RETRY_MAX_DELAY and stop
+// after RECOVERY_RETRY_MAX_ATTEMPTS. No-op while an attempt is pending.
+func (s *Server) scheduleRecoveryRetry(workspaceID string) {
+	maxAttempts := s.config.RecoveryRetryMaxAttempts
+	if maxAttempts <= 0 || workspaceID == "" {
+		return
+	}
+
+	s.recoveryRetryMu.Lock()
+	if s.recoveryRetries == nil {
+		s.recoveryRetries = make(map[string]*recoveryRetryState)
+	}
+	state := s.recoveryRetries[workspaceID]
+	if state == nil {
+		state = &recoveryRetryState{}
+		s.recoveryRetries[workspaceID] = state
+	}
+	if state.timer != nil {
+		s.recoveryRetryMu.Unlock()
+		return
+	}
+	if state.attempts >= maxAttempts {
+		alreadyReported := state.exhausted
+		state.exhausted = true
+		s.recoveryRetryMu.Unlock()
+		if !alreadyReported {
+			s.appendNodeEvent(workspaceID, "warn", "workspace.recovery_retry_exhausted", "Gave up rebuilding the devcontainer; workspace stays on the fallback image", map[string]interface{}{
+				"attempts": state.attempts,
+			})
+		}
+		return
+	}
+	delay := recoveryRetryDelay(state.attempts, s.config.RecoveryRetryInitialDelay, s.config.RecoveryRetryMaxDelay)
+	state.timer = time.AfterFunc(delay, func() { s.runRecoveryRetry(workspaceID) })
+	attempt := state.attempts + 1
+	s.recoveryRetryMu.Unlock()
+
+	slog.Info("Scheduled devcontainer rebuild for recovery-mode workspace", "workspace", workspaceID, "attempt", attempt, "maxAttempts", maxAttempts, "delay", delay)
+}
+
+// clearRecoveryRetry cancels a pending attempt and forgets the attempt count,
+// once the workspace has left recovery mode.
+func (s *Server) clearRecoveryRetry(workspaceID string) {
+	s.recoveryRetryMu.Lock()
+	defer s.recoveryRetryMu.Unlock()
+	if state := s.recoveryRetries[workspaceID]; state != nil && state.timer != nil {
+		state.timer.Stop()
+	}
+	delete(s.recoveryRetries, workspaceID)
+}
+
+// recoveryRetryDelay returns the wait before attempt number attempts+1.
+func recoveryRetryDelay(attempts int, initial, max time.Duration) time.Duration {
+	delay := initial
+	for i := 0; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// runRecoveryRetry is one retry attempt. The repo's devcontainer is built
+// first while the fallback container keeps serving; only when that succeeds
+// are the agent sessions moved and the container replaced. A failed build
+// schedules the next attempt.
+func (s *Server) runRecoveryRetry(workspaceID string) {
+	s.recoveryRetryMu.Lock()
+	state := s.recoveryRetries[workspaceID]
+	if state == nil {
+		s.recoveryRetryMu.Unlock()
+		return
+	}
+	state.timer = nil
+	state.attempts++
+	attempt := state.attempts
+	s.recoveryRetryMu.Unlock()
+
+	runtime, ok := s.getWorkspaceRuntime(workspaceID)
+	if !ok {
+		s.clearRecoveryRetry(workspaceID)
+		return
+	}
+	snapshot := s.snapshotWorkspaceRuntime(runtime)
+	if snapshot.Status != "recovery" || s.drainStatus() != nil {
+		s.clearRecoveryRetry(workspaceID)
+		return
+	}
+
+	ctx := context.Background()
+	cancel := func() {}
+	if s.config.BootstrapTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.config.BootstrapTimeout)
+	}
+	cfg := s.workspaceBootstrapConfig(&snapshot, s.callbackTokenForWorkspace(workspaceID))
+	err := probeDevcontainerBuild(ctx, &cfg, snapshot.DevcontainerConfigName, snapshot.DevcontainerPolicy)
+	cancel()
+	if err != nil {
+		message := err.Error()
+		if len(message) > recoveryRetryErrorLimit {
+			message = "…" + message[len(message)-recoveryRetryErrorLimit:]
+		}
+		slog.Warn("Recovery retry: devcontainer build still failing", "workspace", workspaceID, "attempt", attempt, "error", err)
+		s.appendNodeEvent(workspaceID, "warn", "workspace.recovery_retry_failed", "Devcontainer build retry failed", map[string]interface{}{
+			"attempt":     attempt,
+			"maxAttempts": s.config.RecoveryRetryMaxAttempts,
+			"error":       message,
+		})
+		s.scheduleRecoveryRetry(workspaceID)
+		return
+	}
+
+	if !s.casWorkspaceStatus(workspaceID, []string{"recovery"}, "creating") {
+		s.clearRecoveryRetry(workspaceID)
+		return
+	}
+	s.appendNodeEvent(workspaceID, "info", "workspace.recovery_retrying", "Devcontainer builds again; replacing the fallback container", map[string]interface{}{
+		"attempt": attempt,
+	})
+
+	migrated := s.suspendSessionsForMigration(workspaceID)
+	s.bootLogBroadcasters.Remove(workspaceID)
+	provisionRuntime := s.snapshotWorkspaceRuntime(runtime)
+	provisionRuntime.RebuildContainer = true
+	status := s.runWorkspaceProvision(
+		runtime,
+		provisionRuntime,
+		"workspace.recovery_rebuild_failed",
+		"Devcontainer rebuild after recovery failed",
+		"workspace.recovered",
+		"Workspace moved from the fallback image to its devcontainer",
+		map[string]interface{}{"attempt": attempt, "migratedSessions": migrated},
+	)
+	s.resumeMigratedSessions(workspaceID, migrated)
+	if status == "running" {
+		s.clearRecoveryRetry(workspaceID)
+	}
+}
+
+// suspendSessionsForMigration suspends every agent session of the workspace
+// that has a live SessionHost, so its agent process is not killed with the
+// fallback container. Viewers are told why they are disconnected. Returns the
+// suspended session IDs for resumeMigratedSessions.
+func (s *Server) suspendSessionsForMigration(workspaceID string) []string {
+	notice, _ := json.Marshal(acp.WorkspaceMigratingMessage{Type: acp.MsgWorkspaceMigrating, Reason: "recovered"})
+	migrated := []string{}
+	for _, session := range s.agentSessions.List(workspaceID) {
+		if session.Status != agentsessions.StatusRunning {
+			continue
+		}
+		s.sessionHostMu.Lock()
+		host := s.sessionHosts[workspaceID+":"+session.ID]
+		s.sessionHostMu.Unlock()
+		if host == nil {
+			// Started lazily on the next viewer connection, which will
+			// already land in the new container.
+			continue
+		}
+		host.BroadcastTransient(notice)
+
+		acpSessionID, agentType := s.suspendSessionHost(workspaceID, session.ID)
+		suspended, err := s.agentSessions.Suspend(workspaceID, session.ID)
+		if err != nil {
+			slog.Warn("Recovery retry: failed to suspend session for migration", "workspace", workspaceID, "session", session.ID, "error", err)
+			continue
+		}
+		if acpSessionID != "" && suspended.AcpSessionID == "" {
+			_ = s.agentSessions.UpdateAcpSessionID(workspaceID, session.ID, acpSessionID, agentType)
+		}
+		migrated = append(migrated, session.ID)
+	}
+	return migrated
+}
+
+// resumeMigratedSessions marks the sessions suspended by
+// suspendSessionsForMigration running again. Their SessionHosts are recreated
+// when a viewer reconnects, loading the preserved ACP session in the new
+// container.
+func (s *Server) resumeMigratedSessions(workspaceID string, sessionIDs []string) {
+	for _, sessionID := range sessionIDs {
+		if _, err := s.agentSessions.Resume(workspaceID, sessionID); err != nil {
+			slog.Warn("Recovery retry: failed to resume migrated session", "workspace", workspaceID, "session", sessionID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/acp"
+	"github.com/workspace/vm-agent/internal/agentsessions"
+	"github.com/workspace/vm-agent/internal/bootlog"
+	"github.com/workspace/vm-agent/internal/bootstrap"
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+func TestRecoveryRetryDelay(t *testing.T) {
+	t.Parallel()
+	for attempts, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute} {
+		if got := recoveryRetryDelay(attempts, time.Minute, 5*time.Minute); got != want {
+			t.Errorf("recoveryRetryDelay(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
+
+// newRecoveryRetryTestServer returns a server with one workspace in recovery
+// mode and stubs for the build probe and provisioning. probeFailures builds
+// fail before one succeeds.
+func newRecoveryRetryTestServer(t *testing.T, maxAttempts int, probeFailures int32) (*Server, *atomic.Int32, chan bootstrap.ProvisionState) {
+	t.Helper()
+	originalProbe, originalPrepare := probeDevcontainerBuild, prepareWorkspaceForRuntime
+	t.Cleanup(func() { probeDevcontainerBuild, prepareWorkspaceForRuntime = originalProbe, originalPrepare })
+
+	probes := &atomic.Int32{}
+	probeDevcontainerBuild = func(context.Context, *config.Config, string, *bootstrap.DevcontainerPolicy) error {
+		if probes.Add(1) <= probeFailures {
+			return errors.New("ghcr.io: 503 Service Unavailable")
+		}
+		return nil
+	}
+	states := make(chan bootstrap.ProvisionState, 1)
+	prepareWorkspaceForRuntime = func(_ context.Context, _ *config.Config, state bootstrap.ProvisionState, _ *bootlog.Reporter) (bool, error) {
+		states <- state
+		return false, nil
+	}
+
+	controlPlane := newWorkspaceCreateControlPlane(t)
+	validator, _ := newWorkspaceCreateJWTValidator(t, "node-1")
+	s := newWorkspaceCreateServer(t, controlPlane.URL, validator)
+	s.config.ContainerMode = true
+	s.config.RecoveryRetryMaxAttempts = maxAttempts
+	s.config.RecoveryRetryInitialDelay = 5 * time.Millisecond
+	s.config.RecoveryRetryMaxDelay = 10 * time.Millisecond
+	s.workspaces.Put("ws-1", &WorkspaceRuntime{
+		ID:            "ws-1",
+		Repository:    "owner/repo",
+		Status:        "recovery",
+		CallbackToken: "callback-token",
+	})
+	return s, probes, states
+}
+
+func waitForWorkspaceEvent(t *testing.T, s *Server, eventType string) EventRecord {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s.eventMu.RLock()
+		for _, event := range s.workspaceEvents["ws-1"] {
+			if event.Type == eventType {
+				s.eventMu.RUnlock()
+				return event
+			}
+		}
+		s.eventMu.RUnlock()
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s event", eventType)
+	return EventRecord{}
+}
+
+func TestRecoveryRetryMovesWorkspaceToRebuiltDevcontainer(t *testing.T) {
+	s, probes, states := newRecoveryRetryTestServer(t, 3, 1)
+
+	if _, _, err := s.agentSessions.Create("ws-1", "chat-1", "Chat", ""); err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	host := acp.NewSessionHost(acp.SessionHostConfig{
+		GatewayConfig: acp.GatewayConfig{SessionID: "chat-1", WorkspaceID: "ws-1"},
+	})
+	t.Cleanup(host.Stop)
+	s.sessionHosts["ws-1:chat-1"] = host
+
+	s.scheduleRecoveryRetry("ws-1")
+
+	waitForWorkspaceEvent(t, s, "workspace.recovery_retry_failed")
+	recovered := waitForWorkspaceEvent(t, s, "workspace.recovered")
+	if recovered.Detail["attempt"] != 2 {
+		t.Fatalf("recovered on attempt %v, want 2", recovered.Detail["attempt"])
+	}
+	if got := probes.Load(); got != 2 {
+		t.Fatalf("build probes = %d, want 2", got)
+	}
+	select {
+	case state := <-states:
+		if !state.RebuildContainer {
+			t.Fatal("recovery rebuild did not tear down the fallback container")
+		}
+	default:
+		t.Fatal("workspace was not re-provisioned")
+	}
+
+	runtime, _ := s.getWorkspaceRuntime("ws-1")
+	if snapshot := s.snapshotWorkspaceRuntime(runtime); snapshot.Status != "running" {
+		t.Fatalf("workspace status = %s, want running", snapshot.Status)
+	}
+	// The old SessionHost is gone with the fallback container; the session
+	// itself stays running and reattaches on the next viewer connection.
+	s.sessionHostMu.Lock()
+	_, stillHosted := s.sessionHosts["ws-1:chat-1"]
+	s.sessionHostMu.Unlock()
+	if stillHosted {
+		t.Fatal("SessionHost from the fallback container was kept")
+	}
+	if session, _ := s.agentSessions.Get("ws-1", "chat-1"); session.Status != agentsessions.StatusRunning {
+		t.Fatalf("session status = %s, want running after migration", session.Status)
+	}
+
+	s.recoveryRetryMu.Lock()
+	_, tracked := s.recoveryRetries["ws-1"]
+	s.recoveryRetryMu.Unlock()
+	if tracked {
+		t.Fatal("retry state kept after the workspace recovered")
+	}
+}
+
+func TestRecoveryRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	s, probes, states := newRecoveryRetryTestServer(t, 2, 100)
+
+	s.scheduleRecoveryRetry("ws-1")
+
+	exhausted := waitForWorkspaceEvent(t, s, "workspace.recovery_retry_exhausted")
+	if exhausted.Detail["attempts"] != 2 || probes.Load() != 2 {
+		t.Fatalf("exhausted after %v attempts and %d probes, want 2", exhausted.Detail["attempts"], probes.Load())
+	}
+	select {
+	case <-states:
+		t.Fatal("workspace re-provisioned although every build failed")
+	default:
+	}
+	runtime, _ := s.getWorkspaceRuntime("ws-1")
+	if snapshot := s.snapshotWorkspaceRuntime(runtime); snapshot.Status != "recovery" {
+		t.Fatalf("workspace status = %s, want recovery", snapshot.Status)
+	}
+}
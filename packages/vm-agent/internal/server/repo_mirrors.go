@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/bootstrap"
+	"github.com/workspace/vm-agent/internal/config"
+	"github.com/workspace/vm-agent/internal/gitrepo"
+	"github.com/workspace/vm-agent/internal/repocache"
+)
+
+// repoMirrorMaintenanceTimeout bounds one refresh-and-evict pass so a hung
+// fetch cannot stall later passes.
+const repoMirrorMaintenanceTimeout = 30 * time.Minute
+
+// newRepoMirrorCache opens the node's repository mirror cache. Only
+// multi-workspace nodes clone repeatedly, so standalone and deployment
+// agents run without one. Returns nil when disabled or unavailable.
+func newRepoMirrorCache(cfg *config.Config) *repocache.Cache {
+	if !cfg.RepoMirrorCacheEnabled || cfg.IsStandaloneMode() || cfg.IsDeploymentMode() {
+		return nil
+	}
+	cache, err := repocache.New(repocache.Config{
+		Dir:           cfg.RepoMirrorCacheDir,
+		MaxBytes:      cfg.RepoMirrorCacheMaxBytes,
+		FetchInterval: cfg.RepoMirrorFetchInterval,
+	})
+	if err != nil {
+		slog.Error("Failed to open repository mirror cache; workspaces will clone from origin", "error", err)
+		return nil
+	}
+	return cache
+}
+
+// startRepoMirrorMaintenance periodically refreshes stale mirrors and evicts
+// least recently used mirrors once the cache exceeds its size limit.
+func (s *Server) startRepoMirrorMaintenance() {
+	interval := s.config.RepoMirrorMaintenanceInterval
+	if s.repoMirrors == nil || interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				s.maintainRepoMirrors()
+			}
+		}
+	}()
+}
+
+func (s *Server) maintainRepoMirrors() {
+	ctx, cancel := context.WithTimeout(context.Background(), repoMirrorMaintenanceTimeout)
+	defer cancel()
+
+	s.repoMirrors.Refresh(ctx, s.repoMirrorCloneURL)
+	evicted, err := s.repoMirrors.Evict()
+	if err != nil {
+		slog.Warn("repocache: eviction failed", "error", err)
+		return
+	}
+	for _, m := range evicted {
+		s.appendNodeEvent("", "info", "repo_mirror.evicted", "Repository mirror evicted from cache", map[string]interface{}{
+			"repository": m.URL,
+			"bytes":      m.Bytes,
+		})
+	}
+}
+
+// repoMirrorCloneURL returns an authenticated URL for refreshing the mirror
+// of repoURL, borrowing the git token of a workspace on this node that uses
+// the repository. Without such a workspace the bare URL is returned, which
+// still refreshes public repositories; private mirrors are then refreshed
+// the next time a workspace clones them.
+func (s *Server) repoMirrorCloneURL(ctx context.Context, repoURL string) string {
+	var candidates []WorkspaceRuntime
+	s.workspaces.Range(func(_ string, runtime *WorkspaceRuntime) {
+		source := firstNonEmpty(runtime.CloneURL, runtime.Repository)
+		if source != "" && gitrepo.NormalizeURL(source) == repoURL {
+			candidates = append(candidates, WorkspaceRuntime{
+				ID:             runtime.ID,
+				RepoProvider:   runtime.RepoProvider,
+				RepositoryHost: runtime.RepositoryHost,
+			})
+		}
+	})
+
+	for _, runtime := range candidates {
+		callbackToken := s.callbackTokenForWorkspace(runtime.ID)
+		if callbackToken == "" {
+			continue
+		}
+		token, err := s.fetchGitTokenForWorkspace(ctx, runtime.ID, callbackToken, gitTokenPurposeClone)
+		if err != nil || token == "" {
+			slog.Debug("repocache: no git token for mirror refresh", "workspace", runtime.ID, "error", err)
+			continue
+		}
+		cfg := *s.config
+		cfg.RepoProvider = strings.TrimSpace(runtime.RepoProvider)
+		cfg.RepositoryHost = strings.TrimSpace(runtime.RepositoryHost)
+		cloneURL, err := bootstrap.AuthenticatedCloneURL(repoURL, token, &cfg)
+		if err == nil {
+			return cloneURL
+		}
+	}
+	return repoURL
+}
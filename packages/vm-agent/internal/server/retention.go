@@ -0,0 +1,513 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/messagereport"
+	"github.com/workspace/vm-agent/internal/retention"
+)
+
+const (
+	maxRetentionPolicyBytes       = 64 << 10
+	retentionReceiptShipBatchSize = 100
+)
+
+// newRetentionPurger wires each retention category to the local data it governs.
+func (s *Server) newRetentionPurger() *retention.Purger {
+	sources := map[string]retention.Source{
+		retention.CategoryTranscripts: transcriptRetentionSource{s: s},
+		retention.CategoryEvents:      eventRetentionSource{s: s},
+		retention.CategoryArtifacts:   artifactRetentionSource{s: s},
+		retention.CategoryBootLogs:    bootLogRetentionSource{s: s},
+	}
+	if s.accessAudit != nil || s.execAudit != nil {
+		sources[retention.CategoryAuditLogs] = auditLogRetentionSource{s: s}
+	}
+	return retention.NewPurger(s.retentionStore, sources)
+}
+
+// transcriptRetentionSource covers chat messages held in a workspace's
+// message reporter outbox.
+type transcriptRetentionSource struct{ s *Server }
+
+func (t transcriptRetentionSource) RetentionItems(workspaceID string) ([]retention.Item, error) {
+	var items []retention.Item
+	err := t.withOutbox(workspaceID, func(db *sql.DB) error {
+		var err error
+		items, err = messagereport.OutboxRetentionItems(db)
+		return err
+	})
+	return items, err
+}
+
+func (t transcriptRetentionSource) DeleteRetentionItems(workspaceID string, ids []string) (int64, error) {
+	var deleted int64
+	err := t.withOutbox(workspaceID, func(db *sql.DB) error {
+		var err error
+		deleted, err = messagereport.DeleteOutboxRetentionItems(db, ids)
+		return err
+	})
+	return deleted, err
+}
+
+// withOutbox opens the workspace's outbox database when it exists. A
+// workspace that never reported messages has nothing to purge.
+func (t transcriptRetentionSource) withOutbox(workspaceID string, fn func(*sql.DB) error) error {
+	dbPath := messageReporterDBPath(t.s.config.PersistenceDBPath, workspaceID)
+	if _, err := os.Stat(dbPath); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	db, err := openSQLiteDB(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return fn(db)
+}
+
+// eventRetentionSource covers workspace events, both persisted and the
+// in-memory copies served by the events API.
+type eventRetentionSource struct{ s *Server }
+
+func (e eventRetentionSource) RetentionItems(workspaceID string) ([]retention.Item, error) {
+	if e.s.eventStore != nil {
+		return e.s.eventStore.RetentionItems(workspaceID)
+	}
+	e.s.eventMu.RLock()
+	defer e.s.eventMu.RUnlock()
+	events := e.s.workspaceEvents[workspaceID]
+	items := make([]retention.Item, len(events))
+	for i, event := range events {
+		detail, _ := json.Marshal(event.Detail)
+		items[i] = retention.Item{
+			ID:        event.ID,
+			Bytes:     int64(len(event.ID) + len(event.Type) + len(event.Message) + len(detail)),
+			CreatedAt: retention.ParseTimestamp(event.CreatedAt),
+		}
+	}
+	return items, nil
+}
+
+func (e eventRetentionSource) DeleteRetentionItems(workspaceID string, ids []string) (int64, error) {
+	drop := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		drop[id] = struct{}{}
+	}
+	keep := func(events []EventRecord) ([]EventRecord, int64) {
+		kept := make([]EventRecord, 0, len(events))
+		var removed int64
+		for _, event := range events {
+			if _, ok := drop[event.ID]; ok && event.WorkspaceID == workspaceID {
+				removed++
+				continue
+			}
+			kept = append(kept, event)
+		}
+		return kept, removed
+	}
+
+	e.s.eventMu.Lock()
+	var removed int64
+	if events, ok := e.s.workspaceEvents[workspaceID]; ok {
+		e.s.workspaceEvents[workspaceID], removed = keep(events)
+	}
+	e.s.nodeEvents, _ = keep(e.s.nodeEvents)
+	e.s.eventMu.Unlock()
+
+	if e.s.eventStore != nil {
+		return e.s.eventStore.DeleteRetentionItems(workspaceID, ids)
+	}
+	return removed, nil
+}
+
+// artifactRetentionSource covers the records of completed build-and-publish
+// jobs run for a workspace.
+type artifactRetentionSource struct{ s *Server }
+
+func (a artifactRetentionSource) RetentionItems(workspaceID string) ([]retention.Item, error) {
+	if a.s.store == nil {
+		return nil, nil
+	}
+	return a.s.store.CompletedJobRetentionItems(vmJobKindPublish, workspaceID)
+}
+
+func (a artifactRetentionSource) DeleteRetentionItems(workspaceID string, ids []string) (int64, error) {
+	if a.s.store == nil {
+		return 0, nil
+	}
+	return a.s.store.DeleteCompletedJobs(vmJobKindPublish, workspaceID, ids)
+}
+
+// bootLogRetentionSource covers the boot log entries buffered for replay to
+// late-joining boot log viewers.
+type bootLogRetentionSource struct{ s *Server }
+
+func (b bootLogRetentionSource) RetentionItems(workspaceID string) ([]retention.Item, error) {
+	broadcaster := b.s.bootLogBroadcasters.Get(workspaceID)
+	if broadcaster == nil {
+		return nil, nil
+	}
+	return broadcaster.retentionItems(), nil
+}
+
+func (b bootLogRetentionSource) DeleteRetentionItems(workspaceID string, ids []string) (int64, error) {
+	broadcaster := b.s.bootLogBroadcasters.Get(workspaceID)
+	if broadcaster == nil {
+		return 0, nil
+	}
+	return broadcaster.deleteEntries(ids), nil
+}
+
+// auditLogRetentionSource covers viewer access records and privileged exec
+// records. Exec record IDs carry an "exec-" prefix, which routes deletions
+// to the right store.
+type auditLogRetentionSource struct{ s *Server }
+
+func (a auditLogRetentionSource) RetentionItems(workspaceID string) ([]retention.Item, error) {
+	var items []retention.Item
+	if a.s.accessAudit != nil {
+		accessItems, err := a.s.accessAudit.RetentionItems(workspaceID)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, accessItems...)
+	}
+	if a.s.execAudit != nil {
+		execItems, err := a.s.execAudit.RetentionItems(workspaceID)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, execItems...)
+	}
+	return items, nil
+}
+
+func (a auditLogRetentionSource) DeleteRetentionItems(workspaceID string, ids []string) (int64, error) {
+	var accessIDs, execIDs []string
+	for _, id := range ids {
+		if strings.HasPrefix(id, "exec-") {
+			execIDs = append(execIDs, id)
+		} else {
+			accessIDs = append(accessIDs, id)
+		}
+	}
+	var deleted int64
+	if a.s.accessAudit != nil && len(accessIDs) > 0 {
+		n, err := a.s.accessAudit.DeleteRetentionItems(workspaceID, accessIDs)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+	if a.s.execAudit != nil && len(execIDs) > 0 {
+		n, err := a.s.execAudit.DeleteRetentionItems(workspaceID, execIDs)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// handleGetRetentionPolicy returns the workspace's retention policy.
+func (s *Server) handleGetRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.PathValue("workspaceId")
+	if workspaceID == "" {
+		writeError(w, http.StatusBadRequest, workspaceIDRequiredMessage)
+		return
+	}
+	if !s.checkWorkspaceRequestAuth(r, workspaceID) {
+		if !s.requireNodeManagementAuth(w, r, workspaceID) {
+			return
+		}
+	}
+	if s.retentionStore == nil {
+		writeError(w, http.StatusServiceUnavailable, "retention unavailable")
+		return
+	}
+
+	policy, err := s.retentionStore.Policy(workspaceID)
+	if errors.Is(err, retention.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "no retention policy for workspace")
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to read retention policy", "workspace", workspaceID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to read retention policy")
+		return
+	}
+	writeJSON(w, http.StatusOK, policy)
+}
+
+// handlePutRetentionPolicy stores the retention policy pushed by the control
+// plane. The body is {"rules": {"<category>": {"maxAgeSeconds": n, "maxBytes": n}}}.
+// The policy takes effect on the next scheduled purge.
+func (s *Server) handlePutRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.PathValue("workspaceId")
+	if workspaceID == "" {
+		writeError(w, http.StatusBadRequest, workspaceIDRequiredMessage)
+		return
+	}
+	if !s.requireNodeManagementAuth(w, r, workspaceID) {
+		return
+	}
+	if s.retentionStore == nil {
+		writeError(w, http.StatusServiceUnavailable, "retention unavailable")
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxRetentionPolicyBytes+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "failed to read request body")
+		return
+	}
+	if len(data) > maxRetentionPolicyBytes {
+		writeError(w, http.StatusRequestEntityTooLarge, "retention policy too large")
+		return
+	}
+	var body struct {
+		Rules map[string]retention.Rule `json:"rules"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	policy := retention.Policy{WorkspaceID: workspaceID, Rules: body.Rules}
+	if err := policy.Validate(); err != nil {
+		var verr *retention.ValidationError
+		if errors.As(err, &verr) {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+				"error":    "invalid retention policy",
+				"problems": verr.Problems,
+			})
+			return
+		}
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	policy, err = s.retentionStore.SetPolicy(policy)
+	if err != nil {
+		slog.Error("Failed to store retention policy", "workspace", workspaceID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to store retention policy")
+		return
+	}
+	s.appendNodeEvent(workspaceID, "info", "retention.policy_updated", "Retention policy updated", map[string]interface{}{
+		"rules": policy.Rules,
+	})
+	writeJSON(w, http.StatusOK, policy)
+}
+
+// handleDeleteRetentionPolicy removes the workspace's retention policy.
+// Existing deletion receipts are kept.
+func (s *Server) handleDeleteRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.PathValue("workspaceId")
+	if workspaceID == "" {
+		writeError(w, http.StatusBadRequest, workspaceIDRequiredMessage)
+		return
+	}
+	if !s.requireNodeManagementAuth(w, r, workspaceID) {
+		return
+	}
+	if s.retentionStore == nil {
+		writeError(w, http.StatusServiceUnavailable, "retention unavailable")
+		return
+	}
+	if err := s.retentionStore.DeletePolicy(workspaceID); err != nil {
+		slog.Error("Failed to delete retention policy", "workspace", workspaceID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to delete retention policy")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRunRetention applies the workspace's retention policy immediately.
+// With ?dryRun=true it reports what would be deleted without deleting.
+func (s *Server) handleRunRetention(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.PathValue("workspaceId")
+	if workspaceID == "" {
+		writeError(w, http.StatusBadRequest, workspaceIDRequiredMessage)
+		return
+	}
+	if !s.requireNodeManagementAuth(w, r, workspaceID) {
+		return
+	}
+	if s.retentionPurger == nil {
+		writeError(w, http.StatusServiceUnavailable, "retention unavailable")
+		return
+	}
+	dryRun := false
+	if raw := r.URL.Query().Get("dryRun"); raw != "" {
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "dryRun must be a boolean")
+			return
+		}
+		dryRun = parsed
+	}
+
+	report, err := s.retentionPurger.Run(workspaceID, dryRun)
+	if errors.Is(err, retention.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "no retention policy for workspace")
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to apply retention policy", "workspace", workspaceID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to apply retention policy")
+		return
+	}
+	if !dryRun {
+		s.recordRetentionReport(report)
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// handleListRetentionReceipts returns the workspace's deletion receipts,
+// newest first.
+func (s *Server) handleListRetentionReceipts(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.PathValue("workspaceId")
+	if workspaceID == "" {
+		writeError(w, http.StatusBadRequest, workspaceIDRequiredMessage)
+		return
+	}
+	if !s.checkWorkspaceRequestAuth(r, workspaceID) {
+		if !s.requireNodeManagementAuth(w, r, workspaceID) {
+			return
+		}
+	}
+	if s.retentionStore == nil {
+		writeError(w, http.StatusServiceUnavailable, "retention unavailable")
+		return
+	}
+	receipts, err := s.retentionStore.Receipts(workspaceID, parseEventLimit(r.URL.Query().Get("limit")))
+	if err != nil {
+		slog.Error("Failed to query retention receipts", "workspace", workspaceID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to query retention receipts")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"receipts": receipts})
+}
+
+// recordRetentionReport emits a workspace event for every category a purge
+// deleted data from or failed on.
+func (s *Server) recordRetentionReport(report retention.Report) {
+	for _, category := range report.Categories {
+		switch {
+		case category.Error != "":
+			s.appendNodeEvent(report.WorkspaceID, "warn", "retention.purge_failed", "Retention purge failed", map[string]interface{}{
+				"category": category.Category,
+				"error":    category.Error,
+			})
+		case category.Items > 0:
+			s.appendNodeEvent(report.WorkspaceID, "info", "retention.purged", "Retention policy purged data", map[string]interface{}{
+				"category":  category.Category,
+				"items":     category.Items,
+				"bytes":     category.Bytes,
+				"receiptId": category.ReceiptID,
+			})
+		}
+	}
+}
+
+// startRetentionPurger periodically applies every stored retention policy.
+func (s *Server) startRetentionPurger() {
+	interval := s.config.RetentionPurgeInterval
+	if s.retentionPurger == nil || interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				reports, err := s.retentionPurger.RunAll()
+				if err != nil {
+					slog.Warn("retention: scheduled purge failed", "error", err)
+					continue
+				}
+				for _, report := range reports {
+					s.recordRetentionReport(report)
+				}
+			}
+		}
+	}()
+}
+
+// startRetentionReceiptShipper periodically ships deletion receipts to the
+// control plane as compliance evidence.
+func (s *Server) startRetentionReceiptShipper() {
+	interval := s.config.RetentionReceiptShipInterval
+	if s.retentionStore == nil || s.config.ControlPlaneURL == "" || interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				s.shipRetentionReceipts()
+			}
+		}
+	}()
+}
+
+// shipRetentionReceipts sends one batch of unshipped receipts, grouped by
+// workspace, with the same retry and drop rules as access audit shipping.
+func (s *Server) shipRetentionReceipts() {
+	receipts, err := s.retentionStore.Unshipped(retentionReceiptShipBatchSize)
+	if err != nil {
+		slog.Warn("retention: reading unshipped receipts failed", "error", err)
+		return
+	}
+
+	byWorkspace := make(map[string][]retention.Receipt)
+	var order []string
+	for _, receipt := range receipts {
+		if _, ok := byWorkspace[receipt.WorkspaceID]; !ok {
+			order = append(order, receipt.WorkspaceID)
+		}
+		byWorkspace[receipt.WorkspaceID] = append(byWorkspace[receipt.WorkspaceID], receipt)
+	}
+
+	for _, workspaceID := range order {
+		batch := byWorkspace[workspaceID]
+		token := s.callbackTokenForWorkspace(workspaceID)
+		if token == "" {
+			slog.Debug("retention: skipping workspace without callback token", "workspace", workspaceID)
+			continue
+		}
+		permanent, err := s.postWorkspaceRecords(workspaceID, token, "retention-receipts", map[string]interface{}{"receipts": batch})
+		if err != nil {
+			slog.Warn("retention: shipping receipts failed", "workspace", workspaceID, "count", len(batch), "dropped", permanent, "error", err)
+			if !permanent {
+				continue
+			}
+		}
+		ids := make([]string, len(batch))
+		for i, receipt := range batch {
+			ids[i] = receipt.ID
+		}
+		if err := s.retentionStore.MarkShipped(ids); err != nil {
+			slog.Warn("retention: marking receipts shipped failed", "workspace", workspaceID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/auth"
+	"github.com/workspace/vm-agent/internal/config"
+	"github.com/workspace/vm-agent/internal/eventstore"
+	"github.com/workspace/vm-agent/internal/retention"
+)
+
+func TestRetentionPolicyPushDryRunAndPurge(t *testing.T) {
+	validator, privateKey := newWorkspaceCreateJWTValidator(t, "node-1")
+	s := newWorkspaceCreateServer(t, "", validator)
+	s.sessionManager = auth.NewSessionManager("session", false, time.Hour)
+	s.config.PersistenceDBPath = filepath.Join(t.TempDir(), "state.db")
+	evStore, err := eventstore.New(filepath.Join(t.TempDir(), "events.db"))
+	if err != nil {
+		t.Fatalf("eventstore.New: %v", err)
+	}
+	t.Cleanup(func() { _ = evStore.Close() })
+	s.eventStore = evStore
+	s.retentionStore, err = retention.New(filepath.Join(t.TempDir(), "retention.db"), 0)
+	if err != nil {
+		t.Fatalf("retention.New: %v", err)
+	}
+	t.Cleanup(func() { _ = s.retentionStore.Close() })
+	s.retentionPurger = s.newRetentionPurger()
+
+	const workspaceID = "ws-retain"
+	token := signWorkspaceCreateNodeToken(t, privateKey, "node-1", workspaceID)
+	do := func(method, path, body string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.SetPathValue("workspaceId", workspaceID)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("X-SAM-Node-Id", "node-1")
+		req.Header.Set("X-SAM-Workspace-Id", workspaceID)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	old := time.Now().Add(-48 * time.Hour).UTC().Format(time.RFC3339)
+	for _, id := range []string{"evt-old-1", "evt-old-2"} {
+		event := EventRecord{ID: id, WorkspaceID: workspaceID, Type: "test", Message: "old", CreatedAt: old}
+		evStore.Append(eventstore.EventRecord(event))
+		s.workspaceEvents[workspaceID] = append(s.workspaceEvents[workspaceID], event)
+	}
+	s.appendNodeEvent(workspaceID, "info", "test", "recent", nil)
+	s.bootLogBroadcasters.GetOrCreate(workspaceID).Broadcast("clone", "completed", "cloned repository")
+
+	if rec := do(http.MethodPost, "/workspaces/"+workspaceID+"/retention/purge", "", s.handleRunRetention); rec.Code != http.StatusNotFound {
+		t.Fatalf("purge without policy = %d, want 404", rec.Code)
+	}
+	if rec := do(http.MethodPut, "/workspaces/"+workspaceID+"/retention-policy", `{"rules":{"logs":{"maxBytes":-1}}}`, s.handlePutRetentionPolicy); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("invalid policy = %d %s, want 422", rec.Code, rec.Body.String())
+	}
+	rec := do(http.MethodPut, "/workspaces/"+workspaceID+"/retention-policy",
+		`{"rules":{"events":{"maxAgeSeconds":86400},"bootLogs":{"maxBytes":1}}}`, s.handlePutRetentionPolicy)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("put policy = %d %s", rec.Code, rec.Body.String())
+	}
+	rec = do(http.MethodGet, "/workspaces/"+workspaceID+"/retention-policy", "", s.handleGetRetentionPolicy)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"maxAgeSeconds":86400`) {
+		t.Fatalf("get policy = %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = do(http.MethodPost, "/workspaces/"+workspaceID+"/retention/purge?dryRun=true", "", s.handleRunRetention)
+	var dry retention.Report
+	if err := json.Unmarshal(rec.Body.Bytes(), &dry); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("dry run = %d %s", rec.Code, rec.Body.String())
+	}
+	if !dry.DryRun || len(dry.Categories) != 2 || dry.Categories[0].Items != 2 || dry.Categories[1].Items != 1 {
+		t.Fatalf("dry run report = %+v, want 2 events and 1 boot log entry", dry)
+	}
+	// Two old events, the recent one, and the policy_updated event.
+	if items, _ := evStore.RetentionItems(workspaceID); len(items) != 4 {
+		t.Fatalf("dry run deleted events: %d left", len(items))
+	}
+
+	rec = do(http.MethodPost, "/workspaces/"+workspaceID+"/retention/purge", "", s.handleRunRetention)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("purge = %d %s", rec.Code, rec.Body.String())
+	}
+	stored, _ := evStore.ListWorkspace(workspaceID, 0)
+	for _, event := range stored {
+		if strings.HasPrefix(event.ID, "evt-old") {
+			t.Errorf("purged event %s still in event store", event.ID)
+		}
+	}
+	s.eventMu.RLock()
+	for _, event := range s.workspaceEvents[workspaceID] {
+		if strings.HasPrefix(event.ID, "evt-old") {
+			t.Errorf("purged event %s still served from memory", event.ID)
+		}
+	}
+	s.eventMu.RUnlock()
+	if entries := s.bootLogBroadcasters.Get(workspaceID).retentionItems(); len(entries) != 0 {
+		t.Fatalf("boot log entries left = %d, want 0", len(entries))
+	}
+
+	rec = do(http.MethodGet, "/workspaces/"+workspaceID+"/retention/receipts", "", s.handleListRetentionReceipts)
+	var listed struct {
+		Receipts []retention.Receipt `json:"receipts"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil || len(listed.Receipts) != 2 {
+		t.Fatalf("receipts = %d %s, want 2", rec.Code, rec.Body.String())
+	}
+}
+
+func TestShipRetentionReceiptsMarksDelivered(t *testing.T) {
+	var received atomic.Int32
+	controlPlane := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/workspaces/ws-live/retention-receipts":
+			var body struct {
+				Receipts []retention.Receipt `json:"receipts"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			received.Add(int32(len(body.Receipts)))
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer controlPlane.Close()
+
+	store, err := retention.New(filepath.Join(t.TempDir(), "retention.db"), 0)
+	if err != nil {
+		t.Fatalf("retention.New: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	s := &Server{
+		config:         &config.Config{ControlPlaneURL: controlPlane.URL, CallbackToken: "node-token"},
+		retentionStore: store,
+	}
+	for _, ws := range []string{"ws-live", "ws-flaky"} {
+		if _, err := store.AddReceipt(retention.Receipt{WorkspaceID: ws, Category: retention.CategoryEvents, Items: 3}); err != nil {
+			t.Fatalf("AddReceipt: %v", err)
+		}
+	}
+
+	s.shipRetentionReceipts()
+
+	if got := received.Load(); got != 1 {
+		t.Fatalf("control plane received %d receipts, want 1", got)
+	}
+	pending, err := store.Unshipped(10)
+	if err != nil || len(pending) != 1 || pending[0].WorkspaceID != "ws-flaky" {
+		t.Fatalf("pending after ship = %+v, %v; want only the transient failure", pending, err)
+	}
+}
@@ -0,0 +1,294 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/acp"
+	"github.com/workspace/vm-agent/internal/jobs"
+	"github.com/workspace/vm-agent/internal/schedule"
+)
+
+const (
+	// maxScheduleFileBytes bounds .sam/schedule.json and PUT bodies.
+	maxScheduleFileBytes = 256 * 1024
+	// repoScheduleReadTimeout bounds reading .sam/schedule.json from the
+	// devcontainer.
+	repoScheduleReadTimeout = 15 * time.Second
+)
+
+type scheduleResponse struct {
+	Entries []schedule.Entry `json:"entries"`
+	Runs    []schedule.Run   `json:"runs"`
+}
+
+func (s *Server) scheduleResponse(workspaceID string) scheduleResponse {
+	return scheduleResponse{
+		Entries: s.scheduler.Entries(workspaceID),
+		Runs:    s.scheduler.History(workspaceID),
+	}
+}
+
+// startScheduler runs due schedule entries until the server shuts down.
+func (s *Server) startScheduler() {
+	if s.scheduler == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-s.done
+		cancel()
+	}()
+	go s.scheduler.Start(ctx)
+}
+
+// loadRepoSchedule replaces the workspace's repository schedule with the
+// contents of .sam/schedule.json in its devcontainer. A missing file clears
+// it. Called once the workspace is ready.
+func (s *Server) loadRepoSchedule(workspaceID string) error {
+	if s.scheduler == nil || workspaceID == "" {
+		return nil
+	}
+	containerID, workDir, user, err := s.resolveContainerForWorkspace(workspaceID)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), repoScheduleReadTimeout)
+	defer cancel()
+	cmd, err := s.workspaceExecCommand(ctx, containerID, user, workDir, "cat", schedule.RepoFile)
+	if err != nil {
+		return err
+	}
+	// Any failure to read is treated as no repository schedule: cat exits
+	// non-zero when the file does not exist.
+	data, readErr := cmd.Output()
+	var file schedule.File
+	if readErr == nil {
+		if len(data) > maxScheduleFileBytes {
+			err = fmt.Errorf("%s exceeds %d bytes", schedule.RepoFile, maxScheduleFileBytes)
+		} else if err = json.Unmarshal(data, &file); err != nil {
+			err = fmt.Errorf("%s: %w", schedule.RepoFile, err)
+		}
+	}
+	if err == nil {
+		err = s.scheduler.Set(workspaceID, schedule.SourceRepo, file.Entries, time.Now())
+	}
+	if err != nil {
+		slog.Warn("Invalid repository schedule", "workspace", workspaceID, "error", err)
+		s.appendNodeEvent(workspaceID, "warn", "schedule.invalid", "Repository schedule is invalid and was not loaded", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return err
+	}
+	if len(file.Entries) > 0 {
+		s.appendNodeEvent(workspaceID, "info", "schedule.loaded", "Repository schedule loaded", map[string]interface{}{
+			"entries": len(file.Entries),
+		})
+	}
+	return nil
+}
+
+// handleGetSchedule returns the workspace's schedule entries and recent
+// runs, newest first.
+// GET /workspaces/{workspaceId}/schedule
+func (s *Server) handleGetSchedule(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.PathValue("workspaceId")
+	if workspaceID == "" {
+		writeError(w, http.StatusBadRequest, workspaceIDRequiredMessage)
+		return
+	}
+	if !s.requireWorkspaceRequestAuth(w, r, workspaceID) {
+		return
+	}
+	if s.scheduler == nil {
+		writeError(w, http.StatusServiceUnavailable, "scheduler unavailable")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.scheduleResponse(workspaceID))
+}
+
+// handlePutSchedule replaces the workspace's control-plane schedule entries.
+// Repository entries are not affected.
+// PUT /workspaces/{workspaceId}/schedule
+func (s *Server) handlePutSchedule(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.PathValue("workspaceId")
+	if workspaceID == "" {
+		writeError(w, http.StatusBadRequest, workspaceIDRequiredMessage)
+		return
+	}
+	if !s.requireNodeManagementAuth(w, r, workspaceID) {
+		return
+	}
+	if s.scheduler == nil {
+		writeError(w, http.StatusServiceUnavailable, "scheduler unavailable")
+		return
+	}
+	if _, ok := s.getWorkspaceRuntime(workspaceID); !ok {
+		writeError(w, http.StatusNotFound, "workspace not found")
+		return
+	}
+
+	var body schedule.File
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxScheduleFileBytes)).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if err := s.scheduler.Set(workspaceID, schedule.SourceAPI, body.Entries, time.Now()); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.appendNodeEvent(workspaceID, "info", "schedule.updated", "Workspace schedule updated", map[string]interface{}{
+		"entries": len(body.Entries),
+	})
+	writeJSON(w, http.StatusOK, s.scheduleResponse(workspaceID))
+}
+
+// handleReloadSchedule re-reads .sam/schedule.json, e.g. after it was
+// edited in the workspace.
+// POST /workspaces/{workspaceId}/schedule/reload
+func (s *Server) handleReloadSchedule(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.PathValue("workspaceId")
+	if workspaceID == "" {
+		writeError(w, http.StatusBadRequest, workspaceIDRequiredMessage)
+		return
+	}
+	if !s.requireWorkspaceRequestAuth(w, r, workspaceID) {
+		return
+	}
+	if s.scheduler == nil {
+		writeError(w, http.StatusServiceUnavailable, "scheduler unavailable")
+		return
+	}
+	if err := s.loadRepoSchedule(workspaceID); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, s.scheduleResponse(workspaceID))
+}
+
+// reportScheduledRun records a scheduled run's progress as a workspace event.
+func (s *Server) reportScheduledRun(workspaceID string, run schedule.Run) {
+	detail := map[string]interface{}{
+		"entryId": run.EntryID,
+		"source":  string(run.Source),
+	}
+	if run.JobID != "" {
+		detail["jobId"] = run.JobID
+	}
+	if run.SessionID != "" {
+		detail["sessionId"] = run.SessionID
+	}
+	if run.Error != "" {
+		detail["error"] = run.Error
+	}
+	switch run.Status {
+	case schedule.RunRunning:
+		s.appendNodeEvent(workspaceID, "info", "schedule.run_started", "Scheduled run started", detail)
+	case schedule.RunSucceeded:
+		s.appendNodeEvent(workspaceID, "info", "schedule.run_succeeded", "Scheduled run succeeded", detail)
+	case schedule.RunFailed:
+		s.appendNodeEvent(workspaceID, "error", "schedule.run_failed", "Scheduled run failed", detail)
+	case schedule.RunSkipped:
+		s.appendNodeEvent(workspaceID, "warn", "schedule.run_skipped", "Scheduled run skipped", detail)
+	}
+}
+
+// runScheduledEntry runs a due schedule entry: a command as a background
+// job, a prompt as a new agent session.
+func (s *Server) runScheduledEntry(ctx context.Context, workspaceID string, entry schedule.Entry) schedule.Result {
+	if strings.TrimSpace(entry.Command) != "" {
+		return s.runScheduledCommand(ctx, workspaceID, entry)
+	}
+	return s.runScheduledPrompt(workspaceID, entry)
+}
+
+// runScheduledCommand runs the entry's command as a job in the devcontainer
+// and waits for it, killing it after SCHEDULE_RUN_TIMEOUT.
+func (s *Server) runScheduledCommand(ctx context.Context, workspaceID string, entry schedule.Entry) schedule.Result {
+	if s.jobManager == nil {
+		return schedule.Result{Err: errors.New("background jobs unavailable")}
+	}
+	containerID, workDir, user, err := s.resolveContainerForWorkspace(workspaceID)
+	if err != nil {
+		return schedule.Result{Err: err}
+	}
+	job, err := s.jobManager.Start(workspaceID, entry.Command, workDir, func(jobID string) (*exec.Cmd, jobs.KillFunc, error) {
+		return s.jobCommand(jobID, containerID, user, workDir, entry.Command)
+	})
+	if err != nil {
+		return schedule.Result{Err: fmt.Errorf("start job: %w", err)}
+	}
+
+	waitCtx := ctx
+	if timeout := s.config.ScheduleRunTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	job, err = s.jobManager.Wait(waitCtx, workspaceID, job.ID)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			killCtx, killCancel := context.WithTimeout(context.Background(), s.jobKillTimeout())
+			defer killCancel()
+			_, _ = s.jobManager.Kill(killCtx, workspaceID, job.ID)
+			return schedule.Result{JobID: job.ID, Err: fmt.Errorf("timed out after %s", s.config.ScheduleRunTimeout)}
+		}
+		return schedule.Result{JobID: job.ID, Err: err}
+	}
+	switch job.Status {
+	case jobs.StatusSucceeded:
+		return schedule.Result{JobID: job.ID}
+	case jobs.StatusKilled:
+		return schedule.Result{JobID: job.ID, Err: errors.New("job was killed")}
+	default:
+		if job.Error != "" {
+			return schedule.Result{JobID: job.ID, Err: errors.New(job.Error)}
+		}
+		return schedule.Result{JobID: job.ID, Err: fmt.Errorf("exit code %d", *job.ExitCode)}
+	}
+}
+
+// runScheduledPrompt sends the entry's prompt to a new agent session and
+// waits for the agent to finish. The session is suspended afterwards so its
+// transcript stays available without keeping the agent process running.
+func (s *Server) runScheduledPrompt(workspaceID string, entry schedule.Entry) schedule.Result {
+	runtime, ok := s.getWorkspaceRuntime(workspaceID)
+	if !ok {
+		return schedule.Result{Err: errors.New("workspace not found")}
+	}
+	agentType := strings.TrimSpace(entry.AgentType)
+	if agentType == "" && runtime.ProvisionSpec != nil {
+		agentType = runtime.ProvisionSpec.Agent.Type
+	}
+	if agentType == "" {
+		agentType = s.config.ACPWarmStandbyAgent
+	}
+	if agentType == "" {
+		return schedule.Result{Err: schedule.ErrNoAgent}
+	}
+
+	sessionID := fmt.Sprintf("sched-%s-%d", entry.ID, time.Now().Unix())
+	session, _, err := s.agentSessions.Create(workspaceID, sessionID, "Scheduled: "+entry.ID, "")
+	if err != nil {
+		return schedule.Result{Err: err}
+	}
+	s.appendNodeEvent(workspaceID, "info", "agent_session.created", "Agent session created", map[string]interface{}{"sessionId": sessionID})
+
+	host := s.getOrCreateSessionHost(workspaceID+":"+sessionID, workspaceID, sessionID, session, runtime, "", "")
+	s.startAgentWithPrompt(host, workspaceID, sessionID, agentType, entry.Prompt, "")
+	var runErr error
+	if status := host.Status(); status != acp.HostReady {
+		runErr = fmt.Errorf("agent status is %s", status)
+	}
+
+	s.suspendFinishedAgentSession(workspaceID, sessionID)
+	return schedule.Result{SessionID: sessionID, Err: runErr}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/workspace/vm-agent/internal/schedule"
+)
+
+func newScheduleTestServer(t *testing.T) (*Server, string, string, string) {
+	t.Helper()
+	srv, workspaceID, dir, sessionID := newJobsTestServer(t)
+	srv.scheduler = schedule.New(srv.runScheduledEntry, srv.reportScheduledRun, 0, 0)
+	return srv, workspaceID, dir, sessionID
+}
+
+func writeRepoSchedule(t *testing.T, dir, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Join(dir, ".sam"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, schedule.RepoFile), []byte(content), 0o644); err != nil {
+		t.Fatalf("write schedule: %v", err)
+	}
+}
+
+func TestRepoScheduleLoadAndReload(t *testing.T) {
+	srv, workspaceID, dir, sessionID := newScheduleTestServer(t)
+
+	// No schedule file: nothing is loaded and no error is reported.
+	if err := srv.loadRepoSchedule(workspaceID); err != nil {
+		t.Fatalf("load without file: %v", err)
+	}
+
+	writeRepoSchedule(t, dir, `{"entries":[{"id":"deps","schedule":"@nightly","prompt":"Update dependencies","agentType":"claude-code"}]}`)
+	rec := httptest.NewRecorder()
+	srv.handleReloadSchedule(rec, jobsRequest(http.MethodPost, "/workspaces/"+workspaceID+"/schedule/reload", workspaceID, "", sessionID, ""))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reload status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	var resp scheduleResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode schedule: %v", err)
+	}
+	if len(resp.Entries) != 1 || resp.Entries[0].Source != schedule.SourceRepo || resp.Entries[0].NextRunAt == nil {
+		t.Fatalf("entries = %+v", resp.Entries)
+	}
+
+	// An invalid file is rejected and the previous schedule is kept.
+	writeRepoSchedule(t, dir, `{"entries":[{"id":"deps","schedule":"whenever","command":"true"}]}`)
+	rec = httptest.NewRecorder()
+	srv.handleReloadSchedule(rec, jobsRequest(http.MethodPost, "/workspaces/"+workspaceID+"/schedule/reload", workspaceID, "", sessionID, ""))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid reload status = %d, want 400", rec.Code)
+	}
+	if got := srv.scheduler.Entries(workspaceID); len(got) != 1 || got[0].Prompt != "Update dependencies" {
+		t.Fatalf("entries after invalid reload = %+v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	srv.handleGetSchedule(rec, jobsRequest(http.MethodGet, "/workspaces/"+workspaceID+"/schedule", workspaceID, "", sessionID, ""))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("get status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestScheduledCommandRunsAsJob(t *testing.T) {
+	srv, workspaceID, _, _ := newScheduleTestServer(t)
+
+	result := srv.runScheduledEntry(context.Background(), workspaceID, schedule.Entry{ID: "ok", Schedule: "@hourly", Command: "echo scheduled"})
+	if result.Err != nil || result.JobID == "" {
+		t.Fatalf("successful run = %+v", result)
+	}
+	data, _, _, _, err := srv.jobManager.Output(workspaceID, result.JobID, 0)
+	if err != nil || string(data) != "scheduled\n" {
+		t.Fatalf("job output = %q, %v", data, err)
+	}
+
+	result = srv.runScheduledEntry(context.Background(), workspaceID, schedule.Entry{ID: "bad", Schedule: "@hourly", Command: "exit 3"})
+	if result.Err == nil || result.Err.Error() != "exit code 3" {
+		t.Fatalf("failed run = %+v, want exit code 3", result)
+	}
+}
+
+func TestScheduledPromptWithoutAgentFails(t *testing.T) {
+	srv, workspaceID, _, _ := newScheduleTestServer(t)
+
+	result := srv.runScheduledEntry(context.Background(), workspaceID, schedule.Entry{ID: "deps", Schedule: "@nightly", Prompt: "Update dependencies"})
+	if !errors.Is(result.Err, schedule.ErrNoAgent) {
+		t.Fatalf("run = %+v, want ErrNoAgent", result)
+	}
+}
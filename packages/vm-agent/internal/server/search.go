@@ -0,0 +1,421 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/containerruntime"
+)
+
+const (
+	// searchDefaultContextLines and searchMaxContextLines bound the ?context=
+	// lines returned around each match.
+	searchDefaultContextLines = 2
+	searchMaxContextLines     = 10
+	// searchMaxQueryLength bounds ?q=.
+	searchMaxQueryLength = 1024
+	// searchMaxColumns shortens longer lines, such as minified bundles, to a
+	// preview of this many columns.
+	searchMaxColumns = 500
+	// searchMaxEventSize bounds one line of rg --json output.
+	searchMaxEventSize = 1 << 20
+)
+
+// ripgrepInstallScript installs ripgrep in a devcontainer whose image lacks
+// it. It runs as root.
+const ripgrepInstallScript = `set -e
+if ! command -v rg >/dev/null 2>&1; then
+  if command -v apt-get >/dev/null 2>&1; then
+    apt-get update -qq && DEBIAN_FRONTEND=noninteractive apt-get install -y -qq ripgrep
+  elif command -v apk >/dev/null 2>&1; then
+    apk add --no-cache ripgrep
+  else
+    echo "Unsupported package manager, cannot install ripgrep" >&2
+    exit 1
+  fi
+fi
+`
+
+// errRipgrepMissing is returned when rg is not installed where the search runs.
+var errRipgrepMissing = errors.New("ripgrep (rg) is not installed")
+
+// SearchResponse is the response from the workspace search endpoint.
+type SearchResponse struct {
+	Query   string        `json:"query"`
+	Matches []SearchMatch `json:"matches"`
+	// Truncated is set when more matches exist than SEARCH_MAX_RESULTS.
+	Truncated bool `json:"truncated"`
+}
+
+// SearchMatch is one matching line.
+type SearchMatch struct {
+	File string `json:"file"` // relative to the search directory
+	Line int    `json:"line"` // 1-based
+	Text string `json:"text"` // without the line ending
+	// Submatches are the byte ranges of Text that matched.
+	Submatches []SearchSubmatch    `json:"submatches"`
+	Before     []SearchContextLine `json:"before"`
+	After      []SearchContextLine `json:"after"`
+}
+
+// SearchSubmatch is a matched byte range [Start, End) of a line.
+type SearchSubmatch struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// SearchContextLine is a line next to a match.
+type SearchContextLine struct {
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+// searchRequest holds the parsed query parameters of a search.
+type searchRequest struct {
+	query        string
+	globs        []string
+	regex        bool
+	contextLines int
+}
+
+func parseSearchRequest(r *http.Request) (searchRequest, error) {
+	query := r.URL.Query()
+	req := searchRequest{query: query.Get("q"), contextLines: searchDefaultContextLines}
+	if req.query == "" {
+		return req, errors.New("q query parameter is required")
+	}
+	if len(req.query) > searchMaxQueryLength {
+		return req, fmt.Errorf("q must be at most %d bytes", searchMaxQueryLength)
+	}
+	for _, glob := range query["glob"] {
+		if glob = strings.TrimSpace(glob); glob != "" {
+			req.globs = append(req.globs, glob)
+		}
+	}
+	if raw := query.Get("regex"); raw != "" {
+		regex, err := strconv.ParseBool(raw)
+		if err != nil {
+			return req, errors.New("regex must be true or false")
+		}
+		req.regex = regex
+	}
+	if raw := query.Get("context"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 || n > searchMaxContextLines {
+			return req, fmt.Errorf("context must be between 0 and %d", searchMaxContextLines)
+		}
+		req.contextLines = n
+	}
+	return req, nil
+}
+
+// ripgrepArgs returns the rg command line for req. rg's defaults apply:
+// .gitignore'd, hidden and binary files are skipped.
+func (req searchRequest) ripgrepArgs() []string {
+	args := []string{"rg", "--json",
+		"--context", strconv.Itoa(req.contextLines),
+		"--max-columns", strconv.Itoa(searchMaxColumns), "--max-columns-preview",
+	}
+	if !req.regex {
+		args = append(args, "--fixed-strings")
+	}
+	for _, glob := range req.globs {
+		args = append(args, "--glob", glob)
+	}
+	return append(args, "--", req.query, ".")
+}
+
+// handleSearch handles GET /workspaces/{workspaceId}/search?q=&glob=&regex=&context=
+// Searches file contents in the workspace (or ?worktree=) with ripgrep,
+// installing it in the devcontainer on first use.
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.PathValue("workspaceId")
+	if workspaceID == "" {
+		writeError(w, http.StatusBadRequest, "workspaceId is required")
+		return
+	}
+	if !s.requireWorkspaceRequestAuth(w, r, workspaceID) {
+		return
+	}
+
+	req, err := parseSearchRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	containerID, workDir, user, err := s.resolveContainerForWorkspace(workspaceID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	workDir, err = s.resolveWorktreeWorkDir(r, workspaceID, containerID, user, workDir)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resp, err := s.searchWorkspace(r.Context(), containerID, user, workDir, req)
+	if errors.Is(err, errRipgrepMissing) {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	var usageErr *searchUsageError
+	if errors.As(err, &usageErr) {
+		writeError(w, http.StatusBadRequest, usageErr.Error())
+		return
+	}
+	if err != nil {
+		slog.Error("Workspace search failed", "workspace", workspaceID, "error", err)
+		writeError(w, http.StatusInternalServerError, "search failed")
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// searchUsageError is an rg error caused by the request, such as an invalid
+// regular expression or glob.
+type searchUsageError struct {
+	message string
+}
+
+func (e *searchUsageError) Error() string {
+	return e.message
+}
+
+// searchWorkspace runs req in workDir, installing ripgrep once when the
+// devcontainer lacks it.
+func (s *Server) searchWorkspace(ctx context.Context, containerID, user, workDir string, req searchRequest) (SearchResponse, error) {
+	resp, err := s.runRipgrep(ctx, containerID, user, workDir, req)
+	if !errors.Is(err, errRipgrepMissing) || s.isStandaloneWorkspaceExec() {
+		return resp, err
+	}
+	if err := s.installRipgrep(ctx, containerID); err != nil {
+		return SearchResponse{}, fmt.Errorf("%w and could not be installed: %v", errRipgrepMissing, err)
+	}
+	return s.runRipgrep(ctx, containerID, user, workDir, req)
+}
+
+func (s *Server) runRipgrep(ctx context.Context, containerID, user, workDir string, req searchRequest) (SearchResponse, error) {
+	timeout := s.config.SearchTimeout
+	if timeout <= 0 {
+		timeout = 15 * time.Second
+	}
+	maxResults := s.config.SearchMaxResults
+	if maxResults <= 0 {
+		maxResults = 500
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	args := req.ripgrepArgs()
+	if !s.isStandaloneWorkspaceExec() {
+		// Cancelling ctx only kills the local exec client, so rg gets its own
+		// deadline inside the container.
+		args = withContainerTimeout(timeout, args)
+	}
+	cmd, err := s.workspaceExecCommand(ctx, containerID, user, workDir, args...)
+	if err != nil {
+		return SearchResponse{}, err
+	}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return SearchResponse{}, err
+	}
+	if err := cmd.Start(); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return SearchResponse{}, errRipgrepMissing
+		}
+		return SearchResponse{}, fmt.Errorf("start rg: %w", err)
+	}
+
+	matches, truncated, parseErr := parseRipgrepJSON(stdout, req.contextLines, maxResults)
+	if truncated || parseErr != nil {
+		cancel()
+	}
+	_, _ = io.Copy(io.Discard, stdout)
+	waitErr := cmd.Wait()
+	if parseErr != nil {
+		return SearchResponse{}, fmt.Errorf("parse rg output: %w", parseErr)
+	}
+	if !truncated && waitErr != nil {
+		if err := ripgrepExitError(waitErr, stderr.String(), len(matches)); err != nil {
+			return SearchResponse{}, err
+		}
+	}
+	return SearchResponse{Query: req.query, Matches: matches, Truncated: truncated}, nil
+}
+
+// withContainerTimeout wraps args in timeout(1), so the command is stopped
+// inside the container once timeout has passed, rounded up to a second.
+func withContainerTimeout(timeout time.Duration, args []string) []string {
+	seconds := max(int((timeout+time.Second-1)/time.Second), 1)
+	return append([]string{"timeout", strconv.Itoa(seconds)}, args...)
+}
+
+// ripgrepExitError interprets a non-zero rg exit. Status 1 only means
+// nothing matched, and status 2 after matches means some files could not be
+// read. 126 and 127 come from docker exec when rg is not installed.
+func ripgrepExitError(waitErr error, stderr string, matches int) error {
+	var exitErr *exec.ExitError
+	if !errors.As(waitErr, &exitErr) {
+		return fmt.Errorf("rg: %w", waitErr)
+	}
+	stderr = strings.TrimSpace(stderr)
+	switch code := exitErr.ExitCode(); {
+	case code == 1:
+		return nil
+	case code == 126 || code == 127 || strings.Contains(stderr, "executable file not found"):
+		return errRipgrepMissing
+	case code == 2 && matches > 0:
+		return nil
+	case code == 2 && stderr != "":
+		return &searchUsageError{message: stderr}
+	default:
+		return fmt.Errorf("rg: %w: %s", waitErr, stderr)
+	}
+}
+
+// installRipgrep installs ripgrep in the devcontainer as root. Concurrent
+// searches share one installation.
+func (s *Server) installRipgrep(ctx context.Context, containerID string) error {
+	s.ripgrepInstallMu.Lock()
+	defer s.ripgrepInstallMu.Unlock()
+
+	timeout := s.config.SearchInstallTimeout
+	if timeout <= 0 {
+		timeout = 2 * time.Minute
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	slog.Info("Installing ripgrep in devcontainer for workspace search", "containerID", containerID)
+	cmd := dockerWorkspaceExecCommand(ctx, containerID, containerruntime.ExecOptions{User: "root"}, "sh", "-c", ripgrepInstallScript)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, lastLines(string(output), 5))
+	}
+	return nil
+}
+
+// lastLines returns the last n lines of output.
+func lastLines(output string, n int) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return strings.Join(lines[max(len(lines)-n, 0):], "\n")
+}
+
+// rgEvent is one line of rg --json output. Only the fields searches use are
+// decoded.
+type rgEvent struct {
+	Type string `json:"type"`
+	Data struct {
+		Path       rgText `json:"path"`
+		Lines      rgText `json:"lines"`
+		LineNumber int    `json:"line_number"`
+		Submatches []struct {
+			Start int `json:"start"`
+			End   int `json:"end"`
+		} `json:"submatches"`
+	} `json:"data"`
+}
+
+// rgText is rg's representation of a path or line: text when it is valid
+// UTF-8, base64 bytes otherwise.
+type rgText struct {
+	Text  string  `json:"text"`
+	Bytes *string `json:"bytes"`
+}
+
+func (t rgText) String() string {
+	if t.Bytes == nil {
+		return t.Text
+	}
+	data, err := base64.StdEncoding.DecodeString(*t.Bytes)
+	if err != nil {
+		return ""
+	}
+	return strings.ToValidUTF8(string(data), "�")
+}
+
+// parseRipgrepJSON reads rg --json output into matches with up to
+// contextLines lines of context on each side. It stops reading once a match
+// beyond maxResults arrives and reports truncated.
+func parseRipgrepJSON(r io.Reader, contextLines, maxResults int) (matches []SearchMatch, truncated bool, err error) {
+	matches = []SearchMatch{}
+	// pending holds the context lines seen since the last match of the file;
+	// they are split between its after and the next match's before.
+	var pending []SearchContextLine
+	last := -1
+	flushAfter := func() {
+		if last >= 0 {
+			for _, line := range pending {
+				if line.Line <= matches[last].Line+contextLines {
+					matches[last].After = append(matches[last].After, line)
+				}
+			}
+		}
+		pending = nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), searchMaxEventSize)
+	for scanner.Scan() {
+		var event rgEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, false, err
+		}
+		switch event.Type {
+		case "begin", "end":
+			flushAfter()
+			last = -1
+		case "context":
+			pending = append(pending, SearchContextLine{Line: event.Data.LineNumber, Text: trimLineEnding(event.Data.Lines.String())})
+		case "match":
+			if len(matches) == maxResults {
+				flushAfter()
+				return matches, true, nil
+			}
+			match := SearchMatch{
+				File:       strings.TrimPrefix(event.Data.Path.String(), "./"),
+				Line:       event.Data.LineNumber,
+				Text:       trimLineEnding(event.Data.Lines.String()),
+				Submatches: make([]SearchSubmatch, 0, len(event.Data.Submatches)),
+				Before:     []SearchContextLine{},
+				After:      []SearchContextLine{},
+			}
+			for _, sub := range event.Data.Submatches {
+				match.Submatches = append(match.Submatches, SearchSubmatch{Start: sub.Start, End: sub.End})
+			}
+			for _, line := range pending {
+				if line.Line >= match.Line-contextLines {
+					match.Before = append(match.Before, line)
+				}
+			}
+			flushAfter()
+			matches = append(matches, match)
+			last = len(matches) - 1
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, false, err
+	}
+	flushAfter()
+	return matches, false, nil
+}
+
+func trimLineEnding(line string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+}
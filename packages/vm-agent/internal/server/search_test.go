@@ -0,0 +1,128 @@
+package server
+
+import (
+	"errors"
+	"net/http/httptest"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rgOutput is `rg --json --context 1 foo` over two files. b.txt has a line
+// that is not valid UTF-8.
+const rgOutput = `{"type":"begin","data":{"path":{"text":"./a.go"}}}
+{"type":"context","data":{"path":{"text":"./a.go"},"lines":{"text":"package a\n"},"line_number":1,"absolute_offset":0,"submatches":[]}}
+{"type":"match","data":{"path":{"text":"./a.go"},"lines":{"text":"func foo() {}\n"},"line_number":2,"absolute_offset":10,"submatches":[{"match":{"text":"foo"},"start":5,"end":8}]}}
+{"type":"context","data":{"path":{"text":"./a.go"},"lines":{"text":"\n"},"line_number":3,"absolute_offset":24,"submatches":[]}}
+{"type":"match","data":{"path":{"text":"./a.go"},"lines":{"text":"var x = foo()\r\n"},"line_number":4,"absolute_offset":25,"submatches":[{"match":{"text":"foo"},"start":8,"end":11}]}}
+{"type":"end","data":{"path":{"text":"./a.go"},"binary_offset":null,"stats":{}}}
+{"type":"begin","data":{"path":{"text":"./b.txt"}}}
+{"type":"match","data":{"path":{"text":"./b.txt"},"lines":{"bytes":"Zm9vIOk="},"line_number":7,"absolute_offset":0,"submatches":[{"match":{"text":"foo"},"start":0,"end":3}]}}
+{"type":"context","data":{"path":{"text":"./b.txt"},"lines":{"text":"after\n"},"line_number":8,"absolute_offset":6,"submatches":[]}}
+{"type":"end","data":{"path":{"text":"./b.txt"},"binary_offset":null,"stats":{}}}
+{"type":"summary","data":{"elapsed_total":{"secs":0,"nanos":1},"stats":{}}}
+`
+
+func TestParseRipgrepJSON(t *testing.T) {
+	matches, truncated, err := parseRipgrepJSON(strings.NewReader(rgOutput), 1, 10)
+	if err != nil || truncated {
+		t.Fatalf("parseRipgrepJSON() truncated=%v err=%v", truncated, err)
+	}
+	want := []SearchMatch{
+		{
+			File: "a.go", Line: 2, Text: "func foo() {}",
+			Submatches: []SearchSubmatch{{Start: 5, End: 8}},
+			Before:     []SearchContextLine{{Line: 1, Text: "package a"}},
+			After:      []SearchContextLine{{Line: 3, Text: ""}},
+		},
+		{
+			File: "a.go", Line: 4, Text: "var x = foo()",
+			Submatches: []SearchSubmatch{{Start: 8, End: 11}},
+			Before:     []SearchContextLine{{Line: 3, Text: ""}},
+			After:      []SearchContextLine{},
+		},
+		{
+			File: "b.txt", Line: 7, Text: "foo �",
+			Submatches: []SearchSubmatch{{Start: 0, End: 3}},
+			Before:     []SearchContextLine{},
+			After:      []SearchContextLine{{Line: 8, Text: "after"}},
+		},
+	}
+	if !reflect.DeepEqual(matches, want) {
+		t.Fatalf("matches = %+v\nwant %+v", matches, want)
+	}
+}
+
+func TestParseRipgrepJSONStopsAtMaxResults(t *testing.T) {
+	matches, truncated, err := parseRipgrepJSON(strings.NewReader(rgOutput), 1, 1)
+	if err != nil || !truncated || len(matches) != 1 {
+		t.Fatalf("parseRipgrepJSON() matches=%d truncated=%v err=%v", len(matches), truncated, err)
+	}
+	if got := matches[0].After; len(got) != 1 || got[0].Line != 3 {
+		t.Fatalf("after context of the last match = %+v", got)
+	}
+}
+
+func TestParseSearchRequest(t *testing.T) {
+	req, err := parseSearchRequest(httptest.NewRequest("GET", "/search?q=a.b&glob=*.go&glob=!vendor/**&regex=true&context=0", nil))
+	if err != nil {
+		t.Fatalf("parseSearchRequest() error = %v", err)
+	}
+	wantArgs := []string{"rg", "--json", "--context", "0", "--max-columns", "500", "--max-columns-preview",
+		"--glob", "*.go", "--glob", "!vendor/**", "--", "a.b", "."}
+	if got := req.ripgrepArgs(); !reflect.DeepEqual(got, wantArgs) {
+		t.Fatalf("ripgrepArgs() = %q\nwant %q", got, wantArgs)
+	}
+
+	literal, err := parseSearchRequest(httptest.NewRequest("GET", "/search?q=-rf", nil))
+	if err != nil || literal.contextLines != searchDefaultContextLines {
+		t.Fatalf("parseSearchRequest() = %+v, %v", literal, err)
+	}
+	if args := literal.ripgrepArgs(); !reflect.DeepEqual(args[len(args)-4:], []string{"--fixed-strings", "--", "-rf", "."}) {
+		t.Fatalf("literal ripgrepArgs() = %q", args)
+	}
+
+	for _, query := range []string{"", "q=x&regex=maybe", "q=x&context=11", "q=" + strings.Repeat("x", searchMaxQueryLength+1)} {
+		if _, err := parseSearchRequest(httptest.NewRequest("GET", "/search?"+query, nil)); err == nil {
+			t.Errorf("parseSearchRequest(%q) succeeded", query)
+		}
+	}
+}
+
+func TestWithContainerTimeout(t *testing.T) {
+	got := withContainerTimeout(1500*time.Millisecond, []string{"rg", "--json", "--", "q", "."})
+	want := []string{"timeout", "2", "rg", "--json", "--", "q", "."}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("withContainerTimeout() = %q, want %q", got, want)
+	}
+	if got := withContainerTimeout(0, []string{"rg"}); got[1] != "1" {
+		t.Fatalf("withContainerTimeout(0) = %q, want at least one second", got)
+	}
+}
+
+func TestRipgrepExitError(t *testing.T) {
+	exitErr := func(code int) error {
+		err := exec.Command("sh", "-c", "exit "+strconv.Itoa(code)).Run()
+		if err == nil {
+			t.Fatalf("exit %d succeeded", code)
+		}
+		return err
+	}
+
+	if err := ripgrepExitError(exitErr(1), "", 0); err != nil {
+		t.Fatalf("no matches: %v", err)
+	}
+	if err := ripgrepExitError(exitErr(2), "a.bin: Permission denied", 3); err != nil {
+		t.Fatalf("unreadable file with matches: %v", err)
+	}
+	if err := ripgrepExitError(exitErr(127), "", 0); !errors.Is(err, errRipgrepMissing) {
+		t.Fatalf("exit 127 error = %v, want errRipgrepMissing", err)
+	}
+	var usageErr *searchUsageError
+	if err := ripgrepExitError(exitErr(2), "regex parse error:\n    (foo\n    ^", 0); !errors.As(err, &usageErr) {
+		t.Fatalf("invalid regex error = %v, want searchUsageError", err)
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/bootstrap"
+	"github.com/workspace/vm-agent/internal/provisionspec"
+)
+
+// secretFilesReinjectTimeout bounds one workspace's secret files check and
+// re-injection, which fetches runtime assets from the control plane.
+const secretFilesReinjectTimeout = 30 * time.Second
+
+// Overridable for tests.
+var (
+	secretFilesInstalled = bootstrap.SecretFilesInstalled
+	reinjectSecretFiles  = bootstrap.ReinjectSecretFiles
+)
+
+// startSecretFilesWatcher periodically checks that secret files on the
+// devcontainer tmpfs survived a container restart, which empties it, and
+// injects them again when they did not.
+func (s *Server) startSecretFilesWatcher() {
+	interval := s.config.SecretFilesCheckInterval
+	if !s.config.ContainerMode || !s.config.SecretFilesTmpfs || interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				s.reinjectMissingSecretFiles()
+			}
+		}
+	}()
+}
+
+// reinjectMissingSecretFiles re-injects the secret files of every running
+// workspace whose tmpfs is empty. Failures are logged and retried next
+// interval.
+func (s *Server) reinjectMissingSecretFiles() {
+	type pending struct {
+		workspaceID string
+		spec        *provisionspec.Spec
+	}
+	var workspaces []pending
+	s.workspaces.Range(func(_ string, runtime *WorkspaceRuntime) {
+		if runtime.Status == "running" || runtime.Status == "recovery" {
+			workspaces = append(workspaces, pending{runtime.ID, runtime.ProvisionSpec})
+		}
+	})
+
+	for _, ws := range workspaces {
+		containerID, workDir, user, err := s.resolveContainerForWorkspace(ws.workspaceID)
+		if err != nil || containerID == "" {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), secretFilesReinjectTimeout)
+		if err := s.reinjectWorkspaceSecretFiles(ctx, ws.workspaceID, ws.spec, containerID, workDir, user); err != nil {
+			slog.Warn("Secret files re-injection failed", "workspace", ws.workspaceID, "error", err)
+		}
+		cancel()
+	}
+}
+
+func (s *Server) reinjectWorkspaceSecretFiles(ctx context.Context, workspaceID string, spec *provisionspec.Spec, containerID, workDir, user string) error {
+	installed, err := secretFilesInstalled(ctx, containerID)
+	if err != nil || installed {
+		return err
+	}
+	assets, err := s.fetchProjectRuntimeAssetsForWorkspace(ctx, workspaceID, "", "")
+	if err != nil {
+		return err
+	}
+	if err := reinjectSecretFiles(ctx, containerID, user, workDir, assets.Files, spec); err != nil {
+		return err
+	}
+	s.appendNodeEvent(workspaceID, "info", "workspace.secret_files_reinjected", "Secret files injected again after a devcontainer restart", nil)
+	return nil
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/workspace/vm-agent/internal/bootstrap"
+	"github.com/workspace/vm-agent/internal/config"
+	"github.com/workspace/vm-agent/internal/provisionspec"
+)
+
+func TestReinjectWorkspaceSecretFilesOnlyAfterRestart(t *testing.T) {
+	controlPlane := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"workspaceId":"ws-1","files":[{"path":".env.local","content":"FOO=bar\n","isSecret":true}]}`))
+	}))
+	defer controlPlane.Close()
+
+	installed := true
+	var reinjected []bootstrap.ProjectRuntimeFile
+	origInstalled, origReinject := secretFilesInstalled, reinjectSecretFiles
+	t.Cleanup(func() { secretFilesInstalled, reinjectSecretFiles = origInstalled, origReinject })
+	secretFilesInstalled = func(context.Context, string) (bool, error) { return installed, nil }
+	reinjectSecretFiles = func(_ context.Context, containerID, user, workDir string, files []bootstrap.ProjectRuntimeFile, _ *provisionspec.Spec) error {
+		if containerID != "container-1" || user != "node" || workDir != "/workspaces/repo" {
+			t.Errorf("reinject target = %s %s %s", containerID, user, workDir)
+		}
+		reinjected = files
+		return nil
+	}
+
+	s := &Server{
+		config:          &config.Config{ControlPlaneURL: controlPlane.URL, CallbackToken: "callback-token"},
+		workspaceEvents: make(map[string][]EventRecord),
+	}
+
+	if err := s.reinjectWorkspaceSecretFiles(context.Background(), "ws-1", nil, "container-1", "/workspaces/repo", "node"); err != nil {
+		t.Fatalf("reinjectWorkspaceSecretFiles() error = %v", err)
+	}
+	if reinjected != nil {
+		t.Fatal("secret files were re-injected although they are still installed")
+	}
+
+	installed = false
+	if err := s.reinjectWorkspaceSecretFiles(context.Background(), "ws-1", nil, "container-1", "/workspaces/repo", "node"); err != nil {
+		t.Fatalf("reinjectWorkspaceSecretFiles() error = %v", err)
+	}
+	if len(reinjected) != 1 || reinjected[0].Path != ".env.local" || !reinjected[0].IsSecret {
+		t.Fatalf("reinjected files = %+v, want .env.local", reinjected)
+	}
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/acp"
+	"github.com/workspace/vm-agent/internal/selfupdate"
+	"github.com/workspace/vm-agent/internal/sysinfo"
+)
+
+const (
+	// selfUpdateTimeout bounds one release check and download.
+	selfUpdateTimeout = 10 * time.Minute
+	// selfUpdateIdlePoll is how often an installed release waits for agent
+	// prompts to finish before the agent restarts into it.
+	selfUpdateIdlePoll = 15 * time.Second
+)
+
+// startSelfUpdater periodically asks the control plane for a newer signed
+// agent release. Once one is installed it waits until no agent is mid-prompt
+// and then re-execs into it, keeping the HTTP listener and resuming sessions
+// from the persistence store.
+func (s *Server) startSelfUpdater() {
+	if s.config.SelfUpdateInterval <= 0 {
+		return
+	}
+	updater, err := selfupdate.New(selfupdate.Config{
+		ControlPlaneURL: s.config.ControlPlaneURL,
+		NodeID:          s.config.NodeID,
+		CurrentVersion:  sysinfo.Version,
+		PublicKey:       s.config.SelfUpdatePubKey,
+		Token:           s.getCallbackToken,
+		HTTPClient:      s.controlPlaneHTTPClient(selfUpdateTimeout),
+	})
+	if err != nil {
+		slog.Error("Self-update disabled", "error", err)
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(s.config.SelfUpdateInterval)
+		defer ticker.Stop()
+
+		var installed *selfupdate.Release
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+			}
+			if installed == nil {
+				if installed = s.installSelfUpdate(updater); installed != nil {
+					ticker.Reset(selfUpdateIdlePoll)
+				}
+			}
+			if installed != nil && s.selfUpdateIdle() {
+				s.restartIntoRelease(updater, installed)
+			}
+		}
+	}()
+}
+
+// installSelfUpdate checks for a newer release and installs it over the
+// agent binary. It returns the installed release, or nil if there is none
+// or installing it failed.
+func (s *Server) installSelfUpdate(updater *selfupdate.Updater) *selfupdate.Release {
+	ctx, cancel := context.WithTimeout(context.Background(), selfUpdateTimeout)
+	defer cancel()
+
+	release, err := updater.Check(ctx)
+	if err != nil {
+		slog.Warn("Agent release check failed", "error", err)
+		return nil
+	}
+	if release == nil {
+		return nil
+	}
+	if err := updater.Install(ctx, release); err != nil {
+		slog.Error("Agent release install failed", "version", release.Version, "error", err)
+		s.appendNodeEvent("", "error", "agent.update_failed", "Agent release could not be installed", map[string]interface{}{
+			"version": release.Version,
+			"error":   err.Error(),
+		})
+		return nil
+	}
+	slog.Info("Agent release installed", "from", sysinfo.Version, "to", release.Version, "binary", updater.BinaryPath())
+	return release
+}
+
+// selfUpdateIdle reports whether the agent can restart without cutting off
+// a prompt. A draining node is about to be torn down, so it never restarts.
+func (s *Server) selfUpdateIdle() bool {
+	if s.drainStatus() != nil {
+		return false
+	}
+	s.sessionHostMu.Lock()
+	defer s.sessionHostMu.Unlock()
+	for _, host := range s.sessionHosts {
+		if host != nil && host.IsPrompting() {
+			return false
+		}
+	}
+	return true
+}
+
+// restartIntoRelease suspends every session host, persisting its ACP session
+// ID so viewers reconnecting to the new process resume it via LoadSession,
+// flushes queued messages, and replaces the process with the installed
+// binary. It does not return.
+func (s *Server) restartIntoRelease(updater *selfupdate.Updater, release *selfupdate.Release) {
+	slog.Info("Restarting into agent release", "from", sysinfo.Version, "to", release.Version)
+	s.appendNodeEvent("", "info", "agent.updating", "Agent restarting into new release", map[string]interface{}{
+		"from": sysinfo.Version,
+		"to":   release.Version,
+	})
+
+	// Suspend and Stop wait for agent processes to exit, so they run on
+	// hosts taken out of the maps under the lock rather than while holding it.
+	s.sessionHostMu.Lock()
+	hosts := make(map[string]*acp.SessionHost, len(s.sessionHosts))
+	for key, host := range s.sessionHosts {
+		if host != nil {
+			hosts[key] = host
+		}
+		delete(s.sessionHosts, key)
+	}
+	standbys := make([]*acp.SessionHost, 0, len(s.warmStandbyHosts))
+	for workspaceID, host := range s.warmStandbyHosts {
+		standbys = append(standbys, host)
+		delete(s.warmStandbyHosts, workspaceID)
+	}
+	s.sessionHostMu.Unlock()
+
+	for _, host := range standbys {
+		host.Stop()
+	}
+	for key, host := range hosts {
+		acpSessionID, _ := host.Suspend()
+		_, sessionID, _ := strings.Cut(key, ":")
+		if acpSessionID != "" && s.store != nil {
+			if err := s.store.UpdateTabAcpSessionID(sessionID, acpSessionID); err != nil {
+				slog.Warn("Failed to persist ACP session before restart", "key", key, "error", err)
+			}
+		}
+	}
+
+	s.flushAllReporters()
+	if s.store != nil {
+		if err := s.store.Close(); err != nil {
+			slog.Warn("Failed to close persistence store", "error", err)
+		}
+	}
+
+	err := selfupdate.Exec(updater.BinaryPath(), s.listener)
+	// The store is closed, so this process cannot carry on; exiting lets
+	// systemd restart the unit, which starts the new binary anyway.
+	slog.Error("Agent re-exec failed; exiting", "error", err)
+	os.Exit(1)
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -19,21 +20,38 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/workspace/vm-agent/internal/accessaudit"
 	"github.com/workspace/vm-agent/internal/acp"
+	"github.com/workspace/vm-agent/internal/activity"
 	"github.com/workspace/vm-agent/internal/agentsessions"
 	"github.com/workspace/vm-agent/internal/auth"
+	"github.com/workspace/vm-agent/internal/bootlog"
+	"github.com/workspace/vm-agent/internal/bootstrap"
 	"github.com/workspace/vm-agent/internal/config"
 	"github.com/workspace/vm-agent/internal/container"
 	"github.com/workspace/vm-agent/internal/deploy"
+	"github.com/workspace/vm-agent/internal/diskmon"
+	"github.com/workspace/vm-agent/internal/egress"
 	"github.com/workspace/vm-agent/internal/errorreport"
 	"github.com/workspace/vm-agent/internal/eventstore"
+	"github.com/workspace/vm-agent/internal/execaudit"
+	"github.com/workspace/vm-agent/internal/gitlock"
+	"github.com/workspace/vm-agent/internal/jobs"
+	"github.com/workspace/vm-agent/internal/lifecyclehook"
 	"github.com/workspace/vm-agent/internal/logreader"
 	"github.com/workspace/vm-agent/internal/messagereport"
+	"github.com/workspace/vm-agent/internal/notes"
 	"github.com/workspace/vm-agent/internal/persistence"
 	"github.com/workspace/vm-agent/internal/ports"
+	"github.com/workspace/vm-agent/internal/provisionspec"
 	"github.com/workspace/vm-agent/internal/pty"
 	"github.com/workspace/vm-agent/internal/publish"
+	"github.com/workspace/vm-agent/internal/repocache"
 	"github.com/workspace/vm-agent/internal/resourcemon"
+	"github.com/workspace/vm-agent/internal/retention"
+	"github.com/workspace/vm-agent/internal/schedule"
+	"github.com/workspace/vm-agent/internal/selfupdate"
+	"github.com/workspace/vm-agent/internal/sshserver"
 	"github.com/workspace/vm-agent/internal/sysinfo"
 )
 
@@ -71,39 +89,83 @@ var taskCallbackDiagnosticRedactionPatterns = []*regexp.Regexp{
 // Server is the HTTP server for the VM Agent.
 type Server struct {
 	config              *config.Config
+	settings            atomic.Pointer[config.Config] // reloadable settings; replaced whole by ReloadConfig, never written in place
 	httpServer          *http.Server
+	listener            net.Listener // set by Start; handed to the new process on self-update
 	jwtValidator        *auth.JWTValidator
 	sessionManager      *auth.SessionManager
 	ptyManager          *pty.Manager
 	sysInfoCollector    *sysinfo.Collector
-	workspaceMu         sync.RWMutex
-	workspaces          map[string]*WorkspaceRuntime
-	readyRetryMu        sync.Mutex // guards retryPendingReadyCallbacks — only one run at a time
+	workspaces          WorkspaceRegistry
+	readyRetryMu        sync.Mutex  // guards retryPendingReadyCallbacks — only one run at a time
+	nodeReadyPending    atomic.Bool // node-ready callback failed and awaits redelivery after reconnect
 	eventMu             sync.RWMutex
 	nodeEvents          []EventRecord
 	workspaceEvents     map[string][]EventRecord
 	eventStore          *eventstore.Store
 	resourceMonitor     *resourcemon.Monitor
+	promptDurations     *promptDurationHistogram
+	activityTracker     *activity.Tracker
 	agentSessions       *agentsessions.Manager
 	acpConfig           acp.GatewayConfig
+	reloadMu            sync.Mutex // serializes ReloadConfig
 	sessionHostMu       sync.Mutex
 	sessionHosts        map[string]*acp.SessionHost
 	sessionMcpServers   map[string][]acp.McpServerEntry // hostKey → MCP servers for ACP injection
 	sessionProfileOvr   map[string]profileOverrides     // hostKey → model/permissionMode/effort overrides from agent profiles
 	sessionTaskCtx      map[string]taskCallbackContext  // hostKey → task callback ownership context
+	warmStandbyHosts    map[string]*acp.SessionHost     // workspaceID → unclaimed warm-standby SessionHost
+	pollViewersMu       sync.Mutex
+	pollViewers         map[string]*pollViewer    // viewerID → HTTP long-poll viewer
+	checkpointMu        sync.Mutex                // serializes prompt checkpoints, which rewrite the index
+	projectEnvMu        sync.Mutex                // serializes project env updates, which read, merge, and rewrite the env files
+	envReloadWaiting    map[*acp.SessionHost]bool // hosts with an env reload waiting for a prompt to finish; guarded by projectEnvMu
+	autosaveMu          sync.Mutex
+	lastAutosave        map[string]string // workspaceID → HEAD[:tree] last pushed to an autosave branch; guarded by autosaveMu
+	gitLocks            gitlock.Manager   // per-workspace lock serializing git write operations
+	ripgrepInstallMu    sync.Mutex        // serializes on-demand ripgrep installs for workspace search
+	drainMu             sync.Mutex
+	drain               *drainState // set once the node starts draining for shutdown; guarded by drainMu
+	terminalConnsMu     sync.Mutex
+	terminalConns       map[*terminalConn]struct{} // open terminal WebSockets, for drain notices
+	recoveryRetryMu     sync.Mutex
+	recoveryRetries     map[string]*recoveryRetryState // workspaceID → devcontainer build retries while in recovery mode
+	announcementMu      sync.Mutex
+	announcements       map[string][]*workspaceAnnouncement  // workspaceID → active announcements, oldest first
+	fileChanges         map[string]map[string]acp.FileChange // workspaceID → last broadcast working-tree state; only touched by the file change watcher
+	notesStore          *notes.Store                         // nil when the notes database could not be opened
+	jobManager          *jobs.Manager                        // background jobs; in memory, lost on restart
+	scheduler           *schedule.Scheduler                  // cron-like workspace schedules; in memory, reloaded when a workspace is ready
+	accessAudit         *accessaudit.Store                   // nil when the access audit database could not be opened
+	execAudit           *execaudit.Store                     // nil when the exec audit database could not be opened
+	retentionStore      *retention.Store                     // nil when the retention database could not be opened
+	retentionPurger     *retention.Purger                    // nil when retentionStore is nil
+	repoMirrors         *repocache.Cache                     // nil when the mirror cache is disabled or unavailable
+	diskMonitor         *diskmon.Monitor                     // nil when disk monitoring is disabled
+	sshServer           *sshserver.Server                    // nil when SSH access is disabled or misconfigured
+	controlSocket       *http.Server                         // local samctl API; nil when the control socket is disabled
+	controlPlaneMu      sync.Mutex
+	controlPlane        controlPlaneState             // guarded by controlPlaneMu
+	callbackQueues      map[string]queuedCallbackSink // workspaceID ("" for the node) → boot-log reporter flushed after reconnect; guarded by controlPlaneMu
 	store               *persistence.Store
 	errorReporter       *errorreport.Reporter
+	lifecycleNotifier   *lifecyclehook.Notifier // nil when LIFECYCLE_WEBHOOK_URL is unset
 	messageReportersMu  sync.RWMutex
 	messageReporters    map[string]*messagereport.Reporter // keyed by workspaceID
 	worktreeCacheMu     sync.RWMutex
 	worktreeCache       map[string]cachedWorktreeList
 	logReader           *logreader.Reader
 	bootLogBroadcasters *BootLogBroadcasterManager
+	bootLogSinks        []bootlog.Sink // operator sinks shared by every boot-log reporter; empty when BOOTLOG_SINKS is unset
 	containerDiscovery  *container.Discovery
 	portScannerMu       sync.RWMutex
 	portScanners        map[string]*ports.Scanner
 	portDiscoveries     map[string]*container.Discovery // per-workspace container discovery
 	bootstrapComplete   atomic.Bool
+	startedAt           time.Time       // when the server was created; the agent uptime in heartbeats
+	assigned            atomic.Bool     // set once POST /assign has bound a pre-provisioned node to its workspace
+	idleShutdownForced  atomic.Bool     // set by samctl idle-shutdown; reported with each heartbeat
+	assignments         chan Assignment // delivers the POST /assign payload to WaitForAssignment; buffered 1
 	callbackTokenMu     sync.RWMutex
 	callbackToken       string
 	httpClient          *http.Client // shared HTTP client with timeout for control-plane callbacks
@@ -161,11 +223,53 @@ type WorkspaceRuntime struct {
 	GitUserEmail           string
 	GitHubID               string
 	Lightweight            bool   // Skip devcontainer build, use fallback image for faster startup
+	CommitTrailers         *bool  // Per-workspace commit trailer hooks override; nil uses the node default
 	DevcontainerConfigName string // Named devcontainer config (subdirectory under .devcontainer/)
 	DevcontainerCache      DevcontainerCacheCredentials
 	ProvisioningActive     bool
 	PTY                    *pty.Manager
 
+	// ProvisionSpec is the declarative spec the workspace was created from,
+	// if any. It is replayed on recovery and supplies agent session defaults.
+	ProvisionSpec *provisionspec.Spec
+	// SnapshotURL is the volume snapshot the workspace was created from, if
+	// any. It only applies while the volume is still empty.
+	SnapshotURL string
+	// DeployKey and KnownHosts authenticate SSH repository remotes, and
+	// SigningKey signs commits. They are held in memory only and replayed
+	// when the devcontainer is recovered.
+	DeployKey  string
+	KnownHosts string
+	SigningKey string
+	// EnvironmentTemplate is replayed on recovery; its markers in the
+	// container skip sections that were already applied.
+	EnvironmentTemplate *bootstrap.EnvironmentTemplate
+	// ResourceLimits override the node's default devcontainer limits; they
+	// are reapplied on recovery and reported by the resources endpoint.
+	ResourceLimits *bootstrap.ResourceLimits
+	// EgressPolicy is re-applied on recovery and by the egress refresher.
+	EgressPolicy *egress.Policy
+	// RegistryCredentials are held in memory only and logged in again when
+	// the devcontainer is recovered or rebuilt.
+	RegistryCredentials bootstrap.RegistryCredentials
+	// DevcontainerPolicy is checked again whenever the devcontainer is
+	// rebuilt, recovered, or retried from recovery mode.
+	DevcontainerPolicy *bootstrap.DevcontainerPolicy
+	// Hooks are the workspace's lifecycle hook scripts. Provisioning hooks
+	// are replayed on recovery; preShutdown runs during the node drain.
+	Hooks bootstrap.Hooks
+	// CredentialRemotes maps remotes added through the git remotes API to
+	// their repository paths; the git credential exchange serves these paths
+	// in addition to the bound repository. Guarded by the registry lock, and
+	// persisted with the workspace metadata so it survives agent restarts.
+	CredentialRemotes map[string]string
+	// RebuildContainer is only set on the provisioning snapshot of a
+	// devcontainer rebuild; see bootstrap.ProvisionState.RebuildContainer.
+	RebuildContainer bool
+	// ForceResync is only set on the provisioning snapshot of a restart that
+	// asked for it; see bootstrap.ProvisionState.ForceResync.
+	ForceResync bool
+
 	// ReadyCallbackPending is true when the workspace provisioned successfully but
 	// the workspace-ready callback to the control plane failed (e.g., transient
 	// network issue). The heartbeat loop retries the callback when connectivity
@@ -177,10 +281,11 @@ type WorkspaceRuntime struct {
 }
 
 type DevcontainerCacheCredentials struct {
-	Registry string
-	Username string
-	Password string
-	Ref      string
+	Registry    string
+	Username    string
+	Password    string
+	Ref         string
+	PrebuildRef string
 }
 
 type EventRecord struct {
@@ -211,12 +316,10 @@ func bootMessageReporterWorkspaceID(cfg *config.Config) (string, bool) {
 // markReadyCallbackPending flags a workspace's ready callback as undelivered so
 // the heartbeat loop can retry it when connectivity is restored.
 func (s *Server) markReadyCallbackPending(workspaceID, readyStatus string) {
-	s.workspaceMu.Lock()
-	defer s.workspaceMu.Unlock()
-	if runtime, ok := s.workspaces[workspaceID]; ok {
+	s.workspaces.Update(workspaceID, func(runtime *WorkspaceRuntime) {
 		runtime.ReadyCallbackPending = true
 		runtime.ReadyCallbackStatus = readyStatus
-	}
+	})
 }
 
 // pendingCallbackEntry holds the data needed to retry a single workspace-ready callback.
@@ -229,15 +332,13 @@ type pendingCallbackEntry struct {
 // pendingReadyCallbacks returns workspace IDs and their ready statuses for all
 // workspaces whose ready callback has not been delivered.
 func (s *Server) pendingReadyCallbacks() []pendingCallbackEntry {
-	// Read the node-level fallback token before entering workspaceMu
+	// Read the node-level fallback token before entering the registry lock
 	// to avoid a data race: callbackToken is protected by callbackTokenMu,
-	// not by workspaceMu.
+	// not by the registry.
 	nodeLevelToken := s.getCallbackToken()
 
-	s.workspaceMu.RLock()
-	defer s.workspaceMu.RUnlock()
 	var pending []pendingCallbackEntry
-	for _, runtime := range s.workspaces {
+	s.workspaces.Range(func(_ string, runtime *WorkspaceRuntime) {
 		if runtime.ReadyCallbackPending {
 			token := runtime.CallbackToken
 			if token == "" {
@@ -249,26 +350,24 @@ func (s *Server) pendingReadyCallbacks() []pendingCallbackEntry {
 				Status:        runtime.ReadyCallbackStatus,
 			})
 		}
-	}
+	})
 	return pending
 }
 
 // clearReadyCallbackPending clears the pending callback flag for a workspace.
 func (s *Server) clearReadyCallbackPending(workspaceID string) {
-	s.workspaceMu.Lock()
-	defer s.workspaceMu.Unlock()
-	if runtime, ok := s.workspaces[workspaceID]; ok {
+	s.workspaces.Update(workspaceID, func(runtime *WorkspaceRuntime) {
 		runtime.ReadyCallbackPending = false
 		runtime.ReadyCallbackStatus = ""
-	}
+	})
 }
 
 // effectivePromptTimeout returns the prompt timeout based on session type.
 // Task-driven workspaces (TaskID set) use ACPTaskPromptTimeout (default 6h).
 // Direct workspace sessions use ACPPromptTimeout (default 0 = no timeout).
 //
-// Evaluated once at server startup. The result is baked into acpConfig.PromptTimeout
-// and shared by all SessionHosts on this server instance.
+// Evaluated at server startup and, with the current settings, for each
+// SessionHost created afterwards.
 func effectivePromptTimeout(cfg *config.Config) time.Duration {
 	if cfg.TaskID != "" {
 		return cfg.ACPTaskPromptTimeout
@@ -276,6 +375,22 @@ func effectivePromptTimeout(cfg *config.Config) time.Duration {
 	return cfg.ACPPromptTimeout
 }
 
+// adaptivePromptTimeout builds the node-wide adaptive prompt timeout settings.
+// It returns the zero value (disabled) unless ACPPromptTimeoutAdaptive is set.
+func adaptivePromptTimeout(cfg *config.Config) acp.AdaptivePromptTimeout {
+	if !cfg.ACPPromptTimeoutAdaptive {
+		return acp.AdaptivePromptTimeout{}
+	}
+	return acp.AdaptivePromptTimeout{
+		Tracker:    acp.NewPromptDurationTracker(cfg.ACPPromptTimeoutWindow),
+		Percentile: cfg.ACPPromptTimeoutPercentile,
+		Multiplier: cfg.ACPPromptTimeoutMultiplier,
+		MinSamples: cfg.ACPPromptTimeoutMinSamples,
+		Min:        cfg.ACPPromptTimeoutMin,
+		Max:        cfg.ACPPromptTimeoutMax,
+	}
+}
+
 // New creates a new server instance.
 func New(cfg *config.Config) (*Server, error) {
 	// Create JWT validator with configurable issuer and audience
@@ -375,17 +490,22 @@ func New(cfg *config.Config) (*Server, error) {
 		ContainerUser:                  containerUser,
 		ContainerWorkDir:               containerWorkDir,
 		ProcessLauncher:                processLauncher,
+		StdioReattach:                  cfg.ACPStdioReattach,
+		StdioReattachTimeout:           cfg.ACPStdioReattachTimeout,
 		GitTokenFetcher:                nil, // set below after server construction
 		FileExecTimeout:                cfg.GitExecTimeout,
-		FileMaxSize:                    cfg.GitFileMaxSize,
+		FileMaxSize:                    cfg.ACPFileMaxSize,
 		ErrorReporter:                  errorReporter,
 		PingInterval:                   cfg.ACPPingInterval,
 		PongTimeout:                    cfg.ACPPongTimeout,
 		PromptTimeout:                  effectivePromptTimeout(cfg),
+		AdaptivePromptTimeout:          adaptivePromptTimeout(cfg),
+		PromptTimeoutOverrideMax:       cfg.ACPPromptTimeoutOverrideMax,
 		PromptCancelGracePeriod:        cfg.ACPPromptCancelGrace,
 		PromptRetryMaxRetries:          cfg.ACPPromptRetryMaxRetries,
 		PromptRetryInitialDelay:        cfg.ACPPromptRetryInitial,
 		PromptRetryMaxDelay:            cfg.ACPPromptRetryMax,
+		AgentKeyRetry:                  cfg.AgentKeyFetchRetry,
 		ActivityRereportInterval:       cfg.ACPActivityRereportInterval,
 		TerminalActivityReportAttempts: cfg.ACPTerminalActivityReportAttempts,
 		TerminalActivityReportBackoff:  cfg.ACPTerminalActivityReportBackoff,
@@ -412,6 +532,20 @@ func New(cfg *config.Config) (*Server, error) {
 			return nil, fmt.Errorf("configure persistence token encryption: %w", err)
 		}
 	}
+	if cfg.ACPMaxAgentsPerWorkspace > 0 || cfg.ACPWorkspaceMemoryPercent > 0 {
+		acpGatewayConfig.AgentAdmission = acp.NewSessionHostManager(acp.AgentQuota{
+			MaxAgents:     cfg.ACPMaxAgentsPerWorkspace,
+			MemoryPercent: cfg.ACPWorkspaceMemoryPercent,
+			QueueTimeout:  cfg.ACPAgentQuotaQueueTimeout,
+		})
+	}
+	if cfg.OfflineCredentialCacheEnabled && cfg.CallbackToken != "" {
+		acpGatewayConfig.OfflineCache = offlineCacheStore{store: store}
+		acpGatewayConfig.OfflineCacheMaxAge = cfg.OfflineCredentialCacheMaxAge
+	}
+	if cfg.CallbackOutboxMaxEntries > 0 {
+		acpGatewayConfig.CallbackOutbox = callbackOutboxStore{store: store, keep: cfg.CallbackOutboxMaxEntries}
+	}
 	if err := store.MarkActiveJobsInterrupted(); err != nil {
 		return nil, fmt.Errorf("mark interrupted vm jobs: %w", err)
 	}
@@ -472,6 +606,45 @@ func New(cfg *config.Config) (*Server, error) {
 		slog.Error("Failed to open event store; falling back to in-memory only", "error", err)
 	}
 
+	// Operator boot-log sinks (file, journald, webhook) shared by every reporter.
+	bootLogSinks, err := bootlog.NewSinks(cfg)
+	if err != nil {
+		slog.Error("Failed to configure boot log sinks; boot logs go to the control plane only", "error", err)
+		bootLogSinks = nil
+	}
+
+	// Open workspace notes store (SQLite-backed, survives restarts).
+	notesStore, err := notes.New(cfg.NotesDBPath, cfg.NotesMaxPerWorkspace)
+	if err != nil {
+		slog.Error("Failed to open workspace notes store; notes API disabled", "error", err)
+		notesStore = nil
+	}
+
+	// Open viewer access audit store (SQLite-backed, survives restarts).
+	accessAudit, err := accessaudit.New(cfg.AccessAuditDBPath, cfg.AccessAuditRetention)
+	if err != nil {
+		slog.Error("Failed to open access audit store; viewer access will not be audited", "error", err)
+		accessAudit = nil
+	}
+
+	// Open privileged exec audit store. Bootstrap and agent sessions record
+	// into it through the package-level default.
+	execAudit, err := execaudit.New(cfg.ExecAuditDBPath, cfg.ExecAuditRetention)
+	if err != nil {
+		slog.Error("Failed to open exec audit store; privileged container execs will only be logged", "error", err)
+		execAudit = nil
+	}
+	execaudit.SetDefault(execAudit)
+
+	// Open retention policy store (SQLite-backed, survives restarts).
+	retentionStore, err := retention.New(cfg.RetentionDBPath, cfg.RetentionReceiptRetention)
+	if err != nil {
+		slog.Error("Failed to open retention store; retention policies will not be enforced", "error", err)
+		retentionStore = nil
+	}
+
+	repoMirrors := newRepoMirrorCache(cfg)
+
 	// Start resource monitor (1-minute snapshots of CPU/memory/disk).
 	resMon, err := resourcemon.New(cfg.MetricsDBPath, cfg.MetricsInterval)
 	if err != nil {
@@ -484,35 +657,59 @@ func New(cfg *config.Config) (*Server, error) {
 		sessionManager:      sessionManager,
 		ptyManager:          ptyManager,
 		sysInfoCollector:    sysInfoCollector,
-		workspaces:          make(map[string]*WorkspaceRuntime),
+		assignments:         make(chan Assignment, 1),
 		nodeEvents:          make([]EventRecord, 0, 512),
 		workspaceEvents:     make(map[string][]EventRecord),
 		eventStore:          evStore,
+		notesStore:          notesStore,
+		jobManager:          jobs.New(cfg.JobsMaxPerWorkspace, cfg.JobsMaxOutputBytes),
+		accessAudit:         accessAudit,
+		execAudit:           execAudit,
+		retentionStore:      retentionStore,
+		repoMirrors:         repoMirrors,
+		diskMonitor:         newDiskMonitor(cfg),
 		resourceMonitor:     resMon,
 		agentSessions:       agentsessions.NewManager(),
 		acpConfig:           acpGatewayConfig,
+		promptDurations:     newPromptDurationHistogram(),
+		activityTracker:     newActivityTracker(cfg),
 		sessionHosts:        make(map[string]*acp.SessionHost),
 		sessionMcpServers:   make(map[string][]acp.McpServerEntry),
 		sessionProfileOvr:   make(map[string]profileOverrides),
 		sessionTaskCtx:      make(map[string]taskCallbackContext),
+		warmStandbyHosts:    make(map[string]*acp.SessionHost),
 		store:               store,
 		errorReporter:       errorReporter,
+		lifecycleNotifier:   newLifecycleNotifier(cfg),
 		messageReporters:    messageReporters,
 		worktreeCache:       make(map[string]cachedWorktreeList),
 		logReader:           logreader.NewReaderWithTimeout(cfg.LogReaderTimeout),
 		bootLogBroadcasters: NewBootLogBroadcasterManager(),
+		bootLogSinks:        bootLogSinks,
 		containerDiscovery:  containerDiscoveryInstance,
 		portScanners:        make(map[string]*ports.Scanner),
 		portDiscoveries:     make(map[string]*container.Discovery),
 		callbackToken:       cfg.CallbackToken,
 		httpClient:          config.NewControlPlaneClient(cfg.HTTPCallbackTimeout),
 		done:                make(chan struct{}),
+		startedAt:           time.Now(),
 		publishJobs:         make(map[string]publishJobState),
 		applyWatchdogs:      make(map[string]chan struct{}),
 		deployEngines:       make(map[string]*deploy.Engine),
 		deployRetiring:      make(map[string]bool),
 	}
 
+	settings := *cfg
+	s.settings.Store(&settings)
+	s.acpConfig.OnPromptDuration = s.promptDurations.observe
+
+	if retentionStore != nil {
+		s.retentionPurger = s.newRetentionPurger()
+	}
+	s.scheduler = schedule.New(s.runScheduledEntry, s.reportScheduledRun, cfg.ScheduleMaxEntries, cfg.ScheduleHistorySize)
+	s.sshServer = s.newSSHServer(cfg)
+	s.controlSocket = s.newControlSocket(cfg)
+
 	// GitTokenFetcher is intentionally left nil at the server level.
 	// Each SessionHost receives a per-session closure in getOrCreateSessionHost()
 	// that captures the correct workspace ID. A nil fetcher is safe: session_host.go
@@ -534,22 +731,7 @@ func New(cfg *config.Config) (*Server, error) {
 	}
 
 	if cfg.WorkspaceID != "" {
-		s.workspaces[cfg.WorkspaceID] = &WorkspaceRuntime{
-			ID:                  cfg.WorkspaceID,
-			Repository:          strings.TrimSpace(cfg.Repository),
-			Branch:              strings.TrimSpace(cfg.Branch),
-			Status:              "running",
-			CreatedAt:           time.Now().UTC(),
-			UpdatedAt:           time.Now().UTC(),
-			WorkspaceDir:        strings.TrimSpace(cfg.WorkspaceDir),
-			ContainerLabelValue: strings.TrimSpace(cfg.ContainerLabelValue),
-			ContainerWorkDir:    strings.TrimSpace(cfg.ContainerWorkDir),
-			ContainerUser:       strings.TrimSpace(cfg.ContainerUser),
-			CallbackToken:       strings.TrimSpace(cfg.CallbackToken),
-			ProjectID:           strings.TrimSpace(cfg.ProjectID),
-			Lightweight:         cfg.IsStandaloneMode(),
-			PTY:                 ptyManager,
-		}
+		s.workspaces.PutBoot(cfg.WorkspaceID, s.bootWorkspaceRuntime())
 	}
 
 	// Setup routes
@@ -571,10 +753,35 @@ func New(cfg *config.Config) (*Server, error) {
 	return s, nil
 }
 
+// bootWorkspaceRuntime describes the workspace the node was booted (or
+// assigned) for, from the node config.
+func (s *Server) bootWorkspaceRuntime() *WorkspaceRuntime {
+	cfg := s.config
+	return &WorkspaceRuntime{
+		ID:                  cfg.WorkspaceID,
+		Repository:          strings.TrimSpace(cfg.Repository),
+		Branch:              strings.TrimSpace(cfg.Branch),
+		Status:              "running",
+		CreatedAt:           time.Now().UTC(),
+		UpdatedAt:           time.Now().UTC(),
+		WorkspaceDir:        strings.TrimSpace(cfg.WorkspaceDir),
+		ContainerLabelValue: strings.TrimSpace(cfg.ContainerLabelValue),
+		ContainerWorkDir:    strings.TrimSpace(cfg.ContainerWorkDir),
+		ContainerUser:       strings.TrimSpace(cfg.ContainerUser),
+		CallbackToken:       strings.TrimSpace(cfg.CallbackToken),
+		ProjectID:           strings.TrimSpace(cfg.ProjectID),
+		Lightweight:         cfg.IsStandaloneMode(),
+		PTY:                 s.ptyManager,
+	}
+}
+
 // SetBootLog wires a boot-log reporter into the ACP gateway config so that
 // agent errors (crashes, stderr) are reported to the control plane.
 func (s *Server) SetBootLog(reporter acp.BootLogReporter) {
 	s.acpConfig.BootLog = reporter
+	if sink, ok := reporter.(queuedCallbackSink); ok {
+		s.trackCallbackQueue("", sink)
+	}
 }
 
 // SetDeployEngine wires the deployment engine into the server for heartbeat reporting
@@ -716,11 +923,16 @@ func (s *Server) GetEventStore() *eventstore.Store {
 	return s.eventStore
 }
 
+// BootLogSinks returns the operator-configured boot-log sinks, for wiring into
+// reporters created outside the server.
+func (s *Server) BootLogSinks() []bootlog.Sink {
+	return s.bootLogSinks
+}
+
+// GetBootLogBroadcaster returns the broadcaster of the boot workspace, or nil
+// when the node has none.
 func (s *Server) GetBootLogBroadcaster() *BootLogBroadcaster {
-	if s.config.WorkspaceID == "" || s.bootLogBroadcasters == nil {
-		return nil
-	}
-	return s.bootLogBroadcasters.GetOrCreate(s.config.WorkspaceID)
+	return s.GetBootLogBroadcasterForWorkspace(s.workspaces.BootID())
 }
 
 // GetBootLogBroadcasterForWorkspace returns the broadcaster for a specific workspace ID.
@@ -768,23 +980,31 @@ func (s *Server) UpdateAfterBootstrap(cfg *config.Config) {
 		slog.Info("Propagated bootstrap container user", "user", detectedUser)
 	}
 
-	// Update workspace runtime with the callback token.
-	s.workspaceMu.Lock()
-	if ws, ok := s.workspaces[cfg.WorkspaceID]; ok {
-		ws.CallbackToken = cfg.CallbackToken
+	// Update workspace runtime with the callback token and the hooks
+	// redeemed with the bootstrap token, so the drain runs preShutdown.
+	hooks, err := bootstrap.StateHooks(cfg)
+	if err != nil {
+		slog.Warn("Failed to load lifecycle hooks from bootstrap state", "error", err)
 	}
-	s.workspaceMu.Unlock()
+	s.workspaces.Update(cfg.WorkspaceID, func(ws *WorkspaceRuntime) {
+		ws.CallbackToken = cfg.CallbackToken
+		if hooks != nil {
+			ws.Hooks = hooks
+		}
+	})
 
 	s.bootstrapComplete.Store(true)
 
 	// Start port scanner for the boot-time workspace now that the container is available.
 	if cfg.WorkspaceID != "" {
 		s.StartPortScanner(cfg.WorkspaceID)
+		s.startWarmStandby(cfg.WorkspaceID)
+		go s.loadRepoSchedule(cfg.WorkspaceID)
 	}
 
 	// Notify WebSocket clients that bootstrap is complete.
-	if s.config.WorkspaceID != "" {
-		if broadcaster := s.bootLogBroadcasters.Get(s.config.WorkspaceID); broadcaster != nil {
+	if bootID := s.workspaces.BootID(); bootID != "" {
+		if broadcaster := s.bootLogBroadcasters.Get(bootID); broadcaster != nil {
 			broadcaster.MarkComplete()
 		}
 	}
@@ -803,8 +1023,10 @@ func (s *Server) StartPortScanner(workspaceID string) {
 	// the workspace runtime has the correct value (e.g., "/workspace/repo-name")
 	// derived from the repository passed during workspace creation.
 	var wsDiscovery *container.Discovery
-	s.workspaceMu.RLock()
-	if runtime, ok := s.workspaces[workspaceID]; ok && runtime.ContainerLabelValue != "" {
+	s.workspaces.View(workspaceID, func(runtime *WorkspaceRuntime) {
+		if runtime.ContainerLabelValue == "" {
+			return
+		}
 		wsDiscovery = container.NewDiscovery(container.Config{
 			LabelKey:    s.config.ContainerLabelKey,
 			LabelValue:  runtime.ContainerLabelValue,
@@ -814,8 +1036,7 @@ func (s *Server) StartPortScanner(workspaceID string) {
 		slog.Info("Port scanner: using workspace-specific container label",
 			"workspaceId", workspaceID,
 			"labelValue", runtime.ContainerLabelValue)
-	}
-	s.workspaceMu.RUnlock()
+	})
 
 	// Fall back to server-level discovery if no workspace-specific one is available.
 	if wsDiscovery == nil {
@@ -919,33 +1140,53 @@ func (s *Server) stopAllPortScanners() {
 
 // Start starts the HTTP server (plain HTTP or TLS based on config).
 func (s *Server) Start() error {
+	// After a self-update re-exec this adopts the previous process's listener.
+	ln, err := selfupdate.Listen(s.httpServer.Addr)
+	if err != nil {
+		return err
+	}
+	s.listener = ln
+
 	s.startNodeHealthReporter()
 	s.startAcpHeartbeatReporter()
+	s.startAccessAuditShipper()
+	s.startExecAuditShipper()
+	s.startEgressPolicyRefresher()
+	s.startSecretFilesWatcher()
+	s.startRetentionPurger()
+	s.startRetentionReceiptShipper()
+	s.startScheduler()
+	s.startRepoMirrorMaintenance()
+	s.startDiskMonitor()
+	s.startFileChangeWatcher()
+	s.startSSHServer()
+	s.startControlSocket()
+	s.startSelfUpdater()
 
 	// Start error reporter background flush
 	s.errorReporter.Start()
 
 	if s.config.TLSEnabled {
 		slog.Info("Starting VM Agent with TLS", "addr", s.httpServer.Addr, "cert", s.config.TLSCertPath, "key", s.config.TLSKeyPath)
-		return s.httpServer.ListenAndServeTLS(s.config.TLSCertPath, s.config.TLSKeyPath)
+		return s.httpServer.ServeTLS(ln, s.config.TLSCertPath, s.config.TLSKeyPath)
 	}
 
 	slog.Info("Starting VM Agent", "addr", s.httpServer.Addr)
-	return s.httpServer.ListenAndServe()
+	return s.httpServer.Serve(ln)
 }
 
 // StopAllWorkspacesAndSessions transitions all local workloads to stopped state.
 // This is invoked during node shutdown to ensure no child workloads are left active.
 func (s *Server) StopAllWorkspacesAndSessions() {
-	s.workspaceMu.Lock()
-	workspaceIDs := make([]string, 0, len(s.workspaces))
-	for id, runtime := range s.workspaces {
+	s.NotifyLifecycle(lifecyclehook.EventShutdownRequested, "", nil)
+
+	var workspaceIDs []string
+	s.workspaces.UpdateAll(func(id string, runtime *WorkspaceRuntime) {
 		runtime.PTY.CloseAllSessions()
 		runtime.Status = "stopped"
 		runtime.UpdatedAt = nowUTC()
 		workspaceIDs = append(workspaceIDs, id)
-	}
-	s.workspaceMu.Unlock()
+	})
 
 	for _, workspaceID := range workspaceIDs {
 		if s.agentSessions != nil {
@@ -968,6 +1209,14 @@ func (s *Server) Stop(ctx context.Context) error {
 	// Signal background goroutines to stop.
 	close(s.done)
 
+	// Close SSH listener and connections
+	if s.sshServer != nil {
+		if err := s.sshServer.Close(); err != nil {
+			slog.Warn("Failed to close SSH server", "error", err)
+		}
+	}
+	s.stopControlSocket()
+
 	// Stop all port scanners
 	s.stopAllPortScanners()
 
@@ -981,21 +1230,57 @@ func (s *Server) Stop(ctx context.Context) error {
 		}
 		delete(s.sessionHosts, key)
 	}
+	for workspaceID := range s.warmStandbyHosts {
+		s.stopWarmStandbyLocked(workspaceID)
+	}
 	s.sessionHostMu.Unlock()
 
 	// Close all workspace PTY sessions.
-	s.workspaceMu.Lock()
-	for _, runtime := range s.workspaces {
+	s.workspaces.UpdateAll(func(_ string, runtime *WorkspaceRuntime) {
 		runtime.PTY.CloseAllSessions()
-	}
-	s.workspaceMu.Unlock()
+	})
 
 	// Flush and stop error reporter
 	s.errorReporter.Shutdown()
 
+	// Attempt final delivery of queued lifecycle webhook events.
+	s.lifecycleNotifier.Shutdown()
+
 	// Flush and stop all per-workspace message reporters
 	s.shutdownAllReporters()
 
+	// Deliver queued webhook entries and close boot-log sinks
+	bootlog.CloseSinks(s.bootLogSinks)
+
+	// Close workspace notes store
+	if s.notesStore != nil {
+		if err := s.notesStore.Close(); err != nil {
+			slog.Warn("Failed to close workspace notes store", "error", err)
+		}
+	}
+
+	// Close access audit store
+	if s.accessAudit != nil {
+		if err := s.accessAudit.Close(); err != nil {
+			slog.Warn("Failed to close access audit store", "error", err)
+		}
+	}
+
+	// Close exec audit store
+	if s.execAudit != nil {
+		execaudit.SetDefault(nil)
+		if err := s.execAudit.Close(); err != nil {
+			slog.Warn("Failed to close exec audit store", "error", err)
+		}
+	}
+
+	// Close retention store
+	if s.retentionStore != nil {
+		if err := s.retentionStore.Close(); err != nil {
+			slog.Warn("Failed to close retention store", "error", err)
+		}
+	}
+
 	// Close persistence store
 	if s.store != nil {
 		if err := s.store.Close(); err != nil {
@@ -1020,11 +1305,26 @@ func (s *Server) setupRoutes(mux *http.ServeMux) {
 	// Node/workspace management routes (control-plane authenticated).
 	mux.HandleFunc("GET /workspaces", s.handleListWorkspaces)
 	mux.HandleFunc("POST /workspaces", s.handleCreateWorkspace)
+	mux.HandleFunc("GET /provisioning-spec/schema", s.handleProvisioningSpecSchema)
+	mux.HandleFunc("POST /provisioning-spec/validate", s.handleValidateProvisioningSpec)
+	mux.HandleFunc("POST /drain", s.handleDrain)
+	mux.HandleFunc("POST /config", s.handleReloadConfig)
+	mux.HandleFunc("POST /assign", s.handleAssign)
 	mux.HandleFunc("POST /deployment/environments/{environmentId}/teardown", s.handleTeardownDeploymentEnvironment)
 	mux.HandleFunc("GET /workspaces/{workspaceId}/events", s.handleListWorkspaceEvents)
+	mux.HandleFunc("GET /workspaces/{workspaceId}/access-audit", s.handleListAccessAudit)
+	mux.HandleFunc("GET /workspaces/{workspaceId}/exec-audit", s.handleListExecAudit)
+	mux.HandleFunc("GET /workspaces/{workspaceId}/resources", s.handleGetWorkspaceResources)
+	mux.HandleFunc("GET /workspaces/{workspaceId}/retention-policy", s.handleGetRetentionPolicy)
+	mux.HandleFunc("PUT /workspaces/{workspaceId}/retention-policy", s.handlePutRetentionPolicy)
+	mux.HandleFunc("DELETE /workspaces/{workspaceId}/retention-policy", s.handleDeleteRetentionPolicy)
+	mux.HandleFunc("POST /workspaces/{workspaceId}/retention/purge", s.handleRunRetention)
+	mux.HandleFunc("GET /workspaces/{workspaceId}/retention/receipts", s.handleListRetentionReceipts)
 	mux.HandleFunc("POST /workspaces/{workspaceId}/stop", s.handleStopWorkspace)
 	mux.HandleFunc("POST /workspaces/{workspaceId}/restart", s.handleRestartWorkspace)
 	mux.HandleFunc("POST /workspaces/{workspaceId}/rebuild", s.handleRebuildWorkspace)
+	mux.HandleFunc("POST /workspaces/{workspaceId}/devcontainer/rebuild", s.handleRebuildDevcontainer)
+	mux.HandleFunc("POST /workspaces/{workspaceId}/snapshot", s.handleSnapshotWorkspace)
 	mux.HandleFunc("DELETE /workspaces/{workspaceId}", s.handleDeleteWorkspace)
 	mux.HandleFunc("GET /workspaces/{workspaceId}/agent-sessions", s.handleListAgentSessions)
 	mux.HandleFunc("POST /workspaces/{workspaceId}/agent-sessions", s.handleCreateAgentSession)
@@ -1036,20 +1336,50 @@ func (s *Server) setupRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("POST /workspaces/{workspaceId}/agent-sessions/{sessionId}/prompt", s.handleSendPrompt)
 	mux.HandleFunc("POST /workspaces/{workspaceId}/agent-sessions/{sessionId}/hibernate", s.handleHibernateAgentSession)
 	mux.HandleFunc("POST /workspaces/{workspaceId}/agent-sessions/{sessionId}/restore", s.handleRestoreAgentSession)
+	mux.HandleFunc("GET /workspaces/{workspaceId}/agent-sessions/{sessionId}/transcript", s.handleSessionTranscript)
+	mux.HandleFunc("GET /workspaces/{workspaceId}/agent-sessions/{sessionId}/checkpoints", s.handleListCheckpoints)
+	mux.HandleFunc("POST /workspaces/{workspaceId}/agent-sessions/{sessionId}/rollback", s.handleRollbackCheckpoint)
 	mux.HandleFunc("GET /workspaces/{workspaceId}/tabs", s.handleListTabs)
+	mux.HandleFunc("GET /workspaces/{workspaceId}/announcements", s.handleListAnnouncements)
+	mux.HandleFunc("POST /workspaces/{workspaceId}/announcements", s.handleCreateAnnouncement)
+	mux.HandleFunc("DELETE /workspaces/{workspaceId}/announcements/{announcementId}", s.handleWithdrawAnnouncement)
+	mux.HandleFunc("GET /workspaces/{workspaceId}/notes", s.handleListNotes)
+	mux.HandleFunc("POST /workspaces/{workspaceId}/notes", s.handleCreateNote)
+	mux.HandleFunc("PATCH /workspaces/{workspaceId}/notes/{noteId}", s.handleUpdateNote)
+	mux.HandleFunc("DELETE /workspaces/{workspaceId}/notes/{noteId}", s.handleDeleteNote)
+	mux.HandleFunc("GET /workspaces/{workspaceId}/jobs", s.handleListJobs)
+	mux.HandleFunc("POST /workspaces/{workspaceId}/jobs", s.handleCreateJob)
+	mux.HandleFunc("GET /workspaces/{workspaceId}/jobs/{jobId}/logs", s.handleJobLogs)
+	mux.HandleFunc("DELETE /workspaces/{workspaceId}/jobs/{jobId}", s.handleKillJob)
+	mux.HandleFunc("GET /workspaces/{workspaceId}/schedule", s.handleGetSchedule)
+	mux.HandleFunc("PUT /workspaces/{workspaceId}/schedule", s.handlePutSchedule)
+	mux.HandleFunc("PUT /workspaces/{workspaceId}/env", s.handlePutProjectEnv)
+	mux.HandleFunc("DELETE /workspaces/{workspaceId}/env/{key}", s.handleDeleteProjectEnv)
+	mux.HandleFunc("POST /workspaces/{workspaceId}/schedule/reload", s.handleReloadSchedule)
 
 	// Git integration (browser-authenticated via workspace session/token)
 	mux.HandleFunc("GET /workspaces/{workspaceId}/git/status", s.handleGitStatus)
 	mux.HandleFunc("GET /workspaces/{workspaceId}/git/diff", s.handleGitDiff)
 	mux.HandleFunc("GET /workspaces/{workspaceId}/git/file", s.handleGitFile)
+	mux.HandleFunc("GET /workspaces/{workspaceId}/git/log", s.handleGitLog)
+	mux.HandleFunc("GET /workspaces/{workspaceId}/git/blame", s.handleGitBlame)
+	mux.HandleFunc("GET /workspaces/{workspaceId}/git/show/{sha}", s.handleGitShow)
 	mux.HandleFunc("GET /workspaces/{workspaceId}/git/branches", s.handleGitBranches)
+	mux.HandleFunc("POST /workspaces/{workspaceId}/git/checkout", s.handleGitCheckout)
+	mux.HandleFunc("POST /workspaces/{workspaceId}/git/pull-request", s.handleGitPullRequest)
+	mux.HandleFunc("GET /workspaces/{workspaceId}/git/remotes", s.handleListGitRemotes)
+	mux.HandleFunc("POST /workspaces/{workspaceId}/git/remotes", s.handleAddGitRemote)
+	mux.HandleFunc("DELETE /workspaces/{workspaceId}/git/remotes/{name}", s.handleRemoveGitRemote)
 
 	// File browser (browser-authenticated via workspace session/token)
-	mux.HandleFunc("GET /workspaces/{workspaceId}/files/list", s.handleFileList)
-	mux.HandleFunc("GET /workspaces/{workspaceId}/files/find", s.handleFileFind)
-	mux.HandleFunc("GET /workspaces/{workspaceId}/files/raw", s.handleFileRaw)
-	mux.HandleFunc("POST /workspaces/{workspaceId}/files/upload", s.handleFileUpload)
-	mux.HandleFunc("GET /workspaces/{workspaceId}/files/download", s.handleFileDownload)
+	mux.HandleFunc("GET /workspaces/{workspaceId}/files/list", s.withWorkspaceActivity(activity.SourceFiles, s.handleFileList))
+	mux.HandleFunc("GET /workspaces/{workspaceId}/files/find", s.withWorkspaceActivity(activity.SourceFiles, s.handleFileFind))
+	mux.HandleFunc("GET /workspaces/{workspaceId}/search", s.withWorkspaceActivity(activity.SourceFiles, s.handleSearch))
+	mux.HandleFunc("GET /workspaces/{workspaceId}/files/raw", s.withWorkspaceActivity(activity.SourceFiles, s.handleFileRaw))
+	mux.HandleFunc("POST /workspaces/{workspaceId}/files/upload", s.withWorkspaceActivity(activity.SourceFiles, s.handleFileUpload))
+	mux.HandleFunc("GET /workspaces/{workspaceId}/files/download", s.withWorkspaceActivity(activity.SourceFiles, s.handleFileDownload))
+	mux.HandleFunc("PUT /workspaces/{workspaceId}/files", s.withWorkspaceActivity(activity.SourceFiles, s.handleFileWrite))
+	mux.HandleFunc("DELETE /workspaces/{workspaceId}/files", s.withWorkspaceActivity(activity.SourceFiles, s.handleFileDelete))
 	mux.HandleFunc("GET /workspaces/{workspaceId}/worktrees", s.handleListWorktrees)
 	mux.HandleFunc("POST /workspaces/{workspaceId}/worktrees", s.handleCreateWorktree)
 	mux.HandleFunc("DELETE /workspaces/{workspaceId}/worktrees", s.handleRemoveWorktree)
@@ -1057,6 +1387,7 @@ func (s *Server) setupRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("GET /debug-package", s.handleDebugPackage)
 	mux.HandleFunc("GET /events", s.handleListNodeEvents)
 	mux.HandleFunc("GET /events/export", s.handleExportEvents)
+	mux.HandleFunc("GET /metrics", s.handleMetrics)
 	mux.HandleFunc("GET /metrics/export", s.handleExportMetrics)
 	mux.HandleFunc("GET /system-info", s.handleSystemInfo)
 	mux.HandleFunc("GET /logs", s.handleLogs)
@@ -1083,6 +1414,13 @@ func (s *Server) setupRoutes(mux *http.ServeMux) {
 	// ACP Agent WebSocket
 	mux.HandleFunc("GET /agent/ws", s.handleAgentWS)
 	mux.HandleFunc("GET /git-credential", s.handleGitCredential)
+	mux.HandleFunc("POST /acp/prompt", s.handleHeadlessPrompt)
+
+	// ACP long-poll/SSE fallback for networks that block WebSockets
+	mux.HandleFunc("POST /agent/poll", s.handleAgentPollAttach)
+	mux.HandleFunc("GET /agent/poll/{viewerId}", s.handleAgentPoll)
+	mux.HandleFunc("POST /agent/poll/{viewerId}/messages", s.handleAgentPollMessage)
+	mux.HandleFunc("DELETE /agent/poll/{viewerId}", s.handleAgentPollDetach)
 }
 
 // corsMiddleware adds CORS headers to responses.
@@ -1522,6 +1860,12 @@ func (s *Server) gitPushWorkspaceChanges(workspaceID string, skipPR bool) gitPus
 		result.Error = fmt.Sprintf("resolve container: %s", err)
 		return result
 	}
+	release, err := s.lockWorkspaceGit(context.Background(), workspaceID, containerID, user, workDir, "auto-push")
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer release()
 
 	// Check for uncommitted changes
 	statusOutput, err := s.runWorkspaceGitCommand(containerID, workDir, user, "status", "--porcelain")
@@ -1588,8 +1932,8 @@ func (s *Server) tryCreateReviewRequest(workspaceID, containerID, workDir, user,
 		switch strings.ToLower(strings.TrimSpace(runtime.RepoProvider)) {
 		case "gitlab":
 			return s.tryCreateGitLabMergeRequest(runtime, sourceBranch)
-		case "artifacts":
-			slog.Info("Review request creation skipped for Artifacts workspace", "workspaceID", workspaceID)
+		case "artifacts", "bitbucket":
+			slog.Info("Review request creation skipped for workspace provider", "workspaceID", workspaceID, "provider", runtime.RepoProvider)
 			return "", 0
 		}
 	}
@@ -1617,7 +1961,7 @@ func (s *Server) tryCreateGitLabMergeRequest(runtime *WorkspaceRuntime, sourceBr
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	resp, err := s.fetchGitTokenResponseForWorkspace(ctx, runtime.ID, "")
+	resp, err := s.fetchGitTokenResponseForWorkspace(ctx, runtime.ID, "", gitTokenPurposePullRequest)
 	if err != nil {
 		slog.Warn("GitLab MR creation skipped: token fetch failed", "workspaceID", runtime.ID, "error", err)
 		return "", 0
@@ -18,6 +18,7 @@ import (
 	"time"
 
 	"github.com/workspace/vm-agent/internal/acp"
+	"github.com/workspace/vm-agent/internal/notes"
 )
 
 const (
@@ -66,6 +67,7 @@ type snapshotManifest struct {
 	Degradation    string                      `json:"degradation"`
 	Skipped        []snapshotSkippedEntry      `json:"skipped"`
 	Artifacts      map[string]snapshotArtifact `json:"artifacts"`
+	Notes          []notes.Note                `json:"notes,omitempty"`
 	CreatedAt      string                      `json:"createdAt"`
 }
 
@@ -208,6 +210,7 @@ func (s *Server) hibernateSessionSnapshot(ctx context.Context, runtime *Workspac
 		Degradation:    "none",
 		Skipped:        []snapshotSkippedEntry{},
 		Artifacts:      map[string]snapshotArtifact{},
+		Notes:          s.workspaceNotesForArchive(runtime.ID),
 		CreatedAt:      time.Now().UTC().Format(time.RFC3339),
 	}
 	workDir := standaloneWorkspaceWorkDir(runtime, s.config.WorkspaceDir, s.config.ContainerWorkDir)
@@ -275,6 +278,7 @@ func (s *Server) restoreSessionSnapshot(ctx context.Context, runtime *WorkspaceR
 		return map[string]interface{}{"status": "transcript-replay", "reason": restore.Reason}, nil
 	}
 	idleTimeout := choosePositiveDurationMs(restore.Config.TransferIdleTimeoutMs, defaultSnapshotTransferIdleTimeout)
+	s.restoreWorkspaceNotes(runtime.ID, restore.Manifest)
 	if restore.Download.Home != "" {
 		if err := s.downloadAndExtractTar(ctx, restore.Download.Home, callbackToken, idleTimeout); err != nil {
 			_ = s.reportSnapshotRestoreResult(ctx, runtime.ID, chatSessionID, "home_failed", err.Error(), callbackToken)
@@ -316,14 +320,14 @@ func (s *Server) restoreSessionSnapshot(ctx context.Context, runtime *WorkspaceR
 			return nil, fmt.Errorf("recreate restored agent session: %w", createErr)
 		}
 		hostKey := runtime.ID + ":" + sessionID
-		host := s.getOrCreateSessionHost(hostKey, runtime.ID, sessionID, session, runtime, "")
+		host := s.getOrCreateSessionHost(hostKey, runtime.ID, sessionID, session, runtime, "", "")
 		host.SelectAgent(ctx, agentType)
 		if host.Status() != acp.HostReady {
 			return nil, fmt.Errorf("restored agent failed to become ready: %s", host.Status())
 		}
 	} else if session, exists := s.agentSessions.Get(runtime.ID, sessionID); exists {
 		hostKey := runtime.ID + ":" + sessionID
-		_ = s.getOrCreateSessionHost(hostKey, runtime.ID, sessionID, session, runtime, "")
+		_ = s.getOrCreateSessionHost(hostKey, runtime.ID, sessionID, session, runtime, "", "")
 	}
 	_ = s.reportSnapshotRestoreResult(ctx, runtime.ID, chatSessionID, "restored", "", callbackToken)
 	return map[string]interface{}{"status": "restored", "degradation": restore.Degradation}, nil
@@ -351,11 +355,11 @@ func (s *Server) primeRestoredMessageReporter(runtime *WorkspaceRuntime, chatSes
 			"workspaceId", runtime.ID, "hasProjectID", projectID != "", "hasChatSessionID", chatSessionID != "")
 		return
 	}
-	s.workspaceMu.Lock()
-	if rt, ok := s.workspaces[runtime.ID]; ok && strings.TrimSpace(rt.ProjectID) == "" {
-		rt.ProjectID = projectID
-	}
-	s.workspaceMu.Unlock()
+	s.workspaces.Update(runtime.ID, func(rt *WorkspaceRuntime) {
+		if strings.TrimSpace(rt.ProjectID) == "" {
+			rt.ProjectID = projectID
+		}
+	})
 	if reporter := s.getOrCreateReporter(runtime.ID, projectID, chatSessionID); reporter != nil {
 		reporter.SetSessionID(chatSessionID)
 	}
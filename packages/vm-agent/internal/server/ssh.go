@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+
+	"github.com/workspace/vm-agent/internal/accessaudit"
+	"github.com/workspace/vm-agent/internal/config"
+	"github.com/workspace/vm-agent/internal/sshserver"
+)
+
+// sshAccessSessionID is the access audit session ID recorded for SSH
+// connections, which are not tied to an agent session.
+const sshAccessSessionID = "ssh"
+
+// newSSHServer creates the embedded SSH server, or returns nil when it is
+// disabled or misconfigured.
+func (s *Server) newSSHServer(cfg *config.Config) *sshserver.Server {
+	if !cfg.SSHServerEnabled {
+		return nil
+	}
+	caKeys, err := sshserver.ParseAuthorizedKeys(cfg.SSHUserCAKeys)
+	if err != nil {
+		slog.Error("Invalid SSH_USER_CA_KEYS; SSH server disabled", "error", err)
+		return nil
+	}
+	srv, err := sshserver.New(sshserver.Config{
+		HostKeyPath:     cfg.SSHHostKeyPath,
+		UserCAKeys:      caKeys,
+		MaxCertLifetime: cfg.SSHMaxCertLifetime,
+		Shell:           cfg.DefaultShell,
+		Resolve:         s.resolveSSHTarget,
+		OnConnect:       s.onSSHConnect,
+	})
+	if err != nil {
+		slog.Error("Failed to create SSH server; SSH access disabled", "error", err)
+		return nil
+	}
+	return srv
+}
+
+// startSSHServer serves SSH in the background until Stop.
+func (s *Server) startSSHServer() {
+	if s.sshServer == nil {
+		return
+	}
+	addr := s.config.SSHListenAddr
+	go func() {
+		slog.Info("Starting SSH server", "addr", addr)
+		if err := s.sshServer.ListenAndServe(addr); err != nil {
+			slog.Error("SSH server stopped", "addr", addr, "error", err)
+		}
+	}()
+}
+
+// resolveSSHTarget maps an SSH login (the workspace ID) to the workspace's
+// devcontainer and container user.
+func (s *Server) resolveSSHTarget(workspaceID string) (sshserver.Target, error) {
+	containerID, workDir, user, err := s.resolveContainerForWorkspace(workspaceID)
+	if err != nil {
+		return sshserver.Target{}, err
+	}
+	return sshserver.Target{
+		ContainerID: containerID,
+		User:        user,
+		WorkDir:     workDir,
+		Dial: func(ctx context.Context, port int) (net.Conn, error) {
+			return s.dialWorkspacePort(ctx, workspaceID, port)
+		},
+	}, nil
+}
+
+// dialWorkspacePort connects to a port inside the workspace via the
+// container bridge IP, or loopback when not in container mode.
+func (s *Server) dialWorkspacePort(ctx context.Context, workspaceID string, port int) (net.Conn, error) {
+	host := "127.0.0.1"
+	if discovery := s.workspaceDiscovery(workspaceID); discovery != nil {
+		bridgeIP, err := discovery.GetBridgeIP()
+		if err != nil {
+			return nil, fmt.Errorf("container bridge IP not available: %w", err)
+		}
+		host = bridgeIP
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+}
+
+// onSSHConnect records an SSH connection in the access audit and event log
+// and returns the matching disconnect hook.
+func (s *Server) onSSHConnect(info sshserver.ConnInfo) func() {
+	remote := info.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	userAgent := info.ClientVersion
+	if len(userAgent) > maxAccessAuditUserAgentLength {
+		userAgent = userAgent[:maxAccessAuditUserAgentLength]
+	}
+
+	accessID := ""
+	if s.accessAudit != nil {
+		entry, err := s.accessAudit.Attach(accessaudit.Entry{
+			WorkspaceID: info.WorkspaceID,
+			SessionID:   sshAccessSessionID,
+			ViewerID:    info.ID,
+			Subject:     info.KeyID,
+			IP:          remote,
+			RemoteAddr:  remote,
+			UserAgent:   userAgent,
+		})
+		if err != nil {
+			slog.Warn("Failed to record SSH connection in access audit", "workspace", info.WorkspaceID, "error", err)
+		} else {
+			accessID = entry.ID
+		}
+	}
+	s.appendNodeEvent(info.WorkspaceID, "info", "ssh.connected", "SSH connection established", map[string]interface{}{
+		"keyId":      info.KeyID,
+		"remoteAddr": remote,
+	})
+
+	return func() {
+		s.auditViewerDetach(accessID)
+		s.appendNodeEvent(info.WorkspaceID, "info", "ssh.disconnected", "SSH connection closed", map[string]interface{}{
+			"keyId": info.KeyID,
+		})
+	}
+}
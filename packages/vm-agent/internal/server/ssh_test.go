@@ -0,0 +1,66 @@
+package server
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/workspace/vm-agent/internal/accessaudit"
+	"github.com/workspace/vm-agent/internal/sshserver"
+)
+
+func TestNewSSHServerRequiresEnabledAndValidCAKeys(t *testing.T) {
+	s := newWorkspaceCreateServer(t, "", nil)
+	s.config.SSHHostKeyPath = filepath.Join(t.TempDir(), "ssh_host_ed25519_key")
+
+	if s.newSSHServer(s.config) != nil {
+		t.Fatal("expected nil SSH server when disabled")
+	}
+	s.config.SSHServerEnabled = true
+	if s.newSSHServer(s.config) != nil {
+		t.Fatal("expected nil SSH server without CA keys")
+	}
+	s.config.SSHUserCAKeys = "not-a-key"
+	if s.newSSHServer(s.config) != nil {
+		t.Fatal("expected nil SSH server with invalid CA keys")
+	}
+	s.config.SSHUserCAKeys = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGB1rQ2n6TVZ0d9bU3FvWQmZlCq5nyjq0kQd7f9XJ5yL sam-ca\n"
+	if s.newSSHServer(s.config) == nil {
+		t.Fatal("expected SSH server with a valid CA key")
+	}
+}
+
+func TestSSHConnectionsAreAuditedAndRequireKnownWorkspace(t *testing.T) {
+	s := newWorkspaceCreateServer(t, "", nil)
+	store, err := accessaudit.New(filepath.Join(t.TempDir(), "access-audit.db"), 0)
+	if err != nil {
+		t.Fatalf("accessaudit.New: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	s.accessAudit = store
+
+	if _, err := s.resolveSSHTarget("ws-missing"); err == nil {
+		t.Fatal("expected unknown workspace to be rejected")
+	}
+
+	onClose := s.onSSHConnect(sshserver.ConnInfo{
+		ID:            "conn-1",
+		WorkspaceID:   "ws-1",
+		KeyID:         "user-1",
+		RemoteAddr:    "203.0.113.9:51234",
+		ClientVersion: "SSH-2.0-OpenSSH_9.6",
+	})
+	entries, err := store.List(accessaudit.Query{WorkspaceID: "ws-1"})
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("entries = %+v, err = %v", entries, err)
+	}
+	got := entries[0]
+	if got.SessionID != sshAccessSessionID || got.Subject != "user-1" || got.IP != "203.0.113.9" || got.DetachedAt != "" {
+		t.Fatalf("attach entry = %+v", got)
+	}
+
+	onClose()
+	entries, _ = store.List(accessaudit.Query{WorkspaceID: "ws-1"})
+	if len(entries) != 1 || entries[0].DetachedAt == "" {
+		t.Fatalf("expected entry closed on disconnect, got %+v", entries)
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/workspace/vm-agent/internal/bootstrap"
 )
 
 // standaloneGitCredentialHelperPath is where the standalone git credential
@@ -21,7 +23,9 @@ const standaloneGitBinaryPath = "/usr/bin/git"
 //
 // GitHub keeps the fast path: the per-session GH_TOKEN is injected into the
 // agent process environment (see resolveAgentEnvVars), and git credential
-// helpers inherit that environment.
+// helpers inherit that environment. GH_TOKEN is the pull request token, so a
+// push first asks the local /git-credential endpoint for the push token and
+// uses GH_TOKEN only when that exchange returns nothing.
 //
 // GitLab cannot use GH_TOKEN. It needs a fresh, path-bound token exchange through
 // the local vm-agent /git-credential endpoint. The endpoint performs the
@@ -37,18 +41,7 @@ while IFS= read -r line; do
     path=*) path="${line#path=}" ;;
   esac
 done
-case "$host" in
-  github.com|api.github.com)
-    [ -n "${GH_TOKEN:-}" ] || exit 0
-    printf 'username=x-access-token\npassword=%s\n' "$GH_TOKEN"
-    exit 0
-    ;;
-esac
-
-[ -n "$host" ] || exit 0
-[ -n "$path" ] || exit 0
-[ -n "${SAM_WORKSPACE_ID:-}" ] || exit 0
-
+{{ capability_detection }}
 url_encode_query_value() {
   printf '%s' "$1" | sed 's/%/%25/g; s/&/%26/g; s/=/%3D/g; s/?/%3F/g; s/#/%23/g; s/+/%2B/g; s/ /%20/g'
 }
@@ -59,12 +52,34 @@ if [ -z "$endpoint" ]; then
   endpoint="http://127.0.0.1:${port}/git-credential"
 fi
 
-workspace_id=$(url_encode_query_value "$SAM_WORKSPACE_ID")
-encoded_host=$(url_encode_query_value "$host")
-encoded_path=$(url_encode_query_value "$path")
+exchange() {
+  [ -n "${SAM_WORKSPACE_ID:-}" ] || return 0
+  query="workspaceId=$(url_encode_query_value "$SAM_WORKSPACE_ID")&host=$(url_encode_query_value "$host")"
+  if [ -n "$path" ]; then
+    query="${query}&path=$(url_encode_query_value "$path")"
+  fi
+  query="${query}&capability=$(url_encode_query_value "$capability")"
+  curl -fsS --max-time {{ credential_timeout_seconds }} "${endpoint}?${query}" 2>/dev/null || true
+}
 
-curl -fsS --max-time {{ credential_timeout_seconds }} \
-  "${endpoint}?workspaceId=${workspace_id}&host=${encoded_host}&path=${encoded_path}" 2>/dev/null || true
+case "$host" in
+  github.com|api.github.com)
+    if [ "$capability" = push ]; then
+      creds=$(exchange)
+      if [ -n "$creds" ]; then
+        printf '%s\n' "$creds"
+        exit 0
+      fi
+    fi
+    [ -n "${GH_TOKEN:-}" ] || exit 0
+    printf 'username=x-access-token\npassword=%s\n' "$GH_TOKEN"
+    exit 0
+    ;;
+esac
+
+[ -n "$host" ] || exit 0
+[ -n "$path" ] || exit 0
+exchange
 `
 
 func renderStandaloneGitCredentialHelperScript(timeout time.Duration) (string, error) {
@@ -72,11 +87,10 @@ func renderStandaloneGitCredentialHelperScript(timeout time.Duration) (string, e
 		return "", fmt.Errorf("invalid git credential timeout: %s", timeout)
 	}
 	timeoutSeconds := strconv.FormatFloat(timeout.Seconds(), 'f', -1, 64)
-	return strings.ReplaceAll(
-		standaloneGitCredentialHelperScriptTemplate,
-		"{{ credential_timeout_seconds }}",
-		timeoutSeconds,
-	), nil
+	return strings.NewReplacer(
+		"{{ credential_timeout_seconds }}", timeoutSeconds,
+		"{{ capability_detection }}", bootstrap.GitCredentialCapabilityScript,
+	).Replace(standaloneGitCredentialHelperScriptTemplate), nil
 }
 
 // ConfigureStandaloneGitCredentialHelper installs a git credential helper that
@@ -137,6 +137,35 @@ func TestStandaloneGitCredentialHelperDelegatesGitLabToLocalExchange(t *testing.
 	}
 }
 
+func TestStandaloneGitCredentialHelperExchangesPushTokenForGitHub(t *testing.T) {
+	t.Parallel()
+
+	var gotCapability string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotCapability = r.URL.Query().Get("capability")
+		_, _ = w.Write([]byte("protocol=https\nhost=github.com\nusername=x-access-token\npassword=ghs_push\n\n"))
+	}))
+	t.Cleanup(server.Close)
+
+	env := map[string]string{
+		"SAM_WORKSPACE_ID":            "ws-github",
+		"SAM_GIT_CREDENTIAL_ENDPOINT": server.URL + "/git-credential",
+		"GH_TOKEN":                    "ghs_pull_request",
+		"SAM_GIT_CAPABILITY":          "push",
+	}
+	out := runStandaloneCredScriptWithEnv(t, "get", "protocol=https\nhost=github.com\n\n", env)
+	if !strings.Contains(out, "password=ghs_push") || gotCapability != "push" {
+		t.Fatalf("push creds = %q (capability %q), want exchanged push token", out, gotCapability)
+	}
+
+	env["SAM_GIT_CAPABILITY"] = "read"
+	gotCapability = ""
+	out = runStandaloneCredScriptWithEnv(t, "get", "protocol=https\nhost=github.com\n\n", env)
+	if !strings.Contains(out, "password=ghs_pull_request") || gotCapability != "" {
+		t.Fatalf("read creds = %q (exchange capability %q), want GH_TOKEN without an exchange", out, gotCapability)
+	}
+}
+
 func TestStandaloneGitCredentialHelperRequiresPathForGitLab(t *testing.T) {
 	t.Parallel()
 	called := false
@@ -72,16 +72,14 @@ func noopStandaloneCloneCredentialCleanup() {
 }
 
 func (s *Server) updateStandaloneRuntimeWorkDir(runtime *WorkspaceRuntime, workDir string) *WorkspaceRuntime {
-	s.workspaceMu.Lock()
-	defer s.workspaceMu.Unlock()
-
-	if current := s.workspaces[runtime.ID]; current != nil {
+	updated := runtime
+	s.workspaces.Update(runtime.ID, func(current *WorkspaceRuntime) {
 		current.WorkspaceDir = workDir
 		current.ContainerWorkDir = workDir
 		current.ContainerLabelValue = workDir
-		return current
-	}
-	return runtime
+		updated = current
+	})
+	return updated
 }
 
 func (s *Server) cloneStandaloneRepository(ctx context.Context, runtime *WorkspaceRuntime, workDir string) error {
@@ -214,7 +212,7 @@ func (s *Server) standaloneCloneSpec(ctx context.Context, runtime *WorkspaceRunt
 	var tokenResponse *gitTokenResponse
 
 	if callbackToken := strings.TrimSpace(runtime.CallbackToken); callbackToken != "" {
-		resp, err := s.fetchGitTokenResponseForWorkspace(ctx, runtime.ID, callbackToken)
+		resp, err := s.fetchGitTokenResponseForWorkspace(ctx, runtime.ID, callbackToken, gitTokenPurposeClone)
 		if err != nil {
 			slog.Warn("Standalone repository clone proceeding without git token", "workspace", runtime.ID, "error", err)
 		} else {
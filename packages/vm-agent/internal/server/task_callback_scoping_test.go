@@ -54,11 +54,12 @@ func runTaskCompletionCallback(t *testing.T, mode string, stopReason string, cal
 	s := &Server{
 		config:        &config.Config{HTTPCallbackTimeout: 0},
 		callbackToken: "node-token",
-		workspaces: map[string]*WorkspaceRuntime{
-			"workspace-a": {ID: "workspace-a", CallbackToken: "workspace-token-a"},
-		},
 	}
 
+	putWorkspaces(&s.workspaces, map[string]*WorkspaceRuntime{
+		"workspace-a": {ID: "workspace-a", CallbackToken: "workspace-token-a"},
+	})
+
 	callback := s.makeTaskCompletionCallback(
 		controlPlane.URL,
 		"project-1",
@@ -182,12 +183,13 @@ func TestTaskCompletionCallbacksAreBoundToTaskAndWorkspace(t *testing.T) {
 	s := &Server{
 		config:        &config.Config{HTTPCallbackTimeout: 0},
 		callbackToken: "node-token",
-		workspaces: map[string]*WorkspaceRuntime{
-			"workspace-a": {ID: "workspace-a", CallbackToken: "workspace-token-a"},
-			"workspace-b": {ID: "workspace-b", CallbackToken: "workspace-token-b"},
-		},
 	}
 
+	putWorkspaces(&s.workspaces, map[string]*WorkspaceRuntime{
+		"workspace-a": {ID: "workspace-a", CallbackToken: "workspace-token-a"},
+		"workspace-b": {ID: "workspace-b", CallbackToken: "workspace-token-b"},
+	})
+
 	callbackA := s.makeTaskCompletionCallback(controlPlane.URL, "project-1", "task-a", "workspace-a", config.TaskModeConversation)
 	callbackB := s.makeTaskCompletionCallback(controlPlane.URL, "project-1", "task-b", "workspace-b", config.TaskModeConversation)
 
@@ -280,3 +280,35 @@ func TestBuildTimeoutDiagnostics_CustomThresholds(t *testing.T) {
 		t.Errorf("expected 'larger VM size' suggestion with custom thresholds, got: %s", msg)
 	}
 }
+
+func TestBuildFailureDiagnostics_NoSpaceLeftOnDevice(t *testing.T) {
+	s := newTestServerWithCollector(stubCollector(0.5, 40, 99))
+
+	buildErr := fmt.Errorf("devcontainer up failed: exit status 1: failed to register layer: write /usr/lib/libLLVM.so: no space left on device")
+	msg, diag := s.buildFailureDiagnostics(buildErr)
+
+	if diag == nil || diag.Reason != "disk_full" || !diag.DiskFull {
+		t.Fatalf("expected disk_full diagnostics, got %+v", diag)
+	}
+	if !strings.Contains(msg, "ran out of disk space (disk 99% used)") {
+		t.Errorf("expected disk-full explanation with usage, got: %s", msg)
+	}
+	if !strings.Contains(msg, "no space left on device") {
+		t.Errorf("expected original error in message, got: %s", msg)
+	}
+
+	if _, diag := s.buildFailureDiagnostics(fmt.Errorf("write failed: %w", syscall.ENOSPC)); diag == nil || diag.Reason != "disk_full" {
+		t.Errorf("expected wrapped ENOSPC to be classified as disk_full, got %+v", diag)
+	}
+}
+
+func TestBuildFailureDiagnostics_OtherErrors(t *testing.T) {
+	s := newTestServerWithCollector(stubCollector(0.5, 40, 99))
+
+	if _, diag := s.buildFailureDiagnostics(context.DeadlineExceeded); diag == nil || diag.Reason != "timeout" {
+		t.Errorf("expected timeout diagnostics, got %+v", diag)
+	}
+	if msg, diag := s.buildFailureDiagnostics(fmt.Errorf("git clone failed")); diag != nil || msg != "git clone failed" {
+		t.Errorf("expected unrelated error unchanged, got %q %+v", msg, diag)
+	}
+}
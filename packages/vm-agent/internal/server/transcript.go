@@ -0,0 +1,87 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/workspace/vm-agent/internal/acp"
+)
+
+// sessionTranscriptMessages returns the session's replay buffer: from the
+// live SessionHost when there is one, otherwise from the persisted copy so
+// suspended sessions can still be exported.
+func (s *Server) sessionTranscriptMessages(workspaceID, sessionID string) ([]acp.BufferedMessage, error) {
+	s.sessionHostMu.Lock()
+	host := s.sessionHosts[workspaceID+":"+sessionID]
+	s.sessionHostMu.Unlock()
+	if host != nil {
+		return host.BufferedMessages(), nil
+	}
+	if s.store == nil {
+		return nil, nil
+	}
+	persisted, err := s.store.ListTabMessages(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]acp.BufferedMessage, 0, len(persisted))
+	for _, m := range persisted {
+		messages = append(messages, acp.BufferedMessage{Data: m.Data, SeqNum: m.Seq, Timestamp: m.Timestamp})
+	}
+	return messages, nil
+}
+
+// handleSessionTranscript exports an agent session's conversation as JSON
+// (the default) or, with ?format=markdown, as a Markdown download. It
+// covers what is still in the replay buffer; the oldest turns of very long
+// sessions may have been evicted.
+// GET /workspaces/{workspaceId}/agent-sessions/{sessionId}/transcript
+func (s *Server) handleSessionTranscript(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.PathValue("workspaceId")
+	sessionID := r.PathValue("sessionId")
+	if workspaceID == "" || sessionID == "" {
+		writeError(w, http.StatusBadRequest, "workspaceId and sessionId are required")
+		return
+	}
+	if !s.requireWorkspaceRequestAuth(w, r, workspaceID) {
+		if !s.requireNodeManagementAuth(w, r, workspaceID) {
+			return
+		}
+	}
+	format := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("format")))
+	if format != "" && format != "json" && format != "markdown" && format != "md" {
+		writeError(w, http.StatusBadRequest, "format must be json or markdown")
+		return
+	}
+
+	session, ok := s.agentSessions.Get(workspaceID, sessionID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "session not found")
+		return
+	}
+	buffered, err := s.sessionTranscriptMessages(workspaceID, sessionID)
+	if err != nil {
+		slog.Error("Failed to read session transcript", "workspace", workspaceID, "session", sessionID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to read session transcript")
+		return
+	}
+	messages := acp.BuildTranscript(buffered)
+
+	if format == "markdown" || format == "md" {
+		title := firstNonEmpty(session.Label, "Agent session "+sessionID)
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "transcript-"+sessionID+".md"))
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(acp.RenderTranscriptMarkdown(title, messages))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"workspaceId": workspaceID,
+		"sessionId":   sessionID,
+		"label":       session.Label,
+		"agentType":   session.AgentType,
+		"messages":    messages,
+	})
+}
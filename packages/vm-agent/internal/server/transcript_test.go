@@ -0,0 +1,86 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/acp"
+	"github.com/workspace/vm-agent/internal/agentsessions"
+	"github.com/workspace/vm-agent/internal/persistence"
+)
+
+func TestSessionTranscriptFromPersistedBuffer(t *testing.T) {
+	srv, workspaceID, _, cookie := newFileHandlerTestServer(t)
+	store, err := persistence.Open(filepath.Join(t.TempDir(), "vm-agent.db"))
+	if err != nil {
+		t.Fatalf("Open persistence store: %v", err)
+	}
+	defer store.Close()
+	srv.store = store
+	srv.agentSessions = agentsessions.NewManager()
+	srv.sessionHosts = map[string]*acp.SessionHost{}
+	if _, _, err := srv.agentSessions.Create(workspaceID, "sess-1", "Fix flaky test", ""); err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+
+	// No SessionHost is running, as after the session was suspended.
+	var persisted []persistence.TabMessage
+	for i, update := range []string{
+		`{"sessionUpdate":"user_message_chunk","content":{"type":"text","text":"Why is the test flaky?"}}`,
+		`{"sessionUpdate":"agent_message_chunk","content":{"type":"text","text":"It depends on map order."}}`,
+	} {
+		data := `{"jsonrpc":"2.0","method":"session/update","params":{"sessionId":"acp-1","update":` + update + `}}`
+		persisted = append(persisted, persistence.TabMessage{Seq: uint64(i + 1), Data: []byte(data), Timestamp: time.Now()})
+	}
+	if err := store.AppendTabMessages(workspaceID, "sess-1", persisted, 100); err != nil {
+		t.Fatalf("AppendTabMessages: %v", err)
+	}
+
+	request := func(sessionID, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/workspaces/"+workspaceID+"/agent-sessions/"+sessionID+"/transcript"+query, nil)
+		req.SetPathValue("workspaceId", workspaceID)
+		req.SetPathValue("sessionId", sessionID)
+		req.AddCookie(&http.Cookie{Name: "session", Value: cookie})
+		rec := httptest.NewRecorder()
+		srv.handleSessionTranscript(rec, req)
+		return rec
+	}
+
+	rec := request("sess-1", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("json status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Label    string                  `json:"label"`
+		Messages []acp.TranscriptMessage `json:"messages"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode transcript: %v", err)
+	}
+	if resp.Label != "Fix flaky test" || len(resp.Messages) != 2 || resp.Messages[1].Content != "It depends on map order." {
+		t.Fatalf("transcript = %+v", resp)
+	}
+
+	rec = request("sess-1", "?format=markdown")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("markdown status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Disposition"); !strings.Contains(got, "transcript-sess-1.md") {
+		t.Fatalf("Content-Disposition = %q", got)
+	}
+	if body := rec.Body.String(); !strings.HasPrefix(body, "# Fix flaky test\n") || !strings.Contains(body, "## User\n\nWhy is the test flaky?\n") {
+		t.Fatalf("markdown = %q", body)
+	}
+
+	if rec := request("sess-1", "?format=pdf"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown format status = %d, want 400", rec.Code)
+	}
+	if rec := request("sess-missing", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("missing session status = %d, want 404", rec.Code)
+	}
+}
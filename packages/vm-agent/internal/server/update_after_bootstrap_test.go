@@ -38,7 +38,6 @@ func newTestServerPreBootstrap(t *testing.T, containerResolver pty.ContainerReso
 			// ContainerUser is intentionally empty — pre-bootstrap state
 		},
 		errorReporter:       errorreport.New("", "", "", errorreport.Config{}),
-		workspaces:          make(map[string]*WorkspaceRuntime),
 		bootLogBroadcasters: NewBootLogBroadcasterManager(),
 	}
 }
@@ -79,7 +78,6 @@ func TestUpdateAfterBootstrap_DoesNotOverrideExistingContainerUser(t *testing.T)
 			ContainerUser: "existing-user",
 		},
 		errorReporter:       errorreport.New("", "", "", errorreport.Config{}),
-		workspaces:          make(map[string]*WorkspaceRuntime),
 		bootLogBroadcasters: NewBootLogBroadcasterManager(),
 	}
 
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/workspace/vm-agent/internal/acp"
+)
+
+// startWarmStandby pre-starts a viewerless SessionHost running the configured
+// warm-standby agent (ACP_WARM_STANDBY_AGENT) once a workspace is ready. The
+// agent binary install, credential fetch, and ACP handshake all happen in the
+// background, so the first agent session attached to the workspace is ready
+// for prompts immediately. No-op when warm standby is disabled or a standby
+// host already exists for the workspace.
+func (s *Server) startWarmStandby(workspaceID string) {
+	if s.config == nil || s.config.ACPWarmStandbyAgent == "" || workspaceID == "" {
+		return
+	}
+	agentType := s.config.ACPWarmStandbyAgent
+
+	runtime, ok := s.getWorkspaceRuntime(workspaceID)
+	if !ok {
+		return
+	}
+	if runtime.Lightweight && s.config.IsStandaloneMode() {
+		// Standalone sessions fetch per-session runtime assets at agent start,
+		// which a session-less standby host cannot do.
+		return
+	}
+
+	cfg := s.acpConfig
+	cfg.WorkspaceID = workspaceID
+	cfg.SessionID = ""
+	cfg.OnPromptComplete = nil
+	cfg.MessageReporter = nil
+	cfg.GitTokenFetcher = s.gitHubTokenFetcherForWorkspace(workspaceID)
+	cfg.SessionManager = s.agentSessions
+	cfg.TabStore = s.store
+	cfg.TabLastPromptStore = s.store
+	if s.store != nil {
+		// Guarded: a nil *Store would make the interface non-nil, and the
+		// host reads the buffer back at construction.
+		cfg.TabMessageStore = s.store
+	}
+	cfg.SessionLastPromptManager = s.agentSessions
+	cfg.EventAppender = &serverEventAppender{server: s}
+	cfg.CredentialSyncer = s
+	cfg.ControlPlaneStatus = s
+	cfg.IdleSuspendTimeout = 0
+	if s.config.CheckpointMaxPerSession > 0 {
+		cfg.OnPromptStart = s.checkpointBeforePrompt
+	}
+	if callbackToken := s.callbackTokenForWorkspace(workspaceID); callbackToken != "" {
+		cfg.CallbackToken = callbackToken
+	}
+	if workDir := strings.TrimSpace(runtime.ContainerWorkDir); workDir != "" {
+		cfg.ContainerWorkDir = workDir
+	}
+	if user := strings.TrimSpace(runtime.ContainerUser); user != "" {
+		cfg.ContainerUser = user
+	}
+	if resolver := s.ptyManagerContainerResolverForLabel(runtime.ContainerLabelValue); resolver != nil {
+		cfg.ContainerResolver = resolver
+	}
+
+	s.sessionHostMu.Lock()
+	if _, exists := s.warmStandbyHosts[workspaceID]; exists {
+		s.sessionHostMu.Unlock()
+		return
+	}
+	if s.warmStandbyHosts == nil {
+		s.warmStandbyHosts = make(map[string]*acp.SessionHost)
+	}
+	host := acp.NewSessionHost(s.sessionHostConfig(cfg))
+	s.warmStandbyHosts[workspaceID] = host
+	s.sessionHostMu.Unlock()
+
+	s.appendNodeEvent(workspaceID, "info", "agent.warm_standby_starting", "Pre-warming agent for instant availability", map[string]interface{}{
+		"agentType": agentType,
+	})
+
+	go func() {
+		host.SelectAgent(context.Background(), agentType)
+		if host.Status() != acp.HostReady {
+			slog.Warn("Warm standby agent failed to start; sessions will start agents on demand",
+				"workspace", workspaceID, "agentType", agentType, "status", string(host.Status()))
+			s.sessionHostMu.Lock()
+			if s.warmStandbyHosts[workspaceID] == host {
+				delete(s.warmStandbyHosts, workspaceID)
+			}
+			s.sessionHostMu.Unlock()
+			host.Stop()
+			return
+		}
+		slog.Info("Warm standby agent ready", "workspace", workspaceID, "agentType", agentType)
+		s.appendNodeEvent(workspaceID, "info", "agent.warm_standby_ready", "Warm standby agent is ready", map[string]interface{}{
+			"agentType": agentType,
+		})
+	}()
+}
+
+// claimWarmStandbyLocked hands the workspace's warm-standby SessionHost to
+// sessionID if the session's configuration is compatible with it. Sessions
+// that need per-session MCP servers, profile overrides, a resumed ACP session,
+// an explicit worktree, or standalone runtime assets must start their own
+// agent, because those are fixed when the ACP session is created. Returns nil
+// when no ready standby can be claimed. Must hold s.sessionHostMu.
+func (s *Server) claimWarmStandbyLocked(workspaceID, sessionID string, cfg acp.GatewayConfig, requestedWorktree string, hasRuntimeAssets bool) *acp.SessionHost {
+	host, ok := s.warmStandbyHosts[workspaceID]
+	if !ok {
+		return nil
+	}
+	if len(cfg.McpServers) > 0 || cfg.PreviousAcpSessionID != "" || requestedWorktree != "" || hasRuntimeAssets ||
+		cfg.ModelOverride != "" || cfg.PermissionModeOverride != "" || cfg.EffortOverride != "" ||
+		cfg.OpencodeProviderOverride != "" || cfg.OpencodeBaseURLOverride != "" {
+		return nil
+	}
+	if !host.ClaimStandby(acp.StandbyClaim{
+		SessionID:        sessionID,
+		MessageReporter:  cfg.MessageReporter,
+		OnPromptComplete: cfg.OnPromptComplete,
+	}) {
+		return nil
+	}
+	delete(s.warmStandbyHosts, workspaceID)
+
+	s.appendNodeEvent(workspaceID, "info", "agent.warm_standby_claimed", "Agent session attached to warm standby agent", map[string]interface{}{
+		"sessionId": sessionID,
+	})
+	return host
+}
+
+// stopWarmStandbyLocked stops and discards the workspace's unclaimed
+// warm-standby SessionHost, if any. Must hold s.sessionHostMu.
+func (s *Server) stopWarmStandbyLocked(workspaceID string) {
+	if host, ok := s.warmStandbyHosts[workspaceID]; ok {
+		host.Stop()
+		delete(s.warmStandbyHosts, workspaceID)
+	}
+}
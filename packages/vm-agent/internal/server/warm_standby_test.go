@@ -0,0 +1,66 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/workspace/vm-agent/internal/acp"
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+func TestStartWarmStandbyDisabledByDefault(t *testing.T) {
+	s := &Server{
+		config: &config.Config{},
+	}
+	putWorkspaces(&s.workspaces, map[string]*WorkspaceRuntime{"ws-1": {ID: "ws-1"}})
+	s.startWarmStandby("ws-1")
+	if len(s.warmStandbyHosts) != 0 {
+		t.Fatalf("expected no warm standby when ACP_WARM_STANDBY_AGENT is unset, got %d", len(s.warmStandbyHosts))
+	}
+}
+
+func TestClaimWarmStandbySkipsIncompatibleSessions(t *testing.T) {
+	standby := acp.NewSessionHost(acp.SessionHostConfig{GatewayConfig: acp.GatewayConfig{WorkspaceID: "ws-1"}})
+	s := &Server{
+		config:           &config.Config{},
+		workspaceEvents:  map[string][]EventRecord{},
+		warmStandbyHosts: map[string]*acp.SessionHost{"ws-1": standby},
+	}
+
+	cases := map[string]struct {
+		cfg              acp.GatewayConfig
+		worktree         string
+		hasRuntimeAssets bool
+	}{
+		"mcp servers":      {cfg: acp.GatewayConfig{McpServers: []acp.McpServerEntry{{URL: "https://mcp.example.com"}}}},
+		"previous session": {cfg: acp.GatewayConfig{PreviousAcpSessionID: "acp-1"}},
+		"model override":   {cfg: acp.GatewayConfig{ModelOverride: "opus"}},
+		"worktree":         {worktree: "/workspaces/repo-wt"},
+		"runtime assets":   {hasRuntimeAssets: true},
+		"not ready":        {},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if host := s.claimWarmStandbyLocked("ws-1", "sess-1", tc.cfg, tc.worktree, tc.hasRuntimeAssets); host != nil {
+				t.Fatal("expected standby not to be claimed")
+			}
+			if s.warmStandbyHosts["ws-1"] != standby {
+				t.Fatal("unclaimed standby must remain available")
+			}
+		})
+	}
+}
+
+func TestStopWarmStandbyLocked(t *testing.T) {
+	standby := acp.NewSessionHost(acp.SessionHostConfig{GatewayConfig: acp.GatewayConfig{WorkspaceID: "ws-1"}})
+	s := &Server{warmStandbyHosts: map[string]*acp.SessionHost{"ws-1": standby}}
+
+	s.stopWarmStandbyLocked("ws-1")
+
+	if _, ok := s.warmStandbyHosts["ws-1"]; ok {
+		t.Fatal("expected standby to be removed")
+	}
+	if standby.Status() != acp.HostStopped {
+		t.Fatalf("standby status = %s, want stopped", standby.Status())
+	}
+	s.stopWarmStandbyLocked("ws-missing")
+}
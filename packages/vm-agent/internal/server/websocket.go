@@ -75,6 +75,7 @@ type wsCreateSessionData struct {
 	Cols      int    `json:"cols"`
 	Name      string `json:"name,omitempty"`
 	WorkDir   string `json:"workDir,omitempty"`
+	Subdir    string `json:"subdir,omitempty"`
 }
 
 type wsCloseSessionData struct {
@@ -172,10 +173,7 @@ func (s *Server) resolveWorkspaceIDForWebsocket(r *http.Request) string {
 			}
 		}
 	}
-	if workspaceID == "" {
-		workspaceID = strings.TrimSpace(s.config.WorkspaceID)
-	}
-	if workspaceID == "" {
+	if workspaceID = s.workspaces.Resolve(workspaceID); workspaceID == "" {
 		workspaceID = "default"
 	}
 	return workspaceID
@@ -192,6 +190,9 @@ func (s *Server) handleTerminalWS(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		return
 	}
+	if s.refuseWhileDraining(w) {
+		return
+	}
 
 	runtime := s.upsertWorkspaceRuntime(workspaceID, "", "", "running", "")
 
@@ -253,6 +254,7 @@ func (s *Server) handleTerminalWS(w http.ResponseWriter, r *http.Request) {
 	var writeMu sync.Mutex
 	stopHeartbeat := s.configureTerminalWebSocket(conn, &writeMu)
 	defer stopHeartbeat()
+	defer s.registerTerminalConn(conn, &writeMu)()
 	limiter := newTerminalWSLimiter(s.config.TerminalWSMessageRate, s.config.TerminalWSMessageBurst)
 	done := make(chan struct{})
 	go func() {
@@ -349,6 +351,7 @@ func (s *Server) handleMultiTerminalWS(w http.ResponseWriter, r *http.Request) {
 	var writeMu sync.Mutex
 	stopHeartbeat := s.configureTerminalWebSocket(conn, &writeMu)
 	defer stopHeartbeat()
+	defer s.registerTerminalConn(conn, &writeMu)()
 	limiter := newTerminalWSLimiter(s.config.TerminalWSMessageRate, s.config.TerminalWSMessageBurst)
 
 	defer func() {
@@ -479,18 +482,22 @@ func (s *Server) handleMultiTerminalWS(w http.ResponseWriter, r *http.Request) {
 			if err := json.Unmarshal(msg.Data, &data); err != nil {
 				continue
 			}
+			if s.drainStatus() != nil {
+				sendSessionError(data.SessionID, "node is shutting down")
+				continue
+			}
 			if err := s.validateTerminalSessionID(data.SessionID); err != nil {
 				sendSessionError(data.SessionID, "invalid session ID")
 				continue
 			}
 			requestedWorkDir := strings.TrimSpace(data.WorkDir)
-			if requestedWorkDir != "" {
+			if requestedSubdir := strings.TrimSpace(data.Subdir); requestedWorkDir != "" || requestedSubdir != "" {
 				containerID, defaultWorkDir, user, resolveErr := s.resolveContainerForWorkspace(workspaceID)
 				if resolveErr != nil {
 					sendSessionError(data.SessionID, resolveErr.Error())
 					continue
 				}
-				effectiveWorkDir, resolveErr := s.resolveExplicitWorktreeWorkDir(r.Context(), workspaceID, containerID, user, defaultWorkDir, requestedWorkDir)
+				_, effectiveWorkDir, resolveErr := s.resolveSessionWorkDir(r.Context(), workspaceID, containerID, user, defaultWorkDir, requestedWorkDir, requestedSubdir)
 				if resolveErr != nil {
 					sendSessionError(data.SessionID, resolveErr.Error())
 					continue
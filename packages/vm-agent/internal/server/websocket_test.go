@@ -179,9 +179,8 @@ func TestTerminalWS_DisconnectClosesSingleSession(t *testing.T) {
 }
 
 func (s *Server) workspaceRuntimeForTest(workspaceID string) *WorkspaceRuntime {
-	s.workspaceMu.Lock()
-	defer s.workspaceMu.Unlock()
-	return s.workspaces[workspaceID]
+	runtime, _ := s.workspaces.Get(workspaceID)
+	return runtime
 }
 
 func waitUntil(t *testing.T, condition func() bool, description string) {
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+// autosaveBranchPrefix namespaces work-preservation branches so they never
+// collide with user branches and are easy to find and clean up.
+const autosaveBranchPrefix = "sam/autosave-"
+
+// autosaveResult describes uncommitted work pushed to an autosave branch.
+type autosaveResult struct {
+	Branch     string    `json:"branch"`
+	CommitSHA  string    `json:"commitSha"`
+	BaseBranch string    `json:"baseBranch,omitempty"` // empty when HEAD was detached
+	BaseSHA    string    `json:"baseSha"`
+	Reason     string    `json:"reason"` // "suspend" or "shutdown"
+	SavedAt    time.Time `json:"savedAt"`
+}
+
+// preserveWorkspaceWork pushes the workspace's uncommitted work, including
+// untracked files, to a new sam/autosave-<timestamp> branch and reports the
+// branch to the control plane. The WIP commit is built from a scratch tree
+// with commit-tree, so the user's branch, working tree, and staged changes are
+// left exactly as they were. Returns nil, nil when there is nothing to save:
+// the tree is clean and HEAD is already on a remote, or nothing changed since
+// the previous autosave.
+func (s *Server) preserveWorkspaceWork(ctx context.Context, workspaceID, reason string) (*autosaveResult, error) {
+	// Serializes autosaves so concurrent suspends of one workspace's sessions
+	// push a single branch, and guards lastAutosave.
+	s.autosaveMu.Lock()
+	defer s.autosaveMu.Unlock()
+
+	containerID, workDir, user, err := s.resolveContainerForWorkspace(workspaceID)
+	if err != nil {
+		return nil, fmt.Errorf("resolve container: %w", err)
+	}
+	release, err := s.lockWorkspaceGit(ctx, workspaceID, containerID, user, workDir, "autosave")
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	git := func(args ...string) (string, error) {
+		return s.runWorkspaceGitCommand(containerID, workDir, user, args...)
+	}
+
+	head, err := git("rev-parse", "--verify", "HEAD")
+	if err != nil {
+		return nil, fmt.Errorf("no commit to base an autosave on: %s", head)
+	}
+	status, err := git("status", "--porcelain")
+	if err != nil {
+		return nil, fmt.Errorf("git status failed: %s", status)
+	}
+
+	commitSHA := head
+	key := head
+	if status != "" {
+		tree, err := snapshotWorkingTree(git)
+		if err != nil {
+			return nil, err
+		}
+		key = head + ":" + tree
+		if s.lastAutosave[workspaceID] == key {
+			return nil, nil
+		}
+		message := fmt.Sprintf("wip: SAM autosave on %s\n\nUncommitted work saved automatically before the workspace was %s.", reason, autosaveReasonVerb(reason))
+		commitSHA, err = git("commit-tree", tree, "-p", head, "-m", message)
+		if err != nil {
+			return nil, fmt.Errorf("git commit-tree failed: %s", commitSHA)
+		}
+	} else {
+		// Clean tree: only unpushed commits are at risk.
+		if s.lastAutosave[workspaceID] == key {
+			return nil, nil
+		}
+		if remotes, err := git("branch", "-r", "--contains", head); err == nil && remotes != "" {
+			return nil, nil
+		}
+	}
+
+	result := &autosaveResult{
+		CommitSHA: commitSHA,
+		BaseSHA:   head,
+		Reason:    reason,
+		SavedAt:   nowUTC(),
+	}
+	result.Branch = autosaveBranchPrefix + result.SavedAt.Format("20060102T150405Z")
+	if branch, err := git("symbolic-ref", "--quiet", "--short", "HEAD"); err == nil {
+		result.BaseBranch = branch
+	}
+
+	// Pushes through the workspace's git credential helper, like any other
+	// push from inside the container.
+	if output, err := git("push", "origin", commitSHA+":refs/heads/"+result.Branch); err != nil {
+		return nil, fmt.Errorf("git push failed: %s", output)
+	}
+	if s.lastAutosave == nil {
+		s.lastAutosave = make(map[string]string)
+	}
+	s.lastAutosave[workspaceID] = key
+
+	slog.Info("Workspace work preserved", "workspaceId", workspaceID, "branch", result.Branch, "commitSha", commitSHA, "reason", reason)
+	s.appendNodeEvent(workspaceID, "info", "workspace.work_preserved", "Uncommitted work pushed to an autosave branch", map[string]interface{}{
+		"branch":    result.Branch,
+		"commitSha": commitSHA,
+		"reason":    reason,
+	})
+	if err := s.notifyWorkspaceAutosave(ctx, workspaceID, s.callbackTokenForWorkspace(workspaceID), *result); err != nil {
+		slog.Warn("Failed to report autosave branch to control plane", "workspaceId", workspaceID, "branch", result.Branch, "error", err)
+	}
+	return result, nil
+}
+
+// snapshotWorkingTree writes a tree object of the full working tree, honoring
+// .gitignore, and restores the index to the user's staged state afterwards.
+// Fails when the index has unresolved merge conflicts.
+func snapshotWorkingTree(git func(args ...string) (string, error)) (string, error) {
+	staged, err := git("write-tree")
+	if err != nil {
+		return "", fmt.Errorf("cannot snapshot index (unresolved conflicts?): %s", staged)
+	}
+	if output, err := git("add", "-A"); err != nil {
+		_, _ = git("read-tree", staged)
+		return "", fmt.Errorf("git add failed: %s", output)
+	}
+	tree, treeErr := git("write-tree")
+	if output, err := git("read-tree", staged); err != nil {
+		return "", fmt.Errorf("restore index failed: %s", output)
+	}
+	if treeErr != nil {
+		return "", fmt.Errorf("git write-tree failed: %s", tree)
+	}
+	return tree, nil
+}
+
+func autosaveReasonVerb(reason string) string {
+	switch reason {
+	case "suspend":
+		return "suspended"
+	case "shutdown":
+		return "shut down"
+	default:
+		return reason
+	}
+}
+
+// preserveRunningWorkspaces autosaves every running workspace on the node.
+// Failures are logged and recorded as node events; they never block the
+// caller's shutdown.
+func (s *Server) preserveRunningWorkspaces(ctx context.Context, reason string) {
+	var workspaceIDs []string
+	s.workspaces.Range(func(id string, runtime *WorkspaceRuntime) {
+		if runtime.Status == "running" {
+			workspaceIDs = append(workspaceIDs, id)
+		}
+	})
+	sort.Strings(workspaceIDs)
+
+	for _, workspaceID := range workspaceIDs {
+		s.preserveWorkspaceWorkLogged(ctx, workspaceID, reason)
+	}
+}
+
+// preserveWorkspaceWorkLogged runs preserveWorkspaceWork and records a
+// failure instead of returning it.
+func (s *Server) preserveWorkspaceWorkLogged(ctx context.Context, workspaceID, reason string) {
+	if _, err := s.preserveWorkspaceWork(ctx, workspaceID, reason); err != nil {
+		slog.Warn("Work preservation failed", "workspaceId", workspaceID, "reason", reason, "error", err)
+		s.appendNodeEvent(workspaceID, "warn", "workspace.work_preservation_failed", "Could not push uncommitted work to an autosave branch", map[string]interface{}{
+			"reason": reason,
+			"error":  err.Error(),
+		})
+	}
+}
+
+// preserveWorkOnSuspend autosaves a workspace in the background after one of
+// its agent sessions is suspended. No-op unless WORK_PRESERVATION_ENABLED.
+func (s *Server) preserveWorkOnSuspend(workspaceID string) {
+	if !s.config.WorkPreservationEnabled || strings.TrimSpace(workspaceID) == "" {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+		s.preserveWorkspaceWorkLogged(ctx, workspaceID, "suspend")
+	}()
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+func TestPreserveWorkspaceWorkPushesAutosaveBranch(t *testing.T) {
+	if _, err := exec.LookPath("/usr/bin/git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	remote := filepath.Join(t.TempDir(), "remote.git")
+	runTestGit(t, filepath.Dir(remote), "init", "-q", "--bare", remote)
+	repo := t.TempDir()
+	runTestGit(t, repo, "init", "-q", "-b", "main")
+	runTestGit(t, repo, "config", "user.name", "Test")
+	runTestGit(t, repo, "config", "user.email", "test@example.com")
+	runTestGit(t, repo, "remote", "add", "origin", remote)
+	writeTestFile(t, filepath.Join(repo, ".gitignore"), "ignored.log\n")
+	writeTestFile(t, filepath.Join(repo, "app.go"), "package app\n")
+	runTestGit(t, repo, "add", "-A")
+	runTestGit(t, repo, "commit", "-q", "-m", "init")
+	runTestGit(t, repo, "push", "-q", "origin", "main")
+
+	// Dirty the tree: one staged edit, one unstaged edit, an untracked file,
+	// and an ignored file that must not be saved.
+	writeTestFile(t, filepath.Join(repo, "app.go"), "package app\n\nfunc Staged() {}\n")
+	runTestGit(t, repo, "add", "app.go")
+	writeTestFile(t, filepath.Join(repo, "app.go"), "package app\n\nfunc Staged() {}\n\nfunc Unstaged() {}\n")
+	writeTestFile(t, filepath.Join(repo, "notes.md"), "todo\n")
+	writeTestFile(t, filepath.Join(repo, "ignored.log"), "noise\n")
+	head := runTestGit(t, repo, "rev-parse", "HEAD")
+	statusBefore := runTestGit(t, repo, "status", "--porcelain")
+	stagedBefore := runTestGit(t, repo, "diff", "--cached")
+
+	var mu sync.Mutex
+	var reports []autosaveResult
+	controlPlane := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/workspaces/WS_TEST/autosave" || r.Header.Get("Authorization") != "Bearer cb-token" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		var report autosaveResult
+		_ = json.NewDecoder(r.Body).Decode(&report)
+		mu.Lock()
+		reports = append(reports, report)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer controlPlane.Close()
+
+	s := &Server{
+		config: &config.Config{
+			Role:            config.RoleStandalone,
+			GitExecTimeout:  10 * time.Second,
+			ControlPlaneURL: controlPlane.URL,
+		},
+		workspaceEvents: make(map[string][]EventRecord),
+	}
+
+	putWorkspaces(&s.workspaces, map[string]*WorkspaceRuntime{
+		"WS_TEST": {ID: "WS_TEST", Status: "running", ContainerWorkDir: repo, CallbackToken: "cb-token"},
+	})
+
+	result, err := s.preserveWorkspaceWork(context.Background(), "WS_TEST", "shutdown")
+	if err != nil {
+		t.Fatalf("preserveWorkspaceWork: %v", err)
+	}
+	if result == nil || !strings.HasPrefix(result.Branch, autosaveBranchPrefix) || result.BaseBranch != "main" || result.BaseSHA != head {
+		t.Fatalf("result = %+v", result)
+	}
+
+	if got := runTestGit(t, remote, "rev-parse", "refs/heads/"+result.Branch); got != result.CommitSHA {
+		t.Fatalf("remote %s = %s, want %s", result.Branch, got, result.CommitSHA)
+	}
+	if got := runTestGit(t, remote, "show", result.CommitSHA+":notes.md"); got != "todo" {
+		t.Fatalf("untracked file in autosave = %q", got)
+	}
+	if got := runTestGit(t, remote, "show", result.CommitSHA+":app.go"); !strings.Contains(got, "Unstaged") {
+		t.Fatalf("autosave is missing unstaged edits: %q", got)
+	}
+	if files := runTestGit(t, remote, "ls-tree", "--name-only", result.CommitSHA); strings.Contains(files, "ignored.log") {
+		t.Fatalf("ignored file was saved: %s", files)
+	}
+
+	// The user's checkout is untouched.
+	if got := runTestGit(t, repo, "rev-parse", "HEAD"); got != head {
+		t.Fatalf("HEAD moved to %s", got)
+	}
+	if got := runTestGit(t, repo, "status", "--porcelain"); got != statusBefore {
+		t.Fatalf("status changed:\nbefore:\n%s\nafter:\n%s", statusBefore, got)
+	}
+	if got := runTestGit(t, repo, "diff", "--cached"); got != stagedBefore {
+		t.Fatalf("staged changes altered:\n%s", got)
+	}
+
+	mu.Lock()
+	if len(reports) != 1 || reports[0].Branch != result.Branch || reports[0].Reason != "shutdown" {
+		t.Fatalf("control plane reports = %+v", reports)
+	}
+	mu.Unlock()
+
+	// Nothing changed since the last autosave: no second branch.
+	again, err := s.preserveWorkspaceWork(context.Background(), "WS_TEST", "suspend")
+	if err != nil || again != nil {
+		t.Fatalf("second autosave = %+v, %v; want nothing to save", again, err)
+	}
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
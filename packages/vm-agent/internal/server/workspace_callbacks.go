@@ -10,7 +10,7 @@ import (
 	neturl "net/url"
 	"strings"
 
-	"github.com/workspace/vm-agent/internal/callbackretry"
+	"github.com/workspace/vm-agent/internal/retry"
 )
 
 const (
@@ -58,7 +58,7 @@ func (s *Server) SyncCredential(
 		neturl.PathEscape(trimmedWorkspaceID),
 	)
 
-	return callbackretry.Do(ctx, callbackretry.DefaultConfig(), "credential-sync", func(retryCtx context.Context) error {
+	return retry.Do(ctx, retry.DefaultPolicy(), "credential-sync", func(retryCtx context.Context) error {
 		requestCtx := retryCtx
 		cancel := func() {}
 		if s.config.HTTPReadTimeout > 0 {
@@ -89,7 +89,7 @@ func (s *Server) SyncCredential(
 			if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
 				resp.StatusCode != http.StatusRequestTimeout &&
 				resp.StatusCode != http.StatusTooManyRequests {
-				return callbackretry.Permanent(err)
+				return retry.Permanent(err)
 			}
 			return err
 		}
@@ -132,7 +132,7 @@ func (s *Server) notifyWorkspaceProvisioningFailed(
 		neturl.PathEscape(trimmedWorkspaceID),
 	)
 
-	return callbackretry.Do(ctx, callbackretry.DefaultConfig(), "provisioning-failed", func(retryCtx context.Context) error {
+	return retry.Do(ctx, retry.DefaultPolicy(), "provisioning-failed", func(retryCtx context.Context) error {
 		requestCtx := retryCtx
 		cancel := func() {}
 		if s.config.HTTPReadTimeout > 0 {
@@ -165,7 +165,7 @@ func (s *Server) notifyWorkspaceProvisioningFailed(
 			if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
 				resp.StatusCode != http.StatusRequestTimeout &&
 				resp.StatusCode != http.StatusTooManyRequests {
-				return callbackretry.Permanent(err)
+				return retry.Permanent(err)
 			}
 			return err
 		}
@@ -174,6 +174,53 @@ func (s *Server) notifyWorkspaceProvisioningFailed(
 	})
 }
 
+// notifyWorkspaceAutosave tells the control plane which branch holds a
+// workspace's preserved work so the UI can point the user at it.
+func (s *Server) notifyWorkspaceAutosave(ctx context.Context, workspaceID, callbackToken string, result autosaveResult) error {
+	trimmedCallbackToken := strings.TrimSpace(callbackToken)
+	if trimmedCallbackToken == "" {
+		return fmt.Errorf("callback token is required")
+	}
+
+	body, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("marshal autosave payload: %w", err)
+	}
+
+	endpoint := fmt.Sprintf(
+		"%s/api/workspaces/%s/autosave",
+		strings.TrimRight(s.config.ControlPlaneURL, "/"),
+		neturl.PathEscape(strings.TrimSpace(workspaceID)),
+	)
+
+	return retry.Do(ctx, retry.DefaultPolicy(), "autosave", func(retryCtx context.Context) error {
+		req, err := http.NewRequestWithContext(retryCtx, http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("build autosave request: %w", err)
+		}
+		req.Header.Set(authorizationHeaderName, bearerTokenPrefix+trimmedCallbackToken)
+		req.Header.Set(contentTypeHeaderName, jsonContentType)
+
+		resp, err := s.controlPlaneHTTPClient(0).Do(req)
+		if err != nil {
+			return fmt.Errorf("send autosave request: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			responseBody, _ := io.ReadAll(io.LimitReader(resp.Body, 8*1024))
+			err := fmt.Errorf("autosave callback returned HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(responseBody)))
+			if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+				resp.StatusCode != http.StatusRequestTimeout &&
+				resp.StatusCode != http.StatusTooManyRequests {
+				return retry.Permanent(err)
+			}
+			return err
+		}
+		return nil
+	})
+}
+
 func (s *Server) notifyWorkspaceReady(ctx context.Context, workspaceID, callbackToken, status string) error {
 	if callbackToken == "" {
 		return fmt.Errorf("callback token is empty")
@@ -54,11 +54,12 @@ func TestSyncCredential(t *testing.T) {
 				ControlPlaneURL: controlPlane.URL,
 				HTTPReadTimeout: 5 * time.Second,
 			},
-			workspaces: map[string]*WorkspaceRuntime{
-				"ws-codex": {ID: "ws-codex", CallbackToken: "ws-callback-token"},
-			},
 		}
 
+		putWorkspaces(&s.workspaces, map[string]*WorkspaceRuntime{
+			"ws-codex": {ID: "ws-codex", CallbackToken: "ws-callback-token"},
+		})
+
 		err := s.SyncCredential(
 			context.Background(),
 			"ws-codex",
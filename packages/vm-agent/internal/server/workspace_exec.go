@@ -5,9 +5,15 @@ import (
 	"fmt"
 	"os/exec"
 	"strings"
+
+	"github.com/workspace/vm-agent/internal/containerruntime"
 )
 
-const dockerBinaryPath = "/usr/bin/docker"
+// workspaceExecBinary is the container CLI at its conventional install
+// location; workspace exec never resolves it through PATH.
+func workspaceExecBinary() string {
+	return "/usr/bin/" + containerruntime.Current().Name()
+}
 
 func (s *Server) isStandaloneWorkspaceExec() bool {
 	return s != nil && s.config != nil && s.config.IsStandaloneMode()
@@ -19,6 +25,8 @@ func standaloneWorkspaceCommandPath(command string) (string, error) {
 		return "/usr/bin/cat", nil
 	case "find":
 		return "/usr/bin/find", nil
+	case "flock":
+		return "/usr/bin/flock", nil
 	case "gh":
 		return "/usr/bin/gh", nil
 	case "git":
@@ -29,10 +37,16 @@ func standaloneWorkspaceCommandPath(command string) (string, error) {
 		return "/usr/bin/printenv", nil
 	case "pwd":
 		return "/usr/bin/pwd", nil
+	case "rg":
+		return "/usr/bin/rg", nil
+	case "rm":
+		return "/usr/bin/rm", nil
 	case "stat":
 		return "/usr/bin/stat", nil
 	case "tee":
 		return "/usr/bin/tee", nil
+	case "timeout":
+		return "/usr/bin/timeout", nil
 	default:
 		return "", fmt.Errorf("unsupported standalone workspace command %q", command)
 	}
@@ -53,9 +67,13 @@ func validateWorkspaceExecArgs(args []string) error {
 	return nil
 }
 
-func dockerWorkspaceExecCommand(ctx context.Context, dockerArgs []string) *exec.Cmd {
-	cmd := exec.CommandContext(ctx, dockerBinaryPath)
-	cmd.Args = append([]string{dockerBinaryPath}, dockerArgs...)
+// dockerWorkspaceExecCommand returns the container CLI invocation that runs
+// command in containerID. The arguments come from the runtime's ExecArgs, so
+// the command is passed as argv and never through a shell string.
+func dockerWorkspaceExecCommand(ctx context.Context, containerID string, opts containerruntime.ExecOptions, command ...string) *exec.Cmd {
+	binary := workspaceExecBinary()
+	cmd := exec.CommandContext(ctx, binary)
+	cmd.Args = append([]string{binary}, containerruntime.Current().ExecArgs(containerID, opts, command...)...)
 	return cmd
 }
 
@@ -76,14 +94,9 @@ func (s *Server) workspaceExecCommand(ctx context.Context, containerID, user, wo
 		return cmd, nil
 	}
 
-	dockerArgs := []string{"exec", "-i"}
-	if user != "" {
-		dockerArgs = append(dockerArgs, "-u", user)
-	}
-	if workDir != "" {
-		dockerArgs = append(dockerArgs, "-w", workDir)
-	}
-	dockerArgs = append(dockerArgs, containerID)
-	dockerArgs = append(dockerArgs, args...)
-	return dockerWorkspaceExecCommand(ctx, dockerArgs), nil
+	return dockerWorkspaceExecCommand(ctx, containerID, containerruntime.ExecOptions{
+		User:        user,
+		WorkDir:     workDir,
+		Interactive: true,
+	}, args...), nil
 }
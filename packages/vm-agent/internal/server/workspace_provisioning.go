@@ -14,7 +14,9 @@ import (
 
 	"github.com/workspace/vm-agent/internal/bootlog"
 	"github.com/workspace/vm-agent/internal/bootstrap"
+	"github.com/workspace/vm-agent/internal/bootstrap/gitprovider"
 	"github.com/workspace/vm-agent/internal/config"
+	"github.com/workspace/vm-agent/internal/lifecyclehook"
 )
 
 var prepareWorkspaceForRuntime = bootstrap.PrepareWorkspace // returns (recoveryMode bool, error)
@@ -67,12 +69,7 @@ func workspaceRuntimeRequiresGitToken(runtime *WorkspaceRuntime) bool {
 		return false
 	}
 
-	switch strings.ToLower(strings.TrimSpace(runtime.RepoProvider)) {
-	case "", "github", "gitlab", "artifacts":
-		return true
-	default:
-		return false
-	}
+	return gitprovider.Known(runtime.RepoProvider)
 }
 
 func (s *Server) provisionWorkspaceRuntime(ctx context.Context, runtime *WorkspaceRuntime) (bool, error) {
@@ -85,23 +82,7 @@ func (s *Server) provisionWorkspaceRuntime(ctx context.Context, runtime *Workspa
 		callbackToken = strings.TrimSpace(s.config.CallbackToken)
 	}
 
-	cfg := *s.config
-	cfg.WorkspaceID = runtime.ID
-	cfg.Repository = strings.TrimSpace(runtime.Repository)
-	cfg.Branch = strings.TrimSpace(runtime.Branch)
-	cfg.RepoProvider = strings.TrimSpace(runtime.RepoProvider)
-	cfg.CloneURL = strings.TrimSpace(runtime.CloneURL)
-	cfg.RepositoryHost = strings.TrimSpace(runtime.RepositoryHost)
-	cfg.RepositoryPath = strings.TrimSpace(runtime.RepositoryPath)
-	cfg.WorkspaceDir = strings.TrimSpace(runtime.WorkspaceDir)
-	cfg.ContainerLabelValue = strings.TrimSpace(runtime.ContainerLabelValue)
-	cfg.ContainerWorkDir = strings.TrimSpace(runtime.ContainerWorkDir)
-	cfg.ContainerUser = strings.TrimSpace(runtime.ContainerUser)
-	if cfg.ContainerUser == "" {
-		cfg.ContainerUser = strings.TrimSpace(s.config.ContainerUser)
-	}
-	cfg.CallbackToken = callbackToken
-	applyDevcontainerCacheCredentials(&cfg, runtime.DevcontainerCache)
+	cfg := s.workspaceBootstrapConfig(runtime, callbackToken)
 
 	provisionCtx := ctx
 	cancel := func() {}
@@ -110,7 +91,7 @@ func (s *Server) provisionWorkspaceRuntime(ctx context.Context, runtime *Workspa
 	}
 	defer cancel()
 
-	gitToken, err := s.fetchGitTokenForWorkspace(provisionCtx, runtime.ID, callbackToken)
+	gitToken, err := s.fetchGitTokenForWorkspace(provisionCtx, runtime.ID, callbackToken, gitTokenPurposeClone)
 	if err != nil {
 		if workspaceRuntimeRequiresGitToken(runtime) {
 			return false, fmt.Errorf("failed to fetch git token: %w", err)
@@ -130,6 +111,11 @@ func (s *Server) provisionWorkspaceRuntime(ctx context.Context, runtime *Workspa
 	if broadcaster := s.GetBootLogBroadcasterForWorkspace(runtime.ID); broadcaster != nil {
 		reporter.SetBroadcaster(broadcaster)
 	}
+	reporter.AddSinks(s.bootLogSinks...)
+	reporter.TrackProgress(s.config.BootLogHistoryPath)
+	s.trackCallbackQueue(runtime.ID, reporter)
+
+	s.NotifyLifecycle(lifecyclehook.EventBootstrapStarted, runtime.ID, nil)
 
 	recoveryMode, err := prepareWorkspaceForRuntime(provisionCtx, &cfg, bootstrap.ProvisionState{
 		GitHubToken:            gitToken,
@@ -144,6 +130,21 @@ func (s *Server) provisionWorkspaceRuntime(ctx context.Context, runtime *Workspa
 		ProjectFiles:           runtimeAssets.Files,
 		Lightweight:            runtime.Lightweight,
 		DevcontainerConfigName: runtime.DevcontainerConfigName,
+		CommitTrailers:         runtime.CommitTrailers,
+		Spec:                   runtime.ProvisionSpec,
+		RepoCache:              s.repoMirrors,
+		SnapshotURL:            runtime.SnapshotURL,
+		DeployKey:              runtime.DeployKey,
+		KnownHosts:             runtime.KnownHosts,
+		SigningKey:             runtime.SigningKey,
+		EnvironmentTemplate:    runtime.EnvironmentTemplate,
+		ResourceLimits:         runtime.ResourceLimits,
+		EgressPolicy:           runtime.EgressPolicy,
+		RegistryCredentials:    runtime.RegistryCredentials,
+		DevcontainerPolicy:     runtime.DevcontainerPolicy,
+		Hooks:                  runtime.Hooks,
+		RebuildContainer:       runtime.RebuildContainer,
+		ForceResync:            runtime.ForceResync,
 	}, reporter)
 	if err != nil {
 		return false, err
@@ -179,27 +180,11 @@ func (s *Server) recoverWorkspaceRuntime(ctx context.Context, runtime *Workspace
 
 	s.hydrateWorkspaceRuntimeForRecovery(recoveryCtx, runtime, callbackToken)
 
-	cfg := *s.config
-	cfg.WorkspaceID = runtime.ID
-	cfg.Repository = strings.TrimSpace(runtime.Repository)
-	cfg.Branch = strings.TrimSpace(runtime.Branch)
-	cfg.RepoProvider = strings.TrimSpace(runtime.RepoProvider)
-	cfg.CloneURL = strings.TrimSpace(runtime.CloneURL)
-	cfg.RepositoryHost = strings.TrimSpace(runtime.RepositoryHost)
-	cfg.RepositoryPath = strings.TrimSpace(runtime.RepositoryPath)
-	cfg.WorkspaceDir = strings.TrimSpace(runtime.WorkspaceDir)
-	cfg.ContainerLabelValue = strings.TrimSpace(runtime.ContainerLabelValue)
-	cfg.ContainerWorkDir = strings.TrimSpace(runtime.ContainerWorkDir)
-	cfg.ContainerUser = strings.TrimSpace(runtime.ContainerUser)
-	if cfg.ContainerUser == "" {
-		cfg.ContainerUser = strings.TrimSpace(s.config.ContainerUser)
-	}
-	cfg.CallbackToken = callbackToken
-	applyDevcontainerCacheCredentials(&cfg, runtime.DevcontainerCache)
+	cfg := s.workspaceBootstrapConfig(runtime, callbackToken)
 
 	state := bootstrap.ProvisionState{}
 	if cfg.Repository != "" && callbackToken != "" {
-		gitToken, fetchErr := s.fetchGitTokenForWorkspace(recoveryCtx, runtime.ID, callbackToken)
+		gitToken, fetchErr := s.fetchGitTokenForWorkspace(recoveryCtx, runtime.ID, callbackToken, gitTokenPurposeClone)
 		if fetchErr != nil {
 			slog.Warn("Recovery proceeding without git token", "workspace", runtime.ID, "error", fetchErr)
 		} else {
@@ -215,10 +200,22 @@ func (s *Server) recoverWorkspaceRuntime(ctx context.Context, runtime *Workspace
 	state.ProjectFiles = runtimeAssets.Files
 	state.Lightweight = runtime.Lightweight
 	state.DevcontainerConfigName = runtime.DevcontainerConfigName
+	state.CommitTrailers = runtime.CommitTrailers
 	state.RepoProvider = runtime.RepoProvider
 	state.CloneURL = runtime.CloneURL
 	state.RepositoryHost = runtime.RepositoryHost
 	state.RepositoryPath = runtime.RepositoryPath
+	state.Spec = runtime.ProvisionSpec
+	state.RepoCache = s.repoMirrors
+	state.DeployKey = runtime.DeployKey
+	state.KnownHosts = runtime.KnownHosts
+	state.SigningKey = runtime.SigningKey
+	state.EnvironmentTemplate = runtime.EnvironmentTemplate
+	state.ResourceLimits = runtime.ResourceLimits
+	state.EgressPolicy = runtime.EgressPolicy
+	state.RegistryCredentials = runtime.RegistryCredentials
+	state.DevcontainerPolicy = runtime.DevcontainerPolicy
+	state.Hooks = runtime.Hooks
 
 	_, err := prepareWorkspaceForRuntime(recoveryCtx, &cfg, state, nil)
 	if err != nil {
@@ -228,17 +225,43 @@ func (s *Server) recoverWorkspaceRuntime(ctx context.Context, runtime *Workspace
 	return nil
 }
 
+// workspaceBootstrapConfig returns the node config specialized for one
+// workspace, as passed to the bootstrap package.
+func (s *Server) workspaceBootstrapConfig(runtime *WorkspaceRuntime, callbackToken string) config.Config {
+	cfg := s.workspaceConfig()
+	cfg.WorkspaceID = runtime.ID
+	cfg.Repository = strings.TrimSpace(runtime.Repository)
+	cfg.Branch = strings.TrimSpace(runtime.Branch)
+	cfg.RepoProvider = strings.TrimSpace(runtime.RepoProvider)
+	cfg.CloneURL = strings.TrimSpace(runtime.CloneURL)
+	cfg.RepositoryHost = strings.TrimSpace(runtime.RepositoryHost)
+	cfg.RepositoryPath = strings.TrimSpace(runtime.RepositoryPath)
+	cfg.WorkspaceDir = strings.TrimSpace(runtime.WorkspaceDir)
+	cfg.ContainerLabelValue = strings.TrimSpace(runtime.ContainerLabelValue)
+	cfg.ContainerWorkDir = strings.TrimSpace(runtime.ContainerWorkDir)
+	cfg.ContainerUser = strings.TrimSpace(runtime.ContainerUser)
+	if cfg.ContainerUser == "" {
+		cfg.ContainerUser = strings.TrimSpace(s.config.ContainerUser)
+	}
+	cfg.CallbackToken = callbackToken
+	applyDevcontainerCacheCredentials(&cfg, runtime.DevcontainerCache)
+	return cfg
+}
+
 func applyDevcontainerCacheCredentials(cfg *config.Config, credentials DevcontainerCacheCredentials) {
-	if cfg == nil || credentials.Ref == "" {
+	if cfg == nil || (credentials.Ref == "" && credentials.PrebuildRef == "") {
 		return
 	}
-	cfg.DevcontainerCacheEnabled = true
 	if credentials.Registry != "" {
 		cfg.DevcontainerCacheRegistry = credentials.Registry
 	}
 	cfg.DevcontainerCacheUsername = credentials.Username
 	cfg.DevcontainerCachePassword = credentials.Password
-	cfg.DevcontainerCacheRef = credentials.Ref
+	if credentials.Ref != "" {
+		cfg.DevcontainerCacheEnabled = true
+		cfg.DevcontainerCacheRef = credentials.Ref
+	}
+	cfg.DevcontainerPrebuildRef = credentials.PrebuildRef
 }
 
 func (s *Server) hydrateWorkspaceRuntimeForRecovery(
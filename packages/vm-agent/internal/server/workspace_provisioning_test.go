@@ -99,9 +99,10 @@ func TestRecoverWorkspaceRuntimeUsesRuntimeConfig(t *testing.T) {
 			CallbackToken:   "node-callback-token",
 			ControlPlaneURL: controlPlane.URL,
 		},
-		workspaces: map[string]*WorkspaceRuntime{runtime.ID: runtime},
 	}
 
+	putWorkspaces(&s.workspaces, map[string]*WorkspaceRuntime{runtime.ID: runtime})
+
 	if err := s.recoverWorkspaceRuntime(context.Background(), runtime); err != nil {
 		t.Fatalf("recoverWorkspaceRuntime() error = %v", err)
 	}
@@ -226,9 +227,9 @@ func TestProvisionWorkspaceRuntimeAppliesDetectedContainerUser(t *testing.T) {
 			ContainerLabelKey:   "devcontainer.local_folder",
 			PTYOutputBufferSize: 1024,
 		},
-		workspaces:      map[string]*WorkspaceRuntime{runtime.ID: runtime},
 		workspaceEvents: map[string][]EventRecord{},
 	}
+	s.workspaces.Put(runtime.ID, runtime)
 
 	if _, err := s.provisionWorkspaceRuntime(context.Background(), runtime); err != nil {
 		t.Fatalf("provisionWorkspaceRuntime() error = %v", err)
@@ -284,9 +285,9 @@ func TestProvisionWorkspaceRuntimeFailsWhenGitTokenFetchFailsForGitHubRepo(t *te
 			ContainerLabelKey:   "devcontainer.local_folder",
 			PTYOutputBufferSize: 1024,
 		},
-		workspaces:      map[string]*WorkspaceRuntime{runtime.ID: runtime},
 		workspaceEvents: map[string][]EventRecord{},
 	}
+	s.workspaces.Put(runtime.ID, runtime)
 
 	_, err := s.provisionWorkspaceRuntime(context.Background(), runtime)
 	if err == nil {
@@ -357,9 +358,10 @@ func TestRecoverWorkspaceRuntimeHydratesMetadataAndAdoptsLegacyLayout(t *testing
 			ContainerLabelKey:   "devcontainer.local_folder",
 			PTYOutputBufferSize: 1024,
 		},
-		workspaces: map[string]*WorkspaceRuntime{runtime.ID: runtime},
 	}
 
+	putWorkspaces(&s.workspaces, map[string]*WorkspaceRuntime{runtime.ID: runtime})
+
 	if err := s.recoverWorkspaceRuntime(context.Background(), runtime); err != nil {
 		t.Fatalf("recoverWorkspaceRuntime() error = %v", err)
 	}
@@ -436,9 +438,10 @@ func TestRecoverWorkspaceRuntimeAdoptsLegacyLayoutFromBaseWorkspaceDir(t *testin
 			ContainerLabelKey:   "devcontainer.local_folder",
 			PTYOutputBufferSize: 1024,
 		},
-		workspaces: map[string]*WorkspaceRuntime{runtime.ID: runtime},
 	}
 
+	putWorkspaces(&s.workspaces, map[string]*WorkspaceRuntime{runtime.ID: runtime})
+
 	if err := s.recoverWorkspaceRuntime(context.Background(), runtime); err != nil {
 		t.Fatalf("recoverWorkspaceRuntime() error = %v", err)
 	}
@@ -562,9 +565,10 @@ func TestRecoverWorkspaceRuntimePropagatesFallbackFlag(t *testing.T) {
 			CallbackToken:   "node-callback-token",
 			ControlPlaneURL: controlPlane.URL,
 		},
-		workspaces: map[string]*WorkspaceRuntime{runtime.ID: runtime},
 	}
 
+	putWorkspaces(&s.workspaces, map[string]*WorkspaceRuntime{runtime.ID: runtime})
+
 	// recoverWorkspaceRuntime uses the mock variable prepareWorkspaceForRuntime
 	err := s.recoverWorkspaceRuntime(context.Background(), runtime)
 	if err != nil {
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// WorkspaceRegistry holds the workspaces hosted by this agent, keyed by
+// workspace ID. Each runtime carries the workspace's own volume, container
+// label, PTY manager, and credentials; session hosts, port scanners, events,
+// and activity are keyed by the same ID, so one agent can host any number of
+// isolated workspaces. The boot workspace (config WorkspaceID) is registered
+// like any other through PutBoot, which also makes it the workspace that
+// Resolve picks for requests that do not name one.
+//
+// The registry lock also guards the mutable fields of registered runtimes:
+// change them through Update, or read them inside Range. The zero value is
+// ready to use.
+type WorkspaceRegistry struct {
+	mu     sync.RWMutex
+	byID   map[string]*WorkspaceRuntime
+	bootID string
+}
+
+// Get returns the runtime registered for workspaceID.
+func (r *WorkspaceRegistry) Get(workspaceID string) (*WorkspaceRuntime, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	runtime, ok := r.byID[workspaceID]
+	return runtime, ok
+}
+
+// Put registers runtime under workspaceID, replacing any existing entry.
+func (r *WorkspaceRegistry) Put(workspaceID string, runtime *WorkspaceRuntime) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.putLocked(workspaceID, runtime)
+}
+
+// PutBoot registers runtime as the boot workspace: the one the node was
+// created or assigned for.
+func (r *WorkspaceRegistry) PutBoot(workspaceID string, runtime *WorkspaceRuntime) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.putLocked(workspaceID, runtime)
+	r.bootID = workspaceID
+}
+
+// BootID returns the boot workspace ID, or "" when the node has none.
+func (r *WorkspaceRegistry) BootID() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.bootID
+}
+
+// Resolve returns workspaceID trimmed, or the boot workspace ID when it is
+// empty. Handlers whose callers may omit the workspace use it instead of
+// falling back to the node config.
+func (r *WorkspaceRegistry) Resolve(workspaceID string) string {
+	if id := strings.TrimSpace(workspaceID); id != "" {
+		return id
+	}
+	return r.BootID()
+}
+
+func (r *WorkspaceRegistry) putLocked(workspaceID string, runtime *WorkspaceRuntime) {
+	if r.byID == nil {
+		r.byID = make(map[string]*WorkspaceRuntime)
+	}
+	r.byID[workspaceID] = runtime
+}
+
+// Delete unregisters workspaceID and returns the runtime it held.
+func (r *WorkspaceRegistry) Delete(workspaceID string) (*WorkspaceRuntime, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	runtime, ok := r.byID[workspaceID]
+	delete(r.byID, workspaceID)
+	return runtime, ok
+}
+
+// Update runs fn on the runtime registered for workspaceID while holding
+// the write lock. It reports whether the workspace was found.
+func (r *WorkspaceRegistry) Update(workspaceID string, fn func(*WorkspaceRuntime)) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	runtime, ok := r.byID[workspaceID]
+	if ok {
+		fn(runtime)
+	}
+	return ok
+}
+
+// View runs fn on the runtime registered for workspaceID while holding the
+// read lock; fn must not modify it. It reports whether the workspace was
+// found.
+func (r *WorkspaceRegistry) View(workspaceID string, fn func(*WorkspaceRuntime)) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	runtime, ok := r.byID[workspaceID]
+	if ok {
+		fn(runtime)
+	}
+	return ok
+}
+
+// Range calls fn for each registered workspace, in no particular order,
+// while holding the read lock. fn must not call back into the registry.
+func (r *WorkspaceRegistry) Range(fn func(workspaceID string, runtime *WorkspaceRuntime)) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for id, runtime := range r.byID {
+		fn(id, runtime)
+	}
+}
+
+// UpdateAll calls fn for each registered workspace while holding the write
+// lock. fn must not call back into the registry.
+func (r *WorkspaceRegistry) UpdateAll(fn func(workspaceID string, runtime *WorkspaceRuntime)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, runtime := range r.byID {
+		fn(id, runtime)
+	}
+}
+
+// IDs returns the registered workspace IDs in sorted order.
+func (r *WorkspaceRegistry) IDs() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ids := make([]string, 0, len(r.byID))
+	for id := range r.byID {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Len returns the number of registered workspaces.
+func (r *WorkspaceRegistry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.byID)
+}
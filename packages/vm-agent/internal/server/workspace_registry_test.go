@@ -0,0 +1,103 @@
+package server
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestWorkspaceRegistryZeroValue(t *testing.T) {
+	t.Parallel()
+	var r WorkspaceRegistry
+
+	if _, ok := r.Get("ws-1"); ok {
+		t.Fatal("Get on an empty registry found a workspace")
+	}
+	if r.Update("ws-1", func(*WorkspaceRuntime) { t.Fatal("Update ran fn for a missing workspace") }) {
+		t.Fatal("Update reported a missing workspace as found")
+	}
+
+	r.Put("ws-b", &WorkspaceRuntime{ID: "ws-b", Status: "running"})
+	r.Put("ws-a", &WorkspaceRuntime{ID: "ws-a", Status: "creating"})
+	if got, want := r.IDs(), []string{"ws-a", "ws-b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("IDs() = %v, want %v", got, want)
+	}
+	if r.Len() != 2 {
+		t.Fatalf("Len() = %d, want 2", r.Len())
+	}
+
+	r.Update("ws-a", func(runtime *WorkspaceRuntime) { runtime.Status = "running" })
+	running := 0
+	r.Range(func(_ string, runtime *WorkspaceRuntime) {
+		if runtime.Status == "running" {
+			running++
+		}
+	})
+	if running != 2 {
+		t.Fatalf("running workspaces = %d, want 2", running)
+	}
+
+	if removed, ok := r.Delete("ws-a"); !ok || removed.ID != "ws-a" {
+		t.Fatalf("Delete(ws-a) = %+v, %v", removed, ok)
+	}
+	if _, ok := r.Get("ws-a"); ok {
+		t.Fatal("ws-a still registered after Delete")
+	}
+}
+
+// Workspaces hosted on the same agent must not share runtime state.
+func TestWorkspaceRegistryIsolatesWorkspaces(t *testing.T) {
+	t.Parallel()
+	var r WorkspaceRegistry
+
+	var wg sync.WaitGroup
+	for _, id := range []string{"ws-1", "ws-2", "ws-3"} {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			r.Put(id, &WorkspaceRuntime{ID: id, WorkspaceDir: "/workspace/" + id})
+			r.Update(id, func(runtime *WorkspaceRuntime) {
+				runtime.CredentialRemotes = map[string]string{"origin": "org/" + id}
+			})
+		}(id)
+	}
+	wg.Wait()
+
+	for _, id := range r.IDs() {
+		r.View(id, func(runtime *WorkspaceRuntime) {
+			if runtime.WorkspaceDir != "/workspace/"+id || runtime.CredentialRemotes["origin"] != "org/"+id {
+				t.Errorf("workspace %s = dir %q remotes %v", id, runtime.WorkspaceDir, runtime.CredentialRemotes)
+			}
+		})
+	}
+}
+
+func TestWorkspaceRegistryResolvesToBootWorkspace(t *testing.T) {
+	t.Parallel()
+	var r WorkspaceRegistry
+
+	if got := r.Resolve(""); got != "" {
+		t.Fatalf("Resolve(\"\") without a boot workspace = %q, want empty", got)
+	}
+	r.Put("ws-other", &WorkspaceRuntime{ID: "ws-other"})
+	r.PutBoot("ws-boot", &WorkspaceRuntime{ID: "ws-boot"})
+	if got := r.BootID(); got != "ws-boot" {
+		t.Fatalf("BootID() = %q, want ws-boot", got)
+	}
+	if got := r.Resolve("  "); got != "ws-boot" {
+		t.Fatalf("Resolve(blank) = %q, want ws-boot", got)
+	}
+	if got := r.Resolve(" ws-other "); got != "ws-other" {
+		t.Fatalf("Resolve(ws-other) = %q, want ws-other", got)
+	}
+	if _, ok := r.Get("ws-boot"); !ok {
+		t.Fatal("PutBoot did not register the boot workspace")
+	}
+}
+
+// putWorkspaces registers runtimes in r, keyed as given.
+func putWorkspaces(r *WorkspaceRegistry, runtimes map[string]*WorkspaceRuntime) {
+	for id, runtime := range runtimes {
+		r.Put(id, runtime)
+	}
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/workspace/vm-agent/internal/bootstrap"
+	"github.com/workspace/vm-agent/internal/containerruntime"
+)
+
+// workspaceStatsTimeout bounds the docker stats call behind the resources
+// endpoint; docker stats samples for about a second before returning.
+const workspaceStatsTimeout = 10 * time.Second
+
+// workspaceResourceUsage is a devcontainer's current resource usage as
+// reported by docker stats.
+type workspaceResourceUsage struct {
+	CPUPercent    float64 `json:"cpuPercent"`
+	MemoryUsage   string  `json:"memoryUsage"`
+	MemoryPercent float64 `json:"memoryPercent"`
+	Pids          int64   `json:"pids"`
+}
+
+// workspaceContainerStats reads a container's resource usage. It is a
+// variable so tests can stub docker.
+var workspaceContainerStats = func(ctx context.Context, containerID string) (*workspaceResourceUsage, error) {
+	output, err := containerruntime.Command(ctx, "stats", "--no-stream", "--format",
+		`{"cpuPercent":"{{.CPUPerc}}","memUsage":"{{.MemUsage}}","memPercent":"{{.MemPerc}}","pids":"{{.PIDs}}"}`,
+		containerID).Output()
+	if err != nil {
+		return nil, fmt.Errorf("docker stats: %w", err)
+	}
+	var stats struct {
+		CPUPercent string `json:"cpuPercent"`
+		MemUsage   string `json:"memUsage"`
+		MemPercent string `json:"memPercent"`
+		Pids       string `json:"pids"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(string(output))), &stats); err != nil {
+		return nil, fmt.Errorf("failed to parse docker stats: %w", err)
+	}
+	pids, _ := strconv.ParseInt(strings.TrimSpace(stats.Pids), 10, 64)
+	return &workspaceResourceUsage{
+		CPUPercent:    parseStatsPercent(stats.CPUPercent),
+		MemoryUsage:   strings.TrimSpace(stats.MemUsage),
+		MemoryPercent: parseStatsPercent(stats.MemPercent),
+		Pids:          pids,
+	}, nil
+}
+
+func parseStatsPercent(s string) float64 {
+	v, _ := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	return v
+}
+
+// handleGetWorkspaceResources returns a workspace's devcontainer resource
+// limits and current usage. Usage is omitted, with usageError set, when the
+// container cannot be queried.
+func (s *Server) handleGetWorkspaceResources(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.PathValue("workspaceId")
+	if workspaceID == "" {
+		writeError(w, http.StatusBadRequest, workspaceIDRequiredMessage)
+		return
+	}
+	if !s.checkWorkspaceRequestAuth(r, workspaceID) {
+		if !s.requireNodeManagementAuth(w, r, workspaceID) {
+			return
+		}
+	}
+	runtime, ok := s.getWorkspaceRuntime(workspaceID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "workspace not found")
+		return
+	}
+
+	resp := map[string]interface{}{
+		"limits": bootstrap.EffectiveResourceLimits(s.config, runtime.ResourceLimits),
+	}
+	containerID, _, _, err := s.resolveContainerForWorkspace(workspaceID)
+	if err == nil && containerID == "" {
+		err = fmt.Errorf("workspace has no devcontainer")
+	}
+	if err == nil {
+		ctx, cancel := context.WithTimeout(r.Context(), workspaceStatsTimeout)
+		defer cancel()
+		var usage *workspaceResourceUsage
+		if usage, err = workspaceContainerStats(ctx, containerID); err == nil {
+			resp["usage"] = usage
+		}
+	}
+	if err != nil {
+		resp["usageError"] = err.Error()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/workspace/vm-agent/internal/bootstrap"
+)
+
+func TestGetWorkspaceResourcesMergesLimits(t *testing.T) {
+	s, _, cookieSessionID := newAgentWSTestServer(t)
+	s.config.WorkspaceCPULimit = "2"
+	s.config.WorkspaceMemoryLimit = "4g"
+	s.workspaces.Put("WS_TEST", &WorkspaceRuntime{
+		ID:             "WS_TEST",
+		Status:         "running",
+		ResourceLimits: &bootstrap.ResourceLimits{Memory: "8g", Pids: 512},
+	})
+
+	mux := http.NewServeMux()
+	s.setupRoutes(mux)
+	req := httptest.NewRequest(http.MethodGet, "/workspaces/WS_TEST/resources", nil)
+	req.Header.Set("Cookie", "session="+cookieSessionID)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var body struct {
+		Limits     bootstrap.ResourceLimits `json:"limits"`
+		Usage      json.RawMessage          `json:"usage"`
+		UsageError string                   `json:"usageError"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	want := bootstrap.ResourceLimits{CPUs: "2", Memory: "8g", Pids: 512}
+	if body.Limits != want {
+		t.Fatalf("limits = %+v, want %+v", body.Limits, want)
+	}
+	// The test server has no container mode, so usage cannot be read.
+	if body.Usage != nil || body.UsageError == "" {
+		t.Fatalf("usage = %s, usageError = %q", body.Usage, body.UsageError)
+	}
+}
@@ -11,9 +11,12 @@ import (
 	"time"
 
 	"github.com/workspace/vm-agent/internal/agentsessions"
+	"github.com/workspace/vm-agent/internal/bootstrap"
 	"github.com/workspace/vm-agent/internal/container"
+	"github.com/workspace/vm-agent/internal/egress"
 	"github.com/workspace/vm-agent/internal/eventstore"
 	"github.com/workspace/vm-agent/internal/persistence"
+	"github.com/workspace/vm-agent/internal/provisionspec"
 	"github.com/workspace/vm-agent/internal/pty"
 )
 
@@ -41,6 +44,18 @@ type workspaceRuntimeOpts struct {
 	Lightweight            bool
 	DevcontainerConfigName string
 	DevcontainerCache      DevcontainerCacheCredentials
+	CommitTrailers         *bool
+	ProvisionSpec          *provisionspec.Spec
+	SnapshotURL            string
+	DeployKey              string
+	KnownHosts             string
+	SigningKey             string
+	EnvironmentTemplate    *bootstrap.EnvironmentTemplate
+	ResourceLimits         *bootstrap.ResourceLimits
+	EgressPolicy           *egress.Policy
+	RegistryCredentials    bootstrap.RegistryCredentials
+	DevcontainerPolicy     *bootstrap.DevcontainerPolicy
+	Hooks                  bootstrap.Hooks
 }
 
 func (s *Server) routedNodeID(r *http.Request) string {
@@ -159,10 +174,7 @@ func (s *Server) checkWorkspaceRequestAuth(r *http.Request, workspaceID string)
 }
 
 func (s *Server) getWorkspaceRuntime(workspaceID string) (*WorkspaceRuntime, bool) {
-	s.workspaceMu.RLock()
-	defer s.workspaceMu.RUnlock()
-	runtime, ok := s.workspaces[workspaceID]
-	return runtime, ok
+	return s.workspaces.Get(workspaceID)
 }
 
 func (s *Server) upsertWorkspaceRuntime(workspaceID, repository, branch, status, callbackToken string, opts ...workspaceRuntimeOpts) *WorkspaceRuntime {
@@ -170,12 +182,9 @@ func (s *Server) upsertWorkspaceRuntime(workspaceID, repository, branch, status,
 	if len(opts) > 0 {
 		opt = opts[0]
 	}
-	s.workspaceMu.Lock()
-	defer s.workspaceMu.Unlock()
+	s.workspaces.mu.Lock()
+	defer s.workspaces.mu.Unlock()
 
-	if s.workspaces == nil {
-		s.workspaces = make(map[string]*WorkspaceRuntime)
-	}
 	if s.workspaceEvents == nil {
 		s.workspaceEvents = make(map[string][]EventRecord)
 	}
@@ -183,7 +192,7 @@ func (s *Server) upsertWorkspaceRuntime(workspaceID, repository, branch, status,
 		s.agentSessions = agentsessions.NewManager()
 	}
 
-	runtime, ok := s.workspaces[workspaceID]
+	runtime, ok := s.workspaces.byID[workspaceID]
 	if ok {
 		metadataChanged := false
 		if repository != "" {
@@ -242,10 +251,44 @@ func (s *Server) upsertWorkspaceRuntime(workspaceID, repository, branch, status,
 			metadataChanged = true
 		}
 		runtime.Lightweight = opt.Lightweight
+		if opt.CommitTrailers != nil {
+			runtime.CommitTrailers = opt.CommitTrailers
+		}
 		if opt.DevcontainerConfigName != "" {
 			runtime.DevcontainerConfigName = opt.DevcontainerConfigName
 		}
-		if opt.DevcontainerCache.Ref != "" {
+		if opt.ProvisionSpec != nil {
+			runtime.ProvisionSpec = opt.ProvisionSpec
+		}
+		if opt.SnapshotURL != "" {
+			runtime.SnapshotURL = opt.SnapshotURL
+		}
+		if opt.DeployKey != "" {
+			runtime.DeployKey = opt.DeployKey
+			runtime.KnownHosts = opt.KnownHosts
+		}
+		if opt.SigningKey != "" {
+			runtime.SigningKey = opt.SigningKey
+		}
+		if opt.EnvironmentTemplate != nil {
+			runtime.EnvironmentTemplate = opt.EnvironmentTemplate
+		}
+		if opt.ResourceLimits != nil {
+			runtime.ResourceLimits = opt.ResourceLimits
+		}
+		if opt.EgressPolicy != nil {
+			runtime.EgressPolicy = opt.EgressPolicy
+		}
+		if len(opt.RegistryCredentials) > 0 {
+			runtime.RegistryCredentials = opt.RegistryCredentials
+		}
+		if opt.DevcontainerPolicy != nil {
+			runtime.DevcontainerPolicy = opt.DevcontainerPolicy
+		}
+		if opt.Hooks != nil {
+			runtime.Hooks = opt.Hooks
+		}
+		if opt.DevcontainerCache.Ref != "" || opt.DevcontainerCache.PrebuildRef != "" {
 			runtime.DevcontainerCache = opt.DevcontainerCache
 		}
 		runtime.UpdatedAt = time.Now().UTC()
@@ -265,6 +308,7 @@ func (s *Server) upsertWorkspaceRuntime(workspaceID, repository, branch, status,
 	var persistedRepoProvider, persistedCloneURL, persistedRepositoryHost, persistedRepositoryPath string
 	var persistedLightweight bool
 	var persistedDevcontainerConfigName string
+	var persistedCredentialRemotes map[string]string
 
 	if s.store != nil {
 		meta, err := s.store.GetWorkspaceMetadata(workspaceID)
@@ -291,6 +335,7 @@ func (s *Server) upsertWorkspaceRuntime(workspaceID, repository, branch, status,
 			persistedRepositoryPath = meta.RepositoryPath
 			persistedLightweight = meta.Lightweight
 			persistedDevcontainerConfigName = meta.DevcontainerConfigName
+			persistedCredentialRemotes = meta.CredentialRemotes
 		}
 	}
 
@@ -335,9 +380,22 @@ func (s *Server) upsertWorkspaceRuntime(workspaceID, repository, branch, status,
 		Lightweight:            opt.Lightweight || persistedLightweight,
 		DevcontainerConfigName: firstNonEmpty(opt.DevcontainerConfigName, persistedDevcontainerConfigName),
 		DevcontainerCache:      opt.DevcontainerCache,
+		CommitTrailers:         opt.CommitTrailers,
+		ProvisionSpec:          opt.ProvisionSpec,
+		SnapshotURL:            opt.SnapshotURL,
+		DeployKey:              opt.DeployKey,
+		KnownHosts:             opt.KnownHosts,
+		SigningKey:             opt.SigningKey,
+		EnvironmentTemplate:    opt.EnvironmentTemplate,
+		ResourceLimits:         opt.ResourceLimits,
+		EgressPolicy:           opt.EgressPolicy,
+		RegistryCredentials:    opt.RegistryCredentials,
+		DevcontainerPolicy:     opt.DevcontainerPolicy,
+		Hooks:                  opt.Hooks,
+		CredentialRemotes:      persistedCredentialRemotes,
 		PTY:                    manager,
 	}
-	s.workspaces[workspaceID] = runtime
+	s.workspaces.putLocked(workspaceID, runtime)
 
 	if effectiveRepo != "" {
 		s.persistWorkspaceMetadata(runtime)
@@ -369,7 +427,7 @@ func (s *Server) newPTYManagerForWorkspace(
 		ContainerResolver:  s.ptyManagerContainerResolverForLabel(containerLabelValue),
 		ContainerUser:      resolvedContainerUser,
 		GracePeriod:        s.config.PTYOrphanGracePeriod,
-		BufferSize:         s.config.PTYOutputBufferSize,
+		BufferSize:         s.currentSettings().PTYOutputBufferSize,
 		SessionIDMaxLength: s.config.TerminalSessionIDMaxLength,
 		CloseGrace:         s.config.PTYCloseGracePeriod,
 	}
@@ -388,7 +446,7 @@ func (s *Server) shouldReusePrimaryPTYManager(workspaceID, workspaceDir, contain
 	}
 
 	// Preserve compatibility with legacy single-workspace host mode.
-	if !s.config.ContainerMode && len(s.workspaces) == 0 {
+	if !s.config.ContainerMode && len(s.workspaces.byID) == 0 {
 		return true
 	}
 
@@ -448,39 +506,42 @@ func (s *Server) rebuildWorkspacePTYManager(runtime *WorkspaceRuntime) {
 // It only sets the new status if the current status is one of the expected values.
 // Returns true if the transition was applied, false if the current status did not match.
 func (s *Server) casWorkspaceStatus(workspaceID string, expectedStatuses []string, newStatus string) bool {
-	s.workspaceMu.Lock()
-	defer s.workspaceMu.Unlock()
-
-	runtime, ok := s.workspaces[workspaceID]
-	if !ok {
-		return false
-	}
-
-	for _, expected := range expectedStatuses {
-		if runtime.Status == expected {
-			runtime.Status = newStatus
-			runtime.UpdatedAt = nowUTC()
-			return true
+	swapped := false
+	s.workspaces.Update(workspaceID, func(runtime *WorkspaceRuntime) {
+		for _, expected := range expectedStatuses {
+			if runtime.Status == expected {
+				runtime.Status = newStatus
+				runtime.UpdatedAt = nowUTC()
+				swapped = true
+				return
+			}
 		}
-	}
-	return false
+	})
+	return swapped
 }
 
 func (s *Server) removeWorkspaceRuntime(workspaceID string) {
-	s.workspaceMu.Lock()
-	defer s.workspaceMu.Unlock()
+	s.workspaces.mu.Lock()
+	defer s.workspaces.mu.Unlock()
 
-	if runtime, ok := s.workspaces[workspaceID]; ok {
+	if runtime, ok := s.workspaces.byID[workspaceID]; ok {
 		runtime.PTY.CloseAllSessions()
-		delete(s.workspaces, workspaceID)
+		delete(s.workspaces.byID, workspaceID)
 	}
 	delete(s.workspaceEvents, workspaceID)
 	s.agentSessions.RemoveWorkspace(workspaceID)
+	s.activityTracker.Forget(workspaceID)
 
 	if s.store != nil {
 		if err := s.store.DeleteWorkspaceMetadata(workspaceID); err != nil {
 			slog.Warn("Failed to delete persisted workspace metadata", "workspace", workspaceID, "error", err)
 		}
+		if err := s.store.DeleteOfflineEntries(workspaceID); err != nil {
+			slog.Warn("Failed to delete cached offline entries", "workspace", workspaceID, "error", err)
+		}
+		if err := s.store.DeleteWorkspaceCallbacks(workspaceID); err != nil {
+			slog.Warn("Failed to delete queued callbacks", "workspace", workspaceID, "error", err)
+		}
 	}
 }
 
@@ -506,6 +567,7 @@ func (s *Server) persistWorkspaceMetadata(runtime *WorkspaceRuntime) {
 		RepositoryPath:         runtime.RepositoryPath,
 		Lightweight:            runtime.Lightweight,
 		DevcontainerConfigName: runtime.DevcontainerConfigName,
+		CredentialRemotes:      runtime.CredentialRemotes,
 	}); err != nil {
 		slog.Warn("Failed to persist workspace metadata", "workspace", runtime.ID, "error", err)
 	}
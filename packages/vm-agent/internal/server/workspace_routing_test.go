@@ -51,7 +51,6 @@ func TestShouldReusePrimaryPTYManager(t *testing.T) {
 			ContainerLabelValue: "/workspace",
 		},
 		ptyManager: legacyManager,
-		workspaces: map[string]*WorkspaceRuntime{},
 	}
 
 	if !s.shouldReusePrimaryPTYManager("WS_LEGACY", "/workspace", "/workspaces", "/workspace") {
@@ -150,7 +149,6 @@ func TestWorkspaceDirForRepo(t *testing.T) {
 					WorkspaceID:  tt.configWsID,
 					WorkspaceDir: tt.configWsDir,
 				},
-				workspaces: map[string]*WorkspaceRuntime{},
 			}
 			got := s.workspaceDirForRepo(tt.workspaceID, tt.repository)
 			if got != tt.want {
@@ -168,7 +166,6 @@ func TestUpsertWorkspaceRuntimeUsesCanonicalWorkspaceIdentityForPathsAndLabels(t
 		config: &config.Config{
 			WorkspaceDir: "/workspace",
 		},
-		workspaces:      map[string]*WorkspaceRuntime{},
 		workspaceEvents: map[string][]EventRecord{},
 	}
 
@@ -209,27 +206,28 @@ func TestCasWorkspaceStatus(t *testing.T) {
 		config: &config.Config{
 			NodeID: "node-1",
 		},
-		workspaces: map[string]*WorkspaceRuntime{
-			"ws-running": {
-				ID:     "ws-running",
-				Status: "running",
-				PTY:    ptyManager,
-			},
-			"ws-creating": {
-				ID:     "ws-creating",
-				Status: "creating",
-				PTY:    ptyManager,
-			},
-			"ws-stopped": {
-				ID:     "ws-stopped",
-				Status: "stopped",
-				PTY:    ptyManager,
-			},
-		},
 		nodeEvents:      make([]EventRecord, 0),
 		workspaceEvents: map[string][]EventRecord{},
 	}
 
+	putWorkspaces(&s.workspaces, map[string]*WorkspaceRuntime{
+		"ws-running": {
+			ID:     "ws-running",
+			Status: "running",
+			PTY:    ptyManager,
+		},
+		"ws-creating": {
+			ID:     "ws-creating",
+			Status: "creating",
+			PTY:    ptyManager,
+		},
+		"ws-stopped": {
+			ID:     "ws-stopped",
+			Status: "stopped",
+			PTY:    ptyManager,
+		},
+	})
+
 	// Transition running -> stopped should succeed
 	if !s.casWorkspaceStatus("ws-running", []string{"running", "creating"}, "stopped") {
 		t.Fatal("expected CAS from running to stopped to succeed")
@@ -268,7 +266,6 @@ func TestAppendNodeEventRespectsConfigLimits(t *testing.T) {
 			MaxNodeEvents:      3,
 			MaxWorkspaceEvents: 2,
 		},
-		workspaces:      map[string]*WorkspaceRuntime{},
 		nodeEvents:      make([]EventRecord, 0),
 		workspaceEvents: map[string][]EventRecord{},
 	}
@@ -310,7 +307,6 @@ func TestNewPTYManagerForWorkspaceSkipsLegacyReuseAfterRuntimeUpdate(t *testing.
 			ContainerLabelKey:   "devcontainer.local_folder",
 		},
 		ptyManager: legacyManager,
-		workspaces: map[string]*WorkspaceRuntime{},
 	}
 
 	migratedManager := s.newPTYManagerForWorkspace(
@@ -358,7 +354,6 @@ func TestUpsertWorkspaceRuntimeHydratesFromSQLite(t *testing.T) {
 		config: &config.Config{
 			WorkspaceDir: "/workspace",
 		},
-		workspaces:      map[string]*WorkspaceRuntime{},
 		workspaceEvents: map[string][]EventRecord{},
 		store:           store,
 	}
@@ -395,7 +390,6 @@ func TestUpsertWorkspaceRuntimeSetsLightweightFlag(t *testing.T) {
 		config: &config.Config{
 			WorkspaceDir: "/workspace",
 		},
-		workspaces:      map[string]*WorkspaceRuntime{},
 		workspaceEvents: map[string][]EventRecord{},
 	}
 
@@ -431,7 +425,6 @@ func TestUpsertWorkspaceRuntimeLightweightPersistsToSQLite(t *testing.T) {
 		config: &config.Config{
 			WorkspaceDir: "/workspace",
 		},
-		workspaces:      map[string]*WorkspaceRuntime{},
 		workspaceEvents: map[string][]EventRecord{},
 		store:           store,
 	}
@@ -486,7 +479,6 @@ func TestUpsertWorkspaceRuntimeHydratesLightweightFromSQLite(t *testing.T) {
 		config: &config.Config{
 			WorkspaceDir: "/workspace",
 		},
-		workspaces:      map[string]*WorkspaceRuntime{},
 		workspaceEvents: map[string][]EventRecord{},
 		store:           store,
 	}
@@ -506,7 +498,6 @@ func TestUpsertWorkspaceRuntimeWithoutStoreFallsBackToDerivation(t *testing.T) {
 		config: &config.Config{
 			WorkspaceDir: "/workspace",
 		},
-		workspaces:      map[string]*WorkspaceRuntime{},
 		workspaceEvents: map[string][]EventRecord{},
 		store:           nil, // No store available
 	}
@@ -540,7 +531,6 @@ func TestUpsertWorkspaceRuntimePersistsMetadata(t *testing.T) {
 		config: &config.Config{
 			WorkspaceDir: "/workspace",
 		},
-		workspaces:      map[string]*WorkspaceRuntime{},
 		workspaceEvents: map[string][]EventRecord{},
 		store:           store,
 	}
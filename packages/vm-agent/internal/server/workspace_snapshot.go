@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/workspace/vm-agent/internal/bootstrap"
+)
+
+var snapshotWorkspaceVolume = bootstrap.SnapshotVolume
+
+type workspaceSnapshotRequest struct {
+	// UploadURL is a presigned object storage URL the archive is PUT to.
+	UploadURL string `json:"uploadUrl"`
+}
+
+type workspaceSnapshotResponse struct {
+	WorkspaceID string `json:"workspaceId"`
+	SizeBytes   int64  `json:"sizeBytes"`
+	SHA256      string `json:"sha256"`
+}
+
+// handleSnapshotWorkspace handles POST /workspaces/{workspaceId}/snapshot.
+// It archives the workspace's Docker volume and uploads it to the
+// control-plane-provided URL, for migration to another node or disaster
+// recovery. The call blocks until the upload finishes. The control plane
+// should stop the workspace first when it needs a consistent copy.
+func (s *Server) handleSnapshotWorkspace(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.PathValue("workspaceId")
+	if workspaceID == "" {
+		writeError(w, http.StatusBadRequest, workspaceIDRequiredMessage)
+		return
+	}
+
+	if !s.requireNodeManagementAuth(w, r, workspaceID) {
+		return
+	}
+
+	if _, ok := s.getWorkspaceRuntime(workspaceID); !ok {
+		writeError(w, http.StatusNotFound, "workspace not found")
+		return
+	}
+	if !s.config.ContainerMode {
+		writeError(w, http.StatusConflict, "snapshots require container mode")
+		return
+	}
+
+	var body workspaceSnapshotRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	body.UploadURL = strings.TrimSpace(body.UploadURL)
+	if err := bootstrap.ValidateSnapshotURL(body.UploadURL); err != nil {
+		writeError(w, http.StatusBadRequest, "uploadUrl: "+err.Error())
+		return
+	}
+
+	ctx := r.Context()
+	if s.config.WorkspaceSnapshotTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.config.WorkspaceSnapshotTimeout)
+		defer cancel()
+	}
+
+	info, err := snapshotWorkspaceVolume(ctx, workspaceID, body.UploadURL)
+	if err != nil {
+		slog.Error("Workspace snapshot failed", "workspace", workspaceID, "error", err)
+		s.appendNodeEvent(workspaceID, "error", "workspace.snapshot_failed", "Workspace snapshot failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		writeError(w, http.StatusBadGateway, "snapshot failed: "+err.Error())
+		return
+	}
+
+	s.appendNodeEvent(workspaceID, "info", "workspace.snapshot_created", "Workspace snapshot uploaded", map[string]interface{}{
+		"sizeBytes": info.SizeBytes,
+		"sha256":    info.SHA256,
+	})
+	writeJSON(w, http.StatusOK, workspaceSnapshotResponse{
+		WorkspaceID: workspaceID,
+		SizeBytes:   info.SizeBytes,
+		SHA256:      info.SHA256,
+	})
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/workspace/vm-agent/internal/bootlog"
+	"github.com/workspace/vm-agent/internal/bootstrap"
+	"github.com/workspace/vm-agent/internal/config"
+)
+
+func TestCreateWorkspaceCarriesSnapshotURL(t *testing.T) {
+	originalPrepare := prepareWorkspaceForRuntime
+	defer func() { prepareWorkspaceForRuntime = originalPrepare }()
+
+	states := make(chan bootstrap.ProvisionState, 1)
+	prepareWorkspaceForRuntime = func(_ context.Context, _ *config.Config, state bootstrap.ProvisionState, _ *bootlog.Reporter) (bool, error) {
+		states <- state
+		return false, nil
+	}
+
+	controlPlane := newWorkspaceCreateControlPlane(t)
+	validator, privateKey := newWorkspaceCreateJWTValidator(t, "node-1")
+	s := newWorkspaceCreateServer(t, controlPlane.URL, validator)
+	token := signWorkspaceCreateNodeToken(t, privateKey, "node-1", "ws-restore")
+
+	post := func(snapshotURL string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]interface{}{
+			"workspaceId":   "ws-restore",
+			"repository":    "owner/repo",
+			"callbackToken": "callback-token",
+			"snapshotUrl":   snapshotURL,
+		})
+		req := httptest.NewRequest(http.MethodPost, "/workspaces", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("X-SAM-Node-Id", "node-1")
+		req.Header.Set("X-SAM-Workspace-Id", "ws-restore")
+		rec := httptest.NewRecorder()
+		s.handleCreateWorkspace(rec, req)
+		return rec
+	}
+
+	if rec := post("file:///tmp/snap.tar.gz"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("non-http snapshotUrl = %d %s, want 400", rec.Code, rec.Body.String())
+	}
+
+	const snapshotURL = "https://storage.example.com/snapshots/ws-old.tar.gz?X-Amz-Signature=abc"
+	if rec := post(snapshotURL); rec.Code != http.StatusAccepted {
+		t.Fatalf("create = %d %s, want 202", rec.Code, rec.Body.String())
+	}
+	if state := <-states; state.SnapshotURL != snapshotURL {
+		t.Fatalf("provision state SnapshotURL = %q, want %q", state.SnapshotURL, snapshotURL)
+	}
+	waitForProvisioningInactive(t, s, "ws-restore")
+}
+
+func TestSnapshotWorkspaceEndpoint(t *testing.T) {
+	originalSnapshot := snapshotWorkspaceVolume
+	defer func() { snapshotWorkspaceVolume = originalSnapshot }()
+
+	var gotWorkspace, gotURL string
+	snapshotErr := error(nil)
+	snapshotWorkspaceVolume = func(_ context.Context, workspaceID, uploadURL string) (bootstrap.SnapshotInfo, error) {
+		gotWorkspace, gotURL = workspaceID, uploadURL
+		if snapshotErr != nil {
+			return bootstrap.SnapshotInfo{}, snapshotErr
+		}
+		return bootstrap.SnapshotInfo{SizeBytes: 42, SHA256: "deadbeef"}, nil
+	}
+
+	validator, privateKey := newWorkspaceCreateJWTValidator(t, "node-1")
+	s := newWorkspaceCreateServer(t, "", validator)
+	s.config.ContainerMode = true
+	s.workspaces.Put("ws-1", &WorkspaceRuntime{ID: "ws-1", Status: "stopped"})
+	token := signWorkspaceCreateNodeToken(t, privateKey, "node-1", "ws-1")
+	mux := http.NewServeMux()
+	s.setupRoutes(mux)
+
+	do := func(workspaceID, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/workspaces/"+workspaceID+"/snapshot", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("X-SAM-Node-Id", "node-1")
+		req.Header.Set("X-SAM-Workspace-Id", workspaceID)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do("ws-1", `{"uploadUrl":"not a url"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid uploadUrl = %d %s, want 400", rec.Code, rec.Body.String())
+	}
+
+	const uploadURL = "https://storage.example.com/snapshots/ws-1.tar.gz?X-Amz-Signature=abc"
+	rec := do("ws-1", `{"uploadUrl":"`+uploadURL+`"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("snapshot = %d %s, want 200", rec.Code, rec.Body.String())
+	}
+	var resp workspaceSnapshotResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if gotWorkspace != "ws-1" || gotURL != uploadURL || resp.SizeBytes != 42 || resp.SHA256 != "deadbeef" {
+		t.Fatalf("snapshot call = (%q, %q), response = %+v", gotWorkspace, gotURL, resp)
+	}
+
+	snapshotErr = errors.New("upload returned HTTP 403")
+	if rec := do("ws-1", `{"uploadUrl":"`+uploadURL+`"}`); rec.Code != http.StatusBadGateway {
+		t.Fatalf("failed snapshot = %d %s, want 502", rec.Code, rec.Body.String())
+	}
+
+	s.config.ContainerMode = false
+	if rec := do("ws-1", `{"uploadUrl":"`+uploadURL+`"}`); rec.Code != http.StatusConflict {
+		t.Fatalf("snapshot without container mode = %d, want 409", rec.Code)
+	}
+}
@@ -1,21 +1,27 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
-	"os/exec"
 	"runtime"
 	"strings"
+	"syscall"
 
 	"github.com/workspace/vm-agent/internal/acp"
 	"github.com/workspace/vm-agent/internal/agentsessions"
 	"github.com/workspace/vm-agent/internal/bootstrap"
 	"github.com/workspace/vm-agent/internal/config"
+	"github.com/workspace/vm-agent/internal/containerruntime"
+	"github.com/workspace/vm-agent/internal/egress"
+	"github.com/workspace/vm-agent/internal/lifecyclehook"
 	"github.com/workspace/vm-agent/internal/persistence"
+	"github.com/workspace/vm-agent/internal/provisionspec"
 	"github.com/workspace/vm-agent/internal/sysinfo"
 )
 
@@ -59,17 +65,21 @@ func (s *Server) removeWorkspaceContainer(workspaceID string) {
 	ctx := context.Background()
 
 	// Find all containers (running or stopped) matching the label.
-	cmd := exec.CommandContext(ctx, "docker", "ps", "-aq", "--filter", filter)
-	output, err := cmd.Output()
+	containers, err := containerruntime.Current().ListContainers(ctx, true, filter)
 	if err != nil {
 		slog.Warn("Failed to list containers for workspace", "workspace", workspaceID, "error", err)
 		return
 	}
 
-	containers := strings.Fields(string(output))
 	for _, id := range containers {
+		if s.config.SecretFilesTmpfs {
+			// Best-effort: a stopped container's tmpfs is already gone.
+			if err := bootstrap.ZeroSecretFiles(ctx, id); err != nil {
+				slog.Debug("Could not zero secret files before removing container", "containerId", id, "error", err)
+			}
+		}
 		slog.Info("Removing container", "containerId", id, "workspace", workspaceID)
-		rmCmd := exec.CommandContext(ctx, "docker", "rm", "-f", id)
+		rmCmd := containerruntime.Command(ctx, "rm", "-f", id)
 		if rmOutput, rmErr := rmCmd.CombinedOutput(); rmErr != nil {
 			slog.Warn("Failed to remove container", "containerId", id, "error", rmErr, "output", strings.TrimSpace(string(rmOutput)))
 		}
@@ -90,6 +100,7 @@ func (s *Server) stopSessionHostsForWorkspace(workspaceID string) {
 		delete(s.sessionProfileOvr, key)
 		delete(s.sessionTaskCtx, key)
 	}
+	s.stopWarmStandbyLocked(workspaceID)
 	s.sessionHostMu.Unlock()
 
 	// Clean up all persisted MCP servers for this workspace (best-effort).
@@ -145,9 +156,6 @@ func (s *Server) handleListWorkspaces(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.workspaceMu.RLock()
-	defer s.workspaceMu.RUnlock()
-
 	type workspaceSummary struct {
 		ID         string `json:"id"`
 		Repository string `json:"repository,omitempty"`
@@ -158,8 +166,8 @@ func (s *Server) handleListWorkspaces(w http.ResponseWriter, r *http.Request) {
 		Sessions   int    `json:"sessions"`
 	}
 
-	result := make([]workspaceSummary, 0, len(s.workspaces))
-	for _, runtime := range s.workspaces {
+	result := make([]workspaceSummary, 0, s.workspaces.Len())
+	s.workspaces.Range(func(_ string, runtime *WorkspaceRuntime) {
 		result = append(result, workspaceSummary{
 			ID:         runtime.ID,
 			Repository: runtime.Repository,
@@ -169,7 +177,7 @@ func (s *Server) handleListWorkspaces(w http.ResponseWriter, r *http.Request) {
 			UpdatedAt:  runtime.UpdatedAt.Format(timeRFC3339),
 			Sessions:   runtime.PTY.SessionCount(),
 		})
-	}
+	})
 
 	writeJSON(w, http.StatusOK, map[string]interface{}{"workspaces": result})
 }
@@ -199,18 +207,17 @@ func workspaceCreateConflict(runtime *WorkspaceRuntime, repository, branch, devc
 }
 
 func (s *Server) maybeHandleDuplicateWorkspaceCreate(w http.ResponseWriter, workspaceID, repository, branch, devcontainerConfigName string, lightweight bool) bool {
-	s.workspaceMu.RLock()
-	runtime, ok := s.workspaces[workspaceID]
+	var status, conflict string
+	var provisioningActive bool
+	ok := s.workspaces.View(workspaceID, func(runtime *WorkspaceRuntime) {
+		status = runtime.Status
+		provisioningActive = runtime.ProvisioningActive
+		conflict = workspaceCreateConflict(runtime, repository, branch, devcontainerConfigName, lightweight)
+	})
 	if !ok {
-		s.workspaceMu.RUnlock()
 		return false
 	}
 
-	status := runtime.Status
-	provisioningActive := runtime.ProvisioningActive
-	conflict := workspaceCreateConflict(runtime, repository, branch, devcontainerConfigName, lightweight)
-	s.workspaceMu.RUnlock()
-
 	if conflict != "" {
 		writeJSON(w, http.StatusConflict, map[string]interface{}{
 			"error":       "workspace_conflict",
@@ -258,9 +265,46 @@ type resourceDiagnostics struct {
 	CPUSaturated bool                  `json:"cpuSaturated"`
 	MemExhausted bool                  `json:"memExhausted"`
 	DiskFull     bool                  `json:"diskFull"`
+	Reason       string                `json:"reason"` // "timeout" or "disk_full"
 	Message      string                `json:"message"`
 }
 
+// buildFailureDiagnostics explains a provisioning failure. Failures caused by
+// the VM running out of disk space are reported as such; timeouts are
+// enriched by buildTimeoutDiagnostics. Other errors are returned unchanged
+// with nil diagnostics.
+func (s *Server) buildFailureDiagnostics(err error) (string, *resourceDiagnostics) {
+	if isNoSpaceError(err) {
+		return s.buildDiskFullDiagnostics(err)
+	}
+	return s.buildTimeoutDiagnostics(err)
+}
+
+// isNoSpaceError reports whether err is ENOSPC. Docker and the devcontainer
+// CLI only surface it in their output, so the message is matched as well.
+func isNoSpaceError(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || strings.Contains(strings.ToLower(err.Error()), "no space left on device")
+}
+
+// buildDiskFullDiagnostics describes a build that failed because the disk
+// filled up, with the current disk usage when it can be collected.
+func (s *Server) buildDiskFullDiagnostics(err error) (string, *resourceDiagnostics) {
+	diag := &resourceDiagnostics{
+		NumCPU:   runtime.NumCPU(),
+		DiskFull: true,
+		Reason:   "disk_full",
+	}
+	var msg strings.Builder
+	msg.WriteString("Workspace build failed: the VM ran out of disk space")
+	if metrics, collectErr := s.sysInfoCollector.CollectQuick(); collectErr == nil {
+		diag.Metrics = metrics
+		fmt.Fprintf(&msg, " (disk %.0f%% used)", metrics.DiskPercent)
+	}
+	fmt.Fprintf(&msg, ". Delete unused workspaces on this node or use a larger VM size. Error: %s", err.Error())
+	diag.Message = msg.String()
+	return diag.Message, diag
+}
+
 // buildTimeoutDiagnostics enriches a timeout error with resource usage information.
 // If the error is not a deadline exceeded or sysinfo collection fails, it returns
 // the original error message unchanged and nil diagnostics.
@@ -290,6 +334,7 @@ func (s *Server) buildTimeoutDiagnostics(err error) (string, *resourceDiagnostic
 		CPUSaturated: cpuPerCore > s.config.DiagCPUSaturationThreshold,
 		MemExhausted: metrics.MemoryPercent > s.config.DiagMemExhaustedThreshold,
 		DiskFull:     metrics.DiskPercent > s.config.DiagDiskFullThreshold,
+		Reason:       "timeout",
 	}
 
 	var msg strings.Builder
@@ -315,6 +360,7 @@ func (s *Server) buildTimeoutDiagnostics(err error) (string, *resourceDiagnostic
 	return diag.Message, diag
 }
 
+// startWorkspaceProvision runs runWorkspaceProvision in the background.
 func (s *Server) startWorkspaceProvision(
 	runtime *WorkspaceRuntime,
 	provisionRuntime WorkspaceRuntime,
@@ -324,125 +370,155 @@ func (s *Server) startWorkspaceProvision(
 	successMessage string,
 	detail map[string]interface{},
 ) {
-	go func() {
-		defer func() {
-			if runtime == nil {
-				return
-			}
-			s.workspaceMu.Lock()
-			runtime.ProvisioningActive = false
-			s.workspaceMu.Unlock()
-		}()
-
-		recoveryMode, err := s.provisionWorkspaceRuntime(context.Background(), &provisionRuntime)
-		s.applyProvisionedContainerUser(provisionRuntime.ID, provisionRuntime.ContainerUser)
-
-		// Mark the boot log broadcaster as complete and schedule cleanup.
-		// This notifies connected WebSocket clients that provisioning is done.
-		if broadcaster := s.bootLogBroadcasters.Get(provisionRuntime.ID); broadcaster != nil {
-			broadcaster.MarkComplete()
-		}
+	go s.runWorkspaceProvision(runtime, provisionRuntime, failureType, failureMessage, successType, successMessage, detail)
+}
 
-		if err != nil {
-			// Check if the workspace actually provisioned fine but only the
-			// control-plane callback failed (transient network issue).
-			var cbErr *bootstrap.CallbackError
-			if errors.As(err, &cbErr) {
-				// Workspace is functional — transition to the ready state and
-				// mark the callback as pending so the heartbeat loop retries it.
-				nextStatus := cbErr.Status
-				if nextStatus == "" {
-					nextStatus = "running"
-				}
-				s.casWorkspaceStatus(provisionRuntime.ID, []string{"creating"}, nextStatus)
-				s.markReadyCallbackPending(provisionRuntime.ID, nextStatus)
-
-				slog.Warn("Workspace ready but callback failed — will retry on next heartbeat",
-					"workspace", provisionRuntime.ID,
-					"status", nextStatus,
-					"callbackError", cbErr.Err,
-				)
-
-				successDetail := make(map[string]interface{}, len(detail)+2)
-				for key, value := range detail {
-					successDetail[key] = value
-				}
-				successDetail["callbackPending"] = true
-				if nextStatus == "recovery" {
-					successDetail["recoveryMode"] = true
-				}
-				s.appendNodeEvent(provisionRuntime.ID, "warn", successType, successMessage+" (callback pending)", successDetail)
-
-				// Start port scanner — workspace is functional
-				s.StartPortScanner(provisionRuntime.ID)
-				return
-			}
+// runWorkspaceProvision provisions a workspace whose status is already
+// "creating", moves it to its ready or error status, and reports the outcome.
+// Returns the status it set, or "" if the workspace left "creating" in the
+// meantime (e.g. it was stopped). A workspace that ends up in recovery mode is
+// handed to the recovery retry loop.
+func (s *Server) runWorkspaceProvision(
+	runtime *WorkspaceRuntime,
+	provisionRuntime WorkspaceRuntime,
+	failureType string,
+	failureMessage string,
+	successType string,
+	successMessage string,
+	detail map[string]interface{},
+) string {
+	defer func() {
+		if runtime == nil {
+			return
+		}
+		s.workspaces.mu.Lock()
+		runtime.ProvisioningActive = false
+		s.workspaces.mu.Unlock()
+	}()
 
-			// Real provisioning failure.
-			// CAS: only transition to error if still in "creating" state.
-			// If the workspace was stopped/deleted while provisioning, skip.
-			s.casWorkspaceStatus(provisionRuntime.ID, []string{"creating"}, "error")
+	recoveryMode, err := s.provisionWorkspaceRuntime(context.Background(), &provisionRuntime)
+	s.applyProvisionedContainerUser(provisionRuntime.ID, provisionRuntime.ContainerUser)
 
-			// Enrich timeout errors with resource diagnostics so the user
-			// knows whether the VM was under-resourced.
-			errorMsg, diag := s.buildTimeoutDiagnostics(err)
+	// Mark the boot log broadcaster as complete and schedule cleanup.
+	// This notifies connected WebSocket clients that provisioning is done.
+	if broadcaster := s.bootLogBroadcasters.Get(provisionRuntime.ID); broadcaster != nil {
+		broadcaster.MarkComplete()
+	}
 
-			callbackToken := s.callbackTokenForWorkspace(provisionRuntime.ID)
-			if callbackToken != "" {
-				if callbackErr := s.notifyWorkspaceProvisioningFailed(context.Background(), provisionRuntime.ID, callbackToken, errorMsg); callbackErr != nil {
-					slog.Error("Provisioning-failed callback error", "workspace", provisionRuntime.ID, "error", callbackErr)
-				}
+	if err != nil {
+		// Check if the workspace actually provisioned fine but only the
+		// control-plane callback failed (transient network issue).
+		var cbErr *bootstrap.CallbackError
+		if errors.As(err, &cbErr) {
+			// Workspace is functional — transition to the ready state and
+			// mark the callback as pending so the heartbeat loop retries it.
+			nextStatus := cbErr.Status
+			if nextStatus == "" {
+				nextStatus = "running"
 			}
+			s.casWorkspaceStatus(provisionRuntime.ID, []string{"creating"}, nextStatus)
+			s.markReadyCallbackPending(provisionRuntime.ID, nextStatus)
+
+			slog.Warn("Workspace ready but callback failed — will retry on next heartbeat",
+				"workspace", provisionRuntime.ID,
+				"status", nextStatus,
+				"callbackError", cbErr.Err,
+			)
 
-			failureDetail := make(map[string]interface{}, len(detail)+2)
+			successDetail := make(map[string]interface{}, len(detail)+2)
 			for key, value := range detail {
-				failureDetail[key] = value
+				successDetail[key] = value
 			}
-			failureDetail["error"] = errorMsg
-			if diag != nil {
-				failureDetail["resourceDiagnostics"] = diag
+			successDetail["callbackPending"] = true
+			if nextStatus == "recovery" {
+				successDetail["recoveryMode"] = true
 			}
-
-			s.appendNodeEvent(provisionRuntime.ID, "error", failureType, failureMessage, failureDetail)
-			return
+			s.appendNodeEvent(provisionRuntime.ID, "warn", successType, successMessage+" (callback pending)", successDetail)
+			s.NotifyWorkspaceProvisioned(provisionRuntime.ID, nextStatus == "recovery")
+
+			// Start port scanner — workspace is functional
+			s.StartPortScanner(provisionRuntime.ID)
+			s.startWarmStandby(provisionRuntime.ID)
+			go s.loadRepoSchedule(provisionRuntime.ID)
+			if nextStatus == "recovery" {
+				s.scheduleRecoveryRetry(provisionRuntime.ID)
+			}
+			return nextStatus
 		}
 
-		nextStatus := "running"
-		if recoveryMode {
-			nextStatus = "recovery"
-		}
+		// Real provisioning failure.
+		// CAS: only transition to error if still in "creating" state.
+		// If the workspace was stopped/deleted while provisioning, skip.
+		s.casWorkspaceStatus(provisionRuntime.ID, []string{"creating"}, "error")
 
-		// CAS: only transition to a ready state if still in "creating" state.
-		// Prevents overwriting "stopped" if user stopped workspace during provisioning.
-		if !s.casWorkspaceStatus(provisionRuntime.ID, []string{"creating"}, nextStatus) {
-			slog.Warn("Provisioning completed but status already changed from creating, skipping transition", "workspace", provisionRuntime.ID, "targetStatus", nextStatus)
-			return
+		// Enrich timeout and out-of-disk errors with resource diagnostics
+		// so the user knows whether the VM was under-resourced.
+		errorMsg, diag := s.buildFailureDiagnostics(err)
+
+		callbackToken := s.callbackTokenForWorkspace(provisionRuntime.ID)
+		if callbackToken != "" {
+			if callbackErr := s.notifyWorkspaceProvisioningFailed(context.Background(), provisionRuntime.ID, callbackToken, errorMsg); callbackErr != nil {
+				slog.Error("Provisioning-failed callback error", "workspace", provisionRuntime.ID, "error", callbackErr)
+			}
 		}
 
-		successDetail := make(map[string]interface{}, len(detail)+1)
+		failureDetail := make(map[string]interface{}, len(detail)+2)
 		for key, value := range detail {
-			successDetail[key] = value
+			failureDetail[key] = value
 		}
-		if recoveryMode {
-			successDetail["devcontainerFallback"] = true
-			successDetail["recoveryMode"] = true
+		failureDetail["error"] = errorMsg
+		if diag != nil {
+			failureDetail["resourceDiagnostics"] = diag
 		}
 
-		s.appendNodeEvent(provisionRuntime.ID, "info", successType, successMessage, successDetail)
+		s.appendNodeEvent(provisionRuntime.ID, "error", failureType, failureMessage, failureDetail)
+		return "error"
+	}
 
-		// Start port scanner for the newly provisioned workspace.
-		// This is the dynamic-workspace counterpart to the boot-time scanner
-		// started in OnBootstrapComplete (server.go).
-		s.StartPortScanner(runtime.ID)
-	}()
+	nextStatus := "running"
+	if recoveryMode {
+		nextStatus = "recovery"
+	}
+
+	// CAS: only transition to a ready state if still in "creating" state.
+	// Prevents overwriting "stopped" if user stopped workspace during provisioning.
+	if !s.casWorkspaceStatus(provisionRuntime.ID, []string{"creating"}, nextStatus) {
+		slog.Warn("Provisioning completed but status already changed from creating, skipping transition", "workspace", provisionRuntime.ID, "targetStatus", nextStatus)
+		return ""
+	}
+
+	successDetail := make(map[string]interface{}, len(detail)+1)
+	for key, value := range detail {
+		successDetail[key] = value
+	}
+	if recoveryMode {
+		successDetail["devcontainerFallback"] = true
+		successDetail["recoveryMode"] = true
+	}
+
+	s.appendNodeEvent(provisionRuntime.ID, "info", successType, successMessage, successDetail)
+	s.NotifyWorkspaceProvisioned(provisionRuntime.ID, recoveryMode)
+
+	// Start port scanner for the newly provisioned workspace.
+	// This is the dynamic-workspace counterpart to the boot-time scanner
+	// started in OnBootstrapComplete (server.go).
+	s.StartPortScanner(runtime.ID)
+	s.startWarmStandby(runtime.ID)
+	go s.loadRepoSchedule(runtime.ID)
+	if recoveryMode {
+		s.scheduleRecoveryRetry(provisionRuntime.ID)
+	} else {
+		s.clearRecoveryRetry(provisionRuntime.ID)
+	}
+	return nextStatus
 }
 
 func (s *Server) snapshotWorkspaceRuntime(runtime *WorkspaceRuntime) WorkspaceRuntime {
 	if runtime == nil {
 		return WorkspaceRuntime{}
 	}
-	s.workspaceMu.RLock()
-	defer s.workspaceMu.RUnlock()
+	s.workspaces.mu.RLock()
+	defer s.workspaces.mu.RUnlock()
 	return *runtime
 }
 
@@ -452,16 +528,18 @@ func (s *Server) applyProvisionedContainerUser(workspaceID string, detected stri
 		return
 	}
 
-	s.workspaceMu.Lock()
-	runtime := s.workspaces[workspaceID]
-	if runtime == nil || strings.TrimSpace(runtime.ContainerUser) == nextUser {
-		s.workspaceMu.Unlock()
+	var changed *WorkspaceRuntime
+	s.workspaces.Update(workspaceID, func(runtime *WorkspaceRuntime) {
+		if strings.TrimSpace(runtime.ContainerUser) != nextUser {
+			runtime.ContainerUser = nextUser
+			changed = runtime
+		}
+	})
+	if changed == nil {
 		return
 	}
-	runtime.ContainerUser = nextUser
-	s.workspaceMu.Unlock()
 
-	s.rebuildWorkspacePTYManager(runtime)
+	s.rebuildWorkspacePTYManager(changed)
 }
 
 type createWorkspaceRequest struct {
@@ -478,12 +556,109 @@ type createWorkspaceRequest struct {
 	GitHubID               string `json:"githubId,omitempty"`
 	Lightweight            bool   `json:"lightweight,omitempty"`
 	DevcontainerConfigName string `json:"devcontainerConfigName,omitempty"`
+	CommitTrailers         *bool  `json:"commitTrailers,omitempty"`
 	DevcontainerCache      struct {
 		Registry string `json:"registry,omitempty"`
 		Username string `json:"username,omitempty"`
 		Password string `json:"password,omitempty"`
 		Ref      string `json:"ref,omitempty"`
+		// PrebuildRef enables prebuild mode: the devcontainer image is
+		// built once, pushed here, and pulled by later workspaces.
+		PrebuildRef string `json:"prebuildRef,omitempty"`
 	} `json:"devcontainerCache,omitempty"`
+	// Spec is a declarative provisioning spec, either as a JSON object or as
+	// a string holding a JSON or YAML document. Its values take precedence
+	// over the loose fields above.
+	Spec json.RawMessage `json:"spec,omitempty"`
+	// SnapshotURL is a download URL for a volume snapshot. When set, the new
+	// workspace volume is seeded from it instead of starting empty.
+	SnapshotURL string `json:"snapshotUrl,omitempty"`
+	// DeployKey is an SSH private key for ssh:// and git@host:path
+	// repositories; KnownHosts pins the git host's keys.
+	DeployKey  string `json:"deployKey,omitempty"`
+	KnownHosts string `json:"knownHosts,omitempty"`
+	// SigningKey is an SSH or armored OpenPGP private key used to sign
+	// commits made in the workspace.
+	SigningKey string `json:"signingKey,omitempty"`
+	// EnvironmentTemplate is the user's packages, dotfiles, and shell
+	// preferences, applied in the devcontainer after it is built.
+	EnvironmentTemplate *bootstrap.EnvironmentTemplate `json:"environmentTemplate,omitempty"`
+	// ResourceLimits override the node's default CPU, memory, and process
+	// limits for the workspace's devcontainer.
+	ResourceLimits *bootstrap.ResourceLimits `json:"resourceLimits,omitempty"`
+	// EgressPolicy is an allowlist or denylist of IPs, CIDRs, and domains
+	// the devcontainer may reach.
+	EgressPolicy *egress.Policy `json:"egressPolicy,omitempty"`
+	// RegistryCredentials authenticate pulls of devcontainer images and
+	// features from private registries.
+	RegistryCredentials bootstrap.RegistryCredentials `json:"registryCredentials,omitempty"`
+	// DevcontainerPolicy is the organization's allowlist of registries,
+	// banned features, and digest pinning rule for the repository's
+	// devcontainer config.
+	DevcontainerPolicy *bootstrap.DevcontainerPolicy `json:"devcontainerPolicy,omitempty"`
+	// Hooks maps lifecycle points (preClone, postClone, postDevcontainerUp,
+	// preShutdown) to inline shell scripts.
+	Hooks bootstrap.Hooks `json:"hooks,omitempty"`
+
+	provisionSpec *provisionspec.Spec
+}
+
+// decodeProvisionSpec parses the spec field of a create request. A JSON
+// string is treated as an embedded JSON or YAML document.
+func decodeProvisionSpec(raw json.RawMessage) (*provisionspec.Spec, error) {
+	raw = json.RawMessage(bytes.TrimSpace(raw))
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	if raw[0] == '"' {
+		var doc string
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			return nil, fmt.Errorf("invalid provisioning spec: %w", err)
+		}
+		return provisionspec.Parse([]byte(doc))
+	}
+	return provisionspec.Parse(raw)
+}
+
+// applyProvisionSpecToCreateRequest parses body.Spec and copies its values
+// over the loose request fields, so conflict checks, runtime metadata, and
+// persistence all see the spec's repository, branch, and profile.
+func applyProvisionSpecToCreateRequest(body *createWorkspaceRequest) error {
+	spec, err := decodeProvisionSpec(body.Spec)
+	if err != nil || spec == nil {
+		return err
+	}
+	body.provisionSpec = spec
+
+	if repo, ok := spec.PrimaryRepository(); ok {
+		body.Repository = repo.Repository
+		if repo.Branch != "" {
+			body.Branch = repo.Branch
+		}
+		body.RepoProvider = repo.Provider
+		body.CloneURL = repo.CloneURL
+		body.RepositoryHost = repo.Host
+		body.RepositoryPath = repo.Path
+	}
+	switch spec.Resources.Profile {
+	case provisionspec.ProfileLightweight:
+		body.Lightweight = true
+	case provisionspec.ProfileFull:
+		body.Lightweight = false
+	}
+	if spec.Resources.DevcontainerConfig != "" {
+		body.DevcontainerConfigName = spec.Resources.DevcontainerConfig
+	}
+	if spec.Git.UserName != "" {
+		body.GitUserName = spec.Git.UserName
+	}
+	if spec.Git.UserEmail != "" {
+		body.GitUserEmail = spec.Git.UserEmail
+	}
+	if spec.Git.CommitTrailers != nil {
+		body.CommitTrailers = spec.Git.CommitTrailers
+	}
+	return nil
 }
 
 func validateCreateWorkspaceRequest(body createWorkspaceRequest) (int, string) {
@@ -495,6 +670,39 @@ func validateCreateWorkspaceRequest(body createWorkspaceRequest) (int, string) {
 			return http.StatusBadRequest, "devcontainerConfigName must not contain path separators or '..'"
 		}
 	}
+	if snapshotURL := strings.TrimSpace(body.SnapshotURL); snapshotURL != "" {
+		if err := bootstrap.ValidateSnapshotURL(snapshotURL); err != nil {
+			return http.StatusBadRequest, "snapshotUrl: " + err.Error()
+		}
+	}
+	if deployKey := strings.TrimSpace(body.DeployKey); deployKey != "" {
+		if err := bootstrap.ValidateDeployKey(deployKey); err != nil {
+			return http.StatusBadRequest, "deployKey: " + err.Error()
+		}
+	}
+	if signingKey := strings.TrimSpace(body.SigningKey); signingKey != "" {
+		if err := bootstrap.ValidateSigningKey(signingKey); err != nil {
+			return http.StatusBadRequest, "signingKey: " + err.Error()
+		}
+	}
+	if err := body.EnvironmentTemplate.Validate(); err != nil {
+		return http.StatusBadRequest, "environmentTemplate: " + err.Error()
+	}
+	if err := body.ResourceLimits.Validate(); err != nil {
+		return http.StatusBadRequest, "resourceLimits: " + err.Error()
+	}
+	if err := body.EgressPolicy.Validate(); err != nil {
+		return http.StatusBadRequest, "egressPolicy: " + err.Error()
+	}
+	if err := body.RegistryCredentials.Validate(); err != nil {
+		return http.StatusBadRequest, "registryCredentials: " + err.Error()
+	}
+	if err := body.DevcontainerPolicy.Validate(); err != nil {
+		return http.StatusBadRequest, "devcontainerPolicy: " + err.Error()
+	}
+	if err := body.Hooks.Validate(); err != nil {
+		return http.StatusBadRequest, "hooks: " + err.Error()
+	}
 	return http.StatusOK, ""
 }
 
@@ -517,11 +725,24 @@ func createWorkspaceRuntimeOptions(body createWorkspaceRequest, devcontainerConf
 		RepositoryPath:         strings.TrimSpace(body.RepositoryPath),
 		Lightweight:            body.Lightweight,
 		DevcontainerConfigName: devcontainerConfigName,
+		CommitTrailers:         body.CommitTrailers,
+		ProvisionSpec:          body.provisionSpec,
+		SnapshotURL:            strings.TrimSpace(body.SnapshotURL),
+		DeployKey:              strings.TrimSpace(body.DeployKey),
+		KnownHosts:             strings.TrimSpace(body.KnownHosts),
+		SigningKey:             strings.TrimSpace(body.SigningKey),
+		EnvironmentTemplate:    body.EnvironmentTemplate,
+		ResourceLimits:         body.ResourceLimits,
+		EgressPolicy:           body.EgressPolicy,
+		RegistryCredentials:    body.RegistryCredentials,
+		DevcontainerPolicy:     body.DevcontainerPolicy,
+		Hooks:                  body.Hooks,
 		DevcontainerCache: DevcontainerCacheCredentials{
-			Registry: strings.TrimSpace(body.DevcontainerCache.Registry),
-			Username: strings.TrimSpace(body.DevcontainerCache.Username),
-			Password: strings.TrimSpace(body.DevcontainerCache.Password),
-			Ref:      strings.TrimSpace(body.DevcontainerCache.Ref),
+			Registry:    strings.TrimSpace(body.DevcontainerCache.Registry),
+			Username:    strings.TrimSpace(body.DevcontainerCache.Username),
+			Password:    strings.TrimSpace(body.DevcontainerCache.Password),
+			Ref:         strings.TrimSpace(body.DevcontainerCache.Ref),
+			PrebuildRef: strings.TrimSpace(body.DevcontainerCache.PrebuildRef),
 		},
 	}
 }
@@ -545,9 +766,10 @@ func (s *Server) hasWorkspaceCreateConflict(
 		return s.maybeHandleDuplicateWorkspaceCreate(w, body.WorkspaceID, repository, branch, devcontainerConfigName, body.Lightweight)
 	}
 
-	s.workspaceMu.RLock()
-	conflict := workspaceCreateConflict(s.workspaces[body.WorkspaceID], repository, branch, devcontainerConfigName, body.Lightweight)
-	s.workspaceMu.RUnlock()
+	var conflict string
+	s.workspaces.View(body.WorkspaceID, func(runtime *WorkspaceRuntime) {
+		conflict = workspaceCreateConflict(runtime, repository, branch, devcontainerConfigName, body.Lightweight)
+	})
 	if conflict == "" {
 		return false
 	}
@@ -566,6 +788,10 @@ func (s *Server) handleCreateWorkspace(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "invalid request body")
 		return
 	}
+	if err := applyProvisionSpecToCreateRequest(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	if status, message := validateCreateWorkspaceRequest(body); message != "" {
 		writeError(w, status, message)
@@ -664,9 +890,9 @@ func (s *Server) handleAsyncWorkspaceCreate(
 	runtime *WorkspaceRuntime,
 	branch string,
 ) {
-	s.workspaceMu.Lock()
+	s.workspaces.mu.Lock()
 	if runtime.ProvisioningActive {
-		s.workspaceMu.Unlock()
+		s.workspaces.mu.Unlock()
 		slog.Warn("Workspace provisioning already in flight, returning idempotent 202",
 			"workspace", runtime.ID)
 		writeJSON(w, http.StatusAccepted, map[string]interface{}{
@@ -677,7 +903,7 @@ func (s *Server) handleAsyncWorkspaceCreate(
 	}
 	runtime.ProvisioningActive = true
 	provisionRuntime := *runtime
-	s.workspaceMu.Unlock()
+	s.workspaces.mu.Unlock()
 
 	// Note: Per-workspace message reporter is created lazily in
 	// handleStartAgentSession when the chatSessionID becomes available.
@@ -764,6 +990,16 @@ func (s *Server) handleRestartWorkspace(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// Optional body: forceResync syncs the host clone into the workspace
+	// volume even if the host clone has not changed since the last sync.
+	var body struct {
+		ForceResync bool `json:"forceResync"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
 	runtime, ok := s.getWorkspaceRuntime(workspaceID)
 	if !ok {
 		writeError(w, http.StatusNotFound, "workspace not found")
@@ -778,11 +1014,15 @@ func (s *Server) handleRestartWorkspace(w http.ResponseWriter, r *http.Request)
 		})
 		return
 	}
-	s.appendNodeEvent(workspaceID, "info", "workspace.restarting", "Workspace restart started", nil)
+	s.appendNodeEvent(workspaceID, "info", "workspace.restarting", "Workspace restart started", map[string]interface{}{
+		"forceResync": body.ForceResync,
+	})
 
+	provisionRuntime := s.snapshotWorkspaceRuntime(runtime)
+	provisionRuntime.ForceResync = body.ForceResync
 	s.startWorkspaceProvision(
 		runtime,
-		s.snapshotWorkspaceRuntime(runtime),
+		provisionRuntime,
 		"workspace.restart_failed",
 		"Workspace restart failed",
 		"workspace.restarted",
@@ -832,6 +1072,69 @@ func (s *Server) handleRebuildWorkspace(w http.ResponseWriter, r *http.Request)
 	writeJSON(w, http.StatusAccepted, map[string]interface{}{"status": "rebuilding"})
 }
 
+// handleRebuildDevcontainer tears down the workspace's devcontainer and builds
+// it again from the repository's current devcontainer config, keeping the
+// workspace volume. Unlike handleRebuildWorkspace, which reuses a container
+// that is still running, this is the recovery path after a bad
+// devcontainer.json edit. Progress streams over the boot log WebSocket.
+func (s *Server) handleRebuildDevcontainer(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.PathValue("workspaceId")
+	if workspaceID == "" {
+		writeError(w, http.StatusBadRequest, workspaceIDRequiredMessage)
+		return
+	}
+
+	if !s.requireNodeManagementAuth(w, r, workspaceID) {
+		return
+	}
+	s.rebuildDevcontainer(w, workspaceID)
+}
+
+// rebuildDevcontainer starts a devcontainer rebuild for an authorized caller
+// and writes the response. The control socket shares it with the HTTP route.
+func (s *Server) rebuildDevcontainer(w http.ResponseWriter, workspaceID string) {
+	if s.refuseWhileDraining(w) {
+		return
+	}
+	if !s.config.ContainerMode {
+		writeError(w, http.StatusConflict, "devcontainer rebuild requires container mode")
+		return
+	}
+
+	runtime, ok := s.getWorkspaceRuntime(workspaceID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "workspace not found")
+		return
+	}
+
+	if !s.casWorkspaceStatus(workspaceID, []string{"running", "recovery", "error"}, "creating") {
+		writeJSON(w, http.StatusConflict, map[string]interface{}{
+			"error":   "invalid_transition",
+			"message": "Workspace must be running, recovery, or in error state to rebuild its devcontainer, currently " + runtime.Status,
+		})
+		return
+	}
+	s.appendNodeEvent(workspaceID, "info", "workspace.devcontainer_rebuilding", "Tearing down and rebuilding devcontainer", nil)
+
+	// Start a fresh boot log stream; the one from the original provisioning
+	// is already complete and would close new clients immediately.
+	s.bootLogBroadcasters.Remove(workspaceID)
+
+	provisionRuntime := s.snapshotWorkspaceRuntime(runtime)
+	provisionRuntime.RebuildContainer = true
+	s.startWorkspaceProvision(
+		runtime,
+		provisionRuntime,
+		"workspace.devcontainer_rebuild_failed",
+		"Devcontainer rebuild failed",
+		"workspace.devcontainer_rebuilt",
+		"Devcontainer rebuilt from repository config",
+		map[string]interface{}{},
+	)
+
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{"status": "rebuilding"})
+}
+
 func (s *Server) handleDeleteWorkspace(w http.ResponseWriter, r *http.Request) {
 	workspaceID := r.PathValue("workspaceId")
 	if workspaceID == "" {
@@ -855,11 +1158,14 @@ func (s *Server) handleDeleteWorkspace(w http.ResponseWriter, r *http.Request) {
 	// The container must be removed before the volume (Docker won't remove a volume in use).
 	s.removeWorkspaceContainer(workspaceID)
 	bootstrap.RemoveCredentialHelperFromHost(workspaceID)
+	bootstrap.RemoveProgress(s.config, workspaceID)
 	if err := bootstrap.RemoveVolume(context.Background(), workspaceID); err != nil {
 		slog.Warn("Failed to remove Docker volume for workspace", "workspace", workspaceID, "error", err)
 	}
 
 	s.removeWorkspaceRuntime(workspaceID)
+	s.clearAnnouncements(workspaceID)
+	s.untrackCallbackQueue(workspaceID)
 
 	// Remove all persisted tabs and MCP server configs for this workspace
 	if s.store != nil {
@@ -870,6 +1176,23 @@ func (s *Server) handleDeleteWorkspace(w http.ResponseWriter, r *http.Request) {
 			slog.Warn("Failed to delete persisted MCP servers for workspace", "workspace", workspaceID, "error", err)
 		}
 	}
+	if s.notesStore != nil {
+		if err := s.notesStore.DeleteWorkspace(workspaceID); err != nil {
+			slog.Warn("Failed to delete notes for workspace", "workspace", workspaceID, "error", err)
+		}
+	}
+	if s.scheduler != nil {
+		s.scheduler.RemoveWorkspace(workspaceID)
+	}
+	if s.jobManager != nil {
+		s.jobManager.RemoveWorkspace(r.Context(), workspaceID)
+	}
+	// Deletion receipts are kept: they document purges after the workspace is gone.
+	if s.retentionStore != nil {
+		if err := s.retentionStore.DeletePolicy(workspaceID); err != nil {
+			slog.Warn("Failed to delete retention policy for workspace", "workspace", workspaceID, "error", err)
+		}
+	}
 
 	s.appendNodeEvent(workspaceID, "info", "workspace.deleted", "Workspace deleted", nil)
 	writeJSON(w, http.StatusOK, map[string]interface{}{"success": true})
@@ -942,6 +1265,9 @@ func (s *Server) handleListAgentSessions(w http.ResponseWriter, r *http.Request)
 			viewers := host.ViewerCount()
 			enriched[i].HostStatus = &status
 			enriched[i].ViewerCount = &viewers
+			if workDir := host.ContainerWorkDir(); workDir != "" {
+				enriched[i].WorkDir = workDir
+			}
 		}
 	}
 
@@ -959,6 +1285,9 @@ func (s *Server) handleCreateAgentSession(w http.ResponseWriter, r *http.Request
 	if !s.requireNodeManagementAuth(w, r, workspaceID) {
 		return
 	}
+	if s.refuseWhileDraining(w) {
+		return
+	}
 
 	var body struct {
 		SessionID     string               `json:"sessionId"`
@@ -966,6 +1295,8 @@ func (s *Server) handleCreateAgentSession(w http.ResponseWriter, r *http.Request
 		ChatSessionID string               `json:"chatSessionId"` // Chat session ID for message routing (warm node reuse)
 		ProjectID     string               `json:"projectId"`     // Project ID for late-init of message reporter (manual nodes)
 		McpServers    []acp.McpServerEntry `json:"mcpServers,omitempty"`
+		Worktree      string               `json:"worktree,omitempty"` // Worktree path under /workspaces the agent runs in
+		Subdir        string               `json:"subdir,omitempty"`   // Directory within the worktree, e.g. a monorepo package
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		writeError(w, http.StatusBadRequest, "invalid request body")
@@ -984,13 +1315,25 @@ func (s *Server) handleCreateAgentSession(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	workDir := ""
+	if worktree, subdir := strings.TrimSpace(body.Worktree), strings.TrimSpace(body.Subdir); worktree != "" || subdir != "" {
+		containerID, defaultWorkDir, user, resolveErr := s.resolveContainerForWorkspace(workspaceID)
+		if resolveErr != nil {
+			writeError(w, http.StatusConflict, resolveErr.Error())
+			return
+		}
+		_, workDir, err = s.resolveSessionWorkDir(r.Context(), workspaceID, containerID, user, defaultWorkDir, worktree, subdir)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
 	// Store projectID on workspace runtime for ACP heartbeat goroutine.
 	if projectID != "" {
-		s.workspaceMu.Lock()
-		if rt, ok := s.workspaces[workspaceID]; ok {
+		s.workspaces.Update(workspaceID, func(rt *WorkspaceRuntime) {
 			rt.ProjectID = projectID
-		}
-		s.workspaceMu.Unlock()
+		})
 	}
 
 	// Ensure a per-workspace message reporter exists for this workspace.
@@ -1015,6 +1358,11 @@ func (s *Server) handleCreateAgentSession(w http.ResponseWriter, r *http.Request
 		return
 	}
 	s.registerSessionMcpServers(workspaceID, session.ID, mcpServers)
+	if workDir != "" && !idempotentHit {
+		if err := s.agentSessions.UpdateWorkDir(workspaceID, session.ID, workDir); err == nil {
+			session.WorkDir = workDir
+		}
+	}
 
 	if !idempotentHit {
 		s.appendNodeEvent(workspaceID, "info", "agent_session.created", "Agent session created", map[string]interface{}{"sessionId": session.ID})
@@ -1029,6 +1377,7 @@ func (s *Server) handleCreateAgentSession(w http.ResponseWriter, r *http.Request
 				Label:       session.Label,
 				AgentID:     "", // Agent ID is inferred from label currently
 				SortOrder:   tabCount,
+				WorkDir:     session.WorkDir,
 			}); err != nil {
 				slog.Warn("Failed to persist chat tab", "error", err)
 			}
@@ -1102,6 +1451,9 @@ func (s *Server) handleStartAgentSession(w http.ResponseWriter, r *http.Request)
 	if !s.requireNodeManagementAuth(w, r, workspaceID) {
 		return
 	}
+	if s.refuseWhileDraining(w) {
+		return
+	}
 
 	var body struct {
 		AgentType        string               `json:"agentType"`
@@ -1124,6 +1476,14 @@ func (s *Server) handleStartAgentSession(w http.ResponseWriter, r *http.Request)
 		writeError(w, http.StatusBadRequest, "invalid request body")
 		return
 	}
+	// Agent defaults from the workspace's provisioning spec fill fields the
+	// request leaves empty.
+	if runtime, ok := s.getWorkspaceRuntime(workspaceID); ok && runtime.ProvisionSpec != nil {
+		defaults := runtime.ProvisionSpec.Agent
+		body.AgentType = firstNonEmpty(strings.TrimSpace(body.AgentType), defaults.Type)
+		body.Model = firstNonEmpty(body.Model, defaults.Model)
+		body.PermissionMode = firstNonEmpty(body.PermissionMode, defaults.PermissionMode)
+	}
 	if strings.TrimSpace(body.AgentType) == "" {
 		writeError(w, http.StatusBadRequest, "agentType is required")
 		return
@@ -1199,7 +1559,7 @@ func (s *Server) handleStartAgentSession(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Create or retrieve the SessionHost for this session.
-	host := s.getOrCreateSessionHost(hostKey, workspaceID, sessionID, session, runtime, "")
+	host := s.getOrCreateSessionHost(hostKey, workspaceID, sessionID, session, runtime, "", "")
 
 	s.appendNodeEvent(workspaceID, "info", "agent_session.starting", "Starting agent with initial prompt", map[string]interface{}{
 		"sessionId": sessionID,
@@ -1294,7 +1654,7 @@ func (s *Server) startAgentWithPrompt(host *acp.SessionHost, workspaceID, sessio
 	// callback fires automatically, handling git push and task status updates.
 	// trustedSource=true: this is the SAM-built initial task prompt, the only
 	// legitimate origin=system producer (the injected instructions block).
-	host.HandlePrompt(ctx, syntheticReqID, promptParams, "server", true)
+	s.handleTrackedPrompt(ctx, workspaceID, host, syntheticReqID, promptParams, "server", true)
 }
 
 // handleSendPrompt sends a follow-up prompt to a running agent session.
@@ -1370,7 +1730,7 @@ func (s *Server) handleSendPrompt(w http.ResponseWriter, r *http.Request) {
 	// Dispatch asynchronously — HandlePrompt blocks until the agent completes.
 	// trustedSource=false: a follow-up carries the user's own message text and
 	// must not be able to mark itself origin=system.
-	go host.HandlePrompt(context.Background(), syntheticReqID, promptParams, "control-plane", false)
+	go s.handleTrackedPrompt(context.Background(), workspaceID, host, syntheticReqID, promptParams, "control-plane", false)
 
 	writeJSON(w, http.StatusAccepted, map[string]interface{}{
 		"status":    "prompting",
@@ -1378,6 +1738,12 @@ func (s *Server) handleSendPrompt(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// maxCancelReasonLength bounds the free-text cancellation reason shown to viewers.
+const maxCancelReasonLength = 500
+
+// handleCancelAgentSession cancels the in-flight prompt. The optional JSON
+// body {"initiator": "user"|"admin"|"budget", "reason": "..."} labels the
+// cancellation for viewers; it defaults to a user cancel.
 func (s *Server) handleCancelAgentSession(w http.ResponseWriter, r *http.Request) {
 	workspaceID := r.PathValue("workspaceId")
 	sessionID := r.PathValue("sessionId")
@@ -1389,6 +1755,28 @@ func (s *Server) handleCancelAgentSession(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	var body struct {
+		Initiator acp.CancelInitiator `json:"initiator"`
+		Reason    string              `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if body.Initiator == "" {
+		body.Initiator = acp.CancelByUser
+	}
+	// Timeouts are raised by the agent itself, never requested over HTTP.
+	if !body.Initiator.Valid() || body.Initiator == acp.CancelByTimeout {
+		writeError(w, http.StatusBadRequest, "initiator must be one of user, admin, budget")
+		return
+	}
+	body.Reason = strings.TrimSpace(body.Reason)
+	if len(body.Reason) > maxCancelReasonLength {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("reason must be at most %d bytes", maxCancelReasonLength))
+		return
+	}
+
 	// Look up the existing SessionHost for this session.
 	hostKey := workspaceID + ":" + sessionID
 	s.sessionHostMu.Lock()
@@ -1409,11 +1797,13 @@ func (s *Server) handleCancelAgentSession(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	host.CancelPromptFromControlPlane()
+	host.CancelPromptFromControlPlane(acp.PromptCancellation{Initiator: body.Initiator, Reason: body.Reason})
 
-	slog.Info("Agent session prompt cancelled via HTTP", "workspace", workspaceID, "session", sessionID)
+	slog.Info("Agent session prompt cancelled via HTTP", "workspace", workspaceID, "session", sessionID, "initiator", string(body.Initiator))
 	s.appendNodeEvent(workspaceID, "info", "agent_session.prompt_cancelled", "Agent prompt cancelled via HTTP", map[string]interface{}{
 		"sessionId": sessionID,
+		"initiator": string(body.Initiator),
+		"reason":    body.Reason,
 	})
 
 	writeJSON(w, http.StatusOK, map[string]interface{}{
@@ -1466,6 +1856,20 @@ func (s *Server) suspendSessionHost(workspaceID, sessionID string) (acpSessionID
 	return acpSessionID, agentType
 }
 
+// suspendFinishedAgentSession suspends a server-started session once its
+// agent has finished, keeping the transcript without the agent process.
+func (s *Server) suspendFinishedAgentSession(workspaceID, sessionID string) {
+	acpSessionID, agentType := s.suspendSessionHost(workspaceID, sessionID)
+	suspended, err := s.agentSessions.Suspend(workspaceID, sessionID)
+	if err != nil {
+		slog.Warn("Failed to suspend finished agent session", "workspace", workspaceID, "session", sessionID, "error", err)
+		return
+	}
+	if acpSessionID != "" && suspended.AcpSessionID == "" {
+		_ = s.agentSessions.UpdateAcpSessionID(workspaceID, sessionID, acpSessionID, agentType)
+	}
+}
+
 // handleAutoSuspend is called by the SessionHost's OnSuspend callback when
 // auto-suspend fires. It removes the SessionHost from the map (the host has
 // already stopped itself) and transitions the session to suspended status.
@@ -1491,6 +1895,11 @@ func (s *Server) handleAutoSuspend(workspaceID, sessionID string) {
 	}
 
 	slog.Info("Auto-suspend: session suspended", "workspace", workspaceID, "session", sessionID, "acpSessionId", session.AcpSessionID)
+	s.NotifyLifecycle(lifecyclehook.EventSuspended, workspaceID, map[string]interface{}{
+		"sessionId": sessionID,
+		"reason":    "idle",
+	})
+	s.preserveWorkOnSuspend(workspaceID)
 }
 
 func (s *Server) handleSuspendAgentSession(w http.ResponseWriter, r *http.Request) {
@@ -1527,6 +1936,11 @@ func (s *Server) handleSuspendAgentSession(w http.ResponseWriter, r *http.Reques
 		"acpSessionId": session.AcpSessionID,
 		"agentType":    session.AgentType,
 	})
+	s.NotifyLifecycle(lifecyclehook.EventSuspended, workspaceID, map[string]interface{}{
+		"sessionId": sessionID,
+		"reason":    "requested",
+	})
+	s.preserveWorkOnSuspend(workspaceID)
 
 	writeJSON(w, http.StatusOK, session)
 }
@@ -1541,6 +1955,9 @@ func (s *Server) handleResumeAgentSession(w http.ResponseWriter, r *http.Request
 	if !s.requireNodeManagementAuth(w, r, workspaceID) {
 		return
 	}
+	if s.refuseWhileDraining(w) {
+		return
+	}
 
 	// Transition the in-memory session back to running.
 	session, err := s.agentSessions.Resume(workspaceID, sessionID)
@@ -1559,6 +1976,9 @@ func (s *Server) handleResumeAgentSession(w http.ResponseWriter, r *http.Request
 		"acpSessionId": session.AcpSessionID,
 		"agentType":    session.AgentType,
 	})
+	s.NotifyLifecycle(lifecyclehook.EventResumed, workspaceID, map[string]interface{}{
+		"sessionId": sessionID,
+	})
 
 	writeJSON(w, http.StatusOK, session)
 }
@@ -4,7 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -208,6 +210,100 @@ func (s *Server) resolveExplicitWorktreeWorkDir(ctx context.Context, workspaceID
 	return wt.Path, nil
 }
 
+// cleanSessionSubdir normalizes a session subdirectory, a path relative to
+// its worktree root such as packages/service-x. "" and "." mean the root.
+func cleanSessionSubdir(subdir string) (string, error) {
+	subdir = strings.TrimSpace(subdir)
+	if subdir == "" {
+		return "", nil
+	}
+	if strings.ContainsRune(subdir, 0) || path.IsAbs(subdir) {
+		return "", fmt.Errorf("invalid subdir")
+	}
+	cleaned := path.Clean(subdir)
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("invalid subdir")
+	}
+	if cleaned == "." {
+		return "", nil
+	}
+	return cleaned, nil
+}
+
+// resolveSessionWorkDir returns the worktree root and working directory of an
+// agent or terminal session. requested is a worktree path, or a directory
+// inside one as recorded on a session's tab, and defaults to defaultWorkDir.
+// A non-empty subdir scopes the session to that directory of the worktree,
+// e.g. one sub-project of a monorepo. It must exist and, with symlinks
+// resolved, stay inside the worktree.
+func (s *Server) resolveSessionWorkDir(ctx context.Context, workspaceID, containerID, user, defaultWorkDir, requested, subdir string) (root, workDir string, err error) {
+	subdir, err = cleanSessionSubdir(subdir)
+	if err != nil {
+		return "", "", err
+	}
+	validateCtx, cancel := context.WithTimeout(ctx, s.config.GitExecTimeout)
+	defer cancel()
+
+	root = defaultWorkDir
+	requested = strings.TrimSpace(requested)
+	if requested != "" && requested != defaultWorkDir {
+		underDefault := defaultWorkDir != "" && strings.HasPrefix(requested, strings.TrimSuffix(defaultWorkDir, "/")+"/")
+		if strings.ContainsRune(requested, 0) || strings.Contains(requested, "..") || (!underDefault && !strings.HasPrefix(requested, "/workspaces/")) {
+			return "", "", fmt.Errorf("invalid worktree path")
+		}
+		// The longest worktree containing requested wins, so a worktree
+		// nested under the primary checkout is not taken for a subdirectory.
+		found := false
+		if worktrees, listErr := s.listWorktrees(validateCtx, workspaceID, containerID, user, defaultWorkDir, false); listErr == nil {
+			for _, wt := range worktrees {
+				if (requested == wt.Path || strings.HasPrefix(requested, wt.Path+"/")) && (!found || len(wt.Path) > len(root)) {
+					root, found = wt.Path, true
+				}
+			}
+		}
+		if !found && !underDefault {
+			return "", "", fmt.Errorf("not a valid worktree path")
+		}
+		if subdir == "" {
+			subdir = strings.TrimPrefix(strings.TrimPrefix(requested, root), "/")
+		}
+	}
+	if subdir == "" {
+		return root, root, nil
+	}
+
+	workDir = path.Join(root, subdir)
+	realRoot, _, err := s.execInContainer(validateCtx, containerID, user, root, "pwd", "-P")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to resolve worktree root: %w", err)
+	}
+	realDir, _, err := s.execInContainer(validateCtx, containerID, user, workDir, "pwd", "-P")
+	if err != nil {
+		return "", "", fmt.Errorf("subdir %q is not a directory in the worktree", subdir)
+	}
+	realRoot, realDir = strings.TrimSpace(realRoot), strings.TrimSpace(realDir)
+	if realDir != realRoot && !strings.HasPrefix(realDir, strings.TrimSuffix(realRoot, "/")+"/") {
+		return "", "", fmt.Errorf("subdir %q is outside the worktree", subdir)
+	}
+	return root, workDir, nil
+}
+
+// recordSessionWorkDir remembers which checkout an agent session runs in, both
+// in memory and on its persisted tab, so reconnects and agent restarts reuse
+// the same worktree.
+func (s *Server) recordSessionWorkDir(workspaceID, sessionID, workDir string) {
+	if s.agentSessions != nil {
+		if err := s.agentSessions.UpdateWorkDir(workspaceID, sessionID, workDir); err != nil {
+			slog.Warn("Failed to record session workdir", "workspace", workspaceID, "sessionId", sessionID, "error", err)
+		}
+	}
+	if s.store != nil {
+		if err := s.store.UpdateTabWorkDir(sessionID, workDir); err != nil {
+			slog.Warn("Failed to persist tab workdir", "workspace", workspaceID, "sessionId", sessionID, "error", err)
+		}
+	}
+}
+
 func (s *Server) handleListWorktrees(w http.ResponseWriter, r *http.Request) {
 	workspaceID := r.PathValue("workspaceId")
 	if workspaceID == "" {
@@ -262,6 +358,13 @@ func (s *Server) handleCreateWorktree(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	release, err := s.lockWorkspaceGit(r.Context(), workspaceID, containerID, user, workDir, "worktree add")
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	defer release()
+
 	ctx, cancel := context.WithTimeout(r.Context(), s.config.GitWorktreeTimeout)
 	defer cancel()
 
@@ -344,7 +447,10 @@ func (s *Server) stopAgentSessionsBoundToWorktree(workspaceID, worktreePath stri
 		if !strings.HasPrefix(hostKey, workspaceID+":") {
 			continue
 		}
-		if host == nil || host.ContainerWorkDir() != worktreePath {
+		if host == nil {
+			continue
+		}
+		if workDir := host.ContainerWorkDir(); workDir != worktreePath && !strings.HasPrefix(workDir, worktreePath+"/") {
 			continue
 		}
 		sessionID := strings.TrimPrefix(hostKey, workspaceID+":")
@@ -391,6 +497,13 @@ func (s *Server) handleRemoveWorktree(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	release, err := s.lockWorkspaceGit(r.Context(), workspaceID, containerID, user, workDir, "worktree remove")
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	defer release()
+
 	ctx, cancel := context.WithTimeout(r.Context(), s.config.GitWorktreeTimeout)
 	defer cancel()
 	wt, err := s.validateWorktreePath(ctx, workspaceID, containerID, user, workDir, removePath)
@@ -3,6 +3,8 @@ package server
 import (
 	"context"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -165,3 +167,72 @@ func TestResolveWorktreeWorkDir(t *testing.T) {
 		t.Fatalf("resolveWorktreeWorkDir() = %q, want /workspaces/repo-wt-feature", workDir)
 	}
 }
+
+func TestCleanSessionSubdir(t *testing.T) {
+	t.Parallel()
+
+	valid := map[string]string{
+		"":                      "",
+		".":                     "",
+		"packages/service-x":    "packages/service-x",
+		" packages//service-x/": "packages/service-x",
+		"packages/../apps/web":  "apps/web",
+	}
+	for in, want := range valid {
+		got, err := cleanSessionSubdir(in)
+		if err != nil || got != want {
+			t.Fatalf("cleanSessionSubdir(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"/etc", "..", "../other-repo", "packages/../../etc", "a\x00b"} {
+		if _, err := cleanSessionSubdir(in); err == nil {
+			t.Fatalf("cleanSessionSubdir(%q) expected error", in)
+		}
+	}
+}
+
+func TestResolveSessionWorkDirScopesToSubdir(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	service := filepath.Join(root, "packages", "service-x")
+	if err := os.MkdirAll(service, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(t.TempDir(), filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{
+		config: &config.Config{
+			Role:             config.RoleStandalone,
+			GitExecTimeout:   5 * time.Second,
+			WorktreeCacheTTL: 5 * time.Second,
+		},
+		worktreeCache: map[string]cachedWorktreeList{},
+	}
+	s.setCachedWorktrees("ws-1", []WorktreeInfo{{Path: root, Branch: "main", IsPrimary: true}})
+
+	gotRoot, workDir, err := s.resolveSessionWorkDir(context.Background(), "ws-1", "", "", root, "", "packages/service-x")
+	if err != nil {
+		t.Fatalf("resolveSessionWorkDir() unexpected error: %v", err)
+	}
+	if gotRoot != root || workDir != service {
+		t.Fatalf("resolveSessionWorkDir() = %q, %q; want %q, %q", gotRoot, workDir, root, service)
+	}
+
+	// A recorded session workdir resolves back to its worktree root.
+	gotRoot, workDir, err = s.resolveSessionWorkDir(context.Background(), "ws-1", "", "", root, service, "")
+	if err != nil {
+		t.Fatalf("resolveSessionWorkDir() with recorded workdir unexpected error: %v", err)
+	}
+	if gotRoot != root || workDir != service {
+		t.Fatalf("resolveSessionWorkDir() = %q, %q; want %q, %q", gotRoot, workDir, root, service)
+	}
+
+	for _, subdir := range []string{"missing", "escape", "../outside"} {
+		if _, _, err := s.resolveSessionWorkDir(context.Background(), "ws-1", "", "", root, "", subdir); err == nil {
+			t.Fatalf("resolveSessionWorkDir() with subdir %q expected error", subdir)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+// Package sshserver implements an embedded SSH server that lets developers
+// reach their workspace with ssh, scp/sftp, and VS Code Remote-SSH instead of
+// the browser terminal.
+//
+// Clients authenticate with short-lived user certificates signed by the
+// control plane's SSH certificate authority. The login name is the workspace
+// ID and must be one of the certificate's principals. Shells, commands, and
+// the sftp subsystem run inside the workspace's devcontainer as its resolved
+// container user; direct-tcpip forwards to loopback are dialed into the
+// container so editors can reach their remote server.
+package sshserver
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Certificate extensions honoured by the server. Certificates issued by
+// ssh-keygen include both by default.
+const (
+	extPermitPTY            = "permit-pty"
+	extPermitPortForwarding = "permit-port-forwarding"
+
+	// permKeyID carries the certificate key ID from authentication to the
+	// connection handler. Not a certificate extension.
+	permKeyID = "sam-key-id"
+)
+
+// Target is where an authenticated connection's sessions run.
+type Target struct {
+	// ContainerID is the devcontainer to exec into. Empty runs commands
+	// directly on the host (standalone mode).
+	ContainerID string
+	User        string
+	WorkDir     string
+	// Dial opens a TCP connection to a port inside the workspace for
+	// direct-tcpip forwarding. Nil disables forwarding.
+	Dial func(ctx context.Context, port int) (net.Conn, error)
+}
+
+// Resolver maps a workspace ID (the SSH login name) to its target. An error
+// closes the connection.
+type Resolver func(workspaceID string) (Target, error)
+
+// ConnInfo describes an authenticated connection.
+type ConnInfo struct {
+	ID            string
+	WorkspaceID   string
+	KeyID         string // certificate key ID, set by the issuing control plane to identify the user
+	RemoteAddr    string
+	ClientVersion string
+}
+
+// Config configures a Server.
+type Config struct {
+	HostKeyPath string // generated as ed25519 on first start when missing
+	// UserCAKeys are the certificate authorities trusted to sign user
+	// certificates.
+	UserCAKeys []ssh.PublicKey
+	// MaxCertLifetime rejects certificates whose validity window is longer
+	// than this. Zero disables the check; certificates without an expiry are
+	// always rejected.
+	MaxCertLifetime time.Duration
+	Shell           string // login shell inside the container (default: /bin/bash)
+	Resolve         Resolver
+	// OnConnect is called after authentication. The returned function, if
+	// non-nil, is called when the connection closes.
+	OnConnect func(ConnInfo) func()
+}
+
+// Server is an SSH server for workspace access.
+type Server struct {
+	cfg       Config
+	sshConfig *ssh.ServerConfig
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[*ssh.ServerConn]struct{}
+	closed   bool
+}
+
+// New creates a server, loading or generating its host key.
+func New(cfg Config) (*Server, error) {
+	if len(cfg.UserCAKeys) == 0 {
+		return nil, errors.New("at least one user CA key is required")
+	}
+	if cfg.Resolve == nil {
+		return nil, errors.New("resolver is required")
+	}
+	if cfg.Shell == "" {
+		cfg.Shell = "/bin/bash"
+	}
+	hostKey, err := loadOrCreateHostKey(cfg.HostKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{cfg: cfg, conns: make(map[*ssh.ServerConn]struct{})}
+	s.sshConfig = &ssh.ServerConfig{
+		PublicKeyCallback: s.authenticate,
+		ServerVersion:     "SSH-2.0-SAM-VMAgent",
+	}
+	s.sshConfig.AddHostKey(hostKey)
+	return s, nil
+}
+
+// ParseAuthorizedKeys parses CA public keys in authorized_keys format, one
+// per line. Blank lines and comments are skipped.
+func ParseAuthorizedKeys(data string) ([]ssh.PublicKey, error) {
+	var keys []ssh.PublicKey
+	rest := []byte(data)
+	for len(strings.TrimSpace(string(rest))) > 0 {
+		key, _, _, next, err := ssh.ParseAuthorizedKey(rest)
+		if err != nil {
+			return nil, fmt.Errorf("parse CA key %d: %w", len(keys)+1, err)
+		}
+		keys = append(keys, key)
+		rest = next
+	}
+	return keys, nil
+}
+
+// ListenAndServe listens on addr and serves connections until Close.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until Close. It returns nil after Close.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return nil
+	}
+	s.listener = l
+	s.mu.Unlock()
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go s.handleConn(nc)
+	}
+}
+
+// Close stops accepting connections and closes every open connection.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	if s.listener != nil {
+		return s.listener.Close()
+	}
+	return nil
+}
+
+// authenticate accepts only certificates signed by a trusted CA, valid now,
+// listing the login name as a principal, and no longer-lived than
+// MaxCertLifetime.
+func (s *Server) authenticate(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	cert, ok := key.(*ssh.Certificate)
+	if !ok || cert.CertType != ssh.UserCert {
+		return nil, errors.New("a user certificate is required")
+	}
+	if cert.ValidBefore == ssh.CertTimeInfinity {
+		return nil, errors.New("certificate has no expiry")
+	}
+	if maxLifetime := s.cfg.MaxCertLifetime; maxLifetime > 0 && cert.ValidBefore > cert.ValidAfter &&
+		time.Duration(cert.ValidBefore-cert.ValidAfter)*time.Second > maxLifetime {
+		return nil, fmt.Errorf("certificate lifetime exceeds %s", maxLifetime)
+	}
+
+	checker := &ssh.CertChecker{IsUserAuthority: s.isUserAuthority}
+	perms, err := checker.Authenticate(conn, key)
+	if err != nil {
+		slog.Info("SSH authentication rejected", "user", conn.User(), "keyId", cert.KeyId, "remoteAddr", conn.RemoteAddr().String(), "error", err)
+		return nil, err
+	}
+
+	extensions := make(map[string]string, len(perms.Extensions)+1)
+	for k, v := range perms.Extensions {
+		extensions[k] = v
+	}
+	extensions[permKeyID] = cert.KeyId
+	return &ssh.Permissions{CriticalOptions: perms.CriticalOptions, Extensions: extensions}, nil
+}
+
+func (s *Server) isUserAuthority(auth ssh.PublicKey) bool {
+	marshaled := auth.Marshal()
+	for _, ca := range s.cfg.UserCAKeys {
+		if string(ca.Marshal()) == string(marshaled) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) handleConn(nc net.Conn) {
+	sconn, chans, reqs, err := ssh.NewServerConn(nc, s.sshConfig)
+	if err != nil {
+		slog.Debug("SSH handshake failed", "remoteAddr", nc.RemoteAddr().String(), "error", err)
+		nc.Close()
+		return
+	}
+	defer sconn.Close()
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.conns[sconn] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, sconn)
+		s.mu.Unlock()
+	}()
+
+	workspaceID := sconn.User()
+	target, err := s.cfg.Resolve(workspaceID)
+	if err != nil {
+		slog.Warn("SSH connection rejected: workspace unavailable", "workspace", workspaceID, "error", err)
+		return
+	}
+
+	info := ConnInfo{
+		ID:            fmt.Sprintf("%x", sconn.SessionID()[:8]),
+		WorkspaceID:   workspaceID,
+		KeyID:         sconn.Permissions.Extensions[permKeyID],
+		RemoteAddr:    sconn.RemoteAddr().String(),
+		ClientVersion: string(sconn.ClientVersion()),
+	}
+	slog.Info("SSH connection established", "workspace", workspaceID, "keyId", info.KeyID, "remoteAddr", info.RemoteAddr)
+	if s.cfg.OnConnect != nil {
+		if onClose := s.cfg.OnConnect(info); onClose != nil {
+			defer onClose()
+		}
+	}
+
+	// Remote forwarding (tcpip-forward) and other global requests are refused.
+	go ssh.DiscardRequests(reqs)
+
+	_, permitPTY := sconn.Permissions.Extensions[extPermitPTY]
+	_, permitForwarding := sconn.Permissions.Extensions[extPermitPortForwarding]
+	for newCh := range chans {
+		switch newCh.ChannelType() {
+		case "session":
+			ch, chReqs, err := newCh.Accept()
+			if err != nil {
+				continue
+			}
+			sess := &session{server: s, target: target, ch: ch, permitPTY: permitPTY}
+			go sess.serve(chReqs)
+		case "direct-tcpip":
+			if !permitForwarding {
+				newCh.Reject(ssh.Prohibited, "port forwarding is not permitted by this certificate")
+				continue
+			}
+			go handleDirectTCPIP(newCh, target)
+		default:
+			newCh.Reject(ssh.UnknownChannelType, "unsupported channel type")
+		}
+	}
+	slog.Info("SSH connection closed", "workspace", workspaceID, "keyId", info.KeyID)
+}
+
+// loadOrCreateHostKey reads the PEM host key at path, generating and saving
+// an ed25519 key when the file does not exist.
+func loadOrCreateHostKey(path string) (ssh.Signer, error) {
+	if path == "" {
+		return nil, errors.New("host key path is required")
+	}
+	data, err := os.ReadFile(path)
+	if err == nil {
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse host key %s: %w", path, err)
+		}
+		return signer, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read host key: %w", err)
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate host key: %w", err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		return nil, fmt.Errorf("encode host key: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("create host key directory: %w", err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		return nil, fmt.Errorf("write host key: %w", err)
+	}
+	slog.Info("Generated SSH host key", "path", path)
+	return ssh.NewSignerFromKey(priv)
+}
@@ -0,0 +1,226 @@
+package sshserver
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type testCA struct {
+	signer ssh.Signer
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{signer: signer}
+}
+
+// issue returns a client signer presenting a user certificate for principals
+// valid for lifetime.
+func (ca *testCA) issue(t *testing.T, lifetime time.Duration, principals ...string) ssh.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	cert := &ssh.Certificate{
+		Key:             key.PublicKey(),
+		CertType:        ssh.UserCert,
+		KeyId:           "user-1",
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(now.Add(-time.Minute).Unix()),
+		ValidBefore:     uint64(now.Add(lifetime).Unix()),
+		Permissions: ssh.Permissions{Extensions: map[string]string{
+			extPermitPTY:            "",
+			extPermitPortForwarding: "",
+		}},
+	}
+	if err := cert.SignCert(rand.Reader, ca.signer); err != nil {
+		t.Fatal(err)
+	}
+	certSigner, err := ssh.NewCertSigner(cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certSigner
+}
+
+func startTestServer(t *testing.T, ca *testCA, target Target) (string, <-chan ConnInfo) {
+	t.Helper()
+	connected := make(chan ConnInfo, 4)
+	srv, err := New(Config{
+		HostKeyPath:     filepath.Join(t.TempDir(), "ssh_host_ed25519_key"),
+		UserCAKeys:      []ssh.PublicKey{ca.signer.PublicKey()},
+		MaxCertLifetime: time.Hour,
+		Shell:           "/bin/sh",
+		Resolve: func(workspaceID string) (Target, error) {
+			if workspaceID != "ws-1" {
+				return Target{}, errors.New("workspace not found")
+			}
+			return target, nil
+		},
+		OnConnect: func(info ConnInfo) func() {
+			connected <- info
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return l.Addr().String(), connected
+}
+
+func dialTestServer(addr, user string, signer ssh.Signer) (*ssh.Client, error) {
+	return ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+}
+
+func TestExecRunsInTargetWorkDirAndReportsExitStatus(t *testing.T) {
+	ca := newTestCA(t)
+	workDir := t.TempDir()
+	addr, connected := startTestServer(t, ca, Target{WorkDir: workDir})
+
+	client, err := dialTestServer(addr, "ws-1", ca.issue(t, 30*time.Minute, "ws-1"))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer client.Close()
+
+	info := <-connected
+	if info.WorkspaceID != "ws-1" || info.KeyID != "user-1" {
+		t.Fatalf("conn info = %+v", info)
+	}
+
+	sess, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.Close()
+	out, err := sess.Output("pwd; exit 3")
+	var exitErr *ssh.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitStatus() != 3 {
+		t.Fatalf("exit error = %v, want status 3", err)
+	}
+	if got := strings.TrimSpace(string(out)); got != workDir {
+		t.Fatalf("pwd = %q, want %q", got, workDir)
+	}
+}
+
+func TestAuthenticationRejectsUntrustedOrOverlongCredentials(t *testing.T) {
+	ca := newTestCA(t)
+	addr, _ := startTestServer(t, ca, Target{WorkDir: t.TempDir()})
+
+	_, plainKey, _ := ed25519.GenerateKey(rand.Reader)
+	plainSigner, _ := ssh.NewSignerFromKey(plainKey)
+
+	cases := []struct {
+		name   string
+		user   string
+		signer ssh.Signer
+	}{
+		{"plain public key", "ws-1", plainSigner},
+		{"untrusted CA", "ws-1", newTestCA(t).issue(t, 30*time.Minute, "ws-1")},
+		{"principal mismatch", "ws-1", ca.issue(t, 30*time.Minute, "ws-2")},
+		{"lifetime too long", "ws-1", ca.issue(t, 24*time.Hour, "ws-1")},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			client, err := dialTestServer(addr, tc.user, tc.signer)
+			if err == nil {
+				client.Close()
+				t.Fatal("expected authentication to fail")
+			}
+		})
+	}
+}
+
+func TestDirectTCPIPForwardsLoopbackOnly(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	echoPort := echo.Addr().(*net.TCPAddr).Port
+
+	ca := newTestCA(t)
+	var dialedPort int
+	addr, _ := startTestServer(t, ca, Target{
+		WorkDir: t.TempDir(),
+		Dial: func(ctx context.Context, port int) (net.Conn, error) {
+			dialedPort = port
+			var d net.Dialer
+			return d.DialContext(ctx, "tcp", echo.Addr().String())
+		},
+	})
+	client, err := dialTestServer(addr, "ws-1", ca.issue(t, 30*time.Minute, "ws-1"))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer client.Close()
+
+	conn, err := client.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(echoPort)))
+	if err != nil {
+		t.Fatalf("forward: %v", err)
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("echo = %q, %v", buf, err)
+	}
+	conn.Close()
+	if dialedPort != echoPort {
+		t.Fatalf("dialed port %d, want %d", dialedPort, echoPort)
+	}
+
+	if conn, err := client.Dial("tcp", "10.0.0.1:22"); err == nil {
+		conn.Close()
+		t.Fatal("expected forward to a non-loopback host to be refused")
+	}
+}
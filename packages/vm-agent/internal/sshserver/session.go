@@ -0,0 +1,296 @@
+package sshserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/creack/pty"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/workspace/vm-agent/internal/containerruntime"
+)
+
+// sftpServerScript execs the first sftp-server found in the container.
+// Devcontainer images install OpenSSH in different locations, and some do
+// not ship it at all.
+const sftpServerScript = `for p in /usr/lib/openssh/sftp-server /usr/libexec/openssh/sftp-server /usr/lib/ssh/sftp-server /usr/libexec/sftp-server; do [ -x "$p" ] && exec "$p"; done; echo "sftp-server not found in workspace container" >&2; exit 127`
+
+const dialTimeout = 10 * time.Second
+
+// session is one "session" channel: at most one shell, command, or
+// subsystem, optionally on a PTY.
+type session struct {
+	server    *Server
+	target    Target
+	ch        ssh.Channel
+	permitPTY bool
+
+	env     []string
+	term    string
+	winSize *pty.Winsize
+
+	mu      sync.Mutex
+	ptmx    *os.File
+	started bool
+}
+
+type ptyRequest struct {
+	Term    string
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+	Modes   string
+}
+
+type windowChange struct {
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+}
+
+type envRequest struct {
+	Name  string
+	Value string
+}
+
+type execRequest struct {
+	Command string
+}
+
+type exitStatus struct {
+	Status uint32
+}
+
+type directTCPIP struct {
+	Host       string
+	Port       uint32
+	OriginAddr string
+	OriginPort uint32
+}
+
+func (sess *session) serve(reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		ok := false
+		switch req.Type {
+		case "pty-req":
+			var p ptyRequest
+			if sess.permitPTY && ssh.Unmarshal(req.Payload, &p) == nil {
+				sess.term = p.Term
+				sess.winSize = &pty.Winsize{Rows: uint16(p.Rows), Cols: uint16(p.Columns)}
+				ok = true
+			}
+		case "window-change":
+			var wc windowChange
+			if ssh.Unmarshal(req.Payload, &wc) == nil {
+				sess.resize(wc)
+				ok = true
+			}
+		case "env":
+			var e envRequest
+			if ssh.Unmarshal(req.Payload, &e) == nil && allowedEnv(e.Name) {
+				sess.env = append(sess.env, e.Name+"="+e.Value)
+				ok = true
+			}
+		case "shell":
+			ok = sess.start(req, []string{sess.server.cfg.Shell, "-l"})
+		case "exec":
+			var e execRequest
+			if ssh.Unmarshal(req.Payload, &e) == nil {
+				ok = sess.start(req, []string{sess.server.cfg.Shell, "-c", e.Command})
+			}
+		case "subsystem":
+			var e execRequest
+			if ssh.Unmarshal(req.Payload, &e) == nil && e.Command == "sftp" {
+				sess.winSize = nil // sftp is a binary protocol; never allocate a PTY
+				ok = sess.start(req, []string{"/bin/sh", "-c", sftpServerScript})
+			}
+		}
+		if req.WantReply {
+			req.Reply(ok, nil)
+		}
+	}
+}
+
+// allowedEnv limits client-supplied environment to locale and terminal
+// settings, matching OpenSSH's usual AcceptEnv.
+func allowedEnv(name string) bool {
+	return name == "LANG" || name == "COLORTERM" || strings.HasPrefix(name, "LC_")
+}
+
+func (sess *session) resize(wc windowChange) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	size := &pty.Winsize{Rows: uint16(wc.Rows), Cols: uint16(wc.Columns)}
+	if sess.ptmx == nil {
+		sess.winSize = size
+		return
+	}
+	_ = pty.Setsize(sess.ptmx, size)
+}
+
+// start runs argv in the target and reports its exit status when it ends.
+// Only the first shell/exec/subsystem request on a channel is honoured.
+func (sess *session) start(req *ssh.Request, argv []string) bool {
+	sess.mu.Lock()
+	if sess.started {
+		sess.mu.Unlock()
+		return false
+	}
+	sess.started = true
+	sess.mu.Unlock()
+
+	tty := sess.winSize != nil
+	env := sess.env
+	if tty {
+		term := sess.term
+		if term == "" {
+			term = "xterm-256color"
+		}
+		env = append(env, "TERM="+term)
+	}
+	cmd := sess.server.command(sess.target, tty, env, argv)
+
+	if tty {
+		ptmx, err := pty.StartWithSize(cmd, sess.winSize)
+		if err != nil {
+			slog.Warn("SSH session start failed", "error", err)
+			return false
+		}
+		sess.mu.Lock()
+		sess.ptmx = ptmx
+		sess.mu.Unlock()
+		go func() { _, _ = io.Copy(ptmx, sess.ch) }()
+		outputDone := make(chan struct{})
+		go func() {
+			_, _ = io.Copy(sess.ch, ptmx)
+			close(outputDone)
+		}()
+		go func() {
+			err := cmd.Wait()
+			<-outputDone
+			ptmx.Close()
+			sess.finish(err)
+		}()
+		return true
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return false
+	}
+	cmd.Stdout = sess.ch
+	cmd.Stderr = sess.ch.Stderr()
+	if err := cmd.Start(); err != nil {
+		slog.Warn("SSH session start failed", "error", err)
+		return false
+	}
+	go func() {
+		_, _ = io.Copy(stdin, sess.ch)
+		stdin.Close()
+	}()
+	go func() {
+		sess.finish(cmd.Wait())
+	}()
+	return true
+}
+
+// finish sends the exit status and closes the channel.
+func (sess *session) finish(waitErr error) {
+	status := uint32(0)
+	if waitErr != nil {
+		status = 255
+		var exitErr *exec.ExitError
+		if errors.As(waitErr, &exitErr) && exitErr.ExitCode() >= 0 {
+			status = uint32(exitErr.ExitCode())
+		}
+	}
+	_, _ = sess.ch.SendRequest("exit-status", false, ssh.Marshal(exitStatus{Status: status}))
+	sess.ch.Close()
+}
+
+// command builds the process for argv: docker exec into the container as the
+// container user, or a host process in standalone mode.
+func (s *Server) command(target Target, tty bool, env, argv []string) *exec.Cmd {
+	if target.ContainerID == "" {
+		cmd := exec.Command(argv[0], argv[1:]...)
+		cmd.Env = append(os.Environ(), env...)
+		cmd.Dir = target.WorkDir
+		return cmd
+	}
+
+	rt := containerruntime.Current()
+	args := rt.ExecArgs(target.ContainerID, containerruntime.ExecOptions{
+		User:        target.User,
+		WorkDir:     target.WorkDir,
+		Env:         env,
+		Interactive: true,
+		TTY:         tty,
+	}, argv...)
+	return rt.Command(context.Background(), args...)
+}
+
+// handleDirectTCPIP forwards a local port forward (ssh -L, VS Code
+// Remote-SSH) to a port inside the workspace. Only loopback destinations are
+// allowed: the client means "localhost inside the workspace", not other
+// hosts reachable from the node.
+func handleDirectTCPIP(newCh ssh.NewChannel, target Target) {
+	var req directTCPIP
+	if err := ssh.Unmarshal(newCh.ExtraData(), &req); err != nil {
+		newCh.Reject(ssh.ConnectionFailed, "invalid forward request")
+		return
+	}
+	if target.Dial == nil || !isLoopbackHost(req.Host) || req.Port == 0 || req.Port > 65535 {
+		newCh.Reject(ssh.Prohibited, "only forwards to localhost inside the workspace are allowed")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	conn, err := target.Dial(ctx, int(req.Port))
+	cancel()
+	if err != nil {
+		newCh.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	ch, reqs, err := newCh.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(conn, ch)
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			_ = tcp.CloseWrite()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(ch, conn)
+		_ = ch.CloseWrite()
+	}()
+	wg.Wait()
+	conn.Close()
+	ch.Close()
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
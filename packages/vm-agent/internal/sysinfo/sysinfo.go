@@ -19,6 +19,8 @@ import (
 	"sync"
 	"syscall"
 	"time"
+
+	"github.com/workspace/vm-agent/internal/containerruntime"
 )
 
 // Build-time variables injected via ldflags in the Makefile.
@@ -42,9 +44,14 @@ type SystemInfo struct {
 
 // QuickMetrics is a lightweight subset for heartbeat enrichment.
 type QuickMetrics struct {
-	CPULoadAvg1   float64 `json:"cpuLoadAvg1"`
-	MemoryPercent float64 `json:"memoryPercent"`
-	DiskPercent   float64 `json:"diskPercent"`
+	CPULoadAvg1      float64 `json:"cpuLoadAvg1"`
+	MemoryPercent    float64 `json:"memoryPercent"`
+	MemoryUsedBytes  uint64  `json:"memoryUsedBytes"`
+	MemoryTotalBytes uint64  `json:"memoryTotalBytes"`
+	DiskPercent      float64 `json:"diskPercent"`
+	DiskUsedBytes    uint64  `json:"diskUsedBytes"`
+	DiskTotalBytes   uint64  `json:"diskTotalBytes"`
+	UptimeSeconds    float64 `json:"uptimeSeconds"`
 }
 
 // CPUInfo holds CPU load averages and core count.
@@ -225,9 +232,14 @@ func (c *Collector) CollectQuick() (*QuickMetrics, error) {
 	}
 
 	result := &QuickMetrics{
-		CPULoadAvg1:   cpu.LoadAvg1,
-		MemoryPercent: mem.UsedPercent,
-		DiskPercent:   disk.UsedPercent,
+		CPULoadAvg1:      cpu.LoadAvg1,
+		MemoryPercent:    mem.UsedPercent,
+		MemoryUsedBytes:  mem.UsedBytes,
+		MemoryTotalBytes: mem.TotalBytes,
+		DiskPercent:      disk.UsedPercent,
+		DiskUsedBytes:    disk.UsedBytes,
+		DiskTotalBytes:   disk.TotalBytes,
+		UptimeSeconds:    c.collectUptime().Seconds,
 	}
 
 	c.quickMu.Lock()
@@ -494,7 +506,7 @@ func (c *Collector) collectDocker() DockerInfo {
 	// Get Docker version
 	ctx, cancel := context.WithTimeout(context.Background(), c.config.DockerTimeout)
 	defer cancel()
-	out, err := exec.CommandContext(ctx, "docker", "version", "--format", "{{.Server.Version}}").Output()
+	out, err := containerruntime.Command(ctx, "version", "--format", "{{.Server.Version}}").Output()
 	if err == nil {
 		info.Version = strings.TrimSpace(string(out))
 	}
@@ -502,7 +514,7 @@ func (c *Collector) collectDocker() DockerInfo {
 	// Phase 1: Enumerate all containers with docker ps -a
 	ctx2, cancel2 := context.WithTimeout(context.Background(), c.config.DockerListTimeout)
 	defer cancel2()
-	out, err = exec.CommandContext(ctx2, "docker", "ps", "-a", "--format", "{{json .}}").Output()
+	out, err = containerruntime.Command(ctx2, "ps", "-a", "--format", "{{json .}}").Output()
 	if err != nil {
 		errMsg := fmt.Sprintf("failed to list containers: %v", err)
 		slog.Warn("Docker container list failed", "error", err)
@@ -534,7 +546,7 @@ func (c *Collector) collectDocker() DockerInfo {
 		args := append([]string{"stats", "--no-stream", "--format",
 			`{"id":"{{.ID}}","cpuPercent":"{{.CPUPerc}}","memUsage":"{{.MemUsage}}","memPercent":"{{.MemPerc}}"}`},
 			runningIDs...)
-		out, err = exec.CommandContext(ctx3, "docker", args...).Output()
+		out, err = containerruntime.Command(ctx3, args...).Output()
 		if err != nil {
 			slog.Warn("Docker stats query failed (containers still listed)", "error", err)
 		} else {
@@ -617,7 +629,7 @@ func (c *Collector) collectSoftware() SoftwareInfo {
 
 	ctx2, cancel2 := context.WithTimeout(context.Background(), c.config.VersionTimeout)
 	defer cancel2()
-	if out, err := exec.CommandContext(ctx2, "docker", "version", "--format", "{{.Server.Version}}").Output(); err == nil {
+	if out, err := containerruntime.Command(ctx2, "version", "--format", "{{.Server.Version}}").Output(); err == nil {
 		info.DockerVersion = strings.TrimSpace(string(out))
 	}
 
@@ -0,0 +1,436 @@
+// Package tracing records OpenTelemetry spans for bootstrap and ACP flows and
+// exports them to an OTLP/HTTP collector using the OTLP JSON encoding.
+//
+// Spans are started with the package-level Start, which uses the tracer set
+// by SetDefault. Until a tracer is set, Start returns a nil *Span and every
+// Span method is a no-op, so instrumented code never checks whether tracing
+// is enabled.
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// scopeName identifies the instrumentation scope in exported spans.
+	scopeName = "github.com/workspace/vm-agent"
+	// maxQueuedSpans bounds the spans waiting for export. Spans are dropped
+	// when the collector falls behind.
+	maxQueuedSpans = 2048
+	// exportBatchSize is the most spans sent in one export request.
+	exportBatchSize = 512
+)
+
+// Config holds configuration for the OTLP exporter.
+type Config struct {
+	Endpoint       string            // OTLP/HTTP base URL; spans are POSTed to Endpoint + "/v1/traces". Empty disables tracing.
+	Headers        map[string]string // Extra headers on every export request, e.g. collector auth
+	ServiceName    string            // service.name resource attribute (default: vm-agent)
+	ServiceVersion string            // service.version resource attribute
+	NodeID         string            // sam.node_id resource attribute
+	ExportInterval time.Duration     // Max delay before ended spans are exported (default: 5s)
+	HTTPTimeout    time.Duration     // Per-export HTTP timeout (default: 10s)
+}
+
+// Attr is a span attribute.
+type Attr struct {
+	Key   string
+	Value interface{}
+}
+
+// String returns a string attribute.
+func String(key, value string) Attr { return Attr{Key: key, Value: value} }
+
+// Int returns an integer attribute.
+func Int(key string, value int) Attr { return Attr{Key: key, Value: int64(value)} }
+
+// Bool returns a boolean attribute.
+func Bool(key string, value bool) Attr { return Attr{Key: key, Value: value} }
+
+// Tracer buffers ended spans and exports them from a background goroutine.
+type Tracer struct {
+	cfg    Config
+	client *http.Client
+
+	mu      sync.Mutex
+	queue   []*Span
+	dropped int
+
+	flush     chan struct{}
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+// New creates a Tracer and starts its export loop. Returns nil when no
+// endpoint is configured.
+func New(cfg Config) *Tracer {
+	if strings.TrimSpace(cfg.Endpoint) == "" {
+		return nil
+	}
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = "vm-agent"
+	}
+	if cfg.ExportInterval <= 0 {
+		cfg.ExportInterval = 5 * time.Second
+	}
+	if cfg.HTTPTimeout <= 0 {
+		cfg.HTTPTimeout = 10 * time.Second
+	}
+	t := &Tracer{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: cfg.HTTPTimeout},
+		flush:   make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+var (
+	defaultMu     sync.RWMutex
+	defaultTracer *Tracer
+)
+
+// SetDefault installs t as the tracer used by Start. Passing nil disables
+// tracing.
+func SetDefault(t *Tracer) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultTracer = t
+}
+
+// Start begins a span named name using the default tracer. The span is a
+// child of the span in ctx, if any. The returned context carries the new
+// span. Returns ctx unchanged and a nil span when tracing is disabled.
+func Start(ctx context.Context, name string, attrs ...Attr) (context.Context, *Span) {
+	defaultMu.RLock()
+	t := defaultTracer
+	defaultMu.RUnlock()
+	return t.Start(ctx, name, attrs...)
+}
+
+// Start begins a span named name. Nil-safe: a nil tracer returns ctx and a
+// nil span.
+func (t *Tracer) Start(ctx context.Context, name string, attrs ...Attr) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	span := &Span{
+		tracer: t,
+		name:   name,
+		spanID: randomHex(8),
+		start:  time.Now(),
+		attrs:  append([]Attr(nil), attrs...),
+	}
+	if parent := FromContext(ctx); parent != nil {
+		span.traceID = parent.traceID
+		span.parentID = parent.spanID
+	} else {
+		span.traceID = randomHex(16)
+	}
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
+
+type spanContextKey struct{}
+
+// FromContext returns the span carried by ctx, or nil.
+func FromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey{}).(*Span)
+	return span
+}
+
+// Span is one timed operation. All methods are nil-safe.
+type Span struct {
+	tracer   *Tracer
+	name     string
+	traceID  string
+	spanID   string
+	parentID string
+	start    time.Time
+
+	mu     sync.Mutex
+	end    time.Time
+	attrs  []Attr
+	errMsg string
+	ended  bool
+}
+
+// SetAttributes adds attributes to the span.
+func (s *Span) SetAttributes(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs = append(s.attrs, attrs...)
+}
+
+// End finishes the span and queues it for export. A non-nil err marks the
+// span as failed with err as the status message. Only the first call has
+// an effect.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	if err != nil {
+		s.errMsg = err.Error()
+	}
+	s.mu.Unlock()
+	s.tracer.enqueue(s)
+}
+
+// TraceID returns the span's hex trace ID, or "" for a nil span.
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return s.traceID
+}
+
+func (t *Tracer) enqueue(span *Span) {
+	t.mu.Lock()
+	if len(t.queue) >= maxQueuedSpans {
+		t.dropped++
+		t.mu.Unlock()
+		return
+	}
+	t.queue = append(t.queue, span)
+	full := len(t.queue) >= exportBatchSize
+	t.mu.Unlock()
+	if full {
+		select {
+		case t.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.stopped)
+	ticker := time.NewTicker(t.cfg.ExportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.exportQueued()
+		case <-t.flush:
+			t.exportQueued()
+		case <-t.done:
+			t.exportQueued()
+			return
+		}
+	}
+}
+
+// Shutdown exports the remaining spans and stops the export loop. It waits
+// until the final export finishes or ctx is done. Nil-safe.
+func (t *Tracer) Shutdown(ctx context.Context) {
+	if t == nil {
+		return
+	}
+	t.closeOnce.Do(func() { close(t.done) })
+	select {
+	case <-t.stopped:
+	case <-ctx.Done():
+	}
+}
+
+// exportQueued sends every queued span in batches. A failed batch is
+// dropped: traces are diagnostics and must not grow without bound while the
+// collector is down.
+func (t *Tracer) exportQueued() {
+	for {
+		t.mu.Lock()
+		n := len(t.queue)
+		if n > exportBatchSize {
+			n = exportBatchSize
+		}
+		batch := t.queue[:n]
+		t.queue = t.queue[n:]
+		dropped := t.dropped
+		t.dropped = 0
+		t.mu.Unlock()
+
+		if dropped > 0 {
+			slog.Warn("tracing: dropped spans while export queue was full", "dropped", dropped)
+		}
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.export(batch); err != nil {
+			slog.Warn("tracing: span export failed", "spans", len(batch), "error", err)
+		}
+	}
+}
+
+func (t *Tracer) export(spans []*Span) error {
+	body, err := json.Marshal(t.encode(spans))
+	if err != nil {
+		return fmt.Errorf("marshal spans: %w", err)
+	}
+	url := strings.TrimRight(t.cfg.Endpoint, "/") + "/v1/traces"
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range t.cfg.Headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("collector returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// OTLP JSON encoding of ExportTraceServiceRequest. 64-bit integers are
+// encoded as strings, as the OTLP JSON mapping requires.
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            *otlpStatus    `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+const (
+	spanKindInternal = 1
+	statusCodeError  = 2
+)
+
+func (t *Tracer) encode(spans []*Span) otlpRequest {
+	resource := []Attr{String("service.name", t.cfg.ServiceName)}
+	if t.cfg.ServiceVersion != "" {
+		resource = append(resource, String("service.version", t.cfg.ServiceVersion))
+	}
+	if t.cfg.NodeID != "" {
+		resource = append(resource, String("sam.node_id", t.cfg.NodeID))
+	}
+
+	out := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		span.mu.Lock()
+		encoded := otlpSpan{
+			TraceID:           span.traceID,
+			SpanID:            span.spanID,
+			ParentSpanID:      span.parentID,
+			Name:              span.name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(span.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.end.UnixNano(), 10),
+			Attributes:        encodeAttrs(span.attrs),
+		}
+		if span.errMsg != "" {
+			encoded.Status = &otlpStatus{Code: statusCodeError, Message: span.errMsg}
+		}
+		span.mu.Unlock()
+		out = append(out, encoded)
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: encodeAttrs(resource)},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: scopeName}, Spans: out}},
+	}}}
+}
+
+func encodeAttrs(attrs []Attr) []otlpKeyValue {
+	if len(attrs) == 0 {
+		return nil
+	}
+	out := make([]otlpKeyValue, 0, len(attrs))
+	for _, attr := range attrs {
+		var value otlpAnyValue
+		switch v := attr.Value.(type) {
+		case string:
+			value.StringValue = &v
+		case int64:
+			s := strconv.FormatInt(v, 10)
+			value.IntValue = &s
+		case bool:
+			value.BoolValue = &v
+		case float64:
+			value.DoubleValue = &v
+		default:
+			s := fmt.Sprint(v)
+			value.StringValue = &s
+		}
+		out = append(out, otlpKeyValue{Key: attr.Key, Value: value})
+	}
+	return out
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,138 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNilTracerIsNoop(t *testing.T) {
+	t.Parallel()
+
+	var tracer *Tracer
+	ctx, span := tracer.Start(context.Background(), "noop")
+	if span != nil || FromContext(ctx) != nil {
+		t.Fatalf("nil tracer returned span %v", span)
+	}
+	span.SetAttributes(String("k", "v"))
+	span.End(errors.New("ignored"))
+	tracer.Shutdown(context.Background())
+
+	if New(Config{}) != nil {
+		t.Fatal("New() without endpoint should return nil")
+	}
+}
+
+func TestTracerExportsOTLPJSON(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var requests []otlpRequest
+	var headers []string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected export %s %q", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		var req otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode export: %v", err)
+		}
+		mu.Lock()
+		requests = append(requests, req)
+		headers = append(headers, r.Header.Get("Authorization"))
+		mu.Unlock()
+	}))
+	t.Cleanup(collector.Close)
+
+	tracer := New(Config{
+		Endpoint:       collector.URL + "/",
+		Headers:        map[string]string{"Authorization": "Bearer collector-token"},
+		ServiceVersion: "1.2.3",
+		NodeID:         "node-1",
+		ExportInterval: time.Hour,
+	})
+	ctx, parent := tracer.Start(context.Background(), "bootstrap.Run", String("workspace.id", "ws-1"))
+	_, child := tracer.Start(ctx, "git.clone", Int("attempt", 2), Bool("git.partial", true))
+	child.End(errors.New("clone failed"))
+	parent.End(nil)
+	parent.End(errors.New("second End is ignored"))
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tracer.Shutdown(shutdownCtx)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 1 || headers[0] != "Bearer collector-token" {
+		t.Fatalf("exports = %d (auth %v), want 1 with collector auth", len(requests), headers)
+	}
+	rs := requests[0].ResourceSpans[0]
+	resource := map[string]string{}
+	for _, kv := range rs.Resource.Attributes {
+		resource[kv.Key] = *kv.Value.StringValue
+	}
+	if resource["service.name"] != "vm-agent" || resource["service.version"] != "1.2.3" || resource["sam.node_id"] != "node-1" {
+		t.Fatalf("resource = %v", resource)
+	}
+
+	spans := rs.ScopeSpans[0].Spans
+	if len(spans) != 2 || spans[0].Name != "git.clone" || spans[1].Name != "bootstrap.Run" {
+		t.Fatalf("spans = %+v, want git.clone then bootstrap.Run", spans)
+	}
+	clone, run := spans[0], spans[1]
+	if clone.TraceID != run.TraceID || clone.ParentSpanID != run.SpanID || run.ParentSpanID != "" {
+		t.Fatalf("clone %s/%s parent %s is not a child of run %s/%s", clone.TraceID, clone.SpanID, clone.ParentSpanID, run.TraceID, run.SpanID)
+	}
+	if len(run.TraceID) != 32 || len(run.SpanID) != 16 {
+		t.Fatalf("trace/span ID lengths = %d/%d, want 32/16", len(run.TraceID), len(run.SpanID))
+	}
+	if clone.Status == nil || clone.Status.Code != statusCodeError || clone.Status.Message != "clone failed" {
+		t.Fatalf("clone status = %+v", clone.Status)
+	}
+	if run.Status != nil {
+		t.Fatalf("run status = %+v, want unset", run.Status)
+	}
+	if len(clone.Attributes) != 2 || *clone.Attributes[0].Value.IntValue != "2" || !*clone.Attributes[1].Value.BoolValue {
+		t.Fatalf("clone attributes = %+v", clone.Attributes)
+	}
+	if clone.StartTimeUnixNano == "" || clone.EndTimeUnixNano < clone.StartTimeUnixNano {
+		t.Fatalf("clone times = %s..%s", clone.StartTimeUnixNano, clone.EndTimeUnixNano)
+	}
+}
+
+func TestDefaultTracer(t *testing.T) {
+	exported := make(chan string, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req otlpRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		exported <- req.ResourceSpans[0].ScopeSpans[0].Spans[0].Name
+	}))
+	t.Cleanup(collector.Close)
+
+	if _, span := Start(context.Background(), "disabled"); span != nil {
+		t.Fatal("Start() without a default tracer should return a nil span")
+	}
+
+	tracer := New(Config{Endpoint: collector.URL, ExportInterval: 10 * time.Millisecond})
+	SetDefault(tracer)
+	t.Cleanup(func() {
+		SetDefault(nil)
+		tracer.Shutdown(context.Background())
+	})
+
+	_, span := Start(context.Background(), "SessionHost.HandlePrompt")
+	span.End(nil)
+	select {
+	case name := <-exported:
+		if name != "SessionHost.HandlePrompt" {
+			t.Fatalf("exported span %q", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for periodic export")
+	}
+}
@@ -7,20 +7,37 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
 	"github.com/workspace/vm-agent/internal/bootlog"
 	"github.com/workspace/vm-agent/internal/bootstrap"
 	"github.com/workspace/vm-agent/internal/config"
+	"github.com/workspace/vm-agent/internal/containerruntime"
 	"github.com/workspace/vm-agent/internal/deploy"
 	"github.com/workspace/vm-agent/internal/errorreport"
+	"github.com/workspace/vm-agent/internal/faultinject"
+	"github.com/workspace/vm-agent/internal/lifecyclehook"
 	"github.com/workspace/vm-agent/internal/logging"
 	"github.com/workspace/vm-agent/internal/provision"
+	"github.com/workspace/vm-agent/internal/redact"
+	"github.com/workspace/vm-agent/internal/samctl"
 	"github.com/workspace/vm-agent/internal/server"
+	"github.com/workspace/vm-agent/internal/sysinfo"
+	"github.com/workspace/vm-agent/internal/tracing"
 )
 
 func main() {
+	// samctl talks to a running agent over its control socket; it needs no
+	// configuration of its own.
+	if filepath.Base(os.Args[0]) == "samctl" {
+		os.Exit(samctl.Run(os.Args[1:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "samctl" {
+		os.Exit(samctl.Run(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
 	logging.Setup()
 	slog.Info("Starting VM Agent...")
 
@@ -34,9 +51,44 @@ func main() {
 		slog.Error("Configuration validation failed", "error", err)
 		os.Exit(1)
 	}
+	redact.Register(cfg.CallbackToken, cfg.BootstrapToken)
+
+	// Before any HTTP request: proxy settings are read from the environment
+	// on first use.
+	if err := config.ApplyOutbound(cfg); err != nil {
+		slog.Error("Failed to apply proxy and CA settings", "error", err)
+		os.Exit(1)
+	}
+
+	rt, err := containerruntime.Detect(cfg.ContainerRuntime)
+	if err != nil {
+		slog.Error("Invalid CONTAINER_RUNTIME", "error", err)
+		os.Exit(1)
+	}
+	containerruntime.Set(rt)
+	// Structured operations use the engine API when its socket answers;
+	// otherwise everything goes through the CLI.
+	engineCtx, engineCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if engine, err := containerruntime.NewEngine(engineCtx, rt); err != nil {
+		slog.Warn("Container engine API unavailable, using the CLI", "runtime", rt.Name(), "error", err)
+	} else {
+		containerruntime.Set(engine)
+	}
+	engineCancel()
+	slog.Info("Container runtime selected", "runtime", rt.Name(), "path", rt.Path(), "api", containerruntime.Current() != rt)
+
+	if err := faultinject.Configure(cfg.FaultInjection); err != nil {
+		slog.Error("Invalid SAM_FAULT_INJECTION spec", "error", err)
+		os.Exit(1)
+	}
 
 	slog.Info("Configuration loaded", "node", cfg.NodeID, "port", cfg.Port, "role", cfg.Role)
 
+	// OpenTelemetry spans for bootstrap and ACP prompts; no-op when
+	// OTEL_EXPORTER_OTLP_ENDPOINT is unset.
+	tracer := newTracer(cfg)
+	tracing.SetDefault(tracer)
+
 	// Branch on node role
 	if cfg.IsDeploymentMode() {
 		runDeploymentMode(cfg)
@@ -45,6 +97,29 @@ func main() {
 	} else {
 		runWorkspaceMode(cfg)
 	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	tracer.Shutdown(ctx)
+}
+
+// newTracer builds the OTLP span exporter from config. Returns nil when
+// tracing is disabled.
+func newTracer(cfg *config.Config) *tracing.Tracer {
+	// Validate has already rejected malformed headers.
+	headers, _ := config.ParseKeyValueList(cfg.TracingOTLPHeaders)
+	tracer := tracing.New(tracing.Config{
+		Endpoint:       cfg.TracingOTLPEndpoint,
+		Headers:        headers,
+		ServiceName:    cfg.TracingServiceName,
+		ServiceVersion: sysinfo.Version,
+		NodeID:         cfg.NodeID,
+		ExportInterval: cfg.TracingExportInterval,
+	})
+	if tracer != nil {
+		slog.Info("OpenTelemetry tracing enabled", "endpoint", cfg.TracingOTLPEndpoint)
+	}
+	return tracer
 }
 
 // runStandaloneMode starts the agent inside a single Cloudflare Container.
@@ -70,6 +145,7 @@ func runStandaloneMode(cfg *config.Config) {
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	reloadOnSIGHUP(srv)
 
 	errCh := make(chan error, 1)
 	go func() {
@@ -167,6 +243,7 @@ func runDeploymentMode(cfg *config.Config) {
 	// Handle shutdown signals
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	reloadOnSIGHUP(srv)
 
 	// Start HTTP server after the deployment engine is attached so the first
 	// heartbeat can refresh signing keys and observe pending releases.
@@ -220,9 +297,16 @@ func runWorkspaceMode(cfg *config.Config) {
 	// Wire broadcaster for real-time WebSocket delivery of boot logs
 	reporter.SetBroadcaster(srv.GetBootLogBroadcaster())
 
+	// Wire operator-configured boot log sinks (file, journald, webhook)
+	reporter.AddSinks(srv.BootLogSinks()...)
+
+	// Report a progress percentage and ETA with each entry
+	reporter.TrackProgress(cfg.BootLogHistoryPath)
+
 	// Handle shutdown signals
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	reloadOnSIGHUP(srv)
 
 	// Start server in goroutine — HTTP is available immediately.
 	errCh := make(chan error, 1)
@@ -237,6 +321,8 @@ func runWorkspaceMode(cfg *config.Config) {
 	provisionStatus, provisionErr := provision.Run(provisionCtx, provision.Config{
 		VMAgentPort:      fmt.Sprintf("%d", cfg.Port),
 		CFIPFetchTimeout: "10",
+		// Podman hosts bring their own runtime; never install Docker beside it.
+		SkipDocker: containerruntime.Current().Name() == containerruntime.NamePodman,
 	}, srv.GetEventStore())
 	provisionCancel()
 
@@ -249,17 +335,41 @@ func runWorkspaceMode(cfg *config.Config) {
 			"duration", provisionStatus.CompletedAt.Sub(provisionStatus.StartedAt).Round(time.Millisecond))
 	}
 
+	// Docker is installed by now; its daemon needs its own proxy/CA setup.
+	bootstrap.WarnDockerOutboundConfig(context.Background(), cfg)
+
 	// Send node-ready callback AFTER provisioning.
 	srv.SendNodeReady()
 
+	// A pre-provisioned (warm pool) node warms its caches until the control
+	// plane assigns it a workspace via POST /assign.
+	if cfg.PreProvisioned && !awaitAssignment(srv, cfg, reporter, errCh, sigCh) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := srv.Stop(ctx); err != nil {
+			slog.Error("Error during shutdown", "error", err)
+		}
+		slog.Info("VM Agent stopped")
+		return
+	}
+
 	// Run bootstrap (blocks until workspace is provisioned).
 	bootstrapCtx, bootstrapCancel := context.WithTimeout(context.Background(), cfg.BootstrapTimeout)
 	defer bootstrapCancel()
 
-	if err := bootstrap.Run(bootstrapCtx, cfg, reporter); err != nil {
+	if cfg.BootstrapToken != "" {
+		srv.NotifyLifecycle(lifecyclehook.EventBootstrapStarted, cfg.WorkspaceID, nil)
+	}
+	recoveryMode, err := bootstrap.Run(bootstrapCtx, cfg, reporter)
+	if err != nil {
 		slog.Error("Bootstrap failed", "error", err)
+		// Deliver bootstrap_started before exiting; Stop does not run.
+		srv.FlushLifecycleWebhooks()
 		os.Exit(1)
 	}
+	if cfg.BootstrapToken != "" {
+		srv.NotifyWorkspaceProvisioned(cfg.WorkspaceID, recoveryMode)
+	}
 
 	// Propagate callback token (obtained during bootstrap) to all subsystems
 	srv.UpdateAfterBootstrap(cfg)
@@ -271,6 +381,11 @@ func runWorkspaceMode(cfg *config.Config) {
 		os.Exit(1)
 	case sig := <-sigCh:
 		slog.Info("Received signal, shutting down...", "signal", sig)
+		// Warn viewers, flush chat messages, and save dirty git state before
+		// workloads stop. A drain already started via POST /drain is awaited.
+		drainCtx, drainCancel := context.WithTimeout(context.Background(), cfg.ShutdownDrainCountdown+30*time.Second)
+		srv.Drain(drainCtx, "node_shutdown")
+		drainCancel()
 		srv.StopAllWorkspacesAndSessions()
 	}
 
@@ -285,6 +400,58 @@ func runWorkspaceMode(cfg *config.Config) {
 	slog.Info("VM Agent stopped")
 }
 
+// awaitAssignment prewarms a pre-provisioned node while it waits for POST
+// /assign. It returns false if a shutdown signal arrives first; the prewarm
+// is cancelled either way so it does not compete with bootstrap.
+func awaitAssignment(srv *server.Server, cfg *config.Config, reporter *bootlog.Reporter, errCh <-chan error, sigCh <-chan os.Signal) bool {
+	prewarmCtx, prewarmCancel := context.WithCancel(context.Background())
+	prewarmDone := make(chan struct{})
+	go func() {
+		defer close(prewarmDone)
+		bootstrap.Prewarm(prewarmCtx, cfg, reporter)
+	}()
+	defer func() {
+		prewarmCancel()
+		<-prewarmDone
+	}()
+
+	assignCtx, assignCancel := context.WithCancel(context.Background())
+	defer assignCancel()
+	assignedCh := make(chan server.Assignment, 1)
+	go func() {
+		if a, err := srv.WaitForAssignment(assignCtx); err == nil {
+			assignedCh <- a
+		}
+	}()
+
+	slog.Info("Pre-provisioned node waiting for workspace assignment")
+	select {
+	case a := <-assignedCh:
+		slog.Info("Workspace assigned", "workspaceId", a.WorkspaceID, "repository", a.Repository)
+		return true
+	case err := <-errCh:
+		slog.Error("Server error", "error", err)
+		os.Exit(1)
+	case sig := <-sigCh:
+		slog.Info("Received signal while awaiting assignment, shutting down...", "signal", sig)
+	}
+	return false
+}
+
+// reloadOnSIGHUP re-reads the settings override file each time the agent
+// receives SIGHUP.
+func reloadOnSIGHUP(srv *server.Server) {
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	go func() {
+		for range hupCh {
+			if _, err := srv.ReloadConfigFile(); err != nil {
+				slog.Error("Failed to reload settings", "error", err)
+			}
+		}
+	}()
+}
+
 func countCompleted(steps []provision.Step) int {
 	n := 0
 	for _, s := range steps {